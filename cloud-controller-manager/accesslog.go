@@ -0,0 +1,194 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"strings"
+)
+
+// Access log delivery to Log Service is not wrapped by aliyungo,
+// so the request and response types are declared here and invoked directly.
+
+const (
+	// ACCESS_LOG_TYPE access logs are collected from layer 7 listeners only
+	ACCESS_LOG_TYPE = "layer7"
+)
+
+// ACCESS_LOG_TARGET_NOT_EXIST error codes returned when the log project or
+// logstore does not exist. They are reported by events instead of failing the sync.
+var ACCESS_LOG_TARGET_NOT_EXIST = []string{"ProjectNotExist", "LogStoreNotExist", "LogstoreNotExist"}
+
+type AccessLogAttribute struct {
+	LoadBalancerId string
+	LogProject     string
+	LogStore       string
+	LogType        string
+	Region         string `json:",omitempty"`
+}
+
+type DescribeAccessLogsDownloadAttributeArgs struct {
+	RegionId       common.Region
+	LoadBalancerId string
+	LogType        string
+}
+
+type DescribeAccessLogsDownloadAttributeResponse struct {
+	common.Response
+	LogsDownloadAttributes struct {
+		LogsDownloadAttribute []AccessLogAttribute
+	}
+}
+
+// AccessLogsDownloadAttributeArgs args of SetAccessLogsDownloadAttribute and DeleteAccessLogsDownloadAttribute.
+// LogsDownloadAttributes is a json array of AccessLogAttribute
+type AccessLogsDownloadAttributeArgs struct {
+	RegionId               common.Region
+	LogsDownloadAttributes string
+}
+
+func accessLogsDownloadAttributeArgs(lb *slb.LoadBalancerType, attr AccessLogAttribute) (*AccessLogsDownloadAttributeArgs, error) {
+	attrs, err := json.Marshal([]AccessLogAttribute{attr})
+	if err != nil {
+		return nil, err
+	}
+	return &AccessLogsDownloadAttributeArgs{
+		RegionId:               lb.RegionId,
+		LogsDownloadAttributes: string(attrs),
+	}, nil
+}
+
+// ValidateAccessLog project and logstore must be set together.
+func ValidateAccessLog(service *v1.Service) error {
+	project := serviceAnnotation(service, ServiceAnnotationLoadBalancerAccessLogProject)
+	logstore := serviceAnnotation(service, ServiceAnnotationLoadBalancerAccessLogLogstore)
+	if (project == "") == (logstore == "") {
+		return nil
+	}
+	if project == "" {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerAccessLogLogstore,
+			token:      logstore,
+			reason:     fmt.Sprintf("requires annotation %s", ServiceAnnotationLoadBalancerAccessLogProject),
+		}
+	}
+	return annotationError{
+		annotation: ServiceAnnotationLoadBalancerAccessLogProject,
+		token:      project,
+		reason:     fmt.Sprintf("requires annotation %s", ServiceAnnotationLoadBalancerAccessLogLogstore),
+	}
+}
+
+// EnsureAccessLog deliver the access logs of lb to the log project and logstore
+// of the service annotations, and stop the delivery when they are removed.
+// A missing project or logstore is reported by an event and does not fail the
+// sync of the rest of the loadbalancer.
+func EnsureAccessLog(ctx context.Context, client ClientSLBSDK, service *v1.Service, lb *slb.LoadBalancerType) error {
+	_, request := ExtractAnnotationRequest(service)
+	if request.AccessLogProject == "" && isUserDefinedLoadBalancer(service) {
+		// leave the access log of a user managed loadbalancer alone
+		return nil
+	}
+	attrs, err := client.DescribeAccessLogsDownloadAttribute(
+		ctx,
+		&DescribeAccessLogsDownloadAttributeArgs{
+			RegionId:       lb.RegionId,
+			LoadBalancerId: lb.LoadBalancerId,
+			LogType:        ACCESS_LOG_TYPE,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("describe access log of loadbalancer %s: %s", lb.LoadBalancerId, err.Error())
+	}
+	var current *AccessLogAttribute
+	for i := range attrs {
+		if attrs[i].LogType == ACCESS_LOG_TYPE {
+			current = &attrs[i]
+			break
+		}
+	}
+
+	if request.AccessLogProject == "" || request.AccessLogLogstore == "" {
+		if current == nil {
+			return nil
+		}
+		utils.Logf(service, "disable access log %s/%s", current.LogProject, current.LogStore)
+		args, err := accessLogsDownloadAttributeArgs(lb, *current)
+		if err != nil {
+			return err
+		}
+		if err := client.DeleteAccessLogsDownloadAttribute(ctx, args); err != nil {
+			return fmt.Errorf("disable access log of loadbalancer %s: %s", lb.LoadBalancerId, err.Error())
+		}
+		return nil
+	}
+
+	desired := AccessLogAttribute{
+		LoadBalancerId: lb.LoadBalancerId,
+		LogProject:     request.AccessLogProject,
+		LogStore:       request.AccessLogLogstore,
+		LogType:        ACCESS_LOG_TYPE,
+		Region:         request.AccessLogRegion,
+	}
+	if current != nil &&
+		current.LogProject == desired.LogProject &&
+		current.LogStore == desired.LogStore &&
+		(desired.Region == "" || current.Region == desired.Region) {
+		return nil
+	}
+	utils.Logf(service, "enable access log %s/%s", desired.LogProject, desired.LogStore)
+	args, err := accessLogsDownloadAttributeArgs(lb, desired)
+	if err != nil {
+		return err
+	}
+	err = client.SetAccessLogsDownloadAttribute(ctx, args)
+	if err == nil {
+		return nil
+	}
+	if !isAccessLogTargetNotExist(err) {
+		return fmt.Errorf("enable access log of loadbalancer %s: %s", lb.LoadBalancerId, err.Error())
+	}
+	record, rerr := utils.GetRecorderFromContext(ctx)
+	if rerr != nil {
+		klog.Warningf("get recorder error: %s", rerr.Error())
+		return nil
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"EnableAccessLogFailed",
+		"Error delivering access logs to %s/%s: %s",
+		desired.LogProject, desired.LogStore, err.Error(),
+	)
+	return nil
+}
+
+func isAccessLogTargetNotExist(err error) bool {
+	for _, code := range ACCESS_LOG_TARGET_NOT_EXIST {
+		if strings.Contains(err.Error(), code) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort:      "http:80",
+					ServiceAnnotationLoadBalancerAccessLogProject:  "my-project",
+					ServiceAnnotationLoadBalancerAccessLogLogstore: "my-logstore",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	// expect the access log delivered to project/logstore, or not delivered at all
+	expect := func(project, logstore string) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		attrs, err := f.SLBSDK().DescribeAccessLogsDownloadAttribute(
+			ctx,
+			&DescribeAccessLogsDownloadAttributeArgs{LoadBalancerId: lb.LoadBalancerId, LogType: ACCESS_LOG_TYPE},
+		)
+		if err != nil {
+			t.Fatalf("describe access log: %s", err.Error())
+		}
+		if project == "" {
+			if len(attrs) != 0 {
+				t.Fatalf("expect access log disabled, got %v", attrs)
+			}
+			return
+		}
+		if len(attrs) != 1 || attrs[0].LogProject != project || attrs[0].LogStore != logstore {
+			t.Fatalf("expect access log delivered to %s/%s, got %v", project, logstore, attrs)
+		}
+	}
+
+	f.RunDefault(t, "Enable access log")
+	expect("my-project", "my-logstore")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAccessLogLogstore] = "other-logstore"
+	f.RunDefault(t, "Change access log logstore")
+	expect("my-project", "other-logstore")
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerAccessLogProject)
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerAccessLogLogstore)
+	f.RunDefault(t, "Disable access log")
+	expect("", "")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAccessLogProject] = "my-project"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAccessLogLogstore] = "missing-logstore"
+	mock := f.SLBSDK().(*mockClientSLB)
+	mock.setAccessLogsDownloadAttribute = func(args *AccessLogsDownloadAttributeArgs) error {
+		return fmt.Errorf("LogStoreNotExist: the logstore does not exist")
+	}
+	defer func() { mock.setAccessLogsDownloadAttribute = nil }()
+	f.RunCustomized(t, "Missing logstore",
+		func(f *FrameWork) error {
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return fmt.Errorf("missing logstore is not expected to fail the sync: %s", err.Error())
+			}
+			if err := ExpectExistAndEqual(f); err != nil {
+				return err
+			}
+			for len(recorder.Events) > 0 {
+				if strings.Contains(<-recorder.Events, "EnableAccessLogFailed") {
+					return nil
+				}
+			}
+			return fmt.Errorf("expect an EnableAccessLogFailed event")
+		},
+	)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerAccessLogProject)
+	f.RunCustomized(t, "Logstore without project",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+				return fmt.Errorf("expect InvalidAnnotation error, got %v", err)
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,356 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Access control lists are not wrapped by aliyungo,
+// so the request and response types are declared here and invoked directly.
+
+const (
+	// ACL_NOT_EXIST error code returned when the access control list does not exist
+	ACL_NOT_EXIST = "AclNotExist"
+	// ACL_ENTRY_LIMIT max entries of an access control list
+	ACL_ENTRY_LIMIT = 300
+	// ACL_ENTRY_BATCH max entries added or removed by a single api call
+	ACL_ENTRY_BATCH = 50
+)
+
+type DescribeAccessControlListAttributeArgs struct {
+	RegionId common.Region
+	AclId    string
+}
+
+type AclEntry struct {
+	AclEntryIP      string
+	AclEntryComment string
+}
+
+type DescribeAccessControlListAttributeResponse struct {
+	common.Response
+	AclId     string
+	AclName   string
+	AclEntrys struct {
+		AclEntry []AclEntry
+	}
+}
+
+type DescribeAccessControlListsArgs struct {
+	RegionId common.Region
+	AclName  string
+}
+
+type AclType struct {
+	AclId   string
+	AclName string
+}
+
+type DescribeAccessControlListsResponse struct {
+	common.Response
+	Acls struct {
+		Acl []AclType
+	}
+}
+
+type CreateAccessControlListArgs struct {
+	RegionId common.Region
+	AclName  string
+}
+
+type CreateAccessControlListResponse struct {
+	common.Response
+	AclId string
+}
+
+type DeleteAccessControlListArgs struct {
+	RegionId common.Region
+	AclId    string
+}
+
+// AccessControlListEntryArgs args of AddAccessControlListEntry and RemoveAccessControlListEntry.
+// AclEntrys is a json array, e.g. [{"entry":"10.0.0.0/24","comment":""}]
+type AccessControlListEntryArgs struct {
+	RegionId  common.Region
+	AclId     string
+	AclEntrys string
+}
+
+// CheckAccessControlList make sure the acl referenced by the service annotation
+// exists before it is applied to any listener, so that the user get an error
+// naming the acl id instead of a raw api error from each listener.
+func CheckAccessControlList(ctx context.Context, client ClientSLBSDK, service *v1.Service) error {
+	def, request := ExtractAnnotationRequest(service)
+	if def.AclStatus != string(slb.OnFlag) {
+		return nil
+	}
+	if request.AclID == "" {
+		return fmt.Errorf("annotation %s is required when %s is on",
+			ServiceAnnotationLoadBalancerAclID, ServiceAnnotationLoadBalancerAclStatus)
+	}
+	_, err := client.DescribeAccessControlListAttribute(
+		ctx,
+		&DescribeAccessControlListAttributeArgs{AclId: request.AclID},
+	)
+	if err != nil {
+		if apiErrorCode(err) == ACL_NOT_EXIST {
+			return fmt.Errorf("access control list %s does not exist", request.AclID)
+		}
+		return fmt.Errorf("describe access control list %s: %s", request.AclID, err.Error())
+	}
+	return nil
+}
+
+// sourceRangesAclName the name of the acl managed for spec.loadBalancerSourceRanges
+func sourceRangesAclName(service *v1.Service) string {
+	return fmt.Sprintf("k8s-%s", service.UID)
+}
+
+func findAccessControlList(ctx context.Context, client ClientSLBSDK, name string) (*AclType, error) {
+	acls, err := client.DescribeAccessControlLists(ctx, &DescribeAccessControlListsArgs{AclName: name})
+	if err != nil {
+		return nil, fmt.Errorf("describe access control list %s: %s", name, err.Error())
+	}
+	for i := range acls {
+		if acls[i].AclName == name {
+			return &acls[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// EnsureSourceRangesAcl reconcile the acl managed for spec.loadBalancerSourceRanges.
+// It returns the service whose acl annotations point the listeners at that acl.
+// The acl-id annotation always wins over spec.loadBalancerSourceRanges.
+func EnsureSourceRangesAcl(ctx context.Context, client ClientSLBSDK, service *v1.Service) (*v1.Service, error) {
+	ranges := service.Spec.LoadBalancerSourceRanges
+	_, request := ExtractAnnotationRequest(service)
+	if len(ranges) != 0 && request.AclID != "" {
+		record, err := utils.GetRecorderFromContext(ctx)
+		if err != nil {
+			klog.Warningf("get recorder error: %s", err.Error())
+		} else {
+			record.Eventf(
+				service,
+				v1.EventTypeWarning,
+				"SourceRangesIgnored",
+				"spec.loadBalancerSourceRanges is ignored because annotation %s is set to %s",
+				ServiceAnnotationLoadBalancerAclID, request.AclID,
+			)
+		}
+	}
+	if len(ranges) == 0 || request.AclID != "" {
+		// the managed acl left on a listener is detached by the listener
+		// itself, see detachSourceRangesAcl
+		return service, nil
+	}
+
+	if len(ranges) > ACL_ENTRY_LIMIT {
+		return service, fmt.Errorf("spec.loadBalancerSourceRanges has %d entries, "+
+			"exceeds the limit %d of an access control list", len(ranges), ACL_ENTRY_LIMIT)
+	}
+	name := sourceRangesAclName(service)
+	acl, err := findAccessControlList(ctx, client, name)
+	if err != nil {
+		return service, err
+	}
+	desired := make(map[string]bool)
+	for _, r := range ranges {
+		_, cidr, err := net.ParseCIDR(strings.TrimSpace(r))
+		if err != nil {
+			return service, fmt.Errorf("invalid spec.loadBalancerSourceRanges entry %q: %s", r, err.Error())
+		}
+		desired[cidr.String()] = true
+	}
+
+	if acl == nil {
+		utils.Logf(service, "create access control list %s for source ranges", name)
+		resp, err := client.CreateAccessControlList(ctx, &CreateAccessControlListArgs{AclName: name})
+		if err != nil {
+			return service, fmt.Errorf("create access control list %s: %s", name, err.Error())
+		}
+		acl = &AclType{AclId: resp.AclId, AclName: name}
+	}
+	attr, err := client.DescribeAccessControlListAttribute(
+		ctx,
+		&DescribeAccessControlListAttributeArgs{AclId: acl.AclId},
+	)
+	if err != nil {
+		return service, fmt.Errorf("describe access control list %s: %s", acl.AclId, err.Error())
+	}
+	var adds, removes []string
+	for _, entry := range attr.AclEntrys.AclEntry {
+		if desired[entry.AclEntryIP] {
+			delete(desired, entry.AclEntryIP)
+			continue
+		}
+		removes = append(removes, entry.AclEntryIP)
+	}
+	for cidr := range desired {
+		adds = append(adds, cidr)
+	}
+	add := func(adds []string) error {
+		return batchAclEntry(adds, func(entrys string) error {
+			return client.AddAccessControlListEntry(
+				ctx, &AccessControlListEntryArgs{AclId: acl.AclId, AclEntrys: entrys})
+		})
+	}
+	// the entries are added first, the clients of a replaced range are never
+	// denied in between. Those exceeding the limit are added once removed.
+	room := ACL_ENTRY_LIMIT - len(attr.AclEntrys.AclEntry)
+	if room > len(adds) {
+		room = len(adds)
+	}
+	if room < 0 {
+		room = 0
+	}
+	if err := add(adds[:room]); err != nil {
+		return service, fmt.Errorf("add entries to access control list %s: %s", acl.AclId, err.Error())
+	}
+	if err := batchAclEntry(removes, func(entrys string) error {
+		return client.RemoveAccessControlListEntry(
+			ctx, &AccessControlListEntryArgs{AclId: acl.AclId, AclEntrys: entrys})
+	}); err != nil {
+		return service, fmt.Errorf("remove entries from access control list %s: %s", acl.AclId, err.Error())
+	}
+	if err := add(adds[room:]); err != nil {
+		return service, fmt.Errorf("add entries to access control list %s: %s", acl.AclId, err.Error())
+	}
+
+	svc := service.DeepCopy()
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	svc.Annotations[ServiceAnnotationLoadBalancerAclStatus] = string(slb.OnFlag)
+	svc.Annotations[ServiceAnnotationLoadBalancerAclID] = acl.AclId
+	svc.Annotations[ServiceAnnotationLoadBalancerAclType] = "white"
+	return svc, nil
+}
+
+type contextDetachedAcls struct{}
+
+// detachedAcls the managed acls detached from the listeners by a sync,
+// collected by the listeners applied in parallel
+type detachedAcls struct {
+	lock sync.Mutex
+	ids  map[string]bool
+}
+
+// withDetachedAcls the context collecting the managed acls detached by the
+// listeners, deleted by CleanupSourceRangesAcl once they are all applied
+func withDetachedAcls(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextDetachedAcls{}, &detachedAcls{ids: map[string]bool{}})
+}
+
+// detachSourceRangesAcl check whether the acl the listener refers to is the
+// one managed for spec.loadBalancerSourceRanges, while the service no longer
+// wants it. The acl is looked up only if the listener refers to another acl
+// than the annotated one, eg. once the source ranges are removed. It is
+// collected to be deleted once the listener no longer refers to it, and
+// returns whether the listener has to switch its acl off, as no acl id is
+// annotated to switch to.
+func detachSourceRangesAcl(ctx context.Context, n *Listener, request *AnnotationRequest, status, id string) bool {
+	if status != string(slb.OnFlag) || id == "" || id == request.AclID {
+		return false
+	}
+	acl, err := n.Client.DescribeAccessControlListAttribute(
+		ctx,
+		&DescribeAccessControlListAttributeArgs{AclId: id},
+	)
+	if err != nil {
+		// left to the next sync
+		utils.Logf(n.Service, "describe access control list %s of listener %d: %s", id, n.Port, err.Error())
+		return false
+	}
+	if acl.AclName != sourceRangesAclName(n.Service) {
+		return false
+	}
+	if detached, ok := ctx.Value(contextDetachedAcls{}).(*detachedAcls); ok {
+		detached.lock.Lock()
+		detached.ids[id] = true
+		detached.lock.Unlock()
+	}
+	utils.Logf(n.Service, "detach access control list %s for source ranges from listener %d", id, n.Port)
+	return request.AclID == ""
+}
+
+// CleanupSourceRangesAcl delete the acl managed for spec.loadBalancerSourceRanges
+// once it is no longer referenced by the service. While the service is synced,
+// only the acls detached from its listeners are deleted, see
+// detachSourceRangesAcl, so that a service without source ranges looks up no
+// acl. One failed to be deleted then is left until the service is deleted.
+func CleanupSourceRangesAcl(ctx context.Context, client ClientSLBSDK, service *v1.Service, deleted bool) error {
+	if !deleted {
+		detached, ok := ctx.Value(contextDetachedAcls{}).(*detachedAcls)
+		if !ok {
+			return nil
+		}
+		detached.lock.Lock()
+		defer detached.lock.Unlock()
+		for id := range detached.ids {
+			utils.Logf(service, "delete access control list %s for source ranges", id)
+			err := client.DeleteAccessControlList(ctx, &DeleteAccessControlListArgs{AclId: id})
+			if err != nil && !utils.IsCloudNotFound(err) {
+				return err
+			}
+			delete(detached.ids, id)
+		}
+		return nil
+	}
+	acl, err := findAccessControlList(ctx, client, sourceRangesAclName(service))
+	if err != nil || acl == nil {
+		return err
+	}
+	utils.Logf(service, "delete access control list %s for source ranges", acl.AclId)
+	err = client.DeleteAccessControlList(ctx, &DeleteAccessControlListArgs{AclId: acl.AclId})
+	if deleted && utils.IsCloudNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func batchAclEntry(cidrs []string, apply func(entrys string) error) error {
+	for start := 0; start < len(cidrs); start += ACL_ENTRY_BATCH {
+		end := start + ACL_ENTRY_BATCH
+		if end > len(cidrs) {
+			end = len(cidrs)
+		}
+		var entrys []map[string]string
+		for _, cidr := range cidrs[start:end] {
+			entrys = append(entrys, map[string]string{"entry": cidr, "comment": ""})
+		}
+		data, err := json.Marshal(entrys)
+		if err != nil {
+			return err
+		}
+		if err := apply(string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
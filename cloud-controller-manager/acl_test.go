@@ -0,0 +1,243 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// expectSourceRangesAcl check the managed acl holds exactly cidrs and
+// the listener on listenPort1 refers to acl id.
+func expectSourceRangesAcl(t *testing.T, f *FrameWork, id string, cidrs ...string) {
+	ctx := context.Background()
+	acl, err := findAccessControlList(ctx, f.SLBSDK(), sourceRangesAclName(f.SVC))
+	if err != nil {
+		t.Fatalf("find acl: %s", err.Error())
+	}
+	_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+	if err != nil || lb == nil {
+		t.Fatalf("find loadbalancer: %v", err)
+	}
+	listener, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(ctx, lb.LoadBalancerId, int(listenPort1))
+	if err != nil {
+		t.Fatalf("describe listener: %s", err.Error())
+	}
+	if len(cidrs) == 0 {
+		if acl != nil {
+			t.Fatalf("managed acl %s should be deleted", acl.AclId)
+		}
+		if id == "" && listener.AclStatus != string(slb.OffFlag) {
+			t.Fatalf("acl should be detached from listener, status %s", listener.AclStatus)
+		}
+		if id != "" && listener.AclId != id {
+			t.Fatalf("listener should use acl %s, got %s", id, listener.AclId)
+		}
+		return
+	}
+	if acl == nil {
+		t.Fatalf("managed acl not found")
+	}
+	attr, err := f.SLBSDK().DescribeAccessControlListAttribute(
+		ctx, &DescribeAccessControlListAttributeArgs{AclId: acl.AclId})
+	if err != nil {
+		t.Fatalf("describe acl: %s", err.Error())
+	}
+	var entries []string
+	for _, e := range attr.AclEntrys.AclEntry {
+		entries = append(entries, e.AclEntryIP)
+	}
+	sort.Strings(entries)
+	sort.Strings(cidrs)
+	if !reflect.DeepEqual(entries, cidrs) {
+		t.Fatalf("expected acl entries %v, got %v", cidrs, entries)
+	}
+	if listener.AclId != acl.AclId ||
+		listener.AclStatus != string(slb.OnFlag) ||
+		listener.AclType != "white" {
+		t.Fatalf("listener acl not applied: id=%s, status=%s, type=%s",
+			listener.AclId, listener.AclStatus, listener.AclType)
+	}
+}
+
+// expectSourceRangesAclDetached ensure the service and expect the managed acl
+// deleted only after it is detached from the listener.
+func expectSourceRangesAclDetached(f *FrameWork) error {
+	faults := f.SLBFaults()
+	faults.Reset()
+	if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+		return err
+	}
+	var operations []string
+	for _, call := range faults.Calls("SetLoadBalancerTCPListenerAttribute", "DeleteAccessControlList") {
+		operations = append(operations, call.Operation)
+	}
+	expect := []string{"SetLoadBalancerTCPListenerAttribute", "DeleteAccessControlList"}
+	if !reflect.DeepEqual(operations, expect) {
+		return fmt.Errorf("expect the acl detached before deleted, got %v", operations)
+	}
+	return nil
+}
+
+func TestLoadBalancerSourceRanges(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-service",
+				Namespace:   "default",
+				UID:         types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:                     v1.ServiceTypeLoadBalancer,
+				SessionAffinity:          v1.ServiceAffinityNone,
+				LoadBalancerSourceRanges: []string{"10.0.0.0/24", "192.168.0.0/16"},
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	f.RunDefault(t, "Create acl from source ranges")
+	expectSourceRangesAcl(t, f, "", "10.0.0.0/24", "192.168.0.0/16")
+
+	f.SVC.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/24", "172.16.0.0/12"}
+	f.RunCustomized(t, "Update source ranges",
+		func(f *FrameWork) error {
+			faults := f.SLBFaults()
+			faults.Reset()
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			// the new range is allowed before the replaced one is denied
+			var operations []string
+			for _, call := range faults.Calls("AddAccessControlListEntry", "RemoveAccessControlListEntry") {
+				args := call.Args[0].(*AccessControlListEntryArgs)
+				operations = append(operations, fmt.Sprintf("%s %s", call.Operation, args.AclEntrys))
+			}
+			expect := []string{
+				`AddAccessControlListEntry [{"comment":"","entry":"172.16.0.0/12"}]`,
+				`RemoveAccessControlListEntry [{"comment":"","entry":"192.168.0.0/16"}]`,
+			}
+			if !reflect.DeepEqual(operations, expect) {
+				return fmt.Errorf("expect the entries added before removed, got %v", operations)
+			}
+			return ExpectExistAndEqual(f)
+		},
+	)
+	expectSourceRangesAcl(t, f, "", "10.0.0.0/24", "172.16.0.0/12")
+
+	f.SVC.Spec.LoadBalancerSourceRanges = nil
+	f.RunCustomized(t, "Remove source ranges", expectSourceRangesAclDetached)
+	expectSourceRangesAcl(t, f, "")
+
+	f.RunCustomized(t, "No acl looked up without source ranges",
+		func(f *FrameWork) error {
+			faults := f.SLBFaults()
+			faults.Reset()
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			if calls := faults.Calls("DescribeAccessControlLists", "DescribeAccessControlListAttribute"); len(calls) != 0 {
+				return fmt.Errorf("expect no acl looked up, got %v", calls)
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/24"}
+	f.RunDefault(t, "Restore source ranges")
+	expectSourceRangesAcl(t, f, "", "10.0.0.0/24")
+
+	f.SVC.Spec.LoadBalancerSourceRanges = nil
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAclStatus] = "off"
+	f.RunCustomized(t, "Remove source ranges with acl status annotated", expectSourceRangesAclDetached)
+	expectSourceRangesAcl(t, f, "")
+
+	f.SVC.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/24"}
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAclStatus] = "on"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAclID] = ACL_ID
+	f.RunDefault(t, "Acl id annotation wins over source ranges")
+	expectSourceRangesAcl(t, f, ACL_ID)
+}
+
+func TestLoadBalancerSourceRangesLimit(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	var ranges []string
+	for i := 0; i <= ACL_ENTRY_LIMIT; i++ {
+		ranges = append(ranges, fmt.Sprintf("10.%d.%d.0/24", i/256, i%256))
+	}
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:                     v1.ServiceTypeLoadBalancer,
+				SessionAffinity:          v1.ServiceAffinityNone,
+				LoadBalancerSourceRanges: ranges,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Too many source ranges",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+				return fmt.Errorf("expect acl entry limit error, got %v", err)
+			}
+			return nil
+		},
+	)
+}
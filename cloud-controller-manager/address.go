@@ -0,0 +1,169 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"net"
+	"strings"
+)
+
+// The address of a loadbalancer can be specified at creation, which
+// slb.CreateLoadBalancerArgs does not carry. The request is invoked
+// directly with the address appended.
+
+type CreateLoadBalancerWithAddressArgs struct {
+	slb.CreateLoadBalancerArgs
+	Address string
+}
+
+// specLoadBalancerIP the field name reported when spec.loadBalancerIP is invalid
+const specLoadBalancerIP = "spec.loadBalancerIP"
+
+// requestedAddress the address the loadbalancer is expected to be created
+// with, the annotation goes first and spec.loadBalancerIP next.
+func requestedAddress(service *v1.Service) string {
+	defaulted, _ := ExtractAnnotationRequest(service)
+	if defaulted.Address != "" {
+		return defaulted.Address
+	}
+	return service.Spec.LoadBalancerIP
+}
+
+// ValidateAddress an address can only be specified for IPv4 loadbalancers,
+// intranet ones must be in a vpc to take an address of the vswitch.
+func ValidateAddress(service *v1.Service) error {
+	defaulted, _ := ExtractAnnotationRequest(service)
+	field, address := ServiceAnnotationLoadBalancerAddress, defaulted.Address
+	if address == "" {
+		field, address = specLoadBalancerIP, service.Spec.LoadBalancerIP
+	}
+	if address == "" {
+		return nil
+	}
+	invalid := func(reason string, args ...interface{}) error {
+		return annotationError{
+			annotation: field,
+			token:      address,
+			reason:     fmt.Sprintf(reason, args...),
+		}
+	}
+	if defaulted.Address != "" && service.Spec.LoadBalancerIP != "" &&
+		!addressEqual(defaulted.Address, service.Spec.LoadBalancerIP) {
+		return invalid("conflicts with %s [%s]", specLoadBalancerIP, service.Spec.LoadBalancerIP)
+	}
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return invalid("must be an ip address")
+	case ip.To4() == nil || defaulted.AddressIPVersion == slb.IPv6:
+		return invalid("only %s loadbalancers can be created with a specified address", slb.IPv4)
+	case defaulted.AddressType == slb.IntranetAddressType && defaulted.SLBNetworkType == "classic":
+		return invalid("classic network loadbalancers can not be created with a specified address")
+	}
+	return nil
+}
+
+func addressEqual(a, b string) bool {
+	ipa, ipb := net.ParseIP(a), net.ParseIP(b)
+	if ipa == nil || ipb == nil {
+		return a == b
+	}
+	return ipa.Equal(ipb)
+}
+
+// createLoadBalancer create the loadbalancer with the requested address if any.
+func createLoadBalancer(ctx context.Context, client ClientSLBSDK,
+	service *v1.Service, opts *slb.CreateLoadBalancerArgs) (*slb.CreateLoadBalancerResponse, error) {
+	address := requestedAddress(service)
+	if address == "" {
+		return client.CreateLoadBalancer(ctx, opts)
+	}
+	utils.Logf(service, "create loadbalancer with address %s", address)
+	lbr, err := client.CreateLoadBalancerWithAddress(
+		ctx,
+		&CreateLoadBalancerWithAddressArgs{
+			CreateLoadBalancerArgs: *opts,
+			Address:                address,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create loadbalancer with address %s: %s", address, err.Error())
+	}
+	if !addressEqual(lbr.Address, address) {
+		return lbr, fmt.Errorf("loadbalancer %s is created with address %s instead of the requested %s",
+			lbr.LoadBalancerId, lbr.Address, address)
+	}
+	return lbr, nil
+}
+
+// recordAddressMismatch explain why the requested address does not take
+// effect on an existing loadbalancer.
+func recordAddressMismatch(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType) {
+	address := requestedAddress(service)
+	if address == "" || addressEqual(lb.Address, address) {
+		return
+	}
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "address of loadbalancer %s is %s, not the requested %s", lb.LoadBalancerId, lb.Address, address)
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"AddressMismatch",
+		"Address of loadbalancer %s is %s, the requested %s only takes effect when the loadbalancer is created",
+		lb.LoadBalancerId, lb.Address, address,
+	)
+}
+
+// isAddressTypeChanged whether the address type annotation differs from lb
+func isAddressTypeChanged(request *AnnotationRequest, lb *slb.LoadBalancerType) bool {
+	return request.AddressType != "" && request.AddressType != lb.AddressType
+}
+
+func isRecreateOnAddressTypeChange(svc *v1.Service) bool {
+	return strings.ToLower(serviceAnnotation(svc, ServiceAnnotationLoadBalancerRecreateOnAddressTypeChange)) == "true"
+}
+
+// recordAddressTypeChanged explain why the address type annotation does not
+// take effect.
+func recordAddressTypeChanged(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType, request *AnnotationRequest) {
+	message := fmt.Sprintf("Loadbalancer %s is %s with address %s, it can not be changed to %s "+
+		"without recreating the loadbalancer. ",
+		lb.LoadBalancerId, lb.AddressType, lb.Address, request.AddressType)
+	if isUserDefinedLoadBalancer(service) {
+		message += "User managed loadbalancers are never recreated"
+	} else {
+		message += fmt.Sprintf("Set annotation %s to \"true\" to recreate it, the address changes",
+			ServiceAnnotationLoadBalancerRecreateOnAddressTypeChange)
+	}
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "%s", message)
+		return
+	}
+	record.Event(service, v1.EventTypeWarning, "AddressTypeChangeUnsupported", message)
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestValidateAddress(t *testing.T) {
+	cases := []struct {
+		annotations map[string]string
+		ip          string
+		invalid     bool
+	}{
+		{annotations: map[string]string{}},
+		{annotations: map[string]string{}, ip: "47.97.241.200"},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerAddress: "47.97.241.200"}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerAddress: "47.97.241.200"}, ip: "47.97.241.200"},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerAddress: "47.97.241.200"}, ip: "47.97.241.201", invalid: true},
+		{annotations: map[string]string{}, ip: "slb.example.com", invalid: true},
+		{annotations: map[string]string{}, ip: "2408:4005:3ff:b500::1", invalid: true},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerAddress:   "47.97.241.200",
+				ServiceAnnotationLoadBalancerIPVersion: string(slb.IPv6),
+			},
+			invalid: true,
+		},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerAddressType: string(slb.IntranetAddressType),
+			},
+			ip: "192.168.0.100",
+		},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerAddressType:    string(slb.IntranetAddressType),
+				ServiceAnnotationLoadBalancerSLBNetworkType: "classic",
+			},
+			ip:      "192.168.0.100",
+			invalid: true,
+		},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: c.annotations},
+			Spec:       v1.ServiceSpec{LoadBalancerIP: c.ip},
+		}
+		err := ValidateAddress(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("%v, %s: unexpected error: %s", c.annotations, c.ip, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%v, %s: expect InvalidAnnotation error, got %v", c.annotations, c.ip, err)
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerAddressType: string(slb.IntranetAddressType),
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+				LoadBalancerIP:  "192.168.0.100",
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	var created string
+	f.RunCustomized(t, "Create with address",
+		func(f *FrameWork) error {
+			status, _, err := f.EnsureWithEvents()
+			if err != nil {
+				return err
+			}
+			if err := ExpectExistAndEqual(f); err != nil {
+				return err
+			}
+			if len(status.Ingress) != 1 || status.Ingress[0].IP != "192.168.0.100" {
+				return fmt.Errorf("expect ingress of the specified address, got %v", status.Ingress)
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			created = lb.LoadBalancerId
+			return nil
+		},
+	)
+
+	f.SVC.Spec.LoadBalancerIP = "192.168.0.101"
+	f.RunCustomized(t, "Address mismatch",
+		func(f *FrameWork) error {
+			status, events, err := f.EnsureWithEvents()
+			if err != nil {
+				return fmt.Errorf("mismatched address is not expected to fail the sync: %s", err.Error())
+			}
+			if !strings.Contains(events, "AddressMismatch") {
+				return fmt.Errorf("expect an AddressMismatch event, got %q", events)
+			}
+			if status.Ingress[0].IP != "192.168.0.100" {
+				return fmt.Errorf("expect ingress of the existing address, got %v", status.Ingress)
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			if lb.LoadBalancerId != created {
+				return fmt.Errorf("loadbalancer is not expected to be recreated")
+			}
+			return nil
+		},
+	)
+}
+
+func TestAddressTypeChanged(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-service",
+				Namespace:   "default",
+				UID:         types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expectAddressType := func(f *FrameWork, addrtype slb.AddressType) (*slb.LoadBalancerType, error) {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return nil, fmt.Errorf("find loadbalancer: %v", err)
+		}
+		if lb.AddressType != addrtype {
+			return nil, fmt.Errorf("expect address type %s, got %s", addrtype, lb.AddressType)
+		}
+		return lb, nil
+	}
+
+	var created string
+	f.RunCustomized(t, "Create internet loadbalancer",
+		func(f *FrameWork) error {
+			if _, _, err := f.EnsureWithEvents(); err != nil {
+				return err
+			}
+			lb, err := expectAddressType(f, slb.InternetAddressType)
+			if err != nil {
+				return err
+			}
+			created = lb.LoadBalancerId
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAddressType] = string(slb.IntranetAddressType)
+	f.RunCustomized(t, "Address type changed",
+		func(f *FrameWork) error {
+			_, events, err := f.EnsureWithEvents()
+			if err != nil {
+				return fmt.Errorf("changed address type is not expected to fail the sync: %s", err.Error())
+			}
+			if !strings.Contains(events, "AddressTypeChangeUnsupported") ||
+				!strings.Contains(events, ServiceAnnotationLoadBalancerRecreateOnAddressTypeChange) {
+				return fmt.Errorf("expect an AddressTypeChangeUnsupported event, got %q", events)
+			}
+			lb, err := expectAddressType(f, slb.InternetAddressType)
+			if err != nil {
+				return err
+			}
+			if lb.LoadBalancerId != created {
+				return fmt.Errorf("loadbalancer is not expected to be recreated")
+			}
+			return nil
+		},
+	)
+
+	// the recreate is refused, the loadbalancer is kept as it is
+	expectRefused := func(f *FrameWork, reason string) error {
+		_, events, err := f.EnsureWithEvents()
+		if err != nil {
+			return fmt.Errorf("refused recreate is not expected to fail the sync: %s", err.Error())
+		}
+		if !strings.Contains(events, "SkipRecreateLoadBalancer") || !strings.Contains(events, reason) {
+			return fmt.Errorf("expect a SkipRecreateLoadBalancer event of %q, got %q", reason, events)
+		}
+		lb, err := expectAddressType(f, slb.InternetAddressType)
+		if err != nil {
+			return err
+		}
+		if lb.LoadBalancerId != created {
+			return fmt.Errorf("loadbalancer is not expected to be recreated")
+		}
+		return ExpectExistAndEqual(f)
+	}
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerRecreateOnAddressTypeChange] = "true"
+	f.RunCustomized(t, "Recreate refused by delete protection",
+		func(f *FrameWork) error {
+			return expectRefused(f, "delete protection is on")
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerDeleteProtection] = string(slb.OffFlag)
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerRetainOnDelete] = "on"
+	f.RunCustomized(t, "Recreate refused by retain on delete",
+		func(f *FrameWork) error {
+			return expectRefused(f, ServiceAnnotationLoadBalancerRetainOnDelete)
+		},
+	)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerRetainOnDelete)
+	f.RunCustomized(t, "Recreate refused by ownership",
+		func(f *FrameWork) error {
+			tags, _ := LOADBALANCER.tags.Load(created)
+			defer LOADBALANCER.tags.Store(created, tags)
+			LOADBALANCER.tags.Store(created, gcTags(map[string]string{
+				TAGKEY:        GetLoadBalancerName(f.SVC),
+				ACKKEY:        CLUSTER_ID,
+				SERVICEUIDKEY: serviceUIDExist,
+			}))
+			return expectRefused(f, "another service uid")
+		},
+	)
+
+	f.RunCustomized(t, "Recreate on address type change",
+		func(f *FrameWork) error {
+			status, _, err := f.EnsureWithEvents()
+			if err != nil {
+				return err
+			}
+			if err := ExpectExistAndEqual(f); err != nil {
+				return err
+			}
+			lb, err := expectAddressType(f, slb.IntranetAddressType)
+			if err != nil {
+				return err
+			}
+			if lb.LoadBalancerId == created {
+				return fmt.Errorf("expect loadbalancer recreated")
+			}
+			if len(status.Ingress) != 1 || status.Ingress[0].IP != lb.Address {
+				return fmt.Errorf("expect ingress of the recreated loadbalancer, got %v", status.Ingress)
+			}
+			exist, _, err := f.LoadBalancer().FindLoadBalancerByID(context.Background(), created)
+			if err != nil {
+				return err
+			}
+			if exist {
+				return fmt.Errorf("expect loadbalancer %s deleted", created)
+			}
+			return nil
+		},
+	)
+}
@@ -29,6 +29,7 @@ import (
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/cloud-provider"
@@ -38,7 +39,6 @@ import (
 	"k8s.io/klog"
 	controller "k8s.io/kube-aggregator/pkg/controllers"
 	"math/rand"
-	"net"
 	"os"
 	"strings"
 	"time"
@@ -49,8 +49,11 @@ const (
 	ProviderName = "alicloud"
 )
 
-// CLUSTER_ID default cluster id if it is not specified.
-var CLUSTER_ID = "clusterid"
+// DEFAULT_CLUSTER_ID default cluster id if it is not specified.
+const DEFAULT_CLUSTER_ID = "clusterid"
+
+// CLUSTER_ID the cluster id, set by --cluster-id or the cloud config
+var CLUSTER_ID = DEFAULT_CLUSTER_ID
 
 // KUBERNETES_ALICLOUD_IDENTITY is for statistic purpose.
 var KUBERNETES_ALICLOUD_IDENTITY = fmt.Sprintf("Kubernetes.Alicloud/%s", Version)
@@ -66,6 +69,8 @@ type Cloud struct {
 	ifactory informers.SharedInformerFactory
 	// kubernetes client
 	kclient kubernetes.Interface
+	// mirror the writer of the loadbalancer states, see StateOptions
+	mirror stateMirror
 }
 
 var (
@@ -75,6 +80,10 @@ var (
 	// DEFAULT_BANDWIDTH default bandwidth
 	DEFAULT_BANDWIDTH = 100
 
+	// MIN_BANDWIDTH, MAX_BANDWIDTH range of the bandwidth in Mbps
+	MIN_BANDWIDTH = 1
+	MAX_BANDWIDTH = 5120
+
 	// DEFAULT_ADDRESS_TYPE default address type
 	DEFAULT_ADDRESS_TYPE = slb.InternetAddressType
 
@@ -96,6 +105,7 @@ type CloudConfig struct {
 		VpcID                string `json:"vpcid"`
 		Region               string `json:"region"`
 		ZoneID               string `json:"zoneid"`
+		InstanceID           string `json:"instanceID"`
 		VswitchID            string `json:"vswitchid"`
 		ClusterID            string `json:"clusterID"`
 		RouteTableIDS        string `json:"routeTableIDs"`
@@ -105,6 +115,25 @@ type CloudConfig struct {
 
 		AccessKeyID     string `json:"accessKeyID"`
 		AccessKeySecret string `json:"accessKeySecret"`
+
+		// AssumeRoleArn role assumed in the account owning the loadbalancers and the vpc
+		AssumeRoleArn         string `json:"assumeRoleArn"`
+		AssumeRoleSessionName string `json:"assumeRoleSessionName"`
+		// AssumeRoleClients comma separated clients calling with the assumed role, "slb,nlb,vpc" by default
+		AssumeRoleClients string `json:"assumeRoleClients"`
+
+		// RamRoleRefreshFraction the sts token of the instance ram role is renewed
+		// when less than the fraction of its lifetime remains, 0.2 by default
+		RamRoleRefreshFraction float64 `json:"ramRoleRefreshFraction"`
+
+		// UseVPCEndpoints call the vpc endpoints of the region, the same as --use-vpc-endpoints
+		UseVPCEndpoints bool `json:"useVpcEndpoints"`
+		// the endpoint of each product, overrides the vpc endpoint
+		SLBEndpoint  string `json:"slbEndpoint"`
+		ECSEndpoint  string `json:"ecsEndpoint"`
+		VPCEndpoint  string `json:"vpcEndpoint"`
+		NLBEndpoint  string `json:"nlbEndpoint"`
+		PVTZEndpoint string `json:"pvtzEndpoint"`
 	}
 }
 
@@ -135,7 +164,8 @@ func init() {
 					}
 					keysecret = string(secret)
 				}
-				if cfg.Global.ClusterID != "" {
+				// the cluster id of --cluster-id goes first
+				if cfg.Global.ClusterID != "" && CLUSTER_ID == DEFAULT_CLUSTER_ID {
 					CLUSTER_ID = cfg.Global.ClusterID
 					klog.Infof("use clusterid %s", CLUSTER_ID)
 				}
@@ -197,7 +227,7 @@ func (c *Cloud) Initialize(builder cloudprovider.ControllerClientBuilder, stop <
 		if len(strings.TrimSpace(cidr)) == 0 {
 			panic(fmt.Sprintf("ivalid cluster CIDR %s", cidr))
 		}
-		_, cidrc, err := net.ParseCIDR(cidr)
+		cidrc, err := route.ParseClusterCIDRs(cidr)
 		if err != nil {
 			panic(fmt.Sprintf("Unsuccessful parsing of cluster CIDR %v: %v", cidr, err))
 		}
@@ -241,6 +271,34 @@ func (c *Cloud) Initialize(builder cloudprovider.ControllerClientBuilder, stop <
 		)
 		go nctrl.Run(stop)
 	}()
+	if GCOptions.Enabled {
+		services := shared.Core().V1().Services()
+		gc := NewLoadBalancerGC(
+			c.climgr.LoadBalancers(),
+			componentRecorder(builder.ClientOrDie(LOADBALANCER_GC), LOADBALANCER_GC),
+			services.Lister(),
+			services.Informer().HasSynced,
+			GCOptions.DryRun,
+		)
+		go gc.Run(stop, GCOptions.Period)
+		klog.Infof("loadbalancer garbage collector started.")
+	}
+	if HealthOptions.Period > 0 {
+		services := shared.Core().V1().Services()
+		checker := NewBackendHealthChecker(
+			c.climgr.LoadBalancers(),
+			componentRecorder(builder.ClientOrDie(BACKEND_HEALTH_CHECKER), BACKEND_HEALTH_CHECKER),
+			services.Lister(),
+			services.Informer().HasSynced,
+			shared.Core().V1().Nodes().Lister(),
+			HealthOptions.QPS,
+		)
+		go checker.Run(stop, HealthOptions.Period)
+		klog.Infof("backend health checker started.")
+	}
+	if meta, ok := c.climgr.MetaData().(*CachedMetaData); ok {
+		go wait.Until(func() { _ = meta.Verify() }, METADATA_VERIFY_PERIOD, stop)
+	}
 	inform := shared.Core().V1().Endpoints().Informer()
 	shared.Start(stop)
 	if !controller.WaitForCacheSync(
@@ -256,10 +314,10 @@ func syncPeriod() time.Duration {
 	return time.Duration(float64(route.Options.MinResyncPeriod.Nanoseconds()) * (rand.Float64() + 1))
 }
 
-// GetLoadBalancerName returns the name of the load balancer. Implementations must treat the
-// *v1.Service parameter as read-only and not modify it.
+// GetLoadBalancerName returns the name of the load balancer, the name annotation
+// if set. Implementations must treat the *v1.Service parameter as read-only and not modify it.
 func (c *Cloud) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
-	return ""
+	return loadBalancerName(service)
 }
 
 // GetLoadBalancer returns whether the specified load balancer exists, and
@@ -268,6 +326,15 @@ func (c *Cloud) GetLoadBalancerName(ctx context.Context, clusterName string, ser
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
 // TODO: Break this up into different interfaces (LB, etc) when we have more than one type of service
 func (c *Cloud) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (status *v1.LoadBalancerStatus, exists bool, err error) {
+	service = utils.WithDefaultAnnotations(service)
+
+	if isNLB(service) {
+		exists, nlb, err := c.climgr.NLBs().FindNLB(ctx, service)
+		if err != nil || !exists {
+			return nil, exists, err
+		}
+		return nlbStatus(nlb), true, nil
+	}
 
 	exists, lb, err := c.climgr.LoadBalancers().FindLoadBalancer(ctx, service)
 
@@ -275,16 +342,30 @@ func (c *Cloud) GetLoadBalancer(ctx context.Context, clusterName string, service
 		return nil, exists, err
 	}
 
+	if status := hostnameStatus(service); status != nil {
+		return status, true, nil
+	}
+
+	if id := serviceAnnotation(service, ServiceAnnotationLoadBalancerEipId); id != "" {
+		eip, err := describeEip(ctx, c.climgr.LoadBalancers().ins, lb.RegionId, id)
+		if err != nil {
+			return nil, exists, err
+		}
+		if eip != nil && eip.InstanceId == lb.LoadBalancerId {
+			return &v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{{IP: eip.IpAddress}}}, true, nil
+		}
+	}
+
 	zone, record, _, err := c.climgr.PrivateZones().findExactRecordByService(ctx, service, lb.Address, lb.AddressIPVersion)
 	if err != nil {
 		return nil, exists, err
 	}
 
 	return &v1.LoadBalancerStatus{
-		Ingress: []v1.LoadBalancerIngress{{
-			IP:       lb.Address,
-			Hostname: getHostName(zone, record),
-		}}}, true, nil
+		Ingress: []v1.LoadBalancerIngress{
+			loadBalancerIngress(service, lb, zone, record),
+		}}, true, nil
 }
 
 // EnsureLoadBalancer creates a new load balancer 'name', or updates the existing one. Returns the status of the balancer
@@ -297,9 +378,39 @@ func (c *Cloud) EnsureLoadBalancer(
 	service *v1.Service,
 	nodes []*v1.Node,
 ) (*v1.LoadBalancerStatus, error) {
+	// the defaults are merged per sync, see utils.WithDefaultAnnotations
+	service = utils.WithDefaultAnnotations(service)
+	// the typos and the malformed values are told before any api is called
+	if err := ValidateAnnotations(ctx, service); err != nil {
+		recordSyncResult(service, err)
+		c.mirrorState(ctx, service, &syncObservation{backends: -1}, nil, err)
+		return nil, err
+	}
+	// the state mirrored reuses the snapshot of the sync
+	ctx = WithSLBSnapshot(ctx)
+	ctx, observed := observeSync(ctx)
+	status, err := c.ensureLoadBalancer(ctx, clusterName, service, nodes)
+	// the sync is retried once with the refreshed token
+	if c.climgr.RefreshExpired(err) {
+		status, err = c.ensureLoadBalancer(ctx, clusterName, service, nodes)
+	}
+	// the backend health is not checked while the service is in backoff
+	recordSyncResult(service, err)
+	c.mirrorState(ctx, service, observed, status, err)
+	return status, err
+}
+
+func (c *Cloud) ensureLoadBalancer(
+	ctx context.Context,
+	clusterName string,
+	service *v1.Service,
+	nodes []*v1.Node,
+) (*v1.LoadBalancerStatus, error) {
 
 	klog.V(2).Infof("Alicloud.EnsureLoadBalancer(%v, %s/%s, %v, %v)",
 		clusterName, service.Namespace, service.Name, c.region, NodeList(nodes))
+	// the describe calls of the sync are served by a snapshot, see SLBSnapshot
+	ctx = WithSLBSnapshot(ctx)
 	defaulted, _ := ExtractAnnotationRequest(service)
 	if defaulted.AddressType == slb.InternetAddressType {
 		if c.cfg != nil && c.cfg.Global.DisablePublicSLB {
@@ -315,6 +426,20 @@ func (c *Cloud) EnsureLoadBalancer(
 	if len(service.Spec.Ports) == 0 {
 		return nil, fmt.Errorf("requested load balancer with no ports")
 	}
+	if err := ValidateListenerAnnotations(service); err != nil {
+		recordInvalidAnnotation(ctx, service, err)
+		return nil, err
+	}
+	if err := c.validateLoadBalancerSwitch(ctx, service); err != nil {
+		recordInvalidAnnotation(ctx, service, err)
+		return nil, err
+	}
+	c.recordVGroupConflicts(ctx, service)
+	// svc carries the cert-id of the certificate uploaded from the cert-secret annotation
+	svc, certid, err := c.ensureSecretCertificate(ctx, service)
+	if err != nil {
+		return nil, err
+	}
 	vswitchid := defaulted.VswitchID
 	if vswitchid == "" {
 		var err error
@@ -338,16 +463,37 @@ func (c *Cloud) EnsureLoadBalancer(
 			klog.Warningf("get available endpoints when EnsureLoadBalancer: %s", err.Error())
 		} else {
 			// avoid removing existing backends of SLB when getting endpoint error
-			return nil, fmt.Errorf("get available endpoints when EnsureLoadBalancer: %s", err.Error())
+			return nil, fmt.Errorf("get available endpoints when EnsureLoadBalancer: %w", err)
 		}
 	}
 	LogSubsetInfo(eps, "api")
+	var gated []*v1.Pod
+	if !isNLB(service) {
+		// pods held by the readiness gate are added before they are ready
+		gated = c.readinessGatedPods(ctx, service, eps)
+		eps = withGatedAddresses(eps, gated)
+	}
+	ns = c.limitBackends(ctx, service, ns, eps)
 
+	existing, err := c.existingNodes(service)
+	if err != nil {
+		return nil, err
+	}
 	backends := &EndpointWithENI{
 		LocalMode:      ServiceModeLocal(service),
 		Endpoints:      eps,
 		Nodes:          ns,
 		BackendTypeENI: IsENIBackendType(service),
+		ExistingNodes:  existing,
+	}
+
+	if isNLB(service) {
+		nlb, err := c.climgr.NLBs().EnsureNLB(ctx, service, backends)
+		if err != nil {
+			return nil, err
+		}
+		utils.PublishLoadBalancerId(ctx, nlb.LoadBalancerId)
+		return nlbStatus(nlb), nil
 	}
 
 	utils.Logf(service, "using vswitch id=%s", vswitchid)
@@ -356,16 +502,36 @@ func (c *Cloud) EnsureLoadBalancer(
 	lb, err := c.climgr.
 		LoadBalancers().
 		EnsureLoadBalancer(
-			ctx, service, backends, vswitchid,
+			ctx, svc, backends, vswitchid,
 		)
 	if err != nil {
 		return nil, err
 	}
+	if certid != "" {
+		// listeners have been rebound, certificates of the previous secret content are unused now.
+		if err := c.climgr.Certificates().CleanupSecretCertificates(ctx, service, certid); err != nil {
+			return nil, err
+		}
+	}
+	c.syncReadinessGates(ctx, service, lb.LoadBalancerId, gated)
+	utils.PublishLoadBalancerId(ctx, lb.LoadBalancerId)
 
 	status := &v1.LoadBalancerStatus{}
 
-	// EIP ExternalIPType, display the slb associated elastic ip as service external ip
-	if defaulted.ExternalIPType == string(EIPExternalIPType) {
+	// the eip of the eip-id annotation is published instead of the intranet address
+	lbc := c.climgr.LoadBalancers().withSnapshot(ctx)
+	eip, err := EnsureEip(ctx, lbc.ins, lbc.c, svc, lb)
+	if err != nil {
+		return nil, fmt.Errorf("ensure eip error: %w", err)
+	}
+	// the eips join the bandwidth package once bound
+	if err := EnsureBandwidthPackage(ctx, lbc.vpc, lbc.ins, lbc.c, svc, lb); err != nil {
+		return nil, fmt.Errorf("ensure bandwidth package error: %w", err)
+	}
+	if eip != nil {
+		status.Ingress = []v1.LoadBalancerIngress{{IP: eip.IpAddress}}
+	} else if defaulted.ExternalIPType == string(EIPExternalIPType) {
+		// EIP ExternalIPType, display the slb associated elastic ip as service external ip
 		status.Ingress, err = c.setEIPAsExternalIP(ctx, lb.LoadBalancerId)
 	}
 
@@ -375,18 +541,18 @@ func (c *Cloud) EnsureLoadBalancer(
 		pz, pzr, err := c.climgr.
 			PrivateZones().
 			EnsurePrivateZoneRecord(
-				ctx, service, lb.Address, defaulted.AddressIPVersion,
+				ctx, service, lb.Address, lb.AddressIPVersion,
 			)
 		if err != nil {
 			return nil, err
 		}
-		status.Ingress = append(status.Ingress,
-			v1.LoadBalancerIngress{
-				IP:       lb.Address,
-				Hostname: getHostName(pz, pzr),
-			})
+		status.Ingress = append(status.Ingress, loadBalancerIngress(service, lb, pz, pzr))
 
 	}
+	// the hostname is resolved by the user, e.g. a cname to the ingress above
+	if hstatus := hostnameStatus(service); hstatus != nil {
+		return hstatus, err
+	}
 	return status, err
 }
 
@@ -399,6 +565,20 @@ func (c *Cloud) UpdateLoadBalancer(
 	clusterName string,
 	service *v1.Service,
 	nodes []*v1.Node,
+) error {
+	service = utils.WithDefaultAnnotations(service)
+	err := c.updateLoadBalancer(ctx, clusterName, service, nodes)
+	if c.climgr.RefreshExpired(err) {
+		err = c.updateLoadBalancer(ctx, clusterName, service, nodes)
+	}
+	return err
+}
+
+func (c *Cloud) updateLoadBalancer(
+	ctx context.Context,
+	clusterName string,
+	service *v1.Service,
+	nodes []*v1.Node,
 ) error {
 	klog.V(2).Infof("Alicloud.UpdateLoadBalancer(%v, %v, %v, %v, %v, %v, %v)",
 		clusterName, service.Namespace, service.Name, c.region, service.Spec.LoadBalancerIP, service.Spec.Ports, NodeList(nodes))
@@ -420,14 +600,27 @@ func (c *Cloud) UpdateLoadBalancer(
 			klog.Warningf("get available endpoints when UpdateLoadBalancer: %s", err.Error())
 		} else {
 			// avoid removing existing backends of SLB when getting endpoint error
-			return fmt.Errorf("get available endpoints when UpdateLoadBalancer: %s", err.Error())
+			return fmt.Errorf("get available endpoints when UpdateLoadBalancer: %w", err)
 		}
 	}
+	if !isNLB(service) {
+		// keep the pods held by the readiness gate, their condition is set by EnsureLoadBalancer
+		eps = withGatedAddresses(eps, c.readinessGatedPods(ctx, service, eps))
+	}
+	ns = c.limitBackends(ctx, service, ns, eps)
+	existing, err := c.existingNodes(service)
+	if err != nil {
+		return err
+	}
 	backends := &EndpointWithENI{
 		LocalMode:      ServiceModeLocal(service),
 		Endpoints:      eps,
 		Nodes:          ns,
 		BackendTypeENI: IsENIBackendType(service),
+		ExistingNodes:  existing,
+	}
+	if isNLB(service) {
+		return c.climgr.NLBs().UpdateNLB(ctx, service, backends)
 	}
 	return c.climgr.LoadBalancers().UpdateLoadBalancer(ctx, service, backends, true)
 }
@@ -444,20 +637,64 @@ func (c *Cloud) EnsureLoadBalancerDeleted(
 	ctx context.Context,
 	clusterName string,
 	service *v1.Service,
+) error {
+	service = utils.WithDefaultAnnotations(service)
+	err := c.ensureLoadBalancerDeleted(ctx, clusterName, service)
+	if c.climgr.RefreshExpired(err) {
+		err = c.ensureLoadBalancerDeleted(ctx, clusterName, service)
+	}
+	if err == nil {
+		c.unmirrorState(ctx, service)
+	}
+	return err
+}
+
+func (c *Cloud) ensureLoadBalancerDeleted(
+	ctx context.Context,
+	clusterName string,
+	service *v1.Service,
 ) error {
 	klog.V(2).Infof("Alicloud.EnsureLoadBalancerDeleted(%v, %v, %v, %v, %v, %v)",
 		clusterName, service.Namespace, service.Name, c.region, service.Spec.LoadBalancerIP, service.Spec.Ports)
 
+	// the nlb is looked up by the type, or by the dns name published in case
+	// the annotation has been removed
+	if servedByNLB(service) {
+		if err := c.climgr.NLBs().EnsureNLBDeleted(ctx, service); err != nil {
+			if isNLB(service) {
+				return err
+			}
+			klog.Warningf("ensure nlb of service %s/%s deleted: %s", service.Namespace, service.Name, err.Error())
+		}
+	}
+
 	defaulted, _ := ExtractAnnotationRequest(service)
 
+	ip, version := "", defaulted.AddressIPVersion
 	if len(service.Status.LoadBalancer.Ingress) > 0 {
-		err := c.climgr.PrivateZones().EnsurePrivateZoneRecordDeleted(ctx, service, service.Status.LoadBalancer.Ingress[0].IP, defaulted.AddressIPVersion)
+		ip = service.Status.LoadBalancer.Ingress[0].IP
+	}
+	// the record points at the address of the loadbalancer, the ingress
+	// has no ip when only the hostname is published.
+	exists, lb, err := c.climgr.LoadBalancers().FindLoadBalancer(ctx, service)
+	if err != nil {
+		return err
+	}
+	if exists {
+		ip, version = lb.Address, lb.AddressIPVersion
+	}
+	if ip != "" {
+		err := c.climgr.PrivateZones().EnsurePrivateZoneRecordDeleted(ctx, service, ip, version)
 		if err != nil {
 			return err
 		}
 	}
 
-	return c.climgr.LoadBalancers().EnsureLoadBalanceDeleted(ctx, service)
+	if err := c.climgr.LoadBalancers().EnsureLoadBalanceDeleted(ctx, service); err != nil {
+		return err
+	}
+	// only the certificates uploaded from the cert-secret annotation are deleted
+	return c.climgr.Certificates().CleanupSecretCertificates(ctx, service, "")
 }
 
 // NodeAddresses returns the addresses of the specified instance.
@@ -479,6 +716,14 @@ func (c *Cloud) ListInstances(ctx context.Context, ids []string) (map[string]*no
 }
 
 func (c *Cloud) SetInstanceTags(ctx context.Context, insid string, tags map[string]string) error {
+	// the instances are tagged with the cluster id like the loadbalancers
+	if _, ok := tags[ACKKEY]; !ok && c.HasClusterID() {
+		tagged := map[string]string{ACKKEY: CLUSTER_ID}
+		for k, v := range tags {
+			tagged[k] = v
+		}
+		tags = tagged
+	}
 	return c.climgr.Instances().AddCloudTags(ctx, insid, tags, c.region)
 }
 
@@ -706,7 +951,7 @@ func (c *Cloud) Zones() (cloudprovider.Zones, bool) { return c, true }
 func (c *Cloud) Routes() (cloudprovider.Routes, bool) { return nil, false }
 
 // HasClusterID returns true if a ClusterID is required and set
-func (c *Cloud) HasClusterID() bool { return CLUSTER_ID != "clusterid" }
+func (c *Cloud) HasClusterID() bool { return CLUSTER_ID != DEFAULT_CLUSTER_ID }
 
 //
 func (c *Cloud) fileOutNode(nodes []*v1.Node, service *v1.Service) ([]*v1.Node, error) {
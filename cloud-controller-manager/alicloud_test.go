@@ -23,6 +23,7 @@ import (
 	"fmt"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/controller/node"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
 	"strings"
 	"testing"
 
@@ -57,6 +58,37 @@ var (
 	//nodeName                  = "iZuf694l8lw6xvdx6gh7tkZ"
 )
 
+// testService the loadbalancer service my-service of the annotations and the
+// ports, a tcp port of listenPort1 if none is given
+func testService(annotations map[string]string, ports ...v1.ServicePort) *v1.Service {
+	if len(ports) == 0 {
+		ports = []v1.ServicePort{
+			{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+		}
+	}
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "my-service",
+			Namespace:   "default",
+			UID:         types.UID(serviceUIDNoneExist),
+			Annotations: annotations,
+		},
+		Spec: v1.ServiceSpec{
+			Ports:           ports,
+			Type:            v1.ServiceTypeLoadBalancer,
+			SessionAffinity: v1.ServiceAffinityNone,
+		},
+	}
+}
+
+// testServiceNamed testService named name with uid, the services living next
+// to my-service
+func testServiceNamed(name, uid string, annotations map[string]string, ports ...v1.ServicePort) *v1.Service {
+	svc := testService(annotations, ports...)
+	svc.Name, svc.UID = name, types.UID(uid)
+	return svc
+}
+
 func TestImplements(t *testing.T) {
 	var cloud cloudprovider.Interface = &Cloud{}
 	_ ,ok := cloud.(node.CloudInstance)
@@ -169,7 +201,8 @@ func TestEnsureLoadBalancerAnnotation(t *testing.T) {
 				Namespace: "default",
 				UID:       types.UID(serviceUIDNoneExist),
 				Annotations: map[string]string{
-					ServiceAnnotationLoadBalancerProtocolPort: "http:80",
+					ServiceAnnotationLoadBalancerProtocolPort: "http:80,https:443",
+					ServiceAnnotationLoadBalancerCertID:       certID,
 					ServiceAnnotationLoadBalancerAddressType:  string(slb.InternetAddressType),
 					//ServiceAnnotationLoadBalancerVswitch: 		VSWITCH_ID,
 					ServiceAnnotationLoadBalancerForwardPort: "80:443",
@@ -179,7 +212,7 @@ func TestEnsureLoadBalancerAnnotation(t *testing.T) {
 					//ServiceAnnotationLoadBalancerBackendLabel: "key=value",
 					ServiceAnnotationLoadBalancerRegion:       string(REGION),
 					ServiceAnnotationLoadBalancerMasterZoneID: string(REGION_A),
-					ServiceAnnotationLoadBalancerSlaveZoneID:  string(REGION_A),
+					ServiceAnnotationLoadBalancerSlaveZoneID:  string(REGION_B),
 					ServiceAnnotationLoadBalancerBandwidth:    "70",
 					ServiceAnnotationLoadBalancerScheduler:    "wlc",
 
@@ -192,7 +225,7 @@ func TestEnsureLoadBalancerAnnotation(t *testing.T) {
 					ServiceAnnotationLoadBalancerHealthCheckType:               "tcp",
 					ServiceAnnotationLoadBalancerHealthCheckURI:                "/v1/check",
 					ServiceAnnotationLoadBalancerHealthCheckConnectPort:        "80",
-					ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold:   "20",
+					ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold:   "10",
 					ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold: "5",
 					ServiceAnnotationLoadBalancerHealthCheckInterval:           "5",
 					ServiceAnnotationLoadBalancerHealthCheckConnectTimeout:     "5",
@@ -203,7 +236,7 @@ func TestEnsureLoadBalancerAnnotation(t *testing.T) {
 					ServiceAnnotationLoadBalancerOverrideListener:              "true",
 					ServiceAnnotationLoadBalancerSpec:                          "lb-mini-spec",
 					ServiceAnnotationLoadBalancerSessionStick:                  "on",
-					ServiceAnnotationLoadBalancerSessionStickType:              "cookie",
+					ServiceAnnotationLoadBalancerSessionStickType:              "server",
 					ServiceAnnotationLoadBalancerCookieTimeout:                 "5000",
 					ServiceAnnotationLoadBalancerCookie:                        "none-cookie",
 					ServiceAnnotationLoadBalancerPersistenceTimeout:            "7400",
@@ -217,6 +250,7 @@ func TestEnsureLoadBalancerAnnotation(t *testing.T) {
 			Spec: v1.ServiceSpec{
 				Ports: []v1.ServicePort{
 					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 31443},
 				},
 				Type:            v1.ServiceTypeLoadBalancer,
 				SessionAffinity: v1.ServiceAffinityNone,
@@ -574,6 +608,7 @@ func TestEnsureLoadBalancerWithENI(t *testing.T) {
 		},
 	)
 	f.RunDefault(t, "ENI backend Type test")
+	ExpectLoadBalancerEqual(t, f)
 }
 
 func TestEnsureLoadbalancerDeleted(t *testing.T) {
@@ -676,6 +711,281 @@ func TestEnsureLoadBalancerDeleteWithUserDefined(t *testing.T) {
 	)
 }
 
+func TestEnsureLoadBalancerDeleteProtection(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerDeleteProtection: "on",
+					ServiceAnnotationLoadBalancerRetainOnDelete:   "on",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	protection := func() (bool, slb.FlagType, error) {
+		exist, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || !exist {
+			return exist, "", err
+		}
+		return exist, lb.DeleteProtection, nil
+	}
+
+	f.RunCustomized(
+		t, "Create Loadbalancer with delete protection",
+		func(f *FrameWork) error {
+			if _, err := f.Cloud.EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			exist, flag, err := protection()
+			if err != nil || !exist || flag != slb.OnFlag {
+				return fmt.Errorf("expect delete protection on, got %v, %t, %s", err, exist, flag)
+			}
+			return nil
+		},
+	)
+
+	f.RunCustomized(
+		t, "Restore delete protection turned off out of band",
+		func(f *FrameWork) error {
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil {
+				return err
+			}
+			if err := f.SLBSDK().SetLoadBalancerDeleteProtection(
+				context.Background(),
+				&slb.SetLoadBalancerDeleteProtectionArgs{LoadBalancerId: lb.LoadBalancerId, DeleteProtection: slb.OffFlag},
+			); err != nil {
+				return err
+			}
+			if _, err := f.Cloud.EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			_, flag, err := protection()
+			if err != nil || flag != slb.OnFlag {
+				return fmt.Errorf("expect delete protection restored, got %v, %s", err, flag)
+			}
+			return nil
+		},
+	)
+
+	f.RunCustomized(
+		t, "Retain Loadbalancer on delete",
+		func(f *FrameWork) error {
+			if err := f.Cloud.EnsureLoadBalancerDeleted(context.Background(), CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			exist, flag, err := protection()
+			if err != nil || !exist || flag != slb.OnFlag {
+				return fmt.Errorf("expect loadbalancer retained with delete protection, got %v, %t, %s", err, exist, flag)
+			}
+			return nil
+		},
+	)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerRetainOnDelete)
+	f.RunCustomized(
+		t, "Turn off delete protection before delete",
+		func(f *FrameWork) error {
+			if err := f.Cloud.EnsureLoadBalancerDeleted(context.Background(), CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			exist, _, err := protection()
+			if err != nil || exist {
+				return fmt.Errorf("expect loadbalancer deleted, got %v, %t", err, exist)
+			}
+			return nil
+		},
+	)
+}
+
+func TestModificationProtection(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerModificationProtection: string(slb.ConsoleProtection),
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(status slb.ModificationProtectionType, reason string) CustomizedTest {
+		return func(f *FrameWork) error {
+			if _, err := f.Cloud.EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil {
+				return err
+			}
+			if lb.ModificationProtectionStatus != status || lb.ModificationProtectionReason != reason {
+				return fmt.Errorf("expect modification protection %s:%s, got %s:%s",
+					status, reason, lb.ModificationProtectionStatus, lb.ModificationProtectionReason)
+			}
+			return nil
+		}
+	}
+
+	f.RunCustomized(t, "Create with console protection",
+		expect(slb.ConsoleProtection, "managed.by.ccm.for.service.default.my-service"))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerModificationProtectionReason] = "locked-by-sre"
+	f.RunCustomized(t, "Change console protection reason",
+		expect(slb.ConsoleProtection, "locked-by-sre"))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerModificationProtection] = string(slb.NonProtection)
+	f.RunCustomized(t, "Clear console protection",
+		expect(slb.NonProtection, ""))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerModificationProtectionReason] = "locked by sre"
+	f.RunCustomized(t, "Invalid console protection reason",
+		func(f *FrameWork) error {
+			_, err := f.Cloud.EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+				return fmt.Errorf("expect InvalidAnnotation error, got %v", err)
+			}
+			return nil
+		},
+	)
+}
+
+func TestInternetSpec(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerChargeType: string(slb.PayByBandwidth),
+					ServiceAnnotationLoadBalancerBandwidth:  "10",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(charge slb.InternetChargeType, bandwidth int) CustomizedTest {
+		return func(f *FrameWork) error {
+			if _, err := f.Cloud.EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil {
+				return err
+			}
+			if lb.InternetChargeType != charge || lb.Bandwidth != bandwidth {
+				return fmt.Errorf("expect internet spec %s:%d, got %s:%d",
+					charge, bandwidth, lb.InternetChargeType, lb.Bandwidth)
+			}
+			return nil
+		}
+	}
+
+	f.RunCustomized(t, "Create with bandwidth", expect(slb.PayByBandwidth, 10))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerBandwidth] = "20"
+	f.RunCustomized(t, "Modify bandwidth in place", expect(slb.PayByBandwidth, 20))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerChargeType] = string(slb.PayByTraffic)
+	f.RunCustomized(t, "Modify charge type in place", expect(slb.PayByTraffic, 20))
+
+	mock := f.SLBSDK().(*mockClientSLB)
+	calls := 0
+	mock.modifyLoadBalancerInternetSpec = func(args *slb.ModifyLoadBalancerInternetSpecArgs) error {
+		calls++
+		return nil
+	}
+	defer func() { mock.modifyLoadBalancerInternetSpec = nil }()
+	f.RunCustomized(t, "No modification when the spec matches",
+		func(f *FrameWork) error {
+			if err := expect(slb.PayByTraffic, 20)(f); err != nil {
+				return err
+			}
+			if calls != 0 {
+				return fmt.Errorf("expect no ModifyLoadBalancerInternetSpec call, got %d", calls)
+			}
+			return nil
+		},
+	)
+
+	for _, bandwidth := range []string{"0", "5121", "ten"} {
+		f.SVC.Annotations[ServiceAnnotationLoadBalancerBandwidth] = bandwidth
+		f.RunCustomized(t, fmt.Sprintf("Invalid bandwidth %s", bandwidth),
+			func(f *FrameWork) error {
+				_, err := f.Cloud.EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+				if err == nil || !strings.Contains(err.Error(), ServiceAnnotationLoadBalancerBandwidth) {
+					return fmt.Errorf("expect bandwidth validation error, got %v", err)
+				}
+				return nil
+			},
+		)
+	}
+}
+
 func TestNodeAddressAndInstanceID(t *testing.T) {
 
 	// Step 2: init Cloud cache data.
@@ -773,3 +1083,71 @@ var con string = `
    }
  }
  `
+
+func TestPublishLoadBalancerId(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "basic-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	// ensure the loadbalancer, returns the published id
+	ensure := func(f *FrameWork) (string, error) {
+		published := ""
+		ctx := context.WithValue(context.Background(), utils.ContextLoadBalancerId, func(id string) { published = id })
+		_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+		return published, err
+	}
+	var first string
+	f.RunCustomized(t, "Id of the created loadbalancer is published",
+		func(f *FrameWork) error {
+			published, err := ensure(f)
+			if err != nil {
+				return err
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			if published != lb.LoadBalancerId {
+				return fmt.Errorf("expect id %s published, got [%s]", lb.LoadBalancerId, published)
+			}
+			first = published
+			return nil
+		},
+	)
+
+	f.RunCustomized(t, "Id is published again on resync",
+		func(f *FrameWork) error {
+			published, err := ensure(f)
+			if err != nil {
+				return err
+			}
+			if published != first {
+				return fmt.Errorf("expect id %s published, got [%s]", first, published)
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,402 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The annotations supported are registered in annotationRegistry, which is
+// checked before each sync: a key under the prefix of the alibaba cloud not
+// registered is most likely a typo silently ignored, and is warned of with
+// the nearest key registered. A value not of the syntax of its annotation
+// blocks the sync. The semantics, eg. a cert of a https port, are left to the
+// validators of each annotation, see ValidateListenerAnnotations.
+
+// annotationFormat the syntax of the value of an annotation
+type annotationFormat struct {
+	// expected the format told of an invalid value
+	expected string
+	valid    func(value string) bool
+}
+
+// annotationSpec an annotation supported
+type annotationSpec struct {
+	// format nil for a free-form value or one checked by its own validator
+	format *annotationFormat
+	// perPort the annotation can be overridden per port, see PortOverrides
+	perPort bool
+}
+
+func enumFormat(values ...string) *annotationFormat {
+	return &annotationFormat{
+		expected: fmt.Sprintf("one of %s", strings.Join(values, ", ")),
+		valid: func(value string) bool {
+			for _, v := range values {
+				if strings.EqualFold(value, v) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// enumListFormat a comma separated list of distinct values of the enum, eg.
+// the http codes of a health check
+func enumListFormat(values ...string) *annotationFormat {
+	return &annotationFormat{
+		expected: fmt.Sprintf("a comma separated list of %s", strings.Join(values, ", ")),
+		valid: func(value string) bool {
+			seen := map[string]bool{}
+			for _, v := range strings.Split(value, ",") {
+				known := false
+				for _, e := range values {
+					known = known || v == e
+				}
+				if !known || seen[v] {
+					return false
+				}
+				seen[v] = true
+			}
+			return true
+		},
+	}
+}
+
+var (
+	onOffFormat     = enumFormat("on", "off")
+	trueFalseFormat = enumFormat("true", "false")
+)
+
+func intFormat(min, max int) *annotationFormat {
+	return &annotationFormat{
+		expected: fmt.Sprintf("an integer in range [%d, %d]", min, max),
+		valid: func(value string) bool {
+			i, err := strconv.Atoi(value)
+			return err == nil && i >= min && i <= max
+		},
+	}
+}
+
+func minIntFormat(min int) *annotationFormat {
+	return &annotationFormat{
+		expected: fmt.Sprintf("an integer of at least %d", min),
+		valid: func(value string) bool {
+			i, err := strconv.Atoi(value)
+			return err == nil && i >= min
+		},
+	}
+}
+
+// idFormat the id of a resource of one of the prefixes, eg. "vsw-"
+func idFormat(prefixes ...string) *annotationFormat {
+	re := regexp.MustCompile(fmt.Sprintf(`^(%s)[0-9a-zA-Z-]+$`, strings.Join(prefixes, "|")))
+	var examples []string
+	for _, prefix := range prefixes {
+		examples = append(examples, prefix+"xxx")
+	}
+	return &annotationFormat{
+		expected: fmt.Sprintf("an id like %s", strings.Join(examples, " or ")),
+		valid:    re.MatchString,
+	}
+}
+
+// annotationRegistry the annotations supported by their normalized key, see
+// normalizedAnnotation
+var annotationRegistry = map[string]annotationSpec{
+	ServiceAnnotationLoadBalancerAclStatus:                     {format: onOffFormat},
+	ServiceAnnotationLoadBalancerAclID:                         {format: idFormat("acl-")},
+	ServiceAnnotationLoadBalancerAclType:                       {format: enumFormat("white", "black")},
+	ServiceAnnotationLoadBalancerAccessLogProject:              {},
+	ServiceAnnotationLoadBalancerAccessLogLogstore:             {},
+	ServiceAnnotationLoadBalancerAccessLogRegion:               {},
+	ServiceAnnotationLoadBalancerProtocolPort:                  {},
+	ServiceAnnotationLoadBalancerAddressType:                   {format: enumFormat("internet", "intranet")},
+	ServiceAnnotationLoadBalancerVswitch:                       {format: idFormat("vsw-")},
+	ServiceAnnotationLoadBalancerForwardPort:                   {},
+	ServiceAnnotationLoadBalancerSLBNetworkType:                {format: enumFormat("classic", "vpc")},
+	ServiceAnnotationLoadBalancerChargeType:                    {},
+	ServiceAnnotationLoadBalancerId:                            {format: idFormat("lb-", "nlb-")},
+	ServiceAnnotationLoadBalancerName:                          {},
+	ServiceAnnotationLoadBalancerBackendLabel:                  {},
+	ServiceAnnotationLoadBalancerRegion:                        {},
+	ServiceAnnotationLoadBalancerMasterZoneID:                  {},
+	ServiceAnnotationLoadBalancerSlaveZoneID:                   {},
+	ServiceAnnotationLoadBalancerForceRecreateZones:            {format: trueFalseFormat},
+	ServiceAnnotationLoadBalancerRecreateOnAddressTypeChange:   {format: trueFalseFormat},
+	ServiceAnnotationLoadBalancerBandwidth:                     {},
+	ServiceAnnotationLoadBalancerBandwidthPolicy:               {format: enumFormat(BandwidthPolicyReject, BandwidthPolicyClamp)},
+	ServiceAnnotationLoadBalancerCertID:                        {format: certIDFormat, perPort: true},
+	ServiceAnnotationLoadBalancerAdditionalCertIDs:             {perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckFlag:               {format: onOffFormat, perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckSwitch:             {perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckType:               {perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckURI:                {perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckConnectPort:        {perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold:   {perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold: {perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckInterval:           {perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckConnectTimeout:     {format: intFormat(1, 300), perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckTimeout:            {format: intFormat(1, 300), perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckDomain:             {perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckHTTPCode:           {format: healthCheckHTTPCodeFormat, perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckReq:                {perPort: true},
+	ServiceAnnotationLoadBalancerHealthCheckExp:                {perPort: true},
+	ServiceAnnotationLoadBalancerAdditionalTags:                {},
+	ServiceAnnotationLoadBalancerOverrideListener:              {format: trueFalseFormat},
+	ServiceAnnotationLoadBalancerSpec:                          {},
+	ServiceAnnotationLoadBalancerInstanceChargeType:            {},
+	ServiceAnnotationLoadBalancerScheduler:                     {format: enumFormat("wrr", "wlc", "rr", "sch", "tch"), perPort: true},
+	ServiceAnnotationLoadBalancerSessionStick:                  {},
+	ServiceAnnotationLoadBalancerSessionStickType:              {},
+	ServiceAnnotationLoadBalancerCookieTimeout:                 {},
+	ServiceAnnotationLoadBalancerCookie:                        {},
+	ServiceAnnotationLoadBalancerIdleTimeout:                   {format: minIntFormat(0), perPort: true},
+	ServiceAnnotationLoadBalancerRequestTimeout:                {format: minIntFormat(0), perPort: true},
+	ServiceAnnotationLoadBalancerConnectionDrain:               {perPort: true},
+	ServiceAnnotationLoadBalancerConnectionDrainTimeout:        {perPort: true},
+	ServiceAnnotationLoadBalancerEstablishedTimeout:            {format: minIntFormat(0), perPort: true},
+	ServiceAnnotationLoadBalancerProxyProtocol:                 {format: onOffFormat, perPort: true},
+	ServiceAnnotationLoadBalancerXForwardedFor:                 {perPort: true},
+	ServiceAnnotationLoadBalancerXForwardedForProto:            {perPort: true},
+	ServiceAnnotationLoadBalancerXForwardedForSLBID:            {perPort: true},
+	ServiceAnnotationLoadBalancerXForwardedForSLBIP:            {perPort: true},
+	ServiceAnnotationLoadBalancerGzip:                          {perPort: true},
+	ServiceAnnotationLoadBalancerPortOverrides:                 {},
+	// clamped to PERSISTENCE_TIMEOUT_MAX
+	ServiceAnnotationLoadBalancerPersistenceTimeout:             {format: minIntFormat(0), perPort: true},
+	ServiceAnnotationLoadBalancerIPVersion:                      {},
+	ServiceAnnotationLoadBalancerPrivateZoneName:                {},
+	ServiceAnnotationLoadBalancerPrivateZoneId:                  {},
+	ServiceAnnotationLoadBalancerPrivateZoneRecordName:          {},
+	ServiceAnnotationLoadBalancerPrivateZoneRecordTTL:           {format: minIntFormat(1)},
+	ServiceAnnotationLoadBalancerPrivateZoneIngressHostname:     {format: trueFalseFormat},
+	ServiceAnnotationLoadBalancerBackendType:                    {},
+	ServiceAnnotationLoadBalancerResourceGroupId:                {format: idFormat("rg-")},
+	ServiceAnnotationLoadBalancerDeleteProtection:               {format: onOffFormat},
+	ServiceAnnotationLoadBalancerRetainOnDelete:                 {format: onOffFormat},
+	ServiceAnnotationLoadBalancerModificationProtection:         {},
+	ServiceAnnotationLoadBalancerModificationProtectionReason:   {},
+	ServiceAnnotationLoadBalancerExternalIPType:                 {},
+	ServiceAnnotationLoadBalancerEipId:                          {},
+	ServiceAnnotationLoadBalancerHostname:                       {},
+	ServiceAnnotationLoadBalancerAddress:                        {},
+	ServiceAnnotationLoadBalancerWeight:                         {},
+	ServiceAnnotationLoadBalancerWeightMode:                     {},
+	ServiceAnnotationLoadBalancerGracefulDrainSeconds:           {},
+	ServiceAnnotationLoadBalancerVGroupPort:                     {},
+	ServiceAnnotationLoadBalancerMaxBackends:                    {},
+	ServiceAnnotationLoadBalancerZoneBalancedBackends:           {format: onOffFormat},
+	ServiceAnnotationLoadBalancerKeepLastBackends:               {},
+	ServiceAnnotationLoadBalancerType:                           {},
+	ServiceAnnotationLoadBalancerZoneMaps:                       {},
+	ServiceAnnotationLoadBalancerManagedPorts:                   {},
+	ServiceAnnotationLoadBalancerEmptyBackendsGuard:             {},
+	ServiceAnnotationLoadBalancerForceDelete:                    {},
+	ServiceAnnotationLoadBalancerBandwidthPackageId:             {format: idFormat("cbwp-")},
+	ServiceAnnotationLoadBalancerListenerBandwidth:              {format: listenerBandwidthFormat, perPort: true},
+	utils.ServiceAnnotationLoadBalancerRemoveUnscheduledBackend: {format: onOffFormat},
+	utils.ServiceAnnotationLoadBalancerCertSecret:               {},
+	utils.ServiceAnnotationLoadBalancerBackendZones:             {},
+	utils.ServiceAnnotationLoadBalancerNotReadyToleranceSeconds: {format: minIntFormat(0)},
+	utils.ServiceAnnotationLoadBalancerIdResult:                 {},
+	utils.ServiceAnnotationReconcileTimestamp:                   {},
+	utils.ServiceAnnotationReconcileTimestampResult:             {},
+}
+
+// ANNOTATION_DOMAIN the annotations of the domain are checked for typos of
+// the prefix, eg. service.beta.kubernetes.io/alibabacloud-loadbalancer-spec
+const ANNOTATION_DOMAIN = "service.beta.kubernetes.io/"
+
+// normalizedAnnotation the key of the annotation as registered, the legacy
+// prefix and camel case keys are taken as getBackwardsCompatibleAnnotation
+func normalizedAnnotation(key string) string {
+	return replaceCamel(normalizePrefix(key))
+}
+
+// suggestAnnotation the registered annotation nearest to the key, empty if
+// none is near enough to be a typo of it
+func suggestAnnotation(key string) string {
+	// the keys are compared without the domain they share
+	name := strings.TrimPrefix(key, ANNOTATION_DOMAIN)
+	suggestion, nearest := "", -1
+	for registered := range annotationRegistry {
+		d := editDistance(name, strings.TrimPrefix(registered, ANNOTATION_DOMAIN))
+		if nearest < 0 || d < nearest || (d == nearest && registered < suggestion) {
+			suggestion, nearest = registered, d
+		}
+	}
+	// a typo is a few edits away from the key
+	if nearest < 0 || nearest*4 > len(name) {
+		return ""
+	}
+	return suggestion
+}
+
+// editDistance the levenshtein distance of a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// annotationProblems the annotations of a service unknown or of an invalid
+// value. Unknown keys are warned of only, invalid values block the sync.
+type annotationProblems struct {
+	// unknown the keys not registered, with the nearest registered if any
+	unknown []string
+	// invalid the values not of the format of their annotation
+	invalid []string
+}
+
+func (p annotationProblems) empty() bool {
+	return len(p.unknown) == 0 && len(p.invalid) == 0
+}
+
+func (p annotationProblems) String() string {
+	var parts []string
+	if len(p.unknown) > 0 {
+		parts = append(parts, fmt.Sprintf("unknown annotations %s", strings.Join(p.unknown, ", ")))
+	}
+	if len(p.invalid) > 0 {
+		parts = append(parts, fmt.Sprintf("invalid values %s", strings.Join(p.invalid, ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// checkAnnotations check the keys and the syntax of the values of the
+// annotations of the service, those overridden per port included
+func checkAnnotations(service *v1.Service) annotationProblems {
+	var problems annotationProblems
+	keys := make([]string, 0, len(service.Annotations))
+	for key := range service.Annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		normalized := normalizedAnnotation(key)
+		spec, known := annotationRegistry[normalized]
+		if listenerBandwidthPort(normalized) != 0 {
+			// listener-bandwidth of a single port
+			spec, known = annotationRegistry[ServiceAnnotationLoadBalancerListenerBandwidth], true
+		}
+		if !known {
+			if !strings.HasPrefix(normalized, ANNOTATION_DOMAIN) {
+				continue
+			}
+			suggestion := suggestAnnotation(normalized)
+			switch {
+			case suggestion != "":
+				problems.unknown = append(problems.unknown, fmt.Sprintf("%s (did you mean %s?)", key, suggestion))
+			case strings.HasPrefix(normalized, ServiceAnnotationPrefix):
+				problems.unknown = append(problems.unknown, key)
+			}
+			// the other annotations of the domain are not of the alibaba cloud
+			continue
+		}
+		if problem := checkAnnotationValue(key, spec, service.Annotations[key]); problem != "" {
+			problems.invalid = append(problems.invalid, problem)
+		}
+	}
+	// the values overridden per port, which are merged by ServiceForPort. The
+	// overrides malformed are rejected by PortOverrides itself.
+	overrides, err := PortOverrides(service)
+	if err != nil {
+		return problems
+	}
+	ports := make([]int, 0, len(overrides))
+	for port := range overrides {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		values := overrides[int32(port)]
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			spec := annotationRegistry[ServiceAnnotationLoadBalancerPrefix+key]
+			name := fmt.Sprintf("%s[%d].%s", ServiceAnnotationLoadBalancerPortOverrides, port, key)
+			if problem := checkAnnotationValue(name, spec, values[key]); problem != "" {
+				problems.invalid = append(problems.invalid, problem)
+			}
+		}
+	}
+	return problems
+}
+
+// checkAnnotationValue the problem of the value of the annotation, empty if
+// it is valid
+func checkAnnotationValue(key string, spec annotationSpec, value string) string {
+	if spec.format == nil || value == "" || spec.format.valid(value) {
+		return ""
+	}
+	return fmt.Sprintf("%s=%q (expected %s)", key, value, spec.format.expected)
+}
+
+// ValidateAnnotations check the annotations of the service against the
+// registry before the sync. The unknown keys and the invalid values are told
+// by a single InvalidAnnotation event, only the invalid values fail the sync.
+func ValidateAnnotations(ctx context.Context, service *v1.Service) error {
+	problems := checkAnnotations(service)
+	if problems.empty() {
+		return nil
+	}
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "annotation problems: %s", problems)
+	} else {
+		record.Eventf(service, v1.EventTypeWarning, utils.InvalidAnnotation, "Annotation problems: %s", problems)
+	}
+	if len(problems.invalid) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s: invalid values %s", utils.InvalidAnnotation, strings.Join(problems.invalid, ", "))
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestCheckAnnotations(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		unknown     []string
+		invalid     []string
+	}{
+		{
+			name: "valid",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerSpec:          "slb.s1.small",
+				ServiceAnnotationLoadBalancerAddressType:   "intranet",
+				ServiceAnnotationLoadBalancerVswitch:       "vsw-2zeclpmxy66zzxj4cg4ls",
+				ServiceAnnotationLoadBalancerAclStatus:     "on",
+				utils.ServiceAnnotationReconcileTimestamp:  "2021-01-01T00:00:00Z",
+				"service.beta.kubernetes.io/aws-load-type": "nlb",
+				"example.com/owner":                        "team",
+			},
+		},
+		{
+			name: "legacy camel case",
+			annotations: map[string]string{
+				"service.beta.kubernetes.io/alicloud-loadbalancer-AddressType": "intranet",
+				"service.beta.kubernetes.io/alicloud-loadbalancer-CertID":      "cert-a",
+			},
+		},
+		{
+			name:        "typo of the key",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerPrefix + "sepc": "slb.s1.small"},
+			unknown: []string{
+				fmt.Sprintf("%ssepc (did you mean %s?)", ServiceAnnotationLoadBalancerPrefix, ServiceAnnotationLoadBalancerSpec),
+			},
+		},
+		{
+			name:        "typo of the prefix",
+			annotations: map[string]string{"service.beta.kubernetes.io/alibabacloud-loadbalancer-spec": "slb.s1.small"},
+			unknown: []string{
+				fmt.Sprintf("service.beta.kubernetes.io/alibabacloud-loadbalancer-spec (did you mean %s?)",
+					ServiceAnnotationLoadBalancerSpec),
+			},
+		},
+		{
+			name:        "unknown without a near match",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerPrefix + "frobnicate-everything-now": "on"},
+			unknown:     []string{ServiceAnnotationLoadBalancerPrefix + "frobnicate-everything-now"},
+		},
+		{
+			name:        "enum",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerAddressType: "public"},
+			invalid: []string{
+				fmt.Sprintf("%s=\"public\" (expected one of internet, intranet)", ServiceAnnotationLoadBalancerAddressType),
+			},
+		},
+		{
+			name:        "int out of range",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerHealthCheckTimeout: "301"},
+			invalid: []string{
+				fmt.Sprintf("%s=\"301\" (expected an integer in range [1, 300])", ServiceAnnotationLoadBalancerHealthCheckTimeout),
+			},
+		},
+		{
+			name:        "not an int",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerPersistenceTimeout: "1h"},
+			invalid: []string{
+				fmt.Sprintf("%s=\"1h\" (expected an integer of at least 0)", ServiceAnnotationLoadBalancerPersistenceTimeout),
+			},
+		},
+		{
+			name:        "id",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerId: "i-xlakjbidlslkcdxxxx"},
+			invalid: []string{
+				fmt.Sprintf("%s=\"i-xlakjbidlslkcdxxxx\" (expected an id like lb-xxx or nlb-xxx)", ServiceAnnotationLoadBalancerId),
+			},
+		},
+		{
+			name: "port override",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerPortOverrides: `{"443":{"health-check-flag":"yes"}}`,
+			},
+			invalid: []string{
+				fmt.Sprintf("%s[443].health-check-flag=\"yes\" (expected one of on, off)", ServiceAnnotationLoadBalancerPortOverrides),
+			},
+		},
+		{
+			name: "listener bandwidth of a port",
+			annotations: map[string]string{
+				listenerBandwidthAnnotation(80):  "-1",
+				listenerBandwidthAnnotation(443): "0",
+			},
+			invalid: []string{
+				fmt.Sprintf("%s=\"0\" (expected -1 or an integer in range [1, 5120])", listenerBandwidthAnnotation(443)),
+			},
+		},
+		{
+			name: "unknown and invalid",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerPrefix + "acl-stauts": "on",
+				ServiceAnnotationLoadBalancerAclType:               "gray",
+			},
+			unknown: []string{
+				fmt.Sprintf("%sacl-stauts (did you mean %s?)", ServiceAnnotationLoadBalancerPrefix, ServiceAnnotationLoadBalancerAclStatus),
+			},
+			invalid: []string{
+				fmt.Sprintf("%s=\"gray\" (expected one of white, black)", ServiceAnnotationLoadBalancerAclType),
+			},
+		},
+	}
+	for _, c := range cases {
+		problems := checkAnnotations(testService(c.annotations, overridePorts()...))
+		if strings.Join(problems.unknown, "\n") != strings.Join(c.unknown, "\n") {
+			t.Errorf("%s: expect unknown %v, got %v", c.name, c.unknown, problems.unknown)
+		}
+		if strings.Join(problems.invalid, "\n") != strings.Join(c.invalid, "\n") {
+			t.Errorf("%s: expect invalid %v, got %v", c.name, c.invalid, problems.invalid)
+		}
+	}
+}
+
+func TestAnnotationRegistry(t *testing.T) {
+	for key := range annotationRegistry {
+		if normalizedAnnotation(key) != key {
+			t.Errorf("expect %s registered by its normalized key %s", key, normalizedAnnotation(key))
+		}
+		if suggestion := suggestAnnotation(key); suggestion != key {
+			t.Errorf("expect %s suggested for itself, got %s", key, suggestion)
+		}
+	}
+}
+
+func TestValidateAnnotations(t *testing.T) {
+	ensure := func(annotations map[string]string) (string, error) {
+		prid := nodeid(string(REGION), INSTANCEID)
+		svc := namedService("team-foo-api")
+		for k, v := range annotations {
+			svc.Annotations[k] = v
+		}
+		var events []string
+		f := NewDefaultFrameWork(nil)
+		f.WithService(svc).WithNodes([]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		})
+		var err error
+		f.RunCustomized(t, "Validate annotations",
+			func(f *FrameWork) error {
+				faults := f.SLBFaults()
+				recorder := record.NewFakeRecorder(10)
+				ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+				_, err = f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+				for len(recorder.Events) > 0 {
+					events = append(events, <-recorder.Events)
+				}
+				if err != nil && len(faults.Calls("CreateLoadBalancer")) != 0 {
+					return fmt.Errorf("expect no loadbalancer created for the invalid annotations")
+				}
+				return nil
+			},
+		)
+		return strings.Join(events, "\n"), err
+	}
+
+	// an unknown key warns but the sync goes on
+	events, err := ensure(map[string]string{ServiceAnnotationLoadBalancerPrefix + "sepc": "slb.s1.small"})
+	if err != nil {
+		t.Fatalf("expect the sync go on with an unknown annotation, got %s", err.Error())
+	}
+	if !strings.Contains(events, utils.InvalidAnnotation) || !strings.Contains(events, ServiceAnnotationLoadBalancerSpec) {
+		t.Fatalf("expect an InvalidAnnotation event suggesting %s, got %s", ServiceAnnotationLoadBalancerSpec, events)
+	}
+
+	// an invalid value blocks the sync as a validation error
+	events, err = ensure(map[string]string{
+		ServiceAnnotationLoadBalancerPrefix + "sepc": "slb.s1.small",
+		ServiceAnnotationLoadBalancerAddressType:     "public",
+	})
+	if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+		t.Fatalf("expect the sync failed of an InvalidAnnotation error, got %v", err)
+	}
+	if strings.Count(events, utils.InvalidAnnotation) != 1 {
+		t.Fatalf("expect a single InvalidAnnotation event, got %s", events)
+	}
+	if !strings.Contains(events, "sepc") || !strings.Contains(events, "public") {
+		t.Fatalf("expect the event list the unknown key and the invalid value, got %s", events)
+	}
+}
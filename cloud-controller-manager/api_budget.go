@@ -0,0 +1,130 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The quota of the cloud api is per account, one service syncing over and
+// over, eg. of flapping endpoints and an annotation the api rejects, may use
+// it up and starve all the others. Each service is given a budget of cloud
+// api calls per window, a token bucket keyed by the service carried in the
+// context of its sync. A call out of the budget fails fast and aborts the
+// sync, which is requeued after the window. The deletions carry no key and
+// are never refused, nor are the Delete calls of a sync.
+
+// ServiceAPIBudgetOptions options of the budget of cloud api calls per service
+type ServiceAPIBudgetOptions struct {
+	// Calls max cloud api calls of a service per window, 0 disables the budget
+	Calls int
+	// Window the window the calls are budgeted over
+	Window time.Duration
+}
+
+// APIBudgetOptions default options of the budget, disabled
+var APIBudgetOptions = ServiceAPIBudgetOptions{Window: time.Minute}
+
+// APIBudget the budget of the services shared by the clients, set at startup
+var APIBudget = NewServiceAPIBudget(APIBudgetOptions)
+
+// ServiceAPIBudget the token buckets of the services, refilled at
+// Calls/Window up to Calls
+type ServiceAPIBudget struct {
+	options ServiceAPIBudgetOptions
+	clock   clock.Clock
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func NewServiceAPIBudget(options ServiceAPIBudgetOptions) *ServiceAPIBudget {
+	return newServiceAPIBudget(options, clock.RealClock{})
+}
+
+func newServiceAPIBudget(options ServiceAPIBudgetOptions, c clock.Clock) *ServiceAPIBudget {
+	return &ServiceAPIBudget{options: options, clock: c, buckets: make(map[string]*tokenBucket)}
+}
+
+// ValidateServiceAPIBudget check the options of the budget
+func ValidateServiceAPIBudget(options ServiceAPIBudgetOptions) error {
+	if options.Calls < 0 {
+		return fmt.Errorf("service api budget %d must not be negative", options.Calls)
+	}
+	if options.Calls > 0 && options.Window <= 0 {
+		return fmt.Errorf("service api budget window %s must be positive", options.Window)
+	}
+	return nil
+}
+
+// apiBudgetError the error of a call out of the budget of its service
+type apiBudgetError struct {
+	key    string
+	calls  int
+	window time.Duration
+	// after the delay until the next call is allowed
+	after time.Duration
+}
+
+func (e *apiBudgetError) Error() string {
+	return fmt.Sprintf("%s: service %s used up its budget of %d cloud api calls per %s, "+
+		"the next call is allowed in %s", utils.APIBudgetExceeded, e.key, e.calls, e.window, e.after.Round(time.Millisecond))
+}
+
+// Take a call of the action from the budget of the service of the ctx, error
+// if it is used up. The calls without a service and the deletions are free.
+func (b *ServiceAPIBudget) Take(ctx context.Context, action string) error {
+	if b == nil || b.options.Calls <= 0 || ctx == nil || strings.HasPrefix(action, "Delete") {
+		return nil
+	}
+	key, _ := ctx.Value(utils.ContextAPIBudget).(string)
+	if key == "" {
+		return nil
+	}
+	after, ok := b.bucket(key).take()
+	if ok {
+		return nil
+	}
+	metric.ServiceAPIBudgetExceeded.Inc()
+	return &apiBudgetError{key: key, calls: b.options.Calls, window: b.options.Window, after: after}
+}
+
+// bucket the bucket of the service, created full. The buckets refilled while
+// idle are dropped, a service deleted leaves none behind.
+func (b *ServiceAPIBudget) bucket(key string) *tokenBucket {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if bucket, ok := b.buckets[key]; ok {
+		return bucket
+	}
+	for k, bucket := range b.buckets {
+		if bucket.full() {
+			delete(b.buckets, k)
+		}
+	}
+	limit := rate.Limit(float64(b.options.Calls) / b.options.Window.Seconds())
+	bucket := newTokenBucket(limit, b.options.Calls, b.clock)
+	b.buckets[key] = bucket
+	return bucket
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// budgetContext the context of the sync of the service taking its budget
+func budgetContext(key string) context.Context {
+	return context.WithValue(context.Background(), utils.ContextAPIBudget, key)
+}
+
+func TestServiceAPIBudget(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	budget := newServiceAPIBudget(ServiceAPIBudgetOptions{Calls: 2, Window: time.Minute}, fakeClock)
+	ctx := budgetContext("default/chatty")
+
+	for i := 0; i < 2; i++ {
+		if err := budget.Take(ctx, "DescribeLoadBalancers"); err != nil {
+			t.Fatalf("expect the calls within the budget allowed, got %s", err.Error())
+		}
+	}
+	err := budget.Take(ctx, "SetLoadBalancerName")
+	if err == nil || !strings.Contains(err.Error(), utils.APIBudgetExceeded) {
+		t.Fatalf("expect the call out of the budget refused, got %v", err)
+	}
+	if e, ok := err.(*apiBudgetError); !ok || e.after != 30*time.Second {
+		t.Fatalf("expect the next call allowed once a call is refilled, got %v", err)
+	}
+	// the deletions and the calls without a service are free
+	for _, c := range []struct {
+		ctx    context.Context
+		action string
+	}{
+		{ctx, "DeleteLoadBalancer"},
+		{ctx, "DeleteLoadBalancerListener"},
+		{context.Background(), "DescribeLoadBalancers"},
+	} {
+		if err := budget.Take(c.ctx, c.action); err != nil {
+			t.Fatalf("expect %s not budgeted, got %s", c.action, err.Error())
+		}
+	}
+	// another service has a budget of its own
+	if err := budget.Take(budgetContext("default/quiet"), "DescribeLoadBalancers"); err != nil {
+		t.Fatalf("expect the budget per service, got %s", err.Error())
+	}
+
+	fakeClock.Step(30 * time.Second)
+	if err := budget.Take(ctx, "SetLoadBalancerName"); err != nil {
+		t.Fatalf("expect the budget refilled over the window, got %s", err.Error())
+	}
+	// the idle buckets refilled are dropped
+	fakeClock.Step(time.Minute)
+	if err := budget.Take(budgetContext("default/another"), "DescribeLoadBalancers"); err != nil {
+		t.Fatalf("expect the call allowed, got %s", err.Error())
+	}
+	if len(budget.buckets) != 1 {
+		t.Fatalf("expect the idle buckets dropped, got %d buckets", len(budget.buckets))
+	}
+
+	// disabled
+	disabled := newServiceAPIBudget(ServiceAPIBudgetOptions{Window: time.Minute}, fakeClock)
+	for i := 0; i < 100; i++ {
+		if err := disabled.Take(ctx, "DescribeLoadBalancers"); err != nil {
+			t.Fatalf("expect no budget, got %s", err.Error())
+		}
+	}
+}
+
+func TestServiceAPIBudgetIsolation(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	former := APIBudget
+	APIBudget = newServiceAPIBudget(ServiceAPIBudgetOptions{Calls: 60, Window: time.Minute}, fakeClock)
+	defer func() { APIBudget = former }()
+
+	f := NewDefaultFrameWork(nil)
+	chatty := f.SVC
+	quiet := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "quiet-service",
+			Namespace: "default",
+			UID:       types.UID("UID-quiet-service"),
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{Port: 80, TargetPort: intstr.FromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080},
+			},
+			Type:            v1.ServiceTypeLoadBalancer,
+			SessionAffinity: v1.ServiceAffinityNone,
+		},
+	}
+	ensure := func(f *FrameWork) error {
+		ctx := budgetContext(fmt.Sprintf("%s/%s", f.SVC.Namespace, f.SVC.Name))
+		_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+		return err
+	}
+
+	f.RunCustomized(t, "Chatty service uses up its budget",
+		func(f *FrameWork) error {
+			for i := 0; i < 100; i++ {
+				err := ensure(f)
+				if err == nil {
+					continue
+				}
+				if i == 0 || !strings.Contains(err.Error(), utils.APIBudgetExceeded) {
+					return fmt.Errorf("expect the sync %d aborted by the budget, got %s", i, err.Error())
+				}
+				return nil
+			}
+			return fmt.Errorf("expect the chatty service out of its budget")
+		},
+	)
+
+	f.SVC = quiet
+	f.RunCustomized(t, "Quiet service unaffected",
+		func(f *FrameWork) error {
+			if err := ensure(f); err != nil {
+				return fmt.Errorf("expect the quiet service synced, got %s", err.Error())
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("expect the loadbalancer of the quiet service: %v", err)
+			}
+			return nil
+		},
+	)
+
+	f.SVC = chatty
+	f.RunCustomized(t, "Chatty service synced once refilled",
+		func(f *FrameWork) error {
+			if err := ensure(f); err == nil || !strings.Contains(err.Error(), utils.APIBudgetExceeded) {
+				return fmt.Errorf("expect the chatty service still out of its budget, got %v", err)
+			}
+			if err := APIBudget.Take(budgetContext("default/my-service"), "DeleteLoadBalancer"); err != nil {
+				return fmt.Errorf("expect the deletion not budgeted, got %s", err.Error())
+			}
+			fakeClock.Step(time.Minute)
+			return ensure(f)
+		},
+	)
+}
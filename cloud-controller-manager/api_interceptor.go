@@ -0,0 +1,207 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"io/ioutil"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"k8s.io/klog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The cloud api calls are intercepted at two layers. The http transport of
+// the sdk clients logs each request with its RequestId and error code at
+// API_LOG_VERBOSITY, the credentials and the certificates redacted. The client
+// wrappers observe the latency and the error code of each operation of their
+// product, and turn the sdk errors into APIError whose message carries the
+// RequestId up to the events.
+
+// API_LOG_VERBOSITY verbosity of the logs of the cloud api calls, set by --cloud-api-log-verbosity
+var API_LOG_VERBOSITY klog.Level = 4
+
+// REDACTED the value of the sensitive parameters logged
+const REDACTED = "******"
+
+// SENSITIVE_PARAMS the parameters redacted by a case insensitive match
+var SENSITIVE_PARAMS = []string{
+	"accesskey", "signature", "securitytoken", "secret", "password",
+	"privatekey", "servercertificate", "cacertificate",
+}
+
+// API_CALL_OK the code of the operations succeeded
+const API_CALL_OK = "OK"
+
+// API_CALL_CLIENT_ERROR the code of the operations failed before a response
+const API_CALL_CLIENT_ERROR = "ClientError"
+
+// APIError a failed cloud api call
+type APIError struct {
+	Action     string
+	RequestId  string
+	Code       string
+	StatusCode int
+	Message    string
+}
+
+// Error the RequestId goes after the Message, which is kept by the events
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Aliyun API Error: %s Status Code: %d Code: %s Message: %s RequestId: %s",
+		e.Action, e.StatusCode, e.Code, e.Message, e.RequestId)
+}
+
+// ErrorCode the code of the api error, parsed by utils.ParseCloudError
+func (e *APIError) ErrorCode() string { return e.Code }
+
+// ErrorRequestId the RequestId of the api error
+func (e *APIError) ErrorRequestId() string { return e.RequestId }
+
+// ErrorMessage the message of the api error
+func (e *APIError) ErrorMessage() string { return e.Message }
+
+var _ utils.CloudAPIError = &APIError{}
+
+// apiErrorCode the code of the failed api call, empty if err is not an api error
+func apiErrorCode(err error) string {
+	switch e := err.(type) {
+	case *APIError:
+		return e.Code
+	case *common.Error:
+		return e.Code
+	}
+	return ""
+}
+
+// apiErrorRequestId the RequestId of the failed api call, empty if err is not
+// an api error
+func apiErrorRequestId(err error) string {
+	switch e := err.(type) {
+	case *APIError:
+		return e.RequestId
+	case *common.Error:
+		return e.RequestId
+	}
+	return ""
+}
+
+// interceptAPI observe the latency and the error of the operation of the
+// product and wrap the sdk error, deferred by the client wrappers with the
+// start time and their error.
+func interceptAPI(product, action string, start time.Time, err *error) {
+	metric.CloudAPIRequestDuration.WithLabelValues(product, action).Observe(time.Since(start).Seconds())
+	if *err == nil {
+		return
+	}
+	code := API_CALL_CLIENT_ERROR
+	if e, ok := (*err).(*APIError); ok {
+		code = e.Code
+	}
+	if e, ok := (*err).(*common.Error); ok {
+		code = e.Code
+		if code == "" {
+			// a gateway error without the body of the api
+			code = fmt.Sprintf("HTTP%d", e.StatusCode)
+		}
+		*err = &APIError{
+			Action:     action,
+			RequestId:  e.RequestId,
+			Code:       e.Code,
+			StatusCode: e.StatusCode,
+			Message:    e.Message,
+		}
+	}
+	metric.CloudAPIRequestErrors.WithLabelValues(product, action, code).Inc()
+}
+
+// apiTransport log the cloud api requests
+type apiTransport struct {
+	base http.RoundTripper
+	logf func(format string, args ...interface{})
+}
+
+func newAPITransport() http.RoundTripper {
+	return &apiTransport{base: http.DefaultTransport, logf: klog.Infof}
+}
+
+func (t *apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !klog.V(API_LOG_VERBOSITY) {
+		return t.base.RoundTrip(req)
+	}
+	start := time.Now()
+	query := req.URL.Query()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.logf("alicloud: api %s failed, took %s: %s, params %s",
+			query.Get("Action"), time.Since(start), err.Error(), redactParams(query))
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	result := struct {
+		RequestId string
+		Code      string
+	}{}
+	// the response of an api not in json has no RequestId
+	_ = json.Unmarshal(body, &result)
+	if result.Code == "" {
+		result.Code = API_CALL_OK
+	}
+	t.logf("alicloud: api %s, status %d, code %s, RequestId %s, took %s, params %s",
+		query.Get("Action"), resp.StatusCode, result.Code, result.RequestId,
+		time.Since(start), redactParams(query))
+	return resp, nil
+}
+
+// redactParams the parameters of the request with the sensitive ones redacted
+func redactParams(query url.Values) string {
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, key := range keys {
+		value := strings.Join(query[key], ",")
+		if isSensitiveParam(key) {
+			value = REDACTED
+		}
+		params = append(params, fmt.Sprintf("%s=%s", key, value))
+	}
+	return strings.Join(params, "&")
+}
+
+func isSensitiveParam(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range SENSITIVE_PARAMS {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
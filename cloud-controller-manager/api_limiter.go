@@ -0,0 +1,183 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The cloud api calls of the client wrappers are paced by token buckets
+// shared by all the clients, the bursts of the syncs are smoothed before the
+// cloud throttles them. The describe calls take their own bucket if one is
+// configured, a burst of modifications does not starve the describes. A call
+// waiting for a token returns once its sync is cancelled.
+
+// CloudAPILimiterOptions options of the limiter of the cloud api calls
+type CloudAPILimiterOptions struct {
+	// QPS max cloud api calls per second, 0 disables the limiter
+	QPS float32
+	// Burst max cloud api calls in a burst
+	Burst int
+	// ReadQPS max describe calls per second, the describe calls share the
+	// bucket of the modifications if 0
+	ReadQPS float32
+	// ReadBurst max describe calls in a burst
+	ReadBurst int
+}
+
+// APILimiterOptions default options of the limiter of the cloud api calls
+var APILimiterOptions = CloudAPILimiterOptions{Burst: 10, ReadBurst: 10}
+
+// APILimiter the limiter shared by the clients, set at startup
+var APILimiter = NewCloudAPILimiter(APILimiterOptions)
+
+const (
+	API_CALL_READ  = "read"
+	API_CALL_WRITE = "write"
+)
+
+// READ_API_PREFIXES the cloud api calls without modification
+var READ_API_PREFIXES = []string{"Describe", "List", "Get", "Check", "WaitFor"}
+
+// CloudAPILimiter the token buckets of the cloud api calls, nil buckets are
+// unlimited.
+type CloudAPILimiter struct {
+	read  *tokenBucket
+	write *tokenBucket
+}
+
+func NewCloudAPILimiter(options CloudAPILimiterOptions) *CloudAPILimiter {
+	return newCloudAPILimiter(options, clock.RealClock{})
+}
+
+func newCloudAPILimiter(options CloudAPILimiterOptions, c clock.Clock) *CloudAPILimiter {
+	limiter := &CloudAPILimiter{}
+	if options.QPS > 0 {
+		limiter.write = newTokenBucket(rate.Limit(options.QPS), options.Burst, c)
+		limiter.read = limiter.write
+	}
+	if options.ReadQPS > 0 {
+		limiter.read = newTokenBucket(rate.Limit(options.ReadQPS), options.ReadBurst, c)
+	}
+	return limiter
+}
+
+// apiCallKind whether the action is a read or a write
+func apiCallKind(action string) string {
+	for _, prefix := range READ_API_PREFIXES {
+		if strings.HasPrefix(action, prefix) {
+			return API_CALL_READ
+		}
+	}
+	return API_CALL_WRITE
+}
+
+// Wait block until the action is allowed or the ctx is done
+func (l *CloudAPILimiter) Wait(ctx context.Context, action string) error {
+	kind := apiCallKind(action)
+	bucket := l.write
+	if kind == API_CALL_READ {
+		bucket = l.read
+	}
+	if bucket == nil {
+		return nil
+	}
+	start := time.Now()
+	err := bucket.wait(ctx)
+	metric.CloudAPILimiterWait.WithLabelValues(kind).Observe(metric.MsSince(start))
+	return err
+}
+
+// tokenBucket a token bucket refilled at limit up to burst tokens, the
+// rate.Limiter paced by the clock
+type tokenBucket struct {
+	limiter *rate.Limiter
+	clock   clock.Clock
+	lock    sync.Mutex
+	// refilled when the bucket is refilled up to the burst at the latest
+	refilled time.Time
+}
+
+func newTokenBucket(limit rate.Limit, burst int, c clock.Clock) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		limiter:  rate.NewLimiter(limit, burst),
+		clock:    c,
+		refilled: c.Now(),
+	}
+}
+
+// taken record a token taken at, the bucket is refilled a burst later
+func (b *tokenBucket) taken(at time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	refill := float64(b.limiter.Burst()) / float64(b.limiter.Limit())
+	if refilled := at.Add(time.Duration(refill * float64(time.Second))); refilled.After(b.refilled) {
+		b.refilled = refilled
+	}
+}
+
+// take a token if one is available without waiting, otherwise return the
+// delay until one is
+func (b *tokenBucket) take() (time.Duration, bool) {
+	now := b.clock.Now()
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay, false
+	}
+	b.taken(now)
+	return 0, true
+}
+
+// full whether the bucket is refilled up to the burst
+func (b *tokenBucket) full() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return !b.clock.Now().Before(b.refilled)
+}
+
+// wait for a token, the token reserved is given back if the ctx is done first
+func (b *tokenBucket) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := b.clock.Now()
+	r := b.limiter.ReserveN(now, 1)
+	delay := r.DelayFrom(now)
+	b.taken(now.Add(delay))
+	if delay == 0 {
+		return nil
+	}
+	timer := b.clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		r.CancelAt(b.clock.Now())
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
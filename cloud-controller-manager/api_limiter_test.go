@@ -0,0 +1,140 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"testing"
+	"time"
+)
+
+// waitAsync call the limiter in background, the result is sent once it returns
+func waitAsync(ctx context.Context, limiter *CloudAPILimiter, action string) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- limiter.Wait(ctx, action) }()
+	return done
+}
+
+// expectBlocked wait until the call blocks on the timer of the fake clock
+func expectBlocked(t *testing.T, fakeClock *clock.FakeClock, done <-chan error) {
+	err := wait.PollImmediate(time.Millisecond, time.Second, func() (bool, error) {
+		return fakeClock.HasWaiters(), nil
+	})
+	if err != nil {
+		t.Fatalf("expect the call waiting for a token")
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("expect the call blocked, got %v", err)
+	default:
+	}
+}
+
+func expectReturned(t *testing.T, done <-chan error, expect error) {
+	select {
+	case err := <-done:
+		if err != expect {
+			t.Fatalf("expect %v, got %v", expect, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expect the call returned")
+	}
+}
+
+func TestAPILimiterPacing(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	limiter := newCloudAPILimiter(CloudAPILimiterOptions{QPS: 2, Burst: 2}, fakeClock)
+	ctx := context.Background()
+
+	// the burst passes through
+	for i := 0; i < 2; i++ {
+		expectReturned(t, waitAsync(ctx, limiter, "CreateLoadBalancer"), nil)
+	}
+	// the next call waits 1/qps for the refill
+	done := waitAsync(ctx, limiter, "DescribeLoadBalancers")
+	expectBlocked(t, fakeClock, done)
+	fakeClock.Step(250 * time.Millisecond)
+	expectBlocked(t, fakeClock, done)
+	fakeClock.Step(250 * time.Millisecond)
+	expectReturned(t, done, nil)
+
+	// the bucket is refilled up to the burst while idle
+	fakeClock.Step(time.Minute)
+	for i := 0; i < 2; i++ {
+		expectReturned(t, waitAsync(ctx, limiter, "AddTags"), nil)
+	}
+	done = waitAsync(ctx, limiter, "AddTags")
+	expectBlocked(t, fakeClock, done)
+	fakeClock.Step(500 * time.Millisecond)
+	expectReturned(t, done, nil)
+}
+
+func TestAPILimiterReadBucket(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	limiter := newCloudAPILimiter(
+		CloudAPILimiterOptions{QPS: 1, Burst: 1, ReadQPS: 10, ReadBurst: 2}, fakeClock)
+	ctx := context.Background()
+
+	expectReturned(t, waitAsync(ctx, limiter, "DeleteLoadBalancer"), nil)
+	write := waitAsync(ctx, limiter, "SetLoadBalancerName")
+	expectBlocked(t, fakeClock, write)
+	// the describes are not starved by the modifications
+	for _, action := range []string{"DescribeLoadBalancers", "ListListeners"} {
+		expectReturned(t, waitAsync(ctx, limiter, action), nil)
+	}
+	fakeClock.Step(time.Second)
+	expectReturned(t, write, nil)
+}
+
+func TestAPILimiterCancel(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	limiter := newCloudAPILimiter(CloudAPILimiterOptions{QPS: 1, Burst: 1}, fakeClock)
+
+	expectReturned(t, waitAsync(context.Background(), limiter, "AddTags"), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := waitAsync(ctx, limiter, "AddTags")
+	expectBlocked(t, fakeClock, done)
+	// the cancelled sync returns without the token
+	cancel()
+	expectReturned(t, done, context.Canceled)
+	expectReturned(t, waitAsync(ctx, limiter, "AddTags"), context.Canceled)
+
+	fakeClock.Step(time.Second)
+	expectReturned(t, waitAsync(context.Background(), limiter, "AddTags"), nil)
+}
+
+func TestAPILimiterDisabled(t *testing.T) {
+	limiter := newCloudAPILimiter(CloudAPILimiterOptions{Burst: 1}, clock.NewFakeClock(time.Now()))
+	for i := 0; i < 100; i++ {
+		if err := limiter.Wait(context.Background(), "CreateLoadBalancerTCPListener"); err != nil {
+			t.Fatalf("expect no limit, got %s", err.Error())
+		}
+	}
+	for action, kind := range map[string]string{
+		"DescribeLoadBalancers":            API_CALL_READ,
+		"ListServerGroups":                 API_CALL_READ,
+		"WaitForAllRouteEntriesAvailable":  API_CALL_READ,
+		"CreateRouteEntry":                 API_CALL_WRITE,
+		"RemoveVServerGroupBackendServers": API_CALL_WRITE,
+	} {
+		if apiCallKind(action) != kind {
+			t.Errorf("expect %s a %s call", action, kind)
+		}
+	}
+}
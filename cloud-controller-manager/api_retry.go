@@ -0,0 +1,147 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"github.com/denverdino/aliyungo/common"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"time"
+)
+
+// The throttled and the transient cloud api calls are retried by the client
+// wrappers a few times with a jittered exponential delay, each attempt paced
+// by the limiter. The error surfaces once the attempts are exhausted or the
+// ctx deadline comes before the next attempt, the syncs failed are requeued
+// with backoff by the controllers as before. A mutating call accepted by the
+// api before a server error or a network failure may be applied twice once
+// replayed, eg. the backends added or a listener stopped, so only the reads
+// and the creations carrying a ClientToken are retried on them, see
+// retryCreationAPI. A throttled call, or one conflicting with another
+// operation in progress on the same loadbalancer, eg. by the listeners of a
+// service applied in parallel, is rejected before it is executed and is
+// always retried.
+
+// API_RETRY_ATTEMPTS max attempts of a cloud api call
+var API_RETRY_ATTEMPTS = 3
+
+// API_RETRY_DELAY delay before the second attempt, doubled by each attempt
+var API_RETRY_DELAY = 500 * time.Millisecond
+
+// API_RETRY_JITTER max factor of the delay added at random
+const API_RETRY_JITTER = 0.5
+
+// REJECTED_API_CODES the error codes of the calls rejected before they are
+// executed, throttled or conflicting with another operation in progress
+var REJECTED_API_CODES = map[string]bool{
+	"Throttling":                true,
+	"Throttling.User":           true,
+	"Throttling.Api":            true,
+	"Operation.Conflict":        true,
+	"ServiceIsConfiguring":      true,
+	"BackendServer.configuring": true,
+	"VServerGroupProcessing":    true,
+}
+
+// RETRYABLE_API_CODES the error codes of the transient calls, retried along
+// with the rejected ones for the reads and the calls with a ClientToken
+var RETRYABLE_API_CODES = map[string]bool{
+	"ServiceUnavailable":    true,
+	"InternalError":         true,
+	"SystemBusy":            true,
+	"UnknownError":          true,
+	"AliyunGoClientFailure": true,
+}
+
+// isRetryableAPIError whether the call is rejected, or failed by a server
+// error or the network
+func isRetryableAPIError(err error) bool {
+	code := apiErrorCode(err)
+	if REJECTED_API_CODES[code] || RETRYABLE_API_CODES[code] {
+		return true
+	}
+	// a gateway error without the body of the api
+	e, ok := err.(*common.Error)
+	return ok && code == "" && e.StatusCode >= 500
+}
+
+// isRejectedAPIError whether the call is rejected before it is executed
+func isRejectedAPIError(err error) bool {
+	return REJECTED_API_CODES[apiErrorCode(err)]
+}
+
+// retryAPI call the action with the credential until it succeeds, fails by a
+// non-retryable error or the attempts are exhausted. A write is retried only
+// while it is rejected, as it may have been applied before a server error or
+// the network failed. The calls fail fast while the circuit of the credential
+// is open or the budget of the service is used up.
+func retryAPI(ctx context.Context, credential, action string, call func() error) error {
+	retryable := isRetryableAPIError
+	if apiCallKind(action) == API_CALL_WRITE {
+		retryable = isRejectedAPIError
+	}
+	return retry(ctx, credential, action, retryable, call)
+}
+
+// retryCreationAPI retryAPI of a creation, which is retried on the server
+// errors and the network only if it carries the ClientToken token, so that
+// the replay gets the resource created before instead of another one.
+func retryCreationAPI(ctx context.Context, credential, action, token string, call func() error) error {
+	retryable := isRetryableAPIError
+	if token == "" {
+		retryable = isRejectedAPIError
+	}
+	return retry(ctx, credential, action, retryable, call)
+}
+
+func retry(ctx context.Context, credential, action string, retryable func(err error) bool, call func() error) error {
+	breaker := circuitBreakerOf(credential)
+	delay := API_RETRY_DELAY
+	for attempt := 1; ; attempt++ {
+		if err := APIBudget.Take(ctx, action); err != nil {
+			return err
+		}
+		if err := APILimiter.Wait(ctx, action); err != nil {
+			return err
+		}
+		if err := breaker.allow(action); err != nil {
+			return err
+		}
+		err := call()
+		breaker.record(err)
+		if err == nil || attempt >= API_RETRY_ATTEMPTS || !retryable(err) {
+			return err
+		}
+		backoff := wait.Jitter(delay, API_RETRY_JITTER)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return err
+		}
+		// logged with the service synced by the logger of the context
+		utils.FromContext(ctx).V(API_LOG_VERBOSITY).Info("Retry cloud api",
+			utils.LogKeyOperation, action, utils.LogKeyRequestID, apiErrorRequestId(err),
+			"code", apiErrorCode(err), "attempt", attempt, "after", backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
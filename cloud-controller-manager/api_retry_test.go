@@ -0,0 +1,240 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// throttled the error of a throttled call
+func throttled() error {
+	return &common.Error{
+		ErrorResponse: common.ErrorResponse{RequestId: "req-1", Code: "Throttling.User", Message: "Request was denied due to user flow control."},
+		StatusCode:    400,
+	}
+}
+
+func TestIsRetryableAPIError(t *testing.T) {
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{err: throttled(), retryable: true},
+		{err: &APIError{Code: "ServiceUnavailable", StatusCode: 503}, retryable: true},
+		{err: common.GetClientErrorFromString("dial tcp: i/o timeout"), retryable: true},
+		{err: &common.Error{StatusCode: 502}, retryable: true},
+		// another operation in progress on the loadbalancer
+		{err: &common.Error{ErrorResponse: common.ErrorResponse{Code: "Operation.Conflict"}, StatusCode: 400}, retryable: true},
+		{err: &common.Error{ErrorResponse: common.ErrorResponse{Code: "ServiceIsConfiguring"}, StatusCode: 400}, retryable: true},
+		{err: &common.Error{ErrorResponse: common.ErrorResponse{Code: "Forbidden.RAM"}, StatusCode: 403}},
+		{err: &common.Error{ErrorResponse: common.ErrorResponse{Code: "InvalidLoadBalancerId.NotFound"}, StatusCode: 404}},
+		// classified by the code, never by the message
+		{err: fmt.Errorf("ensure loadbalancer: Throttling.User")},
+	}
+	for _, c := range cases {
+		if retryable := isRetryableAPIError(c.err); retryable != c.retryable {
+			t.Errorf("%v: expect retryable %t, got %t", c.err, c.retryable, retryable)
+		}
+	}
+}
+
+func TestRetryAPI(t *testing.T) {
+	delay := API_RETRY_DELAY
+	defer func() { API_RETRY_DELAY = delay }()
+	API_RETRY_DELAY = time.Millisecond
+
+	// call fail the first failures attempts with err
+	call := func(failures int, err error, attempts *int) func() error {
+		return func() error {
+			*attempts++
+			if *attempts <= failures {
+				return err
+			}
+			return nil
+		}
+	}
+	forbidden := &common.Error{ErrorResponse: common.ErrorResponse{Code: "Forbidden.RAM"}, StatusCode: 403}
+	cases := []struct {
+		desc     string
+		failures int
+		err      error
+		attempts int
+		failed   bool
+	}{
+		{desc: "throttled twice", failures: 2, err: throttled(), attempts: 3},
+		{desc: "network failure", failures: 1, err: common.GetClientErrorFromString("connection reset by peer"), attempts: 2},
+		{desc: "attempts exhausted", failures: 5, err: throttled(), attempts: API_RETRY_ATTEMPTS, failed: true},
+		{desc: "non-retryable error", failures: 5, err: forbidden, attempts: 1, failed: true},
+	}
+	for _, c := range cases {
+		attempts := 0
+		err := retryAPI(context.Background(), "key-id", "DescribeLoadBalancers", call(c.failures, c.err, &attempts))
+		if attempts != c.attempts {
+			t.Errorf("%s: expect %d attempts, got %d", c.desc, c.attempts, attempts)
+		}
+		if c.failed != (err != nil) || (err != nil && err != c.err) {
+			t.Errorf("%s: expect the error of the last attempt %t, got %v", c.desc, c.failed, err)
+		}
+	}
+
+	// a write may have been applied before a server error or the network failed
+	writes := []struct {
+		action   string
+		err      error
+		attempts int
+	}{
+		{action: "AddBackendServers", err: &APIError{Code: "InternalError", StatusCode: 500}, attempts: 1},
+		{action: "RemoveBackendServers", err: &common.Error{StatusCode: 502}, attempts: 1},
+		{action: "StopLoadBalancerListener", err: TimeoutFault(), attempts: 1},
+		{action: "StartLoadBalancerListener", err: throttled(), attempts: 2},
+		{action: "StartLoadBalancerListener", err: &APIError{Code: "Operation.Conflict", StatusCode: 400}, attempts: 2},
+		{action: "DescribeLoadBalancerAttribute", err: TimeoutFault(), attempts: 2},
+	}
+	for _, c := range writes {
+		attempts := 0
+		retryAPI(context.Background(), "key-id", c.action, call(1, c.err, &attempts))
+		if attempts != c.attempts {
+			t.Errorf("%s failed by %s: expect %d attempts, got %d", c.action, apiErrorCode(c.err), c.attempts, attempts)
+		}
+	}
+
+	// the next attempt would come after the deadline
+	API_RETRY_DELAY = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	attempts := 0
+	start := time.Now()
+	err := retryAPI(ctx, "key-id", "DescribeLoadBalancers", call(5, throttled(), &attempts))
+	if attempts != 1 || apiErrorCode(err) != "Throttling.User" || time.Since(start) > API_RETRY_DELAY/2 {
+		t.Fatalf("expect the throttling error returned before the deadline, got %d attempts, %v", attempts, err)
+	}
+}
+
+func TestRetryCreationAPI(t *testing.T) {
+	delay := API_RETRY_DELAY
+	defer func() { API_RETRY_DELAY = delay }()
+	API_RETRY_DELAY = time.Millisecond
+
+	unavailable := &APIError{Code: "ServiceUnavailable", StatusCode: 503}
+	network := common.GetClientErrorFromString("connection reset by peer")
+	cases := []struct {
+		desc     string
+		token    string
+		err      error
+		attempts int
+	}{
+		{desc: "throttled without token", err: throttled(), attempts: 2},
+		{desc: "conflict without token", err: &APIError{Code: "Operation.Conflict", StatusCode: 400}, attempts: 2},
+		{desc: "server error without token", err: unavailable, attempts: 1},
+		{desc: "network failure without token", err: network, attempts: 1},
+		{desc: "server error with token", token: "token-1", err: unavailable, attempts: 2},
+		{desc: "network failure with token", token: "token-1", err: network, attempts: 2},
+	}
+	for _, c := range cases {
+		attempts := 0
+		retryCreationAPI(context.Background(), "key-id", "CreateLoadBalancer", c.token, func() error {
+			attempts++
+			if attempts == 1 {
+				return c.err
+			}
+			return nil
+		})
+		if attempts != c.attempts {
+			t.Errorf("%s: expect %d attempts, got %d", c.desc, c.attempts, attempts)
+		}
+	}
+}
+
+func TestThrottledReconcile(t *testing.T) {
+	delay := API_RETRY_DELAY
+	defer func() { API_RETRY_DELAY = delay }()
+	API_RETRY_DELAY = time.Millisecond
+
+	// the slb api throttles the first two DescribeLoadBalancers
+	var describes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.URL.Query().Get("Action")
+		w.Header().Set("Content-Type", "application/json")
+		if action == "DescribeLoadBalancers" && atomic.AddInt32(&describes, 1) <= 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"RequestId":"req-%s","Code":"Throttling.User","Message":"Request was denied due to user flow control."}`, action)
+			return
+		}
+		fmt.Fprintf(w, `{"RequestId":"req-%s","LoadBalancers":{"LoadBalancer":[]}}`, action)
+	}))
+	defer server.Close()
+	client := NewContextedClientSLB("key-id", "key-secret", string(REGION))
+	client.slb.WithEndpoint(server.URL)
+
+	f := NewDefaultFrameWork(nil)
+	f.Cloud.climgr.loadbalancer.c = client
+	f.RunCustomized(t, "Throttled calls are retried within the sync",
+		func(f *FrameWork) error {
+			if err := f.CloudImpl().EnsureLoadBalancerDeleted(context.Background(), CLUSTER_ID, f.SVC); err != nil {
+				return fmt.Errorf("expect the sync completed, got %s", err.Error())
+			}
+			// two throttled, then one of each lookup of the loadbalancer, by
+			// the name tag, the uid tag and the name
+			if n := atomic.LoadInt32(&describes); n != 5 {
+				return fmt.Errorf("expect 5 calls of DescribeLoadBalancers, got %d", n)
+			}
+			return nil
+		},
+	)
+}
+
+func TestRetryLogKeys(t *testing.T) {
+	delay := API_RETRY_DELAY
+	defer func() { API_RETRY_DELAY = delay }()
+	API_RETRY_DELAY = time.Millisecond
+	captured, restore := captureLogs()
+	defer restore()
+
+	server := fakeSLBServer(map[string]string{"DescribeLoadBalancers": "Throttling.User"})
+	defer server.Close()
+	var logs []string
+	client := newInterceptedSLB(server, &logs)
+
+	// the client logs the service of the context without it passed in
+	ctx := utils.WithLogger(context.Background(), utils.NewLogger(utils.LogKeyService, "default/nginx"))
+	if _, err := client.DescribeLoadBalancers(ctx, &slb.DescribeLoadBalancersArgs{RegionId: REGION}); err == nil {
+		t.Fatalf("expect the throttled call failed")
+	}
+	retry, ok := findLog(captured(), "Retry cloud api")
+	if !ok {
+		t.Fatalf("expect the retry logged, got %v", captured())
+	}
+	expect := map[string]interface{}{
+		utils.LogKeyService:   "default/nginx",
+		utils.LogKeyOperation: "DescribeLoadBalancers",
+		utils.LogKeyRequestID: "req-DescribeLoadBalancers",
+	}
+	for key, value := range expect {
+		if v, _ := retry.Value(key); v != value {
+			t.Errorf("expect key %s of %v, got %s", key, value, retry.String())
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"github.com/denverdino/aliyungo/sts"
+	"k8s.io/klog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The loadbalancers and the vpc may be owned by another account than the one
+// running the cluster. The clients of the selected products call with the
+// credentials of a role assumed in the target account, the others with the
+// credentials of the local account. The role is assumed with the local
+// credentials and renewed before it expires.
+
+const (
+	ENV_ASSUME_ROLE_ARN          = "ALIBABA_CLOUD_ASSUME_ROLE_ARN"
+	ENV_ASSUME_ROLE_SESSION_NAME = "ALIBABA_CLOUD_ASSUME_ROLE_SESSION_NAME"
+	ENV_ASSUME_ROLE_CLIENTS      = "ALIBABA_CLOUD_ASSUME_ROLE_CLIENTS"
+)
+
+// the clients which can call with the assumed role
+const (
+	CLIENT_SLB  = "slb"
+	CLIENT_NLB  = "nlb"
+	CLIENT_VPC  = "vpc"
+	CLIENT_ECS  = "ecs"
+	CLIENT_PVTZ = "pvtz"
+)
+
+// DEFAULT_ASSUME_ROLE_CLIENTS the loadbalancers and the vpc are in the target
+// account, the instances stay in the local account.
+var DEFAULT_ASSUME_ROLE_CLIENTS = []string{CLIENT_SLB, CLIENT_NLB, CLIENT_VPC}
+
+// ASSUME_ROLE_DURATION lifetime of the credentials of the assumed role
+var ASSUME_ROLE_DURATION = time.Hour
+
+// AssumeRoleToken the credentials of the role assumed in the target account,
+// they are cached until they are about to expire or the local credentials
+// change.
+type AssumeRoleToken struct {
+	RoleArn     string
+	SessionName string
+	Clients     map[string]bool
+
+	// assume is replaced by tests
+	assume func(base *Token, arn, session string) (*Token, time.Time, error)
+
+	lock       sync.Mutex
+	base       *Token
+	token      *Token
+	expiration time.Time
+}
+
+// NewAssumeRoleTokenFromConfig return the role to assume configured by the
+// cloud config or env, nil if none is configured.
+func NewAssumeRoleTokenFromConfig() *AssumeRoleToken {
+	arn, session, clients := cfg.Global.AssumeRoleArn, cfg.Global.AssumeRoleSessionName, cfg.Global.AssumeRoleClients
+	if arn == "" {
+		arn = os.Getenv(ENV_ASSUME_ROLE_ARN)
+	}
+	if session == "" {
+		session = os.Getenv(ENV_ASSUME_ROLE_SESSION_NAME)
+	}
+	if clients == "" {
+		clients = os.Getenv(ENV_ASSUME_ROLE_CLIENTS)
+	}
+	if arn == "" {
+		return nil
+	}
+	return NewAssumeRoleToken(arn, session, clients)
+}
+
+// NewAssumeRoleToken clients is a comma separated list of the clients calling
+// with the assumed role, DEFAULT_ASSUME_ROLE_CLIENTS if empty.
+func NewAssumeRoleToken(arn, session, clients string) *AssumeRoleToken {
+	if session == "" {
+		session = "cloud-controller-manager"
+	}
+	names := DEFAULT_ASSUME_ROLE_CLIENTS
+	if strings.TrimSpace(clients) != "" {
+		names = strings.Split(clients, ",")
+	}
+	token := &AssumeRoleToken{
+		RoleArn:     arn,
+		SessionName: session,
+		Clients:     map[string]bool{},
+		assume:      assumeRoleBySTS,
+	}
+	for _, name := range names {
+		token.Clients[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return token
+}
+
+// Assumes whether the client calls with the assumed role
+func (f *AssumeRoleToken) Assumes(client string) bool { return f.Clients[client] }
+
+// Assume return the credentials of the role assumed with base
+func (f *AssumeRoleToken) Assume(base *Token) (*Token, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.token != nil && f.base != nil && *f.base == *base &&
+		time.Until(f.expiration) > RRSA_REFRESH_MARGIN {
+		return f.token, nil
+	}
+	token, expiration, err := f.assume(base, f.RoleArn, f.SessionName)
+	if err != nil {
+		return nil, fmt.Errorf("assume role %s: %s", f.RoleArn, err.Error())
+	}
+	klog.V(2).Infof("alicloud: role %s assumed, expiring at %s", f.RoleArn, expiration)
+	f.base, f.token, f.expiration = base, token, expiration
+	return f.token, nil
+}
+
+// Invalidate drop the cached credentials, the role is assumed again next time
+func (f *AssumeRoleToken) Invalidate() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.token = nil
+}
+
+func assumeRoleBySTS(base *Token, arn, session string) (*Token, time.Time, error) {
+	client := sts.NewClientWithSecurityToken(base.AccessKey, base.AccessSecret, base.Token)
+	client.SetUserAgent(userAgent())
+	resp, err := client.AssumeRole(
+		sts.AssumeRoleRequest{
+			RoleArn:         arn,
+			RoleSessionName: session,
+			DurationSeconds: int(ASSUME_ROLE_DURATION.Seconds()),
+		},
+	)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	expiration, err := time.Parse(time.RFC3339, resp.Credentials.Expiration)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("parse expiration of sts credentials: %s", err.Error())
+	}
+	return &Token{
+		AccessKey:    resp.Credentials.AccessKeyId,
+		AccessSecret: resp.Credentials.AccessKeySecret,
+		Token:        resp.Credentials.SecurityToken,
+	}, expiration, nil
+}
+
+// isTokenExpiredError whether the call failed as the sts credentials expired
+// before they were refreshed.
+func isTokenExpiredError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "SecurityToken.Expired")
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"testing"
+	"time"
+)
+
+const ASSUME_ROLE_ARN = "acs:ram::9876543210:role/network"
+
+// staticToken the local credentials
+type staticToken struct{ token *Token }
+
+func (f *staticToken) NextToken() (*Token, error) { return f.token, nil }
+
+// fakeAssume assume the role with credentials expiring after the lifetime
+func fakeAssume(calls *int, lifetime time.Duration) func(base *Token, arn, session string) (*Token, time.Time, error) {
+	return func(base *Token, arn, session string) (*Token, time.Time, error) {
+		*calls++
+		return &Token{
+			AccessKey:    fmt.Sprintf("STS.%s", base.AccessKey),
+			AccessSecret: "secret",
+			Token:        fmt.Sprintf("%s-%d", session, *calls),
+		}, time.Now().Add(lifetime), nil
+	}
+}
+
+func TestAssumeRoleToken(t *testing.T) {
+	calls := 0
+	assumed := NewAssumeRoleToken(ASSUME_ROLE_ARN, "", "")
+	assumed.assume = fakeAssume(&calls, time.Hour)
+	local := &Token{AccessKey: "key", AccessSecret: "secret"}
+
+	token, err := assumed.Assume(local)
+	if err != nil || token.Token != "cloud-controller-manager-1" {
+		t.Fatalf("expect role assumed, got %+v, %v", token, err)
+	}
+	if token, err = assumed.Assume(local); err != nil || calls != 1 {
+		t.Fatalf("expect cached credentials, got %+v, %v, %d calls", token, err, calls)
+	}
+	assumed.expiration = time.Now().Add(RRSA_REFRESH_MARGIN / 2)
+	if token, err = assumed.Assume(local); err != nil || calls != 2 {
+		t.Fatalf("expect renewal before the expiration, got %+v, %v, %d calls", token, err, calls)
+	}
+	if token, err = assumed.Assume(&Token{AccessKey: "key2", AccessSecret: "secret"}); err != nil ||
+		calls != 3 || token.AccessKey != "STS.key2" {
+		t.Fatalf("expect the role assumed again with new local credentials, got %+v, %v, %d calls", token, err, calls)
+	}
+	assumed.Invalidate()
+	if _, err = assumed.Assume(&Token{AccessKey: "key2", AccessSecret: "secret"}); err != nil || calls != 4 {
+		t.Fatalf("expect the role assumed again once invalidated, got %v, %d calls", err, calls)
+	}
+}
+
+func TestAssumeRoleClients(t *testing.T) {
+	config := cfg.Global
+	defer func() { cfg.Global = config }()
+
+	cfg.Global.AssumeRoleArn = ""
+	if NewAssumeRoleTokenFromConfig() != nil {
+		t.Fatalf("expect no role assumed without configuration")
+	}
+
+	cfg.Global.AssumeRoleArn = ASSUME_ROLE_ARN
+	cases := []struct {
+		clients string
+		assumed map[string]bool
+	}{
+		{clients: "", assumed: map[string]bool{CLIENT_SLB: true, CLIENT_NLB: true, CLIENT_VPC: true}},
+		{clients: "slb, ECS", assumed: map[string]bool{CLIENT_SLB: true, CLIENT_ECS: true}},
+	}
+	for _, c := range cases {
+		cfg.Global.AssumeRoleClients = c.clients
+		calls := 0
+		mgr := &ClientMgr{assumed: NewAssumeRoleTokenFromConfig()}
+		if mgr.assumed == nil || mgr.assumed.RoleArn != ASSUME_ROLE_ARN {
+			t.Fatalf("expect role %s assumed, got %+v", ASSUME_ROLE_ARN, mgr.assumed)
+		}
+		mgr.assumed.assume = fakeAssume(&calls, time.Hour)
+		local := &Token{AccessKey: "key", AccessSecret: "secret"}
+		tokens, err := mgr.tokens(local)
+		if err != nil {
+			t.Fatalf("tokens: %s", err.Error())
+		}
+		for _, client := range []string{CLIENT_ECS, CLIENT_SLB, CLIENT_PVTZ, CLIENT_VPC, CLIENT_NLB} {
+			if assumed := tokens[client] != local; assumed != c.assumed[client] {
+				t.Errorf("clients [%s]: expect client %s calling with the assumed role %t, got %t",
+					c.clients, client, c.assumed[client], assumed)
+			}
+		}
+	}
+}
+
+func TestRefreshExpired(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-service",
+				Namespace:   "default",
+				UID:         types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	calls, refreshed := 0, 0
+	mgr := f.CloudImpl().climgr
+	mgr.token = &staticToken{token: &Token{AccessKey: "key", AccessSecret: "secret"}}
+	mgr.assumed = NewAssumeRoleToken(ASSUME_ROLE_ARN, "", "")
+	mgr.assumed.assume = fakeAssume(&calls, time.Hour)
+	mgr.settoken = func(mgr *ClientMgr, token *Token) error {
+		refreshed++
+		_, err := mgr.tokens(token)
+		return err
+	}
+	// the first call fails as the token expired
+	expired := false
+	mock := f.SLBSDK().(*mockClientSLB)
+	mock.describeLoadBalancers = func(args *slb.DescribeLoadBalancersArgs) ([]slb.LoadBalancerType, error) {
+		if !expired {
+			expired = true
+			return nil, fmt.Errorf("Aliyun API Error: RequestId: req-1 Status Code: 400 Code: InvalidSecurityToken.Expired")
+		}
+		return (&mockClientSLB{}).DescribeLoadBalancers(context.Background(), args)
+	}
+
+	f.RunCustomized(t, "Retry once with the refreshed token",
+		func(f *FrameWork) error {
+			if err := mgr.Refresh(); err != nil {
+				return err
+			}
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return fmt.Errorf("expect the sync retried with the refreshed token, got %s", err.Error())
+			}
+			if refreshed != 2 || calls != 2 {
+				return fmt.Errorf("expect the token refreshed and the role assumed again, got %d refreshes, %d assumes",
+					refreshed, calls)
+			}
+			if mgr.RefreshExpired(fmt.Errorf("Code: Throttling")) || refreshed != 2 {
+				return fmt.Errorf("expect no refresh for the other errors")
+			}
+			return nil
+		},
+	)
+}
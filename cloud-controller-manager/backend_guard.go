@@ -0,0 +1,90 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The backends of a service with a selector can be computed empty for a
+// moment, eg. all the pods are restarted at once or the informer is resyncing.
+// Emptying the vserver group would drop all the traffic, so the backends are
+// kept and the service is checked again shortly. A service being deleted, not
+// of type LoadBalancer any more, or without a selector is not guarded. The
+// guard is disabled by the empty-backends-guard annotation.
+
+// EMPTY_BACKENDS_RECHECK_INTERVAL the backends are computed again until they
+// are not empty.
+const EMPTY_BACKENDS_RECHECK_INTERVAL = 10 * time.Second
+
+// ValidateEmptyBackendsGuard the empty-backends-guard annotation must be on or off
+func ValidateEmptyBackendsGuard(service *v1.Service) error {
+	guard := serviceAnnotation(service, ServiceAnnotationLoadBalancerEmptyBackendsGuard)
+	if guard == "" || guard == "on" || guard == "off" {
+		return nil
+	}
+	return annotationError{
+		annotation: ServiceAnnotationLoadBalancerEmptyBackendsGuard,
+		token:      guard,
+		reason:     "must be on or off",
+	}
+}
+
+// isEmptyBackendsGuarded whether the backends of the service are kept when
+// none is computed.
+func isEmptyBackendsGuarded(service *v1.Service) bool {
+	if serviceAnnotation(service, ServiceAnnotationLoadBalancerEmptyBackendsGuard) == "off" {
+		return false
+	}
+	return service.DeletionTimestamp == nil &&
+		service.Spec.Type == v1.ServiceTypeLoadBalancer &&
+		len(service.Spec.Selector) > 0
+}
+
+// recordBackendUpdateSkipped warn of the vserver groups whose backends are kept
+func recordBackendUpdateSkipped(ctx context.Context, service *v1.Service, vgrps *vgroups) {
+	var skipped []string
+	for _, v := range *vgrps {
+		if v.EmptyBackendsSkipped {
+			skipped = append(skipped, v.VGroupId)
+		}
+	}
+	if len(skipped) == 0 {
+		return
+	}
+	sort.Strings(skipped)
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "no backend is computed, keep the backends of vgroups %v", skipped)
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"BackendUpdateSkipped",
+		"No backend is computed for the service, keep the backends of vserver groups %s and retry in %s. "+
+			"Set annotation %s to off to empty them",
+		strings.Join(skipped, ", "), EMPTY_BACKENDS_RECHECK_INTERVAL, ServiceAnnotationLoadBalancerEmptyBackendsGuard,
+	)
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmptyBackendsGuarded(t *testing.T) {
+	now := metav1.Now()
+	selector := map[string]string{"app": "nginx"}
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		deletion    *metav1.Time
+		svcType     v1.ServiceType
+		selector    map[string]string
+		guarded     bool
+	}{
+		{name: "default", svcType: v1.ServiceTypeLoadBalancer, selector: selector, guarded: true},
+		{
+			name:        "enabled",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerEmptyBackendsGuard: "on"},
+			svcType:     v1.ServiceTypeLoadBalancer, selector: selector, guarded: true,
+		},
+		{
+			name:        "disabled",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerEmptyBackendsGuard: "off"},
+			svcType:     v1.ServiceTypeLoadBalancer, selector: selector,
+		},
+		{name: "deleted", deletion: &now, svcType: v1.ServiceTypeLoadBalancer, selector: selector},
+		{name: "type changed", svcType: v1.ServiceTypeClusterIP, selector: selector},
+		{name: "no selector", svcType: v1.ServiceTypeLoadBalancer},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:         "default",
+				Annotations:       c.annotations,
+				DeletionTimestamp: c.deletion,
+			},
+			Spec: v1.ServiceSpec{Type: c.svcType, Selector: c.selector},
+		}
+		if guarded := isEmptyBackendsGuarded(svc); guarded != c.guarded {
+			t.Errorf("%s: expect guarded %t, got %t", c.name, c.guarded, guarded)
+		}
+	}
+
+	for _, guard := range []string{"", "on", "off"} {
+		svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{ServiceAnnotationLoadBalancerEmptyBackendsGuard: guard},
+		}}
+		if err := ValidateEmptyBackendsGuard(svc); err != nil {
+			t.Errorf("%q: unexpected error: %s", guard, err.Error())
+		}
+	}
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{ServiceAnnotationLoadBalancerEmptyBackendsGuard: "true"},
+	}}
+	if err := ValidateEmptyBackendsGuard(svc); err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+		t.Errorf("expect InvalidAnnotation error, got %v", err)
+	}
+}
+
+func TestEmptyBackendsGuard(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					utils.BACKEND_TYPE_LABEL: utils.BACKEND_TYPE_ENI,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Selector:        map[string]string{"app": "nginx"},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+	// endpoints of the ready pods by ip
+	pods := func(ips ...string) *v1.Endpoints {
+		var addrs []v1.EndpointAddress
+		for i, ip := range ips {
+			addrs = append(addrs, v1.EndpointAddress{
+				IP:        ip,
+				NodeName:  &prid,
+				TargetRef: &v1.ObjectReference{Kind: "Pod", Name: fmt.Sprintf("nginx-%d", i)},
+			})
+		}
+		return &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-service", Namespace: "default"},
+			Subsets: []v1.EndpointSubset{
+				{Addresses: addrs, Ports: []v1.EndpointPort{{Port: targetPort1.IntVal}}},
+			},
+		}
+	}
+	recorder := record.NewFakeRecorder(10)
+	// ensure the loadbalancer, returns the requeue delay
+	ensure := func(f *FrameWork) (time.Duration, error) {
+		var requeue time.Duration
+		ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+		ctx = context.WithValue(ctx, utils.ContextRequeue, func(delay time.Duration) { requeue = delay })
+		_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+		return requeue, err
+	}
+	// the backends of the single vserver group, by ip
+	expectBackends := func(f *FrameWork, ips ...string) error {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return fmt.Errorf("find loadbalancer: %v", err)
+		}
+		res, err := f.SLBSDK().DescribeVServerGroups(
+			context.Background(),
+			&slb.DescribeVServerGroupsArgs{LoadBalancerId: lb.LoadBalancerId},
+		)
+		if err != nil {
+			return err
+		}
+		if len(res.VServerGroups.VServerGroup) != 1 {
+			return fmt.Errorf("expect 1 vserver group, got %d", len(res.VServerGroups.VServerGroup))
+		}
+		att, err := f.SLBSDK().DescribeVServerGroupAttribute(
+			context.Background(),
+			&slb.DescribeVServerGroupAttributeArgs{VServerGroupId: res.VServerGroups.VServerGroup[0].VServerGroupId},
+		)
+		if err != nil {
+			return err
+		}
+		var got []string
+		for _, b := range att.BackendServers.BackendServer {
+			got = append(got, b.ServerIp)
+		}
+		sort.Strings(got)
+		sort.Strings(ips)
+		if !reflect.DeepEqual(got, ips) {
+			return fmt.Errorf("expect backends %v, got %v", ips, got)
+		}
+		return nil
+	}
+
+	f.WithEndpoints(pods(ENI_ADDR_1, ENI_ADDR_2))
+	f.RunCustomized(t, "Pods are added",
+		func(f *FrameWork) error {
+			if _, err := ensure(f); err != nil {
+				return err
+			}
+			return expectBackends(f, ENI_ADDR_1, ENI_ADDR_2)
+		},
+	)
+
+	f.WithEndpoints(pods())
+	f.RunCustomized(t, "Backends are kept when none is computed",
+		func(f *FrameWork) error {
+			requeue, err := ensure(f)
+			if err != nil {
+				return err
+			}
+			if err := expectBackends(f, ENI_ADDR_1, ENI_ADDR_2); err != nil {
+				return err
+			}
+			if requeue != EMPTY_BACKENDS_RECHECK_INTERVAL {
+				return fmt.Errorf("expect requeue after %s, got %s", EMPTY_BACKENDS_RECHECK_INTERVAL, requeue)
+			}
+			if len(recorder.Events) != 1 {
+				return fmt.Errorf("expect an event of the skipped update, got %d", len(recorder.Events))
+			}
+			if event := <-recorder.Events; !strings.Contains(event, "BackendUpdateSkipped") {
+				return fmt.Errorf("expect BackendUpdateSkipped event, got %s", event)
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerEmptyBackendsGuard] = "off"
+	f.RunCustomized(t, "Vserver group is emptied without the guard",
+		func(f *FrameWork) error {
+			requeue, err := ensure(f)
+			if err != nil {
+				return err
+			}
+			if requeue != 0 || len(recorder.Events) != 0 {
+				return fmt.Errorf("expect no requeue nor event, got %s, %d events", requeue, len(recorder.Events))
+			}
+			return expectBackends(f)
+		},
+	)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerEmptyBackendsGuard)
+	f.SVC.Spec.Selector = nil
+	f.WithEndpoints(pods(ENI_ADDR_1))
+	f.RunCustomized(t, "Endpoints of a service without selector are restored",
+		func(f *FrameWork) error {
+			if _, err := ensure(f); err != nil {
+				return err
+			}
+			return expectBackends(f, ENI_ADDR_1)
+		},
+	)
+
+	f.WithEndpoints(pods())
+	f.RunCustomized(t, "Vserver group of a service without selector is emptied",
+		func(f *FrameWork) error {
+			requeue, err := ensure(f)
+			if err != nil {
+				return err
+			}
+			if requeue != 0 {
+				return fmt.Errorf("expect no requeue, got %s", requeue)
+			}
+			return expectBackends(f)
+		},
+	)
+}
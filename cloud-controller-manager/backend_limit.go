@@ -0,0 +1,206 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"sort"
+	"strconv"
+)
+
+// A vserver group takes a limited number of backends. When there are more
+// nodes than that, a subset of the nodes is selected by rendezvous hashing of
+// the node uid with the service, a node joining or leaving the cluster changes
+// the subset by one node at most. With the zone-balanced-backends annotation
+// the nodes are taken from each zone in turn, so that the subset spans the
+// zones evenly instead of following the hash.
+
+// DEFAULT_MAX_BACKENDS backend limit of a vserver group
+const DEFAULT_MAX_BACKENDS = 200
+
+// ValidateMaxBackends the max-backends annotation must be a positive integer
+func ValidateMaxBackends(service *v1.Service) error {
+	max := serviceAnnotation(service, ServiceAnnotationLoadBalancerMaxBackends)
+	if max == "" {
+		return nil
+	}
+	if i, err := strconv.Atoi(max); err != nil || i < 1 {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerMaxBackends,
+			token:      max,
+			reason:     "must be a positive integer",
+		}
+	}
+	return nil
+}
+
+// limitBackends select the nodes to be added as backends when the eligible
+// ones exceed the max backends. With externalTrafficPolicy=Local only the
+// nodes running the endpoints are eligible, the others receive no traffic.
+// Nodes which are not ecs backends are kept as they are.
+func (c *Cloud) limitBackends(ctx context.Context, service *v1.Service, nodes []*v1.Node, eps *v1.Endpoints) []*v1.Node {
+	if IsENIBackendType(service) {
+		return nodes
+	}
+	defaulted, _ := ExtractAnnotationRequest(service)
+	var hosting map[string]bool
+	if ServiceModeLocal(service) {
+		hosting = endpointNodes(eps)
+	}
+	var (
+		eligible []*v1.Node
+		others   []*v1.Node
+	)
+	for _, node := range nodes {
+		if isExcludeNode(node) || node.Labels["type"] == utils.ECINodeLabel {
+			others = append(others, node)
+			continue
+		}
+		if hosting != nil && !hosting[node.Name] {
+			continue
+		}
+		eligible = append(eligible, node)
+	}
+	if len(eligible) <= defaulted.MaxBackends {
+		return nodes
+	}
+	seed := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+	selected := selectNodes(seed, eligible, defaulted.MaxBackends)
+	if serviceAnnotation(service, ServiceAnnotationLoadBalancerZoneBalancedBackends) == "on" {
+		selected = selectNodesByZone(seed, eligible, defaulted.MaxBackends)
+	}
+	recordBackendsLimited(ctx, service, len(eligible)-len(selected), len(eligible), defaulted.MaxBackends)
+	return append(selected, others...)
+}
+
+// endpointNodes names of the nodes running the ready endpoints
+func endpointNodes(eps *v1.Endpoints) map[string]bool {
+	hosting := map[string]bool{}
+	if eps == nil {
+		return hosting
+	}
+	for _, sub := range eps.Subsets {
+		for _, add := range sub.Addresses {
+			if add.NodeName != nil {
+				hosting[*add.NodeName] = true
+			}
+		}
+	}
+	return hosting
+}
+
+// selectNodes the max nodes of the highest score, the order of nodes is kept
+func selectNodes(seed string, nodes []*v1.Node, max int) []*v1.Node {
+	if len(nodes) <= max {
+		return nodes
+	}
+	ranked := make([]*v1.Node, len(nodes))
+	copy(ranked, nodes)
+	sort.SliceStable(
+		ranked,
+		func(i, j int) bool {
+			return nodeScore(seed, ranked[i]) > nodeScore(seed, ranked[j])
+		},
+	)
+	chosen := map[*v1.Node]bool{}
+	for _, node := range ranked[:max] {
+		chosen[node] = true
+	}
+	var selected []*v1.Node
+	for _, node := range nodes {
+		if chosen[node] {
+			selected = append(selected, node)
+		}
+	}
+	return selected
+}
+
+// selectNodesByZone the max nodes taken from each zone in turn, the nodes of
+// a zone by the highest score. The order of nodes is kept.
+func selectNodesByZone(seed string, nodes []*v1.Node, max int) []*v1.Node {
+	if len(nodes) <= max {
+		return nodes
+	}
+	byZone := map[string][]*v1.Node{}
+	var zones []string
+	for _, node := range nodes {
+		zone := utils.NodeZone(node)
+		if _, ok := byZone[zone]; !ok {
+			zones = append(zones, zone)
+		}
+		byZone[zone] = append(byZone[zone], node)
+	}
+	sort.Strings(zones)
+	for _, zone := range zones {
+		ranked := byZone[zone]
+		sort.SliceStable(
+			ranked,
+			func(i, j int) bool {
+				return nodeScore(seed, ranked[i]) > nodeScore(seed, ranked[j])
+			},
+		)
+	}
+	chosen := map[*v1.Node]bool{}
+	for round := 0; len(chosen) < max; round++ {
+		for _, zone := range zones {
+			if round < len(byZone[zone]) && len(chosen) < max {
+				chosen[byZone[zone][round]] = true
+			}
+		}
+	}
+	var selected []*v1.Node
+	for _, node := range nodes {
+		if chosen[node] {
+			selected = append(selected, node)
+		}
+	}
+	return selected
+}
+
+// nodeScore rendezvous hash of the node for the seed
+func nodeScore(seed string, node *v1.Node) uint64 {
+	id := string(node.UID)
+	if id == "" {
+		id = node.Name
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(seed))
+	hash.Write([]byte{0})
+	hash.Write([]byte(id))
+	return hash.Sum64()
+}
+
+func recordBackendsLimited(ctx context.Context, service *v1.Service, skipped, eligible, max int) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "%d of %d nodes are skipped by max backends %d", skipped, eligible, max)
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeNormal,
+		"BackendsLimited",
+		"%d of %d nodes are not added as backends, the loadbalancer takes %d backends at most",
+		skipped, eligible, max,
+	)
+}
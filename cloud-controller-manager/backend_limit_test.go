@@ -0,0 +1,256 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func limitNodes(from, to int) []*v1.Node {
+	var nodes []*v1.Node
+	for i := from; i < to; i++ {
+		nodes = append(nodes, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: fmt.Sprintf("node-%03d", i),
+				UID:  types.UID(fmt.Sprintf("uid-%03d", i)),
+			},
+		})
+	}
+	return nodes
+}
+
+func nodeNames(nodes []*v1.Node) map[string]bool {
+	names := map[string]bool{}
+	for _, node := range nodes {
+		names[node.Name] = true
+	}
+	return names
+}
+
+// changed number of nodes in a but not in b
+func changed(a, b map[string]bool) int {
+	n := 0
+	for name := range a {
+		if !b[name] {
+			n++
+		}
+	}
+	return n
+}
+
+func TestValidateMaxBackends(t *testing.T) {
+	cases := []struct {
+		max     string
+		invalid bool
+	}{
+		{max: ""},
+		{max: "1"},
+		{max: "200"},
+		{max: "0", invalid: true},
+		{max: "-1", invalid: true},
+		{max: "all", invalid: true},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: map[string]string{}},
+		}
+		if c.max != "" {
+			svc.Annotations[ServiceAnnotationLoadBalancerMaxBackends] = c.max
+		}
+		err := ValidateMaxBackends(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("max backends [%s]: unexpected error: %s", c.max, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("max backends [%s]: expect InvalidAnnotation error, got %v", c.max, err)
+		}
+	}
+}
+
+func TestSelectNodesStability(t *testing.T) {
+	seed := "default/my-service"
+	nodes := limitNodes(0, 600)
+	selected := nodeNames(selectNodes(seed, nodes, 200))
+	if len(selected) != 200 {
+		t.Fatalf("expect 200 nodes selected, got %d", len(selected))
+	}
+	if again := nodeNames(selectNodes(seed, nodes, 200)); changed(selected, again) != 0 {
+		t.Fatalf("expect the same selection for the same nodes")
+	}
+
+	// each node joined replaces one selected node at most
+	joined := nodeNames(selectNodes(seed, append(limitNodes(0, 600), limitNodes(600, 610)...), 200))
+	if n := changed(selected, joined); n > 10 {
+		t.Fatalf("expect 10 selected nodes changed at most after 10 nodes joined, got %d", n)
+	}
+
+	// only the nodes left are replaced
+	var left []*v1.Node
+	removed := 0
+	for _, node := range nodes {
+		if selected[node.Name] && removed < 10 {
+			removed++
+			continue
+		}
+		left = append(left, node)
+	}
+	remaining := nodeNames(selectNodes(seed, left, 200))
+	if len(remaining) != 200 {
+		t.Fatalf("expect 200 nodes selected after nodes left, got %d", len(remaining))
+	}
+	if n := changed(selected, remaining); n != 10 {
+		t.Fatalf("expect only the 10 nodes left to be replaced, got %d changed", n)
+	}
+
+	// nodes left out of the selection change nothing
+	var unselected []*v1.Node
+	for _, node := range nodes {
+		if selected[node.Name] || len(unselected) < 100 {
+			unselected = append(unselected, node)
+		}
+	}
+	if n := changed(selected, nodeNames(selectNodes(seed, unselected, 200))); n != 0 {
+		t.Fatalf("expect selection unchanged when unselected nodes left, got %d changed", n)
+	}
+
+	// services are spread over different subsets
+	if changed(selected, nodeNames(selectNodes("default/another-service", nodes, 200))) == 0 {
+		t.Fatalf("expect another service to select a different subset")
+	}
+}
+
+func TestLimitBackends(t *testing.T) {
+	nodes := limitNodes(0, 5)
+	nodes = append(nodes, &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "eci", UID: "uid-eci", Labels: map[string]string{"type": utils.ECINodeLabel}},
+	})
+	name := func(i int) *string { return &nodes[i].Name }
+	eps := &v1.Endpoints{
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{
+					{IP: "10.0.0.1", NodeName: name(0)},
+					{IP: "10.0.0.2", NodeName: name(2)},
+					{IP: "10.0.0.3", NodeName: name(4)},
+					{IP: "10.0.0.4", NodeName: name(5)},
+				},
+			},
+		},
+	}
+	service := func(policy v1.ServiceExternalTrafficPolicyType, max string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-service",
+				Namespace:   "default",
+				Annotations: map[string]string{ServiceAnnotationLoadBalancerMaxBackends: max},
+			},
+			Spec: v1.ServiceSpec{ExternalTrafficPolicy: policy},
+		}
+	}
+	c := &Cloud{}
+
+	recorder := record.NewFakeRecorder(10)
+	ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+	limited := nodeNames(c.limitBackends(ctx, service(v1.ServiceExternalTrafficPolicyTypeCluster, "3"), nodes, eps))
+	if len(limited) != 4 || !limited["eci"] {
+		t.Fatalf("expect 3 nodes selected with the eci node kept, got %v", limited)
+	}
+	if len(recorder.Events) != 1 || !strings.Contains(<-recorder.Events, "2 of 5 nodes") {
+		t.Fatalf("expect an event of the 2 nodes skipped")
+	}
+
+	limited = nodeNames(c.limitBackends(ctx, service(v1.ServiceExternalTrafficPolicyTypeLocal, "2"), nodes, eps))
+	for name := range limited {
+		if name != "eci" && name != nodes[0].Name && name != nodes[2].Name && name != nodes[4].Name {
+			t.Fatalf("expect nodes running endpoints selected in local mode, got %v", limited)
+		}
+	}
+	if len(limited) != 3 {
+		t.Fatalf("expect 2 nodes selected with the eci node kept, got %v", limited)
+	}
+
+	// every node running endpoints fits
+	limited = nodeNames(c.limitBackends(ctx, service(v1.ServiceExternalTrafficPolicyTypeLocal, "3"), nodes, eps))
+	if len(limited) != len(nodes) {
+		t.Fatalf("expect nodes unchanged within the limit, got %v", limited)
+	}
+}
+
+func TestLimitBackendsByZone(t *testing.T) {
+	// 12 nodes in zone h, 3 in zone i and 1 in zone j
+	var nodes []*v1.Node
+	for i, node := range limitNodes(0, 16) {
+		zone := "cn-hangzhou-h"
+		if i >= 12 {
+			zone = "cn-hangzhou-i"
+		}
+		if i == 15 {
+			zone = "cn-hangzhou-j"
+		}
+		node.Labels = map[string]string{utils.LabelTopologyZone: zone}
+		nodes = append(nodes, node)
+	}
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-service",
+			Namespace: "default",
+			Annotations: map[string]string{
+				ServiceAnnotationLoadBalancerMaxBackends:          "6",
+				ServiceAnnotationLoadBalancerZoneBalancedBackends: "on",
+			},
+		},
+		Spec: v1.ServiceSpec{ExternalTrafficPolicy: v1.ServiceExternalTrafficPolicyTypeCluster},
+	}
+	zones := func(selected []*v1.Node) map[string]int {
+		count := map[string]int{}
+		for _, node := range selected {
+			count[utils.NodeZone(node)]++
+		}
+		return count
+	}
+
+	limited := (&Cloud{}).limitBackends(context.Background(), service, nodes, nil)
+	if count := zones(limited); len(limited) != 6 || count["cn-hangzhou-h"] != 3 ||
+		count["cn-hangzhou-i"] != 2 || count["cn-hangzhou-j"] != 1 {
+		t.Fatalf("expect the selection balanced across the zones, got %v", count)
+	}
+	if again := (&Cloud{}).limitBackends(context.Background(), service, nodes, nil); !reflect.DeepEqual(
+		nodeNames(limited), nodeNames(again)) {
+		t.Fatalf("expect the same selection for the same nodes")
+	}
+
+	service.Annotations[ServiceAnnotationLoadBalancerMaxBackends] = "8"
+	if count := zones((&Cloud{}).limitBackends(context.Background(), service, nodes, nil)); count["cn-hangzhou-h"] != 4 ||
+		count["cn-hangzhou-i"] != 3 || count["cn-hangzhou-j"] != 1 {
+		t.Fatalf("expect the small zones taken whole and the rest from the large one, got %v", count)
+	}
+
+	// the hash alone ignores the zones
+	delete(service.Annotations, ServiceAnnotationLoadBalancerZoneBalancedBackends)
+	if limited := (&Cloud{}).limitBackends(context.Background(), service, nodes, nil); len(limited) != 8 {
+		t.Fatalf("expect 8 nodes selected without the annotation, got %d", len(limited))
+	}
+}
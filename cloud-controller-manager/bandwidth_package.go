@@ -0,0 +1,292 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/ecs"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"strings"
+)
+
+// The common bandwidth package api of vpc is not wrapped by aliyungo, the
+// request types are declared here and invoked directly. Only eips can join a
+// bandwidth package, the eips bound to the loadbalancer join the package of
+// the bandwidth-package-id annotation. The package joined is remembered by a
+// tag so that the eips leave it when the annotation is changed or removed.
+
+type DescribeCommonBandwidthPackagesArgs struct {
+	RegionId           common.Region
+	BandwidthPackageId string
+}
+
+type CommonBandwidthPackageType struct {
+	BandwidthPackageId string
+	Name               string
+	Status             string
+	PublicIpAddresses  struct {
+		PublicIpAddresse []PublicIpAddresseType
+	}
+}
+
+type PublicIpAddresseType struct {
+	AllocationId string
+	IpAddress    string
+}
+
+type DescribeCommonBandwidthPackagesResponse struct {
+	common.Response
+	common.PaginationResult
+	CommonBandwidthPackages struct {
+		CommonBandwidthPackage []CommonBandwidthPackageType
+	}
+}
+
+type AddCommonBandwidthPackageIpArgs struct {
+	RegionId           common.Region
+	BandwidthPackageId string
+	IpInstanceId       string
+}
+
+type RemoveCommonBandwidthPackageIpArgs struct {
+	RegionId           common.Region
+	BandwidthPackageId string
+	IpInstanceId       string
+}
+
+// BANDWIDTHPACKAGEKEY tag of the loadbalancer telling the bandwidth package
+// joined by its eips
+const BANDWIDTHPACKAGEKEY = "kubernetes.bandwidth.package"
+
+// describeBandwidthPackage find the bandwidth package by its id, nil if it
+// does not exist.
+func describeBandwidthPackage(ctx context.Context, client RouteSDK,
+	region common.Region, id string) (*CommonBandwidthPackageType, error) {
+	packages, err := client.DescribeCommonBandwidthPackages(
+		ctx,
+		&DescribeCommonBandwidthPackagesArgs{
+			RegionId:           region,
+			BandwidthPackageId: id,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("describe bandwidth package %s: %s", id, err.Error())
+	}
+	for i := range packages {
+		if packages[i].BandwidthPackageId == id {
+			return &packages[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// joinedBandwidthPackageId the bandwidth package joined by the eips of lb
+func joinedBandwidthPackageId(ctx context.Context, client ClientSLBSDK, lb *slb.LoadBalancerType) (string, error) {
+	tags, _, err := client.DescribeTags(
+		ctx,
+		&slb.DescribeTagsArgs{
+			RegionId:       lb.RegionId,
+			LoadBalancerID: lb.LoadBalancerId,
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("describe tags of loadbalancer %s: %s", lb.LoadBalancerId, err.Error())
+	}
+	for _, tag := range tags {
+		if tag.TagKey == BANDWIDTHPACKAGEKEY {
+			return tag.TagValue, nil
+		}
+	}
+	return "", nil
+}
+
+// boundEips the eips bound to lb, by the eip-id annotation or by the user
+func boundEips(ctx context.Context, ins ClientInstanceSDK, lb *slb.LoadBalancerType) ([]ecs.EipAddressSetType, error) {
+	var (
+		eips       []ecs.EipAddressSetType
+		pagination common.Pagination
+	)
+	for {
+		ret, result, err := ins.DescribeEipAddresses(
+			ctx,
+			&ecs.DescribeEipAddressesArgs{
+				RegionId:               lb.RegionId,
+				AssociatedInstanceType: ecs.AssociatedInstanceTypeSlbInstance,
+				AssociatedInstanceId:   lb.LoadBalancerId,
+				Pagination:             pagination,
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("describe eips of loadbalancer %s: %s", lb.LoadBalancerId, err.Error())
+		}
+		eips = append(eips, ret...)
+		if result == nil || result.NextPage() == nil {
+			return eips, nil
+		}
+		pagination = *result.NextPage()
+	}
+}
+
+func isBandwidthPackageMember(bwp *CommonBandwidthPackageType, id string) bool {
+	for _, ip := range bwp.PublicIpAddresses.PublicIpAddresse {
+		if ip.AllocationId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// EnsureBandwidthPackage make the eips bound to lb members of the bandwidth
+// package of the annotation, the eips removed out of band are added again.
+// Failures of the package are reported by events, they never fail the sync.
+func EnsureBandwidthPackage(ctx context.Context, vpc RouteSDK, ins ClientInstanceSDK, client ClientSLBSDK,
+	service *v1.Service, lb *slb.LoadBalancerType) error {
+	_, request := ExtractAnnotationRequest(service)
+	joined, err := joinedBandwidthPackageId(ctx, client, lb)
+	if err != nil {
+		return err
+	}
+	if joined != "" && joined != request.BandwidthPackageId {
+		if err := leaveBandwidthPackage(ctx, vpc, ins, client, service, lb, joined); err != nil {
+			return err
+		}
+	}
+	if request.BandwidthPackageId == "" {
+		return nil
+	}
+
+	bwp, err := describeBandwidthPackage(ctx, vpc, lb.RegionId, request.BandwidthPackageId)
+	if err != nil {
+		return err
+	}
+	if bwp == nil {
+		recordBandwidthPackageEvent(ctx, service, "BandwidthPackageNotFound",
+			"Bandwidth package %s is not found in region %s", request.BandwidthPackageId, lb.RegionId)
+		return nil
+	}
+	eips, err := boundEips(ctx, ins, lb)
+	if err != nil {
+		return err
+	}
+	if len(eips) == 0 {
+		recordBandwidthPackageEvent(ctx, service, "BandwidthPackageNoEip",
+			"Loadbalancer %s has no eip, only eips can join bandwidth package %s",
+			lb.LoadBalancerId, bwp.BandwidthPackageId)
+		return nil
+	}
+	for _, eip := range eips {
+		if isBandwidthPackageMember(bwp, eip.AllocationId) {
+			continue
+		}
+		if eip.InternetChargeType != "" && eip.InternetChargeType != common.PayByTraffic {
+			recordBandwidthPackageEvent(ctx, service, "BandwidthPackageChargeTypeUnsupported",
+				"Eip %s is charged by %s, only eips paid by traffic can join bandwidth package %s",
+				eip.AllocationId, eip.InternetChargeType, bwp.BandwidthPackageId)
+			continue
+		}
+		utils.Logf(service, "add eip %s[%s] to bandwidth package %s", eip.AllocationId, eip.IpAddress, bwp.BandwidthPackageId)
+		err := vpc.AddCommonBandwidthPackageIp(
+			ctx,
+			&AddCommonBandwidthPackageIpArgs{
+				RegionId:           lb.RegionId,
+				BandwidthPackageId: bwp.BandwidthPackageId,
+				IpInstanceId:       eip.AllocationId,
+			},
+		)
+		switch {
+		case err == nil:
+		case strings.Contains(err.Error(), "QuotaExceeded"):
+			recordBandwidthPackageEvent(ctx, service, "BandwidthPackageFull",
+				"Bandwidth package %s is full, eip %s can not join it: %s",
+				bwp.BandwidthPackageId, eip.AllocationId, err.Error())
+		default:
+			recordBandwidthPackageEvent(ctx, service, "BandwidthPackageJoinFailed",
+				"Eip %s can not join bandwidth package %s: %s",
+				eip.AllocationId, bwp.BandwidthPackageId, err.Error())
+		}
+	}
+	if joined != bwp.BandwidthPackageId {
+		// remember the package so that the eips leave it when the annotation is removed
+		err := addSLBTag(client, ctx, map[string]string{BANDWIDTHPACKAGEKEY: bwp.BandwidthPackageId}, lb.RegionId, lb.LoadBalancerId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureBandwidthPackageLeft remove the eips bound to lb from the bandwidth
+// package joined by the annotation.
+func EnsureBandwidthPackageLeft(ctx context.Context, vpc RouteSDK, ins ClientInstanceSDK, client ClientSLBSDK,
+	service *v1.Service, lb *slb.LoadBalancerType) error {
+	joined, err := joinedBandwidthPackageId(ctx, client, lb)
+	if err != nil {
+		return err
+	}
+	if joined == "" {
+		return nil
+	}
+	return leaveBandwidthPackage(ctx, vpc, ins, client, service, lb, joined)
+}
+
+func leaveBandwidthPackage(ctx context.Context, vpc RouteSDK, ins ClientInstanceSDK, client ClientSLBSDK,
+	service *v1.Service, lb *slb.LoadBalancerType, id string) error {
+	bwp, err := describeBandwidthPackage(ctx, vpc, lb.RegionId, id)
+	if err != nil {
+		return err
+	}
+	// the package may have been released by the user
+	if bwp != nil {
+		eips, err := boundEips(ctx, ins, lb)
+		if err != nil {
+			return err
+		}
+		for _, eip := range eips {
+			if !isBandwidthPackageMember(bwp, eip.AllocationId) {
+				continue
+			}
+			utils.Logf(service, "remove eip %s[%s] from bandwidth package %s", eip.AllocationId, eip.IpAddress, id)
+			err := vpc.RemoveCommonBandwidthPackageIp(
+				ctx,
+				&RemoveCommonBandwidthPackageIpArgs{
+					RegionId:           lb.RegionId,
+					BandwidthPackageId: id,
+					IpInstanceId:       eip.AllocationId,
+				},
+			)
+			if err != nil {
+				return fmt.Errorf("remove eip %s from bandwidth package %s: %s", eip.AllocationId, id, err.Error())
+			}
+		}
+	}
+	return removeSLBTag(client, ctx, []slb.TagItem{{TagKey: BANDWIDTHPACKAGEKEY, TagValue: id}}, lb.RegionId, lb.LoadBalancerId)
+}
+
+func recordBandwidthPackageEvent(ctx context.Context, service *v1.Service, reason, message string, args ...interface{}) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, message, args...)
+		return
+	}
+	record.Eventf(service, v1.EventTypeWarning, reason, message, args...)
+}
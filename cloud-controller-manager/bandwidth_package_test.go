@@ -0,0 +1,173 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/ecs"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"testing"
+)
+
+const (
+	BANDWIDTH_PACKAGE_ID      = "cbwp-2zeeb0q6xhx0n0zkymxxx"
+	BANDWIDTH_PACKAGE_FULL_ID = "cbwp-2zeeb0q6xhx0n0zkymfff"
+)
+
+func TestBandwidthPackage(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerAddressType:        string(slb.IntranetAddressType),
+					ServiceAnnotationLoadBalancerEipId:              EIP_ID_1,
+					ServiceAnnotationLoadBalancerBandwidthPackageId: BANDWIDTH_PACKAGE_ID,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	seed := func() {
+		INSTANCE.eips.Store(EIP_ID_1, ecs.EipAddressSetType{RegionId: REGION, AllocationId: EIP_ID_1, IpAddress: EIP_ADDR_1})
+		ROUTES.bandwidthPackages.Store(BANDWIDTH_PACKAGE_ID, CommonBandwidthPackageType{BandwidthPackageId: BANDWIDTH_PACKAGE_ID})
+		full := CommonBandwidthPackageType{BandwidthPackageId: BANDWIDTH_PACKAGE_FULL_ID}
+		for i := 0; i < BANDWIDTH_PACKAGE_CAPACITY; i++ {
+			full.PublicIpAddresses.PublicIpAddresse = append(full.PublicIpAddresses.PublicIpAddresse,
+				PublicIpAddresseType{AllocationId: fmt.Sprintf("eip-other-%d", i)})
+		}
+		ROUTES.bandwidthPackages.Store(BANDWIDTH_PACKAGE_FULL_ID, full)
+	}
+	expectMember := func(id string, member bool) error {
+		v, ok := ROUTES.bandwidthPackages.Load(id)
+		if !ok {
+			return fmt.Errorf("bandwidth package %s not found", id)
+		}
+		bwp := v.(CommonBandwidthPackageType)
+		if isBandwidthPackageMember(&bwp, EIP_ID_1) != member {
+			return fmt.Errorf("expect eip %s member of bandwidth package %s %t, got %v",
+				EIP_ID_1, id, member, bwp.PublicIpAddresses.PublicIpAddresse)
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Join bandwidth package",
+		func(f *FrameWork) error {
+			seed()
+			if _, _, err := f.EnsureWithEvents(); err != nil {
+				return err
+			}
+			return expectMember(BANDWIDTH_PACKAGE_ID, true)
+		},
+	)
+
+	f.RunCustomized(t, "Repair the membership removed out of band",
+		func(f *FrameWork) error {
+			err := f.RouteSDK().RemoveCommonBandwidthPackageIp(
+				context.Background(),
+				&RemoveCommonBandwidthPackageIpArgs{BandwidthPackageId: BANDWIDTH_PACKAGE_ID, IpInstanceId: EIP_ID_1},
+			)
+			if err != nil {
+				return err
+			}
+			if _, _, err := f.EnsureWithEvents(); err != nil {
+				return err
+			}
+			return expectMember(BANDWIDTH_PACKAGE_ID, true)
+		},
+	)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerBandwidthPackageId)
+	f.RunCustomized(t, "Leave bandwidth package by removing the annotation",
+		func(f *FrameWork) error {
+			if _, _, err := f.EnsureWithEvents(); err != nil {
+				return err
+			}
+			return expectMember(BANDWIDTH_PACKAGE_ID, false)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerBandwidthPackageId] = "cbwp-not-exist"
+	f.RunCustomized(t, "Bandwidth package not found",
+		func(f *FrameWork) error {
+			_, events, err := f.EnsureWithEvents()
+			if err != nil {
+				return fmt.Errorf("missing bandwidth package is not expected to fail the sync: %s", err.Error())
+			}
+			if !strings.Contains(events, "BandwidthPackageNotFound") {
+				return fmt.Errorf("expect a BandwidthPackageNotFound event, got %q", events)
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerBandwidthPackageId] = BANDWIDTH_PACKAGE_FULL_ID
+	f.RunCustomized(t, "Bandwidth package full",
+		func(f *FrameWork) error {
+			_, events, err := f.EnsureWithEvents()
+			if err != nil {
+				return fmt.Errorf("full bandwidth package is not expected to fail the sync: %s", err.Error())
+			}
+			if !strings.Contains(events, "BandwidthPackageFull") {
+				return fmt.Errorf("expect a BandwidthPackageFull event, got %q", events)
+			}
+			return expectMember(BANDWIDTH_PACKAGE_FULL_ID, false)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerBandwidthPackageId] = BANDWIDTH_PACKAGE_ID
+	f.RunCustomized(t, "Leave bandwidth package on deletion",
+		func(f *FrameWork) error {
+			if _, _, err := f.EnsureWithEvents(); err != nil {
+				return err
+			}
+			if err := expectMember(BANDWIDTH_PACKAGE_ID, true); err != nil {
+				return err
+			}
+			if err := f.CloudImpl().EnsureLoadBalancerDeleted(context.Background(), CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			return expectMember(BANDWIDTH_PACKAGE_ID, false)
+		},
+	)
+}
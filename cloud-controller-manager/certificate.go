@@ -0,0 +1,228 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"strings"
+)
+
+// Uploading and deleting server certificates is not wrapped by aliyungo
+// either, see domainextension.go.
+
+type UploadServerCertificateArgs struct {
+	RegionId              common.Region
+	ServerCertificate     string
+	PrivateKey            string
+	ServerCertificateName string
+}
+
+type UploadServerCertificateResponse struct {
+	common.Response
+	ServerCertificateId   string
+	ServerCertificateName string
+}
+
+type DeleteServerCertificateArgs struct {
+	RegionId            common.Region
+	ServerCertificateId string
+}
+
+// CERT_NAME_PREFIX prefix of the certificates uploaded from secrets. The full
+// name is k8s-{service uid}-{hash of the secret content}, certificates
+// uploaded by users never match it and are never deleted.
+const CERT_NAME_PREFIX = "k8s-"
+
+// CertificateClient manage the server certificates uploaded from the tls
+// secrets referenced by ServiceAnnotationLoadBalancerCertSecret.
+type CertificateClient struct {
+	c ClientSLBSDK
+}
+
+func certificateNamePrefix(service *v1.Service) string {
+	return fmt.Sprintf("%s%s-", CERT_NAME_PREFIX, service.UID)
+}
+
+// certificateName name of the certificate uploaded from secret for service.
+// The content hash tells whether the secret has been rotated.
+func certificateName(service *v1.Service, secret *v1.Secret) string {
+	hash := sha256.New()
+	hash.Write(secret.Data[v1.TLSCertKey])
+	hash.Write(secret.Data[v1.TLSPrivateKeyKey])
+	return certificateNamePrefix(service) + hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// EnsureSecretCertificate upload the certificate of secret for service unless
+// the same content has been uploaded before. Returns the server certificate id
+// and whether it has just been uploaded.
+func (c *CertificateClient) EnsureSecretCertificate(
+	ctx context.Context, service *v1.Service, secret *v1.Secret,
+) (string, bool, error) {
+	name := certificateName(service, secret)
+	certs, err := c.c.DescribeServerCertificates(ctx, &DescribeServerCertificatesArgs{})
+	if err != nil {
+		return "", false, fmt.Errorf("describe server certificates: %s", err.Error())
+	}
+	for _, cert := range certs {
+		if cert.ServerCertificateName == name {
+			return cert.ServerCertificateId, false, nil
+		}
+	}
+	utils.Logf(service, "upload server certificate %s from secret %s/%s", name, secret.Namespace, secret.Name)
+	resp, err := c.c.UploadServerCertificate(
+		ctx,
+		&UploadServerCertificateArgs{
+			ServerCertificate:     string(secret.Data[v1.TLSCertKey]),
+			PrivateKey:            string(secret.Data[v1.TLSPrivateKeyKey]),
+			ServerCertificateName: name,
+		},
+	)
+	if err != nil {
+		return "", false, fmt.Errorf("upload server certificate from secret %s/%s: %s", secret.Namespace, secret.Name, err.Error())
+	}
+	return resp.ServerCertificateId, true, nil
+}
+
+// CleanupSecretCertificates delete the certificates uploaded for service
+// except keep. An empty keep deletes all of them.
+func (c *CertificateClient) CleanupSecretCertificates(
+	ctx context.Context, service *v1.Service, keep string,
+) error {
+	certs, err := c.c.DescribeServerCertificates(ctx, &DescribeServerCertificatesArgs{})
+	if err != nil {
+		return fmt.Errorf("describe server certificates: %s", err.Error())
+	}
+	prefix := certificateNamePrefix(service)
+	for _, cert := range certs {
+		if !strings.HasPrefix(cert.ServerCertificateName, prefix) ||
+			cert.ServerCertificateId == keep {
+			continue
+		}
+		utils.Logf(service, "delete server certificate %s[%s]", cert.ServerCertificateName, cert.ServerCertificateId)
+		err := c.c.DeleteServerCertificate(
+			ctx,
+			&DeleteServerCertificateArgs{ServerCertificateId: cert.ServerCertificateId},
+		)
+		if err != nil {
+			return fmt.Errorf("delete server certificate %s: %s", cert.ServerCertificateId, err.Error())
+		}
+	}
+	return nil
+}
+
+// certSecretName split the cert-secret annotation "namespace/name" or "name".
+// Only secrets in the namespace of the service can be referenced.
+func certSecretName(service *v1.Service) (string, error) {
+	annotation := serviceAnnotation(service, utils.ServiceAnnotationLoadBalancerCertSecret)
+	ns, name := service.Namespace, annotation
+	if parts := strings.Split(annotation, "/"); len(parts) == 2 {
+		ns, name = parts[0], parts[1]
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return "", annotationError{
+			annotation: utils.ServiceAnnotationLoadBalancerCertSecret,
+			token:      annotation,
+			reason:     "must be like 'namespace/name' or 'name'",
+		}
+	}
+	if ns != service.Namespace {
+		return "", annotationError{
+			annotation: utils.ServiceAnnotationLoadBalancerCertSecret,
+			token:      annotation,
+			reason:     fmt.Sprintf("secret must be in the namespace of the service %s", service.Namespace),
+		}
+	}
+	return name, nil
+}
+
+// ValidateCertSecret cert-id and cert-secret can not be used together.
+func ValidateCertSecret(service *v1.Service) error {
+	if serviceAnnotation(service, utils.ServiceAnnotationLoadBalancerCertSecret) == "" {
+		return nil
+	}
+	if serviceAnnotation(service, ServiceAnnotationLoadBalancerCertID) != "" {
+		return annotationError{
+			annotation: utils.ServiceAnnotationLoadBalancerCertSecret,
+			token:      serviceAnnotation(service, utils.ServiceAnnotationLoadBalancerCertSecret),
+			reason:     fmt.Sprintf("can not be used together with %s", ServiceAnnotationLoadBalancerCertID),
+		}
+	}
+	_, err := certSecretName(service)
+	return err
+}
+
+// ensureSecretCertificate upload the tls secret referenced by the cert-secret
+// annotation and return a copy of service with the cert-id annotation set to
+// the uploaded certificate, so that listeners are bound to it as usual.
+func (c *Cloud) ensureSecretCertificate(ctx context.Context, service *v1.Service) (*v1.Service, string, error) {
+	if serviceAnnotation(service, utils.ServiceAnnotationLoadBalancerCertSecret) == "" {
+		return service, "", nil
+	}
+	name, err := certSecretName(service)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := c.kclient.CoreV1().Secrets(service.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, "", fmt.Errorf("cert secret %s/%s not found", service.Namespace, name)
+		}
+		return nil, "", fmt.Errorf("get cert secret %s/%s: %s", service.Namespace, name, err.Error())
+	}
+	if len(secret.Data[v1.TLSCertKey]) == 0 || len(secret.Data[v1.TLSPrivateKeyKey]) == 0 {
+		return nil, "", fmt.Errorf("cert secret %s/%s must contain %s and %s",
+			service.Namespace, name, v1.TLSCertKey, v1.TLSPrivateKeyKey)
+	}
+	id, uploaded, err := c.climgr.Certificates().EnsureSecretCertificate(ctx, service, secret)
+	if err != nil {
+		return nil, "", err
+	}
+	if uploaded {
+		recordCertificateUploaded(ctx, service, secret, id)
+	}
+	svc := service.DeepCopy()
+	svc.Annotations[ServiceAnnotationLoadBalancerCertID] = id
+	// the secret may have been rotated without any change of the service,
+	// drop the hash so that listeners are always reconciled.
+	delete(svc.Labels, utils.LabelServiceHash)
+	return svc, id, nil
+}
+
+// recordCertificateUploaded tell which certificate the secret has been uploaded as.
+func recordCertificateUploaded(ctx context.Context, service *v1.Service, secret *v1.Secret, id string) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeNormal,
+		"CertificateUploaded",
+		"Uploaded secret %s/%s as server certificate %s",
+		secret.Namespace, secret.Name, id,
+	)
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func tlsSecret(cert string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-tls-secret", Namespace: "default"},
+		Type:       v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:       []byte(cert),
+			v1.TLSPrivateKeyKey: []byte("key-of-" + cert),
+		},
+	}
+}
+
+func TestCertificateFromSecret(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort:     "https:443",
+					utils.ServiceAnnotationLoadBalancerCertSecret: "default/my-tls-secret",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 31443},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	// a certificate uploaded by the user must survive the cleanup
+	user, err := f.SLBSDK().UploadServerCertificate(
+		context.Background(),
+		&UploadServerCertificateArgs{ServerCertificateName: "user-cert"},
+	)
+	if err != nil {
+		t.Fatalf("upload user certificate: %s", err.Error())
+	}
+
+	// uploaded certificates of the service, besides the user's
+	uploaded := func() ([]ServerCertificateType, error) {
+		certs, err := f.SLBSDK().DescribeServerCertificates(context.Background(), &DescribeServerCertificatesArgs{})
+		if err != nil {
+			return nil, err
+		}
+		var result []ServerCertificateType
+		userFound := false
+		for _, cert := range certs {
+			if cert.ServerCertificateId == user.ServerCertificateId {
+				userFound = true
+				continue
+			}
+			result = append(result, cert)
+		}
+		if !userFound {
+			return nil, fmt.Errorf("user certificate has been deleted")
+		}
+		return result, nil
+	}
+	ensure := func(f *FrameWork, secret *v1.Secret) (string, error) {
+		recorder := record.NewFakeRecorder(10)
+		ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+		_, err := f.Cloud.kclient.CoreV1().Secrets(secret.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+			return "", err
+		}
+		if err := ExpectExistAndEqual(f); err != nil {
+			return "", err
+		}
+		certs, err := uploaded()
+		if err != nil {
+			return "", err
+		}
+		if len(certs) != 1 || certs[0].ServerCertificateName != certificateName(f.SVC, secret) {
+			return "", fmt.Errorf("expect the certificate of the secret only, got %v", certs)
+		}
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil {
+			return "", err
+		}
+		resp, err := f.SLBSDK().DescribeLoadBalancerHTTPSListenerAttribute(ctx, lb.LoadBalancerId, 443)
+		if err != nil {
+			return "", err
+		}
+		if resp.ServerCertificateId != certs[0].ServerCertificateId {
+			return "", fmt.Errorf("expect listener bound to %s, got %s",
+				certs[0].ServerCertificateId, resp.ServerCertificateId)
+		}
+		events := 0
+		for len(recorder.Events) > 0 {
+			if strings.Contains(<-recorder.Events, "CertificateUploaded") {
+				events++
+			}
+		}
+		if events != 1 {
+			return "", fmt.Errorf("expect 1 CertificateUploaded event, got %d", events)
+		}
+		return resp.ServerCertificateId, nil
+	}
+
+	var first string
+	f.RunCustomized(t, "Upload certificate from secret",
+		func(f *FrameWork) error {
+			first, err = ensure(f, tlsSecret("cert-v1"))
+			return err
+		},
+	)
+	f.RunCustomized(t, "Rotate certificate of secret",
+		func(f *FrameWork) error {
+			second, err := ensure(f, tlsSecret("cert-v2"))
+			if err != nil {
+				return err
+			}
+			if second == first {
+				return fmt.Errorf("expect listener rebound to the rotated certificate")
+			}
+			return nil
+		},
+	)
+	f.RunCustomized(t, "Delete uploaded certificates with the service",
+		func(f *FrameWork) error {
+			if err := f.CloudImpl().EnsureLoadBalancerDeleted(context.Background(), CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			certs, err := uploaded()
+			if err != nil {
+				return err
+			}
+			if len(certs) != 0 {
+				return fmt.Errorf("expect uploaded certificates deleted, got %v", certs)
+			}
+			return nil
+		},
+	)
+}
+
+func TestValidateCertSecret(t *testing.T) {
+	cases := []struct {
+		annotations map[string]string
+		invalid     bool
+	}{
+		{annotations: map[string]string{}},
+		{annotations: map[string]string{utils.ServiceAnnotationLoadBalancerCertSecret: "my-tls-secret"}},
+		{annotations: map[string]string{utils.ServiceAnnotationLoadBalancerCertSecret: "default/my-tls-secret"}},
+		{annotations: map[string]string{utils.ServiceAnnotationLoadBalancerCertSecret: "kube-system/my-tls-secret"}, invalid: true},
+		{annotations: map[string]string{utils.ServiceAnnotationLoadBalancerCertSecret: "default/"}, invalid: true},
+		{annotations: map[string]string{utils.ServiceAnnotationLoadBalancerCertSecret: "a/b/c"}, invalid: true},
+		{
+			annotations: map[string]string{
+				utils.ServiceAnnotationLoadBalancerCertSecret: "my-tls-secret",
+				ServiceAnnotationLoadBalancerCertID:           certID,
+			},
+			invalid: true,
+		},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: c.annotations}}
+		err := ValidateCertSecret(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("%v: unexpected error: %s", c.annotations, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%v: expect InvalidAnnotation error, got %v", c.annotations, err)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"k8s.io/klog"
+	"sync"
+	"time"
+)
+
+// The calls of a credential rejected by the authentication or the ram policy
+// keep failing until the operator fixes it, retrying them at full rate only
+// floods the api and the events. After BREAKER_THRESHOLD consecutive auth
+// failures within BREAKER_WINDOW the circuit of the credential opens, its
+// calls fail fast locally for a backoff period. Then a single probe call goes
+// through, the circuit closes if it is not rejected, or opens again with the
+// backoff doubled. The circuits are kept per AccessKey, a rotated or renewed
+// credential starts with a closed circuit. Throttling and quota errors never
+// trip the circuit.
+
+// BREAKER_THRESHOLD consecutive auth failures tripping the circuit
+var BREAKER_THRESHOLD = 5
+
+// BREAKER_WINDOW auth failures further apart do not count as consecutive
+var BREAKER_WINDOW = time.Minute
+
+// BREAKER_BACKOFF calls fail fast for the backoff after the circuit opens,
+// doubled by each failed probe up to BREAKER_MAX_BACKOFF
+var (
+	BREAKER_BACKOFF     = 30 * time.Second
+	BREAKER_MAX_BACKOFF = 10 * time.Minute
+)
+
+// API_CIRCUIT_OPEN the code of the calls failed fast
+const API_CIRCUIT_OPEN = "CircuitOpen"
+
+// AUTH_API_CODES the error codes of the calls rejected by the authentication
+// or the ram policy. An expired sts token is renewed instead, see
+// RefreshExpired.
+var AUTH_API_CODES = map[string]bool{
+	"InvalidAccessKeyId":                         true,
+	"InvalidAccessKeyId.NotFound":                true,
+	"InvalidAccessKeyId.Inactive":                true,
+	"InvalidSecurityToken.MismatchWithAccessKey": true,
+	"InvalidSecurityToken.Malformed":             true,
+	"SignatureDoesNotMatch":                      true,
+	"IncompleteSignature":                        true,
+	"Forbidden":                                  true,
+	"Forbidden.RAM":                              true,
+	"Forbidden.AccessKeyDisabled":                true,
+	"Forbidden.NoPermission":                     true,
+}
+
+// isAuthAPIError whether the call is rejected by the authentication or the
+// ram policy
+func isAuthAPIError(err error) bool {
+	return AUTH_API_CODES[apiErrorCode(err)]
+}
+
+const (
+	CIRCUIT_CLOSED    = "closed"
+	CIRCUIT_OPEN      = "open"
+	CIRCUIT_HALF_OPEN = "half-open"
+)
+
+// circuitBreaker the circuit of the calls of a credential
+type circuitBreaker struct {
+	lock       sync.Mutex
+	clock      clock.Clock
+	credential string
+	state      string
+	// failures consecutive auth failures, the last one at failed
+	failures int
+	failed   time.Time
+	cause    error
+	// backoff of the open circuit, the probe goes after reopen
+	backoff time.Duration
+	reopen  time.Time
+	probing bool
+}
+
+func newCircuitBreaker(credential string, c clock.Clock) *circuitBreaker {
+	return &circuitBreaker{
+		clock:      c,
+		credential: credential,
+		state:      CIRCUIT_CLOSED,
+		backoff:    BREAKER_BACKOFF,
+	}
+}
+
+// breakerClock the clock of the circuits, replaced by tests
+var breakerClock clock.Clock = clock.RealClock{}
+
+// MAX_IDLE_BREAKERS the closed circuits kept before the idle ones are dropped
+const MAX_IDLE_BREAKERS = 64
+
+var (
+	breakersLock sync.Mutex
+	breakers     = map[string]*circuitBreaker{}
+)
+
+// circuitBreakerOf the circuit of the credential
+func circuitBreakerOf(credential string) *circuitBreaker {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	if b, ok := breakers[credential]; ok {
+		return b
+	}
+	if len(breakers) >= MAX_IDLE_BREAKERS {
+		// the sts credentials are renewed periodically
+		for key, b := range breakers {
+			if b.idle() {
+				delete(breakers, key)
+			}
+		}
+	}
+	b := newCircuitBreaker(credential, breakerClock)
+	breakers[credential] = b
+	return b
+}
+
+// idle closed without auth failure
+func (b *circuitBreaker) idle() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state == CIRCUIT_CLOSED && b.failures == 0
+}
+
+// allow whether the call goes through, the first call after the backoff is
+// the probe of the open circuit
+func (b *circuitBreaker) allow(action string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case CIRCUIT_CLOSED:
+		return nil
+	case CIRCUIT_OPEN:
+		if b.clock.Now().Before(b.reopen) {
+			return b.openError(action)
+		}
+		b.state = CIRCUIT_HALF_OPEN
+	}
+	if b.probing {
+		return b.openError(action)
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *circuitBreaker) openError(action string) error {
+	return &APIError{
+		Action:     action,
+		Code:       API_CIRCUIT_OPEN,
+		StatusCode: -1,
+		Message: fmt.Sprintf("calls of AccessKey %s fail fast until %s after %d consecutive auth failures, last: %v",
+			maskKey(b.credential), b.reopen.Format(time.RFC3339), b.failures, b.cause),
+	}
+}
+
+// record the result of the call allowed
+func (b *circuitBreaker) record(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := b.clock.Now()
+	auth := isAuthAPIError(err)
+	if auth {
+		if now.Sub(b.failed) > BREAKER_WINDOW {
+			b.failures = 0
+		}
+		b.failures++
+		b.failed, b.cause = now, err
+	}
+
+	if b.state == CIRCUIT_HALF_OPEN {
+		b.probing = false
+		switch {
+		case auth:
+			b.backoff *= 2
+			if b.backoff > BREAKER_MAX_BACKOFF {
+				b.backoff = BREAKER_MAX_BACKOFF
+			}
+			b.open(now)
+		case apiErrorCode(err) == "AliyunGoClientFailure":
+			// no answer of the api, probe again after the same backoff
+			b.open(now)
+		default:
+			klog.Infof("alicloud: circuit of AccessKey %s closed, the probe call is accepted", maskKey(b.credential))
+			b.state, b.failures, b.backoff = CIRCUIT_CLOSED, 0, BREAKER_BACKOFF
+			metric.CloudAPICircuitOpen.Dec()
+		}
+		return
+	}
+	if !auth {
+		b.failures = 0
+		return
+	}
+	if b.state == CIRCUIT_CLOSED && b.failures >= BREAKER_THRESHOLD {
+		klog.Errorf("alicloud: circuit of AccessKey %s opened after %d consecutive auth failures, "+
+			"the cloud api calls fail fast for %s, check the AccessKey and its ram policy: %s",
+			maskKey(b.credential), b.failures, b.backoff, err.Error())
+		metric.CloudAPICircuitTrips.Inc()
+		metric.CloudAPICircuitOpen.Inc()
+		b.open(now)
+	}
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.state, b.reopen = CIRCUIT_OPEN, now.Add(b.backoff)
+}
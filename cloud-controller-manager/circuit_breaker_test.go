@@ -0,0 +1,166 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"testing"
+	"time"
+)
+
+// withFakeBreakers the circuits start over with the fake clock
+func withFakeBreakers(c clock.Clock) func() {
+	saved, savedClock := breakers, breakerClock
+	breakers, breakerClock = map[string]*circuitBreaker{}, c
+	return func() { breakers, breakerClock = saved, savedClock }
+}
+
+func apiError(code string, status int) error {
+	return &common.Error{ErrorResponse: common.ErrorResponse{RequestId: "req-1", Code: code}, StatusCode: status}
+}
+
+// countedCall the call returning err, counted by calls
+func countedCall(err *error, calls *int) func() error {
+	return func() error {
+		*calls++
+		return *err
+	}
+}
+
+func TestCircuitBreakerTrip(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	defer withFakeBreakers(fakeClock)()
+
+	var (
+		result = apiError("Forbidden.RAM", 403)
+		calls  = 0
+		call   = countedCall(&result, &calls)
+	)
+	trips := testutil.ToFloat64(metric.CloudAPICircuitTrips)
+	for i := 0; i < BREAKER_THRESHOLD; i++ {
+		if err := retryAPI(context.Background(), "key-1", "CreateLoadBalancer", call); apiErrorCode(err) != "Forbidden.RAM" {
+			t.Fatalf("expect the auth failure returned, got %v", err)
+		}
+	}
+	if n := testutil.ToFloat64(metric.CloudAPICircuitTrips); n != trips+1 {
+		t.Fatalf("expect the circuit tripped once, got %v", n-trips)
+	}
+	// the calls fail fast while the circuit is open
+	for i := 0; i < 10; i++ {
+		err := retryAPI(context.Background(), "key-1", "CreateLoadBalancer", call)
+		if apiErrorCode(err) != API_CIRCUIT_OPEN {
+			t.Fatalf("expect the call failed fast, got %v", err)
+		}
+	}
+	if calls != BREAKER_THRESHOLD {
+		t.Fatalf("expect %d calls made, got %d", BREAKER_THRESHOLD, calls)
+	}
+	if n := testutil.ToFloat64(metric.CloudAPICircuitTrips); n != trips+1 {
+		t.Fatalf("expect a single trip reported, got %v", n-trips)
+	}
+	// the circuit is per credential
+	other := 0
+	var ok error
+	if err := retryAPI(context.Background(), "key-2", "CreateLoadBalancer", countedCall(&ok, &other)); err != nil || other != 1 {
+		t.Fatalf("expect the calls of another credential go through, got %v", err)
+	}
+}
+
+func TestCircuitBreakerIgnoresThrottling(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	defer withFakeBreakers(fakeClock)()
+	attempts := API_RETRY_ATTEMPTS
+	defer func() { API_RETRY_ATTEMPTS = attempts }()
+	API_RETRY_ATTEMPTS = 1
+
+	calls := 0
+	for _, code := range []string{"Throttling.User", "QuotaExceeded.LoadBalancer", "InvalidSecurityToken.Expired"} {
+		result := apiError(code, 400)
+		for i := 0; i < 2*BREAKER_THRESHOLD; i++ {
+			if err := retryAPI(context.Background(), "key-1", "DescribeLoadBalancers", countedCall(&result, &calls)); apiErrorCode(err) != code {
+				t.Fatalf("expect %s returned, got %v", code, err)
+			}
+		}
+	}
+	// the auth failures too far apart are not consecutive
+	result := apiError("InvalidAccessKeyId.NotFound", 404)
+	for i := 0; i < 2*BREAKER_THRESHOLD; i++ {
+		if err := retryAPI(context.Background(), "key-1", "DescribeLoadBalancers", countedCall(&result, &calls)); apiErrorCode(err) == API_CIRCUIT_OPEN {
+			t.Fatalf("expect the circuit closed, got %v", err)
+		}
+		fakeClock.Step(BREAKER_WINDOW + time.Second)
+	}
+	if calls != 3*2*BREAKER_THRESHOLD+2*BREAKER_THRESHOLD {
+		t.Fatalf("expect every call made, got %d", calls)
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	defer withFakeBreakers(fakeClock)()
+
+	breaker := circuitBreakerOf("key-1")
+	forbidden := apiError("Forbidden", 403)
+	for i := 0; i < BREAKER_THRESHOLD; i++ {
+		if err := breaker.allow("DescribeLoadBalancers"); err != nil {
+			t.Fatalf("expect the circuit closed, got %v", err)
+		}
+		breaker.record(forbidden)
+	}
+	open := testutil.ToFloat64(metric.CloudAPICircuitOpen)
+	if err := breaker.allow("DescribeLoadBalancers"); apiErrorCode(err) != API_CIRCUIT_OPEN {
+		t.Fatalf("expect the circuit open, got %v", err)
+	}
+
+	// half open, a single probe goes through after the backoff
+	fakeClock.Step(BREAKER_BACKOFF)
+	if err := breaker.allow("DescribeLoadBalancers"); err != nil {
+		t.Fatalf("expect the probe allowed, got %v", err)
+	}
+	if err := breaker.allow("DescribeLoadBalancers"); apiErrorCode(err) != API_CIRCUIT_OPEN {
+		t.Fatalf("expect the calls failed fast during the probe, got %v", err)
+	}
+	// the probe is rejected, the backoff is doubled
+	breaker.record(forbidden)
+	fakeClock.Step(BREAKER_BACKOFF)
+	if err := breaker.allow("DescribeLoadBalancers"); apiErrorCode(err) != API_CIRCUIT_OPEN {
+		t.Fatalf("expect the circuit open for the doubled backoff, got %v", err)
+	}
+	fakeClock.Step(BREAKER_BACKOFF)
+	if err := breaker.allow("DescribeLoadBalancers"); err != nil {
+		t.Fatalf("expect the probe allowed, got %v", err)
+	}
+
+	// the credentials recovered, the probe closes the circuit
+	breaker.record(nil)
+	if n := testutil.ToFloat64(metric.CloudAPICircuitOpen); n != open-1 {
+		t.Fatalf("expect the open circuit reported closed, got %v", n-open)
+	}
+	for i := 0; i < BREAKER_THRESHOLD-1; i++ {
+		if err := breaker.allow("DescribeLoadBalancers"); err != nil {
+			t.Fatalf("expect the circuit closed, got %v", err)
+		}
+		breaker.record(forbidden)
+	}
+	if err := breaker.allow("DescribeLoadBalancers"); err != nil || breaker.backoff != BREAKER_BACKOFF {
+		t.Fatalf("expect the circuit closed with the backoff reset, got %v, %s", err, breaker.backoff)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+// A sync may create the loadbalancer and fail before its status is written,
+// eg. on tagging, while the retry can not find it since the describe of the
+// api is eventually consistent. The creation carries a ClientToken derived
+// from the service uid and a nonce of the creation, so that the retry gets
+// the loadbalancer created before instead of another one, and the
+// loadbalancer is also found by the uid ownership tag once the name lags.
+// The nonce is kept until the creation succeeds or the loadbalancer is
+// deleted, so that a creation after the deletion, eg. a recreation or the
+// service switched back to type LoadBalancer, does not get the deleted
+// loadbalancer back. The nonce is not kept over a restart of the controller,
+// duplicates left then are told by the sync finding them and by the
+// loadbalancer garbage collector.
+
+// creationNonces the nonce of the creation in flight by service uid
+var creationNonces sync.Map
+
+// loadBalancerClientToken the ClientToken of the loadbalancer created for the
+// service. The retries send the same args and get the same loadbalancer, a
+// recreation with other args, eg. zones, gets a new one. The token is at
+// most 64 characters as the api requires.
+func loadBalancerClientToken(service *v1.Service, args *slb.CreateLoadBalancerArgs) string {
+	opts := *args
+	opts.ClientToken = ""
+	return clientToken(service, opts)
+}
+
+// nlbClientToken the ClientToken of the nlb created for the service, as
+// loadBalancerClientToken
+func nlbClientToken(service *v1.Service, args *CreateNLBArgs) string {
+	opts := *args
+	opts.ClientToken = ""
+	return clientToken(service, opts)
+}
+
+func clientToken(service *v1.Service, args interface{}) string {
+	nonce, _ := creationNonces.LoadOrStore(service.UID, fmt.Sprintf("%016x", rand.Int63()))
+	data, err := json.Marshal(args)
+	if err != nil {
+		// the args are plain values and never fail to marshal
+		return string(service.UID)
+	}
+	return fmt.Sprintf("%s-%s", service.UID, utils.Hash(string(service.UID) + nonce.(string) + string(data))[:16])
+}
+
+// forgetClientToken drop the nonce of the creation once it succeeds or the
+// loadbalancer of the service is deleted, the next creation gets a new token
+func forgetClientToken(service *v1.Service) {
+	creationNonces.Delete(service.UID)
+}
+
+// loadBalancerIds the ids of the loadbalancers
+func loadBalancerIds(lbs []slb.LoadBalancerType) []string {
+	var ids []string
+	for _, lb := range lbs {
+		ids = append(ids, lb.LoadBalancerId)
+	}
+	return ids
+}
+
+// recordDuplicateLoadBalancers warn of more than one loadbalancer owned by the
+// service, only one of them is synced while the others keep being charged.
+func recordDuplicateLoadBalancers(record record.EventRecorder, service *v1.Service, ids []string) {
+	klog.Warningf("alicloud: loadbalancers %s are all owned by service %s/%s",
+		strings.Join(ids, ","), service.Namespace, service.Name)
+	if record == nil {
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"DuplicateLoadBalancer",
+		"Loadbalancers %s are all owned by the service, only one of them is synced. "+
+			"Delete the others once checked, they are still charged",
+		strings.Join(ids, ","),
+	)
+}
+
+// recordDuplicateLoadBalancersFromContext warn of the duplicates found by a sync
+func recordDuplicateLoadBalancersFromContext(ctx context.Context, service *v1.Service, lbs []slb.LoadBalancerType) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+	}
+	recordDuplicateLoadBalancers(record, service, loadBalancerIds(lbs))
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestLoadBalancerClientToken(t *testing.T) {
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{UID: types.UID(serviceUIDNoneExist)}}
+	defer forgetClientToken(svc)
+	args := &slb.CreateLoadBalancerArgs{
+		RegionId:         REGION,
+		LoadBalancerName: GetLoadBalancerName(svc),
+		MasterZoneId:     REGION_A,
+	}
+	token := loadBalancerClientToken(svc, args)
+	if len(token) > 64 || !strings.HasPrefix(token, serviceUIDNoneExist) {
+		t.Fatalf("expect a token of at most 64 characters of the uid, got %s", token)
+	}
+	retry := *args
+	retry.ClientToken = token
+	if got := loadBalancerClientToken(svc, &retry); got != token {
+		t.Errorf("expect the same token for the retry, got %s and %s", token, got)
+	}
+	recreate := *args
+	recreate.MasterZoneId = REGION_B
+	if got := loadBalancerClientToken(svc, &recreate); got == token {
+		t.Errorf("expect another token for the recreation of other zones, got %s", got)
+	}
+	other := &v1.Service{ObjectMeta: metav1.ObjectMeta{UID: types.UID(serviceUIDExist)}}
+	defer forgetClientToken(other)
+	if got := loadBalancerClientToken(other, args); got == token {
+		t.Errorf("expect another token for another service, got %s", got)
+	}
+	forgetClientToken(svc)
+	if got := loadBalancerClientToken(svc, args); got == token {
+		t.Errorf("expect another token for the creation after the deletion, got %s", got)
+	}
+}
+
+// ownedLoadBalancers the ids of the loadbalancers named after the service
+func ownedLoadBalancers(svc *v1.Service) []string {
+	var ids []string
+	LOADBALANCER.loadbalancer.Range(
+		func(key, value interface{}) bool {
+			if value.(slb.LoadBalancerType).LoadBalancerName == GetLoadBalancerName(svc) {
+				ids = append(ids, key.(string))
+			}
+			return true
+		},
+	)
+	return ids
+}
+
+func TestIdempotentCreation(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Retry of a failed creation adopts the loadbalancer created",
+		func(f *FrameWork) error {
+			mock := f.SLBSDK().(*mockClientSLB)
+			defer func() { mock.describeLoadBalancers = nil }()
+			faults := f.SLBFaults()
+			faults.FailFirst("AddTags", 1, func() error {
+				return fmt.Errorf("Aliyun API Error: RequestId: req-1 Status Code: 503 Code: ServiceUnavailable")
+			})
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err == nil {
+				return fmt.Errorf("expect the sync failed on tagging")
+			}
+			// the loadbalancer created is not listed yet
+			mock.describeLoadBalancers = func(args *slb.DescribeLoadBalancersArgs) ([]slb.LoadBalancerType, error) {
+				return nil, nil
+			}
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return fmt.Errorf("expect the retry succeeded, got %s", err.Error())
+			}
+			mock.describeLoadBalancers = nil
+
+			calls := faults.Calls("CreateLoadBalancer")
+			if len(calls) != 2 {
+				return fmt.Errorf("expect the creation retried, got %v", calls)
+			}
+			first := calls[0].Args[0].(*slb.CreateLoadBalancerArgs).ClientToken
+			second := calls[1].Args[0].(*slb.CreateLoadBalancerArgs).ClientToken
+			if first == "" || first != second {
+				return fmt.Errorf("expect the same client token of the retry, got %s and %s", first, second)
+			}
+			if ids := ownedLoadBalancers(f.SVC); len(ids) != 1 {
+				return fmt.Errorf("expect a single loadbalancer of the service, got %v", ids)
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return nil
+		},
+	)
+
+	f.RunCustomized(t, "Loadbalancer found by the uid tag while the name lags",
+		func(f *FrameWork) error {
+			mock := f.SLBSDK().(*mockClientSLB)
+			defer func() { mock.describeLoadBalancers = nil }()
+			mock.describeLoadBalancers = func(args *slb.DescribeLoadBalancersArgs) ([]slb.LoadBalancerType, error) {
+				if args.LoadBalancerName != "" || strings.Contains(args.Tags, TAGKEY) {
+					return nil, nil
+				}
+				return (&mockClientSLB{}).DescribeLoadBalancers(context.Background(), args)
+			}
+			faults := f.SLBFaults()
+			faults.Reset()
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			if calls := faults.Calls("CreateLoadBalancer"); len(calls) != 0 {
+				return fmt.Errorf("expect the loadbalancer adopted, got %v", calls)
+			}
+			if ids := ownedLoadBalancers(f.SVC); len(ids) != 1 {
+				return fmt.Errorf("expect a single loadbalancer of the service, got %v", ids)
+			}
+			return nil
+		},
+	)
+
+	f.RunCustomized(t, "Duplicate loadbalancers warned",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			// a duplicate left by a creation before the client token
+			ids := ownedLoadBalancers(f.SVC)
+			v, _ := LOADBALANCER.loadbalancer.Load(ids[0])
+			duplicate := v.(slb.LoadBalancerType)
+			duplicate.LoadBalancerId = newid()
+			LOADBALANCER.loadbalancer.Store(duplicate.LoadBalancerId, duplicate)
+			tags, _ := LOADBALANCER.tags.Load(ids[0])
+			LOADBALANCER.tags.Store(duplicate.LoadBalancerId, tags)
+
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			for len(recorder.Events) > 0 {
+				if event := <-recorder.Events; strings.Contains(event, "DuplicateLoadBalancer") &&
+					strings.Contains(event, duplicate.LoadBalancerId) {
+					return nil
+				}
+			}
+			return fmt.Errorf("expect the duplicate loadbalancer %s warned", duplicate.LoadBalancerId)
+		},
+	)
+
+	f.RunCustomized(t, "Creation after the deletion sends another token",
+		func(f *FrameWork) error {
+			faults := f.SLBFaults()
+			faults.Reset()
+			// the duplicates are deleted out of band
+			for _, id := range ownedLoadBalancers(f.SVC) {
+				LOADBALANCER.loadbalancer.Delete(id)
+				LOADBALANCER.tags.Delete(id)
+			}
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			if err := f.CloudImpl().EnsureLoadBalancerDeleted(context.Background(), CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			calls := faults.Calls("CreateLoadBalancer")
+			if len(calls) != 2 {
+				return fmt.Errorf("expect the loadbalancer created twice, got %v", calls)
+			}
+			first := calls[0].Args[0].(*slb.CreateLoadBalancerArgs).ClientToken
+			second := calls[1].Args[0].(*slb.CreateLoadBalancerArgs).ClientToken
+			if first == second {
+				return fmt.Errorf("expect another client token after the deletion, got %s", second)
+			}
+			return nil
+		},
+	)
+}
@@ -27,11 +27,12 @@ import (
 	"path/filepath"
 	"time"
 
-	"encoding/base64"
 	"fmt"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
 )
 
 // ROLE_NAME default kubernetes master role name
@@ -48,17 +49,28 @@ type ClientMgr struct {
 	stop <-chan struct{}
 
 	token TokenAuth
+	// assumed role in the target account, nil if all clients call with token
+	assumed *AssumeRoleToken
+	// settoken applies the credentials to the clients, set by Start
+	settoken func(mgr *ClientMgr, token *Token) error
+	refresh  sync.Mutex
 
 	meta         IMetaData
 	routes       *RoutesClient
 	loadbalancer *LoadBalancerClient
+	nlb          *NLBClient
 	privateZone  *PrivateZoneClient
 	instance     *InstanceClient
 }
 
 // NewClientMgr return a new client manager
 func NewClientMgr(key, secret string) (*ClientMgr, error) {
-	m := NewMetaData()
+	return newClientMgrWithMetaData(key, secret, NewMetaData())
+}
+
+// newClientMgrWithMetaData the region and the vpc are retrieved from the
+// metadata, the startup fails once its retries are exhausted
+func newClientMgrWithMetaData(key, secret string, m IMetaData) (*ClientMgr, error) {
 	region, err := m.Region()
 	if err != nil {
 		return nil, fmt.Errorf("can not determin region: %s", err.Error())
@@ -67,7 +79,12 @@ func NewClientMgr(key, secret string) (*ClientMgr, error) {
 	if err != nil {
 		return nil, fmt.Errorf("can not determin vpcid: %s", err.Error())
 	}
+	return newClientMgr(key, secret, region, vpcid, m)
+}
+
+func newClientMgr(key, secret, region, vpcid string, m IMetaData) (*ClientMgr, error) {
 	ecsclient := NewContextedClientINS(key, secret, region)
+	vpcclient := NewContextedClientRoute(key, secret, region)
 	mgr := &ClientMgr{
 		stop: make(<-chan struct{}, 1),
 		meta: m,
@@ -77,49 +94,97 @@ func NewClientMgr(key, secret string) (*ClientMgr, error) {
 		loadbalancer: &LoadBalancerClient{
 			vpcid: vpcid,
 			ins:   ecsclient,
+			vpc:   vpcclient,
 			c:     NewContextedClientSLB(key, secret, region),
 		},
 		privateZone: &PrivateZoneClient{
 			c: NewContextedClientPVTZ(key, secret, "cn-hangzhou"),
 		},
+		nlb: &NLBClient{
+			vpcid: vpcid,
+			c:     NewContextedClientNLB(key, secret, region),
+		},
 		routes: &RoutesClient{
-			client: NewContextedClientRoute(key, secret, region),
+			client: vpcclient,
 			region: region,
 		},
 	}
 
+	endpoints := overriddenEndpoints(region)
+	if err := probeEndpoints(endpoints); err != nil {
+		return nil, err
+	}
+	mgr.withEndpoints(endpoints)
+	mgr.withTransport(newAPITransport())
+
+	mgr.token = newTokenAuth(key, secret, m)
+	mgr.assumed = NewAssumeRoleTokenFromConfig()
+	if mgr.assumed != nil {
+		klog.Infof("alicloud: assume role %s for clients %v", mgr.assumed.RoleArn, mgr.assumed.Clients)
+	}
+	mgr.nlb.clb = mgr.loadbalancer
+	return mgr, nil
+}
+
+// withEndpoints override the endpoints of the clients
+func (mgr *ClientMgr) withEndpoints(endpoints map[string]string) {
+	if endpoint, ok := endpoints[CLIENT_ECS]; ok {
+		mgr.instance.c.(*ContextedClientINS).ecs.WithEndpoint(endpoint)
+	}
+	if endpoint, ok := endpoints[CLIENT_SLB]; ok {
+		mgr.loadbalancer.c.(*ContextedClientSLB).slb.WithEndpoint(endpoint)
+	}
+	if endpoint, ok := endpoints[CLIENT_PVTZ]; ok {
+		mgr.privateZone.c.(*ContextedClientPVTZ).pvtz.WithEndpoint(endpoint)
+	}
+	if endpoint, ok := endpoints[CLIENT_VPC]; ok {
+		mgr.routes.client.(*ContextedClientRoute).ecs.WithEndpoint(endpoint)
+	}
+	if endpoint, ok := endpoints[CLIENT_NLB]; ok {
+		mgr.nlb.c.(*ContextedClientNLB).nlb.WithEndpoint(endpoint)
+	}
+}
+
+// withTransport set the http transport of the clients
+func (mgr *ClientMgr) withTransport(transport http.RoundTripper) {
+	mgr.instance.c.(*ContextedClientINS).ecs.SetTransport(transport)
+	mgr.loadbalancer.c.(*ContextedClientSLB).slb.SetTransport(transport)
+	mgr.privateZone.c.(*ContextedClientPVTZ).pvtz.SetTransport(transport)
+	mgr.routes.client.(*ContextedClientRoute).ecs.SetTransport(transport)
+	mgr.nlb.c.(*ContextedClientNLB).nlb.SetTransport(transport)
+}
+
+// newTokenAuth choose the auth method, rrsa goes first, then the ak and the
+// ramrole of the instance.
+func newTokenAuth(key, secret string, m IMetaData) TokenAuth {
+	if oidc := NewOIDCTokenFromEnv(); oidc != nil {
+		klog.Infof("alicloud: rrsa token mode with role %s", oidc.RoleArn)
+		return oidc
+	}
 	if key == "" || secret == "" {
 		klog.Infof("alicloud: use ramrole token mode without ak.")
-		mgr.token = &RamRoleToken{meta: m}
-	} else {
-		inittoken := &Token{
-			AccessKey:    key,
-			AccessSecret: secret,
-			UID:          cfg.Global.UID,
-		}
-		if inittoken.UID == "" {
-			klog.Infof("alicloud: ak mode to authenticate user. without token and role assume")
-			mgr.token = &AkAuthToken{ak: inittoken}
-		} else {
-			klog.Infof("alicloud: service account auth mode")
-			mgr.token = &ServiceToken{svcak: inittoken}
-		}
+		return NewRamRoleToken(m)
 	}
-	return mgr, nil
+	inittoken := &Token{
+		AccessKey:    key,
+		AccessSecret: secret,
+		UID:          cfg.Global.UID,
+	}
+	if inittoken.UID == "" {
+		klog.Infof("alicloud: ak mode to authenticate user. without token and role assume")
+		return &AkAuthToken{ak: inittoken}
+	}
+	klog.Infof("alicloud: service account auth mode")
+	return &ServiceToken{svcak: inittoken}
 }
 
 func (mgr *ClientMgr) Start(settoken func(mgr *ClientMgr, token *Token) error) error {
+	mgr.settoken = settoken
 	initialized := false
 	tokenfunc := func() {
 		// refresh client token periodically
-		token, err := mgr.token.NextToken()
-		if err != nil {
-			klog.Errorf("token retrieve: %s", err.Error())
-			return
-		}
-		err = settoken(mgr, token)
-		if err != nil {
-			klog.Errorf("set token: %s", err.Error())
+		if err := mgr.Refresh(); err != nil {
+			klog.Errorf("%s", err.Error())
 			return
 		}
 		initialized = true
@@ -130,6 +195,30 @@ func (mgr *ClientMgr) Start(settoken func(mgr *ClientMgr, token *Token) error) e
 		time.Duration(TOKEN_RESYNC_PERIOD),
 		mgr.stop,
 	)
+	if ramrole, ok := mgr.token.(*RamRoleToken); ok {
+		// renew the sts token before it expires instead of on the resync
+		go wait.Until(
+			func() {
+				if !ramrole.Due() {
+					return
+				}
+				if err := mgr.Refresh(); err != nil {
+					klog.Errorf("%s", err.Error())
+				}
+			},
+			RAMROLE_CHECK_PERIOD,
+			mgr.stop,
+		)
+	}
+	if _, ok := mgr.token.(*AkAuthToken); ok && CloudConfigFile != "" {
+		// pick up the rotated AccessKey without waiting for the resync
+		watcher := newCredentialWatcher(CloudConfigFile)
+		go wait.Until(
+			func() { mgr.reloadCredentials(watcher) },
+			CREDENTIAL_WATCH_PERIOD,
+			mgr.stop,
+		)
+	}
 	return wait.ExponentialBackoff(
 		wait.Backoff{
 			Steps:    7,
@@ -144,28 +233,96 @@ func (mgr *ClientMgr) Start(settoken func(mgr *ClientMgr, token *Token) error) e
 	)
 }
 
+// Refresh retrieve the next token and apply it to the clients
+func (mgr *ClientMgr) Refresh() error {
+	mgr.refresh.Lock()
+	defer mgr.refresh.Unlock()
+	if mgr.settoken == nil {
+		return fmt.Errorf("token refresh: client manager not started")
+	}
+	token, err := mgr.token.NextToken()
+	if err != nil {
+		return fmt.Errorf("token retrieve: %s", err.Error())
+	}
+	if err := mgr.settoken(mgr, token); err != nil {
+		return fmt.Errorf("set token: %s", err.Error())
+	}
+	return nil
+}
+
+// RefreshExpired refresh the token right away when a call failed as the
+// token expired, the assumed role is assumed again.
+func (mgr *ClientMgr) RefreshExpired(err error) bool {
+	if !isTokenExpiredError(err) {
+		return false
+	}
+	klog.Warningf("alicloud: token expired, refresh: %s", err.Error())
+	if mgr.assumed != nil {
+		mgr.assumed.Invalidate()
+	}
+	if ramrole, ok := mgr.token.(*RamRoleToken); ok {
+		ramrole.Invalidate()
+	}
+	if err := mgr.Refresh(); err != nil {
+		klog.Errorf("%s", err.Error())
+		return false
+	}
+	return true
+}
+
+// tokens the token of each client, the clients selected call with the
+// assumed role.
+func (mgr *ClientMgr) tokens(token *Token) (map[string]*Token, error) {
+	tokens := map[string]*Token{}
+	assumed := token
+	if mgr.assumed != nil {
+		var err error
+		assumed, err = mgr.assumed.Assume(token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, client := range []string{CLIENT_ECS, CLIENT_SLB, CLIENT_PVTZ, CLIENT_VPC, CLIENT_NLB} {
+		tokens[client] = token
+		if mgr.assumed != nil && mgr.assumed.Assumes(client) {
+			tokens[client] = assumed
+		}
+	}
+	return tokens, nil
+}
+
 func RefreshToken(mgr *ClientMgr, token *Token) error {
+	tokens, err := mgr.tokens(token)
+	if err != nil {
+		return err
+	}
 	ecsclient := mgr.instance.c.(*ContextedClientINS)
 	slbclient := mgr.loadbalancer.c.(*ContextedClientSLB)
 	pvtzclient := mgr.privateZone.c.(*ContextedClientPVTZ)
 	vpcclient := mgr.routes.client.(*ContextedClientRoute)
-	ecsclient.ecs.WithSecurityToken(token.Token).
-		WithAccessKeyId(token.AccessKey).
-		WithAccessKeySecret(token.AccessSecret)
-	slbclient.slb.WithSecurityToken(token.Token).
-		WithAccessKeyId(token.AccessKey).
-		WithAccessKeySecret(token.AccessSecret)
-	pvtzclient.pvtz.WithSecurityToken(token.Token).
-		WithAccessKeyId(token.AccessKey).
-		WithAccessKeySecret(token.AccessSecret)
-	vpcclient.ecs.WithSecurityToken(token.Token).
-		WithAccessKeyId(token.AccessKey).
-		WithAccessKeySecret(token.AccessSecret)
-
-	ecsclient.ecs.SetUserAgent(KUBERNETES_ALICLOUD_IDENTITY)
-	slbclient.slb.SetUserAgent(KUBERNETES_ALICLOUD_IDENTITY)
-	pvtzclient.pvtz.SetUserAgent(KUBERNETES_ALICLOUD_IDENTITY)
-	vpcclient.ecs.SetUserAgent(KUBERNETES_ALICLOUD_IDENTITY)
+	nlbclient := mgr.nlb.c.(*ContextedClientNLB)
+	ecsclient.ecs.WithSecurityToken(tokens[CLIENT_ECS].Token).
+		WithAccessKeyId(tokens[CLIENT_ECS].AccessKey).
+		WithAccessKeySecret(tokens[CLIENT_ECS].AccessSecret)
+	slbclient.slb.WithSecurityToken(tokens[CLIENT_SLB].Token).
+		WithAccessKeyId(tokens[CLIENT_SLB].AccessKey).
+		WithAccessKeySecret(tokens[CLIENT_SLB].AccessSecret)
+	pvtzclient.pvtz.WithSecurityToken(tokens[CLIENT_PVTZ].Token).
+		WithAccessKeyId(tokens[CLIENT_PVTZ].AccessKey).
+		WithAccessKeySecret(tokens[CLIENT_PVTZ].AccessSecret)
+	vpcclient.ecs.WithSecurityToken(tokens[CLIENT_VPC].Token).
+		WithAccessKeyId(tokens[CLIENT_VPC].AccessKey).
+		WithAccessKeySecret(tokens[CLIENT_VPC].AccessSecret)
+	nlbclient.nlb.WithSecurityToken(tokens[CLIENT_NLB].Token).
+		WithAccessKeyId(tokens[CLIENT_NLB].AccessKey).
+		WithAccessKeySecret(tokens[CLIENT_NLB].AccessSecret)
+
+	ua := userAgent()
+	ecsclient.ecs.SetUserAgent(ua)
+	slbclient.slb.SetUserAgent(ua)
+	pvtzclient.pvtz.SetUserAgent(ua)
+	vpcclient.ecs.SetUserAgent(ua)
+	nlbclient.nlb.SetUserAgent(ua)
 	return nil
 }
 
@@ -178,6 +335,14 @@ func (mgr *ClientMgr) Routes() *RoutesClient { return mgr.routes }
 // LoadBalancers return loadbalancer client
 func (mgr *ClientMgr) LoadBalancers() *LoadBalancerClient { return mgr.loadbalancer }
 
+// NLBs return network loadbalancer client
+func (mgr *ClientMgr) NLBs() *NLBClient { return mgr.nlb }
+
+// Certificates return server certificate client
+func (mgr *ClientMgr) Certificates() *CertificateClient {
+	return &CertificateClient{c: mgr.loadbalancer.c}
+}
+
 // PrivateZones return PrivateZones client
 func (mgr *ClientMgr) PrivateZones() *PrivateZoneClient { return mgr.privateZone }
 
@@ -214,27 +379,6 @@ func (f *AkAuthToken) NextToken() (*Token, error) {
 	return f.ak, nil
 }
 
-type RamRoleToken struct {
-	meta IMetaData
-}
-
-func (f *RamRoleToken) NextToken() (*Token, error) {
-	roleName, err := f.meta.RoleName()
-	if err != nil {
-		return nil, fmt.Errorf("role name: %s", err.Error())
-	}
-	// use instance ram file way.
-	role, err := f.meta.RamRoleToken(roleName)
-	if err != nil {
-		return nil, fmt.Errorf("ramrole token retrieve: %s", err.Error())
-	}
-	return &Token{
-		AccessKey:    role.AccessKeyId,
-		AccessSecret: role.AccessKeySecret,
-		Token:        role.SecurityToken,
-	}, nil
-}
-
 // ServiceToken is an implemention of service account auth
 type ServiceToken struct {
 	svcak    *Token
@@ -280,21 +424,14 @@ func loadAK() (string, string, error) {
 		if err != nil {
 			return keyId, keySecret, fmt.Errorf("read cloud config file error: %s", err.Error())
 		}
+		// validated before cfg is updated, the file may be written partially
+		keyId, keySecret, err = parseAK(config)
+		if err != nil {
+			return keyId, keySecret, err
+		}
 		if err := yaml.Unmarshal(config, &cfg); err != nil {
 			return keyId, keySecret, fmt.Errorf("unmarshal config error: %s", err.Error())
 		}
-		if cfg.Global.AccessKeyID != "" && cfg.Global.AccessKeySecret != "" {
-			key, err := base64.StdEncoding.DecodeString(cfg.Global.AccessKeyID)
-			if err != nil {
-				return keyId, keySecret, err
-			}
-			keyId = string(key)
-			secret, err := base64.StdEncoding.DecodeString(cfg.Global.AccessKeySecret)
-			if err != nil {
-				return keyId, keySecret, err
-			}
-			keySecret = string(secret)
-		}
 	}
 	if keyId == "" || keySecret == "" {
 		klog.V(2).Infof("LoadAK: cloud config does not have keyId or keySecret. try environment ACCESS_KEY_ID ACCESS_KEY_SECRET")
@@ -329,14 +466,14 @@ type IMetaData interface {
 	VswitchID() (string, error)
 }
 
-// NewMetaData return new metadata
+// NewMetaData return new metadata, the immutable values cached
 func NewMetaData() IMetaData {
 	if cfg.Global.VpcID != "" &&
 		cfg.Global.VswitchID != "" {
 		klog.V(2).Infof("use mocked metadata server.")
-		return &fakeMetaData{base: metadata.NewMetaData(nil)}
+		return NewCachedMetaData(&fakeMetaData{base: metadata.NewMetaData(nil)})
 	}
-	return metadata.NewMetaData(nil)
+	return NewCachedMetaData(metadata.NewMetaData(nil))
 }
 
 type fakeMetaData struct {
@@ -2,11 +2,13 @@ package alicloud
 
 import (
 	"context"
+	"fmt"
 	"github.com/denverdino/aliyungo/common"
 	"github.com/denverdino/aliyungo/ecs"
 	"github.com/denverdino/aliyungo/pvtz"
 	"github.com/denverdino/aliyungo/slb"
 	"k8s.io/client-go/kubernetes"
+	"time"
 )
 
 type BaseClient struct {
@@ -16,9 +18,12 @@ type BaseClient struct {
 func (b *BaseClient) SetCoreClient(core kubernetes.Interface) { b.core = core }
 
 func NewContextedClientSLB(key, secret, region string) *ContextedClientSLB {
+	client := slb.NewSLBClientWithSecurityToken4RegionalDomain(key, secret, "", common.Region(region))
+	client.SetUserAgent(userAgent())
 	return &ContextedClientSLB{
 		BaseClient: BaseClient{},
-		slb:        slb.NewSLBClientWithSecurityToken4RegionalDomain(key, secret, "", common.Region(region)),
+		region:     common.Region(region),
+		slb:        client,
 	}
 }
 
@@ -26,20 +31,65 @@ type ContextedClientSLB struct {
 	BaseClient
 	// base slb client
 	slb *slb.Client
+	// region is used for the raw api calls which are not wrapped by aliyungo
+	region common.Region
 }
 
 func (c *ContextedClientSLB) DescribeLoadBalancers(
 	ctx context.Context,
 	args *slb.DescribeLoadBalancersArgs,
 ) (loadBalancers []slb.LoadBalancerType, err error) {
-	return c.slb.DescribeLoadBalancers(args)
+	defer interceptAPI(CLIENT_SLB, "DescribeLoadBalancers", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeLoadBalancers", func() error {
+		loadBalancers, err = c.slb.DescribeLoadBalancers(args)
+		return err
+	})
+	return loadBalancers, err
+}
+
+func (c *ContextedClientSLB) DescribeLoadBalancersPage(
+	ctx context.Context,
+	args *DescribeLoadBalancersPageArgs,
+) (loadBalancers []slb.LoadBalancerType, pagination *common.PaginationResult, err error) {
+	defer interceptAPI(CLIENT_SLB, "DescribeLoadBalancers", time.Now(), &err)
+	response := &DescribeLoadBalancersPageResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeLoadBalancers", func() error {
+		return c.slb.Invoke("DescribeLoadBalancers", args, response)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return response.LoadBalancers.LoadBalancer, &response.PaginationResult, nil
 }
 
 func (c *ContextedClientSLB) DescribeLoadBalancerAttribute(
 	ctx context.Context,
 	loadBalancerId string,
 ) (loadBalancer *slb.LoadBalancerType, err error) {
-	return c.slb.DescribeLoadBalancerAttribute(loadBalancerId)
+	defer interceptAPI(CLIENT_SLB, "DescribeLoadBalancerAttribute", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeLoadBalancerAttribute", func() error {
+		loadBalancer, err = c.slb.DescribeLoadBalancerAttribute(loadBalancerId)
+		return err
+	})
+	return loadBalancer, err
+}
+
+func (c *ContextedClientSLB) DescribeZones(
+	ctx context.Context,
+	args *DescribeZonesArgs,
+) (zones []ZoneType, err error) {
+	defer interceptAPI(CLIENT_SLB, "DescribeZones", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response := &DescribeZonesResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeZones", func() error {
+		return c.slb.Invoke("DescribeZones", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Zones.Zone, nil
 }
 
 func (c *ContextedClientSLB) DescribeLoadBalancerHTTPSListenerAttribute(
@@ -47,7 +97,12 @@ func (c *ContextedClientSLB) DescribeLoadBalancerHTTPSListenerAttribute(
 	loadBalancerId string,
 	port int,
 ) (response *slb.DescribeLoadBalancerHTTPSListenerAttributeResponse, err error) {
-	return c.slb.DescribeLoadBalancerHTTPSListenerAttribute(loadBalancerId, port)
+	defer interceptAPI(CLIENT_SLB, "DescribeLoadBalancerHTTPSListenerAttribute", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeLoadBalancerHTTPSListenerAttribute", func() error {
+		response, err = c.slb.DescribeLoadBalancerHTTPSListenerAttribute(loadBalancerId, port)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientSLB) DescribeLoadBalancerTCPListenerAttribute(
@@ -55,7 +110,12 @@ func (c *ContextedClientSLB) DescribeLoadBalancerTCPListenerAttribute(
 	loadBalancerId string,
 	port int,
 ) (response *slb.DescribeLoadBalancerTCPListenerAttributeResponse, err error) {
-	return c.slb.DescribeLoadBalancerTCPListenerAttribute(loadBalancerId, port)
+	defer interceptAPI(CLIENT_SLB, "DescribeLoadBalancerTCPListenerAttribute", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeLoadBalancerTCPListenerAttribute", func() error {
+		response, err = c.slb.DescribeLoadBalancerTCPListenerAttribute(loadBalancerId, port)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientSLB) DescribeLoadBalancerUDPListenerAttribute(
@@ -63,7 +123,12 @@ func (c *ContextedClientSLB) DescribeLoadBalancerUDPListenerAttribute(
 	loadBalancerId string,
 	port int,
 ) (response *slb.DescribeLoadBalancerUDPListenerAttributeResponse, err error) {
-	return c.slb.DescribeLoadBalancerUDPListenerAttribute(loadBalancerId, port)
+	defer interceptAPI(CLIENT_SLB, "DescribeLoadBalancerUDPListenerAttribute", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeLoadBalancerUDPListenerAttribute", func() error {
+		response, err = c.slb.DescribeLoadBalancerUDPListenerAttribute(loadBalancerId, port)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientSLB) DescribeLoadBalancerHTTPListenerAttribute(
@@ -71,69 +136,154 @@ func (c *ContextedClientSLB) DescribeLoadBalancerHTTPListenerAttribute(
 	loadBalancerId string,
 	port int,
 ) (response *slb.DescribeLoadBalancerHTTPListenerAttributeResponse, err error) {
-	return c.slb.DescribeLoadBalancerHTTPListenerAttribute(loadBalancerId, port)
+	defer interceptAPI(CLIENT_SLB, "DescribeLoadBalancerHTTPListenerAttribute", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeLoadBalancerHTTPListenerAttribute", func() error {
+		response, err = c.slb.DescribeLoadBalancerHTTPListenerAttribute(loadBalancerId, port)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientSLB) DescribeTags(ctx context.Context, args *slb.DescribeTagsArgs) (tags []slb.TagItemType, pagination *common.PaginationResult, err error) {
-	return c.slb.DescribeTags(args)
+	defer interceptAPI(CLIENT_SLB, "DescribeTags", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeTags", func() error {
+		tags, pagination, err = c.slb.DescribeTags(args)
+		return err
+	})
+	return tags, pagination, err
 }
 
 func (c *ContextedClientSLB) DescribeVServerGroups(
 	ctx context.Context,
 	args *slb.DescribeVServerGroupsArgs,
 ) (response *slb.DescribeVServerGroupsResponse, err error) {
-	return c.slb.DescribeVServerGroups(args)
+	defer interceptAPI(CLIENT_SLB, "DescribeVServerGroups", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeVServerGroups", func() error {
+		response, err = c.slb.DescribeVServerGroups(args)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientSLB) DescribeVServerGroupAttribute(
 	ctx context.Context,
 	args *slb.DescribeVServerGroupAttributeArgs,
 ) (response *slb.DescribeVServerGroupAttributeResponse, err error) {
-	return c.slb.DescribeVServerGroupAttribute(args)
+	defer interceptAPI(CLIENT_SLB, "DescribeVServerGroupAttribute", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeVServerGroupAttribute", func() error {
+		response, err = c.slb.DescribeVServerGroupAttribute(args)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientSLB) CreateLoadBalancer(
 	ctx context.Context,
 	args *slb.CreateLoadBalancerArgs,
 ) (response *slb.CreateLoadBalancerResponse, err error) {
-	return c.slb.CreateLoadBalancer(args)
+	defer interceptAPI(CLIENT_SLB, "CreateLoadBalancer", time.Now(), &err)
+	err = retryCreationAPI(ctx, c.slb.AccessKeyId, "CreateLoadBalancer", args.ClientToken, func() error {
+		response, err = c.slb.CreateLoadBalancer(args)
+		return err
+	})
+	return response, err
+}
+
+func (c *ContextedClientSLB) CreateLoadBalancerWithAddress(
+	ctx context.Context,
+	args *CreateLoadBalancerWithAddressArgs,
+) (response *slb.CreateLoadBalancerResponse, err error) {
+	defer interceptAPI(CLIENT_SLB, "CreateLoadBalancerWithAddress", time.Now(), &err)
+	response = &slb.CreateLoadBalancerResponse{}
+	err = retryCreationAPI(ctx, c.slb.AccessKeyId, "CreateLoadBalancerWithAddress", args.ClientToken, func() error {
+		return c.slb.Invoke("CreateLoadBalancer", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
 }
 
 func (c *ContextedClientSLB) SetLoadBalancerModificationProtection(
 	ctx context.Context,
 	args *slb.SetLoadBalancerModificationProtectionArgs,
 ) (err error) {
-	return c.slb.SetLoadBalancerModificationProtection(args)
+	defer interceptAPI(CLIENT_SLB, "SetLoadBalancerModificationProtection", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "SetLoadBalancerModificationProtection", func() error {
+		return c.slb.SetLoadBalancerModificationProtection(args)
+	})
 }
 
 func (c *ContextedClientSLB) DeleteLoadBalancer(ctx context.Context, loadBalancerId string) (err error) {
-
-	return c.slb.DeleteLoadBalancer(loadBalancerId)
+	defer interceptAPI(CLIENT_SLB, "DeleteLoadBalancer", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "DeleteLoadBalancer", func() error {
+		return c.slb.DeleteLoadBalancer(loadBalancerId)
+	})
 }
 
 func (c *ContextedClientSLB) SetLoadBalancerDeleteProtection(ctx context.Context, args *slb.SetLoadBalancerDeleteProtectionArgs) (err error) {
-	return c.slb.SetLoadBalancerDeleteProtection(args)
+	defer interceptAPI(CLIENT_SLB, "SetLoadBalancerDeleteProtection", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "SetLoadBalancerDeleteProtection", func() error {
+		return c.slb.SetLoadBalancerDeleteProtection(args)
+	})
 }
 
 
 func (c *ContextedClientSLB) SetLoadBalancerName(ctx context.Context, loadBalancerId string, loadBalancerName string) (err error) {
-
-	return c.slb.SetLoadBalancerName(loadBalancerId, loadBalancerName)
+	defer interceptAPI(CLIENT_SLB, "SetLoadBalancerName", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "SetLoadBalancerName", func() error {
+		return c.slb.SetLoadBalancerName(loadBalancerId, loadBalancerName)
+	})
 }
 
 func (c *ContextedClientSLB) ModifyLoadBalancerInstanceSpec(
 	ctx context.Context,
 	args *slb.ModifyLoadBalancerInstanceSpecArgs,
 ) (err error) {
-
-	return c.slb.ModifyLoadBalancerInstanceSpec(args)
+	defer interceptAPI(CLIENT_SLB, "ModifyLoadBalancerInstanceSpec", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "ModifyLoadBalancerInstanceSpec", func() error {
+		return c.slb.ModifyLoadBalancerInstanceSpec(args)
+	})
 }
 func (c *ContextedClientSLB) ModifyLoadBalancerInternetSpec(
 	ctx context.Context,
 	args *slb.ModifyLoadBalancerInternetSpecArgs,
 ) (err error) {
+	defer interceptAPI(CLIENT_SLB, "ModifyLoadBalancerInternetSpec", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "ModifyLoadBalancerInternetSpec", func() error {
+		return c.slb.ModifyLoadBalancerInternetSpec(args)
+	})
+}
+
+func (c *ContextedClientSLB) DescribeLoadBalancerInstanceChargeType(
+	ctx context.Context,
+	args *DescribeLoadBalancerInstanceChargeTypeArgs,
+) (response *DescribeLoadBalancerInstanceChargeTypeResponse, err error) {
+	defer interceptAPI(CLIENT_SLB, "DescribeLoadBalancerInstanceChargeType", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response = &DescribeLoadBalancerInstanceChargeTypeResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeLoadBalancerInstanceChargeType", func() error {
+		return c.slb.Invoke("DescribeLoadBalancerAttribute", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
 
-	return c.slb.ModifyLoadBalancerInternetSpec(args)
+func (c *ContextedClientSLB) ModifyLoadBalancerInstanceChargeType(
+	ctx context.Context,
+	args *ModifyLoadBalancerInstanceChargeTypeArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_SLB, "ModifyLoadBalancerInstanceChargeType", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.slb.AccessKeyId, "ModifyLoadBalancerInstanceChargeType", func() error {
+		return c.slb.Invoke("ModifyLoadBalancerInstanceChargeType", args, &common.Response{})
+	})
 }
 
 func (c *ContextedClientSLB) RemoveBackendServers(
@@ -141,140 +291,535 @@ func (c *ContextedClientSLB) RemoveBackendServers(
 	loadBalancerId string,
 	backendServers []slb.BackendServerType,
 ) (result []slb.BackendServerType, err error) {
-
-	return c.slb.RemoveBackendServers(loadBalancerId, backendServers)
+	defer interceptAPI(CLIENT_SLB, "RemoveBackendServers", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "RemoveBackendServers", func() error {
+		result, err = c.slb.RemoveBackendServers(loadBalancerId, backendServers)
+		return err
+	})
+	return result, err
 }
 
 func (c *ContextedClientSLB) AddBackendServers(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) (result []slb.BackendServerType, err error) {
-
-	return c.slb.AddBackendServers(loadBalancerId, backendServers)
+	defer interceptAPI(CLIENT_SLB, "AddBackendServers", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "AddBackendServers", func() error {
+		result, err = c.slb.AddBackendServers(loadBalancerId, backendServers)
+		return err
+	})
+	return result, err
 }
 
 func (c *ContextedClientSLB) StopLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error) {
-	return c.slb.StopLoadBalancerListener(loadBalancerId, port)
+	defer interceptAPI(CLIENT_SLB, "StopLoadBalancerListener", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "StopLoadBalancerListener", func() error {
+		return c.slb.StopLoadBalancerListener(loadBalancerId, port)
+	})
 }
 
 func (c *ContextedClientSLB) StartLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error) {
-	return c.slb.StartLoadBalancerListener(loadBalancerId, port)
+	defer interceptAPI(CLIENT_SLB, "StartLoadBalancerListener", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "StartLoadBalancerListener", func() error {
+		return c.slb.StartLoadBalancerListener(loadBalancerId, port)
+	})
 }
 
 func (c *ContextedClientSLB) CreateLoadBalancerTCPListener(
 	ctx context.Context,
 	args *slb.CreateLoadBalancerTCPListenerArgs,
 ) (err error) {
-	return c.slb.CreateLoadBalancerTCPListener(args)
+	defer interceptAPI(CLIENT_SLB, "CreateLoadBalancerTCPListener", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "CreateLoadBalancerTCPListener", func() error {
+		return c.slb.CreateLoadBalancerTCPListener(args)
+	})
 }
 
 func (c *ContextedClientSLB) CreateLoadBalancerUDPListener(
 	ctx context.Context,
 	args *slb.CreateLoadBalancerUDPListenerArgs,
 ) (err error) {
-	return c.slb.CreateLoadBalancerUDPListener(args)
+	defer interceptAPI(CLIENT_SLB, "CreateLoadBalancerUDPListener", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "CreateLoadBalancerUDPListener", func() error {
+		return c.slb.CreateLoadBalancerUDPListener(args)
+	})
 }
 
 func (c *ContextedClientSLB) DeleteLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error) {
-
-	return c.slb.DeleteLoadBalancerListener(loadBalancerId, port)
+	defer interceptAPI(CLIENT_SLB, "DeleteLoadBalancerListener", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "DeleteLoadBalancerListener", func() error {
+		return c.slb.DeleteLoadBalancerListener(loadBalancerId, port)
+	})
 }
 
 func (c *ContextedClientSLB) CreateLoadBalancerHTTPSListener(
 	ctx context.Context,
 	args *slb.CreateLoadBalancerHTTPSListenerArgs,
 ) (err error) {
-	return c.slb.CreateLoadBalancerHTTPSListener(args)
+	defer interceptAPI(CLIENT_SLB, "CreateLoadBalancerHTTPSListener", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "CreateLoadBalancerHTTPSListener", func() error {
+		return c.slb.CreateLoadBalancerHTTPSListener(args)
+	})
 }
 
 func (c *ContextedClientSLB) CreateLoadBalancerHTTPListener(
 	ctx context.Context,
 	args *slb.CreateLoadBalancerHTTPListenerArgs,
 ) (err error) {
-	return c.slb.CreateLoadBalancerHTTPListener(args)
+	defer interceptAPI(CLIENT_SLB, "CreateLoadBalancerHTTPListener", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "CreateLoadBalancerHTTPListener", func() error {
+		return c.slb.CreateLoadBalancerHTTPListener(args)
+	})
 }
 
 func (c *ContextedClientSLB) SetLoadBalancerHTTPListenerAttribute(
 	ctx context.Context,
 	args *slb.SetLoadBalancerHTTPListenerAttributeArgs,
 ) (err error) {
-	return c.slb.SetLoadBalancerHTTPListenerAttribute(args)
+	defer interceptAPI(CLIENT_SLB, "SetLoadBalancerHTTPListenerAttribute", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "SetLoadBalancerHTTPListenerAttribute", func() error {
+		return c.slb.SetLoadBalancerHTTPListenerAttribute(args)
+	})
 }
 
 func (c *ContextedClientSLB) SetLoadBalancerHTTPSListenerAttribute(
 	ctx context.Context,
 	args *slb.SetLoadBalancerHTTPSListenerAttributeArgs,
 ) (err error) {
-	return c.slb.SetLoadBalancerHTTPSListenerAttribute(args)
+	defer interceptAPI(CLIENT_SLB, "SetLoadBalancerHTTPSListenerAttribute", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "SetLoadBalancerHTTPSListenerAttribute", func() error {
+		return c.slb.SetLoadBalancerHTTPSListenerAttribute(args)
+	})
 }
 
 func (c *ContextedClientSLB) SetLoadBalancerTCPListenerAttribute(
 	ctx context.Context,
 	args *slb.SetLoadBalancerTCPListenerAttributeArgs,
 ) (err error) {
-	return c.slb.SetLoadBalancerTCPListenerAttribute(args)
+	defer interceptAPI(CLIENT_SLB, "SetLoadBalancerTCPListenerAttribute", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "SetLoadBalancerTCPListenerAttribute", func() error {
+		return c.slb.SetLoadBalancerTCPListenerAttribute(args)
+	})
 }
 
 func (c *ContextedClientSLB) SetLoadBalancerUDPListenerAttribute(
 	ctx context.Context,
 	args *slb.SetLoadBalancerUDPListenerAttributeArgs,
 ) (err error) {
-	return c.slb.SetLoadBalancerUDPListenerAttribute(args)
+	defer interceptAPI(CLIENT_SLB, "SetLoadBalancerUDPListenerAttribute", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "SetLoadBalancerUDPListenerAttribute", func() error {
+		return c.slb.SetLoadBalancerUDPListenerAttribute(args)
+	})
 }
 
-func (c *ContextedClientSLB) RemoveTags(ctx context.Context, args *slb.RemoveTagsArgs) error {
-	return c.slb.RemoveTags(args)
+func (c *ContextedClientSLB) RemoveTags(ctx context.Context, args *slb.RemoveTagsArgs) (err error) {
+	defer interceptAPI(CLIENT_SLB, "RemoveTags", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "RemoveTags", func() error {
+		return c.slb.RemoveTags(args)
+	})
 }
 
-func (c *ContextedClientSLB) AddTags(ctx context.Context, args *slb.AddTagsArgs) error {
-	return c.slb.AddTags(args)
+func (c *ContextedClientSLB) AddTags(ctx context.Context, args *slb.AddTagsArgs) (err error) {
+	defer interceptAPI(CLIENT_SLB, "AddTags", time.Now(), &err)
+	return retryAPI(ctx, c.slb.AccessKeyId, "AddTags", func() error {
+		return c.slb.AddTags(args)
+	})
 }
 
 func (c *ContextedClientSLB) CreateVServerGroup(
 	ctx context.Context,
 	args *slb.CreateVServerGroupArgs,
 ) (response *slb.CreateVServerGroupResponse, err error) {
-	return c.slb.CreateVServerGroup(args)
+	defer interceptAPI(CLIENT_SLB, "CreateVServerGroup", time.Now(), &err)
+	err = retryCreationAPI(ctx, c.slb.AccessKeyId, "CreateVServerGroup", "", func() error {
+		response, err = c.slb.CreateVServerGroup(args)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientSLB) DeleteVServerGroup(
 	ctx context.Context,
 	args *slb.DeleteVServerGroupArgs,
 ) (response *slb.DeleteVServerGroupResponse, err error) {
-	return c.slb.DeleteVServerGroup(args)
+	defer interceptAPI(CLIENT_SLB, "DeleteVServerGroup", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DeleteVServerGroup", func() error {
+		response, err = c.slb.DeleteVServerGroup(args)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientSLB) SetVServerGroupAttribute(
 	ctx context.Context,
 	args *slb.SetVServerGroupAttributeArgs,
 ) (response *slb.SetVServerGroupAttributeResponse, err error) {
-	return c.slb.SetVServerGroupAttribute(args)
+	defer interceptAPI(CLIENT_SLB, "SetVServerGroupAttribute", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "SetVServerGroupAttribute", func() error {
+		response, err = c.slb.SetVServerGroupAttribute(args)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientSLB) ModifyVServerGroupBackendServers(
 	ctx context.Context,
 	args *slb.ModifyVServerGroupBackendServersArgs,
 ) (response *slb.ModifyVServerGroupBackendServersResponse, err error) {
-	return c.slb.ModifyVServerGroupBackendServers(args)
+	defer interceptAPI(CLIENT_SLB, "ModifyVServerGroupBackendServers", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "ModifyVServerGroupBackendServers", func() error {
+		response, err = c.slb.ModifyVServerGroupBackendServers(args)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientSLB) AddVServerGroupBackendServers(
 	ctx context.Context,
 	args *slb.AddVServerGroupBackendServersArgs,
 ) (response *slb.AddVServerGroupBackendServersResponse, err error) {
-	return c.slb.AddVServerGroupBackendServers(args)
+	defer interceptAPI(CLIENT_SLB, "AddVServerGroupBackendServers", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "AddVServerGroupBackendServers", func() error {
+		response, err = c.slb.AddVServerGroupBackendServers(args)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientSLB) RemoveVServerGroupBackendServers(
 	ctx context.Context,
 	args *slb.RemoveVServerGroupBackendServersArgs,
 ) (response *slb.RemoveVServerGroupBackendServersResponse, err error) {
-	return c.slb.RemoveVServerGroupBackendServers(args)
+	defer interceptAPI(CLIENT_SLB, "RemoveVServerGroupBackendServers", time.Now(), &err)
+	err = retryAPI(ctx, c.slb.AccessKeyId, "RemoveVServerGroupBackendServers", func() error {
+		response, err = c.slb.RemoveVServerGroupBackendServers(args)
+		return err
+	})
+	return response, err
+}
+
+func (c *ContextedClientSLB) DescribeHealthStatus(
+	ctx context.Context,
+	args *DescribeHealthStatusArgs,
+) (response *DescribeHealthStatusResponse, err error) {
+	defer interceptAPI(CLIENT_SLB, "DescribeHealthStatus", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response = &DescribeHealthStatusResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeHealthStatus", func() error {
+		return c.slb.Invoke("DescribeHealthStatus", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *ContextedClientSLB) DescribeServerCertificates(
+	ctx context.Context,
+	args *DescribeServerCertificatesArgs,
+) (certificates []ServerCertificateType, err error) {
+	defer interceptAPI(CLIENT_SLB, "DescribeServerCertificates", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response := &DescribeServerCertificatesResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeServerCertificates", func() error {
+		return c.slb.Invoke("DescribeServerCertificates", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.ServerCertificates.ServerCertificate, nil
+}
+
+func (c *ContextedClientSLB) UploadServerCertificate(
+	ctx context.Context,
+	args *UploadServerCertificateArgs,
+) (response *UploadServerCertificateResponse, err error) {
+	defer interceptAPI(CLIENT_SLB, "UploadServerCertificate", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response = &UploadServerCertificateResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "UploadServerCertificate", func() error {
+		return c.slb.Invoke("UploadServerCertificate", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *ContextedClientSLB) DeleteServerCertificate(
+	ctx context.Context,
+	args *DeleteServerCertificateArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_SLB, "DeleteServerCertificate", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.slb.AccessKeyId, "DeleteServerCertificate", func() error {
+		return c.slb.Invoke("DeleteServerCertificate", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientSLB) DescribeDomainExtensions(
+	ctx context.Context,
+	args *DescribeDomainExtensionsArgs,
+) (extensions []DomainExtensionType, err error) {
+	defer interceptAPI(CLIENT_SLB, "DescribeDomainExtensions", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response := &DescribeDomainExtensionsResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeDomainExtensions", func() error {
+		return c.slb.Invoke("DescribeDomainExtensions", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.DomainExtensions.DomainExtension, nil
+}
+
+func (c *ContextedClientSLB) CreateDomainExtension(
+	ctx context.Context,
+	args *CreateDomainExtensionArgs,
+) (response *CreateDomainExtensionResponse, err error) {
+	defer interceptAPI(CLIENT_SLB, "CreateDomainExtension", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response = &CreateDomainExtensionResponse{}
+	err = retryCreationAPI(ctx, c.slb.AccessKeyId, "CreateDomainExtension", "", func() error {
+		return c.slb.Invoke("CreateDomainExtension", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *ContextedClientSLB) DeleteDomainExtension(
+	ctx context.Context,
+	args *DeleteDomainExtensionArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_SLB, "DeleteDomainExtension", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.slb.AccessKeyId, "DeleteDomainExtension", func() error {
+		return c.slb.Invoke("DeleteDomainExtension", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientSLB) DescribeAccessControlListAttribute(
+	ctx context.Context,
+	args *DescribeAccessControlListAttributeArgs,
+) (response *DescribeAccessControlListAttributeResponse, err error) {
+	defer interceptAPI(CLIENT_SLB, "DescribeAccessControlListAttribute", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response = &DescribeAccessControlListAttributeResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeAccessControlListAttribute", func() error {
+		return c.slb.Invoke("DescribeAccessControlListAttribute", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *ContextedClientSLB) DescribeAccessControlLists(
+	ctx context.Context,
+	args *DescribeAccessControlListsArgs,
+) (acls []AclType, err error) {
+	defer interceptAPI(CLIENT_SLB, "DescribeAccessControlLists", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response := &DescribeAccessControlListsResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeAccessControlLists", func() error {
+		return c.slb.Invoke("DescribeAccessControlLists", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Acls.Acl, nil
+}
+
+func (c *ContextedClientSLB) CreateAccessControlList(
+	ctx context.Context,
+	args *CreateAccessControlListArgs,
+) (response *CreateAccessControlListResponse, err error) {
+	defer interceptAPI(CLIENT_SLB, "CreateAccessControlList", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response = &CreateAccessControlListResponse{}
+	err = retryCreationAPI(ctx, c.slb.AccessKeyId, "CreateAccessControlList", "", func() error {
+		return c.slb.Invoke("CreateAccessControlList", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *ContextedClientSLB) DeleteAccessControlList(
+	ctx context.Context,
+	args *DeleteAccessControlListArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_SLB, "DeleteAccessControlList", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.slb.AccessKeyId, "DeleteAccessControlList", func() error {
+		return c.slb.Invoke("DeleteAccessControlList", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientSLB) AddAccessControlListEntry(
+	ctx context.Context,
+	args *AccessControlListEntryArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_SLB, "AddAccessControlListEntry", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.slb.AccessKeyId, "AddAccessControlListEntry", func() error {
+		return c.slb.Invoke("AddAccessControlListEntry", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientSLB) RemoveAccessControlListEntry(
+	ctx context.Context,
+	args *AccessControlListEntryArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_SLB, "RemoveAccessControlListEntry", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.slb.AccessKeyId, "RemoveAccessControlListEntry", func() error {
+		return c.slb.Invoke("RemoveAccessControlListEntry", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientSLB) DescribeAccessLogsDownloadAttribute(
+	ctx context.Context,
+	args *DescribeAccessLogsDownloadAttributeArgs,
+) (attrs []AccessLogAttribute, err error) {
+	defer interceptAPI(CLIENT_SLB, "DescribeAccessLogsDownloadAttribute", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response := &DescribeAccessLogsDownloadAttributeResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeAccessLogsDownloadAttribute", func() error {
+		return c.slb.Invoke("DescribeAccessLogsDownloadAttribute", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.LogsDownloadAttributes.LogsDownloadAttribute, nil
+}
+
+func (c *ContextedClientSLB) SetAccessLogsDownloadAttribute(
+	ctx context.Context,
+	args *AccessLogsDownloadAttributeArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_SLB, "SetAccessLogsDownloadAttribute", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.slb.AccessKeyId, "SetAccessLogsDownloadAttribute", func() error {
+		return c.slb.Invoke("SetAccessLogsDownloadAttribute", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientSLB) DeleteAccessLogsDownloadAttribute(
+	ctx context.Context,
+	args *AccessLogsDownloadAttributeArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_SLB, "DeleteAccessLogsDownloadAttribute", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.slb.AccessKeyId, "DeleteAccessLogsDownloadAttribute", func() error {
+		return c.slb.Invoke("DeleteAccessLogsDownloadAttribute", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientSLB) DescribeListenerExtension(
+	ctx context.Context,
+	proto string,
+	args *DescribeListenerExtensionArgs,
+) (extension *ListenerExtension, err error) {
+	defer interceptAPI(CLIENT_SLB, "DescribeListenerExtension", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response := &DescribeListenerExtensionResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeListenerExtension", func() error {
+		return c.slb.Invoke(listenerExtensionAction("Describe", proto), args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &response.ListenerExtension, nil
+}
+
+func (c *ContextedClientSLB) SetListenerExtension(
+	ctx context.Context,
+	proto string,
+	args *SetListenerExtensionArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_SLB, "SetListenerExtension", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.slb.AccessKeyId, "SetListenerExtension", func() error {
+		return c.slb.Invoke(listenerExtensionAction("Set", proto), args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientSLB) DescribeHTTPSListenerCertificates(
+	ctx context.Context,
+	args *DescribeListenerExtensionArgs,
+) (certificates *ListenerCertificates, err error) {
+	defer interceptAPI(CLIENT_SLB, "DescribeHTTPSListenerCertificates", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response := &DescribeListenerCertificatesResponse{}
+	err = retryAPI(ctx, c.slb.AccessKeyId, "DescribeHTTPSListenerCertificates", func() error {
+		return c.slb.Invoke(listenerExtensionAction("Describe", "https"), args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &response.ListenerCertificates, nil
+}
+
+func (c *ContextedClientSLB) SetHTTPSListenerCertificates(
+	ctx context.Context,
+	args *SetListenerCertificatesArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_SLB, "SetHTTPSListenerCertificates", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.slb.AccessKeyId, "SetHTTPSListenerCertificates", func() error {
+		return c.slb.Invoke(listenerExtensionAction("Set", "https"), args, &common.Response{})
+	})
 }
 
 // =====================================================================================================================
 
 func NewContextedClientINS(key, secret, region string) *ContextedClientINS {
+	client := ecs.NewECSClientWithSecurityToken4RegionalDomain(key, secret, "", common.Region(region))
+	client.SetUserAgent(userAgent())
 	return &ContextedClientINS{
 		BaseClient: BaseClient{},
-		ecs:        ecs.NewECSClientWithSecurityToken4RegionalDomain(key, secret, "", common.Region(region)),
+		ecs:        client,
 	}
 }
 
@@ -284,35 +829,75 @@ type ContextedClientINS struct {
 	ecs *ecs.Client
 }
 
-func (c *ContextedClientINS) AddTags(ctx context.Context, args *ecs.AddTagsArgs) error {
-	return c.ecs.AddTags(args)
+func (c *ContextedClientINS) AddTags(ctx context.Context, args *ecs.AddTagsArgs) (err error) {
+	defer interceptAPI(CLIENT_ECS, "AddTags", time.Now(), &err)
+	return retryAPI(ctx, c.ecs.AccessKeyId, "AddTags", func() error {
+		return c.ecs.AddTags(args)
+	})
 }
 func (c *ContextedClientINS) DescribeInstances(
 	ctx context.Context,
 	args *ecs.DescribeInstancesArgs,
 ) (instances []ecs.InstanceAttributesType, pagination *common.PaginationResult, err error) {
-	return c.ecs.DescribeInstances(args)
+	defer interceptAPI(CLIENT_ECS, "DescribeInstances", time.Now(), &err)
+	err = retryAPI(ctx, c.ecs.AccessKeyId, "DescribeInstances", func() error {
+		instances, pagination, err = c.ecs.DescribeInstances(args)
+		return err
+	})
+	return instances, pagination, err
 }
 func (c *ContextedClientINS) DescribeNetworkInterfaces(
 	ctx context.Context,
 	args *ecs.DescribeNetworkInterfacesArgs,
 ) (resp *ecs.DescribeNetworkInterfacesResponse, err error) {
-	return c.ecs.DescribeNetworkInterfaces(args)
+	defer interceptAPI(CLIENT_ECS, "DescribeNetworkInterfaces", time.Now(), &err)
+	err = retryAPI(ctx, c.ecs.AccessKeyId, "DescribeNetworkInterfaces", func() error {
+		resp, err = c.ecs.DescribeNetworkInterfaces(args)
+		return err
+	})
+	return resp, err
 }
 
 func (c *ContextedClientINS) DescribeEipAddresses(
 	ctx context.Context,
 	args *ecs.DescribeEipAddressesArgs,
 ) (eipAddresses []ecs.EipAddressSetType, pagination *common.PaginationResult, err error) {
-	return c.ecs.DescribeEipAddresses(args)
+	defer interceptAPI(CLIENT_ECS, "DescribeEipAddresses", time.Now(), &err)
+	err = retryAPI(ctx, c.ecs.AccessKeyId, "DescribeEipAddresses", func() error {
+		eipAddresses, pagination, err = c.ecs.DescribeEipAddresses(args)
+		return err
+	})
+	return eipAddresses, pagination, err
+}
+
+func (c *ContextedClientINS) AssociateEipAddress(
+	ctx context.Context,
+	args *AssociateEipAddressArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_ECS, "AssociateEipAddress", time.Now(), &err)
+	return retryAPI(ctx, c.ecs.AccessKeyId, "AssociateEipAddress", func() error {
+		return c.ecs.Invoke("AssociateEipAddress", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientINS) UnassociateEipAddress(
+	ctx context.Context,
+	args *UnassociateEipAddressArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_ECS, "UnassociateEipAddress", time.Now(), &err)
+	return retryAPI(ctx, c.ecs.AccessKeyId, "UnassociateEipAddress", func() error {
+		return c.ecs.Invoke("UnassociateEipAddress", args, &common.Response{})
+	})
 }
 
 // =====================================================================================================================
 func NewContextedClientPVTZ(key, secret, region string) *ContextedClientPVTZ {
+	// TODO: change to regional client
+	client := pvtz.NewPVTZClientWithSecurityToken(key, secret, "", common.Region(region))
+	client.SetUserAgent(userAgent())
 	return &ContextedClientPVTZ{
 		BaseClient: BaseClient{},
-		// TODO: change to regional client
-		pvtz:       pvtz.NewPVTZClientWithSecurityToken(key, secret, "", common.Region(region)),
+		pvtz:       client,
 	}
 }
 
@@ -323,63 +908,126 @@ type ContextedClientPVTZ struct {
 }
 
 func (c *ContextedClientPVTZ) DescribeZones(ctx context.Context, args *pvtz.DescribeZonesArgs) (zones []pvtz.ZoneType, err error) {
-	return c.pvtz.DescribeZones(args)
+	defer interceptAPI(CLIENT_PVTZ, "DescribeZones", time.Now(), &err)
+	err = retryAPI(ctx, c.pvtz.AccessKeyId, "DescribeZones", func() error {
+		zones, err = c.pvtz.DescribeZones(args)
+		return err
+	})
+	return zones, err
 }
 
-func (c *ContextedClientPVTZ) CheckZoneName(ctx context.Context, args *pvtz.CheckZoneNameArgs) (bool, error) {
-	return c.pvtz.CheckZoneName(args)
+func (c *ContextedClientPVTZ) CheckZoneName(ctx context.Context, args *pvtz.CheckZoneNameArgs) (ok bool, err error) {
+	defer interceptAPI(CLIENT_PVTZ, "CheckZoneName", time.Now(), &err)
+	err = retryAPI(ctx, c.pvtz.AccessKeyId, "CheckZoneName", func() error {
+		ok, err = c.pvtz.CheckZoneName(args)
+		return err
+	})
+	return ok, err
 }
 
 func (c *ContextedClientPVTZ) DescribeZoneInfo(ctx context.Context, args *pvtz.DescribeZoneInfoArgs) (response *pvtz.DescribeZoneInfoResponse, err error) {
-	return c.pvtz.DescribeZoneInfo(args)
+	defer interceptAPI(CLIENT_PVTZ, "DescribeZoneInfo", time.Now(), &err)
+	err = retryAPI(ctx, c.pvtz.AccessKeyId, "DescribeZoneInfo", func() error {
+		response, err = c.pvtz.DescribeZoneInfo(args)
+		return err
+	})
+	return response, err
 }
 
 func (c *ContextedClientPVTZ) DescribeRegions(ctx context.Context) (regions []pvtz.RegionType, err error) {
-	return c.pvtz.DescribeRegions()
+	defer interceptAPI(CLIENT_PVTZ, "DescribeRegions", time.Now(), &err)
+	err = retryAPI(ctx, c.pvtz.AccessKeyId, "DescribeRegions", func() error {
+		regions, err = c.pvtz.DescribeRegions()
+		return err
+	})
+	return regions, err
 }
 
 func (c *ContextedClientPVTZ) DescribeZoneRecords(ctx context.Context, args *pvtz.DescribeZoneRecordsArgs) (records []pvtz.ZoneRecordType, err error) {
-	return c.pvtz.DescribeZoneRecords(args)
+	defer interceptAPI(CLIENT_PVTZ, "DescribeZoneRecords", time.Now(), &err)
+	err = retryAPI(ctx, c.pvtz.AccessKeyId, "DescribeZoneRecords", func() error {
+		records, err = c.pvtz.DescribeZoneRecords(args)
+		return err
+	})
+	return records, err
 }
 
 func (c *ContextedClientPVTZ) DescribeZoneRecordsByRR(ctx context.Context, zoneId string, rr string) (records []pvtz.ZoneRecordType, err error) {
-	return c.pvtz.DescribeZoneRecordsByRR(zoneId, rr)
+	defer interceptAPI(CLIENT_PVTZ, "DescribeZoneRecordsByRR", time.Now(), &err)
+	err = retryAPI(ctx, c.pvtz.AccessKeyId, "DescribeZoneRecordsByRR", func() error {
+		records, err = c.pvtz.DescribeZoneRecordsByRR(zoneId, rr)
+		return err
+	})
+	return records, err
 }
 
 func (c *ContextedClientPVTZ) AddZone(ctx context.Context, args *pvtz.AddZoneArgs) (response *pvtz.AddZoneResponse, err error) {
-	return c.pvtz.AddZone(args)
+	defer interceptAPI(CLIENT_PVTZ, "AddZone", time.Now(), &err)
+	err = retryCreationAPI(ctx, c.pvtz.AccessKeyId, "AddZone", "", func() error {
+		response, err = c.pvtz.AddZone(args)
+		return err
+	})
+	return response, err
 }
 func (c *ContextedClientPVTZ) DeleteZone(ctx context.Context, args *pvtz.DeleteZoneArgs) (err error) {
-	return c.pvtz.DeleteZone(args)
+	defer interceptAPI(CLIENT_PVTZ, "DeleteZone", time.Now(), &err)
+	return retryAPI(ctx, c.pvtz.AccessKeyId, "DeleteZone", func() error {
+		return c.pvtz.DeleteZone(args)
+	})
 }
-func (c *ContextedClientPVTZ) UpdateZoneRemark(ctx context.Context, args *pvtz.UpdateZoneRemarkArgs) error {
-	return c.pvtz.UpdateZoneRemark(args)
+func (c *ContextedClientPVTZ) UpdateZoneRemark(ctx context.Context, args *pvtz.UpdateZoneRemarkArgs) (err error) {
+	defer interceptAPI(CLIENT_PVTZ, "UpdateZoneRemark", time.Now(), &err)
+	return retryAPI(ctx, c.pvtz.AccessKeyId, "UpdateZoneRemark", func() error {
+		return c.pvtz.UpdateZoneRemark(args)
+	})
 }
 func (c *ContextedClientPVTZ) BindZoneVpc(ctx context.Context, args *pvtz.BindZoneVpcArgs) (err error) {
-	return c.pvtz.BindZoneVpc(args)
+	defer interceptAPI(CLIENT_PVTZ, "BindZoneVpc", time.Now(), &err)
+	return retryAPI(ctx, c.pvtz.AccessKeyId, "BindZoneVpc", func() error {
+		return c.pvtz.BindZoneVpc(args)
+	})
 }
-func (c *ContextedClientPVTZ) DeleteZoneRecordsByRR(ctx context.Context, zoneId string, rr string) error {
-	return c.pvtz.DeleteZoneRecordsByRR(zoneId, rr)
+func (c *ContextedClientPVTZ) DeleteZoneRecordsByRR(ctx context.Context, zoneId string, rr string) (err error) {
+	defer interceptAPI(CLIENT_PVTZ, "DeleteZoneRecordsByRR", time.Now(), &err)
+	return retryAPI(ctx, c.pvtz.AccessKeyId, "DeleteZoneRecordsByRR", func() error {
+		return c.pvtz.DeleteZoneRecordsByRR(zoneId, rr)
+	})
 }
 func (c *ContextedClientPVTZ) AddZoneRecord(ctx context.Context, args *pvtz.AddZoneRecordArgs) (response *pvtz.AddZoneRecordResponse, err error) {
-	return c.pvtz.AddZoneRecord(args)
+	defer interceptAPI(CLIENT_PVTZ, "AddZoneRecord", time.Now(), &err)
+	err = retryCreationAPI(ctx, c.pvtz.AccessKeyId, "AddZoneRecord", "", func() error {
+		response, err = c.pvtz.AddZoneRecord(args)
+		return err
+	})
+	return response, err
 }
 func (c *ContextedClientPVTZ) UpdateZoneRecord(ctx context.Context, args *pvtz.UpdateZoneRecordArgs) (err error) {
-	return c.pvtz.UpdateZoneRecord(args)
+	defer interceptAPI(CLIENT_PVTZ, "UpdateZoneRecord", time.Now(), &err)
+	return retryAPI(ctx, c.pvtz.AccessKeyId, "UpdateZoneRecord", func() error {
+		return c.pvtz.UpdateZoneRecord(args)
+	})
 }
 func (c *ContextedClientPVTZ) DeleteZoneRecord(ctx context.Context, args *pvtz.DeleteZoneRecordArgs) (err error) {
-	return c.pvtz.DeleteZoneRecord(args)
+	defer interceptAPI(CLIENT_PVTZ, "DeleteZoneRecord", time.Now(), &err)
+	return retryAPI(ctx, c.pvtz.AccessKeyId, "DeleteZoneRecord", func() error {
+		return c.pvtz.DeleteZoneRecord(args)
+	})
 }
 func (c *ContextedClientPVTZ) SetZoneRecordStatus(ctx context.Context, args *pvtz.SetZoneRecordStatusArgs) (err error) {
-	return c.pvtz.SetZoneRecordStatus(args)
+	defer interceptAPI(CLIENT_PVTZ, "SetZoneRecordStatus", time.Now(), &err)
+	return retryAPI(ctx, c.pvtz.AccessKeyId, "SetZoneRecordStatus", func() error {
+		return c.pvtz.SetZoneRecordStatus(args)
+	})
 }
 
 // =====================================================================================================================
 
 func NewContextedClientRoute(key, secret, region string) *ContextedClientRoute {
+	client := ecs.NewVPCClientWithSecurityToken4RegionalDomain(key, secret, "", common.Region(region))
+	client.SetUserAgent(userAgent())
 	return &ContextedClientRoute{
 		BaseClient: BaseClient{},
-		ecs:        ecs.NewVPCClientWithSecurityToken4RegionalDomain(key, secret, "", common.Region(region)),
+		ecs:        client,
 	}
 }
 
@@ -390,27 +1038,397 @@ type ContextedClientRoute struct {
 }
 
 func (c *ContextedClientRoute) DescribeVpcs(ctx context.Context, args *ecs.DescribeVpcsArgs) (vpcs []ecs.VpcSetType, pagination *common.PaginationResult, err error) {
-	return c.ecs.DescribeVpcs(args)
+	defer interceptAPI(CLIENT_VPC, "DescribeVpcs", time.Now(), &err)
+	err = retryAPI(ctx, c.ecs.AccessKeyId, "DescribeVpcs", func() error {
+		vpcs, pagination, err = c.ecs.DescribeVpcs(args)
+		return err
+	})
+	return vpcs, pagination, err
+}
+
+func (c *ContextedClientRoute) DescribeVpcsIpv6(ctx context.Context, args *ecs.DescribeVpcsArgs) (vpcs []VpcIpv6Type, err error) {
+	defer interceptAPI(CLIENT_VPC, "DescribeVpcs", time.Now(), &err)
+	response := &DescribeVpcsIpv6Response{}
+	err = retryAPI(ctx, c.ecs.AccessKeyId, "DescribeVpcs", func() error {
+		return c.ecs.Invoke("DescribeVpcs", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Vpcs.Vpc, nil
 }
 
 func (c *ContextedClientRoute) DescribeVRouters(ctx context.Context, args *ecs.DescribeVRoutersArgs) (vrouters []ecs.VRouterSetType, pagination *common.PaginationResult, err error) {
-	return c.ecs.DescribeVRouters(args)
+	defer interceptAPI(CLIENT_VPC, "DescribeVRouters", time.Now(), &err)
+	err = retryAPI(ctx, c.ecs.AccessKeyId, "DescribeVRouters", func() error {
+		vrouters, pagination, err = c.ecs.DescribeVRouters(args)
+		return err
+	})
+	return vrouters, pagination, err
 }
 
 func (c *ContextedClientRoute) DescribeRouteTables(ctx context.Context, args *ecs.DescribeRouteTablesArgs) (routeTables []ecs.RouteTableSetType, pagination *common.PaginationResult, err error) {
-	return c.ecs.DescribeRouteTables(args)
+	defer interceptAPI(CLIENT_VPC, "DescribeRouteTables", time.Now(), &err)
+	err = retryAPI(ctx, c.ecs.AccessKeyId, "DescribeRouteTables", func() error {
+		routeTables, pagination, err = c.ecs.DescribeRouteTables(args)
+		return err
+	})
+	return routeTables, pagination, err
 }
 
 func (c *ContextedClientRoute) DescribeRouteEntryList(ctx context.Context, args *ecs.DescribeRouteEntryListArgs) (response *ecs.DescribeRouteEntryListResponse, err error) {
-	return c.ecs.DescribeRouteEntryList(args)
+	defer interceptAPI(CLIENT_VPC, "DescribeRouteEntryList", time.Now(), &err)
+	err = retryAPI(ctx, c.ecs.AccessKeyId, "DescribeRouteEntryList", func() error {
+		response, err = c.ecs.DescribeRouteEntryList(args)
+		return err
+	})
+	return response, err
+}
+
+func (c *ContextedClientRoute) DescribeVSwitches(ctx context.Context, args *ecs.DescribeVSwitchesArgs) (vswitches []ecs.VSwitchSetType, pagination *common.PaginationResult, err error) {
+	defer interceptAPI(CLIENT_VPC, "DescribeVSwitches", time.Now(), &err)
+	err = retryAPI(ctx, c.ecs.AccessKeyId, "DescribeVSwitches", func() error {
+		vswitches, pagination, err = c.ecs.DescribeVSwitches(args)
+		return err
+	})
+	return vswitches, pagination, err
+}
+
+func (c *ContextedClientRoute) DescribeRouteEntryPage(ctx context.Context, args *ecs.DescribeRouteEntryListArgs) (response *DescribeRouteEntryPageResponse, err error) {
+	defer interceptAPI(CLIENT_VPC, "DescribeRouteEntryList", time.Now(), &err)
+	response = &DescribeRouteEntryPageResponse{}
+	err = retryAPI(ctx, c.ecs.AccessKeyId, "DescribeRouteEntryList", func() error {
+		return c.ecs.Invoke("DescribeRouteEntryList", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *ContextedClientRoute) DeleteRouteEntry(ctx context.Context, args *ecs.DeleteRouteEntryArgs) (err error) {
+	defer interceptAPI(CLIENT_VPC, "DeleteRouteEntry", time.Now(), &err)
+	return retryAPI(ctx, c.ecs.AccessKeyId, "DeleteRouteEntry", func() error {
+		return c.ecs.DeleteRouteEntry(args)
+	})
+}
+func (c *ContextedClientRoute) CreateRouteEntry(ctx context.Context, args *ecs.CreateRouteEntryArgs) (err error) {
+	defer interceptAPI(CLIENT_VPC, "CreateRouteEntry", time.Now(), &err)
+	return retryCreationAPI(ctx, c.ecs.AccessKeyId, "CreateRouteEntry", args.ClientToken, func() error {
+		return c.ecs.CreateRouteEntry(args)
+	})
+}
+func (c *ContextedClientRoute) WaitForAllRouteEntriesAvailable(ctx context.Context, vrouterId string, routeTableId string, timeout int) (err error) {
+	defer interceptAPI(CLIENT_VPC, "WaitForAllRouteEntriesAvailable", time.Now(), &err)
+	return retryAPI(ctx, c.ecs.AccessKeyId, "WaitForAllRouteEntriesAvailable", func() error {
+		return c.ecs.WaitForAllRouteEntriesAvailable(vrouterId, routeTableId, timeout)
+	})
+}
+
+func (c *ContextedClientRoute) DescribeCommonBandwidthPackages(
+	ctx context.Context,
+	args *DescribeCommonBandwidthPackagesArgs,
+) (packages []CommonBandwidthPackageType, err error) {
+	defer interceptAPI(CLIENT_VPC, "DescribeCommonBandwidthPackages", time.Now(), &err)
+	response := &DescribeCommonBandwidthPackagesResponse{}
+	err = retryAPI(ctx, c.ecs.AccessKeyId, "DescribeCommonBandwidthPackages", func() error {
+		return c.ecs.Invoke("DescribeCommonBandwidthPackages", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.CommonBandwidthPackages.CommonBandwidthPackage, nil
 }
 
-func (c *ContextedClientRoute) DeleteRouteEntry(ctx context.Context, args *ecs.DeleteRouteEntryArgs) error {
-	return c.ecs.DeleteRouteEntry(args)
+func (c *ContextedClientRoute) AddCommonBandwidthPackageIp(
+	ctx context.Context,
+	args *AddCommonBandwidthPackageIpArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_VPC, "AddCommonBandwidthPackageIp", time.Now(), &err)
+	return retryAPI(ctx, c.ecs.AccessKeyId, "AddCommonBandwidthPackageIp", func() error {
+		return c.ecs.Invoke("AddCommonBandwidthPackageIp", args, &common.Response{})
+	})
 }
-func (c *ContextedClientRoute) CreateRouteEntry(ctx context.Context, args *ecs.CreateRouteEntryArgs) error {
-	return c.ecs.CreateRouteEntry(args)
+
+func (c *ContextedClientRoute) RemoveCommonBandwidthPackageIp(
+	ctx context.Context,
+	args *RemoveCommonBandwidthPackageIpArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_VPC, "RemoveCommonBandwidthPackageIp", time.Now(), &err)
+	return retryAPI(ctx, c.ecs.AccessKeyId, "RemoveCommonBandwidthPackageIp", func() error {
+		return c.ecs.Invoke("RemoveCommonBandwidthPackageIp", args, &common.Response{})
+	})
 }
-func (c *ContextedClientRoute) WaitForAllRouteEntriesAvailable(ctx context.Context, vrouterId string, routeTableId string, timeout int) error {
-	return c.ecs.WaitForAllRouteEntriesAvailable(vrouterId, routeTableId, timeout)
+
+// =====================================================================================================================
+func NewContextedClientNLB(key, secret, region string) *ContextedClientNLB {
+	client := &common.Client{}
+	client.Init(fmt.Sprintf("https://nlb.%s.aliyuncs.com", region), NLB_API_VERSION, key, secret)
+	client.SetUserAgent(userAgent())
+	return &ContextedClientNLB{
+		BaseClient: BaseClient{},
+		region:     common.Region(region),
+		nlb:        client,
+	}
+}
+
+// ContextedClientNLB the nlb api is not wrapped by aliyungo, it is invoked by
+// the common client.
+type ContextedClientNLB struct {
+	BaseClient
+	// base nlb client
+	nlb    *common.Client
+	region common.Region
+}
+
+// NLB_PAGE_SIZE max results of a page of the list apis
+const NLB_PAGE_SIZE = 100
+
+func (c *ContextedClientNLB) CreateLoadBalancer(
+	ctx context.Context,
+	args *CreateNLBArgs,
+) (response *CreateNLBResponse, err error) {
+	defer interceptAPI(CLIENT_NLB, "CreateLoadBalancer", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response = &CreateNLBResponse{}
+	err = retryCreationAPI(ctx, c.nlb.AccessKeyId, "CreateLoadBalancer", args.ClientToken, func() error {
+		return c.nlb.Invoke("CreateLoadBalancer", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *ContextedClientNLB) ListLoadBalancers(
+	ctx context.Context,
+	args *ListNLBsArgs,
+) (lbs []NLBType, err error) {
+	defer interceptAPI(CLIENT_NLB, "ListLoadBalancers", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	args.MaxResults = NLB_PAGE_SIZE
+	for {
+		response := &ListNLBsResponse{}
+		if err := retryAPI(ctx, c.nlb.AccessKeyId, "ListLoadBalancers", func() error {
+			return c.nlb.Invoke("ListLoadBalancers", args, response)
+		}); err != nil {
+			return nil, err
+		}
+		lbs = append(lbs, response.LoadBalancers...)
+		if response.NextToken == "" {
+			return lbs, nil
+		}
+		args.NextToken = response.NextToken
+	}
+}
+
+func (c *ContextedClientNLB) GetLoadBalancerAttribute(
+	ctx context.Context,
+	args *GetNLBAttributeArgs,
+) (lb *NLBType, err error) {
+	defer interceptAPI(CLIENT_NLB, "GetLoadBalancerAttribute", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response := &struct {
+		common.Response
+		NLBType
+	}{}
+	err = retryAPI(ctx, c.nlb.AccessKeyId, "GetLoadBalancerAttribute", func() error {
+		return c.nlb.Invoke("GetLoadBalancerAttribute", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &response.NLBType, nil
+}
+
+func (c *ContextedClientNLB) DeleteLoadBalancer(
+	ctx context.Context,
+	args *DeleteNLBArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_NLB, "DeleteLoadBalancer", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.nlb.AccessKeyId, "DeleteLoadBalancer", func() error {
+		return c.nlb.Invoke("DeleteLoadBalancer", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientNLB) CreateServerGroup(
+	ctx context.Context,
+	args *CreateNLBServerGroupArgs,
+) (response *CreateNLBServerGroupResponse, err error) {
+	defer interceptAPI(CLIENT_NLB, "CreateServerGroup", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response = &CreateNLBServerGroupResponse{}
+	err = retryCreationAPI(ctx, c.nlb.AccessKeyId, "CreateServerGroup", "", func() error {
+		return c.nlb.Invoke("CreateServerGroup", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *ContextedClientNLB) ListServerGroups(
+	ctx context.Context,
+	args *ListNLBServerGroupsArgs,
+) (groups []NLBServerGroupType, err error) {
+	defer interceptAPI(CLIENT_NLB, "ListServerGroups", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	args.MaxResults = NLB_PAGE_SIZE
+	for {
+		response := &ListNLBServerGroupsResponse{}
+		if err := retryAPI(ctx, c.nlb.AccessKeyId, "ListServerGroups", func() error {
+			return c.nlb.Invoke("ListServerGroups", args, response)
+		}); err != nil {
+			return nil, err
+		}
+		groups = append(groups, response.ServerGroups...)
+		if response.NextToken == "" {
+			return groups, nil
+		}
+		args.NextToken = response.NextToken
+	}
+}
+
+func (c *ContextedClientNLB) DeleteServerGroup(
+	ctx context.Context,
+	args *DeleteNLBServerGroupArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_NLB, "DeleteServerGroup", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.nlb.AccessKeyId, "DeleteServerGroup", func() error {
+		return c.nlb.Invoke("DeleteServerGroup", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientNLB) ListServerGroupServers(
+	ctx context.Context,
+	args *ListNLBServerGroupServersArgs,
+) (servers []NLBServerType, err error) {
+	defer interceptAPI(CLIENT_NLB, "ListServerGroupServers", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	args.MaxResults = NLB_PAGE_SIZE
+	for {
+		response := &ListNLBServerGroupServersResponse{}
+		if err := retryAPI(ctx, c.nlb.AccessKeyId, "ListServerGroupServers", func() error {
+			return c.nlb.Invoke("ListServerGroupServers", args, response)
+		}); err != nil {
+			return nil, err
+		}
+		servers = append(servers, response.Servers...)
+		if response.NextToken == "" {
+			return servers, nil
+		}
+		args.NextToken = response.NextToken
+	}
+}
+
+func (c *ContextedClientNLB) AddServersToServerGroup(
+	ctx context.Context,
+	args *NLBServersArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_NLB, "AddServersToServerGroup", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.nlb.AccessKeyId, "AddServersToServerGroup", func() error {
+		return c.nlb.Invoke("AddServersToServerGroup", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientNLB) RemoveServersFromServerGroup(
+	ctx context.Context,
+	args *NLBServersArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_NLB, "RemoveServersFromServerGroup", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.nlb.AccessKeyId, "RemoveServersFromServerGroup", func() error {
+		return c.nlb.Invoke("RemoveServersFromServerGroup", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientNLB) CreateListener(
+	ctx context.Context,
+	args *CreateNLBListenerArgs,
+) (response *CreateNLBListenerResponse, err error) {
+	defer interceptAPI(CLIENT_NLB, "CreateListener", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	response = &CreateNLBListenerResponse{}
+	err = retryCreationAPI(ctx, c.nlb.AccessKeyId, "CreateListener", "", func() error {
+		return c.nlb.Invoke("CreateListener", args, response)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *ContextedClientNLB) ListListeners(
+	ctx context.Context,
+	args *ListNLBListenersArgs,
+) (listeners []NLBListenerType, err error) {
+	defer interceptAPI(CLIENT_NLB, "ListListeners", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	args.MaxResults = NLB_PAGE_SIZE
+	for {
+		response := &ListNLBListenersResponse{}
+		if err := retryAPI(ctx, c.nlb.AccessKeyId, "ListListeners", func() error {
+			return c.nlb.Invoke("ListListeners", args, response)
+		}); err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, response.Listeners...)
+		if response.NextToken == "" {
+			return listeners, nil
+		}
+		args.NextToken = response.NextToken
+	}
+}
+
+func (c *ContextedClientNLB) UpdateListenerAttribute(
+	ctx context.Context,
+	args *UpdateNLBListenerArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_NLB, "UpdateListenerAttribute", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.nlb.AccessKeyId, "UpdateListenerAttribute", func() error {
+		return c.nlb.Invoke("UpdateListenerAttribute", args, &common.Response{})
+	})
+}
+
+func (c *ContextedClientNLB) DeleteListener(
+	ctx context.Context,
+	args *DeleteNLBListenerArgs,
+) (err error) {
+	defer interceptAPI(CLIENT_NLB, "DeleteListener", time.Now(), &err)
+	if args.RegionId == "" {
+		args.RegionId = c.region
+	}
+	return retryAPI(ctx, c.nlb.AccessKeyId, "DeleteListener", func() error {
+		return c.nlb.Invoke("DeleteListener", args, &common.Response{})
+	})
 }
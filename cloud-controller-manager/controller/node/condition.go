@@ -0,0 +1,129 @@
+package node
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider/node/helpers"
+	"k8s.io/klog"
+	nodeutil "k8s.io/kubernetes/pkg/util/node"
+)
+
+// The InstanceHealthy condition of a node tells what the cloud thinks about
+// its instance, set by the periodic existence sweep from the status returned
+// by ListInstances. It turns Unknown when the api is unreachable, so that a
+// stale state is not mistaken for the truth. The condition is observability
+// only, the deletion of the node is decided by the existence sweep as before.
+
+// NodeConditionInstanceHealthy condition of the instance of the node
+const NodeConditionInstanceHealthy v1.NodeConditionType = "InstanceHealthy"
+
+// Reasons of the InstanceHealthy condition
+const (
+	// InstanceReasonRunning the instance is running
+	InstanceReasonRunning = "Running"
+	// InstanceReasonStopped the instance is stopped or stopping
+	InstanceReasonStopped = "Stopped"
+	// InstanceReasonExpired the instance is locked for its overdue payment
+	InstanceReasonExpired = "Expired"
+	// InstanceReasonNotFound the instance is not found or deleted
+	InstanceReasonNotFound = "NotFound"
+	// InstanceReasonAPIUnavailable the instances are not listed from the api
+	InstanceReasonAPIUnavailable = "CloudAPIUnavailable"
+)
+
+// instanceCondition the status, reason and message of the InstanceHealthy
+// condition of the instance, nil if it is not found
+func instanceCondition(ins *CloudNodeAttribute) (v1.ConditionStatus, string, string) {
+	switch {
+	case ins == nil:
+		return v1.ConditionFalse, InstanceReasonNotFound, "instance not found"
+	case ins.Expired:
+		return v1.ConditionFalse, InstanceReasonExpired, fmt.Sprintf("instance %s is expired", ins.InstanceID)
+	}
+	switch ins.Status {
+	case "Running":
+		return v1.ConditionTrue, InstanceReasonRunning, fmt.Sprintf("instance %s is running", ins.InstanceID)
+	case "Stopped", "Stopping":
+		return v1.ConditionFalse, InstanceReasonStopped, fmt.Sprintf("instance %s is %s", ins.InstanceID, ins.Status)
+	case "Deleted":
+		return v1.ConditionFalse, InstanceReasonNotFound, fmt.Sprintf("instance %s is deleted", ins.InstanceID)
+	}
+	// eg. Pending or Starting
+	return v1.ConditionUnknown, ins.Status, fmt.Sprintf("instance %s is %s", ins.InstanceID, ins.Status)
+}
+
+// setInstanceCondition a copy of the node with the InstanceHealthy condition
+// set, false if the condition is unchanged. The transition time is kept
+// until the status changes.
+func setInstanceCondition(
+	node *v1.Node,
+	status v1.ConditionStatus,
+	reason, message string,
+	now metav1.Time,
+) (*v1.Node, bool) {
+	condition := v1.NodeCondition{
+		Type:               NodeConditionInstanceHealthy,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+	}
+	i, current := helpers.GetNodeCondition(&node.Status, NodeConditionInstanceHealthy)
+	if current != nil {
+		if current.Status == status && current.Reason == reason && current.Message == message {
+			return node, false
+		}
+		if current.Status == status {
+			condition.LastTransitionTime = current.LastTransitionTime
+		}
+	}
+	clone := node.DeepCopy()
+	if current == nil {
+		clone.Status.Conditions = append(clone.Status.Conditions, condition)
+	} else {
+		clone.Status.Conditions[i] = condition
+	}
+	return clone, true
+}
+
+// syncInstanceConditions set the InstanceHealthy condition of the nodes by
+// their instances. The instances are nil when the api is unreachable, the
+// conditions set are turned Unknown then, and no condition is added.
+func (cnc *CloudNodeController) syncInstanceConditions(
+	nodes []v1.Node,
+	instances map[string]*CloudNodeAttribute,
+) {
+	now := metav1.Now()
+	for i := range nodes {
+		node := &nodes[i]
+		var (
+			status          v1.ConditionStatus
+			reason, message string
+		)
+		if instances == nil {
+			if _, current := helpers.GetNodeCondition(&node.Status, NodeConditionInstanceHealthy); current == nil {
+				continue
+			}
+			status, reason, message = v1.ConditionUnknown, InstanceReasonAPIUnavailable, "instances not listed from the cloud api"
+		} else {
+			status, reason, message = instanceCondition(instances[node.Spec.ProviderID])
+		}
+		clone, changed := setInstanceCondition(node, status, reason, message, now)
+		if !changed {
+			continue
+		}
+		klog.Infof("node %s instance condition changed to %s: %s", node.Name, status, reason)
+		if _, _, err := nodeutil.PatchNodeStatus(
+			cnc.kclient.CoreV1(),
+			types.NodeName(node.Name),
+			node,
+			clone,
+		); err != nil {
+			klog.Errorf("patch node %s instance condition, wait for next retry: %s", node.Name, err.Error())
+		}
+	}
+}
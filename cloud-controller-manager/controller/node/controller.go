@@ -65,6 +65,9 @@ type CloudNodeController struct {
 
 	statusFrequency  time.Duration
 	nodeListerSynced cache.InformerSynced
+
+	// instances shared by the address sync and the label reconcile
+	instances *instanceCache
 }
 
 const (
@@ -86,6 +89,14 @@ type CloudNodeAttribute struct {
 	InstanceID   string
 	Addresses    []v1.NodeAddress
 	InstanceType string
+	// Status status of the instance, eg. Running or Stopped
+	Status string
+	// Expired the instance is locked for its overdue payment
+	Expired bool
+	// Zone the zone of the instance, eg. cn-hangzhou-g
+	Zone string
+	// Region the region of the instance
+	Region string
 }
 
 // CloudInstance is an interface to interact with cloud api
@@ -116,15 +127,24 @@ func NewCloudNodeController(
 		klog.Infof("no api server defined - no events will be sent to API server.")
 	}
 
+	periods := Options.effective(nodeMonitorPeriod, nodeStatusUpdateFrequency)
+	klog.Infof("node controller: monitor period %s, status update frequency %s, "+
+		"label reconcile period %s, node deletion %t", periods.MonitorPeriod,
+		periods.StatusUpdateFrequency, LabelReconcilePeriod, EnableNodeDeletion)
+	for _, warning := range periods.warnings() {
+		klog.Warningf("node controller: %s", warning)
+	}
+
 	cnc := &CloudNodeController{
 		informer:         ninformer,
 		kclient:          kubeClient,
 		recorder:         eventer,
 		broadcaster:      caster,
 		cloud:            cloud,
-		monitorPeriod:    nodeMonitorPeriod,
-		statusFrequency:  nodeStatusUpdateFrequency,
+		monitorPeriod:    periods.MonitorPeriod,
+		statusFrequency:  periods.StatusUpdateFrequency,
 		nodeListerSynced: ninformer.Informer().HasSynced,
+		instances:        newInstanceCache(),
 	}
 
 	HandlerForNode(cnc, ninformer)
@@ -177,66 +197,68 @@ func (cnc *CloudNodeController) Run(stopCh <-chan struct{}) {
 	// very infrequently. DO NOT MODIFY this to perform frequent operations.
 
 	// Start a loop to periodically update the node addresses obtained from the cloud
-	go wait.Until(
-		func() {
-			nodes, err := nodeLists(cnc.kclient)
-			if err != nil {
-				klog.Errorf("Error monitoring node status: %v", err)
-				return
-			}
+	go every("address", cnc.updateAddresses, cnc.statusFrequency, wait.NeverStop)
 
-			// ignore return value, retry on error
-			err = batchAddressUpdate(
-				nodes.Items,
-				cnc.syncNodeAddress,
-			)
-			if err != nil {
-				klog.Errorf("periodically update address: %s", err.Error())
-			}
-		},
-		cnc.statusFrequency,
-		wait.NeverStop,
-	)
+	// Start a loop to periodically repair the cloud labels drifted from the instances
+	if LabelReconcilePeriod > 0 {
+		go every("labels", cnc.reconcileLabels, LabelReconcilePeriod, wait.NeverStop)
+	}
 
 	// Start a loop to periodically check if any nodes have been deleted from cloudprovider
-	go wait.Until(
-		func() {
-			nodes, err := nodeLists(cnc.kclient)
-			if err != nil {
-				klog.Errorf("Error monitoring node status: %v", err)
-				return
-			}
-			// ignore return value, retry on error
-			err = batchAddressUpdate(
-				nodes.Items,
-				cnc.syncCloudNodes,
-			)
-			if err != nil {
-				klog.Errorf("periodically try detect node existence: %s", err.Error())
-			}
-		},
-		cnc.monitorPeriod,
-		wait.NeverStop,
-	)
+	go every("existence", cnc.detectExistence, cnc.monitorPeriod, wait.NeverStop)
 
 	// Start a loop to periodically check if uninitialized taints has been remove from node
-	go wait.Until(
-		func() {
-			nodes, err := nodeLists(cnc.kclient)
-			if err != nil {
-				klog.Errorf("Error monitoring node status: %v", err)
-				return
-			}
-			for _, node := range nodes.Items {
-				err := cnc.AddCloudNode(&node)
-				if err != nil {
-					klog.Errorf("periodically remove cloud node %s taints: %s", node.Name, err.Error())
-				}
-			}
-		},
-		3*time.Minute,
-		wait.NeverStop,
+	go every("taints", cnc.removeTaints, 3*time.Minute, wait.NeverStop)
+}
+
+// updateAddresses update the addresses of all the nodes from the cloud
+func (cnc *CloudNodeController) updateAddresses() {
+	nodes, err := nodeLists(cnc.kclient)
+	if err != nil {
+		klog.Errorf("Error monitoring node status: %v", err)
+		return
+	}
+
+	// ignore return value, retry on error
+	err = batchAddressUpdate(
+		nodes.Items,
+		cnc.syncNodeAddress,
+	)
+	if err != nil {
+		klog.Errorf("periodically update address: %s", err.Error())
+	}
+}
+
+// detectExistence check if any nodes have been deleted from cloudprovider
+func (cnc *CloudNodeController) detectExistence() {
+	nodes, err := nodeLists(cnc.kclient)
+	if err != nil {
+		klog.Errorf("Error monitoring node status: %v", err)
+		return
+	}
+	// ignore return value, retry on error
+	err = batchAddressUpdate(
+		nodes.Items,
+		cnc.syncCloudNodes,
 	)
+	if err != nil {
+		klog.Errorf("periodically try detect node existence: %s", err.Error())
+	}
+}
+
+// removeTaints remove the uninitialized taints of the nodes initialized
+func (cnc *CloudNodeController) removeTaints() {
+	nodes, err := nodeLists(cnc.kclient)
+	if err != nil {
+		klog.Errorf("Error monitoring node status: %v", err)
+		return
+	}
+	for _, node := range nodes.Items {
+		err := cnc.AddCloudNode(&node)
+		if err != nil {
+			klog.Errorf("periodically remove cloud node %s taints: %s", node.Name, err.Error())
+		}
+	}
 }
 
 func (cnc *CloudNodeController) AddCloudNode(node *v1.Node) error {
@@ -263,7 +285,7 @@ func (cnc *CloudNodeController) syncNodeAddress(nodes []v1.Node) error {
 	if !ok {
 		return fmt.Errorf("cloud instance not implemented")
 	}
-	instances, err := ins.ListInstances(context.Background(), nodeids(nodes))
+	instances, err := cnc.instances.list(context.Background(), ins, nodeids(nodes))
 	if err != nil {
 		return fmt.Errorf("syncNodeAddress, retrieve instances from api error: %s", err.Error())
 	}
@@ -278,16 +300,11 @@ func (cnc *CloudNodeController) syncNodeAddress(nodes []v1.Node) error {
 		cloudNode.Addresses = setHostnameAddress(node, cloudNode.Addresses)
 		// If nodeIP was suggested by user, ensure that
 		// it can be found in the cloud as well (consistent with the behaviour in kubelet)
-		nodeIP, ok := isProvidedAddrExist(node, cloudNode.Addresses)
-		if ok {
-			if nodeIP == nil {
-				klog.Errorf("User has specified Node IP in kubelet but it is not found in cloudprovider")
-				continue
-			}
-			// override addresses
-			cloudNode.Addresses = []v1.NodeAddress{*nodeIP}
+		addresses, ok := cnc.providedAddresses(node, cloudNode.Addresses)
+		if !ok {
+			continue
 		}
-		err := tryPatchNodeAddress(cnc.kclient, node, cloudNode.Addresses)
+		err := tryPatchNodeAddress(cnc.kclient, node, addresses)
 		if err != nil {
 			klog.Errorf("Wait for next retry, patch node address error: %s", err.Error())
 			cnc.recorder.Eventf(
@@ -308,12 +325,20 @@ func (cnc *CloudNodeController) syncCloudNodes(nodes []v1.Node) error {
 	}
 	instances, err := ins.ListInstances(context.Background(), nodeids(nodes))
 	if err != nil {
+		// the state of the instances is unknown, not the last one
+		cnc.syncInstanceConditions(nodes, nil)
 		return fmt.Errorf("syncCloudNodes, retrieve instances from api error: %s", err.Error())
 	}
+	cnc.syncInstanceConditions(nodes, instances)
 
 	for i := range nodes {
 		node := &nodes[i]
 
+		if instances[node.Spec.ProviderID] != nil && findInstanceNotFoundTaint(node.Spec.Taints) != nil {
+			// the instance reappears
+			cnc.unmarkInstanceNotFound(node)
+		}
+
 		condition := nodeConditionReady(cnc.kclient, node)
 		if condition == nil {
 			klog.Infof("node %s condition not ready, wait for next retry", node.Spec.ProviderID)
@@ -330,6 +355,10 @@ func (cnc *CloudNodeController) syncCloudNodes(nodes []v1.Node) error {
 			continue
 		}
 
+		if !EnableNodeDeletion {
+			cnc.markInstanceNotFound(node)
+			continue
+		}
 		klog.Infof("node %s not found, start to delete from meta", node.Spec.ProviderID)
 		// try delete node and ignore error, retry next loop
 		deleteNode(cnc, node)
@@ -373,7 +402,7 @@ func (cnc *CloudNodeController) doAddCloudNode(node *v1.Node) error {
 			// If user provided an IP address, ensure that IP address is found
 			// in the cloud provider before removing the taint on the node
 			nodeIP, ok := isProvidedAddrExist(curNode, cloudins.Addresses)
-			if ok && nodeIP == nil {
+			if ok && nodeIP == nil && !AllowProvidedIPOverride {
 				klog.Errorf("failed to get specified nodeIP in cloudprovider, fail fast")
 				return true, nil
 			}
@@ -564,6 +593,7 @@ func deleteNode(cnc *CloudNodeController, node *v1.Node) {
 			context.Background(), nodeName, metav1.DeleteOptions{},
 		); err != nil {
 			klog.Errorf("unable to delete node %q: %v", nodeName, err)
+			metric.NodeDeletion.WithLabelValues("failed").Inc()
 			cnc.recorder.Eventf(
 				ref,
 				v1.EventTypeWarning,
@@ -572,12 +602,14 @@ func deleteNode(cnc *CloudNodeController, node *v1.Node) {
 				err.Error(),
 			)
 		} else {
+			metric.NodeDeletion.WithLabelValues("deleted").Inc()
 			cnc.recorder.Eventf(
 				ref,
 				v1.EventTypeNormal,
 				"DeletedNode",
 				"Deleted node",
 			)
+			notifyNodeDeleted(nodeName)
 		}
 	}(node.Name)
 }
@@ -681,6 +713,8 @@ func removeCloudTaints(node *v1.Node) {
 	node.Spec.Taints = excludeTaintFromList(node.Spec.Taints, *cloudTaint)
 }
 
+// nodeLists the nodes managed, the ones excluded only from the loadbalancers
+// are kept.
 func nodeLists(kclient kubernetes.Interface) (*v1.NodeList, error) {
 	allNodes, err := kclient.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{ResourceVersion: "0"})
 	if allNodes == nil {
@@ -1,2 +1,566 @@
 package node
 
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"k8s.io/cloud-provider/node/helpers"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+)
+
+const providerID = "cn-hangzhou.i-instance"
+
+// fakeInstances the instances listed, err when the api is unreachable
+type fakeInstances struct {
+	cloudprovider.Interface
+	instances map[string]*CloudNodeAttribute
+	err       error
+	// listed the ids of each ListInstances call
+	listed [][]string
+}
+
+func (f *fakeInstances) SetInstanceTags(ctx context.Context, insid string, tags map[string]string) error {
+	return nil
+}
+
+func (f *fakeInstances) ListInstances(ctx context.Context, ids []string) (map[string]*CloudNodeAttribute, error) {
+	f.listed = append(f.listed, ids)
+	if f.err != nil {
+		return nil, f.err
+	}
+	instances := map[string]*CloudNodeAttribute{}
+	for _, id := range ids {
+		instances[id] = f.instances[id]
+	}
+	return instances, nil
+}
+
+func TestInstanceCondition(t *testing.T) {
+	cases := []struct {
+		ins    *CloudNodeAttribute
+		status v1.ConditionStatus
+		reason string
+	}{
+		{ins: &CloudNodeAttribute{Status: "Running"}, status: v1.ConditionTrue, reason: InstanceReasonRunning},
+		{ins: &CloudNodeAttribute{Status: "Stopping"}, status: v1.ConditionFalse, reason: InstanceReasonStopped},
+		{ins: &CloudNodeAttribute{Status: "Stopped"}, status: v1.ConditionFalse, reason: InstanceReasonStopped},
+		{ins: &CloudNodeAttribute{Status: "Running", Expired: true}, status: v1.ConditionFalse, reason: InstanceReasonExpired},
+		{ins: &CloudNodeAttribute{Status: "Deleted"}, status: v1.ConditionFalse, reason: InstanceReasonNotFound},
+		{ins: nil, status: v1.ConditionFalse, reason: InstanceReasonNotFound},
+		{ins: &CloudNodeAttribute{Status: "Starting"}, status: v1.ConditionUnknown, reason: "Starting"},
+	}
+	for _, c := range cases {
+		status, reason, _ := instanceCondition(c.ins)
+		if status != c.status || reason != c.reason {
+			t.Errorf("instance %+v: expect %s %s, got %s %s", c.ins, c.status, c.reason, status, reason)
+		}
+	}
+}
+
+func TestInstanceHealthyCondition(t *testing.T) {
+	ago := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker"},
+		Spec:       v1.NodeSpec{ProviderID: providerID},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionTrue},
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(node)
+	cloud := &fakeInstances{instances: map[string]*CloudNodeAttribute{}}
+	cnc := &CloudNodeController{
+		kclient:  client,
+		cloud:    cloud,
+		recorder: record.NewFakeRecorder(10),
+	}
+	// sweep the node, returns its InstanceHealthy condition
+	sweep := func() *v1.NodeCondition {
+		current, err := client.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get node: %s", err.Error())
+		}
+		_ = cnc.syncCloudNodes([]v1.Node{*current})
+		current, err = client.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expect the node not deleted by the condition: %s", err.Error())
+		}
+		_, condition := helpers.GetNodeCondition(&current.Status, NodeConditionInstanceHealthy)
+		return condition
+	}
+	expect := func(step string, condition *v1.NodeCondition, status v1.ConditionStatus, reason string) {
+		if condition == nil {
+			t.Fatalf("%s: expect condition %s %s, got none", step, status, reason)
+		}
+		if condition.Status != status || condition.Reason != reason {
+			t.Fatalf("%s: expect condition %s %s, got %s %s", step, status, reason, condition.Status, condition.Reason)
+		}
+	}
+
+	// the api unreachable adds no condition
+	cloud.err = fmt.Errorf("ServiceUnavailable")
+	if condition := sweep(); condition != nil {
+		t.Fatalf("expect no condition added while the api is unreachable, got %+v", condition)
+	}
+
+	cloud.err = nil
+	cloud.instances[providerID] = &CloudNodeAttribute{InstanceID: "i-instance", Status: "Running"}
+	running := sweep()
+	expect("running", running, v1.ConditionTrue, InstanceReasonRunning)
+
+	// the transition time is kept while the status is unchanged
+	if err := patchTransition(client, node.Name, ago); err != nil {
+		t.Fatalf("patch transition time: %s", err.Error())
+	}
+	if condition := sweep(); !condition.LastTransitionTime.Equal(&ago) {
+		t.Fatalf("expect the transition time kept, got %s", condition.LastTransitionTime)
+	}
+
+	cloud.instances[providerID].Status = "Stopped"
+	stopped := sweep()
+	expect("stopped", stopped, v1.ConditionFalse, InstanceReasonStopped)
+	if stopped.LastTransitionTime.Equal(&ago) {
+		t.Fatalf("expect the transition time updated by the status change")
+	}
+
+	// the reason changes within the same status
+	cloud.instances[providerID].Expired = true
+	expired := sweep()
+	expect("expired", expired, v1.ConditionFalse, InstanceReasonExpired)
+	if !expired.LastTransitionTime.Equal(&stopped.LastTransitionTime) {
+		t.Fatalf("expect the transition time kept by the reason change, got %s", expired.LastTransitionTime)
+	}
+
+	cloud.err = fmt.Errorf("ServiceUnavailable")
+	expect("api unavailable", sweep(), v1.ConditionUnknown, InstanceReasonAPIUnavailable)
+
+	// the ready node of a missing instance is not deleted by the condition
+	cloud.err = nil
+	delete(cloud.instances, providerID)
+	expect("not found", sweep(), v1.ConditionFalse, InstanceReasonNotFound)
+
+	cloud.instances[providerID] = &CloudNodeAttribute{InstanceID: "i-instance", Status: "Running"}
+	expect("running again", sweep(), v1.ConditionTrue, InstanceReasonRunning)
+}
+
+// patchTransition set the transition time of the InstanceHealthy condition
+func patchTransition(client *fake.Clientset, name string, at metav1.Time) error {
+	node, err := client.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	i, condition := helpers.GetNodeCondition(&node.Status, NodeConditionInstanceHealthy)
+	if condition == nil {
+		return fmt.Errorf("no instance condition")
+	}
+	node.Status.Conditions[i].LastTransitionTime = at
+	_, err = client.CoreV1().Nodes().UpdateStatus(context.TODO(), node, metav1.UpdateOptions{})
+	return err
+}
+
+func TestDisableNodeDeletion(t *testing.T) {
+	defer func(origin bool) { EnableNodeDeletion = origin }(EnableNodeDeletion)
+	EnableNodeDeletion = false
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker"},
+		Spec:       v1.NodeSpec{ProviderID: providerID},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionUnknown},
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(node)
+	cloud := &fakeInstances{instances: map[string]*CloudNodeAttribute{}}
+	recorder := record.NewFakeRecorder(10)
+	cnc := &CloudNodeController{
+		kclient:  client,
+		cloud:    cloud,
+		recorder: recorder,
+	}
+	sweep := func() *v1.Node {
+		current, err := client.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get node: %s", err.Error())
+		}
+		if err := cnc.syncCloudNodes([]v1.Node{*current}); err != nil {
+			t.Fatalf("sync cloud nodes: %s", err.Error())
+		}
+		current, err = client.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expect the node not deleted: %s", err.Error())
+		}
+		return current
+	}
+	marked := func() float64 {
+		return testutil.ToFloat64(metric.NodeDeletion.WithLabelValues("marked"))
+	}
+	origin := marked()
+
+	// the node of the missing instance is marked, not deleted
+	current := sweep()
+	if findInstanceNotFoundTaint(current.Spec.Taints) == nil {
+		t.Fatalf("expect the node tainted %s, got %v", TaintInstanceNotFound, current.Spec.Taints)
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expect an event of the missing instance, got %d", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.Contains(event, "InstanceNotFound") {
+		t.Fatalf("expect the InstanceNotFound event, got %s", event)
+	}
+	if marked() != origin+1 {
+		t.Fatalf("expect the marking counted, got %v", marked()-origin)
+	}
+
+	// the marked node is neither marked nor warned again
+	sweep()
+	if len(recorder.Events) != 0 || marked() != origin+1 {
+		t.Fatalf("expect the marked node not marked again, got %d events", len(recorder.Events))
+	}
+
+	// the instance reappears
+	cloud.instances[providerID] = &CloudNodeAttribute{InstanceID: "i-instance", Status: "Running"}
+	if current := sweep(); findInstanceNotFoundTaint(current.Spec.Taints) != nil {
+		t.Fatalf("expect the taint removed, got %v", current.Spec.Taints)
+	}
+
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "delete" {
+			t.Fatalf("expect no node deleted, got %v", action)
+		}
+	}
+}
+
+func TestNodeDeletionHook(t *testing.T) {
+	defer func(origin bool) { EnableNodeDeletion = origin }(EnableNodeDeletion)
+	defer SetOnNodeDeleted(nil)
+	EnableNodeDeletion = true
+	deleted := make(chan string, 1)
+	SetOnNodeDeleted(func(node string) { deleted <- node })
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker"},
+		Spec:       v1.NodeSpec{ProviderID: providerID},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionUnknown},
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(node)
+	cnc := &CloudNodeController{
+		kclient:  client,
+		cloud:    &fakeInstances{instances: map[string]*CloudNodeAttribute{}},
+		recorder: record.NewFakeRecorder(10),
+	}
+	if err := cnc.syncCloudNodes([]v1.Node{*node}); err != nil {
+		t.Fatalf("sync cloud nodes: %s", err.Error())
+	}
+	select {
+	case name := <-deleted:
+		if name != node.Name {
+			t.Fatalf("expect the hook called with %s, got %s", node.Name, name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expect the hook called once the node is deleted")
+	}
+	if _, err := client.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{}); err == nil {
+		t.Fatalf("expect the node deleted before the hook is called")
+	}
+}
+
+func TestProvidedIPOverride(t *testing.T) {
+	defer func(origin bool) { AllowProvidedIPOverride = origin }(AllowProvidedIPOverride)
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "worker",
+			Annotations: map[string]string{kubeletapis.AnnotationProvidedIPAddr: "192.168.0.10"},
+		},
+		Spec: v1.NodeSpec{ProviderID: providerID},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.0.10"}},
+		},
+	}
+	// the primary private ip of the instance is changed
+	cloud := &fakeInstances{instances: map[string]*CloudNodeAttribute{
+		providerID: {
+			InstanceID: "i-instance",
+			Status:     "Running",
+			Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.1.20"}},
+		},
+	}}
+	sync := func(allow bool) (*v1.Node, *record.FakeRecorder) {
+		AllowProvidedIPOverride = allow
+		client := fake.NewSimpleClientset(node.DeepCopy())
+		recorder := record.NewFakeRecorder(10)
+		cnc := &CloudNodeController{kclient: client, cloud: cloud, recorder: recorder}
+		if err := cnc.syncNodeAddress([]v1.Node{*node.DeepCopy()}); err != nil {
+			t.Fatalf("sync node address: %s", err.Error())
+		}
+		current, err := client.CoreV1().Nodes().Get(context.TODO(), node.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get node: %s", err.Error())
+		}
+		return current, recorder
+	}
+	internalIP := func(node *v1.Node) string {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == v1.NodeInternalIP {
+				return addr.Address
+			}
+		}
+		return ""
+	}
+	event := func(recorder *record.FakeRecorder) string {
+		if len(recorder.Events) != 1 {
+			t.Fatalf("expect an event of the provided ip, got %d", len(recorder.Events))
+		}
+		return <-recorder.Events
+	}
+
+	current, recorder := sync(false)
+	if ip := internalIP(current); ip != "192.168.0.10" {
+		t.Fatalf("expect the provided ip kept without the override, got %s", ip)
+	}
+	if e := event(recorder); !strings.Contains(e, "ProvidedIPNotFound") || !strings.Contains(e, "not updated") {
+		t.Fatalf("expect the ProvidedIPNotFound event, got %s", e)
+	}
+
+	current, recorder = sync(true)
+	if ip := internalIP(current); ip != "192.168.1.20" {
+		t.Fatalf("expect the address of the instance with the override, got %s", ip)
+	}
+	if e := event(recorder); !strings.Contains(e, "ProvidedIPNotFound") || !strings.Contains(e, "overridden") {
+		t.Fatalf("expect the ProvidedIPNotFound event, got %s", e)
+	}
+
+	// the provided ip still found is kept and not warned
+	cloud.instances[providerID].Addresses = append(cloud.instances[providerID].Addresses,
+		v1.NodeAddress{Type: v1.NodeInternalIP, Address: "192.168.0.10"})
+	current, recorder = sync(true)
+	if ip := internalIP(current); ip != "192.168.0.10" || len(recorder.Events) != 0 {
+		t.Fatalf("expect the provided ip kept without event, got %s and %d events", ip, len(recorder.Events))
+	}
+}
+
+func TestReconcileLabels(t *testing.T) {
+	labeled := func(name, zone string, extra map[string]string) *v1.Node {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					v1.LabelInstanceType:            "ecs.g6.large",
+					v1.LabelInstanceTypeStable:      "ecs.g6.large",
+					v1.LabelZoneFailureDomain:       zone,
+					v1.LabelZoneFailureDomainStable: zone,
+					v1.LabelZoneRegion:              "cn-hangzhou",
+					v1.LabelZoneRegionStable:        "cn-hangzhou",
+				},
+			},
+			Spec: v1.NodeSpec{ProviderID: "cn-hangzhou." + name},
+		}
+		for k, v := range extra {
+			node.Labels[k] = v
+		}
+		return node
+	}
+	drifted := labeled("i-drifted", "cn-hangzhou-b", nil)
+	correct := labeled("i-correct", "cn-hangzhou-g", nil)
+	virtual := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "virtual-kubelet", Labels: map[string]string{LabelNodeType: NodeTypeVirtual}},
+		Spec:       v1.NodeSpec{ProviderID: "cn-hangzhou.eci-virtual"},
+	}
+	client := fake.NewSimpleClientset(drifted, correct, virtual)
+	instance := &CloudNodeAttribute{InstanceType: "ecs.g6.large", Zone: "cn-hangzhou-g", Region: "cn-hangzhou"}
+	cloud := &fakeInstances{
+		instances: map[string]*CloudNodeAttribute{
+			drifted.Spec.ProviderID: instance,
+			correct.Spec.ProviderID: instance,
+		},
+	}
+	cnc := &CloudNodeController{
+		kclient:   client,
+		cloud:     cloud,
+		recorder:  record.NewFakeRecorder(10),
+		instances: newInstanceCache(),
+	}
+	repaired := func(label string) float64 {
+		return testutil.ToFloat64(metric.NodeLabelDriftRepaired.WithLabelValues(label))
+	}
+	zone, zoneStable := repaired(v1.LabelZoneFailureDomain), repaired(v1.LabelZoneFailureDomainStable)
+	metric.NodesMissingLabels.Set(-1)
+	// wait for the zone labels of the drifted node to be repaired
+	waitRepaired := func(step string) {
+		err := wait.PollImmediate(20*time.Millisecond, 5*time.Second, func() (bool, error) {
+			current, err := client.CoreV1().Nodes().Get(context.TODO(), drifted.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			return current.Labels[v1.LabelZoneFailureDomain] == "cn-hangzhou-g" &&
+				current.Labels[v1.LabelZoneFailureDomainStable] == "cn-hangzhou-g", nil
+		})
+		if err != nil {
+			t.Fatalf("%s: expect the zone labels repaired: %v", step, err)
+		}
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		wait.Until(cnc.reconcileLabels, 50*time.Millisecond, stop)
+		close(done)
+	}()
+	waitRepaired("drifted zone")
+
+	// the label drifts again and is repaired on the next pass
+	current, err := client.CoreV1().Nodes().Get(context.TODO(), drifted.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get node: %s", err.Error())
+	}
+	delete(current.Labels, v1.LabelZoneFailureDomainStable)
+	if _, err := client.CoreV1().Nodes().Update(context.TODO(), current, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update node: %s", err.Error())
+	}
+	waitRepaired("removed zone")
+	close(stop)
+	<-done
+
+	if repaired(v1.LabelZoneFailureDomain) != zone+1 || repaired(v1.LabelZoneFailureDomainStable) != zoneStable+2 {
+		t.Fatalf("expect the zone labels repaired counted, got %v %v",
+			repaired(v1.LabelZoneFailureDomain)-zone, repaired(v1.LabelZoneFailureDomainStable)-zoneStable)
+	}
+	if missing := testutil.ToFloat64(metric.NodesMissingLabels); missing != 0 {
+		t.Fatalf("expect no node missing labels, got %v", missing)
+	}
+	patched := map[string]int{}
+	for _, action := range client.Actions() {
+		if patch, ok := action.(interface{ GetName() string }); ok && action.GetVerb() == "patch" {
+			patched[patch.GetName()]++
+		}
+	}
+	if patched[drifted.Name] != 2 || patched[correct.Name] != 0 || patched[virtual.Name] != 0 {
+		t.Fatalf("expect only the drifted node patched once each drift, got %v", patched)
+	}
+	// the instances are listed once within the ttl, the virtual node skipped
+	if len(cloud.listed) != 1 || len(cloud.listed[0]) != 2 {
+		t.Fatalf("expect the instances listed once from the cache, got %v", cloud.listed)
+	}
+}
+
+// slowInstances lists the instances in delay, counting the calls at once
+type slowInstances struct {
+	cloudprovider.Interface
+	delay time.Duration
+	// calls the calls of ListInstances, running the ones running
+	calls, running, overlapped int32
+}
+
+func (s *slowInstances) SetInstanceTags(ctx context.Context, insid string, tags map[string]string) error {
+	return nil
+}
+
+func (s *slowInstances) ListInstances(ctx context.Context, ids []string) (map[string]*CloudNodeAttribute, error) {
+	atomic.AddInt32(&s.calls, 1)
+	if atomic.AddInt32(&s.running, 1) > 1 {
+		atomic.AddInt32(&s.overlapped, 1)
+	}
+	defer atomic.AddInt32(&s.running, -1)
+	time.Sleep(s.delay)
+	return map[string]*CloudNodeAttribute{}, nil
+}
+
+func TestLoopOverrun(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker"},
+		Spec:       v1.NodeSpec{ProviderID: providerID},
+	}
+	cloud := &slowInstances{delay: 200 * time.Millisecond}
+	cnc := &CloudNodeController{
+		kclient:  fake.NewSimpleClientset(node),
+		cloud:    cloud,
+		recorder: record.NewFakeRecorder(10),
+	}
+	overruns := func() float64 {
+		return testutil.ToFloat64(metric.NodeLoopOverruns.WithLabelValues("address"))
+	}
+	before := overruns()
+
+	// ticks every 20ms while an iteration takes 200ms
+	stop := make(chan struct{})
+	go every("address", cnc.updateAddresses, 20*time.Millisecond, stop)
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	// the last iteration finishes
+	time.Sleep(300 * time.Millisecond)
+
+	calls := atomic.LoadInt32(&cloud.calls)
+	if calls < 2 || calls > 3 {
+		t.Fatalf("expect an iteration per delay, got %d calls", calls)
+	}
+	if overlapped := atomic.LoadInt32(&cloud.overlapped); overlapped != 0 {
+		t.Fatalf("expect the iterations never overlap, got %d overlapped", overlapped)
+	}
+	if overruns()-before < 10 {
+		t.Fatalf("expect the ticks skipped counted as overruns, got %v", overruns()-before)
+	}
+}
+
+func TestValidatePeriodOptions(t *testing.T) {
+	cases := []struct {
+		name     string
+		options  PeriodOptions
+		invalid  bool
+		warnings int
+	}{
+		{name: "from the cloud config", options: PeriodOptions{}},
+		{name: "monitor period too short", options: PeriodOptions{MonitorPeriod: time.Second}, invalid: true},
+		{name: "status update too frequent", options: PeriodOptions{StatusUpdateFrequency: 10 * time.Second}, invalid: true},
+		{
+			name:     "monitor period above the grace period",
+			options:  PeriodOptions{MonitorPeriod: time.Minute, MonitorGracePeriod: 40 * time.Second},
+			warnings: 1,
+		},
+		{
+			name:     "status update within the cache ttl",
+			options:  PeriodOptions{StatusUpdateFrequency: time.Minute},
+			warnings: 1,
+		},
+		{
+			name:    "sane periods",
+			options: PeriodOptions{MonitorPeriod: 30 * time.Second, StatusUpdateFrequency: 5 * time.Minute, MonitorGracePeriod: 40 * time.Second},
+		},
+	}
+	for _, c := range cases {
+		warnings, err := ValidatePeriodOptions(c.options)
+		if (err != nil) != c.invalid {
+			t.Errorf("%s: expect invalid %t, got %v", c.name, c.invalid, err)
+		}
+		if len(warnings) != c.warnings {
+			t.Errorf("%s: expect %d warnings, got %v", c.name, c.warnings, warnings)
+		}
+	}
+
+	// the cloud config is taken unless the flags are set, raised to the minimums
+	effective := PeriodOptions{MonitorPeriod: time.Minute}.effective(5*time.Second, 240*time.Second)
+	if effective.MonitorPeriod != time.Minute || effective.StatusUpdateFrequency != 240*time.Second {
+		t.Fatalf("expect the flag to take precedence over the cloud config, got %+v", effective)
+	}
+	effective = PeriodOptions{}.effective(5*time.Second, 10*time.Second)
+	if effective.MonitorPeriod != MIN_MONITOR_PERIOD || effective.StatusUpdateFrequency != MIN_STATUS_UPDATE_FREQUENCY {
+		t.Fatalf("expect the cloud config raised to the minimums, got %+v", effective)
+	}
+}
@@ -0,0 +1,83 @@
+package node
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"k8s.io/klog"
+	"sync/atomic"
+)
+
+// A node not ready whose instance is not found is deleted by the existence
+// sweep. With --enable-node-deletion=false the node is tainted and warned by
+// an event instead, to be deleted by the operator, and the taint is removed
+// once the instance reappears, eg. a false positive of the api.
+
+// EnableNodeDeletion whether the nodes of the missing instances are deleted
+var EnableNodeDeletion = true
+
+// onNodeDeleted the func(node string) called with the name of each node
+// deleted, see SetOnNodeDeleted
+var onNodeDeleted atomic.Value
+
+// SetOnNodeDeleted set the hook called with the name of each node deleted, so
+// that the loadbalancers drop it at once instead of waiting for the node
+// informer. Set by the service controller, which starts after the node
+// controller is running, none if it is not running.
+func SetOnNodeDeleted(hook func(node string)) {
+	onNodeDeleted.Store(hook)
+}
+
+func notifyNodeDeleted(node string) {
+	if hook, ok := onNodeDeleted.Load().(func(string)); ok && hook != nil {
+		hook(node)
+	}
+}
+
+// TaintInstanceNotFound taint of the node whose instance is not found, when
+// the node deletion is disabled
+const TaintInstanceNotFound = "node.alibabacloud.com/instance-not-found"
+
+func findInstanceNotFoundTaint(taints []v1.Taint) *v1.Taint {
+	for i := range taints {
+		if taints[i].Key == TaintInstanceNotFound {
+			return &taints[i]
+		}
+	}
+	return nil
+}
+
+// markInstanceNotFound taint the node and warn, instead of deleting it
+func (cnc *CloudNodeController) markInstanceNotFound(node *v1.Node) {
+	if findInstanceNotFoundTaint(node.Spec.Taints) != nil {
+		return
+	}
+	klog.Warningf("node %s not found, node deletion disabled, mark it with taint %s",
+		node.Spec.ProviderID, TaintInstanceNotFound)
+	clone := node.DeepCopy()
+	clone.Spec.Taints = append(clone.Spec.Taints, v1.Taint{
+		Key:    TaintInstanceNotFound,
+		Effect: v1.TaintEffectNoSchedule,
+	})
+	if _, err := PatchNode(cnc.kclient, node, clone); err != nil {
+		klog.Errorf("mark node %s instance not found, wait for next retry: %s", node.Name, err.Error())
+		return
+	}
+	metric.NodeDeletion.WithLabelValues("marked").Inc()
+	cnc.recorder.Eventf(
+		node,
+		v1.EventTypeWarning,
+		"InstanceNotFound",
+		"Instance %s of the node is not found, the node is not deleted as the node deletion is disabled",
+		node.Spec.ProviderID,
+	)
+}
+
+// unmarkInstanceNotFound remove the taint of the node whose instance reappears
+func (cnc *CloudNodeController) unmarkInstanceNotFound(node *v1.Node) {
+	klog.Infof("node %s found again, remove taint %s", node.Spec.ProviderID, TaintInstanceNotFound)
+	clone := node.DeepCopy()
+	clone.Spec.Taints = excludeTaintFromList(clone.Spec.Taints, *findInstanceNotFoundTaint(node.Spec.Taints))
+	if _, err := PatchNode(cnc.kclient, node, clone); err != nil {
+		klog.Errorf("unmark node %s instance not found, wait for next retry: %s", node.Name, err.Error())
+	}
+}
@@ -0,0 +1,74 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// INSTANCE_CACHE_TTL how long the instances listed are shared by the address
+// sync and the label reconcile, so that the passes running close to each
+// other list the instances once.
+var INSTANCE_CACHE_TTL = 2 * time.Minute
+
+// instanceCache the instances listed by ListInstances keyed by provider id.
+// The existence sweep lists the instances without it, a missing instance
+// must not be taken from the cache.
+type instanceCache struct {
+	lock      sync.Mutex
+	instances map[string]cachedInstance
+}
+
+type cachedInstance struct {
+	// ins nil if the instance is not found
+	ins    *CloudNodeAttribute
+	expire time.Time
+}
+
+func newInstanceCache() *instanceCache {
+	return &instanceCache{instances: make(map[string]cachedInstance)}
+}
+
+// list the instances of the ids, only the ones not cached or expired are
+// listed from the cloud. A nil cache lists all of them.
+func (c *instanceCache) list(
+	ctx context.Context, cloud CloudInstance, ids []string,
+) (map[string]*CloudNodeAttribute, error) {
+	if c == nil {
+		return cloud.ListInstances(ctx, ids)
+	}
+	result := make(map[string]*CloudNodeAttribute)
+	var missed []string
+	now := time.Now()
+	c.lock.Lock()
+	for _, id := range ids {
+		cached, ok := c.instances[id]
+		if !ok || now.After(cached.expire) {
+			missed = append(missed, id)
+			continue
+		}
+		result[id] = cached.ins
+	}
+	c.lock.Unlock()
+	if len(missed) == 0 {
+		return result, nil
+	}
+
+	listed, err := cloud.ListInstances(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, id := range missed {
+		result[id] = listed[id]
+		c.instances[id] = cachedInstance{ins: listed[id], expire: now.Add(INSTANCE_CACHE_TTL)}
+	}
+	// drop the expired ones of the nodes gone
+	for id, cached := range c.instances {
+		if now.After(cached.expire) {
+			delete(c.instances, id)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,176 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"k8s.io/klog"
+)
+
+// The instance type, zone and region labels are set from the instance when
+// the node is initialized, and repaired by the label reconcile every
+// LabelReconcilePeriod once they drift, eg. removed or overwritten by hand.
+// The reconcile runs apart from the address sync, so that the labels are
+// neither checked at the cost of the address sync nor left drifted as long.
+// The virtual nodes, eg. the ECI nodes of virtual-kubelet, have no instance
+// and are skipped, as are the nodes not initialized yet.
+
+// LabelReconcilePeriod how often the cloud labels of the nodes are
+// reconciled, 0 disables the reconcile
+var LabelReconcilePeriod = 10 * time.Minute
+
+const (
+	// LabelNodeType the label telling the type of a node
+	LabelNodeType = "type"
+	// NodeTypeVirtual the type of the virtual-kubelet nodes
+	NodeTypeVirtual = "virtual-kubelet"
+)
+
+// requiredLabels the cloud labels every node is expected to carry
+var requiredLabels = []string{
+	v1.LabelInstanceType,
+	v1.LabelInstanceTypeStable,
+	v1.LabelZoneFailureDomain,
+	v1.LabelZoneFailureDomainStable,
+	v1.LabelZoneRegion,
+	v1.LabelZoneRegionStable,
+}
+
+// isVirtualNode whether the node is a virtual node without an instance
+func isVirtualNode(node *v1.Node) bool {
+	return node.Labels[LabelNodeType] == NodeTypeVirtual
+}
+
+// cloudLabels the cloud labels of the node of the instance
+func cloudLabels(ins *CloudNodeAttribute) map[string]string {
+	labels := make(map[string]string)
+	if ins.InstanceType != "" {
+		labels[v1.LabelInstanceType] = ins.InstanceType
+		labels[v1.LabelInstanceTypeStable] = ins.InstanceType
+	}
+	if ins.Zone != "" {
+		labels[v1.LabelZoneFailureDomain] = ins.Zone
+		labels[v1.LabelZoneFailureDomainStable] = ins.Zone
+	}
+	if ins.Region != "" {
+		labels[v1.LabelZoneRegion] = ins.Region
+		labels[v1.LabelZoneRegionStable] = ins.Region
+	}
+	return labels
+}
+
+// missingLabels whether the node lacks any of the required labels
+func missingLabels(node *v1.Node) bool {
+	for _, label := range requiredLabels {
+		if node.Labels[label] == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcileLabels repair the drifted cloud labels of all the nodes, and
+// export the number of the nodes still missing any of them
+func (cnc *CloudNodeController) reconcileLabels() {
+	start := time.Now()
+	nodes, err := nodeLists(cnc.kclient)
+	if err != nil {
+		klog.Errorf("Error monitoring node labels: %v", err)
+		return
+	}
+	missing := 0
+	err = batchAddressUpdate(
+		nodes.Items,
+		func(batch []v1.Node) error {
+			n, err := cnc.syncNodeLabels(batch)
+			missing += n
+			return err
+		},
+	)
+	if err != nil {
+		klog.Errorf("periodically reconcile node labels: %s", err.Error())
+		return
+	}
+	metric.NodesMissingLabels.Set(float64(missing))
+	metric.NodeLatency.WithLabelValues("reconcile_labels").Observe(metric.MsSince(start))
+}
+
+// syncNodeLabels repair the cloud labels of the nodes drifted from their
+// instances, returns the number of the nodes still missing any of them
+func (cnc *CloudNodeController) syncNodeLabels(nodes []v1.Node) (int, error) {
+	ins, ok := cnc.cloud.(CloudInstance)
+	if !ok {
+		return 0, fmt.Errorf("cloud instance not implemented")
+	}
+	var managed []v1.Node
+	for _, node := range nodes {
+		// the labels of the nodes not initialized are set by doAddCloudNode
+		if isVirtualNode(&node) || findCloudTaint(node.Spec.Taints) != nil {
+			continue
+		}
+		managed = append(managed, node)
+	}
+	if len(managed) == 0 {
+		return 0, nil
+	}
+	instances, err := cnc.instances.list(context.Background(), ins, nodeids(managed))
+	if err != nil {
+		return 0, fmt.Errorf("syncNodeLabels, retrieve instances from api error: %s", err.Error())
+	}
+
+	missing := 0
+	for i := range managed {
+		node := &managed[i]
+		cloudNode := instances[node.Spec.ProviderID]
+		if cloudNode == nil {
+			klog.Infof("node %s not found, skip reconcile node labels", node.Spec.ProviderID)
+			if missingLabels(node) {
+				missing++
+			}
+			continue
+		}
+		if !cnc.repairLabels(node, cloudLabels(cloudNode)) {
+			missing++
+		}
+	}
+	return missing, nil
+}
+
+// repairLabels patch the labels of the node drifted from the expected ones,
+// returns whether the node carries all the required labels afterwards
+func (cnc *CloudNodeController) repairLabels(node *v1.Node, expected map[string]string) bool {
+	clone := node.DeepCopy()
+	if clone.Labels == nil {
+		clone.Labels = make(map[string]string)
+	}
+	var drifted []string
+	for _, label := range requiredLabels {
+		value, ok := expected[label]
+		if !ok || clone.Labels[label] == value {
+			continue
+		}
+		klog.Infof("node %s: repair drifted label %s=%s, was [%s]", node.Name, label, value, clone.Labels[label])
+		clone.Labels[label] = value
+		drifted = append(drifted, label)
+	}
+	if len(drifted) == 0 {
+		return !missingLabels(node)
+	}
+	if _, err := PatchNode(cnc.kclient, node, clone); err != nil {
+		klog.Errorf("Wait for next retry, patch node labels error: %s", err.Error())
+		cnc.recorder.Eventf(
+			node,
+			v1.EventTypeWarning,
+			"SyncNodeFailed",
+			"Error patching node labels: %s", err.Error(),
+		)
+		return !missingLabels(node)
+	}
+	for _, label := range drifted {
+		metric.NodeLabelDriftRepaired.WithLabelValues(label).Inc()
+	}
+	return !missingLabels(clone)
+}
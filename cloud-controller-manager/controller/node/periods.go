@@ -0,0 +1,139 @@
+package node
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"k8s.io/klog"
+)
+
+// The node controller lists the instances every StatusUpdateFrequency to
+// sync the addresses, and every MonitorPeriod to check they still exist. The
+// periods are set by flags, or else by the nodeAddrSyncPeriod and the
+// nodeMonitorPeriod of the cloud config. Each loop ticks at its period
+// whatever the time an iteration takes, and skips the tick while the previous
+// iteration is still running, eg. the instances are slow to be described, so
+// that no two iterations of a loop call the api at once. The skipped ticks are
+// counted as overruns of the loop.
+
+const (
+	// MIN_MONITOR_PERIOD the minimum period of the existence check
+	MIN_MONITOR_PERIOD = 10 * time.Second
+	// MIN_STATUS_UPDATE_FREQUENCY the minimum period of the address sync, well
+	// above the latency of describing a batch of instances
+	MIN_STATUS_UPDATE_FREQUENCY = 30 * time.Second
+)
+
+// PeriodOptions the periods of the loops of the node controller
+type PeriodOptions struct {
+	// MonitorPeriod the period of the existence check, 0 to take the one of
+	// the cloud config
+	MonitorPeriod time.Duration
+	// StatusUpdateFrequency the period of the address sync, 0 to take the one
+	// of the cloud config
+	StatusUpdateFrequency time.Duration
+	// MonitorGracePeriod the nodeMonitorGracePeriod of kube-controller-manager
+	// the monitor period is checked against, 0 if unknown
+	MonitorGracePeriod time.Duration
+}
+
+// Options the periods set by flags
+var Options PeriodOptions
+
+// ValidatePeriodOptions error on a period below its minimum, and returns the
+// warnings of the periods interacting badly
+func ValidatePeriodOptions(o PeriodOptions) ([]string, error) {
+	for _, p := range []struct {
+		name   string
+		period time.Duration
+		min    time.Duration
+	}{
+		{"node monitor period", o.MonitorPeriod, MIN_MONITOR_PERIOD},
+		{"node status update frequency", o.StatusUpdateFrequency, MIN_STATUS_UPDATE_FREQUENCY},
+	} {
+		if p.period < 0 || (p.period != 0 && p.period < p.min) {
+			return nil, fmt.Errorf("%s %s must be 0 or at least %s", p.name, p.period, p.min)
+		}
+	}
+	if o.MonitorGracePeriod < 0 {
+		return nil, fmt.Errorf("node monitor grace period %s must not be negative", o.MonitorGracePeriod)
+	}
+	return o.warnings(), nil
+}
+
+// warnings the periods set interacting badly
+func (o PeriodOptions) warnings() []string {
+	var warnings []string
+	if o.MonitorGracePeriod > 0 && o.MonitorPeriod > o.MonitorGracePeriod {
+		warnings = append(warnings, fmt.Sprintf("node monitor period %s is above the node monitor grace "+
+			"period %s, the nodes of the missing instances race kubelet recovering them", o.MonitorPeriod, o.MonitorGracePeriod))
+	}
+	if o.StatusUpdateFrequency > 0 && o.StatusUpdateFrequency < INSTANCE_CACHE_TTL {
+		warnings = append(warnings, fmt.Sprintf("node status update frequency %s is below the instance cache "+
+			"ttl %s, the addresses are synced from the cache in between", o.StatusUpdateFrequency, INSTANCE_CACHE_TTL))
+	}
+	return warnings
+}
+
+// effective the periods the loops run at. The flags take precedence over the
+// cloud config, a period of the cloud config below its minimum is raised to
+// it.
+func (o PeriodOptions) effective(monitor, status time.Duration) PeriodOptions {
+	if o.MonitorPeriod == 0 {
+		o.MonitorPeriod = monitor
+		if o.MonitorPeriod < MIN_MONITOR_PERIOD {
+			klog.Warningf("node monitor period %s of the cloud config is below %s, raised to it",
+				o.MonitorPeriod, MIN_MONITOR_PERIOD)
+			o.MonitorPeriod = MIN_MONITOR_PERIOD
+		}
+	}
+	if o.StatusUpdateFrequency == 0 {
+		o.StatusUpdateFrequency = status
+		if o.StatusUpdateFrequency < MIN_STATUS_UPDATE_FREQUENCY {
+			klog.Warningf("node status update frequency %s of the cloud config is below %s, raised to it",
+				o.StatusUpdateFrequency, MIN_STATUS_UPDATE_FREQUENCY)
+			o.StatusUpdateFrequency = MIN_STATUS_UPDATE_FREQUENCY
+		}
+	}
+	return o
+}
+
+// loopGuard skips an iteration of a periodic loop while the previous one is
+// still running
+type loopGuard struct {
+	loop    string
+	running int32
+}
+
+// run the iteration unless the previous one is still running, which is
+// counted as an overrun of the loop. Returns whether it has run.
+func (g *loopGuard) run(iteration func()) bool {
+	if !atomic.CompareAndSwapInt32(&g.running, 0, 1) {
+		metric.NodeLoopOverruns.WithLabelValues(g.loop).Inc()
+		klog.Warningf("node controller loop %s overran its period, skip the tick", g.loop)
+		return false
+	}
+	defer atomic.StoreInt32(&g.running, 0)
+	iteration()
+	return true
+}
+
+// every run the iteration of the loop every period until stop is closed, the
+// ticks are skipped while the previous iteration is still running
+func every(loop string, iteration func(), period time.Duration, stop <-chan struct{}) {
+	guard := &loopGuard{loop: loop}
+	wait.Until(
+		func() {
+			go func() {
+				defer utilruntime.HandleCrash()
+				guard.run(iteration)
+			}()
+		},
+		period,
+		stop,
+	)
+}
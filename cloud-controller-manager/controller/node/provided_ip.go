@@ -0,0 +1,54 @@
+package node
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/klog"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+)
+
+// The ip given to kubelet by --node-ip is kept as the only address of the
+// node, as long as the instance has it. An instance whose primary private ip
+// is changed, eg. moved to another vswitch, no longer has it, and the node
+// keeps its stale address until kubelet is restarted. Such a node is warned
+// by a ProvidedIPNotFound event, and with --allow-provided-ip-override its
+// addresses are replaced by the ones of the instance.
+
+// AllowProvidedIPOverride whether the addresses of the instance replace the
+// ip provided by kubelet which the instance no longer has
+var AllowProvidedIPOverride = false
+
+// providedAddresses the addresses to set on the node, the one provided by
+// kubelet if the instance has it. False if the provided ip is not found and
+// the node is to be skipped.
+func (cnc *CloudNodeController) providedAddresses(node *v1.Node, addresses []v1.NodeAddress) ([]v1.NodeAddress, bool) {
+	nodeIP, provided := isProvidedAddrExist(node, addresses)
+	if !provided {
+		return addresses, true
+	}
+	if nodeIP != nil {
+		return []v1.NodeAddress{*nodeIP}, true
+	}
+	ip := node.Annotations[kubeletapis.AnnotationProvidedIPAddr]
+	if !AllowProvidedIPOverride {
+		klog.Errorf("node %s: ip %s provided by kubelet is not found in cloudprovider, skip", node.Name, ip)
+		cnc.recorder.Eventf(
+			node,
+			v1.EventTypeWarning,
+			"ProvidedIPNotFound",
+			"IP %s provided by kubelet is not an address of the instance, the node address is not updated. "+
+				"Restart kubelet with the new ip, or enable --allow-provided-ip-override",
+			ip,
+		)
+		return nil, false
+	}
+	klog.Warningf("node %s: ip %s provided by kubelet is not found in cloudprovider, "+
+		"override with the addresses of the instance", node.Name, ip)
+	cnc.recorder.Eventf(
+		node,
+		v1.EventTypeWarning,
+		"ProvidedIPNotFound",
+		"IP %s provided by kubelet is not an address of the instance, overridden by the addresses of the instance",
+		ip,
+	)
+	return addresses, true
+}
@@ -26,6 +26,7 @@ import (
 	"k8s.io/klog"
 	"net"
 	"reflect"
+	"sync"
 	"time"
 
 	"strings"
@@ -73,14 +74,47 @@ type Routes interface {
 	// DeleteRoute deletes the specified managed route
 	// Route should be as returned by ListRoutes
 	DeleteRoute(ctx context.Context, clusterName string, table string, route *cloudprovider.Route) error
+	// InstanceExistsByProviderID returns true if the instance for the given provider id still exists.
+	InstanceExistsByProviderID(ctx context.Context, providerID string) (bool, error)
+}
+
+// IPv6Routes is implemented by the Routes which tell whether the route tables
+// accept the ipv6 destinations, eg. the vpc has ipv6 enabled. The Routes not
+// implementing it are taken as accepting them.
+type IPv6Routes interface {
+	// IPv6RoutesSupported whether the ipv6 podCIDRs can be routed
+	IPv6RoutesSupported(ctx context.Context, clusterName string) (bool, error)
+}
+
+// PagedRoutes is implemented by the Routes which list the routes of a table
+// page by page. The reconcile streams the pages instead of holding the whole
+// table, only the routes within the cluster CIDRs are kept once seen.
+type PagedRoutes interface {
+	// ListRoutePages calls fn with the routes of every page of the table in
+	// turn, the listing fails with the first failed page.
+	ListRoutePages(ctx context.Context, clusterName string, table string, fn func(page []*cloudprovider.Route) error) error
 }
 
 // RouteController response for route reconcile
 type RouteController struct {
-	routes           Routes
-	kubeClient       clientset.Interface
-	clusterName      string
-	clusterCIDR      *net.IPNet
+	routes       Routes
+	kubeClient   clientset.Interface
+	clusterName  string
+	clusterCIDRs []*net.IPNet
+	// conflictResolution how to resolve route conflicted with node podCIDR, report|replace
+	conflictResolution string
+	// dryRun only log the route changes without any mutating vpc call
+	dryRun bool
+	// planLogger output the route changes in dry-run mode
+	planLogger func(line string)
+	// ipv6Unsupported the route tables reject the ipv6 destinations, the ipv6
+	// podCIDRs are neither programmed nor required, see refreshIPv6Support
+	ipv6Unsupported bool
+	// ipv6Warned the lack of the ipv6 support is warned once
+	ipv6Warned bool
+	// deletedNodes the instances of the nodes deleted since the start, the
+	// routes left to them are the controller's, see ownsConflictedRoute
+	deletedNodes     sync.Map
 	nodeLister       corelisters.NodeLister
 	nodeListerSynced cache.InformerSynced
 	broadcaster      record.EventBroadcaster
@@ -97,13 +131,24 @@ type RouteController struct {
 	queues map[string]queue.DelayingInterface
 }
 
-const NODE_QUEUE = "node.queue"
+const (
+	// ROUTE_ACTION_CREATE route would be created
+	ROUTE_ACTION_CREATE = "create"
+	// ROUTE_ACTION_DELETE route would be deleted
+	ROUTE_ACTION_DELETE = "delete"
+)
+
+const (
+	NODE_QUEUE = "node.queue"
+	// NODE_ROUTE_QUEUE queue for nodes which need route creation
+	NODE_ROUTE_QUEUE = "node.route.queue"
+)
 
 // New new route controller
 func New(routes Routes,
 	kubeClient clientset.Interface,
 	nodeInformer coreinformers.NodeInformer,
-	clusterName string, clusterCIDR *net.IPNet) (*RouteController, error) {
+	clusterName string, clusterCIDRs []*net.IPNet) (*RouteController, error) {
 
 	if kubeClient != nil && kubeClient.CoreV1().RESTClient().GetRateLimiter() != nil {
 		err := metrics.RegisterMetricAndTrackRateLimiterUsage(
@@ -115,23 +160,32 @@ func New(routes Routes,
 		}
 	}
 
-	if clusterCIDR == nil {
+	if len(clusterCIDRs) == 0 {
 		return nil, fmt.Errorf("RouteController: Must specify clusterCIDR")
 	}
 
+	switch Options.ConflictResolution {
+	case "", ConflictResolutionReport, ConflictResolutionReplace:
+	default:
+		return nil, fmt.Errorf("RouteController: unknown route conflict resolution %s", Options.ConflictResolution)
+	}
+
 	eventer, caster := broadcaster()
 
 	rc := &RouteController{
-		routes:           routes,
-		kubeClient:       kubeClient,
-		clusterName:      clusterName,
-		clusterCIDR:      clusterCIDR,
-		nodeLister:       nodeInformer.Lister(),
-		nodeListerSynced: nodeInformer.Informer().HasSynced,
-		broadcaster:      caster,
-		recorder:         eventer,
+		routes:             routes,
+		kubeClient:         kubeClient,
+		clusterName:        clusterName,
+		clusterCIDRs:       clusterCIDRs,
+		conflictResolution: Options.ConflictResolution,
+		dryRun:             Options.DryRun,
+		nodeLister:         nodeInformer.Lister(),
+		nodeListerSynced:   nodeInformer.Informer().HasSynced,
+		broadcaster:        caster,
+		recorder:           eventer,
 		queues: map[string]queue.DelayingInterface{
-			NODE_QUEUE: workqueue.NewNamedDelayingQueue(NODE_QUEUE),
+			NODE_QUEUE:       workqueue.NewNamedDelayingQueue(NODE_QUEUE),
+			NODE_ROUTE_QUEUE: workqueue.NewNamedDelayingQueue(NODE_ROUTE_QUEUE),
 		},
 	}
 
@@ -139,6 +193,10 @@ func New(routes Routes,
 		rc.queues[NODE_QUEUE],
 		nodeInformer.Informer(),
 	)
+	rc.HandlerForPodCIDRAssigned(
+		rc.queues[NODE_ROUTE_QUEUE],
+		nodeInformer.Informer(),
+	)
 
 	return rc, nil
 }
@@ -159,6 +217,9 @@ func (rc *RouteController) HandlerForNodeDeletion(
 					klog.Infof("ignore node with exclude node label %s", node.Name)
 					return
 				}
+				if node.Spec.ProviderID != "" {
+					rc.deletedNodes.Store(node.Spec.ProviderID, true)
+				}
 				que.Add(node)
 				klog.Infof("node deletion event: %s, %s", node.Name, node.Spec.ProviderID)
 			},
@@ -166,11 +227,65 @@ func (rc *RouteController) HandlerForNodeDeletion(
 	)
 }
 
+// HandlerForPodCIDRAssigned enqueue node whose podCIDR was just assigned,
+// so that we do not need to wait for the next full reconcile.
+func (rc *RouteController) HandlerForPodCIDRAssigned(
+	que queue.DelayingInterface,
+	informer cache.SharedIndexInformer,
+) {
+	informer.AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldc, newc interface{}) {
+				old, ok1 := oldc.(*v1.Node)
+				node, ok2 := newc.(*v1.Node)
+				if !ok1 || !ok2 {
+					klog.Infof("not node type: %s, %s\n", reflect.TypeOf(oldc), reflect.TypeOf(newc))
+					return
+				}
+				if !isPodCIDRAssigned(old, node) {
+					return
+				}
+				if utils.IsExcludedNode(node) {
+					klog.Infof("ignore node with exclude node label %s", node.Name)
+					return
+				}
+				que.Add(node.Name)
+				klog.Infof("node podCIDR assigned event: %s, %v", node.Name, podCIDRs(node))
+			},
+		},
+	)
+}
+
+// isPodCIDRAssigned returns true when podCIDRs of the node changed from empty to populated,
+// or a new podCIDR was added for dual-stack.
+func isPodCIDRAssigned(old, node *v1.Node) bool {
+	newCIDRs := podCIDRs(node)
+	if len(newCIDRs) == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(podCIDRs(old), newCIDRs)
+}
+
+// podCIDRs returns all podCIDRs of the node. spec.podCIDRs
+// takes precedence over spec.podCIDR for dual-stack node.
+func podCIDRs(node *v1.Node) []string {
+	if len(node.Spec.PodCIDRs) != 0 {
+		return node.Spec.PodCIDRs
+	}
+	if node.Spec.PodCIDR != "" {
+		return []string{node.Spec.PodCIDR}
+	}
+	return nil
+}
+
 // Run start route controller
 func (rc *RouteController) Run(stopCh <-chan struct{}, syncPeriod time.Duration) {
 	defer utilruntime.HandleCrash()
 
 	klog.Info("starting route controller")
+	if rc.dryRun {
+		klog.Info("route controller runs in dry-run mode, no route would be changed")
+	}
 	defer klog.Info("shutting down route controller")
 
 	if !controller.WaitForCacheSync(ROUTE_CONTROLLER, stopCh, rc.nodeListerSynced) {
@@ -195,6 +310,35 @@ func (rc *RouteController) Run(stopCh <-chan struct{}, syncPeriod time.Duration)
 		}
 	}, syncPeriod, stopCh)
 
+	go wait.Until(
+		func() {
+			que := rc.queues[NODE_ROUTE_QUEUE]
+			for {
+				func() {
+					key, quit := que.Get()
+					if quit {
+						return
+					}
+					defer que.Done(key)
+					name, ok := key.(string)
+					if !ok {
+						klog.Errorf("not type of string, %s", reflect.TypeOf(key))
+						return
+					}
+					klog.Infof("worker: queued sync for [%s] node route creation", name)
+					start := time.Now()
+					if err := rc.syncNodeRoute(name); err != nil {
+						que.AddAfter(key, 30*time.Second)
+						klog.Errorf("requeue: create route for node %s, error %v", name, err)
+					}
+					metric.RouteLatency.WithLabelValues("create_node").Observe(metric.MsSince(start))
+				}()
+			}
+		},
+		2*time.Second,
+		stopCh,
+	)
+
 	go wait.Until(
 		func() {
 			que := rc.queues[NODE_QUEUE]
@@ -232,8 +376,9 @@ func (rc *RouteController) syncd(node *v1.Node) error {
 	if utils.IsExcludedNode(node) {
 		return nil
 	}
-	if node.Spec.PodCIDR == "" {
-		klog.Warningf("Node %s PodCIDR is nil, skip delete route", node.Name)
+	cidrs := podCIDRs(node)
+	if len(cidrs) == 0 {
+		klog.Infof("Node %s PodCIDR is nil, skip delete route", node.Name)
 		return nil
 	}
 	if node.Spec.ProviderID == "" {
@@ -247,19 +392,61 @@ func (rc *RouteController) syncd(node *v1.Node) error {
 		return fmt.Errorf("RouteTables: %s", err.Error())
 	}
 	for _, table := range tabs {
-		route := &cloudprovider.Route{
-			Name:            node.Spec.ProviderID,
-			TargetNode:      types.NodeName(node.Spec.ProviderID),
-			DestinationCIDR: node.Spec.PodCIDR,
+		for _, cidr := range cidrs {
+			route := &cloudprovider.Route{
+				Name:            node.Spec.ProviderID,
+				TargetNode:      types.NodeName(node.Spec.ProviderID),
+				DestinationCIDR: cidr,
+			}
+			if rc.dryRun {
+				rc.plan(ROUTE_ACTION_DELETE, table, route)
+				continue
+			}
+			if err := rc.routes.DeleteRoute(
+				ctx, rc.clusterName, table, route,
+			); err != nil {
+				klog.Errorf(
+					"delete route %s %s from table %s, %s", route.Name, route.DestinationCIDR, table, err.Error())
+				return fmt.Errorf("node deletion, delete route error: %s", err.Error())
+			}
+			klog.Infof("node deletion: delete route %s %s from table %s SUCCESS.", route.Name, route.DestinationCIDR, table)
+		}
+	}
+	return nil
+}
+
+// syncNodeRoute create route for the node in every route table.
+// Node without podCIDR is treated as pending and skipped without error.
+func (rc *RouteController) syncNodeRoute(name string) error {
+	node, err := rc.nodeLister.Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			klog.Infof("node %s not found, skip creating route", name)
+			return nil
+		}
+		return fmt.Errorf("get node %s: %s", name, err.Error())
+	}
+	if utils.IsExcludedNode(node) {
+		return nil
+	}
+	if len(podCIDRs(node)) == 0 {
+		klog.V(4).Infof("node %s has no podCIDR assigned yet, wait for update", name)
+		return nil
+	}
+	ctx := context.Background()
+	rc.refreshIPv6Support(ctx)
+	tabs, err := rc.routes.RouteTables(ctx, rc.clusterName)
+	if err != nil {
+		return fmt.Errorf("RouteTables: %s", err.Error())
+	}
+	for _, table := range tabs {
+		routes, err := rc.routes.ListRoutes(ctx, rc.clusterName, table)
+		if err != nil {
+			return fmt.Errorf("error listing routes: %v", err)
 		}
-		if err := rc.routes.DeleteRoute(
-			ctx, rc.clusterName, table, route,
-		); err != nil {
-			klog.Errorf(
-				"delete route %s %s from table %s, %s", route.Name, route.DestinationCIDR, table, err.Error())
-			return fmt.Errorf("node deletion, delete route error: %s", err.Error())
+		if err := rc.tryCreateRoute(ctx, table, node, RouteCacheMap(routes)); err != nil {
+			return fmt.Errorf("create route for node %s in table %s: %s", name, table, err.Error())
 		}
-		klog.Infof("node deletion: delete route %s %s from table %s SUCCESS.", route.Name, route.DestinationCIDR, table)
 	}
 	return nil
 }
@@ -271,11 +458,25 @@ func (rc *RouteController) reconcile() error {
 	if err != nil {
 		return fmt.Errorf("error listing nodes: %v", err)
 	}
+	rc.refreshIPv6Support(ctx)
 	tabs, err := rc.routes.RouteTables(ctx, rc.clusterName)
 	if err != nil {
 		return fmt.Errorf("RouteTables: %s", err.Error())
 	}
 	for _, table := range tabs {
+		if err := rc.reconcileTable(ctx, table, nodes); err != nil {
+			return err
+		}
+	}
+	metric.RouteLatency.WithLabelValues("reconcile").Observe(metric.MsSince(start))
+	return nil
+}
+
+// reconcileTable sync the routes of the table, page by page if the routes
+// are listed by pages.
+func (rc *RouteController) reconcileTable(ctx context.Context, table string, nodes []*v1.Node) error {
+	paged, ok := rc.routes.(PagedRoutes)
+	if !ok {
 		//ListRoutes & Sync
 		routeList, err := rc.routes.ListRoutes(ctx, rc.clusterName, table)
 		if err != nil {
@@ -284,40 +485,117 @@ func (rc *RouteController) reconcile() error {
 		if err := rc.sync(ctx, table, nodes, routeList); err != nil {
 			return fmt.Errorf("reconcile route for table [%s] error: %s", table, err.Error())
 		}
+		return nil
+	}
+	diff := newRouteDiff()
+	err := paged.ListRoutePages(ctx, rc.clusterName, table,
+		func(page []*cloudprovider.Route) error {
+			rc.diff(diff, nodes, page)
+			return nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error listing routes: %v", err)
+	}
+	if err := rc.apply(ctx, table, nodes, diff); err != nil {
+		return fmt.Errorf("reconcile route for table [%s] error: %s", table, err.Error())
 	}
-	metric.RouteLatency.WithLabelValues("reconcile").Observe(metric.MsSince(start))
 	return nil
 }
 
-// Aoxn: Alibaba cloud does not support concurrent route operation
-func (rc *RouteController) sync(ctx context.Context, table string, nodes []*v1.Node, routes []*cloudprovider.Route) error {
+// routeDiff the changes of the routes of a table against the nodes. They are
+// collected page by page and applied once the table is listed, so that the
+// pages are not shifted by the deletions.
+type routeDiff struct {
+	// existing the routes within the cluster CIDRs, keyed as RouteCacheMap
+	existing map[string]*cloudprovider.Route
+	// changes the routes to delete or to resolve, in the listed order
+	changes []routeChange
+}
 
-	//try delete conflicted route from vpc route table.
-	for _, route := range routes {
+// routeChange a stale route to delete, or a route conflicted with the podCIDR
+// of a node
+type routeChange struct {
+	route *cloudprovider.Route
+	// conflicted the node of the podCIDR, nil for a stale route
+	conflicted *v1.Node
+}
+
+func newRouteDiff() *routeDiff {
+	return &routeDiff{existing: make(map[string]*cloudprovider.Route)}
+}
+
+// diff add the changes of a page of routes
+func (rc *RouteController) diff(d *routeDiff, nodes []*v1.Node, page []*cloudprovider.Route) {
+	var responsible []*cloudprovider.Route
+	for _, route := range page {
 		if !rc.isResponsibleForRoute(route) {
 			continue
 		}
+		responsible = append(responsible, route)
+
+		// route targets another instance with the exact podCIDR of a node, the
+		// stale route of a node, eg. replaced, is deleted as any route of the
+		// controller, the others are resolved by conflictResolution
+		if node := conflictedNode(nodes, route); node != nil && !route.Blackhole &&
+			!rc.ownsConflictedRoute(nodes, route) {
+			d.changes = append(d.changes, routeChange{route: route, conflicted: node})
+			continue
+		}
 
 		// Check if this route is a blackhole, or applies to a node we know about & has an incorrect CIDR.
 		if route.Blackhole || rc.isRouteConflicted(nodes, route) {
+			d.changes = append(d.changes, routeChange{route: route})
+		}
+	}
+	for key, route := range RouteCacheMap(responsible) {
+		d.existing[key] = route
+	}
+}
 
-			// Aoxn: Alibaba cloud does not support concurrent route operation
-			klog.Infof("Deleting route %s %s", route.Name, route.DestinationCIDR)
-			if err := rc.routes.DeleteRoute(ctx, rc.clusterName, table, route); err != nil {
-				klog.Errorf("Could not delete route %s %s from table %s, %s", route.Name, route.DestinationCIDR, table, err.Error())
-				continue
+// Aoxn: Alibaba cloud does not support concurrent route operation
+func (rc *RouteController) sync(ctx context.Context, table string, nodes []*v1.Node, routes []*cloudprovider.Route) error {
+	diff := newRouteDiff()
+	rc.diff(diff, nodes, routes)
+	return rc.apply(ctx, table, nodes, diff)
+}
+
+// apply the changes of the diff, then create the missing routes of the nodes
+func (rc *RouteController) apply(ctx context.Context, table string, nodes []*v1.Node, diff *routeDiff) error {
+
+	// nodes which has an unresolved conflicted route
+	conflicted := make(map[string]bool)
+	//try delete conflicted route from vpc route table.
+	for _, change := range diff.changes {
+		route := change.route
+		if node := change.conflicted; node != nil {
+			if !rc.resolveConflictedRoute(ctx, table, node, route) {
+				conflicted[node.Name] = true
 			}
-			klog.Infof("Delete route %s %s from table %s SUCCESS.", route.Name, route.DestinationCIDR, table)
+			continue
 		}
+
+		if rc.dryRun {
+			rc.plan(ROUTE_ACTION_DELETE, table, route)
+			continue
+		}
+		// Aoxn: Alibaba cloud does not support concurrent route operation
+		klog.Infof("Deleting route %s %s", route.Name, route.DestinationCIDR)
+		if err := rc.routes.DeleteRoute(ctx, rc.clusterName, table, route); err != nil {
+			klog.Errorf("Could not delete route %s %s from table %s, %s", route.Name, route.DestinationCIDR, table, err.Error())
+			continue
+		}
+		klog.Infof("Delete route %s %s from table %s SUCCESS.", route.Name, route.DestinationCIDR, table)
 	}
-	cached := RouteCacheMap(routes)
+	cached := diff.existing
 	// try create desired routes
 	for _, node := range nodes {
 
 		if utils.IsExcludedNode(node) {
 			continue
 		}
-		if node.Spec.PodCIDR == "" {
+		if len(podCIDRs(node)) == 0 {
+			// podCIDR not assigned yet, node would be requeued on podCIDR assignment.
 			err := rc.updateNetworkingCondition(types.NodeName(node.Name), false)
 			if err != nil {
 				klog.Errorf("route, update network condition error: %s", err.Error())
@@ -328,6 +606,10 @@ func (rc *RouteController) sync(ctx context.Context, table string, nodes []*v1.N
 			klog.Errorf("Node %s has no Provider ID, skip it", node.Name)
 			continue
 		}
+		if conflicted[node.Name] {
+			klog.Warningf("node %s has conflicted route in table %s, skip creating route", node.Name, table)
+			continue
+		}
 		// ignore error return. Try it next time anyway.
 		err := rc.tryCreateRoute(ctx, table, node, cached)
 		if err != nil {
@@ -363,7 +645,8 @@ func (rc *RouteController) tryCreateRoute(
 		return nil
 	}
 
-	if node.Spec.PodCIDR == "" {
+	cidrs := podCIDRs(node)
+	if len(cidrs) == 0 {
 		return rc.updateNetworkingCondition(types.NodeName(node.Name), false)
 	}
 
@@ -371,120 +654,271 @@ func (rc *RouteController) tryCreateRoute(
 		klog.Warningf("node %s has no node.Spec.ProviderID, skip it", node.Name)
 		return nil
 	}
+
+	created, changed := true, false
+	// programmed the families whose route is programmed, keyed by ipv6
+	programmed := make(map[bool]bool)
+	for _, cidr := range cidrs {
+		// program route only for the ip family which the cluster cidr supports.
+		if !rc.hasFamily(cidr) {
+			klog.Infof("node %s: no cluster CIDR for podCIDR %s family, skip it", node.Name, cidr)
+			continue
+		}
+		if rc.ipv6Unsupported && isIPv6CIDR(cidr) {
+			klog.V(4).Infof("node %s: ipv6 routes not supported, skip podCIDR %s", node.Name, cidr)
+			continue
+		}
+		if !rc.isPodCIDRValid(node, cidr) {
+			continue
+		}
+		ok, err := rc.tryCreateRouteForCIDR(ctx, table, node, cidr, cache)
+		if ok {
+			changed = true
+		}
+		if err != nil {
+			created = false
+			continue
+		}
+		programmed[isIPv6CIDR(cidr)] = true
+	}
+	// the pods of the node are reachable once the route of every family
+	// the cluster requires is programmed
+	for _, ipv6 := range rc.requiredFamilies() {
+		if !programmed[ipv6] {
+			klog.Infof("node %s: route of the %s podCIDR not programmed yet", node.Name, familyName(ipv6))
+			created = false
+		}
+	}
+	if !changed {
+		// Update condition only if it doesn't reflect the current state.
+		_, condition = helpers.GetNodeCondition(&node.Status, v1.NodeNetworkUnavailable)
+		if condition != nil &&
+			(condition.Status == v1.ConditionFalse) == created {
+			return nil
+		}
+	}
+	return rc.updateNetworkingCondition(types.NodeName(node.Name), created)
+}
+
+// tryCreateRouteForCIDR create route for the destination cidr if it does not exist.
+// Returns true if a create call was issued.
+func (rc *RouteController) tryCreateRouteForCIDR(
+	ctx context.Context,
+	table string,
+	node *v1.Node,
+	destinationCIDR string,
+	cache map[string]*cloudprovider.Route,
+) (bool, error) {
 	providerID := node.Spec.ProviderID
-	destinationCIDR := node.Spec.PodCIDR
 	// Check if we have a route for this node w/ the correct CIDR.
 	routeKey := fmt.Sprintf("%s-%s", providerID, destinationCIDR)
 	r := cache[routeKey]
-	if r == nil || r.DestinationCIDR != node.Spec.PodCIDR {
-		start := time.Now()
-		// If not, create the route.
-		route := &cloudprovider.Route{
-			TargetNode:      types.NodeName(providerID),
-			DestinationCIDR: node.Spec.PodCIDR,
-		}
-
-		backoff := wait.Backoff{
-			Duration: 4 * time.Second,
-			Steps:    3,
-			Factor:   2,
-			Jitter:   1,
-		}
-		var lasterr error
-		err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+	if r != nil && r.DestinationCIDR == destinationCIDR {
+		return false, nil
+	}
+	start := time.Now()
+	// If not, create the route.
+	route := &cloudprovider.Route{
+		TargetNode:      types.NodeName(providerID),
+		DestinationCIDR: destinationCIDR,
+	}
+	if rc.dryRun {
+		rc.plan(ROUTE_ACTION_CREATE, table, route)
+		return false, nil
+	}
 
-			klog.Infof("Creating route for node %s %s with hint %s", node.Name, route.DestinationCIDR, node.Name)
-			err := rc.routes.CreateRoute(ctx, rc.clusterName, node.Name, table, route)
-			if err != nil {
-				lasterr = err
-				if strings.Contains(err.Error(), "not found") {
-					klog.Infof("not found route %s", err.Error())
-					return true, nil
-				}
-				klog.Errorf("Backoff creating route: %s", err.Error())
-				return false, nil
-			}
-			return true, nil
-		})
+	backoff := wait.Backoff{
+		Duration: 4 * time.Second,
+		Steps:    3,
+		Factor:   2,
+		Jitter:   1,
+	}
+	var lasterr error
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
 
-		ref := &v1.ObjectReference{
-			Kind:      "Node",
-			Name:      node.Name,
-			UID:       node.UID,
-			Namespace: "",
-		}
+		klog.Infof("Creating route for node %s %s with hint %s", node.Name, route.DestinationCIDR, node.Name)
+		err := rc.routes.CreateRoute(ctx, rc.clusterName, node.Name, table, route)
 		if err != nil {
-			rc.recorder.Eventf(
-				ref,
-				v1.EventTypeWarning,
-				"CreateRouteFailed",
-				"Error creating route: %s",
-				err.Error())
-			klog.Errorf("could not create route %s for node %s: %v -> %v", route.DestinationCIDR, node.Name, err, lasterr)
-		} else {
-			rc.recorder.Eventf(
-				ref,
-				v1.EventTypeNormal,
-				"CreatedRoute",
-				"Created route for %s with %s -> %s successfully",
-				table, node.Name, node.Spec.PodCIDR,
-			)
-			klog.Infof("Created route for %s with %s -> %s", table, node.Name, node.Spec.PodCIDR)
+			lasterr = err
+			if strings.Contains(err.Error(), "not found") {
+				klog.Infof("not found route %s", err.Error())
+				return true, nil
+			}
+			klog.Errorf("Backoff creating route: %s", err.Error())
+			return false, nil
 		}
-		metric.RouteLatency.WithLabelValues("create").Observe(metric.MsSince(start))
-		klog.Infof("Created route for %s with %s -> %s", table, node.Name, node.Spec.PodCIDR)
-		return rc.updateNetworkingCondition(types.NodeName(node.Name), err == nil)
+		return true, nil
+	})
+
+	ref := &v1.ObjectReference{
+		Kind:      "Node",
+		Name:      node.Name,
+		UID:       node.UID,
+		Namespace: "",
 	}
-	// Update condition only if it doesn't reflect the current state.
-	_, condition = helpers.GetNodeCondition(&node.Status, v1.NodeNetworkUnavailable)
-	if condition != nil &&
-		condition.Status == v1.ConditionFalse {
-		return nil
+	if err != nil {
+		rc.recorder.Eventf(
+			ref,
+			v1.EventTypeWarning,
+			"CreateRouteFailed",
+			"Error creating route: %s",
+			err.Error())
+		klog.Errorf("could not create route %s for node %s: %v -> %v", route.DestinationCIDR, node.Name, err, lasterr)
+	} else {
+		cache[routeKey] = route
+		rc.recorder.Eventf(
+			ref,
+			v1.EventTypeNormal,
+			"CreatedRoute",
+			"Created route for %s with %s -> %s successfully",
+			table, node.Name, destinationCIDR,
+		)
+		klog.Infof("Created route for %s with %s -> %s", table, node.Name, destinationCIDR)
 	}
-	return rc.updateNetworkingCondition(types.NodeName(node.Name), true)
+	metric.RouteLatency.WithLabelValues("create").Observe(metric.MsSince(start))
+	return true, err
 }
 
-func (rc *RouteController) isRouteConflicted(nodes []*v1.Node, route *cloudprovider.Route) bool {
+// conflictedNode returns the node whose podCIDR equals to the route destination
+// while the route targets another instance.
+func conflictedNode(nodes []*v1.Node, route *cloudprovider.Route) *v1.Node {
 	for _, node := range nodes {
-		// skip node without podcidr
-		if node.Spec.PodCIDR == "" {
+		if node.Spec.ProviderID == "" {
 			continue
 		}
+		for _, cidr := range podCIDRs(node) {
+			if cidr == route.DestinationCIDR &&
+				!strings.Contains(node.Spec.ProviderID, string(route.TargetNode)) {
+				return node
+			}
+		}
+	}
+	return nil
+}
 
-		if node.Spec.PodCIDR == route.DestinationCIDR &&
-			!strings.Contains(node.Spec.ProviderID, string(route.TargetNode)) {
-			// conflicted with exist route.
+// ownsConflictedRoute whether the conflicted route was created by the
+// controller, ie. its next hop is the instance of a node, current or deleted
+// since the start. The controller only routes to the nodes, so that the other
+// next hops are taken as created manually.
+func (rc *RouteController) ownsConflictedRoute(nodes []*v1.Node, route *cloudprovider.Route) bool {
+	if route.TargetNode == "" {
+		return false
+	}
+	for _, node := range nodes {
+		if node.Spec.ProviderID != "" &&
+			strings.Contains(node.Spec.ProviderID, string(route.TargetNode)) {
 			return true
 		}
-		contains, err := RealContainsCidr(node.Spec.PodCIDR, route.DestinationCIDR)
-		if err != nil {
-			// record event an error out.
-			if rc.recorder != nil {
-				rc.recorder.Eventf(
-					&v1.ObjectReference{
-						Kind:      "Node",
-						Name:      node.Name,
-						UID:       node.UID,
-						Namespace: "",
-					},
-					v1.EventTypeWarning,
-					"SyncRouteFailed",
-					"Error syncing route :route conflict, %s",
-					err.Error(),
-				)
+	}
+	owned := false
+	rc.deletedNodes.Range(func(k, v interface{}) bool {
+		owned = strings.Contains(k.(string), string(route.TargetNode))
+		return !owned
+	})
+	return owned
+}
+
+// resolveConflictedRoute report the conflicted route with a warning event on node.
+// In replace mode, the route is deleted when its next hop instance does not exist,
+// and the node route would be recreated later. Returns true if the conflict is resolved.
+func (rc *RouteController) resolveConflictedRoute(
+	ctx context.Context,
+	table string,
+	node *v1.Node,
+	route *cloudprovider.Route,
+) bool {
+	ref := &v1.ObjectReference{
+		Kind:      "Node",
+		Name:      node.Name,
+		UID:       node.UID,
+		Namespace: "",
+	}
+	rc.recorder.Eventf(
+		ref,
+		v1.EventTypeWarning,
+		"RouteConflict",
+		"Route %s -> %s in table %s conflicts with node podCIDR, resolution=%s",
+		route.DestinationCIDR, route.TargetNode, table, rc.conflictResolution,
+	)
+	klog.Warningf("route conflicted: table=%s, node=%s, route %s -> %s",
+		table, node.Name, route.DestinationCIDR, route.TargetNode)
+	if rc.conflictResolution != ConflictResolutionReplace {
+		return false
+	}
+
+	exist, err := rc.routes.InstanceExistsByProviderID(ctx, string(route.TargetNode))
+	if err != nil && err != cloudprovider.InstanceNotFound {
+		klog.Errorf("check next hop %s of conflicted route: %s", route.TargetNode, err.Error())
+		return false
+	}
+	if exist && err == nil {
+		klog.Warningf("next hop %s of conflicted route %s still exists, skip replace",
+			route.TargetNode, route.DestinationCIDR)
+		return false
+	}
+	if rc.dryRun {
+		rc.plan(ROUTE_ACTION_DELETE, table, route)
+		return true
+	}
+	klog.Infof("Deleting conflicted route %s %s", route.Name, route.DestinationCIDR)
+	if err := rc.routes.DeleteRoute(ctx, rc.clusterName, table, route); err != nil {
+		klog.Errorf("Could not delete conflicted route %s %s from table %s, %s",
+			route.Name, route.DestinationCIDR, table, err.Error())
+		return false
+	}
+	rc.recorder.Eventf(
+		ref,
+		v1.EventTypeNormal,
+		"RouteConflictReplaced",
+		"Deleted conflicted route %s -> %s in table %s, next hop does not exist",
+		route.DestinationCIDR, route.TargetNode, table,
+	)
+	return true
+}
+
+func (rc *RouteController) isRouteConflicted(nodes []*v1.Node, route *cloudprovider.Route) bool {
+	for _, node := range nodes {
+		// node without podcidr has nothing to conflict with
+		for _, podCIDR := range podCIDRs(node) {
+			if rc.isCIDRConflicted(node, podCIDR, route) {
+				return true
 			}
-			klog.Errorf("route conflicted: node.Spec.PodCIDR=%s -> "+
-				"route.CIDR=%s, %s", node.Spec.PodCIDR, route.DestinationCIDR, err.Error())
-			return false
-		}
-		if contains {
-			return true
 		}
 	}
 	return false
 }
 
+// isCIDRConflicted whether the route is within the podCIDR of the node
+func (rc *RouteController) isCIDRConflicted(node *v1.Node, podCIDR string, route *cloudprovider.Route) bool {
+	contains, err := RealContainsCidr(podCIDR, route.DestinationCIDR)
+	if err != nil {
+		// record event an error out.
+		if rc.recorder != nil {
+			rc.recorder.Eventf(
+				&v1.ObjectReference{
+					Kind:      "Node",
+					Name:      node.Name,
+					UID:       node.UID,
+					Namespace: "",
+				},
+				v1.EventTypeWarning,
+				"SyncRouteFailed",
+				"Error syncing route :route conflict, %s",
+				err.Error(),
+			)
+		}
+		klog.Errorf("route conflicted: podCIDR=%s -> "+
+			"route.CIDR=%s, %s", podCIDR, route.DestinationCIDR, err.Error())
+		return false
+	}
+	return contains
+}
+
 func (rc *RouteController) updateNetworkingCondition(nodeName types.NodeName, routeCreated bool) error {
+	if rc.dryRun {
+		// keep node condition untouched in dry-run mode
+		return nil
+	}
 	var err error
 	for i := 0; i < updateNodeStatusMaxRetries; i++ {
 		// Patch could also fail, even though the chance is very slim. So we still do
@@ -521,20 +955,129 @@ func (rc *RouteController) updateNetworkingCondition(nodeName types.NodeName, ro
 }
 
 func (rc *RouteController) isResponsibleForRoute(route *cloudprovider.Route) bool {
-	_, cidr, err := net.ParseCIDR(route.DestinationCIDR)
+	// Not responsible if this route's CIDR is not within any of our clusterCIDRs
+	contains, err := ContainedByAny(rc.clusterCIDRs, route.DestinationCIDR)
 	if err != nil {
 		klog.Errorf("Ignoring route %s, unparsable CIDR: %v", route.Name, err)
 		return false
 	}
-	// Not responsible if this route's CIDR is not within our clusterCIDR
-	lastIP := make([]byte, len(cidr.IP))
-	for i := range lastIP {
-		lastIP[i] = cidr.IP[i] | ^cidr.Mask[i]
+	return contains
+}
+
+// isPodCIDRValid check whether node podCIDR is within any of the cluster CIDRs.
+// A warning event is recorded for node with podCIDR out of cluster CIDRs.
+func (rc *RouteController) isPodCIDRValid(node *v1.Node, podCIDR string) bool {
+	contains, err := ContainedByAny(rc.clusterCIDRs, podCIDR)
+	if err == nil && contains {
+		return true
 	}
-	if !rc.clusterCIDR.Contains(cidr.IP) || !rc.clusterCIDR.Contains(lastIP) {
-		return false
+	reason := fmt.Sprintf("podCIDR %s is not within cluster CIDR %s", podCIDR, rc.clusterCIDRString())
+	if err != nil {
+		reason = err.Error()
 	}
-	return true
+	if rc.recorder != nil {
+		rc.recorder.Eventf(
+			&v1.ObjectReference{
+				Kind:      "Node",
+				Name:      node.Name,
+				UID:       node.UID,
+				Namespace: "",
+			},
+			v1.EventTypeWarning,
+			"InvalidPodCIDR",
+			"Skip creating route: %s",
+			reason,
+		)
+	}
+	klog.Warningf("node %s: skip creating route, %s", node.Name, reason)
+	return false
+}
+
+// hasFamily returns true if any cluster cidr has the same ip family with cidr.
+func (rc *RouteController) hasFamily(cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		// let isPodCIDRValid report the invalid cidr
+		return true
+	}
+	for _, c := range rc.clusterCIDRs {
+		if (c.IP.To4() == nil) == (ip.To4() == nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIPv6CIDR returns true if cidr is an ipv6 cidr.
+func isIPv6CIDR(cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	return err == nil && ip.To4() == nil
+}
+
+func familyName(ipv6 bool) string {
+	if ipv6 {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// requiredFamilies returns the ip families of the cluster cidrs, keyed by ipv6,
+// whose route must be programmed before the node network is available.
+// ipv6 is not required when the route tables do not support it.
+func (rc *RouteController) requiredFamilies() []bool {
+	var families []bool
+	seen := make(map[bool]bool)
+	for _, c := range rc.clusterCIDRs {
+		ipv6 := c.IP.To4() == nil
+		if seen[ipv6] || (ipv6 && rc.ipv6Unsupported) {
+			continue
+		}
+		seen[ipv6] = true
+		families = append(families, ipv6)
+	}
+	return families
+}
+
+// refreshIPv6Support probe whether the route tables accept the ipv6
+// destinations for the dual-stack cluster. The ipv6 routes are skipped with
+// a one-time warning when they do not; a failed probe keeps the former result.
+func (rc *RouteController) refreshIPv6Support(ctx context.Context) {
+	probe, ok := rc.routes.(IPv6Routes)
+	if !ok || !rc.hasFamily("::/0") {
+		return
+	}
+	supported, err := probe.IPv6RoutesSupported(ctx, rc.clusterName)
+	if err != nil {
+		klog.Warningf("probe ipv6 route support: %s, keep ipv6 unsupported=%t", err.Error(), rc.ipv6Unsupported)
+		return
+	}
+	rc.ipv6Unsupported = !supported
+	if rc.ipv6Unsupported && !rc.ipv6Warned {
+		rc.ipv6Warned = true
+		klog.Warningf("ipv6 is not enabled for the vpc of cluster %s, "+
+			"only the ipv4 podCIDRs are routed", rc.clusterName)
+	}
+}
+
+func (rc *RouteController) clusterCIDRString() string {
+	var cidrs []string
+	for _, cidr := range rc.clusterCIDRs {
+		cidrs = append(cidrs, cidr.String())
+	}
+	return strings.Join(cidrs, ",")
+}
+
+// plan output one line for each route change in dry-run mode.
+func (rc *RouteController) plan(action, table string, route *cloudprovider.Route) {
+	line := fmt.Sprintf(
+		"route dry-run: action=%s table=%s destination=%s target=%s",
+		action, table, route.DestinationCIDR, route.TargetNode,
+	)
+	if rc.planLogger != nil {
+		rc.planLogger(line)
+		return
+	}
+	klog.Info(line)
 }
 
 func broadcaster() (record.EventRecorder, record.EventBroadcaster) {
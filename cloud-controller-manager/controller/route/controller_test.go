@@ -0,0 +1,605 @@
+package route
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider"
+	"k8s.io/cloud-provider/node/helpers"
+)
+
+type fakeRoutes struct {
+	routes    map[string][]*cloudprovider.Route
+	instances map[string]bool
+	created   []*cloudprovider.Route
+	deleted   []*cloudprovider.Route
+}
+
+func (f *fakeRoutes) InstanceExistsByProviderID(ctx context.Context, providerID string) (bool, error) {
+	if f.instances[providerID] {
+		return true, nil
+	}
+	return false, cloudprovider.InstanceNotFound
+}
+
+func (f *fakeRoutes) RouteTables(ctx context.Context, clusterName string) ([]string, error) {
+	var tables []string
+	for k := range f.routes {
+		tables = append(tables, k)
+	}
+	return tables, nil
+}
+
+func (f *fakeRoutes) ListRoutes(ctx context.Context, clusterName string, table string) ([]*cloudprovider.Route, error) {
+	return f.routes[table], nil
+}
+
+func (f *fakeRoutes) CreateRoute(ctx context.Context, clusterName string, nameHint string, table string, route *cloudprovider.Route) error {
+	f.created = append(f.created, route)
+	f.routes[table] = append(f.routes[table], route)
+	return nil
+}
+
+func (f *fakeRoutes) DeleteRoute(ctx context.Context, clusterName string, table string, route *cloudprovider.Route) error {
+	f.deleted = append(f.deleted, route)
+	var left []*cloudprovider.Route
+	for _, r := range f.routes[table] {
+		if r.DestinationCIDR != route.DestinationCIDR {
+			left = append(left, r)
+		}
+	}
+	f.routes[table] = left
+	return nil
+}
+
+func newNode(name, podCIDR string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.NodeSpec{
+			PodCIDR:    podCIDR,
+			ProviderID: "cn-hangzhou." + name,
+		},
+	}
+}
+
+func newTestController(t *testing.T, cidrs string, routes Routes, nodes ...*v1.Node) (*RouteController, *record.FakeRecorder) {
+	clusterCIDRs, err := ParseClusterCIDRs(cidrs)
+	if err != nil {
+		t.Fatalf("parse cluster cidr: %s", err.Error())
+	}
+	var objs []runtime.Object
+	for _, n := range nodes {
+		objs = append(objs, n)
+	}
+	recorder := record.NewFakeRecorder(100)
+	return &RouteController{
+		routes:       routes,
+		kubeClient:   fake.NewSimpleClientset(objs...),
+		clusterCIDRs: clusterCIDRs,
+		nodeLister:   listerWith(t, nodes...),
+		recorder:     recorder,
+	}, recorder
+}
+
+// updateNode simulate node update event handled by HandlerForPodCIDRAssigned
+func updateNode(t *testing.T, rc *RouteController, old, node *v1.Node) {
+	rc.nodeLister = listerWith(t, node)
+	if !isPodCIDRAssigned(old, node) {
+		return
+	}
+	if err := rc.syncNodeRoute(node.Name); err != nil {
+		t.Fatalf("sync node route: %s", err.Error())
+	}
+}
+
+func TestParseClusterCIDRs(t *testing.T) {
+	cidrs, err := ParseClusterCIDRs("172.16.0.0/16, 172.20.0.0/16")
+	if err != nil || len(cidrs) != 2 {
+		t.Fatalf("expect 2 cluster cidrs, got %v, %v", cidrs, err)
+	}
+	if _, err := ParseClusterCIDRs("172.16.0.0/16,xxx"); err == nil {
+		t.Fatalf("expect error on invalid cluster cidr")
+	}
+	if _, err := ParseClusterCIDRs(" "); err == nil {
+		t.Fatalf("expect error on empty cluster cidr")
+	}
+}
+
+func TestIsResponsibleForRouteDualCIDR(t *testing.T) {
+	rc, _ := newTestController(t, "172.16.0.0/16,172.20.0.0/16", &fakeRoutes{})
+	cases := map[string]bool{
+		"172.16.3.0/24":  true,
+		"172.20.3.0/24":  true,
+		"172.18.3.0/24":  false,
+		"172.16.0.0/12":  false,
+		"192.168.0.0/16": false,
+		"invalid":        false,
+	}
+	for cidr, expect := range cases {
+		if got := rc.isResponsibleForRoute(&cloudprovider.Route{DestinationCIDR: cidr}); got != expect {
+			t.Errorf("route %s: expect responsible=%t, got %t", cidr, expect, got)
+		}
+	}
+}
+
+func TestSyncDualCIDR(t *testing.T) {
+	table := "vtb-xxx"
+	nodes := []*v1.Node{
+		newNode("i-a", "172.16.1.0/24"),
+		newNode("i-b", "172.20.1.0/24"),
+		newNode("i-c", "10.0.1.0/24"),
+	}
+	routes := &fakeRoutes{
+		routes: map[string][]*cloudprovider.Route{
+			table: {
+				// blackhole route within the second cluster cidr, expect deleted
+				{DestinationCIDR: "172.20.9.0/24", TargetNode: "cn-hangzhou.i-x", Blackhole: true},
+				// blackhole route out of cluster cidrs, expect untouched
+				{DestinationCIDR: "192.168.9.0/24", TargetNode: "cn-hangzhou.i-y", Blackhole: true},
+			},
+		},
+	}
+	rc, recorder := newTestController(t, "172.16.0.0/16,172.20.0.0/16", routes, nodes...)
+
+	if err := rc.sync(context.Background(), table, nodes, routes.routes[table]); err != nil {
+		t.Fatalf("sync route: %s", err.Error())
+	}
+
+	if len(routes.deleted) != 1 || routes.deleted[0].DestinationCIDR != "172.20.9.0/24" {
+		t.Fatalf("expect only route within cluster cidrs deleted, got %v", routes.deleted)
+	}
+	if len(routes.created) != 2 {
+		t.Fatalf("expect 2 routes created, got %d", len(routes.created))
+	}
+	for _, r := range routes.created {
+		if r.DestinationCIDR == "10.0.1.0/24" {
+			t.Fatalf("route should not be created for podCIDR out of cluster cidrs")
+		}
+	}
+	found := false
+	close(recorder.Events)
+	for e := range recorder.Events {
+		if strings.Contains(e, "InvalidPodCIDR") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expect InvalidPodCIDR warning event")
+	}
+}
+
+func TestPodCIDRAssignedLater(t *testing.T) {
+	table := "vtb-xxx"
+	routes := &fakeRoutes{routes: map[string][]*cloudprovider.Route{table: nil}}
+	node := newNode("i-a", "")
+	rc, recorder := newTestController(t, "172.16.0.0/16", routes, node)
+
+	// node without podCIDR is pending, not an error
+	if err := rc.syncNodeRoute(node.Name); err != nil {
+		t.Fatalf("node without podCIDR should not error: %s", err.Error())
+	}
+
+	// first update, podCIDR still empty
+	update1 := node.DeepCopy()
+	update1.Labels = map[string]string{"foo": "bar"}
+	updateNode(t, rc, node, update1)
+
+	// second update, podCIDR assigned
+	update2 := update1.DeepCopy()
+	update2.Spec.PodCIDR = "172.16.1.0/24"
+	updateNode(t, rc, update1, update2)
+
+	// resync, route should not be created again
+	if err := rc.syncNodeRoute(node.Name); err != nil {
+		t.Fatalf("sync node route: %s", err.Error())
+	}
+
+	if len(routes.created) != 1 || len(routes.routes[table]) != 1 {
+		t.Fatalf("expect exactly one route, got %d created", len(routes.created))
+	}
+	close(recorder.Events)
+	for e := range recorder.Events {
+		if strings.HasPrefix(e, v1.EventTypeWarning) {
+			t.Fatalf("expect no warning event, got %s", e)
+		}
+	}
+}
+
+func TestPodCIDRsDualStack(t *testing.T) {
+	table := "vtb-xxx"
+	routes := &fakeRoutes{routes: map[string][]*cloudprovider.Route{table: nil}}
+	node := newNode("i-a", "172.16.1.0/24")
+	node.Spec.PodCIDRs = []string{"172.16.1.0/24", "fd00:10:244:1::/64"}
+
+	// ipv4 only cluster, ipv6 podCIDR is ignored
+	rc, _ := newTestController(t, "172.16.0.0/16", routes, node)
+	if err := rc.syncNodeRoute(node.Name); err != nil {
+		t.Fatalf("sync node route: %s", err.Error())
+	}
+	if len(routes.created) != 1 {
+		t.Fatalf("expect 1 route for ipv4 only cluster, got %d", len(routes.created))
+	}
+
+	// dual stack cluster, route per family
+	routes = &fakeRoutes{routes: map[string][]*cloudprovider.Route{table: nil}}
+	rc, _ = newTestController(t, "172.16.0.0/16,fd00:10:244::/56", routes, node)
+	if err := rc.syncNodeRoute(node.Name); err != nil {
+		t.Fatalf("sync node route: %s", err.Error())
+	}
+	if len(routes.created) != 2 {
+		t.Fatalf("expect 2 routes for dual stack cluster, got %d", len(routes.created))
+	}
+}
+
+// ipv6Routes fakeRoutes telling whether the route tables accept ipv6 destinations
+type ipv6Routes struct {
+	*fakeRoutes
+	supported bool
+	probes    int
+}
+
+func (f *ipv6Routes) IPv6RoutesSupported(ctx context.Context, clusterName string) (bool, error) {
+	f.probes++
+	return f.supported, nil
+}
+
+// networkAvailable whether NodeNetworkUnavailable of the node is cleared
+func networkAvailable(t *testing.T, rc *RouteController, name string) bool {
+	node, err := rc.kubeClient.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get node %s: %s", name, err.Error())
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type == v1.NodeNetworkUnavailable {
+			return c.Status == v1.ConditionFalse
+		}
+	}
+	return false
+}
+
+func TestIPv6RouteSupport(t *testing.T) {
+	table := "vtb-xxx"
+	dualStack := "172.16.0.0/16,fd00:10:244::/56"
+	newDualStackNode := func() *v1.Node {
+		node := newNode("i-a", "172.16.1.0/24")
+		node.Spec.PodCIDRs = []string{"172.16.1.0/24", "fd00:10:244:1::/64"}
+		return node
+	}
+
+	// vpc with ipv6 enabled, route per family
+	routes := &ipv6Routes{
+		fakeRoutes: &fakeRoutes{routes: map[string][]*cloudprovider.Route{table: nil}},
+		supported:  true,
+	}
+	node := newDualStackNode()
+	rc, _ := newTestController(t, dualStack, routes, node)
+	if err := rc.syncNodeRoute(node.Name); err != nil {
+		t.Fatalf("sync node route: %s", err.Error())
+	}
+	if len(routes.created) != 2 {
+		t.Fatalf("expect 2 routes with ipv6 enabled, got %d", len(routes.created))
+	}
+	if !networkAvailable(t, rc, node.Name) {
+		t.Fatalf("expect network available once both families are routed")
+	}
+
+	// vpc without ipv6, ipv4 routed only and warned once
+	routes = &ipv6Routes{
+		fakeRoutes: &fakeRoutes{routes: map[string][]*cloudprovider.Route{table: nil}},
+	}
+	node = newDualStackNode()
+	rc, _ = newTestController(t, dualStack, routes, node)
+	for i := 0; i < 2; i++ {
+		if err := rc.syncNodeRoute(node.Name); err != nil {
+			t.Fatalf("sync node route: %s", err.Error())
+		}
+	}
+	if len(routes.created) != 1 || routes.created[0].DestinationCIDR != "172.16.1.0/24" {
+		t.Fatalf("expect only the ipv4 route without ipv6, got %v", routes.created)
+	}
+	if routes.probes != 2 || !rc.ipv6Warned {
+		t.Fatalf("expect ipv6 support probed on each sync and warned, probes=%d", routes.probes)
+	}
+	if !networkAvailable(t, rc, node.Name) {
+		t.Fatalf("expect network available when ipv6 is not required")
+	}
+
+	// ipv4 only cluster never probes ipv6 support
+	routes = &ipv6Routes{
+		fakeRoutes: &fakeRoutes{routes: map[string][]*cloudprovider.Route{table: nil}},
+	}
+	node = newDualStackNode()
+	rc, _ = newTestController(t, "172.16.0.0/16", routes, node)
+	if err := rc.syncNodeRoute(node.Name); err != nil {
+		t.Fatalf("sync node route: %s", err.Error())
+	}
+	if routes.probes != 0 {
+		t.Fatalf("expect no ipv6 probe for ipv4 only cluster, got %d", routes.probes)
+	}
+}
+
+func TestDualStackRequiresAllFamilies(t *testing.T) {
+	table := "vtb-xxx"
+	routes := &ipv6Routes{
+		fakeRoutes: &fakeRoutes{routes: map[string][]*cloudprovider.Route{table: nil}},
+		supported:  true,
+	}
+	// ipv6 podCIDR not assigned yet on a dual stack cluster
+	node := newNode("i-a", "172.16.1.0/24")
+	rc, _ := newTestController(t, "172.16.0.0/16,fd00:10:244::/56", routes, node)
+	if err := rc.syncNodeRoute(node.Name); err != nil {
+		t.Fatalf("sync node route: %s", err.Error())
+	}
+	if len(routes.created) != 1 {
+		t.Fatalf("expect the ipv4 route created, got %d", len(routes.created))
+	}
+	if networkAvailable(t, rc, node.Name) {
+		t.Fatalf("expect network unavailable until the ipv6 route is programmed")
+	}
+
+	// ipv6 podCIDR assigned
+	update := node.DeepCopy()
+	update.Spec.PodCIDRs = []string{"172.16.1.0/24", "fd00:10:244:1::/64"}
+	if _, err := rc.kubeClient.CoreV1().Nodes().Update(context.Background(), update, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update node: %s", err.Error())
+	}
+	updateNode(t, rc, node, update)
+	if len(routes.created) != 2 {
+		t.Fatalf("expect the ipv6 route created, got %d", len(routes.created))
+	}
+	if !networkAvailable(t, rc, node.Name) {
+		t.Fatalf("expect network available once both families are routed")
+	}
+}
+
+func TestInvalidPodCIDRSkipped(t *testing.T) {
+	table := "vtb-xxx"
+	routes := &ipv6Routes{
+		fakeRoutes: &fakeRoutes{routes: map[string][]*cloudprovider.Route{table: nil}},
+		supported:  true,
+	}
+	// the ipv4 podCIDR is out of the cluster cidr
+	node := newNode("i-a", "10.0.1.0/24")
+	node.Spec.PodCIDRs = []string{"10.0.1.0/24", "fd00:10:244:1::/64"}
+	rc, _ := newTestController(t, "172.16.0.0/16,fd00:10:244::/56", routes, node)
+	if err := rc.syncNodeRoute(node.Name); err != nil {
+		t.Fatalf("sync node route: %s", err.Error())
+	}
+	if len(routes.created) != 1 || routes.created[0].DestinationCIDR != "fd00:10:244:1::/64" {
+		t.Fatalf("expect the route of the valid podCIDR created, got %v", routes.created)
+	}
+	updated, err := rc.kubeClient.CoreV1().Nodes().Get(context.Background(), node.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get node %s: %s", node.Name, err.Error())
+	}
+	_, condition := helpers.GetNodeCondition(&updated.Status, v1.NodeNetworkUnavailable)
+	if condition == nil || condition.Status != v1.ConditionTrue {
+		t.Fatalf("expect network unavailable with the ipv4 podCIDR skipped, got %v", condition)
+	}
+}
+
+func TestConflictResolution(t *testing.T) {
+	table := "vtb-xxx"
+	newRoutes := func() *fakeRoutes {
+		return &fakeRoutes{
+			routes: map[string][]*cloudprovider.Route{
+				table: {
+					// manually created route with node podCIDR but wrong next hop
+					{DestinationCIDR: "172.16.1.0/24", TargetNode: "cn-hangzhou.i-gone"},
+					{DestinationCIDR: "172.16.2.0/24", TargetNode: "cn-hangzhou.i-alive"},
+				},
+			},
+			instances: map[string]bool{"cn-hangzhou.i-alive": true},
+		}
+	}
+	nodes := []*v1.Node{
+		newNode("i-a", "172.16.1.0/24"),
+		newNode("i-b", "172.16.2.0/24"),
+	}
+
+	countEvents := func(recorder *record.FakeRecorder, reason string) int {
+		close(recorder.Events)
+		count := 0
+		for e := range recorder.Events {
+			if strings.Contains(e, reason+" ") {
+				count++
+			}
+		}
+		return count
+	}
+
+	// report mode: nothing deleted or created, conflicts reported on both nodes
+	routes := newRoutes()
+	rc, recorder := newTestController(t, "172.16.0.0/16", routes, nodes...)
+	rc.conflictResolution = ConflictResolutionReport
+	if err := rc.sync(context.Background(), table, nodes, routes.routes[table]); err != nil {
+		t.Fatalf("sync route: %s", err.Error())
+	}
+	if len(routes.deleted) != 0 || len(routes.created) != 0 {
+		t.Fatalf("report mode should not mutate route table, deleted=%d, created=%d",
+			len(routes.deleted), len(routes.created))
+	}
+	if n := countEvents(recorder, "RouteConflict"); n != 2 {
+		t.Fatalf("expect 2 RouteConflict events, got %d", n)
+	}
+
+	// replace mode: only the route to nonexistent instance is replaced
+	routes = newRoutes()
+	rc, recorder = newTestController(t, "172.16.0.0/16", routes, nodes...)
+	rc.conflictResolution = ConflictResolutionReplace
+	if err := rc.sync(context.Background(), table, nodes, routes.routes[table]); err != nil {
+		t.Fatalf("sync route: %s", err.Error())
+	}
+	if len(routes.deleted) != 1 || routes.deleted[0].TargetNode != "cn-hangzhou.i-gone" {
+		t.Fatalf("expect route to nonexistent instance deleted, got %v", routes.deleted)
+	}
+	if len(routes.created) != 1 || routes.created[0].TargetNode != "cn-hangzhou.i-a" {
+		t.Fatalf("expect route recreated for node i-a, got %v", routes.created)
+	}
+	if n := countEvents(recorder, "RouteConflictReplaced"); n != 1 {
+		t.Fatalf("expect 1 RouteConflictReplaced event, got %d", n)
+	}
+}
+
+func TestStaleRouteOfNode(t *testing.T) {
+	table := "vtb-xxx"
+	routes := &fakeRoutes{
+		routes: map[string][]*cloudprovider.Route{
+			table: {
+				// left to the instance of the node replaced
+				{DestinationCIDR: "172.16.1.0/24", TargetNode: "cn-hangzhou.i-old"},
+				// targets the instance of another node
+				{DestinationCIDR: "172.16.2.0/24", TargetNode: "cn-hangzhou.i-a"},
+			},
+		},
+		instances: map[string]bool{"cn-hangzhou.i-old": true},
+	}
+	nodes := []*v1.Node{
+		newNode("i-a", "172.16.1.0/24"),
+		newNode("i-b", "172.16.2.0/24"),
+	}
+	rc, recorder := newTestController(t, "172.16.0.0/16", routes, nodes...)
+	rc.conflictResolution = ConflictResolutionReport
+	rc.deletedNodes.Store("cn-hangzhou.i-old", true)
+	if err := rc.sync(context.Background(), table, nodes, routes.routes[table]); err != nil {
+		t.Fatalf("sync route: %s", err.Error())
+	}
+	if len(routes.deleted) != 2 || len(routes.created) != 2 {
+		t.Fatalf("expect the stale routes of the nodes replaced in report mode, deleted=%v, created=%v",
+			routes.deleted, routes.created)
+	}
+	close(recorder.Events)
+	for e := range recorder.Events {
+		if strings.Contains(e, "RouteConflict ") {
+			t.Fatalf("expect no conflict reported of the routes of the controller, got %s", e)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	table := "vtb-xxx"
+	nodes := []*v1.Node{
+		newNode("i-a", "172.16.1.0/24"),
+		newNode("i-b", "172.16.2.0/24"),
+	}
+	routes := &fakeRoutes{
+		routes: map[string][]*cloudprovider.Route{
+			table: {
+				{DestinationCIDR: "172.16.2.0/24", TargetNode: "cn-hangzhou.i-b"},
+				{DestinationCIDR: "172.16.9.0/24", TargetNode: "cn-hangzhou.i-x", Blackhole: true},
+			},
+		},
+	}
+	rc, _ := newTestController(t, "172.16.0.0/16", routes, nodes...)
+	rc.dryRun = true
+	var plans []string
+	rc.planLogger = func(line string) { plans = append(plans, line) }
+
+	if err := rc.sync(context.Background(), table, nodes, routes.routes[table]); err != nil {
+		t.Fatalf("sync route: %s", err.Error())
+	}
+	if len(routes.created) != 0 || len(routes.deleted) != 0 {
+		t.Fatalf("expect no mutating call in dry-run, created=%d, deleted=%d",
+			len(routes.created), len(routes.deleted))
+	}
+	expected := []string{
+		"route dry-run: action=delete table=vtb-xxx destination=172.16.9.0/24 target=cn-hangzhou.i-x",
+		"route dry-run: action=create table=vtb-xxx destination=172.16.1.0/24 target=cn-hangzhou.i-a",
+	}
+	if strings.Join(plans, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected dry-run plan: %v", plans)
+	}
+	client := rc.kubeClient.(*fake.Clientset)
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "get" && action.GetVerb() != "list" {
+			t.Fatalf("expect node condition untouched in dry-run, got %s", action.GetVerb())
+		}
+	}
+}
+
+// pagedRoutes list the routes of fakeRoutes one per page
+type pagedRoutes struct {
+	*fakeRoutes
+	listing bool
+	// mutated whether a route is created or deleted while listing
+	mutated bool
+}
+
+func (p *pagedRoutes) ListRoutes(ctx context.Context, clusterName string, table string) ([]*cloudprovider.Route, error) {
+	return nil, fmt.Errorf("expect the routes listed by pages")
+}
+
+func (p *pagedRoutes) ListRoutePages(
+	ctx context.Context, clusterName string, table string, fn func(page []*cloudprovider.Route) error,
+) error {
+	p.listing = true
+	defer func() { p.listing = false }()
+	for _, route := range append([]*cloudprovider.Route{}, p.routes[table]...) {
+		if err := fn([]*cloudprovider.Route{route}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pagedRoutes) CreateRoute(ctx context.Context, clusterName string, nameHint string, table string, route *cloudprovider.Route) error {
+	p.mutated = p.mutated || p.listing
+	return p.fakeRoutes.CreateRoute(ctx, clusterName, nameHint, table, route)
+}
+
+func (p *pagedRoutes) DeleteRoute(ctx context.Context, clusterName string, table string, route *cloudprovider.Route) error {
+	p.mutated = p.mutated || p.listing
+	return p.fakeRoutes.DeleteRoute(ctx, clusterName, table, route)
+}
+
+func TestReconcileRoutePages(t *testing.T) {
+	table := "vtb-xxx"
+	nodes := []*v1.Node{
+		newNode("i-a", "172.16.1.0/24"),
+		newNode("i-b", "172.16.2.0/24"),
+	}
+	routes := &pagedRoutes{
+		fakeRoutes: &fakeRoutes{
+			routes: map[string][]*cloudprovider.Route{
+				table: {
+					{DestinationCIDR: "172.16.2.0/24", TargetNode: "cn-hangzhou.i-b"},
+					{DestinationCIDR: "172.16.9.0/24", TargetNode: "cn-hangzhou.i-x", Blackhole: true},
+					{DestinationCIDR: "192.168.9.0/24", TargetNode: "cn-hangzhou.i-y", Blackhole: true},
+				},
+			},
+		},
+	}
+	rc, _ := newTestController(t, "172.16.0.0/16", routes, nodes...)
+
+	if err := rc.reconcile(); err != nil {
+		t.Fatalf("reconcile: %s", err.Error())
+	}
+	if routes.mutated {
+		t.Fatalf("expect the routes changed once the pages are listed")
+	}
+	if len(routes.deleted) != 1 || routes.deleted[0].DestinationCIDR != "172.16.9.0/24" {
+		t.Fatalf("expect the stale route within the cluster cidr deleted, got %v", routes.deleted)
+	}
+	if len(routes.created) != 1 || routes.created[0].DestinationCIDR != "172.16.1.0/24" {
+		t.Fatalf("expect only the missing route of node i-a created, got %v", routes.created)
+	}
+}
+
+func listerWith(t *testing.T, nodes ...*v1.Node) corelisters.NodeLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, n := range nodes {
+		if err := indexer.Add(n); err != nil {
+			t.Fatalf("add node: %s", err.Error())
+		}
+	}
+	return corelisters.NewNodeLister(indexer)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"net"
+	"strings"
 )
 
 // RoutesOptions route controller options
@@ -14,11 +15,61 @@ type RoutesOptions struct {
 	MinResyncPeriod           metav1.Duration
 	RouteReconciliationPeriod metav1.Duration
 	ControllerStartInterval   metav1.Duration
+	// ConflictResolution how to resolve route conflicted with node podCIDR
+	ConflictResolution string
+	// DryRun only log the route changes without any mutating vpc call
+	DryRun bool
 }
 
+const (
+	// ConflictResolutionReport only report conflicted route with event
+	ConflictResolutionReport = "report"
+	// ConflictResolutionReplace delete and recreate conflicted route
+	// when the next hop instance does not exist
+	ConflictResolutionReplace = "replace"
+)
+
 // Options global options for route controller
 var Options = RoutesOptions{}
 
+// ParseClusterCIDRs parse comma separated cluster cidr list.
+func ParseClusterCIDRs(cidrs string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("error parse cluster cidr: %s, message: %s", cidr, err.Error())
+		}
+		result = append(result, ipnet)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("empty cluster cidr: [%s]", cidrs)
+	}
+	return result, nil
+}
+
+// ContainedByAny returns true if inner cidr is within any of the outer cidrs.
+func ContainedByAny(outers []*net.IPNet, inner string) (bool, error) {
+	_, innerCidr, err := net.ParseCIDR(inner)
+	if err != nil {
+		return false, fmt.Errorf("error parse inner cidr: %s, message: %s", inner, err.Error())
+	}
+	lastIP := make([]byte, len(innerCidr.IP))
+	for i := range lastIP {
+		lastIP[i] = innerCidr.IP[i] | ^innerCidr.Mask[i]
+	}
+	for _, outer := range outers {
+		if outer.Contains(innerCidr.IP) && outer.Contains(lastIP) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // RealContainsCidr real contains cidr
 func RealContainsCidr(outer string, inner string) (bool, error) {
 	contains, err := ContainsCidr(outer, inner)
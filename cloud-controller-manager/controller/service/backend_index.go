@@ -0,0 +1,83 @@
+package service
+
+import (
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"sort"
+	"sync"
+)
+
+// A node deleted by the node controller, eg. its instance is released, is
+// removed from the loadbalancers by the syncs its deletion from the node
+// informer triggers, which may be late while the loadbalancers keep checking
+// a dead ip, and the instance id may be recycled. The backend index maps each
+// node to the services whose loadbalancer has it as a backend, as of their
+// last successful ensure, so that the node controller enqueues them at once
+// when it deletes the node, see EnqueueServicesOfNode.
+
+// backendIndex the services of each backend node, the zero value is empty
+type backendIndex struct {
+	lock sync.RWMutex
+	// nodes the names of the backend nodes of each service key
+	nodes map[string][]string
+	// services the service keys of each node name
+	services map[string]map[string]bool
+}
+
+// set the backend nodes of the service, replacing the former ones
+func (i *backendIndex) set(k string, nodes []string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.unset(k)
+	if len(nodes) == 0 {
+		return
+	}
+	if i.nodes == nil {
+		i.nodes = map[string][]string{}
+		i.services = map[string]map[string]bool{}
+	}
+	i.nodes[k] = nodes
+	for _, node := range nodes {
+		if i.services[node] == nil {
+			i.services[node] = map[string]bool{}
+		}
+		i.services[node][k] = true
+	}
+}
+
+// remove the service, eg. its loadbalancer is deleted
+func (i *backendIndex) remove(k string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.unset(k)
+}
+
+func (i *backendIndex) unset(k string) {
+	for _, node := range i.nodes[k] {
+		delete(i.services[node], k)
+		if len(i.services[node]) == 0 {
+			delete(i.services, node)
+		}
+	}
+	delete(i.nodes, k)
+}
+
+// servicesOf the keys of the services having the node as a backend, sorted
+func (i *backendIndex) servicesOf(node string) []string {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	var keys []string
+	for k := range i.services[node] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EnqueueServicesOfNode sync the services whose loadbalancer has the node as
+// a backend at once, called by the node controller once it deletes the node.
+func (con *Controller) EnqueueServicesOfNode(node string) {
+	for _, k := range con.backends.servicesOf(node) {
+		utils.InfoS("Enqueue service for the node deleted", utils.LogKeyService, k, utils.LogKeyNode, node)
+		Enqueue(con.queues[SERVICE_QUEUE], k)
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	queue "k8s.io/client-go/util/workqueue"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBackendIndex(t *testing.T) {
+	index := &backendIndex{}
+	expect := func(node string, keys ...string) {
+		t.Helper()
+		if got := index.servicesOf(node); !reflect.DeepEqual(got, keys) {
+			t.Fatalf("expect the services of %s %v, got %v", node, keys, got)
+		}
+	}
+	expect("worker-1")
+
+	index.set("default/a", []string{"worker-1", "worker-2"})
+	index.set("default/b", []string{"worker-2"})
+	expect("worker-1", "default/a")
+	expect("worker-2", "default/a", "default/b")
+
+	// the backends of the latest ensure replace the former ones
+	index.set("default/a", []string{"worker-3"})
+	expect("worker-1")
+	expect("worker-2", "default/b")
+	expect("worker-3", "default/a")
+
+	index.set("default/b", nil)
+	expect("worker-2")
+
+	index.remove("default/a")
+	expect("worker-3")
+	if len(index.nodes) != 0 || len(index.services) != 0 {
+		t.Fatalf("expect the index emptied, got %v and %v", index.nodes, index.services)
+	}
+}
+
+func TestEnqueueServicesOfDeletedNode(t *testing.T) {
+	svc := testService(nil)
+	other := testService(nil)
+	other.Name = "other-service"
+	client := fake.NewSimpleClientset(svc, other)
+	ifactory := informers.NewSharedInformerFactory(client, 0)
+	for _, s := range []*v1.Service{svc, other} {
+		if err := ifactory.Core().V1().Services().Informer().GetIndexer().Add(s); err != nil {
+			t.Fatalf("add service: %s", err.Error())
+		}
+	}
+	cloud := &fakeCloud{}
+	que := &recordQueue{enqueues: make(chan enqueue, 10)}
+	con := &Controller{
+		cloud:    cloud,
+		client:   client,
+		ifactory: ifactory,
+		local:    &Context{},
+		recorder: record.NewFakeRecorder(100),
+		queues:   map[string]queue.DelayingInterface{SERVICE_QUEUE: que},
+	}
+
+	// the index is maintained by each successful ensure
+	cloud.backendNodes = []string{"worker-1", "worker-2"}
+	if err := con.ServiceSyncTask(key(svc)); err != nil {
+		t.Fatalf("sync service: %s", err.Error())
+	}
+	cloud.backendNodes = []string{"worker-2"}
+	if err := con.ServiceSyncTask(key(other)); err != nil {
+		t.Fatalf("sync service: %s", err.Error())
+	}
+	// the keys enqueued on the deletion of the node, those enqueued by the
+	// syncs are dropped
+	enqueued := func(node string) string {
+		for len(que.enqueues) > 0 {
+			<-que.enqueues
+		}
+		con.EnqueueServicesOfNode(node)
+		var keys []string
+		for len(que.enqueues) > 0 {
+			keys = append(keys, (<-que.enqueues).key.(string))
+		}
+		return strings.Join(keys, ",")
+	}
+	if keys := enqueued("worker-2"); keys != "default/basic-service,default/other-service" {
+		t.Fatalf("expect both services enqueued for worker-2, got %s", keys)
+	}
+	if keys := enqueued("worker-1"); keys != "default/basic-service" {
+		t.Fatalf("expect the service enqueued for worker-1, got %s", keys)
+	}
+
+	// a failed ensure keeps the backends of the last successful one
+	cloud.err = cloudError("Forbidden.RAM")
+	cloud.backendNodes = nil
+	_ = con.ServiceSyncTask(key(svc))
+	if keys := enqueued("worker-1"); keys != "default/basic-service" {
+		t.Fatalf("expect the backends kept after a failed ensure, got %s", keys)
+	}
+
+	// the service whose loadbalancer is deleted is dropped
+	cloud.err = nil
+	if err := ifactory.Core().V1().Services().Informer().GetIndexer().Delete(svc); err != nil {
+		t.Fatalf("delete service: %s", err.Error())
+	}
+	if err := con.ServiceSyncTask(key(svc)); err != nil {
+		t.Fatalf("sync deleted service: %s", err.Error())
+	}
+	if keys := enqueued("worker-2"); keys != "default/other-service" {
+		t.Fatalf("expect only the remaining service enqueued, got %s", keys)
+	}
+	if keys := enqueued("worker-1"); keys != "" {
+		t.Fatalf("expect no service enqueued for worker-1, got %s", keys)
+	}
+}
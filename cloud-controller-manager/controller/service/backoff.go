@@ -0,0 +1,94 @@
+package service
+
+import (
+	"encoding/json"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// BACKOFF_DEBUG_PATH the path of the debug endpoint listing the services in backoff
+	BACKOFF_DEBUG_PATH = "/debug/services/backoff"
+
+	// REQUEUE_REASON_THROTTLE, REQUEUE_REASON_BUDGET, REQUEUE_REASON_ERROR
+	// reasons of the requeues, the throttled syncs back off together, the ones
+	// out of their api budget until the budget is refilled, the others per
+	// service
+	REQUEUE_REASON_THROTTLE = "throttle"
+	REQUEUE_REASON_BUDGET   = "budget"
+	REQUEUE_REASON_ERROR    = "error"
+)
+
+// Backoffs the services requeued with a delay after a failed sync, until they
+// are synced successfully or not retried any more. They are counted by the
+// ccm_services_in_backoff gauge and listed at BACKOFF_DEBUG_PATH.
+var Backoffs = &backoffRegistry{entries: map[string]BackoffEntry{}}
+
+// BackoffEntry the last requeue of a service
+type BackoffEntry struct {
+	Key string `json:"key"`
+	// Reason REQUEUE_REASON_THROTTLE, REQUEUE_REASON_BUDGET or REQUEUE_REASON_ERROR
+	Reason string `json:"reason"`
+	// Backoff the delay of the last requeue
+	Backoff string `json:"backoff"`
+	// Requeued when the service was requeued
+	Requeued time.Time `json:"requeued"`
+	// LastSuccessAge the time since the last successful sync of the service,
+	// empty if it is not synced successfully since the start, see LastSyncs
+	LastSuccessAge string `json:"lastSuccessAge,omitempty"`
+}
+
+type backoffRegistry struct {
+	lock    sync.Mutex
+	entries map[string]BackoffEntry
+}
+
+func requeueReason(result SyncResult) string {
+	switch result {
+	case ResultThrottled:
+		return REQUEUE_REASON_THROTTLE
+	case ResultBudgetExceeded:
+		return REQUEUE_REASON_BUDGET
+	}
+	return REQUEUE_REASON_ERROR
+}
+
+func (r *backoffRegistry) requeued(key, reason string, delay time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries[key] = BackoffEntry{Key: key, Reason: reason, Backoff: delay.String(), Requeued: time.Now()}
+	metric.ServicesInBackoff.Set(float64(len(r.entries)))
+}
+
+func (r *backoffRegistry) forget(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.entries, key)
+	metric.ServicesInBackoff.Set(float64(len(r.entries)))
+}
+
+// List the services in backoff by key
+func (r *backoffRegistry) List() []BackoffEntry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	entries := make([]BackoffEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		if age, ok := LastSyncs.Age(e.Key); ok {
+			e.LastSuccessAge = age.Round(time.Second).String()
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// ServeHTTP list the services in backoff in json
+func (r *backoffRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(r.List()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// simulatedQueue a delaying queue whose delayed items are added once the
+// simulated time passes them
+type simulatedQueue struct {
+	workqueue.Interface
+	lock    sync.Mutex
+	now     time.Duration
+	pending map[interface{}]time.Duration
+}
+
+func (q *simulatedQueue) AddAfter(item interface{}, delay time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.pending[item] = q.now + delay
+}
+
+// advance the simulated time, adding the items due
+func (q *simulatedQueue) advance(d time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.now += d
+	for item, due := range q.pending {
+		if due <= q.now {
+			delete(q.pending, item)
+			q.Add(item)
+		}
+	}
+}
+
+// delay the delay of the pending item, false if it is not pending
+func (q *simulatedQueue) delay(item interface{}) (time.Duration, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	due, ok := q.pending[item]
+	return due - q.now, ok
+}
+
+func TestBackoffMetrics(t *testing.T) {
+	que := &simulatedQueue{Interface: workqueue.New(), pending: map[interface{}]time.Duration{}}
+	defer que.ShutDown()
+	const key = "default/backoff"
+	// the results of the syncs in turn, succeeded once they are out
+	results := make(chan error, 4)
+	results <- cloudError("Throttling.User")
+	results <- cloudError("ServiceUnavailable")
+	results <- cloudError("ServiceUnavailable")
+	synced := make(chan struct{}, 4)
+	task := func(key string) error {
+		defer func() { synced <- struct{}{} }()
+		select {
+		case err := <-results:
+			return err
+		default:
+			return nil
+		}
+	}
+	go WorkerFunc(&Context{}, que, task, NewRequeuePolicy())()
+
+	requeues := func(reason string) float64 {
+		return testutil.ToFloat64(metric.ServiceRequeues.WithLabelValues(reason))
+	}
+	throttled, errored := requeues(REQUEUE_REASON_THROTTLE), requeues(REQUEUE_REASON_ERROR)
+	// wait for the sync of the key, returning the delay of its requeue
+	waitSync := func(step string) (time.Duration, bool) {
+		select {
+		case <-synced:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: expect the service synced", step)
+		}
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if delay, ok := que.delay(key); ok {
+				return delay, true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return 0, false
+	}
+	// the last backoff listed by the debug endpoint
+	listed := func() []BackoffEntry {
+		rec := httptest.NewRecorder()
+		Backoffs.ServeHTTP(rec, httptest.NewRequest("GET", BACKOFF_DEBUG_PATH, nil))
+		var entries []BackoffEntry
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatalf("decode %s: %s", rec.Body.String(), err.Error())
+		}
+		return entries
+	}
+	expect := func(step string, delay time.Duration, reason string) {
+		if testutil.ToFloat64(metric.ServicesInBackoff) != 1 {
+			t.Fatalf("%s: expect the service in backoff, got %v", step, testutil.ToFloat64(metric.ServicesInBackoff))
+		}
+		entries := listed()
+		if len(entries) != 1 || entries[0].Key != key || entries[0].Reason != reason || entries[0].Backoff != delay.String() {
+			t.Fatalf("%s: expect the backoff %s of %s listed, got %v", step, delay, reason, entries)
+		}
+	}
+
+	que.Add(key)
+	delay, ok := waitSync("throttled")
+	if !ok || requeues(REQUEUE_REASON_THROTTLE) != throttled+1 {
+		t.Fatalf("expect the throttled sync requeued, got %t, %v", ok, requeues(REQUEUE_REASON_THROTTLE)-throttled)
+	}
+	expect("throttled", delay, REQUEUE_REASON_THROTTLE)
+
+	// nothing happens before the delay passes
+	que.advance(delay - time.Millisecond)
+	if testutil.ToFloat64(metric.ServicesInBackoff) != 1 {
+		t.Fatalf("expect the service kept in backoff until the delay passes")
+	}
+	que.advance(time.Millisecond)
+	delay, ok = waitSync("first error")
+	if !ok || delay != REQUEUE_BASE_DELAY*2 || requeues(REQUEUE_REASON_ERROR) != errored+1 {
+		t.Fatalf("expect the error backed off, got %t, %s, %v", ok, delay, requeues(REQUEUE_REASON_ERROR)-errored)
+	}
+	expect("first error", delay, REQUEUE_REASON_ERROR)
+
+	que.advance(delay)
+	delay, ok = waitSync("second error")
+	if !ok || delay != REQUEUE_BASE_DELAY*4 || requeues(REQUEUE_REASON_ERROR) != errored+2 {
+		t.Fatalf("expect the error backed off further, got %t, %s, %v", ok, delay, requeues(REQUEUE_REASON_ERROR)-errored)
+	}
+	expect("second error", delay, REQUEUE_REASON_ERROR)
+
+	que.advance(delay)
+	if _, ok := waitSync("succeeded"); ok {
+		t.Fatalf("expect the synced service not requeued")
+	}
+	if testutil.ToFloat64(metric.ServicesInBackoff) != 0 || len(listed()) != 0 {
+		t.Fatalf("expect no service in backoff once synced, got %v, %v",
+			testutil.ToFloat64(metric.ServicesInBackoff), listed())
+	}
+	if requeues(REQUEUE_REASON_THROTTLE) != throttled+1 || requeues(REQUEUE_REASON_ERROR) != errored+2 {
+		t.Fatalf("expect no requeue counted once synced")
+	}
+}
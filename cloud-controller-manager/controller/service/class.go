@@ -0,0 +1,78 @@
+package service
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+)
+
+// A service picks the implementation of its loadbalancer by its class. The
+// services of no class and those of the class claimed by --loadbalancer-class
+// are managed by the controller, the others are left to the controllers of
+// their class, eg. an nlb controller.
+//
+// A managed service, told by the hash label, which is given a class of
+// another controller is released: it is synced no more, and its loadbalancer
+// is kept or deleted by --loadbalancer-class-release-policy.
+//
+// spec.loadBalancerClass is dropped by the decoding of the vendored
+// k8s.io/api v0.18, which predates it, the class is read from the legacy
+// annotation until the client is upgraded, see serviceClass.
+
+const (
+	// ReleasePolicyKeep keep the loadbalancer of the released service, its
+	// listeners and backends are left as is
+	ReleasePolicyKeep = "keep"
+	// ReleasePolicyDelete delete the loadbalancer of the released service as
+	// if the service was deleted
+	ReleasePolicyDelete = "delete"
+)
+
+// ClassOptions options of the loadbalancer class
+type ClassOptions struct {
+	// Class the class claimed by the controller besides the empty one
+	Class string
+	// ReleasePolicy what to do with the loadbalancer of a service given the
+	// class of another controller, keep or delete
+	ReleasePolicy string
+}
+
+// Options global class options for service controller
+var Options = ClassOptions{ReleasePolicy: ReleasePolicyKeep}
+
+// ValidateClassOptions the release policy must be keep or delete
+func ValidateClassOptions(opts ClassOptions) error {
+	switch opts.ReleasePolicy {
+	case "", ReleasePolicyKeep, ReleasePolicyDelete:
+		return nil
+	}
+	return fmt.Errorf("unknown loadbalancer class release policy %s, must be %s or %s",
+		opts.ReleasePolicy, ReleasePolicyKeep, ReleasePolicyDelete)
+}
+
+// serviceClass the loadbalancer class of the service
+func serviceClass(svc *v1.Service) string {
+	return svc.Annotations[CCM_CLASS]
+}
+
+// isProcessNeeded whether the service is of no class or of the class claimed
+func isProcessNeeded(svc *v1.Service) bool {
+	class := serviceClass(svc)
+	return class == "" || (Options.Class != "" && class == Options.Class)
+}
+
+// isManaged whether the loadbalancer of the service is synced by the
+// controller, the hash label is added by the first sync succeeded. The
+// loadbalancer id annotation written along with it tells a service whose
+// label is stripped by hand.
+func isManaged(svc *v1.Service) bool {
+	_, hashed := svc.Labels[utils.LabelServiceHash]
+	_, published := svc.Annotations[utils.ServiceAnnotationLoadBalancerIdResult]
+	return hashed || published
+}
+
+// NeedRelease whether the service is managed but of the class of another
+// controller
+func NeedRelease(svc *v1.Service) bool {
+	return !isProcessNeeded(svc) && isManaged(svc)
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+// classed the service of the class, managed by the controller if hashed
+func classed(svc *v1.Service, class string, hashed bool) *v1.Service {
+	if class != "" {
+		svc.Annotations[CCM_CLASS] = class
+	}
+	if hashed {
+		svc.Labels = map[string]string{utils.LabelServiceHash: "hash"}
+		svc.Annotations[utils.ServiceAnnotationLoadBalancerIdResult] = "lb-class"
+	}
+	return svc
+}
+
+func withClassOptions(t *testing.T, opts ClassOptions) {
+	former := Options
+	Options = opts
+	t.Cleanup(func() { Options = former })
+}
+
+func TestIsProcessNeeded(t *testing.T) {
+	withClassOptions(t, ClassOptions{Class: "alibabacloud.com/clb", ReleasePolicy: ReleasePolicyKeep})
+	cases := []struct {
+		name    string
+		svc     *v1.Service
+		process bool
+		release bool
+	}{
+		{name: "no class", svc: classed(testService(nil), "", false), process: true},
+		{name: "class claimed", svc: classed(testService(nil), "alibabacloud.com/clb", true), process: true},
+		{name: "class of another controller", svc: classed(testService(nil), "alibabacloud.com/nlb", false)},
+		{name: "managed given another class", svc: classed(testService(nil), "alibabacloud.com/nlb", true), release: true},
+	}
+	for _, c := range cases {
+		if process := isProcessNeeded(c.svc); process != c.process {
+			t.Errorf("%s: expect isProcessNeeded %t, got %t", c.name, c.process, process)
+		}
+		if release := NeedRelease(c.svc); release != c.release {
+			t.Errorf("%s: expect NeedRelease %t, got %t", c.name, c.release, release)
+		}
+	}
+
+	// no class is claimed but the empty one
+	Options.Class = ""
+	if isProcessNeeded(classed(testService(nil), "alibabacloud.com/clb", false)) {
+		t.Errorf("expect the class not claimed skipped")
+	}
+}
+
+func TestValidateClassOptions(t *testing.T) {
+	for _, policy := range []string{"", ReleasePolicyKeep, ReleasePolicyDelete} {
+		if err := ValidateClassOptions(ClassOptions{ReleasePolicy: policy}); err != nil {
+			t.Errorf("expect policy %q valid, got %s", policy, err.Error())
+		}
+	}
+	if err := ValidateClassOptions(ClassOptions{ReleasePolicy: "orphan"}); err == nil {
+		t.Errorf("expect policy orphan refused")
+	}
+}
+
+func TestRelease(t *testing.T) {
+	cases := []struct {
+		policy  string
+		deleted bool
+		action  string
+	}{
+		{policy: ReleasePolicyKeep, action: "kept"},
+		{policy: ReleasePolicyDelete, deleted: true, action: "deleted"},
+	}
+	for _, c := range cases {
+		withClassOptions(t, ClassOptions{ReleasePolicy: c.policy})
+		svc := classed(testService(nil), "alibabacloud.com/nlb", true)
+		client := fake.NewSimpleClientset(svc)
+		recorder := record.NewFakeRecorder(10)
+		cloud := &fakeCloud{deleted: "lb-class"}
+		local := &Context{}
+		local.Set(key(svc), svc)
+		con := &Controller{cloud: cloud, client: client, local: local, recorder: recorder}
+
+		if err := con.update(nil, svc); err != nil {
+			t.Fatalf("%s: release: %s", c.policy, err.Error())
+		}
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		joined := strings.Join(events, "\n")
+		if strings.Contains(joined, "DeletedLoadBalancer") != c.deleted {
+			t.Errorf("%s: expect the loadbalancer deleted %t, got %s", c.policy, c.deleted, joined)
+		}
+		if !strings.Contains(joined, "ReleasedLoadBalancer") || !strings.Contains(joined, c.action) {
+			t.Errorf("%s: expect the release event of the loadbalancer %s, got %s", c.policy, c.action, joined)
+		}
+		if local.Get(key(svc)) != nil {
+			t.Errorf("%s: expect the released service removed from the local cache", c.policy)
+		}
+		updated, err := client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: get service: %s", c.policy, err.Error())
+		}
+		if isManaged(updated) || updated.Annotations[utils.ServiceAnnotationLoadBalancerIdResult] != "" {
+			t.Errorf("%s: expect the hash label and the id annotation removed, got %v %v",
+				c.policy, updated.Labels, updated.Annotations)
+		}
+		if NeedRelease(updated) {
+			t.Errorf("%s: expect the released service released only once", c.policy)
+		}
+
+		// the service given back the class claimed is processed again
+		delete(updated.Annotations, CCM_CLASS)
+		if !isProcessNeeded(updated) {
+			t.Errorf("%s: expect the service of no class processed again", c.policy)
+		}
+	}
+}
+
+func TestReleaseUnmanaged(t *testing.T) {
+	withClassOptions(t, ClassOptions{ReleasePolicy: ReleasePolicyDelete})
+	svc := classed(testService(nil), "alibabacloud.com/nlb", false)
+	recorder := record.NewFakeRecorder(10)
+	con := &Controller{cloud: &fakeCloud{deleted: "lb-class"}, client: fake.NewSimpleClientset(svc), local: &Context{}, recorder: recorder}
+	if err := con.update(nil, svc); err != nil {
+		t.Fatalf("release: %s", err.Error())
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("expect the service never managed left alone, got %s", <-recorder.Events)
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+)
+
+// RECONCILE_CONTROLLER the controller label of the ccm_reconcile_total metric
+const RECONCILE_CONTROLLER = "service"
+
+// SyncResult the class of the outcome of a sync, the result label of the
+// ccm_reconcile_total metric. The class decides whether and when the sync is
+// retried by the RequeuePolicy.
+type SyncResult string
+
+const (
+	ResultSuccess         SyncResult = "success"
+	ResultThrottled       SyncResult = "throttled"
+	ResultCloudError      SyncResult = "cloud_error"
+	ResultAPIServerError  SyncResult = "apiserver_error"
+	ResultConflict        SyncResult = "conflict"
+	ResultQuotaExceeded   SyncResult = "quota_exceeded"
+	ResultBudgetExceeded  SyncResult = "budget_exceeded"
+	ResultValidationError SyncResult = "validation_error"
+	ResultNotFound        SyncResult = "not_found"
+)
+
+// Retryable whether the sync of the result is retried
+func (r SyncResult) Retryable() bool {
+	switch r {
+	case ResultThrottled, ResultCloudError, ResultAPIServerError, ResultConflict, ResultQuotaExceeded,
+		ResultBudgetExceeded:
+		return true
+	}
+	return false
+}
+
+// CloudErrorCode the code of the Alibaba Cloud api error in the chain of
+// err, eg. Throttling.User. Empty if err carries none.
+func CloudErrorCode(err error) string {
+	e, _ := utils.ParseCloudError(err)
+	return e.Code
+}
+
+// ClassifyError the class of the error of a sync
+func ClassifyError(err error) SyncResult {
+	if err == nil {
+		return ResultSuccess
+	}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		switch status.Status().Reason {
+		case metav1.StatusReasonNotFound:
+			return ResultNotFound
+		case metav1.StatusReasonConflict:
+			// the service is changed since read, the next sync reads it again
+			return ResultConflict
+		}
+		return ResultAPIServerError
+	}
+	message := err.Error()
+	if strings.Contains(message, utils.InvalidAnnotation) {
+		return ResultValidationError
+	}
+	if strings.Contains(message, utils.APIBudgetExceeded) {
+		return ResultBudgetExceeded
+	}
+	code := CloudErrorCode(err)
+	switch {
+	case strings.HasPrefix(code, "Throttling"):
+		return ResultThrottled
+	case strings.Contains(code, "QuotaExceed"):
+		// eg. QuotaExceeded.LoadBalancersPerUser, which lasts until the quota is raised
+		return ResultQuotaExceeded
+	case strings.HasSuffix(code, ".NotFound") || strings.HasSuffix(code, ".NotExist"):
+		return ResultNotFound
+	case strings.HasPrefix(code, "Missing") || strings.HasPrefix(code, "InvalidParameter"):
+		// the request built from the annotations is rejected
+		return ResultValidationError
+	case code == "" && strings.Contains(message, "Throttling"):
+		return ResultThrottled
+	}
+	// the errors of the cloud provider, eg. of the clients
+	return ResultCloudError
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"testing"
+	"time"
+)
+
+// sdkError an Alibaba Cloud sdk error of the code
+func sdkError(code string) *common.Error {
+	return &common.Error{
+		ErrorResponse: common.ErrorResponse{RequestId: "req-1", Code: code, Message: "The request is denied."},
+		StatusCode:    400,
+	}
+}
+
+// cloudError the sdk error of the code wrapped by the cloud provider
+func cloudError(code string) error {
+	return fmt.Errorf("ensure listener error: %w", sdkError(code))
+}
+
+// apiError an api error of the clients wrapping the sdk errors
+type apiError struct{ code, requestId, message string }
+
+func (e *apiError) Error() string          { return "api error " + e.code }
+func (e *apiError) ErrorCode() string      { return e.code }
+func (e *apiError) ErrorRequestId() string { return e.requestId }
+func (e *apiError) ErrorMessage() string   { return e.message }
+
+func TestFormatCloudError(t *testing.T) {
+	cases := []struct {
+		name    string
+		err     error
+		message string
+	}{
+		{
+			name:    "sdk error",
+			err:     sdkError("Forbidden.RAM"),
+			message: "code=Forbidden.RAM requestId=req-1 message=The request is denied.",
+		},
+		{
+			name:    "wrapped sdk error",
+			err:     cloudError("InvalidParameter.ListenerPort"),
+			message: "code=InvalidParameter.ListenerPort requestId=req-1 message=The request is denied.",
+		},
+		{
+			name: "nested fmt.Errorf chain",
+			err: fmt.Errorf("ensure loadbalancer error: %w",
+				fmt.Errorf("ensure listener: %w",
+					fmt.Errorf("start tcp listener error: %w", sdkError("Throttling.User")))),
+			message: "code=Throttling.User requestId=req-1 message=The request is denied.",
+		},
+		{
+			name:    "api error of the clients",
+			err:     fmt.Errorf("update backend servers: error %w", &apiError{"ServiceUnavailable", "req-2", "The service is unavailable."}),
+			message: "code=ServiceUnavailable requestId=req-2 message=The service is unavailable.",
+		},
+		{
+			name:    "plain error",
+			err:     fmt.Errorf("error get available nodes"),
+			message: "error get available nodes",
+		},
+		{
+			name:    "sdk error wrapped as a string",
+			err:     fmt.Errorf("ensure listener error: %s", sdkError("Forbidden.RAM").Error()),
+			message: "ensure listener error: " + sdkError("Forbidden.RAM").Error(),
+		},
+	}
+	for _, c := range cases {
+		if message := getLogMessage(c.err); message != c.message {
+			t.Errorf("%s: expect %q, got %q", c.name, c.message, message)
+		}
+	}
+	if _, ok := utils.ParseCloudError(nil); ok {
+		t.Errorf("expect no api error of nil")
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	resource := schema.GroupResource{Resource: "services"}
+	cases := []struct {
+		name   string
+		err    error
+		result SyncResult
+	}{
+		{name: "nil", result: ResultSuccess},
+		{name: "throttling", err: cloudError("Throttling"), result: ResultThrottled},
+		{name: "throttling user", err: cloudError("Throttling.User"), result: ResultThrottled},
+		{name: "throttling api", err: cloudError("Throttling.Api"), result: ResultThrottled},
+		{name: "throttling message", err: fmt.Errorf("Request was denied due to Throttling"), result: ResultThrottled},
+		{name: "forbidden", err: cloudError("Forbidden.RAM"), result: ResultCloudError},
+		{name: "service unavailable", err: cloudError("ServiceUnavailable"), result: ResultCloudError},
+		{name: "quota", err: cloudError("QuotaExceeded.LoadBalancersPerUser"), result: ResultQuotaExceeded},
+		{name: "circuit open", err: cloudError("CircuitOpen"), result: ResultCloudError},
+		{name: "client failure", err: cloudError("AliyunGoClientFailure"), result: ResultCloudError},
+		{name: "nested throttling", err: fmt.Errorf("ensure loadbalancer error: %w", cloudError("Throttling.User")), result: ResultThrottled},
+		{name: "api error not found", err: fmt.Errorf("describe: vgroup error, %w", &apiError{code: "InvalidVServerGroupId.NotFound"}), result: ResultNotFound},
+		{name: "loadbalancer not found", err: cloudError("InvalidLoadBalancerId.NotFound"), result: ResultNotFound},
+		{name: "vswitch not exist", err: cloudError("InvalidVSwitchId.NotExist"), result: ResultNotFound},
+		{name: "invalid parameter", err: cloudError("InvalidParameter"), result: ResultValidationError},
+		{name: "missing parameter", err: cloudError("MissingParameter.Bandwidth"), result: ResultValidationError},
+		{name: "invalid annotation", err: fmt.Errorf("%s: annotation %s must be an integer", utils.InvalidAnnotation, "x"), result: ResultValidationError},
+		{name: "api budget exceeded", err: fmt.Errorf("ensure loadbalancer error: %s: service default/nginx used up its budget", utils.APIBudgetExceeded), result: ResultBudgetExceeded},
+		{name: "apiserver conflict", err: fmt.Errorf("update service status: %w", apierrors.NewConflict(resource, "nginx", fmt.Errorf("changed"))), result: ResultConflict},
+		{name: "apiserver unavailable", err: fmt.Errorf("update service hash: %w", apierrors.NewServiceUnavailable("etcd")), result: ResultAPIServerError},
+		{name: "apiserver not found", err: fmt.Errorf("update service hash: %w", apierrors.NewNotFound(resource, "nginx")), result: ResultNotFound},
+		{name: "unknown", err: fmt.Errorf("error get available nodes"), result: ResultCloudError},
+	}
+	for _, c := range cases {
+		if result := ClassifyError(c.err); result != c.result {
+			t.Errorf("%s: expect %s, got %s", c.name, c.result, result)
+		}
+	}
+	for result, retryable := range map[SyncResult]bool{
+		ResultThrottled: true, ResultCloudError: true, ResultAPIServerError: true,
+		ResultConflict: true, ResultQuotaExceeded: true,
+		ResultValidationError: false, ResultNotFound: false, ResultSuccess: false,
+	} {
+		if result.Retryable() != retryable {
+			t.Errorf("%s: expect retryable %t", result, retryable)
+		}
+	}
+}
+
+func fastBackoff() wait.Backoff {
+	return wait.Backoff{Duration: time.Millisecond, Steps: 3, Factor: 1}
+}
+
+func TestRetryByClass(t *testing.T) {
+	svc := testService(nil)
+	calls := 0
+	invalid := fmt.Errorf("%s: bad annotation", utils.InvalidAnnotation)
+	err := retry(nil, func(svc *v1.Service) error { calls++; return invalid }, svc)
+	if err != invalid || calls != 1 {
+		t.Fatalf("expect the validation error not retried, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	backoff := fastBackoff()
+	err = retry(&backoff, func(svc *v1.Service) error { calls++; return cloudError("ServiceUnavailable") }, svc)
+	if ClassifyError(err) != ResultCloudError || calls != backoff.Steps {
+		t.Fatalf("expect the cloud error retried %d times, got %v after %d calls", backoff.Steps, err, calls)
+	}
+}
+
+// fakeCloud the loadbalancer of the cloud provider failing by err, deleting
+// the loadbalancer of the id if set
+type fakeCloud struct {
+	err     error
+	deleted string
+	// forced whether each sync ignored the service hash
+	forced []bool
+	// backends published by the ensure when positive
+	backends int
+	// backendNodes published by the ensure when not nil
+	backendNodes []string
+}
+
+func (c *fakeCloud) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
+	return nil, false, nil
+}
+
+func (c *fakeCloud) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
+	return ""
+}
+
+func (c *fakeCloud) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	c.forced = append(c.forced, utils.IsForceResync(ctx))
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.backends > 0 {
+		utils.PublishBackends(ctx, c.backends)
+	}
+	if c.backendNodes != nil {
+		utils.PublishBackendNodes(ctx, c.backendNodes)
+	}
+	return &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "47.97.241.114"}}}, nil
+}
+
+func (c *fakeCloud) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	return c.err
+}
+
+func (c *fakeCloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+	if c.err == nil && c.deleted != "" {
+		utils.PublishLoadBalancerId(ctx, c.deleted)
+	}
+	return c.err
+}
+
+func TestReconcileMetric(t *testing.T) {
+	svc := testService(nil)
+	client := fake.NewSimpleClientset(svc)
+	ifactory := informers.NewSharedInformerFactory(client, 0)
+	if err := ifactory.Core().V1().Services().Informer().GetIndexer().Add(svc); err != nil {
+		t.Fatalf("add service: %s", err.Error())
+	}
+	cloud := &fakeCloud{}
+	con := &Controller{
+		cloud:    cloud,
+		client:   client,
+		ifactory: ifactory,
+		local:    &Context{},
+		recorder: record.NewFakeRecorder(100),
+	}
+	count := func(result SyncResult) float64 {
+		return testutil.ToFloat64(metric.ReconcileTotal.WithLabelValues(RECONCILE_CONTROLLER, string(result)))
+	}
+	cases := []struct {
+		name   string
+		key    string
+		err    error
+		result SyncResult
+	}{
+		{name: "success", key: key(svc), result: ResultSuccess},
+		{name: "throttled", key: key(svc), err: cloudError("Throttling.User"), result: ResultThrottled},
+		{name: "cloud error", key: key(svc), err: cloudError("Forbidden.RAM"), result: ResultCloudError},
+		{name: "validation error", key: key(svc), err: fmt.Errorf("%s: bad annotation", utils.InvalidAnnotation), result: ResultValidationError},
+		{name: "not found", key: "default/deleted-service", result: ResultNotFound},
+	}
+	for _, c := range cases {
+		cloud.err = c.err
+		before := count(c.result)
+		err := con.ServiceSyncTask(c.key)
+		if ClassifyError(err) != c.result && c.result != ResultNotFound {
+			t.Errorf("%s: expect the error of %s, got %v", c.name, c.result, err)
+		}
+		if after := count(c.result); after != before+1 {
+			t.Errorf("%s: expect ccm_reconcile_total of %s incremented, got %v -> %v", c.name, c.result, before, after)
+		}
+	}
+	// the status of the succeeded sync is persisted
+	updated, err := client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	if err != nil || len(updated.Status.LoadBalancer.Ingress) != 1 {
+		t.Fatalf("expect the status updated, got %v, %v", updated, err)
+	}
+}
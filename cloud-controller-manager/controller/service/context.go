@@ -4,7 +4,6 @@ import (
 	"k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
-	"k8s.io/klog"
 	"reflect"
 	"sort"
 	"strings"
@@ -20,7 +19,7 @@ func (c *Context) Get(name string) *v1.Service {
 	}
 	val, ok := v.(*v1.Service)
 	if !ok {
-		klog.Errorf("not type of v1.svc: [%s]", reflect.TypeOf(v))
+		utils.ErrorS(nil, "Unexpected type of the cached service", utils.LogKeyService, name, "type", reflect.TypeOf(v).String())
 		return nil
 	}
 	return val
@@ -43,17 +42,36 @@ func NeedAdd(newService *v1.Service) bool {
 	}
 
 	// was LoadBalancer
-	_, ok := newService.Labels[utils.LabelServiceHash]
-	if ok {
-		klog.Infof("service %s/%s has hash label, which may was LoadBalancer",
-			newService.Namespace, newService.Name)
+	if isManaged(newService) {
+		utils.ServiceLogger(newService).Info("Service has the hash label, which may was LoadBalancer")
 		return true
 	}
 	return false
 }
 
+// hashLabelBroken whether the hash label of the service was stripped or
+// corrupted, eg. by hand. The LoadBalancer service is fully reconciled and
+// labeled again, and the label is removed from a service of another type.
+// The label written by a sync is well formed, it triggers no sync.
+func hashLabelBroken(old, newm *v1.Service) bool {
+	former, wasHashed := old.Labels[utils.LabelServiceHash]
+	label, hashed := newm.Labels[utils.LabelServiceHash]
+	if wasHashed == hashed && former == label {
+		return false
+	}
+	if !NeedLoadBalancer(newm) {
+		return hashed
+	}
+	return !hashed || !utils.IsServiceHashWellFormed(label)
+}
+
 // NeedUpdate compare old and new service for possible changes
 func NeedUpdate(old, newm *v1.Service, record record.EventRecorder) bool {
+	if hashLabelBroken(old, newm) {
+		utils.ServiceLogger(newm).Info("Service hash label stripped or modified, reconcile it",
+			"from", old.Labels[utils.LabelServiceHash], "to", newm.Labels[utils.LabelServiceHash])
+		return true
+	}
 	if !NeedLoadBalancer(old) &&
 		!NeedLoadBalancer(newm) {
 		// no loadbalancer is needed
@@ -71,32 +89,22 @@ func NeedUpdate(old, newm *v1.Service, record record.EventRecorder) bool {
 		return true
 	}
 
-	if !reflect.DeepEqual(old.Annotations, newm.Annotations) {
-		klog.Infof("AnnotationChanged: %v -> %v", old.Annotations, newm.Annotations)
-		record.Eventf(
-			newm,
-			v1.EventTypeNormal,
-			"AnnotationChanged",
-			"The service will be updated because the annotations has been changed.",
-		)
-		return true
-	}
-	if old.UID != newm.UID {
-		klog.Infof("UIDChanged: %v -> %v", old.UID, newm.UID)
-		return true
-	}
-	if !reflect.DeepEqual(old.Spec, newm.Spec) {
-		klog.Infof("ServiceSpecChanged: %v -> %v", old.Spec, newm.Spec)
-		record.Eventf(
-			newm,
-			v1.EventTypeNormal,
-			"ServiceSpecChanged",
-			"The service will be updated because the spec has been changed.",
-		)
-		return true
+	if reflect.DeepEqual(utils.UserAnnotations(old), utils.UserAnnotations(newm)) &&
+		old.UID == newm.UID &&
+		reflect.DeepEqual(old.Spec, newm.Spec) {
+		return false
 	}
-
-	return false
+	// tell the change of the loadbalancer, see utils.ServiceDiff
+	summary := utils.ServiceDiffSummary(old, newm)
+	utils.ServiceLogger(newm).Info("ServiceSpecChanged", "diff", summary)
+	record.Eventf(
+		newm,
+		v1.EventTypeNormal,
+		"ServiceSpecChanged",
+		"The service will be updated: %s",
+		summary,
+	)
+	return true
 }
 
 //NeedDelete
@@ -106,10 +114,8 @@ func NeedDelete(service *v1.Service) bool {
 	}
 
 	// was LoadBalancer
-	_, ok := service.Labels[utils.LabelServiceHash]
-	if ok {
-		klog.Infof("service %s/%s has hash label, which may was LoadBalancer",
-			service.Namespace, service.Name)
+	if isManaged(service) {
+		utils.ServiceLogger(service).Info("Service has the hash label, which may was LoadBalancer")
 		return true
 	}
 	return false
@@ -120,23 +126,28 @@ func NeedLoadBalancer(service *v1.Service) bool {
 }
 
 func NodeSpecChanged(a, b *v1.Node) bool {
+	if NodeZone(a) != NodeZone(b) {
+		utils.InfoS("Node zone changed", utils.LogKeyNode, a.Name, "from", NodeZone(a), "to", NodeZone(b))
+		return true
+	}
 	if NodeLabelsChanged(a.Labels, b.Labels) {
 		// log node label details for debug convenience
-		klog.Infof("node label changed: %s, from=%v, to=%v", a.Name, a.Labels, b.Labels)
+		utils.InfoS("Node labels changed", utils.LogKeyNode, a.Name, "from", a.Labels, "to", b.Labels)
 		return true
 	}
 	if a.Spec.Unschedulable != b.Spec.Unschedulable {
-		klog.Infof(
-			"spec.Unscheduleable changed: %s, from=%t, to=%t",
-			a.Name, a.Spec.Unschedulable, b.Spec.Unschedulable,
-		)
+		utils.InfoS("Node unschedulable changed", utils.LogKeyNode, a.Name,
+			"from", a.Spec.Unschedulable, "to", b.Spec.Unschedulable)
+		return true
+	}
+	if utils.IsDrainingNode(a) != utils.IsDrainingNode(b) {
+		utils.InfoS("Node draining changed", utils.LogKeyNode, a.Name,
+			"from", utils.IsDrainingNode(a), "to", utils.IsDrainingNode(b))
 		return true
 	}
 	if NodeConditionChanged(a.Name, a.Status.Conditions, b.Status.Conditions) {
-		klog.Infof(
-			"node condition changed: %s, from=%d, to=%d",
-			a.Name, len(a.Status.Conditions), len(b.Status.Conditions),
-		)
+		utils.InfoS("Node conditions changed", utils.LogKeyNode, a.Name,
+			"from", len(a.Status.Conditions), "to", len(b.Status.Conditions))
 		return true
 	}
 	return false
@@ -144,7 +155,7 @@ func NodeSpecChanged(a, b *v1.Node) bool {
 
 func NodeConditionChanged(name string, a, b []v1.NodeCondition) bool {
 	if len(a) != len(b) {
-		klog.Infof("Node Change: node condition changed, before %v, after %v", a, b)
+		utils.V(utils.LOG_SYNC_VERBOSITY).Info("Node condition count changed", utils.LogKeyNode, name, "from", a, "to", b)
 		return true
 	}
 
@@ -159,10 +170,8 @@ func NodeConditionChanged(name string, a, b []v1.NodeCondition) bool {
 	for i := range a {
 		if a[i].Type != b[i].Type ||
 			a[i].Status != b[i].Status {
-			klog.Infof(
-				"node condition changed: %s, type(%s,%s) | status(%s,%s)",
-				name, a[i].Type, b[i].Type, a[i].Status, b[i].Status,
-			)
+			utils.V(utils.LOG_SYNC_VERBOSITY).Info("Node condition changed", utils.LogKeyNode, name,
+				"type", a[i].Type, "newType", b[i].Type, "from", a[i].Status, "to", b[i].Status)
 			return true
 		}
 	}
@@ -171,12 +180,12 @@ func NodeConditionChanged(name string, a, b []v1.NodeCondition) bool {
 
 func NodeLabelsChanged(a, b map[string]string) bool {
 	if len(a) != len(b) {
-		klog.Infof("Node Change: the number of labels on the node are changed, before %v, after %v", a, b)
+		utils.V(utils.LOG_SYNC_VERBOSITY).Info("Number of node labels changed", "from", a, "to", b)
 		return true
 	}
 	for k, v := range a {
 		if b[k] != v {
-			klog.Infof("Node Change: the %v label on the node are changed, before %v, after %v", k, a[k], b[k])
+			utils.V(utils.LOG_SYNC_VERBOSITY).Info("Node label changed", "label", k, "from", a[k], "to", b[k])
 			return true
 		}
 	}
@@ -27,8 +27,8 @@ import (
 	controller "k8s.io/kube-aggregator/pkg/controllers"
 	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
 	"reflect"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -64,6 +64,22 @@ type Controller struct {
 	//      item to be reenqueued while it is being processed.
 	//  * Shutdown notifications.
 	queues map[string]queue.DelayingInterface
+
+	// policy decides when the failed syncs are retried
+	policy RequeuePolicy
+
+	// spread delays the syncs of the services listed at the start
+	spread startupSpread
+
+	// defaults watches the configmap of the default annotations, nil if not
+	// configured
+	defaults cache.SharedIndexInformer
+
+	// failing keys of the services whose last sync failed, see updateGauges
+	failing sync.Map
+
+	// backends the services of each backend node, see EnqueueServicesOfNode
+	backends backendIndex
 }
 
 func NewController(
@@ -92,6 +108,9 @@ func NewController(
 		queues: map[string]queue.DelayingInterface{
 			SERVICE_QUEUE: workqueue.NewNamedDelayingQueue(SERVICE_QUEUE),
 		},
+		policy:   NewRequeuePolicy(),
+		spread:   newStartupSpread(StartupSpread),
+		defaults: newDefaultsInformer(client, DefaultAnnotationsConfigMap),
 	}
 	con.HandlerForEndpointChange(
 		con.local,
@@ -109,6 +128,13 @@ func NewController(
 		con.ifactory.Core().V1().Services().Informer(),
 		recorder,
 	)
+	con.HandlerForSecretChange(
+		con.queues[SERVICE_QUEUE],
+		con.ifactory.Core().V1().Secrets().Informer(),
+	)
+	if con.defaults != nil {
+		con.HandlerForDefaultsChange(con.queues[SERVICE_QUEUE], con.defaults)
+	}
 	return con, nil
 }
 
@@ -123,16 +149,23 @@ func (con *Controller) Run(stopCh <-chan struct{}, workers int) {
 	klog.Info("starting service controller")
 	defer klog.Info("shutting down service controller")
 
-	if !controller.WaitForCacheSync(
-		"service",
-		stopCh,
+	synced := []cache.InformerSynced{
 		con.ifactory.Core().V1().Services().Informer().HasSynced,
 		con.ifactory.Core().V1().Nodes().Informer().HasSynced,
-	) {
-		klog.Error("service and nodes cache has not been syncd")
+		con.ifactory.Core().V1().Secrets().Informer().HasSynced,
+	}
+	if con.defaults != nil {
+		// the services are not synced before the defaults are known
+		go con.defaults.Run(stopCh)
+		synced = append(synced, con.defaults.HasSynced)
+	}
+	if !controller.WaitForCacheSync("service", stopCh, synced...) {
+		klog.Error("service, nodes and secrets cache has not been syncd")
 		return
 	}
 
+	go wait.Until(con.sweepLastSyncs, LAST_SYNC_SWEEP_PERIOD, stopCh)
+
 	tasks := map[string]SyncTask{
 		SERVICE_QUEUE: con.ServiceSyncTask,
 	}
@@ -145,6 +178,7 @@ func (con *Controller) Run(stopCh <-chan struct{}, workers int) {
 					con.local,
 					con.queues[que],
 					task,
+					con.policy,
 				),
 				2*time.Second,
 				stopCh,
@@ -174,7 +208,7 @@ func key(svc *v1.Service) string {
 }
 
 func Enqueue(queue queue.DelayingInterface, k interface{}) {
-	klog.Infof("controller: enqueue object %s for service, queue len %d", k.(string), queue.Len())
+	utils.V(utils.LOG_SYNC_VERBOSITY).Info("Enqueue service", utils.LogKeyService, k, "queueLen", queue.Len())
 	queue.Add(k.(string))
 }
 
@@ -187,26 +221,27 @@ func (con *Controller) HandlerForNodesChange(
 	syncNodes := func(object interface{}) {
 		node, ok := object.(*v1.Node)
 		if !ok || node == nil {
-			klog.Info("node change: node object is nil, skip")
+			utils.V(utils.LOG_SYNC_VERBOSITY).Info("Skip the node change of a nil node")
 			return
 		}
 		if utils.IsExcludedNode(node) {
-			klog.Infof("node change: node %s is excluded from CCM, skip", node.Name)
+			utils.V(utils.LOG_SYNC_VERBOSITY).Info("Skip the node change of an excluded node", utils.LogKeyNode, node.Name)
 			return
 		}
 		// node change may affect any service that concerns
 		// eg. Need LoadBalancer
 		ctx.Range(
 			func(k string, svc *v1.Service) bool {
+				log := utils.ServiceLogger(svc).V(utils.LOG_SYNC_VERBOSITY)
 				if !NeedLoadBalancer(svc) {
-					utils.Logf(svc, "node change: loadbalancer is not needed, skip")
+					log.Info("Skip the node change, loadbalancer not needed", utils.LogKeyNode, node.Name)
 					return true
 				}
 				if !isProcessNeeded(svc) {
-					utils.Logf(svc, "node change: class not empty, skip process ")
+					log.Info("Skip the node change, class not empty", utils.LogKeyNode, node.Name)
 					return true
 				}
-				utils.Logf(svc, "node change: enqueue service")
+				log.Info("Enqueue service for the node change", utils.LogKeyNode, node.Name)
 				Enqueue(que, key(svc))
 				return true
 			},
@@ -223,7 +258,7 @@ func (con *Controller) HandlerForNodesChange(
 					NodeSpecChanged(node1, node2) {
 					// label and schedulable changed .
 					// status healthy should be considered
-					klog.Infof("controller: node[%s/%s] update event", node1.Namespace, node1.Name)
+					utils.V(utils.LOG_SYNC_VERBOSITY).Info("Node updated", utils.LogKeyNode, node1.Name)
 					syncNodes(node1)
 				}
 			},
@@ -241,27 +276,27 @@ func (con *Controller) HandlerForEndpointChange(
 	syncEndpoints := func(epd interface{}) {
 		ep, ok := epd.(*v1.Endpoints)
 		if !ok || ep == nil {
-			klog.Info("endpoint change: endpoint object is nil, skip")
+			utils.V(utils.LOG_SYNC_VERBOSITY).Info("Skip the endpoints change of nil endpoints")
 			return
 		}
-		svc := ctx.Get(fmt.Sprintf("%s/%s", ep.Namespace, ep.Name))
+		epKey := fmt.Sprintf("%s/%s", ep.Namespace, ep.Name)
+		svc := ctx.Get(epKey)
 		if svc == nil {
-			klog.Infof("endpoint change: can not get cached service for "+
-				"endpoints[%s/%s], enqueue for default endpoint.\n", ep.Namespace, ep.Name)
 			var err error
-			svc, err = con.client.CoreV1().Services(ep.Namespace).Get(context.Background(), ep.Name, v12.GetOptions{})
+			svc, err = con.serviceOfEndpoints(ctx, ep)
 			if err != nil {
-				klog.Warningf("can not get service %s/%s. ", ep.Namespace, ep.Name)
+				utils.NewLogger(utils.LogKeyService, epKey).Warning("Skip the endpoints change, can not get service", "err", err)
 				return
 			}
 		}
+		log := utils.ServiceLogger(svc).V(utils.LOG_SYNC_VERBOSITY)
 		if !isProcessNeeded(svc) {
-			utils.Logf(svc, "endpoint: class not empty, skip process ")
+			log.Info("Skip the endpoints change, class not empty")
 			return
 		}
 		if !NeedLoadBalancer(svc) {
 			// we are safe here to skip process syncEnpoint.
-			utils.Logf(svc, "endpoint change: loadBalancer is not needed, skip")
+			log.Info("Skip the endpoints change, loadbalancer not needed")
 			return
 		}
 
@@ -275,7 +310,7 @@ func (con *Controller) HandlerForEndpointChange(
 				epMsg = append(epMsg, fmt.Sprintf("ip: %s, nodeName: %s", add.IP, nodeName))
 			}
 		}
-		utils.Logf(svc, "enqueue endpoint: %v", epMsg)
+		log.Info("Enqueue service for the endpoints change", "endpoints", epMsg)
 
 		Enqueue(que, key(svc))
 	}
@@ -286,7 +321,7 @@ func (con *Controller) HandlerForEndpointChange(
 				ep1, ok1 := obja.(*v1.Endpoints)
 				ep2, ok2 := objb.(*v1.Endpoints)
 				if ok1 && ok2 && !reflect.DeepEqual(ep1.Subsets, ep2.Subsets) {
-					klog.Infof("controller: endpoints update event, endpoints [%s/%s]", ep1.Namespace, ep1.Name)
+					utils.V(utils.LOG_SYNC_VERBOSITY).Info("Endpoints updated", utils.LogKeyService, ep1.Namespace+"/"+ep1.Name)
 					syncEndpoints(ep2)
 				}
 			},
@@ -296,18 +331,107 @@ func (con *Controller) HandlerForEndpointChange(
 	)
 }
 
+// serviceOfEndpoints the service of the endpoints missed by the local cache,
+// eg. after a restart, from the informer, or from the apiserver only when the
+// informer lacks it. The service is cached if it is synced as it is, the
+// cache holds the service of the last succeeded sync.
+func (con *Controller) serviceOfEndpoints(ctx *Context, ep *v1.Endpoints) (*v1.Service, error) {
+	epKey := fmt.Sprintf("%s/%s", ep.Namespace, ep.Name)
+	svc, err := con.ifactory.Core().V1().Services().Lister().Services(ep.Namespace).Get(ep.Name)
+	if errors.IsNotFound(err) {
+		utils.V(utils.LOG_SYNC_VERBOSITY).Info("Service of the endpoints not in the informer, get it from apiserver", utils.LogKeyService, epKey)
+		svc, err = con.client.CoreV1().Services(ep.Namespace).Get(context.Background(), ep.Name, v12.GetOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if hash, err := utils.GetServiceHash(svc); err == nil && svc.Labels[utils.LabelServiceHash] == hash {
+		ctx.Set(epKey, svc)
+	}
+	return svc, nil
+}
+
+// HandlerForSecretChange resync the services whose certificate is uploaded
+// from the changed secret, see utils.ServiceAnnotationLoadBalancerCertSecret.
+func (con *Controller) HandlerForSecretChange(
+	que queue.DelayingInterface,
+	informer cache.SharedIndexInformer,
+) {
+	syncSecret := func(secret *v1.Secret) {
+		// services which failed to sync for the secret are not in the local cache yet
+		svcs, err := con.ifactory.Core().V1().Services().Lister().
+			Services(secret.Namespace).List(labels.Everything())
+		if err != nil {
+			utils.ErrorS(err, "Failed to list the services of the secret", "namespace", secret.Namespace, "secret", secret.Name)
+			return
+		}
+		for _, svc := range svcs {
+			if !referSecret(svc, secret.Name) ||
+				!NeedLoadBalancer(svc) || !isProcessNeeded(svc) {
+				continue
+			}
+			utils.ServiceLogger(svc).Info("Enqueue service for the secret change", "secret", secret.Name)
+			Enqueue(que, key(svc))
+		}
+	}
+	informer.AddEventHandlerWithResyncPeriod(
+		cache.ResourceEventHandlerFuncs{
+			// a secret created after the service which refers to it
+			AddFunc: func(obj interface{}) {
+				if secret, ok := obj.(*v1.Secret); ok {
+					syncSecret(secret)
+				}
+			},
+			UpdateFunc: func(obja, objb interface{}) {
+				sec1, ok1 := obja.(*v1.Secret)
+				sec2, ok2 := objb.(*v1.Secret)
+				if ok1 && ok2 && !reflect.DeepEqual(sec1.Data, sec2.Data) {
+					utils.V(utils.LOG_SYNC_VERBOSITY).Info("Secret updated", "namespace", sec2.Namespace, "secret", sec2.Name)
+					syncSecret(sec2)
+				}
+			},
+		},
+		SERVICE_SYNC_PERIOD,
+	)
+}
+
+// referSecret whether the cert-secret annotation of svc refers to the secret.
+func referSecret(svc *v1.Service, name string) bool {
+	ref := svc.Annotations[utils.ServiceAnnotationLoadBalancerCertSecret]
+	if ref == "" {
+		return false
+	}
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		ref = ref[i+1:]
+	}
+	return ref == name
+}
+
 func (con *Controller) HandlerForServiceChange(
 	context *Context,
 	que queue.DelayingInterface,
 	informer cache.SharedIndexInformer,
 	record record.EventRecorder,
 ) {
-	syncService := func(svc *v1.Service) {
+	// after the delay of the service listed at the start, 0 for at once
+	syncService := func(svc *v1.Service, after time.Duration) {
+		enqueue := func() {
+			if after > 0 {
+				EnqueueAfter(que, key(svc), after)
+				return
+			}
+			Enqueue(que, key(svc))
+		}
+		if NeedRelease(svc) {
+			utils.ServiceLogger(svc).Info("Enqueue service to release, class of another controller", "class", serviceClass(svc))
+			enqueue()
+			return
+		}
 		if !isProcessNeeded(svc) {
-			utils.Logf(svc, "class not empty, skip process")
+			utils.ServiceLogger(svc).V(utils.LOG_SYNC_VERBOSITY).Info("Skip the service change, class of another controller")
 			return
 		}
-		Enqueue(que, key(svc))
+		enqueue()
 	}
 
 	informer.AddEventHandlerWithResyncPeriod(
@@ -315,8 +439,13 @@ func (con *Controller) HandlerForServiceChange(
 			AddFunc: func(add interface{}) {
 				svc, ok := add.(*v1.Service)
 				if ok && NeedAdd(svc) {
-					utils.Logf(svc, "controller: service addition event")
-					syncService(svc)
+					after, spread := con.spread.delay(svc)
+					if spread {
+						utils.ServiceLogger(svc).Info("Service added at start, spread the sync", "after", after)
+					} else {
+						utils.ServiceLogger(svc).Info("Service added")
+					}
+					syncService(svc, after)
 				}
 			},
 			UpdateFunc: func(old, cur interface{}) {
@@ -324,17 +453,21 @@ func (con *Controller) HandlerForServiceChange(
 				curr, ok2 := cur.(*v1.Service)
 				if ok1 && ok2 &&
 					NeedUpdate(oldd, curr, record) {
-					utils.Logf(curr, "controller: service update event")
-					syncService(curr)
+					utils.ServiceLogger(curr).Info("Service updated")
+					if reconcileTimestampChanged(oldd, curr) {
+						con.reconcileNow(curr)
+					}
+					syncService(curr, 0)
 				}
 			},
 			DeleteFunc: func(cur interface{}) {
 				svc, ok := cur.(*v1.Service)
 				if ok && NeedDelete(svc) {
-					utils.Logf(svc, "controller: service deletion received, %s", utils.PrettyJson(svc))
+					utils.ServiceLogger(svc).Info("Service deleted")
+					utils.ServiceLogger(svc).V(utils.LOG_SYNC_VERBOSITY).Info("Deleted service", "object", utils.Diagnostic(svc))
 					// recorder service in local context
 					context.Set(key(svc), svc)
-					syncService(svc)
+					syncService(svc, 0)
 				}
 			},
 		},
@@ -346,12 +479,10 @@ func WorkerFunc(
 	contex *Context,
 	queue queue.DelayingInterface,
 	syncd SyncTask,
+	policy RequeuePolicy,
 ) func() {
 
 	return func() {
-		// requeue exponential in case of throttle error.
-		// for each worker function
-		back := NewBackoff(5*time.Second, 1.5)
 		for {
 			func() {
 				// Workerqueue ensures that a single key would not be process
@@ -362,18 +493,28 @@ func WorkerFunc(
 				}
 				defer queue.Done(key)
 
-				klog.Infof("[%s] worker: queued sync for service", key)
+				log := utils.NewLogger(utils.LogKeyService, key)
+				log.V(utils.LOG_SYNC_VERBOSITY).Info("Sync queued service")
 
-				if err := syncd(key.(string)); err != nil {
-					if strings.Contains(err.Error(), "Throttling") {
-						next := back.Next()
-						queue.AddAfter(key, next)
-						klog.Warningf("request was throttled: %s, retry in next %d ns", key, next)
-					} else {
-						queue.AddAfter(key, 5*time.Second)
-					}
-					klog.Errorf("requeue: sync error for service %s %v", key, err)
+				err := syncd(key.(string))
+				if err == nil {
+					policy.Forget(key)
+					Backoffs.forget(key.(string))
+					return
 				}
+				result := ClassifyError(err)
+				after, requeue := policy.When(key, result)
+				if !requeue {
+					// retry won't help until the service changes, which triggers a new sync.
+					Backoffs.forget(key.(string))
+					log.Error(err, "Failed to sync service, NotRetry", "result", result)
+					return
+				}
+				reason := requeueReason(result)
+				metric.ServiceRequeues.WithLabelValues(reason).Inc()
+				Backoffs.requeued(key.(string), reason, after)
+				queue.AddAfter(key, after)
+				log.Error(err, "Failed to sync service, requeued", "result", result, "after", after)
 			}()
 		}
 	}
@@ -435,8 +576,18 @@ type SyncTask func(key string) error
 // ------------------------------------------------- Where sync begins ---------------------------------------------------------
 
 // SyncService Entrance for syncing service
-func (con *Controller) ServiceSyncTask(k string) error {
+func (con *Controller) ServiceSyncTask(k string) (err error) {
 	startTime := time.Now()
+	// the service deleted with nothing to clean up is not found
+	notFound := false
+	defer func() {
+		result := ClassifyError(err)
+		if notFound && err == nil {
+			result = ResultNotFound
+		}
+		metric.ReconcileTotal.WithLabelValues(RECONCILE_CONTROLLER, string(result)).Inc()
+		con.updateGauges(k, err != nil)
+	}()
 
 	ns, name, err := cache.SplitMetaNamespaceKey(k)
 	if err != nil {
@@ -446,9 +597,10 @@ func (con *Controller) ServiceSyncTask(k string) error {
 	// local cache might be nil on first process which is expected
 	cached := con.local.Get(k)
 
+	log := utils.NewLogger(utils.LogKeyService, k)
 	defer func() {
 		metric.SLBLatency.WithLabelValues("reconcile").Observe(metric.MsSince(startTime))
-		klog.Infof("[%s] finished syncing service (%v)", k, time.Since(startTime))
+		log.Info("Finished syncing service", "duration", time.Since(startTime))
 	}()
 
 	// service holds the latest service info from apiserver
@@ -464,27 +616,26 @@ func (con *Controller) ServiceSyncTask(k string) error {
 	case errors.IsNotFound(err):
 
 		if cached == nil {
-			klog.Errorf("unexpected nil cached service for deletion, wait retry %s", k)
+			log.Error(nil, "Unexpected nil cached service for deletion, wait retry")
+			notFound = true
 			return nil
 		}
 		// service absence in store means watcher caught the deletion, ensure LB
 		// info is cleaned delete error would cause ReEnqueue svc, which mean retry.
-		utils.Logf(cached, "service has been deleted %v", key(cached))
+		log.Info("Service has been deleted")
 		return retry(nil, con.delete, cached)
 	case err != nil:
 		return fmt.Errorf("failed to load service from local context: %s", err.Error())
 	default:
 		// catch unexpected service
 		if service == nil {
-			klog.Errorf("unexpected nil service for update, wait retry. %s", k)
+			log.Error(nil, "Unexpected nil service for update, wait retry")
 			return fmt.Errorf("retry unexpected nil service %s. ", k)
 		}
 		return con.update(cached, service)
 	}
 }
 
-func isProcessNeeded(svc *v1.Service) bool { return svc.Annotations[CCM_CLASS] == "" }
-
 func retry(
 	backoff *wait.Backoff,
 	fun func(svc *v1.Service) error,
@@ -498,32 +649,44 @@ func retry(
 			Jitter:   4,
 		}
 	}
-	return wait.ExponentialBackoff(
+	var last error
+	err := wait.ExponentialBackoff(
 		*backoff,
 		func() (bool, error) {
-			err := fun(svc)
-			if err != nil &&
-				strings.Contains(err.Error(), TRY_AGAIN) {
-				klog.Errorf("retry with error: %s", err.Error())
-				return false, nil
+			last = fun(svc)
+			if last == nil {
+				return true, nil
 			}
-			if err != nil {
-				klog.Errorf("retry error: NotRetry, %s", err.Error())
+			// the validation errors are not retried, the cloud errors back off
+			if result := ClassifyError(last); !result.Retryable() {
+				utils.ServiceLogger(svc).Error(last, "Operation failed, NotRetry", "result", result)
+				return false, last
 			}
-			return true, nil
+			utils.ServiceLogger(svc).Error(last, "Retry the failed operation")
+			return false, nil
 		},
 	)
+	if err == wait.ErrWaitTimeout {
+		// the attempts are exhausted, the error of the last one is returned
+		return last
+	}
+	return err
 }
 
 func (con *Controller) update(cached, svc *v1.Service) error {
 
 	// Save the state so we can avoid a write if it doesn't change
 	pre := svc.Status.LoadBalancer.DeepCopy()
+	log := utils.ServiceLogger(svc)
 	if cached != nil && cached.UID != svc.UID {
-		klog.Warningf("UIDChanged,uid: %s -> %s, try delete old service first", cached.UID, svc.UID)
+		log.Warning("UID changed, delete the loadbalancer of the former service first", "from", cached.UID, "to", svc.UID)
 		return retry(nil, con.delete, svc)
 	}
-	ctx := context.Background()
+	if !isProcessNeeded(svc) {
+		return con.release(svc)
+	}
+	// the clients deep in the sync log the service by the logger of the context
+	ctx := utils.WithLogger(context.Background(), log)
 	var newm *v1.LoadBalancerStatus
 	if !NeedLoadBalancer(svc) {
 		_, exits, err := con.cloud.GetLoadBalancer(ctx, "", svc)
@@ -532,7 +695,7 @@ func (con *Controller) update(cached, svc *v1.Service) error {
 		}
 		if exits {
 			// delete loadbalancer which is no longer needed
-			utils.Logf(svc, "try delete loadbalancer which no longer needed for service.")
+			log.Info("Delete the loadbalancer no longer needed")
 			if err := retry(nil, con.delete, svc); err != nil {
 				return err
 			}
@@ -549,9 +712,9 @@ func (con *Controller) update(cached, svc *v1.Service) error {
 		// continue for updating service status.
 		newm = &v1.LoadBalancerStatus{}
 	} else {
-		utils.Logf(svc, "start to ensure loadbalancer")
+		log.Info("Ensuring loadbalancer")
 		start := time.Now()
-		nodes, err := AvailableNodes(svc, con.ifactory)
+		nodes, err := AvailableNodes(svc, con.ifactory, con.recorder)
 		if err != nil {
 			return fmt.Errorf("error get available nodes %s", err.Error())
 		}
@@ -565,35 +728,93 @@ func (con *Controller) update(cached, svc *v1.Service) error {
 				"There are no available nodes for LoadBalancer",
 			)
 		}
+		if recheck := NotReadyToleranceRecheck(svc, nodes); recheck > 0 {
+			// remove the NotReady nodes kept when their tolerance is over
+			log.Info("NotReady nodes are tolerated, sync again later", "after", recheck)
+			con.queues[SERVICE_QUEUE].AddAfter(key(svc), recheck)
+		}
 		ctx = context.WithValue(ctx, utils.ContextService, svc)
 		ctx = context.WithValue(ctx, utils.ContextRecorder, con.recorder)
+		// the cloud api calls are taken from the budget of the service
+		ctx = context.WithValue(ctx, utils.ContextAPIBudget, key(svc))
+		ctx = context.WithValue(
+			ctx, utils.ContextRequeue,
+			// sync the service again later, eg. when the draining backends are to be removed
+			func(delay time.Duration) { con.queues[SERVICE_QUEUE].AddAfter(key(svc), delay) },
+		)
+		if utils.ReconcileRequested(svc) {
+			log.Info("Reconcile requested, ignore the service hash",
+				"timestamp", svc.Annotations[utils.ServiceAnnotationReconcileTimestamp])
+			ctx = context.WithValue(ctx, utils.ContextForceResync, true)
+		}
+		if cached != nil {
+			// the transitions of the spec, eg. of the external traffic policy
+			ctx = context.WithValue(ctx, utils.ContextFormerService, cached)
+		}
+		lbid := ""
+		ctx = context.WithValue(ctx, utils.ContextLoadBalancerId, func(id string) { lbid = id })
+		backends := -1
+		ctx = context.WithValue(ctx, utils.ContextBackends, func(count int) { backends = count })
+		var zones map[string]int
+		ctx = context.WithValue(ctx, utils.ContextBackendZones, func(z map[string]int) { zones = z })
+		var backendNodes []string
+		ctx = context.WithValue(ctx, utils.ContextBackendNodes, func(n []string) { backendNodes = n })
 		newm, err = con.cloud.EnsureLoadBalancer(ctx, con.clusterName, svc, nodes)
 
 		metric.SLBLatency.WithLabelValues("create").Observe(metric.MsSince(start))
 		if err == nil {
+			log.Info("Ensured loadbalancer", utils.LogKeySLBID, lbid, "duration", time.Since(start))
 			con.recorder.Eventf(
 				svc,
 				v1.EventTypeNormal,
 				"EnsuredLoadBalancer",
 				"Ensured load balancer",
 			)
-			if err := con.addServiceHash(svc); err != nil {
+			if err := con.addServiceHash(svc, lbid); err != nil {
 				return err
 			}
+			if backends >= 0 {
+				metric.SLBBackends.WithLabelValues(key(svc)).Set(float64(backends))
+			}
+			con.checkBackendZones(svc, zones)
+			if backendNodes != nil {
+				con.backends.set(key(svc), backendNodes)
+			}
 		} else {
 			message := getLogMessage(err)
 			con.recorder.Eventf(
 				svc,
 				v1.EventTypeWarning,
 				"SyncLoadBalancerFailed",
-				"Error syncing load balancer: %s",
-				message,
+				"Error syncing load balancer (%s): %s",
+				ClassifyError(err), message,
 			)
-			return fmt.Errorf("ensure loadbalancer error: %s", err)
+			if ClassifyError(err) == ResultQuotaExceeded {
+				con.recorder.Eventf(
+					svc,
+					v1.EventTypeWarning,
+					"QuotaExceeded",
+					"The quota of the account is exceeded, retry in %s. "+
+						"Raise the quota or release the loadbalancers unused: %s",
+					REQUEUE_QUOTA_DELAY, message,
+				)
+			}
+			if ClassifyError(err) == ResultBudgetExceeded {
+				con.recorder.Eventf(
+					svc,
+					v1.EventTypeWarning,
+					utils.APIBudgetExceeded,
+					"The service used up its budget of cloud api calls, retry in %s: %s",
+					REQUEUE_BUDGET_DELAY, message,
+				)
+			}
+			// retry won't help for a validation error until the annotation is
+			// fixed, which triggers a new sync. The worker decides by its class.
+			return fmt.Errorf("ensure loadbalancer error: %w", err)
 		}
 	}
 	if err := con.updateStatus(svc, pre, newm); err != nil {
-		return fmt.Errorf("update service status: %s", err.Error())
+		return fmt.Errorf("update service status: %w", err)
 	}
 	// Always update the cache upon success.
 	// NOTE: Since we update the cached service if and only if we successfully
@@ -603,7 +824,10 @@ func (con *Controller) update(cached, svc *v1.Service) error {
 	return nil
 }
 
-func (con *Controller) addServiceHash(svc *v1.Service) error {
+// addServiceHash label the service with its hash, and annotate it with the id
+// of the loadbalancer and the reconcile timestamp synced in the same patch.
+// The id is kept when lbid is empty.
+func (con *Controller) addServiceHash(svc *v1.Service, lbid string) error {
 	updated := svc.DeepCopy()
 	if updated.Labels == nil {
 		updated.Labels = make(map[string]string)
@@ -613,18 +837,32 @@ func (con *Controller) addServiceHash(svc *v1.Service) error {
 		return fmt.Errorf("compute service hash: %s", err.Error())
 	}
 	updated.Labels[utils.LabelServiceHash] = serviceHash
+	if lbid != "" {
+		if updated.Annotations == nil {
+			updated.Annotations = make(map[string]string)
+		}
+		updated.Annotations[utils.ServiceAnnotationLoadBalancerIdResult] = lbid
+	}
+	if requested := svc.Annotations[utils.ServiceAnnotationReconcileTimestamp]; requested != "" {
+		// the following syncs of the same timestamp are not forced
+		updated.Annotations[utils.ServiceAnnotationReconcileTimestampResult] = requested
+	}
 	if _, err := servicehelper.PatchService(con.client.CoreV1(), svc, updated); err != nil {
-		return fmt.Errorf("update service hash: %s", err.Error())
+		return fmt.Errorf("update service hash: %w", err)
 	}
 	return nil
 }
 
+// removeServiceHash remove the hash label and the loadbalancer id annotation
 func (con *Controller) removeServiceHash(svc *v1.Service) error {
 	updated := svc.DeepCopy()
-	if _, ok := updated.Labels[utils.LabelServiceHash]; ok {
+	_, hashed := updated.Labels[utils.LabelServiceHash]
+	_, published := updated.Annotations[utils.ServiceAnnotationLoadBalancerIdResult]
+	if hashed || published {
 		delete(updated.Labels, utils.LabelServiceHash)
+		delete(updated.Annotations, utils.ServiceAnnotationLoadBalancerIdResult)
 		if _, err := servicehelper.PatchService(con.client.CoreV1(), svc, updated); err != nil {
-			return fmt.Errorf("remove service hash, error: %s", err.Error())
+			return fmt.Errorf("remove service hash, error: %w", err)
 		}
 	}
 	return nil
@@ -637,7 +875,8 @@ func (con *Controller) updateStatus(svc *v1.Service, pre, newm *v1.LoadBalancerS
 	// Write the state if changed
 	// TODO: Be careful here ... what if there were other changes to the service?
 	if !v1helper.LoadBalancerStatusEqual(pre, newm) {
-		utils.Logf(svc, "status: [%v] [%v]", pre, newm)
+		log := utils.ServiceLogger(svc)
+		log.Info("Update loadbalancer status", "from", pre, "to", newm)
 		return retry(
 			&wait.Backoff{
 				Duration: 1 * time.Second,
@@ -649,7 +888,7 @@ func (con *Controller) updateStatus(svc *v1.Service, pre, newm *v1.LoadBalancerS
 				// get latest svc from the shared informer cache
 				updated, err := con.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{ResourceVersion: "0"})
 				if err != nil {
-					return fmt.Errorf("error to get svc %s", key(svc))
+					return fmt.Errorf("error to get svc %s: %w", key(svc), err)
 				}
 				updated.Status.LoadBalancer = *newm
 				_, err = con.
@@ -664,32 +903,36 @@ func (con *Controller) updateStatus(svc *v1.Service, pre, newm *v1.LoadBalancerS
 				// out so that we can process the delete, which we should soon be receiving
 				// if we haven't already.
 				if errors.IsNotFound(err) {
-					utils.Logf(svc, "not persisting update to service that no "+
-						"longer exists: %v", err)
+					log.Info("Skip the status of the service no longer existing", "err", err)
 					return nil
 				}
 				// TODO: Try to resolve the conflict if the change was unrelated to load
 				// balancer status. For now, just pass it up the stack.
 				if errors.IsConflict(err) {
 					return fmt.Errorf("not persisting update to service %s that "+
-						"has been changed since we received it: %v", key(svc), err)
+						"has been changed since we received it: %w", key(svc), err)
 				}
-				klog.Warningf("failed to persist updated LoadBalancerStatus to "+
-					"service %s after creating its load balancer: %v", key(svc), err)
-				return fmt.Errorf("retry with %s, %s", err.Error(), TRY_AGAIN)
+				log.Warning("Failed to persist the loadbalancer status, retry", "err", err)
+				return fmt.Errorf("retry with %w, %s", err, TRY_AGAIN)
 			},
 			svc,
 		)
 	}
-	utils.Logf(svc, "not persisting unchanged LoadBalancerStatus for service to registry.")
+	utils.ServiceLogger(svc).V(utils.LOG_SYNC_VERBOSITY).Info("Skip the unchanged loadbalancer status")
 	return nil
 }
 
 func (con *Controller) delete(svc *v1.Service) error {
-	ctx := context.Background()
+	log := utils.ServiceLogger(svc)
+	ctx := utils.WithLogger(context.Background(), log)
 	ctx = context.WithValue(ctx, utils.ContextService, svc)
+	ctx = context.WithValue(ctx, utils.ContextRecorder, con.recorder)
+	// the id is published only if the loadbalancer is found, otherwise it is
+	// gone already, eg. deleted out of band or by a former partial delete
+	lbid := ""
+	ctx = context.WithValue(ctx, utils.ContextLoadBalancerId, func(id string) { lbid = id })
 	// do not check for the neediness of loadbalancer, delete anyway.
-	klog.Infof("DeletingLoadBalancer for service %s", key(svc))
+	log.Info("Deleting loadbalancer")
 
 	start := time.Now()
 	err := con.cloud.EnsureLoadBalancerDeleted(ctx, con.clusterName, svc)
@@ -699,26 +942,63 @@ func (con *Controller) delete(svc *v1.Service) error {
 			svc,
 			v1.EventTypeWarning,
 			"DeleteLoadBalancerFailed",
-			"Error deleting load balancer: %s",
-			message,
+			"Error deleting load balancer (%s): %s",
+			ClassifyError(err), message,
 		)
-		return fmt.Errorf(TRY_AGAIN)
+		return fmt.Errorf("delete loadbalancer error: %w, %s", err, TRY_AGAIN)
 	}
 	metric.SLBLatency.WithLabelValues("delete").Observe(metric.MsSince(start))
+	absent := ""
+	if lbid == "" {
+		absent = " (already absent)"
+	}
+	log.Info("Deleted loadbalancer", utils.LogKeySLBID, lbid, "duration", time.Since(start))
 	con.recorder.Eventf(
 		svc,
 		v1.EventTypeNormal,
 		"DeletedLoadBalancer",
-		"LoadBalancer Deleted SUCCESS. %s",
-		key(svc),
+		"LoadBalancer Deleted SUCCESS%s. %s",
+		absent, key(svc),
 	)
 	con.local.Remove(key(svc))
 	return nil
 }
 
+// release stop managing the service given the class of another controller,
+// its loadbalancer is kept or deleted by the release policy
+func (con *Controller) release(svc *v1.Service) error {
+	if !isManaged(svc) {
+		con.local.Remove(key(svc))
+		return nil
+	}
+	class := serviceClass(svc)
+	utils.ServiceLogger(svc).Info("Release the service of another class", "class", class, "policy", Options.ReleasePolicy)
+	action := "kept"
+	if Options.ReleasePolicy == ReleasePolicyDelete {
+		if err := retry(nil, con.delete, svc); err != nil {
+			return err
+		}
+		action = "deleted"
+	} else {
+		con.local.Remove(key(svc))
+	}
+	if err := con.removeServiceHash(svc); err != nil {
+		return err
+	}
+	con.recorder.Eventf(
+		svc,
+		v1.EventTypeNormal,
+		"ReleasedLoadBalancer",
+		"Service of class %s is released to its controller, the loadbalancer is %s",
+		class, action,
+	)
+	return nil
+}
+
 func AvailableNodes(
 	svc *v1.Service,
 	ifactory informers.SharedInformerFactory,
+	recorder record.EventRecorder,
 ) ([]*v1.Node, error) {
 	predicate, err := NodeConditionPredicate(svc)
 	if err != nil {
@@ -738,18 +1018,29 @@ func AvailableNodes(
 		}
 	}
 
-	return filtered, nil
+	return FilterNodesByZones(svc, filtered, recorder), nil
 }
 
 type NodeConditionPredicateFunc func(node *v1.Node) bool
 
 func NodeConditionPredicate(svc *v1.Service) (NodeConditionPredicateFunc, error) {
 
+	// the predicate runs for each node of each sync, detail of the sync
+	log := utils.ServiceLogger(svc).V(utils.LOG_SYNC_VERBOSITY)
 	predicate := func(node *v1.Node) bool {
-		// Filter unschedulable node.
-		if node.Spec.Unschedulable {
+		if utils.IsExcludedFromBalancer(node) {
+			log.Info("Ignore node excluded from loadbalancers", utils.LogKeyNode, node.Name)
+			return false
+		}
+		// Filter the cordoned and draining nodes. With the graceful drain
+		// their backends are set to weight 0 for the drain, then removed.
+		if utils.IsDrainingNode(node) {
+			if gracefulDrain(svc) {
+				log.Info("Drain draining node", utils.LogKeyNode, node.Name)
+				return false
+			}
 			if svc.Annotations[utils.ServiceAnnotationLoadBalancerRemoveUnscheduledBackend] == "on" {
-				utils.Logf(svc, "ignore node %s with unschedulable condition", node.Name)
+				log.Info("Ignore unschedulable node", utils.LogKeyNode, node.Name)
 				return false
 			}
 		}
@@ -758,16 +1049,15 @@ func NodeConditionPredicate(svc *v1.Service) (NodeConditionPredicateFunc, error)
 		// it unschedulable. Recognize nodes labeled as master, and filter
 		// them also, as we were doing previously.
 		if _, isMaster := node.Labels[LabelNodeRoleMaster]; isMaster {
-			utils.Logf(svc, "svc %v check node role  %v ",svc.Name, node.Name)
 			if svc.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyTypeCluster {
-				utils.Logf(svc, "ignoring master node %v condition check", node.Name)
+				log.Info("Ignore master node", utils.LogKeyNode, node.Name)
 				return false
 			}
 		}
 
 		// ignore eci node condition check
 		if label, ok := node.Labels["type"]; ok && label == utils.ECINodeLabel {
-			utils.Logf(svc, "ignoring eci node %v condition check", node.Name)
+			log.Info("Skip the condition check of eci node", utils.LogKeyNode, node.Name)
 			return true
 		}
 
@@ -780,8 +1070,13 @@ func NodeConditionPredicate(svc *v1.Service) (NodeConditionPredicateFunc, error)
 			// condition status is ConditionTrue
 			if cond.Type == v1.NodeReady &&
 				cond.Status != v1.ConditionTrue {
-				utils.Logf(svc, "ignoring node %v with %v condition "+
-					"status %v", node.Name, cond.Type, cond.Status)
+				if left := notReadyToleranceLeft(svc, node, cond, time.Now()); left > 0 {
+					log.Info("Keep node within the not ready tolerance", utils.LogKeyNode, node.Name,
+						"condition", cond.Type, "status", cond.Status, "left", left)
+					continue
+				}
+				log.Info("Ignore node not ready", utils.LogKeyNode, node.Name,
+					"condition", cond.Type, "status", cond.Status)
 				return false
 			}
 		}
@@ -792,15 +1087,8 @@ func NodeConditionPredicate(svc *v1.Service) (NodeConditionPredicateFunc, error)
 	return predicate, nil
 }
 
-var re = regexp.MustCompile(".*(Message:.*)")
-
+// getLogMessage the message of the events, the code, RequestId and message
+// of an Alibaba Cloud api error, or the raw error for the other errors.
 func getLogMessage(err error) string {
-	var message string
-	sub := re.FindSubmatch([]byte(err.Error()))
-	if len(sub) > 1 {
-		message = string(sub[1])
-	} else {
-		message = err.Error()
-	}
-	return message
+	return utils.FormatCloudError(err)
 }
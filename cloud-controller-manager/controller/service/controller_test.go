@@ -1,13 +1,35 @@
 package service
 
 import (
+	"context"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
 	"testing"
+	"time"
 )
 
+// testService the LoadBalancer service default/basic-service of the
+// annotations, the fixture the tests override as needed
+func testService(annotations map[string]string) *v1.Service {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "basic-service",
+			Namespace:   "default",
+			UID:         "uid-1",
+			Annotations: annotations,
+		},
+		Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+}
+
 func TestGetServiceHash(t *testing.T) {
 	ServiceAnnotationLoadBalancerAddressType := "service.beta.kubernetes.io/alibaba-cloud-loadbalancer-address-type"
 	serviceA := &v1.Service{
@@ -176,3 +198,401 @@ func TestGetServiceHash(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func hashedService() *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "basic-service",
+			Namespace:       "default",
+			ResourceVersion: "100",
+			Annotations: map[string]string{
+				"service.beta.kubernetes.io/alibaba-cloud-loadbalancer-address-type": "intranet",
+				"service.beta.kubernetes.io/alibaba-cloud-loadbalancer-spec":         "slb.s1.small",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromInt(80), Protocol: v1.ProtocolTCP, NodePort: 30080},
+				{Name: "https", Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 30443},
+			},
+			Type:                     v1.ServiceTypeLoadBalancer,
+			SessionAffinity:          v1.ServiceAffinityNone,
+			ExternalTrafficPolicy:    v1.ServiceExternalTrafficPolicyTypeCluster,
+			LoadBalancerSourceRanges: []string{"10.0.0.0/8", "192.168.0.0/16"},
+			Selector:                 map[string]string{"run": "nginx"},
+		},
+	}
+}
+
+func TestServiceHashStable(t *testing.T) {
+	base, err := utils.GetServiceHash(hashedService())
+	if err != nil {
+		t.Fatalf("get service hash: %s", err.Error())
+	}
+	if !strings.HasPrefix(base, utils.ServiceHashVersion+".") {
+		t.Fatalf("expect hash of version %s, got %s", utils.ServiceHashVersion, base)
+	}
+	cases := []struct {
+		name    string
+		mutate  func(svc *v1.Service)
+		changed bool
+	}{
+		{name: "resource version", mutate: func(svc *v1.Service) { svc.ResourceVersion = "200" }},
+		{name: "managed fields", mutate: func(svc *v1.Service) {
+			svc.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationUpdate}}
+		}},
+		{name: "kubectl annotation", mutate: func(svc *v1.Service) {
+			svc.Annotations[v1.LastAppliedConfigAnnotation] = `{"kind":"Service"}`
+		}},
+		{name: "other annotation", mutate: func(svc *v1.Service) { svc.Annotations["example.com/owner"] = "team-a" }},
+		{name: "id result annotation", mutate: func(svc *v1.Service) {
+			svc.Annotations[utils.ServiceAnnotationLoadBalancerIdResult] = "lb-1"
+		}},
+		{name: "labels", mutate: func(svc *v1.Service) { svc.Labels = map[string]string{"app": "nginx"} }},
+		{name: "selector", mutate: func(svc *v1.Service) { svc.Spec.Selector = map[string]string{"run": "httpd"} }},
+		{name: "cluster ip", mutate: func(svc *v1.Service) { svc.Spec.ClusterIP = "172.21.0.10" }},
+		{name: "status", mutate: func(svc *v1.Service) {
+			svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "47.97.241.114"}}
+		}},
+		{name: "port order", mutate: func(svc *v1.Service) {
+			svc.Spec.Ports[0], svc.Spec.Ports[1] = svc.Spec.Ports[1], svc.Spec.Ports[0]
+		}},
+		{name: "source range order", mutate: func(svc *v1.Service) {
+			svc.Spec.LoadBalancerSourceRanges = []string{"192.168.0.0/16", "10.0.0.0/8"}
+		}},
+		{name: "type", mutate: func(svc *v1.Service) { svc.Spec.Type = v1.ServiceTypeNodePort }, changed: true},
+		{name: "port", mutate: func(svc *v1.Service) { svc.Spec.Ports[0].Port = 8080 }, changed: true},
+		{name: "target port", mutate: func(svc *v1.Service) { svc.Spec.Ports[0].TargetPort = intstr.FromString("web") }, changed: true},
+		{name: "node port", mutate: func(svc *v1.Service) { svc.Spec.Ports[1].NodePort = 31443 }, changed: true},
+		{name: "external traffic policy", mutate: func(svc *v1.Service) {
+			svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+		}, changed: true},
+		{name: "session affinity", mutate: func(svc *v1.Service) { svc.Spec.SessionAffinity = v1.ServiceAffinityClientIP }, changed: true},
+		{name: "source ranges", mutate: func(svc *v1.Service) {
+			svc.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/8"}
+		}, changed: true},
+		{name: "alibaba cloud annotation", mutate: func(svc *v1.Service) {
+			svc.Annotations["service.beta.kubernetes.io/alibaba-cloud-loadbalancer-spec"] = "slb.s2.small"
+		}, changed: true},
+		{name: "legacy annotation", mutate: func(svc *v1.Service) {
+			svc.Annotations["service.beta.kubernetes.io/alicloud-loadbalancer-bandwidth"] = "50"
+		}, changed: true},
+	}
+	for _, c := range cases {
+		svc := hashedService()
+		c.mutate(svc)
+		hash, err := utils.GetServiceHash(svc)
+		if err != nil {
+			t.Fatalf("%s: get service hash: %s", c.name, err.Error())
+		}
+		if changed := hash != base; changed != c.changed {
+			t.Errorf("%s: expect hash changed %t, got %t", c.name, c.changed, changed)
+		}
+	}
+}
+
+func TestServiceHashVersion(t *testing.T) {
+	hash, err := utils.GetServiceHash(hashedService())
+	if err != nil {
+		t.Fatalf("get service hash: %s", err.Error())
+	}
+	legacy, err := utils.HashObjects([]interface{}{hashedService().Spec, utils.UserAnnotations(hashedService())})
+	if err != nil {
+		t.Fatalf("legacy service hash: %s", err.Error())
+	}
+	cases := []struct {
+		name    string
+		label   string
+		mutate  func(svc *v1.Service)
+		changed bool
+	}{
+		{name: "current", label: hash},
+		{name: "current modified", label: hash, mutate: func(svc *v1.Service) { svc.Spec.Ports[0].Port = 8080 }, changed: true},
+		{name: "legacy", label: legacy},
+		{name: "legacy modified", label: legacy, mutate: func(svc *v1.Service) { svc.Spec.Ports[0].Port = 8080 }, changed: true},
+		{name: "unknown version", label: "v9." + strings.TrimPrefix(hash, utils.ServiceHashVersion+"."), changed: true},
+	}
+	for _, c := range cases {
+		svc := hashedService()
+		svc.Labels = map[string]string{utils.LabelServiceHash: c.label}
+		if c.mutate != nil {
+			c.mutate(svc)
+		}
+		changed, err := utils.IsServiceHashChanged(svc)
+		if err != nil {
+			t.Fatalf("%s: service hash changed: %s", c.name, err.Error())
+		}
+		if changed != c.changed {
+			t.Errorf("%s: expect changed %t, got %t", c.name, c.changed, changed)
+		}
+	}
+}
+
+func TestServiceHashLabelBroken(t *testing.T) {
+	hash, err := utils.GetServiceHash(hashedService())
+	if err != nil {
+		t.Fatalf("get service hash: %s", err.Error())
+	}
+	digest := strings.TrimPrefix(hash, utils.ServiceHashVersion+".")
+	labeled := func(label *string, typ v1.ServiceType) *v1.Service {
+		svc := hashedService()
+		svc.Spec.Type = typ
+		svc.Labels = map[string]string{}
+		if label != nil {
+			svc.Labels[utils.LabelServiceHash] = *label
+		}
+		return svc
+	}
+	label := func(l string) *string { return &l }
+	cases := []struct {
+		name       string
+		label      *string
+		wellFormed bool
+		// changed the decision of the sync, a full reconcile if true
+		changed bool
+		// update whether the label set on a synced service triggers a sync
+		update bool
+	}{
+		{name: "synced", label: label(hash), wellFormed: true},
+		{name: "stripped", changed: true, update: true},
+		{name: "emptied", label: label(""), changed: true, update: true},
+		{name: "corrupted", label: label("v2.not-a-hash"), changed: true, update: true},
+		{name: "truncated", label: label(hash[:20]), changed: true, update: true},
+		{name: "malformed version", label: label("2." + digest), changed: true, update: true},
+		{name: "stale version", label: label("v1." + digest), wellFormed: true, changed: true},
+		{name: "upcoming version", label: label("v3." + digest), wellFormed: true, changed: true},
+		{name: "legacy", label: label(digest), wellFormed: true, changed: true},
+	}
+	for _, c := range cases {
+		svc := labeled(c.label, v1.ServiceTypeLoadBalancer)
+		if c.label != nil && utils.IsServiceHashWellFormed(*c.label) != c.wellFormed {
+			t.Errorf("%s: expect well formed %t", c.name, c.wellFormed)
+		}
+		changed, err := utils.IsServiceHashChanged(svc)
+		if err != nil {
+			t.Fatalf("%s: service hash changed: %s", c.name, err.Error())
+		}
+		if changed != c.changed {
+			t.Errorf("%s: expect a full reconcile %t, got %t", c.name, c.changed, changed)
+		}
+		recorder := record.NewFakeRecorder(10)
+		if update := NeedUpdate(labeled(label(hash), v1.ServiceTypeLoadBalancer), svc, recorder); update != c.update {
+			t.Errorf("%s: expect the service synced %t, got %t", c.name, c.update, update)
+		}
+	}
+
+	// the label written by a sync triggers no further sync
+	if NeedUpdate(labeled(nil, v1.ServiceTypeLoadBalancer), labeled(label(hash), v1.ServiceTypeLoadBalancer),
+		record.NewFakeRecorder(10)) {
+		t.Errorf("expect no sync of the label written by a sync")
+	}
+
+	// the label is removed from a service of another type
+	if !NeedUpdate(labeled(nil, v1.ServiceTypeClusterIP), labeled(label(hash), v1.ServiceTypeClusterIP),
+		record.NewFakeRecorder(10)) {
+		t.Errorf("expect a sync of the label set on a ClusterIP service")
+	}
+	if NeedUpdate(labeled(label(hash), v1.ServiceTypeClusterIP), labeled(nil, v1.ServiceTypeClusterIP),
+		record.NewFakeRecorder(10)) {
+		t.Errorf("expect no sync once the label is removed from a ClusterIP service")
+	}
+	if !NeedAdd(labeled(label("corrupted"), v1.ServiceTypeClusterIP)) {
+		t.Errorf("expect a ClusterIP service with a hash label added")
+	}
+
+	// the loadbalancer id tells a formerly synced service whose label is stripped
+	stripped := labeled(nil, v1.ServiceTypeClusterIP)
+	if NeedDelete(stripped) {
+		t.Errorf("expect no deletion of a service never synced")
+	}
+	stripped.Annotations[utils.ServiceAnnotationLoadBalancerIdResult] = "lb-xxx"
+	if !NeedDelete(stripped) || !isManaged(stripped) {
+		t.Errorf("expect a service with the loadbalancer id taken as synced")
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	ip := v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "47.97.241.114"}}}
+	hostname := v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{Hostname: "foo.example.com"}}}
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "basic-service", Namespace: "default"},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		Status:     v1.ServiceStatus{LoadBalancer: ip},
+	}
+	client := fake.NewSimpleClientset(svc)
+	con := &Controller{client: client}
+
+	expect := func(status v1.LoadBalancerStatus) {
+		updated, err := client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get service: %s", err.Error())
+		}
+		got := updated.Status.LoadBalancer
+		if len(got.Ingress) != 1 || got.Ingress[0] != status.Ingress[0] {
+			t.Fatalf("expect status %v, got %v", status, got)
+		}
+	}
+
+	// the hostname replaces the ip
+	if err := con.updateStatus(svc, ip.DeepCopy(), hostname.DeepCopy()); err != nil {
+		t.Fatalf("update status: %s", err.Error())
+	}
+	expect(hostname)
+
+	// an equal status is not written again
+	client.ClearActions()
+	if err := con.updateStatus(svc, hostname.DeepCopy(), hostname.DeepCopy()); err != nil {
+		t.Fatalf("update status: %s", err.Error())
+	}
+	if len(client.Actions()) != 0 {
+		t.Fatalf("expect no update for an equal status, got %v", client.Actions())
+	}
+
+	// and switches back to the ip once the annotation is removed
+	if err := con.updateStatus(svc, hostname.DeepCopy(), ip.DeepCopy()); err != nil {
+		t.Fatalf("update status: %s", err.Error())
+	}
+	expect(ip)
+}
+
+func TestLoadBalancerIdResult(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "basic-service", Namespace: "default"},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+	client := fake.NewSimpleClientset(svc)
+	con := &Controller{client: client}
+	get := func() *v1.Service {
+		updated, err := client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get service: %s", err.Error())
+		}
+		return updated
+	}
+
+	// the id is annotated along with the hash label
+	if err := con.addServiceHash(svc, "lb-1"); err != nil {
+		t.Fatalf("add service hash: %s", err.Error())
+	}
+	if len(client.Actions()) != 1 || client.Actions()[0].GetVerb() != "patch" {
+		t.Fatalf("expect a single patch, got %v", client.Actions())
+	}
+	synced := get()
+	if id := synced.Annotations[utils.ServiceAnnotationLoadBalancerIdResult]; id != "lb-1" {
+		t.Fatalf("expect loadbalancer id lb-1, got [%s]", id)
+	}
+
+	// the annotation triggers no sync and survives the resync
+	changed, err := utils.IsServiceHashChanged(synced)
+	if err != nil || changed {
+		t.Fatalf("expect hash unchanged by the id annotation, got %t, %v", changed, err)
+	}
+	if NeedUpdate(svc, synced, record.NewFakeRecorder(10)) {
+		t.Fatalf("expect no update for the id annotation")
+	}
+	if err := con.addServiceHash(synced, ""); err != nil {
+		t.Fatalf("add service hash: %s", err.Error())
+	}
+	if id := get().Annotations[utils.ServiceAnnotationLoadBalancerIdResult]; id != "lb-1" {
+		t.Fatalf("expect loadbalancer id kept, got [%s]", id)
+	}
+
+	// the recreated loadbalancer
+	if err := con.addServiceHash(get(), "lb-2"); err != nil {
+		t.Fatalf("add service hash: %s", err.Error())
+	}
+	if id := get().Annotations[utils.ServiceAnnotationLoadBalancerIdResult]; id != "lb-2" {
+		t.Fatalf("expect loadbalancer id lb-2, got [%s]", id)
+	}
+
+	// removed with the hash label once the loadbalancer is deleted
+	if err := con.removeServiceHash(get()); err != nil {
+		t.Fatalf("remove service hash: %s", err.Error())
+	}
+	deleted := get()
+	if _, ok := deleted.Annotations[utils.ServiceAnnotationLoadBalancerIdResult]; ok {
+		t.Fatalf("expect loadbalancer id removed, got %v", deleted.Annotations)
+	}
+	if _, ok := deleted.Labels[utils.LabelServiceHash]; ok {
+		t.Fatalf("expect hash label removed, got %v", deleted.Labels)
+	}
+}
+
+func TestSyncLogKeys(t *testing.T) {
+	var entries []utils.LogEntry
+	former := utils.SetLogSink(func(depth int, entry utils.LogEntry) { entries = append(entries, entry) })
+	defer utils.SetLogSink(former)
+
+	svc := testService(nil)
+	predicate, err := NodeConditionPredicate(svc)
+	if err != nil {
+		t.Fatalf("predicate: %s", err.Error())
+	}
+	if predicate(readyNode("node-1", v1.ConditionFalse, time.Minute)) {
+		t.Fatalf("expect the NotReady node ignored")
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expect the ignored node logged, got %v", entries)
+	}
+	entry := entries[0]
+	// the details of the sync are verbose
+	if entry.Severity != utils.LogInfo || entry.Level < utils.LOG_SYNC_VERBOSITY {
+		t.Errorf("expect an info at verbosity %d, got %s %d", utils.LOG_SYNC_VERBOSITY, entry.Severity, entry.Level)
+	}
+	for key, value := range map[string]string{
+		utils.LogKeyService: "default/basic-service",
+		utils.LogKeyNode:    "node-1",
+	} {
+		if v, _ := entry.Value(key); v != value {
+			t.Errorf("expect key %s of %s, got %s", key, value, entry.String())
+		}
+	}
+	expect := `"Ignore node not ready" service="default/basic-service" node="node-1" condition=Ready status=False`
+	if entry.String() != expect {
+		t.Errorf("expect the format of klog.InfoS %s, got %s", expect, entry.String())
+	}
+
+	// the shim of the other packages logs the service by the key as well
+	entries = nil
+	utils.Logf(svc, "ensured %d listeners", 2)
+	if len(entries) != 1 || entries[0].Message != "ensured 2 listeners" {
+		t.Fatalf("expect the message of the shim logged, got %v", entries)
+	}
+	if v, _ := entries[0].Value(utils.LogKeyService); v != "default/basic-service" {
+		t.Errorf("expect key %s of the shim, got %s", utils.LogKeyService, entries[0].String())
+	}
+}
+
+func TestDeleteAlreadyAbsent(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "basic-service", Namespace: "default"},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+	cloud := &fakeCloud{}
+	recorder := record.NewFakeRecorder(10)
+	con := &Controller{cloud: cloud, local: &Context{}, recorder: recorder}
+	event := func() string {
+		if len(recorder.Events) != 1 {
+			t.Fatalf("expect a single event, got %d", len(recorder.Events))
+		}
+		return <-recorder.Events
+	}
+
+	// the loadbalancer found is deleted
+	cloud.deleted = "lb-1"
+	if err := con.delete(svc); err != nil {
+		t.Fatalf("delete: %s", err.Error())
+	}
+	if e := event(); !strings.HasPrefix(e, "Normal DeletedLoadBalancer") || strings.Contains(e, "already absent") {
+		t.Fatalf("expect the loadbalancer deleted, got %s", e)
+	}
+
+	// none is found, the delete succeeds anyway
+	cloud.deleted = ""
+	if err := con.delete(svc); err != nil {
+		t.Fatalf("delete: %s", err.Error())
+	}
+	if e := event(); !strings.HasPrefix(e, "Normal DeletedLoadBalancer") || !strings.Contains(e, "(already absent)") {
+		t.Fatalf("expect the loadbalancer already absent, got %s", e)
+	}
+}
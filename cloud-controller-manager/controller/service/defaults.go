@@ -0,0 +1,110 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	queue "k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+)
+
+// The cluster-wide default annotations of the loadbalancer services are kept
+// in the data of a configmap, eg. the address type or the spec of all the
+// loadbalancers. The cloud merges the defaults under the annotations of the
+// service on each sync, the service wins on conflict, and the merged view is
+// never written back to the service. The defaults take part in the hash of
+// the service, so a change of the configmap syncs the services it affects.
+
+// DefaultAnnotationsConfigMap the namespace/name of the configmap of the
+// default annotations, empty for no defaults
+var DefaultAnnotationsConfigMap string
+
+// ParseDefaultAnnotationsConfigMap the namespace and the name of the
+// configmap of the default annotations, eg. kube-system/lb-defaults
+func ParseDefaultAnnotationsConfigMap(ref string) (string, string, error) {
+	if ref == "" {
+		return "", "", nil
+	}
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("default annotations configmap %q, must be namespace/name", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+// newDefaultsInformer the informer of the configmap of the default
+// annotations only, nil if not configured
+func newDefaultsInformer(client clientset.Interface, ref string) cache.SharedIndexInformer {
+	namespace, name, err := ParseDefaultAnnotationsConfigMap(ref)
+	if err != nil || name == "" {
+		return nil
+	}
+	return coreinformers.NewFilteredConfigMapInformer(
+		client,
+		namespace,
+		SERVICE_SYNC_PERIOD,
+		cache.Indexers{},
+		func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		},
+	)
+}
+
+// HandlerForDefaultsChange set the default annotations from the configmap and
+// resync the loadbalancer services once they change.
+func (con *Controller) HandlerForDefaultsChange(
+	que queue.DelayingInterface,
+	informer cache.SharedIndexInformer,
+) {
+	syncDefaults := func(cm *v1.ConfigMap) {
+		var values map[string]string
+		if cm != nil {
+			values = cm.Data
+		}
+		if !utils.SetDefaultAnnotations(values) {
+			return
+		}
+		utils.InfoS("Default annotations changed", "annotations", utils.DefaultAnnotations())
+		svcs, err := con.ifactory.Core().V1().Services().Lister().List(labels.Everything())
+		if err != nil {
+			utils.ErrorS(err, "Failed to list the services of the default annotations")
+			return
+		}
+		for _, svc := range svcs {
+			if !NeedLoadBalancer(svc) || !isProcessNeeded(svc) {
+				continue
+			}
+			utils.ServiceLogger(svc).V(utils.LOG_SYNC_VERBOSITY).Info("Enqueue service for the default annotations change")
+			Enqueue(que, key(svc))
+		}
+	}
+	informer.AddEventHandlerWithResyncPeriod(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if cm, ok := obj.(*v1.ConfigMap); ok {
+					syncDefaults(cm)
+				}
+			},
+			UpdateFunc: func(obja, objb interface{}) {
+				cm1, ok1 := obja.(*v1.ConfigMap)
+				cm2, ok2 := objb.(*v1.ConfigMap)
+				if ok1 && ok2 && !reflect.DeepEqual(cm1.Data, cm2.Data) {
+					syncDefaults(cm2)
+				}
+			},
+			// the services fall back to their own annotations
+			DeleteFunc: func(obj interface{}) {
+				syncDefaults(nil)
+			},
+		},
+		SERVICE_SYNC_PERIOD,
+	)
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+)
+
+const (
+	annotationSpec        = "service.beta.kubernetes.io/alibaba-cloud-loadbalancer-spec"
+	annotationAddressType = "service.beta.kubernetes.io/alibaba-cloud-loadbalancer-address-type"
+)
+
+func TestDefaultAnnotationsPrecedence(t *testing.T) {
+	defer utils.SetDefaultAnnotations(nil)
+	utils.SetDefaultAnnotations(map[string]string{
+		annotationSpec:        "slb.s2.small",
+		annotationAddressType: "intranet",
+		// not a loadbalancer annotation
+		"example.com/owner": "team",
+	})
+
+	svc := testService(nil)
+	svc.Annotations[annotationSpec] = "slb.s1.small"
+	merged := utils.WithDefaultAnnotations(svc)
+	if merged.Annotations[annotationSpec] != "slb.s1.small" {
+		t.Fatalf("expect the spec of the service wins, got %s", merged.Annotations[annotationSpec])
+	}
+	if merged.Annotations[annotationAddressType] != "intranet" {
+		t.Fatalf("expect the default address type merged, got %q", merged.Annotations[annotationAddressType])
+	}
+	if _, ok := merged.Annotations["example.com/owner"]; ok {
+		t.Fatalf("expect the annotation other than of the loadbalancer not defaulted")
+	}
+	// the service itself is never written
+	if _, ok := svc.Annotations[annotationAddressType]; ok {
+		t.Fatalf("expect the defaults not merged into the service")
+	}
+
+	svc.Annotations[annotationAddressType] = "internet"
+	if merged := utils.WithDefaultAnnotations(svc); merged != svc {
+		t.Fatalf("expect the service not copied when it overrides every default")
+	}
+}
+
+func TestDefaultAnnotationsHash(t *testing.T) {
+	defer utils.SetDefaultAnnotations(nil)
+	svc := testService(nil)
+	svc.Annotations[annotationSpec] = "slb.s1.small"
+	hash := func() string {
+		h, err := utils.GetServiceHash(svc)
+		if err != nil {
+			t.Fatalf("hash service: %s", err.Error())
+		}
+		return h
+	}
+	origin := hash()
+
+	utils.SetDefaultAnnotations(map[string]string{annotationAddressType: "intranet"})
+	defaulted := hash()
+	if defaulted == origin {
+		t.Fatalf("expect a default changes the hash")
+	}
+
+	// a default overridden by the service does not affect it
+	utils.SetDefaultAnnotations(map[string]string{
+		annotationAddressType: "intranet",
+		annotationSpec:        "slb.s2.small",
+	})
+	if hash() != defaulted {
+		t.Fatalf("expect the default overridden by the service not change the hash")
+	}
+
+	// the same annotation by the default or by the service hashes the same
+	svc.Annotations[annotationAddressType] = "intranet"
+	if hash() != defaulted {
+		t.Fatalf("expect the annotation of the service hash as the default")
+	}
+
+	utils.SetDefaultAnnotations(nil)
+	delete(svc.Annotations, annotationAddressType)
+	if hash() != origin {
+		t.Fatalf("expect the hash restored once the defaults removed")
+	}
+}
+
+func TestDefaultAnnotationsConfigMap(t *testing.T) {
+	defer utils.SetDefaultAnnotations(nil)
+	svc := testService(nil)
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "lb-defaults"},
+		Data:       map[string]string{annotationSpec: "slb.s2.small"},
+	}
+	client := fake.NewSimpleClientset(svc, cm)
+	ifactory := informers.NewSharedInformerFactory(client, 0)
+	que := &recordQueue{enqueues: make(chan enqueue, 10)}
+	con := &Controller{client: client, ifactory: ifactory}
+	informer := newDefaultsInformer(client, "kube-system/lb-defaults")
+	con.HandlerForDefaultsChange(que, informer)
+	// the lister of the services is registered before the start
+	ifactory.Core().V1().Services().Informer()
+	stop := make(chan struct{})
+	defer close(stop)
+	ifactory.Start(stop)
+	ifactory.WaitForCacheSync(stop)
+	go informer.Run(stop)
+
+	if e := que.next(t); e.key != key(svc) {
+		t.Fatalf("expect %s enqueued for the defaults, got %+v", key(svc), e)
+	}
+	if spec := utils.DefaultAnnotations()[annotationSpec]; spec != "slb.s2.small" {
+		t.Fatalf("expect the default spec slb.s2.small, got %q", spec)
+	}
+
+	cm.Data = map[string]string{annotationSpec: "slb.s3.small"}
+	if _, err := client.CoreV1().ConfigMaps(cm.Namespace).Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update configmap: %s", err.Error())
+	}
+	if e := que.next(t); e.key != key(svc) {
+		t.Fatalf("expect %s enqueued for the update, got %+v", key(svc), e)
+	}
+	if spec := utils.DefaultAnnotations()[annotationSpec]; spec != "slb.s3.small" {
+		t.Fatalf("expect the default spec slb.s3.small, got %q", spec)
+	}
+
+	if err := client.CoreV1().ConfigMaps(cm.Namespace).Delete(context.TODO(), cm.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete configmap: %s", err.Error())
+	}
+	if e := que.next(t); e.key != key(svc) {
+		t.Fatalf("expect %s enqueued for the delete, got %+v", key(svc), e)
+	}
+	if defaults := utils.DefaultAnnotations(); len(defaults) != 0 {
+		t.Fatalf("expect no defaults after the delete, got %v", defaults)
+	}
+}
+
+func TestParseDefaultAnnotationsConfigMap(t *testing.T) {
+	for _, ref := range []string{"lb-defaults", "/lb-defaults", "kube-system/", "a/b/c"} {
+		if _, _, err := ParseDefaultAnnotationsConfigMap(ref); err == nil {
+			t.Fatalf("expect %q rejected", ref)
+		}
+	}
+	ns, name, err := ParseDefaultAnnotationsConfigMap("kube-system/lb-defaults")
+	if err != nil || ns != "kube-system" || name != "lb-defaults" {
+		t.Fatalf("expect kube-system/lb-defaults, got %s/%s: %v", ns, name, err)
+	}
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+// diagnosticBase the service of sensitive annotations the tests dump copies of
+var diagnosticBase = func() *v1.Service {
+	svc := testService(map[string]string{
+		utils.ServiceAnnotationLoadBalancerCertSecret:                        "default/tls",
+		"service.beta.kubernetes.io/alibaba-cloud-loadbalancer-address-type": "intranet",
+		"example.com/api-token":                                              "t0ken-value",
+		"example.com/db-password":                                            "pa55-value",
+		"example.com/ssh-key":                                                "k3y-value",
+		v1.LastAppliedConfigAnnotation:                                       `{"metadata":{"annotations":{"example.com/api-token":"t0ken-value"}}}`,
+	})
+	svc.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl-client-side-apply"}}
+	svc.Spec.Ports = []v1.ServicePort{{Name: "https", Port: 443, NodePort: 30443, Protocol: v1.ProtocolTCP}}
+	return svc
+}()
+
+func TestDiagnosticRedaction(t *testing.T) {
+	svc := diagnosticBase.DeepCopy()
+	dump := utils.Diagnostic(svc)
+	for _, secret := range []string{"default/tls", "t0ken-value", "pa55-value", "k3y-value"} {
+		if strings.Contains(dump, secret) {
+			t.Errorf("expect %s redacted, got %s", secret, dump)
+		}
+	}
+	if strings.Contains(dump, "kubectl-client-side-apply") {
+		t.Errorf("expect the managed fields dropped, got %s", dump)
+	}
+	// the content not sensitive is kept
+	for _, kept := range []string{
+		"default/basic-service uid=uid-1: ",
+		`"service.beta.kubernetes.io/alibaba-cloud-loadbalancer-address-type":"intranet"`,
+		`"example.com/api-token":"` + utils.DIAGNOSTIC_REDACTED + `"`,
+		`"nodePort":30443`,
+	} {
+		if !strings.Contains(dump, kept) {
+			t.Errorf("expect %s kept, got %s", kept, dump)
+		}
+	}
+	// the object dumped is not modified
+	if svc.Annotations["example.com/api-token"] != "t0ken-value" {
+		t.Fatalf("expect the annotations of the service kept")
+	}
+}
+
+func TestDiagnosticRedactPattern(t *testing.T) {
+	defer func() { utils.SetDiagnosticRedactPattern(utils.DEFAULT_DIAGNOSTIC_REDACT_PATTERN) }()
+	if err := utils.SetDiagnosticRedactPattern("(?i)address-type"); err != nil {
+		t.Fatalf("set redact pattern: %s", err.Error())
+	}
+	dump := utils.Diagnostic(diagnosticBase.DeepCopy())
+	if strings.Contains(dump, "intranet") {
+		t.Errorf("expect the annotation of the pattern redacted, got %s", dump)
+	}
+	if !strings.Contains(dump, "t0ken-value") {
+		t.Errorf("expect the annotation out of the pattern kept, got %s", dump)
+	}
+	if err := utils.SetDiagnosticRedactPattern("(cert"); err == nil {
+		t.Fatalf("expect the invalid pattern rejected")
+	}
+}
+
+func TestDiagnosticTruncation(t *testing.T) {
+	defer func(max int) { utils.DiagnosticMaxBytes = max }(utils.DiagnosticMaxBytes)
+	svc := diagnosticBase.DeepCopy()
+	for i := 0; i < 100; i++ {
+		svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Port: int32(8000 + i), Protocol: v1.ProtocolTCP})
+	}
+	utils.DiagnosticMaxBytes = 0
+	full := utils.Diagnostic(svc)
+
+	utils.DiagnosticMaxBytes = 256
+	dump := utils.Diagnostic(svc)
+	prefix := "default/basic-service uid=uid-1: "
+	if !strings.HasPrefix(dump, prefix) {
+		t.Fatalf("expect the identity kept ahead of the truncated output, got %s", dump)
+	}
+	if !strings.Contains(dump, "...(truncated ") {
+		t.Fatalf("expect the truncation marked, got %s", dump)
+	}
+	body := strings.TrimPrefix(dump, prefix)
+	body = body[:strings.Index(body, "...(truncated ")]
+	if len(body) > 256 || !strings.HasPrefix(strings.TrimPrefix(full, prefix), body) {
+		t.Fatalf("expect the first 256 bytes kept, got %d bytes", len(body))
+	}
+
+	// a dump within the limit is not marked
+	utils.DiagnosticMaxBytes = len(full)
+	if dump := utils.Diagnostic(svc); dump != full {
+		t.Fatalf("expect the dump within the limit kept, got %s", dump)
+	}
+
+	// nor is a dump without the identity of an object
+	if dump := utils.Diagnostic([]string{"a", "b"}); dump != `["a","b"]` {
+		t.Fatalf("expect the plain value dumped, got %s", dump)
+	}
+}
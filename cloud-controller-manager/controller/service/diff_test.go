@@ -0,0 +1,165 @@
+package service
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+// diffBase the service the tests diff their changed copies with
+var diffBase = func() *v1.Service {
+	svc := testService(map[string]string{
+		"service.beta.kubernetes.io/alibaba-cloud-loadbalancer-spec": "slb.s1.small",
+		utils.ServiceAnnotationLoadBalancerCertSecret:                "default/tls",
+	})
+	svc.Spec.Ports = []v1.ServicePort{
+		{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080), NodePort: 30080, Protocol: v1.ProtocolTCP},
+	}
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
+	svc.Spec.SessionAffinity = v1.ServiceAffinityNone
+	return svc
+}()
+
+func TestServiceDiff(t *testing.T) {
+	https := "https"
+	cases := []struct {
+		name   string
+		mutate func(svc *v1.Service)
+		expect string
+	}{
+		{name: "unchanged", mutate: func(svc *v1.Service) {}, expect: ""},
+		{
+			name: "port added",
+			mutate: func(svc *v1.Service) {
+				svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Port: 443, Protocol: v1.ProtocolTCP})
+			},
+			expect: "port added 443/TCP",
+		},
+		{name: "port removed", mutate: func(svc *v1.Service) { svc.Spec.Ports = nil }, expect: "port removed 80/TCP"},
+		{
+			name:   "port protocol",
+			mutate: func(svc *v1.Service) { svc.Spec.Ports[0].Protocol = v1.ProtocolUDP },
+			expect: "port added 80/UDP; port removed 80/TCP",
+		},
+		{name: "port name", mutate: func(svc *v1.Service) { svc.Spec.Ports[0].Name = "web" }, expect: "port 80/TCP name http -> web"},
+		{
+			name:   "target port",
+			mutate: func(svc *v1.Service) { svc.Spec.Ports[0].TargetPort = intstr.FromString("http") },
+			expect: "port 80/TCP targetPort 8080 -> http",
+		},
+		{name: "node port", mutate: func(svc *v1.Service) { svc.Spec.Ports[0].NodePort = 30081 }, expect: "port 80/TCP nodePort 30080 -> 30081"},
+		{
+			name:   "app protocol",
+			mutate: func(svc *v1.Service) { svc.Spec.Ports[0].AppProtocol = &https },
+			expect: "port 80/TCP appProtocol  -> https",
+		},
+		{name: "type", mutate: func(svc *v1.Service) { svc.Spec.Type = v1.ServiceTypeNodePort }, expect: "type LoadBalancer -> NodePort"},
+		{
+			name:   "external traffic policy",
+			mutate: func(svc *v1.Service) { svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal },
+			expect: "externalTrafficPolicy Cluster -> Local",
+		},
+		{
+			name:   "session affinity",
+			mutate: func(svc *v1.Service) { svc.Spec.SessionAffinity = v1.ServiceAffinityClientIP },
+			expect: "sessionAffinity None -> ClientIP",
+		},
+		{
+			name:   "source ranges",
+			mutate: func(svc *v1.Service) { svc.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/8"} },
+			expect: "loadBalancerSourceRanges  -> 10.0.0.0/8",
+		},
+		{
+			name: "annotation added",
+			mutate: func(svc *v1.Service) {
+				svc.Annotations["service.beta.kubernetes.io/alibaba-cloud-loadbalancer-bandwidth"] = "50"
+			},
+			expect: "annotation added service.beta.kubernetes.io/alibaba-cloud-loadbalancer-bandwidth: 50",
+		},
+		{
+			name: "annotation removed",
+			mutate: func(svc *v1.Service) {
+				delete(svc.Annotations, "service.beta.kubernetes.io/alibaba-cloud-loadbalancer-spec")
+			},
+			expect: "annotation removed service.beta.kubernetes.io/alibaba-cloud-loadbalancer-spec",
+		},
+		{
+			name: "annotation changed",
+			mutate: func(svc *v1.Service) {
+				svc.Annotations["service.beta.kubernetes.io/alibaba-cloud-loadbalancer-spec"] = "slb.s2.small"
+			},
+			expect: "annotation changed service.beta.kubernetes.io/alibaba-cloud-loadbalancer-spec: slb.s1.small -> slb.s2.small",
+		},
+		{
+			name: "sensitive annotation",
+			mutate: func(svc *v1.Service) {
+				svc.Annotations[utils.ServiceAnnotationLoadBalancerCertSecret] = "default/tls-2"
+			},
+			expect: "annotation changed " + utils.ServiceAnnotationLoadBalancerCertSecret + ": " +
+				utils.DIAGNOSTIC_REDACTED + " -> " + utils.DIAGNOSTIC_REDACTED,
+		},
+		{
+			name:   "annotation of the controller",
+			mutate: func(svc *v1.Service) { svc.Annotations[utils.ServiceAnnotationLoadBalancerIdResult] = "lb-1" },
+			expect: "",
+		},
+		{name: "uid", mutate: func(svc *v1.Service) { svc.UID = "uid-2" }, expect: "uid uid-1 -> uid-2"},
+		{name: "selector", mutate: func(svc *v1.Service) { svc.Spec.Selector = map[string]string{"app": "nginx"} }, expect: ""},
+	}
+	for _, c := range cases {
+		cur := diffBase.DeepCopy()
+		c.mutate(cur)
+		if got := strings.Join(utils.ServiceDiff(diffBase.DeepCopy(), cur), "; "); got != c.expect {
+			t.Errorf("%s: expect diff [%s], got [%s]", c.name, c.expect, got)
+		}
+	}
+}
+
+func TestServiceDiffTruncation(t *testing.T) {
+	cur := diffBase.DeepCopy()
+	for i := 0; i < 200; i++ {
+		cur.Spec.Ports = append(cur.Spec.Ports, v1.ServicePort{Port: int32(10000 + i), Protocol: v1.ProtocolTCP})
+	}
+	summary := utils.ServiceDiffSummary(diffBase.DeepCopy(), cur)
+	if !strings.HasPrefix(summary, "port added 10000/TCP") || !strings.Contains(summary, "...(truncated ") ||
+		len(summary) > utils.DIFF_MAX_BYTES+len("...(truncated 9999 bytes)") {
+		t.Fatalf("expect the summary truncated to %d bytes, got %s", utils.DIFF_MAX_BYTES, summary)
+	}
+}
+
+func TestNeedUpdateEvent(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	cur := diffBase.DeepCopy()
+	cur.Spec.Ports[0].NodePort = 30081
+	cur.Annotations[utils.ServiceAnnotationLoadBalancerCertSecret] = "default/tls-2"
+	if !NeedUpdate(diffBase.DeepCopy(), cur, recorder) {
+		t.Fatalf("expect the service updated")
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expect a single event, got %d", len(recorder.Events))
+	}
+	event := <-recorder.Events
+	if !strings.HasPrefix(event, "Normal ServiceSpecChanged") ||
+		!strings.Contains(event, "port 80/TCP nodePort 30080 -> 30081") {
+		t.Fatalf("expect the changes told, got %s", event)
+	}
+	if strings.Contains(event, "default/tls") {
+		t.Fatalf("expect the secret redacted, got %s", event)
+	}
+
+	// the changes not affecting the loadbalancer still sync the service
+	cur = diffBase.DeepCopy()
+	cur.Spec.Selector = map[string]string{"app": "nginx"}
+	if !NeedUpdate(diffBase.DeepCopy(), cur, recorder) {
+		t.Fatalf("expect the service updated")
+	}
+	if event := <-recorder.Events; !strings.Contains(event, "no field of the loadbalancer changed") {
+		t.Fatalf("expect the change told, got %s", event)
+	}
+	if NeedUpdate(diffBase.DeepCopy(), diffBase.DeepCopy(), recorder) || len(recorder.Events) != 0 {
+		t.Fatalf("expect no update of the unchanged service")
+	}
+}
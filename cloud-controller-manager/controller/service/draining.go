@@ -0,0 +1,24 @@
+package service
+
+import (
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+)
+
+// A node being drained is cordoned, or carries a drain taint or label, well
+// before it leaves the cluster, see utils.NodeDraining. With the
+// graceful-drain-seconds annotation a draining node is dropped from the
+// backends whatever the remove-unscheduled-backend annotation is, and its
+// backends are set to weight 0 for the drain before they are removed. The
+// node uncordoned is added back with its weight. Without the graceful drain
+// the draining node is kept unless remove-unscheduled-backend is on, as a
+// cordoned node always was.
+
+// gracefulDrain whether the removed backends of the service are drained,
+// the drain time is validated by the cloud
+func gracefulDrain(svc *v1.Service) bool {
+	seconds, err := strconv.Atoi(svc.Annotations[utils.ServiceAnnotationLoadBalancerGracefulDrainSeconds])
+	return err == nil && seconds > 0
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+)
+
+func cordonedNode(name string) *v1.Node {
+	node := readyNode(name, v1.ConditionTrue, time.Hour)
+	node.Spec.Unschedulable = true
+	node.Spec.Taints = []v1.Taint{{Key: v1.TaintNodeUnschedulable, Effect: v1.TaintEffectNoSchedule}}
+	return node
+}
+
+func taintedNode(name, key string) *v1.Node {
+	node := readyNode(name, v1.ConditionTrue, time.Hour)
+	node.Spec.Taints = []v1.Taint{{Key: key, Effect: v1.TaintEffectNoSchedule}}
+	return node
+}
+
+func drainService(seconds, removeUnscheduled string) *v1.Service {
+	svc := testService(nil)
+	if seconds != "" {
+		svc.Annotations[utils.ServiceAnnotationLoadBalancerGracefulDrainSeconds] = seconds
+	}
+	if removeUnscheduled != "" {
+		svc.Annotations[utils.ServiceAnnotationLoadBalancerRemoveUnscheduledBackend] = removeUnscheduled
+	}
+	return svc
+}
+
+func TestDrainingNodePredicate(t *testing.T) {
+	draining, err := utils.NewNodeDraining([]string{"ToBeDeletedByClusterAutoscaler"}, []string{"lifecycle=draining"})
+	if err != nil {
+		t.Fatalf("node draining: %s", err.Error())
+	}
+	defer func(origin utils.NodeDraining) { utils.DrainingNodes = origin }(utils.DrainingNodes)
+	utils.DrainingNodes = draining
+
+	cases := []struct {
+		name    string
+		svc     *v1.Service
+		node    *v1.Node
+		backend bool
+	}{
+		{name: "cordon kept", svc: drainService("", ""), node: cordonedNode("cordoned"), backend: true},
+		{name: "cordon removed unscheduled", svc: drainService("", "on"), node: cordonedNode("cordoned")},
+		{name: "cordon drained", svc: drainService("30", ""), node: cordonedNode("cordoned")},
+		{name: "cordon drained whatever unscheduled", svc: drainService("30", "off"), node: cordonedNode("cordoned")},
+		{name: "taint kept", svc: drainService("", ""), node: taintedNode("tainted", "ToBeDeletedByClusterAutoscaler"), backend: true},
+		{name: "taint drained", svc: drainService("30", ""), node: taintedNode("tainted", "ToBeDeletedByClusterAutoscaler")},
+		{name: "taint removed unscheduled", svc: drainService("", "on"), node: taintedNode("tainted", "ToBeDeletedByClusterAutoscaler")},
+		{name: "label drained", svc: drainService("30", ""), node: labeledNode("labeled", map[string]string{"lifecycle": "draining"})},
+		{name: "other taint", svc: drainService("30", ""), node: taintedNode("gpu", "nvidia.com/gpu"), backend: true},
+		{name: "uncordoned", svc: drainService("30", "on"), node: readyNode("uncordoned", v1.ConditionTrue, time.Hour), backend: true},
+	}
+	for _, c := range cases {
+		predicate, err := NodeConditionPredicate(c.svc)
+		if err != nil {
+			t.Fatalf("%s: predicate: %s", c.name, err.Error())
+		}
+		if predicate(c.node) != c.backend {
+			t.Errorf("%s: expect backend %t, got %t", c.name, c.backend, !c.backend)
+		}
+	}
+}
+
+func TestDrainingNodeChanged(t *testing.T) {
+	defer func(origin utils.NodeDraining) { utils.DrainingNodes = origin }(utils.DrainingNodes)
+	draining, err := utils.NewNodeDraining([]string{"ToBeDeletedByClusterAutoscaler"}, nil)
+	if err != nil {
+		t.Fatalf("node draining: %s", err.Error())
+	}
+	utils.DrainingNodes = draining
+
+	node := readyNode("worker", v1.ConditionTrue, time.Hour)
+	tainted := taintedNode("worker", "ToBeDeletedByClusterAutoscaler")
+	tainted.Status = node.Status
+	if !NodeSpecChanged(node, tainted) {
+		t.Errorf("expect the drain taint changes the node")
+	}
+	// the taint removed, the node is a backend again
+	if !NodeSpecChanged(tainted, node) {
+		t.Errorf("expect the drain taint removed changes the node")
+	}
+	other := taintedNode("worker", "nvidia.com/gpu")
+	other.Status = node.Status
+	if NodeSpecChanged(node, other) {
+		t.Errorf("expect a taint other than of the drain not change the node")
+	}
+}
+
+func TestNewNodeDraining(t *testing.T) {
+	if _, err := utils.NewNodeDraining([]string{""}, nil); err == nil {
+		t.Errorf("expect empty taint rejected")
+	}
+	if _, err := utils.NewNodeDraining(nil, []string{"=x"}); err == nil {
+		t.Errorf("expect invalid selector rejected")
+	}
+	if !utils.DefaultNodeDraining().Draining(taintedNode("cordoned", v1.TaintNodeUnschedulable)) {
+		t.Errorf("expect the unschedulable taint draining by default")
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"testing"
+	"time"
+)
+
+func endpointsOf(svc *v1.Service, ip string) *v1.Endpoints {
+	return &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: svc.Name, Namespace: svc.Namespace},
+		Subsets:    []v1.EndpointSubset{{Addresses: []v1.EndpointAddress{{IP: ip}}}},
+	}
+}
+
+// serviceGets the Gets of the services sent to the apiserver
+func serviceGets(client *fake.Clientset) int {
+	gets := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "get" && action.GetResource().Resource == "services" {
+			gets++
+		}
+	}
+	return gets
+}
+
+func TestEndpointsChangeServiceLister(t *testing.T) {
+	synced := testService(nil)
+	hash, err := utils.GetServiceHash(synced)
+	if err != nil {
+		t.Fatalf("get service hash: %s", err.Error())
+	}
+	synced.Labels = map[string]string{utils.LabelServiceHash: hash}
+	// the service of another controller is in the informer only
+	missed := testService(nil)
+	missed.Name = "missed-service"
+	client := fake.NewSimpleClientset(synced, missed)
+
+	// the services are in the informer, but not in the local cache, eg. after a restart
+	ifactory := informers.NewSharedInformerFactory(client, 0)
+	if err := ifactory.Core().V1().Services().Informer().GetIndexer().Add(synced); err != nil {
+		t.Fatalf("add service: %s", err.Error())
+	}
+	local := &Context{}
+	que := workqueue.NewDelayingQueue()
+	defer que.ShutDown()
+	con := &Controller{client: client, ifactory: ifactory, local: local}
+	epfactory := informers.NewSharedInformerFactory(client, 0)
+	con.HandlerForEndpointChange(local, que, epfactory.Core().V1().Endpoints().Informer())
+	stop := make(chan struct{})
+	defer close(stop)
+	epfactory.Start(stop)
+	epfactory.WaitForCacheSync(stop)
+
+	enqueued := func(expect string) {
+		got := make(chan interface{}, 1)
+		go func() {
+			k, _ := que.Get()
+			que.Done(k)
+			got <- k
+		}()
+		select {
+		case k := <-got:
+			if k != expect {
+				t.Fatalf("expect %s enqueued, got %v", expect, k)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expect %s enqueued", expect)
+		}
+	}
+	apply := func(ep *v1.Endpoints, create bool) {
+		var err error
+		if create {
+			_, err = client.CoreV1().Endpoints(ep.Namespace).Create(context.TODO(), ep, metav1.CreateOptions{})
+		} else {
+			_, err = client.CoreV1().Endpoints(ep.Namespace).Update(context.TODO(), ep, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			t.Fatalf("apply endpoints: %s", err.Error())
+		}
+	}
+
+	// the service is resolved by the informer, and cached as it is synced
+	apply(endpointsOf(synced, "10.0.0.1"), true)
+	enqueued(key(synced))
+	apply(endpointsOf(synced, "10.0.0.2"), false)
+	enqueued(key(synced))
+	if gets := serviceGets(client); gets != 0 {
+		t.Fatalf("expect no service got from apiserver, got %d", gets)
+	}
+	if local.Get(key(synced)) == nil {
+		t.Fatalf("expect the synced service cached")
+	}
+
+	// the apiserver is asked only when the informer lacks the service
+	apply(endpointsOf(missed, "10.0.0.3"), true)
+	enqueued(key(missed))
+	if gets := serviceGets(client); gets != 1 {
+		t.Fatalf("expect the service missed by the informer got from apiserver, got %d", gets)
+	}
+	if local.Get(key(missed)) != nil {
+		t.Fatalf("expect the service never synced not cached")
+	}
+}
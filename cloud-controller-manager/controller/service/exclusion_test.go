@@ -0,0 +1,78 @@
+package service
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"testing"
+	"time"
+)
+
+func labeledNode(name string, labels map[string]string) *v1.Node {
+	node := readyNode(name, v1.ConditionTrue, time.Hour)
+	node.Labels = labels
+	return node
+}
+
+func TestNodeExclusion(t *testing.T) {
+	exclusion, err := utils.NewNodeExclusion(
+		[]string{"pool=gpu-burst"},
+		[]string{"role in (ingress,egress)"},
+	)
+	if err != nil {
+		t.Fatalf("node exclusion: %s", err.Error())
+	}
+	cases := []struct {
+		name       string
+		labels     map[string]string
+		management bool
+		balancer   bool
+	}{
+		{name: "plain", labels: map[string]string{"pool": "default"}},
+		{name: "exclude node", labels: map[string]string{utils.LabelNodeRoleExcludeNode: "true"}, management: true, balancer: true},
+		{name: "deprecated exclude node", labels: map[string]string{utils.LabelNodeRoleExcludeNodeDeprecated: "true"}, management: true, balancer: true},
+		{name: "exclude balancer", labels: map[string]string{utils.LabelNodeRoleExcludeBalancer: ""}, balancer: true},
+		{name: "management selector", labels: map[string]string{"pool": "gpu-burst"}, management: true, balancer: true},
+		{name: "balancer selector", labels: map[string]string{"role": "egress"}, balancer: true},
+	}
+	for _, c := range cases {
+		node := labeledNode(c.name, c.labels)
+		if excluded := exclusion.ExcludedFromManagement(node); excluded != c.management {
+			t.Errorf("%s: expect excluded from management %t, got %t", c.name, c.management, excluded)
+		}
+		if excluded := exclusion.ExcludedFromBalancer(node); excluded != c.balancer {
+			t.Errorf("%s: expect excluded from balancer %t, got %t", c.name, c.balancer, excluded)
+		}
+	}
+}
+
+func TestNodeExclusionInvalidSelector(t *testing.T) {
+	if _, err := utils.NewNodeExclusion([]string{"pool in gpu"}, nil); err == nil {
+		t.Fatalf("expect invalid management selector rejected")
+	}
+	if _, err := utils.NewNodeExclusion(nil, []string{"=x"}); err == nil {
+		t.Fatalf("expect invalid balancer selector rejected")
+	}
+}
+
+func TestNodeExclusionPredicate(t *testing.T) {
+	exclusion, err := utils.NewNodeExclusion(nil, []string{"role=egress"})
+	if err != nil {
+		t.Fatalf("node exclusion: %s", err.Error())
+	}
+	defer func(origin utils.NodeExclusion) { utils.NodeExclusions = origin }(utils.NodeExclusions)
+	utils.NodeExclusions = exclusion
+
+	predicate, err := NodeConditionPredicate(testService(nil))
+	if err != nil {
+		t.Fatalf("predicate: %s", err.Error())
+	}
+	if predicate(labeledNode("egress", map[string]string{"role": "egress"})) {
+		t.Errorf("expect the node excluded from balancer not a backend")
+	}
+	if !predicate(labeledNode("worker", map[string]string{"role": "worker"})) {
+		t.Errorf("expect the worker node a backend")
+	}
+	if utils.IsExcludedNode(labeledNode("egress", map[string]string{"role": "egress"})) {
+		t.Errorf("expect the node excluded from balancer still managed")
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"time"
+)
+
+// updateGauges refresh the gauges of the loadbalancers after the sync of the
+// service of key k. They are derived from the local context, which holds the
+// services synced successfully, and from the result of the syncs, no api is
+// called for them. The backends of a service are set by the ensure itself,
+// and the time of its last successful sync is kept by LastSyncs.
+func (con *Controller) updateGauges(k string, failed bool) {
+	if failed {
+		con.failing.Store(k, true)
+	} else {
+		con.failing.Delete(k)
+	}
+	failing := 0
+	con.failing.Range(
+		func(_, _ interface{}) bool {
+			failing++
+			return true
+		},
+	)
+	metric.ReconcileFailing.WithLabelValues(RECONCILE_CONTROLLER).Set(float64(failing))
+
+	if svc := con.local.Get(k); svc == nil || !NeedLoadBalancer(svc) {
+		// deleted, released or no longer of type LoadBalancer
+		metric.SLBBackends.DeleteLabelValues(k)
+		metric.SLBSingleZoneBackends.DeleteLabelValues(k)
+		con.backends.remove(k)
+		LastSyncs.forget(k)
+	} else if !failed {
+		LastSyncs.succeeded(k, time.Now())
+	}
+	managed := 0
+	con.local.Range(
+		func(_ string, svc *v1.Service) bool {
+			if NeedLoadBalancer(svc) {
+				managed++
+			}
+			return true
+		},
+	)
+	metric.SLBManaged.Set(float64(managed))
+}
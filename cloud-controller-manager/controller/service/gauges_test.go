@@ -0,0 +1,70 @@
+package service
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"testing"
+)
+
+func TestLoadBalancerGauges(t *testing.T) {
+	foo, bar := testService(nil), testService(nil)
+	foo.Name, bar.Name = "foo", "bar"
+	client := fake.NewSimpleClientset(foo, bar)
+	ifactory := informers.NewSharedInformerFactory(client, 0)
+	indexer := ifactory.Core().V1().Services().Informer().GetIndexer()
+	for _, svc := range []interface{}{foo, bar} {
+		if err := indexer.Add(svc); err != nil {
+			t.Fatalf("add service: %s", err.Error())
+		}
+	}
+	cloud := &fakeCloud{}
+	con := &Controller{
+		cloud:    cloud,
+		client:   client,
+		ifactory: ifactory,
+		local:    &Context{},
+		recorder: record.NewFakeRecorder(100),
+	}
+	expect := func(step string, managed, failing float64, backends map[string]float64) {
+		if got := testutil.ToFloat64(metric.SLBManaged); got != managed {
+			t.Errorf("%s: expect ccm_managed_loadbalancers %v, got %v", step, managed, got)
+		}
+		if got := testutil.ToFloat64(metric.ReconcileFailing.WithLabelValues(RECONCILE_CONTROLLER)); got != failing {
+			t.Errorf("%s: expect ccm_reconcile_failing %v, got %v", step, failing, got)
+		}
+		for k, count := range backends {
+			if got := testutil.ToFloat64(metric.SLBBackends.WithLabelValues(k)); got != count {
+				t.Errorf("%s: expect ccm_slb_backends of %s %v, got %v", step, k, count, got)
+			}
+		}
+	}
+	sync := func(svc string, backends int, err error) {
+		cloud.backends, cloud.err = backends, err
+		_ = con.ServiceSyncTask(svc)
+	}
+
+	sync(key(foo), 3, nil)
+	expect("foo created", 1, 0, map[string]float64{key(foo): 3})
+
+	sync(key(bar), 0, fmt.Errorf("ensure listener error"))
+	expect("bar failed", 1, 1, map[string]float64{key(foo): 3})
+
+	sync(key(bar), 5, nil)
+	expect("bar created", 2, 0, map[string]float64{key(foo): 3, key(bar): 5})
+
+	sync(key(foo), 4, nil)
+	expect("foo scaled", 2, 0, map[string]float64{key(foo): 4, key(bar): 5})
+
+	if err := indexer.Delete(foo); err != nil {
+		t.Fatalf("delete service: %s", err.Error())
+	}
+	sync(key(foo), 0, nil)
+	expect("foo deleted", 1, 0, map[string]float64{key(bar): 5})
+	if metric.SLBBackends.DeleteLabelValues(key(foo)) {
+		t.Errorf("expect ccm_slb_backends of the deleted service removed")
+	}
+}
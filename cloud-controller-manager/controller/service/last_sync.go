@@ -0,0 +1,127 @@
+package service
+
+import (
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"sync"
+	"time"
+)
+
+// A service stuck, eg. in backoff, failing its validation or left by a
+// partitioned controller, is told by the age of its last successful sync.
+// LastSyncs keeps the time of the last successful sync of each loadbalancer
+// service, set by ServiceSyncTask and dropped once the service is deleted or
+// no longer of type LoadBalancer. The oldest of them is exported by
+// ccm_service_oldest_successful_sync_timestamp_seconds, and each of them by
+// ccm_service_last_successful_sync_timestamp_seconds if
+// SyncTimestampsPerService, as its series grow with the services. The ages
+// are listed at BACKOFF_DEBUG_PATH along with the backoffs. A service not
+// synced successfully since the start is not tracked, see
+// ccm_reconcile_failing.
+
+// LAST_SYNC_SWEEP_PERIOD the period the services gone are swept from LastSyncs
+const LAST_SYNC_SWEEP_PERIOD = 10 * time.Minute
+
+// SyncTimestampsPerService export the time of the last successful sync of
+// each service, disabled by default
+var SyncTimestampsPerService = false
+
+// LastSyncs the time of the last successful sync of the services by key
+var LastSyncs = &lastSyncRegistry{entries: map[string]time.Time{}}
+
+type lastSyncRegistry struct {
+	lock    sync.Mutex
+	entries map[string]time.Time
+}
+
+func (r *lastSyncRegistry) succeeded(key string, at time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries[key] = at
+	if SyncTimestampsPerService {
+		if ns, name, err := cache.SplitMetaNamespaceKey(key); err == nil {
+			metric.ServiceLastSuccessfulSync.WithLabelValues(ns, name).Set(float64(at.Unix()))
+		}
+	}
+	r.updateOldest()
+}
+
+func (r *lastSyncRegistry) forget(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.entries[key]; !ok {
+		return
+	}
+	r.drop(key)
+	r.updateOldest()
+}
+
+// sweep drop the services exists tells gone, eg. whose deletion is given up,
+// returns how many are dropped
+func (r *lastSyncRegistry) sweep(exists func(key string) bool) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	dropped := 0
+	for key := range r.entries {
+		if !exists(key) {
+			r.drop(key)
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		r.updateOldest()
+	}
+	return dropped
+}
+
+func (r *lastSyncRegistry) drop(key string) {
+	delete(r.entries, key)
+	if ns, name, err := cache.SplitMetaNamespaceKey(key); err == nil {
+		metric.ServiceLastSuccessfulSync.DeleteLabelValues(ns, name)
+	}
+}
+
+func (r *lastSyncRegistry) updateOldest() {
+	var oldest time.Time
+	for _, at := range r.entries {
+		if oldest.IsZero() || at.Before(oldest) {
+			oldest = at
+		}
+	}
+	if oldest.IsZero() {
+		metric.ServiceOldestSuccessfulSync.Reset()
+		return
+	}
+	metric.ServiceOldestSuccessfulSync.WithLabelValues().Set(float64(oldest.Unix()))
+}
+
+// Age the time since the last successful sync of the service, false if it
+// is not tracked
+func (r *lastSyncRegistry) Age(key string) (time.Duration, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	at, ok := r.entries[key]
+	if !ok {
+		return 0, false
+	}
+	return time.Since(at), true
+}
+
+// sweepLastSyncs drop the last syncs of the services gone from the informer
+// or no longer of type LoadBalancer, so that their series do not leak when
+// their deletion is not synced.
+func (con *Controller) sweepLastSyncs() {
+	lister := con.ifactory.Core().V1().Services().Lister()
+	dropped := LastSyncs.sweep(func(key string) bool {
+		ns, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			return false
+		}
+		svc, err := lister.Services(ns).Get(name)
+		return err == nil && NeedLoadBalancer(svc)
+	})
+	if dropped > 0 {
+		utils.InfoS("Swept the last syncs of the services gone", "count", dropped)
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"testing"
+	"time"
+)
+
+func TestLastSuccessfulSync(t *testing.T) {
+	defer func(origin bool) { SyncTimestampsPerService = origin }(SyncTimestampsPerService)
+	SyncTimestampsPerService = true
+	LastSyncs = &lastSyncRegistry{entries: map[string]time.Time{}}
+	metric.ServiceLastSuccessfulSync.Reset()
+	metric.ServiceOldestSuccessfulSync.Reset()
+
+	svc := testService(nil)
+	svc.Name = "stale-a"
+	other := testService(nil)
+	other.Name = "stale-b"
+	client := fake.NewSimpleClientset(svc, other)
+	ifactory := informers.NewSharedInformerFactory(client, 0)
+	indexer := ifactory.Core().V1().Services().Informer().GetIndexer()
+	if err := indexer.Add(svc); err != nil {
+		t.Fatalf("add service: %s", err.Error())
+	}
+	if err := indexer.Add(other); err != nil {
+		t.Fatalf("add service: %s", err.Error())
+	}
+	cloud := &fakeCloud{}
+	con := &Controller{
+		cloud:    cloud,
+		client:   client,
+		ifactory: ifactory,
+		local:    &Context{},
+		recorder: record.NewFakeRecorder(100),
+	}
+	// the timestamp exported of the service, 0 if it is not
+	timestamp := func(name string) float64 {
+		if testutil.CollectAndCount(metric.ServiceLastSuccessfulSync) == 0 {
+			return 0
+		}
+		return testutil.ToFloat64(metric.ServiceLastSuccessfulSync.WithLabelValues("default", name))
+	}
+	oldest := func() float64 {
+		if testutil.CollectAndCount(metric.ServiceOldestSuccessfulSync) == 0 {
+			return 0
+		}
+		return testutil.ToFloat64(metric.ServiceOldestSuccessfulSync.WithLabelValues())
+	}
+
+	// a success tracks the service
+	before := time.Now().Unix()
+	if err := con.ServiceSyncTask(key(svc)); err != nil {
+		t.Fatalf("sync service: %s", err.Error())
+	}
+	synced := timestamp(svc.Name)
+	if synced < float64(before) || oldest() != synced {
+		t.Fatalf("expect the success exported, got %v and oldest %v", synced, oldest())
+	}
+	if _, ok := LastSyncs.Age(key(svc)); !ok {
+		t.Fatalf("expect the service tracked")
+	}
+
+	// the failure streak keeps the last success
+	LastSyncs.succeeded(key(svc), time.Now().Add(-time.Hour))
+	synced = timestamp(svc.Name)
+	cloud.err = cloudError("Forbidden.RAM")
+	for i := 0; i < 3; i++ {
+		if err := con.ServiceSyncTask(key(svc)); err == nil {
+			t.Fatalf("expect the sync failed")
+		}
+	}
+	if timestamp(svc.Name) != synced {
+		t.Fatalf("expect the last success kept through the failures, got %v -> %v", synced, timestamp(svc.Name))
+	}
+	if age, ok := LastSyncs.Age(key(svc)); !ok || age < time.Hour {
+		t.Fatalf("expect the age of the last success over an hour, got %s", age)
+	}
+
+	// the service in backoff lists the age of its last success
+	Backoffs.requeued(key(svc), REQUEUE_REASON_ERROR, time.Minute)
+	defer Backoffs.forget(key(svc))
+	for _, e := range Backoffs.List() {
+		if e.Key == key(svc) && e.LastSuccessAge != "1h0m0s" {
+			t.Fatalf("expect the age listed with the backoff, got %q", e.LastSuccessAge)
+		}
+	}
+
+	// the other service synced later, the stale one is the oldest
+	cloud.err = nil
+	if err := con.ServiceSyncTask(key(other)); err != nil {
+		t.Fatalf("sync service: %s", err.Error())
+	}
+	if oldest() != synced || timestamp(other.Name) <= synced {
+		t.Fatalf("expect the oldest success %v, got %v", synced, oldest())
+	}
+
+	// the deletion drops the service and its series
+	if err := indexer.Delete(svc); err != nil {
+		t.Fatalf("delete service: %s", err.Error())
+	}
+	if err := con.ServiceSyncTask(key(svc)); err != nil {
+		t.Fatalf("sync deleted service: %s", err.Error())
+	}
+	if _, ok := LastSyncs.Age(key(svc)); ok {
+		t.Fatalf("expect the deleted service dropped")
+	}
+	if count := testutil.CollectAndCount(metric.ServiceLastSuccessfulSync); count != 1 {
+		t.Fatalf("expect the series of the remaining service only, got %d", count)
+	}
+	if oldest() != timestamp(other.Name) {
+		t.Fatalf("expect the oldest success of the remaining service, got %v", oldest())
+	}
+
+	// the sweep drops the service gone without its deletion synced
+	if err := indexer.Delete(other); err != nil {
+		t.Fatalf("delete service: %s", err.Error())
+	}
+	con.sweepLastSyncs()
+	if _, ok := LastSyncs.Age(key(other)); ok {
+		t.Fatalf("expect the service gone swept")
+	}
+	if testutil.CollectAndCount(metric.ServiceLastSuccessfulSync) != 0 ||
+		testutil.CollectAndCount(metric.ServiceOldestSuccessfulSync) != 0 {
+		t.Fatalf("expect no series left once no service is tracked")
+	}
+}
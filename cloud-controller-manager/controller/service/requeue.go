@@ -0,0 +1,99 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// REQUEUE_CONFLICT_RETRIES the conflicts retried immediately before they back off
+	REQUEUE_CONFLICT_RETRIES = 3
+	// REQUEUE_BASE_DELAY the first delay of the errors backing off per service
+	REQUEUE_BASE_DELAY = 5 * time.Second
+	// REQUEUE_MAX_DELAY the max delay of the errors backing off per service
+	REQUEUE_MAX_DELAY = 5 * time.Minute
+	// REQUEUE_QUOTA_DELAY the delay of the quota errors, which last until the
+	// quota is raised or some loadbalancers are released
+	REQUEUE_QUOTA_DELAY = 5 * time.Minute
+)
+
+// REQUEUE_BUDGET_DELAY the delay of the syncs out of the api budget of their
+// service, the window of the budget set at startup
+var REQUEUE_BUDGET_DELAY = time.Minute
+
+// RequeuePolicy decides when a failed sync of a service is retried by the
+// class of its error, injected into WorkerFunc.
+type RequeuePolicy interface {
+	// When the delay before the key is synced again, false not to requeue it
+	// until the service changes
+	When(key interface{}, result SyncResult) (time.Duration, bool)
+	// Forget reset the backoff of the key once it is synced
+	Forget(key interface{})
+}
+
+// NewRequeuePolicy the policy requeuing:
+//   - the conflicts immediately, backing off after REQUEUE_CONFLICT_RETRIES;
+//   - the throttled syncs with the backoff shared by all the services, as the
+//     throttling is per account;
+//   - the cloud and apiserver errors exponentially per service, from
+//     REQUEUE_BASE_DELAY up to REQUEUE_MAX_DELAY;
+//   - the quota errors after REQUEUE_QUOTA_DELAY;
+//   - the syncs out of the api budget of their service after
+//     REQUEUE_BUDGET_DELAY;
+//   - never the validation errors and the resources not found.
+func NewRequeuePolicy() RequeuePolicy {
+	return &classRequeuePolicy{
+		throttle: NewBackoff(REQUEUE_BASE_DELAY, 1.5),
+		failures: make(map[interface{}]int),
+	}
+}
+
+type classRequeuePolicy struct {
+	lock     sync.Mutex
+	throttle *RequeueBackoff
+	// failures the consecutive failures of each key
+	failures map[interface{}]int
+}
+
+func (p *classRequeuePolicy) When(key interface{}, result SyncResult) (time.Duration, bool) {
+	if !result.Retryable() {
+		p.Forget(key)
+		return 0, false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	failures := p.failures[key]
+	p.failures[key] = failures + 1
+	switch result {
+	case ResultThrottled:
+		return p.throttle.Next(), true
+	case ResultQuotaExceeded:
+		return REQUEUE_QUOTA_DELAY, true
+	case ResultBudgetExceeded:
+		return REQUEUE_BUDGET_DELAY, true
+	case ResultConflict:
+		if failures < REQUEUE_CONFLICT_RETRIES {
+			return 0, true
+		}
+		failures -= REQUEUE_CONFLICT_RETRIES
+	}
+	return exponential(failures), true
+}
+
+func (p *classRequeuePolicy) Forget(key interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.failures, key)
+}
+
+// exponential the delay after the consecutive failures
+func exponential(failures int) time.Duration {
+	delay := REQUEUE_BASE_DELAY
+	for i := 0; i < failures; i++ {
+		delay *= 2
+		if delay >= REQUEUE_MAX_DELAY {
+			return REQUEUE_MAX_DELAY
+		}
+	}
+	return delay
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"fmt"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequeuePolicy(t *testing.T) {
+	policy := NewRequeuePolicy()
+	cases := []struct {
+		name    string
+		result  SyncResult
+		after   time.Duration
+		requeue bool
+	}{
+		{name: "validation error", result: ResultValidationError, requeue: false},
+		{name: "not found", result: ResultNotFound, requeue: false},
+		{name: "quota exceeded", result: ResultQuotaExceeded, after: REQUEUE_QUOTA_DELAY, requeue: true},
+		{name: "api budget exceeded", result: ResultBudgetExceeded, after: REQUEUE_BUDGET_DELAY, requeue: true},
+		{name: "first conflict", result: ResultConflict, after: 0, requeue: true},
+	}
+	for _, c := range cases {
+		after, requeue := policy.When(c.name, c.result)
+		if after != c.after || requeue != c.requeue {
+			t.Errorf("%s: expect %s, %t, got %s, %t", c.name, c.after, c.requeue, after, requeue)
+		}
+	}
+}
+
+func TestRequeuePolicyCloudError(t *testing.T) {
+	policy := NewRequeuePolicy()
+	expects := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second,
+		80 * time.Second, 160 * time.Second, REQUEUE_MAX_DELAY, REQUEUE_MAX_DELAY}
+	for i, expect := range expects {
+		if after, _ := policy.When("default/nginx", ResultCloudError); after != expect {
+			t.Fatalf("failure %d: expect %s, got %s", i, expect, after)
+		}
+	}
+	// the backoff is per service and reset once synced
+	if after, _ := policy.When("default/other", ResultAPIServerError); after != REQUEUE_BASE_DELAY {
+		t.Fatalf("expect the backoff of other services untouched, got %s", after)
+	}
+	policy.Forget("default/nginx")
+	if after, _ := policy.When("default/nginx", ResultCloudError); after != REQUEUE_BASE_DELAY {
+		t.Fatalf("expect the backoff reset once synced, got %s", after)
+	}
+}
+
+func TestRequeuePolicyConflict(t *testing.T) {
+	policy := NewRequeuePolicy()
+	for i := 0; i < REQUEUE_CONFLICT_RETRIES; i++ {
+		if after, requeue := policy.When("default/nginx", ResultConflict); after != 0 || !requeue {
+			t.Fatalf("conflict %d: expect an immediate retry, got %s, %t", i, after, requeue)
+		}
+	}
+	// the conflicts keep coming, eg. a controller fighting over the service
+	if after, _ := policy.When("default/nginx", ResultConflict); after != REQUEUE_BASE_DELAY {
+		t.Fatalf("expect the repeated conflicts backed off, got %s", after)
+	}
+}
+
+func TestRequeuePolicyThrottled(t *testing.T) {
+	policy := NewRequeuePolicy()
+	first, _ := policy.When("default/nginx", ResultThrottled)
+	// the throttling is per account, the backoff is shared by the services
+	second, _ := policy.When("default/other", ResultThrottled)
+	if first <= REQUEUE_BASE_DELAY || second <= first {
+		t.Fatalf("expect the throttled syncs backed off together, got %s then %s", first, second)
+	}
+}
+
+// recordPolicy requeue each key once, recording the results
+type recordPolicy struct {
+	lock    sync.Mutex
+	results []SyncResult
+	forgot  []interface{}
+}
+
+func (p *recordPolicy) When(key interface{}, result SyncResult) (time.Duration, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.results = append(p.results, result)
+	return 0, len(p.results) == 1
+}
+
+func (p *recordPolicy) Forget(key interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.forgot = append(p.forgot, key)
+}
+
+func TestWorkerFuncRequeuePolicy(t *testing.T) {
+	que := workqueue.NewDelayingQueue()
+	policy := &recordPolicy{}
+	syncs := 0
+	done := make(chan struct{})
+	task := func(key string) error {
+		syncs++
+		switch syncs {
+		case 1:
+			return cloudError("ServiceUnavailable")
+		case 2:
+			return fmt.Errorf("%s: bad annotation", utils.InvalidAnnotation)
+		}
+		close(done)
+		return nil
+	}
+	go WorkerFunc(&Context{}, que, task, policy)()
+	defer que.ShutDown()
+
+	que.Add("default/nginx")
+	// requeued once by the policy, then dropped as told
+	time.Sleep(200 * time.Millisecond)
+	que.Add("default/nginx")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expect the service synced again, got %d syncs", syncs)
+	}
+	time.Sleep(100 * time.Millisecond)
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+	if len(policy.results) != 2 ||
+		policy.results[0] != ResultCloudError ||
+		policy.results[1] != ResultValidationError {
+		t.Fatalf("expect the classes of the errors given to the policy, got %v", policy.results)
+	}
+	if len(policy.forgot) != 1 {
+		t.Fatalf("expect the backoff forgot once synced, got %v", policy.forgot)
+	}
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+)
+
+// A fix made out of band, eg. in the console, is not seen by the controller
+// until the service changes, as the sync of an unchanged service skips the
+// loadbalancer by the hash label. Changing the reconcile-timestamp annotation
+// syncs the service at once: the backoff of its failed syncs is dropped and
+// the sync ignores the hash label. The timestamp is recorded along with the
+// hash label once synced, so the following syncs are not forced again.
+
+// reconcileTimestampChanged whether the reconcile timestamp of the service is
+// changed to one not synced yet
+func reconcileTimestampChanged(old, cur *v1.Service) bool {
+	return old.Annotations[utils.ServiceAnnotationReconcileTimestamp] !=
+		cur.Annotations[utils.ServiceAnnotationReconcileTimestamp] &&
+		utils.ReconcileRequested(cur)
+}
+
+// reconcileNow drop the backoff of the former failures of the service, the
+// service enqueued next is synced at once
+func (con *Controller) reconcileNow(svc *v1.Service) {
+	utils.ServiceLogger(svc).Info("Reconcile requested, sync the service at once",
+		"timestamp", svc.Annotations[utils.ServiceAnnotationReconcileTimestamp])
+	if con.policy != nil {
+		con.policy.Forget(key(svc))
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"testing"
+	"time"
+)
+
+const reconcileTimestamp = "2020-10-16T08:00:00Z"
+
+func reconcileService(requested, synced string) *v1.Service {
+	svc := testService(nil)
+	if requested != "" {
+		svc.Annotations[utils.ServiceAnnotationReconcileTimestamp] = requested
+	}
+	if synced != "" {
+		svc.Annotations[utils.ServiceAnnotationReconcileTimestampResult] = synced
+	}
+	return svc
+}
+
+func TestReconcileRequested(t *testing.T) {
+	cases := []struct {
+		name      string
+		requested string
+		synced    string
+		expect    bool
+	}{
+		{name: "none"},
+		{name: "requested", requested: reconcileTimestamp, expect: true},
+		{name: "synced", requested: reconcileTimestamp, synced: reconcileTimestamp},
+		{name: "requested again", requested: "2020-10-16T09:00:00Z", synced: reconcileTimestamp, expect: true},
+	}
+	for _, c := range cases {
+		if got := utils.ReconcileRequested(reconcileService(c.requested, c.synced)); got != c.expect {
+			t.Errorf("%s: expect reconcile requested %t, got %t", c.name, c.expect, got)
+		}
+	}
+
+	// the timestamp triggers a sync but is not hashed, the synced one neither
+	svc := reconcileService("", "")
+	hash, err := utils.GetServiceHash(svc)
+	if err != nil {
+		t.Fatalf("get service hash: %s", err.Error())
+	}
+	requested := reconcileService(reconcileTimestamp, "")
+	synced := reconcileService(reconcileTimestamp, reconcileTimestamp)
+	for _, s := range []*v1.Service{requested, synced} {
+		if got, _ := utils.GetServiceHash(s); got != hash {
+			t.Errorf("expect the hash unchanged by the reconcile timestamp, got %v", s.Annotations)
+		}
+	}
+	if !NeedUpdate(svc, requested, record.NewFakeRecorder(10)) {
+		t.Errorf("expect an update for the reconcile timestamp")
+	}
+	if NeedUpdate(requested, synced, record.NewFakeRecorder(10)) {
+		t.Errorf("expect no update for the synced reconcile timestamp")
+	}
+	if !reconcileTimestampChanged(svc, requested) || reconcileTimestampChanged(requested, synced) {
+		t.Errorf("expect only the timestamp requested to reconcile")
+	}
+}
+
+func TestReconcileIgnoreHash(t *testing.T) {
+	svc := reconcileService(reconcileTimestamp, "")
+	client := fake.NewSimpleClientset(svc)
+	cloud := &fakeCloud{}
+	con := &Controller{
+		cloud:    cloud,
+		client:   client,
+		ifactory: informers.NewSharedInformerFactory(client, 0),
+		local:    &Context{},
+		recorder: record.NewFakeRecorder(100),
+	}
+	get := func() *v1.Service {
+		updated, err := client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get service: %s", err.Error())
+		}
+		return updated
+	}
+
+	// the sync requested ignores the hash, and records the timestamp synced
+	if err := con.update(nil, svc); err != nil {
+		t.Fatalf("update: %s", err.Error())
+	}
+	synced := get()
+	if got := synced.Annotations[utils.ServiceAnnotationReconcileTimestampResult]; got != reconcileTimestamp {
+		t.Fatalf("expect the timestamp %s synced, got [%s]", reconcileTimestamp, got)
+	}
+	if changed, err := utils.IsServiceHashChanged(synced); err != nil || changed {
+		t.Fatalf("expect the hash label up to date, got %t, %v", changed, err)
+	}
+
+	// the following syncs do not loop
+	if err := con.update(svc, synced); err != nil {
+		t.Fatalf("update: %s", err.Error())
+	}
+	if len(cloud.forced) != 2 || !cloud.forced[0] || cloud.forced[1] {
+		t.Fatalf("expect only the sync requested forced, got %v", cloud.forced)
+	}
+
+	// a failed sync records nothing, the retry is forced as well
+	requested := synced.DeepCopy()
+	requested.Annotations[utils.ServiceAnnotationReconcileTimestamp] = "2020-10-16T09:00:00Z"
+	cloud.err = cloudError("ServiceUnavailable")
+	if err := con.update(synced, requested); err == nil {
+		t.Fatalf("expect the sync failed")
+	}
+	if got := get().Annotations[utils.ServiceAnnotationReconcileTimestampResult]; got != reconcileTimestamp {
+		t.Fatalf("expect the timestamp of the failed sync not recorded, got [%s]", got)
+	}
+	cloud.err = nil
+	if err := con.update(synced, requested); err != nil {
+		t.Fatalf("update: %s", err.Error())
+	}
+	if !cloud.forced[2] || !cloud.forced[3] {
+		t.Fatalf("expect the retry forced, got %v", cloud.forced)
+	}
+}
+
+func TestReconcileBypassBackoff(t *testing.T) {
+	svc := reconcileService("", "")
+	client := fake.NewSimpleClientset(svc)
+	ifactory := informers.NewSharedInformerFactory(client, 0)
+	que := workqueue.NewDelayingQueue()
+	defer que.ShutDown()
+	policy := NewRequeuePolicy()
+	con := &Controller{client: client, ifactory: ifactory, policy: policy}
+	con.HandlerForServiceChange(&Context{}, que, ifactory.Core().V1().Services().Informer(), record.NewFakeRecorder(100))
+	stop := make(chan struct{})
+	defer close(stop)
+	ifactory.Start(stop)
+	ifactory.WaitForCacheSync(stop)
+
+	next := func() interface{} {
+		got := make(chan interface{}, 1)
+		go func() {
+			k, _ := que.Get()
+			que.Done(k)
+			got <- k
+		}()
+		select {
+		case k := <-got:
+			return k
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expect the service enqueued")
+		}
+		return nil
+	}
+	k := next()
+
+	// the failed syncs back off
+	var after time.Duration
+	for i := 0; i < 3; i++ {
+		after, _ = policy.When(k, ResultCloudError)
+	}
+	que.AddAfter(k, after)
+
+	update := func(mutate func(svc *v1.Service)) {
+		updated, err := client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get service: %s", err.Error())
+		}
+		mutate(updated)
+		if _, err := client.CoreV1().Services(svc.Namespace).Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("update service: %s", err.Error())
+		}
+	}
+	update(func(svc *v1.Service) { svc.Annotations[utils.ServiceAnnotationReconcileTimestamp] = reconcileTimestamp })
+	if got := next(); got != k {
+		t.Fatalf("expect %s synced at once, got %v", k, got)
+	}
+	if delay, _ := policy.When(k, ResultCloudError); delay != REQUEUE_BASE_DELAY {
+		t.Fatalf("expect the backoff dropped, got %s", delay)
+	}
+
+	// the timestamp recorded by the sync enqueues nothing
+	update(func(svc *v1.Service) {
+		svc.Annotations[utils.ServiceAnnotationReconcileTimestampResult] = reconcileTimestamp
+	})
+	time.Sleep(200 * time.Millisecond)
+	if que.Len() != 0 {
+		t.Fatalf("expect no sync for the timestamp recorded, got %d queued", que.Len())
+	}
+}
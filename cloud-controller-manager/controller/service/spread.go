@@ -0,0 +1,59 @@
+package service
+
+import (
+	"math/rand"
+	"time"
+
+	"k8s.io/api/core/v1"
+	queue "k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+)
+
+// After a restart or a leader failover, the informer lists every service and
+// the syncs of all the loadbalancers hit the api throttling at once, delaying
+// the urgent changes. The services listed at the start are enqueued with a
+// random delay within the startup spread instead, while the services created
+// or changed later are enqueued at once. The unchanged services are skipped
+// by the hash label, so a spread sync is cheap.
+
+// StartupSpread the window over which the services listed at the start of the
+// controller are enqueued, 0 enqueues them at once
+var StartupSpread time.Duration
+
+// startupSpread delays the enqueues of the services listed at the start
+type startupSpread struct {
+	// window the services are spread over, 0 disables the spread
+	window time.Duration
+	// start when the controller started
+	start time.Time
+	// jitter a random delay within the window
+	jitter func(window time.Duration) time.Duration
+}
+
+func newStartupSpread(window time.Duration) startupSpread {
+	return startupSpread{
+		window: window,
+		start:  time.Now(),
+		jitter: func(window time.Duration) time.Duration {
+			return time.Duration(rand.Int63n(int64(window)))
+		},
+	}
+}
+
+// delay the delay of the added service, false if the service is enqueued at
+// once. The services listed at the start are those created before the start
+// and added within the window, a service created later is a live one.
+func (s startupSpread) delay(svc *v1.Service) (time.Duration, bool) {
+	if s.window <= 0 ||
+		time.Since(s.start) >= s.window ||
+		!svc.CreationTimestamp.Time.Before(s.start) {
+		return 0, false
+	}
+	return s.jitter(s.window), true
+}
+
+// EnqueueAfter enqueue the service after the delay
+func EnqueueAfter(queue queue.DelayingInterface, k interface{}, after time.Duration) {
+	utils.V(utils.LOG_SYNC_VERBOSITY).Info("Enqueue service after", utils.LogKeyService, k, "after", after)
+	queue.AddAfter(k.(string), after)
+}
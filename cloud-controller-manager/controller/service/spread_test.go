@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// enqueue an Add or AddAfter of a key
+type enqueue struct {
+	key   interface{}
+	after time.Duration
+	// delayed by AddAfter
+	delayed bool
+}
+
+// recordQueue records the enqueues of the keys
+type recordQueue struct {
+	workqueue.DelayingInterface
+	enqueues chan enqueue
+}
+
+func (q *recordQueue) Add(item interface{}) {
+	q.enqueues <- enqueue{key: item}
+}
+
+func (q *recordQueue) AddAfter(item interface{}, after time.Duration) {
+	q.enqueues <- enqueue{key: item, after: after, delayed: true}
+}
+
+func (q *recordQueue) Len() int {
+	return len(q.enqueues)
+}
+
+func (q *recordQueue) next(t *testing.T) enqueue {
+	select {
+	case e := <-q.enqueues:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expect the service enqueued")
+	}
+	return enqueue{}
+}
+
+func TestStartupSpreadDelay(t *testing.T) {
+	start := time.Now()
+	jitter := func(window time.Duration) time.Duration { return window / 2 }
+	created := func(at time.Time) *v1.Service {
+		svc := testService(nil)
+		svc.CreationTimestamp = metav1.NewTime(at)
+		return svc
+	}
+	cases := []struct {
+		name   string
+		spread startupSpread
+		svc    *v1.Service
+		expect time.Duration
+	}{
+		{
+			name:   "disabled",
+			spread: startupSpread{start: start, jitter: jitter},
+			svc:    created(start.Add(-time.Hour)),
+		},
+		{
+			name:   "listed at start",
+			spread: startupSpread{window: 2 * time.Minute, start: start, jitter: jitter},
+			svc:    created(start.Add(-time.Hour)),
+			expect: time.Minute,
+		},
+		{
+			name:   "created after start",
+			spread: startupSpread{window: 2 * time.Minute, start: start, jitter: jitter},
+			svc:    created(start.Add(time.Second)),
+		},
+		{
+			name:   "window passed",
+			spread: startupSpread{window: 2 * time.Minute, start: start.Add(-3 * time.Minute), jitter: jitter},
+			svc:    created(start.Add(-time.Hour)),
+		},
+	}
+	for _, c := range cases {
+		after, spread := c.spread.delay(c.svc)
+		if after != c.expect || spread != (c.expect > 0) {
+			t.Fatalf("%s: expect delay %s, got %s spread=%t", c.name, c.expect, after, spread)
+		}
+	}
+}
+
+func TestStartupSpreadEnqueue(t *testing.T) {
+	svc := testService(nil)
+	svc.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	client := fake.NewSimpleClientset(svc)
+	ifactory := informers.NewSharedInformerFactory(client, 0)
+	que := &recordQueue{enqueues: make(chan enqueue, 10)}
+	con := &Controller{
+		client:   client,
+		ifactory: ifactory,
+		spread: startupSpread{
+			window: 2 * time.Minute,
+			start:  time.Now(),
+			jitter: func(window time.Duration) time.Duration { return 30 * time.Second },
+		},
+	}
+	con.HandlerForServiceChange(&Context{}, que, ifactory.Core().V1().Services().Informer(), record.NewFakeRecorder(100))
+	stop := make(chan struct{})
+	defer close(stop)
+	ifactory.Start(stop)
+	ifactory.WaitForCacheSync(stop)
+
+	// the service listed at start is delayed
+	if e := que.next(t); !e.delayed || e.after != 30*time.Second || e.key != key(svc) {
+		t.Fatalf("expect %s enqueued after 30s, got %+v", key(svc), e)
+	}
+
+	// a live edit is enqueued at once
+	updated, err := client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get service: %s", err.Error())
+	}
+	updated.Spec.Ports = []v1.ServicePort{{Port: 80, NodePort: 30080}}
+	if _, err := client.CoreV1().Services(svc.Namespace).Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update service: %s", err.Error())
+	}
+	if e := que.next(t); e.delayed || e.key != key(svc) {
+		t.Fatalf("expect %s enqueued at once, got %+v", key(svc), e)
+	}
+
+	// a service created after the start is enqueued at once
+	created := testService(nil)
+	created.Name = "created"
+	created.CreationTimestamp = metav1.NewTime(time.Now().Add(time.Second))
+	if _, err := client.CoreV1().Services(created.Namespace).Create(context.TODO(), created, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create service: %s", err.Error())
+	}
+	if e := que.next(t); e.delayed || e.key != key(created) {
+		t.Fatalf("expect %s enqueued at once, got %+v", key(created), e)
+	}
+}
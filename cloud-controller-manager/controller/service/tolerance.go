@@ -0,0 +1,66 @@
+package service
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strconv"
+	"time"
+)
+
+// A kubelet restart flips the node NotReady for a while, removing the node
+// from the backends resets its connections. With the not-ready-tolerance-seconds
+// annotation a node NotReady for less than the tolerance, by the last
+// transition of its Ready condition, is kept. The service is synced again when
+// the tolerance is over. A node being deleted is never kept.
+
+// MAX_NOT_READY_TOLERANCE_SECONDS max tolerance of the not-ready-tolerance-seconds annotation
+const MAX_NOT_READY_TOLERANCE_SECONDS = 3600
+
+// NotReadyTolerance tolerance of the NotReady nodes of the service, 0 if none
+func NotReadyTolerance(svc *v1.Service) time.Duration {
+	value, ok := svc.Annotations[utils.ServiceAnnotationLoadBalancerNotReadyToleranceSeconds]
+	if !ok {
+		return 0
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 || seconds > MAX_NOT_READY_TOLERANCE_SECONDS {
+		utils.ServiceLogger(svc).Warning("Not ready tolerance disabled, the annotation must be an integer in range",
+			"annotation", utils.ServiceAnnotationLoadBalancerNotReadyToleranceSeconds,
+			"max", MAX_NOT_READY_TOLERANCE_SECONDS, "value", value)
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// notReadyToleranceLeft time the NotReady node is still kept as backend, 0
+// if it is not kept.
+func notReadyToleranceLeft(svc *v1.Service, node *v1.Node, cond v1.NodeCondition, now time.Time) time.Duration {
+	tolerance := NotReadyTolerance(svc)
+	if tolerance <= 0 || node.DeletionTimestamp != nil || cond.LastTransitionTime.IsZero() {
+		return 0
+	}
+	left := tolerance - now.Sub(cond.LastTransitionTime.Time)
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// NotReadyToleranceRecheck delay after which the service is synced again to
+// remove the tolerated NotReady nodes, 0 if none of the nodes is tolerated.
+func NotReadyToleranceRecheck(svc *v1.Service, nodes []*v1.Node) time.Duration {
+	var recheck time.Duration
+	now := time.Now()
+	for _, node := range nodes {
+		for _, cond := range node.Status.Conditions {
+			if cond.Type != v1.NodeReady || cond.Status == v1.ConditionTrue {
+				continue
+			}
+			left := notReadyToleranceLeft(svc, node, cond, now)
+			if left > 0 && (recheck == 0 || left < recheck) {
+				recheck = left
+			}
+		}
+	}
+	return recheck
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"testing"
+	"time"
+)
+
+// readyNode node of the Ready condition status, transited the duration ago
+func readyNode(name string, status v1.ConditionStatus, ago time.Duration) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{
+					Type:               v1.NodeReady,
+					Status:             status,
+					LastTransitionTime: metav1.NewTime(time.Now().Add(-ago)),
+				},
+			},
+		},
+	}
+}
+
+func TestNotReadyTolerance(t *testing.T) {
+	now := metav1.Now()
+	deleting := readyNode("deleting", v1.ConditionFalse, 10*time.Second)
+	deleting.DeletionTimestamp = &now
+	cases := []struct {
+		name      string
+		tolerance string
+		node      *v1.Node
+		available bool
+	}{
+		{name: "ready", tolerance: "60", node: readyNode("node", v1.ConditionTrue, time.Hour), available: true},
+		{name: "no tolerance", node: readyNode("node", v1.ConditionFalse, 10*time.Second)},
+		{name: "invalid tolerance", tolerance: "1m", node: readyNode("node", v1.ConditionFalse, 10*time.Second)},
+		{name: "within tolerance", tolerance: "60", node: readyNode("node", v1.ConditionFalse, 10*time.Second), available: true},
+		{name: "unknown within tolerance", tolerance: "60", node: readyNode("node", v1.ConditionUnknown, 30*time.Second), available: true},
+		{name: "beyond tolerance", tolerance: "60", node: readyNode("node", v1.ConditionFalse, 90*time.Second)},
+		{name: "deleting", tolerance: "60", node: deleting},
+	}
+	for _, c := range cases {
+		svc := testService(nil)
+		if c.tolerance != "" {
+			svc.Annotations[utils.ServiceAnnotationLoadBalancerNotReadyToleranceSeconds] = c.tolerance
+		}
+		predicate, err := NodeConditionPredicate(svc)
+		if err != nil {
+			t.Fatalf("%s: predicate: %s", c.name, err.Error())
+		}
+		if available := predicate(c.node); available != c.available {
+			t.Errorf("%s: expect available %t, got %t", c.name, c.available, available)
+		}
+	}
+}
+
+func TestNotReadyToleranceFlapping(t *testing.T) {
+	svc := testService(map[string]string{utils.ServiceAnnotationLoadBalancerNotReadyToleranceSeconds: "60"})
+	predicate, err := NodeConditionPredicate(svc)
+	if err != nil {
+		t.Fatalf("predicate: %s", err.Error())
+	}
+	// NotReady beyond the tolerance, the node is removed
+	node := readyNode("node", v1.ConditionFalse, 2*time.Minute)
+	if predicate(node) {
+		t.Fatalf("expect the node NotReady beyond tolerance removed")
+	}
+	// Ready again and then NotReady, the tolerance starts over
+	node = readyNode("node", v1.ConditionTrue, 5*time.Second)
+	if !predicate(node) {
+		t.Fatalf("expect the ready node available")
+	}
+	node = readyNode("node", v1.ConditionFalse, time.Second)
+	if !predicate(node) {
+		t.Fatalf("expect the node flapping to NotReady kept within tolerance")
+	}
+
+	// the service is synced again when the earliest tolerance is over
+	nodes := []*v1.Node{
+		readyNode("ready", v1.ConditionTrue, time.Hour),
+		readyNode("flapped", v1.ConditionFalse, 10*time.Second),
+		readyNode("flapped-earlier", v1.ConditionFalse, 40*time.Second),
+	}
+	recheck := NotReadyToleranceRecheck(svc, nodes)
+	if recheck < 15*time.Second || recheck > 20*time.Second {
+		t.Errorf("expect recheck in about 20s, got %s", recheck)
+	}
+	if recheck := NotReadyToleranceRecheck(testService(nil), nodes); recheck != 0 {
+		t.Errorf("expect no recheck without tolerance, got %s", recheck)
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"sort"
+	"strings"
+)
+
+// BackendZones zones of the backend-zones annotation, none for all zones
+func BackendZones(svc *v1.Service) []string {
+	var zones []string
+	for _, zone := range strings.Split(svc.Annotations[utils.ServiceAnnotationLoadBalancerBackendZones], ",") {
+		zone = strings.TrimSpace(zone)
+		if zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// NodeZone zone of the node by the topology label, or the deprecated
+// failure-domain label.
+func NodeZone(node *v1.Node) string {
+	return utils.NodeZone(node)
+}
+
+// FilterNodesByZones the nodes in the backend zones of the service. All the
+// nodes are kept when none of them is in the zones, a loadbalancer without
+// backends is worse than one across zones.
+func FilterNodesByZones(svc *v1.Service, nodes []*v1.Node, recorder record.EventRecorder) []*v1.Node {
+	zones := BackendZones(svc)
+	if len(zones) == 0 {
+		return nodes
+	}
+	wanted := map[string]bool{}
+	for _, zone := range zones {
+		wanted[zone] = true
+	}
+	var filtered []*v1.Node
+	for _, node := range nodes {
+		if wanted[NodeZone(node)] {
+			filtered = append(filtered, node)
+			continue
+		}
+		utils.ServiceLogger(svc).V(utils.LOG_SYNC_VERBOSITY).Info("Ignore node out of the backend zones",
+			utils.LogKeyNode, node.Name, "zones", zones)
+	}
+	if len(filtered) == 0 && len(nodes) != 0 {
+		if recorder != nil {
+			recorder.Eventf(
+				svc,
+				v1.EventTypeWarning,
+				"NoNodesInBackendZones",
+				"There are no available nodes in backend zones %s, fall back to nodes of all zones",
+				strings.Join(zones, ","),
+			)
+		}
+		return nodes
+	}
+	return filtered
+}
+
+// SingleZoneBackends the zone of the backends when they are all in a single
+// zone while there are ready nodes in the other zones, and those zones. The
+// zone is empty when the backends span the zones, or there is no other zone
+// to span. Only the backend zones of the annotation are considered, a
+// service restricted to a single zone is never warned.
+func SingleZoneBackends(svc *v1.Service, backends map[string]int, nodes []*v1.Node) (string, []string) {
+	if len(backends) != 1 {
+		return "", nil
+	}
+	var zone string
+	for z := range backends {
+		zone = z
+	}
+	wanted := map[string]bool{}
+	for _, z := range BackendZones(svc) {
+		wanted[z] = true
+	}
+	others := map[string]bool{}
+	for _, node := range nodes {
+		z := NodeZone(node)
+		if z == "" || z == zone || (len(wanted) != 0 && !wanted[z]) {
+			continue
+		}
+		if utils.IsExcludedFromBalancer(node) || !isNodeReady(node) {
+			continue
+		}
+		others[z] = true
+	}
+	if len(others) == 0 {
+		return "", nil
+	}
+	var zones []string
+	for z := range others {
+		zones = append(zones, z)
+	}
+	sort.Strings(zones)
+	return zone, zones
+}
+
+func isNodeReady(node *v1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// checkBackendZones warn when all the backends of the service just ensured
+// are in a single zone, eg. after the other zones were drained, as the
+// loadbalancer is down with that zone. It is informational, the sync is not
+// failed. Nothing is checked when the backend zones were not published.
+func (con *Controller) checkBackendZones(svc *v1.Service, backends map[string]int) {
+	if backends == nil {
+		return
+	}
+	nodes, err := con.ifactory.Core().V1().Nodes().Lister().List(labels.Everything())
+	if err != nil {
+		utils.ServiceLogger(svc).Warning("List nodes for the backend zones", "error", err.Error())
+		return
+	}
+	zone, others := SingleZoneBackends(svc, backends, nodes)
+	if zone == "" {
+		metric.SLBSingleZoneBackends.WithLabelValues(key(svc)).Set(0)
+		return
+	}
+	metric.SLBSingleZoneBackends.WithLabelValues(key(svc)).Set(1)
+	con.recorder.Eventf(
+		svc,
+		v1.EventTypeWarning,
+		"SingleZoneBackends",
+		"All the %d backends are in zone %s while there are ready nodes in zones %s",
+		backends[zone], zone, strings.Join(others, ","),
+	)
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func zoneNode(name, zone string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{utils.LabelTopologyZone: zone},
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+func TestAvailableNodesInZones(t *testing.T) {
+	notReady := zoneNode("node-h-notready", "cn-hangzhou-h")
+	notReady.Status.Conditions[0].Status = v1.ConditionFalse
+	client := fake.NewSimpleClientset(
+		zoneNode("node-h", "cn-hangzhou-h"),
+		zoneNode("node-i", "cn-hangzhou-i"),
+		zoneNode("node-j", "cn-hangzhou-j"),
+		notReady,
+	)
+	ifactory := informers.NewSharedInformerFactory(client, 0)
+	ifactory.Core().V1().Nodes().Informer()
+	stop := make(chan struct{})
+	defer close(stop)
+	ifactory.Start(stop)
+	ifactory.WaitForCacheSync(stop)
+
+	available := func(zones string, recorder record.EventRecorder) string {
+		svc := testService(map[string]string{utils.ServiceAnnotationLoadBalancerBackendZones: zones})
+		nodes, err := AvailableNodes(svc, ifactory, recorder)
+		if err != nil {
+			t.Fatalf("available nodes: %s", err.Error())
+		}
+		var names []string
+		for _, node := range nodes {
+			names = append(names, node.Name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	if names := available("", recorder); names != "node-h,node-i,node-j" {
+		t.Fatalf("expect ready nodes of all zones, got %s", names)
+	}
+	if names := available("cn-hangzhou-h, cn-hangzhou-i", recorder); names != "node-h,node-i" {
+		t.Fatalf("expect ready nodes in backend zones, got %s", names)
+	}
+	if len(recorder.Events) != 0 {
+		t.Fatalf("expect no events, got %d", len(recorder.Events))
+	}
+
+	// no node in the zones, fall back to all of them
+	if names := available("cn-hangzhou-k", recorder); names != "node-h,node-i,node-j" {
+		t.Fatalf("expect fallback to ready nodes of all zones, got %s", names)
+	}
+	if len(recorder.Events) != 1 || !strings.Contains(<-recorder.Events, "NoNodesInBackendZones") {
+		t.Fatalf("expect a warning event of the fallback")
+	}
+}
+
+func TestNodeZone(t *testing.T) {
+	deprecated := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node",
+			Labels: map[string]string{utils.LabelFailureDomainZone: "cn-hangzhou-h"},
+		},
+	}
+	if zone := NodeZone(deprecated); zone != "cn-hangzhou-h" {
+		t.Fatalf("expect zone of the failure-domain label, got %s", zone)
+	}
+	deprecated.Labels[utils.LabelTopologyZone] = "cn-hangzhou-i"
+	if zone := NodeZone(deprecated); zone != "cn-hangzhou-i" {
+		t.Fatalf("expect zone of the topology label, got %s", zone)
+	}
+}
+
+func TestNodeZoneChanged(t *testing.T) {
+	a := zoneNode("node", "cn-hangzhou-h")
+	if NodeSpecChanged(a, a.DeepCopy()) {
+		t.Fatalf("expect node unchanged")
+	}
+	b := a.DeepCopy()
+	b.Labels[utils.LabelTopologyZone] = "cn-hangzhou-i"
+	if !NodeSpecChanged(a, b) {
+		t.Fatalf("expect zone label change to trigger reconciliation")
+	}
+}
+
+func TestSingleZoneBackends(t *testing.T) {
+	notReady := zoneNode("node-k", "cn-hangzhou-k")
+	notReady.Status.Conditions[0].Status = v1.ConditionFalse
+	excluded := zoneNode("node-l", "cn-hangzhou-l")
+	excluded.Labels[utils.LabelNodeRoleExcludeBalancer] = "true"
+	nodes := []*v1.Node{
+		zoneNode("node-h", "cn-hangzhou-h"),
+		zoneNode("node-i", "cn-hangzhou-i"),
+		zoneNode("node-j", "cn-hangzhou-j"),
+		notReady,
+		excluded,
+	}
+	for _, c := range []struct {
+		name     string
+		zones    string
+		backends map[string]int
+		nodes    []*v1.Node
+		zone     string
+		others   string
+	}{
+		{name: "single zone", backends: map[string]int{"cn-hangzhou-h": 3}, nodes: nodes,
+			zone: "cn-hangzhou-h", others: "cn-hangzhou-i,cn-hangzhou-j"},
+		{name: "spread over zones", backends: map[string]int{"cn-hangzhou-h": 3, "cn-hangzhou-i": 1}, nodes: nodes},
+		{name: "no backends", backends: map[string]int{}, nodes: nodes},
+		{name: "no ready node in other zones", backends: map[string]int{"cn-hangzhou-k": 1}, nodes: nodes[3:]},
+		{name: "single zone cluster", backends: map[string]int{"cn-hangzhou-h": 1}, nodes: nodes[:1]},
+		{name: "restricted to a single zone", zones: "cn-hangzhou-h", backends: map[string]int{"cn-hangzhou-h": 1},
+			nodes: nodes},
+		{name: "single zone of the backend zones", zones: "cn-hangzhou-h,cn-hangzhou-j",
+			backends: map[string]int{"cn-hangzhou-h": 1}, nodes: nodes, zone: "cn-hangzhou-h", others: "cn-hangzhou-j"},
+	} {
+		zone, others := SingleZoneBackends(testService(map[string]string{utils.ServiceAnnotationLoadBalancerBackendZones: c.zones}), c.backends, c.nodes)
+		if zone != c.zone || strings.Join(others, ",") != c.others {
+			t.Errorf("%s: expect zone %q and others %q, got %q and %v", c.name, c.zone, c.others, zone, others)
+		}
+	}
+}
+
+func TestCheckBackendZones(t *testing.T) {
+	client := fake.NewSimpleClientset(zoneNode("node-h", "cn-hangzhou-h"), zoneNode("node-i", "cn-hangzhou-i"))
+	ifactory := informers.NewSharedInformerFactory(client, 0)
+	ifactory.Core().V1().Nodes().Informer()
+	stop := make(chan struct{})
+	defer close(stop)
+	ifactory.Start(stop)
+	ifactory.WaitForCacheSync(stop)
+	recorder := record.NewFakeRecorder(10)
+	con := &Controller{ifactory: ifactory, recorder: recorder}
+	svc := testService(nil)
+	defer metric.SLBSingleZoneBackends.DeleteLabelValues(key(svc))
+
+	con.checkBackendZones(svc, map[string]int{"cn-hangzhou-h": 2})
+	if got := testutil.ToFloat64(metric.SLBSingleZoneBackends.WithLabelValues(key(svc))); got != 1 {
+		t.Fatalf("expect the single zone gauge set, got %v", got)
+	}
+	if len(recorder.Events) != 1 || !strings.Contains(<-recorder.Events, "SingleZoneBackends") {
+		t.Fatalf("expect a warning event of the single zone")
+	}
+
+	con.checkBackendZones(svc, map[string]int{"cn-hangzhou-h": 1, "cn-hangzhou-i": 1})
+	if got := testutil.ToFloat64(metric.SLBSingleZoneBackends.WithLabelValues(key(svc))); got != 0 {
+		t.Fatalf("expect the single zone gauge cleared, got %v", got)
+	}
+	if len(recorder.Events) != 0 {
+		t.Fatalf("expect no event once spread, got %d", len(recorder.Events))
+	}
+}
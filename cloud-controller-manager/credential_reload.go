@@ -0,0 +1,127 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"io/ioutil"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"k8s.io/klog"
+	"time"
+)
+
+// The AccessKey of the cloud config file is rotated by updating the mounted
+// secret. The file is hashed periodically, a changed one is validated before
+// the clients switch to the new AccessKey under the refresh lock. A partially
+// written file is skipped until the write completes.
+
+// CREDENTIAL_WATCH_PERIOD interval the cloud config file is checked for a rotation
+var CREDENTIAL_WATCH_PERIOD = 10 * time.Second
+
+// the results of the credential reloads
+const (
+	RELOAD_SUCCESS = "success"
+	RELOAD_INVALID = "invalid"
+	RELOAD_FAILED  = "failed"
+)
+
+// credentialWatcher detect the content changes of the cloud config file
+type credentialWatcher struct {
+	path string
+	// hash of the content handled last
+	hash string
+}
+
+func newCredentialWatcher(path string) *credentialWatcher {
+	w := &credentialWatcher{path: path}
+	if config, err := ioutil.ReadFile(path); err == nil {
+		w.hash = hashOf(config)
+	}
+	return w
+}
+
+func hashOf(config []byte) string {
+	sum := sha256.Sum256(config)
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAK the AccessKey of the cloud config, empty if absent
+func parseAK(config []byte) (string, string, error) {
+	var conf CloudConfig
+	if err := yaml.Unmarshal(config, &conf); err != nil {
+		return "", "", fmt.Errorf("unmarshal config error: %s", err.Error())
+	}
+	if conf.Global.AccessKeyID == "" || conf.Global.AccessKeySecret == "" {
+		return "", "", nil
+	}
+	key, err := base64.StdEncoding.DecodeString(conf.Global.AccessKeyID)
+	if err != nil {
+		return "", "", fmt.Errorf("decode accessKeyID: %s", err.Error())
+	}
+	secret, err := base64.StdEncoding.DecodeString(conf.Global.AccessKeySecret)
+	if err != nil {
+		return "", "", fmt.Errorf("decode accessKeySecret: %s", err.Error())
+	}
+	return string(key), string(secret), nil
+}
+
+// maskKey the AccessKey id logged to tell the rotations apart
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return key[:4] + "****"
+}
+
+// reloadCredentials apply the AccessKey of the cloud config file to the
+// clients if the file changed. An invalid file is reported once, the clients
+// keep the current AccessKey.
+func (mgr *ClientMgr) reloadCredentials(w *credentialWatcher) {
+	config, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		klog.Warningf("alicloud: read cloud config %s: %s", w.path, err.Error())
+		return
+	}
+	hash := hashOf(config)
+	if hash == w.hash {
+		return
+	}
+	key, secret, err := parseAK(config)
+	if err == nil && (key == "" || secret == "") {
+		err = fmt.Errorf("accessKeyID or accessKeySecret is empty")
+	}
+	if err != nil {
+		klog.Warningf("alicloud: cloud config %s changed, but invalid, "+
+			"keep the current credentials: %s", w.path, err.Error())
+		metric.CredentialReload.WithLabelValues(RELOAD_INVALID).Inc()
+		w.hash = hash
+		return
+	}
+	// retried by the next check on failure
+	if err := mgr.Refresh(); err != nil {
+		klog.Errorf("alicloud: reload credentials from %s: %s", w.path, err.Error())
+		metric.CredentialReload.WithLabelValues(RELOAD_FAILED).Inc()
+		return
+	}
+	klog.Infof("alicloud: credentials reloaded from %s, AccessKey %s", w.path, maskKey(key))
+	metric.CredentialReload.WithLabelValues(RELOAD_SUCCESS).Inc()
+	w.hash = hash
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseAK(t *testing.T) {
+	cases := []struct {
+		config string
+		key    string
+		err    bool
+	}{
+		{config: cloudConfig("key", "secret"), key: "key"},
+		{config: `{"Global":{"clusterID":"c1"}}`},
+		{config: `{"Global":{"accessKeyID":"` + b64.StdEncoding.EncodeToString([]byte("key")), err: true},
+		{config: `{"Global":{"accessKeyID":"a2V5","accessKeySecret":"not base64!"}}`, err: true},
+	}
+	for _, c := range cases {
+		key, _, err := parseAK([]byte(c.config))
+		if c.err != (err != nil) || key != c.key {
+			t.Errorf("%s: expect key [%s], error %t, got [%s], %v", c.config, c.key, c.err, key, err)
+		}
+	}
+}
+
+func cloudConfig(key, secret string) string {
+	return fmt.Sprintf(
+		`{"Global":{"accessKeyID":"%s","accessKeySecret":"%s"}}`,
+		b64.StdEncoding.EncodeToString([]byte(key)),
+		b64.StdEncoding.EncodeToString([]byte(secret)),
+	)
+}
+
+func TestReloadCredentials(t *testing.T) {
+	// the AccessKeyId of the slb calls
+	var (
+		lock sync.Mutex
+		keys []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		keys = append(keys, r.URL.Query().Get("AccessKeyId"))
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"RequestId":"req-1","LoadBalancers":{"LoadBalancer":[]}}`)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "cloud-config")
+	if err != nil {
+		t.Fatalf("temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cloud-config.conf")
+	// replaced by rename as the kubelet updates the mounted secrets
+	write := func(content string) {
+		if err := ioutil.WriteFile(path+".tmp", []byte(content), 0600); err != nil {
+			t.Fatalf("write cloud config: %s", err.Error())
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			t.Fatalf("rename cloud config: %s", err.Error())
+		}
+	}
+	write(cloudConfig("key-1", "secret-1"))
+
+	file, period := CloudConfigFile, CREDENTIAL_WATCH_PERIOD
+	defer func() { CloudConfigFile, CREDENTIAL_WATCH_PERIOD = file, period }()
+	CloudConfigFile, CREDENTIAL_WATCH_PERIOD = path, 50*time.Millisecond
+
+	stop := make(chan struct{})
+	defer close(stop)
+	slbclient := NewContextedClientSLB("", "", string(REGION))
+	slbclient.slb.WithEndpoint(server.URL)
+	mgr := &ClientMgr{
+		stop:         stop,
+		token:        &AkAuthToken{},
+		instance:     &InstanceClient{c: NewContextedClientINS("", "", string(REGION))},
+		loadbalancer: &LoadBalancerClient{c: slbclient},
+		privateZone:  &PrivateZoneClient{c: NewContextedClientPVTZ("", "", string(REGION))},
+		nlb:          &NLBClient{c: NewContextedClientNLB("", "", string(REGION))},
+		routes:       &RoutesClient{client: NewContextedClientRoute("", "", string(REGION))},
+	}
+	if err := mgr.Start(RefreshToken); err != nil {
+		t.Fatalf("start client manager: %s", err.Error())
+	}
+	// the AccessKeyId of a new slb call
+	call := func() (string, error) {
+		_, err := slbclient.DescribeLoadBalancers(context.Background(), &slb.DescribeLoadBalancersArgs{RegionId: REGION})
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil || len(keys) == 0 {
+			return "", fmt.Errorf("describe loadbalancers: %v", err)
+		}
+		return keys[len(keys)-1], nil
+	}
+	if key, err := call(); err != nil || key != "key-1" {
+		t.Fatalf("expect key-1 used, got [%s], %v", key, err)
+	}
+
+	invalid := testutil.ToFloat64(metric.CredentialReload.WithLabelValues(RELOAD_INVALID))
+	success := testutil.ToFloat64(metric.CredentialReload.WithLabelValues(RELOAD_SUCCESS))
+	// the secret is written partially
+	write(`{"Global":{"accessKeyID":"` + b64.StdEncoding.EncodeToString([]byte("key-2")))
+	time.Sleep(5 * CREDENTIAL_WATCH_PERIOD)
+	if key, err := call(); err != nil || key != "key-1" {
+		t.Fatalf("expect key-1 kept with the partial file, got [%s], %v", key, err)
+	}
+	if testutil.ToFloat64(metric.CredentialReload.WithLabelValues(RELOAD_INVALID)) != invalid+1 {
+		t.Fatalf("expect the partial file reported once")
+	}
+
+	write(cloudConfig("key-2", "secret-2"))
+	err = wait.PollImmediate(CREDENTIAL_WATCH_PERIOD, 5*time.Second, func() (bool, error) {
+		key, err := call()
+		return key == "key-2", err
+	})
+	if err != nil {
+		t.Fatalf("expect key-2 used after the rotation: %s", err.Error())
+	}
+	if testutil.ToFloat64(metric.CredentialReload.WithLabelValues(RELOAD_SUCCESS)) != success+1 {
+		t.Fatalf("expect the rotation reloaded once")
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+)
+
+// Domain extensions and server certificates are not wrapped by aliyungo,
+// so the request and response types are declared here and invoked directly.
+
+type DescribeServerCertificatesArgs struct {
+	RegionId            common.Region
+	ServerCertificateId string
+}
+
+type ServerCertificateType struct {
+	ServerCertificateId   string
+	ServerCertificateName string
+	CommonName            string
+}
+
+type DescribeServerCertificatesResponse struct {
+	common.Response
+	ServerCertificates struct {
+		ServerCertificate []ServerCertificateType
+	}
+}
+
+type DescribeDomainExtensionsArgs struct {
+	RegionId       common.Region
+	LoadBalancerId string
+	ListenerPort   int
+}
+
+type DomainExtensionType struct {
+	DomainExtensionId   string
+	Domain              string
+	ServerCertificateId string
+}
+
+type DescribeDomainExtensionsResponse struct {
+	common.Response
+	DomainExtensions struct {
+		DomainExtension []DomainExtensionType
+	}
+}
+
+type CreateDomainExtensionArgs struct {
+	RegionId            common.Region
+	LoadBalancerId      string
+	ListenerPort        int
+	Domain              string
+	ServerCertificateId string
+}
+
+type CreateDomainExtensionResponse struct {
+	common.Response
+	DomainExtensionId string
+	ListenerPort      int
+}
+
+type DeleteDomainExtensionArgs struct {
+	RegionId          common.Region
+	DomainExtensionId string
+}
+
+// additionalCertIDs returns the extra certificates of the comma separated
+// annotation which should be bound to the https listener. A single port sets
+// its own by port-overrides, see ServiceForPort.
+func additionalCertIDs(annotation string) []string {
+	var ids []string
+	for _, v := range strings.Split(annotation, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		ids = append(ids, v)
+	}
+	return ids
+}
+
+// EnsureDomainExtensions make the domain extensions bound to an https listener
+// match the additional cert ids annotation exactly. The primary certificate is
+// never touched.
+func (n *Listener) EnsureDomainExtensions(ctx context.Context) error {
+	if n.TransforedProto != "https" {
+		return nil
+	}
+	_, request := ExtractAnnotationRequest(n.Service)
+	desired := additionalCertIDs(request.AdditionalCertIDs)
+
+	current, err := n.Client.DescribeDomainExtensions(
+		ctx,
+		&DescribeDomainExtensionsArgs{
+			LoadBalancerId: n.LoadBalancerID,
+			ListenerPort:   int(n.Port),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("describe domain extensions for port %d: %s", n.Port, err.Error())
+	}
+
+	want := make(map[string]bool)
+	for _, id := range desired {
+		want[id] = true
+	}
+	bound := make(map[string]bool)
+	// delete first, a replaced certificate usually shares its domain with the new one.
+	for _, ext := range current {
+		if want[ext.ServerCertificateId] && !bound[ext.ServerCertificateId] {
+			bound[ext.ServerCertificateId] = true
+			continue
+		}
+		utils.Logf(n.Service, "delete domain extension %s with cert %s on port %d",
+			ext.DomainExtensionId, ext.ServerCertificateId, n.Port)
+		err := n.Client.DeleteDomainExtension(
+			ctx,
+			&DeleteDomainExtensionArgs{DomainExtensionId: ext.DomainExtensionId},
+		)
+		if err != nil {
+			return fmt.Errorf("delete domain extension %s: %s", ext.DomainExtensionId, err.Error())
+		}
+	}
+
+	for _, id := range desired {
+		if bound[id] {
+			continue
+		}
+		domain, err := n.certificateDomain(ctx, id)
+		if err != nil {
+			return err
+		}
+		utils.Logf(n.Service, "create domain extension %s with cert %s on port %d", domain, id, n.Port)
+		_, err = n.Client.CreateDomainExtension(
+			ctx,
+			&CreateDomainExtensionArgs{
+				LoadBalancerId:      n.LoadBalancerID,
+				ListenerPort:        int(n.Port),
+				Domain:              domain,
+				ServerCertificateId: id,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("create domain extension for cert %s: %s", id, err.Error())
+		}
+		bound[id] = true
+	}
+	return nil
+}
+
+// certificateDomain find the domain a domain extension should use for certificate id.
+func (n *Listener) certificateDomain(ctx context.Context, id string) (string, error) {
+	certs, err := n.Client.DescribeServerCertificates(
+		ctx,
+		&DescribeServerCertificatesArgs{ServerCertificateId: id},
+	)
+	if err != nil {
+		return "", fmt.Errorf("describe server certificate %s: %s", id, err.Error())
+	}
+	for _, cert := range certs {
+		if cert.ServerCertificateId == id && cert.CommonName != "" {
+			return cert.CommonName, nil
+		}
+	}
+	return "", fmt.Errorf("server certificate %s not found or has no common name", id)
+}
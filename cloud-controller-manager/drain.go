@@ -0,0 +1,138 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A backend removed from the vserver group has its connections reset. With
+// the graceful-drain-seconds annotation the backend of a node still in the
+// cluster is set to weight 0 first, and removed when the drain is over. The
+// service is requeued to remove it then.
+
+// MAX_GRACEFUL_DRAIN_SECONDS max drain time of the graceful-drain-seconds annotation
+const MAX_GRACEFUL_DRAIN_SECONDS = 3600
+
+// ValidateGracefulDrain the drain time must be in range
+func ValidateGracefulDrain(service *v1.Service) error {
+	seconds := serviceAnnotation(service, ServiceAnnotationLoadBalancerGracefulDrainSeconds)
+	if seconds == "" {
+		return nil
+	}
+	if i, err := strconv.Atoi(seconds); err != nil || i < 1 || i > MAX_GRACEFUL_DRAIN_SECONDS {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerGracefulDrainSeconds,
+			token:      seconds,
+			reason:     fmt.Sprintf("must be an integer in range [1, %d] seconds", MAX_GRACEFUL_DRAIN_SECONDS),
+		}
+	}
+	return nil
+}
+
+// drainTracker deadlines of the draining backends keyed by vserver group and
+// backend. The deadline is lost on restart, the draining backend of weight 0
+// starts over a new drain then.
+type drainTracker struct {
+	clock     clock.Clock
+	deadlines sync.Map
+}
+
+var drains = &drainTracker{clock: clock.RealClock{}}
+
+func drainKey(vgroupId string, b slb.VBackendServerType) string {
+	return fmt.Sprintf("%s/%s", vgroupId, backendKey(b))
+}
+
+// existingNodes instance ids of the nodes in the cluster, the backends of the
+// deleted nodes are removed without drain. nil when drain is disabled.
+func (c *Cloud) existingNodes(service *v1.Service) (map[string]bool, error) {
+	defaulted, _ := ExtractAnnotationRequest(service)
+	if defaulted.GracefulDrainSeconds <= 0 {
+		return nil, nil
+	}
+	nodes, err := c.ifactory.Core().V1().Nodes().Lister().List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %s", err.Error())
+	}
+	existing := map[string]bool{}
+	for _, node := range nodes {
+		if _, id, err := nodeFromProviderID(node.Spec.ProviderID); err == nil {
+			existing[id] = true
+		}
+	}
+	return existing, nil
+}
+
+// drain split the backends to be deleted into the ones removed now and the
+// ones set to weight 0 until their drain is over.
+func (v *vgroup) drain(ctx context.Context, deletions, updates []slb.VBackendServerType) (
+	[]slb.VBackendServerType, []slb.VBackendServerType) {
+
+	// the backends back in service are restored by the weight update
+	for _, b := range v.BackendServers {
+		drains.deadlines.Delete(drainKey(v.VGroupId, b))
+	}
+	if v.DrainSeconds <= 0 {
+		return deletions, updates
+	}
+	var (
+		removals []slb.VBackendServerType
+		now      = drains.clock.Now()
+		requeue  time.Duration
+	)
+	for _, b := range deletions {
+		key := drainKey(v.VGroupId, b)
+		if b.Type != utils.BACKEND_TYPE_ECS || !v.ExistingNodes[b.ServerId] {
+			// the node is deleted, nothing to drain
+			drains.deadlines.Delete(key)
+			removals = append(removals, b)
+			continue
+		}
+		deadline := now.Add(time.Duration(v.DrainSeconds) * time.Second)
+		if d, ok := drains.deadlines.LoadOrStore(key, deadline); ok {
+			deadline = d.(time.Time)
+		}
+		if !now.Before(deadline) {
+			v.Logf("drain: backend %s is drained, remove it from vgroup [%s]", b.ServerId, v.VGroupId)
+			drains.deadlines.Delete(key)
+			removals = append(removals, b)
+			continue
+		}
+		if b.Weight != 0 {
+			v.Logf("drain: set backend %s weight to 0 until %s", b.ServerId, deadline.Format(time.RFC3339))
+			b.Weight = 0
+			updates = append(updates, b)
+		}
+		if left := deadline.Sub(now); requeue == 0 || left < requeue {
+			requeue = left
+		}
+	}
+	if requeue > 0 {
+		utils.RequeueAfter(ctx, requeue)
+	}
+	return removals, updates
+}
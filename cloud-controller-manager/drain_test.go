@@ -0,0 +1,247 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateGracefulDrain(t *testing.T) {
+	cases := []struct {
+		seconds string
+		invalid bool
+	}{
+		{seconds: ""},
+		{seconds: "1"},
+		{seconds: "30"},
+		{seconds: "3600"},
+		{seconds: "0", invalid: true},
+		{seconds: "3601", invalid: true},
+		{seconds: "-30", invalid: true},
+		{seconds: "30s", invalid: true},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: map[string]string{}},
+		}
+		if c.seconds != "" {
+			svc.Annotations[ServiceAnnotationLoadBalancerGracefulDrainSeconds] = c.seconds
+		}
+		err := ValidateGracefulDrain(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("drain [%s]: unexpected error: %s", c.seconds, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("drain [%s]: expect InvalidAnnotation error, got %v", c.seconds, err)
+		}
+	}
+}
+
+func TestGracefulDrain(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	origin := drains.clock
+	drains.clock = fakeClock
+	defer func() { drains.clock = origin }()
+
+	prid1 := nodeid(string(REGION), INSTANCEID)
+	prid2 := nodeid(string(REGION), INSTANCEID2)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerGracefulDrainSeconds: "30",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid1},
+				Spec:       v1.NodeSpec{ProviderID: prid1},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid2},
+				Spec:       v1.NodeSpec{ProviderID: prid2},
+			},
+		},
+	)
+
+	// ensure the loadbalancer with the backend nodes, returns the requeue delay
+	ensure := func(f *FrameWork, nodes []*v1.Node) (time.Duration, error) {
+		var requeue time.Duration
+		ctx := context.WithValue(
+			context.Background(), utils.ContextRequeue,
+			func(delay time.Duration) { requeue = delay },
+		)
+		_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, nodes)
+		return requeue, err
+	}
+	expectWeights := func(f *FrameWork, expect map[string]int) error {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return fmt.Errorf("find loadbalancer: %v", err)
+		}
+		vgrps := BuildVirtualGroupFromService(f.LoadBalancer(), f.SVC, lb)
+		for _, vg := range *vgrps {
+			if err := vg.Describe(context.Background()); err != nil {
+				return err
+			}
+			att, err := f.SLBSDK().DescribeVServerGroupAttribute(
+				context.Background(),
+				&slb.DescribeVServerGroupAttributeArgs{VServerGroupId: vg.VGroupId},
+			)
+			if err != nil {
+				return err
+			}
+			weights := map[string]int{}
+			for _, b := range att.BackendServers.BackendServer {
+				weights[b.ServerId] = b.Weight
+			}
+			if !reflect.DeepEqual(weights, expect) {
+				return fmt.Errorf("expect weights %v, got %v", expect, weights)
+			}
+		}
+		return nil
+	}
+	expectRequeue := func(requeue, expect time.Duration) error {
+		if requeue != expect {
+			return fmt.Errorf("expect requeue after %s, got %s", expect, requeue)
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Create loadbalancer with both nodes",
+		func(f *FrameWork) error {
+			requeue, err := ensure(f, f.Nodes)
+			if err != nil {
+				return err
+			}
+			if err := expectRequeue(requeue, 0); err != nil {
+				return err
+			}
+			return expectWeights(f, map[string]int{INSTANCEID: 100, INSTANCEID2: 100})
+		},
+	)
+
+	f.RunCustomized(t, "Excluded node is drained with weight 0",
+		func(f *FrameWork) error {
+			requeue, err := ensure(f, f.Nodes[:1])
+			if err != nil {
+				return err
+			}
+			if err := expectRequeue(requeue, 30*time.Second); err != nil {
+				return err
+			}
+			return expectWeights(f, map[string]int{INSTANCEID: 100, INSTANCEID2: 0})
+		},
+	)
+
+	f.RunCustomized(t, "Draining node is kept until the deadline",
+		func(f *FrameWork) error {
+			fakeClock.Step(10 * time.Second)
+			requeue, err := ensure(f, f.Nodes[:1])
+			if err != nil {
+				return err
+			}
+			if err := expectRequeue(requeue, 20*time.Second); err != nil {
+				return err
+			}
+			return expectWeights(f, map[string]int{INSTANCEID: 100, INSTANCEID2: 0})
+		},
+	)
+
+	f.RunCustomized(t, "Drained node is removed after the deadline",
+		func(f *FrameWork) error {
+			fakeClock.Step(20 * time.Second)
+			requeue, err := ensure(f, f.Nodes[:1])
+			if err != nil {
+				return err
+			}
+			if err := expectRequeue(requeue, 0); err != nil {
+				return err
+			}
+			return expectWeights(f, map[string]int{INSTANCEID: 100})
+		},
+	)
+
+	f.RunCustomized(t, "Node back in service restores its weight",
+		func(f *FrameWork) error {
+			if _, err := ensure(f, f.Nodes); err != nil {
+				return err
+			}
+			if _, err := ensure(f, f.Nodes[:1]); err != nil {
+				return err
+			}
+			fakeClock.Step(10 * time.Second)
+			requeue, err := ensure(f, f.Nodes)
+			if err != nil {
+				return err
+			}
+			if err := expectRequeue(requeue, 0); err != nil {
+				return err
+			}
+			if err := expectWeights(f, map[string]int{INSTANCEID: 100, INSTANCEID2: 100}); err != nil {
+				return err
+			}
+			// the restored node is not removed by the former deadline
+			fakeClock.Step(30 * time.Second)
+			if _, err := ensure(f, f.Nodes); err != nil {
+				return err
+			}
+			return expectWeights(f, map[string]int{INSTANCEID: 100, INSTANCEID2: 100})
+		},
+	)
+
+	f.WithNodes(f.Nodes[:1])
+	f.RunCustomized(t, "Backend of deleted node is removed immediately",
+		func(f *FrameWork) error {
+			requeue, err := ensure(f, f.Nodes)
+			if err != nil {
+				return err
+			}
+			if err := expectRequeue(requeue, 0); err != nil {
+				return err
+			}
+			return expectWeights(f, map[string]int{INSTANCEID: 100})
+		},
+	)
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/ecs"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+)
+
+// Associating an eip with a loadbalancer is not wrapped by aliyungo,
+// the request types are declared here and invoked directly.
+
+type AssociateEipAddressArgs struct {
+	RegionId     common.Region
+	AllocationId string
+	InstanceId   string
+	InstanceType ecs.AssociatedInstanceType
+}
+
+type UnassociateEipAddressArgs struct {
+	RegionId     common.Region
+	AllocationId string
+	InstanceId   string
+	InstanceType ecs.AssociatedInstanceType
+}
+
+// EIPKEY tag of the loadbalancer telling the eip bound by the eip-id
+// annotation. Eips bound by users are never unbound.
+const EIPKEY = "kubernetes.bound.eip"
+
+// ValidateEip only intranet loadbalancers can be bound to an eip.
+func ValidateEip(service *v1.Service) error {
+	eip := serviceAnnotation(service, ServiceAnnotationLoadBalancerEipId)
+	if eip == "" {
+		return nil
+	}
+	defaulted, _ := ExtractAnnotationRequest(service)
+	if defaulted.AddressType != slb.IntranetAddressType {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerEipId,
+			token:      eip,
+			reason: fmt.Sprintf("only %s loadbalancers can be bound to an eip, set annotation %s to %s",
+				slb.IntranetAddressType, ServiceAnnotationLoadBalancerAddressType, slb.IntranetAddressType),
+		}
+	}
+	return nil
+}
+
+// describeEip find the eip by its allocation id, nil if it does not exist.
+func describeEip(ctx context.Context, ins ClientInstanceSDK, region common.Region, id string) (*ecs.EipAddressSetType, error) {
+	eips, _, err := ins.DescribeEipAddresses(
+		ctx,
+		&ecs.DescribeEipAddressesArgs{
+			RegionId:     region,
+			AllocationId: id,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("describe eip %s: %s", id, err.Error())
+	}
+	for i := range eips {
+		if eips[i].AllocationId == id {
+			return &eips[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// boundEipId the eip bound to lb by the eip-id annotation
+func boundEipId(ctx context.Context, client ClientSLBSDK, lb *slb.LoadBalancerType) (string, error) {
+	tags, _, err := client.DescribeTags(
+		ctx,
+		&slb.DescribeTagsArgs{
+			RegionId:       lb.RegionId,
+			LoadBalancerID: lb.LoadBalancerId,
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("describe tags of loadbalancer %s: %s", lb.LoadBalancerId, err.Error())
+	}
+	for _, tag := range tags {
+		if tag.TagKey == EIPKEY {
+			return tag.TagValue, nil
+		}
+	}
+	return "", nil
+}
+
+// EnsureEip bind the eip of the eip-id annotation to lb and unbind the one
+// bound before when the annotation is changed or removed. Returns the eip
+// bound to lb, nil if there is none.
+func EnsureEip(ctx context.Context, ins ClientInstanceSDK, client ClientSLBSDK,
+	service *v1.Service, lb *slb.LoadBalancerType) (*ecs.EipAddressSetType, error) {
+	_, request := ExtractAnnotationRequest(service)
+	bound, err := boundEipId(ctx, client, lb)
+	if err != nil {
+		return nil, err
+	}
+	if bound != "" && bound != request.EipId {
+		if err := unbindEip(ctx, ins, client, service, lb, bound); err != nil {
+			return nil, err
+		}
+	}
+	if request.EipId == "" {
+		return nil, nil
+	}
+
+	eip, err := describeEip(ctx, ins, lb.RegionId, request.EipId)
+	if err != nil {
+		return nil, err
+	}
+	if eip == nil {
+		return nil, fmt.Errorf("eip %s not found in region %s", request.EipId, lb.RegionId)
+	}
+	if eip.InstanceId != "" && eip.InstanceId != lb.LoadBalancerId {
+		return nil, fmt.Errorf("eip %s has been associated with %s", request.EipId, eip.InstanceId)
+	}
+	if eip.InstanceId == "" {
+		utils.Logf(service, "bind eip %s[%s] to loadbalancer %s", eip.AllocationId, eip.IpAddress, lb.LoadBalancerId)
+		err := ins.AssociateEipAddress(
+			ctx,
+			&AssociateEipAddressArgs{
+				RegionId:     lb.RegionId,
+				AllocationId: eip.AllocationId,
+				InstanceId:   lb.LoadBalancerId,
+				InstanceType: ecs.AssociatedInstanceTypeSlbInstance,
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("bind eip %s to loadbalancer %s: %s", eip.AllocationId, lb.LoadBalancerId, err.Error())
+		}
+	}
+	if bound != request.EipId {
+		// remember the eip so that it is unbound when the annotation is removed
+		err := addSLBTag(client, ctx, map[string]string{EIPKEY: request.EipId}, lb.RegionId, lb.LoadBalancerId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return eip, nil
+}
+
+// EnsureEipUnbound unbind the eip bound by the eip-id annotation. The eip
+// is owned by the user and never released.
+func EnsureEipUnbound(ctx context.Context, ins ClientInstanceSDK, client ClientSLBSDK,
+	service *v1.Service, lb *slb.LoadBalancerType) error {
+	bound, err := boundEipId(ctx, client, lb)
+	if err != nil {
+		return err
+	}
+	if bound == "" {
+		return nil
+	}
+	return unbindEip(ctx, ins, client, service, lb, bound)
+}
+
+func unbindEip(ctx context.Context, ins ClientInstanceSDK, client ClientSLBSDK,
+	service *v1.Service, lb *slb.LoadBalancerType, id string) error {
+	eip, err := describeEip(ctx, ins, lb.RegionId, id)
+	if err != nil {
+		return err
+	}
+	// the eip may have been unbound or released by the user
+	if eip != nil && eip.InstanceId == lb.LoadBalancerId {
+		utils.Logf(service, "unbind eip %s[%s] from loadbalancer %s", eip.AllocationId, eip.IpAddress, lb.LoadBalancerId)
+		err := ins.UnassociateEipAddress(
+			ctx,
+			&UnassociateEipAddressArgs{
+				RegionId:     lb.RegionId,
+				AllocationId: eip.AllocationId,
+				InstanceId:   lb.LoadBalancerId,
+				InstanceType: ecs.AssociatedInstanceTypeSlbInstance,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("unbind eip %s from loadbalancer %s: %s", id, lb.LoadBalancerId, err.Error())
+		}
+	}
+	return removeSLBTag(client, ctx, []slb.TagItem{{TagKey: EIPKEY, TagValue: id}}, lb.RegionId, lb.LoadBalancerId)
+}
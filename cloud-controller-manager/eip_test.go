@@ -0,0 +1,209 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/ecs"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+const (
+	EIP_ID_1   = "eip-2zeeb0q6xhx0n0zkymxxx"
+	EIP_ADDR_1 = "47.94.0.1"
+	EIP_ID_2   = "eip-2zeeb0q6xhx0n0zkymyyy"
+	EIP_ADDR_2 = "47.94.0.2"
+)
+
+func TestValidateEip(t *testing.T) {
+	cases := []struct {
+		annotations map[string]string
+		invalid     bool
+	}{
+		{annotations: map[string]string{}},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerEipId:       EIP_ID_1,
+				ServiceAnnotationLoadBalancerAddressType: string(slb.IntranetAddressType),
+			},
+		},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerEipId: EIP_ID_1}, invalid: true},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerEipId:       EIP_ID_1,
+				ServiceAnnotationLoadBalancerAddressType: string(slb.InternetAddressType),
+			},
+			invalid: true,
+		},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: c.annotations},
+		}
+		err := ValidateEip(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("%v: unexpected error: %s", c.annotations, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%v: expect InvalidAnnotation error, got %v", c.annotations, err)
+		}
+	}
+}
+
+func TestEip(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerAddressType: string(slb.IntranetAddressType),
+					ServiceAnnotationLoadBalancerEipId:       EIP_ID_1,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	seed := func() {
+		INSTANCE.eips.Store(EIP_ID_1, ecs.EipAddressSetType{RegionId: REGION, AllocationId: EIP_ID_1, IpAddress: EIP_ADDR_1})
+		INSTANCE.eips.Store(EIP_ID_2, ecs.EipAddressSetType{RegionId: REGION, AllocationId: EIP_ID_2, IpAddress: EIP_ADDR_2})
+	}
+	expectEip := func(id, instance string) error {
+		v, ok := INSTANCE.eips.Load(id)
+		if !ok {
+			return fmt.Errorf("eip %s is not expected to be released", id)
+		}
+		if bound := v.(ecs.EipAddressSetType).InstanceId; bound != instance {
+			return fmt.Errorf("expect eip %s bound to [%s], got [%s]", id, instance, bound)
+		}
+		return nil
+	}
+	// ensure the service and check the reported ingress
+	ensure := func(f *FrameWork, ip string) (string, error) {
+		status, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+		if err != nil {
+			return "", err
+		}
+		if len(status.Ingress) != 1 || status.Ingress[0].IP != ip {
+			return "", fmt.Errorf("expect ingress %s, got %v", ip, status.Ingress)
+		}
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return "", fmt.Errorf("find loadbalancer: %v", err)
+		}
+		return lb.LoadBalancerId, nil
+	}
+
+	var created string
+	f.RunCustomized(t, "Bind eip",
+		func(f *FrameWork) error {
+			seed()
+			id, err := ensure(f, EIP_ADDR_1)
+			if err != nil {
+				return err
+			}
+			created = id
+			if err := expectEip(EIP_ID_1, created); err != nil {
+				return err
+			}
+			status, exist, err := f.CloudImpl().GetLoadBalancer(context.Background(), CLUSTER_ID, f.SVC)
+			if err != nil || !exist {
+				return fmt.Errorf("get loadbalancer: %v, %v", exist, err)
+			}
+			if status.Ingress[0].IP != EIP_ADDR_1 {
+				return fmt.Errorf("expect ingress %s, got %v", EIP_ADDR_1, status.Ingress)
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerEipId] = EIP_ID_2
+	f.RunCustomized(t, "Rebind eip",
+		func(f *FrameWork) error {
+			if _, err := ensure(f, EIP_ADDR_2); err != nil {
+				return err
+			}
+			if err := expectEip(EIP_ID_1, ""); err != nil {
+				return err
+			}
+			return expectEip(EIP_ID_2, created)
+		},
+	)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerEipId)
+	f.RunCustomized(t, "Unbind eip by removing the annotation",
+		func(f *FrameWork) error {
+			if _, err := ensure(f, LOADBALANCER_ADDRESS); err != nil {
+				return err
+			}
+			return expectEip(EIP_ID_2, "")
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerEipId] = EIP_ID_1
+	f.RunCustomized(t, "Unbind eip on deletion",
+		func(f *FrameWork) error {
+			if _, err := ensure(f, EIP_ADDR_1); err != nil {
+				return err
+			}
+			if err := f.CloudImpl().EnsureLoadBalancerDeleted(context.Background(), CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			return expectEip(EIP_ID_1, "")
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerEipId] = EIP_ID_2
+	f.RunCustomized(t, "Eip bound to another instance",
+		func(f *FrameWork) error {
+			INSTANCE.eips.Store(EIP_ID_2, ecs.EipAddressSetType{
+				RegionId: REGION, AllocationId: EIP_ID_2, IpAddress: EIP_ADDR_2, InstanceId: INSTANCEID})
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), INSTANCEID) {
+				return fmt.Errorf("expect eip associated error, got %v", err)
+			}
+			return expectEip(EIP_ID_2, INSTANCEID)
+		},
+	)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"k8s.io/klog"
+	"net"
+	"net/url"
+	"time"
+)
+
+// The clients call the public endpoints of the sdk by default. Clusters in
+// an isolated vpc reach the apis by the vpc endpoints of the region instead.
+// The endpoint of a product given by the cloud config goes first, then the
+// vpc endpoint if the vpc endpoints are enabled. The endpoints overridden are
+// probed at startup, a misconfigured one fails the startup.
+
+// USE_VPC_ENDPOINTS call the vpc endpoints of the region, set by --use-vpc-endpoints
+var USE_VPC_ENDPOINTS = false
+
+// ENDPOINT_PROBE_TIMEOUT timeout of the connectivity probe of an endpoint
+var ENDPOINT_PROBE_TIMEOUT = 5 * time.Second
+
+// VPC_ENDPOINTS the vpc endpoint of each product, formatted with the region
+var VPC_ENDPOINTS = map[string]string{
+	CLIENT_SLB:  "https://slb-vpc.%s.aliyuncs.com",
+	CLIENT_ECS:  "https://ecs-vpc.%s.aliyuncs.com",
+	CLIENT_VPC:  "https://vpc-vpc.%s.aliyuncs.com",
+	CLIENT_NLB:  "https://nlb-vpc.%s.aliyuncs.com",
+	CLIENT_PVTZ: "https://pvtz.vpc-proxy.aliyuncs.com",
+}
+
+// probeEndpoint is replaced by tests
+var probeEndpoint = dialEndpoint
+
+// overriddenEndpoints the endpoint of each product overridden, the products
+// absent call the endpoints of the sdk.
+func overriddenEndpoints(region string) map[string]string {
+	endpoints := map[string]string{}
+	if USE_VPC_ENDPOINTS || cfg.Global.UseVPCEndpoints {
+		for client, format := range VPC_ENDPOINTS {
+			if client == CLIENT_PVTZ {
+				endpoints[client] = format
+				continue
+			}
+			endpoints[client] = fmt.Sprintf(format, region)
+		}
+	}
+	for client, endpoint := range map[string]string{
+		CLIENT_SLB:  cfg.Global.SLBEndpoint,
+		CLIENT_ECS:  cfg.Global.ECSEndpoint,
+		CLIENT_VPC:  cfg.Global.VPCEndpoint,
+		CLIENT_NLB:  cfg.Global.NLBEndpoint,
+		CLIENT_PVTZ: cfg.Global.PVTZEndpoint,
+	} {
+		if endpoint != "" {
+			endpoints[client] = endpoint
+		}
+	}
+	return endpoints
+}
+
+// probeEndpoints check the connectivity of the endpoints overridden
+func probeEndpoints(endpoints map[string]string) error {
+	for client, endpoint := range endpoints {
+		if err := probeEndpoint(endpoint); err != nil {
+			return fmt.Errorf("%s endpoint %s unreachable, check the endpoint "+
+				"configuration: %s", client, endpoint, err.Error())
+		}
+		klog.Infof("alicloud: use %s endpoint %s", client, endpoint)
+	}
+	return nil
+}
+
+// dialEndpoint connect to the host of the endpoint
+func dialEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid endpoint, expect a url like https://slb-vpc.cn-hangzhou.aliyuncs.com")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", host, ENDPOINT_PROBE_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// clientEndpoints the endpoint of each client of the client manager
+func clientEndpoints(mgr *ClientMgr) map[string]string {
+	return map[string]string{
+		CLIENT_ECS:  mgr.instance.c.(*ContextedClientINS).ecs.GetEndpoint(),
+		CLIENT_SLB:  mgr.loadbalancer.c.(*ContextedClientSLB).slb.GetEndpoint(),
+		CLIENT_PVTZ: mgr.privateZone.c.(*ContextedClientPVTZ).pvtz.GetEndpoint(),
+		CLIENT_VPC:  mgr.routes.client.(*ContextedClientRoute).ecs.GetEndpoint(),
+		CLIENT_NLB:  mgr.nlb.c.(*ContextedClientNLB).nlb.GetEndpoint(),
+	}
+}
+
+func TestClientEndpoints(t *testing.T) {
+	config, vpc, probe := cfg.Global, USE_VPC_ENDPOINTS, probeEndpoint
+	defer func() { cfg.Global, USE_VPC_ENDPOINTS, probeEndpoint = config, vpc, probe }()
+
+	var probed []string
+	probeEndpoint = func(endpoint string) error {
+		probed = append(probed, endpoint)
+		return nil
+	}
+	region := string(REGION)
+	cases := []struct {
+		describe  string
+		vpc       bool
+		slb       string
+		ecs       string
+		endpoints map[string]string
+	}{
+		{
+			describe: "sdk endpoints by default",
+		},
+		{
+			describe: "vpc endpoints of the region",
+			vpc:      true,
+			endpoints: map[string]string{
+				CLIENT_SLB:  fmt.Sprintf("https://slb-vpc.%s.aliyuncs.com", region),
+				CLIENT_ECS:  fmt.Sprintf("https://ecs-vpc.%s.aliyuncs.com", region),
+				CLIENT_VPC:  fmt.Sprintf("https://vpc-vpc.%s.aliyuncs.com", region),
+				CLIENT_NLB:  fmt.Sprintf("https://nlb-vpc.%s.aliyuncs.com", region),
+				CLIENT_PVTZ: "https://pvtz.vpc-proxy.aliyuncs.com",
+			},
+		},
+		{
+			describe: "endpoints of the cloud config go first",
+			vpc:      true,
+			slb:      "https://slb.internal.example.com",
+			ecs:      "http://ecs.internal.example.com:8080",
+			endpoints: map[string]string{
+				CLIENT_SLB:  "https://slb.internal.example.com",
+				CLIENT_ECS:  "http://ecs.internal.example.com:8080",
+				CLIENT_VPC:  fmt.Sprintf("https://vpc-vpc.%s.aliyuncs.com", region),
+				CLIENT_NLB:  fmt.Sprintf("https://nlb-vpc.%s.aliyuncs.com", region),
+				CLIENT_PVTZ: "https://pvtz.vpc-proxy.aliyuncs.com",
+			},
+		},
+		{
+			describe: "endpoint of the cloud config only",
+			slb:      "https://slb-vpc.cn-shanghai.aliyuncs.com",
+			endpoints: map[string]string{
+				CLIENT_SLB: "https://slb-vpc.cn-shanghai.aliyuncs.com",
+			},
+		},
+	}
+	for _, c := range cases {
+		probed = nil
+		USE_VPC_ENDPOINTS = c.vpc
+		cfg.Global.SLBEndpoint, cfg.Global.ECSEndpoint = c.slb, c.ecs
+		mgr, err := newClientMgr("key", "secret", region, VPCID, nil)
+		if err != nil {
+			t.Fatalf("%s: new client manager: %s", c.describe, err.Error())
+		}
+		for client, endpoint := range clientEndpoints(mgr) {
+			expect, overridden := c.endpoints[client]
+			if overridden && endpoint != expect {
+				t.Errorf("%s: expect %s endpoint %s, got %s", c.describe, client, expect, endpoint)
+			}
+			if !overridden && (strings.Contains(endpoint, "-vpc.") || strings.Contains(endpoint, "vpc-proxy")) {
+				t.Errorf("%s: expect %s endpoint of the sdk, got %s", c.describe, client, endpoint)
+			}
+		}
+		if len(probed) != len(c.endpoints) {
+			t.Errorf("%s: expect the overridden endpoints probed, got %v", c.describe, probed)
+		}
+	}
+}
+
+func TestProbeEndpoints(t *testing.T) {
+	config, probe := cfg.Global, probeEndpoint
+	defer func() { cfg.Global, probeEndpoint = config, probe }()
+
+	probeEndpoint = func(endpoint string) error {
+		return fmt.Errorf("dial tcp: lookup %s: no such host", endpoint)
+	}
+	cfg.Global.SLBEndpoint = "https://slb-vpc.cn-nowhere.aliyuncs.com"
+	_, err := newClientMgr("key", "secret", string(REGION), VPCID, nil)
+	if err == nil || !strings.Contains(err.Error(), "slb endpoint https://slb-vpc.cn-nowhere.aliyuncs.com unreachable") {
+		t.Fatalf("expect the startup failed on the unreachable endpoint, got %v", err)
+	}
+
+	for _, endpoint := range []string{"slb-vpc.cn-hangzhou.aliyuncs.com", "://"} {
+		if err := dialEndpoint(endpoint); err == nil || !strings.Contains(err.Error(), "invalid endpoint") {
+			t.Errorf("%s: expect an invalid endpoint, got %v", endpoint, err)
+		}
+	}
+}
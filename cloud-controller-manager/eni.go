@@ -0,0 +1,92 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"sort"
+	"strings"
+)
+
+// With backend-type eni the ready pods are added to the vserver groups by
+// their eni ips and target ports, the NodePort hop is skipped. Pods whose ip
+// is not on an eni, eg. on nodes without terway, are skipped.
+
+// ValidateBackendType the backend type annotation must be a single type
+func ValidateBackendType(service *v1.Service) error {
+	backendType := serviceAnnotation(service, utils.BACKEND_TYPE_LABEL)
+	switch backendType {
+	case "", utils.BACKEND_TYPE_ENI, utils.BACKEND_TYPE_ECS:
+		return nil
+	}
+	return annotationError{
+		annotation: utils.BACKEND_TYPE_LABEL,
+		token:      backendType,
+		reason: fmt.Sprintf("must be one of %s, %s, mixed backend types are not supported",
+			utils.BACKEND_TYPE_ENI, utils.BACKEND_TYPE_ECS),
+	}
+}
+
+// ValidateKeepLastBackends the keep-last-backends annotation must be on or off
+func ValidateKeepLastBackends(service *v1.Service) error {
+	keep := serviceAnnotation(service, ServiceAnnotationLoadBalancerKeepLastBackends)
+	if keep == "" || keep == "on" || keep == "off" {
+		return nil
+	}
+	return annotationError{
+		annotation: ServiceAnnotationLoadBalancerKeepLastBackends,
+		token:      keep,
+		reason:     "must be on or off",
+	}
+}
+
+// skipENI the address has no eni to be added as backend
+func (v *EndpointWithENI) skipENI(ip string) {
+	if v.SkippedIPs == nil {
+		v.SkippedIPs = map[string]bool{}
+	}
+	v.SkippedIPs[ip] = true
+}
+
+// recordSkippedENI warn of the pods not added as eni backends
+func recordSkippedENI(ctx context.Context, service *v1.Service, nodes *EndpointWithENI) {
+	if len(nodes.SkippedIPs) == 0 {
+		return
+	}
+	var pods []string
+	for ip := range nodes.SkippedIPs {
+		pods = append(pods, nodes.podName(ip))
+	}
+	sort.Strings(pods)
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "pods %v are skipped, no eni is found for their ips", pods)
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"SkipNonENIBackend",
+		"Pods %s are not added as backends, no eni is found for their ips, the nodes may not support eni",
+		strings.Join(pods, ", "),
+	)
+}
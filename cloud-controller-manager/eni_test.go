@@ -0,0 +1,205 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestValidateBackendType(t *testing.T) {
+	cases := []struct {
+		annotations map[string]string
+		invalid     bool
+	}{
+		{annotations: map[string]string{}},
+		{annotations: map[string]string{utils.BACKEND_TYPE_LABEL: "eni"}},
+		{annotations: map[string]string{utils.BACKEND_TYPE_LABEL: "ecs"}},
+		{annotations: map[string]string{utils.BACKEND_TYPE_LABEL: "eni,ecs"}, invalid: true},
+		{annotations: map[string]string{utils.BACKEND_TYPE_LABEL: "mixed"}, invalid: true},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerKeepLastBackends: "on"}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerKeepLastBackends: "true"}, invalid: true},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: c.annotations},
+		}
+		err := ValidateBackendType(svc)
+		if err == nil {
+			err = ValidateKeepLastBackends(svc)
+		}
+		if !c.invalid && err != nil {
+			t.Errorf("%v: unexpected error: %s", c.annotations, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%v: expect InvalidAnnotation error, got %v", c.annotations, err)
+		}
+	}
+}
+
+func TestENIPodChurn(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	const NO_ENI_ADDR = "10.0.0.1"
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					utils.BACKEND_TYPE_LABEL:                      utils.BACKEND_TYPE_ENI,
+					ServiceAnnotationLoadBalancerKeepLastBackends: "on",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+	// endpoints of the ready pods by ip
+	pods := func(ips ...string) *v1.Endpoints {
+		var addrs []v1.EndpointAddress
+		for i, ip := range ips {
+			addrs = append(addrs, v1.EndpointAddress{
+				IP:        ip,
+				NodeName:  &prid,
+				TargetRef: &v1.ObjectReference{Kind: "Pod", Name: fmt.Sprintf("nginx-%d", i)},
+			})
+		}
+		return &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-service", Namespace: "default"},
+			Subsets: []v1.EndpointSubset{
+				{Addresses: addrs, Ports: []v1.EndpointPort{{Port: targetPort1.IntVal}}},
+			},
+		}
+	}
+	recorder := record.NewFakeRecorder(10)
+	ensure := func(f *FrameWork) error {
+		ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+		_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+		return err
+	}
+	// the eni backends of the single vserver group, by ip
+	expectBackends := func(f *FrameWork, ips ...string) error {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return fmt.Errorf("find loadbalancer: %v", err)
+		}
+		res, err := f.SLBSDK().DescribeVServerGroups(
+			context.Background(),
+			&slb.DescribeVServerGroupsArgs{LoadBalancerId: lb.LoadBalancerId},
+		)
+		if err != nil {
+			return err
+		}
+		if len(res.VServerGroups.VServerGroup) != 1 {
+			return fmt.Errorf("expect 1 vserver group, got %d", len(res.VServerGroups.VServerGroup))
+		}
+		att, err := f.SLBSDK().DescribeVServerGroupAttribute(
+			context.Background(),
+			&slb.DescribeVServerGroupAttributeArgs{VServerGroupId: res.VServerGroups.VServerGroup[0].VServerGroupId},
+		)
+		if err != nil {
+			return err
+		}
+		var got []string
+		for _, b := range att.BackendServers.BackendServer {
+			if b.Type != "eni" || b.Port != int(targetPort1.IntVal) {
+				return fmt.Errorf("expect eni backend on target port, got %v", b)
+			}
+			got = append(got, b.ServerIp)
+		}
+		sort.Strings(got)
+		sort.Strings(ips)
+		if !reflect.DeepEqual(got, ips) {
+			return fmt.Errorf("expect backends %v, got %v", ips, got)
+		}
+		return nil
+	}
+
+	f.WithEndpoints(pods(ENI_ADDR_1, ENI_ADDR_2))
+	f.RunCustomized(t, "Pods are added by eni",
+		func(f *FrameWork) error {
+			if err := ensure(f); err != nil {
+				return err
+			}
+			return expectBackends(f, ENI_ADDR_1, ENI_ADDR_2)
+		},
+	)
+
+	f.WithEndpoints(pods(ENI_ADDR_1, NO_ENI_ADDR))
+	f.RunCustomized(t, "Pod removed and pod without eni skipped",
+		func(f *FrameWork) error {
+			if err := ensure(f); err != nil {
+				return err
+			}
+			if err := expectBackends(f, ENI_ADDR_1); err != nil {
+				return err
+			}
+			if len(recorder.Events) != 1 {
+				return fmt.Errorf("expect an event of the skipped pod, got %d", len(recorder.Events))
+			}
+			if event := <-recorder.Events; !strings.Contains(event, "SkipNonENIBackend") || !strings.Contains(event, "nginx-1") {
+				return fmt.Errorf("expect the skipped pod nginx-1 in event, got %s", event)
+			}
+			return nil
+		},
+	)
+
+	f.WithEndpoints(pods())
+	f.RunCustomized(t, "Last backends are kept on scale to zero",
+		func(f *FrameWork) error {
+			if err := ensure(f); err != nil {
+				return err
+			}
+			return expectBackends(f, ENI_ADDR_1)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerKeepLastBackends] = "off"
+	f.RunCustomized(t, "Vserver group is emptied without keep-last-backends",
+		func(f *FrameWork) error {
+			if err := ensure(f); err != nil {
+				return err
+			}
+			return expectBackends(f)
+		},
+	)
+}
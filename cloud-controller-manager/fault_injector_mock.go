@@ -0,0 +1,269 @@
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"math/rand"
+	"sync"
+)
+
+// The mock sdk clients model the happy path of the apis. A FaultInjector
+// programs the failures of their operations, eg. the first N calls of
+// CreateLoadBalancerTCPListener throttled, and records every call with its
+// arguments in order, so that the tests can assert the backoff and the
+// partial failure handling.
+
+// ThrottlingFault the error of a throttled call
+func ThrottlingFault() error {
+	return &common.Error{
+		ErrorResponse: common.ErrorResponse{
+			RequestId: "req-fault-throttling",
+			Code:      "Throttling.User",
+			Message:   "Request was denied due to user flow control.",
+		},
+		StatusCode: 400,
+	}
+}
+
+// ForbiddenFault the error of a call denied by the RAM policy
+func ForbiddenFault() error {
+	return &common.Error{
+		ErrorResponse: common.ErrorResponse{
+			RequestId: "req-fault-forbidden",
+			Code:      "Forbidden.RAM",
+			Message:   "User not authorized to operate on the specified resource, or this API doesn't support RAM.",
+		},
+		StatusCode: 403,
+	}
+}
+
+// TimeoutFault the error of a call timed out, as the sdk reports a client failure
+func TimeoutFault() error {
+	return &common.Error{
+		ErrorResponse: common.ErrorResponse{
+			Code:    "AliyunGoClientFailure",
+			Message: "net/http: request canceled (Client.Timeout exceeded while awaiting headers)",
+		},
+		StatusCode: -1,
+	}
+}
+
+// LoadBalancerNotFoundFault the error of a call on a loadbalancer deleted
+func LoadBalancerNotFoundFault() error {
+	return &common.Error{
+		ErrorResponse: common.ErrorResponse{
+			RequestId: "req-fault-loadbalancer-not-found",
+			Code:      "InvalidLoadBalancerId.NotFound",
+			Message:   "The specified LoadBalancerId does not exist.",
+		},
+		StatusCode: 404,
+	}
+}
+
+// ListenerNotFoundFault the error of a call on a listener deleted
+func ListenerNotFoundFault() error {
+	return &common.Error{
+		ErrorResponse: common.ErrorResponse{
+			RequestId: "req-fault-listener-not-found",
+			Code:      "InvalidParameter",
+			Message:   "The specified resource does not exist.",
+		},
+		StatusCode: 400,
+	}
+}
+
+// VServerGroupNotFoundFault the error of a call on a vserver group deleted
+func VServerGroupNotFoundFault() error {
+	return &common.Error{
+		ErrorResponse: common.ErrorResponse{
+			RequestId: "req-fault-vgroup-not-found",
+			Code:      "InvalidParameter",
+			Message:   "The specified VServerGroupId does not exist.",
+		},
+		StatusCode: 400,
+	}
+}
+
+// FaultRule a failure of the calls of an operation
+type FaultRule struct {
+	// Operation the action failed, eg. CreateLoadBalancerTCPListener
+	Operation string
+	// Err the fault returned by the failed calls
+	Err func() error
+	// Times the first calls failed, every call if 0
+	Times int
+	// Probability the chance of a call to fail when above 0, counted in Times
+	Probability float64
+	// Match the calls failed by their arguments, every call if nil
+	Match func(args ...interface{}) bool
+}
+
+// MockCall a call of the mock sdk recorded
+type MockCall struct {
+	// Caller the worker making the call, see WithMockCaller
+	Caller    string
+	Operation string
+	Args      []interface{}
+	// Err the fault injected, nil if the call went to the mock
+	Err error
+}
+
+func (c MockCall) String() string {
+	call := fmt.Sprintf("%s%v", c.Operation, c.Args)
+	if c.Caller != "" {
+		call = fmt.Sprintf("%s: %s", c.Caller, call)
+	}
+	if c.Err != nil {
+		return fmt.Sprintf("%s: %s", call, c.Err.Error())
+	}
+	return call
+}
+
+type faultRule struct {
+	FaultRule
+	failed int
+}
+
+// FaultInjector the faults and the calls of a mock sdk client
+type FaultInjector struct {
+	lock  sync.Mutex
+	rules []*faultRule
+	calls []MockCall
+	rand  *rand.Rand
+}
+
+// NewFaultInjector an injector with the probabilities seeded, so that the
+// faults of a test are reproducible
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Inject add the rule, the rules are matched in the order added
+func (f *FaultInjector) Inject(rule FaultRule) *FaultInjector {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.rules = append(f.rules, &faultRule{FaultRule: rule})
+	return f
+}
+
+// FailFirst fail the first n calls of the operation with the fault
+func (f *FaultInjector) FailFirst(operation string, n int, fault func() error) *FaultInjector {
+	return f.Inject(FaultRule{Operation: operation, Err: fault, Times: n})
+}
+
+// FailWithProbability fail the calls of the operation at the probability
+func (f *FaultInjector) FailWithProbability(operation string, probability float64, fault func() error) *FaultInjector {
+	return f.Inject(FaultRule{Operation: operation, Err: fault, Probability: probability})
+}
+
+// Reset remove the rules and the calls recorded
+func (f *FaultInjector) Reset() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.rules = nil
+	f.calls = nil
+}
+
+// Calls the calls recorded in order, of the operations if any given
+func (f *FaultInjector) Calls(operations ...string) []MockCall {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var calls []MockCall
+	for _, call := range f.calls {
+		if len(operations) == 0 || containsString(operations, call.Operation) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Operations the operations called in order
+func (f *FaultInjector) Operations() []string {
+	var operations []string
+	for _, call := range f.Calls() {
+		operations = append(operations, call.Operation)
+	}
+	return operations
+}
+
+// call record the call and return the fault of the first rule failing it
+func (f *FaultInjector) call(operation string, args ...interface{}) error {
+	return f.callBy("", operation, args...)
+}
+
+// callBy record the call of the caller and return its fault
+func (f *FaultInjector) callBy(caller, operation string, args ...interface{}) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var fault error
+	for _, rule := range f.rules {
+		if rule.Operation != operation ||
+			(rule.Times > 0 && rule.failed >= rule.Times) ||
+			(rule.Match != nil && !rule.Match(args...)) {
+			continue
+		}
+		if rule.Probability > 0 && f.rand.Float64() >= rule.Probability {
+			continue
+		}
+		rule.failed++
+		fault = rule.Err()
+		break
+	}
+	f.calls = append(f.calls, MockCall{Caller: caller, Operation: operation, Args: args, Err: fault})
+	return fault
+}
+
+type mockInternalCall struct{}
+
+// internalCall the context of a call of the mock to itself, neither recorded
+// nor failed
+func internalCall(ctx context.Context) context.Context {
+	return context.WithValue(ctx, mockInternalCall{}, true)
+}
+
+func isInternalCall(ctx context.Context) bool {
+	return ctx != nil && ctx.Value(mockInternalCall{}) != nil
+}
+
+type mockCaller struct{}
+
+// WithMockCaller the context of the calls of a worker, recorded by its name
+// so that the calls of the concurrent workers can be told apart
+func WithMockCaller(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, mockCaller{}, name)
+}
+
+// inject the fault of the call of the mock sdk, if any. The call is taken
+// from the api budget of its service as the clients do.
+func (c *mockClientSLB) inject(ctx context.Context, operation string, args ...interface{}) error {
+	if isInternalCall(ctx) {
+		return nil
+	}
+	if err := APIBudget.Take(ctx, operation); err != nil {
+		return err
+	}
+	if c.faults == nil {
+		return nil
+	}
+	caller := ""
+	if ctx != nil {
+		caller, _ = ctx.Value(mockCaller{}).(string)
+	}
+	return c.faults.callBy(caller, operation, args...)
+}
+
+// mockSLBLock serializes the calls of the mock slb sdk, which read, modify and
+// write back the objects of LOADBALANCER. The concurrent tests then race the
+// controller, not the mock.
+var mockSLBLock sync.Mutex
+
+// serialize lock the mock for the call, and return the unlock. The internal
+// calls run under the lock of their caller already.
+func (c *mockClientSLB) serialize(ctx context.Context) func() {
+	if isInternalCall(ctx) {
+		return func() {}
+	}
+	mockSLBLock.Lock()
+	return mockSLBLock.Unlock
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestFaultInjector(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockClientSLB{faults: NewFaultInjector(1)}
+	mock.faults.
+		FailFirst("StopLoadBalancerListener", 2, ThrottlingFault).
+		Inject(FaultRule{
+			Operation: "StartLoadBalancerListener",
+			Err:       TimeoutFault,
+			Match:     func(args ...interface{}) bool { return args[1].(int) == 443 },
+		})
+
+	for i := 0; i < 3; i++ {
+		err := mock.StopLoadBalancerListener(ctx, "lb-none", 80)
+		if i < 2 && cloudErrorCodeOf(err) != "Throttling.User" {
+			t.Fatalf("call %d: expect throttled, got %v", i, err)
+		}
+		if i == 2 && cloudErrorCodeOf(err) == "Throttling.User" {
+			t.Fatalf("call %d: expect the call to go to the mock, got %v", i, err)
+		}
+	}
+	if err := mock.StartLoadBalancerListener(ctx, "lb-none", 443); cloudErrorCodeOf(err) != "AliyunGoClientFailure" {
+		t.Fatalf("expect the listener 443 timed out, got %v", err)
+	}
+	if err := mock.StartLoadBalancerListener(ctx, "lb-none", 80); cloudErrorCodeOf(err) == "AliyunGoClientFailure" {
+		t.Fatalf("expect the listener 80 not matched, got %v", err)
+	}
+
+	calls := mock.faults.Calls()
+	expect := []string{
+		"StopLoadBalancerListener", "StopLoadBalancerListener", "StopLoadBalancerListener",
+		"StartLoadBalancerListener", "StartLoadBalancerListener",
+	}
+	if strings.Join(mock.faults.Operations(), ",") != strings.Join(expect, ",") {
+		t.Fatalf("expect the calls recorded in order, got %v", calls)
+	}
+	if calls[3].Args[0] != "lb-none" || calls[3].Args[1] != 443 || calls[3].Err == nil {
+		t.Fatalf("expect the arguments and the fault recorded, got %s", calls[3])
+	}
+
+	// the faults at a probability are reproducible by the seed
+	failed := func(seed int64) string {
+		faults := NewFaultInjector(seed).FailWithProbability("DescribeZones", 0.5, ForbiddenFault)
+		var failed []string
+		for i := 0; i < 20; i++ {
+			failed = append(failed, fmt.Sprintf("%t", faults.call("DescribeZones") != nil))
+		}
+		return strings.Join(failed, ",")
+	}
+	first := failed(7)
+	if first != failed(7) || !strings.Contains(first, "true") || !strings.Contains(first, "false") {
+		t.Fatalf("expect some calls failed reproducibly, got %s", first)
+	}
+}
+
+// cloudErrorCodeOf the code of the cloud api error of err
+func cloudErrorCodeOf(err error) string {
+	e, _ := utils.ParseCloudError(err)
+	return e.Code
+}
+
+// reconcile ensure the loadbalancer of the service until it succeeds, as the
+// service controller requeues the failed syncs, and return the errors
+func reconcile(f *FrameWork, attempts int) []error {
+	var errs []error
+	for i := 0; i < attempts; i++ {
+		_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+		if err == nil {
+			return errs
+		}
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// listenerRunning whether the tcp listener of the port is created and not stopped
+func listenerRunning(f *FrameWork, port int32) error {
+	resp, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(context.Background(), LOADBALANCER_ID, int(port))
+	if err != nil || resp == nil {
+		return fmt.Errorf("expect the listener %d created, got %v", port, err)
+	}
+	if resp.Status == slb.Stopped {
+		return fmt.Errorf("expect the listener %d running, got %s", port, resp.Status)
+	}
+	return nil
+}
+
+func TestEnsureLoadBalancerAfterThrottling(t *testing.T) {
+	f := NewDefaultFrameWork(nil)
+	// the service of the loadbalancer of the default preset
+	f.WithService(testServiceNamed("my-service", serviceUIDExist, map[string]string{},
+		v1.ServicePort{Name: "tcp-80", Port: listenPort1, TargetPort: intstr.FromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080}))
+	faults := f.SLBFaults().FailFirst("DescribeLoadBalancers", 2, ThrottlingFault)
+
+	f.RunCustomized(t, "Reconcile after transient throttling",
+		func(f *FrameWork) error {
+			errs := reconcile(f, 5)
+			if len(errs) != 2 {
+				return fmt.Errorf("expect the loadbalancer ensured on the third sync, got errors %v", errs)
+			}
+			for _, err := range errs {
+				if cloudErrorCodeOf(err) != "Throttling.User" {
+					return fmt.Errorf("expect the throttling kept in the error chain, got %v", err)
+				}
+			}
+			// a throttled lookup must never be taken for a loadbalancer absent
+			if calls := faults.Calls("CreateLoadBalancer", "DeleteLoadBalancer"); len(calls) != 0 {
+				return fmt.Errorf("expect the loadbalancer kept, got %v", calls)
+			}
+			lookups := faults.Calls("DescribeLoadBalancers")
+			if len(lookups) < 3 || lookups[0].Err == nil || lookups[1].Err == nil || lookups[2].Err != nil {
+				return fmt.Errorf("expect the first two lookups throttled, got %v", lookups)
+			}
+			return listenerRunning(f, listenPort1)
+		},
+	)
+}
+
+func TestEnsureLoadBalancerAfterListenerCreateFailed(t *testing.T) {
+	ctx := context.Background()
+	f := NewDefaultFrameWork(nil)
+	f.WithService(testServiceNamed("my-service", serviceUIDExist, map[string]string{},
+		v1.ServicePort{Name: "tcp-80", Port: listenPort1, TargetPort: intstr.FromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30080},
+		v1.ServicePort{Name: "tcp-443", Port: 443, TargetPort: intstr.FromInt(8443), Protocol: v1.ProtocolTCP, NodePort: 30443}))
+	faults := f.SLBFaults().Inject(FaultRule{
+		Operation: "CreateLoadBalancerTCPListener",
+		Err:       ForbiddenFault,
+		Times:     1,
+		Match: func(args ...interface{}) bool {
+			return args[0].(*slb.CreateLoadBalancerTCPListenerArgs).ListenerPort == 443
+		},
+	})
+
+	f.RunCustomized(t, "Retry the listener failed to create",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+			if cloudErrorCodeOf(err) != "Forbidden.RAM" {
+				return fmt.Errorf("expect the listener creation denied, got %v", err)
+			}
+			if resp, _ := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(ctx, LOADBALANCER_ID, 443); resp != nil {
+				return fmt.Errorf("expect the listener 443 absent after the failure")
+			}
+
+			// the retry creates the listener left out, nothing else is recreated
+			if errs := reconcile(f, 1); len(errs) != 0 {
+				return fmt.Errorf("expect the retry succeeded, got %v", errs)
+			}
+			for _, port := range f.SVC.Spec.Ports {
+				if err := listenerRunning(f, port.Port); err != nil {
+					return err
+				}
+			}
+			creates := faults.Calls("CreateLoadBalancerTCPListener")
+			if len(creates) != 2 || creates[0].Err == nil || creates[1].Err != nil {
+				return fmt.Errorf("expect the listener 443 created on the retry, got %v", creates)
+			}
+			if calls := faults.Calls("CreateLoadBalancer", "DeleteLoadBalancer", "DeleteLoadBalancerListener"); len(calls) != 0 {
+				return fmt.Errorf("expect the loadbalancer and the listener 80 kept, got %v", calls)
+			}
+			return nil
+		},
+	)
+}
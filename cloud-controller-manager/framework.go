@@ -5,16 +5,17 @@ import (
 	"fmt"
 	"github.com/denverdino/aliyungo/common"
 	"github.com/denverdino/aliyungo/ecs"
-	"github.com/denverdino/aliyungo/metadata"
 	"github.com/denverdino/aliyungo/pvtz"
 	"github.com/denverdino/aliyungo/slb"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
 	"k8s.io/klog"
 	controller "k8s.io/kube-aggregator/pkg/controllers"
@@ -36,16 +37,23 @@ func DefaultPreset() {
 		// LoadBalancer
 		WithNewLoadBalancerStore(),
 		WithLoadBalancer(),
+		WithAccessControlLists(),
+		WithNewNLBStore(),
 
 		// VPC & Route
 		WithNewRouteStore(),
 		WithVpcs(),
 		WithVRouter(),
+		WithVSwitches(),
 
 		// Instance Store
 		WithNewInstanceStore(),
 		WithInstance(),
 		WithENI(),
+
+		// PrivateZone
+		WithNewPrivateZoneStore(),
+		WithPrivateZone(),
 	)
 }
 
@@ -66,8 +74,11 @@ var (
 	// do not change LOADBALANCER_NAME unless needed
 	LOADBALANCER_NAME         = "ac83f8bed812e11e9a0ad00163e0a398"
 	LOADBALANCER_ADDRESS      = "47.97.241.114"
+	LOADBALANCER_IPV6_ADDRESS = "2408:4005:03ff:b500:0000:0000:0000:0001"
 	LOADBALANCER_NETWORK_TYPE = "classic"
 	LOADBALANCER_SPEC         = slb.LoadBalancerSpecType(slb.S1Small)
+	ACL_ID                    = "acl-idxxx"
+	ACL_ID2                   = "acl-idyyy"
 
 	SERVICE_UID = types.UID("2cb99d47-cc83-11e8-99db-00163e125603")
 )
@@ -78,6 +89,7 @@ var (
 	ROUTE_TABLE_ID = "vtb-2zedne8cr43rp5oqsr9xg"
 	REGION         = common.Hangzhou
 	REGION_A       = "cn-hangzhou-a"
+	REGION_B       = "cn-hangzhou-b"
 	VSWITCH_ID     = "vsw-2zeclpmxy66zzxj4cg4ls"
 	ROUTE_ENTRIES  = []ecs.RouteEntrySetType{
 		{
@@ -130,11 +142,18 @@ var (
 )
 
 func NewMockCloud() (*Cloud, error) {
+	return NewMockCloudWithMetaData(nil)
+}
+
+// NewMockCloudWithMetaData the mock cloud with the metadata client, eg. of a
+// MockMetaDataServer scripted to fail. The values of NewMockMetaDataServer are
+// served if nil.
+func NewMockCloudWithMetaData(meta IMetaData) (*Cloud, error) {
 	return newMockCloudWithSDK(
 		&mockClientSLB{},
 		&mockRouteSDK{},
 		&mockClientInstanceSDK{},
-		nil,
+		meta,
 	)
 }
 
@@ -142,33 +161,21 @@ func newMockCloudWithSDK(
 	slb ClientSLBSDK,
 	route RouteSDK,
 	ins ClientInstanceSDK,
-	meta *metadata.MetaData,
+	meta IMetaData,
 ) (*Cloud, error) {
 
 	if meta == nil {
-		meta = metadata.NewMockMetaData(
-			nil,
-			func(resource string) (string, error) {
-				if strings.Contains(resource, metadata.REGION) {
-					return string(REGION), nil
-				}
-				if strings.Contains(resource, metadata.VPC_ID) {
-					return VPCID, nil
-				}
-				if strings.Contains(resource, metadata.VSWITCH_ID) {
-					return VSWITCH_ID, nil
-				}
-				return "", fmt.Errorf("not found")
-			},
-		)
+		meta = NewMockMetaDataServer().MetaData()
 	}
 	mgr := &ClientMgr{
 		stop:         make(<-chan struct{}, 1),
 		meta:         meta,
-		loadbalancer: &LoadBalancerClient{c: slb, ins: ins, vpcid: VPCID},
+		loadbalancer: &LoadBalancerClient{c: slb, ins: ins, vpc: route, vpcid: VPCID},
 		routes:       &RoutesClient{client: route, region: string(REGION)},
 		instance:     &InstanceClient{c: ins},
+		privateZone:  &PrivateZoneClient{c: &mockClientPVTZ{}},
 	}
+	mgr.nlb = &NLBClient{c: &mockClientNLB{}, clb: mgr.loadbalancer, vpcid: VPCID}
 
 	return newAliCloud(mgr, "")
 }
@@ -287,6 +294,32 @@ func (f *FrameWork) SLBSDK() ClientSLBSDK           { return f.Cloud.climgr.Load
 func (f *FrameWork) RouteSDK() RouteSDK             { return f.Cloud.climgr.Routes().client }
 func (f *FrameWork) InstanceSDK() ClientInstanceSDK { return f.Cloud.climgr.Instances().c }
 func (f *FrameWork) PVTZSDK() ClientPVTZSDK         { return f.Cloud.climgr.PrivateZones().c }
+func (f *FrameWork) NLBSDK() ClientNLBSDK           { return f.Cloud.climgr.NLBs().c }
+
+// SLBFaults the fault injector of the mock slb sdk, created on the first call
+func (f *FrameWork) SLBFaults() *FaultInjector {
+	mock, ok := f.SLBSDK().(*mockClientSLB)
+	if !ok {
+		panic(fmt.Sprintf("faults injected into an unexpected slb sdk %T", f.SLBSDK()))
+	}
+	if mock.faults == nil {
+		mock.faults = NewFaultInjector(1)
+	}
+	return mock.faults
+}
+
+// EnsureWithEvents ensure the service of the framework, return its status and
+// the events recorded, one per line
+func (f *FrameWork) EnsureWithEvents() (*v1.LoadBalancerStatus, string, error) {
+	recorder := record.NewFakeRecorder(100)
+	ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+	status, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	return status, strings.Join(events, "\n"), err
+}
 
 func (f *FrameWork) hasAnnotation(anno string) bool { return serviceAnnotation(f.SVC, anno) != "" }
 
@@ -317,17 +350,22 @@ func (f *FrameWork) RunCustomized(
 
 func (f *FrameWork) Run(run CustomizedTest) error {
 	// initialize kubernetes client
-	f.Cloud.kclient = fake.NewSimpleClientset(f.Endpoint, f.SVC)
+	objects := []runtime.Object{f.Endpoint, f.SVC}
+	for _, node := range f.Nodes {
+		objects = append(objects, node)
+	}
+	f.Cloud.kclient = fake.NewSimpleClientset(objects...)
 	// initialize shared informer factory before run any test.
 	f.Cloud.ifactory = informers.NewSharedInformerFactory(
 		f.Cloud.kclient, 0,
 	)
 	// set informer
 	inform := f.Cloud.ifactory.Core().V1().Endpoints().Informer()
+	nodes := f.Cloud.ifactory.Core().V1().Nodes().Informer()
 	f.Cloud.ifactory.Start(nil)
 
 	if !controller.WaitForCacheSync(
-		"service", nil, inform.HasSynced,
+		"service", nil, inform.HasSynced, nodes.HasSynced,
 	) {
 		return fmt.Errorf("unable to initialize endpoint informer")
 	}
@@ -413,7 +451,11 @@ func ExpectExistAndEqual(f *FrameWork) error {
 		found := false
 		for _, v := range mlb.ListenerPortsAndProtocol.ListenerPortAndProtocol {
 
-			proto, err := Protocol(serviceAnnotation(f.SVC, ServiceAnnotationLoadBalancerProtocolPort), p)
+			psvc, err := ServiceForPort(f.SVC, p)
+			if err != nil {
+				return fmt.Errorf("port overrides error: %s", err.Error())
+			}
+			proto, err := Protocol(serviceAnnotation(psvc, ServiceAnnotationLoadBalancerProtocolPort), p)
 			if err != nil {
 				return fmt.Errorf("proto transfor error")
 			}
@@ -790,7 +832,16 @@ func (f *FrameWork) ListenerEqual(ctx context.Context, id string, p v1.ServicePo
 		aclType   string
 
 		scheduler string
+
+		// header & gzip switches of http and https
+		headers []slb.FlagType
 	)
+	// compare with the annotations overridden for this port.
+	psvc, err := ServiceForPort(f.SVC, p)
+	if err != nil {
+		return err
+	}
+	f = &FrameWork{Cloud: f.Cloud, SVC: psvc, Nodes: f.Nodes, Endpoint: f.Endpoint, CloudDataMock: f.CloudDataMock}
 	defd, _ := ExtractAnnotationRequest(f.SVC)
 	switch proto {
 	case "tcp":
@@ -799,8 +850,7 @@ func (f *FrameWork) ListenerEqual(ctx context.Context, id string, p v1.ServicePo
 			return err
 		}
 		if resp.BackendServerPort == 0 ||
-			(!IsENIBackendType(f.SVC) && resp.BackendServerPort != int(p.NodePort)) ||
-			(IsENIBackendType(f.SVC) && resp.BackendServerPort != int(p.TargetPort.IntVal)) {
+			resp.BackendServerPort != int(backendPort(f.SVC, p)) {
 			return fmt.Errorf("TCPBackendServerPortNotEqual")
 		}
 
@@ -828,11 +878,14 @@ func (f *FrameWork) ListenerEqual(ctx context.Context, id string, p v1.ServicePo
 			return err
 		}
 		if resp.BackendServerPort == 0 ||
-			(!IsENIBackendType(f.SVC) && resp.BackendServerPort != int(p.NodePort)) ||
-			(IsENIBackendType(f.SVC) && resp.BackendServerPort != int(p.TargetPort.IntVal)) {
+			resp.BackendServerPort != int(backendPort(f.SVC, p)) {
 			return fmt.Errorf("UDPBackendServerPortNotEqual")
 		}
 
+		if resp.PersistenceTimeout != nil {
+			persistenceTimeout = *resp.PersistenceTimeout
+		}
+
 		healthCheckInterval = resp.HealthCheckInterval
 		healthCheckConnectPort = resp.HealthCheckConnectPort
 		healthCheckConnectTimeout = resp.HealthCheckConnectTimeout
@@ -849,8 +902,7 @@ func (f *FrameWork) ListenerEqual(ctx context.Context, id string, p v1.ServicePo
 			return err
 		}
 		if resp.BackendServerPort == 0 ||
-			(!IsENIBackendType(f.SVC) && resp.BackendServerPort != int(p.NodePort)) ||
-			(IsENIBackendType(f.SVC) && resp.BackendServerPort != int(p.TargetPort.IntVal)) {
+			resp.BackendServerPort != int(backendPort(f.SVC, p)) {
 			return fmt.Errorf("HTTPBackendServerPortNotEqual: %v, %v,%v", resp.BackendServerPort, p.NodePort, p.Port)
 		}
 
@@ -871,17 +923,31 @@ func (f *FrameWork) ListenerEqual(ctx context.Context, id string, p v1.ServicePo
 		aclStatus = resp.AclStatus
 		aclType = resp.AclType
 		scheduler = string(resp.Scheduler)
+		for _, flag := range httpHeaders(&resp.HTTPListenerType) {
+			headers = append(headers, *flag)
+		}
 	case "https":
 		resp, err := f.SLBSDK().DescribeLoadBalancerHTTPSListenerAttribute(ctx, id, int(p.Port))
 		if err != nil {
 			return err
 		}
 		if resp.BackendServerPort == 0 ||
-			(!IsENIBackendType(f.SVC) && resp.BackendServerPort != int(p.NodePort)) ||
-			(IsENIBackendType(f.SVC) && resp.BackendServerPort != int(p.TargetPort.IntVal)) {
+			resp.BackendServerPort != int(backendPort(f.SVC, p)) {
 			return fmt.Errorf("HTTPSBackendServerPortNotEqual")
 		}
-		if resp.ServerCertificateId == "" ||
+		if serviceAnnotation(f.SVC, utils.ServiceAnnotationLoadBalancerCertSecret) != "" {
+			// bound to the certificate uploaded from the secret
+			certs, err := f.SLBSDK().DescribeServerCertificates(
+				ctx, &DescribeServerCertificatesArgs{ServerCertificateId: resp.ServerCertificateId},
+			)
+			if err != nil {
+				return err
+			}
+			if len(certs) != 1 ||
+				!strings.HasPrefix(certs[0].ServerCertificateName, certificateNamePrefix(f.SVC)) {
+				return fmt.Errorf("HTTPSCertSecretNotBound")
+			}
+		} else if resp.ServerCertificateId == "" ||
 			resp.ServerCertificateId != defd.CertID {
 			return fmt.Errorf("HTTPSCertIDNotEqual")
 		}
@@ -902,9 +968,19 @@ func (f *FrameWork) ListenerEqual(ctx context.Context, id string, p v1.ServicePo
 		aclStatus = resp.AclStatus
 		aclType = resp.AclType
 		scheduler = string(resp.Scheduler)
+		for _, flag := range httpHeaders(&resp.HTTPListenerType) {
+			headers = append(headers, *flag)
+		}
 	default:
 		return fmt.Errorf("unknown proto: %s", proto)
 	}
+	// --------------------------- headers ---------------------------
+	_, reqd := ExtractAnnotationRequest(f.SVC)
+	for i, want := range requestHTTPHeaders(reqd) {
+		if len(headers) != 0 && want != "" && headers[i] != want {
+			return fmt.Errorf("http header switch %d error: %s, %s", i, headers[i], want)
+		}
+	}
 	// --------------------------- acl ---------------------------
 	if f.hasAnnotation(ServiceAnnotationLoadBalancerAclID) {
 		if aclId != string(defd.AclID) {
@@ -926,7 +1002,7 @@ func (f *FrameWork) ListenerEqual(ctx context.Context, id string, p v1.ServicePo
 
 	if f.hasAnnotation(ServiceAnnotationLoadBalancerScheduler) {
 		if scheduler != defd.Scheduler {
-			return fmt.Errorf("scheduler type error")
+			return fmt.Errorf("scheduler type error: %s, %s", scheduler, defd.Scheduler)
 		}
 	}
 	// --------------------------- SessionStick ----------------------------
@@ -938,31 +1014,35 @@ func (f *FrameWork) ListenerEqual(ctx context.Context, id string, p v1.ServicePo
 		}
 	}
 
-	if (proto == "http" || proto == "https") &&
+	// session attributes not applying to the sticky session mode are cleared.
+	sticky := defd.StickySession == slb.OnFlag
+	if (proto == "http" || proto == "https") && sticky &&
 		f.hasAnnotation(ServiceAnnotationLoadBalancerSessionStickType) {
 		if sessionStickType != string(defd.StickySessionType) {
 			return fmt.Errorf("stick session type error")
 		}
 	}
 
-	if (proto == "http" || proto == "https") &&
+	if (proto == "http" || proto == "https") && sticky &&
+		defd.StickySessionType == slb.InsertStickySessionType &&
 		f.hasAnnotation(ServiceAnnotationLoadBalancerCookieTimeout) {
 		if cookieTimeout != defd.CookieTimeout {
 			return fmt.Errorf("cookie timeout error")
 		}
 	}
 
-	if (proto == "http" || proto == "https") &&
+	if (proto == "http" || proto == "https") && sticky &&
+		defd.StickySessionType == slb.ServerStickySessionType &&
 		f.hasAnnotation(ServiceAnnotationLoadBalancerCookie) {
 		if cookie != string(defd.Cookie) {
 			return fmt.Errorf("cookie error")
 		}
 	}
 
-	if proto == "tcp" &&
-		f.hasAnnotation(ServiceAnnotationLoadBalancerPersistenceTimeout) {
-		if persistenceTimeout != *defd.PersistenceTimeout {
-			return fmt.Errorf("persistency timeout error: %d, %d", persistenceTimeout, defd.PersistenceTimeout)
+	if proto == "tcp" || proto == "udp" {
+		want, _ := PersistenceTimeout(f.SVC)
+		if persistenceTimeout != want {
+			return fmt.Errorf("persistency timeout error: %d, %d", persistenceTimeout, want)
 		}
 	}
 
@@ -974,33 +1054,44 @@ func (f *FrameWork) ListenerEqual(ctx context.Context, id string, p v1.ServicePo
 		}
 	}
 
+	// probe parameters are not synced while tcp & udp health check is switched off.
+	probing := (proto != "tcp" && proto != "udp") || defd.HealthCheckSwitch != string(slb.OffFlag)
+
 	// Health checks with TCP type only work with listeners of the same protocol.
-	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckConnectPort) {
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckConnectPort) && probing {
 		if healthCheckConnectPort != defd.HealthCheckConnectPort && healthCheckType == proto {
 			return fmt.Errorf("health check connect port error")
 		}
 	}
 
-	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold) {
+	// Local traffic policy services probe the healthCheckNodePort.
+	if local := (&Listener{Service: f.SVC}).localHealthCheckPort(); local != 0 && proto != "udp" && probing &&
+		!f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckConnectPort) {
+		if healthCheckConnectPort != local {
+			return fmt.Errorf("local health check connect port error: %d, %d", healthCheckConnectPort, local)
+		}
+	}
+
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold) && probing {
 		if healthCheckHealthyThreshold != defd.HealthyThreshold && healthCheckType == proto {
 			return fmt.Errorf("health check health threshold error")
 		}
 	}
 
-	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold) {
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold) && probing {
 		if healthCheckUnhealthyThreshold != defd.UnhealthyThreshold && healthCheckType == proto {
 			return fmt.Errorf("health check unhealthy threshold error")
 		}
 	}
 
-	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckInterval) {
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckInterval) && probing {
 		if healthCheckInterval != defd.HealthCheckInterval && healthCheckType == proto {
 			return fmt.Errorf("health check interval error")
 		}
 	}
 
 	if (proto == "tcp" || proto == "udp") &&
-		f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckConnectTimeout) {
+		f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckConnectTimeout) && probing {
 		if healthCheckConnectTimeout != defd.HealthCheckConnectTimeout {
 			return fmt.Errorf("health check connect timeout error")
 		}
@@ -1012,19 +1103,21 @@ func (f *FrameWork) ListenerEqual(ctx context.Context, id string, p v1.ServicePo
 		}
 	}
 
-	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckURI) {
+	// tcp listener only keeps the http settings when checking in http mode.
+	httpCheck := proto != "tcp" || healthCheckType == string(slb.HTTPHealthCheckType)
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckURI) && httpCheck {
 		if healthCheckURI != string(defd.HealthCheckURI) {
 			return fmt.Errorf("health check URI error")
 		}
 	}
 
-	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckDomain) {
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckDomain) && httpCheck {
 		if healthCheckDomain != string(defd.HealthCheckDomain) {
 			return fmt.Errorf("health check domain error")
 		}
 	}
 
-	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckHTTPCode) {
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerHealthCheckHTTPCode) && httpCheck {
 		if healthCheckHTTPCode != string(defd.HealthCheckHttpCode) {
 			return fmt.Errorf("health check http code error")
 		}
@@ -1075,18 +1168,10 @@ func tagsEqual(tags string, items []slb.TagItemType) bool {
 	return true
 }
 
-const labelNodeRoleMaster = "node-role.kubernetes.io/master"
-
 func filterOutMaster(nodes []*v1.Node) []*v1.Node {
 	var result []*v1.Node
 	for _, node := range nodes {
-		found := false
-		for k := range node.Labels {
-			if k == labelNodeRoleMaster {
-				found = true
-			}
-		}
-		if !found {
+		if !isMasterNode(node) {
 			result = append(result, node)
 		}
 	}
@@ -1131,16 +1216,13 @@ func isUserManagedVBackendServer(VServerGroupName string, service *v1.Service) b
 		vg := &vgroup{
 			NamedKey: &NamedKey{
 				CID:         CLUSTER_ID,
-				Port:        port.NodePort,
+				Port:        backendPort(service, port),
 				Namespace:   service.Namespace,
 				ServiceName: service.Name,
 				Prefix:      DEFAULT_PREFIX,
 			},
 		}
-		if IsENIBackendType(service) {
-			vg.NamedKey.Port = port.TargetPort.IntVal
-		}
-		if vg.NamedKey.Key() == VServerGroupName {
+		if vg.NamedKey.VGroupName() == VServerGroupName {
 			return false
 		}
 	}
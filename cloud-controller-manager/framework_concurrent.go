@@ -0,0 +1,110 @@
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ConcurrentReconcile a reconcile of a worker, run together with the others
+// by RunConcurrently
+type ConcurrentReconcile struct {
+	// Worker the name of the worker, its calls of the mock slb sdk are
+	// recorded by it
+	Worker string
+	Run    func(ctx context.Context, f *FrameWork) error
+}
+
+// EnsureConcurrently ensure the loadbalancer of the service with the nodes
+func EnsureConcurrently(worker string, svc *v1.Service, nodes []*v1.Node) ConcurrentReconcile {
+	return ConcurrentReconcile{
+		Worker: worker,
+		Run: func(ctx context.Context, f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, svc, nodes)
+			return err
+		},
+	}
+}
+
+// UpdateConcurrently update the backends of the loadbalancer of the service
+// to the nodes
+func UpdateConcurrently(worker string, svc *v1.Service, nodes []*v1.Node) ConcurrentReconcile {
+	return ConcurrentReconcile{
+		Worker: worker,
+		Run: func(ctx context.Context, f *FrameWork) error {
+			return f.CloudImpl().UpdateLoadBalancer(ctx, CLUSTER_ID, svc, nodes)
+		},
+	}
+}
+
+// DeleteConcurrently delete the loadbalancer of the service
+func DeleteConcurrently(worker string, svc *v1.Service) ConcurrentReconcile {
+	return ConcurrentReconcile{
+		Worker: worker,
+		Run: func(ctx context.Context, f *FrameWork) error {
+			return f.CloudImpl().EnsureLoadBalancerDeleted(ctx, CLUSTER_ID, svc)
+		},
+	}
+}
+
+// RunConcurrently run the reconciles together against the shared mock cloud,
+// released at once in each of the rounds, then verify the state by check.
+// The unit tests run with the race detector, and the mock slb sdk serializes
+// its own calls, so that a race reported is one of the controller.
+//
+// The errors of the reconciles do not fail the test by themselves, a worker
+// may well lose a race and retry as the service controller does. A failed
+// check dumps the calls of the mock slb sdk interleaved by the workers, with
+// the errors of the rounds.
+func (f *FrameWork) RunConcurrently(
+	t *testing.T,
+	describe string,
+	rounds int,
+	check CustomizedTest,
+	reconciles ...ConcurrentReconcile,
+) {
+	t.Log(describe)
+	faults := f.SLBFaults()
+	var failures []string
+	err := f.Run(
+		func(f *FrameWork) error {
+			for round := 0; round < rounds; round++ {
+				start := make(chan struct{})
+				errs := make([]error, len(reconciles))
+				var wg sync.WaitGroup
+				for i, r := range reconciles {
+					wg.Add(1)
+					go func(i int, r ConcurrentReconcile) {
+						defer wg.Done()
+						<-start
+						errs[i] = r.Run(WithMockCaller(context.Background(), r.Worker), f)
+					}(i, r)
+				}
+				close(start)
+				wg.Wait()
+				for i, err := range errs {
+					if err != nil {
+						failures = append(failures, fmt.Sprintf("round %d, %s: %s", round, reconciles[i].Worker, err.Error()))
+					}
+				}
+			}
+			return check(f)
+		},
+	)
+	if err != nil {
+		t.Fatalf("RunConcurrently: %s, %s\nerrors:\n\t%s\ncalls:\n%s",
+			describe, err.Error(), strings.Join(failures, "\n\t"), interleaving(faults.Calls()))
+	}
+}
+
+// interleaving the calls numbered in order
+func interleaving(calls []MockCall) string {
+	lines := make([]string, len(calls))
+	for i, call := range calls {
+		lines[i] = fmt.Sprintf("\t%4d %s", i, call)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,117 @@
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"strings"
+	"testing"
+)
+
+func concurrentNodes(ids ...string) []*v1.Node {
+	var nodes []*v1.Node
+	for _, id := range ids {
+		prid := nodeid(string(REGION), id)
+		nodes = append(nodes, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: prid},
+			Spec:       v1.NodeSpec{ProviderID: prid},
+		})
+	}
+	return nodes
+}
+
+// expectConverged the loadbalancer of the service as expected
+func expectConverged(f *FrameWork, svc *v1.Service, nodes []*v1.Node) error {
+	g := &FrameWork{Cloud: f.Cloud, SVC: svc, Nodes: nodes, Endpoint: f.Endpoint}
+	diff, err := g.LoadBalancerDiff(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(diff) != 0 {
+		return fmt.Errorf("%s/%s not as expected:\n\t%s", svc.Namespace, svc.Name, strings.Join(diff, "\n\t"))
+	}
+	return nil
+}
+
+func TestConcurrentServicesSharingLoadBalancer(t *testing.T) {
+	shared := func() map[string]string {
+		return map[string]string{
+			ServiceAnnotationLoadBalancerId:               LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerOverrideListener: "true",
+		}
+	}
+	svcA := testServiceNamed("service-a", "uid-service-a", shared(),
+		v1.ServicePort{Port: 8081, TargetPort: intstr.FromInt(8081), Protocol: v1.ProtocolTCP, NodePort: 30081})
+	svcB := testServiceNamed("service-b", "uid-service-b", shared(),
+		v1.ServicePort{Port: 8082, TargetPort: intstr.FromInt(8082), Protocol: v1.ProtocolTCP, NodePort: 30082})
+	nodes := concurrentNodes(INSTANCEID, INSTANCEID2)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(svcA).WithNodes(nodes)
+
+	f.RunConcurrently(t, "Two workers ensure the services of one loadbalancer", 5,
+		func(f *FrameWork) error {
+			// a worker never removes the listener of the other service
+			for _, port := range []int32{8081, 8082} {
+				if err := listenerRunning(f, port); err != nil {
+					return err
+				}
+			}
+			if err := expectConverged(f, svcA, nodes); err != nil {
+				return err
+			}
+			return expectConverged(f, svcB, nodes)
+		},
+		EnsureConcurrently("worker-a", svcA, nodes),
+		EnsureConcurrently("worker-b", svcB, nodes),
+	)
+}
+
+func TestConcurrentDeleteRacingRecreatedService(t *testing.T) {
+	nodes := concurrentNodes(INSTANCEID)
+	old := testService(map[string]string{})
+	// the service deleted and created again under the same name
+	recreated := testServiceNamed("my-service", "uid-recreated", map[string]string{})
+	f := NewDefaultFrameWork(nil)
+	f.WithService(old).WithNodes(nodes)
+	f.RunCustomized(t, "Loadbalancer of the deleted service",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, old, nodes)
+			return err
+		},
+	)
+
+	f.RunConcurrently(t, "Delete of the old service racing the update of the new", 3,
+		func(f *FrameWork) error {
+			// the delete of the old uid never takes the loadbalancer of the new one
+			if err := ExpectNotExist(&FrameWork{Cloud: f.Cloud, SVC: old}); err != nil {
+				return err
+			}
+			return expectConverged(f, recreated, nodes)
+		},
+		DeleteConcurrently("worker-delete", old),
+		EnsureConcurrently("worker-update", recreated, nodes),
+	)
+}
+
+func TestConcurrentNodeChurnDuringEnsure(t *testing.T) {
+	before := concurrentNodes(INSTANCEID)
+	after := concurrentNodes(INSTANCEID, INSTANCEID2)
+	svc := testService(map[string]string{})
+	f := NewDefaultFrameWork(nil)
+	f.WithService(svc).WithNodes(after)
+
+	f.RunConcurrently(t, "Nodes added while the service is ensured", 5,
+		func(f *FrameWork) error {
+			// the node controller syncs the backends once more with the
+			// nodes of the cluster, which must converge whatever the race left
+			if err := f.CloudImpl().UpdateLoadBalancer(context.Background(), CLUSTER_ID, svc, after); err != nil {
+				return err
+			}
+			return expectConverged(f, svc, after)
+		},
+		EnsureConcurrently("worker-service", svc, before),
+		UpdateConcurrently("worker-node", svc, after),
+	)
+}
@@ -0,0 +1,373 @@
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// SLBState the attributes of a loadbalancer keyed by their path, eg.
+// "listener 80/scheduler": "wrr" or "listener 80/backend i-xxx": "100".
+// The expected state of a service holds the attributes decided by its
+// annotations, ports and endpoints, and the loadbalancer is compared with
+// it attribute by attribute, so that a mismatch reads as a diff.
+type SLBState map[string]string
+
+// ANY_VALUE an expected attribute of any value, eg. the weights of the
+// backends weighted by pod count
+const ANY_VALUE = "*"
+
+// Diff the attributes of actual not as expected, sorted by path. The
+// backends are compared both ways, those not expected are reported too.
+func (s SLBState) Diff(actual SLBState) []string {
+	var diff []string
+	for path, want := range s {
+		got, ok := actual[path]
+		switch {
+		case !ok:
+			diff = append(diff, fmt.Sprintf("%s: expect %q, got none", path, want))
+		case want != ANY_VALUE && got != want:
+			diff = append(diff, fmt.Sprintf("%s: expect %q, got %q", path, want, got))
+		}
+	}
+	for path, got := range actual {
+		if _, ok := s[path]; !ok && strings.Contains(path, "/backend ") {
+			diff = append(diff, fmt.Sprintf("%s: expect none, got %q", path, got))
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// ExpectLoadBalancerEqual fail the test with the diff of the loadbalancer
+// of the service and the state expected from the service
+func ExpectLoadBalancerEqual(t *testing.T, f *FrameWork) {
+	t.Helper()
+	diff, err := f.LoadBalancerDiff(context.Background())
+	if err != nil {
+		t.Fatalf("diff loadbalancer: %s", err.Error())
+	}
+	if len(diff) != 0 {
+		t.Fatalf("loadbalancer not as expected:\n\t%s", strings.Join(diff, "\n\t"))
+	}
+}
+
+// LoadBalancerDiff the diff of the loadbalancer of the service and the
+// state expected from the service, empty if equal
+func (f *FrameWork) LoadBalancerDiff(ctx context.Context) ([]string, error) {
+	ctx = context.WithValue(ctx, utils.ContextService, f.SVC)
+	exist, mlb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+	if err != nil || !exist {
+		return nil, fmt.Errorf("slb must exist: %v, %v", exist, err)
+	}
+	expect, err := f.ExpectedState()
+	if err != nil {
+		return nil, fmt.Errorf("expected state: %s", err.Error())
+	}
+	actual, err := f.ActualState(ctx, mlb)
+	if err != nil {
+		return nil, fmt.Errorf("actual state: %s", err.Error())
+	}
+	return expect.Diff(actual), nil
+}
+
+// ExpectedState the state of the loadbalancer expected from the service.
+// Only the annotated attributes are expected, the others are left to the
+// defaults of the cloud.
+func (f *FrameWork) ExpectedState() (SLBState, error) {
+	state := SLBState{}
+	defd, _ := ExtractAnnotationRequest(f.SVC)
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerAddressType) {
+		state["address-type"] = string(defd.AddressType)
+	}
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerSpec) {
+		state["spec"] = string(defd.LoadBalancerSpec)
+	}
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerBandwidth) {
+		state["bandwidth"] = strconv.Itoa(defd.Bandwidth)
+	}
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerChargeType) {
+		state["charge-type"] = string(defd.ChargeType)
+	}
+	if f.hasAnnotation(ServiceAnnotationLoadBalancerAdditionalTags) {
+		for _, tag := range strings.Split(serviceAnnotation(f.SVC, ServiceAnnotationLoadBalancerAdditionalTags), ",") {
+			kv := strings.SplitN(tag, "=", 2)
+			if len(kv) == 2 {
+				state["tag "+kv[0]] = kv[1]
+			}
+		}
+	}
+
+	for _, p := range f.SVC.Spec.Ports {
+		psvc, err := ServiceForPort(f.SVC, p)
+		if err != nil {
+			return nil, fmt.Errorf("port overrides error: %s", err.Error())
+		}
+		proto, err := Protocol(serviceAnnotation(psvc, ServiceAnnotationLoadBalancerProtocolPort), p)
+		if err != nil {
+			return nil, err
+		}
+		path := fmt.Sprintf("listener %d", p.Port)
+		state[path+"/protocol"] = proto
+		if f.hasAnnotation(ServiceAnnotationLoadBalancerForwardPort) && proto == "http" {
+			// forwarded to the https listener, no backends of its own
+			continue
+		}
+		state[path+"/backend-port"] = strconv.Itoa(int(backendPort(psvc, p)))
+		expectListener(state, path, psvc, p, proto)
+
+		if serviceAnnotation(psvc, ServiceAnnotationLoadBalancerVGroupPort) != "" {
+			// the backends of the user managed vserver groups are left alone
+			continue
+		}
+		backends, err := f.expectedBackends(psvc)
+		if err != nil {
+			return nil, err
+		}
+		for id, weight := range backends {
+			state[path+"/backend "+id] = weight
+		}
+	}
+	return state, nil
+}
+
+// expectListener the annotated attributes of the listener of the port
+func expectListener(state SLBState, path string, psvc *v1.Service, p v1.ServicePort, proto string) {
+	defd, _ := ExtractAnnotationRequest(psvc)
+	annotated := func(anno string) bool { return serviceAnnotation(psvc, anno) != "" }
+
+	if annotated(ServiceAnnotationLoadBalancerScheduler) {
+		state[path+"/scheduler"] = defd.Scheduler
+	}
+	if proto == "tcp" && annotated(ServiceAnnotationLoadBalancerHealthCheckType) {
+		state[path+"/health-check-type"] = string(defd.HealthCheckType)
+	}
+	if (proto == "http" || proto == "https") && annotated(ServiceAnnotationLoadBalancerHealthCheckFlag) {
+		state[path+"/health-check"] = string(defd.HealthCheck)
+	}
+
+	// the probe parameters are compared as ListenerEqual does: of the tcp
+	// listeners probing in tcp mode, which are not synced while switched off.
+	// Local traffic policy services probe the healthCheckNodePort in http mode.
+	local := (&Listener{Service: psvc}).localHealthCheckPort() != 0
+	if local && proto != "udp" && defd.HealthCheckSwitch != string(slb.OffFlag) {
+		if !annotated(ServiceAnnotationLoadBalancerHealthCheckConnectPort) {
+			state[path+"/health-check-connect-port"] = strconv.Itoa(int(psvc.Spec.HealthCheckNodePort))
+		}
+		httpProbe := proto != "tcp" || !annotated(ServiceAnnotationLoadBalancerHealthCheckType) ||
+			defd.HealthCheckType == slb.HTTPHealthCheckType
+		if httpProbe && !annotated(ServiceAnnotationLoadBalancerHealthCheckURI) {
+			state[path+"/health-check-uri"] = LOCAL_HEALTH_CHECK_URI
+		}
+	}
+	tcpCheck := proto == "tcp" && !local && defd.HealthCheckType != slb.HTTPHealthCheckType &&
+		defd.HealthCheckSwitch != string(slb.OffFlag)
+	if tcpCheck && annotated(ServiceAnnotationLoadBalancerHealthCheckConnectPort) {
+		state[path+"/health-check-connect-port"] = strconv.Itoa(defd.HealthCheckConnectPort)
+	}
+	if tcpCheck && annotated(ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold) {
+		state[path+"/healthy-threshold"] = strconv.Itoa(defd.HealthyThreshold)
+	}
+	if tcpCheck && annotated(ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold) {
+		state[path+"/unhealthy-threshold"] = strconv.Itoa(defd.UnhealthyThreshold)
+	}
+	if tcpCheck && annotated(ServiceAnnotationLoadBalancerHealthCheckInterval) {
+		state[path+"/health-check-interval"] = strconv.Itoa(defd.HealthCheckInterval)
+	}
+
+	// tcp listener only keeps the http settings when checking in http mode.
+	httpCheck := (proto == "http" || proto == "https") || defd.HealthCheckType == slb.HTTPHealthCheckType
+	if httpCheck && annotated(ServiceAnnotationLoadBalancerHealthCheckURI) {
+		state[path+"/health-check-uri"] = defd.HealthCheckURI
+	}
+	if httpCheck && annotated(ServiceAnnotationLoadBalancerHealthCheckDomain) {
+		state[path+"/health-check-domain"] = defd.HealthCheckDomain
+	}
+}
+
+// expectedBackends the weights of the backends of the port keyed by their
+// server id, or by their ip for the eni backends
+func (f *FrameWork) expectedBackends(psvc *v1.Service) (map[string]string, error) {
+	defd, _ := ExtractAnnotationRequest(psvc)
+	counts := map[string]int{}
+	switch {
+	case IsENIBackendType(psvc):
+		if f.Endpoint != nil {
+			for _, sub := range f.Endpoint.Subsets {
+				for _, addr := range sub.Addresses {
+					counts[addr.IP] = DEFAULT_SERVER_WEIGHT
+				}
+			}
+		}
+	case ServiceModeLocal(psvc):
+		// a backend for the nodes running the pods, weighted by the pods
+		if f.Endpoint != nil {
+			for _, sub := range f.Endpoint.Subsets {
+				for _, addr := range sub.Addresses {
+					if addr.NodeName == nil {
+						continue
+					}
+					node := findNodeByNodeName(f.Nodes, *addr.NodeName)
+					if node == nil || isExcludeNode(node) {
+						continue
+					}
+					_, id, err := nodeFromProviderID(node.Spec.ProviderID)
+					if err != nil {
+						return nil, err
+					}
+					if counts[id] < MAX_SERVER_WEIGHT {
+						counts[id]++
+					}
+				}
+			}
+		}
+	default:
+		for _, node := range filterOutMaster(f.Nodes) {
+			if isExcludeNode(node) ||
+				(defd.BackendLabel != "" && !containsLabel(node, strings.Split(defd.BackendLabel, ","))) {
+				continue
+			}
+			_, id, err := nodeFromProviderID(node.Spec.ProviderID)
+			if err != nil {
+				return nil, err
+			}
+			counts[id] = DEFAULT_SERVER_WEIGHT
+		}
+	}
+
+	backends := map[string]string{}
+	for id, weight := range counts {
+		switch {
+		case isPodCountWeighted(psvc):
+			backends[id] = ANY_VALUE
+		case defd.Weight != 0:
+			backends[id] = strconv.Itoa(defd.Weight)
+		default:
+			backends[id] = strconv.Itoa(weight)
+		}
+	}
+	return backends, nil
+}
+
+// ActualState the state of the loadbalancer, of the attributes expected of
+// the service
+func (f *FrameWork) ActualState(ctx context.Context, mlb *slb.LoadBalancerType) (SLBState, error) {
+	state := SLBState{
+		"address-type": string(mlb.AddressType),
+		"spec":         string(mlb.LoadBalancerSpec),
+		"bandwidth":    strconv.Itoa(mlb.Bandwidth),
+		"charge-type":  string(mlb.InternetChargeType),
+	}
+	tags, _, err := f.SLBSDK().DescribeTags(ctx, &slb.DescribeTagsArgs{LoadBalancerID: mlb.LoadBalancerId})
+	if err != nil {
+		return nil, fmt.Errorf("describe tags: %s", err.Error())
+	}
+	for _, tag := range tags {
+		state["tag "+tag.TagKey] = tag.TagValue
+	}
+
+	for _, p := range f.SVC.Spec.Ports {
+		path := fmt.Sprintf("listener %d", p.Port)
+		proto := ""
+		for _, v := range mlb.ListenerPortsAndProtocol.ListenerPortAndProtocol {
+			if v.ListenerPort == int(p.Port) {
+				proto = v.ListenerProtocol
+				break
+			}
+		}
+		if proto == "" {
+			continue
+		}
+		state[path+"/protocol"] = proto
+		vgroup, err := f.actualListener(ctx, state, path, mlb.LoadBalancerId, p, proto)
+		if err != nil {
+			return nil, err
+		}
+		if vgroup == "" {
+			continue
+		}
+		vg, err := f.SLBSDK().DescribeVServerGroupAttribute(
+			ctx, &slb.DescribeVServerGroupAttributeArgs{VServerGroupId: vgroup},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("vserver group attribute error: %s", err.Error())
+		}
+		for _, b := range vg.BackendServers.BackendServer {
+			id := b.ServerId
+			if b.Type == "eni" {
+				id = b.ServerIp
+			}
+			state[path+"/backend "+id] = strconv.Itoa(b.Weight)
+		}
+	}
+	return state, nil
+}
+
+// actualListener add the attributes of the listener to the state, and
+// return the id of its vserver group
+func (f *FrameWork) actualListener(
+	ctx context.Context, state SLBState, path, id string, p v1.ServicePort, proto string,
+) (string, error) {
+	var (
+		port, interval, connectPort, healthy, unhealthy int
+		scheduler, healthCheck, checkType, uri, domain  string
+		vgroup                                          string
+	)
+	switch proto {
+	case "tcp":
+		resp, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(ctx, id, int(p.Port))
+		if err != nil || resp == nil {
+			return "", fmt.Errorf("describe tcp listener %d: %v", p.Port, err)
+		}
+		port, scheduler, vgroup = resp.BackendServerPort, string(resp.Scheduler), resp.VServerGroupId
+		checkType, connectPort, interval = string(resp.HealthCheckType), resp.HealthCheckConnectPort, resp.HealthCheckInterval
+		healthy, unhealthy = resp.HealthyThreshold, resp.UnhealthyThreshold
+		uri, domain = resp.HealthCheckURI, resp.HealthCheckDomain
+	case "udp":
+		resp, err := f.SLBSDK().DescribeLoadBalancerUDPListenerAttribute(ctx, id, int(p.Port))
+		if err != nil || resp == nil {
+			return "", fmt.Errorf("describe udp listener %d: %v", p.Port, err)
+		}
+		port, scheduler, vgroup = resp.BackendServerPort, string(resp.Scheduler), resp.VServerGroupId
+		connectPort, interval = resp.HealthCheckConnectPort, resp.HealthCheckInterval
+		healthy, unhealthy = resp.HealthyThreshold, resp.UnhealthyThreshold
+	case "http", "https":
+		var listener *slb.HTTPListenerType
+		if proto == "http" {
+			resp, err := f.SLBSDK().DescribeLoadBalancerHTTPListenerAttribute(ctx, id, int(p.Port))
+			if err != nil || resp == nil {
+				return "", fmt.Errorf("describe http listener %d: %v", p.Port, err)
+			}
+			listener = &resp.HTTPListenerType
+		} else {
+			resp, err := f.SLBSDK().DescribeLoadBalancerHTTPSListenerAttribute(ctx, id, int(p.Port))
+			if err != nil || resp == nil {
+				return "", fmt.Errorf("describe https listener %d: %v", p.Port, err)
+			}
+			listener = &resp.HTTPListenerType
+		}
+		port, scheduler, vgroup = listener.BackendServerPort, string(listener.Scheduler), listener.VServerGroupId
+		healthCheck, connectPort, interval = string(listener.HealthCheck), listener.HealthCheckConnectPort, listener.HealthCheckInterval
+		healthy, unhealthy = listener.HealthyThreshold, listener.UnhealthyThreshold
+		uri, domain = listener.HealthCheckURI, listener.HealthCheckDomain
+	default:
+		return "", fmt.Errorf("unknown proto: %s", proto)
+	}
+	state[path+"/backend-port"] = strconv.Itoa(port)
+	state[path+"/scheduler"] = scheduler
+	state[path+"/health-check"] = healthCheck
+	state[path+"/health-check-type"] = checkType
+	state[path+"/health-check-connect-port"] = strconv.Itoa(connectPort)
+	state[path+"/health-check-interval"] = strconv.Itoa(interval)
+	state[path+"/healthy-threshold"] = strconv.Itoa(healthy)
+	state[path+"/unhealthy-threshold"] = strconv.Itoa(unhealthy)
+	state[path+"/health-check-uri"] = uri
+	state[path+"/health-check-domain"] = domain
+	return vgroup, nil
+}
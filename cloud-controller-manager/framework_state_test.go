@@ -0,0 +1,227 @@
+package alicloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"strings"
+	"testing"
+)
+
+func TestSLBStateDiff(t *testing.T) {
+	expect := SLBState{
+		"spec":                        "slb.s2.small",
+		"listener 80/scheduler":       "wlc",
+		"listener 80/backend i-1":     "100",
+		"listener 80/backend i-2":     ANY_VALUE,
+		"listener 80/health-check":    "on",
+		"listener 443/backend-port":   "8443",
+		"listener 443/backend i-3":    "50",
+		"listener 443/health-check":   "off",
+		"listener 443/backend i-gone": "50",
+	}
+	actual := SLBState{
+		"spec":                      "slb.s1.small",
+		"address-type":              "internet",
+		"listener 80/scheduler":     "wlc",
+		"listener 80/backend i-1":   "100",
+		"listener 80/backend i-2":   "7",
+		"listener 80/health-check":  "on",
+		"listener 443/backend-port": "8443",
+		"listener 443/backend i-3":  "20",
+		"listener 443/backend i-4":  "50",
+		"listener 443/health-check": "off",
+	}
+	expectDiff := []string{
+		`listener 443/backend i-3: expect "50", got "20"`,
+		`listener 443/backend i-4: expect none, got "50"`,
+		`listener 443/backend i-gone: expect "50", got none`,
+		`spec: expect "slb.s2.small", got "slb.s1.small"`,
+	}
+	diff := expect.Diff(actual)
+	if strings.Join(diff, "\n") != strings.Join(expectDiff, "\n") {
+		t.Fatalf("expect diff:\n%s\ngot:\n%s", strings.Join(expectDiff, "\n"), strings.Join(diff, "\n"))
+	}
+}
+
+// stateService the service of two tcp ports
+func stateService(annotations map[string]string) *v1.Service {
+	return testService(annotations,
+		v1.ServicePort{Name: "tcp-80", Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+		v1.ServicePort{Name: "tcp-443", Port: 443, TargetPort: intstr.FromInt(8443), Protocol: v1.ProtocolTCP, NodePort: 30443},
+	)
+}
+
+// expectDiff the diff of the loadbalancer holds the line
+func expectDiff(f *FrameWork, line string) error {
+	diff, err := f.LoadBalancerDiff(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, d := range diff {
+		if d == line {
+			return nil
+		}
+	}
+	return fmt.Errorf("expect diff %q, got %v", line, diff)
+}
+
+func TestLoadBalancerStatePortOverrides(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		stateService(map[string]string{
+			ServiceAnnotationLoadBalancerScheduler:                   "wrr",
+			ServiceAnnotationLoadBalancerHealthCheckType:             "tcp",
+			ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold: "4",
+			ServiceAnnotationLoadBalancerPortOverrides:               `{"443":{"scheduler":"wlc","healthy-threshold":"8"}}`,
+		}),
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Listeners of the overridden port",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			expect, err := f.ExpectedState()
+			if err != nil {
+				return err
+			}
+			if expect["listener 80/scheduler"] != "wrr" || expect["listener 443/scheduler"] != "wlc" ||
+				expect["listener 80/healthy-threshold"] != "4" || expect["listener 443/healthy-threshold"] != "8" {
+				return fmt.Errorf("expect the overrides of the port 443 expected, got %v", expect)
+			}
+			ExpectLoadBalancerEqual(t, f)
+
+			// the listener drifted from the override is reported
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			resp, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(context.Background(), lb.LoadBalancerId, 443)
+			if err != nil || resp == nil {
+				return fmt.Errorf("describe tcp listener: %v", err)
+			}
+			drifted := slb.SetLoadBalancerTCPListenerAttributeArgs(resp.TCPListenerType)
+			drifted.LoadBalancerId = lb.LoadBalancerId
+			drifted.Scheduler = "wrr"
+			if err := f.SLBSDK().SetLoadBalancerTCPListenerAttribute(context.Background(), &drifted); err != nil {
+				return err
+			}
+			return expectDiff(f, `listener 443/scheduler: expect "wlc", got "wrr"`)
+		},
+	)
+}
+
+func TestLoadBalancerStateBackendWeight(t *testing.T) {
+	prid1 := nodeid(string(REGION), INSTANCEID)
+	prid2 := nodeid(string(REGION), INSTANCEID2)
+	svc := stateService(map[string]string{})
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Spec.HealthCheckNodePort = 32000
+	f := NewDefaultFrameWork(nil)
+	f.WithService(svc).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid1},
+				Spec:       v1.NodeSpec{ProviderID: prid1},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid2},
+				Spec:       v1.NodeSpec{ProviderID: prid2},
+			},
+		},
+	).WithEndpoints(
+		// two pods on the first node, one on the second
+		&v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-service", Namespace: "default"},
+			Subsets: []v1.EndpointSubset{
+				{
+					Addresses: []v1.EndpointAddress{
+						{IP: "10.0.0.1", NodeName: &prid1},
+						{IP: "10.0.0.2", NodeName: &prid1},
+						{IP: "10.0.0.3", NodeName: &prid2},
+					},
+					Ports: []v1.EndpointPort{{Port: 8443}},
+				},
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Local backends weighted by their pods",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			expect, err := f.ExpectedState()
+			if err != nil {
+				return err
+			}
+			path := fmt.Sprintf("listener %d/backend ", listenPort1)
+			if expect[path+INSTANCEID] != "2" || expect[path+INSTANCEID2] != "1" {
+				return fmt.Errorf("expect the nodes weighted by their pods, got %v", expect)
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerWeight] = "50"
+	f.RunCustomized(t, "Weight annotation over the pods",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			ExpectLoadBalancerEqual(t, f)
+
+			// the backend removed out of band is reported
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			resp, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(context.Background(), lb.LoadBalancerId, 443)
+			if err != nil || resp == nil {
+				return fmt.Errorf("describe tcp listener: %v", err)
+			}
+			vg, err := f.SLBSDK().DescribeVServerGroupAttribute(
+				context.Background(), &slb.DescribeVServerGroupAttributeArgs{VServerGroupId: resp.VServerGroupId},
+			)
+			if err != nil {
+				return err
+			}
+			var removed []slb.VBackendServerType
+			for _, b := range vg.BackendServers.BackendServer {
+				if b.ServerId == INSTANCEID2 {
+					removed = append(removed, b)
+				}
+			}
+			backends, err := json.Marshal(removed)
+			if err != nil {
+				return err
+			}
+			_, err = f.SLBSDK().RemoveVServerGroupBackendServers(
+				context.Background(),
+				&slb.RemoveVServerGroupBackendServersArgs{
+					LoadBalancerId: lb.LoadBalancerId,
+					VServerGroupId: resp.VServerGroupId,
+					BackendServers: string(backends),
+				},
+			)
+			if err != nil {
+				return err
+			}
+			return expectDiff(f, fmt.Sprintf(`listener 443/backend %s: expect "50", got none`, INSTANCEID2))
+		},
+	)
+}
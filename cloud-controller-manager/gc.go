@@ -0,0 +1,219 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/controller"
+	"sort"
+	"time"
+)
+
+// Ownership tags of the loadbalancers created by the cloudprovider, together
+// with ACKKEY they tell the service a loadbalancer is created for.
+const (
+	SERVICENAMESPACEKEY = "kubernetes.service.namespace"
+	SERVICENAMEKEY      = "kubernetes.service.name"
+	SERVICEUIDKEY       = "kubernetes.service.uid"
+)
+
+// ownershipTags tags stamped on the loadbalancer created for the service
+func ownershipTags(service *v1.Service) map[string]string {
+	return map[string]string{
+		TAGKEY:              GetLoadBalancerName(service),
+		ACKKEY:              CLUSTER_ID,
+		SERVICENAMESPACEKEY: service.Namespace,
+		SERVICENAMEKEY:      service.Name,
+		SERVICEUIDKEY:       string(service.UID),
+	}
+}
+
+// LoadBalancerGCOptions options of the orphan loadbalancer garbage collector
+type LoadBalancerGCOptions struct {
+	// Enabled run the garbage collector periodically
+	Enabled bool
+	// Period interval between two collections
+	Period time.Duration
+	// DryRun only report the orphan loadbalancers without deleting them
+	DryRun bool
+}
+
+// GCOptions global options of the orphan loadbalancer garbage collector
+var GCOptions = LoadBalancerGCOptions{Period: 30 * time.Minute}
+
+const LOADBALANCER_GC = "loadbalancer-gc"
+
+// LoadBalancerGC delete the loadbalancers created for services which no
+// longer exist. Services deleted while the cloudprovider is down leak their
+// loadbalancers otherwise. Only the loadbalancers tagged with the cluster id
+// are collected, the user specified ones never carry the tag. More than one
+// loadbalancer owned by a live service is warned of but never collected.
+type LoadBalancerGC struct {
+	slb                 *LoadBalancerClient
+	dryRun              bool
+	recorder            record.EventRecorder
+	serviceLister       corelisters.ServiceLister
+	serviceListerSynced cache.InformerSynced
+}
+
+// NewLoadBalancerGC new orphan loadbalancer garbage collector
+func NewLoadBalancerGC(client *LoadBalancerClient, recorder record.EventRecorder,
+	lister corelisters.ServiceLister, synced cache.InformerSynced, dryRun bool) *LoadBalancerGC {
+	return &LoadBalancerGC{
+		slb:                 client,
+		dryRun:              dryRun,
+		recorder:            recorder,
+		serviceLister:       lister,
+		serviceListerSynced: synced,
+	}
+}
+
+// Run collect the orphan loadbalancers every period until stopped
+func (g *LoadBalancerGC) Run(stop <-chan struct{}, period time.Duration) {
+	defer utilruntime.HandleCrash()
+
+	klog.Info("starting loadbalancer garbage collector")
+	if g.dryRun {
+		klog.Info("loadbalancer garbage collector runs in dry-run mode, no loadbalancer would be deleted")
+	}
+	defer klog.Info("shutting down loadbalancer garbage collector")
+
+	if !controller.WaitForCacheSync(LOADBALANCER_GC, stop, g.serviceListerSynced) {
+		return
+	}
+	wait.Until(func() {
+		if _, err := g.Collect(context.Background()); err != nil {
+			klog.Errorf("collect orphan loadbalancers: %s", err.Error())
+		}
+	}, period, stop)
+}
+
+// Collect delete the orphan loadbalancers, or only report them in dry-run
+// mode. The ids of the orphan loadbalancers are returned.
+func (g *LoadBalancerGC) Collect(ctx context.Context) ([]string, error) {
+	if CLUSTER_ID == DEFAULT_CLUSTER_ID {
+		// the default cluster id is shared by every cluster without one
+		return nil, fmt.Errorf("cluster id is not specified, refuse to collect loadbalancers")
+	}
+	services, err := g.serviceLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("list services: %s", err.Error())
+	}
+	var (
+		uids      = map[string]*v1.Service{}
+		names     = map[string]bool{}
+		specified = map[string]bool{}
+		// owned the loadbalancers owned by each live service
+		owned = map[string][]string{}
+	)
+	for _, svc := range services {
+		uids[string(svc.UID)] = svc
+		names[GetLoadBalancerName(svc)] = true
+		if def, _ := ExtractAnnotationRequest(svc); def.Loadbalancerid != "" {
+			specified[def.Loadbalancerid] = true
+		}
+	}
+
+	lbs, _, err := describeLoadBalancersByTags(ctx, g.slb.c, []slb.TagItem{{TagKey: ACKKEY, TagValue: CLUSTER_ID}})
+	if err != nil {
+		return nil, fmt.Errorf("describe loadbalancers of cluster %s: %s", CLUSTER_ID, err.Error())
+	}
+
+	var orphans []string
+	for _, lb := range lbs {
+		if specified[lb.LoadBalancerId] {
+			continue
+		}
+		tags, _, err := g.slb.c.DescribeTags(
+			ctx,
+			&slb.DescribeTagsArgs{
+				RegionId:       lb.RegionId,
+				LoadBalancerID: lb.LoadBalancerId,
+			},
+		)
+		if err != nil {
+			return orphans, fmt.Errorf("describe tags of loadbalancer %s: %s", lb.LoadBalancerId, err.Error())
+		}
+		owner := map[string]string{}
+		for _, tag := range tags {
+			owner[tag.TagKey] = tag.TagValue
+		}
+		if owner[ACKKEY] != CLUSTER_ID {
+			continue
+		}
+		// loadbalancers created before the uid tag is stamped are told by name
+		if uid, ok := owner[SERVICEUIDKEY]; ok {
+			if uids[uid] != nil {
+				owned[uid] = append(owned[uid], lb.LoadBalancerId)
+				continue
+			}
+		} else if name, ok := owner[TAGKEY]; !ok || names[name] {
+			continue
+		}
+
+		orphans = append(orphans, lb.LoadBalancerId)
+		service := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: owner[SERVICENAMESPACEKEY],
+				Name:      owner[SERVICENAMEKEY],
+			},
+		}
+		if g.dryRun {
+			klog.Infof("loadbalancer gc dry-run: loadbalancer %s of deleted service %s/%s would be deleted",
+				lb.LoadBalancerId, service.Namespace, service.Name)
+			continue
+		}
+		klog.Infof("loadbalancer gc: delete loadbalancer %s of deleted service %s/%s",
+			lb.LoadBalancerId, service.Namespace, service.Name)
+		if err := g.delete(ctx, service, lb.LoadBalancerId); err != nil {
+			return orphans, err
+		}
+	}
+	for uid, ids := range owned {
+		if len(ids) > 1 {
+			sort.Strings(ids)
+			recordDuplicateLoadBalancers(g.recorder, uids[uid], ids)
+		}
+	}
+	return orphans, nil
+}
+
+func (g *LoadBalancerGC) delete(ctx context.Context, service *v1.Service, id string) error {
+	exists, lb, err := g.slb.FindLoadBalancerByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("find loadbalancer %s: %s", id, err.Error())
+	}
+	if !exists {
+		return nil
+	}
+	// the eip is owned by the user, it is unbound but never released
+	if err := EnsureEipUnbound(ctx, g.slb.ins, g.slb.c, service, lb); err != nil {
+		return err
+	}
+	return g.slb.deleteLoadBalancer(ctx, service, lb)
+}
@@ -0,0 +1,344 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	GC_CLUSTER_ID   = "c9f3a1b2e5d4c4b7e8a1f2d3c4b5a6e7f"
+	GC_LIVE_UID     = "2cb99d4b-7c2e-11e9-9d5e-00163e0a1a01"
+	GC_ORPHAN_UID   = "2cb99d4b-7c2e-11e9-9d5e-00163e0a1a02"
+	GC_LEGACY_UID   = "2cb99d4b-7c2e-11e9-9d5e-00163e0a1a03"
+	GC_LIVE_ID      = "lb-gc-owned-live"
+	GC_ORPHAN_ID    = "lb-gc-owned-orphan"
+	GC_LEGACY_ID    = "lb-gc-owned-legacy"
+	GC_FOREIGN_ID   = "lb-gc-foreign"
+	GC_SPECIFIED_ID = "lb-gc-user-specified"
+)
+
+func gcLister(t *testing.T, services ...*v1.Service) corelisters.ServiceLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, svc := range services {
+		if err := indexer.Add(svc); err != nil {
+			t.Fatalf("add service: %s", err.Error())
+		}
+	}
+	return corelisters.NewServiceLister(indexer)
+}
+
+// WithGCLoadBalancers loadbalancers owned by the cluster with and without a
+// live service, owned by another cluster, and specified by the user.
+func WithGCLoadBalancers() CloudDataMock {
+	return func() {
+		lbs := map[string][]slb.TagItemType{
+			GC_LIVE_ID: gcTags(map[string]string{
+				TAGKEY:              GetLoadBalancerName(testServiceNamed("live", GC_LIVE_UID, nil)),
+				ACKKEY:              GC_CLUSTER_ID,
+				SERVICENAMESPACEKEY: "default",
+				SERVICENAMEKEY:      "live",
+				SERVICEUIDKEY:       GC_LIVE_UID,
+			}),
+			GC_ORPHAN_ID: gcTags(map[string]string{
+				TAGKEY:              GetLoadBalancerName(testServiceNamed("orphan", GC_ORPHAN_UID, nil)),
+				ACKKEY:              GC_CLUSTER_ID,
+				SERVICENAMESPACEKEY: "default",
+				SERVICENAMEKEY:      "orphan",
+				SERVICEUIDKEY:       GC_ORPHAN_UID,
+			}),
+			// created before the uid tag is stamped
+			GC_LEGACY_ID: gcTags(map[string]string{
+				TAGKEY: GetLoadBalancerName(testServiceNamed("legacy", GC_LEGACY_UID, nil)),
+				ACKKEY: GC_CLUSTER_ID,
+			}),
+			GC_FOREIGN_ID: gcTags(map[string]string{
+				TAGKEY:        GetLoadBalancerName(testServiceNamed("foreign", GC_ORPHAN_UID, nil)),
+				ACKKEY:        "another-cluster",
+				SERVICEUIDKEY: GC_ORPHAN_UID,
+			}),
+			GC_SPECIFIED_ID: gcTags(map[string]string{REUSEKEY: "true"}),
+		}
+		for id, tags := range lbs {
+			LOADBALANCER.loadbalancer.Store(
+				id,
+				slb.LoadBalancerType{
+					LoadBalancerId: id,
+					RegionId:       REGION,
+				},
+			)
+			LOADBALANCER.tags.Store(id, tags)
+		}
+	}
+}
+
+func gcTags(tags map[string]string) []slb.TagItemType {
+	var items []slb.TagItemType
+	for k, v := range tags {
+		items = append(items, slb.TagItemType{TagItem: slb.TagItem{TagKey: k, TagValue: v}})
+	}
+	return items
+}
+
+func TestLoadBalancerGC(t *testing.T) {
+	cid := CLUSTER_ID
+	defer func() { CLUSTER_ID = cid }()
+
+	f := NewDefaultFrameWork(
+		func() {
+			DefaultPreset()
+			PreSetCloudData(WithGCLoadBalancers())
+		},
+	)
+	lister := gcLister(
+		t,
+		testServiceNamed("live", GC_LIVE_UID, nil),
+		testServiceNamed("user-specified", GC_LIVE_UID+"0",
+			map[string]string{ServiceAnnotationLoadBalancerId: GC_SPECIFIED_ID}),
+	)
+	remaining := func() []string {
+		var ids []string
+		LOADBALANCER.loadbalancer.Range(
+			func(key, value interface{}) bool {
+				ids = append(ids, key.(string))
+				return true
+			},
+		)
+		sort.Strings(ids)
+		return ids
+	}
+	expect := func(got, want []string) error {
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("expect %v, got %v", want, got)
+		}
+		return nil
+	}
+	all := []string{LOADBALANCER_ID, GC_LIVE_ID, GC_ORPHAN_ID, GC_LEGACY_ID, GC_FOREIGN_ID, GC_SPECIFIED_ID}
+
+	f.RunCustomized(t, "Refuse to collect without cluster id",
+		func(f *FrameWork) error {
+			CLUSTER_ID = "clusterid"
+			gc := NewLoadBalancerGC(f.LoadBalancer(), nil, lister, nil, false)
+			if _, err := gc.Collect(context.Background()); err == nil {
+				return fmt.Errorf("expect error without cluster id")
+			}
+			return expect(remaining(), all)
+		},
+	)
+
+	f.RunCustomized(t, "Report orphan loadbalancers in dry-run mode",
+		func(f *FrameWork) error {
+			CLUSTER_ID = GC_CLUSTER_ID
+			gc := NewLoadBalancerGC(f.LoadBalancer(), nil, lister, nil, true)
+			orphans, err := gc.Collect(context.Background())
+			if err != nil {
+				return err
+			}
+			if err := expect(orphans, []string{GC_ORPHAN_ID, GC_LEGACY_ID}); err != nil {
+				return err
+			}
+			return expect(remaining(), all)
+		},
+	)
+
+	f.RunCustomized(t, "Report orphan loadbalancers of all the pages",
+		func(f *FrameWork) error {
+			CLUSTER_ID = GC_CLUSTER_ID
+			mock := f.SLBSDK().(*mockClientSLB)
+			mock.pageSize = 1
+			defer func() { mock.pageSize = 0 }()
+			gc := NewLoadBalancerGC(f.LoadBalancer(), nil, lister, nil, true)
+			orphans, err := gc.Collect(context.Background())
+			if err != nil {
+				return err
+			}
+			return expect(orphans, []string{GC_ORPHAN_ID, GC_LEGACY_ID})
+		},
+	)
+
+	f.RunCustomized(t, "Delete orphan loadbalancers",
+		func(f *FrameWork) error {
+			CLUSTER_ID = GC_CLUSTER_ID
+			v, _ := LOADBALANCER.loadbalancer.Load(GC_ORPHAN_ID)
+			protected := v.(slb.LoadBalancerType)
+			protected.DeleteProtection = slb.OnFlag
+			LOADBALANCER.loadbalancer.Store(GC_ORPHAN_ID, protected)
+
+			gc := NewLoadBalancerGC(f.LoadBalancer(), nil, lister, nil, false)
+			orphans, err := gc.Collect(context.Background())
+			if err != nil {
+				return err
+			}
+			if err := expect(orphans, []string{GC_ORPHAN_ID, GC_LEGACY_ID}); err != nil {
+				return err
+			}
+			return expect(remaining(), []string{LOADBALANCER_ID, GC_LIVE_ID, GC_FOREIGN_ID, GC_SPECIFIED_ID})
+		},
+	)
+}
+
+func TestLoadBalancerGCDuplicates(t *testing.T) {
+	cid := CLUSTER_ID
+	defer func() { CLUSTER_ID = cid }()
+
+	f := NewDefaultFrameWork(
+		func() {
+			DefaultPreset()
+			PreSetCloudData(WithGCLoadBalancers())
+		},
+	)
+	lister := gcLister(t, testServiceNamed("live", GC_LIVE_UID, nil))
+
+	f.RunCustomized(t, "Warn of the loadbalancers owned by the same service",
+		func(f *FrameWork) error {
+			CLUSTER_ID = GC_CLUSTER_ID
+			duplicate := GC_LIVE_ID + "-duplicate"
+			LOADBALANCER.loadbalancer.Store(
+				duplicate,
+				slb.LoadBalancerType{LoadBalancerId: duplicate, RegionId: REGION},
+			)
+			tags, _ := LOADBALANCER.tags.Load(GC_LIVE_ID)
+			LOADBALANCER.tags.Store(duplicate, tags)
+
+			recorder := record.NewFakeRecorder(10)
+			gc := NewLoadBalancerGC(f.LoadBalancer(), recorder, lister, nil, false)
+			orphans, err := gc.Collect(context.Background())
+			if err != nil {
+				return err
+			}
+			for _, id := range orphans {
+				if id == GC_LIVE_ID || id == duplicate {
+					return fmt.Errorf("expect the duplicates never collected, got %v", orphans)
+				}
+			}
+			if len(recorder.Events) != 1 {
+				return fmt.Errorf("expect the duplicates warned once, got %d events", len(recorder.Events))
+			}
+			event := <-recorder.Events
+			if !strings.Contains(event, "DuplicateLoadBalancer") ||
+				!strings.Contains(event, GC_LIVE_ID) || !strings.Contains(event, duplicate) {
+				return fmt.Errorf("expect the duplicates of the service warned, got %s", event)
+			}
+			return nil
+		},
+	)
+}
+
+func TestOwnershipTags(t *testing.T) {
+	cid := CLUSTER_ID
+	defer func() { CLUSTER_ID = cid }()
+	CLUSTER_ID = GC_CLUSTER_ID
+
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	tagsOf := func(f *FrameWork) (map[string]string, error) {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return nil, fmt.Errorf("find loadbalancer: %v", err)
+		}
+		items, _, err := f.SLBSDK().DescribeTags(
+			context.Background(),
+			&slb.DescribeTagsArgs{RegionId: lb.RegionId, LoadBalancerID: lb.LoadBalancerId},
+		)
+		if err != nil {
+			return nil, err
+		}
+		tags := map[string]string{}
+		for _, item := range items {
+			tags[item.TagKey] = item.TagValue
+		}
+		return tags, nil
+	}
+
+	f.RunCustomized(t, "Created loadbalancer carries ownership tags",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			tags, err := tagsOf(f)
+			if err != nil {
+				return err
+			}
+			for key, value := range ownershipTags(f.SVC) {
+				if tags[key] != value {
+					return fmt.Errorf("expect tag %s=%s, got %v", key, value, tags)
+				}
+			}
+			return nil
+		},
+	)
+
+	f.SVC = testServiceNamed("user-specified", serviceUIDExist, map[string]string{ServiceAnnotationLoadBalancerId: LOADBALANCER_ID})
+	f.RunCustomized(t, "User specified loadbalancer carries no ownership tag",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			tags, err := tagsOf(f)
+			if err != nil {
+				return err
+			}
+			for key := range tags {
+				if key != REUSEKEY && isOwnershipTag(key) {
+					return fmt.Errorf("expect no ownership tag on user specified loadbalancer, got %v", tags)
+				}
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"k8s.io/klog"
+	"k8s.io/kubernetes/pkg/controller"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A backend registered but failing the health check of the slb, eg. a wrong
+// health check path or a security group blocking the probe, drops the traffic
+// while the service looks fine. The health checker describes the backend
+// health of the loadbalancer of each service periodically, reports the
+// unhealthy backends by an event when their count changes, and exports the
+// counts as gauges. The loadbalancer is told by the id-result annotation.
+// Services whose last sync failed are left to the service controller. The
+// pods of a draining node are evicted, the backends of the draining nodes
+// are not checked for the Local services, whose health check fails then.
+
+// BackendHealthOptions options of the backend health checker
+type BackendHealthOptions struct {
+	// Period interval between two checks, 0 disables the checker
+	Period time.Duration
+	// QPS max DescribeHealthStatus calls per second
+	QPS float32
+}
+
+// HealthOptions global options of the backend health checker
+var HealthOptions = BackendHealthOptions{QPS: 2}
+
+const BACKEND_HEALTH_CHECKER = "backend-health-checker"
+
+// syncFailures services whose last EnsureLoadBalancer failed, they are in
+// the backoff of the service controller. key: namespace/name
+var syncFailures sync.Map
+
+func serviceKey(svc *v1.Service) string {
+	return fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+}
+
+// recordSyncResult remember whether the service is in backoff
+func recordSyncResult(svc *v1.Service, err error) {
+	if err != nil {
+		syncFailures.Store(serviceKey(svc), true)
+		return
+	}
+	syncFailures.Delete(serviceKey(svc))
+}
+
+func inBackoff(svc *v1.Service) bool {
+	_, ok := syncFailures.Load(serviceKey(svc))
+	return ok
+}
+
+// BackendHealthChecker report the unhealthy backends of the loadbalancers
+type BackendHealthChecker struct {
+	slb                 *LoadBalancerClient
+	recorder            record.EventRecorder
+	limiter             flowcontrol.RateLimiter
+	serviceLister       corelisters.ServiceLister
+	serviceListerSynced cache.InformerSynced
+	nodeLister          corelisters.NodeLister
+	// unhealthy count of the unhealthy backends of the last check by service key
+	unhealthy map[string]int
+}
+
+// NewBackendHealthChecker new backend health checker
+func NewBackendHealthChecker(client *LoadBalancerClient, recorder record.EventRecorder,
+	services corelisters.ServiceLister, synced cache.InformerSynced,
+	nodes corelisters.NodeLister, qps float32) *BackendHealthChecker {
+	if qps <= 0 {
+		qps = HealthOptions.QPS
+	}
+	return &BackendHealthChecker{
+		slb:                 client,
+		recorder:            recorder,
+		limiter:             flowcontrol.NewTokenBucketRateLimiter(qps, 1),
+		serviceLister:       services,
+		serviceListerSynced: synced,
+		nodeLister:          nodes,
+		unhealthy:           map[string]int{},
+	}
+}
+
+// componentRecorder recorder of the events of the component, eg. the unhealthy
+// backends of the health checker
+func componentRecorder(client clientset.Interface, component string) record.EventRecorder {
+	caster := record.NewBroadcaster()
+	caster.StartLogging(klog.Infof)
+	caster.StartRecordingToSink(
+		&v1core.EventSinkImpl{Interface: v1core.New(client.CoreV1().RESTClient()).Events("")},
+	)
+	return caster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
+}
+
+// Run check the backend health every period until stopped
+func (h *BackendHealthChecker) Run(stop <-chan struct{}, period time.Duration) {
+	defer utilruntime.HandleCrash()
+
+	klog.Info("starting backend health checker")
+	defer klog.Info("shutting down backend health checker")
+
+	if !controller.WaitForCacheSync(BACKEND_HEALTH_CHECKER, stop, h.serviceListerSynced) {
+		return
+	}
+	wait.Until(func() { h.Check(context.Background()) }, period, stop)
+}
+
+// Check describe the backend health of the loadbalancer of each service
+func (h *BackendHealthChecker) Check(ctx context.Context) {
+	services, err := h.serviceLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("backend health: list services: %s", err.Error())
+		return
+	}
+	checked := map[string]bool{}
+	for _, svc := range services {
+		lbid := svc.Annotations[utils.ServiceAnnotationLoadBalancerIdResult]
+		if svc.Spec.Type != v1.ServiceTypeLoadBalancer || lbid == "" || isNLB(svc) {
+			continue
+		}
+		key := serviceKey(svc)
+		if inBackoff(svc) {
+			utils.Logf(svc, "backend health: service is in backoff, skip")
+			if _, ok := h.unhealthy[key]; ok {
+				checked[key] = true
+			}
+			continue
+		}
+		h.limiter.Accept()
+		status, err := h.slb.c.DescribeHealthStatus(ctx, &DescribeHealthStatusArgs{LoadBalancerId: lbid})
+		if err != nil {
+			klog.Warningf("backend health: describe health status of loadbalancer %s: %s", lbid, err.Error())
+			if _, ok := h.unhealthy[key]; ok {
+				checked[key] = true
+			}
+			continue
+		}
+		checked[key] = true
+		h.report(svc, status)
+	}
+	for key := range h.unhealthy {
+		if !checked[key] {
+			// the service is deleted or served by another loadbalancer now
+			delete(h.unhealthy, key)
+			metric.SLBBackendHealthy.DeleteLabelValues(key)
+			metric.SLBBackendUnhealthy.DeleteLabelValues(key)
+		}
+	}
+}
+
+// report export the health counts of the backends, and record the unhealthy
+// ones when their count changes.
+func (h *BackendHealthChecker) report(svc *v1.Service, status *DescribeHealthStatusResponse) {
+	var (
+		healthy         = map[string]bool{}
+		unhealthy       = map[string]string{}
+		names, draining = h.nodeNames()
+		local           = ServiceModeLocal(svc)
+	)
+	for _, b := range status.BackendServers.BackendServer {
+		if local && draining[b.ServerId] {
+			continue
+		}
+		backend := fmt.Sprintf("%s:%d", backendName(names, b), b.Port)
+		if b.ServerHealthStatus == SERVER_HEALTH_STATUS_NORMAL {
+			healthy[backend] = true
+			continue
+		}
+		unhealthy[backend] = fmt.Sprintf("%s (%s on listener %d)", backend, b.ServerHealthStatus, b.ListenerPort)
+	}
+	for backend := range unhealthy {
+		// unhealthy on any of the listeners
+		delete(healthy, backend)
+	}
+	key := serviceKey(svc)
+	metric.SLBBackendHealthy.WithLabelValues(key).Set(float64(len(healthy)))
+	metric.SLBBackendUnhealthy.WithLabelValues(key).Set(float64(len(unhealthy)))
+
+	last, ok := h.unhealthy[key]
+	h.unhealthy[key] = len(unhealthy)
+	if (!ok && len(unhealthy) == 0) || last == len(unhealthy) {
+		return
+	}
+	if len(unhealthy) == 0 {
+		utils.Logf(svc, "backend health: all %d backends are healthy", len(healthy))
+		return
+	}
+	var reasons []string
+	for _, reason := range unhealthy {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	h.recorder.Eventf(
+		svc,
+		v1.EventTypeWarning,
+		"UnhealthyBackends",
+		"%d of %d backends are unhealthy: %s, check the health check config and the security group",
+		len(unhealthy), len(unhealthy)+len(healthy), strings.Join(reasons, ", "),
+	)
+}
+
+// nodeNames node names by instance id, and the instance ids of the draining
+// nodes
+func (h *BackendHealthChecker) nodeNames() (map[string]string, map[string]bool) {
+	names, draining := map[string]string{}, map[string]bool{}
+	if h.nodeLister == nil {
+		return names, draining
+	}
+	nodes, err := h.nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.Warningf("backend health: list nodes: %s", err.Error())
+		return names, draining
+	}
+	for _, node := range nodes {
+		if _, id, err := nodeFromProviderID(node.Spec.ProviderID); err == nil {
+			names[id] = node.Name
+			if utils.IsDrainingNode(node) {
+				draining[id] = true
+			}
+		}
+	}
+	return names, draining
+}
+
+// backendName name of the node of an ecs backend, or the ip of the backend
+func backendName(names map[string]string, b BackendServerHealthStatusType) string {
+	if name, ok := names[b.ServerId]; ok {
+		return name
+	}
+	if b.ServerIp != "" {
+		return b.ServerIp
+	}
+	return b.ServerId
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+)
+
+// The health check parameters a service does not annotate are left to slb,
+// whose defaults may not be those of the cluster, so that every service
+// annotates the same values. HealthCheckOptions sets them controller wide.
+// A default is taken as if the service annotated it, so that the listeners
+// converge to it once it changes, and any annotation, including those of the
+// default-lb-annotations-configmap and of the port-overrides, takes
+// precedence over it. The defaults are hashed along with the services, which
+// are synced again once they change. The check type is defaulted per
+// listener protocol: tcp or http for the tcp listeners, on or off for the
+// http and https ones. The listeners of the Local traffic policy services
+// keep probing the healthCheckNodePort unless the type is annotated.
+
+const (
+	HEALTH_CHECK_TIMEOUT_MIN = 1
+	HEALTH_CHECK_TIMEOUT_MAX = 300
+)
+
+// HealthCheckDefaults the health check defaults of the listeners, 0 or empty
+// leaves the parameter to slb
+type HealthCheckDefaults struct {
+	Interval int
+	// Timeout the connect timeout of tcp & udp listeners and the response
+	// timeout of http & https ones
+	Timeout            int
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	// Types the check type keyed by listener protocol
+	Types map[string]string
+}
+
+// HealthCheckOptions global health check defaults, none by default, see
+// SetHealthCheckDefaults
+var HealthCheckOptions = HealthCheckDefaults{}
+
+// SetHealthCheckDefaults set HealthCheckOptions and the hash of them
+func SetHealthCheckDefaults(options HealthCheckDefaults) {
+	HealthCheckOptions = options
+	utils.ServiceHashDefaults = options.hashed()
+}
+
+// hashed the encoding of the defaults hashed along with the services, empty
+// if there is none
+func (o HealthCheckDefaults) hashed() string {
+	if o.Interval == 0 && o.Timeout == 0 && o.HealthyThreshold == 0 &&
+		o.UnhealthyThreshold == 0 && len(o.Types) == 0 {
+		return ""
+	}
+	// the keys of the types are sorted by the encoding
+	b, err := json.Marshal(o)
+	if err != nil {
+		return ""
+	}
+	return "health-check:" + string(b)
+}
+
+// healthCheckTypes the check types accepted of each listener protocol
+var healthCheckTypes = map[string][]string{
+	"tcp":   {string(slb.TCPHealthCheckType), string(slb.HTTPHealthCheckType)},
+	"http":  {string(slb.OnFlag), string(slb.OffFlag)},
+	"https": {string(slb.OnFlag), string(slb.OffFlag)},
+}
+
+// ParseHealthCheckTypes parse the protocol=type pairs of the check types,
+// eg. tcp=http or https=on
+func ParseHealthCheckTypes(pairs []string) (map[string]string, error) {
+	types := map[string]string{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("health check type %q must be protocol=type", pair)
+		}
+		types[strings.ToLower(parts[0])] = strings.ToLower(parts[1])
+	}
+	return types, nil
+}
+
+// ValidateHealthCheckDefaults check the defaults against the ranges of slb
+func ValidateHealthCheckDefaults(options HealthCheckDefaults) error {
+	for _, v := range []struct {
+		name     string
+		value    int
+		min, max int
+	}{
+		{"interval", options.Interval, HEALTH_CHECK_INTERVAL_MIN, HEALTH_CHECK_INTERVAL_MAX},
+		{"timeout", options.Timeout, HEALTH_CHECK_TIMEOUT_MIN, HEALTH_CHECK_TIMEOUT_MAX},
+		{"healthy threshold", options.HealthyThreshold, HEALTH_CHECK_THRESHOLD_MIN, HEALTH_CHECK_THRESHOLD_MAX},
+		{"unhealthy threshold", options.UnhealthyThreshold, HEALTH_CHECK_THRESHOLD_MIN, HEALTH_CHECK_THRESHOLD_MAX},
+	} {
+		if v.value != 0 && (v.value < v.min || v.value > v.max) {
+			return fmt.Errorf("default health check %s %d is out of range [%d, %d]", v.name, v.value, v.min, v.max)
+		}
+	}
+	for proto, t := range options.Types {
+		accepted, ok := healthCheckTypes[proto]
+		if !ok {
+			return fmt.Errorf("default health check type of protocol %s is not supported, expect one of tcp, http or https", proto)
+		}
+		if !enumFormat(accepted...).valid(t) {
+			return fmt.Errorf("default health check type of protocol %s must be one of %s, got %s",
+				proto, strings.Join(accepted, ", "), t)
+		}
+	}
+	return nil
+}
+
+// apply fill the health check parameters request leaves unset with the
+// defaults of the listener protocol, in both def and request. local tells
+// whether the listener probes the healthCheckNodePort of a Local traffic
+// policy service, whose check type is not defaulted.
+func (o HealthCheckDefaults) apply(proto string, local bool, def, request *AnnotationRequest) {
+	proto = strings.ToLower(proto)
+	if t := o.Types[proto]; t != "" && !local {
+		switch proto {
+		case "tcp":
+			if request.HealthCheckType == "" {
+				def.HealthCheckType = slb.HealthCheckType(t)
+				request.HealthCheckType = def.HealthCheckType
+			}
+		case "http", "https":
+			if request.HealthCheck == "" {
+				def.HealthCheck = slb.FlagType(t)
+				request.HealthCheck = def.HealthCheck
+			}
+		}
+	}
+	if (proto == "http" || proto == "https") && def.HealthCheck != slb.OnFlag && !local {
+		// the probe of an http check switched off is left as it is
+		return
+	}
+	for _, v := range []struct {
+		def, request *int
+		value        int
+	}{
+		{&def.HealthCheckInterval, &request.HealthCheckInterval, o.Interval},
+		{&def.HealthCheckConnectTimeout, &request.HealthCheckConnectTimeout, o.Timeout},
+		{&def.HealthCheckTimeout, &request.HealthCheckTimeout, o.Timeout},
+		{&def.HealthyThreshold, &request.HealthyThreshold, o.HealthyThreshold},
+		{&def.UnhealthyThreshold, &request.UnhealthyThreshold, o.UnhealthyThreshold},
+	} {
+		if v.value != 0 && *v.request == 0 {
+			*v.def, *v.request = v.value, v.value
+		}
+	}
+}
+
+// annotationRequest ExtractAnnotationRequest of the service of the listener,
+// with the health check defaults of its protocol applied
+func (n *Listener) annotationRequest() (*AnnotationRequest, *AnnotationRequest) {
+	def, request := ExtractAnnotationRequest(n.Service)
+	HealthCheckOptions.apply(n.TransforedProto, n.localHealthCheckPort() != 0, def, request)
+	return def, request
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"testing"
+)
+
+func TestValidateHealthCheckDefaults(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		options HealthCheckDefaults
+		types   []string
+		valid   bool
+	}{
+		{name: "none", valid: true},
+		{name: "in range", options: HealthCheckDefaults{Interval: 5, Timeout: 10, HealthyThreshold: 2, UnhealthyThreshold: 10}, valid: true},
+		{name: "interval out of range", options: HealthCheckDefaults{Interval: 51}},
+		{name: "timeout out of range", options: HealthCheckDefaults{Timeout: 301}},
+		{name: "threshold out of range", options: HealthCheckDefaults{HealthyThreshold: 1}},
+		{name: "types", types: []string{"tcp=http", "HTTP=on", "https=off"}, valid: true},
+		{name: "tcp type", types: []string{"tcp=on"}},
+		{name: "http type", types: []string{"http=tcp"}},
+		{name: "udp type", types: []string{"udp=udp"}},
+		{name: "not a pair", types: []string{"tcp"}},
+	} {
+		types, err := ParseHealthCheckTypes(c.types)
+		if err == nil {
+			c.options.Types = types
+			err = ValidateHealthCheckDefaults(c.options)
+		}
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expect valid %t, got %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestHealthCheckDefaultsPrecedence(t *testing.T) {
+	defer func() { HealthCheckOptions = HealthCheckDefaults{} }()
+	HealthCheckOptions = HealthCheckDefaults{
+		Interval:         3,
+		HealthyThreshold: 4,
+		Timeout:          6,
+		Types:            map[string]string{"tcp": "http", "http": "off"},
+	}
+
+	// the request of the listener of the port of the service
+	request := func(svc *v1.Service, port int32, proto string) *AnnotationRequest {
+		t.Helper()
+		for _, p := range svc.Spec.Ports {
+			if p.Port != port {
+				continue
+			}
+			s, err := ServiceForPort(svc, p)
+			if err != nil {
+				t.Fatalf("service for port %d: %s", port, err.Error())
+			}
+			n := &Listener{Service: s, Port: port, TransforedProto: proto}
+			_, request := n.annotationRequest()
+			return request
+		}
+		t.Fatalf("port %d not found", port)
+		return nil
+	}
+
+	svc := testService(map[string]string{
+		ServiceAnnotationLoadBalancerHealthCheckInterval: "5",
+		ServiceAnnotationLoadBalancerHealthCheckType:     "tcp",
+		ServiceAnnotationLoadBalancerPortOverrides:       `{"9000":{"health-check-interval":"8"}}`,
+	}, overridePorts()...)
+	for _, c := range []struct {
+		port     int32
+		interval int
+	}{
+		// the annotation over the flag
+		{port: 80, interval: 5},
+		// the override of the port over the annotation
+		{port: 9000, interval: 8},
+	} {
+		r := request(svc, c.port, "tcp")
+		if r.HealthCheckInterval != c.interval {
+			t.Errorf("port %d: expect interval %d, got %d", c.port, c.interval, r.HealthCheckInterval)
+		}
+		if r.HealthCheckType != slb.TCPHealthCheckType {
+			t.Errorf("port %d: expect the annotated type tcp, got %s", c.port, r.HealthCheckType)
+		}
+		if r.HealthyThreshold != 4 || r.HealthCheckConnectTimeout != 6 {
+			t.Errorf("port %d: expect the defaults of the flags, got %d and %d",
+				c.port, r.HealthyThreshold, r.HealthCheckConnectTimeout)
+		}
+	}
+
+	// the flag once nothing is annotated
+	svc = testService(map[string]string{}, overridePorts()...)
+	if r := request(svc, 80, "tcp"); r.HealthCheckInterval != 3 || r.HealthCheckType != slb.HTTPHealthCheckType {
+		t.Errorf("expect the defaults of the flags, got interval %d and type %s", r.HealthCheckInterval, r.HealthCheckType)
+	}
+
+	// the http check off by default keeps its probe untouched
+	if r := request(svc, 80, "http"); r.HealthCheck != slb.OffFlag || r.HealthCheckInterval != 0 {
+		t.Errorf("expect the http check off and its probe untouched, got %s and interval %d",
+			r.HealthCheck, r.HealthCheckInterval)
+	}
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheckFlag] = "on"
+	if r := request(svc, 80, "http"); r.HealthCheck != slb.OnFlag || r.HealthCheckInterval != 3 || r.HealthCheckTimeout != 6 {
+		t.Errorf("expect the annotated http check on with the defaults of the flags, got %s, interval %d and timeout %d",
+			r.HealthCheck, r.HealthCheckInterval, r.HealthCheckTimeout)
+	}
+
+	// the Local traffic policy service keeps probing the healthCheckNodePort
+	svc = testService(map[string]string{}, overridePorts()...)
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Spec.HealthCheckNodePort = 32000
+	if r := request(svc, 80, "tcp"); r.HealthCheckType != "" || r.HealthCheckInterval != 3 {
+		t.Errorf("expect the type of the Local service not defaulted, got %s and interval %d",
+			r.HealthCheckType, r.HealthCheckInterval)
+	}
+}
+
+func TestHealthCheckDefaultsConverge(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-service",
+				Namespace:   "default",
+				UID:         types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Name: "tcp", Port: 80, TargetPort: intstr.FromInt(80), Protocol: v1.ProtocolTCP, NodePort: 30080},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+	defer SetHealthCheckDefaults(HealthCheckDefaults{})
+
+	// ensure the service and expect the health check of the listener, the
+	// service is labeled by its hash then as by the service controller, so
+	// that only a change of it syncs the listener again
+	expect := func(interval, healthy int, check slb.HealthCheckType) func(f *FrameWork) error {
+		return func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			tcp, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(context.Background(), lb.LoadBalancerId, 80)
+			if err != nil || tcp == nil {
+				return fmt.Errorf("expect tcp listener 80: %v", err)
+			}
+			if tcp.HealthCheckInterval != interval || tcp.HealthyThreshold != healthy || tcp.HealthCheckType != check {
+				return fmt.Errorf("expect interval %d, healthy threshold %d and type %s, got %d, %d and %s",
+					interval, healthy, check, tcp.HealthCheckInterval, tcp.HealthyThreshold, tcp.HealthCheckType)
+			}
+			hash, err := utils.GetServiceHash(f.SVC)
+			if err != nil {
+				return err
+			}
+			f.SVC.Labels = map[string]string{utils.LabelServiceHash: hash}
+			return nil
+		}
+	}
+
+	SetHealthCheckDefaults(HealthCheckDefaults{Interval: 5, HealthyThreshold: 4})
+	f.RunCustomized(t, "Defaults applied at creation", expect(5, 4, slb.TCPHealthCheckType))
+
+	SetHealthCheckDefaults(HealthCheckDefaults{Interval: 10, HealthyThreshold: 4, Types: map[string]string{"tcp": "http"}})
+	f.RunCustomized(t, "Changed defaults converged", expect(10, 4, slb.HTTPHealthCheckType))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckInterval] = "20"
+	f.RunCustomized(t, "Annotation over the defaults", expect(20, 4, slb.HTTPHealthCheckType))
+}
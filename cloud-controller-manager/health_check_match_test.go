@@ -0,0 +1,143 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"strings"
+	"testing"
+)
+
+func TestHealthCheckMatch(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort:        "http:80,tcp:8080",
+					ServiceAnnotationLoadBalancerHealthCheckFlag:     "on",
+					ServiceAnnotationLoadBalancerHealthCheckType:     string(slb.HTTPHealthCheckType),
+					ServiceAnnotationLoadBalancerHealthCheckURI:      "/check",
+					ServiceAnnotationLoadBalancerHealthCheckDomain:   "www.example.com",
+					ServiceAnnotationLoadBalancerHealthCheckHTTPCode: "http_2xx,http_3xx",
+					ServiceAnnotationLoadBalancerPortOverrides:       `{"8080":{"health-check-httpcode":"http_3xx"}}`,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Name: "http", Port: 80, TargetPort: intstr.FromInt(80), Protocol: v1.ProtocolTCP, NodePort: 30080},
+					{Name: "tcp", Port: 8080, TargetPort: intstr.FromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 30880},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	// the domain and the http codes of the health check of the listeners
+	matches := func(f *FrameWork) (map[int]string, error) {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return nil, fmt.Errorf("find loadbalancer: %v", err)
+		}
+		http, err := f.SLBSDK().DescribeLoadBalancerHTTPListenerAttribute(context.Background(), lb.LoadBalancerId, 80)
+		if err != nil || http == nil {
+			return nil, fmt.Errorf("expect http listener 80: %v", err)
+		}
+		tcp, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(context.Background(), lb.LoadBalancerId, 8080)
+		if err != nil || tcp == nil {
+			return nil, fmt.Errorf("expect tcp listener 8080: %v", err)
+		}
+		return map[int]string{
+			80:   fmt.Sprintf("%s %s", http.HealthCheckDomain, http.HealthCheckHttpCode),
+			8080: fmt.Sprintf("%s %s", tcp.HealthCheckDomain, tcp.HealthCheckHttpCode),
+		}, nil
+	}
+	// ensure the service and expect the health check of the listeners
+	expect := func(want map[int]string) func(f *FrameWork) error {
+		return func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			got, err := matches(f)
+			if err != nil {
+				return err
+			}
+			for port, match := range want {
+				if got[port] != match {
+					return fmt.Errorf("expect listener %d checks %s, got %s", port, match, got[port])
+				}
+			}
+			return nil
+		}
+	}
+
+	f.RunCustomized(t, "Matches applied at creation", expect(map[int]string{
+		80:   "www.example.com http_2xx,http_3xx",
+		8080: "www.example.com http_3xx",
+	}))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckHTTPCode] = "http_4xx"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = `{"8080":{"health-check-httpcode":"http_3xx,http_2xx"}}`
+	f.RunCustomized(t, "Http codes changed", expect(map[int]string{
+		80:   "www.example.com http_4xx",
+		8080: "www.example.com http_3xx,http_2xx",
+	}))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckHTTPCode] = "http_2xx,http_6xx"
+	f.RunCustomized(t, "Bogus http code rejected",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "http_2xx,http_6xx") {
+				return fmt.Errorf("expect the bogus http code rejected, got %v", err)
+			}
+			got, err := matches(f)
+			if err != nil {
+				return err
+			}
+			if got[80] != "www.example.com http_4xx" {
+				return fmt.Errorf("expect listener 80 left untouched, got %s", got[80])
+			}
+			return nil
+		},
+	)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerHealthCheckDomain)
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerHealthCheckHTTPCode)
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerPortOverrides)
+	f.RunCustomized(t, "Defaults restored", expect(map[int]string{
+		80:   fmt.Sprintf("%s %s", DEFAULT_HEALTH_CHECK_DOMAIN, DEFAULT_HEALTH_CHECK_HTTP_CODE),
+		8080: fmt.Sprintf("%s %s", DEFAULT_HEALTH_CHECK_DOMAIN, DEFAULT_HEALTH_CHECK_HTTP_CODE),
+	}))
+}
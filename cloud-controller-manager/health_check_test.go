@@ -0,0 +1,211 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"strings"
+	"testing"
+)
+
+func TestBackendHealthChecker(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	prid2 := nodeid(string(REGION), INSTANCEID2)
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: prid},
+			Spec:       v1.NodeSpec{ProviderID: prid},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: prid2},
+			Spec:       v1.NodeSpec{ProviderID: prid2},
+		},
+	}
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-service",
+				Namespace:   "default",
+				UID:         types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(nodes)
+
+	recorder := record.NewFakeRecorder(10)
+	// checker of the service annotated with the id of its loadbalancer
+	checker := func(f *FrameWork) (*BackendHealthChecker, error) {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return nil, fmt.Errorf("find loadbalancer: %v", err)
+		}
+		svc := f.SVC.DeepCopy()
+		svc.Annotations[utils.ServiceAnnotationLoadBalancerIdResult] = lb.LoadBalancerId
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		for _, node := range nodes {
+			if err := indexer.Add(node); err != nil {
+				return nil, err
+			}
+		}
+		return NewBackendHealthChecker(
+			f.LoadBalancer(), recorder, gcLister(t, svc), nil,
+			corelisters.NewNodeLister(indexer), 100,
+		), nil
+	}
+	expectGauges := func(healthy, unhealthy float64) error {
+		key := "default/my-service"
+		h := testutil.ToFloat64(metric.SLBBackendHealthy.WithLabelValues(key))
+		u := testutil.ToFloat64(metric.SLBBackendUnhealthy.WithLabelValues(key))
+		if h != healthy || u != unhealthy {
+			return fmt.Errorf("expect %v healthy and %v unhealthy backends, got %v, %v", healthy, unhealthy, h, u)
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Healthy backends turn unhealthy",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			h, err := checker(f)
+			if err != nil {
+				return err
+			}
+			h.Check(context.Background())
+			if err := expectGauges(2, 0); err != nil {
+				return err
+			}
+			if len(recorder.Events) != 0 {
+				return fmt.Errorf("expect no event of healthy backends, got %d", len(recorder.Events))
+			}
+
+			LOADBALANCER.health.Store(INSTANCEID2, "abnormal")
+			h.Check(context.Background())
+			if err := expectGauges(1, 1); err != nil {
+				return err
+			}
+			if len(recorder.Events) != 1 {
+				return fmt.Errorf("expect an event of the unhealthy backend, got %d", len(recorder.Events))
+			}
+			event := <-recorder.Events
+			if !strings.Contains(event, "UnhealthyBackends") ||
+				!strings.Contains(event, fmt.Sprintf("%s:%d (abnormal", prid2, nodePort1)) {
+				return fmt.Errorf("expect node %s unhealthy in event, got %s", prid2, event)
+			}
+
+			// no event until the unhealthy count changes
+			h.Check(context.Background())
+			if len(recorder.Events) != 0 {
+				return fmt.Errorf("expect no event of an unchanged count, got %s", <-recorder.Events)
+			}
+
+			// the service in backoff is skipped
+			recordSyncResult(f.SVC, fmt.Errorf("Throttling"))
+			LOADBALANCER.health.Store(INSTANCEID, "abnormal")
+			h.Check(context.Background())
+			if err := expectGauges(1, 1); err != nil {
+				return err
+			}
+			if len(recorder.Events) != 0 {
+				return fmt.Errorf("expect service in backoff skipped, got %s", <-recorder.Events)
+			}
+			recordSyncResult(f.SVC, nil)
+			h.Check(context.Background())
+			if err := expectGauges(0, 2); err != nil {
+				return err
+			}
+			if len(recorder.Events) != 1 {
+				return fmt.Errorf("expect an event of the changed count, got %d", len(recorder.Events))
+			}
+			<-recorder.Events
+			return nil
+		},
+	)
+}
+
+func TestBackendHealthDrainingNodes(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	prid2 := nodeid(string(REGION), INSTANCEID2)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: prid},
+			Spec:       v1.NodeSpec{ProviderID: prid},
+		},
+		{
+			// cordoned, its pods are evicted
+			ObjectMeta: metav1.ObjectMeta{Name: prid2},
+			Spec:       v1.NodeSpec{ProviderID: prid2, Unschedulable: true},
+		},
+	} {
+		if err := indexer.Add(node); err != nil {
+			t.Fatalf("add node: %s", err.Error())
+		}
+	}
+	status := &DescribeHealthStatusResponse{}
+	status.BackendServers.BackendServer = []BackendServerHealthStatusType{
+		{ServerId: INSTANCEID, Port: nodePort1, ListenerPort: listenPort1, ServerHealthStatus: SERVER_HEALTH_STATUS_NORMAL},
+		{ServerId: INSTANCEID2, Port: nodePort1, ListenerPort: listenPort1, ServerHealthStatus: "abnormal"},
+	}
+	for _, c := range []struct {
+		policy    v1.ServiceExternalTrafficPolicyType
+		unhealthy float64
+	}{
+		// the draining node fails the health check of the Local service
+		{policy: v1.ServiceExternalTrafficPolicyTypeLocal, unhealthy: 0},
+		{policy: v1.ServiceExternalTrafficPolicyTypeCluster, unhealthy: 1},
+	} {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "draining-" + strings.ToLower(string(c.policy)), Namespace: "default"},
+			Spec: v1.ServiceSpec{
+				Type:                  v1.ServiceTypeLoadBalancer,
+				ExternalTrafficPolicy: c.policy,
+			},
+		}
+		recorder := record.NewFakeRecorder(10)
+		h := NewBackendHealthChecker(nil, recorder, gcLister(t, svc), nil, corelisters.NewNodeLister(indexer), 100)
+		h.report(svc, status)
+		key := serviceKey(svc)
+		if u := testutil.ToFloat64(metric.SLBBackendUnhealthy.WithLabelValues(key)); u != c.unhealthy {
+			t.Errorf("%s: expect %v unhealthy backends, got %v", c.policy, c.unhealthy, u)
+		}
+		if h := testutil.ToFloat64(metric.SLBBackendHealthy.WithLabelValues(key)); h != 1 {
+			t.Errorf("%s: expect 1 healthy backend, got %v", c.policy, h)
+		}
+		metric.SLBBackendHealthy.DeleteLabelValues(key)
+		metric.SLBBackendUnhealthy.DeleteLabelValues(key)
+	}
+}
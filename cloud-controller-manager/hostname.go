@@ -0,0 +1,60 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"net"
+	"strings"
+)
+
+// ValidateHostname the hostname is written to the service status, which
+// the apiserver rejects unless it is a dns name. Reject it before the
+// loadbalancer is ensured instead of failing every status update.
+func ValidateHostname(service *v1.Service) error {
+	hostname, ok := service.Annotations[ServiceAnnotationLoadBalancerHostname]
+	if !ok {
+		return nil
+	}
+	reason := ""
+	if net.ParseIP(hostname) != nil {
+		reason = "must be a dns name, not an ip address"
+	} else if errs := validation.IsDNS1123Subdomain(hostname); len(errs) > 0 {
+		reason = strings.Join(errs, ", ")
+	}
+	if reason == "" {
+		return nil
+	}
+	return annotationError{
+		annotation: ServiceAnnotationLoadBalancerHostname,
+		token:      hostname,
+		reason:     reason,
+	}
+}
+
+// hostnameStatus the status publishing only the hostname of the annotation,
+// nil if the annotation is absent and the address is published.
+func hostnameStatus(service *v1.Service) *v1.LoadBalancerStatus {
+	defaulted, _ := ExtractAnnotationRequest(service)
+	if defaulted.Hostname == "" {
+		return nil
+	}
+	return &v1.LoadBalancerStatus{
+		Ingress: []v1.LoadBalancerIngress{{Hostname: defaulted.Hostname}},
+	}
+}
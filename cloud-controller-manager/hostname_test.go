@@ -0,0 +1,122 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestValidateHostname(t *testing.T) {
+	cases := []struct {
+		annotations map[string]string
+		invalid     bool
+	}{
+		{annotations: map[string]string{}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerHostname: "foo.example.com"}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerHostname: ""}, invalid: true},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerHostname: "Foo_bar.example.com"}, invalid: true},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerHostname: "47.97.241.114"}, invalid: true},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: c.annotations},
+		}
+		err := ValidateHostname(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("%v: unexpected error: %s", c.annotations, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%v: expect InvalidAnnotation error, got %v", c.annotations, err)
+		}
+	}
+}
+
+func TestHostname(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerHostname: "foo.example.com",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	// the status of EnsureLoadBalancer and GetLoadBalancer must agree,
+	// otherwise the service status flaps between syncs.
+	expectIngress := func(f *FrameWork, ip, hostname string) error {
+		status, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+		if err != nil {
+			return err
+		}
+		got, exist, err := f.CloudImpl().GetLoadBalancer(context.Background(), CLUSTER_ID, f.SVC)
+		if err != nil || !exist {
+			return fmt.Errorf("get loadbalancer: %v, %v", exist, err)
+		}
+		for _, s := range []*v1.LoadBalancerStatus{status, got} {
+			if len(s.Ingress) != 1 || s.Ingress[0].IP != ip || s.Ingress[0].Hostname != hostname {
+				return fmt.Errorf("expect ingress [%s, %s], got %v", ip, hostname, s.Ingress)
+			}
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Publish hostname",
+		func(f *FrameWork) error {
+			if err := expectIngress(f, "", "foo.example.com"); err != nil {
+				return err
+			}
+			return ExpectExistAndEqual(f)
+		},
+	)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerHostname)
+	f.RunCustomized(t, "Switch back to ip",
+		func(f *FrameWork) error {
+			return expectIngress(f, LOADBALANCER_ADDRESS, "")
+		},
+	)
+}
@@ -44,6 +44,8 @@ type ClientInstanceSDK interface {
 	DescribeInstances(ctx context.Context, args *ecs.DescribeInstancesArgs) (instances []ecs.InstanceAttributesType, pagination *common.PaginationResult, err error)
 	DescribeNetworkInterfaces(ctx context.Context, args *ecs.DescribeNetworkInterfacesArgs) (resp *ecs.DescribeNetworkInterfacesResponse, err error)
 	DescribeEipAddresses(ctx context.Context, args *ecs.DescribeEipAddressesArgs) (eipAddresses []ecs.EipAddressSetType, pagination *common.PaginationResult, err error)
+	AssociateEipAddress(ctx context.Context, args *AssociateEipAddressArgs) error
+	UnassociateEipAddress(ctx context.Context, args *UnassociateEipAddressArgs) error
 }
 
 func (s *InstanceClient) filterOutByLabel(nodes []*v1.Node, labels string) ([]*v1.Node, error) {
@@ -209,6 +211,10 @@ func (s *InstanceClient) ListInstances(ctx context.Context, ids []string) (map[s
 					InstanceID:   n.InstanceId,
 					InstanceType: n.InstanceType,
 					Addresses:    s.findAddressByInstance(&n),
+					Status:       string(n.Status),
+					Expired:      financialLocked(&n),
+					Zone:         n.ZoneId,
+					Region:       string(n.RegionId),
 				}
 				break
 			}
@@ -217,6 +223,17 @@ func (s *InstanceClient) ListInstances(ctx context.Context, ids []string) (map[s
 	return mins, nil
 }
 
+// financialLocked whether the instance is locked for its overdue payment,
+// eg. an expired subscription
+func financialLocked(ins *ecs.InstanceAttributesType) bool {
+	for _, lock := range ins.OperationLocks.LockReason {
+		if lock.LockReason == ecs.LockReasonFinancial {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *InstanceClient) getInstances(ctx context.Context, ids []string, region common.Region) ([]ecs.InstanceAttributesType, error) {
 	bids, err := json.Marshal(ids)
 	if err != nil {
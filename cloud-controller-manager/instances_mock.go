@@ -16,6 +16,7 @@ var INSTANCE = InstanceStore{}
 type InstanceStore struct {
 	instance sync.Map
 	enis     sync.Map
+	eips     sync.Map
 }
 
 func WithNewInstanceStore() CloudDataMock {
@@ -98,6 +99,9 @@ type mockClientInstanceSDK struct {
 	describeInstances         func(args *ecs.DescribeInstancesArgs) (instances []ecs.InstanceAttributesType, pagination *common.PaginationResult, err error)
 	describeNetworkInterfaces func(args *ecs.DescribeNetworkInterfacesArgs) (resp *ecs.DescribeNetworkInterfacesResponse, err error)
 	describeEipAddresses      func(args *ecs.DescribeEipAddressesArgs) (eipAddresses []ecs.EipAddressSetType, pagination *common.PaginationResult, err error)
+	associateEipAddress       func(args *AssociateEipAddressArgs) error
+	unassociateEipAddress     func(args *UnassociateEipAddressArgs) error
+	addTags                   func(args *ecs.AddTagsArgs) error
 }
 
 func (m *mockClientInstanceSDK) DescribeInstances(ctx context.Context, args *ecs.DescribeInstancesArgs) (instances []ecs.InstanceAttributesType, pagination *common.PaginationResult, err error) {
@@ -130,7 +134,12 @@ func (m *mockClientInstanceSDK) DescribeInstances(ctx context.Context, args *ecs
 	return results, nil, nil
 }
 
-func (m *mockClientInstanceSDK) AddTags(ctx context.Context, args *ecs.AddTagsArgs) error { return nil }
+func (m *mockClientInstanceSDK) AddTags(ctx context.Context, args *ecs.AddTagsArgs) error {
+	if m.addTags != nil {
+		return m.addTags(args)
+	}
+	return nil
+}
 
 func (m *mockClientInstanceSDK) DescribeNetworkInterfaces(ctx context.Context, args *ecs.DescribeNetworkInterfacesArgs) (resp *ecs.DescribeNetworkInterfacesResponse, err error) {
 	if m.describeNetworkInterfaces != nil {
@@ -160,5 +169,54 @@ func (m *mockClientInstanceSDK) DescribeEipAddresses(ctx context.Context, args *
 	if m.describeEipAddresses != nil {
 		return m.describeEipAddresses(args)
 	}
-	return nil, nil, nil
+	var results []ecs.EipAddressSetType
+	INSTANCE.eips.Range(
+		func(key, value interface{}) bool {
+			v := value.(ecs.EipAddressSetType)
+			if args.AllocationId != "" &&
+				args.AllocationId != v.AllocationId {
+				// continue next
+				return true
+			}
+			if args.AssociatedInstanceId != "" &&
+				args.AssociatedInstanceId != v.InstanceId {
+				// continue next
+				return true
+			}
+			results = append(results, v)
+			return true
+		},
+	)
+	return results, nil, nil
+}
+
+func (m *mockClientInstanceSDK) AssociateEipAddress(ctx context.Context, args *AssociateEipAddressArgs) error {
+	if m.associateEipAddress != nil {
+		return m.associateEipAddress(args)
+	}
+	v, ok := INSTANCE.eips.Load(args.AllocationId)
+	if !ok {
+		return fmt.Errorf("eip %s not found", args.AllocationId)
+	}
+	eip := v.(ecs.EipAddressSetType)
+	if eip.InstanceId != "" {
+		return fmt.Errorf("eip %s has been associated with %s", args.AllocationId, eip.InstanceId)
+	}
+	eip.InstanceId = args.InstanceId
+	INSTANCE.eips.Store(args.AllocationId, eip)
+	return nil
+}
+
+func (m *mockClientInstanceSDK) UnassociateEipAddress(ctx context.Context, args *UnassociateEipAddressArgs) error {
+	if m.unassociateEipAddress != nil {
+		return m.unassociateEipAddress(args)
+	}
+	v, ok := INSTANCE.eips.Load(args.AllocationId)
+	if !ok {
+		return fmt.Errorf("eip %s not found", args.AllocationId)
+	}
+	eip := v.(ecs.EipAddressSetType)
+	eip.InstanceId = ""
+	INSTANCE.eips.Store(args.AllocationId, eip)
+	return nil
 }
@@ -0,0 +1,190 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+)
+
+// The instance charge type is neither returned nor accepted by aliyungo,
+// so the request and response types are declared here and invoked directly.
+
+// InstanceChargeType how the loadbalancer instance is charged
+type InstanceChargeType string
+
+const (
+	// PayBySpec charged by the LoadBalancerSpec of the instance
+	PayBySpec = InstanceChargeType("PayBySpec")
+	// PayByCLCU charged by the capacity units used, the instance has no spec
+	PayByCLCU = InstanceChargeType("PayByCLCU")
+)
+
+type DescribeLoadBalancerInstanceChargeTypeArgs struct {
+	RegionId       common.Region
+	LoadBalancerId string
+}
+
+// DescribeLoadBalancerInstanceChargeTypeResponse the part of the
+// DescribeLoadBalancerAttribute response missing in slb.LoadBalancerType
+type DescribeLoadBalancerInstanceChargeTypeResponse struct {
+	common.Response
+	LoadBalancerId     string
+	InstanceChargeType InstanceChargeType
+	LoadBalancerSpec   slb.LoadBalancerSpecType
+}
+
+type ModifyLoadBalancerInstanceChargeTypeArgs struct {
+	RegionId           common.Region
+	LoadBalancerId     string
+	InstanceChargeType InstanceChargeType
+	// LoadBalancerSpec is required when switching to PayBySpec
+	LoadBalancerSpec slb.LoadBalancerSpecType `json:",omitempty"`
+}
+
+// ValidateInstanceChargeType PayByCLCU loadbalancers have no spec to choose.
+func ValidateInstanceChargeType(service *v1.Service) error {
+	charge := serviceAnnotation(service, ServiceAnnotationLoadBalancerInstanceChargeType)
+	switch InstanceChargeType(charge) {
+	case "", PayBySpec:
+	case PayByCLCU:
+		if serviceAnnotation(service, ServiceAnnotationLoadBalancerSpec) != "" {
+			return annotationError{
+				annotation: ServiceAnnotationLoadBalancerInstanceChargeType,
+				token:      charge,
+				reason:     fmt.Sprintf("can not be used together with %s", ServiceAnnotationLoadBalancerSpec),
+			}
+		}
+	default:
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerInstanceChargeType,
+			token:      charge,
+			reason:     fmt.Sprintf("must be one of %s, %s", PayBySpec, PayByCLCU),
+		}
+	}
+	return nil
+}
+
+// instanceChargeType the current instance charge type of lb. Loadbalancers
+// created before the charge type was introduced report none, they are PayBySpec.
+func instanceChargeType(ctx context.Context, slbClient ClientSLBSDK, lb *slb.LoadBalancerType) (InstanceChargeType, error) {
+	resp, err := slbClient.DescribeLoadBalancerInstanceChargeType(
+		ctx,
+		&DescribeLoadBalancerInstanceChargeTypeArgs{
+			RegionId:       lb.RegionId,
+			LoadBalancerId: lb.LoadBalancerId,
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("describe instance charge type of loadbalancer %s: %s", lb.LoadBalancerId, err.Error())
+	}
+	if resp.InstanceChargeType == "" {
+		return PayBySpec, nil
+	}
+	return resp.InstanceChargeType, nil
+}
+
+// ensureInstanceChargeType switch the instance charge type of lb to the
+// annotation. It is also called right after creation, for CreateLoadBalancer
+// of the sdk takes no instance charge type.
+func ensureInstanceChargeType(ctx context.Context, slbClient ClientSLBSDK, lb *slb.LoadBalancerType,
+	service *v1.Service, request *AnnotationRequest) error {
+	if request.InstanceChargeType == "" {
+		return nil
+	}
+	current, err := instanceChargeType(ctx, slbClient, lb)
+	if err != nil {
+		return err
+	}
+	if current == request.InstanceChargeType {
+		return nil
+	}
+	args := &ModifyLoadBalancerInstanceChargeTypeArgs{
+		RegionId:           lb.RegionId,
+		LoadBalancerId:     lb.LoadBalancerId,
+		InstanceChargeType: request.InstanceChargeType,
+	}
+	if request.InstanceChargeType == PayBySpec {
+		defaulted, _ := ExtractAnnotationRequest(service)
+		args.LoadBalancerSpec = defaulted.LoadBalancerSpec
+	}
+	utils.Logf(service, "instance charge type changed([%s] -> [%s]), update loadbalancer [%s]",
+		current, request.InstanceChargeType, lb.LoadBalancerId)
+	if err := slbClient.ModifyLoadBalancerInstanceChargeType(ctx, args); err != nil {
+		return fmt.Errorf("modify instance charge type of loadbalancer %s: %s", lb.LoadBalancerId, err.Error())
+	}
+	lb.LoadBalancerSpec = args.LoadBalancerSpec
+	return nil
+}
+
+// ensureInstanceSpec resize lb to the spec annotation in place. Changes the
+// api does not allow are reported by an event instead of failing every sync:
+// PayByCLCU loadbalancers have no spec, and shared loadbalancers can not be
+// resized to a performance guaranteed spec.
+func ensureInstanceSpec(ctx context.Context, slbClient ClientSLBSDK, lb *slb.LoadBalancerType,
+	service *v1.Service, request *AnnotationRequest) error {
+	if request.LoadBalancerSpec == "" || request.LoadBalancerSpec == lb.LoadBalancerSpec {
+		return nil
+	}
+	current, err := instanceChargeType(ctx, slbClient, lb)
+	if err != nil {
+		return err
+	}
+	if current == PayByCLCU {
+		recordInstanceSpecUnsupported(ctx, service,
+			"Spec of loadbalancer %s can not be changed to %s, it is charged by %s. "+
+				"Set annotation %s to %s to choose a spec",
+			lb.LoadBalancerId, request.LoadBalancerSpec, PayByCLCU,
+			ServiceAnnotationLoadBalancerInstanceChargeType, PayBySpec)
+		return nil
+	}
+	if lb.LoadBalancerSpec == "" {
+		recordInstanceSpecUnsupported(ctx, service,
+			"Shared loadbalancer %s can not be resized to spec %s, recreate the loadbalancer to use a guaranteed spec",
+			lb.LoadBalancerId, request.LoadBalancerSpec)
+		return nil
+	}
+	utils.Logf(service, "loadbalancer spec changed([%s] -> [%s]), update loadbalancer [%s]",
+		lb.LoadBalancerSpec, request.LoadBalancerSpec, lb.LoadBalancerId)
+	if err := slbClient.ModifyLoadBalancerInstanceSpec(
+		ctx,
+		&slb.ModifyLoadBalancerInstanceSpecArgs{
+			RegionId:         lb.RegionId,
+			LoadBalancerId:   lb.LoadBalancerId,
+			LoadBalancerSpec: request.LoadBalancerSpec,
+		},
+	); err != nil {
+		return err
+	}
+	lb.LoadBalancerSpec = request.LoadBalancerSpec
+	return nil
+}
+
+func recordInstanceSpecUnsupported(ctx context.Context, service *v1.Service, format string, args ...interface{}) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, format, args...)
+		return
+	}
+	record.Eventf(service, v1.EventTypeWarning, "ModifyInstanceSpecUnsupported", format, args...)
+}
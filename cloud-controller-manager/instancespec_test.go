@@ -0,0 +1,210 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestInstanceSpec(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerInstanceChargeType: string(PayByCLCU),
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	// expect the loadbalancer charged by charge with spec
+	expect := func(f *FrameWork, charge InstanceChargeType, spec slb.LoadBalancerSpecType) error {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			return fmt.Errorf("find loadbalancer: %v", err)
+		}
+		resp, err := f.SLBSDK().DescribeLoadBalancerInstanceChargeType(
+			ctx,
+			&DescribeLoadBalancerInstanceChargeTypeArgs{LoadBalancerId: lb.LoadBalancerId},
+		)
+		if err != nil {
+			return err
+		}
+		if resp.InstanceChargeType != charge || lb.LoadBalancerSpec != spec {
+			return fmt.Errorf("expect %s[%s], got %s[%s]",
+				charge, spec, resp.InstanceChargeType, lb.LoadBalancerSpec)
+		}
+		return nil
+	}
+	// ensure the service, the sync is expected to succeed with an unsupported event or none
+	ensure := func(f *FrameWork, unsupported bool) error {
+		recorder := record.NewFakeRecorder(10)
+		ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+		if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+			return err
+		}
+		found := false
+		for len(recorder.Events) > 0 {
+			if strings.Contains(<-recorder.Events, "ModifyInstanceSpecUnsupported") {
+				found = true
+			}
+		}
+		if found != unsupported {
+			return fmt.Errorf("expect ModifyInstanceSpecUnsupported event: %v, got %v", unsupported, found)
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Create PayByCLCU loadbalancer",
+		func(f *FrameWork) error {
+			if err := ensure(f, false); err != nil {
+				return err
+			}
+			return expect(f, PayByCLCU, "")
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerInstanceChargeType] = string(PayBySpec)
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerSpec] = string(slb.S1Small)
+	f.RunCustomized(t, "Switch to PayBySpec",
+		func(f *FrameWork) error {
+			if err := ensure(f, false); err != nil {
+				return err
+			}
+			return expect(f, PayBySpec, slb.S1Small)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerSpec] = string(slb.S2Small)
+	f.RunCustomized(t, "Resize spec in place",
+		func(f *FrameWork) error {
+			if err := ensure(f, false); err != nil {
+				return err
+			}
+			if err := ExpectExistAndEqual(f); err != nil {
+				return err
+			}
+			return expect(f, PayBySpec, slb.S2Small)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerSpec] = string(slb.S3Small)
+	f.RunCustomized(t, "Resize PayByCLCU loadbalancer",
+		func(f *FrameWork) error {
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			// switched to PayByCLCU in the console
+			err = f.SLBSDK().ModifyLoadBalancerInstanceChargeType(
+				context.Background(),
+				&ModifyLoadBalancerInstanceChargeTypeArgs{LoadBalancerId: lb.LoadBalancerId, InstanceChargeType: PayByCLCU},
+			)
+			if err != nil {
+				return err
+			}
+			delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerInstanceChargeType)
+			if err := ensure(f, true); err != nil {
+				return err
+			}
+			return expect(f, PayByCLCU, "")
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerSpec] = string(slb.S2Medium)
+	f.RunCustomized(t, "Resize shared loadbalancer",
+		func(f *FrameWork) error {
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			// a shared loadbalancer is charged by spec but has none
+			LOADBALANCER.instanceChargeTypes.Delete(lb.LoadBalancerId)
+			if err := ensure(f, true); err != nil {
+				return err
+			}
+			return expect(f, PayBySpec, "")
+		},
+	)
+}
+
+func TestValidateInstanceChargeType(t *testing.T) {
+	cases := []struct {
+		annotations map[string]string
+		invalid     bool
+	}{
+		{annotations: map[string]string{}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerInstanceChargeType: string(PayBySpec)}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerInstanceChargeType: string(PayByCLCU)}},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerInstanceChargeType: string(PayBySpec),
+				ServiceAnnotationLoadBalancerSpec:               string(slb.S2Small),
+			},
+		},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerInstanceChargeType: string(PayByCLCU),
+				ServiceAnnotationLoadBalancerSpec:               string(slb.S2Small),
+			},
+			invalid: true,
+		},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerInstanceChargeType: "paybyclcu"}, invalid: true},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: c.annotations}}
+		err := ValidateInstanceChargeType(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("%v: unexpected error: %s", c.annotations, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%v: expect InvalidAnnotation error, got %v", c.annotations, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strconv"
+)
+
+// A paybybandwidth loadbalancer pays for a bandwidth within the range of its
+// spec, the api rejects a bandwidth out of it with an error which retrying
+// does not fix. The bandwidth is fit in the range before any create or modify
+// call instead: it is rejected as an invalid annotation, or clamped to the
+// range with the bandwidth-policy annotation set to clamp. The ranges are
+// embedded by spec, a region with a narrower range is still told by the api.
+//
+// The bandwidth of paybytraffic is only a cap, a loadbalancer switched to
+// paybybandwidth is given the bandwidth annotation, or DEFAULT_BANDWIDTH, in
+// the same ModifyLoadBalancerInternetSpec call.
+
+const (
+	// BandwidthPolicyReject reject the bandwidth out of the range of the spec
+	BandwidthPolicyReject = "reject"
+	// BandwidthPolicyClamp clamp the bandwidth to the range of the spec
+	BandwidthPolicyClamp = "clamp"
+)
+
+// bandwidthRange the range of the bandwidth of paybybandwidth in Mbps
+type bandwidthRange struct {
+	min int
+	max int
+}
+
+// specBandwidthRanges the ranges of the specs narrower than the default one,
+// [MIN_BANDWIDTH, MAX_BANDWIDTH]
+var specBandwidthRanges = map[slb.LoadBalancerSpecType]bandwidthRange{
+	"slb.s1.small":  {min: 1, max: 1024},
+	"slb.s2.small":  {min: 1, max: 2048},
+	"slb.s2.medium": {min: 1, max: 2048},
+}
+
+// bandwidthRangeOf the range of the bandwidth of the spec
+func bandwidthRangeOf(spec slb.LoadBalancerSpecType) bandwidthRange {
+	if r, ok := specBandwidthRanges[spec]; ok {
+		return r
+	}
+	return bandwidthRange{min: MIN_BANDWIDTH, max: MAX_BANDWIDTH}
+}
+
+// bandwidthPolicy the bandwidth-policy annotation, reject by default
+func bandwidthPolicy(service *v1.Service) string {
+	if policy := serviceAnnotation(service, ServiceAnnotationLoadBalancerBandwidthPolicy); policy != "" {
+		return policy
+	}
+	return BandwidthPolicyReject
+}
+
+// fitBandwidth the bandwidth within the range of the spec, clamped by the
+// clamp policy or rejected otherwise
+func fitBandwidth(service *v1.Service, spec slb.LoadBalancerSpecType, bandwidth int) (int, error) {
+	r := bandwidthRangeOf(spec)
+	if bandwidth >= r.min && bandwidth <= r.max {
+		return bandwidth, nil
+	}
+	if bandwidthPolicy(service) == BandwidthPolicyClamp {
+		if bandwidth < r.min {
+			return r.min, nil
+		}
+		return r.max, nil
+	}
+	return 0, annotationError{
+		annotation: ServiceAnnotationLoadBalancerBandwidth,
+		token:      strconv.Itoa(bandwidth),
+		reason: fmt.Sprintf("%s loadbalancer of spec %s takes a bandwidth in range [%d, %d] Mbps, "+
+			"or set %s to %s", slb.PayByBandwidth, specOrDefault(spec), r.min, r.max,
+			ServiceAnnotationLoadBalancerBandwidthPolicy, BandwidthPolicyClamp),
+	}
+}
+
+func specOrDefault(spec slb.LoadBalancerSpecType) string {
+	if spec == "" {
+		return "shared"
+	}
+	return string(spec)
+}
+
+// createBandwidth the bandwidth of the paybybandwidth loadbalancer to be
+// created, fit in the range of its spec
+func createBandwidth(ctx context.Context, service *v1.Service) (int, error) {
+	defaulted, _ := ExtractAnnotationRequest(service)
+	bandwidth, err := fitBandwidth(service, defaulted.LoadBalancerSpec, defaulted.Bandwidth)
+	if err != nil {
+		recordInvalidAnnotation(ctx, service, err)
+		return 0, err
+	}
+	if bandwidth != defaulted.Bandwidth {
+		recordBandwidthClamped(ctx, service, defaulted.LoadBalancerSpec, defaulted.Bandwidth, bandwidth)
+	}
+	return bandwidth, nil
+}
+
+// recordBandwidthClamped tell the bandwidth is clamped to the range of the spec
+func recordBandwidthClamped(ctx context.Context, service *v1.Service, spec slb.LoadBalancerSpecType, from, to int) {
+	utils.Logf(service, "bandwidth %d clamped to %d Mbps by spec %s", from, to, specOrDefault(spec))
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"BandwidthClamped",
+		"Bandwidth %d is out of the range of spec %s, clamped to %d Mbps",
+		from, specOrDefault(spec), to,
+	)
+}
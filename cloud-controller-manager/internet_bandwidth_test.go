@@ -0,0 +1,208 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestInternetBandwidthRange(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		charge    string
+		spec      string
+		bandwidth string
+		policy    string
+		// fit the bandwidth of the spec, 0 if rejected
+		fit int
+	}{
+		{name: "within the spec", charge: "paybybandwidth", spec: "slb.s1.small", bandwidth: "1024", fit: 1024},
+		{name: "over the spec", charge: "paybybandwidth", spec: "slb.s1.small", bandwidth: "2000"},
+		{name: "over the spec clamped", charge: "paybybandwidth", spec: "slb.s1.small", bandwidth: "2000",
+			policy: BandwidthPolicyClamp, fit: 1024},
+		{name: "over the spec rejected", charge: "paybybandwidth", spec: "slb.s2.small", bandwidth: "3000",
+			policy: BandwidthPolicyReject},
+		{name: "larger spec", charge: "paybybandwidth", spec: "slb.s3.large", bandwidth: "5120", fit: 5120},
+		{name: "shared", charge: "paybybandwidth", bandwidth: "5120", fit: 5120},
+		{name: "paybytraffic peak", charge: "paybytraffic", spec: "slb.s1.small", bandwidth: "2000", fit: 2000},
+		{name: "over the max", charge: "paybybandwidth", bandwidth: "5121"},
+		{name: "over the max clamped", charge: "paybybandwidth", bandwidth: "5121", policy: BandwidthPolicyClamp},
+	} {
+		svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			ServiceAnnotationLoadBalancerChargeType: c.charge,
+			ServiceAnnotationLoadBalancerBandwidth:  c.bandwidth,
+		}}}
+		if c.spec != "" {
+			svc.Annotations[ServiceAnnotationLoadBalancerSpec] = c.spec
+		}
+		if c.policy != "" {
+			svc.Annotations[ServiceAnnotationLoadBalancerBandwidthPolicy] = c.policy
+		}
+		err := ValidateInternetSpec(svc)
+		if (err == nil) != (c.fit != 0) {
+			t.Errorf("%s: expect valid %t, got %v", c.name, c.fit != 0, err)
+			continue
+		}
+		if err != nil {
+			if !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+				t.Errorf("%s: expect an invalid annotation error, got %s", c.name, err.Error())
+			}
+			continue
+		}
+		if c.charge != string(slb.PayByBandwidth) {
+			continue
+		}
+		lb := &slb.LoadBalancerType{
+			InternetChargeType: slb.PayByTraffic,
+			LoadBalancerSpec:   slb.LoadBalancerSpecType(c.spec),
+		}
+		_, request := ExtractAnnotationRequest(svc)
+		if _, bandwidth, err := internetSpec(lb, svc, request); err != nil || bandwidth != c.fit {
+			t.Errorf("%s: expect bandwidth %d, got %d, %v", c.name, c.fit, bandwidth, err)
+		}
+	}
+
+	// the spec of the loadbalancer is checked once described
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		ServiceAnnotationLoadBalancerChargeType: string(slb.PayByBandwidth),
+		ServiceAnnotationLoadBalancerBandwidth:  "2000",
+	}}}
+	if err := ValidateInternetSpec(svc); err != nil {
+		t.Fatalf("expect the bandwidth of an unknown spec accepted, got %s", err.Error())
+	}
+	lb := &slb.LoadBalancerType{InternetChargeType: slb.PayByBandwidth, Bandwidth: 100, LoadBalancerSpec: "slb.s1.small"}
+	_, request := ExtractAnnotationRequest(svc)
+	if _, _, err := internetSpec(lb, svc, request); err == nil || !strings.Contains(err.Error(), "[1, 1024]") {
+		t.Fatalf("expect the bandwidth rejected by the spec of the loadbalancer, got %v", err)
+	}
+	// an unchanged bandwidth is not checked
+	lb.Bandwidth = 2000
+	if _, _, err := internetSpec(lb, svc, request); err != nil {
+		t.Fatalf("expect the unchanged bandwidth kept, got %s", err.Error())
+	}
+}
+
+func TestInternetChargeTypeSwitch(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerChargeType: string(slb.PayByTraffic),
+					ServiceAnnotationLoadBalancerSpec:       "slb.s1.small",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	mock := f.SLBSDK().(*mockClientSLB)
+	var calls []slb.ModifyLoadBalancerInternetSpecArgs
+	mock.modifyLoadBalancerInternetSpec = func(args *slb.ModifyLoadBalancerInternetSpecArgs) error {
+		calls = append(calls, *args)
+		return (&mockClientSLB{}).ModifyLoadBalancerInternetSpec(context.Background(), args)
+	}
+	defer func() { mock.modifyLoadBalancerInternetSpec = nil }()
+
+	recorder := record.NewFakeRecorder(100)
+	ensure := func(f *FrameWork) error {
+		ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+		_, err := f.Cloud.EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+		return err
+	}
+	// expect a single modification to the internet spec
+	expect := func(charge slb.InternetChargeType, bandwidth int) CustomizedTest {
+		return func(f *FrameWork) error {
+			calls = nil
+			if err := ensure(f); err != nil {
+				return err
+			}
+			if len(calls) != 1 || calls[0].InternetChargeType != charge || calls[0].Bandwidth != bandwidth {
+				return fmt.Errorf("expect a single modification to %s:%d, got %+v", charge, bandwidth, calls)
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil {
+				return err
+			}
+			if lb.InternetChargeType != charge || lb.Bandwidth != bandwidth {
+				return fmt.Errorf("expect internet spec %s:%d, got %s:%d",
+					charge, bandwidth, lb.InternetChargeType, lb.Bandwidth)
+			}
+			return nil
+		}
+	}
+	// expect the sync rejected before any modification
+	rejected := func(f *FrameWork) error {
+		calls = nil
+		err := ensure(f)
+		if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) || !strings.Contains(err.Error(), "[1, 1024]") {
+			return fmt.Errorf("expect the bandwidth rejected by the spec, got %v", err)
+		}
+		if len(calls) != 0 {
+			return fmt.Errorf("expect no modification, got %+v", calls)
+		}
+		for len(recorder.Events) > 0 {
+			if event := <-recorder.Events; strings.Contains(event, utils.InvalidAnnotation) {
+				return nil
+			}
+		}
+		return fmt.Errorf("expect an event of the invalid bandwidth")
+	}
+
+	f.RunCustomized(t, "Create paybytraffic", func(f *FrameWork) error { return ensure(f) })
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerChargeType] = string(slb.PayByBandwidth)
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerBandwidth] = "2000"
+	f.RunCustomized(t, "Switch rejected by the spec", rejected)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerBandwidthPolicy] = BandwidthPolicyClamp
+	f.RunCustomized(t, "Switch with the bandwidth clamped", expect(slb.PayByBandwidth, 1024))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerChargeType] = string(slb.PayByTraffic)
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerBandwidth)
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerBandwidthPolicy)
+	f.RunCustomized(t, "Switch back to paybytraffic", expect(slb.PayByTraffic, 1024))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerChargeType] = string(slb.PayByBandwidth)
+	f.RunCustomized(t, "Switch with the default bandwidth", expect(slb.PayByBandwidth, DEFAULT_BANDWIDTH))
+}
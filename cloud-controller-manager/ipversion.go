@@ -0,0 +1,144 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"net"
+)
+
+// isIPv6Family whether spec.ipFamily of the service is IPv6. Dual stack
+// services, spec.ipFamilies and spec.ipFamilyPolicy, are not available in the
+// api version this provider is built with.
+func isIPv6Family(service *v1.Service) bool {
+	return service.Spec.IPFamily != nil && *service.Spec.IPFamily == v1.IPv6Protocol
+}
+
+// ValidateIPVersion IPv6 loadbalancers are internet facing, vpc and charged
+// by spec, other combinations are rejected by the api after a long wait.
+// The annotation must agree with the ip family of an IPv6 service.
+func ValidateIPVersion(service *v1.Service) error {
+	version := serviceAnnotation(service, ServiceAnnotationLoadBalancerIPVersion)
+	switch slb.AddressIPVersionType(version) {
+	case "":
+		if !isIPv6Family(service) {
+			return nil
+		}
+	case slb.IPv4:
+		if isIPv6Family(service) {
+			return annotationError{
+				annotation: ServiceAnnotationLoadBalancerIPVersion,
+				token:      version,
+				reason:     fmt.Sprintf("conflicts with ip family %s of the service", v1.IPv6Protocol),
+			}
+		}
+		return nil
+	case slb.IPv6:
+	default:
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerIPVersion,
+			token:      version,
+			reason:     fmt.Sprintf("must be one of %s, %s", slb.IPv4, slb.IPv6),
+		}
+	}
+	defaulted, _ := ExtractAnnotationRequest(service)
+	reason := ""
+	switch {
+	case defaulted.AddressType == slb.IntranetAddressType:
+		reason = fmt.Sprintf("%s loadbalancers do not support %s", slb.IntranetAddressType, slb.IPv6)
+	case defaulted.SLBNetworkType == "classic":
+		reason = fmt.Sprintf("classic network loadbalancers do not support %s", slb.IPv6)
+	case defaulted.InstanceChargeType == PayByCLCU:
+		reason = fmt.Sprintf("%s loadbalancers must be charged by %s", slb.IPv6, PayBySpec)
+	default:
+		return nil
+	}
+	return annotationError{
+		annotation: ServiceAnnotationLoadBalancerIPVersion,
+		token:      string(defaulted.AddressIPVersion),
+		reason:     reason,
+	}
+}
+
+// isIPFamilyMismatched whether the service asks for an IPv6 loadbalancer by
+// the annotation while its ip family is IPv4. The IPv6 address published is
+// of another family than the cluster ip and the endpoints, which a single
+// stack cluster can not serve, eg. kube-proxy ignores the ingress ip. A dual
+// stack service is the way to serve both and it can not be expressed by the
+// api version this provider is built with, see isIPv6Family.
+func isIPFamilyMismatched(service *v1.Service, request *AnnotationRequest) bool {
+	return service.Spec.IPFamily != nil && *service.Spec.IPFamily == v1.IPv4Protocol &&
+		request.AddressIPVersion == slb.IPv6
+}
+
+// recordIPFamilyMismatch explain that the loadbalancer of the service is not
+// of the ip family of the service.
+func recordIPFamilyMismatch(ctx context.Context, service *v1.Service, request *AnnotationRequest) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "ip version %s of the loadbalancer differs from ip family %s of the service",
+			request.AddressIPVersion, *service.Spec.IPFamily)
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"IPFamilyUnsupported",
+		"IP version %s of annotation %s differs from ip family %s of the service, "+
+			"the address published is not served in the cluster as dual stack services are not supported",
+		request.AddressIPVersion, ServiceAnnotationLoadBalancerIPVersion, *service.Spec.IPFamily,
+	)
+}
+
+// loadBalancerAddress the address of lb in its canonical form, so that
+// IPv6 addresses are reported the same way kubernetes formats them.
+func loadBalancerAddress(lb *slb.LoadBalancerType) string {
+	if ip := net.ParseIP(lb.Address); ip != nil {
+		return ip.String()
+	}
+	return lb.Address
+}
+
+// recordIPVersionChanged explain why the ip version annotation does not take effect.
+func recordIPVersionChanged(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType, request *AnnotationRequest) {
+	current, requested := lb.AddressIPVersion, request.AddressIPVersion
+	if current == "" {
+		current = slb.IPv4
+	}
+	if requested == "" {
+		requested = slb.IPv4
+	}
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "can not change ip version of loadbalancer %s once created", lb.LoadBalancerId)
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"IPVersionChangeUnsupported",
+		"IP version of loadbalancer %s is %s, it can not be changed to %s without recreating the loadbalancer",
+		lb.LoadBalancerId, current, requested,
+	)
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestValidateIPVersion(t *testing.T) {
+	ipv6 := v1.IPv6Protocol
+	cases := []struct {
+		annotations map[string]string
+		family      *v1.IPFamily
+		invalid     bool
+	}{
+		{annotations: map[string]string{}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerIPVersion: string(slb.IPv4)}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerIPVersion: string(slb.IPv6)}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerIPVersion: "IPv6"}, invalid: true},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerIPVersion:   string(slb.IPv4),
+				ServiceAnnotationLoadBalancerAddressType: string(slb.IntranetAddressType),
+			},
+		},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerIPVersion:   string(slb.IPv6),
+				ServiceAnnotationLoadBalancerAddressType: string(slb.IntranetAddressType),
+			},
+			invalid: true,
+		},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerIPVersion:      string(slb.IPv6),
+				ServiceAnnotationLoadBalancerSLBNetworkType: "classic",
+			},
+			invalid: true,
+		},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerIPVersion:          string(slb.IPv6),
+				ServiceAnnotationLoadBalancerInstanceChargeType: string(PayByCLCU),
+			},
+			invalid: true,
+		},
+		{annotations: map[string]string{}, family: &ipv6},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerIPVersion: string(slb.IPv6)}, family: &ipv6},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerIPVersion: string(slb.IPv4)}, family: &ipv6, invalid: true},
+		{
+			annotations: map[string]string{ServiceAnnotationLoadBalancerAddressType: string(slb.IntranetAddressType)},
+			family:      &ipv6,
+			invalid:     true,
+		},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: c.annotations},
+			Spec:       v1.ServiceSpec{IPFamily: c.family},
+		}
+		err := ValidateIPVersion(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("%v: unexpected error: %s", c.annotations, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%v: expect InvalidAnnotation error, got %v", c.annotations, err)
+		}
+	}
+}
+
+func TestIPv6LoadBalancer(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerIPVersion: string(slb.IPv6),
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Create IPv6 loadbalancer",
+		func(f *FrameWork) error {
+			status, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err != nil {
+				return err
+			}
+			if err := ExpectExistAndEqual(f); err != nil {
+				return err
+			}
+			if len(status.Ingress) != 1 || status.Ingress[0].IP != "2408:4005:3ff:b500::1" {
+				return fmt.Errorf("expect ingress of the IPv6 address, got %v", status.Ingress)
+			}
+			status, exist, err := f.CloudImpl().GetLoadBalancer(context.Background(), CLUSTER_ID, f.SVC)
+			if err != nil || !exist {
+				return fmt.Errorf("get loadbalancer: %v, %v", exist, err)
+			}
+			if status.Ingress[0].IP != "2408:4005:3ff:b500::1" {
+				return fmt.Errorf("expect ingress of the IPv6 address, got %v", status.Ingress)
+			}
+			return nil
+		},
+	)
+
+	// an IPv6 service behaves like the annotation
+	ipv6 := v1.IPv6Protocol
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerIPVersion)
+	f.SVC.Spec.IPFamily = &ipv6
+	f.RunCustomized(t, "IPv6 ip family",
+		func(f *FrameWork) error {
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			for len(recorder.Events) > 0 {
+				if event := <-recorder.Events; strings.Contains(event, "IPVersionChangeUnsupported") {
+					return fmt.Errorf("unexpected event: %s", event)
+				}
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Spec.IPFamily = nil
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerIPVersion] = string(slb.IPv4)
+	f.RunCustomized(t, "IP version changed",
+		func(f *FrameWork) error {
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return fmt.Errorf("changed ip version is not expected to fail the sync: %s", err.Error())
+			}
+			for len(recorder.Events) > 0 {
+				if strings.Contains(<-recorder.Events, "IPVersionChangeUnsupported") {
+					return nil
+				}
+			}
+			return fmt.Errorf("expect an IPVersionChangeUnsupported event")
+		},
+	)
+
+	// an IPv6 loadbalancer of an IPv4 service is not served as dual stack
+	ipv4 := v1.IPv4Protocol
+	f.SVC.Spec.IPFamily = &ipv4
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerIPVersion] = string(slb.IPv6)
+	f.RunCustomized(t, "IP family mismatched",
+		func(f *FrameWork) error {
+			_, events, err := f.EnsureWithEvents()
+			if err != nil {
+				return fmt.Errorf("mismatched ip family is not expected to fail the sync: %s", err.Error())
+			}
+			if !strings.Contains(events, "IPFamilyUnsupported") || strings.Contains(events, "IPVersionChangeUnsupported") {
+				return fmt.Errorf("expect an IPFamilyUnsupported event only, got %s", events)
+			}
+			return ExpectExistAndEqual(f)
+		},
+	)
+}
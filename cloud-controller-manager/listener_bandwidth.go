@@ -0,0 +1,146 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Listeners of a paybybandwidth loadbalancer share its bandwidth, the
+// listener-bandwidth annotation caps each of them so that one port can not
+// starve the others. The cap of a single port is set by the annotation
+// suffixed by the port, eg. listener-bandwidth-443: "50", or by
+// port-overrides. The caps must fit in the bandwidth of the loadbalancer,
+// while -1, the default, shares it without a cap and is the only value the
+// other charge types accept.
+
+var listenerBandwidthFormat = &annotationFormat{
+	expected: fmt.Sprintf("%d or an integer in range [%d, %d]", DEFAULT_LISTENER_BANDWIDTH, MIN_BANDWIDTH, MAX_BANDWIDTH),
+	valid: func(value string) bool {
+		_, err := parseListenerBandwidth(value)
+		return err == nil
+	},
+}
+
+func parseListenerBandwidth(value string) (int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil || (i != DEFAULT_LISTENER_BANDWIDTH && (i < MIN_BANDWIDTH || i > MAX_BANDWIDTH)) {
+		return 0, fmt.Errorf("must be %s", listenerBandwidthFormat.expected)
+	}
+	return i, nil
+}
+
+// listenerBandwidthAnnotation the listener-bandwidth annotation of the port
+func listenerBandwidthAnnotation(port int32) string {
+	return fmt.Sprintf("%s-%d", ServiceAnnotationLoadBalancerListenerBandwidth, port)
+}
+
+// listenerBandwidthPort the port of the listener-bandwidth annotation of a
+// single port, 0 if the key is not one
+func listenerBandwidthPort(key string) int32 {
+	suffix := strings.TrimPrefix(key, ServiceAnnotationLoadBalancerListenerBandwidth+"-")
+	if suffix == key {
+		return 0
+	}
+	port, err := strconv.Atoi(suffix)
+	if err != nil || port < 1 || port > 65535 {
+		return 0
+	}
+	return int32(port)
+}
+
+// ListenerBandwidth the bandwidth cap of the listener of the service returned
+// by ServiceForPort, DEFAULT_LISTENER_BANDWIDTH if none is set
+func ListenerBandwidth(service *v1.Service) (int, error) {
+	value := serviceAnnotation(service, ServiceAnnotationLoadBalancerListenerBandwidth)
+	if value == "" {
+		return DEFAULT_LISTENER_BANDWIDTH, nil
+	}
+	return parseListenerBandwidth(value)
+}
+
+func (n *Listener) bandwidth() int {
+	// invalid value is rejected by ValidateListenerBandwidth
+	bandwidth, err := ListenerBandwidth(n.Service)
+	if err != nil {
+		return DEFAULT_LISTENER_BANDWIDTH
+	}
+	return bandwidth
+}
+
+// ValidateListenerBandwidth the caps of the listeners fit in the bandwidth of
+// the loadbalancer, as it is once the charge type and the bandwidth
+// annotations are applied.
+func ValidateListenerBandwidth(service *v1.Service, lb *slb.LoadBalancerType) error {
+	_, request := ExtractAnnotationRequest(service)
+	charge, total, err := internetSpec(lb, service, request)
+	if err != nil {
+		return err
+	}
+	caps := map[int32]int{}
+	sum := 0
+	for _, port := range service.Spec.Ports {
+		svc, err := ServiceForPort(service, port)
+		if err != nil {
+			return err
+		}
+		bandwidth, err := ListenerBandwidth(svc)
+		if err != nil {
+			return annotationError{
+				annotation: ServiceAnnotationLoadBalancerListenerBandwidth,
+				token:      serviceAnnotation(svc, ServiceAnnotationLoadBalancerListenerBandwidth),
+				reason:     fmt.Sprintf("port %d: %s", port.Port, err.Error()),
+			}
+		}
+		if bandwidth == DEFAULT_LISTENER_BANDWIDTH {
+			continue
+		}
+		if charge != slb.PayByBandwidth {
+			return annotationError{
+				annotation: ServiceAnnotationLoadBalancerListenerBandwidth,
+				token:      strconv.Itoa(bandwidth),
+				reason: fmt.Sprintf("port %d: only %s loadbalancers cap the bandwidth of the listeners, %s accepts %d",
+					port.Port, slb.PayByBandwidth, charge, DEFAULT_LISTENER_BANDWIDTH),
+			}
+		}
+		caps[port.Port] = bandwidth
+		sum += bandwidth
+	}
+	if sum <= total {
+		return nil
+	}
+	ports := make([]int, 0, len(caps))
+	for port := range caps {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+	tokens := make([]string, 0, len(ports))
+	for _, port := range ports {
+		tokens = append(tokens, fmt.Sprintf("%d:%d", port, caps[int32(port)]))
+	}
+	return annotationError{
+		annotation: ServiceAnnotationLoadBalancerListenerBandwidth,
+		token:      strings.Join(tokens, ","),
+		reason: fmt.Sprintf("the listeners are capped at %d Mbps in total, over the bandwidth %d Mbps of loadbalancer %s",
+			sum, total, lb.LoadBalancerId),
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestValidateListenerBandwidth(t *testing.T) {
+	paybybandwidth := &slb.LoadBalancerType{LoadBalancerId: "lb-1", InternetChargeType: slb.PayByBandwidth, Bandwidth: 100}
+	paybytraffic := &slb.LoadBalancerType{LoadBalancerId: "lb-1", InternetChargeType: slb.PayByTraffic, Bandwidth: 100}
+	cases := []struct {
+		name        string
+		lb          *slb.LoadBalancerType
+		annotations map[string]string
+		invalid     string
+	}{
+		{name: "no caps", lb: paybytraffic, annotations: map[string]string{}},
+		{
+			name:        "caps of every listener",
+			lb:          paybybandwidth,
+			annotations: map[string]string{ServiceAnnotationLoadBalancerListenerBandwidth: "30"},
+		},
+		{
+			name: "caps of a port",
+			lb:   paybybandwidth,
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerListenerBandwidth: "-1",
+				listenerBandwidthAnnotation(443):               "100",
+			},
+		},
+		{
+			name:        "over allocated",
+			lb:          paybybandwidth,
+			annotations: map[string]string{ServiceAnnotationLoadBalancerListenerBandwidth: "40"},
+			invalid:     "80:40,443:40,9000:40",
+		},
+		{
+			name: "port annotation takes precedence over the overrides",
+			lb:   paybybandwidth,
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerPortOverrides: `{"443":{"listener-bandwidth":"10"}}`,
+				listenerBandwidthAnnotation(443):           "60",
+				listenerBandwidthAnnotation(80):            "50",
+			},
+			invalid: "80:50,443:60",
+		},
+		{
+			name:        "paybytraffic accepts no cap",
+			lb:          paybytraffic,
+			annotations: map[string]string{ServiceAnnotationLoadBalancerListenerBandwidth: "-1"},
+		},
+		{
+			name:        "paybytraffic rejects caps",
+			lb:          paybytraffic,
+			annotations: map[string]string{listenerBandwidthAnnotation(80): "10"},
+			invalid:     "10",
+		},
+		{
+			name: "bandwidth of the annotations",
+			lb:   paybytraffic,
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerChargeType:        string(slb.PayByBandwidth),
+				ServiceAnnotationLoadBalancerBandwidth:         "300",
+				ServiceAnnotationLoadBalancerListenerBandwidth: "100",
+			},
+		},
+	}
+	for _, c := range cases {
+		err := ValidateListenerBandwidth(testService(c.annotations, overridePorts()...), c.lb)
+		if c.invalid == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", c.name, err.Error())
+			}
+			continue
+		}
+		e, ok := err.(annotationError)
+		if !ok || e.token != c.invalid {
+			t.Errorf("%s: expect the token [%s] rejected, got %v", c.name, c.invalid, err)
+		}
+	}
+}
+
+func TestListenerBandwidth(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerChargeType:        string(slb.PayByBandwidth),
+					ServiceAnnotationLoadBalancerBandwidth:         "100",
+					ServiceAnnotationLoadBalancerListenerBandwidth: "20",
+					listenerBandwidthAnnotation(443):               "50",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Name: "http", Port: 80, TargetPort: intstr.FromInt(80), Protocol: v1.ProtocolTCP, NodePort: 30080},
+					{Name: "https", Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 30443},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	// ensure the service and expect the bandwidth of the listeners
+	expect := func(bandwidths map[int]int) func(f *FrameWork) error {
+		return func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			for port, bandwidth := range bandwidths {
+				l, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(context.Background(), lb.LoadBalancerId, port)
+				if err != nil || l == nil {
+					return fmt.Errorf("expect listener %d: %v", port, err)
+				}
+				if l.Bandwidth != bandwidth {
+					return fmt.Errorf("expect listener %d capped at %d, got %d", port, bandwidth, l.Bandwidth)
+				}
+			}
+			return nil
+		}
+	}
+
+	f.RunCustomized(t, "Caps applied at creation", expect(map[int]int{80: 20, 443: 50}))
+
+	f.SVC.Annotations[listenerBandwidthAnnotation(443)] = "70"
+	f.RunCustomized(t, "Cap of a port changed", expect(map[int]int{80: 20, 443: 70}))
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerListenerBandwidth)
+	f.RunCustomized(t, "Cap removed", expect(map[int]int{80: DEFAULT_LISTENER_BANDWIDTH, 443: 70}))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerListenerBandwidth] = "40"
+	f.RunCustomized(t, "Over allocation rejected",
+		func(f *FrameWork) error {
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+				return fmt.Errorf("expect the over allocation rejected, got %v", err)
+			}
+			events := ""
+			for len(recorder.Events) > 0 {
+				events += <-recorder.Events
+			}
+			if !strings.Contains(events, utils.InvalidAnnotation) || !strings.Contains(events, "80:40,443:70") {
+				return fmt.Errorf("expect an InvalidAnnotation event of the caps, got %s", events)
+			}
+			delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerListenerBandwidth)
+			return expect(map[int]int{80: DEFAULT_LISTENER_BANDWIDTH, 443: 70})(f)
+		},
+	)
+}
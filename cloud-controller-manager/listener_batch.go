@@ -0,0 +1,192 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The listeners of a service are independent of each other, except that the
+// http listeners forwarding to https ones need them to exist, and a port is
+// taken until its listener is deleted. They are applied in phases, deletions,
+// https listeners and then the others, by a bounded number of workers each.
+// The listeners added are started in a final batch. A failed listener does not
+// abort the others, the failures are aggregated.
+
+// LISTENER_WORKERS listeners of a loadbalancer applied in parallel. The slb
+// api throttles the calls of an account and rejects those conflicting with
+// another operation in progress on the loadbalancer, both are retried by the
+// client wrappers, see REJECTED_API_CODES, and the syncs still rejected are
+// requeued with backoff by the service controller.
+var LISTENER_WORKERS = 5
+
+// listenerResults error of each listener applied, nil on success
+type listenerResults map[*Listener]error
+
+// parallelize call apply on each listener by LISTENER_WORKERS workers at most
+func parallelize(ctx context.Context, listeners Listeners, apply func(ctx context.Context, n *Listener) error) listenerResults {
+	results := listenerResults{}
+	if len(listeners) == 0 {
+		return results
+	}
+	workers := LISTENER_WORKERS
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+	queue := make(chan *Listener, len(listeners))
+	for _, n := range listeners {
+		queue <- n
+	}
+	close(queue)
+	for i := 0; i < workers && i < len(listeners); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range queue {
+				err := apply(ctx, n)
+				lock.Lock()
+				results[n] = err
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// applyListeners apply the actions of the listeners in phases, and start the
+// listeners added.
+func applyListeners(ctx context.Context, service *v1.Service, updates Listeners) error {
+	var deletions, secure, others Listeners
+	for _, up := range updates {
+		switch {
+		case isDeleteAction(up.Action):
+			deletions = append(deletions, up)
+		case strings.ToUpper(up.TransforedProto) == "HTTPS":
+			secure = append(secure, up)
+		default:
+			others = append(others, up)
+		}
+	}
+	results := listenerResults{}
+	apply := func(ctx context.Context, n *Listener) error { return n.Apply(ctx) }
+	for _, phase := range []Listeners{deletions, secure, others} {
+		for n, err := range parallelize(ctx, phase, apply) {
+			results[n] = err
+		}
+	}
+	var added Listeners
+	for _, up := range updates {
+		if up.Action == ACTION_ADD && results[up] == nil {
+			added = append(added, up)
+		}
+	}
+	start := func(ctx context.Context, n *Listener) error { return n.Start(ctx) }
+	for n, err := range parallelize(ctx, added, start) {
+		if err != nil {
+			results[n] = fmt.Errorf("start: %w", err)
+		}
+	}
+	recordListenerResults(ctx, service, updates, results)
+
+	var (
+		failures []string
+		first    error
+	)
+	for _, up := range updates {
+		if err := results[up]; err != nil {
+			failures = append(failures, fmt.Sprintf("%s %s:%d: %s", up.Action, up.Proto, up.Port, err.Error()))
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return &listenersError{
+			message: fmt.Sprintf("ensure listener: %d of %d failed, %s", len(failures), len(updates), strings.Join(failures, "; ")),
+			first:   first,
+		}
+	}
+	return nil
+}
+
+// listenersError the listeners failed to apply, unwrapped to the first failure
+// so that the code of its cloud api error is kept in the chain
+type listenersError struct {
+	message string
+	first   error
+}
+
+func (e *listenersError) Error() string { return e.message }
+
+func (e *listenersError) Unwrap() error { return e.first }
+
+// recordListenerResults log the listeners applied, the failed ones are
+// reported by an event. Every sync updates the listeners, an event of the
+// succeeded ones would be noise.
+func recordListenerResults(ctx context.Context, service *v1.Service, updates Listeners, results listenerResults) {
+	if len(updates) == 0 {
+		return
+	}
+	applied := map[string][]string{}
+	var failed []string
+	for _, up := range updates {
+		token := fmt.Sprintf("%s:%d", up.Proto, up.Port)
+		if err := results[up]; err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%s)", token, err.Error()))
+			continue
+		}
+		applied[up.Action] = append(applied[up.Action], token)
+	}
+	var summary []string
+	for _, action := range []string{ACTION_ADD, ACTION_UPDATE, ACTION_DELETE} {
+		if len(applied[action]) > 0 {
+			sort.Strings(applied[action])
+			summary = append(summary, fmt.Sprintf("%s [%s]", strings.ToLower(action), strings.Join(applied[action], ", ")))
+		}
+	}
+	if len(summary) > 0 {
+		utils.Logf(service, "listeners applied: %s", strings.Join(summary, ", "))
+	}
+	if len(failed) == 0 {
+		return
+	}
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "%d of %d listeners failed: %s", len(failed), len(updates), strings.Join(failed, "; "))
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"EnsureListenersFailed",
+		"%d of %d listeners failed: %s",
+		len(failed), len(updates), strings.Join(failed, "; "),
+	)
+}
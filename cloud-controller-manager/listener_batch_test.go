@@ -0,0 +1,184 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParallelListeners(t *testing.T) {
+	const (
+		PORTS       = 12
+		FAILED_PORT = 85
+	)
+	prid := nodeid(string(REGION), INSTANCEID)
+	var ports []v1.ServicePort
+	for i := int32(0); i < PORTS; i++ {
+		ports = append(ports, v1.ServicePort{
+			Name:       fmt.Sprintf("port-%d", i),
+			Port:       80 + i,
+			TargetPort: intstr.FromInt(int(8080 + i)),
+			Protocol:   v1.ProtocolTCP,
+			NodePort:   30080 + i,
+		})
+	}
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-service",
+				Namespace:   "default",
+				UID:         types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{},
+			},
+			Spec: v1.ServiceSpec{
+				Ports:           ports,
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	// calls of the listener api in order, e.g. "create:80", "start:80"
+	var (
+		lock  sync.Mutex
+		calls []string
+	)
+	call := func(action string, port int) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls = append(calls, fmt.Sprintf("%s:%d", action, port))
+	}
+	mock := f.SLBSDK().(*mockClientSLB)
+	mock.createLoadBalancerTCPListener = func(args *slb.CreateLoadBalancerTCPListenerArgs) error {
+		call("create", args.ListenerPort)
+		if args.ListenerPort == FAILED_PORT {
+			return fmt.Errorf("injected failure")
+		}
+		return (&mockClientSLB{}).CreateLoadBalancerTCPListener(context.Background(), args)
+	}
+	mock.startLoadBalancerListener = func(loadBalancerId string, port int) error {
+		call("start", port)
+		return (&mockClientSLB{}).StartLoadBalancerListener(context.Background(), loadBalancerId, port)
+	}
+	recorder := record.NewFakeRecorder(100)
+	ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+	// ports of the running listeners
+	running := func(f *FrameWork) (map[int]bool, error) {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return nil, fmt.Errorf("find loadbalancer: %v", err)
+		}
+		ports := map[int]bool{}
+		for _, p := range f.SVC.Spec.Ports {
+			res, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(context.Background(), lb.LoadBalancerId, int(p.Port))
+			if err == nil && res != nil && res.Status == slb.Running {
+				ports[int(p.Port)] = true
+			}
+		}
+		return ports, nil
+	}
+
+	f.RunCustomized(t, "Every listener is attempted despite a failed one",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), fmt.Sprintf(":%d: injected failure", FAILED_PORT)) {
+				return fmt.Errorf("expect the failure of port %d, got %v", FAILED_PORT, err)
+			}
+			created, started, lastCreate := 0, 0, -1
+			for i, c := range calls {
+				if strings.HasPrefix(c, "create:") {
+					created++
+					lastCreate = i
+				}
+			}
+			for i, c := range calls {
+				if !strings.HasPrefix(c, "start:") {
+					continue
+				}
+				started++
+				if i < lastCreate {
+					return fmt.Errorf("expect listeners started after all creations, got %v", calls)
+				}
+				if c == fmt.Sprintf("start:%d", FAILED_PORT) {
+					return fmt.Errorf("expect the failed listener not started")
+				}
+			}
+			if created != PORTS || started != PORTS-1 {
+				return fmt.Errorf("expect %d listeners created and %d started, got %d, %d", PORTS, PORTS-1, created, started)
+			}
+			ports, err := running(f)
+			if err != nil {
+				return err
+			}
+			if len(ports) != PORTS-1 || ports[FAILED_PORT] {
+				return fmt.Errorf("expect the listeners but port %d running, got %v", FAILED_PORT, ports)
+			}
+			found := false
+			for len(recorder.Events) > 0 {
+				event := <-recorder.Events
+				if strings.Contains(event, "EnsureListenersFailed") && strings.Contains(event, fmt.Sprintf(":%d (injected failure)", FAILED_PORT)) {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("expect an event of the failed listener")
+			}
+			return nil
+		},
+	)
+
+	mock.createLoadBalancerTCPListener = nil
+	calls = nil
+	f.RunCustomized(t, "Failed listener is created by the next sync",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			if len(calls) != 1 || calls[0] != fmt.Sprintf("start:%d", FAILED_PORT) {
+				return fmt.Errorf("expect only the listener of port %d started, got %v", FAILED_PORT, calls)
+			}
+			ports, err := running(f)
+			if err != nil {
+				return err
+			}
+			if len(ports) != PORTS {
+				return fmt.Errorf("expect all listeners running, got %v", ports)
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+)
+
+// An https listener serves a server certificate of each algorithm, the rsa
+// one by ServerCertificateId and an ecc one along with it, so that the
+// clients supporting ecc get the cheaper handshake. The cert-id annotation
+// takes both, eg. "cert-rsa,cert-ecc". The ecc certificate is not wrapped by
+// aliyungo, it is bound and unbound in place by the listener attribute apis,
+// see ListenerExtension. Both certificates must exist before the listener is
+// created.
+
+// ListenerCertificates the certificates of an https listener
+type ListenerCertificates struct {
+	ServerCertificateId string
+	// EccServerCertificateId the ecc certificate, empty if none is bound
+	EccServerCertificateId string
+}
+
+type DescribeListenerCertificatesResponse struct {
+	common.Response
+	ListenerCertificates
+}
+
+type SetListenerCertificatesArgs struct {
+	RegionId       common.Region
+	LoadBalancerId string
+	ListenerPort   int
+	// EccServerCertificateId always sent, empty unbinds the ecc certificate
+	EccServerCertificateId *string
+}
+
+var certIDFormat = &annotationFormat{
+	expected: "a certificate id, or the ids of the rsa and the ecc certificates separated by a comma",
+	valid: func(value string) bool {
+		ids := strings.Split(value, ",")
+		for _, id := range ids {
+			if strings.TrimSpace(id) == "" {
+				return false
+			}
+		}
+		return len(ids) == 1 || (len(ids) == 2 && strings.TrimSpace(ids[0]) != strings.TrimSpace(ids[1]))
+	},
+}
+
+// listenerCertIDs the rsa and the ecc certificate ids of the cert-id
+// annotation, the ecc one is empty if not given
+func listenerCertIDs(annotation string) (string, string) {
+	parts := strings.SplitN(annotation, ",", 2)
+	if len(parts) == 1 {
+		return strings.TrimSpace(parts[0]), ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// checkCertificates the certificates exist, before they are bound
+func (n *Listener) checkCertificates(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		certs, err := n.Client.DescribeServerCertificates(
+			ctx,
+			&DescribeServerCertificatesArgs{ServerCertificateId: id},
+		)
+		if err != nil {
+			return fmt.Errorf("describe server certificate %s: %s", id, err.Error())
+		}
+		found := false
+		for _, cert := range certs {
+			found = found || cert.ServerCertificateId == id
+		}
+		if !found {
+			return fmt.Errorf("server certificate %s of https listener %d not found", id, n.Port)
+		}
+	}
+	return nil
+}
+
+// EnsureEccCertificate bind the ecc certificate of the cert-id annotation to
+// the https listener, or unbind it once it is removed from the annotation.
+// The listener is updated in place.
+func (n *Listener) EnsureEccCertificate(ctx context.Context) error {
+	if n.TransforedProto != "https" {
+		return nil
+	}
+	_, request := ExtractAnnotationRequest(n.Service)
+	_, ecc := listenerCertIDs(request.CertID)
+	current, err := n.Client.DescribeHTTPSListenerCertificates(
+		ctx,
+		&DescribeListenerExtensionArgs{LoadBalancerId: n.LoadBalancerID, ListenerPort: int(n.Port)},
+	)
+	if err != nil {
+		return fmt.Errorf("describe certificates of https listener %d: %s", n.Port, err.Error())
+	}
+	if current.EccServerCertificateId == ecc {
+		return nil
+	}
+	if err := n.checkCertificates(ctx, ecc); err != nil {
+		return err
+	}
+	if ecc == "" {
+		utils.Logf(n.Service, "unbind ecc certificate %s from https listener %d", current.EccServerCertificateId, n.Port)
+	} else {
+		utils.Logf(n.Service, "bind ecc certificate %s to https listener %d", ecc, n.Port)
+	}
+	return n.Client.SetHTTPSListenerCertificates(
+		ctx,
+		&SetListenerCertificatesArgs{
+			LoadBalancerId:         n.LoadBalancerID,
+			ListenerPort:           int(n.Port),
+			EccServerCertificateId: &ecc,
+		},
+	)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"strings"
+	"testing"
+)
+
+func TestCertIDFormat(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"cert-rsa":                   true,
+		"cert-rsa,cert-ecc":          true,
+		"cert-rsa, cert-ecc":         true,
+		"cert-rsa,":                  false,
+		",cert-ecc":                  false,
+		"cert-rsa,cert-rsa":          false,
+		"cert-rsa,cert-ecc,cert-sm2": false,
+	} {
+		if certIDFormat.valid(value) != valid {
+			t.Errorf("%q: expect valid %t", value, valid)
+		}
+	}
+	if rsa, ecc := listenerCertIDs("cert-rsa, cert-ecc"); rsa != "cert-rsa" || ecc != "cert-ecc" {
+		t.Errorf("expect cert-rsa and cert-ecc, got %s and %s", rsa, ecc)
+	}
+}
+
+func TestListenerEccCertificate(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort: "https:443",
+					ServiceAnnotationLoadBalancerCertID:       "cert-rsa,cert-missing",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Name: "https", Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 30443},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	mock := f.SLBSDK().(*mockClientSLB)
+	mock.describeServerCertificates = func(args *DescribeServerCertificatesArgs) ([]ServerCertificateType, error) {
+		if args.ServerCertificateId == "cert-missing" {
+			return nil, nil
+		}
+		return (&mockClientSLB{}).DescribeServerCertificates(context.Background(), args)
+	}
+	created := 0
+	mock.createLoadBalancerHTTPSListener = func(args *slb.CreateLoadBalancerHTTPSListenerArgs) error {
+		created++
+		return (&mockClientSLB{}).CreateLoadBalancerHTTPSListener(context.Background(), args)
+	}
+	defer func() {
+		mock.describeServerCertificates = nil
+		mock.createLoadBalancerHTTPSListener = nil
+	}()
+
+	// ensure the service and expect the certificates of the listener
+	expect := func(rsa, ecc string, creates int) func(f *FrameWork) error {
+		return func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			certs, err := f.SLBSDK().DescribeHTTPSListenerCertificates(context.Background(),
+				&DescribeListenerExtensionArgs{LoadBalancerId: lb.LoadBalancerId, ListenerPort: 443})
+			if err != nil {
+				return fmt.Errorf("expect https listener 443: %s", err.Error())
+			}
+			if certs.ServerCertificateId != rsa || certs.EccServerCertificateId != ecc {
+				return fmt.Errorf("expect certificates %s and %s, got %+v", rsa, ecc, certs)
+			}
+			if created != creates {
+				return fmt.Errorf("expect the listener created %d times, got %d", creates, created)
+			}
+			return nil
+		}
+	}
+
+	f.RunCustomized(t, "Missing certificate rejected before the listener is created",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "cert-missing") {
+				return fmt.Errorf("expect the missing certificate rejected, got %v", err)
+			}
+			if created != 0 {
+				return fmt.Errorf("expect no listener created, got %d", created)
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerCertID] = "cert-rsa,cert-ecc"
+	f.RunCustomized(t, "Dual certificates bound at creation", expect("cert-rsa", "cert-ecc", 1))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerCertID] = "cert-rsa,cert-ecc-2"
+	f.RunCustomized(t, "Ecc certificate swapped in place", expect("cert-rsa", "cert-ecc-2", 1))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerCertID] = "cert-rsa-2,cert-ecc-2"
+	f.RunCustomized(t, "Rsa certificate swapped in place", expect("cert-rsa-2", "cert-ecc-2", 1))
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerCertID] = "cert-rsa-2"
+	f.RunCustomized(t, "Ecc certificate unbound in place", expect("cert-rsa-2", "", 1))
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"strings"
+)
+
+const (
+	CONNECTION_DRAIN_TIMEOUT_MIN = 10
+	CONNECTION_DRAIN_TIMEOUT_MAX = 900
+
+	ESTABLISHED_TIMEOUT_MIN = 10
+	ESTABLISHED_TIMEOUT_MAX = 900
+)
+
+// ListenerExtension tcp & udp listener attributes which are not wrapped by aliyungo.
+// Empty values are not sent to slb, except ProxyProtocolV2Enabled which is always sent.
+type ListenerExtension struct {
+	ConnectionDrain        string
+	ConnectionDrainTimeout int
+	// EstablishedTimeout tcp only
+	EstablishedTimeout int
+	// HealthCheckReq & HealthCheckExp udp only
+	HealthCheckReq string
+	HealthCheckExp string
+	// ProxyProtocolV2Enabled carry the current value when it is not changed.
+	ProxyProtocolV2Enabled bool
+	// HealthCheck current health check switch, describe only.
+	HealthCheck string
+}
+
+type DescribeListenerExtensionArgs struct {
+	RegionId       common.Region
+	LoadBalancerId string
+	ListenerPort   int
+}
+
+type DescribeListenerExtensionResponse struct {
+	common.Response
+	ListenerExtension
+}
+
+type SetListenerExtensionArgs struct {
+	RegionId       common.Region
+	LoadBalancerId string
+	ListenerPort   int
+	ListenerExtension
+	HealthCheckSwitch string
+}
+
+// listenerExtensionAction the api action of a tcp or udp listener, e.g. DescribeLoadBalancerTCPListenerAttribute
+func listenerExtensionAction(verb, proto string) string {
+	return fmt.Sprintf("%sLoadBalancer%sListenerAttribute", verb, strings.ToUpper(proto))
+}
+
+// ValidateListenerExtension check the listener extension annotations before any slb api is called.
+func ValidateListenerExtension(service *v1.Service) error {
+	def, _ := ExtractAnnotationRequest(service)
+	if def.ConnectionDrainTimeout != 0 {
+		if def.ConnectionDrain != string(slb.OnFlag) {
+			return fmt.Errorf("annotation %s requires %s to be on",
+				ServiceAnnotationLoadBalancerConnectionDrainTimeout, ServiceAnnotationLoadBalancerConnectionDrain)
+		}
+		if def.ConnectionDrainTimeout < CONNECTION_DRAIN_TIMEOUT_MIN ||
+			def.ConnectionDrainTimeout > CONNECTION_DRAIN_TIMEOUT_MAX {
+			return fmt.Errorf("connection drain timeout %d is out of range [%d, %d]",
+				def.ConnectionDrainTimeout, CONNECTION_DRAIN_TIMEOUT_MIN, CONNECTION_DRAIN_TIMEOUT_MAX)
+		}
+	}
+	if def.HealthCheckSwitch != "" &&
+		def.HealthCheckSwitch != string(slb.OnFlag) &&
+		def.HealthCheckSwitch != string(slb.OffFlag) {
+		return fmt.Errorf("annotation %s must be on or off, got %s",
+			ServiceAnnotationLoadBalancerHealthCheckSwitch, def.HealthCheckSwitch)
+	}
+	if def.ConnectionDrain != "" &&
+		def.ConnectionDrain != string(slb.OnFlag) &&
+		def.ConnectionDrain != string(slb.OffFlag) {
+		return fmt.Errorf("annotation %s must be on or off, got %s",
+			ServiceAnnotationLoadBalancerConnectionDrain, def.ConnectionDrain)
+	}
+	pp := def.ProxyProtocol
+	for _, port := range service.Spec.Ports {
+		if pp != "" && pp != string(slb.OnFlag) && pp != string(slb.OffFlag) {
+			return fmt.Errorf("annotation %s must be on or off, got %s of port %d",
+				ServiceAnnotationLoadBalancerProxyProtocol, pp, port.Port)
+		}
+		if pp == string(slb.OnFlag) {
+			proto, err := Protocol(serviceAnnotation(service, ServiceAnnotationLoadBalancerProtocolPort), port)
+			if err != nil {
+				return err
+			}
+			if proto != "tcp" && proto != "udp" {
+				return fmt.Errorf("proxy protocol is not supported by %s listener of port %d", proto, port.Port)
+			}
+		}
+		established := def.EstablishedTimeout
+		if established != 0 &&
+			(established < ESTABLISHED_TIMEOUT_MIN || established > ESTABLISHED_TIMEOUT_MAX) {
+			return fmt.Errorf("established timeout %d of port %d is out of range [%d, %d]",
+				established, port.Port, ESTABLISHED_TIMEOUT_MIN, ESTABLISHED_TIMEOUT_MAX)
+		}
+	}
+	return nil
+}
+
+// EnsureListenerExtension make the extension attributes of a tcp or udp
+// listener match the annotations. It is updated in place.
+func (n *Listener) EnsureListenerExtension(ctx context.Context) error {
+	if n.TransforedProto != "tcp" && n.TransforedProto != "udp" {
+		return nil
+	}
+	def, request := ExtractAnnotationRequest(n.Service)
+	if request.ConnectionDrain == "" &&
+		request.ConnectionDrainTimeout == 0 &&
+		request.EstablishedTimeout == 0 &&
+		request.HealthCheckReq == "" &&
+		request.HealthCheckExp == "" &&
+		request.ProxyProtocol == "" &&
+		request.HealthCheckSwitch == "" {
+		// nothing requested, save the describe call.
+		return nil
+	}
+	current, err := n.Client.DescribeListenerExtension(
+		ctx, n.TransforedProto,
+		&DescribeListenerExtensionArgs{LoadBalancerId: n.LoadBalancerID, ListenerPort: int(n.Port)},
+	)
+	if err != nil {
+		return fmt.Errorf("describe %s listener %d: %s", n.TransforedProto, n.Port, err.Error())
+	}
+
+	needUpdate := false
+	config := &SetListenerExtensionArgs{
+		LoadBalancerId: n.LoadBalancerID,
+		ListenerPort:   int(n.Port),
+		ListenerExtension: ListenerExtension{
+			ProxyProtocolV2Enabled: current.ProxyProtocolV2Enabled,
+		},
+	}
+	if request.ConnectionDrain != "" &&
+		def.ConnectionDrain != current.ConnectionDrain {
+		needUpdate = true
+		config.ConnectionDrain = def.ConnectionDrain
+	}
+	if def.ConnectionDrain == string(slb.OnFlag) &&
+		request.ConnectionDrainTimeout != 0 &&
+		def.ConnectionDrainTimeout != current.ConnectionDrainTimeout {
+		needUpdate = true
+		config.ConnectionDrain = def.ConnectionDrain
+		config.ConnectionDrainTimeout = def.ConnectionDrainTimeout
+	}
+	if n.TransforedProto == "tcp" {
+		// validated by ValidateListenerExtension
+		if def.EstablishedTimeout != 0 && def.EstablishedTimeout != current.EstablishedTimeout {
+			needUpdate = true
+			config.EstablishedTimeout = def.EstablishedTimeout
+		}
+	}
+	if n.TransforedProto == "udp" {
+		// removed strings are cleared by recreating the listener, see udp.Update
+		if request.HealthCheckReq != "" &&
+			def.HealthCheckReq != current.HealthCheckReq {
+			needUpdate = true
+			config.HealthCheckReq = def.HealthCheckReq
+		}
+		if request.HealthCheckExp != "" &&
+			def.HealthCheckExp != current.HealthCheckExp {
+			needUpdate = true
+			config.HealthCheckExp = def.HealthCheckExp
+		}
+	}
+
+	if request.HealthCheckSwitch != "" &&
+		def.HealthCheckSwitch != current.HealthCheck {
+		needUpdate = true
+		config.HealthCheckSwitch = def.HealthCheckSwitch
+	}
+	// validated by ValidateListenerExtension
+	pp := def.ProxyProtocol
+	if pp != "" && (pp == string(slb.OnFlag)) != current.ProxyProtocolV2Enabled {
+		needUpdate = true
+		config.ProxyProtocolV2Enabled = pp == string(slb.OnFlag)
+		if n.Action == ACTION_UPDATE {
+			n.recordProxyProtocolChanged(ctx, pp)
+		}
+	}
+
+	if !needUpdate {
+		return nil
+	}
+	utils.Logf(n.Service, "%s listener %d extension changed, update in place", n.TransforedProto, n.Port)
+	return n.Client.SetListenerExtension(ctx, n.TransforedProto, config)
+}
+
+// recordProxyProtocolChanged backends which do not expect the proxy protocol
+// header break once it is toggled, make it visible on the service.
+func (n *Listener) recordProxyProtocolChanged(ctx context.Context, pp string) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		return
+	}
+	record.Eventf(
+		n.Service,
+		v1.EventTypeNormal,
+		"ProxyProtocolChanged",
+		"Proxy protocol v2 of %s listener %d is turned %s, backends must be able to handle the proxy protocol header accordingly",
+		strings.ToUpper(n.TransforedProto), n.Port, pp,
+	)
+}
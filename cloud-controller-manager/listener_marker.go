@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"strings"
+)
+
+// Every listener created by the controller is marked in its description by the
+// named key of its port followed by the hash of the service uid, e.g.
+// k8s/443/my-svc/default/c123/1a2b3c4d. Only the listeners marked by the
+// service are updated or deleted. A listener at a port of the service not
+// marked by it is a conflict: it is reported by a ListenerConflict event and
+// left untouched, unless force-override-listeners or the managed-ports
+// annotation takes it over, and then it is marked by the service.
+//
+// Listeners of the former versions are migrated once: those described by the
+// named key alone belong to the service of the key, and on a loadbalancer
+// created by the controller the unmarked listeners at the ports of the service
+// are adopted as long as none of its listeners is marked.
+
+// listenerUIDHash hash of the service uid in the marker of its listeners
+func listenerUIDHash(svc *v1.Service) string {
+	return utils.Hash(string(svc.UID))[:NAME_HASH_LENGTH]
+}
+
+// listenerMarker description of the listener of the named key marked by the service
+func listenerMarker(key *NamedKey, svc *v1.Service) string {
+	return fmt.Sprintf("%s/%s", key.Key(), listenerUIDHash(svc))
+}
+
+// loadListenerMarker the named key and the uid hash of a listener description.
+// The hash is empty for a description of the named key alone.
+func loadListenerMarker(description string) (*NamedKey, string, error) {
+	metas := strings.Split(description, "/")
+	if len(metas) != 6 {
+		key, err := LoadNamedKey(description)
+		return key, "", err
+	}
+	if metas[5] == "" {
+		return nil, "", formatError{key: description}
+	}
+	key, err := LoadNamedKey(strings.Join(metas[:5], "/"))
+	if err != nil {
+		return nil, "", err
+	}
+	return key, metas[5], nil
+}
+
+// hasMarkedListener whether any of the remote listeners carries a marker
+func hasMarkedListener(remote Listeners) bool {
+	for _, l := range remote {
+		if l.UIDHash != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// isListenerOwned whether the remote listener at a port of the service can be
+// modified or deleted by it. migrating tells the loadbalancer has no marked
+// listener yet.
+func isListenerOwned(svc *v1.Service, remote *Listener, migrating bool) bool {
+	if isManagedByMyService(svc, remote) {
+		return true
+	}
+	if hasManagedPorts(svc) {
+		// invalid annotation is rejected by ValidateManagedPorts
+		ports, _ := parseManagedPorts(serviceAnnotation(svc, ServiceAnnotationLoadBalancerManagedPorts))
+		return ports[remote.Port]
+	}
+	if isOverrideListeners(svc) {
+		return true
+	}
+	return migrating && !isUserDefinedLoadBalancer(svc) && remote.UIDHash == ""
+}
+
+func recordListenerConflict(ctx context.Context, service *v1.Service, remote *Listener) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "port %d is taken by listener [%s] not managed by the service", remote.Port, remote.Name)
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"ListenerConflict",
+		"Port %d is taken by listener [%s] not managed by the service, set annotation %s "+
+			"to true or list the port in annotation %s to take it over",
+		remote.Port, remote.Name, ServiceAnnotationLoadBalancerOverrideListener, ServiceAnnotationLoadBalancerManagedPorts,
+	)
+}
+
+// marker description of the listener marked by its service
+func (n *Listener) marker() string {
+	return listenerMarker(n.NamedKey, n.Service)
+}
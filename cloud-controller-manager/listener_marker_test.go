@@ -0,0 +1,201 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"strings"
+	"testing"
+)
+
+func TestLoadListenerMarker(t *testing.T) {
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "my-service", Namespace: "default", UID: types.UID(serviceUIDNoneExist)}}
+	named := &NamedKey{Prefix: DEFAULT_PREFIX, CID: CLUSTER_ID, Namespace: "default", ServiceName: "my-service", Port: 443}
+
+	key, hash, err := loadListenerMarker(listenerMarker(named, svc))
+	if err != nil || key.Key() != named.Key() || hash != listenerUIDHash(svc) {
+		t.Fatalf("expect the marker loaded, got %v %s %v", key, hash, err)
+	}
+	key, hash, err = loadListenerMarker(named.Key())
+	if err != nil || key.Key() != named.Key() || hash != "" {
+		t.Fatalf("expect the legacy named key loaded without hash, got %v %s %v", key, hash, err)
+	}
+	for _, desc := range []string{"", "-", "team-b", named.Key() + "/", "k8s/http/my-service/default/c1/1a2b3c4d"} {
+		if _, _, err := loadListenerMarker(desc); err == nil {
+			t.Errorf("expect [%s] not a marker", desc)
+		}
+	}
+}
+
+func TestListenerMarker(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-service",
+				Namespace:   "default",
+				UID:         types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Name: "http", Port: 80, TargetPort: intstr.FromInt(80), Protocol: v1.ProtocolTCP, NodePort: 30080},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	var lbid string
+	// ensure the service and return the events recorded
+	ensure := func(f *FrameWork) (string, error) {
+		_, events, err := f.EnsureWithEvents()
+		if err != nil {
+			return "", err
+		}
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return "", fmt.Errorf("find loadbalancer: %v", err)
+		}
+		lbid = lb.LoadBalancerId
+		return events, nil
+	}
+	listener := func(f *FrameWork, port int) *slb.DescribeLoadBalancerTCPListenerAttributeResponse {
+		res, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(context.Background(), lbid, port)
+		if err != nil {
+			return nil
+		}
+		return res
+	}
+	named := func(f *FrameWork, port int32) *NamedKey {
+		return &NamedKey{Prefix: DEFAULT_PREFIX, CID: CLUSTER_ID, Namespace: f.SVC.Namespace, ServiceName: f.SVC.Name, Port: port}
+	}
+	expectMarked := func(f *FrameWork, port int, nodePort int) error {
+		l := listener(f, port)
+		if l == nil {
+			return fmt.Errorf("expect listener %d", port)
+		}
+		if marker := listenerMarker(named(f, int32(port)), f.SVC); l.Description != marker {
+			return fmt.Errorf("expect listener %d marked as [%s], got [%s]", port, marker, l.Description)
+		}
+		if l.BackendServerPort != nodePort {
+			return fmt.Errorf("expect listener %d forwarding to node port %d, got %d", port, nodePort, l.BackendServerPort)
+		}
+		return nil
+	}
+	// an unmarked listener of others on the loadbalancer
+	addUnmarked := func(f *FrameWork, port int, desc string) error {
+		return f.SLBSDK().CreateLoadBalancerTCPListener(
+			context.Background(),
+			&slb.CreateLoadBalancerTCPListenerArgs{
+				LoadBalancerId:    lbid,
+				ListenerPort:      port,
+				BackendServerPort: 31999,
+				Description:       desc,
+			},
+		)
+	}
+
+	f.RunCustomized(t, "Listeners created are marked",
+		func(f *FrameWork) error {
+			if _, err := ensure(f); err != nil {
+				return err
+			}
+			return expectMarked(f, 80, 30080)
+		},
+	)
+
+	f.RunCustomized(t, "Unmarked listeners of the ports are adopted on migration",
+		func(f *FrameWork) error {
+			// the loadbalancer of a former version
+			v, ok := LOADBALANCER.listeners.Load(listenerKey(lbid, 80))
+			if !ok {
+				return fmt.Errorf("expect listener 80")
+			}
+			v.(*slb.DescribeLoadBalancerTCPListenerAttributeResponse).Description = named(f, 80).Key()
+			if err := addUnmarked(f, 443, "-"); err != nil {
+				return err
+			}
+			f.SVC.Spec.Ports = append(f.SVC.Spec.Ports,
+				v1.ServicePort{Name: "https", Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 30443})
+			events, err := ensure(f)
+			if err != nil {
+				return err
+			}
+			if strings.Contains(events, "ListenerConflict") {
+				return fmt.Errorf("expect no conflict on migration, got %s", events)
+			}
+			if err := expectMarked(f, 80, 30080); err != nil {
+				return err
+			}
+			return expectMarked(f, 443, 30443)
+		},
+	)
+
+	f.RunCustomized(t, "Unmarked listener of a port is a conflict once migrated",
+		func(f *FrameWork) error {
+			if err := addUnmarked(f, 9000, "user-defined"); err != nil {
+				return err
+			}
+			f.SVC.Spec.Ports = append(f.SVC.Spec.Ports,
+				v1.ServicePort{Name: "metrics", Port: 9000, TargetPort: intstr.FromInt(9000), Protocol: v1.ProtocolTCP, NodePort: 30900})
+			events, err := ensure(f)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(events, "ListenerConflict") || !strings.Contains(events, "Port 9000") {
+				return fmt.Errorf("expect a ListenerConflict event of port 9000, got %s", events)
+			}
+			if l := listener(f, 9000); l == nil || l.Description != "user-defined" || l.BackendServerPort != 31999 {
+				return fmt.Errorf("expect listener 9000 untouched, got %v", l)
+			}
+			if err := expectMarked(f, 80, 30080); err != nil {
+				return err
+			}
+			return expectMarked(f, 443, 30443)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerOverrideListener] = "true"
+	f.RunCustomized(t, "Conflicting listener is adopted by force",
+		func(f *FrameWork) error {
+			events, err := ensure(f)
+			if err != nil {
+				return err
+			}
+			if strings.Contains(events, "ListenerConflict") {
+				return fmt.Errorf("expect no conflict with force-override-listeners, got %s", events)
+			}
+			return expectMarked(f, 9000, 30900)
+		},
+	)
+}
@@ -18,11 +18,17 @@ package alicloud
 
 import (
 	"context"
+	"fmt"
 	"github.com/denverdino/aliyungo/slb"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -198,3 +204,1476 @@ func TestStopLoadBalancerListener(t *testing.T) {
 		t.Fatalf("listener stop error.")
 	}
 }
+
+func domainExtensionCerts(t *testing.T, f *FrameWork, port int) []string {
+	ctx := context.Background()
+	_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+	if err != nil || lb == nil {
+		t.Fatalf("find loadbalancer: %v", err)
+	}
+	exts, err := f.SLBSDK().DescribeDomainExtensions(
+		ctx,
+		&DescribeDomainExtensionsArgs{LoadBalancerId: lb.LoadBalancerId, ListenerPort: port},
+	)
+	if err != nil {
+		t.Fatalf("describe domain extensions: %s", err.Error())
+	}
+	var certs []string
+	for _, ext := range exts {
+		certs = append(certs, ext.ServerCertificateId)
+	}
+	sort.Strings(certs)
+	return certs
+}
+
+func TestHTTPSAdditionalCertIDs(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort:      "https:443,https:8443",
+					ServiceAnnotationLoadBalancerCertID:            certID,
+					ServiceAnnotationLoadBalancerAdditionalCertIDs: "cert-a,cert-b",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 31443},
+					{Port: 8443, TargetPort: intstr.FromInt(8443), Protocol: v1.ProtocolTCP, NodePort: 31843},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(port int, certs ...string) {
+		got := domainExtensionCerts(t, f, port)
+		if !reflect.DeepEqual(got, certs) && !(len(got) == 0 && len(certs) == 0) {
+			t.Fatalf("port %d: expected domain extensions %v, got %v", port, certs, got)
+		}
+	}
+
+	f.RunDefault(t, "Add additional certs")
+	expect(443, "cert-a", "cert-b")
+	expect(8443, "cert-a", "cert-b")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAdditionalCertIDs] = "cert-b,cert-c"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = `{"8443":{"additional-cert-ids":"cert-d"}}`
+	f.RunDefault(t, "Replace additional certs with per port override")
+	expect(443, "cert-b", "cert-c")
+	expect(8443, "cert-d")
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerAdditionalCertIDs)
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerPortOverrides)
+	f.RunDefault(t, "Remove additional certs")
+	expect(443)
+	expect(8443)
+
+	ctx := context.Background()
+	_, lb, _ := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+	res, err := f.SLBSDK().DescribeLoadBalancerHTTPSListenerAttribute(ctx, lb.LoadBalancerId, 443)
+	if err != nil {
+		t.Fatalf("DescribeLoadBalancerHTTPSListenerAttribute error: %s", err.Error())
+	}
+	if res.ServerCertificateId != certID {
+		t.Fatalf("primary cert should be kept, got %s", res.ServerCertificateId)
+	}
+}
+
+func TestListenerAccessControl(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort: "https:443",
+					ServiceAnnotationLoadBalancerCertID:       certID,
+					ServiceAnnotationLoadBalancerAclStatus:    "on",
+					ServiceAnnotationLoadBalancerAclID:        ACL_ID,
+					ServiceAnnotationLoadBalancerAclType:      "white",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 31443},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	f.RunDefault(t, "Enable white list acl")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAclID] = ACL_ID2
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAclType] = "black"
+	f.RunDefault(t, "Switch to black list acl")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAclStatus] = "off"
+	f.RunDefault(t, "Disable acl")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAclStatus] = "on"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAclID] = "acl-not-exist"
+	f.RunCustomized(t, "Acl does not exist",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil {
+				return fmt.Errorf("expect error for acl which does not exist")
+			}
+			if !strings.Contains(err.Error(), "acl-not-exist") {
+				return fmt.Errorf("error should name the acl id: %s", err.Error())
+			}
+			return nil
+		},
+	)
+}
+
+func TestHTTPForwardPort(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort: "http:80,https:443",
+					ServiceAnnotationLoadBalancerCertID:       certID,
+					ServiceAnnotationLoadBalancerForwardPort:  "80:443",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 31443},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(forward slb.FlagType, port int) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		res, err := f.SLBSDK().DescribeLoadBalancerHTTPListenerAttribute(ctx, lb.LoadBalancerId, int(listenPort1))
+		if err != nil {
+			t.Fatalf("DescribeLoadBalancerHTTPListenerAttribute error: %s", err.Error())
+		}
+		if res.ListenerForward != forward || res.ForwardPort != port {
+			t.Fatalf("expected listener forward %s to %d, got %s to %d",
+				forward, port, res.ListenerForward, res.ForwardPort)
+		}
+		if forward == slb.OnFlag && res.VServerGroupId != "" {
+			t.Fatalf("forwarding listener should not have backends, got vgroup %s", res.VServerGroupId)
+		}
+	}
+
+	f.RunDefault(t, "Create http listener forward to https")
+	expect(slb.OnFlag, 443)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerForwardPort)
+	f.RunDefault(t, "Turn listener forward off")
+	expect(slb.OffFlag, 0)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerForwardPort] = "80:8443"
+	f.RunCustomized(t, "Forward to a port which is not https",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "8443") {
+				return fmt.Errorf("expect invalid forward port error, got %v", err)
+			}
+			return nil
+		},
+	)
+	expect(slb.OffFlag, 0)
+}
+
+func TestHTTPListenerTimeouts(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort:   "http:80,https:443",
+					ServiceAnnotationLoadBalancerCertID:         certID,
+					ServiceAnnotationLoadBalancerIdleTimeout:    "30",
+					ServiceAnnotationLoadBalancerRequestTimeout: "90",
+					ServiceAnnotationLoadBalancerPortOverrides:  `{"443":{"idle-timeout":"45"}}`,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 31443},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(httpIdle, httpsIdle, request int) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		h, err := f.SLBSDK().DescribeLoadBalancerHTTPListenerAttribute(ctx, lb.LoadBalancerId, int(listenPort1))
+		if err != nil {
+			t.Fatalf("DescribeLoadBalancerHTTPListenerAttribute error: %s", err.Error())
+		}
+		if h.IdleTimeout != httpIdle || h.RequestTimeout != request {
+			t.Fatalf("http listener: expected timeouts %d/%d, got %d/%d",
+				httpIdle, request, h.IdleTimeout, h.RequestTimeout)
+		}
+		hs, err := f.SLBSDK().DescribeLoadBalancerHTTPSListenerAttribute(ctx, lb.LoadBalancerId, 443)
+		if err != nil {
+			t.Fatalf("DescribeLoadBalancerHTTPSListenerAttribute error: %s", err.Error())
+		}
+		if hs.IdleTimeout != httpsIdle || hs.RequestTimeout != request {
+			t.Fatalf("https listener: expected timeouts %d/%d, got %d/%d",
+				httpsIdle, request, hs.IdleTimeout, hs.RequestTimeout)
+		}
+	}
+
+	f.RunDefault(t, "Create listeners with timeouts")
+	expect(30, 45, 90)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerIdleTimeout] = "20"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerRequestTimeout] = "120"
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerPortOverrides)
+	f.RunDefault(t, "Update timeouts")
+	expect(20, 20, 120)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = `{"443":{"idle-timeout":"61"}}`
+	f.RunCustomized(t, "Idle timeout out of range",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "out of range") {
+				return fmt.Errorf("expect out of range error, got %v", err)
+			}
+			return nil
+		},
+	)
+	expect(20, 20, 120)
+}
+
+func TestListenerConnectionDrain(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerConnectionDrain:        "on",
+					ServiceAnnotationLoadBalancerConnectionDrainTimeout: "30",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 53, TargetPort: intstr.FromInt(53), Protocol: v1.ProtocolUDP, NodePort: 31053},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(drain string, timeout int) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		for _, p := range []struct {
+			proto string
+			port  int
+		}{{"tcp", int(listenPort1)}, {"udp", 53}} {
+			ext, err := f.SLBSDK().DescribeListenerExtension(
+				ctx, p.proto,
+				&DescribeListenerExtensionArgs{LoadBalancerId: lb.LoadBalancerId, ListenerPort: p.port},
+			)
+			if err != nil {
+				t.Fatalf("describe %s listener extension: %s", p.proto, err.Error())
+			}
+			if ext.ConnectionDrain != drain || ext.ConnectionDrainTimeout != timeout {
+				t.Fatalf("%s listener: expected connection drain %s/%d, got %s/%d",
+					p.proto, drain, timeout, ext.ConnectionDrain, ext.ConnectionDrainTimeout)
+			}
+		}
+	}
+
+	f.RunDefault(t, "Create listeners with connection drain")
+	expect("on", 30)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerConnectionDrainTimeout] = "60"
+	f.RunDefault(t, "Update connection drain timeout only")
+	expect("on", 60)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerConnectionDrain] = "off"
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerConnectionDrainTimeout)
+	f.RunDefault(t, "Turn connection drain off")
+	expect("off", 0)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerConnectionDrainTimeout] = "60"
+	f.RunCustomized(t, "Connection drain timeout without drain",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), ServiceAnnotationLoadBalancerConnectionDrain) {
+				return fmt.Errorf("expect connection drain validation error, got %v", err)
+			}
+			return nil
+		},
+	)
+}
+
+func TestTCPListenerEstablishedTimeout(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerEstablishedTimeout: "300",
+					ServiceAnnotationLoadBalancerPortOverrides:      `{"3306":{"established-timeout":"600"}}`,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 3306, TargetPort: intstr.FromInt(3306), Protocol: v1.ProtocolTCP, NodePort: 31306},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(port int, timeout int) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		ext, err := f.SLBSDK().DescribeListenerExtension(
+			ctx, "tcp",
+			&DescribeListenerExtensionArgs{LoadBalancerId: lb.LoadBalancerId, ListenerPort: port},
+		)
+		if err != nil {
+			t.Fatalf("describe tcp listener extension: %s", err.Error())
+		}
+		if ext.EstablishedTimeout != timeout {
+			t.Fatalf("port %d: expected established timeout %d, got %d", port, timeout, ext.EstablishedTimeout)
+		}
+	}
+
+	f.RunDefault(t, "Create tcp listeners with established timeout")
+	expect(int(listenPort1), 300)
+	expect(3306, 600)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerEstablishedTimeout] = "120"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = `{"3306":{"established-timeout":"900"}}`
+	f.RunDefault(t, "Update established timeout")
+	expect(int(listenPort1), 120)
+	expect(3306, 900)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = `{"3306":{"established-timeout":"1800"}}`
+	f.RunCustomized(t, "Established timeout out of range",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "out of range") {
+				return fmt.Errorf("expect out of range error, got %v", err)
+			}
+			return nil
+		},
+	)
+	expect(3306, 900)
+}
+
+func TestTCPListenerHTTPHealthCheck(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerHealthCheckType:     "http",
+					ServiceAnnotationLoadBalancerHealthCheckURI:      "/healthz",
+					ServiceAnnotationLoadBalancerHealthCheckDomain:   "www.aliyun.com",
+					ServiceAnnotationLoadBalancerHealthCheckHTTPCode: "http_2xx,http_3xx",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(checkType, uri, domain, code string) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		resp, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(ctx, lb.LoadBalancerId, int(listenPort1))
+		if err != nil {
+			t.Fatalf("describe tcp listener: %s", err.Error())
+		}
+		if string(resp.HealthCheckType) != checkType ||
+			resp.HealthCheckURI != uri ||
+			resp.HealthCheckDomain != domain ||
+			string(resp.HealthCheckHttpCode) != code {
+			t.Fatalf("unexpected health check: type=%s, uri=%s, domain=%s, code=%s",
+				resp.HealthCheckType, resp.HealthCheckURI, resp.HealthCheckDomain, resp.HealthCheckHttpCode)
+		}
+	}
+
+	f.RunDefault(t, "Create tcp listener with http health check")
+	expect("http", "/healthz", "www.aliyun.com", "http_2xx,http_3xx")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckType] = "tcp"
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerHealthCheckURI)
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerHealthCheckDomain)
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerHealthCheckHTTPCode)
+	f.RunDefault(t, "Switch health check type to tcp")
+	expect("tcp", "", "", "")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckType] = "http"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckURI] = "/ready"
+	f.RunDefault(t, "Switch health check type back to http")
+	expect("http", "/ready", "", "")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckURI] = "ready"
+	f.RunCustomized(t, "Invalid health check uri",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "must begin with '/'") {
+				return fmt.Errorf("expect invalid uri error, got %v", err)
+			}
+			return nil
+		},
+	)
+	expect("http", "/ready", "", "")
+}
+
+func TestUDPListenerHealthCheck(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerHealthCheckConnectPort:        "8053",
+					ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold:   "4",
+					ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold: "5",
+					ServiceAnnotationLoadBalancerHealthCheckInterval:           "3",
+					ServiceAnnotationLoadBalancerHealthCheckReq:                "ping",
+					ServiceAnnotationLoadBalancerHealthCheckExp:                "pong",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: 53, TargetPort: intstr.FromInt(53), Protocol: v1.ProtocolUDP, NodePort: 31053},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(port, healthy, unhealthy, interval int, req, exp string) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		resp, err := f.SLBSDK().DescribeLoadBalancerUDPListenerAttribute(ctx, lb.LoadBalancerId, 53)
+		if err != nil {
+			t.Fatalf("describe udp listener: %s", err.Error())
+		}
+		if resp.HealthCheckConnectPort != port ||
+			resp.HealthyThreshold != healthy ||
+			resp.UnhealthyThreshold != unhealthy ||
+			resp.HealthCheckInterval != interval {
+			t.Fatalf("unexpected health check: port=%d, healthy=%d, unhealthy=%d, interval=%d",
+				resp.HealthCheckConnectPort, resp.HealthyThreshold, resp.UnhealthyThreshold, resp.HealthCheckInterval)
+		}
+		ext, err := f.SLBSDK().DescribeListenerExtension(
+			ctx, "udp",
+			&DescribeListenerExtensionArgs{LoadBalancerId: lb.LoadBalancerId, ListenerPort: 53},
+		)
+		if err != nil {
+			t.Fatalf("describe udp listener extension: %s", err.Error())
+		}
+		if ext.HealthCheckReq != req || ext.HealthCheckExp != exp {
+			t.Fatalf("unexpected health check string: req=%s, exp=%s", ext.HealthCheckReq, ext.HealthCheckExp)
+		}
+	}
+
+	f.RunDefault(t, "Create udp listener with health check")
+	expect(8053, 4, 5, 3, "ping", "pong")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold] = "6"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckInterval] = "10"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckExp] = "ok"
+	f.RunDefault(t, "Modify udp health check")
+	expect(8053, 6, 5, 10, "ping", "ok")
+
+	for _, key := range []string{
+		ServiceAnnotationLoadBalancerHealthCheckConnectPort,
+		ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold,
+		ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold,
+		ServiceAnnotationLoadBalancerHealthCheckInterval,
+		ServiceAnnotationLoadBalancerHealthCheckReq,
+		ServiceAnnotationLoadBalancerHealthCheckExp,
+	} {
+		delete(f.SVC.Annotations, key)
+	}
+	f.RunDefault(t, "Clear udp health check")
+	expect(MagicHealthCheckConnectPort, DEFAULT_HEALTHY_THRESHOLD,
+		DEFAULT_UNHEALTHY_THRESHOLD, DEFAULT_HEALTH_CHECK_INTERVAL, "", "")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold] = "11"
+	f.RunCustomized(t, "Unhealthy threshold out of range",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "out of range") {
+				return fmt.Errorf("expect out of range error, got %v", err)
+			}
+			return nil
+		},
+	)
+}
+
+func TestListenerProxyProtocol(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProxyProtocol: "on",
+					ServiceAnnotationLoadBalancerPortOverrides: `{"53":{"proxy-protocol":"off"}}`,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 53, TargetPort: intstr.FromInt(53), Protocol: v1.ProtocolUDP, NodePort: 31053},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(proto string, port int, enabled bool) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		ext, err := f.SLBSDK().DescribeListenerExtension(
+			ctx, proto,
+			&DescribeListenerExtensionArgs{LoadBalancerId: lb.LoadBalancerId, ListenerPort: port},
+		)
+		if err != nil {
+			t.Fatalf("describe %s listener extension: %s", proto, err.Error())
+		}
+		if ext.ProxyProtocolV2Enabled != enabled {
+			t.Fatalf("%s listener %d: expected proxy protocol %t, got %t", proto, port, enabled, ext.ProxyProtocolV2Enabled)
+		}
+	}
+
+	f.RunDefault(t, "Create listeners with proxy protocol")
+	expect("tcp", int(listenPort1), true)
+	expect("udp", 53, false)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerProxyProtocol] = "off"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = `{"53":{"proxy-protocol":"on"}}`
+	f.RunCustomized(t, "Toggle proxy protocol",
+		func(f *FrameWork) error {
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+			if err != nil {
+				return err
+			}
+			changed := 0
+			for len(recorder.Events) > 0 {
+				if strings.Contains(<-recorder.Events, "ProxyProtocolChanged") {
+					changed++
+				}
+			}
+			if changed != 2 {
+				return fmt.Errorf("expect 2 ProxyProtocolChanged events, got %d", changed)
+			}
+			return nil
+		},
+	)
+	expect("tcp", int(listenPort1), false)
+	expect("udp", 53, true)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerProtocolPort] = fmt.Sprintf("http:%d", listenPort1)
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = fmt.Sprintf(`{"%d":{"proxy-protocol":"on"}}`, listenPort1)
+	f.RunCustomized(t, "Proxy protocol on http listener",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "not supported") {
+				return fmt.Errorf("expect proxy protocol not supported error, got %v", err)
+			}
+			return nil
+		},
+	)
+}
+
+func TestTCPListenerHealthCheckSwitch(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerHealthCheckSwitch:           "off",
+					ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold: "4",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(flag slb.FlagType, healthy, unhealthy, interval int) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		resp, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(ctx, lb.LoadBalancerId, int(listenPort1))
+		if err != nil {
+			t.Fatalf("describe tcp listener: %s", err.Error())
+		}
+		if resp.HealthCheck != flag ||
+			resp.HealthyThreshold != healthy ||
+			resp.UnhealthyThreshold != unhealthy ||
+			resp.HealthCheckInterval != interval {
+			t.Fatalf("unexpected health check: switch=%s, healthy=%d, unhealthy=%d, interval=%d",
+				resp.HealthCheck, resp.HealthyThreshold, resp.UnhealthyThreshold, resp.HealthCheckInterval)
+		}
+	}
+
+	f.RunDefault(t, "Create tcp listener with health check off")
+	expect(slb.OffFlag, 4, 0, 0)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold] = "6"
+	f.RunDefault(t, "Probe parameters are not synced while health check is off")
+	expect(slb.OffFlag, 4, 0, 0)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckSwitch] = "on"
+	f.RunDefault(t, "Switch health check back on")
+	expect(slb.OnFlag, 6, DEFAULT_UNHEALTHY_THRESHOLD, DEFAULT_HEALTH_CHECK_INTERVAL)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckSwitch] = "false"
+	f.RunCustomized(t, "Invalid health check switch",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "must be on or off") {
+				return fmt.Errorf("expect invalid switch error, got %v", err)
+			}
+			return nil
+		},
+	)
+}
+
+func TestListenerPortOverrides(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerScheduler:       "wrr",
+					ServiceAnnotationLoadBalancerHealthCheckType: "http",
+					ServiceAnnotationLoadBalancerHealthCheckURI:  "/healthz",
+					ServiceAnnotationLoadBalancerPortOverrides: fmt.Sprintf(
+						`{"9000":{"scheduler":"wlc","health-check-uri":"/metrics"},"443":{"protocol":"https","cert-id":%q}}`,
+						certID,
+					),
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 9000, TargetPort: intstr.FromInt(9000), Protocol: v1.ProtocolTCP, NodePort: 31900},
+					{Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 31443},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(port int, scheduler, uri string) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		resp, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(ctx, lb.LoadBalancerId, port)
+		if err != nil {
+			t.Fatalf("describe tcp listener: %s", err.Error())
+		}
+		if string(resp.Scheduler) != scheduler || resp.HealthCheckURI != uri {
+			t.Fatalf("port %d: unexpected scheduler=%s, uri=%s", port, resp.Scheduler, resp.HealthCheckURI)
+		}
+	}
+
+	f.RunDefault(t, "Create listeners with port overrides")
+	expect(int(listenPort1), "wrr", "/healthz")
+	expect(9000, "wlc", "/metrics")
+
+	_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+	if err != nil || lb == nil {
+		t.Fatalf("find loadbalancer: %v", err)
+	}
+	https, err := f.SLBSDK().DescribeLoadBalancerHTTPSListenerAttribute(context.Background(), lb.LoadBalancerId, 443)
+	if err != nil || https == nil {
+		t.Fatalf("port 443 is expected to be https listener: %v", err)
+	}
+	if https.ServerCertificateId != certID {
+		t.Fatalf("port 443: expected cert %s, got %s", certID, https.ServerCertificateId)
+	}
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = `{"9000":{"scheduler":"rr"}}`
+	f.RunDefault(t, "Update port overrides")
+	expect(int(listenPort1), "wrr", "/healthz")
+	expect(9000, "rr", "/healthz")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = `{"9000":{"spec":"slb.s2.small"}}`
+	f.RunCustomized(t, "Load balancer annotation can not be overridden per port",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "can not be overridden per port") {
+				return fmt.Errorf("expect port overrides error, got %v", err)
+			}
+			return nil
+		},
+	)
+}
+
+func TestHTTPListenerStickySession(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort:     fmt.Sprintf("http:%d", listenPort1),
+					ServiceAnnotationLoadBalancerSessionStick:     "on",
+					ServiceAnnotationLoadBalancerSessionStickType: "insert",
+					ServiceAnnotationLoadBalancerCookieTimeout:    "1800",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(sticky slb.FlagType, stype slb.StickySessionType, timeout int, cookie string) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		resp, err := f.SLBSDK().DescribeLoadBalancerHTTPListenerAttribute(ctx, lb.LoadBalancerId, int(listenPort1))
+		if err != nil {
+			t.Fatalf("describe http listener: %s", err.Error())
+		}
+		if resp.StickySession != sticky ||
+			resp.StickySessionType != stype ||
+			resp.CookieTimeout != timeout ||
+			resp.Cookie != cookie {
+			t.Fatalf("unexpected sticky session: sticky=%s, type=%s, timeout=%d, cookie=%s",
+				resp.StickySession, resp.StickySessionType, resp.CookieTimeout, resp.Cookie)
+		}
+	}
+	// the service controller records the error as a SyncLoadBalancerFailed event.
+	expectError := func(describe, message string) {
+		f.RunCustomized(t, describe,
+			func(f *FrameWork) error {
+				_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+				if err == nil || !strings.Contains(err.Error(), message) {
+					return fmt.Errorf("expect error %q, got %v", message, err)
+				}
+				return nil
+			},
+		)
+	}
+
+	f.RunDefault(t, "Create http listener with insert sticky session")
+	expect(slb.OnFlag, slb.InsertStickySessionType, 1800, "")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerSessionStickType] = "server"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerCookie] = "SESSIONID"
+	f.RunDefault(t, "Switch to server sticky session")
+	expect(slb.OnFlag, slb.ServerStickySessionType, 0, "SESSIONID")
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerCookie)
+	expectError("Server sticky session without cookie", ServiceAnnotationLoadBalancerCookie)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerSessionStickType] = "insert"
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerCookieTimeout)
+	expectError("Insert sticky session without cookie timeout", ServiceAnnotationLoadBalancerCookieTimeout)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerSessionStickType] = "cookie"
+	expectError("Unknown sticky session type", ServiceAnnotationLoadBalancerSessionStickType)
+	expect(slb.OnFlag, slb.ServerStickySessionType, 0, "SESSIONID")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerSessionStick] = "off"
+	f.RunDefault(t, "Turn off sticky session")
+	expect(slb.OffFlag, "", 0, "")
+}
+
+func TestListenerPersistenceTimeout(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	timeout := int32(600)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-service",
+				Namespace:   "default",
+				UID:         types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 53, TargetPort: intstr.FromInt(53), Protocol: v1.ProtocolUDP, NodePort: 31053},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityClientIP,
+				SessionAffinityConfig: &v1.SessionAffinityConfig{
+					ClientIP: &v1.ClientIPConfig{TimeoutSeconds: &timeout},
+				},
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(persistence int) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		tcp, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(ctx, lb.LoadBalancerId, int(listenPort1))
+		if err != nil {
+			t.Fatalf("describe tcp listener: %s", err.Error())
+		}
+		udp, err := f.SLBSDK().DescribeLoadBalancerUDPListenerAttribute(ctx, lb.LoadBalancerId, 53)
+		if err != nil {
+			t.Fatalf("describe udp listener: %s", err.Error())
+		}
+		if intValue(tcp.PersistenceTimeout) != persistence ||
+			intValue(udp.PersistenceTimeout) != persistence {
+			t.Fatalf("expected persistence timeout %d, got tcp=%d udp=%d", persistence,
+				intValue(tcp.PersistenceTimeout), intValue(udp.PersistenceTimeout))
+		}
+	}
+
+	f.RunDefault(t, "Create listeners with ClientIP session affinity")
+	expect(600)
+
+	f.SVC.Spec.SessionAffinity = v1.ServiceAffinityNone
+	f.SVC.Spec.SessionAffinityConfig = nil
+	f.RunDefault(t, "Turn off session affinity")
+	expect(0)
+
+	f.SVC.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+	f.RunCustomized(t, "Clamp default ClientIP timeout",
+		func(f *FrameWork) error {
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+			if err != nil {
+				return err
+			}
+			clamped := 0
+			for len(recorder.Events) > 0 {
+				if strings.Contains(<-recorder.Events, "PersistenceTimeoutClamped") {
+					clamped++
+				}
+			}
+			if clamped != 2 {
+				return fmt.Errorf("expect 2 PersistenceTimeoutClamped events, got %d", clamped)
+			}
+			return ExpectExistAndEqual(f)
+		},
+	)
+	expect(PERSISTENCE_TIMEOUT_MAX)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPersistenceTimeout] = "120"
+	f.RunDefault(t, "Annotation takes precedence over session affinity")
+	expect(120)
+}
+
+func TestPersistenceTimeout(t *testing.T) {
+	timeout := int32(30)
+	for _, c := range []struct {
+		affinity   v1.ServiceAffinity
+		config     *v1.SessionAffinityConfig
+		annotation string
+		want       int
+		clamped    bool
+	}{
+		{affinity: v1.ServiceAffinityNone, want: 0},
+		{affinity: v1.ServiceAffinityClientIP, want: PERSISTENCE_TIMEOUT_MAX, clamped: true},
+		{
+			affinity: v1.ServiceAffinityClientIP,
+			config:   &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: &timeout}},
+			want:     30,
+		},
+		{affinity: v1.ServiceAffinityNone, annotation: "100", want: 100},
+		{affinity: v1.ServiceAffinityClientIP, annotation: "0", want: 0},
+		{affinity: v1.ServiceAffinityNone, annotation: "7400", want: PERSISTENCE_TIMEOUT_MAX, clamped: true},
+	} {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}},
+			Spec:       v1.ServiceSpec{SessionAffinity: c.affinity, SessionAffinityConfig: c.config},
+		}
+		if c.annotation != "" {
+			svc.Annotations[ServiceAnnotationLoadBalancerPersistenceTimeout] = c.annotation
+		}
+		got, clamped := PersistenceTimeout(svc)
+		if got != c.want || clamped != c.clamped {
+			t.Errorf("affinity=%s annotation=%q: expected %d/%t, got %d/%t",
+				c.affinity, c.annotation, c.want, c.clamped, got, clamped)
+		}
+	}
+}
+
+func TestListenerScheduler(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerScheduler:     "wlc",
+					ServiceAnnotationLoadBalancerProtocolPort:  fmt.Sprintf("http:%d", listenPort1),
+					ServiceAnnotationLoadBalancerPortOverrides: `{"53":{"scheduler":"tch"}}`,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 53, TargetPort: intstr.FromInt(53), Protocol: v1.ProtocolUDP, NodePort: 31053},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(http, udp slb.SchedulerType) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		h, err := f.SLBSDK().DescribeLoadBalancerHTTPListenerAttribute(ctx, lb.LoadBalancerId, int(listenPort1))
+		if err != nil {
+			t.Fatalf("describe http listener: %s", err.Error())
+		}
+		u, err := f.SLBSDK().DescribeLoadBalancerUDPListenerAttribute(ctx, lb.LoadBalancerId, 53)
+		if err != nil {
+			t.Fatalf("describe udp listener: %s", err.Error())
+		}
+		if h.Scheduler != http || u.Scheduler != udp {
+			t.Fatalf("expected scheduler http=%s udp=%s, got http=%s udp=%s", http, udp, h.Scheduler, u.Scheduler)
+		}
+	}
+
+	f.RunDefault(t, "Create listeners with different schedulers")
+	expect("wlc", "tch")
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerScheduler)
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = `{"53":{"scheduler":"sch"}}`
+	f.RunDefault(t, "Update scheduler of the udp port")
+	expect(DEFAULT_SCHEDULER, "sch")
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerScheduler] = "tch"
+	f.RunCustomized(t, "Consistent hashing is rejected for http listener",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "not supported by http listener") {
+				return fmt.Errorf("expect scheduler validation error, got %v", err)
+			}
+			return nil
+		},
+	)
+	expect(DEFAULT_SCHEDULER, "sch")
+}
+
+func TestHTTPListenerHeaders(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort: fmt.Sprintf("http:%d,https:443", listenPort1),
+					ServiceAnnotationLoadBalancerCertID:       certID,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 31443},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	// in the order of XForwardedFor, XForwardedFor_proto, XForwardedFor_SLBID, XForwardedFor_SLBIP, Gzip
+	expect := func(want ...slb.FlagType) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		h, err := f.SLBSDK().DescribeLoadBalancerHTTPListenerAttribute(ctx, lb.LoadBalancerId, int(listenPort1))
+		if err != nil {
+			t.Fatalf("describe http listener: %s", err.Error())
+		}
+		s, err := f.SLBSDK().DescribeLoadBalancerHTTPSListenerAttribute(ctx, lb.LoadBalancerId, 443)
+		if err != nil {
+			t.Fatalf("describe https listener: %s", err.Error())
+		}
+		for i, l := range []*slb.HTTPListenerType{&h.HTTPListenerType, &s.HTTPListenerType} {
+			var got []slb.FlagType
+			for _, flag := range httpHeaders(l) {
+				got = append(got, *flag)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("listener %d: expected headers %v, got %v", i, want, got)
+			}
+		}
+	}
+
+	f.RunDefault(t, "Create listeners with slb default headers")
+	expect(slb.OnFlag, slb.OffFlag, slb.OffFlag, slb.OffFlag, slb.OnFlag)
+
+	want := []slb.FlagType{slb.OnFlag, slb.OffFlag, slb.OffFlag, slb.OffFlag, slb.OnFlag}
+	for i, anno := range []string{
+		ServiceAnnotationLoadBalancerXForwardedFor,
+		ServiceAnnotationLoadBalancerXForwardedForProto,
+		ServiceAnnotationLoadBalancerXForwardedForSLBID,
+		ServiceAnnotationLoadBalancerXForwardedForSLBIP,
+		ServiceAnnotationLoadBalancerGzip,
+	} {
+		if want[i] == slb.OnFlag {
+			want[i] = slb.OffFlag
+		} else {
+			want[i] = slb.OnFlag
+		}
+		f.SVC.Annotations[anno] = string(want[i])
+		f.RunDefault(t, fmt.Sprintf("Flip %s", anno))
+		expect(want...)
+	}
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerGzip] = "enabled"
+	f.RunCustomized(t, "Invalid gzip switch",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "must be on or off") {
+				return fmt.Errorf("expect gzip validation error, got %v", err)
+			}
+			return nil
+		},
+	)
+	expect(want...)
+}
+
+func TestListenerLocalHealthCheck(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort: "http:8080",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 8080, TargetPort: intstr.FromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 31080},
+				},
+				Type:                  v1.ServiceTypeLoadBalancer,
+				SessionAffinity:       v1.ServiceAffinityNone,
+				ExternalTrafficPolicy: v1.ServiceExternalTrafficPolicyTypeLocal,
+				HealthCheckNodePort:   32000,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(port int, tcpType slb.HealthCheckType, check slb.FlagType, uri string) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		tcp, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(ctx, lb.LoadBalancerId, int(listenPort1))
+		if err != nil {
+			t.Fatalf("describe tcp listener: %s", err.Error())
+		}
+		http, err := f.SLBSDK().DescribeLoadBalancerHTTPListenerAttribute(ctx, lb.LoadBalancerId, 8080)
+		if err != nil {
+			t.Fatalf("describe http listener: %s", err.Error())
+		}
+		if tcp.HealthCheckConnectPort != port || tcp.HealthCheckType != tcpType || tcp.HealthCheckURI != uri {
+			t.Fatalf("tcp listener: expected health check %d %s %q, got %d %s %q",
+				port, tcpType, uri, tcp.HealthCheckConnectPort, tcp.HealthCheckType, tcp.HealthCheckURI)
+		}
+		if http.HealthCheckConnectPort != port || http.HealthCheck != check || http.HealthCheckURI != uri {
+			t.Fatalf("http listener: expected health check %d %s %q, got %d %s %q",
+				port, check, uri, http.HealthCheckConnectPort, http.HealthCheck, http.HealthCheckURI)
+		}
+	}
+
+	f.RunDefault(t, "Create listeners of Local traffic policy")
+	expect(32000, slb.HTTPHealthCheckType, slb.OnFlag, LOCAL_HEALTH_CHECK_URI)
+
+	f.SVC.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
+	f.SVC.Spec.HealthCheckNodePort = 0
+	f.RunDefault(t, "Switch to Cluster traffic policy")
+	expect(MagicHealthCheckConnectPort, slb.TCPHealthCheckType, slb.OffFlag, "")
+
+	f.SVC.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	f.SVC.Spec.HealthCheckNodePort = 32001
+	f.RunDefault(t, "Switch back to Local traffic policy")
+	expect(32001, slb.HTTPHealthCheckType, slb.OnFlag, LOCAL_HEALTH_CHECK_URI)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckConnectPort] = "9000"
+	f.RunDefault(t, "Connect port annotation takes precedence")
+	expect(9000, slb.HTTPHealthCheckType, slb.OnFlag, LOCAL_HEALTH_CHECK_URI)
+}
+
+func TestListenerHealthCheckConnectPort(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort:           "http:8080",
+					ServiceAnnotationLoadBalancerHealthCheckConnectPort: "9000",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 53, TargetPort: intstr.FromInt(53), Protocol: v1.ProtocolUDP, NodePort: 31053},
+					{Port: 8080, TargetPort: intstr.FromInt(8080), Protocol: v1.ProtocolTCP, NodePort: 31080},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expect := func(tcp, udp, http int) {
+		ctx := context.Background()
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+		if err != nil || lb == nil {
+			t.Fatalf("find loadbalancer: %v", err)
+		}
+		tl, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(ctx, lb.LoadBalancerId, int(listenPort1))
+		if err != nil {
+			t.Fatalf("describe tcp listener: %s", err.Error())
+		}
+		ul, err := f.SLBSDK().DescribeLoadBalancerUDPListenerAttribute(ctx, lb.LoadBalancerId, 53)
+		if err != nil {
+			t.Fatalf("describe udp listener: %s", err.Error())
+		}
+		hl, err := f.SLBSDK().DescribeLoadBalancerHTTPListenerAttribute(ctx, lb.LoadBalancerId, 8080)
+		if err != nil {
+			t.Fatalf("describe http listener: %s", err.Error())
+		}
+		if tl.HealthCheckConnectPort != tcp || ul.HealthCheckConnectPort != udp || hl.HealthCheckConnectPort != http {
+			t.Fatalf("expected connect port tcp=%d udp=%d http=%d, got tcp=%d udp=%d http=%d", tcp, udp, http,
+				tl.HealthCheckConnectPort, ul.HealthCheckConnectPort, hl.HealthCheckConnectPort)
+		}
+	}
+
+	f.RunDefault(t, "Create listeners with health check connect port")
+	expect(9000, 9000, 9000)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckConnectPort] = "9001"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = `{"53":{"health-check-connect-port":"0"}}`
+	f.RunDefault(t, "Change connect port and probe the backend port of udp")
+	expect(9001, MagicHealthCheckConnectPort, 9001)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerHealthCheckConnectPort)
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerPortOverrides)
+	f.RunDefault(t, "Remove connect port annotation")
+	expect(MagicHealthCheckConnectPort, MagicHealthCheckConnectPort, MagicHealthCheckConnectPort)
+
+	for _, port := range []string{"-1", "65536", "http"} {
+		f.SVC.Annotations[ServiceAnnotationLoadBalancerHealthCheckConnectPort] = port
+		f.RunCustomized(t, fmt.Sprintf("Invalid connect port %s", port),
+			func(f *FrameWork) error {
+				_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+				if err == nil || !strings.Contains(err.Error(), ServiceAnnotationLoadBalancerHealthCheckConnectPort) {
+					return fmt.Errorf("expect connect port validation error, got %v", err)
+				}
+				return nil
+			},
+		)
+	}
+	expect(MagicHealthCheckConnectPort, MagicHealthCheckConnectPort, MagicHealthCheckConnectPort)
+}
@@ -24,6 +24,7 @@ import (
 	"k8s.io/api/core/v1"
 	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
 	"k8s.io/klog"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -57,12 +58,12 @@ var DEFAULT_LISTENER_BANDWIDTH = -1
 	VServer Group Name Format:  k8s/NodePort/ServiceName/Namespace/ClusterID
 */
 
-// Protocol for protocol transform
+// Protocol for protocol transform. The ports not covered by the annotation
+// are of their appProtocol, see appProtocol, or of their port protocol.
 func Protocol(annotation string, port v1.ServicePort) (string, error) {
 
 	if annotation == "" {
-		klog.Infof("transfor protocol, empty annotation %d/%s", port.Port, port.Protocol)
-		return strings.ToLower(string(port.Protocol)), nil
+		return portProtocol(port), nil
 	}
 	for _, v := range strings.Split(annotation, ",") {
 		pp := strings.Split(v, ":")
@@ -80,11 +81,10 @@ func Protocol(annotation string, port v1.ServicePort) (string, error) {
 		}
 
 		if pp[1] == fmt.Sprintf("%d", port.Port) {
-			klog.Infof("transfor protocol from %s to %s", string(port.Protocol), pp[0])
 			return pp[0], nil
 		}
 	}
-	return strings.ToLower(string(port.Protocol)), nil
+	return portProtocol(port), nil
 }
 
 // IListener listener interface
@@ -188,6 +188,9 @@ type Listener struct {
 	// NodePort Backend server port
 	NodePort int32
 
+	// UIDHash hash of the service uid marking a remote listener, see listenerMarker
+	UIDHash string
+
 	// ServiceName reference from k8s service
 	Service *v1.Service
 
@@ -229,17 +232,36 @@ func (n *Listener) Instance() IListener {
 }
 
 // Apply apply listener operate . add/update/delete etc.
+// The listener added is not started, see applyListeners.
 func (n *Listener) Apply(ctx context.Context) error {
-	klog.Infof("apply %s listener for %v with trans protocol %s", n.Action, n.NamedKey, n.TransforedProto)
+	// the protocol of the port is logged along with the one it is transformed to
+	utils.FromContext(ctx).V(4).Info("Apply listener", utils.LogKeySLBID, n.LoadBalancerID,
+		utils.LogKeyListenerPort, n.Port, "action", n.Action, "protocol", n.Proto, "listenerProtocol", n.TransforedProto)
 	switch n.Action {
 	case ACTION_UPDATE:
-		return n.Instance().Update(ctx)
+		err := n.Instance().Update(ctx)
+		if err != nil {
+			return err
+		}
+		if err := n.EnsureListenerExtension(ctx); err != nil {
+			return err
+		}
+		if err := n.EnsureEccCertificate(ctx); err != nil {
+			return err
+		}
+		return n.EnsureDomainExtensions(ctx)
 	case ACTION_ADD:
 		err := n.Instance().Add(ctx)
 		if err != nil {
 			return err
 		}
-		return n.Start(ctx)
+		if err := n.EnsureListenerExtension(ctx); err != nil {
+			return err
+		}
+		if err := n.EnsureEccCertificate(ctx); err != nil {
+			return err
+		}
+		return n.EnsureDomainExtensions(ctx)
 	case ACTION_DELETE:
 		return n.Instance().Remove(ctx)
 	}
@@ -262,10 +284,16 @@ func (n *Listener) Describe(ctx context.Context) error {
 // Remove remove Listener
 func (n *Listener) Remove(ctx context.Context) error {
 	err := n.Client.StopLoadBalancerListener(ctx, n.LoadBalancerID, int(n.Port))
-	if err != nil {
-		return err
+	if err == nil {
+		err = n.Client.DeleteLoadBalancerListener(ctx, n.LoadBalancerID, int(n.Port))
+	}
+	if utils.IsCloudNotFound(err) {
+		// removed already, eg. out of band or by a former partial delete
+		utils.FromContext(ctx).Info("Listener is gone already", utils.LogKeySLBID, n.LoadBalancerID,
+			utils.LogKeyListenerPort, n.Port, "err", err.Error())
+		return nil
 	}
-	return n.Client.DeleteLoadBalancerListener(ctx, n.LoadBalancerID, int(n.Port))
+	return err
 }
 
 func (n *Listener) findVgroup(key string) string {
@@ -289,7 +317,7 @@ type Listeners []*Listener
 // 1. First, build listeners config from aliyun API output.
 // 2. Second, build listeners from k8s service object.
 // 3. Third, Merge the up two listeners to decide whether add/update/remove is needed.
-// 4. Do update.  Clean unused vserver group.
+// 4. Do update. The unused ones are left to cleanupOrphans.
 func EnsureListeners(
 	ctx context.Context,
 	slbins *LoadBalancerClient,
@@ -298,48 +326,47 @@ func EnsureListeners(
 	vgs *vgroups,
 ) error {
 
-	local, err := BuildListenersFromService(service, lb, slbins.c, vgs)
+	// svc carries the acl annotations of spec.loadBalancerSourceRanges, the
+	// managed acls detached by the listeners are collected by ctx
+	ctx = withDetachedAcls(ctx)
+	svc, err := EnsureSourceRangesAcl(ctx, slbins.c, service)
+	if err != nil {
+		return err
+	}
+	if err := CheckAccessControlList(ctx, slbins.c, svc); err != nil {
+		return err
+	}
+
+	local, err := BuildListenersFromService(svc, lb, slbins.c, vgs)
 	if err != nil {
 		return fmt.Errorf("build listener from service: %s", err.Error())
 	}
+	if err := validateForwardPorts(svc, local); err != nil {
+		return err
+	}
+	if err := ValidateListenerBandwidth(svc, lb); err != nil {
+		recordInvalidAnnotation(ctx, service, err)
+		return err
+	}
 
 	// Merge listeners generate an listener list to be updated/deleted/added.
-	updates, err := BuildActionsForListeners(service, local, BuildListenersFromAPI(service, lb, slbins.c, vgs))
+	updates, err := BuildActionsForListeners(ctx, svc, local, BuildListenersFromAPI(svc, lb, slbins.c, vgs))
 	if err != nil {
 		return fmt.Errorf("merge listener: %s", err.Error())
 	}
 	utils.Logf(service, "ensure listener: %d updates for %s", len(updates), lb.LoadBalancerId)
 
-	// make https come first.
-	// ensure https listeners to be created first for http forward
-	sort.SliceStable(
-		updates,
-		func(i, j int) bool {
-			// 1. https comes first.
-			// 2. DELETE action comes before https
-			if isDeleteAction(updates[i].Action) {
-				return true
-			}
-			if isDeleteAction(updates[j].Action) {
-				return false
-			}
-			if strings.ToUpper(
-				updates[i].TransforedProto,
-			) == "HTTPS" {
-				return true
-			}
-			return false
-		},
-	)
-	// do update/add/delete
-	for _, up := range updates {
-		err := up.Apply(ctx)
-		if err != nil {
-			return fmt.Errorf("ensure listener: %s", err.Error())
-		}
+	// do update/add/delete, https listeners are created first for http forward
+	if err := applyListeners(ctx, service, updates); err != nil {
+		return err
 	}
 
-	return CleanUPVGroupMerged(ctx, slbins, service, lb, vgs)
+	if err := CleanupSourceRangesAcl(ctx, slbins.c, service, false); err != nil {
+		utils.Logf(service, "cleanup source ranges acl error: %s", err.Error())
+	}
+	// the listeners and the vserver groups of the removed ports are cleaned
+	// up at the end of the sync, see cleanupOrphans
+	return nil
 }
 
 func isDeleteAction(action string) bool { return action == ACTION_DELETE }
@@ -377,7 +404,7 @@ func EnsureListenersDeleted(
 			if loc.Port == rem.Port {
 				err := loc.Remove(ctx)
 				if err != nil {
-					return fmt.Errorf("ensure listener: %s", err.Error())
+					return fmt.Errorf("ensure listener: %w", err)
 				}
 			}
 		}
@@ -389,7 +416,8 @@ func EnsureListenersDeleted(
 func isManagedByMyService(svc *v1.Service, remote *Listener) bool {
 
 	return remote.NamedKey != nil &&
-		remote.NamedKey.ServiceURI() == URIfromService(svc)
+		remote.NamedKey.ServiceURI() == URIfromService(svc) &&
+		(remote.UIDHash == "" || remote.UIDHash == listenerUIDHash(svc))
 }
 
 func isProtocolMatch(local, remote *Listener) bool {
@@ -404,12 +432,16 @@ func isPortMatch(local, remote *Listener) bool {
 // 2. We assume listener with an empty name to be legacy version.
 // 3. We assume listener with an arbitrary name to be user managed listener.
 // 4. LoadBalancer created by kubernetes is not allowed to be reused.
-func BuildActionsForListeners(svc *v1.Service, service, console Listeners) (Listeners, error) {
-	override := isOverrideListeners(svc)
+// 5. A port taken by a listener not owned by the service is skipped, see isListenerOwned.
+func BuildActionsForListeners(ctx context.Context, svc *v1.Service, service, console Listeners) (Listeners, error) {
 	var (
 		addition = Listeners{}
 		updation = Listeners{}
 		deletion = Listeners{}
+		// occupied ports taken by the listeners not owned by the service
+		occupied = map[int32]bool{}
+		// migrating no listener of the loadbalancer is marked yet
+		migrating = !hasMarkedListener(console)
 	)
 	// For updations and deletions
 	for _, remote := range console {
@@ -418,27 +450,20 @@ func BuildActionsForListeners(svc *v1.Service, service, console Listeners) (List
 			if remote.Port == local.Port {
 				found = true
 				// port matched. that is where the conflict case begin.
-				// 1. check protocol match.
+				if !isListenerOwned(svc, remote, migrating) {
+					// the other listeners of the service are reconciled anyway
+					occupied[local.Port] = true
+					recordListenerConflict(ctx, svc, remote)
+					continue
+				}
+				// check protocol match.
 				if isProtocolMatch(local, remote) {
-					// protocol match, need to do update operate no matter managed by whom
-					// consider override annotation & user defined loadbalancer
-					if !override && isUserDefinedLoadBalancer(svc) {
-						// port conflict with user managed slb or listener.
-						return nil, fmt.Errorf("PortProtocolConflict] port matched, but conflict with user managed listener. "+
-							"Port:%d, ListenerName:%s, svc: %s. Protocol:[source:%s dst:%s]",
-							remote.Port, remote.Name, local.NamedKey.Key(), remote.TransforedProto, local.TransforedProto)
-					}
-
 					// do update operate
 					local.Action = ACTION_UPDATE
 					updation = append(updation, local)
 					utils.Logf(svc, "found listener with port & protocol match, do update %s", local.NamedKey.Key())
 				} else {
 					// protocol not match, need to recreate
-					if !override && isUserDefinedLoadBalancer(svc) {
-						return nil, fmt.Errorf("[PortProtocolConflict] port matched, "+
-							"while protocol does not. force override listener %t. source[%v], target[%v]", override, local.NamedKey, remote.NamedKey)
-					}
 					remote.Action = ACTION_DELETE
 					deletion = append(deletion, remote)
 					utils.Logf(svc, "found listener with port match while protocol not, do delete & add %s", local.NamedKey.Key())
@@ -446,13 +471,11 @@ func BuildActionsForListeners(svc *v1.Service, service, console Listeners) (List
 			}
 		}
 		// Do not delete any listener that no longer managed by my service
-		// for safety. Only conflict case is taking care.
+		// for safety. Those of the removed ports are left to cleanupOrphans.
 		if !found {
 			if isManagedByMyService(svc, remote) {
-				remote.Action = ACTION_DELETE
-				deletion = append(deletion, remote)
 				utils.Logf(svc, "found listener[%s] which is no longer needed "+
-					"by my service[%s/%s], do delete", remote.NamedKey.Key(), svc.Namespace, svc.Name)
+					"by my service[%s/%s], left to the cleanup", remote.NamedKey.Key(), svc.Namespace, svc.Name)
 			} else {
 				utils.Logf(svc, "port [%d] not managed by my service [%s/%s], skip processing.", remote.Port, svc.Namespace, svc.Name)
 			}
@@ -461,6 +484,9 @@ func BuildActionsForListeners(svc *v1.Service, service, console Listeners) (List
 	// +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++
 	// For additions
 	for _, local := range service {
+		if occupied[local.Port] {
+			continue
+		}
 		found := false
 		for _, remote := range console {
 			if isPortMatch(remote, local) {
@@ -495,7 +521,12 @@ func BuildListenersFromService(
 	listeners := Listeners{}
 
 	for _, port := range svc.Spec.Ports {
-		proto, err := Protocol(serviceAnnotation(svc, ServiceAnnotationLoadBalancerProtocolPort), port)
+		// psvc carries the annotations overridden for this port
+		psvc, err := ServiceForPort(svc, port)
+		if err != nil {
+			return nil, err
+		}
+		proto, err := Protocol(serviceAnnotation(psvc, ServiceAnnotationLoadBalancerProtocolPort), port)
 		if err != nil {
 			return nil, err
 		}
@@ -509,15 +540,13 @@ func BuildListenersFromService(
 			Port:            port.Port,
 			NodePort:        port.NodePort,
 			Proto:           string(port.Protocol),
-			Service:         svc,
+			Service:         psvc,
 			TransforedProto: proto,
 			Client:          client,
 			VGroups:         vgrps,
 			LoadBalancerID:  lb.LoadBalancerId,
 		}
-		if IsENIBackendType(svc) {
-			n.NodePort = port.TargetPort.IntVal
-		}
+		n.NodePort = backendPort(svc, port)
 		n.Name = n.NamedKey.Key()
 		listeners = append(listeners, &n)
 	}
@@ -533,7 +562,7 @@ func BuildListenersFromAPI(
 ) (listeners Listeners) {
 	ports := lb.ListenerPortsAndProtocol.ListenerPortAndProtocol
 	for _, port := range ports {
-		key, err := LoadNamedKey(port.Description)
+		key, hash, err := loadListenerMarker(port.Description)
 		if err != nil {
 			klog.Warningf("alicloud: error parse listener description[%s]. %s", port.Description, err.Error())
 		}
@@ -545,6 +574,7 @@ func BuildListenersFromAPI(
 		n := Listener{
 			Name:            port.Description,
 			NamedKey:        key,
+			UIDHash:         hash,
 			Port:            int32(port.ListenerPort),
 			Proto:           proto,
 			TransforedProto: port.ListenerProtocol,
@@ -561,57 +591,203 @@ func BuildListenersFromAPI(
 type tcp struct{ *Listener }
 
 func (t *tcp) Add(ctx context.Context) error {
-	def, _ := ExtractAnnotationRequest(t.Service)
-	return t.Client.CreateLoadBalancerTCPListener(
-		ctx,
-		&slb.CreateLoadBalancerTCPListenerArgs{
-			LoadBalancerId:    t.LoadBalancerID,
-			ListenerPort:      int(t.Port),
-			BackendServerPort: int(t.NodePort),
-			//Health Check
-			Scheduler:          slb.SchedulerType(def.Scheduler),
-			Bandwidth:          DEFAULT_LISTENER_BANDWIDTH,
-			PersistenceTimeout: def.PersistenceTimeout,
-			Description:        t.NamedKey.Key(),
-
-			VServerGroupId:            t.findVgroup(t.NamedKey.Reference(t.NodePort)),
-			AclType:                   def.AclType,
-			AclStatus:                 def.AclStatus,
-			AclId:                     def.AclID,
-			HealthCheckType:           def.HealthCheckType,
-			HealthCheckURI:            def.HealthCheckURI,
-			HealthCheckConnectPort:    def.HealthCheckConnectPort,
-			HealthyThreshold:          def.HealthyThreshold,
-			UnhealthyThreshold:        def.UnhealthyThreshold,
-			HealthCheckConnectTimeout: def.HealthCheckConnectTimeout,
-			HealthCheckInterval:       def.HealthCheckInterval,
-			HealthCheck:               def.HealthCheck,
-			HealthCheckDomain:         def.HealthCheckDomain,
-			HealthCheckHttpCode:       def.HealthCheckHttpCode,
-		})
+	def, request := t.annotationRequest()
+	config := &slb.CreateLoadBalancerTCPListenerArgs{
+		LoadBalancerId:    t.LoadBalancerID,
+		ListenerPort:      int(t.Port),
+		BackendServerPort: int(t.NodePort),
+		//Health Check
+		Scheduler:          slb.SchedulerType(t.scheduler()),
+		Bandwidth:          t.bandwidth(),
+		PersistenceTimeout: t.persistenceTimeout(ctx),
+		Description:        t.marker(),
+
+		VServerGroupId:            t.findVgroup(t.NamedKey.Reference(t.NodePort)),
+		AclType:                   def.AclType,
+		AclStatus:                 def.AclStatus,
+		AclId:                     def.AclID,
+		HealthCheckType:           def.HealthCheckType,
+		HealthCheckURI:            def.HealthCheckURI,
+		HealthCheckConnectPort:    def.HealthCheckConnectPort,
+		HealthyThreshold:          def.HealthyThreshold,
+		UnhealthyThreshold:        def.UnhealthyThreshold,
+		HealthCheckConnectTimeout: def.HealthCheckConnectTimeout,
+		HealthCheckInterval:       def.HealthCheckInterval,
+		HealthCheck:               def.HealthCheck,
+		HealthCheckDomain:         def.HealthCheckDomain,
+		HealthCheckHttpCode:       def.HealthCheckHttpCode,
+	}
+	t.localTCPHealthCheck(request, config)
+	clearHTTPHealthCheck(config)
+	return t.Client.CreateLoadBalancerTCPListener(ctx, config)
+}
+
+// PersistenceTimeout returns the persistence timeout of tcp & udp listeners.
+// The annotation takes precedence over spec.sessionAffinity, ClientIP affinity
+// is mapped to its timeoutSeconds. The value is clamped to what slb accepts,
+// clamped reports whether it has been.
+func PersistenceTimeout(service *v1.Service) (timeout int, clamped bool) {
+	def, request := ExtractAnnotationRequest(service)
+	switch {
+	case request.PersistenceTimeout != nil:
+		timeout = *def.PersistenceTimeout
+	case service.Spec.SessionAffinity == v1.ServiceAffinityClientIP:
+		timeout = int(v1.DefaultClientIPServiceAffinitySeconds)
+		if cfg := service.Spec.SessionAffinityConfig; cfg != nil &&
+			cfg.ClientIP != nil && cfg.ClientIP.TimeoutSeconds != nil {
+			timeout = int(*cfg.ClientIP.TimeoutSeconds)
+		}
+	}
+	if timeout < PERSISTENCE_TIMEOUT_MIN {
+		return PERSISTENCE_TIMEOUT_MIN, true
+	}
+	if timeout > PERSISTENCE_TIMEOUT_MAX {
+		return PERSISTENCE_TIMEOUT_MAX, true
+	}
+	return timeout, false
+}
+
+func (n *Listener) persistenceTimeout(ctx context.Context) *int {
+	timeout, clamped := PersistenceTimeout(n.Service)
+	if clamped {
+		record, err := utils.GetRecorderFromContext(ctx)
+		if err != nil {
+			klog.Warningf("get recorder error: %s", err.Error())
+		} else {
+			record.Eventf(
+				n.Service,
+				v1.EventTypeWarning,
+				"PersistenceTimeoutClamped",
+				"Persistence timeout of %s listener %d is clamped to %d, slb accepts [%d, %d]",
+				strings.ToUpper(n.TransforedProto), n.Port, timeout,
+				PERSISTENCE_TIMEOUT_MIN, PERSISTENCE_TIMEOUT_MAX,
+			)
+		}
+	}
+	return &timeout
+}
+
+func intValue(v *int) int {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+// localHealthCheckPort the healthCheckNodePort kube-proxy serves for Local
+// traffic policy services, it reports whether the node has local endpoints.
+// 0 is returned for Cluster policy and eni backends. Udp listeners can only
+// be probed over udp and always probe the backend port.
+func (n *Listener) localHealthCheckPort() int {
+	svc := n.Service
+	if !ServiceModeLocal(svc) ||
+		svc.Spec.HealthCheckNodePort == 0 ||
+		IsENIBackendType(svc) {
+		return 0
+	}
+	return int(svc.Spec.HealthCheckNodePort)
+}
+
+// setLocalHealthCheckConnectPort unless the connect port is annotated.
+func (n *Listener) setLocalHealthCheckConnectPort(port *int, local int) {
+	if serviceAnnotation(n.Service, ServiceAnnotationLoadBalancerHealthCheckConnectPort) == "" {
+		*port = local
+	}
+}
+
+// resetHealthCheckConnectPort probe the backend port again once the connect
+// port is neither annotated nor redirected to the healthCheckNodePort.
+func (n *Listener) resetHealthCheckConnectPort(port *int, backend int) {
+	if serviceAnnotation(n.Service, ServiceAnnotationLoadBalancerHealthCheckConnectPort) != "" {
+		return
+	}
+	if *port != 0 && *port != MagicHealthCheckConnectPort && *port != backend {
+		*port = MagicHealthCheckConnectPort
+	}
+}
+
+// localTCPHealthCheck http check LOCAL_HEALTH_CHECK_URI on the
+// healthCheckNodePort for Local traffic policy services, and undo it once the
+// policy is switched back. Annotations take precedence. Returns whether config
+// has changed.
+func (n *Listener) localTCPHealthCheck(request *AnnotationRequest, config *slb.CreateLoadBalancerTCPListenerArgs) bool {
+	port, uri, hcType := config.HealthCheckConnectPort, config.HealthCheckURI, config.HealthCheckType
+	if local := n.localHealthCheckPort(); local != 0 {
+		n.setLocalHealthCheckConnectPort(&config.HealthCheckConnectPort, local)
+		if request.HealthCheckType == "" {
+			config.HealthCheckType = slb.HTTPHealthCheckType
+		}
+		if request.HealthCheckURI == "" {
+			config.HealthCheckURI = LOCAL_HEALTH_CHECK_URI
+		}
+	} else {
+		n.resetHealthCheckConnectPort(&config.HealthCheckConnectPort, config.BackendServerPort)
+		if request.HealthCheckURI == "" && config.HealthCheckURI == LOCAL_HEALTH_CHECK_URI {
+			config.HealthCheckURI = ""
+			if request.HealthCheckType == "" {
+				config.HealthCheckType = slb.TCPHealthCheckType
+			}
+		}
+	}
+	return port != config.HealthCheckConnectPort || uri != config.HealthCheckURI || hcType != config.HealthCheckType
+}
+
+// localHTTPHealthCheck same as localTCPHealthCheck for http & https listeners,
+// health check is turned on unless annotated.
+func (n *Listener) localHTTPHealthCheck(request *AnnotationRequest, config *slb.HTTPListenerType) bool {
+	port, uri, check := config.HealthCheckConnectPort, config.HealthCheckURI, config.HealthCheck
+	if local := n.localHealthCheckPort(); local != 0 {
+		n.setLocalHealthCheckConnectPort(&config.HealthCheckConnectPort, local)
+		if request.HealthCheck == "" {
+			config.HealthCheck = slb.OnFlag
+		}
+		if request.HealthCheckURI == "" {
+			config.HealthCheckURI = LOCAL_HEALTH_CHECK_URI
+		}
+	} else {
+		n.resetHealthCheckConnectPort(&config.HealthCheckConnectPort, config.BackendServerPort)
+		if request.HealthCheckURI == "" && config.HealthCheckURI == LOCAL_HEALTH_CHECK_URI {
+			config.HealthCheckURI = ""
+			if request.HealthCheck == "" {
+				config.HealthCheck = slb.OffFlag
+			}
+		}
+	}
+	return port != config.HealthCheckConnectPort || uri != config.HealthCheckURI || check != config.HealthCheck
+}
+
+// clearHTTPHealthCheck drop the http only health check settings when tcp
+// listener is checked in tcp mode. They are shared with http listeners.
+func clearHTTPHealthCheck(config *slb.CreateLoadBalancerTCPListenerArgs) {
+	if config.HealthCheckType == slb.HTTPHealthCheckType {
+		return
+	}
+	config.HealthCheckURI = ""
+	config.HealthCheckDomain = ""
+	config.HealthCheckHttpCode = ""
 }
 
 func (t *tcp) Update(ctx context.Context) error {
-	def, request := ExtractAnnotationRequest(t.Service)
+	def, request := t.annotationRequest()
 
 	response, err := t.Client.DescribeLoadBalancerTCPListenerAttribute(ctx, t.LoadBalancerID, int(t.Port))
 	if err != nil {
-		return fmt.Errorf("update tcp listener: %s", err.Error())
+		return fmt.Errorf("update tcp listener: %w", err)
 	}
 	utils.Logf(t.Service, "tcp listener %d status is %s.", t.Port, response.Status)
 	if response.Status == slb.Stopped {
 		if err = t.Client.StartLoadBalancerListener(ctx, t.LoadBalancerID, int(t.Port)); err != nil {
-			return fmt.Errorf("start tcp listener error: %s", err.Error())
+			return fmt.Errorf("start tcp listener error: %w", err)
 		}
 	}
 	config := &slb.SetLoadBalancerTCPListenerAttributeArgs{
 		LoadBalancerId:    t.LoadBalancerID,
 		ListenerPort:      int(t.Port),
 		BackendServerPort: int(t.NodePort),
-		Description:       t.NamedKey.Key(),
+		Description:       t.marker(),
 		//Health Check
 		Scheduler:          slb.SchedulerType(response.Scheduler),
-		Bandwidth:          DEFAULT_LISTENER_BANDWIDTH,
+		Bandwidth:          t.bandwidth(),
 		PersistenceTimeout: response.PersistenceTimeout,
 		VServerGroup:       slb.OnFlag,
 		VServerGroupId:     t.findVgroup(t.NamedKey.Reference(t.NodePort)),
@@ -631,6 +807,16 @@ func (t *tcp) Update(ctx context.Context) error {
 		HealthCheckDomain:         response.HealthCheckDomain,
 	}
 	needUpdate := false
+	// a listener taken over is marked by the service
+	if response.Description != config.Description {
+		needUpdate = true
+	}
+	// the cap is lifted as well once the annotation is removed
+	if config.Bandwidth != response.Bandwidth &&
+		(config.Bandwidth != DEFAULT_LISTENER_BANDWIDTH || response.Bandwidth > 0) {
+		needUpdate = true
+		klog.V(2).Infof("listener %d [bandwidth] changed, request=%d. response=%d", t.Port, config.Bandwidth, response.Bandwidth)
+	}
 	/*
 		if request.Bandwidth != 0 &&
 			def.Bandwidth != response.Bandwidth {
@@ -654,69 +840,80 @@ func (t *tcp) Update(ctx context.Context) error {
 		needUpdate = true
 		config.AclId = def.AclID
 	}
+	if detachSourceRangesAcl(ctx, t.Listener, request, response.AclStatus, response.AclId) {
+		needUpdate = true
+		config.AclStatus = string(slb.OffFlag)
+	}
 	if request.AclType != "" &&
 		def.AclType != response.AclType {
 		needUpdate = true
 		config.AclType = def.AclType
 	}
 
-	if request.Scheduler != "" &&
-		def.Scheduler != string(response.Scheduler) {
+	if scheduler := t.scheduler(); request.Scheduler != "" &&
+		scheduler != string(response.Scheduler) {
 		needUpdate = true
-		config.Scheduler = slb.SchedulerType(def.Scheduler)
+		config.Scheduler = slb.SchedulerType(scheduler)
 	}
 
-	// todo: perform healthcheck update.
-	if request.HealthCheckType != "" &&
-		def.HealthCheckType != response.HealthCheckType {
-		needUpdate = true
-		config.HealthCheckType = def.HealthCheckType
-	}
-	if request.HealthCheckURI != "" &&
-		def.HealthCheckURI != response.HealthCheckURI {
-		needUpdate = true
-		config.HealthCheckURI = def.HealthCheckURI
-	}
-	if request.HealthCheckConnectPort != 0 &&
-		def.HealthCheckConnectPort != response.HealthCheckConnectPort {
-		needUpdate = true
-		config.HealthCheckConnectPort = def.HealthCheckConnectPort
-	}
-	if request.HealthyThreshold != 0 &&
-		def.HealthyThreshold != response.HealthyThreshold {
-		needUpdate = true
-		config.HealthyThreshold = def.HealthyThreshold
-	}
-	if request.UnhealthyThreshold != 0 &&
-		def.UnhealthyThreshold != response.UnhealthyThreshold {
-		needUpdate = true
-		config.UnhealthyThreshold = def.UnhealthyThreshold
-	}
-	if request.HealthCheckConnectTimeout != 0 &&
-		def.HealthCheckConnectTimeout != response.HealthCheckConnectTimeout {
-		needUpdate = true
-		config.HealthCheckConnectTimeout = def.HealthCheckConnectTimeout
-	}
-	if request.HealthCheckInterval != 0 &&
-		def.HealthCheckInterval != response.HealthCheckInterval {
-		needUpdate = true
-		config.HealthCheckInterval = def.HealthCheckInterval
-	}
-	if request.PersistenceTimeout != nil &&
-		*def.PersistenceTimeout != *response.PersistenceTimeout {
+	if persistence := t.persistenceTimeout(ctx); *persistence != intValue(response.PersistenceTimeout) {
 		needUpdate = true
-		config.PersistenceTimeout = def.PersistenceTimeout
+		config.PersistenceTimeout = persistence
 	}
-	if request.HealthCheckHttpCode != "" &&
-		def.HealthCheckHttpCode != response.HealthCheckHttpCode {
+	if request.HealthCheckSwitch == string(slb.OnFlag) &&
+		response.HealthCheck == slb.OffFlag {
+		// health check is switched back on, restore the probe parameters.
 		needUpdate = true
-		config.HealthCheckHttpCode = def.HealthCheckHttpCode
+		restoreHealthCheck(def, &config.HealthyThreshold, &config.UnhealthyThreshold,
+			&config.HealthCheckConnectTimeout, &config.HealthCheckInterval)
 	}
-	if request.HealthCheckDomain != "" &&
-		def.HealthCheckDomain != response.HealthCheckDomain {
-		needUpdate = true
-		config.HealthCheckDomain = def.HealthCheckDomain
+	// todo: perform healthcheck update.
+	// probe parameters are left untouched while health check is switched off.
+	if def.HealthCheckSwitch != string(slb.OffFlag) {
+		if request.HealthCheckType != "" &&
+			def.HealthCheckType != response.HealthCheckType {
+			needUpdate = true
+			config.HealthCheckType = def.HealthCheckType
+		}
+		if request.HealthCheckURI != "" &&
+			def.HealthCheckURI != response.HealthCheckURI {
+			needUpdate = true
+			config.HealthCheckURI = def.HealthCheckURI
+		}
+		if request.HealthCheckConnectPort != 0 &&
+			def.HealthCheckConnectPort != response.HealthCheckConnectPort {
+			needUpdate = true
+			config.HealthCheckConnectPort = def.HealthCheckConnectPort
+		}
+		if request.HealthyThreshold != 0 &&
+			def.HealthyThreshold != response.HealthyThreshold {
+			needUpdate = true
+			config.HealthyThreshold = def.HealthyThreshold
+		}
+		if request.UnhealthyThreshold != 0 &&
+			def.UnhealthyThreshold != response.UnhealthyThreshold {
+			needUpdate = true
+			config.UnhealthyThreshold = def.UnhealthyThreshold
+		}
+		if request.HealthCheckConnectTimeout != 0 &&
+			def.HealthCheckConnectTimeout != response.HealthCheckConnectTimeout {
+			needUpdate = true
+			config.HealthCheckConnectTimeout = def.HealthCheckConnectTimeout
+		}
+		if request.HealthCheckInterval != 0 &&
+			def.HealthCheckInterval != response.HealthCheckInterval {
+			needUpdate = true
+			config.HealthCheckInterval = def.HealthCheckInterval
+		}
+		if t.localTCPHealthCheck(request, (*slb.CreateLoadBalancerTCPListenerArgs)(config)) {
+			needUpdate = true
+		}
+		if config.HealthCheckType == slb.HTTPHealthCheckType &&
+			restoreHealthCheckMatch(def, request, &config.HealthCheckDomain, &config.HealthCheckHttpCode) {
+			needUpdate = true
+		}
 	}
+	clearHTTPHealthCheck((*slb.CreateLoadBalancerTCPListenerArgs)(config))
 	// backend server port has changed.
 	if int(t.NodePort) != response.BackendServerPort {
 		config.BackendServerPort = int(t.NodePort)
@@ -763,19 +960,19 @@ func (t *udp) Describe(ctx context.Context) error {
 	return fmt.Errorf("unimplemented")
 }
 func (t *udp) Add(ctx context.Context) error {
-	def, _ := ExtractAnnotationRequest(t.Service)
+	def, _ := t.annotationRequest()
 	return t.Client.CreateLoadBalancerUDPListener(
 		ctx,
 		&slb.CreateLoadBalancerUDPListenerArgs{
 			LoadBalancerId:    t.LoadBalancerID,
 			ListenerPort:      int(t.Port),
 			BackendServerPort: int(t.NodePort),
-			Description:       t.NamedKey.Key(),
+			Description:       t.marker(),
 			VServerGroupId:    t.findVgroup(t.NamedKey.Reference(t.NodePort)),
 			//Health Check
-			Scheduler:          slb.SchedulerType(def.Scheduler),
-			Bandwidth:          DEFAULT_LISTENER_BANDWIDTH,
-			PersistenceTimeout: def.PersistenceTimeout,
+			Scheduler:          slb.SchedulerType(t.scheduler()),
+			Bandwidth:          t.bandwidth(),
+			PersistenceTimeout: t.persistenceTimeout(ctx),
 
 			AclType:   def.AclType,
 			AclStatus: def.AclStatus,
@@ -793,7 +990,7 @@ func (t *udp) Add(ctx context.Context) error {
 }
 
 func (t *udp) Update(ctx context.Context) error {
-	def, request := ExtractAnnotationRequest(t.Service)
+	def, request := t.annotationRequest()
 	response, err := t.Client.DescribeLoadBalancerUDPListenerAttribute(ctx, t.LoadBalancerID, int(t.Port))
 	if err != nil {
 		return err
@@ -801,14 +998,14 @@ func (t *udp) Update(ctx context.Context) error {
 	utils.Logf(t.Service, "udp listener %d status is %s.", t.Port, response.Status)
 	if response.Status == slb.Stopped {
 		if err = t.Client.StartLoadBalancerListener(ctx, t.LoadBalancerID, int(t.Port)); err != nil {
-			return fmt.Errorf("start udp listener error: %s", err.Error())
+			return fmt.Errorf("start udp listener error: %w", err)
 		}
 	}
 	config := &slb.SetLoadBalancerUDPListenerAttributeArgs{
 		LoadBalancerId:    t.LoadBalancerID,
 		ListenerPort:      int(t.Port),
 		BackendServerPort: int(t.NodePort),
-		Description:       t.NamedKey.Key(),
+		Description:       t.marker(),
 		VServerGroup:      slb.OnFlag,
 		VServerGroupId:    t.findVgroup(t.NamedKey.Reference(t.NodePort)),
 		AclType:           response.AclType,
@@ -816,7 +1013,7 @@ func (t *udp) Update(ctx context.Context) error {
 		AclId:             response.AclId,
 		//Health Check
 		Scheduler:          slb.SchedulerType(response.Scheduler),
-		Bandwidth:          DEFAULT_LISTENER_BANDWIDTH,
+		Bandwidth:          t.bandwidth(),
 		PersistenceTimeout: response.PersistenceTimeout,
 		//HealthCheckType:           response.HealthCheckType,
 		//HealthCheckURI:            response.HealthCheckURI,
@@ -828,6 +1025,16 @@ func (t *udp) Update(ctx context.Context) error {
 		HealthCheck:               response.HealthCheck,
 	}
 	needUpdate := false
+	// a listener taken over is marked by the service
+	if response.Description != config.Description {
+		needUpdate = true
+	}
+	// the cap is lifted as well once the annotation is removed
+	if config.Bandwidth != response.Bandwidth &&
+		(config.Bandwidth != DEFAULT_LISTENER_BANDWIDTH || response.Bandwidth > 0) {
+		needUpdate = true
+		klog.V(2).Infof("listener %d [bandwidth] changed, request=%d. response=%d", t.Port, config.Bandwidth, response.Bandwidth)
+	}
 	/*
 		if request.Bandwidth != 0 &&
 			request.Bandwidth != response.Bandwidth {
@@ -850,53 +1057,89 @@ func (t *udp) Update(ctx context.Context) error {
 		needUpdate = true
 		config.AclId = def.AclID
 	}
+	if detachSourceRangesAcl(ctx, t.Listener, request, response.AclStatus, response.AclId) {
+		needUpdate = true
+		config.AclStatus = string(slb.OffFlag)
+	}
 	if request.AclType != "" &&
 		def.AclType != response.AclType {
 		needUpdate = true
 		config.AclType = def.AclType
 	}
 
-	if request.Scheduler != "" &&
-		def.Scheduler != string(response.Scheduler) {
+	if scheduler := t.scheduler(); request.Scheduler != "" &&
+		scheduler != string(response.Scheduler) {
 		needUpdate = true
-		config.Scheduler = slb.SchedulerType(def.Scheduler)
+		config.Scheduler = slb.SchedulerType(scheduler)
 	}
-	// todo: perform healthcheck update.
-	if request.HealthCheckConnectPort != 0 &&
-		def.HealthCheckConnectPort != response.HealthCheckConnectPort {
+	if request.HealthCheckSwitch == string(slb.OnFlag) &&
+		response.HealthCheck == slb.OffFlag {
+		// health check is switched back on, restore the probe parameters.
 		needUpdate = true
-		config.HealthCheckConnectPort = def.HealthCheckConnectPort
+		restoreHealthCheck(def, &config.HealthyThreshold, &config.UnhealthyThreshold,
+			&config.HealthCheckConnectTimeout, &config.HealthCheckInterval)
 	}
-	if request.HealthyThreshold != 0 &&
-		def.HealthyThreshold != response.HealthyThreshold {
-		needUpdate = true
-		config.HealthyThreshold = def.HealthyThreshold
-	}
-	if request.UnhealthyThreshold != 0 &&
-		def.UnhealthyThreshold != response.UnhealthyThreshold {
-		needUpdate = true
-		config.UnhealthyThreshold = def.UnhealthyThreshold
+	// todo: perform healthcheck update.
+	// probe parameters are left untouched while health check is switched off.
+	if def.HealthCheckSwitch != string(slb.OffFlag) {
+		if request.HealthCheckConnectPort != 0 &&
+			def.HealthCheckConnectPort != response.HealthCheckConnectPort {
+			needUpdate = true
+			config.HealthCheckConnectPort = def.HealthCheckConnectPort
+		}
+		if request.HealthCheckConnectPort == 0 &&
+			response.HealthCheckConnectPort != 0 &&
+			response.HealthCheckConnectPort != MagicHealthCheckConnectPort &&
+			response.HealthCheckConnectPort != response.BackendServerPort {
+			// annotation removed, check the backend server port again.
+			needUpdate = true
+			config.HealthCheckConnectPort = MagicHealthCheckConnectPort
+		}
+		for _, hc := range []struct {
+			request  int
+			def      int
+			current  int
+			defaults int
+			config   *int
+		}{
+			{request.HealthyThreshold, def.HealthyThreshold, response.HealthyThreshold,
+				DEFAULT_HEALTHY_THRESHOLD, &config.HealthyThreshold},
+			{request.UnhealthyThreshold, def.UnhealthyThreshold, response.UnhealthyThreshold,
+				DEFAULT_UNHEALTHY_THRESHOLD, &config.UnhealthyThreshold},
+			{request.HealthCheckConnectTimeout, def.HealthCheckConnectTimeout, response.HealthCheckConnectTimeout,
+				DEFAULT_HEALTH_CHECK_CONNECT_TIMEOUT, &config.HealthCheckConnectTimeout},
+			{request.HealthCheckInterval, def.HealthCheckInterval, response.HealthCheckInterval,
+				DEFAULT_HEALTH_CHECK_INTERVAL, &config.HealthCheckInterval},
+		} {
+			want := hc.def
+			if hc.request == 0 {
+				// annotation removed, restore slb default. 0 means never been set.
+				if hc.current == 0 {
+					continue
+				}
+				want = hc.defaults
+			}
+			if want != hc.current {
+				needUpdate = true
+				*hc.config = want
+			}
+		}
 	}
-	if request.HealthCheckConnectTimeout != 0 &&
-		def.HealthCheckConnectTimeout != response.HealthCheckConnectTimeout {
+	if persistence := t.persistenceTimeout(ctx); *persistence != intValue(response.PersistenceTimeout) {
 		needUpdate = true
-		config.HealthCheckConnectTimeout = def.HealthCheckConnectTimeout
+		config.PersistenceTimeout = persistence
 	}
-	if request.HealthCheckInterval != 0 &&
-		def.HealthCheckInterval != response.HealthCheckInterval {
-		needUpdate = true
-		config.HealthCheckInterval = def.HealthCheckInterval
-	}
-	if request.PersistenceTimeout != nil &&
-		*def.PersistenceTimeout != *response.PersistenceTimeout {
-		needUpdate = true
-		config.PersistenceTimeout = def.PersistenceTimeout
+	// slb ignores empty health check strings, the listener has to be
+	// recreated to clear them.
+	clearCheckString, err := t.clearUDPHealthCheckString(ctx)
+	if err != nil {
+		return err
 	}
 	// backend server port has changed.
-	if int(t.NodePort) != response.BackendServerPort {
+	if int(t.NodePort) != response.BackendServerPort || clearCheckString {
 		config.BackendServerPort = int(t.NodePort)
 		utils.Logf(t.Service, "udp listener checker [BackendServerPort] changed, "+
-			"request=%d. response=%d", t.NodePort, response.BackendServerPort)
+			"request=%d. response=%d, clear health check string=%t", t.NodePort, response.BackendServerPort, clearCheckString)
 		// The listener description has changed. It may be that multiple services reuse the same port of the same slb, and needs to record event.
 		if response.Description != config.Description {
 			record, err := utils.GetRecorderFromContext(ctx)
@@ -935,22 +1178,102 @@ func (t *udp) Update(ctx context.Context) error {
 	return t.Client.SetLoadBalancerUDPListenerAttribute(ctx, config)
 }
 
+// restoreHealthCheck set the probe parameters to the configured value, or the
+// slb default when it is not configured.
+func restoreHealthCheck(def *AnnotationRequest, healthy, unhealthy, connectTimeout, interval *int) {
+	for _, v := range []struct {
+		config   *int
+		value    int
+		defaults int
+	}{
+		{healthy, def.HealthyThreshold, DEFAULT_HEALTHY_THRESHOLD},
+		{unhealthy, def.UnhealthyThreshold, DEFAULT_UNHEALTHY_THRESHOLD},
+		{connectTimeout, def.HealthCheckConnectTimeout, DEFAULT_HEALTH_CHECK_CONNECT_TIMEOUT},
+		{interval, def.HealthCheckInterval, DEFAULT_HEALTH_CHECK_INTERVAL},
+	} {
+		*v.config = v.value
+		if v.value == 0 {
+			*v.config = v.defaults
+		}
+	}
+}
+
+// healthCheckHTTPCodeFormat the http codes slb accepts as healthy
+var healthCheckHTTPCodeFormat = enumListFormat(
+	string(slb.HTTP_2XX), string(slb.HTTP_3XX), string(slb.HTTP_4XX), string(slb.HTTP_5XX))
+
+// sameHTTPCodes whether the comma separated http codes are the same regardless
+// of their order.
+func sameHTTPCodes(a, b slb.HealthCheckHttpCodeType) bool {
+	split := func(codes slb.HealthCheckHttpCodeType) []string {
+		s := strings.Split(string(codes), ",")
+		sort.Strings(s)
+		return s
+	}
+	return strings.Join(split(a), ",") == strings.Join(split(b), ",")
+}
+
+// restoreHealthCheckMatch set the domain and the http codes of http health
+// check to the annotated value, or the slb default once the annotation is
+// removed. Returns whether config has changed.
+func restoreHealthCheckMatch(def, request *AnnotationRequest, domain *string, code *slb.HealthCheckHttpCodeType) bool {
+	changed := false
+	// empty means never been set.
+	if want := def.HealthCheckDomain; request.HealthCheckDomain != "" || *domain != "" {
+		if request.HealthCheckDomain == "" {
+			want = DEFAULT_HEALTH_CHECK_DOMAIN
+		}
+		if want != *domain {
+			changed = true
+			*domain = want
+		}
+	}
+	if want := def.HealthCheckHttpCode; request.HealthCheckHttpCode != "" || *code != "" {
+		if request.HealthCheckHttpCode == "" {
+			want = DEFAULT_HEALTH_CHECK_HTTP_CODE
+		}
+		if !sameHTTPCodes(want, *code) {
+			changed = true
+			*code = want
+		}
+	}
+	return changed
+}
+
+// clearUDPHealthCheckString whether the udp health check request or response
+// string is still set on the listener after its annotation has been removed.
+func (t *udp) clearUDPHealthCheckString(ctx context.Context) (bool, error) {
+	_, request := ExtractAnnotationRequest(t.Service)
+	if request.HealthCheckReq != "" && request.HealthCheckExp != "" {
+		return false, nil
+	}
+	current, err := t.Client.DescribeListenerExtension(
+		ctx, "udp",
+		&DescribeListenerExtensionArgs{LoadBalancerId: t.LoadBalancerID, ListenerPort: int(t.Port)},
+	)
+	if err != nil {
+		return false, fmt.Errorf("describe udp listener %d: %s", t.Port, err.Error())
+	}
+	return (request.HealthCheckReq == "" && current.HealthCheckReq != "") ||
+		(request.HealthCheckExp == "" && current.HealthCheckExp != ""), nil
+}
+
 type http struct{ *Listener }
 
 func (t *http) Describe(ctx context.Context) error {
 	return fmt.Errorf("unimplemented")
 }
 func (t *http) Add(ctx context.Context) error {
-	def, request := ExtractAnnotationRequest(t.Service)
+	def, request := t.annotationRequest()
 	httpc := &slb.CreateLoadBalancerHTTPListenerArgs{
 		LoadBalancerId:    t.LoadBalancerID,
 		ListenerPort:      int(t.Port),
 		BackendServerPort: int(t.NodePort),
-		Description:       t.NamedKey.Key(),
+		Description:       t.marker(),
 		VServerGroupId:    t.findVgroup(t.NamedKey.Reference(t.NodePort)),
 		//Health Check
-		Scheduler:         slb.SchedulerType(def.Scheduler),
-		Bandwidth:         DEFAULT_LISTENER_BANDWIDTH,
+		Scheduler:         slb.SchedulerType(t.scheduler()),
+		Bandwidth:         t.bandwidth(),
 		StickySession:     def.StickySession,
 		StickySessionType: def.StickySessionType,
 		CookieTimeout:     def.CookieTimeout,
@@ -971,9 +1294,15 @@ func (t *http) Add(ctx context.Context) error {
 		HealthCheckTimeout:  def.HealthCheckTimeout,
 		HealthCheckHttpCode: def.HealthCheckHttpCode,
 	}
+	httpc.IdleTimeout, httpc.RequestTimeout = t.listenerTimeouts()
+	clearStickySession((*slb.HTTPListenerType)(httpc))
+	setHTTPHeaders(request, (*slb.HTTPListenerType)(httpc))
+	t.localHTTPHealthCheck(request, (*slb.HTTPListenerType)(httpc))
 	forward := forwardPort(def.ForwardPort, t.Port)
 	if forward != 0 {
 		httpc.ListenerForward = slb.OnFlag
+		// requests are redirected to the forward port, no backend is attached.
+		httpc.VServerGroupId = ""
 	} else {
 		httpc.ListenerForward = slb.OffFlag
 	}
@@ -1010,9 +1339,310 @@ func forwardPort(port string, target int32) int32 {
 	return 0
 }
 
+const (
+	IDLE_TIMEOUT_MIN    = 1
+	IDLE_TIMEOUT_MAX    = 60
+	REQUEST_TIMEOUT_MIN = 1
+	REQUEST_TIMEOUT_MAX = 180
+
+	PERSISTENCE_TIMEOUT_MIN = 0
+	PERSISTENCE_TIMEOUT_MAX = 3600
+
+	DEFAULT_SCHEDULER = "rr"
+
+	// LOCAL_HEALTH_CHECK_URI served by kube-proxy on the healthCheckNodePort
+	LOCAL_HEALTH_CHECK_URI = "/healthz"
+
+	COOKIE_TIMEOUT_MIN = 1
+	COOKIE_TIMEOUT_MAX = 86400
+
+	HEALTH_CHECK_THRESHOLD_MIN = 2
+	HEALTH_CHECK_THRESHOLD_MAX = 10
+	HEALTH_CHECK_INTERVAL_MIN  = 1
+	HEALTH_CHECK_INTERVAL_MAX  = 50
+
+	// slb defaults, restored when the annotation is removed.
+	DEFAULT_HEALTHY_THRESHOLD            = 3
+	DEFAULT_UNHEALTHY_THRESHOLD          = 3
+	DEFAULT_HEALTH_CHECK_INTERVAL        = 2
+	DEFAULT_HEALTH_CHECK_CONNECT_TIMEOUT = 5
+	// DEFAULT_HEALTH_CHECK_DOMAIN the ip of the backend server
+	DEFAULT_HEALTH_CHECK_DOMAIN    = "$_ip"
+	DEFAULT_HEALTH_CHECK_HTTP_CODE = slb.HTTP_2XX
+)
+
+// ValidateListenerTimeouts check idle-timeout and request-timeout of the
+// service, whose ports share them, against the range allowed by slb.
+func ValidateListenerTimeouts(service *v1.Service) error {
+	def, _ := ExtractAnnotationRequest(service)
+	for _, port := range service.Spec.Ports {
+		if def.IdleTimeout != 0 &&
+			(def.IdleTimeout < IDLE_TIMEOUT_MIN || def.IdleTimeout > IDLE_TIMEOUT_MAX) {
+			return fmt.Errorf("idle timeout %d of port %d is out of range [%d, %d]",
+				def.IdleTimeout, port.Port, IDLE_TIMEOUT_MIN, IDLE_TIMEOUT_MAX)
+		}
+		if def.RequestTimeout != 0 &&
+			(def.RequestTimeout < REQUEST_TIMEOUT_MIN || def.RequestTimeout > REQUEST_TIMEOUT_MAX) {
+			return fmt.Errorf("request timeout %d of port %d is out of range [%d, %d]",
+				def.RequestTimeout, port.Port, REQUEST_TIMEOUT_MIN, REQUEST_TIMEOUT_MAX)
+		}
+	}
+	return nil
+}
+
+// healthCheckDomainRe domain of http health check, $_ip means the ip of backend server.
+var healthCheckDomainRe = regexp.MustCompile(`^([a-zA-Z0-9.-]{1,80}|\$_ip)$`)
+
+// ValidateHealthCheck check the health check annotations.
+func ValidateHealthCheck(service *v1.Service) error {
+	def, request := ExtractAnnotationRequest(service)
+	if request.HealthCheckType != "" &&
+		def.HealthCheckType != slb.TCPHealthCheckType &&
+		def.HealthCheckType != slb.HTTPHealthCheckType {
+		return fmt.Errorf("annotation %s must be tcp or http, got %s",
+			ServiceAnnotationLoadBalancerHealthCheckType, def.HealthCheckType)
+	}
+	if request.HealthCheckURI != "" &&
+		!strings.HasPrefix(def.HealthCheckURI, "/") {
+		return fmt.Errorf("annotation %s must begin with '/', got %s",
+			ServiceAnnotationLoadBalancerHealthCheckURI, def.HealthCheckURI)
+	}
+	if port := serviceAnnotation(service, ServiceAnnotationLoadBalancerHealthCheckConnectPort); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 0 || p > 65535 {
+			return fmt.Errorf("annotation %s must be in range [1, 65535] or 0 for the backend port, got %s",
+				ServiceAnnotationLoadBalancerHealthCheckConnectPort, port)
+		}
+	}
+	if request.HealthCheckDomain != "" &&
+		!healthCheckDomainRe.MatchString(def.HealthCheckDomain) {
+		return fmt.Errorf("annotation %s is not a valid domain: %s",
+			ServiceAnnotationLoadBalancerHealthCheckDomain, def.HealthCheckDomain)
+	}
+	for _, th := range []struct {
+		annotation string
+		value      int
+	}{
+		{ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold, request.HealthyThreshold},
+		{ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold, request.UnhealthyThreshold},
+	} {
+		if th.value != 0 &&
+			(th.value < HEALTH_CHECK_THRESHOLD_MIN || th.value > HEALTH_CHECK_THRESHOLD_MAX) {
+			return fmt.Errorf("annotation %s %d is out of range [%d, %d]",
+				th.annotation, th.value, HEALTH_CHECK_THRESHOLD_MIN, HEALTH_CHECK_THRESHOLD_MAX)
+		}
+	}
+	if request.HealthCheckInterval != 0 &&
+		(request.HealthCheckInterval < HEALTH_CHECK_INTERVAL_MIN ||
+			request.HealthCheckInterval > HEALTH_CHECK_INTERVAL_MAX) {
+		return fmt.Errorf("annotation %s %d is out of range [%d, %d]",
+			ServiceAnnotationLoadBalancerHealthCheckInterval, request.HealthCheckInterval,
+			HEALTH_CHECK_INTERVAL_MIN, HEALTH_CHECK_INTERVAL_MAX)
+	}
+	return nil
+}
+
+// ValidateStickySession check the session stickiness annotations of http and
+// https listeners against the combinations accepted by slb.
+func ValidateStickySession(service *v1.Service) error {
+	def, _ := ExtractAnnotationRequest(service)
+	for _, port := range service.Spec.Ports {
+		proto, err := Protocol(serviceAnnotation(service, ServiceAnnotationLoadBalancerProtocolPort), port)
+		if err != nil {
+			return err
+		}
+		if proto != "http" && proto != "https" {
+			continue
+		}
+		switch def.StickySession {
+		case slb.OffFlag:
+			continue
+		case slb.OnFlag:
+		default:
+			return fmt.Errorf("annotation %s must be on or off, got %s",
+				ServiceAnnotationLoadBalancerSessionStick, def.StickySession)
+		}
+		switch def.StickySessionType {
+		case slb.InsertStickySessionType:
+			if def.CookieTimeout < COOKIE_TIMEOUT_MIN || def.CookieTimeout > COOKIE_TIMEOUT_MAX {
+				return fmt.Errorf("annotation %s must be in range [%d, %d] for sticky session type insert, got %d",
+					ServiceAnnotationLoadBalancerCookieTimeout, COOKIE_TIMEOUT_MIN, COOKIE_TIMEOUT_MAX, def.CookieTimeout)
+			}
+		case slb.ServerStickySessionType:
+			if def.Cookie == "" || strings.ContainsAny(def.Cookie, ",; ") || strings.HasPrefix(def.Cookie, "$") {
+				return fmt.Errorf("annotation %s must be a valid cookie name for sticky session type server, got %q",
+					ServiceAnnotationLoadBalancerCookie, def.Cookie)
+			}
+		default:
+			return fmt.Errorf("annotation %s must be insert or server when sticky session is on, got %q",
+				ServiceAnnotationLoadBalancerSessionStickType, def.StickySessionType)
+		}
+	}
+	return nil
+}
+
+// ValidateListenerAnnotations validate listener annotations of every port
+// before any slb api is called.
+func ValidateListenerAnnotations(service *v1.Service) error {
+	if err := ValidateProtocolPort(service); err != nil {
+		return err
+	}
+	if err := ValidateCertSecret(service); err != nil {
+		return err
+	}
+	if err := ValidateAccessLog(service); err != nil {
+		return err
+	}
+	if err := ValidateModificationProtection(service); err != nil {
+		return err
+	}
+	if err := ValidateInternetSpec(service); err != nil {
+		return err
+	}
+	if err := ValidateInstanceChargeType(service); err != nil {
+		return err
+	}
+	if err := ValidateIPVersion(service); err != nil {
+		return err
+	}
+	if err := ValidateEip(service); err != nil {
+		return err
+	}
+	if err := ValidateHostname(service); err != nil {
+		return err
+	}
+	if err := ValidateAddress(service); err != nil {
+		return err
+	}
+	if err := ValidateBackendWeight(service); err != nil {
+		return err
+	}
+	if err := ValidateWeightMode(service); err != nil {
+		return err
+	}
+	if err := ValidateGracefulDrain(service); err != nil {
+		return err
+	}
+	if err := ValidateVGroupPort(service); err != nil {
+		return err
+	}
+	if err := ValidateMaxBackends(service); err != nil {
+		return err
+	}
+	if err := ValidateBackendType(service); err != nil {
+		return err
+	}
+	if err := ValidateNodePorts(service); err != nil {
+		return err
+	}
+	if err := ValidateKeepLastBackends(service); err != nil {
+		return err
+	}
+	if err := ValidateLoadBalancerType(service); err != nil {
+		return err
+	}
+	if err := ValidateManagedPorts(service); err != nil {
+		return err
+	}
+	if err := ValidateEmptyBackendsGuard(service); err != nil {
+		return err
+	}
+	if err := ValidateForceDelete(service); err != nil {
+		return err
+	}
+	for _, port := range service.Spec.Ports {
+		svc, err := ServiceForPort(service, port)
+		if err != nil {
+			return err
+		}
+		for _, validate := range []func(*v1.Service) error{
+			ValidateListenerTimeouts,
+			ValidateListenerExtension,
+			ValidateHealthCheck,
+			ValidateStickySession,
+			ValidateScheduler,
+			ValidateHTTPHeaders,
+			ValidateCertID,
+		} {
+			if err := validate(svc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scheduler of the listener, the value of the annotation has been validated
+// by ValidateScheduler. DEFAULT_SCHEDULER if it is not annotated.
+func (n *Listener) scheduler() string {
+	def, _ := ExtractAnnotationRequest(n.Service)
+	return def.Scheduler
+}
+
+// ValidateScheduler scheduler must be one of wrr, wlc, rr. Consistent hashing
+// sch and tch are supported by tcp & udp listeners only.
+func ValidateScheduler(service *v1.Service) error {
+	def, request := ExtractAnnotationRequest(service)
+	if request.Scheduler == "" {
+		return nil
+	}
+	scheduler := def.Scheduler
+	for _, port := range service.Spec.Ports {
+		switch scheduler {
+		case "wrr", "wlc", "rr":
+			continue
+		case "sch", "tch":
+		default:
+			return fmt.Errorf("annotation %s must be one of wrr, wlc, rr, sch, tch, got %s of port %d",
+				ServiceAnnotationLoadBalancerScheduler, scheduler, port.Port)
+		}
+		proto, err := Protocol(serviceAnnotation(service, ServiceAnnotationLoadBalancerProtocolPort), port)
+		if err != nil {
+			return err
+		}
+		if proto != "tcp" && proto != "udp" {
+			return fmt.Errorf("scheduler %s is not supported by %s listener of port %d", scheduler, proto, port.Port)
+		}
+	}
+	return nil
+}
+
+// listenerTimeouts idle timeout and request timeout of the listener, 0 means not set.
+// Values have been validated by ValidateListenerTimeouts.
+func (n *Listener) listenerTimeouts() (idle int, request int) {
+	def, _ := ExtractAnnotationRequest(n.Service)
+	return def.IdleTimeout, def.RequestTimeout
+}
+
+// validateForwardPorts make sure every forward-port target is an https listener of the same service.
+func validateForwardPorts(service *v1.Service, listeners Listeners) error {
+	def, _ := ExtractAnnotationRequest(service)
+	for _, l := range listeners {
+		if l.TransforedProto != "http" {
+			continue
+		}
+		forward := forwardPort(def.ForwardPort, l.Port)
+		if forward == 0 {
+			continue
+		}
+		found := false
+		for _, target := range listeners {
+			if target.Port == forward && target.TransforedProto == "https" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("forward-port %d:%d: port %d is not an https listener of service %s/%s",
+				l.Port, forward, forward, service.Namespace, service.Name)
+		}
+	}
+	return nil
+}
+
 func (t *http) Update(ctx context.Context) error {
 
-	def, request := ExtractAnnotationRequest(t.Service)
+	def, request := t.annotationRequest()
 	response, err := t.Client.DescribeLoadBalancerHTTPListenerAttribute(ctx, t.LoadBalancerID, int(t.Port))
 	if err != nil {
 		return err
@@ -1020,7 +1650,7 @@ func (t *http) Update(ctx context.Context) error {
 	utils.Logf(t.Service, "http listener %d status is %s.", t.Port, response.Status)
 	if response.Status == slb.Stopped {
 		if err = t.Client.StartLoadBalancerListener(ctx, t.LoadBalancerID, int(t.Port)); err != nil {
-			return fmt.Errorf("start http listener error: %s", err.Error())
+			return fmt.Errorf("start http listener error: %w", err)
 		}
 	}
 	config := &slb.SetLoadBalancerHTTPListenerAttributeArgs{
@@ -1029,12 +1659,12 @@ func (t *http) Update(ctx context.Context) error {
 		BackendServerPort: int(t.NodePort),
 		//Health Check
 		Scheduler:         slb.SchedulerType(response.Scheduler),
-		Bandwidth:         DEFAULT_LISTENER_BANDWIDTH,
+		Bandwidth:         t.bandwidth(),
 		StickySession:     response.StickySession,
 		StickySessionType: response.StickySessionType,
 		CookieTimeout:     response.CookieTimeout,
 		Cookie:            response.Cookie,
-		Description:       t.NamedKey.Key(),
+		Description:       t.marker(),
 		VServerGroup:      slb.OnFlag,
 		VServerGroupId:    t.findVgroup(t.NamedKey.Reference(t.NodePort)),
 
@@ -1050,8 +1680,20 @@ func (t *http) Update(ctx context.Context) error {
 		HealthCheckDomain:      response.HealthCheckDomain,
 		HealthCheckHttpCode:    response.HealthCheckHttpCode,
 		HealthCheckInterval:    response.HealthCheckInterval,
+		IdleTimeout:            response.IdleTimeout,
+		RequestTimeout:         response.RequestTimeout,
 	}
 	needUpdate := false
+	// a listener taken over is marked by the service
+	if response.Description != config.Description {
+		needUpdate = true
+	}
+	// the cap is lifted as well once the annotation is removed
+	if config.Bandwidth != response.Bandwidth &&
+		(config.Bandwidth != DEFAULT_LISTENER_BANDWIDTH || response.Bandwidth > 0) {
+		needUpdate = true
+		klog.V(2).Infof("listener %d [bandwidth] changed, request=%d. response=%d", t.Port, config.Bandwidth, response.Bandwidth)
+	}
 	needRecreate := false
 	/*
 		if request.Bandwidth != 0 &&
@@ -1075,15 +1717,19 @@ func (t *http) Update(ctx context.Context) error {
 		needUpdate = true
 		config.AclId = def.AclID
 	}
+	if detachSourceRangesAcl(ctx, t.Listener, request, response.AclStatus, response.AclId) {
+		needUpdate = true
+		config.AclStatus = string(slb.OffFlag)
+	}
 	if request.AclType != "" &&
 		def.AclType != response.AclType {
 		needUpdate = true
 		config.AclType = def.AclType
 	}
-	if request.Scheduler != "" &&
-		def.Scheduler != string(response.Scheduler) {
+	if scheduler := t.scheduler(); request.Scheduler != "" &&
+		scheduler != string(response.Scheduler) {
 		needUpdate = true
-		config.Scheduler = slb.SchedulerType(def.Scheduler)
+		config.Scheduler = slb.SchedulerType(scheduler)
 	}
 	// todo: perform healthcheck update.
 	if request.HealthCheck != "" &&
@@ -1141,30 +1787,46 @@ func (t *http) Update(ctx context.Context) error {
 		needUpdate = true
 		config.CookieTimeout = def.CookieTimeout
 	}
-	if request.HealthCheckHttpCode != "" &&
-		def.HealthCheckHttpCode != response.HealthCheckHttpCode {
+	idle, timeout := t.listenerTimeouts()
+	if idle != 0 && idle != response.IdleTimeout {
 		needUpdate = true
-		config.HealthCheckHttpCode = def.HealthCheckHttpCode
+		config.IdleTimeout = idle
 	}
-	if request.HealthCheckDomain != "" &&
-		def.HealthCheckDomain != response.HealthCheckDomain {
+	if timeout != 0 && timeout != response.RequestTimeout {
 		needUpdate = true
-		config.HealthCheckDomain = def.HealthCheckDomain
+		config.RequestTimeout = timeout
 	}
+	// toggling ListenerForward is only possible by recreating the listener.
+	forwardChanged := false
 	forward := forwardPort(def.ForwardPort, t.Port)
 	if forward != 0 {
-		if response.ListenerForward != slb.OnFlag {
+		if response.ListenerForward != slb.OnFlag ||
+			response.ForwardPort != int(forward) {
 			needRecreate = true
+			forwardChanged = true
 			config.ListenerForward = slb.OnFlag
 		}
+		config.VServerGroupId = ""
 	} else {
 		if response.ListenerForward != slb.OffFlag {
 			needRecreate = true
+			forwardChanged = true
 			config.ListenerForward = slb.OffFlag
 		}
 	}
 	config.ForwardPort = int(forward)
+	if updateHTTPHeaders(request, &response.HTTPListenerType, (*slb.HTTPListenerType)(config)) {
+		needUpdate = true
+	}
+	if t.localHTTPHealthCheck(request, (*slb.HTTPListenerType)(config)) {
+		needUpdate = true
+	}
+	if config.HealthCheck == slb.OnFlag &&
+		restoreHealthCheckMatch(def, request, &config.HealthCheckDomain, &config.HealthCheckHttpCode) {
+		needUpdate = true
+	}
 
+	clearStickySession((*slb.HTTPListenerType)(config))
 	// backend server port has changed.
 	if int(t.NodePort) != response.BackendServerPort {
 		// listener with listenerforward status on, no need to reRecreate
@@ -1193,13 +1855,36 @@ func (t *http) Update(ctx context.Context) error {
 				)
 			}
 		}
+		if forwardChanged {
+			record, err := utils.GetRecorderFromContext(ctx)
+			if err != nil {
+				klog.Warningf("get recorder error: %s", err.Error())
+			} else {
+				record.Eventf(
+					t.Service,
+					v1.EventTypeNormal,
+					"ListenerForwardChanged",
+					"Recreate HTTP listener %d to set listener forward %s, forward port %d",
+					t.Port, config.ListenerForward, config.ForwardPort,
+				)
+			}
+		}
 		err := t.Client.DeleteLoadBalancerListener(ctx, t.LoadBalancerID, int(t.Port))
 		if err != nil {
 			return err
 		}
 		err = t.Client.CreateLoadBalancerHTTPListener(ctx, (*slb.CreateLoadBalancerHTTPListenerArgs)(config))
 		if err != nil {
-			return err
+			// restore the previous listener, so that the port is not left without listener.
+			previous := slb.CreateLoadBalancerHTTPListenerArgs(response.HTTPListenerType)
+			previous.LoadBalancerId = t.LoadBalancerID
+			previous.ListenerPort = int(t.Port)
+			if rerr := t.Client.CreateLoadBalancerHTTPListener(ctx, &previous); rerr != nil {
+				utils.Logf(t.Service, "restore http listener %d error: %s", t.Port, rerr.Error())
+			} else if rerr := t.Client.StartLoadBalancerListener(ctx, t.LoadBalancerID, int(t.Port)); rerr != nil {
+				utils.Logf(t.Service, "start restored http listener %d error: %s", t.Port, rerr.Error())
+			}
+			return fmt.Errorf("recreate http listener %d: %w", t.Port, err)
 		}
 		return t.Client.StartLoadBalancerListener(ctx, t.LoadBalancerID, int(t.Port))
 	}
@@ -1221,6 +1906,77 @@ func (t *http) Update(ctx context.Context) error {
 	return t.Client.SetLoadBalancerHTTPListenerAttribute(ctx, config)
 }
 
+// clearStickySession drop the session attributes which do not apply to the
+// sticky session mode. Disabling stickiness clears all of them.
+func clearStickySession(l *slb.HTTPListenerType) {
+	if l.StickySession != slb.OnFlag {
+		l.StickySessionType = ""
+		l.Cookie = ""
+		l.CookieTimeout = 0
+		return
+	}
+	switch l.StickySessionType {
+	case slb.InsertStickySessionType:
+		l.Cookie = ""
+	case slb.ServerStickySessionType:
+		l.CookieTimeout = 0
+	}
+}
+
+// httpHeaders header & gzip switches of the listener, in the order of requestHTTPHeaders.
+func httpHeaders(l *slb.HTTPListenerType) []*slb.FlagType {
+	return []*slb.FlagType{&l.XForwardedFor, &l.XForwardedFor_proto, &l.XForwardedFor_SLBID, &l.XForwardedFor_SLBIP, &l.Gzip}
+}
+
+func requestHTTPHeaders(request *AnnotationRequest) []slb.FlagType {
+	return []slb.FlagType{request.XForwardedFor, request.XForwardedForProto, request.XForwardedForSLBID, request.XForwardedForSLBIP, request.Gzip}
+}
+
+// setHTTPHeaders set the annotated header & gzip switches on listener
+// creation. Others are left empty so that slb defaults apply.
+func setHTTPHeaders(request *AnnotationRequest, l *slb.HTTPListenerType) {
+	want := requestHTTPHeaders(request)
+	for i, flag := range httpHeaders(l) {
+		if want[i] != "" {
+			*flag = want[i]
+		}
+	}
+}
+
+// updateHTTPHeaders keep the current header & gzip switches in config and
+// replace the annotated ones which differ. Returns whether any has changed.
+func updateHTTPHeaders(request *AnnotationRequest, current, config *slb.HTTPListenerType) bool {
+	changed := false
+	want := requestHTTPHeaders(request)
+	cur := httpHeaders(current)
+	for i, flag := range httpHeaders(config) {
+		*flag = *cur[i]
+		if want[i] != "" && want[i] != *cur[i] {
+			*flag = want[i]
+			changed = true
+		}
+	}
+	return changed
+}
+
+// ValidateHTTPHeaders header & gzip switches must be on or off.
+func ValidateHTTPHeaders(service *v1.Service) error {
+	_, request := ExtractAnnotationRequest(service)
+	flags := requestHTTPHeaders(request)
+	for i, anno := range []string{
+		ServiceAnnotationLoadBalancerXForwardedFor,
+		ServiceAnnotationLoadBalancerXForwardedForProto,
+		ServiceAnnotationLoadBalancerXForwardedForSLBID,
+		ServiceAnnotationLoadBalancerXForwardedForSLBIP,
+		ServiceAnnotationLoadBalancerGzip,
+	} {
+		if flags[i] != "" && flags[i] != slb.OnFlag && flags[i] != slb.OffFlag {
+			return fmt.Errorf("annotation %s must be on or off, got %s", anno, flags[i])
+		}
+	}
+	return nil
+}
+
 type https struct{ *Listener }
 
 func (t *https) Describe(ctx context.Context) error {
@@ -1228,44 +1984,53 @@ func (t *https) Describe(ctx context.Context) error {
 }
 func (t *https) Add(ctx context.Context) error {
 
-	def, request := ExtractAnnotationRequest(t.Service)
-	return t.Client.CreateLoadBalancerHTTPSListener(
-		ctx,
-		&slb.CreateLoadBalancerHTTPSListenerArgs{
-			HTTPListenerType: slb.HTTPListenerType{
-				LoadBalancerId:    t.LoadBalancerID,
-				ListenerPort:      int(t.Port),
-				BackendServerPort: int(t.NodePort),
-				Description:       t.NamedKey.Key(),
-				VServerGroupId:    t.findVgroup(t.NamedKey.Reference(t.NodePort)),
-				AclType:           def.AclType,
-				AclStatus:         def.AclStatus,
-				AclId:             def.AclID,
-				//Health Check
-				Scheduler:         slb.SchedulerType(def.Scheduler),
-				HealthCheck:       def.HealthCheck,
-				Bandwidth:         DEFAULT_LISTENER_BANDWIDTH,
-				StickySession:     def.StickySession,
-				StickySessionType: def.StickySessionType,
-				Cookie:            def.Cookie,
-				CookieTimeout:     def.CookieTimeout,
-
-				HealthCheckURI:         def.HealthCheckURI,
-				HealthCheckConnectPort: def.HealthCheckConnectPort,
-				HealthyThreshold:       def.HealthyThreshold,
-				UnhealthyThreshold:     def.UnhealthyThreshold,
-				HealthCheckTimeout:     def.HealthCheckTimeout,
-				HealthCheckInterval:    def.HealthCheckInterval,
-				HealthCheckDomain:      def.HealthCheckDomain,
-				HealthCheckHttpCode:    def.HealthCheckHttpCode,
-			},
-			ServerCertificateId: request.CertID,
+	def, request := t.annotationRequest()
+	// the ecc certificate is bound once created, see EnsureEccCertificate
+	rsa, ecc := listenerCertIDs(request.CertID)
+	if err := t.checkCertificates(ctx, rsa, ecc); err != nil {
+		return err
+	}
+	idle, timeout := t.listenerTimeouts()
+	config := &slb.CreateLoadBalancerHTTPSListenerArgs{
+		HTTPListenerType: slb.HTTPListenerType{
+			LoadBalancerId:    t.LoadBalancerID,
+			ListenerPort:      int(t.Port),
+			BackendServerPort: int(t.NodePort),
+			Description:       t.marker(),
+			VServerGroupId:    t.findVgroup(t.NamedKey.Reference(t.NodePort)),
+			AclType:           def.AclType,
+			AclStatus:         def.AclStatus,
+			AclId:             def.AclID,
+			//Health Check
+			Scheduler:         slb.SchedulerType(t.scheduler()),
+			HealthCheck:       def.HealthCheck,
+			Bandwidth:         t.bandwidth(),
+			StickySession:     def.StickySession,
+			StickySessionType: def.StickySessionType,
+			Cookie:            def.Cookie,
+			CookieTimeout:     def.CookieTimeout,
+
+			HealthCheckURI:         def.HealthCheckURI,
+			HealthCheckConnectPort: def.HealthCheckConnectPort,
+			HealthyThreshold:       def.HealthyThreshold,
+			UnhealthyThreshold:     def.UnhealthyThreshold,
+			HealthCheckTimeout:     def.HealthCheckTimeout,
+			HealthCheckInterval:    def.HealthCheckInterval,
+			HealthCheckDomain:      def.HealthCheckDomain,
+			HealthCheckHttpCode:    def.HealthCheckHttpCode,
+			IdleTimeout:            idle,
+			RequestTimeout:         timeout,
 		},
-	)
+		ServerCertificateId: rsa,
+	}
+	clearStickySession(&config.HTTPListenerType)
+	setHTTPHeaders(request, &config.HTTPListenerType)
+	t.localHTTPHealthCheck(request, &config.HTTPListenerType)
+	return t.Client.CreateLoadBalancerHTTPSListener(ctx, config)
 }
 
 func (t *https) Update(ctx context.Context) error {
-	def, request := ExtractAnnotationRequest(t.Service)
+	def, request := t.annotationRequest()
 	response, err := t.Client.DescribeLoadBalancerHTTPSListenerAttribute(ctx, t.LoadBalancerID, int(t.Port))
 	if err != nil {
 		return err
@@ -1273,7 +2038,7 @@ func (t *https) Update(ctx context.Context) error {
 	utils.Logf(t.Service, "https listener %d status is %s.", t.Port, response.Status)
 	if response.Status == slb.Stopped {
 		if err = t.Client.StartLoadBalancerListener(ctx, t.LoadBalancerID, int(t.Port)); err != nil {
-			return fmt.Errorf("start https listener error: %s", err.Error())
+			return fmt.Errorf("start https listener error: %w", err)
 		}
 	}
 	config := &slb.SetLoadBalancerHTTPSListenerAttributeArgs{
@@ -1281,13 +2046,13 @@ func (t *https) Update(ctx context.Context) error {
 			LoadBalancerId:    t.LoadBalancerID,
 			ListenerPort:      response.ListenerPort,
 			BackendServerPort: response.BackendServerPort,
-			Description:       t.NamedKey.Key(),
+			Description:       t.marker(),
 			VServerGroup:      slb.OnFlag,
 			VServerGroupId:    t.findVgroup(t.NamedKey.Reference(t.NodePort)),
 			//Health Check
 			Scheduler:         slb.SchedulerType(response.Scheduler),
 			HealthCheck:       response.HealthCheck,
-			Bandwidth:         DEFAULT_LISTENER_BANDWIDTH,
+			Bandwidth:         t.bandwidth(),
 			StickySession:     response.StickySession,
 			StickySessionType: response.StickySessionType,
 			CookieTimeout:     response.CookieTimeout,
@@ -1304,11 +2069,23 @@ func (t *https) Update(ctx context.Context) error {
 			HealthCheckInterval:    response.HealthCheckInterval,
 			HealthCheckHttpCode:    response.HealthCheckHttpCode,
 			HealthCheckDomain:      response.HealthCheckDomain,
+			IdleTimeout:            response.IdleTimeout,
+			RequestTimeout:         response.RequestTimeout,
 		},
 		ServerCertificateId: response.ServerCertificateId,
 	}
 
 	needUpdate := false
+	// a listener taken over is marked by the service
+	if response.Description != config.Description {
+		needUpdate = true
+	}
+	// the cap is lifted as well once the annotation is removed
+	if config.Bandwidth != response.Bandwidth &&
+		(config.Bandwidth != DEFAULT_LISTENER_BANDWIDTH || response.Bandwidth > 0) {
+		needUpdate = true
+		klog.V(2).Infof("listener %d [bandwidth] changed, request=%d. response=%d", t.Port, config.Bandwidth, response.Bandwidth)
+	}
 	/*
 		if request.Bandwidth != 0 &&
 			request.Bandwidth != response.Bandwidth {
@@ -1332,15 +2109,19 @@ func (t *https) Update(ctx context.Context) error {
 		needUpdate = true
 		config.AclId = def.AclID
 	}
+	if detachSourceRangesAcl(ctx, t.Listener, request, response.AclStatus, response.AclId) {
+		needUpdate = true
+		config.AclStatus = string(slb.OffFlag)
+	}
 	if request.AclType != "" &&
 		def.AclType != response.AclType {
 		needUpdate = true
 		config.AclType = def.AclType
 	}
-	if request.Scheduler != "" &&
-		def.Scheduler != string(response.Scheduler) {
+	if scheduler := t.scheduler(); request.Scheduler != "" &&
+		scheduler != string(response.Scheduler) {
 		needUpdate = true
-		config.Scheduler = slb.SchedulerType(def.Scheduler)
+		config.Scheduler = slb.SchedulerType(scheduler)
 	}
 	if request.HealthCheck != "" &&
 		def.HealthCheck != response.HealthCheck {
@@ -1398,21 +2179,34 @@ func (t *https) Update(ctx context.Context) error {
 		needUpdate = true
 		config.CookieTimeout = def.CookieTimeout
 	}
-	if request.HealthCheckHttpCode != "" &&
-		def.HealthCheckHttpCode != response.HealthCheckHttpCode {
+	if rsa, _ := listenerCertIDs(def.CertID); request.CertID != "" &&
+		rsa != response.ServerCertificateId {
+		if err := t.checkCertificates(ctx, rsa); err != nil {
+			return err
+		}
 		needUpdate = true
-		config.HealthCheckHttpCode = def.HealthCheckHttpCode
+		config.ServerCertificateId = rsa
 	}
-	if request.HealthCheckDomain != "" &&
-		def.HealthCheckDomain != response.HealthCheckDomain {
+	idle, timeout := t.listenerTimeouts()
+	if idle != 0 && idle != response.IdleTimeout {
+		needUpdate = true
+		config.IdleTimeout = idle
+	}
+	if timeout != 0 && timeout != response.RequestTimeout {
+		needUpdate = true
+		config.RequestTimeout = timeout
+	}
+	if updateHTTPHeaders(request, &response.HTTPListenerType, &config.HTTPListenerType) {
+		needUpdate = true
+	}
+	if t.localHTTPHealthCheck(request, &config.HTTPListenerType) {
 		needUpdate = true
-		config.HealthCheckDomain = def.HealthCheckDomain
 	}
-	if request.CertID != "" &&
-		def.CertID != response.ServerCertificateId {
+	if config.HealthCheck == slb.OnFlag &&
+		restoreHealthCheckMatch(def, request, &config.HealthCheckDomain, &config.HealthCheckHttpCode) {
 		needUpdate = true
-		config.ServerCertificateId = def.CertID
 	}
+	clearStickySession(&config.HTTPListenerType)
 	// backend server port has changed.
 	if int(t.NodePort) != response.BackendServerPort {
 		config.BackendServerPort = int(t.NodePort)
@@ -20,9 +20,11 @@ import (
 	"context"
 	"fmt"
 	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
-	"k8s.io/klog"
 	"os"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"encoding/json"
@@ -45,6 +47,11 @@ type AnnotationRequest struct {
 	AclID          string
 	AclType        string
 	VswitchID      string
+
+	AccessLogProject  string
+	AccessLogLogstore string
+	AccessLogRegion   string
+
 	ForwardPort    string
 	SLBNetworkType string
 
@@ -53,10 +60,13 @@ type AnnotationRequest struct {
 	Bandwidth int
 	CertID    string
 
+	AdditionalCertIDs string
+
 	MasterZoneID string
 	SlaveZoneID  string
 
 	HealthCheck            slb.FlagType
+	HealthCheckSwitch      string // for tcp and udp
 	HealthCheckURI         string
 	HealthCheckConnectPort int
 	HealthyThreshold       int
@@ -68,9 +78,12 @@ type AnnotationRequest struct {
 	HealthCheckConnectTimeout int                 // for tcp
 	HealthCheckType           slb.HealthCheckType // for tcp, Type could be http tcp
 	HealthCheckTimeout        int                 // for https and http
+	HealthCheckReq            string              // for udp
+	HealthCheckExp            string              // for udp
 
-	LoadBalancerSpec slb.LoadBalancerSpecType
-	Scheduler        string
+	LoadBalancerSpec   slb.LoadBalancerSpecType
+	InstanceChargeType InstanceChargeType
+	Scheduler          string
 
 	StickySession      slb.FlagType
 	StickySessionType  slb.StickySessionType
@@ -79,6 +92,24 @@ type AnnotationRequest struct {
 	PersistenceTimeout *int
 	AddressIPVersion   slb.AddressIPVersionType
 
+	// IdleTimeout & RequestTimeout of http & https listeners, 0 if not set
+	IdleTimeout    int
+	RequestTimeout int
+
+	ConnectionDrain        string
+	ConnectionDrainTimeout int
+	// EstablishedTimeout of tcp listeners, 0 if not set
+	EstablishedTimeout int
+	// ProxyProtocol of tcp & udp listeners, on or off
+	ProxyProtocol string
+
+	// header & gzip switches of http and https listeners, slb defaults apply when empty
+	XForwardedFor      slb.FlagType
+	XForwardedForProto slb.FlagType
+	XForwardedForSLBID slb.FlagType
+	XForwardedForSLBIP slb.FlagType
+	Gzip               slb.FlagType
+
 	OverrideListeners string
 
 	PrivateZoneName       string
@@ -91,25 +122,45 @@ type AnnotationRequest struct {
 
 	DeleteProtection             slb.FlagType
 	ModificationProtectionStatus slb.ModificationProtectionType
+	ModificationProtectionReason string
 	ExternalIPType               string
+	EipId                        string
+	Hostname                     string
+	Address                      string
+	Weight                       int
+	WeightMode                   string
+	GracefulDrainSeconds         int
+	VGroupPort                   string
+	MaxBackends                  int
+	KeepLastBackends             string
+	LoadBalancerType             string
+	ZoneMaps                     string
+	ManagedPorts                 string
+	EmptyBackendsGuard           string
+	ForceDelete                  string
+	BandwidthPackageId           string
 }
 
 // TAGKEY Default tag key.
 const TAGKEY = "kubernetes.do.not.delete"
 const REUSEKEY = "kubernetes.reused.by.user"
 const ACKKEY = "ack.aliyun.com"
-const MDSKEY = "managed.by.ack"
 
 // ClientSLBSDK client sdk for slb
 type ClientSLBSDK interface {
 	DescribeLoadBalancers(ctx context.Context, args *slb.DescribeLoadBalancersArgs) (loadBalancers []slb.LoadBalancerType, err error)
+	DescribeLoadBalancersPage(ctx context.Context, args *DescribeLoadBalancersPageArgs) (loadBalancers []slb.LoadBalancerType, pagination *common.PaginationResult, err error)
 	CreateLoadBalancer(ctx context.Context, args *slb.CreateLoadBalancerArgs) (response *slb.CreateLoadBalancerResponse, err error)
+	CreateLoadBalancerWithAddress(ctx context.Context, args *CreateLoadBalancerWithAddressArgs) (response *slb.CreateLoadBalancerResponse, err error)
 	SetLoadBalancerName(ctx context.Context, loadBalancerId string, loadBalancerName string) (err error)
 	DeleteLoadBalancer(ctx context.Context, loadBalancerId string) (err error)
 	SetLoadBalancerDeleteProtection(ctx context.Context, args *slb.SetLoadBalancerDeleteProtectionArgs) (err error)
 	ModifyLoadBalancerInstanceSpec(ctx context.Context, args *slb.ModifyLoadBalancerInstanceSpecArgs) (err error)
 	ModifyLoadBalancerInternetSpec(ctx context.Context, args *slb.ModifyLoadBalancerInternetSpecArgs) (err error)
+	DescribeLoadBalancerInstanceChargeType(ctx context.Context, args *DescribeLoadBalancerInstanceChargeTypeArgs) (response *DescribeLoadBalancerInstanceChargeTypeResponse, err error)
+	ModifyLoadBalancerInstanceChargeType(ctx context.Context, args *ModifyLoadBalancerInstanceChargeTypeArgs) (err error)
 	DescribeLoadBalancerAttribute(ctx context.Context, loadBalancerId string) (loadBalancer *slb.LoadBalancerType, err error)
+	DescribeZones(ctx context.Context, args *DescribeZonesArgs) (zones []ZoneType, err error)
 	RemoveBackendServers(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) (result []slb.BackendServerType, err error)
 	AddBackendServers(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) (result []slb.BackendServerType, err error)
 	SetLoadBalancerModificationProtection(ctx context.Context, args *slb.SetLoadBalancerModificationProtectionArgs) (err error)
@@ -143,6 +194,31 @@ type ClientSLBSDK interface {
 	ModifyVServerGroupBackendServers(ctx context.Context, args *slb.ModifyVServerGroupBackendServersArgs) (response *slb.ModifyVServerGroupBackendServersResponse, err error)
 	AddVServerGroupBackendServers(ctx context.Context, args *slb.AddVServerGroupBackendServersArgs) (response *slb.AddVServerGroupBackendServersResponse, err error)
 	RemoveVServerGroupBackendServers(ctx context.Context, args *slb.RemoveVServerGroupBackendServersArgs) (response *slb.RemoveVServerGroupBackendServersResponse, err error)
+	DescribeHealthStatus(ctx context.Context, args *DescribeHealthStatusArgs) (response *DescribeHealthStatusResponse, err error)
+
+	DescribeServerCertificates(ctx context.Context, args *DescribeServerCertificatesArgs) (certificates []ServerCertificateType, err error)
+	UploadServerCertificate(ctx context.Context, args *UploadServerCertificateArgs) (response *UploadServerCertificateResponse, err error)
+	DeleteServerCertificate(ctx context.Context, args *DeleteServerCertificateArgs) (err error)
+	DescribeDomainExtensions(ctx context.Context, args *DescribeDomainExtensionsArgs) (extensions []DomainExtensionType, err error)
+	CreateDomainExtension(ctx context.Context, args *CreateDomainExtensionArgs) (response *CreateDomainExtensionResponse, err error)
+	DeleteDomainExtension(ctx context.Context, args *DeleteDomainExtensionArgs) (err error)
+
+	DescribeAccessControlListAttribute(ctx context.Context, args *DescribeAccessControlListAttributeArgs) (response *DescribeAccessControlListAttributeResponse, err error)
+	DescribeAccessControlLists(ctx context.Context, args *DescribeAccessControlListsArgs) (acls []AclType, err error)
+	CreateAccessControlList(ctx context.Context, args *CreateAccessControlListArgs) (response *CreateAccessControlListResponse, err error)
+	DeleteAccessControlList(ctx context.Context, args *DeleteAccessControlListArgs) (err error)
+	AddAccessControlListEntry(ctx context.Context, args *AccessControlListEntryArgs) (err error)
+	RemoveAccessControlListEntry(ctx context.Context, args *AccessControlListEntryArgs) (err error)
+
+	DescribeAccessLogsDownloadAttribute(ctx context.Context, args *DescribeAccessLogsDownloadAttributeArgs) (attrs []AccessLogAttribute, err error)
+	SetAccessLogsDownloadAttribute(ctx context.Context, args *AccessLogsDownloadAttributeArgs) (err error)
+	DeleteAccessLogsDownloadAttribute(ctx context.Context, args *AccessLogsDownloadAttributeArgs) (err error)
+
+	DescribeListenerExtension(ctx context.Context, proto string, args *DescribeListenerExtensionArgs) (extension *ListenerExtension, err error)
+	SetListenerExtension(ctx context.Context, proto string, args *SetListenerExtensionArgs) (err error)
+
+	DescribeHTTPSListenerCertificates(ctx context.Context, args *DescribeListenerExtensionArgs) (certificates *ListenerCertificates, err error)
+	SetHTTPSListenerCertificates(ctx context.Context, args *SetListenerCertificatesArgs) (err error)
 }
 
 // LoadBalancerClient slb client wrapper
@@ -152,6 +228,8 @@ type LoadBalancerClient struct {
 	c      ClientSLBSDK
 	// known service resource version
 	ins ClientInstanceSDK
+	// vpc client to describe vswitches
+	vpc RouteSDK
 }
 
 func (s *LoadBalancerClient) FindLoadBalancer(ctx context.Context, service *v1.Service) (bool, *slb.LoadBalancerType, error) {
@@ -174,12 +252,21 @@ func (s *LoadBalancerClient) FindLoadBalancerByID(ctx context.Context, lbid stri
 	return err == nil, lb, err
 }
 
+// FindLoadBalancerByTags find the loadbalancer of the service by the ownership
+// tags, the ones created before the tags are stamped by name.
 func (s *LoadBalancerClient) FindLoadBalancerByTags(ctx context.Context, service *v1.Service) (bool, *slb.LoadBalancerType, error) {
 	if service.UID == "" {
 		return false, nil, fmt.Errorf("unexpected empty service uid")
 	}
+	// the cluster id and the service uid go first
+	exists, lb, err := s.FindLoadBalancerByUID(ctx, service)
+	if err != nil || exists {
+		return exists, lb, err
+	}
 	lbn := GetLoadBalancerName(service)
-	items, err := json.Marshal(
+	lbs, items, err := describeLoadBalancersByTags(
+		ctx,
+		s.c,
 		[]slb.TagItem{
 			{
 				TagKey:   TAGKEY,
@@ -187,43 +274,78 @@ func (s *LoadBalancerClient) FindLoadBalancerByTags(ctx context.Context, service
 			},
 		},
 	)
+	utils.FromContext(ctx).V(utils.LOG_SYNC_VERBOSITY).Info("Find loadbalancer by tags", "tags", items)
 	if err != nil {
 		return false, nil, err
 	}
-	lbs, err := s.c.DescribeLoadBalancers(
+
+	if len(lbs) == 0 {
+		// here we need to fallback on finding by name for compatible reason
+		// the old service slb may not have a tag.
+		exists, lb, err = s.FindLoadBalancerByName(ctx, service, lbn)
+		if err != nil || exists {
+			return exists, lb, err
+		}
+		if name := serviceAnnotation(service, ServiceAnnotationLoadBalancerName); name != "" {
+			return s.FindOwnedLoadBalancerByName(ctx, service, name)
+		}
+		return false, nil, nil
+	}
+	if len(lbs) > 1 {
+		utils.FromContext(ctx).Warning("Multiple loadbalancers found by tags, using the first one",
+			"tags", items, utils.LogKeySLBID, lbs[0].LoadBalancerId, "address", lbs[0].Address)
+		recordDuplicateLoadBalancersFromContext(ctx, service, lbs)
+	}
+	// the loadbalancer may be deleted since listed
+	return s.FindLoadBalancerByID(ctx, lbs[0].LoadBalancerId)
+}
+
+// FindLoadBalancerByUID find the loadbalancer created for the service by the
+// uid ownership tag of the cluster
+func (s *LoadBalancerClient) FindLoadBalancerByUID(ctx context.Context, service *v1.Service) (bool, *slb.LoadBalancerType, error) {
+	lbs, items, err := describeLoadBalancersByTags(
 		ctx,
-		&slb.DescribeLoadBalancersArgs{
-			Tags:     string(items),
-			RegionId: DEFAULT_REGION,
+		s.c,
+		[]slb.TagItem{
+			{
+				TagKey:   ACKKEY,
+				TagValue: CLUSTER_ID,
+			},
+			{
+				TagKey:   SERVICEUIDKEY,
+				TagValue: string(service.UID),
+			},
 		},
 	)
-	utils.Logf(service, "alicloud: fallback to find loadbalancer by tags [%s]", string(items))
+	utils.FromContext(ctx).V(utils.LOG_SYNC_VERBOSITY).Info("Find loadbalancer by uid", "tags", items)
 	if err != nil {
 		return false, nil, err
 	}
-
 	if len(lbs) == 0 {
-		// here we need to fallback on finding by name for compatible reason
-		// the old service slb may not have a tag.
-		return s.FindLoadBalancerByName(ctx, lbn)
+		return false, nil, nil
 	}
 	if len(lbs) > 1 {
-		utils.Logf(service, "Warning: multiple loadbalancer returned with tags [%s], "+
-			"using the first one with IP=%s", string(items), lbs[0].Address)
+		utils.FromContext(ctx).Warning("Multiple loadbalancers found by uid, using the first one",
+			"tags", items, utils.LogKeySLBID, lbs[0].LoadBalancerId, "address", lbs[0].Address)
+		recordDuplicateLoadBalancersFromContext(ctx, service, lbs)
 	}
-	lb, err := s.c.DescribeLoadBalancerAttribute(ctx, lbs[0].LoadBalancerId)
-	return err == nil, lb, err
+	// the loadbalancer may be deleted since listed
+	return s.FindLoadBalancerByID(ctx, lbs[0].LoadBalancerId)
 }
 
-func (s *LoadBalancerClient) FindLoadBalancerByName(ctx context.Context, name string) (bool, *slb.LoadBalancerType, error) {
-	lbs, err := s.c.DescribeLoadBalancers(
+// FindLoadBalancerByName find the loadbalancer of the service by name. Of
+// more than one loadbalancer of the name, the one tagged for the service is
+// preferred and the rest are warned of.
+func (s *LoadBalancerClient) FindLoadBalancerByName(ctx context.Context, service *v1.Service, name string) (bool, *slb.LoadBalancerType, error) {
+	lbs, err := describeAllLoadBalancers(
 		ctx,
-		&slb.DescribeLoadBalancersArgs{
+		s.c,
+		DescribeLoadBalancersPageArgs{
 			RegionId:         DEFAULT_REGION,
 			LoadBalancerName: name,
 		},
 	)
-	klog.V(2).Infof("fallback to find loadbalancer by name [%s]", name)
+	utils.FromContext(ctx).V(utils.LOG_SYNC_VERBOSITY).Info("Find loadbalancer by name", "name", name)
 	if err != nil {
 		return false, nil, err
 	}
@@ -231,12 +353,30 @@ func (s *LoadBalancerClient) FindLoadBalancerByName(ctx context.Context, name st
 	if len(lbs) == 0 {
 		return false, nil, nil
 	}
+	found := lbs[0]
 	if len(lbs) > 1 {
-		klog.Warningf("alicloud: multiple loadbalancer returned with name [%s], "+
-			"using the first one with IP=%s", name, lbs[0].Address)
+		for i := range lbs {
+			tags, _, err := s.c.DescribeTags(
+				ctx,
+				&slb.DescribeTagsArgs{
+					RegionId:       lbs[i].RegionId,
+					LoadBalancerID: lbs[i].LoadBalancerId,
+				},
+			)
+			if err != nil {
+				return false, nil, fmt.Errorf("describe tags of loadbalancer %s: %s", lbs[i].LoadBalancerId, err.Error())
+			}
+			if owned, _ := isLoadBalancerOwned(tags, &lbs[i], service); owned && hasOwnershipTag(tags) {
+				found = lbs[i]
+				break
+			}
+		}
+		utils.FromContext(ctx).Warning("Multiple loadbalancers found by name, using the tagged one if any",
+			"name", name, utils.LogKeySLBID, found.LoadBalancerId, "address", found.Address)
+		recordDuplicateLoadBalancersFromContext(ctx, service, lbs)
 	}
-	lb, err := s.c.DescribeLoadBalancerAttribute(ctx, lbs[0].LoadBalancerId)
-	return err == nil, lb, err
+	// the loadbalancer may be deleted since listed
+	return s.FindLoadBalancerByID(ctx, found.LoadBalancerId)
 }
 
 // getLoadBalancerAdditionalTags converts the comma separated list of key-value
@@ -268,6 +408,97 @@ func getLoadBalancerAdditionalTags(annotations map[string]string) map[string]str
 	return additionalTags
 }
 
+// ADDITIONALTAGSKEY tag telling the keys of the additional-tags annotation
+// applied to the loadbalancer, only those are removed with the annotation.
+const ADDITIONALTAGSKEY = "kubernetes.additional.tags"
+
+// isOwnershipTag tags added by the cloudprovider to tell the owner of the
+// loadbalancer, they can not be overridden by the additional-tags annotation.
+func isOwnershipTag(key string) bool {
+	switch key {
+	case TAGKEY, ACKKEY, REUSEKEY, EIPKEY, BANDWIDTHPACKAGEKEY, ADDITIONALTAGSKEY,
+		SERVICENAMESPACEKEY, SERVICENAMEKEY, SERVICEUIDKEY:
+		return true
+	}
+	return false
+}
+
+// additionalTags tags of the additional-tags annotation except the ownership ones
+func additionalTags(service *v1.Service) map[string]string {
+	tags := getLoadBalancerAdditionalTags(getBackwardsCompatibleAnnotation(service.Annotations))
+	for key := range tags {
+		if isOwnershipTag(key) {
+			delete(tags, key)
+		}
+	}
+	return tags
+}
+
+func additionalTagKeys(tags map[string]string) string {
+	var keys []string
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// ensureAdditionalTags converge the tags of lb to the additional-tags
+// annotation. Tags neither in the annotation nor applied by it before are
+// managed by the user and left alone.
+func ensureAdditionalTags(ctx context.Context, client ClientSLBSDK,
+	service *v1.Service, lb *slb.LoadBalancerType, tags []slb.TagItemType) error {
+	desired := additionalTags(service)
+	current := make(map[string]string)
+	for _, tag := range tags {
+		current[tag.TagKey] = tag.TagValue
+	}
+
+	add := make(map[string]string)
+	var remove []slb.TagItem
+	for key, value := range desired {
+		old, ok := current[key]
+		if ok && old == value {
+			continue
+		}
+		if ok {
+			remove = append(remove, slb.TagItem{TagKey: key, TagValue: old})
+		}
+		add[key] = value
+	}
+	applied, tracked := current[ADDITIONALTAGSKEY]
+	for _, key := range strings.Split(applied, ",") {
+		if _, ok := desired[key]; ok || isOwnershipTag(key) {
+			continue
+		}
+		if value, ok := current[key]; ok {
+			remove = append(remove, slb.TagItem{TagKey: key, TagValue: value})
+		}
+	}
+	if keys := additionalTagKeys(desired); keys != applied {
+		if tracked {
+			remove = append(remove, slb.TagItem{TagKey: ADDITIONALTAGSKEY, TagValue: applied})
+		}
+		if keys != "" {
+			add[ADDITIONALTAGSKEY] = keys
+		}
+	}
+
+	if len(remove) > 0 {
+		utils.FromContext(ctx).Info("Remove tags from loadbalancer", utils.LogKeySLBID, lb.LoadBalancerId, "tags", remove)
+		if err := removeSLBTag(client, ctx, remove, lb.RegionId, lb.LoadBalancerId); err != nil {
+			return err
+		}
+	}
+	if len(add) > 0 {
+		utils.FromContext(ctx).Info("Add tags to loadbalancer", utils.LogKeySLBID, lb.LoadBalancerId, "tags", add)
+		if err := addSLBTag(client, ctx, add, lb.RegionId, lb.LoadBalancerId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func equalsAddressIPVersion(request, origined slb.AddressIPVersionType) bool {
 	if request == "" {
 		request = slb.IPv4
@@ -281,14 +512,58 @@ func equalsAddressIPVersion(request, origined slb.AddressIPVersionType) bool {
 
 // EnsureLoadBalancer make sure slb is reconciled nodes []*v1.Node
 func (s *LoadBalancerClient) EnsureLoadBalancer(ctx context.Context, service *v1.Service, nodes *EndpointWithENI, vswitchid string) (*slb.LoadBalancerType, error) {
-	utils.Logf(service, "ensure loadbalancer with service details, \n%+v", PrettyJson(service))
+	// the loadbalancer is described once for the sync, see SLBSnapshot
+	ctx = WithSLBSnapshot(ctx)
+	s = s.withSnapshot(ctx)
+	base := utils.FromContext(ctx)
+	log := base
+	log.V(utils.LOG_SYNC_VERBOSITY).Info("Ensure loadbalancer", "object", utils.Diagnostic(service))
 
 	exists, origined, err := s.FindLoadBalancer(ctx, service)
 	if err != nil {
 		return nil, err
 	}
-	utils.Logf(service, "find loadbalancer with result, exist=%v, %s\n", exists, PrettyJson(origined))
+	if exists {
+		// the entries of the sync below carry the id of the loadbalancer
+		log = base.WithValues(utils.LogKeySLBID, origined.LoadBalancerId)
+		ctx = utils.WithLogger(ctx, log)
+	}
+	log.V(utils.LOG_SYNC_VERBOSITY).Info("Found loadbalancer", "exists", exists, "loadbalancer", utils.Diagnostic(origined))
 	_, request := ExtractAnnotationRequest(service)
+	if isIPFamilyMismatched(service, request) {
+		recordIPFamilyMismatch(ctx, service, request)
+	}
+
+	// zones and address type can not be modified, the loadbalancer is recreated when asked for
+	recreateZones := exists && isZonesChanged(request, origined) && isForceRecreateZones(service)
+	recreateAddressType := exists && isAddressTypeChanged(request, origined) && isRecreateOnAddressTypeChange(service)
+	recreate := recreateZones || recreateAddressType
+	if !exists || recreate {
+		if err := ValidateZones(ctx, s.c, service); err != nil {
+			recordInvalidAnnotation(ctx, service, err)
+			return nil, err
+		}
+		if err := s.ValidateVSwitch(ctx, service, vswitchid); err != nil {
+			return nil, err
+		}
+	}
+	if recreate {
+		if recreateZones {
+			log.Info("Zones changed, recreate loadbalancer",
+				"from", []string{origined.MasterZoneId, origined.SlaveZoneId},
+				"to", []string{request.MasterZoneID, request.SlaveZoneID})
+		}
+		if recreateAddressType {
+			log.Info("Address type changed, recreate loadbalancer",
+				"from", origined.AddressType, "to", request.AddressType)
+		}
+		recreated, err := s.recreateLoadBalancer(ctx, service, origined, request)
+		if err != nil {
+			return nil, err
+		}
+		// a refused loadbalancer is synced as it is
+		recreate, exists = recreated, !recreated
+	}
 
 	var derr error
 	serviceHashChanged := true
@@ -296,11 +571,11 @@ func (s *LoadBalancerClient) EnsureLoadBalancer(ctx context.Context, service *v1
 	if !exists {
 		// If need created, double check if the resource id has been deleted
 		if isServiceDeleted(service) {
-			klog.V(2).Infof("alicloud: isServiceDeleted report that this service has been " +
-				"deleted before. see issue: https://github.com/kubernetes/kubernetes/issues/59084")
+			log.Error(nil, "Service has been deleted before, "+
+				"see issue: https://github.com/kubernetes/kubernetes/issues/59084")
 			os.Exit(1)
 		}
-		if isLoadbalancerOwnIngress(service) {
+		if isLoadbalancerOwnIngress(service) && !recreate {
 			return nil, fmt.Errorf("alicloud: not able to find loadbalancer "+
 				"named [%s] in openapi, but it's defined in service.loaderbalancer.ingress. "+
 				"this may happen when you removed loadbalancerid annotation", service.Name)
@@ -311,25 +586,53 @@ func (s *LoadBalancerClient) EnsureLoadBalancer(ctx context.Context, service *v1
 		}
 
 		// From here, we need to create a new loadbalancer
-		klog.V(5).Infof("alicloud: can not find a "+
-			"loadbalancer with service name [%s/%s], creating a new one", service.Namespace, service.Name)
+		if request.LoadBalancerName != "" {
+			conflict, reason, err := loadBalancerNameConflict(ctx, s.c, service, request.LoadBalancerName, "")
+			if err != nil {
+				return nil, err
+			}
+			if conflict != nil {
+				recordLoadBalancerNameConflict(ctx, service, request.LoadBalancerName, conflict, reason)
+				return nil, fmt.Errorf("alicloud: loadbalancer name %s is taken by loadbalancer %s "+
+					"not owned by the service, %s", request.LoadBalancerName, conflict.LoadBalancerId, reason)
+			}
+		}
+		log.Info("Loadbalancer not found, creating a new one")
 		opts := s.getLoadBalancerOpts(service, vswitchid)
-		lbr, err := s.c.CreateLoadBalancer(ctx, opts)
+		if opts.InternetChargeType == slb.PayByBandwidth {
+			if opts.Bandwidth, err = createBandwidth(ctx, service); err != nil {
+				return nil, err
+			}
+		}
+		opts.ClientToken = loadBalancerClientToken(service, opts)
+		lbr, err := createLoadBalancer(ctx, s.c, service, opts)
 		if err != nil {
 			return nil, err
 		}
+		log = base.WithValues(utils.LogKeySLBID, lbr.LoadBalancerId)
+		ctx = utils.WithLogger(ctx, log)
+		log.Info("Created loadbalancer")
 
 		//deal with loadBalancer tags
-		tags := getLoadBalancerAdditionalTags(getBackwardsCompatibleAnnotation(service.Annotations))
-		loadbalancerName := GetLoadBalancerName(service)
-		// Add default tags
-		tags[TAGKEY] = loadbalancerName
-		tags[ACKKEY] = CLUSTER_ID
+		tags := additionalTags(service)
+		if keys := additionalTagKeys(tags); keys != "" {
+			tags[ADDITIONALTAGSKEY] = keys
+		}
+		// Add ownership tags, the user specified loadbalancer never carries them
+		for key, value := range ownershipTags(service) {
+			tags[key] = value
+		}
 		if err := addSLBTag(s.c, ctx, tags, opts.RegionId, lbr.LoadBalancerId); err != nil {
 			return nil, err
 		}
 
+		forgetClientToken(service)
+
 		origined, derr = s.c.DescribeLoadBalancerAttribute(ctx, lbr.LoadBalancerId)
+		if derr == nil {
+			recordZonesSelected(ctx, service, origined)
+			derr = ensureInstanceChargeType(ctx, s.c, origined, service, request)
+		}
 	} else {
 		// Need to verify loadbalancer.
 		// Reuse SLB is not allowed when the SLB is created by k8s service.
@@ -363,10 +666,26 @@ func (s *LoadBalancerClient) EnsureLoadBalancer(ctx context.Context, service *v1
 			return origined, fmt.Errorf("alicloud: the loadbalancer %s can not be reused, %s", origined.LoadBalancerId, reason)
 		}
 
+		// the address and vswitch can not be modified once created
+		recordAddressMismatch(ctx, service, origined)
+		recordVSwitchChanged(ctx, service, origined, request)
+
+		// delete protection is checked on every sync to restore out-of-band changes
+		if err := ensureDeleteProtection(ctx, s.c, origined, service, request); err != nil {
+			return origined, err
+		}
+		if err := ensureTemplatedName(ctx, s.c, origined, service, tags); err != nil {
+			return origined, err
+		}
+
 		serviceHashChanged, err = utils.IsServiceHashChanged(service)
 		if err != nil {
 			return origined, fmt.Errorf("compute svc hash error :%s", err.Error())
 		}
+		if !serviceHashChanged && utils.IsForceResync(ctx) {
+			log.Info("Reconcile requested, sync the unchanged service fully")
+			serviceHashChanged = true
+		}
 		if serviceHashChanged {
 			if err := updateLoadBalancerByAnnotations(ctx, s.c, origined, service, request, tags); err != nil {
 				return origined, err
@@ -375,31 +694,236 @@ func (s *LoadBalancerClient) EnsureLoadBalancer(ctx context.Context, service *v1
 		}
 	}
 	if derr != nil {
-		utils.Logf(service, "alicloud: can not get loadbalancer attribute. ")
+		log.Error(derr, "Can not get loadbalancer attribute")
 		return nil, derr
 	}
+	from, transition := formerTrafficPolicy(ctx, service)
+	transition = transition && exists
+	if transition {
+		// the backends and the health checks of the listeners are switched together
+		log.Info("External traffic policy changed, rebuild the backends and the listeners",
+			"from", from, "to", service.Spec.ExternalTrafficPolicy)
+		serviceHashChanged = true
+	}
 	vgs := BuildVirtualGroupFromService(s, service, origined)
 
 	// Make sure virtual server backend group has been updated.
-	if err := EnsureVirtualGroups(ctx, vgs, nodes); err != nil {
-		return origined, fmt.Errorf("update backend servers: error %s", err.Error())
+	err = EnsureVirtualGroups(ctx, vgs, nodes)
+	recordSkippedENI(ctx, service, nodes)
+	recordBackendUpdateSkipped(ctx, service, vgs)
+	if err != nil {
+		return origined, fmt.Errorf("update backend servers: error %w", err)
 	}
+	utils.PublishBackends(ctx, vgs.backendCount())
+	utils.PublishBackendZones(ctx, vgs.backendZones(nodes.Nodes))
+	utils.PublishBackendNodes(ctx, vgs.backendNodes(nodes.Nodes))
 	// Apply listener when
 	//   1. user does not assign loadbalancer id by themselves.
 	//   2. force-override-listener annotation is set.
+	//   3. managed-ports annotation is set.
+	manageListeners := (!isUserDefinedLoadBalancer(service)) ||
+		(isUserDefinedLoadBalancer(service) && (isOverrideListeners(service) || hasManagedPorts(service)))
 	if serviceHashChanged {
-		if (!isUserDefinedLoadBalancer(service)) ||
-			(isUserDefinedLoadBalancer(service) && isOverrideListeners(service)) {
-			utils.Logf(service, "not user defined loadbalancer[%s], start to apply listener.", origined.LoadBalancerId)
+		if manageListeners {
+			log.V(utils.LOG_SYNC_VERBOSITY).Info("Apply listeners")
 			// If listener update is needed. Switch to vserver group immediately.
 			// No longer update default backend servers.
 			if err := EnsureListeners(ctx, s, service, origined, vgs); err != nil {
 
-				return origined, fmt.Errorf("ensure listener error: %s", err.Error())
+				return origined, fmt.Errorf("ensure listener error: %w", err)
 			}
 		}
+		if err := EnsureAccessLog(ctx, s.c, service, origined); err != nil {
+			return origined, fmt.Errorf("ensure access log error: %w", err)
+		}
+	}
+	if err := s.UpdateLoadBalancer(ctx, service, nodes, false); err != nil {
+		return origined, err
+	}
+	if manageListeners {
+		cleanupOrphans(ctx, s, service, origined, vgs)
+	}
+	if transition {
+		recordTrafficPolicyChanged(ctx, service, from, vgs)
+	}
+	return origined, nil
+}
+
+// ensureInternetSpec modify the charge type and bandwidth in place when they
+// differ from the annotations. Intranet loadbalancers have neither of them,
+// which is reported by an event instead of failing the sync.
+func ensureInternetSpec(ctx context.Context, slbClient ClientSLBSDK, lb *slb.LoadBalancerType,
+	service *v1.Service, request *AnnotationRequest) error {
+	charge, bandwidth, err := internetSpec(lb, service, request)
+	if err != nil {
+		recordInvalidAnnotation(ctx, service, err)
+		return err
+	}
+	if charge == lb.InternetChargeType && bandwidth == lb.Bandwidth {
+		return nil
+	}
+	if lb.AddressType != slb.InternetAddressType {
+		record, err := utils.GetRecorderFromContext(ctx)
+		if err != nil {
+			utils.FromContext(ctx).Warning("Only internet loadbalancer is allowed to modify bandwidth and pay type",
+				utils.LogKeySLBID, lb.LoadBalancerId, "err", err)
+			return nil
+		}
+		record.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"ModifyInternetSpecUnsupported",
+			"Charge type and bandwidth of %s loadbalancer %s can not be modified, only internet loadbalancers support them",
+			lb.AddressType, lb.LoadBalancerId,
+		)
+		return nil
+	}
+	if request.Bandwidth != 0 && charge == slb.PayByBandwidth && bandwidth != request.Bandwidth {
+		recordBandwidthClamped(ctx, service, internetSpecType(lb, request), request.Bandwidth, bandwidth)
+	}
+	utils.FromContext(ctx).Info("Internet spec changed, update loadbalancer", utils.LogKeySLBID, lb.LoadBalancerId,
+		"fromChargeType", lb.InternetChargeType, "fromBandwidth", lb.Bandwidth,
+		"toChargeType", charge, "toBandwidth", bandwidth)
+	return slbClient.ModifyLoadBalancerInternetSpec(
+		ctx,
+		&slb.ModifyLoadBalancerInternetSpecArgs{
+			LoadBalancerId:     lb.LoadBalancerId,
+			InternetChargeType: charge,
+			Bandwidth:          bandwidth,
+		},
+	)
+}
+
+// internetSpec the charge type and the bandwidth of the loadbalancer once the
+// annotations are applied by ensureInternetSpec. A paybybandwidth bandwidth
+// changed is fit in the range of the spec, see fitBandwidth.
+func internetSpec(lb *slb.LoadBalancerType, service *v1.Service, request *AnnotationRequest) (slb.InternetChargeType, int, error) {
+	charge, bandwidth := lb.InternetChargeType, lb.Bandwidth
+	if request.ChargeType != "" {
+		charge = request.ChargeType
+	}
+	if charge != slb.PayByBandwidth {
+		return charge, bandwidth, nil
+	}
+	switch {
+	case request.Bandwidth != 0:
+		bandwidth = request.Bandwidth
+	case lb.InternetChargeType != slb.PayByBandwidth:
+		// the bandwidth of paybytraffic is only a cap set at creation, not
+		// one to pay for
+		bandwidth = DEFAULT_BANDWIDTH
+	}
+	if charge == lb.InternetChargeType && bandwidth == lb.Bandwidth {
+		return charge, bandwidth, nil
+	}
+	bandwidth, err := fitBandwidth(service, internetSpecType(lb, request), bandwidth)
+	return charge, bandwidth, err
+}
+
+// internetSpecType the spec of the loadbalancer once the annotations are applied
+func internetSpecType(lb *slb.LoadBalancerType, request *AnnotationRequest) slb.LoadBalancerSpecType {
+	if request.LoadBalancerSpec != "" {
+		return request.LoadBalancerSpec
+	}
+	return lb.LoadBalancerSpec
+}
+
+// ValidateInternetSpec charge type and bandwidth are checked before they are
+// sent to the api.
+func ValidateInternetSpec(service *v1.Service) error {
+	charge := serviceAnnotation(service, ServiceAnnotationLoadBalancerChargeType)
+	switch slb.InternetChargeType(charge) {
+	case "", slb.PayByTraffic, slb.PayByBandwidth:
+	default:
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerChargeType,
+			token:      charge,
+			reason:     fmt.Sprintf("must be one of %s, %s", slb.PayByTraffic, slb.PayByBandwidth),
+		}
+	}
+	bandwidth := serviceAnnotation(service, ServiceAnnotationLoadBalancerBandwidth)
+	if bandwidth == "" {
+		return nil
+	}
+	if i, err := strconv.Atoi(bandwidth); err != nil || i < MIN_BANDWIDTH || i > MAX_BANDWIDTH {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerBandwidth,
+			token:      bandwidth,
+			reason:     fmt.Sprintf("must be an integer in range [%d, %d] Mbps", MIN_BANDWIDTH, MAX_BANDWIDTH),
+		}
 	}
-	return origined, s.UpdateLoadBalancer(ctx, service, nodes, false)
+	// the spec of a loadbalancer not annotated is known once it is described
+	spec := serviceAnnotation(service, ServiceAnnotationLoadBalancerSpec)
+	if slb.InternetChargeType(charge) == slb.PayByBandwidth && spec != "" {
+		i, _ := strconv.Atoi(bandwidth)
+		if _, err := fitBandwidth(service, slb.LoadBalancerSpecType(spec), i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MODIFICATION_PROTECTION_REASON_MAX max length of the modification protection reason
+const MODIFICATION_PROTECTION_REASON_MAX = 80
+
+// the reason starts with a letter and contains letters, digits, '.', '_' and '-' only
+var modificationProtectionReasonRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9._-]*$`)
+
+// defaultModificationProtectionReason tell who protects the loadbalancer in the console
+func defaultModificationProtectionReason(service *v1.Service) string {
+	reason := fmt.Sprintf("managed.by.ccm.for.service.%s.%s", service.Namespace, service.Name)
+	if len(reason) > MODIFICATION_PROTECTION_REASON_MAX {
+		reason = reason[:MODIFICATION_PROTECTION_REASON_MAX]
+	}
+	return reason
+}
+
+// modificationProtectionReason the reason is only set along with ConsoleProtection.
+func modificationProtectionReason(service *v1.Service, status slb.ModificationProtectionType) string {
+	if status != slb.ConsoleProtection {
+		return ""
+	}
+	defaulted, _ := ExtractAnnotationRequest(service)
+	return defaulted.ModificationProtectionReason
+}
+
+// ValidateModificationProtection the reason is rejected by the api unless it is valid.
+func ValidateModificationProtection(service *v1.Service) error {
+	reason := serviceAnnotation(service, ServiceAnnotationLoadBalancerModificationProtectionReason)
+	if reason == "" {
+		return nil
+	}
+	if len(reason) > MODIFICATION_PROTECTION_REASON_MAX || !modificationProtectionReasonRegexp.MatchString(reason) {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerModificationProtectionReason,
+			token:      reason,
+			reason: fmt.Sprintf("must start with a letter, contain letters, digits, '.', '_' and '-' only "+
+				"and be at most %d characters", MODIFICATION_PROTECTION_REASON_MAX),
+		}
+	}
+	return nil
+}
+
+// ensureDeleteProtection restore the delete protection of the annotation.
+func ensureDeleteProtection(ctx context.Context, slbClient ClientSLBSDK, lb *slb.LoadBalancerType,
+	service *v1.Service, request *AnnotationRequest) error {
+	if request.DeleteProtection == "" || request.DeleteProtection == lb.DeleteProtection {
+		return nil
+	}
+	utils.FromContext(ctx).Info("Delete protection changed, update loadbalancer", utils.LogKeySLBID, lb.LoadBalancerId,
+		"from", lb.DeleteProtection, "to", request.DeleteProtection)
+	if err := slbClient.SetLoadBalancerDeleteProtection(
+		ctx,
+		&slb.SetLoadBalancerDeleteProtectionArgs{
+			RegionId:         lb.RegionId,
+			LoadBalancerId:   lb.LoadBalancerId,
+			DeleteProtection: request.DeleteProtection,
+		},
+	); err != nil {
+		return err
+	}
+	lb.DeleteProtection = request.DeleteProtection
+	return nil
 }
 
 func isLoadBalancerNonReusable(tags []slb.TagItemType, service *v1.Service) (bool, string) {
@@ -423,127 +947,97 @@ func isLoadBalancerHasTag(tags []slb.TagItemType) bool {
 
 func updateLoadBalancerByAnnotations(context context.Context, slbClient ClientSLBSDK, lb *slb.LoadBalancerType,
 	service *v1.Service, request *AnnotationRequest, tags []slb.TagItemType) error {
-	klog.V(5).Infof("alicloud: found "+
-		"an exist loadbalancer[%s], check to see whether update is needed.", lb.LoadBalancerId)
+	log := utils.FromContext(context)
+	log.V(utils.LOG_SYNC_VERBOSITY).Info("Check whether the loadbalancer needs update", utils.LogKeySLBID, lb.LoadBalancerId)
 
-	if request.MasterZoneID != "" && request.MasterZoneID != lb.MasterZoneId {
-		return fmt.Errorf("alicloud: can not change LoadBalancer master zone id once created")
-	}
-	if request.SlaveZoneID != "" && request.SlaveZoneID != lb.SlaveZoneId {
-		return fmt.Errorf("alicloud: can not change LoadBalancer slave zone id once created")
+	if isZonesChanged(request, lb) {
+		recordZonesChanged(context, service, lb, request)
 	}
-	if request.AddressType != "" && request.AddressType != lb.AddressType {
-		return fmt.Errorf("alicloud: can not change LoadBalancer AddressType once created. delete and retry")
+	if isAddressTypeChanged(request, lb) {
+		recordAddressTypeChanged(context, service, lb, request)
 	}
 	if !equalsAddressIPVersion(request.AddressIPVersion, lb.AddressIPVersion) {
-		return fmt.Errorf("alicloud: can not change LoadBalancer AddressIPVersion once created")
+		recordIPVersionChanged(context, service, lb, request)
 	}
 	if request.ResourceGroupId != "" && request.ResourceGroupId != lb.ResourceGroupId {
 		return fmt.Errorf("alicloud: can not change ResourceGroupId once created")
 	}
 
 	// update chargeType & bandwidth
-	needUpdate, charge, bandwidth := false, lb.InternetChargeType, lb.Bandwidth
-	if request.ChargeType != "" && request.ChargeType != lb.InternetChargeType {
-		needUpdate = true
-		charge = request.ChargeType
-		utils.Logf(service, "internet chargeType changed([%s] -> [%s]), update loadbalancer [%s]",
-			string(lb.InternetChargeType), string(request.ChargeType), lb.LoadBalancerId)
-	}
-	if request.ChargeType == slb.PayByBandwidth && request.Bandwidth != lb.Bandwidth && request.Bandwidth != 0 {
-		needUpdate = true
-		bandwidth = request.Bandwidth
-		utils.Logf(service, "bandwidth changed([%d] -> [%d]), update loadbalancer[%s]",
-			lb.Bandwidth, request.Bandwidth, lb.LoadBalancerId)
-	}
-	if needUpdate {
-		if lb.AddressType == slb.InternetAddressType {
-			utils.Logf(service, "modify loadbalancer: chargeType=%s, bandwidth=%d", charge, bandwidth)
-			if err := slbClient.ModifyLoadBalancerInternetSpec(
-				context,
-				&slb.ModifyLoadBalancerInternetSpecArgs{
-					LoadBalancerId:     lb.LoadBalancerId,
-					InternetChargeType: charge,
-					Bandwidth:          bandwidth,
-				}); err != nil {
-				return err
-			}
-		} else {
-			utils.Logf(service, "only internet loadbalancer is allowed to modify bandwidth and pay type")
-		}
+	if err := ensureInternetSpec(context, slbClient, lb, service, request); err != nil {
+		return err
 	}
 
-	// update instance spec
-	if request.LoadBalancerSpec != "" && request.LoadBalancerSpec != lb.LoadBalancerSpec {
-		klog.Infof("alicloud: loadbalancerSpec changed ([%s] -> [%s]), update loadbalancer [%s]",
-			lb.LoadBalancerSpec, request.LoadBalancerSpec, lb.LoadBalancerId)
-		if err := slbClient.ModifyLoadBalancerInstanceSpec(
-			context,
-			&slb.ModifyLoadBalancerInstanceSpecArgs{
-				RegionId:         lb.RegionId,
-				LoadBalancerId:   lb.LoadBalancerId,
-				LoadBalancerSpec: request.LoadBalancerSpec,
-			},
-		); err != nil {
-			return err
-		}
+	// update instance charge type & spec
+	if err := ensureInstanceChargeType(context, slbClient, lb, service, request); err != nil {
+		return err
 	}
-
-	// update slb delete protection
-	if request.DeleteProtection != "" && request.DeleteProtection != lb.DeleteProtection {
-		utils.Logf(service, "delete protection changed([%d] -> [%d]), update loadbalancer [%s]",
-			lb.DeleteProtection, request.DeleteProtection, lb.LoadBalancerId)
-		if err := slbClient.SetLoadBalancerDeleteProtection(
-			context,
-			&slb.SetLoadBalancerDeleteProtectionArgs{
-				RegionId:         lb.RegionId,
-				LoadBalancerId:   lb.LoadBalancerId,
-				DeleteProtection: request.DeleteProtection,
-			},
-		); err != nil {
-			return err
-		}
+	if err := ensureInstanceSpec(context, slbClient, lb, service, request); err != nil {
+		return err
 	}
 
-	// update modification protection
-	if request.ModificationProtectionStatus != "" && request.ModificationProtectionStatus != lb.ModificationProtectionStatus {
-		klog.Infof("alicloud: loadbalancer modification protection changed([%s] -> [%s]) changed, update loadbalancer [%s]",
-			lb.ModificationProtectionStatus, request.ModificationProtectionStatus, lb.LoadBalancerName)
+	// update modification protection, a reason annotation alone keeps the current status
+	status := request.ModificationProtectionStatus
+	if status == "" && request.ModificationProtectionReason != "" {
+		status = lb.ModificationProtectionStatus
+	}
+	reason := modificationProtectionReason(service, status)
+	if status != "" &&
+		(status != lb.ModificationProtectionStatus || reason != lb.ModificationProtectionReason) {
+		log.Info("Modification protection changed, update loadbalancer", utils.LogKeySLBID, lb.LoadBalancerId,
+			"fromStatus", lb.ModificationProtectionStatus, "fromReason", lb.ModificationProtectionReason,
+			"toStatus", status, "toReason", reason)
 		args := slb.SetLoadBalancerModificationProtectionArgs{
 			RegionId:                     lb.RegionId,
 			LoadBalancerId:               lb.LoadBalancerId,
-			ModificationProtectionStatus: request.ModificationProtectionStatus,
-			ModificationProtectionReason: MDSKEY,
+			ModificationProtectionStatus: status,
+			ModificationProtectionReason: reason,
 		}
 		if err := slbClient.SetLoadBalancerModificationProtection(context, &args); err != nil {
 			return err
 		}
 	}
 
+	// update additional tags
+	if err := ensureAdditionalTags(context, slbClient, service, lb, tags); err != nil {
+		return err
+	}
+
 	// update slb name
 	// only user defined slb or slb which has "kubernetes.do.not.delete" tag can update name
 	if request.LoadBalancerName != "" && request.LoadBalancerName != lb.LoadBalancerName {
 		if isLoadBalancerHasTag(tags) || isUserDefinedLoadBalancer(service) {
-			klog.Infof("alicloud: LoadBalancer name (%s -> %s) changed, update loadbalancer [%s]",
-				lb.LoadBalancerName, request.LoadBalancerName, lb.LoadBalancerId)
+			conflict, reason, err := loadBalancerNameConflict(
+				context, slbClient, service, request.LoadBalancerName, lb.LoadBalancerId)
+			if err != nil {
+				return err
+			}
+			if conflict != nil {
+				// the loadbalancer keeps its name, the sync goes on
+				log.Warning("Name taken by another loadbalancer, skip renaming", utils.LogKeySLBID, lb.LoadBalancerId,
+					"to", request.LoadBalancerName, "conflict", conflict.LoadBalancerId, "reason", reason)
+				recordLoadBalancerNameConflict(context, service, request.LoadBalancerName, conflict, reason)
+				return nil
+			}
+			log.Info("Name changed, update loadbalancer", utils.LogKeySLBID, lb.LoadBalancerId,
+				"from", lb.LoadBalancerName, "to", request.LoadBalancerName)
 			if err := slbClient.SetLoadBalancerName(context, lb.LoadBalancerId, request.LoadBalancerName); err != nil {
 				return err
 			}
 		} else {
 			record, err := utils.GetRecorderFromContext(context)
 			if err != nil {
-				klog.Warningf("get recorder error: %s", err.Error())
-				klog.Warningf("alicloud: LoadBalancer name (%s -> %s) changed, try to update loadbalancer [%s],"+
-					" warning: only user defined slb or slb which has 'kubernetes.do.not.delete' tag can update name",
-					lb.LoadBalancerName, request.LoadBalancerName, lb.LoadBalancerId)
+				log.Warning("Name changed, but only user defined slb or slb which has "+
+					"'kubernetes.do.not.delete' tag can update name", utils.LogKeySLBID, lb.LoadBalancerId,
+					"from", lb.LoadBalancerName, "to", request.LoadBalancerName, "err", err)
 			} else {
 				record.Eventf(
 					service,
 					v1.EventTypeWarning,
 					"SetLoadBalancerNameFailed",
-					"Error setting load balancer %s name: "+
+					"Error setting load balancer %s name from %s to %s: "+
 						"only user defined slb or slb which has 'kubernetes.do.not.delete' tag can update name",
-					lb.LoadBalancerName, request.LoadBalancerName, lb.LoadBalancerId,
+					lb.LoadBalancerId, lb.LoadBalancerName, request.LoadBalancerName,
 				)
 			}
 		}
@@ -554,6 +1048,8 @@ func updateLoadBalancerByAnnotations(context context.Context, slbClient ClientSL
 
 //UpdateLoadBalancer make sure slb backend is reconciled
 func (s *LoadBalancerClient) UpdateLoadBalancer(ctx context.Context, service *v1.Service, nodes *EndpointWithENI, withVgroup bool) error {
+	ctx = WithSLBSnapshot(ctx)
+	s = s.withSnapshot(ctx)
 
 	exists, lb, err := s.FindLoadBalancer(ctx, service)
 	if err != nil {
@@ -564,14 +1060,17 @@ func (s *LoadBalancerClient) UpdateLoadBalancer(ctx context.Context, service *v1
 	}
 	if withVgroup {
 		vgs := BuildVirtualGroupFromService(s, service, lb)
-		if err := EnsureVirtualGroups(ctx, vgs, nodes); err != nil {
-			return fmt.Errorf("update backend servers: error %s", err.Error())
+		err = EnsureVirtualGroups(ctx, vgs, nodes)
+		recordSkippedENI(ctx, service, nodes)
+		recordBackendUpdateSkipped(ctx, service, vgs)
+		if err != nil {
+			return fmt.Errorf("update backend servers: error %w", err)
 		}
 	}
 	if !needUpdateDefaultBackend(service, lb) {
 		return nil
 	}
-	utils.Logf(service, "update default backend server group")
+	utils.FromContext(ctx).V(utils.LOG_SYNC_VERBOSITY).Info("Update default backend server group", utils.LogKeySLBID, lb.LoadBalancerId)
 	return s.UpdateDefaultServerGroup(ctx, nodes, lb)
 }
 
@@ -588,8 +1087,8 @@ func needUpdateDefaultBackend(svc *v1.Service, lb *slb.LoadBalancerType) bool {
 			if !hasPort(svc, int32(lis.ListenerPort)) {
 				continue
 			}
-			utils.Logf(svc, "port %d has legacy listener, "+
-				"apply default backend server group. [%s]", lis.ListenerPort, lis.Description)
+			utils.ServiceLogger(svc).V(utils.LOG_SYNC_VERBOSITY).Info("Legacy listener, apply default backend server group",
+				utils.LogKeySLBID, lb.LoadBalancerId, utils.LogKeyListenerPort, lis.ListenerPort, "description", lis.Description)
 			return true
 		}
 	}
@@ -609,23 +1108,116 @@ func hasPort(svc *v1.Service, port int32) bool {
 func (s *LoadBalancerClient) EnsureLoadBalanceDeleted(ctx context.Context, service *v1.Service) error {
 	// need to save the resource version when deleted event
 	// need to remove. when svc type changed (LoadBalancer -> ClusterIP -> LoadBalancer), ccm will restart.
+	log := utils.FromContext(ctx)
 	err := keepResourceVersion(service)
 	if err != nil {
-		utils.Logf(service, "Warning: failed to save deleted service resourceVersion,due to [%s] ", err.Error())
+		log.Warning("Failed to save the resource version of the deleted service", "err", err)
 	}
 	exists, lb, err := s.FindLoadBalancer(ctx, service)
 	if err != nil {
 		return err
 	}
 	if !exists {
+		log.Info("Loadbalancer is gone already")
 		return nil
 	}
-	// skip delete user defined loadbalancer
+	utils.PublishLoadBalancerId(ctx, lb.LoadBalancerId)
+	if err := EnsureBandwidthPackageLeft(ctx, s.vpc, s.ins, s.c, service, lb); err != nil {
+		return err
+	}
+	// the eip is owned by the user, it is unbound but never released
+	if err := EnsureEipUnbound(ctx, s.ins, s.c, service, lb); err != nil {
+		return err
+	}
+	// skip delete user defined loadbalancer and the retained one
+	if isUserDefinedLoadBalancer(service) || isRetainedLoadBalancer(service) {
+		log.Info("User managed or retained loadbalancer will not be deleted by cloudprovider", utils.LogKeySLBID, lb.LoadBalancerId)
+		return s.detachLoadBalancer(ctx, service, lb)
+	}
+	// skip delete the loadbalancer not owned by the service
+	owned, reason, err := s.loadBalancerOwnership(ctx, service, lb)
+	if err != nil {
+		return err
+	}
+	if !owned && !isForceDeleteLoadBalancer(service) {
+		recordSkipDeleteUnmanaged(ctx, service, lb, reason)
+		return s.detachLoadBalancer(ctx, service, lb)
+	}
+	if err := s.deleteLoadBalancer(ctx, service, lb); err != nil {
+		return err
+	}
+	return CleanupSourceRangesAcl(ctx, s.c, service, true)
+}
+
+// detachLoadBalancer remove the listeners and vserver groups of the service
+// from the loadbalancer kept
+func (s *LoadBalancerClient) detachLoadBalancer(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType) error {
+	err := EnsureListenersDeleted(ctx, s.c, service, lb, BuildVirtualGroupFromService(s, service, lb))
+	if err != nil {
+		return err
+	}
+	return CleanupSourceRangesAcl(ctx, s.c, service, true)
+}
+
+// recreateLoadBalancer drain lb before deleting it, the eip leaves the bandwidth
+// package and is unbound, the listeners and vserver groups of the service are
+// removed. They are restored on the loadbalancer created next. A loadbalancer
+// which would be kept on delete is not recreated, an event tells why and false
+// is returned.
+func (s *LoadBalancerClient) recreateLoadBalancer(ctx context.Context, service *v1.Service,
+	lb *slb.LoadBalancerType, request *AnnotationRequest) (bool, error) {
+	reason, err := s.recreateRefusal(ctx, service, lb, request)
+	if err != nil {
+		return false, err
+	}
+	if reason != "" {
+		recordSkipRecreate(ctx, service, lb, reason)
+		return false, nil
+	}
+	if err := EnsureBandwidthPackageLeft(ctx, s.vpc, s.ins, s.c, service, lb); err != nil {
+		return false, err
+	}
+	if err := EnsureEipUnbound(ctx, s.ins, s.c, service, lb); err != nil {
+		return false, err
+	}
+	err = EnsureListenersDeleted(ctx, s.c, service, lb, BuildVirtualGroupFromService(s, service, lb))
+	if err != nil {
+		return false, err
+	}
+	if err := s.deleteLoadBalancer(ctx, service, lb); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// recreateRefusal why lb can not be recreated, empty if it can. The checks of
+// EnsureLoadBalanceDeleted apply, and the delete protection of lb must be off
+// or turned off by annotation.
+func (s *LoadBalancerClient) recreateRefusal(ctx context.Context, service *v1.Service,
+	lb *slb.LoadBalancerType, request *AnnotationRequest) (string, error) {
 	if isUserDefinedLoadBalancer(service) {
-		utils.Logf(service, "user managed loadbalancer will not be deleted by cloudprovider.")
-		return EnsureListenersDeleted(ctx, s.c, service, lb, BuildVirtualGroupFromService(s, service, lb))
+		return "it is managed by the user", nil
+	}
+	if isRetainedLoadBalancer(service) {
+		return fmt.Sprintf("it is retained on delete by annotation %s", ServiceAnnotationLoadBalancerRetainOnDelete), nil
+	}
+	if lb.DeleteProtection == slb.OnFlag && request.DeleteProtection != slb.OffFlag {
+		return fmt.Sprintf("its delete protection is on, set annotation %s to off to recreate it",
+			ServiceAnnotationLoadBalancerDeleteProtection), nil
+	}
+	owned, reason, err := s.loadBalancerOwnership(ctx, service, lb)
+	if err != nil {
+		return "", err
+	}
+	if !owned && !isForceDeleteLoadBalancer(service) {
+		return fmt.Sprintf("%s, set annotation %s to on to recreate it anyway",
+			reason, ServiceAnnotationLoadBalancerForceDelete), nil
 	}
+	return "", nil
+}
 
+// deleteLoadBalancer delete lb regardless of its delete protection
+func (s *LoadBalancerClient) deleteLoadBalancer(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType) error {
 	// set delete protection off
 	if lb.DeleteProtection == slb.OnFlag {
 		if err := s.c.SetLoadBalancerDeleteProtection(
@@ -636,11 +1228,21 @@ func (s *LoadBalancerClient) EnsureLoadBalanceDeleted(ctx context.Context, servi
 				DeleteProtection: slb.OffFlag,
 			},
 		); err != nil {
-			return fmt.Errorf("error to set slb id [%s] delete protection off, svc [%s], err: %s", lb.LoadBalancerId, service.Name, err.Error())
+			if utils.IsCloudNotFound(err) {
+				return nil
+			}
+			return fmt.Errorf("error to set slb id [%s] delete protection off, svc [%s], err: %w", lb.LoadBalancerId, service.Name, err)
 		}
 	}
 
-	return s.c.DeleteLoadBalancer(ctx, lb.LoadBalancerId)
+	err := s.c.DeleteLoadBalancer(ctx, lb.LoadBalancerId)
+	forgetClientToken(service)
+	if utils.IsCloudNotFound(err) {
+		// deleted out of band while the service was torn down
+		utils.FromContext(ctx).Info("Loadbalancer is gone already", utils.LogKeySLBID, lb.LoadBalancerId)
+		return nil
+	}
+	return err
 }
 
 func (s *LoadBalancerClient) getLoadBalancerOpts(service *v1.Service, vswitchid string) (args *slb.CreateLoadBalancerArgs) {
@@ -656,23 +1258,26 @@ func (s *LoadBalancerClient) getLoadBalancerOpts(service *v1.Service, vswitchid
 		DeleteProtection:             ar.DeleteProtection,
 		ResourceGroupId:              ar.ResourceGroupId,
 		ModificationProtectionStatus: ar.ModificationProtectionStatus,
-		ModificationProtectionReason: MDSKEY,
+		ModificationProtectionReason: modificationProtectionReason(service, ar.ModificationProtectionStatus),
 	}
 	// paybybandwidth need a default bandwidth args, while paybytraffic doesnt.
 	if ar.ChargeType == slb.PayByBandwidth ||
 		(ar.ChargeType == slb.PayByTraffic && req.Bandwidth != 0) {
-		klog.V(5).Infof("alicloud: %s, set bandwidth to %d", ar.ChargeType, ar.Bandwidth)
+		utils.ServiceLogger(service).V(utils.LOG_SYNC_VERBOSITY).Info("Set bandwidth", "chargeType", ar.ChargeType, "bandwidth", ar.Bandwidth)
 		args.Bandwidth = ar.Bandwidth
 	}
 	if ar.SLBNetworkType != "classic" &&
 		strings.Compare(string(ar.AddressType), string(slb.IntranetAddressType)) == 0 {
 
-		utils.Logf(service, "intranet vpc "+
-			"loadbalancer will be created. address type=%s, switchid=%s", ar.AddressType, vswitchid)
+		utils.ServiceLogger(service).Info("Intranet vpc loadbalancer will be created",
+			"addressType", ar.AddressType, "vswitchID", vswitchid)
 		args.VSwitchId = vswitchid
 	}
 	if req.LoadBalancerName == "" {
 		args.LoadBalancerName = GetLoadBalancerName(service)
+		if name := templatedLoadBalancerName(service); name != "" {
+			args.LoadBalancerName = name
+		}
 	} else {
 		args.LoadBalancerName = req.LoadBalancerName
 	}
@@ -684,21 +1289,22 @@ const DEFAULT_SERVER_WEIGHT = 100
 
 // UpdateDefaultServerGroup update default server group
 func (s *LoadBalancerClient) UpdateDefaultServerGroup(ctx context.Context, backends interface{}, lb *slb.LoadBalancerType) error {
+	log := utils.FromContext(ctx).WithValues(utils.LogKeySLBID, lb.LoadBalancerId)
 	nodes, ok := backends.([]*v1.Node)
 	if !ok {
-		klog.Infof("skip default server group update for type %s", reflect.TypeOf(backends))
+		log.Info("Skip the default server group update", "type", reflect.TypeOf(backends).String())
 		return nil
 	}
 	additions, deletions := []slb.BackendServerType{}, []string{}
-	klog.V(5).Infof("alicloud: try to update loadbalancer backend servers. [%s]", lb.LoadBalancerId)
+	log.V(utils.LOG_SYNC_VERBOSITY).Info("Update default backend servers")
 	// checkout for newly added servers
 	for _, n1 := range nodes {
 		found := false
 		_, id, err := nodeFromProviderID(n1.Spec.ProviderID)
 		for _, n2 := range lb.BackendServers.BackendServer {
 			if err != nil {
-				klog.Errorf("alicloud: node providerid=%s is not"+
-					" in the correct form, expect regionid.instanceid. skip add op", n1.Spec.ProviderID)
+				log.Error(err, "Node providerID not in the form regionid.instanceid, skip add",
+					utils.LogKeyNode, n1.Name, "providerID", n1.Spec.ProviderID)
 				continue
 			}
 			if id == n2.ServerId {
@@ -711,19 +1317,18 @@ func (s *LoadBalancerClient) UpdateDefaultServerGroup(ctx context.Context, backe
 		}
 	}
 	if len(additions) > 0 {
-		klog.V(5).Infof("alicloud: add loadbalancer backend for [%s] \n %s\n", lb.LoadBalancerId, PrettyJson(additions))
+		log.V(utils.LOG_SYNC_VERBOSITY).Info("Add backend servers", "backends", PrettyJson(additions))
 		// only 20 backend servers is accepted per delete.
 		for len(additions) > 0 {
 			var target []slb.BackendServerType
 			if len(additions) > MAX_LOADBALANCER_BACKEND {
 				target = additions[0:MAX_LOADBALANCER_BACKEND]
 				additions = additions[MAX_LOADBALANCER_BACKEND:]
-				klog.V(5).Infof("alicloud: batch add backend servers, %v", target)
 			} else {
 				target = additions
 				additions = []slb.BackendServerType{}
-				klog.V(5).Infof("alicloud: batch add backend servers, else %v", target)
 			}
+			log.V(utils.LOG_SYNC_VERBOSITY).Info("Batch add backend servers", "backends", target)
 			if _, err := s.c.AddBackendServers(ctx, lb.LoadBalancerId, target); err != nil {
 				return err
 			}
@@ -736,8 +1341,8 @@ func (s *LoadBalancerClient) UpdateDefaultServerGroup(ctx context.Context, backe
 		for _, n2 := range nodes {
 			_, id, err := nodeFromProviderID(n2.Spec.ProviderID)
 			if err != nil {
-				klog.Errorf("alicloud: node providerid=%s is not "+
-					"in the correct form, expect regionid.instanceid.. skip delete op... [%s]", n2.Spec.ProviderID, err.Error())
+				log.Error(err, "Node providerID not in the form regionid.instanceid, skip delete",
+					utils.LogKeyNode, n2.Name, "providerID", n2.Spec.ProviderID)
 				continue
 			}
 			if n1.ServerId == id {
@@ -750,19 +1355,18 @@ func (s *LoadBalancerClient) UpdateDefaultServerGroup(ctx context.Context, backe
 		}
 	}
 	if len(deletions) > 0 {
-		klog.V(5).Infof("alicloud: delete loadbalancer backend for [%s] %v", lb.LoadBalancerId, deletions)
+		log.V(utils.LOG_SYNC_VERBOSITY).Info("Remove backend servers", "backends", deletions)
 		// only 20 backend servers is accepted per delete.
 		for len(deletions) > 0 {
 			var target []string
 			if len(deletions) > MAX_LOADBALANCER_BACKEND {
 				target = deletions[0:MAX_LOADBALANCER_BACKEND]
 				deletions = deletions[MAX_LOADBALANCER_BACKEND:]
-				klog.V(5).Infof("alicloud: batch delete backend servers, %s", target)
 			} else {
 				target = deletions
 				deletions = []string{}
-				klog.V(5).Infof("alicloud: batch delete backend servers else, %s", target)
 			}
+			log.V(utils.LOG_SYNC_VERBOSITY).Info("Batch remove backend servers", "backends", target)
 			var mdelete []slb.BackendServerType
 			for _, del := range target {
 				mdelete = append(mdelete, slb.BackendServerType{ServerId: del})
@@ -774,10 +1378,10 @@ func (s *LoadBalancerClient) UpdateDefaultServerGroup(ctx context.Context, backe
 		}
 	}
 	if len(additions) <= 0 && len(deletions) <= 0 {
-		klog.V(5).Infof("alicloud: no backend servers need to be updated.[%s]", lb.LoadBalancerId)
+		log.V(utils.LOG_SYNC_VERBOSITY).Info("No backend servers need to be updated")
 		return nil
 	}
-	klog.V(5).Infof("alicloud: update loadbalancer`s backend servers finished! [%s]", lb.LoadBalancerId)
+	log.V(utils.LOG_SYNC_VERBOSITY).Info("Updated default backend servers")
 	return nil
 }
 
@@ -786,6 +1390,12 @@ func isUserDefinedLoadBalancer(svc *v1.Service) bool {
 	return serviceAnnotation(svc, ServiceAnnotationLoadBalancerId) != ""
 }
 
+// isRetainedLoadBalancer the loadbalancer is kept with its delete protection when
+// the service is deleted, only the listeners are removed.
+func isRetainedLoadBalancer(svc *v1.Service) bool {
+	return strings.ToLower(serviceAnnotation(svc, ServiceAnnotationLoadBalancerRetainOnDelete)) == "on"
+}
+
 func isOverrideListeners(svc *v1.Service) bool {
 	return strings.ToLower(serviceAnnotation(svc, ServiceAnnotationLoadBalancerOverrideListener)) == "true"
 }
@@ -793,15 +1403,16 @@ func isOverrideListeners(svc *v1.Service) bool {
 // check if the service exists in service definition
 func isLoadbalancerOwnIngress(service *v1.Service) bool {
 	if service == nil {
-		utils.Logf(service, "service is nil")
+		utils.V(utils.LOG_SYNC_VERBOSITY).Info("Service is nil")
 		return false
 	}
 
+	log := utils.ServiceLogger(service).V(utils.LOG_SYNC_VERBOSITY)
 	if len(service.Status.LoadBalancer.Ingress) == 0 {
-		utils.Logf(service, "service %v doesn't have ingresses", service.Name)
+		log.Info("Service has no ingresses")
 		return false
 	}
-	utils.Logf(service, "service %s has ingresses=%v", service.Name, service.Status.LoadBalancer.Ingress)
+	log.Info("Service has ingresses", "ingresses", service.Status.LoadBalancer.Ingress)
 	return true
 }
 
@@ -815,12 +1426,10 @@ func isServiceDeleted(service *v1.Service) bool {
 	keeper := GetLocalService()
 	deleted := keeper.get(serviceUID)
 	if deleted {
-		utils.Logf(service, "service "+
-			"%s's uid %v has been deleted, shouldn't be created again.", service.Name, serviceUID)
+		utils.ServiceLogger(service).Info("Service has been deleted, shouldn't be created again", "uid", serviceUID)
 		return true
 	}
-	utils.Logf(service, "service %s's uid %v "+
-		"hasn't been deleted, first time to process, as expected.", service.Name, serviceUID)
+	utils.ServiceLogger(service).V(utils.LOG_SYNC_VERBOSITY).Info("Service hasn't been deleted, first time to process", "uid", serviceUID)
 	return false
 }
 
@@ -834,10 +1443,9 @@ func keepResourceVersion(service *v1.Service) error {
 	keeper := GetLocalService()
 	if !keeper.get(serviceUID) {
 		keeper.set(serviceUID)
-		utils.Logf(service, "service %s's uid %v is kept in the DeletedSvcKeeper successfully.", service.Name, serviceUID)
+		utils.ServiceLogger(service).V(utils.LOG_SYNC_VERBOSITY).Info("Service uid kept in the DeletedSvcKeeper", "uid", serviceUID)
 	} else {
-		utils.Logf(service, "service %s's uid %v has "+
-			"already been kept in the DeletedSvcKeeper, no need to update.", service.Name, serviceUID)
+		utils.ServiceLogger(service).V(utils.LOG_SYNC_VERBOSITY).Info("Service uid already kept in the DeletedSvcKeeper", "uid", serviceUID)
 	}
 	// keeper.set(serviceUIDNoneExist, currentVersion)
 	return nil
@@ -874,3 +1482,18 @@ func addSLBTag(client ClientSLBSDK, ctx context.Context, tags map[string]string,
 		},
 	)
 }
+
+func removeSLBTag(client ClientSLBSDK, ctx context.Context, tags []slb.TagItem, regionId common.Region, loadbalancerId string) error {
+	tagItems, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	return client.RemoveTags(
+		ctx,
+		&slb.RemoveTagsArgs{
+			RegionId:       regionId,
+			LoadBalancerID: loadbalancerId,
+			Tags:           string(tagItems),
+		},
+	)
+}
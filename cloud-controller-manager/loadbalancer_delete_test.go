@@ -0,0 +1,147 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+// deleteService delete the loadbalancer of the service, and return the id
+// published and the warning events recorded
+func deleteService(f *FrameWork) (string, []string, error) {
+	recorder := record.NewFakeRecorder(10)
+	published := ""
+	ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+	ctx = context.WithValue(ctx, utils.ContextLoadBalancerId, func(id string) { published = id })
+	err := f.CloudImpl().EnsureLoadBalancerDeleted(ctx, CLUSTER_ID, f.SVC)
+	var warnings []string
+	for len(recorder.Events) > 0 {
+		if e := <-recorder.Events; strings.HasPrefix(e, v1.EventTypeWarning) {
+			warnings = append(warnings, e)
+		}
+	}
+	return published, warnings, err
+}
+
+func TestEnsureLoadBalancerDeletedAbsent(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(testService(map[string]string{})).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Delete the service of a loadbalancer removed out of band",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			LOADBALANCER.loadbalancer.Delete(lb.LoadBalancerId)
+
+			// deleted again, eg. the retry of a delete succeeded already
+			for i := 0; i < 2; i++ {
+				published, warnings, err := deleteService(f)
+				if err != nil {
+					return fmt.Errorf("expect the delete succeeded, got %s", err.Error())
+				}
+				if published != "" {
+					return fmt.Errorf("expect no loadbalancer deleted, got %s", published)
+				}
+				if len(warnings) != 0 {
+					return fmt.Errorf("expect no warning, got %v", warnings)
+				}
+			}
+			return ExpectNotExist(f)
+		},
+	)
+}
+
+func TestEnsureLoadBalancerDeletedPartial(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: prid},
+			Spec:       v1.NodeSpec{ProviderID: prid},
+		},
+	}
+
+	f := NewDefaultFrameWork(nil)
+	f.WithService(testService(map[string]string{})).WithNodes(nodes)
+	f.RunCustomized(t, "Loadbalancer deleted out of band during the teardown",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			f.SLBFaults().FailFirst("DeleteLoadBalancer", 1, LoadBalancerNotFoundFault)
+			published, warnings, err := deleteService(f)
+			if err != nil {
+				return fmt.Errorf("expect the delete succeeded, got %s", err.Error())
+			}
+			if published == "" || len(warnings) != 0 {
+				return fmt.Errorf("expect the loadbalancer deleted without warning, got %s, %v", published, warnings)
+			}
+			return nil
+		},
+	)
+
+	// the listeners and vserver groups of a reused loadbalancer are removed
+	f = NewDefaultFrameWork(nil)
+	f.WithService(
+		testService(map[string]string{
+			ServiceAnnotationLoadBalancerId:               LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerOverrideListener: "true",
+		}),
+	).WithNodes(nodes)
+	f.RunCustomized(t, "Listener and vserver group removed out of band during the teardown",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			faults := f.SLBFaults().
+				FailFirst("StopLoadBalancerListener", 1, ListenerNotFoundFault).
+				FailFirst("DeleteVServerGroup", 1, VServerGroupNotFoundFault)
+			published, warnings, err := deleteService(f)
+			if err != nil {
+				return fmt.Errorf("expect the delete succeeded, got %s", err.Error())
+			}
+			if published != LOADBALANCER_ID || len(warnings) != 0 {
+				return fmt.Errorf("expect the loadbalancer detached without warning, got %s, %v", published, warnings)
+			}
+			// the cleanup goes on past the listener missing
+			if calls := faults.Calls("DeleteVServerGroup"); len(calls) == 0 {
+				return fmt.Errorf("expect the vserver groups removed after the listener missing")
+			}
+			_, _, err = deleteService(f)
+			return err
+		},
+	)
+}
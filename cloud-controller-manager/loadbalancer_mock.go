@@ -7,6 +7,7 @@ import (
 	"github.com/denverdino/aliyungo/common"
 	"github.com/denverdino/aliyungo/slb"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -61,15 +62,29 @@ func WithLoadBalancer() CloudDataMock {
 	}
 }
 
+func WithAccessControlLists() CloudDataMock {
+	return func() {
+		for _, id := range []string{ACL_ID, ACL_ID2} {
+			LOADBALANCER.acls.Store(
+				id,
+				&DescribeAccessControlListAttributeResponse{AclId: id, AclName: id},
+			)
+		}
+	}
+}
+
 type mockClientSLB struct {
 	describeLoadBalancers                 func(args *slb.DescribeLoadBalancersArgs) (loadBalancers []slb.LoadBalancerType, err error)
 	createLoadBalancer                    func(args *slb.CreateLoadBalancerArgs) (response *slb.CreateLoadBalancerResponse, err error)
+	createLoadBalancerWithAddress         func(args *CreateLoadBalancerWithAddressArgs) (response *slb.CreateLoadBalancerResponse, err error)
 	deleteLoadBalancer                    func(loadBalancerId string) (err error)
 	setLoadBalancerName                   func(loadBalancerId string, name string) (err error)
 	setLoadBalancerDeleteProtection       func(args *slb.SetLoadBalancerDeleteProtectionArgs) (err error)
 	modifyLoadBalancerInternetSpec        func(args *slb.ModifyLoadBalancerInternetSpecArgs) (err error)
 	modifyLoadBalancerInstanceSpec        func(args *slb.ModifyLoadBalancerInstanceSpecArgs) (err error)
+	modifyLoadBalancerInstanceChargeType  func(args *ModifyLoadBalancerInstanceChargeTypeArgs) (err error)
 	describeLoadBalancerAttribute         func(loadBalancerId string) (loadBalancer *slb.LoadBalancerType, err error)
+	describeZones                         func(args *DescribeZonesArgs) (zones []ZoneType, err error)
 	removeBackendServers                  func(loadBalancerId string, backendServers []slb.BackendServerType) (result []slb.BackendServerType, err error)
 	addBackendServers                     func(loadBalancerId string, backendServers []slb.BackendServerType) (result []slb.BackendServerType, err error)
 	setLoadBalancerModificationProtection func(args *slb.SetLoadBalancerModificationProtectionArgs) (err error)
@@ -102,6 +117,37 @@ type mockClientSLB struct {
 	modifyVServerGroupBackendServers func(args *slb.ModifyVServerGroupBackendServersArgs) (response *slb.ModifyVServerGroupBackendServersResponse, err error)
 	addVServerGroupBackendServers    func(args *slb.AddVServerGroupBackendServersArgs) (response *slb.AddVServerGroupBackendServersResponse, err error)
 	removeVServerGroupBackendServers func(args *slb.RemoveVServerGroupBackendServersArgs) (response *slb.RemoveVServerGroupBackendServersResponse, err error)
+	describeHealthStatus             func(args *DescribeHealthStatusArgs) (response *DescribeHealthStatusResponse, err error)
+
+	describeServerCertificates func(args *DescribeServerCertificatesArgs) (certificates []ServerCertificateType, err error)
+	uploadServerCertificate    func(args *UploadServerCertificateArgs) (response *UploadServerCertificateResponse, err error)
+	deleteServerCertificate    func(args *DeleteServerCertificateArgs) (err error)
+	describeDomainExtensions   func(args *DescribeDomainExtensionsArgs) (extensions []DomainExtensionType, err error)
+	createDomainExtension      func(args *CreateDomainExtensionArgs) (response *CreateDomainExtensionResponse, err error)
+	deleteDomainExtension      func(args *DeleteDomainExtensionArgs) (err error)
+
+	describeAccessControlListAttribute func(args *DescribeAccessControlListAttributeArgs) (response *DescribeAccessControlListAttributeResponse, err error)
+	describeAccessControlLists         func(args *DescribeAccessControlListsArgs) (acls []AclType, err error)
+	createAccessControlList            func(args *CreateAccessControlListArgs) (response *CreateAccessControlListResponse, err error)
+	deleteAccessControlList            func(args *DeleteAccessControlListArgs) (err error)
+	addAccessControlListEntry          func(args *AccessControlListEntryArgs) (err error)
+	removeAccessControlListEntry       func(args *AccessControlListEntryArgs) (err error)
+
+	describeAccessLogsDownloadAttribute func(args *DescribeAccessLogsDownloadAttributeArgs) (attrs []AccessLogAttribute, err error)
+	setAccessLogsDownloadAttribute      func(args *AccessLogsDownloadAttributeArgs) (err error)
+	deleteAccessLogsDownloadAttribute   func(args *AccessLogsDownloadAttributeArgs) (err error)
+
+	describeListenerExtension func(proto string, args *DescribeListenerExtensionArgs) (extension *ListenerExtension, err error)
+	setListenerExtension      func(proto string, args *SetListenerExtensionArgs) (err error)
+
+	describeHTTPSListenerCertificates func(args *DescribeListenerExtensionArgs) (certificates *ListenerCertificates, err error)
+	setHTTPSListenerCertificates      func(args *SetListenerCertificatesArgs) (err error)
+
+	// pageSize the max loadbalancers of a page described, 100 if not set
+	pageSize int
+
+	// faults the failures injected into the calls, which are recorded
+	faults *FaultInjector
 }
 
 type LBStore struct {
@@ -109,6 +155,24 @@ type LBStore struct {
 	listeners    sync.Map
 	tags         sync.Map
 	vgroups      sync.Map
+	// domainExtensions listenerKey: []DomainExtensionType
+	domainExtensions sync.Map
+	// acls AclId: *DescribeAccessControlListAttributeResponse
+	acls sync.Map
+	// extensions listenerKey: *ListenerExtension
+	extensions sync.Map
+	// eccCertificates listenerKey: the ecc certificate id bound to the https listener
+	eccCertificates sync.Map
+	// certificates ServerCertificateId: ServerCertificateType, uploaded by UploadServerCertificate
+	certificates sync.Map
+	// accessLogs LoadBalancerId: AccessLogAttribute
+	accessLogs sync.Map
+	// instanceChargeTypes LoadBalancerId: InstanceChargeType, PayBySpec if absent
+	instanceChargeTypes sync.Map
+	// health ServerId: health status of the backend, normal if absent
+	health sync.Map
+	// clientTokens ClientToken: LoadBalancerId created with the token
+	clientTokens sync.Map
 }
 
 // LOADBALANCER slb cloud mock storage
@@ -116,9 +180,13 @@ type LBStore struct {
 var LOADBALANCER = LBStore{}
 
 func (c *mockClientSLB) DescribeLoadBalancers(ctx context.Context, args *slb.DescribeLoadBalancersArgs) (loadBalancers []slb.LoadBalancerType, err error) {
+	if err := c.inject(ctx, "DescribeLoadBalancers", args); err != nil {
+		return nil, err
+	}
 	if c.describeLoadBalancers != nil {
 		return c.describeLoadBalancers(args)
 	}
+	defer c.serialize(ctx)()
 	var results []slb.LoadBalancerType
 	LOADBALANCER.loadbalancer.Range(
 		func(key, value interface{}) bool {
@@ -145,12 +213,16 @@ func (c *mockClientSLB) DescribeLoadBalancers(ctx context.Context, args *slb.Des
 				return true
 			}
 			if args.Tags != "" {
+				var want []slb.TagItem
+				if err := json.Unmarshal([]byte(args.Tags), &want); err != nil {
+					fmt.Printf("API: DescribeLoadBalancers, unexpected tags %s", args.Tags)
+					return true
+				}
 				bytag := &slb.DescribeTagsArgs{
-					LoadBalancerID: args.LoadBalancerId,
-					Tags:           args.Tags,
+					LoadBalancerID: v.LoadBalancerId,
 				}
-				tag, _, _ := c.DescribeTags(ctx, bytag)
-				if len(tag) <= 0 {
+				tags, _, _ := c.DescribeTags(internalCall(ctx), bytag)
+				if !hasTags(tags, want) {
 					return true
 				}
 			}
@@ -161,10 +233,61 @@ func (c *mockClientSLB) DescribeLoadBalancers(ctx context.Context, args *slb.Des
 	return results, nil
 }
 
+func (c *mockClientSLB) DescribeLoadBalancersPage(ctx context.Context, args *DescribeLoadBalancersPageArgs) (loadBalancers []slb.LoadBalancerType, pagination *common.PaginationResult, err error) {
+	if err := c.inject(ctx, "DescribeLoadBalancers", args); err != nil {
+		return nil, nil, err
+	}
+	query := &slb.DescribeLoadBalancersArgs{
+		RegionId:         args.RegionId,
+		LoadBalancerId:   args.LoadBalancerId,
+		LoadBalancerName: args.LoadBalancerName,
+		Tags:             args.Tags,
+	}
+	var all []slb.LoadBalancerType
+	if c.describeLoadBalancers != nil {
+		all, err = c.describeLoadBalancers(query)
+	} else {
+		unlock := c.serialize(ctx)
+		all, err = c.DescribeLoadBalancers(internalCall(ctx), query)
+		unlock()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// the pages are ordered by id, 10 loadbalancers a page by default
+	sort.Slice(all, func(i, j int) bool { return all[i].LoadBalancerId < all[j].LoadBalancerId })
+	size, number := args.PageSize, args.PageNumber
+	if size <= 0 {
+		size = 10
+	}
+	limit := c.pageSize
+	if limit <= 0 {
+		limit = 100
+	}
+	if size > limit {
+		size = limit
+	}
+	if number <= 0 {
+		number = 1
+	}
+	begin, end := (number-1)*size, number*size
+	if begin > len(all) {
+		begin = len(all)
+	}
+	if end > len(all) {
+		end = len(all)
+	}
+	return all[begin:end], &common.PaginationResult{TotalCount: len(all), PageNumber: number, PageSize: size}, nil
+}
+
 func (c *mockClientSLB) StopLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error) {
+	if err := c.inject(ctx, "StopLoadBalancerListener", loadBalancerId, port); err != nil {
+		return err
+	}
 	if c.stopLoadBalancerListener != nil {
 		return c.stopLoadBalancerListener(loadBalancerId, port)
 	}
+	defer c.serialize(ctx)()
 	key := listenerKey(loadBalancerId, port)
 	listenerObj, ok := LOADBALANCER.listeners.Load(key)
 	if !ok || listenerObj == nil {
@@ -199,12 +322,28 @@ func (c *mockClientSLB) StopLoadBalancerListener(ctx context.Context, loadBalanc
 }
 
 func (c *mockClientSLB) CreateLoadBalancer(ctx context.Context, args *slb.CreateLoadBalancerArgs) (response *slb.CreateLoadBalancerResponse, err error) {
+	if err := c.inject(ctx, "CreateLoadBalancer", args); err != nil {
+		return nil, err
+	}
 	if c.createLoadBalancer != nil {
 		return c.createLoadBalancer(args)
 	}
+	defer c.serialize(ctx)()
 	if args.LoadBalancerName == "" {
 		return nil, fmt.Errorf("slb name must not be empty")
 	}
+	// the retries with the same token get the loadbalancer created before
+	if id, ok := LOADBALANCER.clientTokens.Load(args.ClientToken); ok && args.ClientToken != "" {
+		if v, ok := LOADBALANCER.loadbalancer.Load(id); ok {
+			ins := v.(slb.LoadBalancerType)
+			return &slb.CreateLoadBalancerResponse{
+				LoadBalancerId:   ins.LoadBalancerId,
+				Address:          ins.Address,
+				NetworkType:      string(ins.InternetChargeType),
+				LoadBalancerName: ins.LoadBalancerName,
+			}, nil
+		}
+	}
 	addrtype := slb.InternetAddressType
 	if args.AddressType != "" {
 		addrtype = args.AddressType
@@ -213,6 +352,15 @@ func (c *mockClientSLB) CreateLoadBalancer(ctx context.Context, args *slb.Create
 	if args.AddressIPVersion != "" {
 		ipver = args.AddressIPVersion
 	}
+	address := LOADBALANCER_ADDRESS
+	if ipver == slb.IPv6 {
+		address = LOADBALANCER_IPV6_ADDRESS
+	}
+	// zones are chosen by the api when absent
+	master, slave := args.MasterZoneId, args.SlaveZoneId
+	if master == "" {
+		master, slave = REGION_A, REGION_B
+	}
 	ins := slb.LoadBalancerType{
 		LoadBalancerId:               newid(),
 		LoadBalancerName:             args.LoadBalancerName,
@@ -220,17 +368,21 @@ func (c *mockClientSLB) CreateLoadBalancer(ctx context.Context, args *slb.Create
 		LoadBalancerSpec:             args.LoadBalancerSpec,
 		Bandwidth:                    args.Bandwidth,
 		InternetChargeType:           args.InternetChargeType,
-		Address:                      LOADBALANCER_ADDRESS,
+		Address:                      address,
 		AddressType:                  addrtype,
 		VSwitchId:                    args.VSwitchId,
 		VpcId:                        VPCID,
 		AddressIPVersion:             ipver,
-		MasterZoneId:                 args.MasterZoneId,
-		SlaveZoneId:                  args.SlaveZoneId,
+		MasterZoneId:                 master,
+		SlaveZoneId:                  slave,
+		DeleteProtection:             args.DeleteProtection,
 		ModificationProtectionStatus: args.ModificationProtectionStatus,
-		ModificationProtectionReason: TAGKEY,
+		ModificationProtectionReason: args.ModificationProtectionReason,
 	}
 	LOADBALANCER.loadbalancer.Store(ins.LoadBalancerId, ins)
+	if args.ClientToken != "" {
+		LOADBALANCER.clientTokens.Store(args.ClientToken, ins.LoadBalancerId)
+	}
 	return &slb.CreateLoadBalancerResponse{
 		LoadBalancerId:   ins.LoadBalancerId,
 		Address:          ins.Address,
@@ -239,18 +391,51 @@ func (c *mockClientSLB) CreateLoadBalancer(ctx context.Context, args *slb.Create
 	}, nil
 }
 
+func (c *mockClientSLB) CreateLoadBalancerWithAddress(ctx context.Context, args *CreateLoadBalancerWithAddressArgs) (response *slb.CreateLoadBalancerResponse, err error) {
+	if err := c.inject(ctx, "CreateLoadBalancerWithAddress", args); err != nil {
+		return nil, err
+	}
+	if c.createLoadBalancerWithAddress != nil {
+		return c.createLoadBalancerWithAddress(args)
+	}
+	defer c.serialize(ctx)()
+	response, err = c.CreateLoadBalancer(internalCall(ctx), &args.CreateLoadBalancerArgs)
+	if err != nil {
+		return nil, err
+	}
+	v, _ := LOADBALANCER.loadbalancer.Load(response.LoadBalancerId)
+	ins := v.(slb.LoadBalancerType)
+	ins.Address = args.Address
+	LOADBALANCER.loadbalancer.Store(ins.LoadBalancerId, ins)
+	response.Address = args.Address
+	return response, nil
+}
+
 func (c *mockClientSLB) DeleteLoadBalancer(ctx context.Context, loadBalancerId string) (err error) {
+	if err := c.inject(ctx, "DeleteLoadBalancer", loadBalancerId); err != nil {
+		return err
+	}
 	if c.deleteLoadBalancer != nil {
 		return c.deleteLoadBalancer(loadBalancerId)
 	}
+	defer c.serialize(ctx)()
+	if v, ok := LOADBALANCER.loadbalancer.Load(loadBalancerId); ok {
+		if ins, ok := v.(slb.LoadBalancerType); ok && ins.DeleteProtection == slb.OnFlag {
+			return fmt.Errorf("LoadBalancer %s is protected from deletion", loadBalancerId)
+		}
+	}
 	LOADBALANCER.loadbalancer.Delete(loadBalancerId)
 	return nil
 }
 
 func (c *mockClientSLB) SetLoadBalancerDeleteProtection(ctx context.Context, args *slb.SetLoadBalancerDeleteProtectionArgs) (err error) {
+	if err := c.inject(ctx, "SetLoadBalancerDeleteProtection", args); err != nil {
+		return err
+	}
 	if c.setLoadBalancerDeleteProtection != nil {
 		return c.setLoadBalancerDeleteProtection(args)
 	}
+	defer c.serialize(ctx)()
 	if args.LoadBalancerId == "" {
 		return fmt.Errorf("loadbalancer id must not be empty")
 	}
@@ -270,9 +455,13 @@ func (c *mockClientSLB) SetLoadBalancerDeleteProtection(ctx context.Context, arg
 }
 
 func (c *mockClientSLB) SetLoadBalancerName(ctx context.Context, loadBalancerId string, loadBalancerName string) (err error) {
+	if err := c.inject(ctx, "SetLoadBalancerName", loadBalancerId, loadBalancerName); err != nil {
+		return err
+	}
 	if c.setLoadBalancerName != nil {
 		return c.setLoadBalancerName(loadBalancerId, loadBalancerName)
 	}
+	defer c.serialize(ctx)()
 	if loadBalancerId == "" || loadBalancerName == "" {
 		return fmt.Errorf("loadbalancer id and name must not be empty")
 	}
@@ -290,9 +479,13 @@ func (c *mockClientSLB) SetLoadBalancerName(ctx context.Context, loadBalancerId
 }
 
 func (c *mockClientSLB) ModifyLoadBalancerInternetSpec(ctx context.Context, args *slb.ModifyLoadBalancerInternetSpecArgs) (err error) {
+	if err := c.inject(ctx, "ModifyLoadBalancerInternetSpec", args); err != nil {
+		return err
+	}
 	if c.modifyLoadBalancerInternetSpec != nil {
 		return c.modifyLoadBalancerInternetSpec(args)
 	}
+	defer c.serialize(ctx)()
 	if args.LoadBalancerId == "" {
 		return fmt.Errorf("loadbalancer id must not be empty")
 	}
@@ -315,9 +508,13 @@ func (c *mockClientSLB) ModifyLoadBalancerInternetSpec(ctx context.Context, args
 }
 
 func (c *mockClientSLB) ModifyLoadBalancerInstanceSpec(ctx context.Context, args *slb.ModifyLoadBalancerInstanceSpecArgs) (err error) {
+	if err := c.inject(ctx, "ModifyLoadBalancerInstanceSpec", args); err != nil {
+		return err
+	}
 	if c.modifyLoadBalancerInstanceSpec != nil {
 		return c.modifyLoadBalancerInstanceSpec(args)
 	}
+	defer c.serialize(ctx)()
 	if args.LoadBalancerId == "" {
 		return fmt.Errorf("loadbalancer id must not be empty")
 	}
@@ -336,10 +533,93 @@ func (c *mockClientSLB) ModifyLoadBalancerInstanceSpec(ctx context.Context, args
 	return nil
 }
 
+func (c *mockClientSLB) DescribeLoadBalancerInstanceChargeType(ctx context.Context, args *DescribeLoadBalancerInstanceChargeTypeArgs) (response *DescribeLoadBalancerInstanceChargeTypeResponse, err error) {
+	if err := c.inject(ctx, "DescribeLoadBalancerInstanceChargeType", args); err != nil {
+		return nil, err
+	}
+	defer c.serialize(ctx)()
+	v, ok := LOADBALANCER.loadbalancer.Load(args.LoadBalancerId)
+	if !ok {
+		return nil, fmt.Errorf("loadbalancer not found by id %s", args.LoadBalancerId)
+	}
+	ins, ok := v.(slb.LoadBalancerType)
+	if !ok {
+		return nil, fmt.Errorf("not slb.LoadBalancerType")
+	}
+	response = &DescribeLoadBalancerInstanceChargeTypeResponse{
+		LoadBalancerId:     ins.LoadBalancerId,
+		InstanceChargeType: PayBySpec,
+		LoadBalancerSpec:   ins.LoadBalancerSpec,
+	}
+	if charge, ok := LOADBALANCER.instanceChargeTypes.Load(args.LoadBalancerId); ok {
+		response.InstanceChargeType = charge.(InstanceChargeType)
+	}
+	return response, nil
+}
+
+func (c *mockClientSLB) ModifyLoadBalancerInstanceChargeType(ctx context.Context, args *ModifyLoadBalancerInstanceChargeTypeArgs) (err error) {
+	if err := c.inject(ctx, "ModifyLoadBalancerInstanceChargeType", args); err != nil {
+		return err
+	}
+	if c.modifyLoadBalancerInstanceChargeType != nil {
+		return c.modifyLoadBalancerInstanceChargeType(args)
+	}
+	defer c.serialize(ctx)()
+	v, ok := LOADBALANCER.loadbalancer.Load(args.LoadBalancerId)
+	if !ok {
+		return fmt.Errorf("loadbalancer not found by id %s", args.LoadBalancerId)
+	}
+	ins, ok := v.(slb.LoadBalancerType)
+	if !ok {
+		return fmt.Errorf("not slb.LoadBalancerType")
+	}
+	switch args.InstanceChargeType {
+	case PayByCLCU:
+		ins.LoadBalancerSpec = ""
+	case PayBySpec:
+		if args.LoadBalancerSpec == "" {
+			return fmt.Errorf("LoadBalancerSpec is required by %s", PayBySpec)
+		}
+		ins.LoadBalancerSpec = args.LoadBalancerSpec
+	default:
+		return fmt.Errorf("unknown instance charge type %s", args.InstanceChargeType)
+	}
+	LOADBALANCER.instanceChargeTypes.Store(ins.LoadBalancerId, args.InstanceChargeType)
+	LOADBALANCER.loadbalancer.Store(ins.LoadBalancerId, ins)
+	return nil
+}
+
+func (c *mockClientSLB) DescribeZones(ctx context.Context, args *DescribeZonesArgs) (zones []ZoneType, err error) {
+	if err := c.inject(ctx, "DescribeZones", args); err != nil {
+		return nil, err
+	}
+	if c.describeZones != nil {
+		return c.describeZones(args)
+	}
+	defer c.serialize(ctx)()
+	zone := func(id string, slaves ...string) ZoneType {
+		z := ZoneType{ZoneId: id}
+		for _, slave := range slaves {
+			z.SlaveZones.SlaveZone = append(z.SlaveZones.SlaveZone, SlaveZoneType{ZoneId: slave})
+		}
+		return z
+	}
+	// zone g has no slave zone
+	return []ZoneType{
+		zone(REGION_A, REGION_B),
+		zone(REGION_B, REGION_A),
+		zone(fmt.Sprintf("%s-g", REGION)),
+	}, nil
+}
+
 func (c *mockClientSLB) DescribeLoadBalancerAttribute(ctx context.Context, loadBalancerId string) (loadBalancer *slb.LoadBalancerType, err error) {
+	if err := c.inject(ctx, "DescribeLoadBalancerAttribute", loadBalancerId); err != nil {
+		return nil, err
+	}
 	if c.describeLoadBalancerAttribute != nil {
 		return c.describeLoadBalancerAttribute(loadBalancerId)
 	}
+	defer c.serialize(ctx)()
 
 	if loadBalancerId == "" {
 		return nil, fmt.Errorf("loadbalancer id must not be empty")
@@ -397,9 +677,13 @@ func (c *mockClientSLB) DescribeLoadBalancerAttribute(ctx context.Context, loadB
 }
 
 func (c *mockClientSLB) RemoveBackendServers(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) (result []slb.BackendServerType, err error) {
+	if err := c.inject(ctx, "RemoveBackendServers", loadBalancerId, backendServers); err != nil {
+		return nil, err
+	}
 	if c.removeBackendServers != nil {
 		return c.removeBackendServers(loadBalancerId, backendServers)
 	}
+	defer c.serialize(ctx)()
 	v, ok := LOADBALANCER.loadbalancer.Load(loadBalancerId)
 	if !ok {
 		return nil, fmt.Errorf("loadbalancer not found %s addbackend", loadBalancerId)
@@ -439,9 +723,13 @@ func backend(bc []slb.BackendServerType) []slb.BackendServerType {
 }
 
 func (c *mockClientSLB) AddBackendServers(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) (result []slb.BackendServerType, err error) {
+	if err := c.inject(ctx, "AddBackendServers", loadBalancerId, backendServers); err != nil {
+		return nil, err
+	}
 	if c.addBackendServers != nil {
 		return c.addBackendServers(loadBalancerId, backendServers)
 	}
+	defer c.serialize(ctx)()
 
 	v, ok := LOADBALANCER.loadbalancer.Load(loadBalancerId)
 	if !ok {
@@ -475,9 +763,13 @@ func listenerKey(id string, port int) string {
 }
 
 func (c *mockClientSLB) StartLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error) {
+	if err := c.inject(ctx, "StartLoadBalancerListener", loadBalancerId, port); err != nil {
+		return err
+	}
 	if c.startLoadBalancerListener != nil {
 		return c.startLoadBalancerListener(loadBalancerId, port)
 	}
+	defer c.serialize(ctx)()
 	key := listenerKey(loadBalancerId, port)
 	listenerObj, ok := LOADBALANCER.listeners.Load(key)
 	if !ok {
@@ -505,9 +797,13 @@ func (c *mockClientSLB) StartLoadBalancerListener(ctx context.Context, loadBalan
 	}
 }
 func (c *mockClientSLB) CreateLoadBalancerTCPListener(ctx context.Context, args *slb.CreateLoadBalancerTCPListenerArgs) (err error) {
+	if err := c.inject(ctx, "CreateLoadBalancerTCPListener", args); err != nil {
+		return err
+	}
 	if c.createLoadBalancerTCPListener != nil {
 		return c.createLoadBalancerTCPListener(args)
 	}
+	defer c.serialize(ctx)()
 	listener := &slb.DescribeLoadBalancerTCPListenerAttributeResponse{
 		DescribeLoadBalancerListenerAttributeResponse: slb.DescribeLoadBalancerListenerAttributeResponse{},
 		TCPListenerType: slb.TCPListenerType{
@@ -545,9 +841,13 @@ func (c *mockClientSLB) CreateLoadBalancerTCPListener(ctx context.Context, args
 }
 
 func (c *mockClientSLB) CreateLoadBalancerUDPListener(ctx context.Context, args *slb.CreateLoadBalancerUDPListenerArgs) (err error) {
+	if err := c.inject(ctx, "CreateLoadBalancerUDPListener", args); err != nil {
+		return err
+	}
 	if c.createLoadBalancerUDPListener != nil {
 		return c.createLoadBalancerUDPListener(args)
 	}
+	defer c.serialize(ctx)()
 
 	listener := &slb.DescribeLoadBalancerUDPListenerAttributeResponse{
 		DescribeLoadBalancerListenerAttributeResponse: slb.DescribeLoadBalancerListenerAttributeResponse{},
@@ -581,16 +881,26 @@ func (c *mockClientSLB) CreateLoadBalancerUDPListener(ctx context.Context, args
 	return nil
 }
 func (c *mockClientSLB) DeleteLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error) {
+	if err := c.inject(ctx, "DeleteLoadBalancerListener", loadBalancerId, port); err != nil {
+		return err
+	}
 	if c.deleteLoadBalancerListener != nil {
 		return c.deleteLoadBalancerListener(loadBalancerId, port)
 	}
+	defer c.serialize(ctx)()
 	LOADBALANCER.listeners.Delete(listenerKey(loadBalancerId, port))
+	LOADBALANCER.extensions.Delete(listenerKey(loadBalancerId, port))
+	LOADBALANCER.eccCertificates.Delete(listenerKey(loadBalancerId, port))
 	return nil
 }
 func (c *mockClientSLB) CreateLoadBalancerHTTPSListener(ctx context.Context, args *slb.CreateLoadBalancerHTTPSListenerArgs) (err error) {
+	if err := c.inject(ctx, "CreateLoadBalancerHTTPSListener", args); err != nil {
+		return err
+	}
 	if c.createLoadBalancerHTTPSListener != nil {
 		return c.createLoadBalancerHTTPSListener(args)
 	}
+	defer c.serialize(ctx)()
 
 	listener := &slb.DescribeLoadBalancerHTTPSListenerAttributeResponse{
 		DescribeLoadBalancerListenerAttributeResponse: slb.DescribeLoadBalancerListenerAttributeResponse{},
@@ -619,11 +929,14 @@ func (c *mockClientSLB) CreateLoadBalancerHTTPSListener(ctx context.Context, arg
 				AclType:                args.AclType,
 				AclId:                  args.AclId,
 				AclStatus:              args.AclStatus,
+				IdleTimeout:            args.IdleTimeout,
+				RequestTimeout:         args.RequestTimeout,
 				Scheduler:              args.Scheduler,
 			},
 			ServerCertificateId: args.ServerCertificateId,
 		},
 	}
+	mockHTTPHeaders(&listener.HTTPListenerType, &args.HTTPListenerType, true)
 	key := listenerKey(args.LoadBalancerId, args.ListenerPort)
 	_, ok := LOADBALANCER.listeners.Load(key)
 	if ok {
@@ -634,9 +947,13 @@ func (c *mockClientSLB) CreateLoadBalancerHTTPSListener(ctx context.Context, arg
 	return nil
 }
 func (c *mockClientSLB) CreateLoadBalancerHTTPListener(ctx context.Context, args *slb.CreateLoadBalancerHTTPListenerArgs) (err error) {
+	if err := c.inject(ctx, "CreateLoadBalancerHTTPListener", args); err != nil {
+		return err
+	}
 	if c.createLoadBalancerHTTPListener != nil {
 		return c.createLoadBalancerHTTPListener(args)
 	}
+	defer c.serialize(ctx)()
 	listener := &slb.DescribeLoadBalancerHTTPListenerAttributeResponse{
 		DescribeLoadBalancerListenerAttributeResponse: slb.DescribeLoadBalancerListenerAttributeResponse{},
 		HTTPListenerType: slb.HTTPListenerType{
@@ -663,9 +980,14 @@ func (c *mockClientSLB) CreateLoadBalancerHTTPListener(ctx context.Context, args
 			AclType:                args.AclType,
 			AclId:                  args.AclId,
 			AclStatus:              args.AclStatus,
+			IdleTimeout:            args.IdleTimeout,
+			RequestTimeout:         args.RequestTimeout,
 			Scheduler:              args.Scheduler,
+			ListenerForward:        args.ListenerForward,
+			ForwardPort:            args.ForwardPort,
 		},
 	}
+	mockHTTPHeaders(&listener.HTTPListenerType, (*slb.HTTPListenerType)(args), true)
 	key := listenerKey(args.LoadBalancerId, args.ListenerPort)
 	_, ok := LOADBALANCER.listeners.Load(key)
 	if ok {
@@ -675,9 +997,13 @@ func (c *mockClientSLB) CreateLoadBalancerHTTPListener(ctx context.Context, args
 	return nil
 }
 func (c *mockClientSLB) DescribeLoadBalancerHTTPSListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerHTTPSListenerAttributeResponse, err error) {
+	if err := c.inject(ctx, "DescribeLoadBalancerHTTPSListenerAttribute", loadBalancerId, port); err != nil {
+		return nil, err
+	}
 	if c.describeLoadBalancerHTTPSListenerAttribute != nil {
 		return c.describeLoadBalancerHTTPSListenerAttribute(loadBalancerId, port)
 	}
+	defer c.serialize(ctx)()
 	v, ok := LOADBALANCER.listeners.Load(listenerKey(loadBalancerId, port))
 	if !ok {
 		fmt.Printf("listener not found, %s, %d\n", loadBalancerId, port)
@@ -687,13 +1013,19 @@ func (c *mockClientSLB) DescribeLoadBalancerHTTPSListenerAttribute(ctx context.C
 	if !ok {
 		return nil, fmt.Errorf("not type HTTPS listener. %s", reflect.TypeOf(v))
 	}
-	return result, nil
+	// a copy, the stored listener is modified by the other calls
+	copied := *result
+	return &copied, nil
 }
 
 func (c *mockClientSLB) DescribeLoadBalancerTCPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerTCPListenerAttributeResponse, err error) {
+	if err := c.inject(ctx, "DescribeLoadBalancerTCPListenerAttribute", loadBalancerId, port); err != nil {
+		return nil, err
+	}
 	if c.describeLoadBalancerTCPListenerAttribute != nil {
 		return c.describeLoadBalancerTCPListenerAttribute(loadBalancerId, port)
 	}
+	defer c.serialize(ctx)()
 	v, ok := LOADBALANCER.listeners.Load(listenerKey(loadBalancerId, port))
 	if !ok {
 		fmt.Printf("listener not found, %s, %d\n", loadBalancerId, port)
@@ -703,13 +1035,19 @@ func (c *mockClientSLB) DescribeLoadBalancerTCPListenerAttribute(ctx context.Con
 	if !ok {
 		return nil, fmt.Errorf("not type TCP listener. %s", reflect.TypeOf(v))
 	}
-	return result, nil
+	// a copy, the stored listener is modified by the other calls
+	copied := *result
+	return &copied, nil
 }
 
 func (c *mockClientSLB) DescribeLoadBalancerUDPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerUDPListenerAttributeResponse, err error) {
+	if err := c.inject(ctx, "DescribeLoadBalancerUDPListenerAttribute", loadBalancerId, port); err != nil {
+		return nil, err
+	}
 	if c.describeLoadBalancerUDPListenerAttribute != nil {
 		return c.describeLoadBalancerUDPListenerAttribute(loadBalancerId, port)
 	}
+	defer c.serialize(ctx)()
 	v, ok := LOADBALANCER.listeners.Load(listenerKey(loadBalancerId, port))
 	if !ok {
 		fmt.Printf("listener not found, %s, %d\n", loadBalancerId, port)
@@ -719,13 +1057,19 @@ func (c *mockClientSLB) DescribeLoadBalancerUDPListenerAttribute(ctx context.Con
 	if !ok {
 		return nil, fmt.Errorf("not type UDP listener. %s", reflect.TypeOf(v))
 	}
-	return result, nil
+	// a copy, the stored listener is modified by the other calls
+	copied := *result
+	return &copied, nil
 }
 
 func (c *mockClientSLB) DescribeLoadBalancerHTTPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerHTTPListenerAttributeResponse, err error) {
+	if err := c.inject(ctx, "DescribeLoadBalancerHTTPListenerAttribute", loadBalancerId, port); err != nil {
+		return nil, err
+	}
 	if c.describeLoadBalancerHTTPListenerAttribute != nil {
 		return c.describeLoadBalancerHTTPListenerAttribute(loadBalancerId, port)
 	}
+	defer c.serialize(ctx)()
 	v, ok := LOADBALANCER.listeners.Load(listenerKey(loadBalancerId, port))
 	if !ok {
 		fmt.Printf("listener not found, %s, %d\n", loadBalancerId, port)
@@ -735,14 +1079,20 @@ func (c *mockClientSLB) DescribeLoadBalancerHTTPListenerAttribute(ctx context.Co
 	if !ok {
 		return nil, fmt.Errorf("not type HTTP listener. %s", reflect.TypeOf(v))
 	}
-	return result, nil
+	// a copy, the stored listener is modified by the other calls
+	copied := *result
+	return &copied, nil
 }
 
 func (c *mockClientSLB) SetLoadBalancerHTTPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerHTTPListenerAttributeArgs) (err error) {
+	if err := c.inject(ctx, "SetLoadBalancerHTTPListenerAttribute", args); err != nil {
+		return err
+	}
 	if c.setLoadBalancerHTTPListenerAttribute != nil {
 		return c.setLoadBalancerHTTPListenerAttribute(args)
 	}
-	lb, err := c.DescribeLoadBalancerHTTPListenerAttribute(ctx, args.LoadBalancerId, args.ListenerPort)
+	defer c.serialize(ctx)()
+	lb, err := c.DescribeLoadBalancerHTTPListenerAttribute(internalCall(ctx), args.LoadBalancerId, args.ListenerPort)
 	if err != nil {
 		return err
 	}
@@ -769,15 +1119,39 @@ func (c *mockClientSLB) SetLoadBalancerHTTPListenerAttribute(ctx context.Context
 	lb.AclId = args.AclId
 	lb.AclType = args.AclType
 	lb.Scheduler = args.Scheduler
+	lb.IdleTimeout = args.IdleTimeout
+	lb.RequestTimeout = args.RequestTimeout
+	mockHTTPHeaders(&lb.HTTPListenerType, (*slb.HTTPListenerType)(args), false)
 	LOADBALANCER.listeners.Store(listenerKey(args.LoadBalancerId, args.ListenerPort), lb)
 	return nil
 }
 
+// mockHTTPHeaders slb keeps the header & gzip switches which are not passed,
+// X-Forwarded-For and gzip are on by default.
+func mockHTTPHeaders(lb *slb.HTTPListenerType, args *slb.HTTPListenerType, create bool) {
+	if create {
+		lb.XForwardedFor = slb.OnFlag
+		lb.XForwardedFor_proto = slb.OffFlag
+		lb.XForwardedFor_SLBID = slb.OffFlag
+		lb.XForwardedFor_SLBIP = slb.OffFlag
+		lb.Gzip = slb.OnFlag
+	}
+	for i, flag := range httpHeaders(lb) {
+		if v := *httpHeaders(args)[i]; v != "" {
+			*flag = v
+		}
+	}
+}
+
 func (c *mockClientSLB) SetLoadBalancerHTTPSListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerHTTPSListenerAttributeArgs) (err error) {
+	if err := c.inject(ctx, "SetLoadBalancerHTTPSListenerAttribute", args); err != nil {
+		return err
+	}
 	if c.setLoadBalancerHTTPSListenerAttribute != nil {
 		return c.setLoadBalancerHTTPSListenerAttribute(args)
 	}
-	lb, err := c.DescribeLoadBalancerHTTPSListenerAttribute(ctx, args.LoadBalancerId, args.ListenerPort)
+	defer c.serialize(ctx)()
+	lb, err := c.DescribeLoadBalancerHTTPSListenerAttribute(internalCall(ctx), args.LoadBalancerId, args.ListenerPort)
 	if err != nil {
 		return err
 	}
@@ -804,16 +1178,23 @@ func (c *mockClientSLB) SetLoadBalancerHTTPSListenerAttribute(ctx context.Contex
 	lb.AclId = args.AclId
 	lb.AclType = args.AclType
 	lb.Scheduler = args.Scheduler
+	lb.IdleTimeout = args.IdleTimeout
+	lb.RequestTimeout = args.RequestTimeout
+	mockHTTPHeaders(&lb.HTTPListenerType, &args.HTTPListenerType, false)
 	LOADBALANCER.listeners.Store(listenerKey(args.LoadBalancerId, args.ListenerPort), lb)
 	return nil
 }
 
 func (c *mockClientSLB) SetLoadBalancerTCPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerTCPListenerAttributeArgs) (err error) {
+	if err := c.inject(ctx, "SetLoadBalancerTCPListenerAttribute", args); err != nil {
+		return err
+	}
 	if c.setLoadBalancerTCPListenerAttribute != nil {
 		return c.setLoadBalancerTCPListenerAttribute(args)
 	}
+	defer c.serialize(ctx)()
 
-	lb, err := c.DescribeLoadBalancerTCPListenerAttribute(ctx, args.LoadBalancerId, args.ListenerPort)
+	lb, err := c.DescribeLoadBalancerTCPListenerAttribute(internalCall(ctx), args.LoadBalancerId, args.ListenerPort)
 	if err != nil {
 		return err
 	}
@@ -825,6 +1206,7 @@ func (c *mockClientSLB) SetLoadBalancerTCPListenerAttribute(ctx context.Context,
 	lb.HealthCheckDomain = args.HealthCheckDomain
 	lb.HealthCheckConnectPort = args.HealthCheckConnectPort
 	lb.HealthCheckURI = args.HealthCheckURI
+	lb.HealthCheckType = args.HealthCheckType
 	lb.UnhealthyThreshold = args.UnhealthyThreshold
 	lb.ListenerPort = args.ListenerPort
 	lb.VServerGroup = args.VServerGroup
@@ -842,11 +1224,15 @@ func (c *mockClientSLB) SetLoadBalancerTCPListenerAttribute(ctx context.Context,
 }
 
 func (c *mockClientSLB) SetLoadBalancerUDPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerUDPListenerAttributeArgs) (err error) {
+	if err := c.inject(ctx, "SetLoadBalancerUDPListenerAttribute", args); err != nil {
+		return err
+	}
 	if c.setLoadBalancerUDPListenerAttribute != nil {
 		return c.setLoadBalancerUDPListenerAttribute(args)
 	}
+	defer c.serialize(ctx)()
 
-	lb, err := c.DescribeLoadBalancerUDPListenerAttribute(ctx, args.LoadBalancerId, args.ListenerPort)
+	lb, err := c.DescribeLoadBalancerUDPListenerAttribute(internalCall(ctx), args.LoadBalancerId, args.ListenerPort)
 	if err != nil {
 		return err
 	}
@@ -872,9 +1258,13 @@ func (c *mockClientSLB) SetLoadBalancerUDPListenerAttribute(ctx context.Context,
 }
 
 func (c *mockClientSLB) RemoveTags(ctx context.Context, args *slb.RemoveTagsArgs) error {
+	if err := c.inject(ctx, "RemoveTags", args); err != nil {
+		return err
+	}
 	if c.removeTags != nil {
 		return c.removeTags(args)
 	}
+	defer c.serialize(ctx)()
 	v, ok := LOADBALANCER.tags.Load(args.LoadBalancerID)
 	if !ok {
 		return nil
@@ -885,11 +1275,11 @@ func (c *mockClientSLB) RemoveTags(ctx context.Context, args *slb.RemoveTagsArgs
 		return err
 	}
 
-	ins, ok := v.([]slb.TagItem)
+	ins, ok := v.([]slb.TagItemType)
 	if !ok {
 		return fmt.Errorf("not TagItem type %s", reflect.TypeOf(v))
 	}
-	var result []slb.TagItem
+	var result []slb.TagItemType
 	for _, t := range ins {
 		found := false
 		for _, m := range *tags {
@@ -908,9 +1298,13 @@ func (c *mockClientSLB) RemoveTags(ctx context.Context, args *slb.RemoveTagsArgs
 }
 
 func (c *mockClientSLB) DescribeTags(ctx context.Context, args *slb.DescribeTagsArgs) (tags []slb.TagItemType, pagination *common.PaginationResult, err error) {
+	if err := c.inject(ctx, "DescribeTags", args); err != nil {
+		return nil, nil, err
+	}
 	if c.describeTags != nil {
 		return c.describeTags(args)
 	}
+	defer c.serialize(ctx)()
 	v, ok := LOADBALANCER.tags.Load(args.LoadBalancerID)
 	if !ok {
 		return []slb.TagItemType{}, nil, nil
@@ -923,9 +1317,13 @@ func (c *mockClientSLB) DescribeTags(ctx context.Context, args *slb.DescribeTags
 	return ins, nil, nil
 }
 func (c *mockClientSLB) AddTags(ctx context.Context, args *slb.AddTagsArgs) error {
+	if err := c.inject(ctx, "AddTags", args); err != nil {
+		return err
+	}
 	if c.addTags != nil {
 		return c.addTags(args)
 	}
+	defer c.serialize(ctx)()
 	tags := &[]slb.TagItem{}
 	err := json.Unmarshal([]byte(args.Tags), tags)
 	if err != nil {
@@ -962,14 +1360,71 @@ func (c *mockClientSLB) AddTags(ctx context.Context, args *slb.AddTagsArgs) erro
 	return nil
 }
 
+// hasTags whether all the wanted tags are found
+func hasTags(tags []slb.TagItemType, want []slb.TagItem) bool {
+	for _, w := range want {
+		found := false
+		for _, tag := range tags {
+			if tag.TagKey == w.TagKey && tag.TagValue == w.TagValue {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// DescribeHealthStatus all the backends of the vserver groups are healthy
+func (c *mockClientSLB) DescribeHealthStatus(ctx context.Context, args *DescribeHealthStatusArgs) (response *DescribeHealthStatusResponse, err error) {
+	if err := c.inject(ctx, "DescribeHealthStatus", args); err != nil {
+		return nil, err
+	}
+	if c.describeHealthStatus != nil {
+		return c.describeHealthStatus(args)
+	}
+	defer c.serialize(ctx)()
+	response = &DescribeHealthStatusResponse{}
+	LOADBALANCER.vgroups.Range(
+		func(key, value interface{}) bool {
+			if !strings.HasPrefix(key.(string), args.LoadBalancerId+"/") {
+				return true
+			}
+			for _, b := range value.(slb.CreateVServerGroupResponse).BackendServers.BackendServer {
+				health := SERVER_HEALTH_STATUS_NORMAL
+				if v, ok := LOADBALANCER.health.Load(b.ServerId); ok {
+					health = v.(string)
+				}
+				response.BackendServers.BackendServer = append(
+					response.BackendServers.BackendServer,
+					BackendServerHealthStatusType{
+						ServerId:           b.ServerId,
+						ServerIp:           b.ServerIp,
+						Port:               b.Port,
+						ServerHealthStatus: health,
+					},
+				)
+			}
+			return true
+		},
+	)
+	return response, nil
+}
+
 func vgroupKey(id, vgroupid string) string {
 	return fmt.Sprintf("%s/%s", id, vgroupid)
 }
 
 func (c *mockClientSLB) CreateVServerGroup(ctx context.Context, args *slb.CreateVServerGroupArgs) (response *slb.CreateVServerGroupResponse, err error) {
+	if err := c.inject(ctx, "CreateVServerGroup", args); err != nil {
+		return nil, err
+	}
 	if c.createVServerGroup != nil {
 		return c.createVServerGroup(args)
 	}
+	defer c.serialize(ctx)()
 	vgroup := slb.CreateVServerGroupResponse{
 		VServerGroupId:   newid(),
 		VServerGroupName: args.VServerGroupName,
@@ -979,10 +1434,13 @@ func (c *mockClientSLB) CreateVServerGroup(ctx context.Context, args *slb.Create
 }
 
 func (c *mockClientSLB) DescribeVServerGroups(ctx context.Context, args *slb.DescribeVServerGroupsArgs) (response *slb.DescribeVServerGroupsResponse, err error) {
-
+	if err := c.inject(ctx, "DescribeVServerGroups", args); err != nil {
+		return nil, err
+	}
 	if c.describeVServerGroups != nil {
 		return c.describeVServerGroups(args)
 	}
+	defer c.serialize(ctx)()
 	var vgr []slb.VServerGroup
 	LOADBALANCER.vgroups.Range(
 		func(key, value interface{}) bool {
@@ -1005,9 +1463,13 @@ func (c *mockClientSLB) DescribeVServerGroups(ctx context.Context, args *slb.Des
 }
 
 func (c *mockClientSLB) DeleteVServerGroup(ctx context.Context, args *slb.DeleteVServerGroupArgs) (response *slb.DeleteVServerGroupResponse, err error) {
+	if err := c.inject(ctx, "DeleteVServerGroup", args); err != nil {
+		return nil, err
+	}
 	if c.deleteVServerGroup != nil {
 		return c.deleteVServerGroup(args)
 	}
+	defer c.serialize(ctx)()
 	ikey := ""
 	LOADBALANCER.vgroups.Range(
 		func(key, value interface{}) bool {
@@ -1024,16 +1486,68 @@ func (c *mockClientSLB) DeleteVServerGroup(ctx context.Context, args *slb.Delete
 }
 
 func (c *mockClientSLB) SetVServerGroupAttribute(ctx context.Context, args *slb.SetVServerGroupAttributeArgs) (response *slb.SetVServerGroupAttributeResponse, err error) {
+	if err := c.inject(ctx, "SetVServerGroupAttribute", args); err != nil {
+		return nil, err
+	}
 	if c.setVServerGroupAttribute != nil {
 		return c.setVServerGroupAttribute(args)
 	}
-	return nil, nil
+	defer c.serialize(ctx)()
+	if args.BackendServers == "" && args.VServerGroupName == "" {
+		return nil, nil
+	}
+	ikey := ""
+	LOADBALANCER.vgroups.Range(
+		func(key, value interface{}) bool {
+			k := key.(string)
+			if strings.Contains(k, args.VServerGroupId) {
+				ikey = k
+				return false
+			}
+			return true
+		},
+	)
+	if ikey == "" {
+		return nil, fmt.Errorf("set: vgroup not found, %s", args.VServerGroupId)
+	}
+	v, _ := LOADBALANCER.vgroups.Load(ikey)
+	vgr := v.(slb.CreateVServerGroupResponse)
+	if args.VServerGroupName != "" {
+		vgr.VServerGroupName = args.VServerGroupName
+	}
+	// the backends returned by the former calls are not modified in place
+	vgr.BackendServers.BackendServer = append([]slb.VBackendServerType(nil), vgr.BackendServers.BackendServer...)
+	backends := &[]slb.VBackendServerType{}
+	if args.BackendServers != "" {
+		err = json.Unmarshal([]byte(args.BackendServers), backends)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, b := range *backends {
+		for i, cac := range vgr.BackendServers.BackendServer {
+			if backendKey(b) == backendKey(cac) {
+				vgr.BackendServers.BackendServer[i].Weight = b.Weight
+				vgr.BackendServers.BackendServer[i].Description = b.Description
+			}
+		}
+	}
+	LOADBALANCER.vgroups.Store(ikey, vgr)
+	return &slb.SetVServerGroupAttributeResponse{
+		VServerGroupId:   vgr.VServerGroupId,
+		VServerGroupName: vgr.VServerGroupName,
+		BackendServers:   vgr.BackendServers,
+	}, nil
 }
 
 func (c *mockClientSLB) DescribeVServerGroupAttribute(ctx context.Context, args *slb.DescribeVServerGroupAttributeArgs) (response *slb.DescribeVServerGroupAttributeResponse, err error) {
+	if err := c.inject(ctx, "DescribeVServerGroupAttribute", args); err != nil {
+		return nil, err
+	}
 	if c.describeVServerGroupAttribute != nil {
 		return c.describeVServerGroupAttribute(args)
 	}
+	defer c.serialize(ctx)()
 	ikey := ""
 	LOADBALANCER.vgroups.Range(
 		func(key, value interface{}) bool {
@@ -1060,15 +1574,23 @@ func (c *mockClientSLB) DescribeVServerGroupAttribute(ctx context.Context, args
 }
 
 func (c *mockClientSLB) ModifyVServerGroupBackendServers(ctx context.Context, args *slb.ModifyVServerGroupBackendServersArgs) (response *slb.ModifyVServerGroupBackendServersResponse, err error) {
+	if err := c.inject(ctx, "ModifyVServerGroupBackendServers", args); err != nil {
+		return nil, err
+	}
 	if c.modifyVServerGroupBackendServers != nil {
 		return c.modifyVServerGroupBackendServers(args)
 	}
+	defer c.serialize(ctx)()
 	return nil, nil
 }
 func (c *mockClientSLB) AddVServerGroupBackendServers(ctx context.Context, args *slb.AddVServerGroupBackendServersArgs) (response *slb.AddVServerGroupBackendServersResponse, err error) {
+	if err := c.inject(ctx, "AddVServerGroupBackendServers", args); err != nil {
+		return nil, err
+	}
 	if c.addVServerGroupBackendServers != nil {
 		return c.addVServerGroupBackendServers(args)
 	}
+	defer c.serialize(ctx)()
 	ikey := ""
 	LOADBALANCER.vgroups.Range(
 		func(key, value interface{}) bool {
@@ -1094,8 +1616,7 @@ func (c *mockClientSLB) AddVServerGroupBackendServers(ctx context.Context, args
 	for _, b := range *backends {
 		found := false
 		for _, cac := range vgr.BackendServers.BackendServer {
-			if b.ServerId == cac.ServerId &&
-				b.ServerIp == cac.ServerIp {
+			if backendKey(b) == backendKey(cac) {
 				found = true
 				break
 			}
@@ -1113,9 +1634,13 @@ func (c *mockClientSLB) AddVServerGroupBackendServers(ctx context.Context, args
 }
 
 func (c *mockClientSLB) RemoveVServerGroupBackendServers(ctx context.Context, args *slb.RemoveVServerGroupBackendServersArgs) (response *slb.RemoveVServerGroupBackendServersResponse, err error) {
+	if err := c.inject(ctx, "RemoveVServerGroupBackendServers", args); err != nil {
+		return nil, err
+	}
 	if c.removeVServerGroupBackendServers != nil {
 		return c.removeVServerGroupBackendServers(args)
 	}
+	defer c.serialize(ctx)()
 	ikey := ""
 	LOADBALANCER.vgroups.Range(
 		func(key, value interface{}) bool {
@@ -1141,8 +1666,7 @@ func (c *mockClientSLB) RemoveVServerGroupBackendServers(ctx context.Context, ar
 	for _, b := range vgr.BackendServers.BackendServer {
 		found := false
 		for _, cac := range *backends {
-			if b.ServerId == cac.ServerId &&
-				b.ServerIp == cac.ServerIp {
+			if backendKey(b) == backendKey(cac) {
 				found = true
 				break
 			}
@@ -1161,8 +1685,445 @@ func (c *mockClientSLB) RemoveVServerGroupBackendServers(ctx context.Context, ar
 }
 
 func (c *mockClientSLB) SetLoadBalancerModificationProtection(ctx context.Context, args *slb.SetLoadBalancerModificationProtectionArgs) (err error) {
+	if err := c.inject(ctx, "SetLoadBalancerModificationProtection", args); err != nil {
+		return err
+	}
 	if c.setLoadBalancerModificationProtection != nil {
 		return c.setLoadBalancerModificationProtection(args)
 	}
+	defer c.serialize(ctx)()
+	v, ok := LOADBALANCER.loadbalancer.Load(args.LoadBalancerId)
+	if !ok {
+		return fmt.Errorf("loadbalancer not found by id %s", args.LoadBalancerId)
+	}
+	ins, ok := v.(slb.LoadBalancerType)
+	if !ok {
+		return fmt.Errorf("not slb.LoadBalancerType")
+	}
+	ins.ModificationProtectionStatus = args.ModificationProtectionStatus
+	ins.ModificationProtectionReason = args.ModificationProtectionReason
+	LOADBALANCER.loadbalancer.Store(ins.LoadBalancerId, ins)
+	return nil
+}
+
+func (c *mockClientSLB) DescribeServerCertificates(ctx context.Context, args *DescribeServerCertificatesArgs) (certificates []ServerCertificateType, err error) {
+	if err := c.inject(ctx, "DescribeServerCertificates", args); err != nil {
+		return nil, err
+	}
+	if c.describeServerCertificates != nil {
+		return c.describeServerCertificates(args)
+	}
+	defer c.serialize(ctx)()
+	if args.ServerCertificateId == "" {
+		LOADBALANCER.certificates.Range(
+			func(key, value interface{}) bool {
+				certificates = append(certificates, value.(ServerCertificateType))
+				return true
+			},
+		)
+		return certificates, nil
+	}
+	if v, ok := LOADBALANCER.certificates.Load(args.ServerCertificateId); ok {
+		return []ServerCertificateType{v.(ServerCertificateType)}, nil
+	}
+	// any other certificate is regarded as uploaded by the user
+	return []ServerCertificateType{
+		{
+			ServerCertificateId:   args.ServerCertificateId,
+			ServerCertificateName: args.ServerCertificateId,
+			CommonName:            fmt.Sprintf("%s.example.com", args.ServerCertificateId),
+		},
+	}, nil
+}
+
+func (c *mockClientSLB) UploadServerCertificate(ctx context.Context, args *UploadServerCertificateArgs) (response *UploadServerCertificateResponse, err error) {
+	if err := c.inject(ctx, "UploadServerCertificate", args); err != nil {
+		return nil, err
+	}
+	if c.uploadServerCertificate != nil {
+		return c.uploadServerCertificate(args)
+	}
+	defer c.serialize(ctx)()
+	id := fmt.Sprintf("cert-%s", args.ServerCertificateName)
+	LOADBALANCER.certificates.Store(
+		id,
+		ServerCertificateType{
+			ServerCertificateId:   id,
+			ServerCertificateName: args.ServerCertificateName,
+		},
+	)
+	return &UploadServerCertificateResponse{
+		ServerCertificateId:   id,
+		ServerCertificateName: args.ServerCertificateName,
+	}, nil
+}
+
+func (c *mockClientSLB) DeleteServerCertificate(ctx context.Context, args *DeleteServerCertificateArgs) (err error) {
+	if err := c.inject(ctx, "DeleteServerCertificate", args); err != nil {
+		return err
+	}
+	if c.deleteServerCertificate != nil {
+		return c.deleteServerCertificate(args)
+	}
+	defer c.serialize(ctx)()
+	LOADBALANCER.certificates.Delete(args.ServerCertificateId)
+	return nil
+}
+
+func (c *mockClientSLB) DescribeDomainExtensions(ctx context.Context, args *DescribeDomainExtensionsArgs) (extensions []DomainExtensionType, err error) {
+	if err := c.inject(ctx, "DescribeDomainExtensions", args); err != nil {
+		return nil, err
+	}
+	if c.describeDomainExtensions != nil {
+		return c.describeDomainExtensions(args)
+	}
+	defer c.serialize(ctx)()
+	v, ok := LOADBALANCER.domainExtensions.Load(listenerKey(args.LoadBalancerId, args.ListenerPort))
+	if !ok {
+		return nil, nil
+	}
+	return append([]DomainExtensionType{}, v.([]DomainExtensionType)...), nil
+}
+
+func (c *mockClientSLB) CreateDomainExtension(ctx context.Context, args *CreateDomainExtensionArgs) (response *CreateDomainExtensionResponse, err error) {
+	if err := c.inject(ctx, "CreateDomainExtension", args); err != nil {
+		return nil, err
+	}
+	if c.createDomainExtension != nil {
+		return c.createDomainExtension(args)
+	}
+	defer c.serialize(ctx)()
+	key := listenerKey(args.LoadBalancerId, args.ListenerPort)
+	var extensions []DomainExtensionType
+	if v, ok := LOADBALANCER.domainExtensions.Load(key); ok {
+		extensions = v.([]DomainExtensionType)
+	}
+	for _, ext := range extensions {
+		if ext.Domain == args.Domain {
+			return nil, fmt.Errorf("domain extension exist %s", args.Domain)
+		}
+	}
+	id := fmt.Sprintf("de-%s-%d-%s", args.LoadBalancerId, args.ListenerPort, args.ServerCertificateId)
+	extensions = append(extensions, DomainExtensionType{
+		DomainExtensionId:   id,
+		Domain:              args.Domain,
+		ServerCertificateId: args.ServerCertificateId,
+	})
+	LOADBALANCER.domainExtensions.Store(key, extensions)
+	return &CreateDomainExtensionResponse{DomainExtensionId: id, ListenerPort: args.ListenerPort}, nil
+}
+
+func (c *mockClientSLB) DeleteDomainExtension(ctx context.Context, args *DeleteDomainExtensionArgs) (err error) {
+	if err := c.inject(ctx, "DeleteDomainExtension", args); err != nil {
+		return err
+	}
+	if c.deleteDomainExtension != nil {
+		return c.deleteDomainExtension(args)
+	}
+	defer c.serialize(ctx)()
+	LOADBALANCER.domainExtensions.Range(
+		func(key, value interface{}) bool {
+			var remain []DomainExtensionType
+			for _, ext := range value.([]DomainExtensionType) {
+				if ext.DomainExtensionId != args.DomainExtensionId {
+					remain = append(remain, ext)
+				}
+			}
+			LOADBALANCER.domainExtensions.Store(key, remain)
+			return true
+		},
+	)
+	return nil
+}
+
+func (c *mockClientSLB) DescribeAccessControlListAttribute(ctx context.Context, args *DescribeAccessControlListAttributeArgs) (response *DescribeAccessControlListAttributeResponse, err error) {
+	if err := c.inject(ctx, "DescribeAccessControlListAttribute", args); err != nil {
+		return nil, err
+	}
+	if c.describeAccessControlListAttribute != nil {
+		return c.describeAccessControlListAttribute(args)
+	}
+	defer c.serialize(ctx)()
+	v, ok := LOADBALANCER.acls.Load(args.AclId)
+	if !ok {
+		return nil, &common.Error{
+			ErrorResponse: common.ErrorResponse{
+				Code:    ACL_NOT_EXIST,
+				Message: fmt.Sprintf("The specified AclId %s does not exist.", args.AclId),
+			},
+			StatusCode: 400,
+		}
+	}
+	return v.(*DescribeAccessControlListAttributeResponse), nil
+}
+
+func (c *mockClientSLB) DescribeAccessControlLists(ctx context.Context, args *DescribeAccessControlListsArgs) (acls []AclType, err error) {
+	if err := c.inject(ctx, "DescribeAccessControlLists", args); err != nil {
+		return nil, err
+	}
+	if c.describeAccessControlLists != nil {
+		return c.describeAccessControlLists(args)
+	}
+	defer c.serialize(ctx)()
+	LOADBALANCER.acls.Range(
+		func(key, value interface{}) bool {
+			acl := value.(*DescribeAccessControlListAttributeResponse)
+			if args.AclName == "" || args.AclName == acl.AclName {
+				acls = append(acls, AclType{AclId: acl.AclId, AclName: acl.AclName})
+			}
+			return true
+		},
+	)
+	return acls, nil
+}
+
+func (c *mockClientSLB) CreateAccessControlList(ctx context.Context, args *CreateAccessControlListArgs) (response *CreateAccessControlListResponse, err error) {
+	if err := c.inject(ctx, "CreateAccessControlList", args); err != nil {
+		return nil, err
+	}
+	if c.createAccessControlList != nil {
+		return c.createAccessControlList(args)
+	}
+	defer c.serialize(ctx)()
+	id := strings.Replace(newid(), "lb-", "acl-", 1)
+	LOADBALANCER.acls.Store(id, &DescribeAccessControlListAttributeResponse{AclId: id, AclName: args.AclName})
+	return &CreateAccessControlListResponse{AclId: id}, nil
+}
+
+func (c *mockClientSLB) DeleteAccessControlList(ctx context.Context, args *DeleteAccessControlListArgs) (err error) {
+	if err := c.inject(ctx, "DeleteAccessControlList", args); err != nil {
+		return err
+	}
+	if c.deleteAccessControlList != nil {
+		return c.deleteAccessControlList(args)
+	}
+	defer c.serialize(ctx)()
+	LOADBALANCER.acls.Delete(args.AclId)
+	return nil
+}
+
+func (c *mockClientSLB) AddAccessControlListEntry(ctx context.Context, args *AccessControlListEntryArgs) (err error) {
+	if err := c.inject(ctx, "AddAccessControlListEntry", args); err != nil {
+		return err
+	}
+	if c.addAccessControlListEntry != nil {
+		return c.addAccessControlListEntry(args)
+	}
+	defer c.serialize(ctx)()
+	return updateMockAclEntry(args, false)
+}
+
+func (c *mockClientSLB) RemoveAccessControlListEntry(ctx context.Context, args *AccessControlListEntryArgs) (err error) {
+	if err := c.inject(ctx, "RemoveAccessControlListEntry", args); err != nil {
+		return err
+	}
+	if c.removeAccessControlListEntry != nil {
+		return c.removeAccessControlListEntry(args)
+	}
+	defer c.serialize(ctx)()
+	return updateMockAclEntry(args, true)
+}
+
+func updateMockAclEntry(args *AccessControlListEntryArgs, remove bool) error {
+	v, ok := LOADBALANCER.acls.Load(args.AclId)
+	if !ok {
+		return fmt.Errorf("acl not found: %s", args.AclId)
+	}
+	var entrys []map[string]string
+	if err := json.Unmarshal([]byte(args.AclEntrys), &entrys); err != nil {
+		return err
+	}
+	if len(entrys) > ACL_ENTRY_BATCH {
+		return fmt.Errorf("too many acl entries in one call: %d", len(entrys))
+	}
+	acl := v.(*DescribeAccessControlListAttributeResponse)
+	for _, e := range entrys {
+		var remain []AclEntry
+		for _, exist := range acl.AclEntrys.AclEntry {
+			if exist.AclEntryIP != e["entry"] {
+				remain = append(remain, exist)
+			}
+		}
+		if !remove {
+			remain = append(remain, AclEntry{AclEntryIP: e["entry"], AclEntryComment: e["comment"]})
+		}
+		acl.AclEntrys.AclEntry = remain
+	}
+	return nil
+}
+
+func (c *mockClientSLB) DescribeAccessLogsDownloadAttribute(ctx context.Context, args *DescribeAccessLogsDownloadAttributeArgs) (attrs []AccessLogAttribute, err error) {
+	if err := c.inject(ctx, "DescribeAccessLogsDownloadAttribute", args); err != nil {
+		return nil, err
+	}
+	if c.describeAccessLogsDownloadAttribute != nil {
+		return c.describeAccessLogsDownloadAttribute(args)
+	}
+	defer c.serialize(ctx)()
+	if v, ok := LOADBALANCER.accessLogs.Load(args.LoadBalancerId); ok {
+		return []AccessLogAttribute{v.(AccessLogAttribute)}, nil
+	}
+	return nil, nil
+}
+
+func (c *mockClientSLB) SetAccessLogsDownloadAttribute(ctx context.Context, args *AccessLogsDownloadAttributeArgs) (err error) {
+	if err := c.inject(ctx, "SetAccessLogsDownloadAttribute", args); err != nil {
+		return err
+	}
+	if c.setAccessLogsDownloadAttribute != nil {
+		return c.setAccessLogsDownloadAttribute(args)
+	}
+	defer c.serialize(ctx)()
+	var attrs []AccessLogAttribute
+	if err := json.Unmarshal([]byte(args.LogsDownloadAttributes), &attrs); err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		LOADBALANCER.accessLogs.Store(attr.LoadBalancerId, attr)
+	}
+	return nil
+}
+
+func (c *mockClientSLB) DeleteAccessLogsDownloadAttribute(ctx context.Context, args *AccessLogsDownloadAttributeArgs) (err error) {
+	if err := c.inject(ctx, "DeleteAccessLogsDownloadAttribute", args); err != nil {
+		return err
+	}
+	if c.deleteAccessLogsDownloadAttribute != nil {
+		return c.deleteAccessLogsDownloadAttribute(args)
+	}
+	defer c.serialize(ctx)()
+	var attrs []AccessLogAttribute
+	if err := json.Unmarshal([]byte(args.LogsDownloadAttributes), &attrs); err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		LOADBALANCER.accessLogs.Delete(attr.LoadBalancerId)
+	}
+	return nil
+}
+
+func (c *mockClientSLB) DescribeListenerExtension(ctx context.Context, proto string, args *DescribeListenerExtensionArgs) (extension *ListenerExtension, err error) {
+	if err := c.inject(ctx, "DescribeListenerExtension", proto, args); err != nil {
+		return nil, err
+	}
+	if c.describeListenerExtension != nil {
+		return c.describeListenerExtension(proto, args)
+	}
+	defer c.serialize(ctx)()
+	key := listenerKey(args.LoadBalancerId, args.ListenerPort)
+	listener, ok := LOADBALANCER.listeners.Load(key)
+	if !ok {
+		return nil, fmt.Errorf("not found listener: %s %d ", args.LoadBalancerId, args.ListenerPort)
+	}
+	ext := &ListenerExtension{ConnectionDrain: string(slb.OffFlag)}
+	if proto == "tcp" {
+		ext.EstablishedTimeout = ESTABLISHED_TIMEOUT_MAX
+	}
+	if v, ok := LOADBALANCER.extensions.Load(key); ok {
+		*ext = *v.(*ListenerExtension)
+	}
+	switch l := listener.(type) {
+	case *slb.DescribeLoadBalancerTCPListenerAttributeResponse:
+		ext.HealthCheck = string(l.HealthCheck)
+	case *slb.DescribeLoadBalancerUDPListenerAttributeResponse:
+		ext.HealthCheck = string(l.HealthCheck)
+	}
+	return ext, nil
+}
+
+func (c *mockClientSLB) SetListenerExtension(ctx context.Context, proto string, args *SetListenerExtensionArgs) (err error) {
+	if err := c.inject(ctx, "SetListenerExtension", proto, args); err != nil {
+		return err
+	}
+	if c.setListenerExtension != nil {
+		return c.setListenerExtension(proto, args)
+	}
+	defer c.serialize(ctx)()
+	current, err := c.DescribeListenerExtension(
+		internalCall(ctx), proto,
+		&DescribeListenerExtensionArgs{LoadBalancerId: args.LoadBalancerId, ListenerPort: args.ListenerPort},
+	)
+	if err != nil {
+		return err
+	}
+	// empty values are not sent to slb, keep the current ones.
+	if args.ConnectionDrain != "" {
+		current.ConnectionDrain = args.ConnectionDrain
+	}
+	if args.ConnectionDrainTimeout != 0 {
+		current.ConnectionDrainTimeout = args.ConnectionDrainTimeout
+	}
+	if args.EstablishedTimeout != 0 {
+		current.EstablishedTimeout = args.EstablishedTimeout
+	}
+	if args.HealthCheckReq != "" {
+		current.HealthCheckReq = args.HealthCheckReq
+	}
+	if args.HealthCheckExp != "" {
+		current.HealthCheckExp = args.HealthCheckExp
+	}
+	current.ProxyProtocolV2Enabled = args.ProxyProtocolV2Enabled
+	if args.HealthCheckSwitch != "" {
+		key := listenerKey(args.LoadBalancerId, args.ListenerPort)
+		listener, _ := LOADBALANCER.listeners.Load(key)
+		switch l := listener.(type) {
+		case *slb.DescribeLoadBalancerTCPListenerAttributeResponse:
+			l.HealthCheck = slb.FlagType(args.HealthCheckSwitch)
+		case *slb.DescribeLoadBalancerUDPListenerAttributeResponse:
+			l.HealthCheck = slb.FlagType(args.HealthCheckSwitch)
+		}
+	}
+	// describe only
+	current.HealthCheck = ""
+	if current.ConnectionDrain == string(slb.OffFlag) {
+		current.ConnectionDrainTimeout = 0
+	}
+	LOADBALANCER.extensions.Store(listenerKey(args.LoadBalancerId, args.ListenerPort), current)
+	return nil
+}
+
+func (c *mockClientSLB) DescribeHTTPSListenerCertificates(ctx context.Context, args *DescribeListenerExtensionArgs) (certificates *ListenerCertificates, err error) {
+	if err := c.inject(ctx, "DescribeHTTPSListenerCertificates", args); err != nil {
+		return nil, err
+	}
+	if c.describeHTTPSListenerCertificates != nil {
+		return c.describeHTTPSListenerCertificates(args)
+	}
+	defer c.serialize(ctx)()
+	key := listenerKey(args.LoadBalancerId, args.ListenerPort)
+	v, ok := LOADBALANCER.listeners.Load(key)
+	listener, https := v.(*slb.DescribeLoadBalancerHTTPSListenerAttributeResponse)
+	if !ok || !https {
+		return nil, fmt.Errorf("not found https listener: %s %d ", args.LoadBalancerId, args.ListenerPort)
+	}
+	certificates = &ListenerCertificates{ServerCertificateId: listener.ServerCertificateId}
+	if ecc, ok := LOADBALANCER.eccCertificates.Load(key); ok {
+		certificates.EccServerCertificateId = ecc.(string)
+	}
+	return certificates, nil
+}
+
+func (c *mockClientSLB) SetHTTPSListenerCertificates(ctx context.Context, args *SetListenerCertificatesArgs) (err error) {
+	if err := c.inject(ctx, "SetHTTPSListenerCertificates", args); err != nil {
+		return err
+	}
+	if c.setHTTPSListenerCertificates != nil {
+		return c.setHTTPSListenerCertificates(args)
+	}
+	defer c.serialize(ctx)()
+	key := listenerKey(args.LoadBalancerId, args.ListenerPort)
+	v, ok := LOADBALANCER.listeners.Load(key)
+	if _, https := v.(*slb.DescribeLoadBalancerHTTPSListenerAttributeResponse); !ok || !https {
+		return fmt.Errorf("not found https listener: %s %d ", args.LoadBalancerId, args.ListenerPort)
+	}
+	// nil is not sent to slb, keep the current one
+	if args.EccServerCertificateId == nil {
+		return nil
+	}
+	if *args.EccServerCertificateId == "" {
+		LOADBALANCER.eccCertificates.Delete(key)
+		return nil
+	}
+	LOADBALANCER.eccCertificates.Store(key, *args.EccServerCertificateId)
 	return nil
 }
@@ -0,0 +1,219 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"regexp"
+	"strings"
+)
+
+// A loadbalancer is named by the uid of its service unless the name
+// annotation is set. The names of the loadbalancers are not unique, so that
+// a loadbalancer is only taken as the one of the service by its name if it is
+// owned by the service, see isLoadBalancerOwned. A loadbalancer of the name
+// not owned by the service is a conflict: no loadbalancer of the same name is
+// created or renamed to, the service is told by an event instead.
+//
+// With --lb-name-template the loadbalancers created are named by the template
+// instead, and those owned by the services are renamed on the next sync once
+// the template changes. The name annotation overrides the template, and a
+// reused loadbalancer of the id annotation is never renamed. The lookup goes
+// by the ownership tags, so that a renamed loadbalancer is still found.
+
+// LoadBalancerNameTemplate the template of the names of the loadbalancers,
+// set by --lb-name-template, eg. "k8s-{cluster}-{namespace}-{service}"
+var LoadBalancerNameTemplate = ""
+
+var namePlaceholder = regexp.MustCompile(`{[^{}]*}`)
+
+// the placeholders of the name template
+var namePlaceholders = map[string]func(service *v1.Service) string{
+	"{cluster}":   func(*v1.Service) string { return CLUSTER_ID },
+	"{namespace}": func(service *v1.Service) string { return service.Namespace },
+	"{service}":   func(service *v1.Service) string { return service.Name },
+	"{uid}":       func(service *v1.Service) string { return string(service.UID) },
+}
+
+// ValidateLoadBalancerNameTemplate the template must be made of the known
+// placeholders {cluster}, {namespace}, {service} and {uid}
+func ValidateLoadBalancerNameTemplate(template string) error {
+	for _, placeholder := range namePlaceholder.FindAllString(template, -1) {
+		if _, ok := namePlaceholders[placeholder]; !ok {
+			return fmt.Errorf("unknown placeholder %s in loadbalancer name template %q", placeholder, template)
+		}
+	}
+	if strings.ContainsAny(namePlaceholder.ReplaceAllString(template, ""), "{}") {
+		return fmt.Errorf("unbalanced braces in loadbalancer name template %q", template)
+	}
+	return nil
+}
+
+// renderLoadBalancerName the name of the template for the service, a name
+// exceeding MAX_NAME_LENGTH ends with the hash of its tail
+func renderLoadBalancerName(template string, service *v1.Service) string {
+	name := namePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		if value, ok := namePlaceholders[placeholder]; ok {
+			return value(service)
+		}
+		return placeholder
+	})
+	return truncateName(name, MAX_NAME_LENGTH)
+}
+
+// templatedLoadBalancerName the name of the template for the service, empty
+// if no template is set or the name annotation overrides it
+func templatedLoadBalancerName(service *v1.Service) string {
+	if LoadBalancerNameTemplate == "" ||
+		serviceAnnotation(service, ServiceAnnotationLoadBalancerName) != "" {
+		return ""
+	}
+	return renderLoadBalancerName(LoadBalancerNameTemplate, service)
+}
+
+// loadBalancerName the name of the loadbalancer of the service
+func loadBalancerName(service *v1.Service) string {
+	if name := serviceAnnotation(service, ServiceAnnotationLoadBalancerName); name != "" {
+		return name
+	}
+	if name := templatedLoadBalancerName(service); name != "" {
+		return name
+	}
+	return GetLoadBalancerName(service)
+}
+
+// ensureTemplatedName rename the loadbalancer owned by the service to the name
+// of the template. Checked on every sync, so that a template change applies
+// to the unchanged services as well.
+func ensureTemplatedName(
+	ctx context.Context, client ClientSLBSDK, lb *slb.LoadBalancerType,
+	service *v1.Service, tags []slb.TagItemType,
+) error {
+	name := templatedLoadBalancerName(service)
+	if name == "" || name == lb.LoadBalancerName {
+		return nil
+	}
+	// a reused or untagged loadbalancer keeps its name, the untagged ones are
+	// found by it
+	if isUserDefinedLoadBalancer(service) || !hasOwnershipTag(tags) {
+		return nil
+	}
+	if owned, _ := isLoadBalancerOwned(tags, lb, service); !owned {
+		return nil
+	}
+	utils.FromContext(ctx).Info("Name template changed, rename loadbalancer", utils.LogKeySLBID, lb.LoadBalancerId,
+		"from", lb.LoadBalancerName, "to", name)
+	if err := client.SetLoadBalancerName(ctx, lb.LoadBalancerId, name); err != nil {
+		return fmt.Errorf("rename loadbalancer %s to %s: %s", lb.LoadBalancerId, name, err.Error())
+	}
+	lb.LoadBalancerName = name
+	return nil
+}
+
+// describeLoadBalancersByName the loadbalancers of the name with their tags
+func describeLoadBalancersByName(
+	ctx context.Context, client ClientSLBSDK, name string,
+) ([]slb.LoadBalancerType, [][]slb.TagItemType, error) {
+	lbs, err := describeAllLoadBalancers(
+		ctx,
+		client,
+		DescribeLoadBalancersPageArgs{
+			RegionId:         DEFAULT_REGION,
+			LoadBalancerName: name,
+		},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	tags := make([][]slb.TagItemType, len(lbs))
+	for i := range lbs {
+		tags[i], _, err = client.DescribeTags(
+			ctx,
+			&slb.DescribeTagsArgs{
+				RegionId:       lbs[i].RegionId,
+				LoadBalancerID: lbs[i].LoadBalancerId,
+			},
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("describe tags of loadbalancer %s: %s", lbs[i].LoadBalancerId, err.Error())
+		}
+	}
+	return lbs, tags, nil
+}
+
+// FindOwnedLoadBalancerByName find the loadbalancer of the name owned by the
+// service, those of the same name owned by others are skipped
+func (s *LoadBalancerClient) FindOwnedLoadBalancerByName(
+	ctx context.Context, service *v1.Service, name string,
+) (bool, *slb.LoadBalancerType, error) {
+	utils.FromContext(ctx).V(utils.LOG_SYNC_VERBOSITY).Info("Find owned loadbalancer by name", "name", name)
+	lbs, tags, err := describeLoadBalancersByName(ctx, s.c, name)
+	if err != nil {
+		return false, nil, err
+	}
+	for i := range lbs {
+		if owned, _ := isLoadBalancerOwned(tags[i], &lbs[i], service); owned {
+			return s.FindLoadBalancerByID(ctx, lbs[i].LoadBalancerId)
+		}
+	}
+	return false, nil, nil
+}
+
+// loadBalancerNameConflict the loadbalancer of the name not owned by the
+// service, other than the loadbalancer self, with the reason it is not owned.
+// nil if the name is free for the service.
+func loadBalancerNameConflict(
+	ctx context.Context, client ClientSLBSDK, service *v1.Service, name, self string,
+) (*slb.LoadBalancerType, string, error) {
+	lbs, tags, err := describeLoadBalancersByName(ctx, client, name)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range lbs {
+		if lbs[i].LoadBalancerId == self {
+			continue
+		}
+		if owned, reason := isLoadBalancerOwned(tags[i], &lbs[i], service); !owned {
+			return &lbs[i], reason, nil
+		}
+	}
+	return nil, "", nil
+}
+
+func recordLoadBalancerNameConflict(
+	ctx context.Context, service *v1.Service, name string, lb *slb.LoadBalancerType, reason string,
+) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "loadbalancer name %s conflicts with loadbalancer %s, %s", name, lb.LoadBalancerId, reason)
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"LoadBalancerNameConflict",
+		"Loadbalancer name %s of annotation %s is taken by loadbalancer %s not owned by the service, %s. "+
+			"Choose another name",
+		name, ServiceAnnotationLoadBalancerName, lb.LoadBalancerId, reason,
+	)
+}
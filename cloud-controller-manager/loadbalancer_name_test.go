@@ -0,0 +1,270 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+)
+
+const UNOWNED_NAMED_ID = "lb-unowned-named"
+
+// WithUnownedNamedLoadBalancer a loadbalancer of the name owned by another
+// cluster
+func WithUnownedNamedLoadBalancer(name string) CloudDataMock {
+	return func() {
+		LOADBALANCER.loadbalancer.Store(
+			UNOWNED_NAMED_ID,
+			slb.LoadBalancerType{
+				LoadBalancerId:   UNOWNED_NAMED_ID,
+				LoadBalancerName: name,
+				RegionId:         REGION,
+			},
+		)
+		LOADBALANCER.tags.Store(UNOWNED_NAMED_ID, gcTags(map[string]string{
+			TAGKEY: "aanotherservice",
+			ACKKEY: "another-cluster",
+		}))
+	}
+}
+
+func namedService(name string) *v1.Service {
+	return testService(map[string]string{ServiceAnnotationLoadBalancerName: name})
+}
+
+// ensureNamed ensure the service and return its loadbalancer and the events
+// recorded
+func ensureNamed(f *FrameWork) (*slb.LoadBalancerType, string, error) {
+	_, events, err := f.EnsureWithEvents()
+	if err != nil {
+		return nil, events, err
+	}
+	_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+	if err == nil && lb == nil {
+		err = fmt.Errorf("loadbalancer not found")
+	}
+	return lb, events, err
+}
+
+func TestLoadBalancerName(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(namedService("team-foo-api")).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	var created string
+	f.RunCustomized(t, "Create with name",
+		func(f *FrameWork) error {
+			lb, _, err := ensureNamed(f)
+			if err != nil {
+				return err
+			}
+			if lb.LoadBalancerName != "team-foo-api" {
+				return fmt.Errorf("expect loadbalancer named team-foo-api, got %s", lb.LoadBalancerName)
+			}
+			if name := f.CloudImpl().GetLoadBalancerName(context.Background(), CLUSTER_ID, f.SVC); name != "team-foo-api" {
+				return fmt.Errorf("expect GetLoadBalancerName of the annotation, got %s", name)
+			}
+			created = lb.LoadBalancerId
+
+			// found by the name once the tags are lost
+			LOADBALANCER.tags.Store(created, gcTags(map[string]string{SERVICEUIDKEY: serviceUIDNoneExist}))
+			exists, found, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || !exists || found.LoadBalancerId != created {
+				return fmt.Errorf("expect loadbalancer %s found by name, got %v, %v", created, found, err)
+			}
+			LOADBALANCER.tags.Store(created, gcTags(ownershipTags(f.SVC)))
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerName] = "team-foo-web"
+	f.RunCustomized(t, "Rename",
+		func(f *FrameWork) error {
+			faults := f.SLBFaults()
+			lb, _, err := ensureNamed(f)
+			if err != nil {
+				return err
+			}
+			if lb.LoadBalancerId != created || lb.LoadBalancerName != "team-foo-web" {
+				return fmt.Errorf("expect loadbalancer %s renamed to team-foo-web, got %s %s",
+					created, lb.LoadBalancerId, lb.LoadBalancerName)
+			}
+			if calls := faults.Calls("CreateLoadBalancer"); len(calls) != 0 {
+				return fmt.Errorf("expect no loadbalancer created on rename, got %v", calls)
+			}
+			return nil
+		},
+	)
+}
+
+func TestLoadBalancerNameConflict(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: prid},
+			Spec:       v1.NodeSpec{ProviderID: prid},
+		},
+	}
+	preset := func() {
+		DefaultPreset()
+		PreSetCloudData(WithUnownedNamedLoadBalancer("team-foo-api"))
+	}
+
+	f := NewDefaultFrameWork(preset)
+	f.WithService(namedService("team-foo-api")).WithNodes(nodes)
+	f.RunCustomized(t, "Create with a name taken",
+		func(f *FrameWork) error {
+			faults := f.SLBFaults()
+			_, events, err := ensureNamed(f)
+			if err == nil || !strings.Contains(err.Error(), UNOWNED_NAMED_ID) {
+				return fmt.Errorf("expect the creation refused for the name taken, got %v", err)
+			}
+			if !strings.Contains(events, "LoadBalancerNameConflict") {
+				return fmt.Errorf("expect a name conflict event, got %s", events)
+			}
+			if calls := faults.Calls("CreateLoadBalancer"); len(calls) != 0 {
+				return fmt.Errorf("expect no loadbalancer created, got %v", calls)
+			}
+			return nil
+		},
+	)
+
+	f = NewDefaultFrameWork(preset)
+	f.WithService(namedService("team-foo-web")).WithNodes(nodes)
+	f.RunCustomized(t, "Rename to a name taken",
+		func(f *FrameWork) error {
+			lb, _, err := ensureNamed(f)
+			if err != nil {
+				return err
+			}
+			f.SVC.Annotations[ServiceAnnotationLoadBalancerName] = "team-foo-api"
+			renamed, events, err := ensureNamed(f)
+			if err != nil {
+				return fmt.Errorf("expect the sync go on without the rename, got %s", err.Error())
+			}
+			if renamed.LoadBalancerId != lb.LoadBalancerId || renamed.LoadBalancerName != "team-foo-web" {
+				return fmt.Errorf("expect loadbalancer %s keep its name, got %s %s",
+					lb.LoadBalancerId, renamed.LoadBalancerId, renamed.LoadBalancerName)
+			}
+			if !strings.Contains(events, "LoadBalancerNameConflict") || !strings.Contains(events, UNOWNED_NAMED_ID) {
+				return fmt.Errorf("expect a name conflict event, got %s", events)
+			}
+			return nil
+		},
+	)
+}
+
+func TestLoadBalancerNameTemplate(t *testing.T) {
+	defer func(origin string) { LoadBalancerNameTemplate = origin }(LoadBalancerNameTemplate)
+	LoadBalancerNameTemplate = "k8s-{cluster}-{namespace}-{service}"
+
+	prid := nodeid(string(REGION), INSTANCEID)
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: prid},
+			Spec:       v1.NodeSpec{ProviderID: prid},
+		},
+	}
+	templated := namedService("")
+	delete(templated.Annotations, ServiceAnnotationLoadBalancerName)
+
+	f := NewDefaultFrameWork(nil)
+	f.WithService(templated).WithNodes(nodes)
+	f.RunCustomized(t, "Rename on template change",
+		func(f *FrameWork) error {
+			lb, _, err := ensureNamed(f)
+			if err != nil {
+				return err
+			}
+			expect := fmt.Sprintf("k8s-%s-default-my-service", CLUSTER_ID)
+			if lb.LoadBalancerName != expect {
+				return fmt.Errorf("expect loadbalancer named %s, got %s", expect, lb.LoadBalancerName)
+			}
+
+			LoadBalancerNameTemplate = "{namespace}-{service}-" + strings.Repeat("x", MAX_NAME_LENGTH)
+			faults := f.SLBFaults()
+			renamed, _, err := ensureNamed(f)
+			if err != nil {
+				return err
+			}
+			if renamed.LoadBalancerId != lb.LoadBalancerId {
+				return fmt.Errorf("expect loadbalancer %s renamed, got %s", lb.LoadBalancerId, renamed.LoadBalancerId)
+			}
+			if len(renamed.LoadBalancerName) != MAX_NAME_LENGTH ||
+				!strings.HasPrefix(renamed.LoadBalancerName, "default-my-service-") {
+				return fmt.Errorf("expect the name truncated to %d, got %s", MAX_NAME_LENGTH, renamed.LoadBalancerName)
+			}
+			if calls := faults.Calls("CreateLoadBalancer"); len(calls) != 0 {
+				return fmt.Errorf("expect no loadbalancer created on rename, got %v", calls)
+			}
+
+			// the name annotation overrides the template
+			f.SVC.Annotations[ServiceAnnotationLoadBalancerName] = "team-foo-api"
+			if name := f.CloudImpl().GetLoadBalancerName(context.Background(), CLUSTER_ID, f.SVC); name != "team-foo-api" {
+				return fmt.Errorf("expect GetLoadBalancerName of the annotation, got %s", name)
+			}
+			return nil
+		},
+	)
+
+	reused := namedService("")
+	delete(reused.Annotations, ServiceAnnotationLoadBalancerName)
+	reused.Annotations[ServiceAnnotationLoadBalancerId] = LOADBALANCER_ID
+	f = NewDefaultFrameWork(nil)
+	f.WithService(reused).WithNodes(nodes)
+	f.RunCustomized(t, "Reused loadbalancer kept",
+		func(f *FrameWork) error {
+			faults := f.SLBFaults()
+			lb, _, err := ensureNamed(f)
+			if err != nil {
+				return err
+			}
+			if lb.LoadBalancerName != LOADBALANCER_NAME {
+				return fmt.Errorf("expect the reused loadbalancer keep name %s, got %s", LOADBALANCER_NAME, lb.LoadBalancerName)
+			}
+			if calls := faults.Calls("SetLoadBalancerName"); len(calls) != 0 {
+				return fmt.Errorf("expect the reused loadbalancer not renamed, got %v", calls)
+			}
+			return nil
+		},
+	)
+}
+
+func TestValidateLoadBalancerNameTemplate(t *testing.T) {
+	for _, template := range []string{"", "k8s-{cluster}-{namespace}-{service}", "lb-{uid}"} {
+		if err := ValidateLoadBalancerNameTemplate(template); err != nil {
+			t.Errorf("expect %q valid, got %s", template, err.Error())
+		}
+	}
+	for _, template := range []string{"k8s-{name}", "k8s-{service", "k8s-service}"} {
+		if err := ValidateLoadBalancerNameTemplate(template); err == nil {
+			t.Errorf("expect %q rejected", template)
+		}
+	}
+}
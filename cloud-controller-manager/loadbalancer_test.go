@@ -25,7 +25,9 @@ import (
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -248,6 +250,145 @@ func TestGetLoadBalancerAdditionalTags(t *testing.T) {
 	}
 }
 
+func TestAdditionalTags(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				ServiceAnnotationLoadBalancerAdditionalTags: "K=V K1=V2,Key1========, =====, " + TAGKEY + "=x, " + EIPKEY + "=y",
+			},
+		},
+	}
+	tags := additionalTags(svc)
+	if len(tags) != 2 || tags["K"] != "V K1" || tags["Key1"] != "" {
+		t.Errorf("ownership tags are not expected, got %v", tags)
+	}
+	if keys := additionalTagKeys(tags); keys != "K,Key1" {
+		t.Errorf("expect keys K,Key1, got %s", keys)
+	}
+}
+
+func TestEnsureAdditionalTags(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerAdditionalTags: "cost-center=ops,team=sre",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	// ensure the service and return the tags of the loadbalancer
+	ensure := func(f *FrameWork) (map[string]string, error) {
+		if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+			return nil, err
+		}
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return nil, fmt.Errorf("find loadbalancer: %v", err)
+		}
+		items, _, err := f.SLBSDK().DescribeTags(context.Background(), &slb.DescribeTagsArgs{LoadBalancerID: lb.LoadBalancerId})
+		if err != nil {
+			return nil, err
+		}
+		tags := make(map[string]string)
+		for _, item := range items {
+			if _, ok := tags[item.TagKey]; ok {
+				return nil, fmt.Errorf("duplicated tag %s", item.TagKey)
+			}
+			tags[item.TagKey] = item.TagValue
+		}
+		return tags, nil
+	}
+	expectTags := func(tags, expect map[string]string, absent ...string) error {
+		for k, v := range expect {
+			if tags[k] != v {
+				return fmt.Errorf("expect tag %s=%s, got %v", k, v, tags)
+			}
+		}
+		for _, k := range absent {
+			if _, ok := tags[k]; ok {
+				return fmt.Errorf("expect tag %s removed, got %v", k, tags)
+			}
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Create with additional tags",
+		func(f *FrameWork) error {
+			tags, err := ensure(f)
+			if err != nil {
+				return err
+			}
+			if err := ExpectExistAndEqual(f); err != nil {
+				return err
+			}
+			if err := expectTags(tags, map[string]string{"cost-center": "ops", "team": "sre"}); err != nil {
+				return err
+			}
+			// tags added by the user out of band
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			return addSLBTag(f.SLBSDK(), context.Background(), map[string]string{"owner": "finance"}, lb.RegionId, lb.LoadBalancerId)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerAdditionalTags] = "cost-center=finance,env=prod"
+	f.RunCustomized(t, "Change, add and remove additional tags",
+		func(f *FrameWork) error {
+			tags, err := ensure(f)
+			if err != nil {
+				return err
+			}
+			return expectTags(tags,
+				map[string]string{"cost-center": "finance", "env": "prod", "owner": "finance", ACKKEY: CLUSTER_ID},
+				"team",
+			)
+		},
+	)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerAdditionalTags)
+	f.RunCustomized(t, "Remove the annotation",
+		func(f *FrameWork) error {
+			tags, err := ensure(f)
+			if err != nil {
+				return err
+			}
+			if _, ok := tags[TAGKEY]; !ok {
+				return fmt.Errorf("ownership tag %s is not expected to be removed, got %v", TAGKEY, tags)
+			}
+			return expectTags(tags,
+				map[string]string{"owner": "finance"},
+				"cost-center", "env", ADDITIONALTAGSKEY,
+			)
+		},
+	)
+}
+
 // anomaly test case
 func TestUpdateLoadBalancerWhenStartLoadBalancerFailed(t *testing.T) {
 	ctx := context.Background()
@@ -299,3 +440,155 @@ func TestUpdateLoadBalancerWhenStartLoadBalancerFailed(t *testing.T) {
 		t.Fatalf("listener stop error.")
 	}
 }
+
+// captureLogs capture the structured logs at every verbosity until restored
+func captureLogs() (func() []utils.LogEntry, func()) {
+	var (
+		lock    sync.Mutex
+		entries []utils.LogEntry
+	)
+	former := utils.SetLogSink(func(depth int, entry utils.LogEntry) {
+		lock.Lock()
+		defer lock.Unlock()
+		entries = append(entries, entry)
+	})
+	captured := func() []utils.LogEntry {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]utils.LogEntry{}, entries...)
+	}
+	return captured, func() { utils.SetLogSink(former) }
+}
+
+// findLog the first entry of the message
+func findLog(entries []utils.LogEntry, msg string) (utils.LogEntry, bool) {
+	for _, entry := range entries {
+		if entry.Message == msg {
+			return entry, true
+		}
+	}
+	return utils.LogEntry{}, false
+}
+
+func TestEnsureLoadBalancerLogKeys(t *testing.T) {
+	captured, restore := captureLogs()
+	defer restore()
+
+	f := NewDefaultFrameWork(nil)
+	f.RunCustomized(t, "The logs of the sync carry the service and the loadbalancer",
+		func(f *FrameWork) error {
+			service := f.SVC.Namespace + "/" + f.SVC.Name
+			// the logger of the sync, the clients find it in the context
+			ctx := utils.WithLogger(context.Background(), utils.ServiceLogger(f.SVC))
+			if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			entries := captured()
+			created, ok := findLog(entries, "Created loadbalancer")
+			if !ok {
+				return fmt.Errorf("expect the creation logged, got %v", entries)
+			}
+			if v, _ := created.Value(utils.LogKeyService); v != service {
+				return fmt.Errorf("expect key %s of %s, got %v", utils.LogKeyService, service, created)
+			}
+			slbID, ok := created.Value(utils.LogKeySLBID)
+			if !ok || slbID == "" {
+				return fmt.Errorf("expect key %s, got %v", utils.LogKeySLBID, created)
+			}
+			// the entries deep in the sync carry the keys of the owner as well
+			batch, ok := findLog(entries, "Batch add backend servers")
+			if !ok {
+				batch, ok = findLog(entries, "Apply listeners")
+			}
+			if !ok {
+				return fmt.Errorf("expect the details of the sync logged, got %v", entries)
+			}
+			if v, _ := batch.Value(utils.LogKeyService); v != service {
+				return fmt.Errorf("expect key %s of %s, got %v", utils.LogKeyService, service, batch)
+			}
+			if v, _ := batch.Value(utils.LogKeySLBID); v != slbID {
+				return fmt.Errorf("expect key %s of %s, got %v", utils.LogKeySLBID, slbID, batch)
+			}
+			// each listener applied is logged by its port
+			applied, ok := findLog(entries, "Apply listener")
+			if !ok {
+				return fmt.Errorf("expect the listener applied logged, got %v", entries)
+			}
+			if v, _ := applied.Value(utils.LogKeySLBID); v != slbID {
+				return fmt.Errorf("expect key %s of %s, got %v", utils.LogKeySLBID, slbID, applied)
+			}
+			if _, ok := applied.Value(utils.LogKeyListenerPort); !ok {
+				return fmt.Errorf("expect key %s, got %v", utils.LogKeyListenerPort, applied)
+			}
+			return nil
+		},
+	)
+}
+
+func TestEnsureLoadBalancerForceResync(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(stateService(map[string]string{})).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+	listeners := func(f *FrameWork) (string, error) {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return "", fmt.Errorf("find loadbalancer: %v", err)
+		}
+		return fmt.Sprint(lb.ListenerPortsAndProtocol.ListenerPortAndProtocol), nil
+	}
+
+	f.RunCustomized(t, "Listener deleted out of band",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			hash, err := utils.GetServiceHash(f.SVC)
+			if err != nil {
+				return err
+			}
+			f.SVC.Labels = map[string]string{utils.LabelServiceHash: hash}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			if err := f.SLBSDK().DeleteLoadBalancerListener(context.Background(), lb.LoadBalancerId, 443); err != nil {
+				return err
+			}
+			// the unchanged service skips the listeners
+			before, err := listeners(f)
+			if err != nil {
+				return err
+			}
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			after, err := listeners(f)
+			if err != nil {
+				return err
+			}
+			if before != after || strings.Contains(after, "443") {
+				return fmt.Errorf("expect the listeners skipped by the hash, got %s -> %s", before, after)
+			}
+			return nil
+		},
+	)
+
+	f.RunCustomized(t, "Reconcile requested",
+		func(f *FrameWork) error {
+			ctx := context.WithValue(context.Background(), utils.ContextForceResync, true)
+			if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			// the listener is restored although the hash is unchanged
+			ExpectLoadBalancerEqual(t, f)
+			return nil
+		},
+	)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"k8s.io/api/core/v1"
+	"strconv"
+	"strings"
+)
+
+// On a loadbalancer of the loadbalancer-id annotation, the managed-ports
+// annotation lists the ports of the existing listeners the service takes over,
+// e.g. "80,443". The other listeners are left untouched, see listener_marker.go.
+// Without the annotation force-override-listeners takes over all the listeners.
+
+// parseManagedPorts ports of the managed-ports annotation
+func parseManagedPorts(value string) (map[int32]bool, error) {
+	ports := map[int32]bool{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		port, err := strconv.Atoi(item)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("port [%s] must be an integer in range [1, 65535]", item)
+		}
+		ports[int32(port)] = true
+	}
+	return ports, nil
+}
+
+// ValidateManagedPorts the managed ports are ports of the loadbalancer
+// specified by the loadbalancer-id annotation.
+func ValidateManagedPorts(service *v1.Service) error {
+	value := serviceAnnotation(service, ServiceAnnotationLoadBalancerManagedPorts)
+	if value == "" {
+		return nil
+	}
+	if _, err := parseManagedPorts(value); err != nil {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerManagedPorts,
+			token:      value,
+			reason:     err.Error(),
+		}
+	}
+	if !isUserDefinedLoadBalancer(service) {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerManagedPorts,
+			token:      value,
+			reason:     fmt.Sprintf("requires the loadbalancer specified by %s", ServiceAnnotationLoadBalancerId),
+		}
+	}
+	return nil
+}
+
+// hasManagedPorts whether the service takes over the listeners of the managed-ports annotation
+func hasManagedPorts(svc *v1.Service) bool {
+	ports, err := parseManagedPorts(serviceAnnotation(svc, ServiceAnnotationLoadBalancerManagedPorts))
+	return err == nil && len(ports) > 0
+}
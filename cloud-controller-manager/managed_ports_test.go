@@ -0,0 +1,225 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+// WithForeignListeners listeners of other teams on the loadbalancer
+func WithForeignListeners() CloudDataMock {
+	return func() {
+		for port, desc := range map[int]string{443: "team-b", 8443: "team-c"} {
+			listener := &slb.DescribeLoadBalancerTCPListenerAttributeResponse{
+				DescribeLoadBalancerListenerAttributeResponse: slb.DescribeLoadBalancerListenerAttributeResponse{
+					Status: slb.Running,
+				},
+				TCPListenerType: slb.TCPListenerType{
+					LoadBalancerId:    LOADBALANCER_ID,
+					ListenerPort:      port,
+					BackendServerPort: 31999,
+					Description:       desc,
+				},
+			}
+			LOADBALANCER.listeners.Store(listenerKey(LOADBALANCER_ID, port), listener)
+		}
+	}
+}
+
+func TestValidateManagedPorts(t *testing.T) {
+	cases := []struct {
+		annotations map[string]string
+		invalid     bool
+	}{
+		{annotations: map[string]string{}},
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerId:           LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerManagedPorts: "80, 443",
+		}},
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerManagedPorts: "80",
+		}, invalid: true},
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerId:           LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerManagedPorts: "http",
+		}, invalid: true},
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerId:           LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerManagedPorts: "70000",
+		}, invalid: true},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: c.annotations},
+		}
+		err := ValidateManagedPorts(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("%v: unexpected error: %s", c.annotations, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%v: expect InvalidAnnotation error, got %v", c.annotations, err)
+		}
+	}
+}
+
+func TestManagedPorts(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(
+		func() {
+			DefaultPreset()
+			PreSetCloudData(WithForeignListeners())
+		},
+	)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerId:           LOADBALANCER_ID,
+					ServiceAnnotationLoadBalancerManagedPorts: "80",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Name: "http", Port: 80, TargetPort: intstr.FromInt(80), Protocol: v1.ProtocolTCP, NodePort: 30080},
+					{Name: "https", Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 30443},
+					{Name: "metrics", Port: 9090, TargetPort: intstr.FromInt(9090), Protocol: v1.ProtocolTCP, NodePort: 30090},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	recorder := record.NewFakeRecorder(100)
+	ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+	// listener of the port, nil if absent
+	listener := func(f *FrameWork, port int) *slb.DescribeLoadBalancerTCPListenerAttributeResponse {
+		res, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(context.Background(), LOADBALANCER_ID, port)
+		if err != nil {
+			return nil
+		}
+		return res
+	}
+	// the listeners of the other teams are untouched
+	expectForeign := func(f *FrameWork) error {
+		for port, desc := range map[int]string{443: "team-b", 8443: "team-c"} {
+			l := listener(f, port)
+			if l == nil || l.Description != desc || l.BackendServerPort != 31999 {
+				return fmt.Errorf("expect foreign listener %d untouched, got %v", port, l)
+			}
+		}
+		return nil
+	}
+	// the listener of the port is marked by the service
+	expectManaged := func(f *FrameWork, port int, nodePort int) error {
+		l := listener(f, port)
+		if l == nil {
+			return fmt.Errorf("expect listener %d", port)
+		}
+		key, hash, err := loadListenerMarker(l.Description)
+		if err != nil || key.ServiceURI() != URIfromService(f.SVC) || hash != listenerUIDHash(f.SVC) {
+			return fmt.Errorf("expect listener %d marked by the service, got [%s]", port, l.Description)
+		}
+		if l.BackendServerPort != nodePort {
+			return fmt.Errorf("expect listener %d forwarding to node port %d, got %d", port, nodePort, l.BackendServerPort)
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Managed ports are taken over, occupied ports are reported",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			if err := expectManaged(f, 80, 30080); err != nil {
+				return err
+			}
+			if err := expectManaged(f, 9090, 30090); err != nil {
+				return err
+			}
+			if l := listener(f, 9090); l.Status != slb.Running {
+				return fmt.Errorf("expect listener 9090 started, got [%s]", l.Status)
+			}
+			if err := expectForeign(f); err != nil {
+				return err
+			}
+			found := false
+			for len(recorder.Events) > 0 {
+				event := <-recorder.Events
+				if strings.Contains(event, "ListenerConflict") {
+					if !strings.Contains(event, "Port 443") {
+						return fmt.Errorf("expect only port 443 occupied, got %s", event)
+					}
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("expect an event of the occupied port 443")
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Spec.Ports = f.SVC.Spec.Ports[:2]
+	f.RunCustomized(t, "Only the marked listener of the removed port is deleted",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			if listener(f, 9090) != nil {
+				return fmt.Errorf("expect listener 9090 deleted")
+			}
+			if err := expectManaged(f, 80, 30080); err != nil {
+				return err
+			}
+			return expectForeign(f)
+		},
+	)
+
+	f.RunCustomized(t, "Foreign listeners are kept on deletion",
+		func(f *FrameWork) error {
+			if err := f.CloudImpl().EnsureLoadBalancerDeleted(ctx, CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			if listener(f, 80) != nil {
+				return fmt.Errorf("expect listener 80 deleted")
+			}
+			return expectForeign(f)
+		},
+	)
+}
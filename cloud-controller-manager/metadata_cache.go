@@ -0,0 +1,185 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"github.com/denverdino/aliyungo/metadata"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The values of the metadata server which never change on an instance,
+// region, vpc, zone and instance id, are retrieved once with retries and kept
+// in memory, a brief outage of the metadata server at startup or during the
+// syncs is ridden out. They may be overridden by the cloud config, then by the
+// environment, where the metadata server is unreachable, e.g. a control plane
+// outside of the cloud managing a vpc.
+
+const (
+	ENV_REGION_ID   = "ALIBABA_CLOUD_REGION_ID"
+	ENV_VPC_ID      = "ALIBABA_CLOUD_VPC_ID"
+	ENV_ZONE_ID     = "ALIBABA_CLOUD_ZONE_ID"
+	ENV_INSTANCE_ID = "ALIBABA_CLOUD_INSTANCE_ID"
+)
+
+// METADATA_RETRY_BACKOFF retries of a metadata value, about 30s in total
+var METADATA_RETRY_BACKOFF = wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: 6}
+
+// METADATA_VERIFY_PERIOD period of the cached values verified against the
+// metadata server
+var METADATA_VERIFY_PERIOD = 10 * time.Minute
+
+// metaDataOverride the cloud config field and the environment overriding a
+// metadata value
+type metaDataOverride struct {
+	field string
+	env   string
+}
+
+var METADATA_OVERRIDES = map[string]metaDataOverride{
+	metadata.REGION:      {field: "region", env: ENV_REGION_ID},
+	metadata.VPC_ID:      {field: "vpcid", env: ENV_VPC_ID},
+	metadata.ZONE:        {field: "zoneid", env: ENV_ZONE_ID},
+	metadata.INSTANCE_ID: {field: "instanceID", env: ENV_INSTANCE_ID},
+}
+
+// CachedMetaData the metadata with the immutable values cached, the other
+// lookups pass through.
+type CachedMetaData struct {
+	IMetaData
+	lock   sync.Mutex
+	values map[string]string
+}
+
+func NewCachedMetaData(base IMetaData) *CachedMetaData {
+	return &CachedMetaData{IMetaData: base, values: map[string]string{}}
+}
+
+func (m *CachedMetaData) Region() (string, error) {
+	return m.lookup(metadata.REGION, m.IMetaData.Region)
+}
+
+func (m *CachedMetaData) VpcID() (string, error) {
+	return m.lookup(metadata.VPC_ID, m.IMetaData.VpcID)
+}
+
+func (m *CachedMetaData) Zone() (string, error) {
+	return m.lookup(metadata.ZONE, m.IMetaData.Zone)
+}
+
+func (m *CachedMetaData) InstanceID() (string, error) {
+	return m.lookup(metadata.INSTANCE_ID, m.IMetaData.InstanceID)
+}
+
+// configValue the value of the resource set by the cloud config
+func configValue(resource string) string {
+	switch resource {
+	case metadata.REGION:
+		return cfg.Global.Region
+	case metadata.VPC_ID:
+		return cfg.Global.VpcID
+	case metadata.ZONE:
+		return cfg.Global.ZoneID
+	case metadata.INSTANCE_ID:
+		return cfg.Global.InstanceID
+	}
+	return ""
+}
+
+// Verify the cached values against the metadata server once. A value changed
+// is an instance moved under the controller, eg. the region the loadbalancers
+// are created in, which is never followed: the cached value is kept and the
+// change reported as an error. The values the metadata server fails to return
+// are left to the next verify.
+func (m *CachedMetaData) Verify() error {
+	m.lock.Lock()
+	cached := make(map[string]string, len(m.values))
+	for resource, value := range m.values {
+		cached[resource] = value
+	}
+	m.lock.Unlock()
+
+	gets := map[string]func() (string, error){
+		metadata.REGION:      m.IMetaData.Region,
+		metadata.VPC_ID:      m.IMetaData.VpcID,
+		metadata.ZONE:        m.IMetaData.Zone,
+		metadata.INSTANCE_ID: m.IMetaData.InstanceID,
+	}
+	var changed []string
+	for resource, value := range cached {
+		current, err := gets[resource]()
+		if err != nil {
+			klog.Warningf("metadata: verify %s: %s", resource, err.Error())
+			continue
+		}
+		if current != value {
+			klog.Errorf("metadata: %s changed from %s to %s, keep %s. "+
+				"restart the controller if the instance was moved on purpose", resource, value, current, value)
+			changed = append(changed, fmt.Sprintf("%s changed from %s to %s", resource, value, current))
+		}
+	}
+	if len(changed) != 0 {
+		sort.Strings(changed)
+		return fmt.Errorf("metadata changed: %s", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// lookup the value of the resource, the overrides go first, then the cache
+// and the metadata server
+func (m *CachedMetaData) lookup(resource string, get func() (string, error)) (string, error) {
+	override := METADATA_OVERRIDES[resource]
+	if value := configValue(resource); value != "" {
+		return value, nil
+	}
+	if value := os.Getenv(override.env); value != "" {
+		return value, nil
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if value, ok := m.values[resource]; ok {
+		return value, nil
+	}
+	var (
+		value string
+		last  error
+	)
+	attempts := 0
+	err := wait.ExponentialBackoff(METADATA_RETRY_BACKOFF, func() (bool, error) {
+		attempts++
+		value, last = get()
+		if last != nil {
+			klog.Warningf("metadata: retrieve %s, attempt %d: %s", resource, attempts, last.Error())
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("retrieve %s from the metadata server failed after %d attempts: %v, "+
+			"set %s of the cloud config or env %s if the metadata server is unreachable",
+			resource, attempts, last, override.field, override.env)
+	}
+	m.values[resource] = value
+	return value, nil
+}
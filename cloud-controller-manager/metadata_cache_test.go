@@ -0,0 +1,154 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"github.com/denverdino/aliyungo/metadata"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyMetaData the mocked metadata server failing the first failures
+// requests, the requests of each resource are counted.
+func flakyMetaData(failures int, requests map[string]int) IMetaData {
+	return metadata.NewMockMetaData(
+		nil,
+		func(resource string) (string, error) {
+			requests[resource]++
+			if failures > 0 {
+				failures--
+				return "", fmt.Errorf("dial tcp 100.100.100.200:80: connect: connection refused")
+			}
+			switch {
+			case strings.Contains(resource, metadata.REGION):
+				return string(REGION), nil
+			case strings.Contains(resource, metadata.VPC_ID):
+				return VPCID, nil
+			case strings.Contains(resource, metadata.INSTANCE_ID):
+				return INSTANCEID, nil
+			}
+			return "", fmt.Errorf("not found")
+		},
+	)
+}
+
+// requestsOf the requests of the resource
+func requestsOf(requests map[string]int, resource string) int {
+	n := 0
+	for path, count := range requests {
+		if strings.Contains(path, resource) {
+			n += count
+		}
+	}
+	return n
+}
+
+func withMetaDataRetries(steps int) func() {
+	backoff := METADATA_RETRY_BACKOFF
+	METADATA_RETRY_BACKOFF.Duration, METADATA_RETRY_BACKOFF.Steps = time.Millisecond, steps
+	return func() { METADATA_RETRY_BACKOFF = backoff }
+}
+
+func TestCachedMetaData(t *testing.T) {
+	defer withMetaDataRetries(6)()
+
+	requests := map[string]int{}
+	meta := NewCachedMetaData(flakyMetaData(0, requests))
+	for i := 0; i < 3; i++ {
+		region, err := meta.Region()
+		if err != nil || region != string(REGION) {
+			t.Fatalf("expect region %s, got %s, %v", REGION, region, err)
+		}
+		instance, err := meta.InstanceID()
+		if err != nil || instance != INSTANCEID {
+			t.Fatalf("expect instance %s, got %s, %v", INSTANCEID, instance, err)
+		}
+	}
+	if n := requestsOf(requests, metadata.REGION); n != 1 {
+		t.Fatalf("expect the region retrieved once, got %d requests", n)
+	}
+	if n := requestsOf(requests, metadata.INSTANCE_ID); n != 1 {
+		t.Fatalf("expect the instance id retrieved once, got %d requests", n)
+	}
+	// the failed lookups are not cached
+	if _, err := meta.Zone(); err == nil {
+		t.Fatalf("expect the zone not found")
+	}
+	if _, err := meta.Zone(); err == nil || requestsOf(requests, metadata.ZONE) != 12 {
+		t.Fatalf("expect the zone retried by each lookup, got %d requests", requestsOf(requests, metadata.ZONE))
+	}
+}
+
+func TestCachedMetaDataRetry(t *testing.T) {
+	defer withMetaDataRetries(3)()
+
+	requests := map[string]int{}
+	meta := NewCachedMetaData(flakyMetaData(2, requests))
+	vpc, err := meta.VpcID()
+	if err != nil || vpc != VPCID {
+		t.Fatalf("expect vpc %s after the metadata server recovered, got %s, %v", VPCID, vpc, err)
+	}
+	if n := requestsOf(requests, metadata.VPC_ID); n != 3 {
+		t.Fatalf("expect 3 requests of the vpc, got %d", n)
+	}
+
+	// the retries are exhausted
+	requests = map[string]int{}
+	meta = NewCachedMetaData(flakyMetaData(3, requests))
+	_, err = meta.Region()
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") || !strings.Contains(err.Error(), ENV_REGION_ID) {
+		t.Fatalf("expect the retries exhausted with a hint of the override, got %v", err)
+	}
+	meta = NewCachedMetaData(flakyMetaData(100, requests))
+	if _, err := newClientMgrWithMetaData("key-id", "key-secret", meta); err == nil || !strings.Contains(err.Error(), "can not determin region") {
+		t.Fatalf("expect the startup failed, got %v", err)
+	}
+}
+
+func TestCachedMetaDataOverride(t *testing.T) {
+	defer withMetaDataRetries(1)()
+	region := cfg.Global.Region
+	defer func() { cfg.Global.Region = region }()
+	defer os.Unsetenv(ENV_REGION_ID)
+
+	requests := map[string]int{}
+	meta := NewCachedMetaData(flakyMetaData(100, requests))
+	cases := []struct {
+		desc   string
+		config string
+		env    string
+		expect string
+	}{
+		{desc: "env overrides the metadata", env: "cn-shanghai", expect: "cn-shanghai"},
+		{desc: "cloud config overrides the env", config: "cn-beijing", env: "cn-shanghai", expect: "cn-beijing"},
+		{desc: "cloud config only", config: "cn-beijing", expect: "cn-beijing"},
+	}
+	for _, c := range cases {
+		cfg.Global.Region = c.config
+		os.Setenv(ENV_REGION_ID, c.env)
+		got, err := meta.Region()
+		if err != nil || got != c.expect {
+			t.Errorf("%s: expect region %s, got %s, %v", c.desc, c.expect, got, err)
+		}
+	}
+	if len(requests) != 0 {
+		t.Fatalf("expect the metadata server unused, got %v", requests)
+	}
+}
@@ -0,0 +1,114 @@
+package alicloud
+
+import (
+	"fmt"
+	"github.com/denverdino/aliyungo/metadata"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetaDataResponse a scripted response of the mock metadata server
+type MetaDataResponse struct {
+	// Value returned if Err is nil
+	Value string
+	Err   error
+	// Delay before the response, eg. of a metadata server overloaded
+	Delay time.Duration
+}
+
+// MetaDataUnavailable the error of the metadata server unreachable
+func MetaDataUnavailable() error {
+	return fmt.Errorf("dial tcp 100.100.100.200:80: connect: connection refused")
+}
+
+// MockMetaDataServer the mock metadata server, with the failures, the delays
+// and the changes of the values scripted by resource path, eg. the region
+// unavailable for the first requests. Every request is counted.
+type MockMetaDataServer struct {
+	lock     sync.Mutex
+	values   map[string]string
+	scripts  map[string][]MetaDataResponse
+	requests map[string]int
+}
+
+// NewMockMetaDataServer the server of an instance in REGION and VPCID
+func NewMockMetaDataServer() *MockMetaDataServer {
+	return &MockMetaDataServer{
+		values: map[string]string{
+			metadata.REGION:      string(REGION),
+			metadata.VPC_ID:      VPCID,
+			metadata.VSWITCH_ID:  VSWITCH_ID,
+			metadata.ZONE:        REGION_A,
+			metadata.INSTANCE_ID: INSTANCEID,
+		},
+		scripts:  map[string][]MetaDataResponse{},
+		requests: map[string]int{},
+	}
+}
+
+// Set the value of the resource from now on, eg. a region changed
+func (s *MockMetaDataServer) Set(resource, value string) *MockMetaDataServer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.values[resource] = value
+	return s
+}
+
+// Script the next responses of the resource, its value is returned once they
+// are used up
+func (s *MockMetaDataServer) Script(resource string, responses ...MetaDataResponse) *MockMetaDataServer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.scripts[resource] = append(s.scripts[resource], responses...)
+	return s
+}
+
+// FailFirst fail the next n requests of the resource
+func (s *MockMetaDataServer) FailFirst(resource string, n int) *MockMetaDataServer {
+	responses := make([]MetaDataResponse, n)
+	for i := range responses {
+		responses[i] = MetaDataResponse{Err: MetaDataUnavailable()}
+	}
+	return s.Script(resource, responses...)
+}
+
+// Requests the requests of the resource
+func (s *MockMetaDataServer) Requests(resource string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests[resource]
+}
+
+// MetaData the client of the server, the values are not cached
+func (s *MockMetaDataServer) MetaData() *metadata.MetaData {
+	return metadata.NewMockMetaData(nil, s.respond)
+}
+
+// respond the request of the path, which ends with the resource
+func (s *MockMetaDataServer) respond(path string) (string, error) {
+	s.lock.Lock()
+	resource := ""
+	for r := range s.values {
+		if strings.Contains(path, r) {
+			resource = r
+			break
+		}
+	}
+	if resource == "" {
+		s.lock.Unlock()
+		return "", fmt.Errorf("not found")
+	}
+	s.requests[resource]++
+	response := MetaDataResponse{Value: s.values[resource]}
+	if script := s.scripts[resource]; len(script) > 0 {
+		response, s.scripts[resource] = script[0], script[1:]
+	}
+	s.lock.Unlock()
+
+	time.Sleep(response.Delay)
+	if response.Err != nil {
+		return "", response.Err
+	}
+	return response.Value, nil
+}
@@ -0,0 +1,136 @@
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/metadata"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frameWorkOfMetaData the default framework with the cached metadata of the
+// server
+func frameWorkOfMetaData(server *MockMetaDataServer) (*FrameWork, *CachedMetaData, error) {
+	DefaultPreset()
+	meta := NewCachedMetaData(server.MetaData())
+	cloud, err := NewMockCloudWithMetaData(meta)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewFrameWork(cloud, nil, nil, nil, nil), meta, nil
+}
+
+func TestMetaDataUnavailableAtStartup(t *testing.T) {
+	defer withMetaDataRetries(3)()
+
+	server := NewMockMetaDataServer().Script(
+		metadata.REGION,
+		MetaDataResponse{Err: MetaDataUnavailable()},
+		MetaDataResponse{Value: string(REGION), Delay: 10 * time.Millisecond},
+	)
+	if _, _, err := frameWorkOfMetaData(server); err != nil {
+		t.Fatalf("expect the controller started once the metadata server recovered, got %s", err.Error())
+	}
+	if n := server.Requests(metadata.REGION); n != 2 {
+		t.Fatalf("expect 2 requests of the region, got %d", n)
+	}
+
+	// never recovered, the startup fails instead of a panic
+	server = NewMockMetaDataServer().FailFirst(metadata.REGION, 100)
+	_, _, err := frameWorkOfMetaData(server)
+	if err == nil || !strings.Contains(err.Error(), "please provide region") {
+		t.Fatalf("expect the startup failed without the region, got %v", err)
+	}
+	if n := server.Requests(metadata.REGION); n != 3 {
+		t.Fatalf("expect the retries exhausted in 3 requests, got %d", n)
+	}
+}
+
+func TestMetaDataFailingMidReconcile(t *testing.T) {
+	defer withMetaDataRetries(1)()
+
+	server := NewMockMetaDataServer()
+	f, _, err := frameWorkOfMetaData(server)
+	if err != nil {
+		t.Fatalf("mock cloud: %s", err.Error())
+	}
+	prid := nodeid(string(REGION), INSTANCEID)
+	f.WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+	ensure := func(f *FrameWork) error {
+		_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+		return err
+	}
+	f.RunCustomized(t, "Loadbalancer ensured", ensure)
+
+	vpcRequests := server.Requests(metadata.VPC_ID)
+	server.FailFirst(metadata.VPC_ID, 100)
+	f.RunCustomized(t, "Vpc id unavailable mid reconcile",
+		func(f *FrameWork) error {
+			// the vpc id cached at startup is used
+			if err := ensure(f); err != nil {
+				return err
+			}
+			if n := server.Requests(metadata.VPC_ID); n != vpcRequests {
+				return fmt.Errorf("expect the vpc id never requested again, got %d requests", n-vpcRequests)
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return nil
+		},
+	)
+
+	server.FailFirst(metadata.VSWITCH_ID, 1)
+	f.RunCustomized(t, "Vswitch id unavailable mid reconcile",
+		func(f *FrameWork) error {
+			// the vswitch id is not cached, the sync fails and is retried
+			err := ensure(f)
+			if err == nil || !strings.Contains(err.Error(), "can not obtain vswitchid") {
+				return fmt.Errorf("expect the sync failed without the vswitch id, got %v", err)
+			}
+			if err := ensure(f); err != nil {
+				return fmt.Errorf("expect the retry succeeded, got %s", err.Error())
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return nil
+		},
+	)
+}
+
+func TestMetaDataRegionChanged(t *testing.T) {
+	defer withMetaDataRetries(1)()
+
+	server := NewMockMetaDataServer()
+	_, meta, err := frameWorkOfMetaData(server)
+	if err != nil {
+		t.Fatalf("mock cloud: %s", err.Error())
+	}
+	if err := meta.Verify(); err != nil {
+		t.Fatalf("expect the metadata unchanged, got %s", err.Error())
+	}
+
+	// the metadata server failing is no change
+	server.FailFirst(metadata.REGION, 1)
+	if err := meta.Verify(); err != nil {
+		t.Fatalf("expect the failed verify skipped, got %s", err.Error())
+	}
+
+	server.Set(metadata.REGION, "cn-shanghai")
+	err = meta.Verify()
+	expect := fmt.Sprintf("%s changed from %s to cn-shanghai", metadata.REGION, REGION)
+	if err == nil || !strings.Contains(err.Error(), expect) {
+		t.Fatalf("expect the region change reported as %q, got %v", expect, err)
+	}
+	region, err := meta.Region()
+	if err != nil || region != string(REGION) {
+		t.Fatalf("expect the region %s kept, got %s, %v", REGION, region, err)
+	}
+}
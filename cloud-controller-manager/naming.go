@@ -0,0 +1,109 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strconv"
+	"strings"
+)
+
+// Vserver groups are named ${namespace}/${name}/${port}/k8s.${cluster} to be
+// told apart in the console, the cluster id is hashed to keep the service part
+// readable. Backends are described by the node name, or the pod name for eni
+// backends. Names over the limit are truncated with a hash of the full name.
+
+// MAX_NAME_LENGTH max length of vserver group names and backend descriptions
+const MAX_NAME_LENGTH = 80
+
+// NAME_HASH_LENGTH length of the hashes in vserver group names
+const NAME_HASH_LENGTH = 8
+
+// truncateName keep name within max, a truncated name ends with the hash of
+// the full one to stay unique.
+func truncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	hash := utils.Hash(name)[:NAME_HASH_LENGTH]
+	return name[:max-NAME_HASH_LENGTH-1] + "-" + hash
+}
+
+// VGroupName name of the vserver group in the console
+func (n *NamedKey) VGroupName() string {
+	if n.Prefix == "" {
+		n.Prefix = DEFAULT_PREFIX
+	}
+	suffix := fmt.Sprintf("/%d/%s.%s", n.Port, n.Prefix, utils.Hash(n.CID)[:NAME_HASH_LENGTH])
+	return truncateName(fmt.Sprintf("%s/%s", n.Namespace, n.ServiceName), MAX_NAME_LENGTH-len(suffix)) + suffix
+}
+
+// loadVGroupName port of a vserver group named by VGroupName. The service
+// part may be truncated, whether it belongs to a service is told by the name
+// the service would give it.
+func loadVGroupName(name string) (*NamedKey, error) {
+	metas := strings.Split(name, "/")
+	if len(metas) < 3 || !strings.HasPrefix(metas[len(metas)-1], DEFAULT_PREFIX+".") {
+		return nil, formatError{key: name}
+	}
+	port, err := strconv.Atoi(metas[len(metas)-2])
+	if err != nil {
+		return nil, formatError{key: name}
+	}
+	return &NamedKey{Port: int32(port), Prefix: DEFAULT_PREFIX}, nil
+}
+
+// isVGroupOf whether the remote vserver group is created for service, by
+// either the current or the former naming scheme.
+func (v *vgroup) isVGroupOf(service *v1.Service) bool {
+	key := &NamedKey{
+		Prefix:      DEFAULT_PREFIX,
+		CID:         CLUSTER_ID,
+		Namespace:   service.Namespace,
+		ServiceName: service.Name,
+		Port:        v.NamedKey.Port,
+	}
+	return v.VGroupName == key.VGroupName() || v.VGroupName == key.Key()
+}
+
+// backendDescription description of an ecs backend by its node, or an eni
+// backend by its pod.
+func backendDescription(name string) string {
+	return truncateName(name, MAX_NAME_LENGTH)
+}
+
+// podName name of the pod of the endpoint address, the ip for addresses
+// without a pod reference.
+func (v *EndpointWithENI) podName(ip string) string {
+	if v.Endpoints == nil {
+		return ip
+	}
+	for _, sub := range v.Endpoints.Subsets {
+		for _, add := range append(sub.Addresses, sub.NotReadyAddresses...) {
+			if add.IP != ip {
+				continue
+			}
+			if add.TargetRef != nil && add.TargetRef.Kind == "Pod" {
+				return add.TargetRef.Name
+			}
+			return ip
+		}
+	}
+	return ip
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestVGroupName(t *testing.T) {
+	key := &NamedKey{CID: "c9f3a1b2e5d4c4b7e8a1f2d3c4b5a6e7f", Namespace: "default", ServiceName: "nginx", Port: 30080}
+	expect := "default/nginx/30080/k8s." + utils.Hash(key.CID)[:NAME_HASH_LENGTH]
+	if name := key.VGroupName(); name != expect {
+		t.Fatalf("expect vgroup name %s, got %s", expect, name)
+	}
+
+	long := func(name string) *NamedKey {
+		return &NamedKey{
+			CID:         key.CID,
+			Namespace:   strings.Repeat("n", 63),
+			ServiceName: name,
+			Port:        30080,
+		}
+	}
+	name1 := long(strings.Repeat("a", 60) + "-1").VGroupName()
+	name2 := long(strings.Repeat("a", 60) + "-2").VGroupName()
+	if len(name1) > MAX_NAME_LENGTH || len(name2) > MAX_NAME_LENGTH {
+		t.Fatalf("expect vgroup names within %d, got %s, %s", MAX_NAME_LENGTH, name1, name2)
+	}
+	if name1 == name2 {
+		t.Fatalf("expect truncated vgroup names to differ, got %s", name1)
+	}
+	if name1 != long(strings.Repeat("a", 60)+"-1").VGroupName() {
+		t.Fatalf("expect vgroup name to be deterministic")
+	}
+
+	for _, name := range []string{expect, name1} {
+		loaded, err := loadVGroupName(name)
+		if err != nil {
+			t.Fatalf("load vgroup name %s: %s", name, err.Error())
+		}
+		if loaded.Port != 30080 {
+			t.Fatalf("expect port 30080 from %s, got %d", name, loaded.Port)
+		}
+	}
+	// named by older versions
+	if _, err := loadVGroupName(key.Key()); err == nil {
+		t.Fatalf("expect error loading former vgroup name %s", key.Key())
+	}
+}
+
+func TestBackendDescription(t *testing.T) {
+	if desc := backendDescription("cn-hangzhou.192.168.0.1"); desc != "cn-hangzhou.192.168.0.1" {
+		t.Fatalf("expect description of the node name, got %s", desc)
+	}
+	name := strings.Repeat("p", 100)
+	desc := backendDescription(name)
+	if len(desc) != MAX_NAME_LENGTH || !strings.HasSuffix(desc, utils.Hash(name)[:NAME_HASH_LENGTH]) {
+		t.Fatalf("expect truncated description with hash, got %s", desc)
+	}
+
+	pod := "nginx-7b9f6c5d4-x2x7k"
+	v := &EndpointWithENI{
+		Endpoints: &v1.Endpoints{
+			Subsets: []v1.EndpointSubset{
+				{
+					Addresses: []v1.EndpointAddress{
+						{IP: "10.0.0.1", TargetRef: &v1.ObjectReference{Kind: "Pod", Name: pod}},
+						{IP: "10.0.0.2"},
+					},
+				},
+			},
+		},
+	}
+	if name := v.podName("10.0.0.1"); name != pod {
+		t.Fatalf("expect pod name %s, got %s", pod, name)
+	}
+	if name := v.podName("10.0.0.2"); name != "10.0.0.2" {
+		t.Fatalf("expect ip for address without pod, got %s", name)
+	}
+}
+
+func TestVGroupNaming(t *testing.T) {
+	prid1 := nodeid(string(REGION), INSTANCEID)
+	prid2 := nodeid(string(REGION), INSTANCEID2)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid1},
+				Spec:       v1.NodeSpec{ProviderID: prid1},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid2},
+				Spec:       v1.NodeSpec{ProviderID: prid2},
+			},
+		},
+	)
+	key := &NamedKey{
+		Prefix:      DEFAULT_PREFIX,
+		CID:         CLUSTER_ID,
+		Namespace:   f.SVC.Namespace,
+		ServiceName: f.SVC.Name,
+		Port:        nodePort1,
+	}
+
+	// the vserver groups of the loadbalancer by id
+	vgroupsOf := func(f *FrameWork) (map[string]slb.DescribeVServerGroupAttributeResponse, error) {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return nil, fmt.Errorf("find loadbalancer: %v", err)
+		}
+		res, err := f.SLBSDK().DescribeVServerGroups(
+			context.Background(),
+			&slb.DescribeVServerGroupsArgs{LoadBalancerId: lb.LoadBalancerId},
+		)
+		if err != nil {
+			return nil, err
+		}
+		vgs := map[string]slb.DescribeVServerGroupAttributeResponse{}
+		for _, v := range res.VServerGroups.VServerGroup {
+			att, err := f.SLBSDK().DescribeVServerGroupAttribute(
+				context.Background(),
+				&slb.DescribeVServerGroupAttributeArgs{VServerGroupId: v.VServerGroupId},
+			)
+			if err != nil {
+				return nil, err
+			}
+			vgs[v.VServerGroupId] = *att
+		}
+		return vgs, nil
+	}
+	// a single vserver group of the current name, backends described by node
+	expectNaming := func(f *FrameWork) (string, error) {
+		vgs, err := vgroupsOf(f)
+		if err != nil {
+			return "", err
+		}
+		if len(vgs) != 1 {
+			return "", fmt.Errorf("expect 1 vserver group, got %v", vgs)
+		}
+		for id, vg := range vgs {
+			if vg.VServerGroupName != key.VGroupName() {
+				return "", fmt.Errorf("expect vserver group name %s, got %s", key.VGroupName(), vg.VServerGroupName)
+			}
+			names := map[string]string{INSTANCEID: prid1, INSTANCEID2: prid2}
+			for _, b := range vg.BackendServers.BackendServer {
+				if b.Description != names[b.ServerId] {
+					return "", fmt.Errorf("expect backend %s described as %s, got %s", b.ServerId, names[b.ServerId], b.Description)
+				}
+			}
+			return id, nil
+		}
+		return "", nil
+	}
+	// rewrite the vserver groups in the mock store
+	rewrite := func(update func(vg *slb.CreateVServerGroupResponse)) {
+		LOADBALANCER.vgroups.Range(
+			func(k, v interface{}) bool {
+				vg := v.(slb.CreateVServerGroupResponse)
+				update(&vg)
+				LOADBALANCER.vgroups.Store(k, vg)
+				return true
+			},
+		)
+	}
+	ensure := func(f *FrameWork) error {
+		_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+		return err
+	}
+
+	var created string
+	f.RunCustomized(t, "Created vserver group is named by service",
+		func(f *FrameWork) error {
+			if err := ensure(f); err != nil {
+				return err
+			}
+			id, err := expectNaming(f)
+			created = id
+			return err
+		},
+	)
+
+	f.RunCustomized(t, "Vserver group of older version is renamed",
+		func(f *FrameWork) error {
+			rewrite(func(vg *slb.CreateVServerGroupResponse) {
+				vg.VServerGroupName = key.Key()
+				for i := range vg.BackendServers.BackendServer {
+					vg.BackendServers.BackendServer[i].Description = key.Key()
+				}
+			})
+			if err := ensure(f); err != nil {
+				return err
+			}
+			id, err := expectNaming(f)
+			if err != nil {
+				return err
+			}
+			if id != created {
+				return fmt.Errorf("expect vserver group %s renamed, got %s", created, id)
+			}
+			return nil
+		},
+	)
+
+	f.RunCustomized(t, "Drifted backend description is restored",
+		func(f *FrameWork) error {
+			rewrite(func(vg *slb.CreateVServerGroupResponse) {
+				if len(vg.BackendServers.BackendServer) > 0 {
+					vg.BackendServers.BackendServer[0].Description = "edited in console"
+				}
+			})
+			if err := ensure(f); err != nil {
+				return err
+			}
+			_, err := expectNaming(f)
+			return err
+		},
+	)
+}
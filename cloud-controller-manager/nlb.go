@@ -0,0 +1,794 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"strings"
+	"time"
+)
+
+// A service of loadbalancer-type nlb is served by a network loadbalancer
+// instead of a classic one. The nlb is created across the zones of the
+// zone-maps annotation and found by the name of the service. Each service
+// port gets a server group and a listener, tcp, udp, or tcpssl for the https
+// ports of the protocol-port annotation. Server groups belong to the vpc, they
+// are named k8s.${port}.${loadbalancer name}.${cluster hash}.
+// The nlb api is not wrapped by aliyungo, see domainextension.go.
+
+const (
+	LOADBALANCER_TYPE_CLB = "clb"
+	LOADBALANCER_TYPE_NLB = "nlb"
+
+	NLB_API_VERSION   = "2022-04-30"
+	NLB_STATUS_ACTIVE = "Active"
+
+	// NLB_ACTIVE_TIMEOUT time to wait for a created nlb to be active
+	NLB_ACTIVE_TIMEOUT = 2 * time.Minute
+
+	// NLB_DNS_SUFFIX the suffix of the dns names of the nlbs
+	NLB_DNS_SUFFIX = ".nlb.aliyuncs.com"
+)
+
+// NLBPagination the list apis of nlb page by token
+type NLBPagination struct {
+	NextToken  string
+	MaxResults int
+}
+
+type NLBZoneMapping struct {
+	ZoneId       string
+	VSwitchId    string
+	AllocationId string `json:",omitempty"`
+}
+
+type CreateNLBArgs struct {
+	RegionId         common.Region
+	LoadBalancerType string
+	LoadBalancerName string
+	AddressType      string
+	AddressIpVersion string
+	VpcId            string
+	ZoneMappings     []NLBZoneMapping
+	ClientToken      string `json:",omitempty"`
+}
+
+type CreateNLBResponse struct {
+	common.Response
+	LoadBalancerId string
+	JobId          string
+}
+
+type NLBType struct {
+	LoadBalancerId     string
+	LoadBalancerName   string
+	LoadBalancerStatus string
+	AddressType        string
+	DNSName            string
+	VpcId              string
+	ZoneMappings       []NLBZoneMapping
+}
+
+type ListNLBsArgs struct {
+	RegionId          common.Region
+	LoadBalancerIds   []string
+	LoadBalancerNames []string
+	VpcIds            []string
+	NLBPagination
+}
+
+type ListNLBsResponse struct {
+	common.Response
+	LoadBalancers []NLBType
+	NextToken     string
+}
+
+type GetNLBAttributeArgs struct {
+	RegionId       common.Region
+	LoadBalancerId string
+}
+
+type DeleteNLBArgs struct {
+	RegionId       common.Region
+	LoadBalancerId string
+}
+
+type NLBServerGroupType struct {
+	ServerGroupId   string
+	ServerGroupName string
+	ServerGroupType string
+	Protocol        string
+	VpcId           string
+}
+
+type CreateNLBServerGroupArgs struct {
+	RegionId        common.Region
+	ServerGroupName string
+	ServerGroupType string
+	Protocol        string
+	VpcId           string
+}
+
+type CreateNLBServerGroupResponse struct {
+	common.Response
+	ServerGroupId string
+	JobId         string
+}
+
+type ListNLBServerGroupsArgs struct {
+	RegionId         common.Region
+	ServerGroupIds   []string
+	ServerGroupNames []string
+	VpcId            string
+	NLBPagination
+}
+
+type ListNLBServerGroupsResponse struct {
+	common.Response
+	ServerGroups []NLBServerGroupType
+	NextToken    string
+}
+
+type DeleteNLBServerGroupArgs struct {
+	RegionId      common.Region
+	ServerGroupId string
+}
+
+type NLBServerType struct {
+	ServerId    string
+	ServerType  string
+	ServerIp    string `json:",omitempty"`
+	Port        int
+	Weight      int    `json:",omitempty"`
+	Description string `json:",omitempty"`
+}
+
+type ListNLBServerGroupServersArgs struct {
+	RegionId      common.Region
+	ServerGroupId string
+	NLBPagination
+}
+
+type ListNLBServerGroupServersResponse struct {
+	common.Response
+	Servers   []NLBServerType
+	NextToken string
+}
+
+// NLBServersArgs args of AddServersToServerGroup and RemoveServersFromServerGroup
+type NLBServersArgs struct {
+	RegionId      common.Region
+	ServerGroupId string
+	Servers       []NLBServerType
+}
+
+type NLBListenerType struct {
+	ListenerId          string
+	ListenerProtocol    string
+	ListenerPort        int
+	ServerGroupId       string
+	ListenerDescription string
+	CertificateIds      []string
+}
+
+type CreateNLBListenerArgs struct {
+	RegionId            common.Region
+	LoadBalancerId      string
+	ListenerProtocol    string
+	ListenerPort        int
+	ServerGroupId       string
+	ListenerDescription string
+	CertificateIds      []string
+}
+
+type CreateNLBListenerResponse struct {
+	common.Response
+	ListenerId string
+	JobId      string
+}
+
+type ListNLBListenersArgs struct {
+	RegionId        common.Region
+	LoadBalancerIds []string
+	NLBPagination
+}
+
+type ListNLBListenersResponse struct {
+	common.Response
+	Listeners []NLBListenerType
+	NextToken string
+}
+
+type UpdateNLBListenerArgs struct {
+	RegionId       common.Region
+	ListenerId     string
+	ServerGroupId  string
+	CertificateIds []string
+}
+
+type DeleteNLBListenerArgs struct {
+	RegionId   common.Region
+	ListenerId string
+}
+
+// ClientNLBSDK network loadbalancer api
+type ClientNLBSDK interface {
+	CreateLoadBalancer(ctx context.Context, args *CreateNLBArgs) (response *CreateNLBResponse, err error)
+	ListLoadBalancers(ctx context.Context, args *ListNLBsArgs) (lbs []NLBType, err error)
+	GetLoadBalancerAttribute(ctx context.Context, args *GetNLBAttributeArgs) (lb *NLBType, err error)
+	DeleteLoadBalancer(ctx context.Context, args *DeleteNLBArgs) (err error)
+
+	CreateServerGroup(ctx context.Context, args *CreateNLBServerGroupArgs) (response *CreateNLBServerGroupResponse, err error)
+	ListServerGroups(ctx context.Context, args *ListNLBServerGroupsArgs) (groups []NLBServerGroupType, err error)
+	DeleteServerGroup(ctx context.Context, args *DeleteNLBServerGroupArgs) (err error)
+	ListServerGroupServers(ctx context.Context, args *ListNLBServerGroupServersArgs) (servers []NLBServerType, err error)
+	AddServersToServerGroup(ctx context.Context, args *NLBServersArgs) (err error)
+	RemoveServersFromServerGroup(ctx context.Context, args *NLBServersArgs) (err error)
+
+	CreateListener(ctx context.Context, args *CreateNLBListenerArgs) (response *CreateNLBListenerResponse, err error)
+	ListListeners(ctx context.Context, args *ListNLBListenersArgs) (listeners []NLBListenerType, err error)
+	UpdateListenerAttribute(ctx context.Context, args *UpdateNLBListenerArgs) (err error)
+	DeleteListener(ctx context.Context, args *DeleteNLBListenerArgs) (err error)
+}
+
+// NLBClient network loadbalancers of the services
+type NLBClient struct {
+	c ClientNLBSDK
+	// clb builds the backends as the vserver groups do
+	clb   *LoadBalancerClient
+	vpcid string
+}
+
+func isNLB(service *v1.Service) bool {
+	return serviceAnnotation(service, ServiceAnnotationLoadBalancerType) == LOADBALANCER_TYPE_NLB
+}
+
+// servedByNLB whether the service is of type nlb, or has published the dns
+// name of a nlb, in case the annotation has been removed since
+func servedByNLB(service *v1.Service) bool {
+	if isNLB(service) {
+		return true
+	}
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if strings.HasSuffix(ingress.Hostname, NLB_DNS_SUFFIX) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseZoneMaps zone mappings of the annotation like "cn-hangzhou-h:vsw-xxx,cn-hangzhou-i:vsw-yyy"
+func parseZoneMaps(value string) ([]NLBZoneMapping, error) {
+	var zones []NLBZoneMapping
+	seen := map[string]bool{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pair := strings.Split(item, ":")
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" || strings.TrimSpace(pair[1]) == "" {
+			return nil, fmt.Errorf("[%s] must be of format ${zone-id}:${vswitch-id}", item)
+		}
+		zone := strings.TrimSpace(pair[0])
+		if seen[zone] {
+			return nil, fmt.Errorf("zone %s is mapped more than once", zone)
+		}
+		seen[zone] = true
+		zones = append(zones, NLBZoneMapping{ZoneId: zone, VSwitchId: strings.TrimSpace(pair[1])})
+	}
+	return zones, nil
+}
+
+// ValidateLoadBalancerType the type must be clb or nlb. A nlb takes two zones
+// at least, and the features of classic loadbalancers are not available.
+func ValidateLoadBalancerType(service *v1.Service) error {
+	kind := serviceAnnotation(service, ServiceAnnotationLoadBalancerType)
+	switch kind {
+	case "", LOADBALANCER_TYPE_CLB:
+		return nil
+	case LOADBALANCER_TYPE_NLB:
+	default:
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerType,
+			token:      kind,
+			reason:     fmt.Sprintf("must be one of %s, %s", LOADBALANCER_TYPE_CLB, LOADBALANCER_TYPE_NLB),
+		}
+	}
+	zoneMaps := serviceAnnotation(service, ServiceAnnotationLoadBalancerZoneMaps)
+	zones, err := parseZoneMaps(zoneMaps)
+	if err == nil && len(zones) < 2 {
+		err = fmt.Errorf("a nlb takes two zones at least")
+	}
+	if err != nil {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerZoneMaps,
+			token:      zoneMaps,
+			reason:     err.Error(),
+		}
+	}
+	for _, unsupported := range []string{
+		ServiceAnnotationLoadBalancerId,
+		utils.ServiceAnnotationLoadBalancerCertSecret,
+	} {
+		if serviceAnnotation(service, unsupported) != "" {
+			return annotationError{
+				annotation: ServiceAnnotationLoadBalancerType,
+				token:      kind,
+				reason:     fmt.Sprintf("annotation %s is not supported by nlb", unsupported),
+			}
+		}
+	}
+	for _, port := range service.Spec.Ports {
+		proto, err := Protocol(serviceAnnotation(service, ServiceAnnotationLoadBalancerProtocolPort), port)
+		if err != nil {
+			return err
+		}
+		if proto == "http" && !protocolAnnotated(service, port) {
+			// listened in tcp, appProtocol http is a hint of the layer 7 loadbalancers
+			continue
+		}
+		if proto == "http" {
+			return annotationError{
+				annotation: ServiceAnnotationLoadBalancerProtocolPort,
+				token:      fmt.Sprintf("http:%d", port.Port),
+				reason:     "http listeners are not supported by nlb",
+			}
+		}
+	}
+	return nil
+}
+
+// validateLoadBalancerSwitch the type of the loadbalancer can not be switched
+// once it is created, the service would be served by a new address.
+func (c *Cloud) validateLoadBalancerSwitch(ctx context.Context, service *v1.Service) error {
+	kind, other := LOADBALANCER_TYPE_CLB, LOADBALANCER_TYPE_NLB
+	var (
+		exists, switched bool
+		err              error
+	)
+	if isNLB(service) {
+		kind, other = other, kind
+		exists, _, err = c.climgr.NLBs().FindNLB(ctx, service)
+		if err != nil || exists {
+			return err
+		}
+		switched, _, err = c.climgr.LoadBalancers().FindLoadBalancer(ctx, service)
+		if err != nil {
+			return err
+		}
+	} else {
+		exists, _, err = c.climgr.LoadBalancers().FindLoadBalancer(ctx, service)
+		if err != nil || exists {
+			return err
+		}
+		// the clb services keep working when the nlb api is not available
+		switched, _, err = c.climgr.NLBs().FindNLB(ctx, service)
+		if err != nil {
+			klog.Warningf("find nlb of service %s/%s: %s", service.Namespace, service.Name, err.Error())
+			return nil
+		}
+	}
+	if !switched {
+		return nil
+	}
+	return annotationError{
+		annotation: ServiceAnnotationLoadBalancerType,
+		token:      kind,
+		reason:     fmt.Sprintf("the service is served by a %s, the type can not be switched", other),
+	}
+}
+
+// nlbListenerProtocol tcpssl for the https ports, the certificate is of the
+// cert-id annotation.
+func nlbListenerProtocol(service *v1.Service, port v1.ServicePort) (string, error) {
+	proto, err := Protocol(serviceAnnotation(service, ServiceAnnotationLoadBalancerProtocolPort), port)
+	if err != nil {
+		return "", err
+	}
+	switch proto {
+	case "udp":
+		return "UDP", nil
+	case "https":
+		return "TCPSSL", nil
+	}
+	return "TCP", nil
+}
+
+// nlbServerGroupName name of the server group of the service port
+func nlbServerGroupName(service *v1.Service, port int32) string {
+	return fmt.Sprintf("%s.%d%s", DEFAULT_PREFIX, port, nlbServerGroupSuffix(service))
+}
+
+func nlbServerGroupSuffix(service *v1.Service) string {
+	return fmt.Sprintf(".%s.%s", GetLoadBalancerName(service), utils.Hash(CLUSTER_ID)[:NAME_HASH_LENGTH])
+}
+
+// isNLBServerGroupOf whether the server group is created for service
+func isNLBServerGroupOf(group NLBServerGroupType, service *v1.Service) bool {
+	return strings.HasPrefix(group.ServerGroupName, DEFAULT_PREFIX+".") &&
+		strings.HasSuffix(group.ServerGroupName, nlbServerGroupSuffix(service))
+}
+
+// nlbServerKey servers are told apart by id and port, and ip for the ones
+// other than ecs.
+func nlbServerKey(s NLBServerType) string {
+	if s.ServerType == "Ecs" {
+		return fmt.Sprintf("%s/%d", s.ServerId, s.Port)
+	}
+	return fmt.Sprintf("%s/%s/%d", s.ServerId, s.ServerIp, s.Port)
+}
+
+// nlbServer the backend of the vserver group as a nlb server
+func nlbServer(b slb.VBackendServerType) NLBServerType {
+	server := NLBServerType{
+		ServerId:    b.ServerId,
+		ServerType:  "Ecs",
+		Port:        b.Port,
+		Weight:      b.Weight,
+		Description: b.Description,
+	}
+	switch b.Type {
+	case "eni":
+		server.ServerType = "Eni"
+		server.ServerIp = b.ServerIp
+	case "eci":
+		server.ServerType = "Eci"
+		server.ServerIp = b.ServerIp
+	}
+	return server
+}
+
+// FindNLB the nlb of the service by name
+func (n *NLBClient) FindNLB(ctx context.Context, service *v1.Service) (bool, *NLBType, error) {
+	if service.UID == "" {
+		return false, nil, fmt.Errorf("unexpected empty service uid")
+	}
+	lbs, err := n.c.ListLoadBalancers(
+		ctx,
+		&ListNLBsArgs{LoadBalancerNames: []string{GetLoadBalancerName(service)}},
+	)
+	if err != nil {
+		return false, nil, fmt.Errorf("list nlb: %s", err.Error())
+	}
+	if len(lbs) == 0 {
+		return false, nil, nil
+	}
+	if len(lbs) > 1 {
+		utils.Logf(service, "Warning: multiple nlb found by name %s, using %s", GetLoadBalancerName(service), lbs[0].LoadBalancerId)
+	}
+	return true, &lbs[0], nil
+}
+
+// EnsureNLB create the nlb of the service if absent, and reconcile its
+// listeners and server groups.
+func (n *NLBClient) EnsureNLB(ctx context.Context, service *v1.Service, nodes *EndpointWithENI) (*NLBType, error) {
+	exists, lb, err := n.FindNLB(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if lb, err = n.createNLB(ctx, service); err != nil {
+			return nil, err
+		}
+	}
+	groups, err := n.ensureServerGroups(ctx, service, nodes)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.ensureListeners(ctx, service, lb, groups); err != nil {
+		return nil, err
+	}
+	return lb, n.cleanupServerGroups(ctx, service, groups)
+}
+
+// UpdateNLB reconcile the backends of the server groups
+func (n *NLBClient) UpdateNLB(ctx context.Context, service *v1.Service, nodes *EndpointWithENI) error {
+	exists, _, err := n.FindNLB(ctx, service)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("the nlb of service %s/%s does not exist", service.Namespace, service.Name)
+	}
+	_, err = n.ensureServerGroups(ctx, service, nodes)
+	return err
+}
+
+// EnsureNLBDeleted delete the listeners, the nlb and then its server groups
+func (n *NLBClient) EnsureNLBDeleted(ctx context.Context, service *v1.Service) error {
+	exists, lb, err := n.FindNLB(ctx, service)
+	if err != nil {
+		return err
+	}
+	if exists {
+		utils.PublishLoadBalancerId(ctx, lb.LoadBalancerId)
+		listeners, err := n.c.ListListeners(ctx, &ListNLBListenersArgs{LoadBalancerIds: []string{lb.LoadBalancerId}})
+		if err != nil {
+			return fmt.Errorf("list nlb listeners: %s", err.Error())
+		}
+		for _, l := range listeners {
+			err := n.c.DeleteListener(ctx, &DeleteNLBListenerArgs{ListenerId: l.ListenerId})
+			if err != nil && !utils.IsCloudNotFound(err) {
+				return fmt.Errorf("delete nlb listener %d: %s", l.ListenerPort, err.Error())
+			}
+		}
+		utils.Logf(service, "delete nlb %s", lb.LoadBalancerId)
+		err = n.c.DeleteLoadBalancer(ctx, &DeleteNLBArgs{LoadBalancerId: lb.LoadBalancerId})
+		forgetClientToken(service)
+		if err != nil && !utils.IsCloudNotFound(err) {
+			return fmt.Errorf("delete nlb %s: %s", lb.LoadBalancerId, err.Error())
+		}
+	}
+	return n.cleanupServerGroups(ctx, service, nil)
+}
+
+func (n *NLBClient) createNLB(ctx context.Context, service *v1.Service) (*NLBType, error) {
+	// invalid annotation is rejected by ValidateLoadBalancerType
+	zones, _ := parseZoneMaps(serviceAnnotation(service, ServiceAnnotationLoadBalancerZoneMaps))
+	addressType := "Internet"
+	if serviceAnnotation(service, ServiceAnnotationLoadBalancerAddressType) == string(slb.IntranetAddressType) {
+		addressType = "Intranet"
+	}
+	args := &CreateNLBArgs{
+		LoadBalancerType: "Network",
+		LoadBalancerName: GetLoadBalancerName(service),
+		AddressType:      addressType,
+		AddressIpVersion: "ipv4",
+		VpcId:            n.vpcid,
+		ZoneMappings:     zones,
+	}
+	args.ClientToken = nlbClientToken(service, args)
+	resp, err := n.c.CreateLoadBalancer(ctx, args)
+	if err != nil {
+		return nil, fmt.Errorf("create nlb: %s", err.Error())
+	}
+	// the nlb is found by its name from now on
+	forgetClientToken(service)
+	utils.Logf(service, "nlb %s created, wait for it to be active", resp.LoadBalancerId)
+	var lb *NLBType
+	err = wait.PollImmediate(
+		2*time.Second, NLB_ACTIVE_TIMEOUT,
+		func() (bool, error) {
+			lb, err = n.c.GetLoadBalancerAttribute(ctx, &GetNLBAttributeArgs{LoadBalancerId: resp.LoadBalancerId})
+			if err != nil {
+				return false, err
+			}
+			return lb.LoadBalancerStatus == NLB_STATUS_ACTIVE, nil
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("wait for nlb %s to be active: %s", resp.LoadBalancerId, err.Error())
+	}
+	return lb, nil
+}
+
+// ensureServerGroups server group ids of the service ports, with the backends
+// reconciled. Weights are set when the backends are added.
+func (n *NLBClient) ensureServerGroups(ctx context.Context, service *v1.Service, nodes *EndpointWithENI) (map[int32]string, error) {
+	remote, err := n.c.ListServerGroups(ctx, &ListNLBServerGroupsArgs{VpcId: n.vpcid})
+	if err != nil {
+		return nil, fmt.Errorf("list nlb server groups: %s", err.Error())
+	}
+	byName := map[string]string{}
+	for _, g := range remote {
+		byName[g.ServerGroupName] = g.ServerGroupId
+	}
+	groups := map[int32]string{}
+	vgs := BuildVirtualGroupFromService(n.clb, service, &slb.LoadBalancerType{})
+	for i, vg := range *vgs {
+		port := service.Spec.Ports[i]
+		name := nlbServerGroupName(service, port.Port)
+		id, ok := byName[name]
+		if !ok {
+			protocol := "TCP"
+			if port.Protocol == v1.ProtocolUDP {
+				protocol = "UDP"
+			}
+			resp, err := n.c.CreateServerGroup(
+				ctx,
+				&CreateNLBServerGroupArgs{
+					ServerGroupName: name,
+					ServerGroupType: "Instance",
+					Protocol:        protocol,
+					VpcId:           n.vpcid,
+				},
+			)
+			if err != nil {
+				return nil, fmt.Errorf("create nlb server group %s: %s", name, err.Error())
+			}
+			id = resp.ServerGroupId
+		}
+		groups[port.Port] = id
+		backends, err := nodes.BuildBackend(ctx, vg)
+		if err != nil {
+			return nil, fmt.Errorf("build backend: %s, %s", err.Error(), name)
+		}
+		if err := n.ensureServers(ctx, id, backends); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// ensureServers add and remove the servers of the group by nlbServerKey
+func (n *NLBClient) ensureServers(ctx context.Context, id string, backends []slb.VBackendServerType) error {
+	servers, err := n.c.ListServerGroupServers(ctx, &ListNLBServerGroupServersArgs{ServerGroupId: id})
+	if err != nil {
+		return fmt.Errorf("list servers of nlb server group %s: %s", id, err.Error())
+	}
+	expected := map[string]bool{}
+	existing := map[string]bool{}
+	for _, s := range servers {
+		existing[nlbServerKey(s)] = true
+	}
+	var add, del []NLBServerType
+	for _, b := range backends {
+		s := nlbServer(b)
+		expected[nlbServerKey(s)] = true
+		if !existing[nlbServerKey(s)] {
+			add = append(add, s)
+		}
+	}
+	for _, s := range servers {
+		if !expected[nlbServerKey(s)] {
+			del = append(del, s)
+		}
+	}
+	if len(add) > 0 {
+		if err := Batch(add, MAX_BACKEND_NUM,
+			func(list []interface{}) error {
+				var batch []NLBServerType
+				for _, s := range list {
+					batch = append(batch, s.(NLBServerType))
+				}
+				klog.Infof("nlb server group %s: add servers %v", id, batch)
+				return n.c.AddServersToServerGroup(ctx, &NLBServersArgs{ServerGroupId: id, Servers: batch})
+			}); err != nil {
+			return fmt.Errorf("add servers to nlb server group %s: %s", id, err.Error())
+		}
+	}
+	if len(del) > 0 {
+		if err := Batch(del, MAX_BACKEND_NUM,
+			func(list []interface{}) error {
+				var batch []NLBServerType
+				for _, s := range list {
+					batch = append(batch, s.(NLBServerType))
+				}
+				klog.Infof("nlb server group %s: remove servers %v", id, batch)
+				return n.c.RemoveServersFromServerGroup(ctx, &NLBServersArgs{ServerGroupId: id, Servers: batch})
+			}); err != nil {
+			return fmt.Errorf("remove servers from nlb server group %s: %s", id, err.Error())
+		}
+	}
+	return nil
+}
+
+// ensureListeners a listener of each service port on its server group. The
+// listeners of the other ports or protocols are deleted first, the port of a
+// listener is taken until then.
+func (n *NLBClient) ensureListeners(ctx context.Context, service *v1.Service, lb *NLBType, groups map[int32]string) error {
+	remote, err := n.c.ListListeners(ctx, &ListNLBListenersArgs{LoadBalancerIds: []string{lb.LoadBalancerId}})
+	if err != nil {
+		return fmt.Errorf("list nlb listeners: %s", err.Error())
+	}
+	protocols := map[int32]string{}
+	for _, port := range service.Spec.Ports {
+		if protocols[port.Port], err = nlbListenerProtocol(service, port); err != nil {
+			return err
+		}
+	}
+	existing := map[int32]NLBListenerType{}
+	for _, l := range remote {
+		if protocols[int32(l.ListenerPort)] == l.ListenerProtocol {
+			existing[int32(l.ListenerPort)] = l
+			continue
+		}
+		utils.Logf(service, "delete nlb listener %s:%d", l.ListenerProtocol, l.ListenerPort)
+		if err := n.c.DeleteListener(ctx, &DeleteNLBListenerArgs{ListenerId: l.ListenerId}); err != nil {
+			return fmt.Errorf("delete nlb listener %s:%d: %s", l.ListenerProtocol, l.ListenerPort, err.Error())
+		}
+	}
+	var certs []string
+	if cert := serviceAnnotation(service, ServiceAnnotationLoadBalancerCertID); cert != "" {
+		// the nlb serves the certificate of the algorithm the client supports
+		rsa, ecc := listenerCertIDs(cert)
+		certs = []string{rsa}
+		if ecc != "" {
+			certs = append(certs, ecc)
+		}
+	}
+	for _, port := range service.Spec.Ports {
+		proto := protocols[port.Port]
+		var listenerCerts []string
+		if proto == "TCPSSL" {
+			listenerCerts = certs
+		}
+		listener, ok := existing[port.Port]
+		if !ok {
+			utils.Logf(service, "create nlb listener %s:%d", proto, port.Port)
+			_, err := n.c.CreateListener(
+				ctx,
+				&CreateNLBListenerArgs{
+					LoadBalancerId:      lb.LoadBalancerId,
+					ListenerProtocol:    proto,
+					ListenerPort:        int(port.Port),
+					ServerGroupId:       groups[port.Port],
+					ListenerDescription: nlbServerGroupName(service, port.Port),
+					CertificateIds:      listenerCerts,
+				},
+			)
+			if err != nil {
+				return fmt.Errorf("create nlb listener %s:%d: %s", proto, port.Port, err.Error())
+			}
+			continue
+		}
+		if listener.ServerGroupId == groups[port.Port] &&
+			strings.Join(listener.CertificateIds, ",") == strings.Join(listenerCerts, ",") {
+			continue
+		}
+		utils.Logf(service, "update nlb listener %s:%d", proto, port.Port)
+		err := n.c.UpdateListenerAttribute(
+			ctx,
+			&UpdateNLBListenerArgs{
+				ListenerId:     listener.ListenerId,
+				ServerGroupId:  groups[port.Port],
+				CertificateIds: listenerCerts,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("update nlb listener %s:%d: %s", proto, port.Port, err.Error())
+		}
+	}
+	return nil
+}
+
+// cleanupServerGroups delete the server groups of the service not in use
+func (n *NLBClient) cleanupServerGroups(ctx context.Context, service *v1.Service, groups map[int32]string) error {
+	remote, err := n.c.ListServerGroups(ctx, &ListNLBServerGroupsArgs{VpcId: n.vpcid})
+	if err != nil {
+		return fmt.Errorf("list nlb server groups: %s", err.Error())
+	}
+	used := map[string]bool{}
+	for _, id := range groups {
+		used[id] = true
+	}
+	for _, g := range remote {
+		if used[g.ServerGroupId] || !isNLBServerGroupOf(g, service) {
+			continue
+		}
+		utils.Logf(service, "delete nlb server group %s", g.ServerGroupName)
+		if err := n.c.DeleteServerGroup(ctx, &DeleteNLBServerGroupArgs{ServerGroupId: g.ServerGroupId}); err != nil {
+			return fmt.Errorf("delete nlb server group %s: %s", g.ServerGroupName, err.Error())
+		}
+	}
+	return nil
+}
+
+// nlbStatus the dns name of the nlb is published, it has no fixed ip
+func nlbStatus(lb *NLBType) *v1.LoadBalancerStatus {
+	return &v1.LoadBalancerStatus{
+		Ingress: []v1.LoadBalancerIngress{{Hostname: lb.DNSName}},
+	}
+}
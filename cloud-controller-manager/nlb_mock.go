@@ -0,0 +1,313 @@
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+func WithNewNLBStore() CloudDataMock {
+	return func() {
+		NLBSTORE = NLBStore{}
+	}
+}
+
+type NLBStore struct {
+	// loadbalancers LoadBalancerId: NLBType
+	loadbalancers sync.Map
+	// groups ServerGroupId: NLBServerGroupType
+	groups sync.Map
+	// servers ServerGroupId: []NLBServerType
+	servers sync.Map
+	// listeners LoadBalancerId/ListenerId: NLBListenerType
+	listeners sync.Map
+}
+
+// NLBSTORE nlb cloud mock storage
+var NLBSTORE = NLBStore{}
+
+type mockClientNLB struct {
+	createLoadBalancer           func(args *CreateNLBArgs) (response *CreateNLBResponse, err error)
+	listLoadBalancers            func(args *ListNLBsArgs) (lbs []NLBType, err error)
+	getLoadBalancerAttribute     func(args *GetNLBAttributeArgs) (lb *NLBType, err error)
+	deleteLoadBalancer           func(args *DeleteNLBArgs) (err error)
+	createServerGroup            func(args *CreateNLBServerGroupArgs) (response *CreateNLBServerGroupResponse, err error)
+	listServerGroups             func(args *ListNLBServerGroupsArgs) (groups []NLBServerGroupType, err error)
+	deleteServerGroup            func(args *DeleteNLBServerGroupArgs) (err error)
+	listServerGroupServers       func(args *ListNLBServerGroupServersArgs) (servers []NLBServerType, err error)
+	addServersToServerGroup      func(args *NLBServersArgs) (err error)
+	removeServersFromServerGroup func(args *NLBServersArgs) (err error)
+	createListener               func(args *CreateNLBListenerArgs) (response *CreateNLBListenerResponse, err error)
+	listListeners                func(args *ListNLBListenersArgs) (listeners []NLBListenerType, err error)
+	updateListenerAttribute      func(args *UpdateNLBListenerArgs) (err error)
+	deleteListener               func(args *DeleteNLBListenerArgs) (err error)
+}
+
+func (c *mockClientNLB) CreateLoadBalancer(ctx context.Context, args *CreateNLBArgs) (response *CreateNLBResponse, err error) {
+	if c.createLoadBalancer != nil {
+		return c.createLoadBalancer(args)
+	}
+	id := "nlb-" + strings.TrimPrefix(newid(), "lb-")
+	NLBSTORE.loadbalancers.Store(
+		id,
+		NLBType{
+			LoadBalancerId:     id,
+			LoadBalancerName:   args.LoadBalancerName,
+			LoadBalancerStatus: NLB_STATUS_ACTIVE,
+			AddressType:        args.AddressType,
+			DNSName:            fmt.Sprintf("%s.%s.nlb.aliyuncs.com", id, REGION),
+			VpcId:              args.VpcId,
+			ZoneMappings:       args.ZoneMappings,
+		},
+	)
+	return &CreateNLBResponse{LoadBalancerId: id}, nil
+}
+
+func (c *mockClientNLB) ListLoadBalancers(ctx context.Context, args *ListNLBsArgs) (lbs []NLBType, err error) {
+	if c.listLoadBalancers != nil {
+		return c.listLoadBalancers(args)
+	}
+	NLBSTORE.loadbalancers.Range(
+		func(key, value interface{}) bool {
+			v := value.(NLBType)
+			if len(args.LoadBalancerIds) > 0 && !containsString(args.LoadBalancerIds, v.LoadBalancerId) {
+				return true
+			}
+			if len(args.LoadBalancerNames) > 0 && !containsString(args.LoadBalancerNames, v.LoadBalancerName) {
+				return true
+			}
+			lbs = append(lbs, v)
+			return true
+		},
+	)
+	return lbs, nil
+}
+
+func (c *mockClientNLB) GetLoadBalancerAttribute(ctx context.Context, args *GetNLBAttributeArgs) (lb *NLBType, err error) {
+	if c.getLoadBalancerAttribute != nil {
+		return c.getLoadBalancerAttribute(args)
+	}
+	v, ok := NLBSTORE.loadbalancers.Load(args.LoadBalancerId)
+	if !ok {
+		return nil, fmt.Errorf("ResourceNotFound.loadBalancer: %s not found", args.LoadBalancerId)
+	}
+	nlb := v.(NLBType)
+	return &nlb, nil
+}
+
+func (c *mockClientNLB) DeleteLoadBalancer(ctx context.Context, args *DeleteNLBArgs) (err error) {
+	if c.deleteLoadBalancer != nil {
+		return c.deleteLoadBalancer(args)
+	}
+	NLBSTORE.loadbalancers.Delete(args.LoadBalancerId)
+	NLBSTORE.listeners.Range(
+		func(key, value interface{}) bool {
+			if strings.HasPrefix(key.(string), args.LoadBalancerId+"/") {
+				NLBSTORE.listeners.Delete(key)
+			}
+			return true
+		},
+	)
+	return nil
+}
+
+func (c *mockClientNLB) CreateServerGroup(ctx context.Context, args *CreateNLBServerGroupArgs) (response *CreateNLBServerGroupResponse, err error) {
+	if c.createServerGroup != nil {
+		return c.createServerGroup(args)
+	}
+	id := "sgp-" + strings.TrimPrefix(newid(), "lb-")
+	NLBSTORE.groups.Store(
+		id,
+		NLBServerGroupType{
+			ServerGroupId:   id,
+			ServerGroupName: args.ServerGroupName,
+			ServerGroupType: args.ServerGroupType,
+			Protocol:        args.Protocol,
+			VpcId:           args.VpcId,
+		},
+	)
+	return &CreateNLBServerGroupResponse{ServerGroupId: id}, nil
+}
+
+func (c *mockClientNLB) ListServerGroups(ctx context.Context, args *ListNLBServerGroupsArgs) (groups []NLBServerGroupType, err error) {
+	if c.listServerGroups != nil {
+		return c.listServerGroups(args)
+	}
+	NLBSTORE.groups.Range(
+		func(key, value interface{}) bool {
+			v := value.(NLBServerGroupType)
+			if args.VpcId != "" && v.VpcId != args.VpcId {
+				return true
+			}
+			if len(args.ServerGroupIds) > 0 && !containsString(args.ServerGroupIds, v.ServerGroupId) {
+				return true
+			}
+			if len(args.ServerGroupNames) > 0 && !containsString(args.ServerGroupNames, v.ServerGroupName) {
+				return true
+			}
+			groups = append(groups, v)
+			return true
+		},
+	)
+	return groups, nil
+}
+
+func (c *mockClientNLB) DeleteServerGroup(ctx context.Context, args *DeleteNLBServerGroupArgs) (err error) {
+	if c.deleteServerGroup != nil {
+		return c.deleteServerGroup(args)
+	}
+	inuse := false
+	NLBSTORE.listeners.Range(
+		func(key, value interface{}) bool {
+			if value.(NLBListenerType).ServerGroupId == args.ServerGroupId {
+				inuse = true
+			}
+			return !inuse
+		},
+	)
+	if inuse {
+		return fmt.Errorf("ResourceInUse.serverGroup: %s is used by listeners", args.ServerGroupId)
+	}
+	NLBSTORE.groups.Delete(args.ServerGroupId)
+	NLBSTORE.servers.Delete(args.ServerGroupId)
+	return nil
+}
+
+func (c *mockClientNLB) ListServerGroupServers(ctx context.Context, args *ListNLBServerGroupServersArgs) (servers []NLBServerType, err error) {
+	if c.listServerGroupServers != nil {
+		return c.listServerGroupServers(args)
+	}
+	if _, ok := NLBSTORE.groups.Load(args.ServerGroupId); !ok {
+		return nil, fmt.Errorf("ResourceNotFound.serverGroup: %s not found", args.ServerGroupId)
+	}
+	v, ok := NLBSTORE.servers.Load(args.ServerGroupId)
+	if !ok {
+		return nil, nil
+	}
+	return append(servers, v.([]NLBServerType)...), nil
+}
+
+func (c *mockClientNLB) AddServersToServerGroup(ctx context.Context, args *NLBServersArgs) (err error) {
+	if c.addServersToServerGroup != nil {
+		return c.addServersToServerGroup(args)
+	}
+	var servers []NLBServerType
+	if v, ok := NLBSTORE.servers.Load(args.ServerGroupId); ok {
+		servers = v.([]NLBServerType)
+	}
+	NLBSTORE.servers.Store(args.ServerGroupId, append(servers, args.Servers...))
+	return nil
+}
+
+func (c *mockClientNLB) RemoveServersFromServerGroup(ctx context.Context, args *NLBServersArgs) (err error) {
+	if c.removeServersFromServerGroup != nil {
+		return c.removeServersFromServerGroup(args)
+	}
+	v, ok := NLBSTORE.servers.Load(args.ServerGroupId)
+	if !ok {
+		return nil
+	}
+	removed := map[string]bool{}
+	for _, s := range args.Servers {
+		removed[nlbServerKey(s)] = true
+	}
+	var left []NLBServerType
+	for _, s := range v.([]NLBServerType) {
+		if !removed[nlbServerKey(s)] {
+			left = append(left, s)
+		}
+	}
+	NLBSTORE.servers.Store(args.ServerGroupId, left)
+	return nil
+}
+
+func (c *mockClientNLB) CreateListener(ctx context.Context, args *CreateNLBListenerArgs) (response *CreateNLBListenerResponse, err error) {
+	if c.createListener != nil {
+		return c.createListener(args)
+	}
+	if _, ok := NLBSTORE.loadbalancers.Load(args.LoadBalancerId); !ok {
+		return nil, fmt.Errorf("ResourceNotFound.loadBalancer: %s not found", args.LoadBalancerId)
+	}
+	id := "lsn-" + strings.TrimPrefix(newid(), "lb-")
+	NLBSTORE.listeners.Store(
+		args.LoadBalancerId+"/"+id,
+		NLBListenerType{
+			ListenerId:          id,
+			ListenerProtocol:    args.ListenerProtocol,
+			ListenerPort:        args.ListenerPort,
+			ServerGroupId:       args.ServerGroupId,
+			ListenerDescription: args.ListenerDescription,
+			CertificateIds:      args.CertificateIds,
+		},
+	)
+	return &CreateNLBListenerResponse{ListenerId: id}, nil
+}
+
+func (c *mockClientNLB) ListListeners(ctx context.Context, args *ListNLBListenersArgs) (listeners []NLBListenerType, err error) {
+	if c.listListeners != nil {
+		return c.listListeners(args)
+	}
+	NLBSTORE.listeners.Range(
+		func(key, value interface{}) bool {
+			lbid := strings.Split(key.(string), "/")[0]
+			if len(args.LoadBalancerIds) > 0 && !containsString(args.LoadBalancerIds, lbid) {
+				return true
+			}
+			listeners = append(listeners, value.(NLBListenerType))
+			return true
+		},
+	)
+	return listeners, nil
+}
+
+func (c *mockClientNLB) UpdateListenerAttribute(ctx context.Context, args *UpdateNLBListenerArgs) (err error) {
+	if c.updateListenerAttribute != nil {
+		return c.updateListenerAttribute(args)
+	}
+	found := false
+	NLBSTORE.listeners.Range(
+		func(key, value interface{}) bool {
+			v := value.(NLBListenerType)
+			if v.ListenerId != args.ListenerId {
+				return true
+			}
+			if args.ServerGroupId != "" {
+				v.ServerGroupId = args.ServerGroupId
+			}
+			v.CertificateIds = args.CertificateIds
+			NLBSTORE.listeners.Store(key, v)
+			found = true
+			return false
+		},
+	)
+	if !found {
+		return fmt.Errorf("ResourceNotFound.listener: %s not found", args.ListenerId)
+	}
+	return nil
+}
+
+func (c *mockClientNLB) DeleteListener(ctx context.Context, args *DeleteNLBListenerArgs) (err error) {
+	if c.deleteListener != nil {
+		return c.deleteListener(args)
+	}
+	NLBSTORE.listeners.Range(
+		func(key, value interface{}) bool {
+			if value.(NLBListenerType).ListenerId == args.ListenerId {
+				NLBSTORE.listeners.Delete(key)
+				return false
+			}
+			return true
+		},
+	)
+	return nil
+}
+
+func containsString(list []string, x string) bool {
+	for _, v := range list {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const NLB_ZONE_MAPS = "cn-hangzhou-h:vsw-xxx,cn-hangzhou-i:vsw-yyy"
+
+func TestValidateLoadBalancerType(t *testing.T) {
+	nlb := func(annotations map[string]string) map[string]string {
+		annotations[ServiceAnnotationLoadBalancerType] = LOADBALANCER_TYPE_NLB
+		return annotations
+	}
+	cases := []struct {
+		annotations map[string]string
+		invalid     bool
+	}{
+		{annotations: map[string]string{}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerType: LOADBALANCER_TYPE_CLB}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerType: "alb"}, invalid: true},
+		{annotations: nlb(map[string]string{ServiceAnnotationLoadBalancerZoneMaps: NLB_ZONE_MAPS})},
+		{annotations: nlb(map[string]string{
+			ServiceAnnotationLoadBalancerZoneMaps:     NLB_ZONE_MAPS,
+			ServiceAnnotationLoadBalancerProtocolPort: "https:443",
+			ServiceAnnotationLoadBalancerCertID:       "cert-xxx",
+		})},
+		{annotations: nlb(map[string]string{}), invalid: true},
+		{annotations: nlb(map[string]string{ServiceAnnotationLoadBalancerZoneMaps: "cn-hangzhou-h:vsw-xxx"}), invalid: true},
+		{annotations: nlb(map[string]string{ServiceAnnotationLoadBalancerZoneMaps: "cn-hangzhou-h,cn-hangzhou-i"}), invalid: true},
+		{annotations: nlb(map[string]string{ServiceAnnotationLoadBalancerZoneMaps: "cn-hangzhou-h:vsw-xxx,cn-hangzhou-h:vsw-yyy"}), invalid: true},
+		{annotations: nlb(map[string]string{
+			ServiceAnnotationLoadBalancerZoneMaps: NLB_ZONE_MAPS,
+			ServiceAnnotationLoadBalancerId:       LOADBALANCER_ID,
+		}), invalid: true},
+		{annotations: nlb(map[string]string{
+			ServiceAnnotationLoadBalancerZoneMaps:     NLB_ZONE_MAPS,
+			ServiceAnnotationLoadBalancerProtocolPort: "http:443",
+		}), invalid: true},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: c.annotations},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP}},
+			},
+		}
+		err := ValidateLoadBalancerType(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("%v: unexpected error: %s", c.annotations, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%v: expect InvalidAnnotation error, got %v", c.annotations, err)
+		}
+	}
+}
+
+func TestNLB(t *testing.T) {
+	prid1 := nodeid(string(REGION), INSTANCEID)
+	prid2 := nodeid(string(REGION), INSTANCEID2)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerType:         LOADBALANCER_TYPE_NLB,
+					ServiceAnnotationLoadBalancerZoneMaps:     NLB_ZONE_MAPS,
+					ServiceAnnotationLoadBalancerProtocolPort: "https:443",
+					ServiceAnnotationLoadBalancerCertID:       "cert-xxx",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 443, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: 31443},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid1},
+				Spec:       v1.NodeSpec{ProviderID: prid1},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid2},
+				Spec:       v1.NodeSpec{ProviderID: prid2},
+			},
+		},
+	)
+
+	recorder := record.NewFakeRecorder(10)
+	ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+	// listeners of the nlb by protocol/port, with the servers of their server groups
+	expectListeners := func(f *FrameWork, expect map[string][]string) error {
+		exists, lb, err := f.Cloud.climgr.NLBs().FindNLB(context.Background(), f.SVC)
+		if err != nil || !exists {
+			return fmt.Errorf("find nlb: exists=%t, %v", exists, err)
+		}
+		listeners, err := f.NLBSDK().ListListeners(context.Background(), &ListNLBListenersArgs{LoadBalancerIds: []string{lb.LoadBalancerId}})
+		if err != nil {
+			return err
+		}
+		got := map[string][]string{}
+		for _, l := range listeners {
+			if l.ListenerProtocol == "TCPSSL" && !reflect.DeepEqual(l.CertificateIds, []string{"cert-xxx"}) {
+				return fmt.Errorf("expect certificate cert-xxx of tcpssl listener, got %v", l.CertificateIds)
+			}
+			servers, err := f.NLBSDK().ListServerGroupServers(context.Background(), &ListNLBServerGroupServersArgs{ServerGroupId: l.ServerGroupId})
+			if err != nil {
+				return err
+			}
+			key := fmt.Sprintf("%s/%d", l.ListenerProtocol, l.ListenerPort)
+			got[key] = []string{}
+			for _, s := range servers {
+				got[key] = append(got[key], fmt.Sprintf("%s:%d", s.ServerId, s.Port))
+			}
+			sort.Strings(got[key])
+		}
+		for _, servers := range expect {
+			sort.Strings(servers)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			return fmt.Errorf("expect listeners %v, got %v", expect, got)
+		}
+		groups, err := f.NLBSDK().ListServerGroups(context.Background(), &ListNLBServerGroupsArgs{VpcId: VPCID})
+		if err != nil {
+			return err
+		}
+		if len(groups) != len(expect) {
+			return fmt.Errorf("expect %d server groups, got %d", len(expect), len(groups))
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "NLB is created with a listener of each port",
+		func(f *FrameWork) error {
+			mock := f.NLBSDK().(*mockClientNLB)
+			defer func() { mock.createLoadBalancer = nil }()
+			var token string
+			mock.createLoadBalancer = func(args *CreateNLBArgs) (*CreateNLBResponse, error) {
+				token = args.ClientToken
+				return (&mockClientNLB{}).CreateLoadBalancer(context.Background(), args)
+			}
+			status, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+			if err != nil {
+				return err
+			}
+			if len(status.Ingress) != 1 || !strings.HasSuffix(status.Ingress[0].Hostname, NLB_DNS_SUFFIX) {
+				return fmt.Errorf("expect the dns name of the nlb as ingress, got %v", status.Ingress)
+			}
+			f.SVC.Status.LoadBalancer = *status
+			if !strings.HasPrefix(token, string(f.SVC.UID)) {
+				return fmt.Errorf("expect the nlb created with the client token of the service, got %q", token)
+			}
+			_, lb, err := f.Cloud.climgr.NLBs().FindNLB(context.Background(), f.SVC)
+			if err != nil {
+				return err
+			}
+			if len(lb.ZoneMappings) != 2 || lb.VpcId != VPCID {
+				return fmt.Errorf("expect nlb in 2 zones of vpc %s, got %v", VPCID, lb)
+			}
+			// no classic loadbalancer is created
+			if exists, _, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC); err != nil || exists {
+				return fmt.Errorf("expect no clb created, exists=%t, %v", exists, err)
+			}
+			return expectListeners(f, map[string][]string{
+				"TCP/80":     {fmt.Sprintf("%s:%d", INSTANCEID, nodePort1), fmt.Sprintf("%s:%d", INSTANCEID2, nodePort1)},
+				"TCPSSL/443": {fmt.Sprintf("%s:31443", INSTANCEID), fmt.Sprintf("%s:31443", INSTANCEID2)},
+			})
+		},
+	)
+
+	f.RunCustomized(t, "Backends are updated",
+		func(f *FrameWork) error {
+			if err := f.CloudImpl().UpdateLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes[:1]); err != nil {
+				return err
+			}
+			return expectListeners(f, map[string][]string{
+				"TCP/80":     {fmt.Sprintf("%s:%d", INSTANCEID, nodePort1)},
+				"TCPSSL/443": {fmt.Sprintf("%s:31443", INSTANCEID)},
+			})
+		},
+	)
+
+	f.SVC.Spec.Ports = f.SVC.Spec.Ports[:1]
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerProtocolPort)
+	f.RunCustomized(t, "Listener and server group of the removed port are deleted",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			return expectListeners(f, map[string][]string{
+				"TCP/80": {fmt.Sprintf("%s:%d", INSTANCEID, nodePort1), fmt.Sprintf("%s:%d", INSTANCEID2, nodePort1)},
+			})
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerType] = LOADBALANCER_TYPE_CLB
+	f.RunCustomized(t, "Switching to clb is rejected",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+				return fmt.Errorf("expect InvalidAnnotation error, got %v", err)
+			}
+			if len(recorder.Events) != 1 || !strings.Contains(<-recorder.Events, ServiceAnnotationLoadBalancerType) {
+				return fmt.Errorf("expect an event of the type annotation")
+			}
+			if exists, _, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC); err != nil || exists {
+				return fmt.Errorf("expect no clb created, exists=%t, %v", exists, err)
+			}
+			return nil
+		},
+	)
+
+	// the dns name published tells the nlb once the annotation is removed
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerType)
+	f.RunCustomized(t, "NLB and its server groups are deleted",
+		func(f *FrameWork) error {
+			if err := f.CloudImpl().EnsureLoadBalancerDeleted(ctx, CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			if exists, _, err := f.Cloud.climgr.NLBs().FindNLB(context.Background(), f.SVC); err != nil || exists {
+				return fmt.Errorf("expect nlb deleted, exists=%t, %v", exists, err)
+			}
+			groups, err := f.NLBSDK().ListServerGroups(context.Background(), &ListNLBServerGroupsArgs{})
+			if err != nil || len(groups) != 0 {
+				return fmt.Errorf("expect server groups deleted, got %v, %v", groups, err)
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Status.LoadBalancer = v1.LoadBalancerStatus{}
+	f.RunCustomized(t, "No nlb looked up by the deletion of a clb service",
+		func(f *FrameWork) error {
+			mock := f.NLBSDK().(*mockClientNLB)
+			defer func() { mock.listLoadBalancers = nil }()
+			lookups := 0
+			mock.listLoadBalancers = func(args *ListNLBsArgs) ([]NLBType, error) {
+				lookups++
+				return nil, nil
+			}
+			if err := f.CloudImpl().EnsureLoadBalancerDeleted(ctx, CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			if lookups != 0 {
+				return fmt.Errorf("expect no nlb looked up, got %d lookups", lookups)
+			}
+			return nil
+		},
+	)
+}
+
+func TestSwitchToNLB(t *testing.T) {
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// the clb of the service exists
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerType:     LOADBALANCER_TYPE_NLB,
+					ServiceAnnotationLoadBalancerZoneMaps: NLB_ZONE_MAPS,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	)
+	f.RunCustomized(t, "Switching to nlb is rejected",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+				return fmt.Errorf("expect InvalidAnnotation error, got %v", err)
+			}
+			if exists, _, err := f.Cloud.climgr.NLBs().FindNLB(context.Background(), f.SVC); err != nil || exists {
+				return fmt.Errorf("expect no nlb created, exists=%t, %v", exists, err)
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+)
+
+// A LoadBalancer service of spec.allocateLoadBalancerNodePorts=false has no
+// node ports unless they are set by the user. The field is dropped by the
+// decoding of the vendored k8s.io/api v0.18, which predates it, so that the
+// ports without a node port tell the allocation disabled. The eni backends
+// are added by their target ports and need no node port, the node backends
+// can not be reached without one.
+
+// portsWithoutNodePort the service ports whose node port is not allocated
+func portsWithoutNodePort(service *v1.Service) []int32 {
+	var ports []int32
+	for _, port := range service.Spec.Ports {
+		if port.NodePort == 0 {
+			ports = append(ports, port.Port)
+		}
+	}
+	return ports
+}
+
+// ValidateNodePorts the node backends need the node ports of every port,
+// the eni backends ignore them
+func ValidateNodePorts(service *v1.Service) error {
+	if IsENIBackendType(service) {
+		return nil
+	}
+	ports := portsWithoutNodePort(service)
+	if len(ports) == 0 {
+		return nil
+	}
+	backendType := serviceAnnotation(service, utils.BACKEND_TYPE_LABEL)
+	if backendType == "" {
+		backendType = utils.BACKEND_TYPE_ECS
+	}
+	return annotationError{
+		annotation: utils.BACKEND_TYPE_LABEL,
+		token:      backendType,
+		reason: fmt.Sprintf("node ports of ports %v are not allocated, "+
+			"eg. spec.allocateLoadBalancerNodePorts is false, the node backends can not be reached. "+
+			"Set spec.allocateLoadBalancerNodePorts to true, or set the annotation to %s "+
+			"to add the pods by their target ports", ports, utils.BACKEND_TYPE_ENI),
+	}
+}
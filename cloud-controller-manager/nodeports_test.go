@@ -0,0 +1,160 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+// noNodePortService a service of spec.allocateLoadBalancerNodePorts=false,
+// its ports have no node port
+func noNodePortService(annotations map[string]string) *v1.Service {
+	return testService(annotations,
+		v1.ServicePort{Name: "tcp-80", Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP},
+		v1.ServicePort{Name: "http", Port: 8080, TargetPort: intstr.FromString("http"), Protocol: v1.ProtocolTCP},
+	)
+}
+
+func TestValidateNodePorts(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		nodePort    int32
+		valid       bool
+	}{
+		{name: "node ports allocated", annotations: map[string]string{}, nodePort: nodePort1, valid: true},
+		{name: "eni without node ports", annotations: map[string]string{utils.BACKEND_TYPE_LABEL: utils.BACKEND_TYPE_ENI}, valid: true},
+		{name: "node backends without node ports", annotations: map[string]string{}},
+		{name: "ecs without node ports", annotations: map[string]string{utils.BACKEND_TYPE_LABEL: utils.BACKEND_TYPE_ECS}},
+	}
+	for _, c := range cases {
+		svc := noNodePortService(c.annotations)
+		for i := range svc.Spec.Ports {
+			if c.nodePort != 0 {
+				svc.Spec.Ports[i].NodePort = c.nodePort + int32(i)
+			}
+		}
+		err := ValidateNodePorts(svc)
+		if c.valid && err != nil {
+			t.Errorf("%s: expect valid, got %s", c.name, err.Error())
+		}
+		if !c.valid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%s: expect an invalid annotation error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestNodePortsNotAllocated(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: prid},
+			Spec:       v1.NodeSpec{ProviderID: prid},
+		},
+	}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-service", Namespace: "default"},
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{
+					{IP: ENI_ADDR_1, NodeName: &prid},
+					{IP: ENI_ADDR_2, NodeName: &prid},
+				},
+				Ports: []v1.EndpointPort{
+					{Name: "tcp-80", Port: targetPort1.IntVal},
+					{Name: "http", Port: 8081},
+				},
+			},
+		},
+	}
+
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		noNodePortService(map[string]string{utils.BACKEND_TYPE_LABEL: utils.BACKEND_TYPE_ENI}),
+	).WithNodes(nodes).WithEndpoints(endpoints)
+	f.RunCustomized(t, "Eni backends by target ports without node ports",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			ExpectLoadBalancerEqual(t, f)
+			// no backend is registered on port 0
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			res, err := f.SLBSDK().DescribeVServerGroups(
+				context.Background(),
+				&slb.DescribeVServerGroupsArgs{LoadBalancerId: lb.LoadBalancerId},
+			)
+			if err != nil {
+				return err
+			}
+			for _, g := range res.VServerGroups.VServerGroup {
+				att, err := f.SLBSDK().DescribeVServerGroupAttribute(
+					context.Background(),
+					&slb.DescribeVServerGroupAttributeArgs{VServerGroupId: g.VServerGroupId},
+				)
+				if err != nil {
+					return err
+				}
+				for _, b := range att.BackendServers.BackendServer {
+					if b.Type != "eni" || b.Port == 0 {
+						return fmt.Errorf("expect eni backends on the target ports, got %v of %s", b, g.VServerGroupName)
+					}
+				}
+			}
+			return nil
+		},
+	)
+
+	f = NewDefaultFrameWork(nil)
+	f.WithService(noNodePortService(map[string]string{})).WithNodes(nodes).WithEndpoints(endpoints)
+	f.RunCustomized(t, "Node backends without node ports rejected",
+		func(f *FrameWork) error {
+			faults := f.SLBFaults()
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+				return fmt.Errorf("expect the sync failed by validation, got %v", err)
+			}
+			if len(recorder.Events) != 1 {
+				return fmt.Errorf("expect a validation event, got %d", len(recorder.Events))
+			}
+			event := <-recorder.Events
+			if !strings.Contains(event, utils.InvalidAnnotation) ||
+				!strings.Contains(event, "allocateLoadBalancerNodePorts") ||
+				!strings.Contains(event, utils.BACKEND_TYPE_ENI) {
+				return fmt.Errorf("expect the event telling to enable node ports or switch to eni, got %s", event)
+			}
+			if calls := faults.Calls("CreateLoadBalancer"); len(calls) != 0 {
+				return fmt.Errorf("expect no loadbalancer created, got %v", calls)
+			}
+			return nil
+		},
+	)
+}
@@ -24,8 +24,6 @@ import (
 	"unicode"
 	"unicode/utf8"
 
-	"bytes"
-	"encoding/json"
 	"github.com/denverdino/aliyungo/slb"
 	"k8s.io/api/core/v1"
 )
@@ -52,6 +50,15 @@ const (
 	// ServiceAnnotationLoadBalancerAclType acl type, black or white
 	ServiceAnnotationLoadBalancerAclType = ServiceAnnotationLoadBalancerPrefix + "acl-type"
 
+	// ServiceAnnotationLoadBalancerAccessLogProject log project the access logs are delivered to
+	ServiceAnnotationLoadBalancerAccessLogProject = ServiceAnnotationLoadBalancerPrefix + "access-log-project"
+
+	// ServiceAnnotationLoadBalancerAccessLogLogstore logstore the access logs are delivered to
+	ServiceAnnotationLoadBalancerAccessLogLogstore = ServiceAnnotationLoadBalancerPrefix + "access-log-logstore"
+
+	// ServiceAnnotationLoadBalancerAccessLogRegion region of the log project, optional
+	ServiceAnnotationLoadBalancerAccessLogRegion = ServiceAnnotationLoadBalancerPrefix + "access-log-region"
+
 	// ServiceAnnotationLoadBalancerProtocolPort protocol port
 	ServiceAnnotationLoadBalancerProtocolPort = ServiceAnnotationLoadBalancerPrefix + "protocol-port"
 
@@ -87,15 +94,31 @@ const (
 	// ServiceAnnotationLoadBalancerSlaveZoneID slave zone id
 	ServiceAnnotationLoadBalancerSlaveZoneID = ServiceAnnotationLoadBalancerPrefix + "slave-zoneid"
 
+	// ServiceAnnotationLoadBalancerForceRecreateZones recreate the loadbalancer when the zones are changed, "true" or "false"
+	ServiceAnnotationLoadBalancerForceRecreateZones = ServiceAnnotationLoadBalancerPrefix + "force-recreate-zones"
+
+	// ServiceAnnotationLoadBalancerRecreateOnAddressTypeChange recreate the loadbalancer when the address type is changed, "true" or "false"
+	ServiceAnnotationLoadBalancerRecreateOnAddressTypeChange = ServiceAnnotationLoadBalancerPrefix + "recreate-on-address-type-change"
+
 	// ServiceAnnotationLoadBalancerBandwidth bandwidth
 	ServiceAnnotationLoadBalancerBandwidth = ServiceAnnotationLoadBalancerPrefix + "bandwidth"
 
+	// ServiceAnnotationLoadBalancerBandwidthPolicy what to do with a bandwidth out of the range of the spec, reject or clamp
+	ServiceAnnotationLoadBalancerBandwidthPolicy = ServiceAnnotationLoadBalancerPrefix + "bandwidth-policy"
+
 	// ServiceAnnotationLoadBalancerCertID cert id
 	ServiceAnnotationLoadBalancerCertID = ServiceAnnotationLoadBalancerPrefix + "cert-id"
 
+	// ServiceAnnotationLoadBalancerAdditionalCertIDs additional cert ids bound to https listeners as
+	// domain extensions, e.g. "cert-a,cert-b". Set a single port by port-overrides.
+	ServiceAnnotationLoadBalancerAdditionalCertIDs = ServiceAnnotationLoadBalancerPrefix + "additional-cert-ids"
+
 	// ServiceAnnotationLoadBalancerHealthCheckFlag health check flag
 	ServiceAnnotationLoadBalancerHealthCheckFlag = ServiceAnnotationLoadBalancerPrefix + "health-check-flag"
 
+	// ServiceAnnotationLoadBalancerHealthCheckSwitch health check switch of tcp & udp listeners, on or off
+	ServiceAnnotationLoadBalancerHealthCheckSwitch = ServiceAnnotationLoadBalancerPrefix + "health-check-switch"
+
 	// ServiceAnnotationLoadBalancerHealthCheckType health check type
 	ServiceAnnotationLoadBalancerHealthCheckType = ServiceAnnotationLoadBalancerPrefix + "health-check-type"
 
@@ -126,6 +149,12 @@ const (
 	// ServiceAnnotationLoadBalancerHealthCheckHTTPCode health check http code
 	ServiceAnnotationLoadBalancerHealthCheckHTTPCode = ServiceAnnotationLoadBalancerPrefix + "health-check-httpcode"
 
+	// ServiceAnnotationLoadBalancerHealthCheckReq request string of udp health check
+	ServiceAnnotationLoadBalancerHealthCheckReq = ServiceAnnotationLoadBalancerPrefix + "health-check-udp-req"
+
+	// ServiceAnnotationLoadBalancerHealthCheckExp expected response string of udp health check
+	ServiceAnnotationLoadBalancerHealthCheckExp = ServiceAnnotationLoadBalancerPrefix + "health-check-udp-exp"
+
 	// ServiceAnnotationLoadBalancerAdditionalTags For example: "Key1=Val1,Key2=Val2,KeyNoVal1=,KeyNoVal2",same with aws
 	ServiceAnnotationLoadBalancerAdditionalTags = ServiceAnnotationLoadBalancerPrefix + "additional-resource-tags"
 
@@ -135,6 +164,9 @@ const (
 	// ServiceAnnotationLoadBalancerSpec slb spec
 	ServiceAnnotationLoadBalancerSpec = ServiceAnnotationLoadBalancerPrefix + "spec"
 
+	// ServiceAnnotationLoadBalancerInstanceChargeType slb instance charge type, PayBySpec or PayByCLCU
+	ServiceAnnotationLoadBalancerInstanceChargeType = ServiceAnnotationLoadBalancerPrefix + "instance-charge-type"
+
 	// ServiceAnnotationLoadBalancerScheduler slb scheduler
 	ServiceAnnotationLoadBalancerScheduler = ServiceAnnotationLoadBalancerPrefix + "scheduler"
 
@@ -150,9 +182,48 @@ const (
 	//ServiceAnnotationLoadBalancerCookie lb cookie
 	ServiceAnnotationLoadBalancerCookie = ServiceAnnotationLoadBalancerPrefix + "cookie"
 
+	// ServiceAnnotationLoadBalancerIdleTimeout idle timeout of http & https listeners in seconds, e.g. "30"
+	ServiceAnnotationLoadBalancerIdleTimeout = ServiceAnnotationLoadBalancerPrefix + "idle-timeout"
+
+	// ServiceAnnotationLoadBalancerRequestTimeout request timeout of http & https listeners in seconds, e.g. "60"
+	ServiceAnnotationLoadBalancerRequestTimeout = ServiceAnnotationLoadBalancerPrefix + "request-timeout"
+
+	// ServiceAnnotationLoadBalancerConnectionDrain connection drain of tcp & udp listeners, on or off
+	ServiceAnnotationLoadBalancerConnectionDrain = ServiceAnnotationLoadBalancerPrefix + "connection-drain"
+
+	// ServiceAnnotationLoadBalancerConnectionDrainTimeout connection drain timeout in seconds, 10-900
+	ServiceAnnotationLoadBalancerConnectionDrainTimeout = ServiceAnnotationLoadBalancerPrefix + "connection-drain-timeout"
+
+	// ServiceAnnotationLoadBalancerEstablishedTimeout established timeout of tcp listeners in seconds, e.g. "900"
+	ServiceAnnotationLoadBalancerEstablishedTimeout = ServiceAnnotationLoadBalancerPrefix + "established-timeout"
+
+	// ServiceAnnotationLoadBalancerProxyProtocol proxy protocol v2 of tcp & udp listeners, on or off
+	ServiceAnnotationLoadBalancerProxyProtocol = ServiceAnnotationLoadBalancerPrefix + "proxy-protocol"
+
+	// ServiceAnnotationLoadBalancerXForwardedFor X-Forwarded-For header of http & https listeners, on or off
+	ServiceAnnotationLoadBalancerXForwardedFor = ServiceAnnotationLoadBalancerPrefix + "xforwardedfor"
+
+	// ServiceAnnotationLoadBalancerXForwardedForProto X-Forwarded-Proto header of http & https listeners, on or off
+	ServiceAnnotationLoadBalancerXForwardedForProto = ServiceAnnotationLoadBalancerPrefix + "xforwardedfor-proto"
+
+	// ServiceAnnotationLoadBalancerXForwardedForSLBID SLB-ID header of http & https listeners, on or off
+	ServiceAnnotationLoadBalancerXForwardedForSLBID = ServiceAnnotationLoadBalancerPrefix + "xforwardedfor-slbid"
+
+	// ServiceAnnotationLoadBalancerXForwardedForSLBIP SLB-IP header of http & https listeners, on or off
+	ServiceAnnotationLoadBalancerXForwardedForSLBIP = ServiceAnnotationLoadBalancerPrefix + "xforwardedfor-slbip"
+
+	// ServiceAnnotationLoadBalancerGzip gzip compression of http & https listeners, on or off
+	ServiceAnnotationLoadBalancerGzip = ServiceAnnotationLoadBalancerPrefix + "gzip"
+
+	// ServiceAnnotationLoadBalancerPortOverrides per port listener annotations in json,
+	// e.g. {"9000":{"protocol":"tcp","health-check-uri":"/metrics"}}
+	ServiceAnnotationLoadBalancerPortOverrides = ServiceAnnotationLoadBalancerPrefix + "port-overrides"
+
 	// ServiceAnnotationLoadBalancerPersistenceTimeout persistence timeout
 	ServiceAnnotationLoadBalancerPersistenceTimeout = ServiceAnnotationLoadBalancerPrefix + "persistence-timeout"
-	//MagicHealthCheckConnectPort                     = -520
+
+	// MagicHealthCheckConnectPort health check the backend server port of the listener.
+	MagicHealthCheckConnectPort = -520
 
 	//ServiceAnnotationLoadBalancerIPVersion ip version
 	ServiceAnnotationLoadBalancerIPVersion = ServiceAnnotationLoadBalancerPrefix + "ip-version"
@@ -172,6 +243,9 @@ const (
 	// ServiceAnnotationLoadBalancerPrivateZoneRecordTTL private zone record ttl
 	ServiceAnnotationLoadBalancerPrivateZoneRecordTTL = ServiceAnnotationPrivateZonePrefix + "record-ttl"
 
+	// ServiceAnnotationLoadBalancerPrivateZoneIngressHostname publish the hostname of the record instead of the ip
+	ServiceAnnotationLoadBalancerPrivateZoneIngressHostname = ServiceAnnotationPrivateZonePrefix + "ingress-hostname"
+
 	// ServiceAnnotationLoadBalancerBackendType backend type
 	ServiceAnnotationLoadBalancerBackendType = utils.BACKEND_TYPE_LABEL
 
@@ -181,11 +255,69 @@ const (
 	// ServiceAnnotationLoadBalancerDeleteProtection delete protection
 	ServiceAnnotationLoadBalancerDeleteProtection = ServiceAnnotationLoadBalancerPrefix + "delete-protection"
 
+	// ServiceAnnotationLoadBalancerRetainOnDelete keep the loadbalancer when the service is deleted, "on" or "off"
+	ServiceAnnotationLoadBalancerRetainOnDelete = ServiceAnnotationLoadBalancerPrefix + "retain-on-delete"
+
 	// ServiceAnnotationLoadBalancerModificationProtection modification type
 	ServiceAnnotationLoadBalancerModificationProtection = ServiceAnnotationLoadBalancerPrefix + "modification-protection"
 
+	// ServiceAnnotationLoadBalancerModificationProtectionReason reason of the console protection
+	ServiceAnnotationLoadBalancerModificationProtectionReason = ServiceAnnotationLoadBalancerPrefix + "modification-protection-reason"
+
 	// ServiceAnnotationLoadBalancerBackendType external ip type
 	ServiceAnnotationLoadBalancerExternalIPType = ServiceAnnotationLoadBalancerPrefix + "external-ip-type"
+
+	// ServiceAnnotationLoadBalancerEipId allocation id of the eip bound to an intranet loadbalancer
+	ServiceAnnotationLoadBalancerEipId = ServiceAnnotationLoadBalancerPrefix + "eip-id"
+
+	// ServiceAnnotationLoadBalancerHostname hostname published in the service status instead of the ip
+	ServiceAnnotationLoadBalancerHostname = ServiceAnnotationLoadBalancerPrefix + "hostname"
+
+	// ServiceAnnotationLoadBalancerAddress address the loadbalancer is created with, same as spec.loadBalancerIP
+	ServiceAnnotationLoadBalancerAddress = ServiceAnnotationLoadBalancerPrefix + "address"
+
+	// ServiceAnnotationLoadBalancerWeight weight of the backends in the vserver groups
+	ServiceAnnotationLoadBalancerWeight = ServiceAnnotationLoadBalancerPrefix + "weight"
+
+	// ServiceAnnotationLoadBalancerWeightMode how the weight of the backends is computed, pod-count
+	ServiceAnnotationLoadBalancerWeightMode = ServiceAnnotationLoadBalancerPrefix + "weight-mode"
+
+	// ServiceAnnotationLoadBalancerGracefulDrainSeconds time the removed backends are kept with weight 0
+	ServiceAnnotationLoadBalancerGracefulDrainSeconds = utils.ServiceAnnotationLoadBalancerGracefulDrainSeconds
+
+	// ServiceAnnotationLoadBalancerVGroupPort existing vserver groups of the service ports, eg. "rsp-xxx:443,rsp-yyy:80"
+	ServiceAnnotationLoadBalancerVGroupPort = ServiceAnnotationLoadBalancerPrefix + "vgroup-port"
+
+	// ServiceAnnotationLoadBalancerMaxBackends max nodes added as backends of a vserver group
+	ServiceAnnotationLoadBalancerMaxBackends = ServiceAnnotationLoadBalancerPrefix + "max-backends"
+
+	// ServiceAnnotationLoadBalancerZoneBalancedBackends select the nodes trimmed to max-backends evenly across the zones, on or off
+	ServiceAnnotationLoadBalancerZoneBalancedBackends = ServiceAnnotationLoadBalancerPrefix + "zone-balanced-backends"
+
+	// ServiceAnnotationLoadBalancerKeepLastBackends keep the backends of a vserver group when none is left, on or off
+	ServiceAnnotationLoadBalancerKeepLastBackends = ServiceAnnotationLoadBalancerPrefix + "keep-last-backends"
+
+	// ServiceAnnotationLoadBalancerType type of the loadbalancer, clb or nlb
+	ServiceAnnotationLoadBalancerType = ServiceAnnotationLoadBalancerPrefix + "type"
+
+	// ServiceAnnotationLoadBalancerZoneMaps zones and vswitches of a nlb, eg. "cn-hangzhou-h:vsw-xxx,cn-hangzhou-i:vsw-yyy"
+	ServiceAnnotationLoadBalancerZoneMaps = ServiceAnnotationLoadBalancerPrefix + "zone-maps"
+
+	// ServiceAnnotationLoadBalancerManagedPorts ports of the existing listeners taken over by the service, eg. "80,443"
+	ServiceAnnotationLoadBalancerManagedPorts = ServiceAnnotationLoadBalancerPrefix + "managed-ports"
+
+	// ServiceAnnotationLoadBalancerEmptyBackendsGuard keep the backends when none is computed for a while, on or off
+	ServiceAnnotationLoadBalancerEmptyBackendsGuard = ServiceAnnotationLoadBalancerPrefix + "empty-backends-guard"
+
+	// ServiceAnnotationLoadBalancerForceDelete delete the loadbalancer not owned by the service, "on" or "off"
+	ServiceAnnotationLoadBalancerForceDelete = ServiceAnnotationLoadBalancerPrefix + "force-delete"
+
+	// ServiceAnnotationLoadBalancerBandwidthPackageId common bandwidth package joined by the eips of the loadbalancer
+	ServiceAnnotationLoadBalancerBandwidthPackageId = ServiceAnnotationLoadBalancerPrefix + "bandwidth-package-id"
+
+	// ServiceAnnotationLoadBalancerListenerBandwidth bandwidth cap of the listeners in Mbps, -1 for no cap.
+	// Suffixed by a port it is the cap of the listener of the port, eg. listener-bandwidth-443
+	ServiceAnnotationLoadBalancerListenerBandwidth = ServiceAnnotationLoadBalancerPrefix + "listener-bandwidth"
 )
 
 type ExternalIPType string
@@ -264,6 +396,22 @@ func ExtractAnnotationRequest(service *v1.Service) (*AnnotationRequest, *Annotat
 		request.AclType = defaulted.AclType
 	}
 
+	project, ok := annotation[ServiceAnnotationLoadBalancerAccessLogProject]
+	if ok {
+		defaulted.AccessLogProject = project
+		request.AccessLogProject = defaulted.AccessLogProject
+	}
+	logstore, ok := annotation[ServiceAnnotationLoadBalancerAccessLogLogstore]
+	if ok {
+		defaulted.AccessLogLogstore = logstore
+		request.AccessLogLogstore = defaulted.AccessLogLogstore
+	}
+	logregion, ok := annotation[ServiceAnnotationLoadBalancerAccessLogRegion]
+	if ok {
+		defaulted.AccessLogRegion = logregion
+		request.AccessLogRegion = defaulted.AccessLogRegion
+	}
+
 	forward, ok := annotation[ServiceAnnotationLoadBalancerForwardPort]
 	if ok {
 		defaulted.ForwardPort = forward
@@ -320,6 +468,12 @@ func ExtractAnnotationRequest(service *v1.Service) (*AnnotationRequest, *Annotat
 		request.CertID = defaulted.CertID
 	}
 
+	certids, ok := annotation[ServiceAnnotationLoadBalancerAdditionalCertIDs]
+	if ok {
+		defaulted.AdditionalCertIDs = certids
+		request.AdditionalCertIDs = defaulted.AdditionalCertIDs
+	}
+
 	hcFlag, ok := annotation[ServiceAnnotationLoadBalancerHealthCheckFlag]
 	if ok {
 		defaulted.HealthCheck = slb.FlagType(hcFlag)
@@ -328,6 +482,12 @@ func ExtractAnnotationRequest(service *v1.Service) (*AnnotationRequest, *Annotat
 		defaulted.HealthCheck = slb.OffFlag
 	}
 
+	hcSwitch, ok := annotation[ServiceAnnotationLoadBalancerHealthCheckSwitch]
+	if ok {
+		defaulted.HealthCheckSwitch = hcSwitch
+		request.HealthCheckSwitch = defaulted.HealthCheckSwitch
+	}
+
 	hcType, ok := annotation[ServiceAnnotationLoadBalancerHealthCheckType]
 	if ok {
 		defaulted.HealthCheckType = slb.HealthCheckType(hcType)
@@ -359,6 +519,10 @@ func ExtractAnnotationRequest(service *v1.Service) (*AnnotationRequest, *Annotat
 				healthCheckConnectPort, err.Error())
 			//defaulted.HealthCheckConnectPort = MagicHealthCheckConnectPort
 		} else {
+			if port == 0 {
+				// 0 means the backend server port.
+				port = MagicHealthCheckConnectPort
+			}
 			defaulted.HealthCheckConnectPort = port
 			request.HealthCheckConnectPort = defaulted.HealthCheckConnectPort
 		}
@@ -446,6 +610,18 @@ func ExtractAnnotationRequest(service *v1.Service) (*AnnotationRequest, *Annotat
 		request.HealthCheckHttpCode = defaulted.HealthCheckHttpCode
 	}
 
+	hcReq, ok := annotation[ServiceAnnotationLoadBalancerHealthCheckReq]
+	if ok {
+		defaulted.HealthCheckReq = hcReq
+		request.HealthCheckReq = defaulted.HealthCheckReq
+	}
+
+	hcExp, ok := annotation[ServiceAnnotationLoadBalancerHealthCheckExp]
+	if ok {
+		defaulted.HealthCheckExp = hcExp
+		request.HealthCheckExp = defaulted.HealthCheckExp
+	}
+
 	loadbalancerSpec, ok := annotation[ServiceAnnotationLoadBalancerSpec]
 	if ok {
 		defaulted.LoadBalancerSpec = slb.LoadBalancerSpecType(loadbalancerSpec)
@@ -454,12 +630,20 @@ func ExtractAnnotationRequest(service *v1.Service) (*AnnotationRequest, *Annotat
 		defaulted.LoadBalancerSpec = "slb.s1.small"
 	}
 
+	instanceChargeType, ok := annotation[ServiceAnnotationLoadBalancerInstanceChargeType]
+	if ok {
+		defaulted.InstanceChargeType = InstanceChargeType(instanceChargeType)
+		request.InstanceChargeType = defaulted.InstanceChargeType
+	} else {
+		defaulted.InstanceChargeType = PayBySpec
+	}
+
 	scheduler, ok := annotation[ServiceAnnotationLoadBalancerScheduler]
 	if ok {
 		defaulted.Scheduler = scheduler
 		request.Scheduler = defaulted.Scheduler
 	} else {
-		defaulted.Scheduler = "rr"
+		defaulted.Scheduler = DEFAULT_SCHEDULER
 	}
 
 	// stick session
@@ -511,10 +695,104 @@ func ExtractAnnotationRequest(service *v1.Service) (*AnnotationRequest, *Annotat
 		defaulted.Cookie = request.Cookie
 	}
 
+	idleTimeout, ok := annotation[ServiceAnnotationLoadBalancerIdleTimeout]
+	if ok {
+		timeout, err := strconv.Atoi(idleTimeout)
+		if err != nil {
+			klog.Warningf("annotation idle timeout must be integer, but got [%s]. message=[%s]\n",
+				idleTimeout, err.Error())
+		} else {
+			defaulted.IdleTimeout = timeout
+			request.IdleTimeout = defaulted.IdleTimeout
+		}
+	}
+
+	requestTimeout, ok := annotation[ServiceAnnotationLoadBalancerRequestTimeout]
+	if ok {
+		timeout, err := strconv.Atoi(requestTimeout)
+		if err != nil {
+			klog.Warningf("annotation request timeout must be integer, but got [%s]. message=[%s]\n",
+				requestTimeout, err.Error())
+		} else {
+			defaulted.RequestTimeout = timeout
+			request.RequestTimeout = defaulted.RequestTimeout
+		}
+	}
+
+	drain, ok := annotation[ServiceAnnotationLoadBalancerConnectionDrain]
+	if ok {
+		request.ConnectionDrain = drain
+		defaulted.ConnectionDrain = request.ConnectionDrain
+	}
+
+	drainTimeout, ok := annotation[ServiceAnnotationLoadBalancerConnectionDrainTimeout]
+	if ok {
+		timeout, err := strconv.Atoi(drainTimeout)
+		if err != nil {
+			klog.Warningf("annotation connection drain timeout must be integer, but got [%s]. message=[%s]\n",
+				drainTimeout, err.Error())
+		} else {
+			defaulted.ConnectionDrainTimeout = timeout
+			request.ConnectionDrainTimeout = defaulted.ConnectionDrainTimeout
+		}
+	}
+
+	establishedTimeout, ok := annotation[ServiceAnnotationLoadBalancerEstablishedTimeout]
+	if ok {
+		timeout, err := strconv.Atoi(establishedTimeout)
+		if err != nil {
+			klog.Warningf("annotation established timeout must be integer, but got [%s]. message=[%s]\n",
+				establishedTimeout, err.Error())
+		} else {
+			defaulted.EstablishedTimeout = timeout
+			request.EstablishedTimeout = defaulted.EstablishedTimeout
+		}
+	}
+
+	proxyProtocol, ok := annotation[ServiceAnnotationLoadBalancerProxyProtocol]
+	if ok {
+		defaulted.ProxyProtocol = proxyProtocol
+		request.ProxyProtocol = defaulted.ProxyProtocol
+	}
+
+	xForwardedFor, ok := annotation[ServiceAnnotationLoadBalancerXForwardedFor]
+	if ok {
+		defaulted.XForwardedFor = slb.FlagType(xForwardedFor)
+		request.XForwardedFor = defaulted.XForwardedFor
+	}
+
+	xForwardedForProto, ok := annotation[ServiceAnnotationLoadBalancerXForwardedForProto]
+	if ok {
+		defaulted.XForwardedForProto = slb.FlagType(xForwardedForProto)
+		request.XForwardedForProto = defaulted.XForwardedForProto
+	}
+
+	xForwardedForSLBID, ok := annotation[ServiceAnnotationLoadBalancerXForwardedForSLBID]
+	if ok {
+		defaulted.XForwardedForSLBID = slb.FlagType(xForwardedForSLBID)
+		request.XForwardedForSLBID = defaulted.XForwardedForSLBID
+	}
+
+	xForwardedForSLBIP, ok := annotation[ServiceAnnotationLoadBalancerXForwardedForSLBIP]
+	if ok {
+		defaulted.XForwardedForSLBIP = slb.FlagType(xForwardedForSLBIP)
+		request.XForwardedForSLBIP = defaulted.XForwardedForSLBIP
+	}
+
+	gzip, ok := annotation[ServiceAnnotationLoadBalancerGzip]
+	if ok {
+		defaulted.Gzip = slb.FlagType(gzip)
+		request.Gzip = defaulted.Gzip
+	}
+
 	ipVersion, ok := annotation[ServiceAnnotationLoadBalancerIPVersion]
 	if ok {
 		request.AddressIPVersion = slb.AddressIPVersionType(ipVersion)
 		defaulted.AddressIPVersion = request.AddressIPVersion
+	} else if isIPv6Family(service) {
+		// an IPv6 service asks for an IPv6 loadbalancer without the annotation
+		request.AddressIPVersion = slb.IPv6
+		defaulted.AddressIPVersion = request.AddressIPVersion
 	}
 
 	privateZoneName, ok := annotation[ServiceAnnotationLoadBalancerPrivateZoneName]
@@ -588,6 +866,13 @@ func ExtractAnnotationRequest(service *v1.Service) (*AnnotationRequest, *Annotat
 	} else {
 		defaulted.ModificationProtectionStatus = slb.ConsoleProtection
 	}
+	modificationProtectionReason, ok := annotation[ServiceAnnotationLoadBalancerModificationProtectionReason]
+	if ok {
+		request.ModificationProtectionReason = modificationProtectionReason
+		defaulted.ModificationProtectionReason = request.ModificationProtectionReason
+	} else {
+		defaulted.ModificationProtectionReason = defaultModificationProtectionReason(service)
+	}
 
 	externalIpType, ok := annotation[ServiceAnnotationLoadBalancerExternalIPType]
 	if ok {
@@ -595,6 +880,112 @@ func ExtractAnnotationRequest(service *v1.Service) (*AnnotationRequest, *Annotat
 		defaulted.ExternalIPType = request.ExternalIPType
 	}
 
+	eipId, ok := annotation[ServiceAnnotationLoadBalancerEipId]
+	if ok {
+		request.EipId = eipId
+		defaulted.EipId = request.EipId
+	}
+
+	hostname, ok := annotation[ServiceAnnotationLoadBalancerHostname]
+	if ok {
+		request.Hostname = hostname
+		defaulted.Hostname = request.Hostname
+	}
+
+	address, ok := annotation[ServiceAnnotationLoadBalancerAddress]
+	if ok {
+		request.Address = address
+		defaulted.Address = request.Address
+	}
+
+	weight, ok := annotation[ServiceAnnotationLoadBalancerWeight]
+	if ok {
+		if i, err := strconv.Atoi(weight); err == nil {
+			request.Weight = i
+			defaulted.Weight = i
+		} else {
+			klog.Warningf("annotation %s must be integer, but got [%s], use default weight. message=[%s]\n",
+				ServiceAnnotationLoadBalancerWeight, weight, err.Error())
+		}
+	}
+
+	weightMode, ok := annotation[ServiceAnnotationLoadBalancerWeightMode]
+	if ok {
+		request.WeightMode = weightMode
+		defaulted.WeightMode = request.WeightMode
+	}
+
+	drainSeconds, ok := annotation[ServiceAnnotationLoadBalancerGracefulDrainSeconds]
+	if ok {
+		if i, err := strconv.Atoi(drainSeconds); err == nil {
+			request.GracefulDrainSeconds = i
+			defaulted.GracefulDrainSeconds = i
+		} else {
+			klog.Warningf("annotation %s must be integer, but got [%s], backends are removed without drain. message=[%s]\n",
+				ServiceAnnotationLoadBalancerGracefulDrainSeconds, drainSeconds, err.Error())
+		}
+	}
+
+	vgroupPort, ok := annotation[ServiceAnnotationLoadBalancerVGroupPort]
+	if ok {
+		request.VGroupPort = vgroupPort
+		defaulted.VGroupPort = request.VGroupPort
+	}
+
+	defaulted.MaxBackends = DEFAULT_MAX_BACKENDS
+	maxBackends, ok := annotation[ServiceAnnotationLoadBalancerMaxBackends]
+	if ok {
+		if i, err := strconv.Atoi(maxBackends); err == nil && i > 0 {
+			request.MaxBackends = i
+			defaulted.MaxBackends = i
+		} else {
+			klog.Warningf("annotation %s must be positive integer, but got [%s], default to %d.\n",
+				ServiceAnnotationLoadBalancerMaxBackends, maxBackends, DEFAULT_MAX_BACKENDS)
+		}
+	}
+
+	keepLast, ok := annotation[ServiceAnnotationLoadBalancerKeepLastBackends]
+	if ok {
+		request.KeepLastBackends = keepLast
+		defaulted.KeepLastBackends = request.KeepLastBackends
+	}
+
+	lbType, ok := annotation[ServiceAnnotationLoadBalancerType]
+	if ok {
+		request.LoadBalancerType = lbType
+		defaulted.LoadBalancerType = request.LoadBalancerType
+	}
+
+	zoneMaps, ok := annotation[ServiceAnnotationLoadBalancerZoneMaps]
+	if ok {
+		request.ZoneMaps = zoneMaps
+		defaulted.ZoneMaps = request.ZoneMaps
+	}
+
+	managedPorts, ok := annotation[ServiceAnnotationLoadBalancerManagedPorts]
+	if ok {
+		request.ManagedPorts = managedPorts
+		defaulted.ManagedPorts = request.ManagedPorts
+	}
+
+	guard, ok := annotation[ServiceAnnotationLoadBalancerEmptyBackendsGuard]
+	if ok {
+		request.EmptyBackendsGuard = guard
+		defaulted.EmptyBackendsGuard = request.EmptyBackendsGuard
+	}
+
+	forceDelete, ok := annotation[ServiceAnnotationLoadBalancerForceDelete]
+	if ok {
+		request.ForceDelete = forceDelete
+		defaulted.ForceDelete = request.ForceDelete
+	}
+
+	bandwidthPackageId, ok := annotation[ServiceAnnotationLoadBalancerBandwidthPackageId]
+	if ok {
+		request.BandwidthPackageId = bandwidthPackageId
+		defaulted.BandwidthPackageId = request.BandwidthPackageId
+	}
+
 	return defaulted, request
 }
 
@@ -676,19 +1067,7 @@ func replaceCamel(str string) string {
 	return ServiceAnnotationLoadBalancerPrefix + strings.Join(res, "-")
 }
 
-// PrettyJson  pretty json output
+// PrettyJson  pretty json output, sanitized as utils.PrettyJson
 func PrettyJson(obj interface{}) string {
-	pretty := bytes.Buffer{}
-	data, err := json.Marshal(obj)
-	if err != nil {
-		klog.Errorf("PrettyJson, mashal error: %s\n", err.Error())
-		return ""
-	}
-	err = json.Indent(&pretty, data, "", "    ")
-
-	if err != nil {
-		klog.Errorf("PrettyJson, indent error: %s\n", err.Error())
-		return ""
-	}
-	return pretty.String()
+	return utils.PrettyJson(obj)
 }
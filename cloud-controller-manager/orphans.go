@@ -0,0 +1,138 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"k8s.io/klog"
+	"strings"
+)
+
+// The listeners and the vserver groups of the ports removed from the service
+// are not desired any more, the ensure of the desired ones leaves them on the
+// loadbalancer. They are cleaned up at the end of every sync instead, whether
+// the service is changed or not: the listeners marked by the service, see
+// isManagedByMyService, and then the vserver groups named after it, see
+// isVGroupOf. Those of others on a reused loadbalancer carry neither and are
+// never touched. A failed deletion does not fail the sync, it is counted by
+// metric.SLBOrphanCleanup, reported by an event and retried by the next sync.
+
+const (
+	ORPHAN_LISTENER = "listener"
+	ORPHAN_VGROUP   = "vgroup"
+)
+
+// orphanListeners the listeners of the service whose port is removed
+func orphanListeners(service *v1.Service, remote Listeners) Listeners {
+	var orphans Listeners
+	for _, rem := range remote {
+		if isManagedByMyService(service, rem) && !hasPort(service, rem.Port) {
+			orphans = append(orphans, rem)
+		}
+	}
+	return orphans
+}
+
+// orphanVGroups the vserver groups of the service not desired by its ports,
+// a vserver group of the former name duplicating the ensured one included
+func orphanVGroups(service *v1.Service, remote vgroups, local *vgroups) vgroups {
+	var orphans vgroups
+	for _, rem := range remote {
+		if !rem.isVGroupOf(service) {
+			// skip those which does not belong to this service
+			continue
+		}
+		found := false
+		for _, svc := range *local {
+			if rem.NamedKey.Port == svc.NamedKey.Port &&
+				(svc.VGroupId == "" || svc.VGroupId == rem.VGroupId) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			orphans = append(orphans, rem)
+		}
+	}
+	return orphans
+}
+
+// cleanupOrphans delete the listeners of the removed ports and then the vserver
+// groups no longer referenced, the failures are reported but never returned.
+func cleanupOrphans(
+	ctx context.Context,
+	slbins *LoadBalancerClient,
+	service *v1.Service,
+	lb *slb.LoadBalancerType,
+	local *vgroups,
+) {
+	log := utils.FromContext(ctx)
+	var failed []string
+	for _, n := range orphanListeners(service, BuildListenersFromAPI(service, lb, slbins.c, local)) {
+		if err := n.Remove(ctx); err != nil {
+			metric.SLBOrphanCleanup.WithLabelValues(ORPHAN_LISTENER, "failed").Inc()
+			failed = append(failed, fmt.Sprintf("listener %d: %s", n.Port, err.Error()))
+			continue
+		}
+		metric.SLBOrphanCleanup.WithLabelValues(ORPHAN_LISTENER, "deleted").Inc()
+		log.Info("Deleted the listener of the removed port", utils.LogKeySLBID, lb.LoadBalancerId,
+			utils.LogKeyListenerPort, n.Port)
+	}
+	// the vserver groups are listed after the listeners using them are gone
+	remote, err := BuildVirtualGroupFromRemoteAPI(ctx, lb, slbins)
+	if err != nil {
+		metric.SLBOrphanCleanup.WithLabelValues(ORPHAN_VGROUP, "failed").Inc()
+		failed = append(failed, fmt.Sprintf("list vserver groups: %s", err.Error()))
+	}
+	for _, v := range orphanVGroups(service, remote, local) {
+		if err := v.Remove(ctx); err != nil {
+			metric.SLBOrphanCleanup.WithLabelValues(ORPHAN_VGROUP, "failed").Inc()
+			failed = append(failed, fmt.Sprintf("vserver group %s: %s", v.VGroupId, err.Error()))
+			continue
+		}
+		metric.SLBOrphanCleanup.WithLabelValues(ORPHAN_VGROUP, "deleted").Inc()
+		log.Info("Deleted the vserver group no longer used", utils.LogKeySLBID, lb.LoadBalancerId,
+			"vgroup", v.VGroupId, "name", v.VGroupName)
+	}
+	if len(failed) > 0 {
+		recordOrphanCleanupFailed(ctx, service, lb, failed)
+	}
+}
+
+// recordOrphanCleanupFailed tell the resources of the removed ports left on the
+// loadbalancer until the next sync
+func recordOrphanCleanupFailed(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType, failed []string) {
+	utils.FromContext(ctx).Warning("Failed to clean up the resources of the removed ports, retry on the next sync",
+		utils.LogKeySLBID, lb.LoadBalancerId, "failed", failed)
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"CleanupOrphansFailed",
+		"%d resources of the removed ports are left on loadbalancer %s, retry on the next sync: %s",
+		len(failed), lb.LoadBalancerId, strings.Join(failed, "; "),
+	)
+}
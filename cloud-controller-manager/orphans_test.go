@@ -0,0 +1,188 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// remoteResources the listener ports and the vserver group names of the
+// loadbalancer of the service
+func remoteResources(f *FrameWork) ([]int, []string, error) {
+	_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+	if err != nil || lb == nil {
+		return nil, nil, fmt.Errorf("find loadbalancer: %v", err)
+	}
+	var ports []int
+	for _, v := range lb.ListenerPortsAndProtocol.ListenerPortAndProtocol {
+		ports = append(ports, v.ListenerPort)
+	}
+	sort.Ints(ports)
+	res, err := f.SLBSDK().DescribeVServerGroups(
+		context.Background(),
+		&slb.DescribeVServerGroupsArgs{LoadBalancerId: lb.LoadBalancerId},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	var names []string
+	for _, g := range res.VServerGroups.VServerGroup {
+		names = append(names, g.VServerGroupName)
+	}
+	sort.Strings(names)
+	return ports, names, nil
+}
+
+// expectResources the listeners of the ports, and a vserver group of each
+// node port along with the unmanaged ones
+func expectResources(f *FrameWork, ports []int, groups ...string) error {
+	gotPorts, gotGroups, err := remoteResources(f)
+	if err != nil {
+		return err
+	}
+	if fmt.Sprint(gotPorts) != fmt.Sprint(ports) {
+		return fmt.Errorf("expect listeners %v, got %v", ports, gotPorts)
+	}
+	for _, name := range groups {
+		found := false
+		for _, got := range gotGroups {
+			found = found || strings.Contains(got, name)
+		}
+		if !found {
+			return fmt.Errorf("expect vserver group %s, got %v", name, gotGroups)
+		}
+	}
+	if len(gotGroups) != len(groups) {
+		return fmt.Errorf("expect vserver groups %v, got %v", groups, gotGroups)
+	}
+	return nil
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(stateService(map[string]string{})).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Listeners and vserver groups of the ports",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			// the listener and the vserver group of others on the loadbalancer
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			if err := f.SLBSDK().CreateLoadBalancerTCPListener(
+				context.Background(),
+				&slb.CreateLoadBalancerTCPListenerArgs{
+					LoadBalancerId:    lb.LoadBalancerId,
+					ListenerPort:      9000,
+					BackendServerPort: 9000,
+					Description:       "user-defined",
+				},
+			); err != nil {
+				return err
+			}
+			if _, err := f.SLBSDK().CreateVServerGroup(
+				context.Background(),
+				&slb.CreateVServerGroupArgs{LoadBalancerId: lb.LoadBalancerId, VServerGroupName: "user-vgroup"},
+			); err != nil {
+				return err
+			}
+			return expectResources(f, []int{80, 443, 9000}, "/8080/", "/30443/", "user-vgroup")
+		},
+	)
+
+	f.SVC.Spec.Ports = f.SVC.Spec.Ports[:1]
+	f.RunCustomized(t, "Port removed",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			ExpectLoadBalancerEqual(t, f)
+			// the listener and the vserver group of others are kept
+			return expectResources(f, []int{80, 9000}, "/8080/", "user-vgroup")
+		},
+	)
+
+	f.SVC.Spec.Ports = stateService(map[string]string{}).Spec.Ports
+	f.RunCustomized(t, "Port added back",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return expectResources(f, []int{80, 443, 9000}, "/8080/", "/30443/", "user-vgroup")
+		},
+	)
+
+	f.SVC.Spec.Ports = f.SVC.Spec.Ports[:1]
+	f.RunCustomized(t, "Failed cleanup retried by the next sync",
+		func(f *FrameWork) error {
+			failed := testutil.ToFloat64(metric.SLBOrphanCleanup.WithLabelValues(ORPHAN_LISTENER, "failed"))
+			faults := f.SLBFaults()
+			faults.FailFirst("DeleteLoadBalancerListener", 1, ThrottlingFault)
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return fmt.Errorf("expect the sync not failed by the cleanup, got %s", err.Error())
+			}
+			if got := testutil.ToFloat64(metric.SLBOrphanCleanup.WithLabelValues(ORPHAN_LISTENER, "failed")); got != failed+1 {
+				return fmt.Errorf("expect the failure counted, got %v", got-failed)
+			}
+			events := ""
+			for len(recorder.Events) > 0 {
+				events += <-recorder.Events
+			}
+			if !strings.Contains(events, "CleanupOrphansFailed") || !strings.Contains(events, "listener 443") {
+				return fmt.Errorf("expect the failed cleanup told, got %s", events)
+			}
+			ports, _, err := remoteResources(f)
+			if err != nil {
+				return err
+			}
+			if fmt.Sprint(ports) != fmt.Sprint([]int{80, 443, 9000}) {
+				return fmt.Errorf("expect the listener 443 left, got %v", ports)
+			}
+
+			// the service is unchanged since
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			return expectResources(f, []int{80, 9000}, "/8080/", "user-vgroup")
+		},
+	)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+)
+
+// A loadbalancer is deleted with its service only if it is owned by the
+// service, told by the ownership tags stamped on creation, see ownershipTags.
+// The loadbalancers created by older versions carry no ownership tag, they are
+// owned if named by the service uid scheme. Otherwise, eg. the loadbalancer-id
+// annotation is removed from a service reusing a shared loadbalancer, only the
+// listeners marked by the service and its vserver groups are removed. The
+// force-delete annotation deletes the loadbalancer anyway.
+
+// isLoadBalancerOwned whether the loadbalancer is created for the service,
+// the reason is returned if not.
+func isLoadBalancerOwned(tags []slb.TagItemType, lb *slb.LoadBalancerType, service *v1.Service) (bool, string) {
+	owner := map[string]string{}
+	for _, tag := range tags {
+		owner[tag.TagKey] = tag.TagValue
+	}
+	name := GetLoadBalancerName(service)
+	cluster, tagged := owner[ACKKEY]
+	if tagged && cluster != CLUSTER_ID {
+		return false, fmt.Sprintf("it is tagged with another cluster %s", cluster)
+	}
+	if uid, ok := owner[SERVICEUIDKEY]; ok {
+		if uid != string(service.UID) {
+			return false, fmt.Sprintf("it is tagged with another service uid %s", uid)
+		}
+		return true, ""
+	}
+	if n, ok := owner[TAGKEY]; ok {
+		if n != name {
+			return false, fmt.Sprintf("it is tagged with another service name %s", n)
+		}
+		return true, ""
+	}
+	if tagged {
+		return false, "it is tagged with the cluster but not the service"
+	}
+	// created by an older version without ownership tags
+	if lb.LoadBalancerName == name {
+		return true, ""
+	}
+	return false, "it carries no ownership tag and is not named by the service"
+}
+
+// hasOwnershipTag whether the loadbalancer is tagged for a service
+func hasOwnershipTag(tags []slb.TagItemType) bool {
+	for _, tag := range tags {
+		if tag.TagKey == SERVICEUIDKEY || tag.TagKey == TAGKEY {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateForceDelete the force-delete annotation must be on or off
+func ValidateForceDelete(service *v1.Service) error {
+	force := serviceAnnotation(service, ServiceAnnotationLoadBalancerForceDelete)
+	if force == "" || force == "on" || force == "off" {
+		return nil
+	}
+	return annotationError{
+		annotation: ServiceAnnotationLoadBalancerForceDelete,
+		token:      force,
+		reason:     "must be on or off",
+	}
+}
+
+func isForceDeleteLoadBalancer(service *v1.Service) bool {
+	return serviceAnnotation(service, ServiceAnnotationLoadBalancerForceDelete) == "on"
+}
+
+// loadBalancerOwnership whether the loadbalancer can be deleted with the service
+func (s *LoadBalancerClient) loadBalancerOwnership(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType) (bool, string, error) {
+	tags, _, err := s.c.DescribeTags(
+		ctx,
+		&slb.DescribeTagsArgs{
+			RegionId:       lb.RegionId,
+			LoadBalancerID: lb.LoadBalancerId,
+		},
+	)
+	if err != nil {
+		return false, "", fmt.Errorf("describe tags of loadbalancer %s: %s", lb.LoadBalancerId, err.Error())
+	}
+	owned, reason := isLoadBalancerOwned(tags, lb, service)
+	return owned, reason, nil
+}
+
+func recordSkipDeleteUnmanaged(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType, reason string) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "skip deleting loadbalancer %s not owned by the service, %s", lb.LoadBalancerId, reason)
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"SkipDeleteUnmanagedLoadBalancer",
+		"Loadbalancer %s is not deleted, %s. Only the listeners and vserver groups of the service are removed, "+
+			"set annotation %s to on to delete it anyway",
+		lb.LoadBalancerId, reason, ServiceAnnotationLoadBalancerForceDelete,
+	)
+}
+
+func recordSkipRecreate(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType, reason string) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "skip recreating loadbalancer %s, %s", lb.LoadBalancerId, reason)
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"SkipRecreateLoadBalancer",
+		"Loadbalancer %s is not recreated, %s. Its zones and address type are kept",
+		lb.LoadBalancerId, reason,
+	)
+}
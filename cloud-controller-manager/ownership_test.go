@@ -0,0 +1,176 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+const AMBIGUOUS_ID = "lb-ownership-ambiguous"
+
+func TestLoadBalancerOwned(t *testing.T) {
+	svc := testServiceNamed("my-service", serviceUIDNoneExist, nil)
+	name := GetLoadBalancerName(svc)
+	cases := []struct {
+		desc   string
+		tags   map[string]string
+		lbname string
+		owned  bool
+	}{
+		{desc: "owned", tags: ownershipTags(svc), owned: true},
+		{desc: "owned before the uid tag is stamped", tags: map[string]string{TAGKEY: name, ACKKEY: CLUSTER_ID}, owned: true},
+		{desc: "legacy named by the service", lbname: name, owned: true},
+		{desc: "reused", tags: map[string]string{REUSEKEY: "true"}, lbname: "user-lb"},
+		{desc: "untagged user loadbalancer", lbname: "user-lb"},
+		{desc: "another service", tags: map[string]string{TAGKEY: name, ACKKEY: CLUSTER_ID, SERVICEUIDKEY: serviceUIDExist}},
+		{desc: "another cluster", tags: map[string]string{TAGKEY: name, ACKKEY: "another-cluster", SERVICEUIDKEY: serviceUIDNoneExist}},
+		{desc: "another service name", tags: map[string]string{TAGKEY: "another"}, lbname: name},
+	}
+	for _, c := range cases {
+		lb := &slb.LoadBalancerType{LoadBalancerName: c.lbname}
+		owned, reason := isLoadBalancerOwned(gcTags(c.tags), lb, svc)
+		if owned != c.owned {
+			t.Errorf("%s: expect owned %t, got %t, %s", c.desc, c.owned, owned, reason)
+		}
+		if !owned && reason == "" {
+			t.Errorf("%s: expect a reason", c.desc)
+		}
+	}
+}
+
+// WithAmbiguousLoadBalancer a loadbalancer tagged with the name of the service
+// but the uid of another one
+func WithAmbiguousLoadBalancer(svc *v1.Service) CloudDataMock {
+	return func() {
+		LOADBALANCER.loadbalancer.Store(
+			AMBIGUOUS_ID,
+			slb.LoadBalancerType{
+				LoadBalancerId:   AMBIGUOUS_ID,
+				LoadBalancerName: "user-lb",
+				RegionId:         REGION,
+			},
+		)
+		LOADBALANCER.tags.Store(AMBIGUOUS_ID, gcTags(map[string]string{
+			TAGKEY:        GetLoadBalancerName(svc),
+			ACKKEY:        CLUSTER_ID,
+			SERVICEUIDKEY: serviceUIDExist,
+		}))
+	}
+}
+
+func TestEnsureLoadBalancerDeletedOwnership(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "my-service",
+			Namespace:   "default",
+			UID:         types.UID(serviceUIDNoneExist),
+			Annotations: map[string]string{},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+			},
+			Type:            v1.ServiceTypeLoadBalancer,
+			SessionAffinity: v1.ServiceAffinityNone,
+		},
+	}
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: prid},
+			Spec:       v1.NodeSpec{ProviderID: prid},
+		},
+	}
+	exists := func(id string) bool {
+		_, ok := LOADBALANCER.loadbalancer.Load(id)
+		return ok
+	}
+
+	f := NewDefaultFrameWork(nil)
+	f.WithService(svc.DeepCopy()).WithNodes(nodes)
+	f.RunCustomized(t, "Owned loadbalancer is deleted",
+		func(f *FrameWork) error {
+			if _, err := f.Cloud.EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			if err := f.Cloud.EnsureLoadBalancerDeleted(context.Background(), CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			if exists(lb.LoadBalancerId) {
+				return fmt.Errorf("expect owned loadbalancer %s deleted", lb.LoadBalancerId)
+			}
+			return nil
+		},
+	)
+
+	recorder := record.NewFakeRecorder(100)
+	ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+	f = NewDefaultFrameWork(
+		func() {
+			DefaultPreset()
+			PreSetCloudData(WithAmbiguousLoadBalancer(svc))
+		},
+	)
+	f.WithService(svc.DeepCopy()).WithNodes(nodes)
+	f.RunCustomized(t, "Ambiguous loadbalancer is kept",
+		func(f *FrameWork) error {
+			if err := f.Cloud.EnsureLoadBalancerDeleted(ctx, CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			if !exists(AMBIGUOUS_ID) {
+				return fmt.Errorf("expect ambiguous loadbalancer %s kept", AMBIGUOUS_ID)
+			}
+			found := false
+			for len(recorder.Events) > 0 {
+				event := <-recorder.Events
+				if strings.Contains(event, "SkipDeleteUnmanagedLoadBalancer") && strings.Contains(event, AMBIGUOUS_ID) {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("expect an event of the loadbalancer kept")
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerForceDelete] = "on"
+	f.RunCustomized(t, "Ambiguous loadbalancer is deleted by force",
+		func(f *FrameWork) error {
+			if err := f.Cloud.EnsureLoadBalancerDeleted(ctx, CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			if exists(AMBIGUOUS_ID) {
+				return fmt.Errorf("expect ambiguous loadbalancer %s deleted", AMBIGUOUS_ID)
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/slb"
+)
+
+// DescribeLoadBalancers of aliyungo sends no pagination and returns the first
+// page only, the loadbalancers beyond it are missed in the accounts of many
+// loadbalancers, eg. the one of the service is taken as absent and created
+// again. The loadbalancers are looked up page by page instead.
+
+// DESCRIBE_LOADBALANCERS_PAGE_SIZE loadbalancers described per page
+const DESCRIBE_LOADBALANCERS_PAGE_SIZE = 50
+
+type DescribeLoadBalancersPageArgs struct {
+	RegionId         common.Region
+	LoadBalancerId   string
+	LoadBalancerName string
+	Tags             string
+	common.Pagination
+}
+
+type DescribeLoadBalancersPageResponse struct {
+	common.Response
+	common.PaginationResult
+	LoadBalancers struct {
+		LoadBalancer []slb.LoadBalancerType
+	}
+}
+
+// describeAllLoadBalancers the loadbalancers of all the pages
+func describeAllLoadBalancers(
+	ctx context.Context, client ClientSLBSDK, args DescribeLoadBalancersPageArgs,
+) ([]slb.LoadBalancerType, error) {
+	var lbs []slb.LoadBalancerType
+	args.Pagination = common.Pagination{PageNumber: 1, PageSize: DESCRIBE_LOADBALANCERS_PAGE_SIZE}
+	for {
+		page, result, err := client.DescribeLoadBalancersPage(ctx, &args)
+		if err != nil {
+			return nil, err
+		}
+		lbs = append(lbs, page...)
+		// an empty page ends the pages anyway, the total may grow meanwhile
+		if len(page) == 0 || result == nil || result.NextPage() == nil {
+			return lbs, nil
+		}
+		args.Pagination = *result.NextPage()
+	}
+}
+
+// describeLoadBalancersByTags the loadbalancers carrying all the tags
+func describeLoadBalancersByTags(
+	ctx context.Context, client ClientSLBSDK, tags []slb.TagItem,
+) ([]slb.LoadBalancerType, string, error) {
+	items, err := json.Marshal(tags)
+	if err != nil {
+		return nil, "", err
+	}
+	lbs, err := describeAllLoadBalancers(
+		ctx,
+		client,
+		DescribeLoadBalancersPageArgs{
+			RegionId: DEFAULT_REGION,
+			Tags:     string(items),
+		},
+	)
+	return lbs, string(items), err
+}
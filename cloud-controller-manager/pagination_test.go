@@ -0,0 +1,153 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDescribeAllLoadBalancers(t *testing.T) {
+	const total = 120
+	var pages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		number, _ := strconv.Atoi(query.Get("PageNumber"))
+		size, _ := strconv.Atoi(query.Get("PageSize"))
+		pages = append(pages, fmt.Sprintf("%d/%d %s", number, size, query.Get("Tags")))
+		var lbs []string
+		for i := (number - 1) * size; i < number*size && i < total; i++ {
+			lbs = append(lbs, fmt.Sprintf(`{"LoadBalancerId":"lb-%03d"}`, i))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"RequestId":"req-%d","TotalCount":%d,"PageNumber":%d,"PageSize":%d,"LoadBalancers":{"LoadBalancer":[%s]}}`,
+			number, total, number, size, strings.Join(lbs, ","))
+	}))
+	defer server.Close()
+	client := NewContextedClientSLB("key-id", "key-secret", string(REGION))
+	client.slb.WithEndpoint(server.URL)
+
+	lbs, err := describeAllLoadBalancers(
+		context.Background(),
+		client,
+		DescribeLoadBalancersPageArgs{RegionId: REGION, Tags: `[{"TagKey":"k","TagValue":"v"}]`},
+	)
+	if err != nil {
+		t.Fatalf("describe loadbalancers: %s", err.Error())
+	}
+	if len(lbs) != total || lbs[0].LoadBalancerId != "lb-000" || lbs[total-1].LoadBalancerId != "lb-119" {
+		t.Fatalf("expect the loadbalancers of all the pages, got %d", len(lbs))
+	}
+	tags := `[{"TagKey":"k","TagValue":"v"}]`
+	expect := []string{"1/50 " + tags, "2/50 " + tags, "3/50 " + tags}
+	if strings.Join(pages, ",") != strings.Join(expect, ",") {
+		t.Errorf("expect pages %v, got %v", expect, pages)
+	}
+}
+
+func TestFindLoadBalancerPages(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-service",
+			Namespace: "default",
+			UID:       types.UID(serviceUIDNoneExist),
+		},
+		Spec: v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+	name := GetLoadBalancerName(svc)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(svc)
+
+	f.RunCustomized(t, "Prefer the tagged one of the loadbalancers of the name",
+		func(f *FrameWork) error {
+			mock := f.SLBSDK().(*mockClientSLB)
+			mock.pageSize = 1
+			defer func() {
+				mock.pageSize = 0
+				mock.describeLoadBalancers = nil
+			}()
+			// the tagged one is on the last page
+			for _, id := range []string{"lb-page-1", "lb-page-2", "lb-page-3"} {
+				LOADBALANCER.loadbalancer.Store(
+					id,
+					slb.LoadBalancerType{LoadBalancerId: id, LoadBalancerName: name, RegionId: REGION},
+				)
+			}
+			LOADBALANCER.tags.Store("lb-page-3", gcTags(ownershipTags(f.SVC)))
+			// the tags are not indexed yet
+			mock.describeLoadBalancers = func(args *slb.DescribeLoadBalancersArgs) ([]slb.LoadBalancerType, error) {
+				if args.Tags != "" {
+					return nil, nil
+				}
+				return (&mockClientSLB{}).DescribeLoadBalancers(context.Background(), args)
+			}
+
+			faults := f.SLBFaults()
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			exists, lb, err := f.LoadBalancer().FindLoadBalancer(ctx, f.SVC)
+			if err != nil {
+				return err
+			}
+			if !exists || lb.LoadBalancerId != "lb-page-3" {
+				return fmt.Errorf("expect the tagged loadbalancer lb-page-3, got %v", lb)
+			}
+			// the uid and the name tags, then the three pages of the name
+			if calls := faults.Calls("DescribeLoadBalancers"); len(calls) != 5 {
+				return fmt.Errorf("expect the name described page by page, got %v", calls)
+			}
+			if len(recorder.Events) != 1 {
+				return fmt.Errorf("expect the duplicates warned, got %d events", len(recorder.Events))
+			}
+			if event := <-recorder.Events; !strings.Contains(event, "DuplicateLoadBalancer") ||
+				!strings.Contains(event, "lb-page-1") || !strings.Contains(event, "lb-page-2") {
+				return fmt.Errorf("expect the rest of the name warned, got %s", event)
+			}
+			return nil
+		},
+	)
+
+	f.RunCustomized(t, "Find the loadbalancer by the ownership tags beyond the first page",
+		func(f *FrameWork) error {
+			mock := f.SLBSDK().(*mockClientSLB)
+			mock.pageSize = 1
+			defer func() { mock.pageSize = 0 }()
+			LOADBALANCER.tags.Store("lb-page-2", gcTags(ownershipTags(f.SVC)))
+			LOADBALANCER.tags.Store("lb-page-3", gcTags(ownershipTags(f.SVC)))
+
+			exists, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil {
+				return err
+			}
+			if !exists || lb.LoadBalancerId != "lb-page-2" {
+				return fmt.Errorf("expect the first tagged loadbalancer lb-page-2, got %v", lb)
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"k8s.io/api/core/v1"
+	"strconv"
+	"strings"
+)
+
+// PORT_OVERRIDE_PROTOCOL the protocol of the port, merged into ServiceAnnotationLoadBalancerProtocolPort
+const PORT_OVERRIDE_PROTOCOL = "protocol"
+
+// isPortOverrideKey listener annotations registered perPort could be
+// overridden by ServiceAnnotationLoadBalancerPortOverrides, see
+// annotationRegistry. Annotations of the load balancer itself, acl and
+// forward port are service wide.
+func isPortOverrideKey(key string) bool {
+	if key == PORT_OVERRIDE_PROTOCOL {
+		return true
+	}
+	return annotationRegistry[ServiceAnnotationLoadBalancerPrefix+key].perPort
+}
+
+// PortOverrides parse ServiceAnnotationLoadBalancerPortOverrides of the service.
+// The result is keyed by port, values are keyed by annotation name without prefix.
+func PortOverrides(service *v1.Service) (map[int32]map[string]string, error) {
+	result := map[int32]map[string]string{}
+	anno := serviceAnnotation(service, ServiceAnnotationLoadBalancerPortOverrides)
+	if anno == "" {
+		return result, nil
+	}
+	overrides := map[string]map[string]string{}
+	if err := json.Unmarshal([]byte(anno), &overrides); err != nil {
+		return nil, fmt.Errorf("annotation %s is not valid json: %s",
+			ServiceAnnotationLoadBalancerPortOverrides, err.Error())
+	}
+	for p, values := range overrides {
+		port, err := strconv.Atoi(p)
+		if err != nil || !hasPort(service, int32(port)) {
+			return nil, fmt.Errorf("annotation %s: %q is not a port of the service",
+				ServiceAnnotationLoadBalancerPortOverrides, p)
+		}
+		for k := range values {
+			if !isPortOverrideKey(k) {
+				return nil, fmt.Errorf("annotation %s: %q can not be overridden per port",
+					ServiceAnnotationLoadBalancerPortOverrides, k)
+			}
+		}
+		result[int32(port)] = values
+	}
+	return result, nil
+}
+
+// ServiceForPort returns a copy of the service which only contains the port, and
+// whose annotations are the service level ones merged with the overrides of
+// the port. Listeners are built and validated from it. The listener-bandwidth
+// annotation of the port takes precedence over the overrides.
+func ServiceForPort(service *v1.Service, port v1.ServicePort) (*v1.Service, error) {
+	overrides, err := PortOverrides(service)
+	if err != nil {
+		return nil, err
+	}
+	svc := service.DeepCopy()
+	svc.Spec.Ports = []v1.ServicePort{port}
+	values, ok := overrides[port.Port]
+	bandwidth := serviceAnnotation(service, listenerBandwidthAnnotation(port.Port))
+	if !ok && bandwidth == "" {
+		return svc, nil
+	}
+	annotations := getBackwardsCompatibleAnnotation(service.Annotations)
+	for k, v := range values {
+		if k == PORT_OVERRIDE_PROTOCOL {
+			annotations[ServiceAnnotationLoadBalancerProtocolPort] = mergeProtocolPort(
+				annotations[ServiceAnnotationLoadBalancerProtocolPort], v, port.Port)
+			continue
+		}
+		annotations[ServiceAnnotationLoadBalancerPrefix+k] = v
+	}
+	if bandwidth != "" {
+		annotations[ServiceAnnotationLoadBalancerListenerBandwidth] = bandwidth
+	}
+	svc.Annotations = annotations
+	return svc, nil
+}
+
+// mergeProtocolPort replace the protocol of port in annotation like "https:443,http:80".
+func mergeProtocolPort(annotation, proto string, port int32) string {
+	var merged []string
+	for _, v := range strings.Split(annotation, ",") {
+		if v == "" || strings.HasSuffix(v, fmt.Sprintf(":%d", port)) {
+			continue
+		}
+		merged = append(merged, v)
+	}
+	merged = append(merged, fmt.Sprintf("%s:%d", proto, port))
+	return strings.Join(merged, ",")
+}
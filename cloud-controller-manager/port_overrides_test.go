@@ -0,0 +1,226 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// overridePorts the ports the port overrides of the tests apply to
+func overridePorts() []v1.ServicePort {
+	return []v1.ServicePort{
+		{Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080},
+		{Port: 443, Protocol: v1.ProtocolTCP, NodePort: 30443},
+		{Port: 9000, Protocol: v1.ProtocolTCP, NodePort: 30900},
+	}
+}
+
+func TestServiceForPort(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		port        int32
+		expect      map[string]string
+	}{
+		{
+			name:        "no annotations",
+			annotations: nil,
+			port:        80,
+			expect:      nil,
+		},
+		{
+			name: "no overrides",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerScheduler: "wrr",
+			},
+			port: 80,
+			expect: map[string]string{
+				ServiceAnnotationLoadBalancerScheduler: "wrr",
+			},
+		},
+		{
+			name: "port without overrides keeps service level annotations",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerScheduler:     "wrr",
+				ServiceAnnotationLoadBalancerPortOverrides: `{"9000":{"scheduler":"wlc"}}`,
+			},
+			port: 80,
+			expect: map[string]string{
+				ServiceAnnotationLoadBalancerScheduler:     "wrr",
+				ServiceAnnotationLoadBalancerPortOverrides: `{"9000":{"scheduler":"wlc"}}`,
+			},
+		},
+		{
+			name: "override service level annotation",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerScheduler:     "wrr",
+				ServiceAnnotationLoadBalancerPortOverrides: `{"9000":{"scheduler":"wlc"}}`,
+			},
+			port: 9000,
+			expect: map[string]string{
+				ServiceAnnotationLoadBalancerScheduler:     "wlc",
+				ServiceAnnotationLoadBalancerPortOverrides: `{"9000":{"scheduler":"wlc"}}`,
+			},
+		},
+		{
+			name: "add annotation missing at service level",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerPortOverrides: `{"9000":{"health-check-uri":"/metrics","idle-timeout":"30"}}`,
+			},
+			port: 9000,
+			expect: map[string]string{
+				ServiceAnnotationLoadBalancerHealthCheckURI: "/metrics",
+				ServiceAnnotationLoadBalancerIdleTimeout:    "30",
+				ServiceAnnotationLoadBalancerPortOverrides:  `{"9000":{"health-check-uri":"/metrics","idle-timeout":"30"}}`,
+			},
+		},
+		{
+			name: "override protocol and cert id",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerProtocolPort:  "http:80",
+				ServiceAnnotationLoadBalancerPortOverrides: `{"443":{"protocol":"https","cert-id":"cert-a"}}`,
+			},
+			port: 443,
+			expect: map[string]string{
+				ServiceAnnotationLoadBalancerProtocolPort:  "http:80,https:443",
+				ServiceAnnotationLoadBalancerCertID:        "cert-a",
+				ServiceAnnotationLoadBalancerPortOverrides: `{"443":{"protocol":"https","cert-id":"cert-a"}}`,
+			},
+		},
+		{
+			name: "override protocol of port in protocol-port",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerProtocolPort:  "https:443,http:80",
+				ServiceAnnotationLoadBalancerPortOverrides: `{"80":{"protocol":"tcp"}}`,
+			},
+			port: 80,
+			expect: map[string]string{
+				ServiceAnnotationLoadBalancerProtocolPort:  "https:443,tcp:80",
+				ServiceAnnotationLoadBalancerPortOverrides: `{"80":{"protocol":"tcp"}}`,
+			},
+		},
+		{
+			name: "legacy annotation is overridden",
+			annotations: map[string]string{
+				"service.beta.kubernetes.io/alicloud-loadbalancer-scheduler": "wrr",
+				ServiceAnnotationLoadBalancerPortOverrides:                   `{"9000":{"scheduler":"wlc"}}`,
+			},
+			port: 9000,
+			expect: map[string]string{
+				ServiceAnnotationLoadBalancerScheduler:     "wlc",
+				ServiceAnnotationLoadBalancerPortOverrides: `{"9000":{"scheduler":"wlc"}}`,
+			},
+		},
+	}
+	for _, c := range cases {
+		svc := testService(c.annotations, overridePorts()...)
+		var port v1.ServicePort
+		for _, p := range svc.Spec.Ports {
+			if p.Port == c.port {
+				port = p
+			}
+		}
+		psvc, err := ServiceForPort(svc, port)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", c.name, err.Error())
+		}
+		if !reflect.DeepEqual(psvc.Annotations, c.expect) {
+			t.Fatalf("%s: expected annotations %v, got %v", c.name, c.expect, psvc.Annotations)
+		}
+		if len(psvc.Spec.Ports) != 1 || psvc.Spec.Ports[0] != port {
+			t.Fatalf("%s: expected only port %d, got %v", c.name, c.port, psvc.Spec.Ports)
+		}
+		if len(svc.Spec.Ports) != 3 {
+			t.Fatalf("%s: service must not be modified", c.name)
+		}
+	}
+}
+
+func TestServiceForPortError(t *testing.T) {
+	cases := []struct {
+		name      string
+		overrides string
+		err       string
+	}{
+		{name: "invalid json", overrides: `{"9000":`, err: "not valid json"},
+		{name: "value is not an object", overrides: `{"9000":"wlc"}`, err: "not valid json"},
+		{name: "port is not a number", overrides: `{"http":{"scheduler":"wlc"}}`, err: "not a port of the service"},
+		{name: "port is not a service port", overrides: `{"8080":{"scheduler":"wlc"}}`, err: "not a port of the service"},
+		{name: "load balancer annotation", overrides: `{"9000":{"spec":"slb.s2.small"}}`, err: "can not be overridden per port"},
+		{name: "forward port", overrides: `{"9000":{"forward-port":"80:443"}}`, err: "can not be overridden per port"},
+		{name: "full annotation name", overrides: `{"9000":{"` + ServiceAnnotationLoadBalancerScheduler + `":"wlc"}}`, err: "can not be overridden per port"},
+	}
+	for _, c := range cases {
+		svc := testService(map[string]string{ServiceAnnotationLoadBalancerPortOverrides: c.overrides}, overridePorts()...)
+		_, err := ServiceForPort(svc, svc.Spec.Ports[0])
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s: expected error %q, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestPortOverrideKeys(t *testing.T) {
+	for anno, spec := range annotationRegistry {
+		if !spec.perPort {
+			continue
+		}
+		if !strings.HasPrefix(anno, ServiceAnnotationLoadBalancerPrefix) {
+			t.Fatalf("%s is not a load balancer annotation", anno)
+		}
+		if !isPortOverrideKey(strings.TrimPrefix(anno, ServiceAnnotationLoadBalancerPrefix)) {
+			t.Fatalf("%s is expected to be overridable", anno)
+		}
+	}
+	if !isPortOverrideKey(PORT_OVERRIDE_PROTOCOL) {
+		t.Fatalf("protocol is expected to be overridable")
+	}
+	for _, anno := range []string{
+		ServiceAnnotationLoadBalancerProtocolPort,
+		ServiceAnnotationLoadBalancerForwardPort,
+		ServiceAnnotationLoadBalancerAclID,
+		ServiceAnnotationLoadBalancerSpec,
+		ServiceAnnotationLoadBalancerPortOverrides,
+	} {
+		if isPortOverrideKey(strings.TrimPrefix(anno, ServiceAnnotationLoadBalancerPrefix)) {
+			t.Fatalf("%s is not expected to be overridable", anno)
+		}
+	}
+}
+
+func TestMergeProtocolPort(t *testing.T) {
+	cases := []struct {
+		annotation string
+		proto      string
+		port       int32
+		expect     string
+	}{
+		{annotation: "", proto: "https", port: 443, expect: "https:443"},
+		{annotation: "http:80", proto: "https", port: 443, expect: "http:80,https:443"},
+		{annotation: "http:443", proto: "https", port: 443, expect: "https:443"},
+		{annotation: "http:80,https:443", proto: "tcp", port: 80, expect: "https:443,tcp:80"},
+		{annotation: "http:8080", proto: "tcp", port: 80, expect: "http:8080,tcp:80"},
+	}
+	for _, c := range cases {
+		merged := mergeProtocolPort(c.annotation, c.proto, c.port)
+		if merged != c.expect {
+			t.Fatalf("merge %q with %s:%d: expected %q, got %q", c.annotation, c.proto, c.port, c.expect, merged)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"k8s.io/api/core/v1"
 	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
 	"k8s.io/klog"
+	"strings"
 )
 
 // DEFAULT_LANG default lang
@@ -222,7 +223,7 @@ func (s *PrivateZoneClient) EnsurePrivateZoneRecord(ctx context.Context, service
 	}()
 
 	recordType := getRecordType(ipVersion)
-	_, request := ExtractAnnotationRequest(service)
+	defaulted, request := ExtractAnnotationRequest(service)
 
 	zone, record, err = s.findRecordByService(ctx, service)
 	if err != nil {
@@ -250,6 +251,7 @@ func (s *PrivateZoneClient) EnsurePrivateZoneRecord(ctx context.Context, service
 				ZoneId: zone.ZoneId,
 				Rr:     request.PrivateZoneRecordName,
 				Type:   recordType,
+				Ttl:    defaulted.PrivateZoneRecordTTL,
 				Value:  ip,
 			})
 		if err != nil {
@@ -265,11 +267,14 @@ func (s *PrivateZoneClient) EnsurePrivateZoneRecord(ctx context.Context, service
 		if record == nil {
 			return nil, nil, fmt.Errorf("alicloud: unknown error on creating private zone record, it shouldn't be happened. ")
 		}
-	} else if record.Type != recordType || record.Value != ip {
-		utils.Logf(service, "update private zone record [%s.%s] bind to ip [%s]",
+	} else if record.Type != recordType || record.Value != ip ||
+		(defaulted.PrivateZoneRecordTTL != 0 && record.Ttl != defaulted.PrivateZoneRecordTTL) {
+		// the address changes when the loadbalancer is recreated
+		utils.Logf(service, "update private zone record [%s.%s] bind to ip [%s] with ttl [%d]",
 			request.PrivateZoneRecordName,
 			zone.ZoneName,
-			ip)
+			ip,
+			defaulted.PrivateZoneRecordTTL)
 
 		err = s.c.UpdateZoneRecord(
 			ctx,
@@ -277,6 +282,7 @@ func (s *PrivateZoneClient) EnsurePrivateZoneRecord(ctx context.Context, service
 				RecordId: record.RecordId,
 				Rr:       request.PrivateZoneRecordName,
 				Type:     recordType,
+				Ttl:      defaulted.PrivateZoneRecordTTL,
 				Value:    ip,
 				Lang:     DEFAULT_LANG,
 			})
@@ -311,7 +317,10 @@ func (s *PrivateZoneClient) EnsurePrivateZoneRecordDeleted(ctx context.Context,
 
 	if zoneInfo != nil && record != nil {
 		utils.Logf(service, "private zone record deleted by cloudprovider. service [%s]", service.Name)
-		return s.c.DeleteZoneRecordsByRR(ctx, zoneInfo.ZoneId, record.Rr)
+		if err := s.c.DeleteZoneRecordsByRR(ctx, zoneInfo.ZoneId, record.Rr); err != nil {
+			return err
+		}
+		GetPrivateZoneRecordCache().remove(string(service.GetUID()))
 	}
 
 	return nil
@@ -325,6 +334,21 @@ func getHostName(pz *pvtz.DescribeZoneInfoResponse, pzr *pvtz.ZoneRecordType) st
 	return hostname
 }
 
+// loadBalancerIngress the ingress of lb. Only the hostname of the record is
+// published when the ingress-hostname annotation is "true".
+func loadBalancerIngress(service *v1.Service, lb *slb.LoadBalancerType,
+	pz *pvtz.DescribeZoneInfoResponse, pzr *pvtz.ZoneRecordType) v1.LoadBalancerIngress {
+	hostname := getHostName(pz, pzr)
+	if hostname != "" && isIngressHostname(service) {
+		return v1.LoadBalancerIngress{Hostname: hostname}
+	}
+	return v1.LoadBalancerIngress{IP: loadBalancerAddress(lb), Hostname: hostname}
+}
+
+func isIngressHostname(service *v1.Service) bool {
+	return strings.ToLower(serviceAnnotation(service, ServiceAnnotationLoadBalancerPrivateZoneIngressHostname)) == "true"
+}
+
 func getRecordType(ipVersion slb.AddressIPVersionType) string {
 	if ipVersion == slb.IPv6 {
 		return "AAAA"
@@ -0,0 +1,205 @@
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/pvtz"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var PRIVATEZONE = PrivateZoneStore{}
+
+type PrivateZoneStore struct {
+	zones   sync.Map
+	records sync.Map
+	// last record id allocated
+	recordId int64
+}
+
+const (
+	PRIVATEZONE_ID   = "d8b9f9f0c21a4fd48fc6ab3dfbc0xxxx"
+	PRIVATEZONE_NAME = "internal.example.com"
+)
+
+func WithNewPrivateZoneStore() CloudDataMock {
+	return func() {
+		PRIVATEZONE = PrivateZoneStore{}
+	}
+}
+
+func WithPrivateZone() CloudDataMock {
+	return func() {
+		PRIVATEZONE.zones.Store(
+			PRIVATEZONE_ID,
+			pvtz.ZoneType{
+				ZoneId:   PRIVATEZONE_ID,
+				ZoneName: PRIVATEZONE_NAME,
+			},
+		)
+	}
+}
+
+type mockClientPVTZ struct {
+	addZoneRecord    func(args *pvtz.AddZoneRecordArgs) (response *pvtz.AddZoneRecordResponse, err error)
+	updateZoneRecord func(args *pvtz.UpdateZoneRecordArgs) (err error)
+	deleteZoneRecord func(args *pvtz.DeleteZoneRecordArgs) (err error)
+}
+
+func (m *mockClientPVTZ) DescribeZones(ctx context.Context, args *pvtz.DescribeZonesArgs) (zones []pvtz.ZoneType, err error) {
+	PRIVATEZONE.zones.Range(
+		func(key, value interface{}) bool {
+			v := value.(pvtz.ZoneType)
+			if args.Keyword != "" &&
+				!strings.Contains(v.ZoneName, args.Keyword) {
+				// continue next
+				return true
+			}
+			zones = append(zones, v)
+			return true
+		},
+	)
+	return zones, nil
+}
+
+func (m *mockClientPVTZ) AddZone(ctx context.Context, args *pvtz.AddZoneArgs) (response *pvtz.AddZoneResponse, err error) {
+	return nil, fmt.Errorf("unimplemented")
+}
+
+func (m *mockClientPVTZ) DeleteZone(ctx context.Context, args *pvtz.DeleteZoneArgs) (err error) {
+	return fmt.Errorf("unimplemented")
+}
+
+func (m *mockClientPVTZ) CheckZoneName(ctx context.Context, args *pvtz.CheckZoneNameArgs) (bool, error) {
+	return false, fmt.Errorf("unimplemented")
+}
+
+func (m *mockClientPVTZ) UpdateZoneRemark(ctx context.Context, args *pvtz.UpdateZoneRemarkArgs) error {
+	return fmt.Errorf("unimplemented")
+}
+
+func (m *mockClientPVTZ) DescribeZoneInfo(ctx context.Context, args *pvtz.DescribeZoneInfoArgs) (response *pvtz.DescribeZoneInfoResponse, err error) {
+	v, ok := PRIVATEZONE.zones.Load(args.ZoneId)
+	if !ok {
+		return nil, fmt.Errorf("private zone %s not found", args.ZoneId)
+	}
+	zone := v.(pvtz.ZoneType)
+	return &pvtz.DescribeZoneInfoResponse{
+		ZoneId:   zone.ZoneId,
+		ZoneName: zone.ZoneName,
+	}, nil
+}
+
+func (m *mockClientPVTZ) BindZoneVpc(ctx context.Context, args *pvtz.BindZoneVpcArgs) (err error) {
+	return fmt.Errorf("unimplemented")
+}
+
+func (m *mockClientPVTZ) DescribeRegions(ctx context.Context) (regions []pvtz.RegionType, err error) {
+	return nil, fmt.Errorf("unimplemented")
+}
+
+func (m *mockClientPVTZ) DescribeZoneRecords(ctx context.Context, args *pvtz.DescribeZoneRecordsArgs) (records []pvtz.ZoneRecordType, err error) {
+	PRIVATEZONE.records.Range(
+		func(key, value interface{}) bool {
+			v := value.(zoneRecord)
+			if args.ZoneId != v.zoneId {
+				// continue next
+				return true
+			}
+			records = append(records, v.record)
+			return true
+		},
+	)
+	return records, nil
+}
+
+func (m *mockClientPVTZ) DescribeZoneRecordsByRR(ctx context.Context, zoneId string, rr string) (records []pvtz.ZoneRecordType, err error) {
+	all, err := m.DescribeZoneRecords(ctx, &pvtz.DescribeZoneRecordsArgs{ZoneId: zoneId})
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range all {
+		if record.Rr == rr {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (m *mockClientPVTZ) DeleteZoneRecordsByRR(ctx context.Context, zoneId string, rr string) error {
+	records, err := m.DescribeZoneRecordsByRR(ctx, zoneId, rr)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		PRIVATEZONE.records.Delete(record.RecordId)
+	}
+	return nil
+}
+
+func (m *mockClientPVTZ) AddZoneRecord(ctx context.Context, args *pvtz.AddZoneRecordArgs) (response *pvtz.AddZoneRecordResponse, err error) {
+	if m.addZoneRecord != nil {
+		return m.addZoneRecord(args)
+	}
+	if _, ok := PRIVATEZONE.zones.Load(args.ZoneId); !ok {
+		return nil, fmt.Errorf("private zone %s not found", args.ZoneId)
+	}
+	ttl := args.Ttl
+	if ttl == 0 {
+		// default ttl of the api
+		ttl = 60
+	}
+	id := atomic.AddInt64(&PRIVATEZONE.recordId, 1)
+	PRIVATEZONE.records.Store(
+		id,
+		zoneRecord{
+			zoneId: args.ZoneId,
+			record: pvtz.ZoneRecordType{
+				RecordId: id,
+				Rr:       args.Rr,
+				Type:     args.Type,
+				Ttl:      ttl,
+				Value:    args.Value,
+			},
+		},
+	)
+	return &pvtz.AddZoneRecordResponse{RecordId: id}, nil
+}
+
+func (m *mockClientPVTZ) UpdateZoneRecord(ctx context.Context, args *pvtz.UpdateZoneRecordArgs) (err error) {
+	if m.updateZoneRecord != nil {
+		return m.updateZoneRecord(args)
+	}
+	v, ok := PRIVATEZONE.records.Load(args.RecordId)
+	if !ok {
+		return fmt.Errorf("private zone record %d not found", args.RecordId)
+	}
+	record := v.(zoneRecord)
+	record.record.Rr = args.Rr
+	record.record.Type = args.Type
+	record.record.Value = args.Value
+	if args.Ttl != 0 {
+		record.record.Ttl = args.Ttl
+	}
+	PRIVATEZONE.records.Store(args.RecordId, record)
+	return nil
+}
+
+func (m *mockClientPVTZ) DeleteZoneRecord(ctx context.Context, args *pvtz.DeleteZoneRecordArgs) (err error) {
+	if m.deleteZoneRecord != nil {
+		return m.deleteZoneRecord(args)
+	}
+	PRIVATEZONE.records.Delete(args.RecordId)
+	return nil
+}
+
+func (m *mockClientPVTZ) SetZoneRecordStatus(ctx context.Context, args *pvtz.SetZoneRecordStatusArgs) (err error) {
+	return fmt.Errorf("unimplemented")
+}
+
+// zoneRecord the record type does not tell the zone it belongs to
+type zoneRecord struct {
+	zoneId string
+	record pvtz.ZoneRecordType
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/pvtz"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"testing"
+)
+
+func TestPrivateZoneRecord(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerPrivateZoneId:         PRIVATEZONE_ID,
+					ServiceAnnotationLoadBalancerPrivateZoneRecordName: "myservice",
+					ServiceAnnotationLoadBalancerPrivateZoneRecordTTL:  "30",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	hostname := "myservice." + PRIVATEZONE_NAME
+	records := func() ([]pvtz.ZoneRecordType, error) {
+		return f.PVTZSDK().DescribeZoneRecordsByRR(context.Background(), PRIVATEZONE_ID, "myservice")
+	}
+	expectRecord := func(ip string, ttl int) error {
+		rs, err := records()
+		if err != nil {
+			return err
+		}
+		if len(rs) != 1 {
+			return fmt.Errorf("expect exactly one record, got %v", rs)
+		}
+		if rs[0].Type != "A" || rs[0].Value != ip || rs[0].Ttl != ttl {
+			return fmt.Errorf("expect A record to %s with ttl %d, got %+v", ip, ttl, rs[0])
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Create private zone record",
+		func(f *FrameWork) error {
+			status, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err != nil {
+				return err
+			}
+			if err := ExpectExistAndEqual(f); err != nil {
+				return err
+			}
+			if len(status.Ingress) != 1 ||
+				status.Ingress[0].IP != LOADBALANCER_ADDRESS || status.Ingress[0].Hostname != hostname {
+				return fmt.Errorf("expect ingress %s/%s, got %v", LOADBALANCER_ADDRESS, hostname, status.Ingress)
+			}
+			return expectRecord(LOADBALANCER_ADDRESS, 30)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPrivateZoneRecordTTL] = "120"
+	f.RunCustomized(t, "Update record ttl",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			return expectRecord(LOADBALANCER_ADDRESS, 120)
+		},
+	)
+
+	address := "47.97.241.115"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerPrivateZoneIngressHostname] = "true"
+	f.RunCustomized(t, "Address changed and hostname published",
+		func(f *FrameWork) error {
+			// the address changes when the loadbalancer is recreated
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			v, _ := LOADBALANCER.loadbalancer.Load(lb.LoadBalancerId)
+			changed := v.(slb.LoadBalancerType)
+			changed.Address = address
+			LOADBALANCER.loadbalancer.Store(lb.LoadBalancerId, changed)
+
+			status, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err != nil {
+				return err
+			}
+			if len(status.Ingress) != 1 || status.Ingress[0].IP != "" || status.Ingress[0].Hostname != hostname {
+				return fmt.Errorf("expect ingress of hostname %s only, got %v", hostname, status.Ingress)
+			}
+			status, exist, err := f.CloudImpl().GetLoadBalancer(context.Background(), CLUSTER_ID, f.SVC)
+			if err != nil || !exist {
+				return fmt.Errorf("get loadbalancer: %v, %v", exist, err)
+			}
+			if status.Ingress[0].IP != "" || status.Ingress[0].Hostname != hostname {
+				return fmt.Errorf("expect ingress of hostname %s only, got %v", hostname, status.Ingress)
+			}
+			return expectRecord(address, 120)
+		},
+	)
+
+	f.RunCustomized(t, "Delete private zone record",
+		func(f *FrameWork) error {
+			// the ingress published has no ip
+			f.SVC.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{Hostname: hostname}}
+			if err := f.CloudImpl().EnsureLoadBalancerDeleted(context.Background(), CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			rs, err := records()
+			if err != nil {
+				return err
+			}
+			if len(rs) != 0 {
+				return fmt.Errorf("expect record deleted, got %v", rs)
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"strconv"
+	"strings"
+)
+
+// annotationError a malformed annotation. The sync is aborted without retry,
+// token is the part of the annotation which is rejected.
+type annotationError struct {
+	annotation string
+	token      string
+	reason     string
+}
+
+func (e annotationError) Error() string {
+	return fmt.Sprintf("%s: annotation %s, token [%s]: %s", utils.InvalidAnnotation, e.annotation, e.token, e.reason)
+}
+
+// ProtocolPort one token of ServiceAnnotationLoadBalancerProtocolPort, e.g. "https:443"
+type ProtocolPort struct {
+	Protocol string
+	Port     int32
+}
+
+// ParseProtocolPort parse the protocol-port annotation like "https:443,http:80"
+// strictly. Every port must be one of ports and appear only once, http and
+// https can not be set on udp ports.
+func ParseProtocolPort(annotation string, ports []v1.ServicePort) ([]ProtocolPort, error) {
+	var result []ProtocolPort
+	if strings.TrimSpace(annotation) == "" {
+		return result, nil
+	}
+	invalid := func(token, format string, args ...interface{}) error {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerProtocolPort,
+			token:      token,
+			reason:     fmt.Sprintf(format, args...),
+		}
+	}
+	seen := make(map[int32]bool)
+	for _, token := range strings.Split(annotation, ",") {
+		pp := strings.Split(strings.TrimSpace(token), ":")
+		if len(pp) != 2 {
+			return nil, invalid(token, "must be like 'https:443' and separated by comma")
+		}
+		proto := pp[0]
+		switch proto {
+		case "http", "https", "tcp", "udp":
+		default:
+			return nil, invalid(token, "protocol must be one of http, https, tcp, udp")
+		}
+		port, err := strconv.Atoi(pp[1])
+		if err != nil || port < 1 || port > 65535 {
+			return nil, invalid(token, "port must be in range [1, 65535]")
+		}
+		if seen[int32(port)] {
+			return nil, invalid(token, "port %d is duplicated", port)
+		}
+		seen[int32(port)] = true
+		var sp *v1.ServicePort
+		for i := range ports {
+			if ports[i].Port == int32(port) {
+				sp = &ports[i]
+				break
+			}
+		}
+		if sp == nil {
+			return nil, invalid(token, "port %d is not a port of the service", port)
+		}
+		if (proto == "http" || proto == "https") && sp.Protocol == v1.ProtocolUDP {
+			return nil, invalid(token, "%s listener can not be created for udp port %d", proto, port)
+		}
+		result = append(result, ProtocolPort{Protocol: proto, Port: int32(port)})
+	}
+	return result, nil
+}
+
+// appProtocol the listener protocol of spec.ports[].appProtocol, http and
+// https of the tcp ports, empty for the others, eg. grpc, which are listened
+// by their port protocol.
+func appProtocol(port v1.ServicePort) string {
+	if port.AppProtocol == nil || port.Protocol == v1.ProtocolUDP {
+		return ""
+	}
+	switch proto := strings.ToLower(*port.AppProtocol); proto {
+	case "http", "https":
+		return proto
+	}
+	return ""
+}
+
+// portProtocol the listener protocol of the port not covered by the
+// protocol-port annotation
+func portProtocol(port v1.ServicePort) string {
+	if proto := appProtocol(port); proto != "" {
+		klog.Infof("transfor protocol from %s to %s of appProtocol", string(port.Protocol), proto)
+		return proto
+	}
+	return strings.ToLower(string(port.Protocol))
+}
+
+// protocolAnnotated whether the protocol of the port is set by the
+// protocol-port annotation, which goes over its appProtocol
+func protocolAnnotated(service *v1.Service, port v1.ServicePort) bool {
+	pps, err := ParseProtocolPort(serviceAnnotation(service, ServiceAnnotationLoadBalancerProtocolPort), service.Spec.Ports)
+	if err != nil {
+		return true
+	}
+	for _, pp := range pps {
+		if pp.Port == port.Port {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateProtocolPort validate the protocol-port annotation of the service.
+func ValidateProtocolPort(service *v1.Service) error {
+	_, err := ParseProtocolPort(serviceAnnotation(service, ServiceAnnotationLoadBalancerProtocolPort), service.Spec.Ports)
+	return err
+}
+
+// ValidateCertID https listeners need a certificate, either by id or from a secret.
+func ValidateCertID(service *v1.Service) error {
+	for _, port := range service.Spec.Ports {
+		proto, err := Protocol(serviceAnnotation(service, ServiceAnnotationLoadBalancerProtocolPort), port)
+		if err != nil {
+			return err
+		}
+		if proto == "https" &&
+			serviceAnnotation(service, ServiceAnnotationLoadBalancerCertID) == "" &&
+			serviceAnnotation(service, utils.ServiceAnnotationLoadBalancerCertSecret) == "" {
+			if !protocolAnnotated(service, port) {
+				return annotationError{
+					annotation: ServiceAnnotationLoadBalancerCertID,
+					token:      "",
+					reason: fmt.Sprintf("https listener of port %d of appProtocol %s requires annotation %s or %s, "+
+						"or annotation %s to listen in another protocol",
+						port.Port, *port.AppProtocol, ServiceAnnotationLoadBalancerCertID,
+						utils.ServiceAnnotationLoadBalancerCertSecret, ServiceAnnotationLoadBalancerProtocolPort),
+				}
+			}
+			return annotationError{
+				annotation: ServiceAnnotationLoadBalancerProtocolPort,
+				token:      fmt.Sprintf("https:%d", port.Port),
+				reason: fmt.Sprintf("https listener requires annotation %s or %s",
+					ServiceAnnotationLoadBalancerCertID, utils.ServiceAnnotationLoadBalancerCertSecret),
+			}
+		}
+	}
+	return nil
+}
+
+// recordInvalidAnnotation pinpoint the rejected token of the annotation on the service.
+func recordInvalidAnnotation(ctx context.Context, service *v1.Service, err error) {
+	e, ok := err.(annotationError)
+	if !ok {
+		return
+	}
+	record, rerr := utils.GetRecorderFromContext(ctx)
+	if rerr != nil {
+		klog.Warningf("get recorder error: %s", rerr.Error())
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		utils.InvalidAnnotation,
+		"Invalid token [%s] of annotation %s: %s",
+		e.token, e.annotation, e.reason,
+	)
+}
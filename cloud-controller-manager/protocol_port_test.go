@@ -0,0 +1,312 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProtocolPort(t *testing.T) {
+	ports := []v1.ServicePort{
+		{Port: 80, Protocol: v1.ProtocolTCP},
+		{Port: 443, Protocol: v1.ProtocolTCP},
+		{Port: 53, Protocol: v1.ProtocolUDP},
+	}
+	cases := []struct {
+		annotation string
+		expect     []ProtocolPort
+		invalid    bool
+		token      string
+	}{
+		{annotation: "", expect: nil},
+		{annotation: "https:443", expect: []ProtocolPort{{"https", 443}}},
+		{annotation: "https:443,http:80", expect: []ProtocolPort{{"https", 443}, {"http", 80}}},
+		{annotation: "https:443, http:80", expect: []ProtocolPort{{"https", 443}, {"http", 80}}},
+		{annotation: "tcp:80,udp:53", expect: []ProtocolPort{{"tcp", 80}, {"udp", 53}}},
+		{annotation: "https:443;http:80", invalid: true, token: "https:443;http:80"},
+		{annotation: "https:443 http:80", invalid: true, token: "https:443 http:80"},
+		{annotation: "https443", invalid: true, token: "https443"},
+		{annotation: "443:https", invalid: true, token: "443:https"},
+		{annotation: "https:443,", invalid: true, token: ""},
+		{annotation: "HTTPS:443", invalid: true, token: "HTTPS:443"},
+		{annotation: "grpc:443", invalid: true, token: "grpc:443"},
+		{annotation: "https:", invalid: true, token: "https:"},
+		{annotation: "https:abc", invalid: true, token: "https:abc"},
+		{annotation: "https:0", invalid: true, token: "https:0"},
+		{annotation: "https:65536", invalid: true, token: "https:65536"},
+		{annotation: "https:8443", invalid: true, token: "https:8443"},
+		{annotation: "https:443,http:443", invalid: true, token: "http:443"},
+		{annotation: "http:53", invalid: true, token: "http:53"},
+		{annotation: "https:443:80", invalid: true, token: "https:443:80"},
+	}
+	for _, c := range cases {
+		result, err := ParseProtocolPort(c.annotation, ports)
+		if !c.invalid {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", c.annotation, err.Error())
+				continue
+			}
+			if !reflect.DeepEqual(result, c.expect) {
+				t.Errorf("%q: expected %v, got %v", c.annotation, c.expect, result)
+			}
+			continue
+		}
+		e, ok := err.(annotationError)
+		if !ok {
+			t.Errorf("%q: expected annotation error, got %v", c.annotation, err)
+			continue
+		}
+		if e.token != c.token {
+			t.Errorf("%q: expected token %q, got %q", c.annotation, c.token, e.token)
+		}
+		if !strings.Contains(e.Error(), utils.InvalidAnnotation) {
+			t.Errorf("%q: error is expected to contain %s: %s", c.annotation, utils.InvalidAnnotation, e.Error())
+		}
+	}
+}
+
+func TestValidateCertID(t *testing.T) {
+	svc := testService(map[string]string{
+		ServiceAnnotationLoadBalancerProtocolPort: "https:443",
+	}, overridePorts()...)
+	if err := ValidateListenerAnnotations(svc); err == nil ||
+		!strings.Contains(err.Error(), ServiceAnnotationLoadBalancerCertID) {
+		t.Fatalf("expected cert id required error, got %v", err)
+	}
+	svc.Annotations[ServiceAnnotationLoadBalancerCertID] = certID
+	if err := ValidateListenerAnnotations(svc); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	svc = testService(map[string]string{
+		ServiceAnnotationLoadBalancerPortOverrides: `{"443":{"protocol":"https"}}`,
+	}, overridePorts()...)
+	if err := ValidateListenerAnnotations(svc); err == nil {
+		t.Fatalf("expected cert id required error of the overridden https port")
+	}
+	svc.Annotations[ServiceAnnotationLoadBalancerPortOverrides] = fmt.Sprintf(`{"443":{"protocol":"https","cert-id":%q}}`, certID)
+	if err := ValidateListenerAnnotations(svc); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestInvalidProtocolPortEvent(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerProtocolPort: "https:443;http:80",
+					ServiceAnnotationLoadBalancerCertID:       certID,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+					{Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 31443},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Malformed protocol-port annotation",
+		func(f *FrameWork) error {
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+				return fmt.Errorf("expect InvalidAnnotation error, got %v", err)
+			}
+			if len(recorder.Events) != 1 {
+				return fmt.Errorf("expect 1 event, got %d", len(recorder.Events))
+			}
+			event := <-recorder.Events
+			if !strings.Contains(event, utils.InvalidAnnotation) ||
+				!strings.Contains(event, "https:443;http:80") {
+				return fmt.Errorf("unexpected event: %s", event)
+			}
+			exist, _, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil {
+				return err
+			}
+			if exist {
+				return fmt.Errorf("no loadbalancer is expected to be created")
+			}
+			return nil
+		},
+	)
+}
+
+func appProtocolService(annotations map[string]string, http, https, grpc string) *v1.Service {
+	return testService(annotations,
+		v1.ServicePort{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1, AppProtocol: &http},
+		v1.ServicePort{Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 31443, AppProtocol: &https},
+		v1.ServicePort{Port: 9090, TargetPort: intstr.FromInt(9090), Protocol: v1.ProtocolTCP, NodePort: 31090, AppProtocol: &grpc},
+		v1.ServicePort{Port: 53, TargetPort: intstr.FromInt(53), Protocol: v1.ProtocolUDP, NodePort: 31053, AppProtocol: &http},
+	)
+}
+
+func TestAppProtocol(t *testing.T) {
+	svc := appProtocolService(map[string]string{}, "http", "HTTPS", "grpc")
+	expect := map[int32]string{80: "http", 443: "https", 9090: "tcp", 53: "udp"}
+	for _, port := range svc.Spec.Ports {
+		proto, err := Protocol("", port)
+		if err != nil || proto != expect[port.Port] {
+			t.Errorf("port %d: expect %s of appProtocol, got %s, %v", port.Port, expect[port.Port], proto, err)
+		}
+	}
+
+	// the annotation goes over appProtocol
+	expect[80], expect[443] = "tcp", "http"
+	for _, port := range svc.Spec.Ports {
+		proto, err := Protocol("tcp:80,http:443", port)
+		if err != nil || proto != expect[port.Port] {
+			t.Errorf("port %d: expect %s of the annotation, got %s, %v", port.Port, expect[port.Port], proto, err)
+		}
+	}
+
+	// https of appProtocol needs a certificate
+	err := ValidateCertID(svc)
+	if err == nil || !strings.Contains(err.Error(), "appProtocol") {
+		t.Errorf("expect the https port of appProtocol rejected without certificate, got %v", err)
+	}
+	svc.Annotations[ServiceAnnotationLoadBalancerProtocolPort] = "tcp:443"
+	if err := ValidateCertID(svc); err != nil {
+		t.Errorf("expect the annotation over appProtocol, got %s", err.Error())
+	}
+}
+
+func TestAppProtocolListeners(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: prid},
+			Spec:       v1.NodeSpec{ProviderID: prid},
+		},
+	}
+	// the listener protocols of the loadbalancer by port
+	protocols := func(f *FrameWork) (map[int]string, error) {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return nil, fmt.Errorf("find loadbalancer: %v", err)
+		}
+		result := map[int]string{}
+		for _, v := range lb.ListenerPortsAndProtocol.ListenerPortAndProtocol {
+			result[v.ListenerPort] = v.ListenerProtocol
+		}
+		return result, nil
+	}
+	expectProtocols := func(f *FrameWork, expect map[int]string) error {
+		got, err := protocols(f)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(got, expect) {
+			return fmt.Errorf("expect listeners %v, got %v", expect, got)
+		}
+		return nil
+	}
+
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		appProtocolService(map[string]string{ServiceAnnotationLoadBalancerCertID: certID}, "http", "https", "grpc"),
+	).WithNodes(nodes)
+	f.RunCustomized(t, "Listeners of appProtocol",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return expectProtocols(f, map[int]string{80: "http", 443: "https", 9090: "tcp", 53: "udp"})
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerProtocolPort] = "tcp:80"
+	f.RunCustomized(t, "Annotation over appProtocol",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return expectProtocols(f, map[int]string{80: "tcp", 443: "https", 9090: "tcp", 53: "udp"})
+		},
+	)
+
+	// the listener is recreated, its protocol can not be modified in place
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerProtocolPort)
+	grpc := "http"
+	f.SVC.Spec.Ports[2].AppProtocol = &grpc
+	f.RunCustomized(t, "appProtocol changed",
+		func(f *FrameWork) error {
+			faults := f.SLBFaults()
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			if calls := faults.Calls("DeleteLoadBalancerListener"); len(calls) != 2 {
+				return fmt.Errorf("expect the listeners of 80 and 9090 recreated, got %v", calls)
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return expectProtocols(f, map[int]string{80: "http", 443: "https", 9090: "http", 53: "udp"})
+		},
+	)
+
+	f = NewDefaultFrameWork(nil)
+	f.WithService(appProtocolService(map[string]string{}, "http", "https", "grpc")).WithNodes(nodes)
+	f.RunCustomized(t, "https of appProtocol without certificate",
+		func(f *FrameWork) error {
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+				return fmt.Errorf("expect InvalidAnnotation error, got %v", err)
+			}
+			if len(recorder.Events) != 1 {
+				return fmt.Errorf("expect 1 event, got %d", len(recorder.Events))
+			}
+			if event := <-recorder.Events; !strings.Contains(event, "appProtocol https") {
+				return fmt.Errorf("expect the event of the https port of appProtocol, got %s", event)
+			}
+			return ExpectNotExist(f)
+		},
+	)
+}
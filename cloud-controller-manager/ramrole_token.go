@@ -0,0 +1,142 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"k8s.io/klog"
+	"sync"
+	"time"
+)
+
+// The sts token of the instance ram role is cached and renewed by the client
+// manager in background once less than a fraction of its lifetime remains,
+// the calls are not left with a token about to expire. The metadata server
+// flaps now and then, the token is retrieved with jittered retries, the
+// cached one is kept until it expires.
+
+// DEFAULT_RAMROLE_REFRESH_FRACTION fraction of the lifetime remaining when the token is renewed
+const DEFAULT_RAMROLE_REFRESH_FRACTION = 0.2
+
+// RAMROLE_CHECK_PERIOD interval the token is checked for renewal
+var RAMROLE_CHECK_PERIOD = 30 * time.Second
+
+// RAMROLE_RETRY_INTERVAL initial interval of the retries on metadata server errors
+var RAMROLE_RETRY_INTERVAL = time.Second
+
+// RAMROLE_RETRY_STEPS attempts to retrieve the token from the metadata server
+var RAMROLE_RETRY_STEPS = 4
+
+// RamRoleToken implement the instance ram role auth
+type RamRoleToken struct {
+	meta IMetaData
+	// Fraction of the lifetime remaining when the token is renewed
+	Fraction float64
+
+	lock       sync.Mutex
+	token      *Token
+	updated    time.Time
+	expiration time.Time
+}
+
+func NewRamRoleToken(m IMetaData) *RamRoleToken {
+	fraction := cfg.Global.RamRoleRefreshFraction
+	if fraction <= 0 || fraction >= 1 {
+		fraction = DEFAULT_RAMROLE_REFRESH_FRACTION
+	}
+	return &RamRoleToken{meta: m, Fraction: fraction}
+}
+
+// Due whether the token is to be renewed
+func (f *RamRoleToken) Due() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.due()
+}
+
+func (f *RamRoleToken) due() bool {
+	if f.token == nil {
+		return true
+	}
+	lifetime := f.expiration.Sub(f.updated)
+	return time.Until(f.expiration) < time.Duration(float64(lifetime)*f.Fraction)
+}
+
+// Invalidate drop the cached token, it is retrieved again next time
+func (f *RamRoleToken) Invalidate() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.token = nil
+}
+
+func (f *RamRoleToken) NextToken() (*Token, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.due() {
+		return f.token, nil
+	}
+	var lastErr error
+	err := wait.ExponentialBackoff(
+		wait.Backoff{
+			Steps:    RAMROLE_RETRY_STEPS,
+			Duration: RAMROLE_RETRY_INTERVAL,
+			Jitter:   1,
+			Factor:   2,
+		}, func() (done bool, err error) {
+			lastErr = f.retrieve()
+			if lastErr != nil {
+				klog.Warningf("alicloud: %s, retry", lastErr.Error())
+			}
+			return lastErr == nil, nil
+		},
+	)
+	if err == nil {
+		return f.token, nil
+	}
+	metric.RamRoleRefreshFailure.Inc()
+	if f.token != nil && time.Now().Before(f.expiration) {
+		klog.Errorf("alicloud: %s, keep the token expiring at %s", lastErr.Error(), f.expiration)
+		return f.token, nil
+	}
+	return nil, lastErr
+}
+
+// retrieve the token from the metadata server
+func (f *RamRoleToken) retrieve() error {
+	roleName, err := f.meta.RoleName()
+	if err != nil {
+		return fmt.Errorf("role name: %s", err.Error())
+	}
+	// use instance ram file way.
+	role, err := f.meta.RamRoleToken(roleName)
+	if err != nil {
+		return fmt.Errorf("ramrole token retrieve: %s", err.Error())
+	}
+	f.token = &Token{
+		AccessKey:    role.AccessKeyId,
+		AccessSecret: role.AccessKeySecret,
+		Token:        role.SecurityToken,
+	}
+	f.updated, f.expiration = role.LastUpdated, role.Expiration
+	if f.updated.IsZero() || !f.updated.Before(f.expiration) {
+		f.updated = time.Now()
+	}
+	klog.V(2).Infof("alicloud: ramrole token of %s renewed, expiring at %s", roleName, f.expiration)
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"github.com/denverdino/aliyungo/metadata"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRamRoleMeta metadata server issuing a token of the lifetime on each
+// call, the calls fail while failures remain.
+type fakeRamRoleMeta struct {
+	IMetaData
+	lock     sync.Mutex
+	lifetime time.Duration
+	failures int
+	calls    int
+}
+
+func (m *fakeRamRoleMeta) RoleName() (string, error) { return ROLE_NAME, nil }
+
+func (m *fakeRamRoleMeta) RamRoleToken(role string) (metadata.RoleAuth, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls++
+	if m.failures > 0 {
+		m.failures--
+		return metadata.RoleAuth{}, fmt.Errorf("metadata server: 500 Internal Server Error")
+	}
+	now := time.Now()
+	return metadata.RoleAuth{
+		AccessKeyId:     fmt.Sprintf("STS.key-%d", m.calls),
+		AccessKeySecret: "secret",
+		SecurityToken:   fmt.Sprintf("token-%d", m.calls),
+		LastUpdated:     now,
+		Expiration:      now.Add(m.lifetime),
+		Code:            "Success",
+	}, nil
+}
+
+func newFakeRamRoleToken(meta *fakeRamRoleMeta) *RamRoleToken {
+	return &RamRoleToken{meta: meta, Fraction: DEFAULT_RAMROLE_REFRESH_FRACTION}
+}
+
+func TestRamRoleTokenEarlyRefresh(t *testing.T) {
+	meta := &fakeRamRoleMeta{lifetime: time.Hour}
+	ramrole := newFakeRamRoleToken(meta)
+	if !ramrole.Due() {
+		t.Fatalf("expect the token retrieved at first")
+	}
+	token, err := ramrole.NextToken()
+	if err != nil || token.Token != "token-1" {
+		t.Fatalf("expect token-1, got %+v, %v", token, err)
+	}
+	// more than the fraction of the lifetime remains
+	ramrole.updated, ramrole.expiration = time.Now().Add(-30*time.Minute), time.Now().Add(30*time.Minute)
+	if token, err = ramrole.NextToken(); err != nil || meta.calls != 1 || ramrole.Due() {
+		t.Fatalf("expect the token cached, got %+v, %v, %d calls", token, err, meta.calls)
+	}
+	// less than the fraction of the lifetime remains, renewed before it expires
+	ramrole.updated, ramrole.expiration = time.Now().Add(-50*time.Minute), time.Now().Add(10*time.Minute)
+	if !ramrole.Due() {
+		t.Fatalf("expect the token due for renewal")
+	}
+	if token, err = ramrole.NextToken(); err != nil || token.Token != "token-2" || ramrole.Due() {
+		t.Fatalf("expect token-2 renewed early, got %+v, %v", token, err)
+	}
+}
+
+func TestRamRoleTokenMetadataFlaps(t *testing.T) {
+	interval := RAMROLE_RETRY_INTERVAL
+	defer func() { RAMROLE_RETRY_INTERVAL = interval }()
+	RAMROLE_RETRY_INTERVAL = time.Millisecond
+
+	meta := &fakeRamRoleMeta{lifetime: time.Hour, failures: RAMROLE_RETRY_STEPS - 1}
+	ramrole := newFakeRamRoleToken(meta)
+	token, err := ramrole.NextToken()
+	if err != nil || meta.calls != RAMROLE_RETRY_STEPS {
+		t.Fatalf("expect the token retrieved by retries, got %+v, %v, %d calls", token, err, meta.calls)
+	}
+
+	failures := testutil.ToFloat64(metric.RamRoleRefreshFailure)
+	// the metadata server is down while the token is due, the cached one is kept
+	meta.failures = RAMROLE_RETRY_STEPS
+	ramrole.updated, ramrole.expiration = time.Now().Add(-55*time.Minute), time.Now().Add(5*time.Minute)
+	if next, err := ramrole.NextToken(); err != nil || next != token {
+		t.Fatalf("expect the cached token kept, got %+v, %v", next, err)
+	}
+	if testutil.ToFloat64(metric.RamRoleRefreshFailure) != failures+1 {
+		t.Fatalf("expect the refresh failure counted")
+	}
+	// the cached token expired
+	meta.failures = RAMROLE_RETRY_STEPS
+	ramrole.expiration = time.Now().Add(-time.Second)
+	if next, err := ramrole.NextToken(); err == nil {
+		t.Fatalf("expect an error with the token expired, got %+v", next)
+	}
+}
+
+func TestRamRoleTokenForcedRefresh(t *testing.T) {
+	meta := &fakeRamRoleMeta{lifetime: time.Hour}
+	applied := []*Token{}
+	mgr := &ClientMgr{
+		token: newFakeRamRoleToken(meta),
+		settoken: func(mgr *ClientMgr, token *Token) error {
+			applied = append(applied, token)
+			return nil
+		},
+	}
+	if err := mgr.Refresh(); err != nil {
+		t.Fatalf("refresh: %s", err.Error())
+	}
+	if mgr.RefreshExpired(fmt.Errorf("Code: Throttling")) || len(applied) != 1 {
+		t.Fatalf("expect no refresh for the other errors")
+	}
+	// the token is not due, but a call failed as it expired
+	expired := fmt.Errorf("Aliyun API Error: RequestId: req-1 Status Code: 400 Code: InvalidSecurityToken.Expired")
+	if !mgr.RefreshExpired(expired) {
+		t.Fatalf("expect the token refreshed on the expiry error")
+	}
+	if len(applied) != 2 || applied[1].Token != "token-2" || meta.calls != 2 {
+		t.Fatalf("expect token-2 retrieved by the forced refresh, got %+v, %d calls", applied, meta.calls)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"time"
+)
+
+// Pods of eni backends opt in the readiness gate by the readinessGates of
+// the pod spec. Such a pod is not ready until the condition is set, it is
+// listed by the not ready addresses of the endpoints. Once its containers
+// are ready, it is added to the vserver groups anyway, and the condition is
+// set when the loadbalancer reports it healthy. The pod becoming ready or
+// terminating changes the endpoints, which resyncs the service, so there is
+// no need to watch the pods.
+
+// PodConditionSLBRegistered readiness gate of the pods of eni backends
+const PodConditionSLBRegistered v1.PodConditionType = "service.readiness.alibabacloud.com/slb-registered"
+
+// READINESS_GATE_RECHECK_INTERVAL the health status is checked again until
+// the registered pods are healthy
+const READINESS_GATE_RECHECK_INTERVAL = 5 * time.Second
+
+// SERVER_HEALTH_STATUS_NORMAL health status of a healthy backend
+const SERVER_HEALTH_STATUS_NORMAL = "normal"
+
+// The health status of the backends is described by the slb api, which is
+// not wrapped by aliyungo either, see domainextension.go.
+
+type DescribeHealthStatusArgs struct {
+	RegionId       common.Region
+	LoadBalancerId string
+	ListenerPort   int
+}
+
+type BackendServerHealthStatusType struct {
+	ListenerPort       int
+	Protocol           string
+	ServerId           string
+	ServerIp           string
+	Port               int
+	ServerHealthStatus string
+}
+
+type DescribeHealthStatusResponse struct {
+	common.Response
+	BackendServers struct {
+		BackendServer []BackendServerHealthStatusType
+	}
+}
+
+func hasReadinessGate(pod *v1.Pod) bool {
+	for _, gate := range pod.Spec.ReadinessGates {
+		if gate.ConditionType == PodConditionSLBRegistered {
+			return true
+		}
+	}
+	return false
+}
+
+func podConditionTrue(pod *v1.Pod, condition v1.PodConditionType) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == condition {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// readinessGatedPods the pods of the not ready addresses, which are held
+// only by the readiness gate. Terminating pods are left out.
+func (c *Cloud) readinessGatedPods(ctx context.Context, service *v1.Service, eps *v1.Endpoints) []*v1.Pod {
+	if eps == nil || !IsENIBackendType(service) {
+		return nil
+	}
+	var pods []*v1.Pod
+	for _, sub := range eps.Subsets {
+		for _, addr := range sub.NotReadyAddresses {
+			if addr.TargetRef == nil || addr.TargetRef.Kind != "Pod" {
+				continue
+			}
+			pod, err := c.kclient.CoreV1().Pods(service.Namespace).Get(ctx, addr.TargetRef.Name, metav1.GetOptions{})
+			if err != nil {
+				klog.Warningf("get pod %s/%s of endpoint %s: %s", service.Namespace, addr.TargetRef.Name, addr.IP, err.Error())
+				continue
+			}
+			if pod.DeletionTimestamp != nil ||
+				!hasReadinessGate(pod) ||
+				!podConditionTrue(pod, v1.ContainersReady) ||
+				podConditionTrue(pod, PodConditionSLBRegistered) {
+				continue
+			}
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
+// withGatedAddresses endpoints with the addresses of the gated pods ready
+func withGatedAddresses(eps *v1.Endpoints, pods []*v1.Pod) *v1.Endpoints {
+	if len(pods) == 0 {
+		return eps
+	}
+	gated := map[string]bool{}
+	for _, pod := range pods {
+		gated[pod.Name] = true
+	}
+	eps = eps.DeepCopy()
+	for i := range eps.Subsets {
+		sub := &eps.Subsets[i]
+		var notReady []v1.EndpointAddress
+		for _, addr := range sub.NotReadyAddresses {
+			if addr.TargetRef != nil && gated[addr.TargetRef.Name] {
+				sub.Addresses = append(sub.Addresses, addr)
+				continue
+			}
+			notReady = append(notReady, addr)
+		}
+		sub.NotReadyAddresses = notReady
+	}
+	return eps
+}
+
+// healthyBackends ips of the backends healthy on all the listeners
+func healthyBackends(status *DescribeHealthStatusResponse) map[string]bool {
+	healthy := map[string]bool{}
+	for _, b := range status.BackendServers.BackendServer {
+		if b.ServerIp == "" {
+			continue
+		}
+		normal := b.ServerHealthStatus == SERVER_HEALTH_STATUS_NORMAL
+		if ok, found := healthy[b.ServerIp]; found {
+			normal = normal && ok
+		}
+		healthy[b.ServerIp] = normal
+	}
+	return healthy
+}
+
+// syncReadinessGates set the readiness gate condition of the gated pods
+// healthy in the loadbalancer, the others are checked again later.
+func (c *Cloud) syncReadinessGates(ctx context.Context, service *v1.Service, lbid string, pods []*v1.Pod) {
+	if len(pods) == 0 {
+		return
+	}
+	status, err := c.climgr.LoadBalancers().c.DescribeHealthStatus(
+		ctx, &DescribeHealthStatusArgs{LoadBalancerId: lbid},
+	)
+	if err != nil {
+		utils.Logf(service, "describe health status of loadbalancer %s: %s", lbid, err.Error())
+		utils.RequeueAfter(ctx, READINESS_GATE_RECHECK_INTERVAL)
+		return
+	}
+	healthy := healthyBackends(status)
+	pending := 0
+	for _, pod := range pods {
+		if !healthy[pod.Status.PodIP] {
+			pending++
+			continue
+		}
+		if err := c.setPodRegistered(ctx, pod, lbid); err != nil {
+			utils.Logf(service, "set readiness gate of pod %s: %s", pod.Name, err.Error())
+			pending++
+		}
+	}
+	if pending > 0 {
+		utils.Logf(service, "%d pods are not healthy in loadbalancer %s yet, check again in %s",
+			pending, lbid, READINESS_GATE_RECHECK_INTERVAL)
+		utils.RequeueAfter(ctx, READINESS_GATE_RECHECK_INTERVAL)
+	}
+}
+
+// setPodRegistered set the readiness gate condition of the pod true
+func (c *Cloud) setPodRegistered(ctx context.Context, pod *v1.Pod, lbid string) error {
+	pod = pod.DeepCopy()
+	condition := v1.PodCondition{
+		Type:               PodConditionSLBRegistered,
+		Status:             v1.ConditionTrue,
+		LastTransitionTime: metav1.Now(),
+		Reason:             "LoadBalancerHealthy",
+		Message:            fmt.Sprintf("pod is healthy in loadbalancer %s", lbid),
+	}
+	found := false
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == PodConditionSLBRegistered {
+			pod.Status.Conditions[i] = condition
+			found = true
+		}
+	}
+	if !found {
+		pod.Status.Conditions = append(pod.Status.Conditions, condition)
+	}
+	_, err := c.kclient.CoreV1().Pods(pod.Namespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHealthyBackends(t *testing.T) {
+	status := &DescribeHealthStatusResponse{}
+	status.BackendServers.BackendServer = []BackendServerHealthStatusType{
+		{ListenerPort: 80, ServerIp: "10.0.0.1", ServerHealthStatus: "normal"},
+		{ListenerPort: 443, ServerIp: "10.0.0.1", ServerHealthStatus: "normal"},
+		{ListenerPort: 80, ServerIp: "10.0.0.2", ServerHealthStatus: "normal"},
+		{ListenerPort: 443, ServerIp: "10.0.0.2", ServerHealthStatus: "abnormal"},
+		{ListenerPort: 80, ServerIp: "10.0.0.3", ServerHealthStatus: "unavailable"},
+	}
+	expect := map[string]bool{"10.0.0.1": true, "10.0.0.2": false, "10.0.0.3": false}
+	if healthy := healthyBackends(status); !reflect.DeepEqual(healthy, expect) {
+		t.Fatalf("expect healthy backends %v, got %v", expect, healthy)
+	}
+}
+
+func TestReadinessGate(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					utils.BACKEND_TYPE_LABEL: utils.BACKEND_TYPE_ENI,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	).WithEndpoints(
+		// pod-b is held by the readiness gate
+		&v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-service", Namespace: "default"},
+			Subsets: []v1.EndpointSubset{
+				{
+					Addresses: []v1.EndpointAddress{
+						{IP: ENI_ADDR_1, NodeName: &prid, TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "pod-a"}},
+					},
+					NotReadyAddresses: []v1.EndpointAddress{
+						{IP: ENI_ADDR_2, NodeName: &prid, TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "pod-b"}},
+					},
+					Ports: []v1.EndpointPort{{Port: targetPort1.IntVal}},
+				},
+			},
+		},
+	)
+	gatedPod := func(terminating bool) *v1.Pod {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: "default"},
+			Spec: v1.PodSpec{
+				ReadinessGates: []v1.PodReadinessGate{{ConditionType: PodConditionSLBRegistered}},
+			},
+			Status: v1.PodStatus{
+				PodIP: ENI_ADDR_2,
+				Conditions: []v1.PodCondition{
+					{Type: v1.ContainersReady, Status: v1.ConditionTrue},
+					{Type: v1.PodReady, Status: v1.ConditionFalse},
+				},
+			},
+		}
+		if terminating {
+			now := metav1.Now()
+			pod.DeletionTimestamp = &now
+		}
+		return pod
+	}
+	var requeued []time.Duration
+	ensure := func(f *FrameWork, pod *v1.Pod) error {
+		if _, err := f.Cloud.kclient.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		requeued = nil
+		ctx := context.WithValue(
+			context.Background(), utils.ContextRequeue,
+			func(delay time.Duration) { requeued = append(requeued, delay) },
+		)
+		_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+		return err
+	}
+	registered := func(f *FrameWork) (bool, error) {
+		pod, err := f.Cloud.kclient.CoreV1().Pods("default").Get(context.Background(), "pod-b", metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return podConditionTrue(pod, PodConditionSLBRegistered), nil
+	}
+	expectBackends := func(f *FrameWork, ips ...string) error {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return fmt.Errorf("find loadbalancer: %v", err)
+		}
+		res, err := f.SLBSDK().DescribeVServerGroups(
+			context.Background(),
+			&slb.DescribeVServerGroupsArgs{LoadBalancerId: lb.LoadBalancerId},
+		)
+		if err != nil || len(res.VServerGroups.VServerGroup) != 1 {
+			return fmt.Errorf("expect 1 vserver group, got %v, %v", res, err)
+		}
+		att, err := f.SLBSDK().DescribeVServerGroupAttribute(
+			context.Background(),
+			&slb.DescribeVServerGroupAttributeArgs{VServerGroupId: res.VServerGroups.VServerGroup[0].VServerGroupId},
+		)
+		if err != nil {
+			return err
+		}
+		var got []string
+		for _, b := range att.BackendServers.BackendServer {
+			got = append(got, b.ServerIp)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, ips) {
+			return fmt.Errorf("expect backends %v, got %v", ips, got)
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Gated pod is registered before it is healthy",
+		func(f *FrameWork) error {
+			sdk := f.SLBSDK().(*mockClientSLB)
+			sdk.describeHealthStatus = func(args *DescribeHealthStatusArgs) (*DescribeHealthStatusResponse, error) {
+				status := &DescribeHealthStatusResponse{}
+				status.BackendServers.BackendServer = []BackendServerHealthStatusType{
+					{ServerIp: ENI_ADDR_1, ServerHealthStatus: SERVER_HEALTH_STATUS_NORMAL},
+					{ServerIp: ENI_ADDR_2, ServerHealthStatus: "abnormal"},
+				}
+				return status, nil
+			}
+			defer func() { sdk.describeHealthStatus = nil }()
+			if err := ensure(f, gatedPod(false)); err != nil {
+				return err
+			}
+			if err := expectBackends(f, ENI_ADDR_1, ENI_ADDR_2); err != nil {
+				return err
+			}
+			if ok, err := registered(f); err != nil || ok {
+				return fmt.Errorf("expect condition unset before the pod is healthy, got %t, %v", ok, err)
+			}
+			if len(requeued) != 1 || requeued[0] != READINESS_GATE_RECHECK_INTERVAL {
+				return fmt.Errorf("expect requeue after %s, got %v", READINESS_GATE_RECHECK_INTERVAL, requeued)
+			}
+			return nil
+		},
+	)
+
+	f.RunCustomized(t, "Condition is set once the pod is healthy",
+		func(f *FrameWork) error {
+			if err := ensure(f, gatedPod(false)); err != nil {
+				return err
+			}
+			if ok, err := registered(f); err != nil || !ok {
+				return fmt.Errorf("expect condition set for the healthy pod, got %t, %v", ok, err)
+			}
+			if len(requeued) != 0 {
+				return fmt.Errorf("expect no requeue, got %v", requeued)
+			}
+			return expectBackends(f, ENI_ADDR_1, ENI_ADDR_2)
+		},
+	)
+
+	f.RunCustomized(t, "Terminating pod is removed",
+		func(f *FrameWork) error {
+			if err := ensure(f, gatedPod(true)); err != nil {
+				return err
+			}
+			if ok, err := registered(f); err != nil || ok {
+				return fmt.Errorf("expect condition unset for the terminating pod, got %t, %v", ok, err)
+			}
+			return expectBackends(f, ENI_ADDR_1)
+		},
+	)
+}
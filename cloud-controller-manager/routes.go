@@ -24,8 +24,9 @@ import (
 	"fmt"
 	"github.com/denverdino/aliyungo/common"
 	"github.com/denverdino/aliyungo/ecs"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
 	"strings"
+	"time"
 )
 
 type vpc struct {
@@ -44,12 +45,18 @@ type RoutesClient struct {
 //RouteSDK define route sdk interface
 type RouteSDK interface {
 	DescribeVpcs(ctx context.Context, args *ecs.DescribeVpcsArgs) (vpcs []ecs.VpcSetType, pagination *common.PaginationResult, err error)
+	DescribeVpcsIpv6(ctx context.Context, args *ecs.DescribeVpcsArgs) (vpcs []VpcIpv6Type, err error)
 	DescribeVRouters(ctx context.Context, args *ecs.DescribeVRoutersArgs) (vrouters []ecs.VRouterSetType, pagination *common.PaginationResult, err error)
 	DescribeRouteTables(ctx context.Context, args *ecs.DescribeRouteTablesArgs) (routeTables []ecs.RouteTableSetType, pagination *common.PaginationResult, err error)
 	DeleteRouteEntry(ctx context.Context, args *ecs.DeleteRouteEntryArgs) error
 	CreateRouteEntry(ctx context.Context, args *ecs.CreateRouteEntryArgs) error
 	WaitForAllRouteEntriesAvailable(ctx context.Context, vrouterId string, routeTableId string, timeout int) error
 	DescribeRouteEntryList(ctx context.Context, args *ecs.DescribeRouteEntryListArgs) (response *ecs.DescribeRouteEntryListResponse, err error)
+	DescribeRouteEntryPage(ctx context.Context, args *ecs.DescribeRouteEntryListArgs) (response *DescribeRouteEntryPageResponse, err error)
+	DescribeVSwitches(ctx context.Context, args *ecs.DescribeVSwitchesArgs) (vswitches []ecs.VSwitchSetType, pagination *common.PaginationResult, err error)
+	DescribeCommonBandwidthPackages(ctx context.Context, args *DescribeCommonBandwidthPackagesArgs) (packages []CommonBandwidthPackageType, err error)
+	AddCommonBandwidthPackageIp(ctx context.Context, args *AddCommonBandwidthPackageIpArgs) error
+	RemoveCommonBandwidthPackageIp(ctx context.Context, args *RemoveCommonBandwidthPackageIpArgs) error
 }
 
 //WithVPC set vpc id and and route table ids.
@@ -79,61 +86,31 @@ func (r *RoutesClient) WithVPC(ctx context.Context, vpcid string, tableids strin
 
 // ListRoutes lists all managed routes that belong to the specified clusterName
 func (r *RoutesClient) ListRoutes(ctx context.Context, tableid string) (routes []*cloudprovider.Route, err error) {
-
 	klog.Infof("ListRoutes: for route table %s", tableid)
-	// route will be overwritten by getRouteEntryBatch
-	err = r.getRouteEntryBatch(ctx, tableid, "", &routes)
+	routes, err = r.getRouteEntries(ctx, tableid)
 	if err != nil {
 		return []*cloudprovider.Route{},
 			fmt.Errorf("table %s get route entries error ,err %s", tableid, err.Error())
-	} else {
-		return routes, nil
 	}
+	return routes, nil
 }
 
-func (r *RoutesClient) getRouteEntryBatch(ctx context.Context, tableid string, nextToken string, routes *[]*cloudprovider.Route) error {
-
-	args := &ecs.DescribeRouteEntryListArgs{
-		RegionId:       r.region,
-		RouteTableId:   tableid,
-		RouteEntryType: "Custom",
-		NextToken:      nextToken,
-	}
-	response, err := r.client.DescribeRouteEntryList(ctx, args)
-	if err != nil || response == nil {
-		return fmt.Errorf("describe route entry list error, err %v", err)
+// getRouteEntries describe all pages of the custom route entries in table.
+func (r *RoutesClient) getRouteEntries(ctx context.Context, tableid string) ([]*cloudprovider.Route, error) {
+	var routes []*cloudprovider.Route
+	err := r.EachRoutePage(ctx, tableid,
+		func(page []*cloudprovider.Route) error {
+			routes = append(routes, page...)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
 	}
-
-	routeEntries := response.RouteEntrys.RouteEntry
-	if len(routeEntries) <= 0 {
+	if len(routes) <= 0 {
 		klog.Warningf("alicloud: table [%s] has 0 route entry.", tableid)
 	}
-
-	for _, e := range routeEntries {
-
-		//skip none custom route
-		if e.Type != string(ecs.RouteTableCustom) ||
-			// ECMP is not supported yet, skip next hop not equals 1
-			len(e.NextHops.NextHop) != 1 ||
-			// skip none Instance route
-			strings.ToLower(e.NextHops.NextHop[0].NextHopType) != "instance" ||
-			// skip DNAT route
-			e.DestinationCidrBlock == "0.0.0.0/0" {
-			continue
-		}
-
-		route := &cloudprovider.Route{
-			Name:            nodeid(r.region, e.NextHops.NextHop[0].NextHopId),
-			DestinationCIDR: e.DestinationCidrBlock,
-			TargetNode:      types.NodeName(nodeid(r.region, e.NextHops.NextHop[0].NextHopId)),
-		}
-		*routes = append(*routes, route)
-	}
-	// get next batch
-	if response.NextToken != "" {
-		return r.getRouteEntryBatch(ctx, tableid, response.NextToken, routes)
-	}
-	return nil
+	return routes, nil
 }
 
 //RouteTables return all the tables in the vpc network.
@@ -184,7 +161,7 @@ func (r *RoutesClient) CreateRoute(ctx context.Context, tabid string, route *clo
 	}
 
 	args := &ecs.CreateRouteEntryArgs{
-		ClientToken:          "",
+		ClientToken:          routeEntryClientToken(tabid, route),
 		RouteTableId:         tabid,
 		DestinationCidrBlock: route.DestinationCIDR,
 		NextHopType:          ecs.NextHopInstance,
@@ -194,6 +171,13 @@ func (r *RoutesClient) CreateRoute(ctx context.Context, tabid string, route *clo
 	return WaitCreate(ctx, r, tabid, args)
 }
 
+// routeEntryClientToken the ClientToken of a route entry creation, unique to
+// the call so that only its retries get the route entry created before
+func routeEntryClientToken(tabid string, route *cloudprovider.Route) string {
+	return utils.Hash(fmt.Sprintf("%s/%s/%s/%d",
+		tabid, route.DestinationCIDR, route.TargetNode, time.Now().UnixNano()))[:32]
+}
+
 // DeleteRoute deletes the specified managed route
 // Route should be as returned by ListRoutes
 func (r *RoutesClient) DeleteRoute(ctx context.Context, tabid string, route *cloudprovider.Route, region common.Region) error {
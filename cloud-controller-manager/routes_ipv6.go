@@ -0,0 +1,66 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/ecs"
+)
+
+// The ipv6 cidr block of a vpc is not in ecs.VpcSetType of aliyungo, the
+// DescribeVpcs of the vpc api is invoked directly to tell it. A vpc without
+// an ipv6 cidr block, eg. in a region without ipv6 support, rejects the route
+// entries of the ipv6 destinations, the route controller programs the ipv4
+// podCIDRs only.
+
+type VpcIpv6Type struct {
+	VpcId         string
+	Ipv6CidrBlock string
+}
+
+type DescribeVpcsIpv6Response struct {
+	common.Response
+	common.PaginationResult
+	Vpcs struct {
+		Vpc []VpcIpv6Type
+	}
+}
+
+// IPv6Enabled whether the vpc has an ipv6 cidr block
+func (r *RoutesClient) IPv6Enabled(ctx context.Context) (bool, error) {
+	args := &ecs.DescribeVpcsArgs{
+		VpcId:    r.vpc.vpcid,
+		RegionId: common.Region(r.region),
+	}
+	vpcs, err := r.client.DescribeVpcsIpv6(ctx, args)
+	if err != nil {
+		return false, fmt.Errorf("describe ipv6 of vpc %s: %s", r.vpc.vpcid, err.Error())
+	}
+	if len(vpcs) != 1 {
+		return false, fmt.Errorf("alicloud: "+
+			"multiple vpc found by id[%s], length(vpcs)=%d", r.vpc.vpcid, len(vpcs))
+	}
+	return vpcs[0].Ipv6CidrBlock != "", nil
+}
+
+// IPv6RoutesSupported whether the route tables accept the route entries of
+// the ipv6 destinations, see route.IPv6Routes
+func (c *Cloud) IPv6RoutesSupported(ctx context.Context, clusterName string) (bool, error) {
+	return c.climgr.Routes().IPv6Enabled(ctx)
+}
@@ -18,6 +18,15 @@ type RouteStore struct {
 
 	// ecs.RouteTableSetType
 	tables sync.Map
+
+	// ecs.VSwitchSetType
+	vswitches sync.Map
+
+	// CommonBandwidthPackageType
+	bandwidthPackages sync.Map
+
+	// ipv6 cidr block of the vpcs
+	ipv6 sync.Map
 }
 
 func key(region, id string) string {
@@ -28,12 +37,18 @@ var ROUTES = RouteStore{}
 
 type mockRouteSDK struct {
 	describeVpcs                    func(args *ecs.DescribeVpcsArgs) (vpcs []ecs.VpcSetType, pagination *common.PaginationResult, err error)
+	describeVpcsIpv6                func(args *ecs.DescribeVpcsArgs) (vpcs []VpcIpv6Type, err error)
 	describeVRouters                func(args *ecs.DescribeVRoutersArgs) (vrouters []ecs.VRouterSetType, pagination *common.PaginationResult, err error)
 	describeRouteTables             func(args *ecs.DescribeRouteTablesArgs) (routeTables []ecs.RouteTableSetType, pagination *common.PaginationResult, err error)
 	deleteRouteEntry                func(args *ecs.DeleteRouteEntryArgs) error
 	createRouteEntry                func(args *ecs.CreateRouteEntryArgs) error
 	waitForAllRouteEntriesAvailable func(vrouterId string, routeTableId string, timeout int) error
 	describeRouteEntryList          func(args *ecs.DescribeRouteEntryListArgs) (response *ecs.DescribeRouteEntryListResponse, err error)
+	describeRouteEntryPage          func(args *ecs.DescribeRouteEntryListArgs) (response *DescribeRouteEntryPageResponse, err error)
+	describeVSwitches               func(args *ecs.DescribeVSwitchesArgs) (vswitches []ecs.VSwitchSetType, pagination *common.PaginationResult, err error)
+	describeCommonBandwidthPackages func(args *DescribeCommonBandwidthPackagesArgs) (packages []CommonBandwidthPackageType, err error)
+	addCommonBandwidthPackageIp     func(args *AddCommonBandwidthPackageIpArgs) error
+	removeCommonBandwidthPackageIp  func(args *RemoveCommonBandwidthPackageIpArgs) error
 }
 
 func WithNewRouteStore() CloudDataMock {
@@ -66,6 +81,28 @@ func WithVpcs() CloudDataMock {
 	}
 }
 
+// WithVpcIpv6 enable ipv6 of the vpc with the cidr block
+func WithVpcIpv6(cidr string) CloudDataMock {
+	return func() {
+		ROUTES.ipv6.Store(key(string(REGION), VPCID), cidr)
+	}
+}
+
+func WithVSwitches() CloudDataMock {
+	return func() {
+		ROUTES.vswitches.Store(
+			key(string(REGION), VSWITCH_ID),
+			ecs.VSwitchSetType{
+				VSwitchId:               VSWITCH_ID,
+				VpcId:                   VPCID,
+				ZoneId:                  REGION_A,
+				CidrBlock:               "192.168.0.0/24",
+				AvailableIpAddressCount: 250,
+			},
+		)
+	}
+}
+
 func WithVRouter() CloudDataMock {
 	return func() {
 		ROUTES.routers.Store(
@@ -120,6 +157,24 @@ func (m *mockRouteSDK) DescribeVpcs(ctx context.Context, args *ecs.DescribeVpcsA
 	return []ecs.VpcSetType{result}, nil, nil
 }
 
+func (m *mockRouteSDK) DescribeVpcsIpv6(ctx context.Context, args *ecs.DescribeVpcsArgs) (vpcs []VpcIpv6Type, err error) {
+	if m.describeVpcsIpv6 != nil {
+		return m.describeVpcsIpv6(args)
+	}
+	found, _, err := m.DescribeVpcs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	for _, vpc := range found {
+		result := VpcIpv6Type{VpcId: vpc.VpcId}
+		if cidr, ok := ROUTES.ipv6.Load(key(string(args.RegionId), vpc.VpcId)); ok {
+			result.Ipv6CidrBlock = cidr.(string)
+		}
+		vpcs = append(vpcs, result)
+	}
+	return vpcs, nil
+}
+
 func (m *mockRouteSDK) DescribeVRouters(ctx context.Context, args *ecs.DescribeVRoutersArgs) (vrouters []ecs.VRouterSetType, pagination *common.PaginationResult, err error) {
 	if m.describeVRouters != nil {
 		return m.describeVRouters(args)
@@ -266,3 +321,105 @@ func (m *mockRouteSDK) DescribeRouteEntryList(ctx context.Context, args *ecs.Des
 	}
 	return response, nil
 }
+
+// DescribeRouteEntryPage the page of DescribeRouteEntryList, whose TotalCount
+// is left unreported when the entries are mocked by describeRouteEntryList
+func (m *mockRouteSDK) DescribeRouteEntryPage(ctx context.Context, args *ecs.DescribeRouteEntryListArgs) (response *DescribeRouteEntryPageResponse, err error) {
+	if m.describeRouteEntryPage != nil {
+		return m.describeRouteEntryPage(args)
+	}
+	list, err := m.DescribeRouteEntryList(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	response = &DescribeRouteEntryPageResponse{NextToken: list.NextToken}
+	response.RouteEntrys.RouteEntry = list.RouteEntrys.RouteEntry
+	if m.describeRouteEntryList == nil {
+		response.TotalCount = len(list.RouteEntrys.RouteEntry)
+	}
+	return response, nil
+}
+
+func (m *mockRouteSDK) DescribeVSwitches(ctx context.Context, args *ecs.DescribeVSwitchesArgs) (vswitches []ecs.VSwitchSetType, pagination *common.PaginationResult, err error) {
+	if m.describeVSwitches != nil {
+		return m.describeVSwitches(args)
+	}
+	if args.VSwitchId == "" {
+		return nil, nil, fmt.Errorf("no VSwitchId specified")
+	}
+	vsw, ok := ROUTES.vswitches.Load(key(string(args.RegionId), args.VSwitchId))
+	if !ok {
+		return []ecs.VSwitchSetType{}, nil, nil
+	}
+	result, ok := vsw.(ecs.VSwitchSetType)
+	if !ok {
+		return nil, nil, fmt.Errorf("not type ecs.VSwitchSetType %s", reflect.TypeOf(vsw))
+	}
+	return []ecs.VSwitchSetType{result}, nil, nil
+}
+
+// BANDWIDTH_PACKAGE_CAPACITY eips a mocked bandwidth package can hold
+const BANDWIDTH_PACKAGE_CAPACITY = 2
+
+func (m *mockRouteSDK) DescribeCommonBandwidthPackages(ctx context.Context, args *DescribeCommonBandwidthPackagesArgs) (packages []CommonBandwidthPackageType, err error) {
+	if m.describeCommonBandwidthPackages != nil {
+		return m.describeCommonBandwidthPackages(args)
+	}
+	ROUTES.bandwidthPackages.Range(
+		func(key, value interface{}) bool {
+			v := value.(CommonBandwidthPackageType)
+			if args.BandwidthPackageId != "" && args.BandwidthPackageId != v.BandwidthPackageId {
+				return true
+			}
+			packages = append(packages, v)
+			return true
+		},
+	)
+	return packages, nil
+}
+
+func (m *mockRouteSDK) AddCommonBandwidthPackageIp(ctx context.Context, args *AddCommonBandwidthPackageIpArgs) error {
+	if m.addCommonBandwidthPackageIp != nil {
+		return m.addCommonBandwidthPackageIp(args)
+	}
+	v, ok := ROUTES.bandwidthPackages.Load(args.BandwidthPackageId)
+	if !ok {
+		return fmt.Errorf("InvalidBandwidthPackageId.NotFound: %s not found", args.BandwidthPackageId)
+	}
+	bwp := v.(CommonBandwidthPackageType)
+	ips := bwp.PublicIpAddresses.PublicIpAddresse
+	for _, ip := range ips {
+		if ip.AllocationId == args.IpInstanceId {
+			return fmt.Errorf("IpInstanceId.AlreadyInBandwidthPackage: %s", args.IpInstanceId)
+		}
+	}
+	if len(ips) >= BANDWIDTH_PACKAGE_CAPACITY {
+		return fmt.Errorf("QuotaExceeded.PublicIpAddress: bandwidth package %s is full", args.BandwidthPackageId)
+	}
+	bwp.PublicIpAddresses.PublicIpAddresse = append(
+		append([]PublicIpAddresseType{}, ips...),
+		PublicIpAddresseType{AllocationId: args.IpInstanceId},
+	)
+	ROUTES.bandwidthPackages.Store(args.BandwidthPackageId, bwp)
+	return nil
+}
+
+func (m *mockRouteSDK) RemoveCommonBandwidthPackageIp(ctx context.Context, args *RemoveCommonBandwidthPackageIpArgs) error {
+	if m.removeCommonBandwidthPackageIp != nil {
+		return m.removeCommonBandwidthPackageIp(args)
+	}
+	v, ok := ROUTES.bandwidthPackages.Load(args.BandwidthPackageId)
+	if !ok {
+		return fmt.Errorf("InvalidBandwidthPackageId.NotFound: %s not found", args.BandwidthPackageId)
+	}
+	bwp := v.(CommonBandwidthPackageType)
+	var left []PublicIpAddresseType
+	for _, ip := range bwp.PublicIpAddresses.PublicIpAddresse {
+		if ip.AllocationId != args.IpInstanceId {
+			left = append(left, ip)
+		}
+	}
+	bwp.PublicIpAddresses.PublicIpAddresse = left
+	ROUTES.bandwidthPackages.Store(args.BandwidthPackageId, bwp)
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/ecs"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider"
+	"k8s.io/klog"
+	"strings"
+)
+
+// The route entries of a large table span many pages of DescribeRouteEntryList,
+// and a page may come back short while the table is being modified, the
+// entries missed would be taken as absent. The TotalCount of the vpc api,
+// which ecs.DescribeRouteEntryListResponse of aliyungo misses, tells how many
+// entries each page is expected to hold, and a mismatched page is described
+// again. The pages are handed over one by one, so that the route controller
+// does not hold the raw entries of the whole table.
+
+// ROUTE_ENTRY_PAGE_SIZE route entries described per page
+const ROUTE_ENTRY_PAGE_SIZE = 50
+
+// ROUTE_ENTRY_PAGE_RETRIES times a mismatched page is described again
+const ROUTE_ENTRY_PAGE_RETRIES = 3
+
+type DescribeRouteEntryPageResponse struct {
+	common.Response
+	NextToken   string
+	TotalCount  int
+	RouteEntrys struct {
+		RouteEntry []ecs.RouteEntry
+	}
+}
+
+// EachRoutePage call fn with the managed routes of every page of the custom
+// route entries in table, in turn.
+func (r *RoutesClient) EachRoutePage(
+	ctx context.Context, tableid string, fn func(page []*cloudprovider.Route) error,
+) error {
+	var (
+		nextToken string
+		received  int
+		seen      = make(map[string]bool)
+	)
+	for {
+		response, err := r.describeRoutePage(ctx, tableid, nextToken, received)
+		if err != nil {
+			return err
+		}
+		received += len(response.RouteEntrys.RouteEntry)
+		if err := fn(r.routesOf(response.RouteEntrys.RouteEntry)); err != nil {
+			return err
+		}
+		// get next batch
+		if response.NextToken == "" {
+			return nil
+		}
+		if seen[response.NextToken] {
+			return fmt.Errorf("describe route entry list: repeated next token %s", response.NextToken)
+		}
+		seen[response.NextToken] = true
+		nextToken = response.NextToken
+	}
+}
+
+// describeRoutePage describe the page of the token, received is the number
+// of the entries on the former pages.
+func (r *RoutesClient) describeRoutePage(
+	ctx context.Context, tableid, token string, received int,
+) (*DescribeRouteEntryPageResponse, error) {
+	args := &ecs.DescribeRouteEntryListArgs{
+		RegionId:       r.region,
+		RouteTableId:   tableid,
+		RouteEntryType: string(ecs.RouteTableCustom),
+		MaxResult:      ROUTE_ENTRY_PAGE_SIZE,
+		NextToken:      token,
+	}
+	var mismatch error
+	for i := 0; i <= ROUTE_ENTRY_PAGE_RETRIES; i++ {
+		response, err := r.client.DescribeRouteEntryPage(ctx, args)
+		if err != nil || response == nil {
+			return nil, fmt.Errorf("describe route entry list error, err %v", err)
+		}
+		mismatch = pageMismatch(response, received)
+		if mismatch == nil {
+			return response, nil
+		}
+		klog.Warningf("route table %s: %s, describe the page again", tableid, mismatch.Error())
+	}
+	return nil, fmt.Errorf("describe route entry list of table %s: %s after %d retries",
+		tableid, mismatch.Error(), ROUTE_ENTRY_PAGE_RETRIES)
+}
+
+// pageMismatch check the number of the entries on the page against the
+// TotalCount, nil if the page holds as many as expected. A page with
+// entries but no TotalCount is taken as is.
+func pageMismatch(response *DescribeRouteEntryPageResponse, received int) error {
+	count := len(response.RouteEntrys.RouteEntry)
+	if response.TotalCount == 0 && count > 0 {
+		return nil
+	}
+	expected := response.TotalCount - received
+	if response.NextToken != "" && expected > ROUTE_ENTRY_PAGE_SIZE {
+		expected = ROUTE_ENTRY_PAGE_SIZE
+	}
+	if count != expected {
+		return fmt.Errorf("page holds %d entries, expect %d with %d received of total %d",
+			count, expected, received, response.TotalCount)
+	}
+	return nil
+}
+
+// routesOf the managed routes of the entries
+func (r *RoutesClient) routesOf(entries []ecs.RouteEntry) []*cloudprovider.Route {
+	var routes []*cloudprovider.Route
+	for _, e := range entries {
+
+		//skip none custom route
+		if e.Type != string(ecs.RouteTableCustom) ||
+			// ECMP is not supported yet, skip next hop not equals 1
+			len(e.NextHops.NextHop) != 1 ||
+			// skip none Instance route
+			strings.ToLower(e.NextHops.NextHop[0].NextHopType) != "instance" ||
+			// skip DNAT route
+			e.DestinationCidrBlock == "0.0.0.0/0" || e.DestinationCidrBlock == "::/0" {
+			continue
+		}
+
+		route := &cloudprovider.Route{
+			Name:            nodeid(r.region, e.NextHops.NextHop[0].NextHopId),
+			DestinationCIDR: e.DestinationCidrBlock,
+			TargetNode:      types.NodeName(nodeid(r.region, e.NextHops.NextHop[0].NextHopId)),
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// ListRoutePages call fn with the managed routes of every page of the table,
+// see route.PagedRoutes
+func (c *Cloud) ListRoutePages(
+	ctx context.Context, clusterName string, tableid string, fn func(page []*cloudprovider.Route) error,
+) error {
+	klog.Infof("ListRoutePages: for route table %s", tableid)
+	return c.climgr.Routes().EachRoutePage(ctx, tableid, fn)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/ecs"
+	"k8s.io/cloud-provider"
+	"strings"
+	"testing"
+)
+
+// pagedRouteSDK mock the pages of the route entries, short lists the entries
+// dropped from a page on each describe of it
+type pagedRouteSDK struct {
+	total     int
+	reported  int
+	short     map[string][]int
+	describes map[string]int
+}
+
+func (p *pagedRouteSDK) describePage(args *ecs.DescribeRouteEntryListArgs) (*DescribeRouteEntryPageResponse, error) {
+	if args.MaxResult != ROUTE_ENTRY_PAGE_SIZE {
+		return nil, fmt.Errorf("expect page size %d, got %d", ROUTE_ENTRY_PAGE_SIZE, args.MaxResult)
+	}
+	begin := 0
+	if args.NextToken != "" {
+		if _, err := fmt.Sscanf(args.NextToken, "page-%d", &begin); err != nil {
+			return nil, fmt.Errorf("unknown next token %s", args.NextToken)
+		}
+	}
+	end := begin + args.MaxResult
+	response := &DescribeRouteEntryPageResponse{TotalCount: p.reported}
+	if end < p.total {
+		response.NextToken = fmt.Sprintf("page-%d", end)
+	} else {
+		end = p.total
+	}
+	attempt := p.describes[args.NextToken]
+	p.describes[args.NextToken]++
+	if dropped := p.short[args.NextToken]; attempt < len(dropped) {
+		end -= dropped[attempt]
+	}
+	for i := begin; i < end; i++ {
+		response.RouteEntrys.RouteEntry = append(
+			response.RouteEntrys.RouteEntry,
+			ecs.RouteEntry{
+				DestinationCidrBlock: fmt.Sprintf("172.%d.%d.0/24", 16+i/256, i%256),
+				RouteTableId:         args.RouteTableId,
+				Type:                 string(ecs.RouteTableCustom),
+				NextHops: struct {
+					NextHop []ecs.NextHop
+				}{
+					NextHop: []ecs.NextHop{{NextHopId: fmt.Sprintf("i-%d", i), NextHopType: "Instance"}},
+				},
+			},
+		)
+	}
+	return response, nil
+}
+
+func TestEachRoutePage(t *testing.T) {
+	cases := []struct {
+		name     string
+		reported int
+		// short the entries dropped from a page on each describe of it
+		short     map[string][]int
+		describes map[string]int
+		pages     int
+		routes    int
+		err       string
+	}{
+		{
+			name:      "consistent pages",
+			reported:  120,
+			describes: map[string]int{"": 1, "page-50": 1, "page-100": 1},
+			pages:     3,
+			routes:    120,
+		},
+		{
+			name:      "short page described again",
+			reported:  120,
+			short:     map[string][]int{"page-50": {1, 3}},
+			describes: map[string]int{"": 1, "page-50": 3, "page-100": 1},
+			pages:     3,
+			routes:    120,
+		},
+		{
+			name:      "short last page described again",
+			reported:  120,
+			short:     map[string][]int{"page-100": {20}},
+			describes: map[string]int{"": 1, "page-50": 1, "page-100": 2},
+			pages:     3,
+			routes:    120,
+		},
+		{
+			name:      "total count unreported",
+			reported:  0,
+			short:     map[string][]int{"page-50": {1}},
+			describes: map[string]int{"": 1, "page-50": 1, "page-100": 1},
+			pages:     3,
+			routes:    119,
+		},
+		{
+			name:      "mismatch persists",
+			reported:  121,
+			describes: map[string]int{"": 1, "page-50": 1, "page-100": 1 + ROUTE_ENTRY_PAGE_RETRIES},
+			pages:     2,
+			routes:    100,
+			err:       "page holds 20 entries, expect 21",
+		},
+	}
+	for _, c := range cases {
+		sdk := &pagedRouteSDK{total: 120, reported: c.reported, short: c.short, describes: map[string]int{}}
+		cmgr, err := NewMockRouteMgr("")
+		if err != nil {
+			t.Fatal("failed to create client manager")
+		}
+		cmgr.routes.client = &mockRouteSDK{describeRouteEntryPage: sdk.describePage}
+
+		pages, routes := 0, 0
+		err = cmgr.Routes().EachRoutePage(context.Background(), ROUTE_TABLE_ID,
+			func(page []*cloudprovider.Route) error {
+				pages++
+				routes += len(page)
+				return nil
+			},
+		)
+		if c.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err.Error())
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expect error [%s], got %v", c.name, c.err, err)
+		}
+		if pages != c.pages {
+			t.Errorf("%s: expect %d pages handed over, got %d", c.name, c.pages, pages)
+		}
+		if routes != c.routes {
+			t.Errorf("%s: expect %d routes, got %d", c.name, c.routes, routes)
+		}
+		for token, n := range c.describes {
+			if sdk.describes[token] != n {
+				t.Errorf("%s: expect page [%s] described %d times, got %d", c.name, token, n, sdk.describes[token])
+			}
+		}
+	}
+}
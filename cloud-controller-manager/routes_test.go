@@ -19,6 +19,9 @@ package alicloud
 import (
 	"context"
 	"fmt"
+	"github.com/denverdino/aliyungo/ecs"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider"
 	"strings"
 	"testing"
 )
@@ -72,6 +75,149 @@ func TestListRoutes(t *testing.T) {
 	}
 }
 
+func TestListRoutesPagination(t *testing.T) {
+	cmgr, err := NewMockRouteMgr("")
+	if err != nil {
+		t.Fatal("failed to create client manager")
+	}
+	PreSetCloudData(
+		WithNewRouteStore(),
+		WithVpcs(),
+		WithVRouter(),
+	)
+
+	// three pages of route entries
+	pages := map[string]struct {
+		cidrs []string
+		next  string
+	}{
+		"":       {cidrs: []string{"172.16.0.0/24", "172.16.1.0/24"}, next: "page-2"},
+		"page-2": {cidrs: []string{"172.16.2.0/24", "172.16.3.0/24"}, next: "page-3"},
+		"page-3": {cidrs: []string{"172.16.4.0/24"}, next: ""},
+	}
+	describe, create := 0, 0
+	var tokens []string
+	sdk := &mockRouteSDK{
+		describeRouteEntryList: func(args *ecs.DescribeRouteEntryListArgs) (*ecs.DescribeRouteEntryListResponse, error) {
+			describe++
+			page, ok := pages[args.NextToken]
+			if !ok {
+				return nil, fmt.Errorf("unknown next token %s", args.NextToken)
+			}
+			response := &ecs.DescribeRouteEntryListResponse{NextToken: page.next}
+			for i, cidr := range page.cidrs {
+				response.RouteEntrys.RouteEntry = append(
+					response.RouteEntrys.RouteEntry,
+					ecs.RouteEntry{
+						DestinationCidrBlock: cidr,
+						RouteTableId:         args.RouteTableId,
+						Type:                 string(ecs.RouteTableCustom),
+						NextHops: struct {
+							NextHop []ecs.NextHop
+						}{
+							NextHop: []ecs.NextHop{
+								{NextHopId: fmt.Sprintf("i-%s-%d", args.NextToken, i), NextHopType: "Instance"},
+							},
+						},
+					},
+				)
+			}
+			return response, nil
+		},
+		createRouteEntry: func(args *ecs.CreateRouteEntryArgs) error {
+			create++
+			tokens = append(tokens, args.ClientToken)
+			return nil
+		},
+	}
+	cmgr.routes.client = sdk
+
+	routes, err := cmgr.Routes().ListRoutes(context.Background(), ROUTE_TABLE_ID)
+	if err != nil {
+		t.Fatalf("failed to list routes, %v", err)
+	}
+	if len(routes) != 5 {
+		t.Fatalf("expect 5 routes from 3 pages, got %d", len(routes))
+	}
+	if describe != 3 {
+		t.Fatalf("expect 3 describe calls, got %d", describe)
+	}
+
+	// route table is not cached beyond the reconcile pass
+	if _, err := cmgr.Routes().ListRoutes(context.Background(), ROUTE_TABLE_ID); err != nil {
+		t.Fatalf("failed to list routes, %v", err)
+	}
+	if describe != 6 {
+		t.Fatalf("expect route table described again, got %d describe calls", describe)
+	}
+
+	// routes on every page exist, no create call should be issued
+	for _, r := range routes {
+		_, instance, err := nodeFromProviderID(string(r.TargetNode))
+		if err != nil {
+			t.Fatalf("unexpected target node %s", r.TargetNode)
+		}
+		route := &cloudprovider.Route{
+			DestinationCIDR: r.DestinationCIDR,
+			TargetNode:      types.NodeName(instance),
+		}
+		if err := cmgr.Routes().CreateRoute(context.Background(), ROUTE_TABLE_ID, route, REGION, VPCID); err != nil {
+			t.Fatalf("create route: %s", err.Error())
+		}
+	}
+	if create != 0 {
+		t.Fatalf("expect no duplicate create call, got %d", create)
+	}
+
+	// route deleted out of band since listed is created again
+	pages[""] = struct {
+		cidrs []string
+		next  string
+	}{}
+	route := &cloudprovider.Route{DestinationCIDR: routes[0].DestinationCIDR, TargetNode: "i--0"}
+	if err := cmgr.Routes().CreateRoute(context.Background(), ROUTE_TABLE_ID, route, REGION, VPCID); err != nil {
+		t.Fatalf("create route: %s", err.Error())
+	}
+	if create != 1 {
+		t.Fatalf("expect route deleted out of band created, got %d create calls", create)
+	}
+	// the creation is retried on the server errors by its client token only
+	if err := cmgr.Routes().CreateRoute(context.Background(), ROUTE_TABLE_ID, route, REGION, VPCID); err != nil {
+		t.Fatalf("create route: %s", err.Error())
+	}
+	if len(tokens) != 2 || tokens[0] == "" || tokens[0] == tokens[1] {
+		t.Fatalf("expect a client token of each creation, got %v", tokens)
+	}
+}
+
+func TestIPv6Enabled(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mocks  []CloudDataMock
+		expect bool
+	}{
+		{name: "vpc without ipv6", mocks: []CloudDataMock{WithNewRouteStore(), WithVpcs()}},
+		{
+			name:   "vpc with ipv6",
+			mocks:  []CloudDataMock{WithNewRouteStore(), WithVpcs(), WithVpcIpv6("2408:4005:3a0::/56")},
+			expect: true,
+		},
+	} {
+		PreSetCloudData(tc.mocks...)
+		cmgr, err := NewMockRouteMgr("")
+		if err != nil {
+			t.Fatalf("%s: failed to create client manager, %v", tc.name, err)
+		}
+		enabled, err := cmgr.Routes().IPv6Enabled(context.Background())
+		if err != nil {
+			t.Fatalf("%s: ipv6 enabled, %v", tc.name, err)
+		}
+		if enabled != tc.expect {
+			t.Fatalf("%s: expect ipv6 enabled %t, got %t", tc.name, tc.expect, enabled)
+		}
+	}
+}
+
 func testCamel(t *testing.T, original, expected string) {
 	converted := replaceCamel(normalizePrefix(original))
 	if converted != expected {
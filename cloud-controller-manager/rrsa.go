@@ -0,0 +1,178 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"k8s.io/klog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// RRSA (RAM roles for service accounts) exchanges the projected service
+// account token for sts credentials by AssumeRoleWithOIDC, which needs no
+// accesskey. It is enabled by the env vars injected by the rrsa webhook and
+// goes before the accesskey and the ram role of the instance.
+
+const (
+	ENV_ROLE_ARN          = "ALIBABA_CLOUD_ROLE_ARN"
+	ENV_OIDC_PROVIDER_ARN = "ALIBABA_CLOUD_OIDC_PROVIDER_ARN"
+	ENV_OIDC_TOKEN_FILE   = "ALIBABA_CLOUD_OIDC_TOKEN_FILE"
+	ENV_ROLE_SESSION_NAME = "ALIBABA_CLOUD_ROLE_SESSION_NAME"
+	ENV_STS_ENDPOINT      = "ALIBABA_CLOUD_STS_ENDPOINT"
+)
+
+// DEFAULT_STS_ENDPOINT sts endpoint when ALIBABA_CLOUD_STS_ENDPOINT is absent
+var DEFAULT_STS_ENDPOINT = "https://sts.aliyuncs.com"
+
+// RRSA_DURATION lifetime of the sts credentials requested
+var RRSA_DURATION = time.Hour
+
+// RRSA_REFRESH_MARGIN the credentials are exchanged again when they expire
+// within the margin. It is longer than TOKEN_RESYNC_PERIOD so that the
+// clients are given new credentials before the current ones expire.
+var RRSA_REFRESH_MARGIN = 2 * TOKEN_RESYNC_PERIOD
+
+// OIDCToken implement rrsa auth, the sts credentials are cached until they
+// are about to expire.
+type OIDCToken struct {
+	RoleArn         string
+	OIDCProviderArn string
+	TokenFile       string
+	SessionName     string
+	Endpoint        string
+
+	client     *http.Client
+	token      *Token
+	expiration time.Time
+}
+
+// NewOIDCTokenFromEnv return the rrsa auth configured by env, nil if rrsa is
+// not enabled.
+func NewOIDCTokenFromEnv() *OIDCToken {
+	role, provider, file := os.Getenv(ENV_ROLE_ARN), os.Getenv(ENV_OIDC_PROVIDER_ARN), os.Getenv(ENV_OIDC_TOKEN_FILE)
+	if role == "" || provider == "" || file == "" {
+		return nil
+	}
+	token := &OIDCToken{
+		RoleArn:         role,
+		OIDCProviderArn: provider,
+		TokenFile:       file,
+		SessionName:     os.Getenv(ENV_ROLE_SESSION_NAME),
+		Endpoint:        os.Getenv(ENV_STS_ENDPOINT),
+	}
+	if token.SessionName == "" {
+		token.SessionName = "cloud-controller-manager"
+	}
+	if token.Endpoint == "" {
+		token.Endpoint = DEFAULT_STS_ENDPOINT
+	}
+	if !strings.Contains(token.Endpoint, "://") {
+		token.Endpoint = "https://" + token.Endpoint
+	}
+	return token
+}
+
+func (f *OIDCToken) NextToken() (*Token, error) {
+	if f.token != nil && time.Until(f.expiration) > RRSA_REFRESH_MARGIN {
+		return f.token, nil
+	}
+	token, expiration, err := f.assumeRole()
+	if err != nil {
+		// the cached credentials are kept until they expire
+		if f.token != nil && time.Now().Before(f.expiration) {
+			klog.Warningf("rrsa: refresh sts credentials expiring at %s: %s", f.expiration, err.Error())
+			return f.token, nil
+		}
+		return nil, err
+	}
+	klog.V(2).Infof("rrsa: sts credentials of role %s refreshed, expiring at %s", f.RoleArn, expiration)
+	f.token, f.expiration = token, expiration
+	return f.token, nil
+}
+
+type assumeRoleWithOIDCResponse struct {
+	RequestId   string
+	Code        string
+	Message     string
+	Credentials struct {
+		AccessKeyId     string
+		AccessKeySecret string
+		SecurityToken   string
+		Expiration      string
+	}
+}
+
+// assumeRole exchange the service account token for sts credentials, the
+// token file is read on every exchange as it is rotated by kubelet.
+func (f *OIDCToken) assumeRole() (*Token, time.Time, error) {
+	oidc, err := ioutil.ReadFile(f.TokenFile)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("read oidc token file: %s", err.Error())
+	}
+	form := url.Values{}
+	form.Set("Action", "AssumeRoleWithOIDC")
+	form.Set("Format", "JSON")
+	form.Set("Version", "2015-04-01")
+	form.Set("Timestamp", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	form.Set("RoleArn", f.RoleArn)
+	form.Set("OIDCProviderArn", f.OIDCProviderArn)
+	form.Set("OIDCToken", strings.TrimSpace(string(oidc)))
+	form.Set("RoleSessionName", f.SessionName)
+	form.Set("DurationSeconds", fmt.Sprintf("%d", int(RRSA_DURATION.Seconds())))
+
+	client := f.client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.PostForm(f.Endpoint, form)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("assume role %s with oidc: %s", f.RoleArn, err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("read sts response: %s", err.Error())
+	}
+	result := &assumeRoleWithOIDCResponse{}
+	if err := json.Unmarshal(body, result); err != nil {
+		return nil, time.Time{}, fmt.Errorf("unmarshal sts response: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("assume role %s with oidc: %s %s, request id %s",
+			f.RoleArn, result.Code, result.Message, result.RequestId)
+	}
+	credentials := result.Credentials
+	if credentials.AccessKeyId == "" || credentials.AccessKeySecret == "" || credentials.SecurityToken == "" {
+		return nil, time.Time{}, fmt.Errorf("assume role %s with oidc: empty credentials, request id %s",
+			f.RoleArn, result.RequestId)
+	}
+	expiration, err := time.Parse(time.RFC3339, credentials.Expiration)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("parse expiration of sts credentials: %s", err.Error())
+	}
+	return &Token{
+		AccessKey:    credentials.AccessKeyId,
+		AccessSecret: credentials.AccessKeySecret,
+		Token:        credentials.SecurityToken,
+	}, expiration, nil
+}
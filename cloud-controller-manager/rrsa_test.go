@@ -0,0 +1,173 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	RRSA_ROLE_ARN     = "acs:ram::1234567890:role/ccm"
+	RRSA_PROVIDER_ARN = "acs:ram::1234567890:oidc-provider/ack-rrsa-c123"
+)
+
+// fakeSTS an sts endpoint issuing credentials expiring after the lifetime
+type fakeSTS struct {
+	server   *httptest.Server
+	lifetime time.Duration
+	calls    int
+	fail     bool
+	tokens   []string
+}
+
+func newFakeSTS(t *testing.T) *fakeSTS {
+	sts := &fakeSTS{lifetime: time.Hour}
+	sts.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse sts request: %s", err.Error())
+		}
+		if r.Form.Get("Action") != "AssumeRoleWithOIDC" ||
+			r.Form.Get("RoleArn") != RRSA_ROLE_ARN || r.Form.Get("OIDCProviderArn") != RRSA_PROVIDER_ARN {
+			t.Errorf("unexpected sts request %v", r.Form)
+		}
+		sts.calls++
+		sts.tokens = append(sts.tokens, r.Form.Get("OIDCToken"))
+		if sts.fail {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"RequestId":"req-1","Code":"AuthenticationFail.OIDCToken.Expired","Message":"expired"}`)
+			return
+		}
+		resp := assumeRoleWithOIDCResponse{RequestId: fmt.Sprintf("req-%d", sts.calls)}
+		resp.Credentials.AccessKeyId = fmt.Sprintf("STS.key-%d", sts.calls)
+		resp.Credentials.AccessKeySecret = "secret"
+		resp.Credentials.SecurityToken = fmt.Sprintf("token-%d", sts.calls)
+		resp.Credentials.Expiration = time.Now().Add(sts.lifetime).UTC().Format(time.RFC3339)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Errorf("encode sts response: %s", err.Error())
+		}
+	}))
+	return sts
+}
+
+func setRRSAEnv(t *testing.T, endpoint, file string) func() {
+	envs := map[string]string{
+		ENV_ROLE_ARN:          RRSA_ROLE_ARN,
+		ENV_OIDC_PROVIDER_ARN: RRSA_PROVIDER_ARN,
+		ENV_OIDC_TOKEN_FILE:   file,
+		ENV_STS_ENDPOINT:      endpoint,
+	}
+	for k, v := range envs {
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatalf("set env %s: %s", k, err.Error())
+		}
+	}
+	return func() {
+		for k := range envs {
+			_ = os.Unsetenv(k)
+		}
+	}
+}
+
+func TestOIDCToken(t *testing.T) {
+	sts := newFakeSTS(t)
+	defer sts.server.Close()
+	dir, err := ioutil.TempDir("", "rrsa")
+	if err != nil {
+		t.Fatalf("temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(file, []byte("oidc-1\n"), 0600); err != nil {
+		t.Fatalf("write token file: %s", err.Error())
+	}
+	defer setRRSAEnv(t, sts.server.URL, file)()
+
+	oidc := NewOIDCTokenFromEnv()
+	if oidc == nil {
+		t.Fatalf("expect rrsa enabled by env")
+	}
+	token, err := oidc.NextToken()
+	if err != nil {
+		t.Fatalf("next token: %s", err.Error())
+	}
+	if token.AccessKey != "STS.key-1" || token.Token != "token-1" || sts.tokens[0] != "oidc-1" {
+		t.Fatalf("unexpected sts credentials %+v, oidc token %v", token, sts.tokens)
+	}
+
+	// cached while far from the expiration
+	if token, err = oidc.NextToken(); err != nil || token.Token != "token-1" || sts.calls != 1 {
+		t.Fatalf("expect cached credentials, got %+v, %v, %d calls", token, err, sts.calls)
+	}
+
+	// refreshed before the expiration with the rotated token
+	if err := ioutil.WriteFile(file, []byte("oidc-2"), 0600); err != nil {
+		t.Fatalf("write token file: %s", err.Error())
+	}
+	oidc.expiration = time.Now().Add(RRSA_REFRESH_MARGIN / 2)
+	if token, err = oidc.NextToken(); err != nil || token.Token != "token-2" || sts.tokens[1] != "oidc-2" {
+		t.Fatalf("expect refreshed credentials, got %+v, %v, oidc token %v", token, err, sts.tokens)
+	}
+
+	// a failed refresh keeps the credentials not expired yet
+	sts.fail = true
+	oidc.expiration = time.Now().Add(RRSA_REFRESH_MARGIN / 2)
+	if token, err = oidc.NextToken(); err != nil || token.Token != "token-2" {
+		t.Fatalf("expect the cached credentials kept, got %+v, %v", token, err)
+	}
+	oidc.expiration = time.Now().Add(-time.Minute)
+	if _, err = oidc.NextToken(); err == nil {
+		t.Fatalf("expect the failure once the credentials expired")
+	}
+}
+
+func TestTokenAuthOrder(t *testing.T) {
+	uid := cfg.Global.UID
+	defer func() { cfg.Global.UID = uid }()
+	cfg.Global.UID = ""
+
+	if _, ok := newTokenAuth("", "", nil).(*RamRoleToken); !ok {
+		t.Errorf("expect ramrole auth without ak")
+	}
+	if _, ok := newTokenAuth("key", "secret", nil).(*AkAuthToken); !ok {
+		t.Errorf("expect ak auth")
+	}
+	cfg.Global.UID = "1234567890"
+	if _, ok := newTokenAuth("key", "secret", nil).(*ServiceToken); !ok {
+		t.Errorf("expect service account auth with uid")
+	}
+
+	defer setRRSAEnv(t, "sts.cn-hangzhou.aliyuncs.com", "/var/run/secrets/tokens/oidc-token")()
+	auth, ok := newTokenAuth("key", "secret", nil).(*OIDCToken)
+	if !ok {
+		t.Fatalf("expect rrsa auth going before the ak")
+	}
+	if auth.Endpoint != "https://sts.cn-hangzhou.aliyuncs.com" || auth.SessionName != "cloud-controller-manager" {
+		t.Errorf("unexpected rrsa auth %+v", auth)
+	}
+	_ = os.Unsetenv(ENV_OIDC_TOKEN_FILE)
+	if _, ok := newTokenAuth("key", "secret", nil).(*OIDCToken); ok {
+		t.Errorf("expect rrsa disabled without the token file")
+	}
+}
@@ -0,0 +1,511 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/slb"
+	"sync"
+)
+
+// A sync of a service describes the loadbalancer, its listeners, tags and
+// vserver groups over and over, finding it, diffing the listeners and the
+// backends and checking the tags. The sync carries an SLBSnapshot in its
+// context instead, the first describe of each of them is kept and served to
+// the following ones, and a mutation drops only the parts it changes, eg.
+// a listener updated drops the attribute of that listener and the listener
+// ports of the loadbalancer. The snapshot lives within a single sync, the
+// concurrent syncs of the other services never share it.
+
+type slbSnapshotKey struct{}
+
+// SLBSnapshot the describe results of the loadbalancers within a sync
+type SLBSnapshot struct {
+	lock sync.Mutex
+	// loadbalancers the attributes by loadbalancer id
+	loadbalancers map[string]*slb.LoadBalancerType
+	// listeners the listener attributes by loadbalancer id, then port and protocol
+	listeners map[string]map[string]interface{}
+	// tags the tags by loadbalancer id, then the describe args
+	tags map[string]map[string]snapshotTags
+	// vgroups the vserver groups by loadbalancer id, then the describe args
+	vgroups map[string]map[string]*slb.DescribeVServerGroupsResponse
+	// vgroupAttributes the vserver group attributes by vserver group id
+	vgroupAttributes map[string]*slb.DescribeVServerGroupAttributeResponse
+}
+
+type snapshotTags struct {
+	tags       []slb.TagItemType
+	pagination *common.PaginationResult
+}
+
+// WithSLBSnapshot the context of a sync, whose describe calls of the
+// loadbalancers are served by a snapshot. The snapshot of ctx is kept if any.
+func WithSLBSnapshot(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(slbSnapshotKey{}).(*SLBSnapshot); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, slbSnapshotKey{}, &SLBSnapshot{
+		loadbalancers:    make(map[string]*slb.LoadBalancerType),
+		listeners:        make(map[string]map[string]interface{}),
+		tags:             make(map[string]map[string]snapshotTags),
+		vgroups:          make(map[string]map[string]*slb.DescribeVServerGroupsResponse),
+		vgroupAttributes: make(map[string]*slb.DescribeVServerGroupAttributeResponse),
+	})
+}
+
+// withoutSLBSnapshot the context of a sync describing the loadbalancers on
+// every call
+func withoutSLBSnapshot(ctx context.Context) context.Context {
+	return context.WithValue(ctx, slbSnapshotKey{}, (*SLBSnapshot)(nil))
+}
+
+func slbSnapshotOf(ctx context.Context) *SLBSnapshot {
+	if ctx == nil {
+		return nil
+	}
+	snap, _ := ctx.Value(slbSnapshotKey{}).(*SLBSnapshot)
+	return snap
+}
+
+// withSnapshot the client of the sync, whose describe calls are served by the
+// snapshot of ctx. s is returned if ctx has no snapshot.
+func (s *LoadBalancerClient) withSnapshot(ctx context.Context) *LoadBalancerClient {
+	snap := slbSnapshotOf(ctx)
+	if snap == nil {
+		return s
+	}
+	if _, ok := s.c.(*snapshotSLB); ok {
+		return s
+	}
+	client := *s
+	client.c = &snapshotSLB{ClientSLBSDK: s.c, snap: snap}
+	return &client
+}
+
+func snapshotListenerKey(proto string, port int) string {
+	return fmt.Sprintf("%d/%s", port, proto)
+}
+
+// dropListener drop the attributes of the listeners on the port, whatever
+// the protocol, and the listener ports of the loadbalancer
+func (snap *SLBSnapshot) dropListener(lbid string, port int) {
+	snap.lock.Lock()
+	defer snap.lock.Unlock()
+	delete(snap.loadbalancers, lbid)
+	for key := range snap.listeners[lbid] {
+		var p int
+		if _, err := fmt.Sscanf(key, "%d/", &p); err == nil && p == port {
+			delete(snap.listeners[lbid], key)
+		}
+	}
+}
+
+func (snap *SLBSnapshot) dropLoadBalancer(lbid string) {
+	snap.lock.Lock()
+	defer snap.lock.Unlock()
+	delete(snap.loadbalancers, lbid)
+}
+
+func (snap *SLBSnapshot) dropTags(lbid string) {
+	snap.lock.Lock()
+	defer snap.lock.Unlock()
+	delete(snap.tags, lbid)
+}
+
+// dropVGroups drop the vserver groups of all the loadbalancers, as the
+// deletion of a vserver group does not tell its loadbalancer, and the
+// attribute of the vserver group
+func (snap *SLBSnapshot) dropVGroups(vgroupid string) {
+	snap.lock.Lock()
+	defer snap.lock.Unlock()
+	snap.vgroups = make(map[string]map[string]*slb.DescribeVServerGroupsResponse)
+	delete(snap.vgroupAttributes, vgroupid)
+}
+
+func (snap *SLBSnapshot) dropVGroupAttribute(vgroupid string) {
+	snap.lock.Lock()
+	defer snap.lock.Unlock()
+	delete(snap.vgroupAttributes, vgroupid)
+}
+
+func (snap *SLBSnapshot) drop(lbid string) {
+	snap.lock.Lock()
+	defer snap.lock.Unlock()
+	delete(snap.loadbalancers, lbid)
+	delete(snap.listeners, lbid)
+	delete(snap.tags, lbid)
+	delete(snap.vgroups, lbid)
+}
+
+// listener the attribute of the listener, described by describe on a miss.
+// describe returns an untyped nil for the listener not found.
+func (snap *SLBSnapshot) listener(
+	lbid, proto string, port int,
+	describe func() (interface{}, error),
+) (interface{}, error) {
+	key := snapshotListenerKey(proto, port)
+	snap.lock.Lock()
+	cached, ok := snap.listeners[lbid][key]
+	snap.lock.Unlock()
+	if ok {
+		return cached, nil
+	}
+	// the listener not found is not kept
+	response, err := describe()
+	if err != nil || response == nil {
+		return nil, err
+	}
+	snap.lock.Lock()
+	defer snap.lock.Unlock()
+	if snap.listeners[lbid] == nil {
+		snap.listeners[lbid] = make(map[string]interface{})
+	}
+	snap.listeners[lbid][key] = response
+	return response, nil
+}
+
+// snapshotSLB the slb sdk of a sync, the describe calls are served by the
+// snapshot and the mutations drop the parts they change. The responses are
+// copied so that the callers never modify the snapshot.
+type snapshotSLB struct {
+	ClientSLBSDK
+	snap *SLBSnapshot
+}
+
+func (c *snapshotSLB) DescribeLoadBalancerAttribute(ctx context.Context, loadBalancerId string) (*slb.LoadBalancerType, error) {
+	c.snap.lock.Lock()
+	cached, ok := c.snap.loadbalancers[loadBalancerId]
+	c.snap.lock.Unlock()
+	if !ok {
+		lb, err := c.ClientSLBSDK.DescribeLoadBalancerAttribute(ctx, loadBalancerId)
+		if err != nil || lb == nil {
+			return lb, err
+		}
+		c.snap.lock.Lock()
+		c.snap.loadbalancers[loadBalancerId] = lb
+		c.snap.lock.Unlock()
+		cached = lb
+	}
+	lb := *cached
+	return &lb, nil
+}
+
+func (c *snapshotSLB) DescribeLoadBalancerHTTPSListenerAttribute(ctx context.Context, loadBalancerId string, port int) (*slb.DescribeLoadBalancerHTTPSListenerAttributeResponse, error) {
+	cached, err := c.snap.listener(loadBalancerId, "https", port, func() (interface{}, error) {
+		response, err := c.ClientSLBSDK.DescribeLoadBalancerHTTPSListenerAttribute(ctx, loadBalancerId, port)
+		if response == nil {
+			return nil, err
+		}
+		return response, err
+	})
+	if err != nil || cached == nil {
+		return nil, err
+	}
+	response := *cached.(*slb.DescribeLoadBalancerHTTPSListenerAttributeResponse)
+	return &response, nil
+}
+
+func (c *snapshotSLB) DescribeLoadBalancerTCPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (*slb.DescribeLoadBalancerTCPListenerAttributeResponse, error) {
+	cached, err := c.snap.listener(loadBalancerId, "tcp", port, func() (interface{}, error) {
+		response, err := c.ClientSLBSDK.DescribeLoadBalancerTCPListenerAttribute(ctx, loadBalancerId, port)
+		if response == nil {
+			return nil, err
+		}
+		return response, err
+	})
+	if err != nil || cached == nil {
+		return nil, err
+	}
+	response := *cached.(*slb.DescribeLoadBalancerTCPListenerAttributeResponse)
+	return &response, nil
+}
+
+func (c *snapshotSLB) DescribeLoadBalancerUDPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (*slb.DescribeLoadBalancerUDPListenerAttributeResponse, error) {
+	cached, err := c.snap.listener(loadBalancerId, "udp", port, func() (interface{}, error) {
+		response, err := c.ClientSLBSDK.DescribeLoadBalancerUDPListenerAttribute(ctx, loadBalancerId, port)
+		if response == nil {
+			return nil, err
+		}
+		return response, err
+	})
+	if err != nil || cached == nil {
+		return nil, err
+	}
+	response := *cached.(*slb.DescribeLoadBalancerUDPListenerAttributeResponse)
+	return &response, nil
+}
+
+func (c *snapshotSLB) DescribeLoadBalancerHTTPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (*slb.DescribeLoadBalancerHTTPListenerAttributeResponse, error) {
+	cached, err := c.snap.listener(loadBalancerId, "http", port, func() (interface{}, error) {
+		response, err := c.ClientSLBSDK.DescribeLoadBalancerHTTPListenerAttribute(ctx, loadBalancerId, port)
+		if response == nil {
+			return nil, err
+		}
+		return response, err
+	})
+	if err != nil || cached == nil {
+		return nil, err
+	}
+	response := *cached.(*slb.DescribeLoadBalancerHTTPListenerAttributeResponse)
+	return &response, nil
+}
+
+func (c *snapshotSLB) DescribeTags(ctx context.Context, args *slb.DescribeTagsArgs) ([]slb.TagItemType, *common.PaginationResult, error) {
+	// the tags of all the loadbalancers, eg. found by the tags, are not kept
+	if args.LoadBalancerID == "" {
+		return c.ClientSLBSDK.DescribeTags(ctx, args)
+	}
+	key := fmt.Sprintf("%+v", *args)
+	c.snap.lock.Lock()
+	cached, ok := c.snap.tags[args.LoadBalancerID][key]
+	c.snap.lock.Unlock()
+	if !ok {
+		tags, pagination, err := c.ClientSLBSDK.DescribeTags(ctx, args)
+		if err != nil {
+			return tags, pagination, err
+		}
+		cached = snapshotTags{tags: tags, pagination: pagination}
+		c.snap.lock.Lock()
+		if c.snap.tags[args.LoadBalancerID] == nil {
+			c.snap.tags[args.LoadBalancerID] = make(map[string]snapshotTags)
+		}
+		c.snap.tags[args.LoadBalancerID][key] = cached
+		c.snap.lock.Unlock()
+	}
+	tags := append([]slb.TagItemType{}, cached.tags...)
+	if cached.pagination == nil {
+		return tags, nil, nil
+	}
+	pagination := *cached.pagination
+	return tags, &pagination, nil
+}
+
+func (c *snapshotSLB) DescribeVServerGroups(ctx context.Context, args *slb.DescribeVServerGroupsArgs) (*slb.DescribeVServerGroupsResponse, error) {
+	key := fmt.Sprintf("%+v", *args)
+	c.snap.lock.Lock()
+	cached, ok := c.snap.vgroups[args.LoadBalancerId][key]
+	c.snap.lock.Unlock()
+	if !ok {
+		response, err := c.ClientSLBSDK.DescribeVServerGroups(ctx, args)
+		if err != nil || response == nil {
+			return response, err
+		}
+		c.snap.lock.Lock()
+		if c.snap.vgroups[args.LoadBalancerId] == nil {
+			c.snap.vgroups[args.LoadBalancerId] = make(map[string]*slb.DescribeVServerGroupsResponse)
+		}
+		c.snap.vgroups[args.LoadBalancerId][key] = response
+		c.snap.lock.Unlock()
+		cached = response
+	}
+	response := *cached
+	return &response, nil
+}
+
+func (c *snapshotSLB) DescribeVServerGroupAttribute(ctx context.Context, args *slb.DescribeVServerGroupAttributeArgs) (*slb.DescribeVServerGroupAttributeResponse, error) {
+	c.snap.lock.Lock()
+	cached, ok := c.snap.vgroupAttributes[args.VServerGroupId]
+	c.snap.lock.Unlock()
+	if !ok {
+		response, err := c.ClientSLBSDK.DescribeVServerGroupAttribute(ctx, args)
+		if err != nil || response == nil {
+			return response, err
+		}
+		c.snap.lock.Lock()
+		c.snap.vgroupAttributes[args.VServerGroupId] = response
+		c.snap.lock.Unlock()
+		cached = response
+	}
+	response := *cached
+	return &response, nil
+}
+
+// the mutations of the loadbalancers
+
+func (c *snapshotSLB) SetLoadBalancerName(ctx context.Context, loadBalancerId string, loadBalancerName string) error {
+	defer c.snap.dropLoadBalancer(loadBalancerId)
+	return c.ClientSLBSDK.SetLoadBalancerName(ctx, loadBalancerId, loadBalancerName)
+}
+
+func (c *snapshotSLB) DeleteLoadBalancer(ctx context.Context, loadBalancerId string) error {
+	defer c.snap.drop(loadBalancerId)
+	return c.ClientSLBSDK.DeleteLoadBalancer(ctx, loadBalancerId)
+}
+
+func (c *snapshotSLB) SetLoadBalancerDeleteProtection(ctx context.Context, args *slb.SetLoadBalancerDeleteProtectionArgs) error {
+	defer c.snap.dropLoadBalancer(args.LoadBalancerId)
+	return c.ClientSLBSDK.SetLoadBalancerDeleteProtection(ctx, args)
+}
+
+func (c *snapshotSLB) ModifyLoadBalancerInstanceSpec(ctx context.Context, args *slb.ModifyLoadBalancerInstanceSpecArgs) error {
+	defer c.snap.dropLoadBalancer(args.LoadBalancerId)
+	return c.ClientSLBSDK.ModifyLoadBalancerInstanceSpec(ctx, args)
+}
+
+func (c *snapshotSLB) ModifyLoadBalancerInternetSpec(ctx context.Context, args *slb.ModifyLoadBalancerInternetSpecArgs) error {
+	defer c.snap.dropLoadBalancer(args.LoadBalancerId)
+	return c.ClientSLBSDK.ModifyLoadBalancerInternetSpec(ctx, args)
+}
+
+func (c *snapshotSLB) ModifyLoadBalancerInstanceChargeType(ctx context.Context, args *ModifyLoadBalancerInstanceChargeTypeArgs) error {
+	defer c.snap.dropLoadBalancer(args.LoadBalancerId)
+	return c.ClientSLBSDK.ModifyLoadBalancerInstanceChargeType(ctx, args)
+}
+
+func (c *snapshotSLB) SetLoadBalancerModificationProtection(ctx context.Context, args *slb.SetLoadBalancerModificationProtectionArgs) error {
+	defer c.snap.dropLoadBalancer(args.LoadBalancerId)
+	return c.ClientSLBSDK.SetLoadBalancerModificationProtection(ctx, args)
+}
+
+func (c *snapshotSLB) RemoveBackendServers(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) ([]slb.BackendServerType, error) {
+	defer c.snap.dropLoadBalancer(loadBalancerId)
+	return c.ClientSLBSDK.RemoveBackendServers(ctx, loadBalancerId, backendServers)
+}
+
+func (c *snapshotSLB) AddBackendServers(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) ([]slb.BackendServerType, error) {
+	defer c.snap.dropLoadBalancer(loadBalancerId)
+	return c.ClientSLBSDK.AddBackendServers(ctx, loadBalancerId, backendServers)
+}
+
+// the mutations of the listeners
+
+func (c *snapshotSLB) StopLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) error {
+	defer c.snap.dropListener(loadBalancerId, port)
+	return c.ClientSLBSDK.StopLoadBalancerListener(ctx, loadBalancerId, port)
+}
+
+func (c *snapshotSLB) StartLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) error {
+	defer c.snap.dropListener(loadBalancerId, port)
+	return c.ClientSLBSDK.StartLoadBalancerListener(ctx, loadBalancerId, port)
+}
+
+func (c *snapshotSLB) DeleteLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) error {
+	defer c.snap.dropListener(loadBalancerId, port)
+	return c.ClientSLBSDK.DeleteLoadBalancerListener(ctx, loadBalancerId, port)
+}
+
+func (c *snapshotSLB) CreateLoadBalancerTCPListener(ctx context.Context, args *slb.CreateLoadBalancerTCPListenerArgs) error {
+	defer c.snap.dropListener(args.LoadBalancerId, args.ListenerPort)
+	return c.ClientSLBSDK.CreateLoadBalancerTCPListener(ctx, args)
+}
+
+func (c *snapshotSLB) CreateLoadBalancerUDPListener(ctx context.Context, args *slb.CreateLoadBalancerUDPListenerArgs) error {
+	defer c.snap.dropListener(args.LoadBalancerId, args.ListenerPort)
+	return c.ClientSLBSDK.CreateLoadBalancerUDPListener(ctx, args)
+}
+
+func (c *snapshotSLB) CreateLoadBalancerHTTPSListener(ctx context.Context, args *slb.CreateLoadBalancerHTTPSListenerArgs) error {
+	defer c.snap.dropListener(args.LoadBalancerId, args.ListenerPort)
+	return c.ClientSLBSDK.CreateLoadBalancerHTTPSListener(ctx, args)
+}
+
+func (c *snapshotSLB) CreateLoadBalancerHTTPListener(ctx context.Context, args *slb.CreateLoadBalancerHTTPListenerArgs) error {
+	defer c.snap.dropListener(args.LoadBalancerId, args.ListenerPort)
+	return c.ClientSLBSDK.CreateLoadBalancerHTTPListener(ctx, args)
+}
+
+func (c *snapshotSLB) SetLoadBalancerHTTPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerHTTPListenerAttributeArgs) error {
+	defer c.snap.dropListener(args.LoadBalancerId, args.ListenerPort)
+	return c.ClientSLBSDK.SetLoadBalancerHTTPListenerAttribute(ctx, args)
+}
+
+func (c *snapshotSLB) SetLoadBalancerHTTPSListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerHTTPSListenerAttributeArgs) error {
+	defer c.snap.dropListener(args.LoadBalancerId, args.ListenerPort)
+	return c.ClientSLBSDK.SetLoadBalancerHTTPSListenerAttribute(ctx, args)
+}
+
+func (c *snapshotSLB) SetLoadBalancerTCPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerTCPListenerAttributeArgs) error {
+	defer c.snap.dropListener(args.LoadBalancerId, args.ListenerPort)
+	return c.ClientSLBSDK.SetLoadBalancerTCPListenerAttribute(ctx, args)
+}
+
+func (c *snapshotSLB) SetLoadBalancerUDPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerUDPListenerAttributeArgs) error {
+	defer c.snap.dropListener(args.LoadBalancerId, args.ListenerPort)
+	return c.ClientSLBSDK.SetLoadBalancerUDPListenerAttribute(ctx, args)
+}
+
+func (c *snapshotSLB) SetListenerExtension(ctx context.Context, proto string, args *SetListenerExtensionArgs) error {
+	defer c.snap.dropListener(args.LoadBalancerId, args.ListenerPort)
+	return c.ClientSLBSDK.SetListenerExtension(ctx, proto, args)
+}
+
+func (c *snapshotSLB) SetHTTPSListenerCertificates(ctx context.Context, args *SetListenerCertificatesArgs) error {
+	defer c.snap.dropListener(args.LoadBalancerId, args.ListenerPort)
+	return c.ClientSLBSDK.SetHTTPSListenerCertificates(ctx, args)
+}
+
+func (c *snapshotSLB) CreateDomainExtension(ctx context.Context, args *CreateDomainExtensionArgs) (*CreateDomainExtensionResponse, error) {
+	defer c.snap.dropListener(args.LoadBalancerId, args.ListenerPort)
+	return c.ClientSLBSDK.CreateDomainExtension(ctx, args)
+}
+
+func (c *snapshotSLB) DeleteDomainExtension(ctx context.Context, args *DeleteDomainExtensionArgs) error {
+	// the domain extension does not tell its listener, drop all of them
+	defer func() {
+		c.snap.lock.Lock()
+		defer c.snap.lock.Unlock()
+		c.snap.listeners = make(map[string]map[string]interface{})
+	}()
+	return c.ClientSLBSDK.DeleteDomainExtension(ctx, args)
+}
+
+// the mutations of the tags
+
+func (c *snapshotSLB) RemoveTags(ctx context.Context, args *slb.RemoveTagsArgs) error {
+	defer c.snap.dropTags(args.LoadBalancerID)
+	return c.ClientSLBSDK.RemoveTags(ctx, args)
+}
+
+func (c *snapshotSLB) AddTags(ctx context.Context, args *slb.AddTagsArgs) error {
+	defer c.snap.dropTags(args.LoadBalancerID)
+	return c.ClientSLBSDK.AddTags(ctx, args)
+}
+
+// the mutations of the vserver groups
+
+func (c *snapshotSLB) CreateVServerGroup(ctx context.Context, args *slb.CreateVServerGroupArgs) (*slb.CreateVServerGroupResponse, error) {
+	defer c.snap.dropVGroups(args.VServerGroupId)
+	return c.ClientSLBSDK.CreateVServerGroup(ctx, args)
+}
+
+func (c *snapshotSLB) DeleteVServerGroup(ctx context.Context, args *slb.DeleteVServerGroupArgs) (*slb.DeleteVServerGroupResponse, error) {
+	defer c.snap.dropVGroups(args.VServerGroupId)
+	return c.ClientSLBSDK.DeleteVServerGroup(ctx, args)
+}
+
+func (c *snapshotSLB) SetVServerGroupAttribute(ctx context.Context, args *slb.SetVServerGroupAttributeArgs) (*slb.SetVServerGroupAttributeResponse, error) {
+	defer c.snap.dropVGroups(args.VServerGroupId)
+	return c.ClientSLBSDK.SetVServerGroupAttribute(ctx, args)
+}
+
+func (c *snapshotSLB) ModifyVServerGroupBackendServers(ctx context.Context, args *slb.ModifyVServerGroupBackendServersArgs) (*slb.ModifyVServerGroupBackendServersResponse, error) {
+	defer c.snap.dropVGroupAttribute(args.VServerGroupId)
+	return c.ClientSLBSDK.ModifyVServerGroupBackendServers(ctx, args)
+}
+
+func (c *snapshotSLB) AddVServerGroupBackendServers(ctx context.Context, args *slb.AddVServerGroupBackendServersArgs) (*slb.AddVServerGroupBackendServersResponse, error) {
+	defer c.snap.dropVGroupAttribute(args.VServerGroupId)
+	return c.ClientSLBSDK.AddVServerGroupBackendServers(ctx, args)
+}
+
+func (c *snapshotSLB) RemoveVServerGroupBackendServers(ctx context.Context, args *slb.RemoveVServerGroupBackendServersArgs) (*slb.RemoveVServerGroupBackendServersResponse, error) {
+	defer c.snap.dropVGroupAttribute(args.VServerGroupId)
+	return c.ClientSLBSDK.RemoveVServerGroupBackendServers(ctx, args)
+}
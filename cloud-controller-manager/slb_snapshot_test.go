@@ -0,0 +1,183 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"testing"
+)
+
+// describeOperations the describe calls served by the snapshot
+var describeOperations = []string{
+	"DescribeLoadBalancerAttribute",
+	"DescribeLoadBalancerTCPListenerAttribute",
+	"DescribeLoadBalancerUDPListenerAttribute",
+	"DescribeLoadBalancerHTTPListenerAttribute",
+	"DescribeLoadBalancerHTTPSListenerAttribute",
+	"DescribeTags",
+	"DescribeVServerGroups",
+	"DescribeVServerGroupAttribute",
+}
+
+func TestSLBSnapshotDescribeCalls(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(stateService(map[string]string{})).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Describe calls of a full sync",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			faults := f.SLBFaults()
+			// the sync of the unchanged loadbalancer goes through the listeners and the backends
+			sync := func(ctx context.Context) (int, error) {
+				faults.Reset()
+				ctx = context.WithValue(ctx, utils.ContextForceResync, true)
+				if _, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+					return 0, err
+				}
+				return len(faults.Calls(describeOperations...)), nil
+			}
+			before, err := sync(withoutSLBSnapshot(context.Background()))
+			if err != nil {
+				return err
+			}
+			after, err := sync(context.Background())
+			if err != nil {
+				return err
+			}
+			t.Logf("describe calls of a sync: %d without the snapshot, %d with it", before, after)
+			if after*3 > before*2 {
+				return fmt.Errorf("expect the describe calls cut by a third at least, %d -> %d", before, after)
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return nil
+		},
+	)
+}
+
+func TestSLBSnapshotInvalidation(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(stateService(map[string]string{})).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Mutations drop the parts they change",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			ctx := WithSLBSnapshot(context.Background())
+			client := f.LoadBalancer().withSnapshot(ctx).c
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			faults := f.SLBFaults()
+			faults.Reset()
+			count := func(operation string) int { return len(faults.Calls(operation)) }
+
+			describe := func() error {
+				if _, err := client.DescribeLoadBalancerAttribute(ctx, lb.LoadBalancerId); err != nil {
+					return err
+				}
+				if _, err := client.DescribeLoadBalancerTCPListenerAttribute(ctx, lb.LoadBalancerId, 80); err != nil {
+					return err
+				}
+				if _, err := client.DescribeLoadBalancerTCPListenerAttribute(ctx, lb.LoadBalancerId, 443); err != nil {
+					return err
+				}
+				_, _, err := client.DescribeTags(ctx, &slb.DescribeTagsArgs{LoadBalancerID: lb.LoadBalancerId})
+				return err
+			}
+			for i := 0; i < 2; i++ {
+				if err := describe(); err != nil {
+					return err
+				}
+			}
+			if count("DescribeLoadBalancerAttribute") != 1 ||
+				count("DescribeLoadBalancerTCPListenerAttribute") != 2 ||
+				count("DescribeTags") != 1 {
+				return fmt.Errorf("expect each described once, got %v", faults.Operations())
+			}
+
+			// the listener 80 updated, its attribute and the listener ports are described again
+			if err := client.SetLoadBalancerTCPListenerAttribute(ctx, &slb.SetLoadBalancerTCPListenerAttributeArgs{
+				LoadBalancerId: lb.LoadBalancerId, ListenerPort: 80, Description: "updated",
+			}); err != nil {
+				return err
+			}
+			if err := describe(); err != nil {
+				return err
+			}
+			if count("DescribeLoadBalancerAttribute") != 2 ||
+				count("DescribeLoadBalancerTCPListenerAttribute") != 3 ||
+				count("DescribeTags") != 1 {
+				return fmt.Errorf("expect only the listener 80 and the loadbalancer described again, got %v", faults.Operations())
+			}
+			response, err := client.DescribeLoadBalancerTCPListenerAttribute(ctx, lb.LoadBalancerId, 80)
+			if err != nil {
+				return err
+			}
+			if response.Description != "updated" {
+				return fmt.Errorf("expect the updated listener described, got %s", response.Description)
+			}
+
+			// the tags added, only the tags are described again
+			if err := client.AddTags(ctx, &slb.AddTagsArgs{
+				LoadBalancerID: lb.LoadBalancerId, Tags: `[{"TagKey":"k","TagValue":"v"}]`,
+			}); err != nil {
+				return err
+			}
+			if err := describe(); err != nil {
+				return err
+			}
+			if count("DescribeLoadBalancerAttribute") != 2 || count("DescribeTags") != 2 {
+				return fmt.Errorf("expect only the tags described again, got %v", faults.Operations())
+			}
+
+			// another sync never sees the snapshot
+			other := f.LoadBalancer().withSnapshot(WithSLBSnapshot(context.Background())).c
+			if _, err := other.DescribeLoadBalancerAttribute(context.Background(), lb.LoadBalancerId); err != nil {
+				return err
+			}
+			if count("DescribeLoadBalancerAttribute") != 3 {
+				return fmt.Errorf("expect the snapshot not shared, got %v", faults.Operations())
+			}
+			return nil
+		},
+	)
+}
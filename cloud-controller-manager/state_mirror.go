@@ -0,0 +1,332 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Telling which loadbalancer a service owns, its listeners and why its last
+// sync failed takes the logs or the slb console. The state mirror keeps a
+// summary of each service in a configmap instead, readable by kubectl:
+//
+//   kubectl -n kube-system get cm ccm-loadbalancer-state -o jsonpath='{.data.default_my-service}'
+//
+// The summary is built after each sync from the SLBSnapshot of the sync, so
+// that it costs no describe call but those of the parts the sync changed.
+// The configmap is written only when the summary changes, and the entry of a
+// service is removed once its loadbalancer is deleted. The keys are
+// namespace_name, as neither of them may contain an underscore.
+
+// LoadBalancerStateOptions options of the state mirror
+type LoadBalancerStateOptions struct {
+	// ConfigMap namespace/name of the configmap mirroring the states, empty disables the mirror
+	ConfigMap string
+}
+
+// StateOptions global options of the state mirror, disabled by default
+var StateOptions = LoadBalancerStateOptions{}
+
+// ValidateLoadBalancerStateOptions check the options of the state mirror
+func ValidateLoadBalancerStateOptions(options LoadBalancerStateOptions) error {
+	if options.ConfigMap == "" {
+		return nil
+	}
+	if _, _, err := options.configMap(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (o LoadBalancerStateOptions) configMap() (string, string, error) {
+	parts := strings.Split(o.ConfigMap, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("loadbalancer state configmap %q must be namespace/name", o.ConfigMap)
+	}
+	return parts[0], parts[1], nil
+}
+
+// LoadBalancerState the state of the loadbalancer of a service mirrored
+type LoadBalancerState struct {
+	LoadBalancerId string          `json:"loadBalancerId,omitempty"`
+	Address        string          `json:"address,omitempty"`
+	Listeners      []ListenerState `json:"listeners,omitempty"`
+	// Backends count of the backends, -1 if unknown
+	Backends  int    `json:"backends"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// ListenerState a listener of the loadbalancer mirrored
+type ListenerState struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	// HealthCheck type of the health check, off if it is disabled
+	HealthCheck string `json:"healthCheck"`
+}
+
+func stateKey(svc *v1.Service) string {
+	return fmt.Sprintf("%s_%s", svc.Namespace, svc.Name)
+}
+
+// syncObservation the loadbalancer id and the backend count published by a
+// sync, observed on their way to the service controller
+type syncObservation struct {
+	lock     sync.Mutex
+	lbid     string
+	backends int
+}
+
+func observeSync(ctx context.Context) (context.Context, *syncObservation) {
+	o := &syncObservation{backends: -1}
+	parent := ctx
+	ctx = context.WithValue(ctx, utils.ContextLoadBalancerId, func(id string) {
+		o.lock.Lock()
+		o.lbid = id
+		o.lock.Unlock()
+		utils.PublishLoadBalancerId(parent, id)
+	})
+	ctx = context.WithValue(ctx, utils.ContextBackends, func(count int) {
+		o.lock.Lock()
+		o.backends = count
+		o.lock.Unlock()
+		utils.PublishBackends(parent, count)
+	})
+	return ctx, o
+}
+
+// loadBalancerState the state of the loadbalancer after the sync, described
+// by the snapshot of ctx. What fails to be described is left out.
+func (c *Cloud) loadBalancerState(
+	ctx context.Context,
+	service *v1.Service,
+	observed *syncObservation,
+	status *v1.LoadBalancerStatus,
+	err error,
+) *LoadBalancerState {
+	observed.lock.Lock()
+	state := &LoadBalancerState{LoadBalancerId: observed.lbid, Backends: observed.backends}
+	observed.lock.Unlock()
+	if err != nil {
+		state.LastError = err.Error()
+	}
+	if status != nil && len(status.Ingress) > 0 {
+		state.Address = status.Ingress[0].IP
+		if state.Address == "" {
+			state.Address = status.Ingress[0].Hostname
+		}
+	}
+	if state.LoadBalancerId == "" || isNLB(service) {
+		return state
+	}
+	lbc := c.climgr.LoadBalancers().withSnapshot(ctx)
+	lb, derr := lbc.c.DescribeLoadBalancerAttribute(ctx, state.LoadBalancerId)
+	if derr != nil || lb == nil {
+		klog.V(4).Infof("state of loadbalancer %s of service %s: describe: %v",
+			state.LoadBalancerId, serviceKey(service), derr)
+		return state
+	}
+	if state.Address == "" {
+		state.Address = lb.Address
+	}
+	for _, l := range lb.ListenerPortsAndProtocol.ListenerPortAndProtocol {
+		check, derr := listenerHealthCheck(ctx, lbc.c, lb.LoadBalancerId, l)
+		if derr != nil {
+			klog.V(4).Infof("state of loadbalancer %s of service %s: describe listener %d: %s",
+				lb.LoadBalancerId, serviceKey(service), l.ListenerPort, derr.Error())
+		}
+		state.Listeners = append(state.Listeners, ListenerState{
+			Port:        l.ListenerPort,
+			Protocol:    l.ListenerProtocol,
+			HealthCheck: check,
+		})
+	}
+	sort.Slice(state.Listeners, func(i, j int) bool {
+		if state.Listeners[i].Port != state.Listeners[j].Port {
+			return state.Listeners[i].Port < state.Listeners[j].Port
+		}
+		return state.Listeners[i].Protocol < state.Listeners[j].Protocol
+	})
+	return state
+}
+
+// listenerHealthCheck the type of the health check of the listener, off if
+// it is disabled and empty if unknown
+func listenerHealthCheck(
+	ctx context.Context,
+	client ClientSLBSDK,
+	lbid string,
+	l slb.ListenerPortAndProtocolType,
+) (string, error) {
+	enabled := func(flag slb.FlagType, check string) string {
+		if flag == slb.OffFlag {
+			return string(slb.OffFlag)
+		}
+		return check
+	}
+	switch strings.ToLower(l.ListenerProtocol) {
+	case "tcp":
+		response, err := client.DescribeLoadBalancerTCPListenerAttribute(ctx, lbid, l.ListenerPort)
+		if err != nil || response == nil {
+			return "", err
+		}
+		if response.HealthCheckType == "" {
+			return string(slb.TCPHealthCheckType), nil
+		}
+		return string(response.HealthCheckType), nil
+	case "udp":
+		response, err := client.DescribeLoadBalancerUDPListenerAttribute(ctx, lbid, l.ListenerPort)
+		if err != nil || response == nil {
+			return "", err
+		}
+		return enabled(response.HealthCheck, "udp"), nil
+	case "http":
+		response, err := client.DescribeLoadBalancerHTTPListenerAttribute(ctx, lbid, l.ListenerPort)
+		if err != nil || response == nil {
+			return "", err
+		}
+		return enabled(response.HealthCheck, "http"), nil
+	case "https":
+		response, err := client.DescribeLoadBalancerHTTPSListenerAttribute(ctx, lbid, l.ListenerPort)
+		if err != nil || response == nil {
+			return "", err
+		}
+		return enabled(response.HealthCheck, "http"), nil
+	}
+	return "", nil
+}
+
+// stateMirror write the states to the configmap of StateOptions, one writer
+// at a time
+type stateMirror struct {
+	lock sync.Mutex
+}
+
+// set the state of the service, kept as it is unless the sync found the
+// loadbalancer: a sync failing early keeps the former state with its error.
+// The configmap is written only if the state changes.
+func (m *stateMirror) set(ctx context.Context, client kubernetes.Interface, svc *v1.Service, state *LoadBalancerState) error {
+	return m.mutate(ctx, client, func(data map[string]string) bool {
+		key := stateKey(svc)
+		if state.LoadBalancerId == "" && state.LastError != "" && data[key] != "" {
+			former := &LoadBalancerState{}
+			if err := json.Unmarshal([]byte(data[key]), former); err == nil {
+				former.LastError = state.LastError
+				state = former
+			}
+		}
+		value, err := json.Marshal(state)
+		if err != nil || data[key] == string(value) {
+			return false
+		}
+		data[key] = string(value)
+		return true
+	})
+}
+
+// remove the state of the service
+func (m *stateMirror) remove(ctx context.Context, client kubernetes.Interface, svc *v1.Service) error {
+	return m.mutate(ctx, client, func(data map[string]string) bool {
+		if _, ok := data[stateKey(svc)]; !ok {
+			return false
+		}
+		delete(data, stateKey(svc))
+		return true
+	})
+}
+
+// mutate the data of the configmap, created if not found, and write it if
+// change returns true
+func (m *stateMirror) mutate(ctx context.Context, client kubernetes.Interface, change func(map[string]string) bool) error {
+	namespace, name, err := StateOptions.configMap()
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			data := map[string]string{}
+			if !change(data) {
+				return nil
+			}
+			cm = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+				Data:       data,
+			}
+			_, err = client.CoreV1().ConfigMaps(namespace).Create(ctx, cm, metav1.CreateOptions{})
+			if errors.IsAlreadyExists(err) {
+				// created by a former writer, retried as a conflict
+				return errors.NewConflict(v1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		cm = cm.DeepCopy()
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if !change(cm.Data) {
+			return nil
+		}
+		_, err = client.CoreV1().ConfigMaps(namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// mirrorState mirror the state of the service after its sync, a noop unless
+// the mirror is enabled. The failure to mirror never fails the sync.
+func (c *Cloud) mirrorState(
+	ctx context.Context,
+	service *v1.Service,
+	observed *syncObservation,
+	status *v1.LoadBalancerStatus,
+	err error,
+) {
+	if StateOptions.ConfigMap == "" || c.kclient == nil {
+		return
+	}
+	state := c.loadBalancerState(ctx, service, observed, status, err)
+	if err := c.mirror.set(ctx, c.kclient, service, state); err != nil {
+		klog.Warningf("mirror the loadbalancer state of service %s: %s", serviceKey(service), err.Error())
+	}
+}
+
+// unmirrorState remove the state of the service whose loadbalancer is deleted
+func (c *Cloud) unmirrorState(ctx context.Context, service *v1.Service) {
+	if StateOptions.ConfigMap == "" || c.kclient == nil {
+		return
+	}
+	if err := c.mirror.remove(ctx, c.kclient, service); err != nil {
+		klog.Warningf("remove the loadbalancer state of service %s: %s", serviceKey(service), err.Error())
+	}
+}
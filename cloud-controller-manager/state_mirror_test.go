@@ -0,0 +1,176 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"strings"
+	"testing"
+)
+
+func TestValidateLoadBalancerStateOptions(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"":                                   true,
+		"kube-system/ccm-loadbalancer-state": true,
+		"ccm-loadbalancer-state":             false,
+		"kube-system/":                       false,
+		"a/b/c":                              false,
+	} {
+		err := ValidateLoadBalancerStateOptions(LoadBalancerStateOptions{ConfigMap: value})
+		if (err == nil) != valid {
+			t.Errorf("%q: expect valid %t, got %v", value, valid, err)
+		}
+	}
+}
+
+func TestLoadBalancerStateMirror(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-service",
+				Namespace:   "default",
+				UID:         types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Name: "tcp", Port: 80, TargetPort: intstr.FromInt(80), Protocol: v1.ProtocolTCP, NodePort: 30080},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+	StateOptions = LoadBalancerStateOptions{ConfigMap: "kube-system/ccm-loadbalancer-state"}
+	defer func() { StateOptions = LoadBalancerStateOptions{} }()
+
+	f.RunCustomized(t, "Loadbalancer state mirrored", func(f *FrameWork) error {
+		client := f.Cloud.kclient.(*fake.Clientset)
+		// the writes of the configmap so far
+		writes := func() int {
+			count := 0
+			for _, action := range client.Actions() {
+				if action.GetResource().Resource == "configmaps" &&
+					(action.GetVerb() == "create" || action.GetVerb() == "update") {
+					count++
+				}
+			}
+			return count
+		}
+		// the state mirrored of the service, nil if absent
+		mirrored := func() (*LoadBalancerState, error) {
+			cm, err := client.CoreV1().ConfigMaps("kube-system").Get(
+				context.Background(), "ccm-loadbalancer-state", metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			value, ok := cm.Data["default_my-service"]
+			if !ok {
+				return nil, nil
+			}
+			state := &LoadBalancerState{}
+			return state, json.Unmarshal([]byte(value), state)
+		}
+		ensure := func() error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			return err
+		}
+
+		if err := ensure(); err != nil {
+			return err
+		}
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return fmt.Errorf("find loadbalancer: %v", err)
+		}
+		state, err := mirrored()
+		if err != nil || state == nil {
+			return fmt.Errorf("expect the state mirrored at creation, got %v", err)
+		}
+		if state.LoadBalancerId != lb.LoadBalancerId || state.Address == "" || state.LastError != "" ||
+			len(state.Listeners) != 1 || state.Listeners[0].Port != 80 || state.Listeners[0].HealthCheck != "tcp" {
+			return fmt.Errorf("unexpected state mirrored at creation: %+v", state)
+		}
+		if writes() != 1 {
+			return fmt.Errorf("expect the configmap created once, got %d writes", writes())
+		}
+
+		// nothing changed, nothing written
+		if err := ensure(); err != nil {
+			return err
+		}
+		if writes() != 1 {
+			return fmt.Errorf("expect the unchanged state not written, got %d writes", writes())
+		}
+
+		f.SVC.Spec.Ports = append(f.SVC.Spec.Ports,
+			v1.ServicePort{Name: "udp", Port: 53, TargetPort: intstr.FromInt(53), Protocol: v1.ProtocolUDP, NodePort: 30053})
+		if err := ensure(); err != nil {
+			return err
+		}
+		state, err = mirrored()
+		if err != nil || state == nil || len(state.Listeners) != 2 ||
+			state.Listeners[0].Port != 53 || state.Listeners[0].Protocol != "udp" {
+			return fmt.Errorf("expect the listener added mirrored, got %+v, %v", state, err)
+		}
+		if writes() != 2 {
+			return fmt.Errorf("expect the changed state written once, got %d writes", writes())
+		}
+
+		// the error of a sync failing early is mirrored with the former state
+		f.SVC.Annotations[ServiceAnnotationLoadBalancerListenerBandwidth] = "abc"
+		if err := ensure(); err == nil {
+			return fmt.Errorf("expect the invalid annotation rejected")
+		}
+		state, err = mirrored()
+		if err != nil || state == nil || state.LoadBalancerId != lb.LoadBalancerId ||
+			len(state.Listeners) != 2 || !strings.Contains(state.LastError, "abc") {
+			return fmt.Errorf("expect the error mirrored with the former state, got %+v, %v", state, err)
+		}
+		delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerListenerBandwidth)
+		if err := ensure(); err != nil {
+			return err
+		}
+		if state, err = mirrored(); err != nil || state == nil || state.LastError != "" {
+			return fmt.Errorf("expect the error cleared once synced, got %+v, %v", state, err)
+		}
+
+		if err := f.CloudImpl().EnsureLoadBalancerDeleted(context.Background(), CLUSTER_ID, f.SVC); err != nil {
+			return err
+		}
+		if state, err = mirrored(); err != nil || state != nil {
+			return fmt.Errorf("expect the state removed once deleted, got %+v, %v", state, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// A named targetPort, eg. "http", is resolved by each pod to its container
+// port. The endpoints controller groups the addresses by the resolved ports
+// into subsets, the port of an eni backend is looked up there by the name of
+// the service port. Pods of different versions may resolve the name to
+// different ports, each backend is added with its own one.
+
+// backendPort port of the vserver group of the service port. It is the target
+// port for eni backends, the node port otherwise. The vserver group of a named
+// target port is keyed by the node port, its backends are of various ports,
+// or by the service port once the node ports are not allocated.
+func backendPort(service *v1.Service, port v1.ServicePort) int32 {
+	if !IsENIBackendType(service) {
+		return port.NodePort
+	}
+	if isNamedTargetPort(port) {
+		if port.NodePort == 0 {
+			return port.Port
+		}
+		return port.NodePort
+	}
+	return port.TargetPort.IntVal
+}
+
+func isNamedTargetPort(port v1.ServicePort) bool {
+	return port.TargetPort.Type == intstr.String
+}
+
+// resolveTargetPorts container ports of the endpoint addresses by ip for the
+// service port of name.
+func resolveTargetPorts(eps *v1.Endpoints, name string) map[string]int {
+	ports := map[string]int{}
+	if eps == nil {
+		return ports
+	}
+	for _, sub := range eps.Subsets {
+		for _, p := range sub.Ports {
+			if p.Name != name {
+				continue
+			}
+			for _, addr := range sub.Addresses {
+				ports[addr.IP] = int(p.Port)
+			}
+		}
+	}
+	return ports
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"testing"
+)
+
+// subset of the addresses resolving the port of name to number
+func portSubset(name string, number int32, ips ...string) v1.EndpointSubset {
+	sub := v1.EndpointSubset{Ports: []v1.EndpointPort{{Name: name, Port: number}}}
+	for _, ip := range ips {
+		sub.Addresses = append(sub.Addresses, v1.EndpointAddress{IP: ip})
+	}
+	return sub
+}
+
+func TestBackendPort(t *testing.T) {
+	eni := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{utils.BACKEND_TYPE_LABEL: utils.BACKEND_TYPE_ENI},
+		},
+	}
+	ecs := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	numeric := v1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080), NodePort: 30080}
+	named := v1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromString("http"), NodePort: 30080}
+
+	if port := backendPort(ecs, numeric); port != 30080 {
+		t.Fatalf("expect node port for ecs backends, got %d", port)
+	}
+	if port := backendPort(eni, numeric); port != 8080 {
+		t.Fatalf("expect target port for eni backends, got %d", port)
+	}
+	if port := backendPort(eni, named); port != 30080 {
+		t.Fatalf("expect node port for eni backends of named target port, got %d", port)
+	}
+}
+
+func TestResolveTargetPorts(t *testing.T) {
+	cases := []struct {
+		name   string
+		eps    *v1.Endpoints
+		expect map[string]int
+	}{
+		{
+			name:   "numeric",
+			eps:    &v1.Endpoints{Subsets: []v1.EndpointSubset{portSubset("", 8080, "10.0.0.1", "10.0.0.2")}},
+			expect: map[string]int{"10.0.0.1": 8080, "10.0.0.2": 8080},
+		},
+		{
+			name:   "named-uniform",
+			eps:    &v1.Endpoints{Subsets: []v1.EndpointSubset{portSubset("http", 8080, "10.0.0.1", "10.0.0.2")}},
+			expect: map[string]int{"10.0.0.1": 8080, "10.0.0.2": 8080},
+		},
+		{
+			name: "named-divergent",
+			eps: &v1.Endpoints{Subsets: []v1.EndpointSubset{
+				portSubset("http", 8080, "10.0.0.1"),
+				portSubset("http", 9090, "10.0.0.2"),
+				portSubset("metrics", 9100, "10.0.0.3"),
+			}},
+			expect: map[string]int{"10.0.0.1": 8080, "10.0.0.2": 9090},
+		},
+	}
+	for _, c := range cases {
+		name := "http"
+		if c.name == "numeric" {
+			name = ""
+		}
+		if ports := resolveTargetPorts(c.eps, name); !reflect.DeepEqual(ports, c.expect) {
+			t.Errorf("%s: expect ports %v, got %v", c.name, c.expect, ports)
+		}
+	}
+	if ports := resolveTargetPorts(nil, "http"); len(ports) != 0 {
+		t.Errorf("expect no ports for nil endpoints, got %v", ports)
+	}
+}
+
+func TestNamedTargetPort(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					utils.BACKEND_TYPE_LABEL: utils.BACKEND_TYPE_ENI,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{
+						Name:       "http",
+						Port:       listenPort1,
+						TargetPort: intstr.FromString("http"),
+						Protocol:   v1.ProtocolTCP,
+						NodePort:   nodePort1,
+					},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	).WithEndpoints(
+		// pods of two versions, resolving http to different ports
+		&v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-service", Namespace: "default"},
+			Subsets: []v1.EndpointSubset{
+				portSubset("http", 8080, ENI_ADDR_1),
+				portSubset("http", 9090, ENI_ADDR_2),
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Backends of named target port are added with resolved ports",
+		func(f *FrameWork) error {
+			_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+			if err != nil {
+				return err
+			}
+			_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil || lb == nil {
+				return fmt.Errorf("find loadbalancer: %v", err)
+			}
+			res, err := f.SLBSDK().DescribeVServerGroups(
+				context.Background(),
+				&slb.DescribeVServerGroupsArgs{LoadBalancerId: lb.LoadBalancerId},
+			)
+			if err != nil {
+				return err
+			}
+			if len(res.VServerGroups.VServerGroup) != 1 {
+				return fmt.Errorf("expect 1 vserver group, got %d", len(res.VServerGroups.VServerGroup))
+			}
+			att, err := f.SLBSDK().DescribeVServerGroupAttribute(
+				context.Background(),
+				&slb.DescribeVServerGroupAttributeArgs{VServerGroupId: res.VServerGroups.VServerGroup[0].VServerGroupId},
+			)
+			if err != nil {
+				return err
+			}
+			ports := map[string]int{}
+			for _, b := range att.BackendServers.BackendServer {
+				ports[b.ServerIp] = b.Port
+			}
+			expect := map[string]int{ENI_ADDR_1: 8080, ENI_ADDR_2: 9090}
+			if !reflect.DeepEqual(ports, expect) {
+				return fmt.Errorf("expect backend ports %v, got %v", expect, ports)
+			}
+			listener, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(context.Background(), lb.LoadBalancerId, int(listenPort1))
+			if err != nil || listener == nil {
+				return fmt.Errorf("describe listener %d: %v", listenPort1, err)
+			}
+			if listener.VServerGroupId != res.VServerGroups.VServerGroup[0].VServerGroupId {
+				return fmt.Errorf("expect listener bound to the vserver group, got %s", listener.VServerGroupId)
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+)
+
+// The external traffic policy decides both the backends, the nodes running
+// the pods for Local or all the nodes but the masters for Cluster, and the
+// health check of the listeners, the healthCheckNodePort kube-proxy serves
+// for Local or the backend port for Cluster. kube-proxy switches its side as
+// soon as the policy is changed, so that the backends and the listeners are
+// rebuilt in the same sync once a transition is told, see
+// formerTrafficPolicy, whatever the hash label says.
+
+// formerTrafficPolicy the external traffic policy the loadbalancer was synced
+// with, and whether it differs from the one of the service. It is told by the
+// service of the last succeeded sync, or by the hash label matching the
+// service of the other policy once the former service is lost, eg. after a
+// restart of the controller.
+func formerTrafficPolicy(ctx context.Context, service *v1.Service) (v1.ServiceExternalTrafficPolicyType, bool) {
+	if former, ok := ctx.Value(utils.ContextFormerService).(*v1.Service); ok && former != nil {
+		return former.Spec.ExternalTrafficPolicy,
+			former.Spec.ExternalTrafficPolicy != service.Spec.ExternalTrafficPolicy
+	}
+	label, ok := service.Labels[utils.LabelServiceHash]
+	if !ok {
+		return "", false
+	}
+	other := service.DeepCopy()
+	other.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	if ServiceModeLocal(service) {
+		other.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeCluster
+	}
+	hash, err := utils.GetServiceHash(other)
+	if err != nil || hash != label {
+		return "", false
+	}
+	return other.Spec.ExternalTrafficPolicy, true
+}
+
+// trafficPolicyBackends the distinct backends of the vserver groups
+func trafficPolicyBackends(vgrps *vgroups) int {
+	servers := map[string]bool{}
+	for _, v := range *vgrps {
+		if v == nil || v.UserManaged {
+			continue
+		}
+		for _, b := range v.BackendServers {
+			servers[backendKey(b)] = true
+		}
+	}
+	return len(servers)
+}
+
+// trafficPolicyHealthCheck where the listeners of the service probe
+func trafficPolicyHealthCheck(service *v1.Service) string {
+	if port := (&Listener{Service: service}).localHealthCheckPort(); port != 0 {
+		return fmt.Sprintf("healthCheckNodePort %d", port)
+	}
+	return "the backend port"
+}
+
+// recordTrafficPolicyChanged tell the transition of the external traffic
+// policy rebuilt, and warn of a Local service without local endpoints, which
+// leaves the loadbalancer without a healthy backend.
+func recordTrafficPolicyChanged(
+	ctx context.Context, service *v1.Service, from v1.ServiceExternalTrafficPolicyType, vgrps *vgroups,
+) {
+	backends := trafficPolicyBackends(vgrps)
+	check := trafficPolicyHealthCheck(service)
+	utils.FromContext(ctx).Info("Rebuilt the loadbalancer for the external traffic policy",
+		"from", from, "to", service.Spec.ExternalTrafficPolicy, "backends", backends, "healthCheck", check)
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeNormal,
+		"TrafficPolicyChanged",
+		"External traffic policy changed from %s to %s, the loadbalancer is rebuilt with %d backends and health check on %s",
+		from, service.Spec.ExternalTrafficPolicy, backends, check,
+	)
+	if ServiceModeLocal(service) && !IsENIBackendType(service) && backends == 0 {
+		record.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"UnAvailableLoadBalancer",
+			"There are no local endpoints of the service for the Local external traffic policy, "+
+				"the loadbalancer has no available backends",
+		)
+	}
+}
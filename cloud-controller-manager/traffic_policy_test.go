@@ -0,0 +1,218 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const HEALTH_CHECK_NODE_PORT = 32000
+
+// ensureFrom ensure the service last synced as former, nil for none, and
+// return the events recorded
+func ensureFrom(f *FrameWork, former *v1.Service) (string, error) {
+	recorder := record.NewFakeRecorder(10)
+	ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+	if former != nil {
+		ctx = context.WithValue(ctx, utils.ContextFormerService, former)
+	}
+	_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	return strings.Join(events, "\n"), err
+}
+
+// setTrafficPolicy switch the policy of the service as the apiserver does,
+// the healthCheckNodePort is only allocated for Local
+func setTrafficPolicy(svc *v1.Service, policy v1.ServiceExternalTrafficPolicyType) {
+	svc.Spec.ExternalTrafficPolicy = policy
+	svc.Spec.HealthCheckNodePort = 0
+	if policy == v1.ServiceExternalTrafficPolicyTypeLocal {
+		svc.Spec.HealthCheckNodePort = HEALTH_CHECK_NODE_PORT
+	}
+}
+
+// trafficPolicyNodes a worker and a master running the pods
+func trafficPolicyNodes() []*v1.Node {
+	worker := nodeid(string(REGION), INSTANCEID)
+	master := nodeid(string(REGION), INSTANCEID2)
+	return []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: worker},
+			Spec:       v1.NodeSpec{ProviderID: worker},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   master,
+				Labels: map[string]string{labelNodeRoleMaster: ""},
+			},
+			Spec: v1.NodeSpec{ProviderID: master},
+		},
+	}
+}
+
+// expectClusterHealthCheck the listeners probe the backend port again
+func expectClusterHealthCheck(f *FrameWork) error {
+	_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+	if err != nil || lb == nil {
+		return fmt.Errorf("find loadbalancer: %v", err)
+	}
+	actual, err := f.ActualState(context.Background(), lb)
+	if err != nil {
+		return err
+	}
+	for _, p := range f.SVC.Spec.Ports {
+		path := fmt.Sprintf("listener %d", p.Port)
+		if port := actual[path+"/health-check-connect-port"]; port == strconv.Itoa(HEALTH_CHECK_NODE_PORT) {
+			return fmt.Errorf("%s: expect the healthCheckNodePort no longer probed, got %s", path, port)
+		}
+		if uri := actual[path+"/health-check-uri"]; uri == LOCAL_HEALTH_CHECK_URI {
+			return fmt.Errorf("%s: expect the local health check uri dropped, got %s", path, uri)
+		}
+	}
+	return nil
+}
+
+func TestTrafficPolicyTransition(t *testing.T) {
+	svc := stateService(map[string]string{})
+	setTrafficPolicy(svc, v1.ServiceExternalTrafficPolicyTypeLocal)
+	master := nodeid(string(REGION), INSTANCEID2)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(svc).WithNodes(trafficPolicyNodes()).WithEndpoints(
+		// the pods only run on the master
+		&v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-service", Namespace: "default"},
+			Subsets: []v1.EndpointSubset{
+				{
+					Addresses: []v1.EndpointAddress{{IP: "10.0.0.1", NodeName: &master}},
+					Ports:     []v1.EndpointPort{{Port: 8443}},
+				},
+			},
+		},
+	)
+
+	f.RunCustomized(t, "Local",
+		func(f *FrameWork) error {
+			events, err := ensureFrom(f, nil)
+			if err != nil {
+				return err
+			}
+			if strings.Contains(events, "TrafficPolicyChanged") {
+				return fmt.Errorf("expect no transition on creation, got %s", events)
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return nil
+		},
+	)
+
+	former := f.SVC.DeepCopy()
+	setTrafficPolicy(f.SVC, v1.ServiceExternalTrafficPolicyTypeCluster)
+	f.RunCustomized(t, "Local to Cluster",
+		func(f *FrameWork) error {
+			// the hash label is up to date, the transition is applied anyway
+			hash, err := utils.GetServiceHash(f.SVC)
+			if err != nil {
+				return err
+			}
+			f.SVC.Labels = map[string]string{utils.LabelServiceHash: hash}
+			events, err := ensureFrom(f, former)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(events, "TrafficPolicyChanged") ||
+				!strings.Contains(events, "from Local to Cluster") ||
+				!strings.Contains(events, "with 1 backends") {
+				return fmt.Errorf("expect the transition to Cluster told, got %s", events)
+			}
+			// the master running the pods is no longer a backend
+			ExpectLoadBalancerEqual(t, f)
+			return expectClusterHealthCheck(f)
+		},
+	)
+
+	// the transition is told by the hash label once the former service is lost
+	setTrafficPolicy(f.SVC, v1.ServiceExternalTrafficPolicyTypeLocal)
+	f.RunCustomized(t, "Cluster to Local",
+		func(f *FrameWork) error {
+			faults := f.SLBFaults()
+			events, err := ensureFrom(f, nil)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(events, "from Cluster to Local") ||
+				!strings.Contains(events, fmt.Sprintf("healthCheckNodePort %d", HEALTH_CHECK_NODE_PORT)) {
+				return fmt.Errorf("expect the transition to Local told, got %s", events)
+			}
+			if strings.Contains(events, "UnAvailableLoadBalancer") {
+				return fmt.Errorf("expect no warning with local endpoints, got %s", events)
+			}
+			if calls := faults.Calls("SetLoadBalancerTCPListenerAttribute"); len(calls) == 0 {
+				return fmt.Errorf("expect the listeners updated in the same sync as the backends")
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return nil
+		},
+	)
+}
+
+func TestTrafficPolicyTransitionWithoutLocalEndpoints(t *testing.T) {
+	svc := stateService(map[string]string{})
+	setTrafficPolicy(svc, v1.ServiceExternalTrafficPolicyTypeCluster)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(svc).WithNodes(trafficPolicyNodes()).WithEndpoints(
+		&v1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "my-service", Namespace: "default"}},
+	)
+
+	f.RunCustomized(t, "Cluster",
+		func(f *FrameWork) error {
+			if _, err := ensureFrom(f, nil); err != nil {
+				return err
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return nil
+		},
+	)
+
+	former := f.SVC.DeepCopy()
+	setTrafficPolicy(f.SVC, v1.ServiceExternalTrafficPolicyTypeLocal)
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerEmptyBackendsGuard] = "off"
+	f.RunCustomized(t, "Cluster to Local without local endpoints",
+		func(f *FrameWork) error {
+			events, err := ensureFrom(f, former)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(events, "TrafficPolicyChanged") || !strings.Contains(events, "with 0 backends") {
+				return fmt.Errorf("expect the transition to Local told, got %s", events)
+			}
+			if !strings.Contains(events, "UnAvailableLoadBalancer") {
+				return fmt.Errorf("expect the loadbalancer without local endpoints warned, got %s", events)
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The cloud api calls carry the version of the cloud provider and the cluster
+// id in the user agent, the traffic of a cluster is told apart by the support.
+// An operator may append a suffix, e.g. the platform managing the clusters.
+// The values end up in a header, the unsafe characters are replaced.
+
+// USER_AGENT_SUFFIX appended to the user agent, set by --user-agent-suffix
+var USER_AGENT_SUFFIX = ""
+
+// MAX_USER_AGENT_SUFFIX max length of the suffix
+const MAX_USER_AGENT_SUFFIX = 128
+
+// userAgent the user agent of the clients, e.g.
+// "Kubernetes.Alicloud/v1.9.3 cluster/c0123 my-platform/1.0"
+func userAgent() string {
+	ua := fmt.Sprintf("%s cluster/%s", KUBERNETES_ALICLOUD_IDENTITY, sanitizeUserAgent(CLUSTER_ID, false))
+	if suffix := sanitizeUserAgent(USER_AGENT_SUFFIX, true); suffix != "" {
+		ua += " " + suffix
+	}
+	return ua
+}
+
+// sanitizeUserAgent replace the characters unsafe in a header by '_', the
+// tokens of a suffix are kept apart by a single space
+func sanitizeUserAgent(value string, spaces bool) string {
+	if spaces {
+		value = strings.Join(strings.Fields(value), " ")
+	}
+	if len(value) > MAX_USER_AGENT_SUFFIX {
+		value = value[:MAX_USER_AGENT_SUFFIX]
+	}
+	return strings.Map(
+		func(r rune) rune {
+			if (r > ' ' && r < 0x7f) || (spaces && r == ' ') {
+				return r
+			}
+			return '_'
+		}, value,
+	)
+}
+
+// ValidateUserAgentSuffix the suffix must be printable ascii within
+// MAX_USER_AGENT_SUFFIX characters
+func ValidateUserAgentSuffix(suffix string) error {
+	if len(suffix) > MAX_USER_AGENT_SUFFIX {
+		return fmt.Errorf("user agent suffix must be no more than %d characters, got %d", MAX_USER_AGENT_SUFFIX, len(suffix))
+	}
+	for _, r := range suffix {
+		if r < ' ' || r >= 0x7f {
+			return fmt.Errorf("user agent suffix %q must be printable ascii", suffix)
+		}
+	}
+	return nil
+}
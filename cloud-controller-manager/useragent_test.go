@@ -0,0 +1,130 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/ecs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withUserAgent set the cluster id and the suffix, restored by the func returned
+func withUserAgent(cluster, suffix string) func() {
+	c, s := CLUSTER_ID, USER_AGENT_SUFFIX
+	CLUSTER_ID, USER_AGENT_SUFFIX = cluster, suffix
+	return func() { CLUSTER_ID, USER_AGENT_SUFFIX = c, s }
+}
+
+func TestUserAgent(t *testing.T) {
+	cases := []struct {
+		cluster string
+		suffix  string
+		expect  string
+	}{
+		{cluster: "c0123", expect: KUBERNETES_ALICLOUD_IDENTITY + " cluster/c0123"},
+		{cluster: "c0123", suffix: "my-platform/1.0", expect: KUBERNETES_ALICLOUD_IDENTITY + " cluster/c0123 my-platform/1.0"},
+		{cluster: "c0123", suffix: "  my-platform/1.0   (team-a) ", expect: KUBERNETES_ALICLOUD_IDENTITY + " cluster/c0123 my-platform/1.0 (team-a)"},
+		// header injection
+		{cluster: "c0123\r\nX-Injected: 1", expect: KUBERNETES_ALICLOUD_IDENTITY + " cluster/c0123__X-Injected:_1"},
+		{cluster: "c0123", suffix: "platform\n/1.0", expect: KUBERNETES_ALICLOUD_IDENTITY + " cluster/c0123 platform /1.0"},
+		{cluster: "集群", expect: KUBERNETES_ALICLOUD_IDENTITY + " cluster/__"},
+		{cluster: DEFAULT_CLUSTER_ID, suffix: strings.Repeat("x", 200), expect: KUBERNETES_ALICLOUD_IDENTITY + " cluster/clusterid " + strings.Repeat("x", MAX_USER_AGENT_SUFFIX)},
+	}
+	for _, c := range cases {
+		restore := withUserAgent(c.cluster, c.suffix)
+		if ua := userAgent(); ua != c.expect {
+			t.Errorf("cluster %q, suffix %q: expect user agent %q, got %q", c.cluster, c.suffix, c.expect, ua)
+		}
+		restore()
+	}
+}
+
+func TestValidateUserAgentSuffix(t *testing.T) {
+	for suffix, valid := range map[string]bool{
+		"":                         true,
+		"my-platform/1.0 (team-a)": true,
+		"platform\r\n":             false,
+		"平台":                       false,
+		strings.Repeat("x", MAX_USER_AGENT_SUFFIX+1): false,
+	} {
+		if err := ValidateUserAgentSuffix(suffix); (err == nil) != valid {
+			t.Errorf("%q: expect valid %t, got %v", suffix, valid, err)
+		}
+	}
+}
+
+func TestClientsUserAgent(t *testing.T) {
+	defer withUserAgent("c0123", "my-platform/1.0")()
+	expect := KUBERNETES_ALICLOUD_IDENTITY + " cluster/c0123 my-platform/1.0"
+
+	agents := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents[r.URL.Query().Get("Action")] = r.UserAgent()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"RequestId":"req-1"}`)
+	}))
+	defer server.Close()
+
+	// the base client of each constructor
+	clients := map[string]*common.Client{
+		CLIENT_SLB:  &NewContextedClientSLB("key-id", "key-secret", string(REGION)).slb.Client,
+		CLIENT_ECS:  &NewContextedClientINS("key-id", "key-secret", string(REGION)).ecs.Client,
+		CLIENT_PVTZ: &NewContextedClientPVTZ("key-id", "key-secret", string(REGION)).pvtz.Client,
+		CLIENT_VPC:  &NewContextedClientRoute("key-id", "key-secret", string(REGION)).ecs.Client,
+		CLIENT_NLB:  NewContextedClientNLB("key-id", "key-secret", string(REGION)).nlb,
+	}
+	for name, client := range clients {
+		client.WithEndpoint(server.URL)
+		if err := client.Invoke(name, &struct{}{}, &common.Response{}); err != nil {
+			t.Fatalf("%s: invoke: %s", name, err.Error())
+		}
+		if ua := agents[name]; !strings.HasSuffix(ua, " "+expect) {
+			t.Errorf("%s: expect user agent ending with %q, got %q", name, expect, ua)
+		}
+	}
+}
+
+func TestInstanceTagsClusterID(t *testing.T) {
+	defer withUserAgent("c0123", "")()
+	f := NewDefaultFrameWork(nil)
+	var tags map[string]string
+	f.InstanceSDK().(*mockClientInstanceSDK).addTags = func(args *ecs.AddTagsArgs) error {
+		tags = args.Tag
+		return nil
+	}
+	f.RunCustomized(t, "Instances are tagged with the cluster id",
+		func(f *FrameWork) error {
+			err := f.CloudImpl().SetInstanceTags(context.Background(), INSTANCEID, map[string]string{"kubernetes.ccm": "true"})
+			if err != nil {
+				return err
+			}
+			if tags[ACKKEY] != "c0123" || tags["kubernetes.ccm"] != "true" {
+				return fmt.Errorf("expect the cluster id tagged along, got %v", tags)
+			}
+			// the same cluster id as the loadbalancers
+			if owner := ownershipTags(f.SVC); owner[ACKKEY] != tags[ACKKEY] {
+				return fmt.Errorf("expect the cluster id of the loadbalancers %s, got %s", owner[ACKKEY], tags[ACKKEY])
+			}
+			return nil
+		},
+	)
+}
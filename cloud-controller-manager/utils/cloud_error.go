@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"strings"
+)
+
+// CloudAPIError an Alibaba Cloud api error exposing its machine readable
+// parts, eg. the error the alicloud clients wrap the sdk errors into.
+type CloudAPIError interface {
+	error
+	ErrorCode() string
+	ErrorRequestId() string
+	ErrorMessage() string
+}
+
+// CloudError the code, RequestId and message of an Alibaba Cloud api error
+type CloudError struct {
+	Code      string
+	RequestId string
+	Message   string
+}
+
+// String the error in the format of the events, eg.
+// code=Forbidden.RAM requestId=xxx message=User not authorized to operate on the specified resource.
+func (e CloudError) String() string {
+	return fmt.Sprintf("code=%s requestId=%s message=%s", e.Code, e.RequestId, e.Message)
+}
+
+// ParseCloudError the Alibaba Cloud api error in the chain of err, wrapped by
+// fmt.Errorf with %w. False if err carries none.
+func ParseCloudError(err error) (CloudError, bool) {
+	if err == nil {
+		return CloudError{}, false
+	}
+	var api CloudAPIError
+	if errors.As(err, &api) {
+		return CloudError{Code: api.ErrorCode(), RequestId: api.ErrorRequestId(), Message: api.ErrorMessage()}, true
+	}
+	var sdk *common.Error
+	if errors.As(err, &sdk) {
+		return CloudError{Code: sdk.Code, RequestId: sdk.RequestId, Message: sdk.Message}, true
+	}
+	return CloudError{}, false
+}
+
+// IsCloudNotFound whether err tells the resource operated on does not exist,
+// eg. InvalidLoadBalancerId.NotFound, or a listener or a vserver group removed
+// already. The errors of some apis carry it in the message only.
+func IsCloudNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := ParseCloudError(err); ok &&
+		(strings.HasSuffix(e.Code, "NotFound") || strings.HasSuffix(e.Code, "NotExist")) {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "notfound") ||
+		strings.Contains(message, "not found") ||
+		strings.Contains(message, "not exist")
+}
+
+// FormatCloudError the Alibaba Cloud api error of err in the format of the
+// events, or the raw error for the other errors.
+func FormatCloudError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if e, ok := ParseCloudError(err); ok {
+		return e.String()
+	}
+	return err.Error()
+}
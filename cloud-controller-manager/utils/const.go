@@ -7,6 +7,30 @@ const (
 	BACKEND_TYPE_ENI                                      = "eni"
 	BACKEND_TYPE_ECS                                      = "ecs"
 	ServiceAnnotationLoadBalancerRemoveUnscheduledBackend = "service.beta.kubernetes.io/alibaba-cloud-loadbalancer-remove-unscheduled-backend"
+	// ServiceAnnotationLoadBalancerGracefulDrainSeconds time the removed backends are kept with weight 0,
+	// the draining nodes are removed by the drain as well.
+	ServiceAnnotationLoadBalancerGracefulDrainSeconds = "service.beta.kubernetes.io/alibaba-cloud-loadbalancer-graceful-drain-seconds"
+	// ServiceAnnotationLoadBalancerCertSecret tls secret uploaded as the certificate of https listeners,
+	// services are resynced by the service controller when the secret changes.
+	ServiceAnnotationLoadBalancerCertSecret = "service.beta.kubernetes.io/alibaba-cloud-loadbalancer-cert-secret"
+	// ServiceAnnotationLoadBalancerBackendZones comma separated zones of the nodes added as backends,
+	// e.g. "cn-hangzhou-h,cn-hangzhou-i".
+	ServiceAnnotationLoadBalancerBackendZones = "service.beta.kubernetes.io/alibaba-cloud-loadbalancer-backend-zones"
+	// ServiceAnnotationLoadBalancerNotReadyToleranceSeconds seconds a NotReady node is kept as backend,
+	// e.g. during a kubelet restart.
+	ServiceAnnotationLoadBalancerNotReadyToleranceSeconds = "service.beta.kubernetes.io/alibaba-cloud-loadbalancer-not-ready-tolerance-seconds"
+	// ServiceAnnotationLoadBalancerIdResult id of the loadbalancer serving the service, written by the controller.
+	// It is excluded from the service hash.
+	ServiceAnnotationLoadBalancerIdResult = "service.beta.kubernetes.io/alibaba-cloud-loadbalancer-id-result"
+	// ServiceAnnotationReconcileTimestamp any value, e.g. a timestamp, a change of which forces a full sync of
+	// the service at once, ignoring the service hash and the backoff of the failed syncs.
+	ServiceAnnotationReconcileTimestamp = "service.beta.kubernetes.io/alibaba-cloud-reconcile-timestamp"
+	// ServiceAnnotationReconcileTimestampResult the reconcile timestamp of the last succeeded sync, written by
+	// the controller. It is excluded from the service hash.
+	ServiceAnnotationReconcileTimestampResult = "service.beta.kubernetes.io/alibaba-cloud-reconcile-timestamp-result"
+	// LabelTopologyZone zone of the node, LabelFailureDomainZone is the deprecated one.
+	LabelTopologyZone      = "topology.kubernetes.io/zone"
+	LabelFailureDomainZone = "failure-domain.beta.kubernetes.io/zone"
 	// LabelNodeRoleExcludeNodeDeprecated specifies that the node should be exclude from CCM
 	LabelNodeRoleExcludeNodeDeprecated = "service.beta.kubernetes.io/exclude-node"
 	LabelNodeRoleExcludeNode           = "service.alibabacloud.com/exclude-node"
@@ -17,4 +41,40 @@ const (
 	ECINodeLabel                            = "virtual-kubelet"
 	ContextService               contextKey = "request.service"
 	ContextRecorder              contextKey = "context.recorder"
-)
\ No newline at end of file
+	// ContextRequeue func(time.Duration) requeue the service after the delay
+	ContextRequeue contextKey = "context.requeue"
+	// ContextLoadBalancerId func(string) publish the id of the ensured or the deleted loadbalancer
+	ContextLoadBalancerId contextKey = "context.loadbalancer.id"
+	// ContextBackends func(int) publish the count of the backends of the ensured loadbalancer
+	ContextBackends contextKey = "context.loadbalancer.backends"
+	// ContextBackendZones func(map[string]int) publish the count of the backends of the ensured loadbalancer by zone
+	ContextBackendZones contextKey = "context.loadbalancer.backend.zones"
+	// ContextBackendNodes func([]string) publish the names of the nodes added as backends of the ensured loadbalancer
+	ContextBackendNodes contextKey = "context.loadbalancer.backend.nodes"
+	// ContextFormerService *v1.Service the service of the last succeeded sync
+	ContextFormerService contextKey = "context.service.former"
+	// ContextForceResync bool sync the service fully, ignoring the service hash
+	ContextForceResync contextKey = "context.resync.force"
+	// ContextAPIBudget string the key of the service whose budget the cloud
+	// api calls of the sync are taken from, absent for the deletions
+	ContextAPIBudget contextKey = "context.api.budget"
+	// InvalidAnnotation reason of the events and errors of malformed annotations,
+	// syncs failed with it are not retried until the service changes.
+	InvalidAnnotation = "InvalidAnnotation"
+	// APIBudgetExceeded reason of the events and errors of the syncs aborted
+	// as their service ran out of its budget of cloud api calls
+	APIBudgetExceeded = "APIBudgetExceeded"
+)
+
+const (
+	// ServiceHashVersion version of the algorithm of the hash label, bumped
+	// when the hashed fields change
+	ServiceHashVersion = "v2"
+	// ServiceAnnotationPrefix prefix of the annotations of the alibaba cloud
+	ServiceAnnotationPrefix = "service.beta.kubernetes.io/alibaba-cloud-"
+	// ServiceAnnotationLegacyPrefix legacy prefix of the annotations
+	ServiceAnnotationLegacyPrefix = "service.beta.kubernetes.io/alicloud-"
+)
+
+// ContextLogger Logger the structured logger of the sync
+const ContextLogger contextKey = "context.logger"
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"reflect"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// defaultAnnotations the cluster-wide default annotations of the
+// loadbalancer services, set from the configmap watched by the service
+// controller. The annotations of the service win over the defaults.
+var defaultAnnotations = struct {
+	sync.RWMutex
+	values map[string]string
+}{}
+
+// SetDefaultAnnotations replace the default annotations, only the annotations
+// of the alibaba cloud but the ones written by the controller are kept.
+// Whether the defaults are changed is returned.
+func SetDefaultAnnotations(values map[string]string) bool {
+	kept := make(map[string]string, len(values))
+	for k, v := range values {
+		if !isHashedAnnotation(k) {
+			InfoS("Skip the default annotation, not a loadbalancer annotation", "annotation", k)
+			continue
+		}
+		kept[k] = v
+	}
+	defaultAnnotations.Lock()
+	defer defaultAnnotations.Unlock()
+	if len(kept) == len(defaultAnnotations.values) &&
+		(len(kept) == 0 || reflect.DeepEqual(kept, defaultAnnotations.values)) {
+		return false
+	}
+	defaultAnnotations.values = kept
+	return true
+}
+
+// DefaultAnnotations a copy of the default annotations
+func DefaultAnnotations() map[string]string {
+	defaultAnnotations.RLock()
+	defer defaultAnnotations.RUnlock()
+	values := make(map[string]string, len(defaultAnnotations.values))
+	for k, v := range defaultAnnotations.values {
+		values[k] = v
+	}
+	return values
+}
+
+// WithDefaultAnnotations the service with the default annotations merged
+// under its own. The service is copied when a default is missing from it,
+// it must never be written back to the apiserver.
+func WithDefaultAnnotations(service *v1.Service) *v1.Service {
+	if service == nil {
+		return nil
+	}
+	defaultAnnotations.RLock()
+	defer defaultAnnotations.RUnlock()
+	var merged *v1.Service
+	for k, v := range defaultAnnotations.values {
+		if _, ok := service.Annotations[k]; ok {
+			continue
+		}
+		if merged == nil {
+			merged = service.DeepCopy()
+			if merged.Annotations == nil {
+				merged.Annotations = make(map[string]string, len(defaultAnnotations.values))
+			}
+		}
+		merged.Annotations[k] = v
+	}
+	if merged == nil {
+		return service
+	}
+	return merged
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"regexp"
+	"unicode/utf8"
+)
+
+// The objects dumped to the logs for diagnosis are sanitized: the values of
+// the annotations whose key matches DiagnosticRedactPattern are redacted, the
+// managed fields are dropped, and the output is truncated to
+// DiagnosticMaxBytes. The namespace, name and uid of an object are always
+// kept ahead of the truncated output.
+
+// DIAGNOSTIC_REDACTED the value of the redacted annotations
+const DIAGNOSTIC_REDACTED = "******"
+
+// DEFAULT_DIAGNOSTIC_REDACT_PATTERN the annotations redacted by default, the
+// last applied configuration carries all the annotations
+const DEFAULT_DIAGNOSTIC_REDACT_PATTERN = "(?i)(cert|key|token|password|secret|last-applied-configuration)"
+
+// DEFAULT_DIAGNOSTIC_MAX_BYTES the max bytes of a dump by default
+const DEFAULT_DIAGNOSTIC_MAX_BYTES = 4096
+
+// DiagnosticRedactPattern the keys of the annotations redacted, set by --diagnostic-redact-pattern
+var DiagnosticRedactPattern = regexp.MustCompile(DEFAULT_DIAGNOSTIC_REDACT_PATTERN)
+
+// DiagnosticMaxBytes the max bytes of a dump, 0 for no limit, set by --diagnostic-max-bytes
+var DiagnosticMaxBytes = DEFAULT_DIAGNOSTIC_MAX_BYTES
+
+// SetDiagnosticRedactPattern replace the pattern of the annotations redacted
+func SetDiagnosticRedactPattern(expr string) error {
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("diagnostic redact pattern %q: %s", expr, err.Error())
+	}
+	DiagnosticRedactPattern = pattern
+	return nil
+}
+
+// Diagnostic the sanitized dump of the object in compact json, prefixed by
+// the namespace, name and uid of a kubernetes object.
+func Diagnostic(obj interface{}) string {
+	return diagnostic(obj, "")
+}
+
+func diagnostic(obj interface{}, indent string) string {
+	identity := ""
+	if accessor, err := meta.Accessor(obj); err == nil {
+		identity = fmt.Sprintf("%s/%s uid=%s: ", accessor.GetNamespace(), accessor.GetName(), accessor.GetUID())
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return identity + fmt.Sprintf("<marshal error: %s>", err.Error())
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return identity + fmt.Sprintf("<unmarshal error: %s>", err.Error())
+	}
+	value = sanitize(value)
+	if indent != "" {
+		data, err = json.MarshalIndent(value, "", indent)
+	} else {
+		data, err = json.Marshal(value)
+	}
+	if err != nil {
+		return identity + fmt.Sprintf("<marshal error: %s>", err.Error())
+	}
+	return identity + truncate(string(data), DiagnosticMaxBytes)
+}
+
+// sanitize redact the annotations and drop the managed fields at any depth
+func sanitize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		delete(v, "managedFields")
+		for key, item := range v {
+			if annotations, ok := item.(map[string]interface{}); ok && key == "annotations" {
+				for k := range annotations {
+					if DiagnosticRedactPattern.MatchString(k) {
+						annotations[k] = DIAGNOSTIC_REDACTED
+					}
+				}
+				continue
+			}
+			v[key] = sanitize(item)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = sanitize(v[i])
+		}
+	}
+	return value
+}
+
+// truncate the output to max bytes with a marker of the bytes left out
+func truncate(output string, max int) string {
+	if max <= 0 || len(output) <= max {
+		return output
+	}
+	cut := max
+	// never split a multi-byte character
+	for cut > 0 && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...(truncated %d bytes)", output[:cut], len(output)-cut)
+}
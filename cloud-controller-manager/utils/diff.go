@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"fmt"
+	"k8s.io/api/core/v1"
+	"sort"
+	"strings"
+)
+
+// The diff of two versions of a service tells which change of the fields
+// affecting the loadbalancer triggers a sync, for the logs and the events of
+// the service controller and for the report of a dry run. The values of the
+// annotations matching DiagnosticRedactPattern are redacted.
+
+// DIFF_MAX_BYTES the max bytes of the summary of a diff
+const DIFF_MAX_BYTES = 1024
+
+// ServiceDiff the changes from old to cur, one per item, eg.
+//
+//	port added 443/TCP
+//	port 80/TCP nodePort 30080 -> 30081
+//	annotation changed service.beta.kubernetes.io/alibaba-cloud-loadbalancer-spec: slb.s1.small -> slb.s2.small
+//	externalTrafficPolicy Cluster -> Local
+//
+// The changes of the fields not affecting the loadbalancer are left out.
+func ServiceDiff(old, cur *v1.Service) []string {
+	var diff []string
+	if old.UID != cur.UID {
+		diff = append(diff, fmt.Sprintf("uid %s -> %s", old.UID, cur.UID))
+	}
+	diff = appendFieldDiff(diff, "type", string(old.Spec.Type), string(cur.Spec.Type))
+	diff = append(diff, portsDiff(old.Spec.Ports, cur.Spec.Ports)...)
+	diff = appendFieldDiff(diff, "externalTrafficPolicy",
+		string(old.Spec.ExternalTrafficPolicy), string(cur.Spec.ExternalTrafficPolicy))
+	diff = appendFieldDiff(diff, "sessionAffinity",
+		string(old.Spec.SessionAffinity), string(cur.Spec.SessionAffinity))
+	diff = appendFieldDiff(diff, "loadBalancerSourceRanges",
+		strings.Join(old.Spec.LoadBalancerSourceRanges, ","), strings.Join(cur.Spec.LoadBalancerSourceRanges, ","))
+	diff = append(diff, annotationsDiff(UserAnnotations(old), UserAnnotations(cur))...)
+	return diff
+}
+
+// ServiceDiffSummary the diff in a line truncated to DIFF_MAX_BYTES
+func ServiceDiffSummary(old, cur *v1.Service) string {
+	diff := ServiceDiff(old, cur)
+	if len(diff) == 0 {
+		return "no field of the loadbalancer changed"
+	}
+	return truncate(strings.Join(diff, "; "), DIFF_MAX_BYTES)
+}
+
+func appendFieldDiff(diff []string, field, old, cur string) []string {
+	if old == cur {
+		return diff
+	}
+	return append(diff, fmt.Sprintf("%s %s -> %s", field, old, cur))
+}
+
+func portKey(port v1.ServicePort) string {
+	return fmt.Sprintf("%d/%s", port.Port, port.Protocol)
+}
+
+func appProtocol(port v1.ServicePort) string {
+	if port.AppProtocol == nil {
+		return ""
+	}
+	return *port.AppProtocol
+}
+
+// portsDiff the ports added, removed, and changed by the port and protocol
+func portsDiff(old, cur []v1.ServicePort) []string {
+	olds := make(map[string]v1.ServicePort, len(old))
+	for _, port := range old {
+		olds[portKey(port)] = port
+	}
+	var diff []string
+	for _, port := range cur {
+		k := portKey(port)
+		former, ok := olds[k]
+		if !ok {
+			diff = append(diff, "port added "+k)
+			continue
+		}
+		delete(olds, k)
+		field := "port " + k + " "
+		diff = appendFieldDiff(diff, field+"name", former.Name, port.Name)
+		diff = appendFieldDiff(diff, field+"targetPort", former.TargetPort.String(), port.TargetPort.String())
+		diff = appendFieldDiff(diff, field+"nodePort",
+			fmt.Sprint(former.NodePort), fmt.Sprint(port.NodePort))
+		diff = appendFieldDiff(diff, field+"appProtocol", appProtocol(former), appProtocol(port))
+	}
+	var removed []string
+	for k := range olds {
+		removed = append(removed, "port removed "+k)
+	}
+	sort.Strings(removed)
+	return append(diff, removed...)
+}
+
+// annotationsDiff the annotations added, removed and changed sorted by key
+func annotationsDiff(old, cur map[string]string) []string {
+	keys := make(map[string]bool, len(old)+len(cur))
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range cur {
+		keys[k] = true
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	var diff []string
+	for _, k := range sorted {
+		former, had := old[k]
+		value, has := cur[k]
+		if DiagnosticRedactPattern.MatchString(k) {
+			former, value = DIAGNOSTIC_REDACTED, DIAGNOSTIC_REDACTED
+		}
+		switch {
+		case !had:
+			diff = append(diff, fmt.Sprintf("annotation added %s: %s", k, value))
+		case !has:
+			diff = append(diff, fmt.Sprintf("annotation removed %s", k))
+		case old[k] != cur[k]:
+			diff = append(diff, fmt.Sprintf("annotation changed %s: %s -> %s", k, former, value))
+		}
+	}
+	return diff
+}
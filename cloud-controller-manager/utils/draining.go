@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NodeDraining tells the nodes being drained, which are cordoned or carry one
+// of the drain taints or labels well before they leave the cluster. The
+// backends of a draining node are drained by weight 0 with the graceful
+// drain, and are not reported unhealthy for the Local services.
+type NodeDraining struct {
+	// Taints keys of the taints of a draining node
+	Taints []string
+	// Selectors label selectors of a draining node
+	Selectors []labels.Selector
+}
+
+// DrainingNodes the draining nodes, set at startup
+var DrainingNodes = DefaultNodeDraining()
+
+// DefaultNodeDraining the cordoned nodes, tainted unschedulable by the node
+// lifecycle controller, are draining.
+func DefaultNodeDraining() NodeDraining {
+	return NodeDraining{Taints: []string{v1.TaintNodeUnschedulable}}
+}
+
+// NewNodeDraining the default draining nodes with the taint keys and the label
+// selectors added, e.g. "ToBeDeletedByClusterAutoscaler" or "drain=true"
+func NewNodeDraining(taints, selectors []string) (NodeDraining, error) {
+	draining := DefaultNodeDraining()
+	for _, taint := range taints {
+		if taint == "" {
+			return draining, fmt.Errorf("empty draining node taint")
+		}
+		draining.Taints = append(draining.Taints, taint)
+	}
+	for _, s := range selectors {
+		selector, err := labels.Parse(s)
+		if err != nil {
+			return draining, fmt.Errorf("draining node selector %q: %s", s, err.Error())
+		}
+		draining.Selectors = append(draining.Selectors, selector)
+	}
+	return draining, nil
+}
+
+// Draining whether the node is cordoned, tainted or labeled as draining
+func (d NodeDraining) Draining(node *v1.Node) bool {
+	if node == nil {
+		return false
+	}
+	if node.Spec.Unschedulable {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		for _, key := range d.Taints {
+			if taint.Key == key {
+				return true
+			}
+		}
+	}
+	return matchesAny(d.Selectors, node)
+}
+
+// IsDrainingNode whether the node is draining by DrainingNodes
+func IsDrainingNode(node *v1.Node) bool {
+	return DrainingNodes.Draining(node)
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NodeExclusion the label selectors of the nodes excluded by the controllers.
+// A node excluded from the management is skipped by the node, route and
+// service controllers, and is never a backend. A node excluded from the
+// loadbalancers is still managed, e.g. its addresses are synced, but it is
+// not added to the backends.
+type NodeExclusion struct {
+	Management []labels.Selector
+	Balancer   []labels.Selector
+}
+
+// NodeExclusions the exclusion of the controllers, set at startup
+var NodeExclusions = DefaultNodeExclusion()
+
+// DefaultNodeExclusion the nodes with the exclude-node labels are excluded
+// from the management, the ones with the exclude-balancer label from the
+// loadbalancers.
+func DefaultNodeExclusion() NodeExclusion {
+	return NodeExclusion{
+		Management: []labels.Selector{
+			labelExists(LabelNodeRoleExcludeNodeDeprecated),
+			labelExists(LabelNodeRoleExcludeNode),
+		},
+		Balancer: []labels.Selector{
+			labelExists(LabelNodeRoleExcludeBalancer),
+		},
+	}
+}
+
+func labelExists(key string) labels.Selector {
+	selector, err := labels.Parse(key)
+	if err != nil {
+		panic(fmt.Sprintf("label %s: %s", key, err.Error()))
+	}
+	return selector
+}
+
+// NewNodeExclusion the default exclusion with the selectors of the
+// management and the loadbalancers added, e.g. "pool=gpu-burst"
+func NewNodeExclusion(management, balancer []string) (NodeExclusion, error) {
+	exclusion := DefaultNodeExclusion()
+	for _, s := range management {
+		selector, err := labels.Parse(s)
+		if err != nil {
+			return exclusion, fmt.Errorf("node selector %q excluded from management: %s", s, err.Error())
+		}
+		exclusion.Management = append(exclusion.Management, selector)
+	}
+	for _, s := range balancer {
+		selector, err := labels.Parse(s)
+		if err != nil {
+			return exclusion, fmt.Errorf("node selector %q excluded from loadbalancers: %s", s, err.Error())
+		}
+		exclusion.Balancer = append(exclusion.Balancer, selector)
+	}
+	return exclusion, nil
+}
+
+func matchesAny(selectors []labels.Selector, node *v1.Node) bool {
+	if node == nil || node.Labels == nil {
+		return false
+	}
+	for _, selector := range selectors {
+		if !selector.Empty() && selector.Matches(labels.Set(node.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExcludedFromManagement whether the node is skipped by the controllers
+func (e NodeExclusion) ExcludedFromManagement(node *v1.Node) bool {
+	return matchesAny(e.Management, node)
+}
+
+// ExcludedFromBalancer whether the node is kept out of the backends
+func (e NodeExclusion) ExcludedFromBalancer(node *v1.Node) bool {
+	return e.ExcludedFromManagement(node) || matchesAny(e.Balancer, node)
+}
+
+// IsExcludedNode whether the node is excluded from the management by
+// NodeExclusions
+func IsExcludedNode(node *v1.Node) bool {
+	return NodeExclusions.ExcludedFromManagement(node)
+}
+
+// IsExcludedFromBalancer whether the node is excluded from the loadbalancers
+// by NodeExclusions
+func IsExcludedFromBalancer(node *v1.Node) bool {
+	return NodeExclusions.ExcludedFromBalancer(node)
+}
@@ -1,15 +1,224 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog"
+	"strconv"
+	"strings"
 )
 
-func Logf(svc *v1.Service, format string, args ...interface{}) {
-	prefix := ""
-	if svc != nil {
-		prefix = fmt.Sprintf("[%s/%s]", svc.Namespace, svc.Name)
+// The keys of the structured logs, kept consistent so that the logs of a
+// service or a loadbalancer can be indexed.
+const (
+	LogKeyService      = "service"
+	LogKeySLBID        = "slbID"
+	LogKeyListenerPort = "listenerPort"
+	LogKeyOperation    = "operation"
+	LogKeyRequestID    = "requestID"
+	LogKeyNode         = "node"
+)
+
+// LOG_SYNC_VERBOSITY verbosity of the details of each sync
+const LOG_SYNC_VERBOSITY klog.Level = 4
+
+// LogSeverity severity of the structured logs
+type LogSeverity string
+
+const (
+	LogInfo    LogSeverity = "INFO"
+	LogWarning LogSeverity = "WARNING"
+	LogError   LogSeverity = "ERROR"
+)
+
+// LogEntry a structured log, the message and the key value pairs
+type LogEntry struct {
+	Severity      LogSeverity
+	Level         klog.Level
+	Err           error
+	Message       string
+	KeysAndValues []interface{}
+}
+
+// Value the value of the key, the last one wins as in klog
+func (e LogEntry) Value(key string) (interface{}, bool) {
+	var (
+		value interface{}
+		found bool
+	)
+	for i := 0; i+1 < len(e.KeysAndValues); i += 2 {
+		if k, ok := e.KeysAndValues[i].(string); ok && k == key {
+			value, found = e.KeysAndValues[i+1], true
+		}
+	}
+	return value, found
+}
+
+// String the entry in the format of klog.InfoS, eg.
+// "Ensured loadbalancer" service="default/nginx" slbID="lb-xxx"
+func (e LogEntry) String() string {
+	var b strings.Builder
+	b.WriteString(strconv.Quote(e.Message))
+	if e.Err != nil {
+		b.WriteString(" err=")
+		b.WriteString(strconv.Quote(e.Err.Error()))
+	}
+	for i := 0; i < len(e.KeysAndValues); i += 2 {
+		key := fmt.Sprintf("%v", e.KeysAndValues[i])
+		var value interface{} = "(MISSING)"
+		if i+1 < len(e.KeysAndValues) {
+			value = e.KeysAndValues[i+1]
+		}
+		b.WriteString(" ")
+		b.WriteString(key)
+		b.WriteString("=")
+		switch v := value.(type) {
+		case string:
+			b.WriteString(strconv.Quote(v))
+		case error:
+			b.WriteString(strconv.Quote(v.Error()))
+		case fmt.Stringer:
+			b.WriteString(strconv.Quote(v.String()))
+		default:
+			b.WriteString(fmt.Sprintf("%+v", v))
+		}
+	}
+	return b.String()
+}
+
+// LogSink writes the entries, depth is the one of the call site of the logger
+// counted from the sink
+type LogSink func(depth int, entry LogEntry)
+
+// callerDepth the depth of the call site from the sink
+const callerDepth = 3
+
+var logSink LogSink = klogSink
+
+func klogSink(depth int, entry LogEntry) {
+	switch entry.Severity {
+	case LogError:
+		klog.ErrorDepth(depth, entry.String())
+	case LogWarning:
+		klog.WarningDepth(depth, entry.String())
+	default:
+		if entry.Level > 0 && !klog.V(entry.Level) {
+			return
+		}
+		klog.InfoDepth(depth, entry.String())
+	}
+}
+
+// SetLogSink replace the sink of the structured logs and return the former
+// one, the sink is called at every verbosity, eg. by the tests capturing the
+// logs.
+func SetLogSink(sink LogSink) LogSink {
+	former := logSink
+	logSink = sink
+	return former
+}
+
+// Logger a structured logger with the key value pairs of its owner, eg.
+// the service synced, added to every entry.
+type Logger struct {
+	values []interface{}
+}
+
+// NewLogger a logger with the key value pairs
+func NewLogger(keysAndValues ...interface{}) Logger {
+	return Logger{values: keysAndValues}
+}
+
+// ServiceLogger a logger of the service
+func ServiceLogger(svc *v1.Service) Logger {
+	if svc == nil {
+		return Logger{}
+	}
+	return NewLogger(LogKeyService, svc.Namespace+"/"+svc.Name)
+}
+
+// WithValues a logger with the key value pairs added
+func (l Logger) WithValues(keysAndValues ...interface{}) Logger {
+	values := make([]interface{}, 0, len(l.values)+len(keysAndValues))
+	values = append(values, l.values...)
+	return Logger{values: append(values, keysAndValues...)}
+}
+
+func (l Logger) log(entry LogEntry, keysAndValues []interface{}) {
+	entry.KeysAndValues = append(append([]interface{}{}, l.values...), keysAndValues...)
+	logSink(callerDepth, entry)
+}
+
+// Info log the message as klog.InfoS
+func (l Logger) Info(msg string, keysAndValues ...interface{}) {
+	l.log(LogEntry{Severity: LogInfo, Message: msg}, keysAndValues)
+}
+
+// Warning log the message at the warning severity, which klog.InfoS lacks
+func (l Logger) Warning(msg string, keysAndValues ...interface{}) {
+	l.log(LogEntry{Severity: LogWarning, Message: msg}, keysAndValues)
+}
+
+// Error log the message as klog.ErrorS
+func (l Logger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.log(LogEntry{Severity: LogError, Err: err, Message: msg}, keysAndValues)
+}
+
+// V a logger of the verbosity
+func (l Logger) V(level klog.Level) Verbose {
+	return Verbose{logger: l, level: level}
+}
+
+// Verbose logs at the verbosity
+type Verbose struct {
+	logger Logger
+	level  klog.Level
+}
+
+// Info log the message as klog.V(level).InfoS
+func (v Verbose) Info(msg string, keysAndValues ...interface{}) {
+	v.logger.log(LogEntry{Severity: LogInfo, Level: v.level, Message: msg}, keysAndValues)
+}
+
+// V a logger of the verbosity without any key value pair, as klog.V
+func V(level klog.Level) Verbose {
+	return Logger{}.V(level)
+}
+
+// InfoS log the message with the key value pairs, as klog.InfoS
+func InfoS(msg string, keysAndValues ...interface{}) {
+	Logger{}.log(LogEntry{Severity: LogInfo, Message: msg}, keysAndValues)
+}
+
+// ErrorS log the error with the key value pairs, as klog.ErrorS
+func ErrorS(err error, msg string, keysAndValues ...interface{}) {
+	Logger{}.log(LogEntry{Severity: LogError, Err: err, Message: msg}, keysAndValues)
+}
+
+// WithLogger a context carrying the logger, so that the code deep in a sync
+// logs its owner without threading the logger through.
+func WithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, ContextLogger, logger)
+}
+
+// FromContext the logger of the context, or the one of the service of the
+// context, or a logger without any key value pair.
+func FromContext(ctx context.Context) Logger {
+	if ctx == nil {
+		return Logger{}
+	}
+	if logger, ok := ctx.Value(ContextLogger).(Logger); ok {
+		return logger
+	}
+	if svc, ok := ctx.Value(ContextService).(*v1.Service); ok {
+		return ServiceLogger(svc)
 	}
-	klog.Infof(prefix+format, args...)
+	return Logger{}
+}
+
+// Logf log the message of the service, a shim of the structured logger kept
+// for the callers of the other packages.
+func Logf(svc *v1.Service, format string, args ...interface{}) {
+	ServiceLogger(svc).log(LogEntry{Severity: LogInfo, Message: fmt.Sprintf(format, args...)}, nil)
 }
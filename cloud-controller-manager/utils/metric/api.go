@@ -0,0 +1,62 @@
+package metric
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// CloudAPILimiterWait time the cloud api calls wait on the limiter
+	CloudAPILimiterWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "ccm_cloud_api_limiter_wait_duration_milliseconds",
+			Help: "Time the cloud api calls wait on the client side limiter in milliseconds for each kind of call.",
+			Buckets: []float64{0, 10, 50, 100, 200, 500, 1000,
+				2000, 5000, 10000, 30000},
+		},
+		[]string{"kind"},
+	)
+
+	// CloudAPIRequestDuration latency of the cloud api operations
+	CloudAPIRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "cloud_api_request_duration_seconds",
+			Help: "Latency distribution of the cloud api operations in seconds for each product and operation.",
+			Buckets: []float64{0.05, 0.1, 0.2, 0.3, 0.5, 1,
+				2, 3, 5, 10, 30},
+		},
+		[]string{"product", "operation"},
+	)
+
+	// CloudAPIRequestErrors cloud api operations failed
+	CloudAPIRequestErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cloud_api_request_errors_total",
+			Help: "Number of the cloud api operations failed for each product, operation and error code.",
+		},
+		[]string{"product", "operation", "code"},
+	)
+
+	// CloudAPICircuitOpen circuits of the credentials open
+	CloudAPICircuitOpen = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ccm_cloud_api_circuit_open",
+			Help: "Number of the credentials whose cloud api calls fail fast after consecutive auth failures.",
+		},
+	)
+
+	// CloudAPICircuitTrips circuits of the credentials opened
+	CloudAPICircuitTrips = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ccm_cloud_api_circuit_trips_total",
+			Help: "Number of the times the circuit of a credential opened after consecutive auth failures.",
+		},
+	)
+
+	// ServiceAPIBudgetExceeded cloud api calls refused by the budget of their service
+	ServiceAPIBudgetExceeded = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ccm_service_api_budget_exceeded_total",
+			Help: "Number of the cloud api calls refused as their service used up its budget of calls per window.",
+		},
+	)
+)
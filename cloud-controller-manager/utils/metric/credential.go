@@ -0,0 +1,24 @@
+package metric
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// CredentialReload reloads of the credentials of the cloud config file
+	CredentialReload = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ccm_credential_reload_total",
+			Help: "Reloads of the credentials of the cloud config file by result.",
+		},
+		[]string{"result"},
+	)
+
+	// RamRoleRefreshFailure failed renewals of the sts token of the instance ram role
+	RamRoleRefreshFailure = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ccm_ramrole_refresh_failures_total",
+			Help: "Failed renewals of the sts token of the instance ram role.",
+		},
+	)
+)
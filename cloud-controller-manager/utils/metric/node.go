@@ -15,4 +15,42 @@ var (
 		},
 		[]string{"verb"},
 	)
+
+	// NodeDeletion nodes of the missing instances by result, deleted, failed,
+	// or marked when the node deletion is disabled
+	NodeDeletion = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ccm_node_deletion_total",
+			Help: "Nodes whose instance is not found by result, one of deleted, failed and marked, the ones marked would have been deleted if the node deletion was enabled.",
+		},
+		[]string{"result"},
+	)
+
+	// NodeLabelDriftRepaired cloud labels of the nodes repaired by label
+	NodeLabelDriftRepaired = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ccm_node_label_drift_repaired_total",
+			Help: "Cloud labels of the nodes repaired by the label reconcile as they drifted from the instances, by label.",
+		},
+		[]string{"label"},
+	)
+
+	// NodesMissingLabels nodes missing any of the cloud labels after the last
+	// label reconcile
+	NodesMissingLabels = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ccm_nodes_missing_cloud_labels",
+			Help: "Nodes missing any of the instance type, zone and region labels after the last label reconcile.",
+		},
+	)
+
+	// NodeLoopOverruns ticks of the loops of the node controller skipped as
+	// the previous iteration was still running, by loop
+	NodeLoopOverruns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ccm_node_loop_overruns_total",
+			Help: "Ticks of the periodic loops of the node controller skipped as the previous iteration was still running, by loop.",
+		},
+		[]string{"loop"},
+	)
 )
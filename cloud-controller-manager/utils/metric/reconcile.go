@@ -0,0 +1,62 @@
+package metric
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ReconcileTotal syncs of the controllers by the class of their outcome
+	ReconcileTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ccm_reconcile_total",
+			Help: "Syncs of the controller by result, one of success, throttled, cloud_error, apiserver_error, validation_error and not_found.",
+		},
+		[]string{"controller", "result"},
+	)
+
+	// ServiceRequeues failed syncs of the services requeued, by reason, throttle, budget or error
+	ServiceRequeues = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ccm_service_requeues_total",
+			Help: "Failed syncs of the services requeued with a delay, by reason, throttle, budget or error.",
+		},
+		[]string{"reason"},
+	)
+
+	// ServicesInBackoff services requeued after a failed sync, not synced successfully since
+	ServicesInBackoff = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ccm_services_in_backoff",
+			Help: "Services with a pending delayed requeue after a failed sync.",
+		},
+	)
+
+	// ReconcileFailing objects of the controllers whose last sync failed
+	ReconcileFailing = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ccm_reconcile_failing",
+			Help: "Objects of the controller whose last sync failed.",
+		},
+		[]string{"controller"},
+	)
+
+	// ServiceLastSuccessfulSync the time of the last successful sync of each
+	// loadbalancer service, exported only if enabled as it is per service
+	ServiceLastSuccessfulSync = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ccm_service_last_successful_sync_timestamp_seconds",
+			Help: "Unix time of the last successful sync of the loadbalancer service.",
+		},
+		[]string{"namespace", "name"},
+	)
+
+	// ServiceOldestSuccessfulSync the oldest of the last successful syncs of
+	// the loadbalancer services, absent if none is tracked
+	ServiceOldestSuccessfulSync = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ccm_service_oldest_successful_sync_timestamp_seconds",
+			Help: "Unix time of the oldest last successful sync among the loadbalancer services, absent if none is tracked.",
+		},
+		[]string{},
+	)
+)
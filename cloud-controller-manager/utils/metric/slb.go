@@ -15,4 +15,59 @@ var (
 		},
 		[]string{"verb"},
 	)
+
+	// SLBBackendHealthy healthy backends of the loadbalancer of a service
+	SLBBackendHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slb_backend_healthy",
+			Help: "Healthy backends of the load balancer of the service.",
+		},
+		[]string{"service"},
+	)
+
+	// SLBBackendUnhealthy unhealthy backends of the loadbalancer of a service
+	SLBBackendUnhealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slb_backend_unhealthy",
+			Help: "Unhealthy backends of the load balancer of the service.",
+		},
+		[]string{"service"},
+	)
+
+	// SLBManaged loadbalancers of the services synced successfully
+	SLBManaged = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ccm_managed_loadbalancers",
+			Help: "Load balancers managed by the controller, one per service of type LoadBalancer synced successfully.",
+		},
+	)
+
+	// SLBBackends backends of the loadbalancer of a service as of its last successful sync
+	SLBBackends = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ccm_slb_backends",
+			Help: "Distinct backend servers of the vserver groups of the load balancer of the service as of its last successful sync.",
+		},
+		[]string{"service"},
+	)
+
+	// SLBSingleZoneBackends 1 if all the backends of the loadbalancer of a
+	// service are in a single zone while there are ready nodes in others
+	SLBSingleZoneBackends = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ccm_slb_single_zone_backends",
+			Help: "1 if all the backends of the load balancer of the service are in a single zone while the cluster has ready nodes in others, as of its last successful sync.",
+		},
+		[]string{"service"},
+	)
+
+	// SLBOrphanCleanup listeners and vserver groups of the removed ports
+	// cleaned up, by resource and result, deleted or failed
+	SLBOrphanCleanup = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ccm_slb_orphan_cleanup_total",
+			Help: "Listeners and vserver groups of the ports removed from the services cleaned up, by resource and result.",
+		},
+		[]string{"resource", "result"},
+	)
 )
@@ -15,5 +15,29 @@ func MsSince(start time.Time) float64 {
 func RegisterPrometheus() {
 	prometheus.MustRegister(RouteLatency)
 	prometheus.MustRegister(NodeLatency)
+	prometheus.MustRegister(NodeDeletion)
+	prometheus.MustRegister(NodeLabelDriftRepaired)
+	prometheus.MustRegister(NodesMissingLabels)
+	prometheus.MustRegister(NodeLoopOverruns)
 	prometheus.MustRegister(SLBLatency)
+	prometheus.MustRegister(SLBBackendHealthy)
+	prometheus.MustRegister(SLBBackendUnhealthy)
+	prometheus.MustRegister(SLBOrphanCleanup)
+	prometheus.MustRegister(SLBManaged)
+	prometheus.MustRegister(SLBBackends)
+	prometheus.MustRegister(SLBSingleZoneBackends)
+	prometheus.MustRegister(CredentialReload)
+	prometheus.MustRegister(RamRoleRefreshFailure)
+	prometheus.MustRegister(CloudAPILimiterWait)
+	prometheus.MustRegister(CloudAPIRequestDuration)
+	prometheus.MustRegister(CloudAPIRequestErrors)
+	prometheus.MustRegister(CloudAPICircuitOpen)
+	prometheus.MustRegister(CloudAPICircuitTrips)
+	prometheus.MustRegister(ServiceAPIBudgetExceeded)
+	prometheus.MustRegister(ReconcileTotal)
+	prometheus.MustRegister(ReconcileFailing)
+	prometheus.MustRegister(ServiceRequeues)
+	prometheus.MustRegister(ServicesInBackoff)
+	prometheus.MustRegister(ServiceLastSuccessfulSync)
+	prometheus.MustRegister(ServiceOldestSuccessfulSync)
 }
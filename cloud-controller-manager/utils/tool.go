@@ -11,15 +11,16 @@ import (
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
+// PrettyJson the indented dump of the object for diagnosis, sanitized and
+// truncated as Diagnostic
 func PrettyJson(object interface{}) string {
-	b, err := json.MarshalIndent(object, "", "    ")
-	if err != nil {
-		fmt.Printf("ERROR: PrettyJson, %v\n %s\n", err, b)
-	}
-	return string(b)
+	return diagnostic(object, "    ")
 }
 
 // HashObjects
@@ -79,22 +80,276 @@ func PrettyYaml(obj interface{}) string {
 	return string(bs)
 }
 
+// IsServiceHashChanged whether the service is modified since its hash label
+// was written. A label of the legacy algorithm is compared with the legacy
+// hash, so that an upgrade does not take every service as modified, and a
+// label of an unknown version is recomputed. A missing or malformed label,
+// eg. stripped or edited by hand, always takes the service as modified, so
+// that it is fully reconciled and labeled again.
 func IsServiceHashChanged(service *v1.Service) (bool, error) {
-	if oldHash, ok := service.Labels[LabelServiceHash]; ok {
-		newHash, err := GetServiceHash(service)
-		if err != nil {
-			return true, err
-		}
-		if strings.Compare(newHash, oldHash) == 0 {
-			klog.Infof("service %s/%s hash label not changed, skip", service.Namespace, service.Name)
-			return false, nil
-		}
+	oldHash, ok := service.Labels[LabelServiceHash]
+	if !ok {
+		klog.Infof("service %s/%s has no hash label, reconcile fully", service.Namespace, service.Name)
+		return true, nil
+	}
+	if !IsServiceHashWellFormed(oldHash) {
+		klog.Warningf("service %s/%s hash label %q malformed, reconcile fully", service.Namespace, service.Name, oldHash)
+		return true, nil
+	}
+	var (
+		newHash string
+		err     error
+	)
+	version, _ := splitServiceHash(oldHash)
+	switch version {
+	case ServiceHashVersion:
+		newHash, err = GetServiceHash(service)
+	case "":
+		newHash, err = legacyServiceHash(service)
+	default:
+		klog.Infof("service %s/%s hash label of version %s, recompute", service.Namespace, service.Name, version)
+		return true, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	if strings.Compare(newHash, oldHash) == 0 {
+		klog.Infof("service %s/%s hash label not changed, skip", service.Namespace, service.Name)
+		return false, nil
 	}
 	return true, nil
 }
 
+// GetServiceHash the versioned hash of the fields of the service affecting
+// the loadbalancer, eg. v2.<hash>. Labels do not allow a colon, the version
+// is separated by a dot.
 func GetServiceHash(service *v1.Service) (string, error) {
-	return HashObjects([]interface{}{service.Spec, service.Annotations})
+	// the defaults take part in the hash, a change of them syncs the service
+	b, err := json.Marshal(newServiceHashInput(WithDefaultAnnotations(service)))
+	if err != nil {
+		return "", fmt.Errorf("hash marshal error: %s", err)
+	}
+	return ServiceHashVersion + "." + Hash(string(b)), nil
+}
+
+var (
+	serviceHashPattern        = regexp.MustCompile(`^[0-9a-f]{56}$`)
+	serviceHashVersionPattern = regexp.MustCompile(`^v[0-9]+$`)
+)
+
+// IsServiceHashWellFormed whether the label is a hash label as written by
+// the controllers, of the legacy algorithm or of a version vN, including the
+// versions to come which are recomputed.
+func IsServiceHashWellFormed(label string) bool {
+	version, hash := splitServiceHash(label)
+	if !serviceHashPattern.MatchString(hash) {
+		return false
+	}
+	return version == "" || serviceHashVersionPattern.MatchString(version)
+}
+
+// splitServiceHash the version and the hash of the label, the version of a
+// legacy label is empty.
+func splitServiceHash(label string) (string, string) {
+	i := strings.Index(label, ".")
+	if i < 0 {
+		return "", label
+	}
+	return label[:i], label[i+1:]
+}
+
+// legacyServiceHash the hash of the whole spec and the user annotations,
+// written by the controllers before the hash was versioned.
+func legacyServiceHash(service *v1.Service) (string, error) {
+	return HashObjects([]interface{}{service.Spec, UserAnnotations(service)})
+}
+
+type serviceHashPort struct {
+	Name       string `json:"name"`
+	Protocol   string `json:"protocol"`
+	Port       int32  `json:"port"`
+	TargetPort string `json:"targetPort"`
+	NodePort   int32  `json:"nodePort"`
+	// AppProtocol decides the listener protocol, omitted when unset to keep
+	// the hash of the services without it
+	AppProtocol string `json:"appProtocol,omitempty"`
+}
+
+// serviceHashInput the fields hashed, explicitly enumerated. The slices are
+// sorted and the annotations are pairs, so the encoding is deterministic.
+type serviceHashInput struct {
+	Type                     string            `json:"type"`
+	Ports                    []serviceHashPort `json:"ports"`
+	ExternalTrafficPolicy    string            `json:"externalTrafficPolicy"`
+	SessionAffinity          string            `json:"sessionAffinity"`
+	LoadBalancerSourceRanges []string          `json:"loadBalancerSourceRanges"`
+	Annotations              [][2]string       `json:"annotations"`
+	// Defaults the controller wide defaults, omitted when unset to keep the
+	// hash of the services
+	Defaults string `json:"defaults,omitempty"`
+}
+
+// ServiceHashDefaults the controller wide defaults of the loadbalancers, eg.
+// those of the health checks, hashed along with each service so that a
+// change of them syncs the services. Set at startup.
+var ServiceHashDefaults = ""
+
+func newServiceHashInput(service *v1.Service) serviceHashInput {
+	input := serviceHashInput{
+		Type:                  string(service.Spec.Type),
+		ExternalTrafficPolicy: string(service.Spec.ExternalTrafficPolicy),
+		SessionAffinity:       string(service.Spec.SessionAffinity),
+	}
+	for _, port := range service.Spec.Ports {
+		input.Ports = append(input.Ports, serviceHashPort{
+			Name:       port.Name,
+			Protocol:   string(port.Protocol),
+			Port:       port.Port,
+			TargetPort: port.TargetPort.String(),
+			NodePort:   port.NodePort,
+		})
+		if port.AppProtocol != nil {
+			input.Ports[len(input.Ports)-1].AppProtocol = *port.AppProtocol
+		}
+	}
+	sort.Slice(input.Ports, func(i, j int) bool {
+		if input.Ports[i].Port != input.Ports[j].Port {
+			return input.Ports[i].Port < input.Ports[j].Port
+		}
+		return input.Ports[i].Protocol < input.Ports[j].Protocol
+	})
+	input.LoadBalancerSourceRanges = append(input.LoadBalancerSourceRanges, service.Spec.LoadBalancerSourceRanges...)
+	sort.Strings(input.LoadBalancerSourceRanges)
+	for k, v := range service.Annotations {
+		if isHashedAnnotation(k) {
+			input.Annotations = append(input.Annotations, [2]string{k, v})
+		}
+	}
+	sort.Slice(input.Annotations, func(i, j int) bool {
+		return input.Annotations[i][0] < input.Annotations[j][0]
+	})
+	input.Defaults = ServiceHashDefaults
+	return input
+}
+
+// isHashedAnnotation only the annotations of the alibaba cloud, but the ones
+// written by the controller itself, affect the loadbalancer.
+func isHashedAnnotation(key string) bool {
+	if isControllerAnnotation(key) || key == ServiceAnnotationReconcileTimestamp {
+		// the reconcile timestamp forces a sync by itself, see ReconcileRequested
+		return false
+	}
+	return strings.HasPrefix(key, ServiceAnnotationPrefix) ||
+		strings.HasPrefix(key, ServiceAnnotationLegacyPrefix)
+}
+
+// isControllerAnnotation whether the annotation is written by the controller
+func isControllerAnnotation(key string) bool {
+	return key == ServiceAnnotationLoadBalancerIdResult ||
+		key == ServiceAnnotationReconcileTimestampResult
+}
+
+// UserAnnotations annotations of the service but the ones written by the
+// controller itself, which must not trigger a sync.
+func UserAnnotations(service *v1.Service) map[string]string {
+	_, published := service.Annotations[ServiceAnnotationLoadBalancerIdResult]
+	_, reconciled := service.Annotations[ServiceAnnotationReconcileTimestampResult]
+	if !published && !reconciled {
+		return service.Annotations
+	}
+	var annotations map[string]string
+	for k, v := range service.Annotations {
+		if isControllerAnnotation(k) {
+			continue
+		}
+		if annotations == nil {
+			annotations = make(map[string]string, len(service.Annotations))
+		}
+		annotations[k] = v
+	}
+	// nil as the service never annotated, the hash is the same
+	return annotations
+}
+
+// ReconcileRequested whether the reconcile timestamp of the service is not
+// processed by a succeeded sync yet.
+func ReconcileRequested(service *v1.Service) bool {
+	requested := service.Annotations[ServiceAnnotationReconcileTimestamp]
+	return requested != "" && requested != service.Annotations[ServiceAnnotationReconcileTimestampResult]
+}
+
+// IsForceResync whether the sync ignores the service hash, see
+// ReconcileRequested.
+func IsForceResync(ctx context.Context) bool {
+	force, _ := ctx.Value(ContextForceResync).(bool)
+	return force
+}
+
+// RequeueAfter ask the service controller to sync the service again after
+// the delay, it is a noop when the context has no requeue func.
+func RequeueAfter(ctx context.Context, delay time.Duration) {
+	requeue, ok := ctx.Value(ContextRequeue).(func(time.Duration))
+	if !ok {
+		klog.Warningf("requeue is not supported by the context, requeue after %s skipped", delay)
+		return
+	}
+	requeue(delay)
+}
+
+// PublishLoadBalancerId report the id of the ensured or the deleted
+// loadbalancer to the service controller, it is a noop when the context has
+// no publish func.
+func PublishLoadBalancerId(ctx context.Context, id string) {
+	publish, ok := ctx.Value(ContextLoadBalancerId).(func(string))
+	if !ok {
+		klog.V(5).Infof("publish is not supported by the context, loadbalancer id %s skipped", id)
+		return
+	}
+	publish(id)
+}
+
+// PublishBackends report the count of the backends of the ensured loadbalancer
+// to the service controller, it is a noop when the context has no publish func.
+func PublishBackends(ctx context.Context, count int) {
+	publish, ok := ctx.Value(ContextBackends).(func(int))
+	if !ok {
+		klog.V(5).Infof("publish is not supported by the context, backends %d skipped", count)
+		return
+	}
+	publish(count)
+}
+
+// PublishBackendZones report the count of the backends of the ensured
+// loadbalancer by zone to the service controller, it is a noop when the
+// context has no publish func.
+func PublishBackendZones(ctx context.Context, zones map[string]int) {
+	publish, ok := ctx.Value(ContextBackendZones).(func(map[string]int))
+	if !ok {
+		klog.V(5).Infof("publish is not supported by the context, backend zones %v skipped", zones)
+		return
+	}
+	publish(zones)
+}
+
+// PublishBackendNodes report the names of the nodes added as backends of the
+// ensured loadbalancer to the service controller, it is a noop when the
+// context has no publish func.
+func PublishBackendNodes(ctx context.Context, nodes []string) {
+	publish, ok := ctx.Value(ContextBackendNodes).(func([]string))
+	if !ok {
+		klog.V(5).Infof("publish is not supported by the context, %d backend nodes skipped", len(nodes))
+		return
+	}
+	publish(nodes)
+}
+
+// NodeZone zone of the node by the topology label, or the deprecated
+// failure-domain label.
+func NodeZone(node *v1.Node) string {
+	if zone, ok := node.Labels[LabelTopologyZone]; ok {
+		return zone
+	}
+	return node.Labels[LabelFailureDomainZone]
 }
 
 func GetRecorderFromContext(ctx context.Context) (record.EventRecorder, error) {
@@ -110,16 +365,3 @@ func GetRecorderFromContext(ctx context.Context) (record.EventRecorder, error) {
 
 	return r, nil
 }
-
-func IsExcludedNode(node *v1.Node) bool {
-	if node == nil || node.Labels == nil {
-		return false
-	}
-	if _, exclude := node.Labels[LabelNodeRoleExcludeNodeDeprecated]; exclude {
-		return true
-	}
-	if _, exclude := node.Labels[LabelNodeRoleExcludeNode]; exclude {
-		return true
-	}
-	return false
-}
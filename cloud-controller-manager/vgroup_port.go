@@ -0,0 +1,192 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The vgroup-port annotation maps service ports to the existing vserver groups
+// of a user managed loadbalancer, e.g. "rsp-xxx:443,rsp-yyy:80". Only the
+// backends of these vserver groups are reconciled, the groups are neither
+// renamed nor deleted with the service.
+
+// parseVGroupPort vserver group ids of the annotation by service port
+func parseVGroupPort(value string) (map[int32]string, error) {
+	ports := map[int32]string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pair := strings.Split(item, ":")
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return nil, fmt.Errorf("[%s] must be of format ${vgroup-id}:${port}", item)
+		}
+		port, err := strconv.Atoi(strings.TrimSpace(pair[1]))
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("port of [%s] must be an integer in range [1, 65535]", item)
+		}
+		if _, ok := ports[int32(port)]; ok {
+			return nil, fmt.Errorf("port %d is mapped more than once", port)
+		}
+		ports[int32(port)] = strings.TrimSpace(pair[0])
+	}
+	return ports, nil
+}
+
+// ValidateVGroupPort the vserver groups must be mapped to the ports of the
+// service on a user managed loadbalancer.
+func ValidateVGroupPort(service *v1.Service) error {
+	value := serviceAnnotation(service, ServiceAnnotationLoadBalancerVGroupPort)
+	if value == "" {
+		return nil
+	}
+	ports, err := parseVGroupPort(value)
+	if err != nil {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerVGroupPort,
+			token:      value,
+			reason:     err.Error(),
+		}
+	}
+	if !isUserDefinedLoadBalancer(service) {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerVGroupPort,
+			token:      value,
+			reason:     fmt.Sprintf("requires the loadbalancer specified by %s", ServiceAnnotationLoadBalancerId),
+		}
+	}
+	for port := range ports {
+		found := false
+		for _, p := range service.Spec.Ports {
+			if p.Port == port {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return annotationError{
+				annotation: ServiceAnnotationLoadBalancerVGroupPort,
+				token:      value,
+				reason:     fmt.Sprintf("port %d is not a port of the service", port),
+			}
+		}
+	}
+	return nil
+}
+
+// verifyUserManaged the vserver group of the annotation must exist on the
+// loadbalancer.
+func (v *vgroup) verifyUserManaged(ctx context.Context) error {
+	vgrp, err := v.Client.DescribeVServerGroups(
+		ctx,
+		&slb.DescribeVServerGroupsArgs{
+			RegionId:       v.RegionId,
+			LoadBalancerId: v.LoadBalancerId,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("describe: vgroup error, %s", err.Error())
+	}
+	for _, val := range vgrp.VServerGroups.VServerGroup {
+		if val.VServerGroupId == v.VGroupId {
+			return nil
+		}
+	}
+	return fmt.Errorf("vserver group %s of annotation %s is not found on loadbalancer %s",
+		v.VGroupId, ServiceAnnotationLoadBalancerVGroupPort, v.LoadBalancerId)
+}
+
+// vgroupConflicts the other services in the cluster mapping to the same
+// vserver groups as service, by vserver group id.
+func vgroupConflicts(lister corelisters.ServiceLister, service *v1.Service) (map[string][]string, error) {
+	ports, err := parseVGroupPort(serviceAnnotation(service, ServiceAnnotationLoadBalancerVGroupPort))
+	if err != nil || len(ports) == 0 {
+		return nil, err
+	}
+	ids := map[string]bool{}
+	for _, id := range ports {
+		ids[id] = true
+	}
+	svcs, err := lister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("list services: %s", err.Error())
+	}
+	conflicts := map[string][]string{}
+	for _, svc := range svcs {
+		if svc.UID == service.UID {
+			continue
+		}
+		others, err := parseVGroupPort(serviceAnnotation(svc, ServiceAnnotationLoadBalancerVGroupPort))
+		if err != nil {
+			continue
+		}
+		for _, id := range others {
+			if ids[id] {
+				conflicts[id] = append(conflicts[id], fmt.Sprintf("%s/%s", svc.Namespace, svc.Name))
+			}
+		}
+	}
+	for id := range conflicts {
+		sort.Strings(conflicts[id])
+	}
+	return conflicts, nil
+}
+
+// recordVGroupConflicts warn of the vserver groups shared with other
+// services, their backends overwrite each other.
+func (c *Cloud) recordVGroupConflicts(ctx context.Context, service *v1.Service) {
+	if serviceAnnotation(service, ServiceAnnotationLoadBalancerVGroupPort) == "" {
+		return
+	}
+	conflicts, err := vgroupConflicts(c.ifactory.Core().V1().Services().Lister(), service)
+	if err != nil {
+		klog.Warningf("check vserver group conflicts: %s", err.Error())
+		return
+	}
+	if len(conflicts) == 0 {
+		return
+	}
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+	}
+	for id, svcs := range conflicts {
+		if record == nil {
+			utils.Logf(service, "vserver group %s is also used by services %v", id, svcs)
+			continue
+		}
+		record.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"VGroupConflict",
+			"Vserver group %s is also used by services %s, their backends overwrite each other",
+			id, strings.Join(svcs, ", "),
+		)
+	}
+}
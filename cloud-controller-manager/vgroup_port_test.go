@@ -0,0 +1,278 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	USER_VGROUP_ID   = "rsp-terraform-443"
+	USER_VGROUP_NAME = "terraform-443"
+)
+
+// WithUserVGroup vserver group provisioned by the user on the loadbalancer,
+// with a backend of a node gone.
+func WithUserVGroup() CloudDataMock {
+	return func() {
+		vg := slb.CreateVServerGroupResponse{
+			VServerGroupId:   USER_VGROUP_ID,
+			VServerGroupName: USER_VGROUP_NAME,
+		}
+		vg.BackendServers.BackendServer = []slb.VBackendServerType{
+			{ServerId: "i-gone", Port: 31443, Weight: 100, Type: "ecs"},
+		}
+		LOADBALANCER.vgroups.Store(vgroupKey(LOADBALANCER_ID, USER_VGROUP_ID), vg)
+	}
+}
+
+func TestValidateVGroupPort(t *testing.T) {
+	cases := []struct {
+		annotations map[string]string
+		invalid     bool
+	}{
+		{annotations: map[string]string{}},
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerId:         LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerVGroupPort: "rsp-xxx:443,rsp-yyy:80",
+		}},
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerId:         LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerVGroupPort: " rsp-xxx:443 ",
+		}},
+		// the loadbalancer is created by the controller
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerVGroupPort: "rsp-xxx:443",
+		}, invalid: true},
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerId:         LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerVGroupPort: "rsp-xxx",
+		}, invalid: true},
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerId:         LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerVGroupPort: ":443",
+		}, invalid: true},
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerId:         LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerVGroupPort: "rsp-xxx:https",
+		}, invalid: true},
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerId:         LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerVGroupPort: "rsp-xxx:443,rsp-yyy:443",
+		}, invalid: true},
+		// not a port of the service
+		{annotations: map[string]string{
+			ServiceAnnotationLoadBalancerId:         LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerVGroupPort: "rsp-xxx:8443",
+		}, invalid: true},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: c.annotations},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: 80, Protocol: v1.ProtocolTCP},
+					{Port: 443, Protocol: v1.ProtocolTCP},
+				},
+			},
+		}
+		err := ValidateVGroupPort(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("%v: unexpected error: %s", c.annotations, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%v: expect InvalidAnnotation error, got %v", c.annotations, err)
+		}
+	}
+}
+
+func TestVGroupConflicts(t *testing.T) {
+	annotated := func(name, uid, vgroupPort string) *v1.Service {
+		return testServiceNamed(name, uid, map[string]string{
+			ServiceAnnotationLoadBalancerId:         LOADBALANCER_ID,
+			ServiceAnnotationLoadBalancerVGroupPort: vgroupPort,
+		})
+	}
+	service := annotated("web", "uid-web", "rsp-xxx:443,rsp-yyy:80")
+	lister := gcLister(
+		t,
+		service,
+		annotated("web-canary", "uid-canary", "rsp-xxx:443"),
+		annotated("api", "uid-api", "rsp-zzz:443"),
+		testServiceNamed("plain", "uid-plain", nil),
+	)
+	conflicts, err := vgroupConflicts(lister, service)
+	if err != nil {
+		t.Fatalf("vgroup conflicts: %s", err.Error())
+	}
+	expect := map[string][]string{"rsp-xxx": {"default/web-canary"}}
+	if !reflect.DeepEqual(conflicts, expect) {
+		t.Fatalf("expect conflicts %v, got %v", expect, conflicts)
+	}
+}
+
+func TestVGroupPort(t *testing.T) {
+	prid1 := nodeid(string(REGION), INSTANCEID)
+	prid2 := nodeid(string(REGION), INSTANCEID2)
+	f := NewDefaultFrameWork(
+		func() {
+			DefaultPreset()
+			PreSetCloudData(WithUserVGroup())
+		},
+	)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerId:               LOADBALANCER_ID,
+					ServiceAnnotationLoadBalancerOverrideListener: "true",
+					ServiceAnnotationLoadBalancerVGroupPort:       USER_VGROUP_ID + ":443",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: 443, TargetPort: intstr.FromInt(443), Protocol: v1.ProtocolTCP, NodePort: 31443},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid1},
+				Spec:       v1.NodeSpec{ProviderID: prid1},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid2},
+				Spec:       v1.NodeSpec{ProviderID: prid2},
+			},
+		},
+	)
+
+	ensure := func(f *FrameWork, nodes []*v1.Node) error {
+		_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, nodes)
+		return err
+	}
+	// the vserver groups on the loadbalancer by id
+	vgroupsOf := func(f *FrameWork) (map[string]string, error) {
+		res, err := f.SLBSDK().DescribeVServerGroups(
+			context.Background(),
+			&slb.DescribeVServerGroupsArgs{LoadBalancerId: LOADBALANCER_ID},
+		)
+		if err != nil {
+			return nil, err
+		}
+		vgs := map[string]string{}
+		for _, v := range res.VServerGroups.VServerGroup {
+			vgs[v.VServerGroupId] = v.VServerGroupName
+		}
+		return vgs, nil
+	}
+	expectUserVGroup := func(f *FrameWork, backends ...string) error {
+		vgs, err := vgroupsOf(f)
+		if err != nil {
+			return err
+		}
+		expect := map[string]string{USER_VGROUP_ID: USER_VGROUP_NAME}
+		if !reflect.DeepEqual(vgs, expect) {
+			return fmt.Errorf("expect vserver groups %v, got %v", expect, vgs)
+		}
+		att, err := f.SLBSDK().DescribeVServerGroupAttribute(
+			context.Background(),
+			&slb.DescribeVServerGroupAttributeArgs{VServerGroupId: USER_VGROUP_ID},
+		)
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for _, b := range att.BackendServers.BackendServer {
+			ids = append(ids, b.ServerId)
+		}
+		sort.Strings(ids)
+		sort.Strings(backends)
+		if !reflect.DeepEqual(ids, backends) {
+			return fmt.Errorf("expect backends %v, got %v", backends, ids)
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Listener is bound to the user vserver group",
+		func(f *FrameWork) error {
+			if err := ensure(f, f.Nodes); err != nil {
+				return err
+			}
+			if err := expectUserVGroup(f, INSTANCEID, INSTANCEID2); err != nil {
+				return err
+			}
+			listener, err := f.SLBSDK().DescribeLoadBalancerTCPListenerAttribute(context.Background(), LOADBALANCER_ID, 443)
+			if err != nil || listener == nil {
+				return fmt.Errorf("describe listener 443: %v", err)
+			}
+			if listener.VServerGroupId != USER_VGROUP_ID {
+				return fmt.Errorf("expect listener bound to %s, got %s", USER_VGROUP_ID, listener.VServerGroupId)
+			}
+			return nil
+		},
+	)
+
+	f.RunCustomized(t, "Backends of the user vserver group are reconciled",
+		func(f *FrameWork) error {
+			if err := ensure(f, f.Nodes[:1]); err != nil {
+				return err
+			}
+			return expectUserVGroup(f, INSTANCEID)
+		},
+	)
+
+	f.RunCustomized(t, "User vserver group is kept on deletion",
+		func(f *FrameWork) error {
+			if err := f.CloudImpl().EnsureLoadBalancerDeleted(context.Background(), CLUSTER_ID, f.SVC); err != nil {
+				return err
+			}
+			return expectUserVGroup(f, INSTANCEID)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerVGroupPort] = "rsp-missing:443"
+	f.RunCustomized(t, "Missing vserver group is reported",
+		func(f *FrameWork) error {
+			err := ensure(f, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), "rsp-missing") {
+				return fmt.Errorf("expect error of the missing vserver group, got %v", err)
+			}
+			return nil
+		},
+	)
+}
@@ -11,6 +11,8 @@ import (
 	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
 	"k8s.io/klog"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -23,6 +25,29 @@ type vgroup struct {
 	Client         ClientSLBSDK
 	InsClient      ClientInstanceSDK
 	BackendServers []slb.VBackendServerType
+	// Weight of the backends from the weight annotation, 0 for the default
+	Weight int
+	// PodCountWeighted weight the backends by the pods on the node
+	PodCountWeighted bool
+	// DrainSeconds time the removed backends are kept with weight 0
+	DrainSeconds int
+	// ExistingNodes instance ids of the nodes in the cluster
+	ExistingNodes map[string]bool
+	// VGroupName name of the vserver group from the api, set for the remote ones
+	VGroupName string
+	// UserManaged vserver group of the vgroup-port annotation, only its
+	// backends are reconciled
+	UserManaged bool
+	// KeepLastBackends the backends are not removed when none is left
+	KeepLastBackends bool
+	// GuardEmptyBackends the backends are kept for a while when none is computed
+	GuardEmptyBackends bool
+	// EmptyBackendsSkipped the update is skipped by the empty backends guard
+	EmptyBackendsSkipped bool
+	// NamedTargetPort the target port is named, resolved by each pod to its
+	// container port of the service port of ServicePortName
+	NamedTargetPort bool
+	ServicePortName string
 }
 
 func (v *vgroup) Logf(format string, args ...interface{}) {
@@ -37,24 +62,51 @@ func (v *vgroup) Describe(ctx context.Context) error {
 	if v.NamedKey == nil {
 		return fmt.Errorf("describe: format error of vgroup name")
 	}
+	if v.UserManaged {
+		return v.verifyUserManaged(ctx)
+	}
 	vargs := slb.DescribeVServerGroupsArgs{
 		RegionId:       v.RegionId,
 		LoadBalancerId: v.LoadBalancerId,
 	}
 	vgrp, err := v.Client.DescribeVServerGroups(ctx, &vargs)
 	if err != nil {
-		return fmt.Errorf("describe: vgroup error, %s", err.Error())
+		return fmt.Errorf("describe: vgroup error, %w", err)
 	}
 	if vgrp != nil {
 		for _, val := range vgrp.VServerGroups.VServerGroup {
 			if val.VServerGroupName ==
-				v.NamedKey.Key() {
+				v.NamedKey.VGroupName() {
 				v.VGroupId = val.VServerGroupId
 				return nil
 			}
 		}
+		// created by an older version, rename it instead of creating another one
+		for _, val := range vgrp.VServerGroups.VServerGroup {
+			if val.VServerGroupName ==
+				v.NamedKey.Key() {
+				v.VGroupId = val.VServerGroupId
+				return v.rename(ctx, val.VServerGroupName)
+			}
+		}
 	}
-	return fmt.Errorf("vgroup not found, %s", v.NamedKey.Key())
+	return fmt.Errorf("vgroup not found, %s", v.NamedKey.VGroupName())
+}
+
+func (v *vgroup) rename(ctx context.Context, former string) error {
+	v.Logf("rename vserver group [%s] from [%s] to [%s]", v.VGroupId, former, v.NamedKey.VGroupName())
+	_, err := v.Client.SetVServerGroupAttribute(
+		ctx,
+		&slb.SetVServerGroupAttributeArgs{
+			VServerGroupId:   v.VGroupId,
+			RegionId:         v.RegionId,
+			VServerGroupName: v.NamedKey.VGroupName(),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("rename vserver group [%s]: %w", v.VGroupId, err)
+	}
+	return nil
 }
 func (v *vgroup) Add(ctx context.Context) error {
 	if v.VGroupId != "" {
@@ -65,7 +117,7 @@ func (v *vgroup) Add(ctx context.Context) error {
 	}
 	vgp := slb.CreateVServerGroupArgs{
 		LoadBalancerId:   v.LoadBalancerId,
-		VServerGroupName: v.NamedKey.Key(),
+		VServerGroupName: v.NamedKey.VGroupName(),
 		RegionId:         v.RegionId,
 	}
 
@@ -73,16 +125,16 @@ func (v *vgroup) Add(ctx context.Context) error {
 		// work around for vserver group old version,it needs backend on creating.
 		backend, err := json.Marshal(v.BackendServers[0:1])
 		if err != nil {
-			return fmt.Errorf("add new vserver group: %s", err.Error())
+			return fmt.Errorf("add new vserver group: %w", err)
 		}
 		vgp.BackendServers = string(backend)
 	}
 	gp, err := v.Client.CreateVServerGroup(ctx, &vgp)
 	if err != nil {
-		return fmt.Errorf("CreateVServerGroup. %s", err.Error())
+		return fmt.Errorf("CreateVServerGroup. %w", err)
 	}
 	v.Logf("create new vserver group[%s]"+
-		" for loadbalancer[%s] with empty backend list", v.NamedKey.Key(), v.LoadBalancerId)
+		" for loadbalancer[%s] with empty backend list", v.NamedKey.VGroupName(), v.LoadBalancerId)
 	v.VGroupId = gp.VServerGroupId
 	return nil
 }
@@ -95,6 +147,10 @@ func (v *vgroup) Remove(ctx context.Context) error {
 		RegionId:       v.RegionId,
 	}
 	_, err := v.Client.DeleteVServerGroup(ctx, &vdel)
+	if utils.IsCloudNotFound(err) {
+		v.Logf("vserver group [%s] is gone already. %s", v.VGroupId, err.Error())
+		return nil
+	}
 	return err
 }
 func (v *vgroup) Update(ctx context.Context) error {
@@ -102,7 +158,7 @@ func (v *vgroup) Update(ctx context.Context) error {
 		err := v.Describe(ctx)
 		if err != nil {
 			if !strings.Contains(err.Error(), "not found") {
-				return fmt.Errorf("update: vserver group error, %s", err.Error())
+				return fmt.Errorf("update: vserver group error, %w", err)
 			}
 			if err := v.Add(ctx); err != nil {
 				return err
@@ -117,10 +173,26 @@ func (v *vgroup) Update(ctx context.Context) error {
 	}
 	att, err := v.Client.DescribeVServerGroupAttribute(ctx, dsc)
 	if err != nil {
-		return fmt.Errorf("update: describe vserver group attribute error. %s", err.Error())
+		return fmt.Errorf("update: describe vserver group attribute error. %w", err)
 	}
 	v.Logf("update: apis[%v], node[%v]", att.BackendServers.BackendServer, v.BackendServers)
+	if v.PodCountWeighted {
+		v.BackendServers = withWeightHysteresis(att.BackendServers.BackendServer, v.BackendServers)
+	}
 	add, del, update := v.diff(att.BackendServers.BackendServer, v.BackendServers)
+	if v.KeepLastBackends && len(v.BackendServers) == 0 && len(del) > 0 {
+		// eg. the pods are scaled to zero, a loadbalancer without backends drops all the traffic
+		v.Logf("update: no backend left, keep the last %d backends of vgroup [%s]", len(del), v.VGroupId)
+		del = nil
+	}
+	if v.GuardEmptyBackends && len(v.BackendServers) == 0 && len(del) > 0 {
+		// eg. a rolling restart of all the pods, retry before emptying the vgroup
+		v.Logf("update: no backend computed, skip removing the %d backends of vgroup [%s]", len(del), v.VGroupId)
+		v.EmptyBackendsSkipped = true
+		utils.RequeueAfter(ctx, EMPTY_BACKENDS_RECHECK_INTERVAL)
+		return nil
+	}
+	del, update = v.drain(ctx, del, update)
 	if len(add) == 0 && len(del) == 0 && len(update) == 0 {
 		v.Logf("update: no backend need to be added for vgroupid [%s]", v.VGroupId)
 		return nil
@@ -190,8 +262,8 @@ func (v *vgroup) Update(ctx context.Context) error {
 	return nil
 }
 
-// MAX_BACKEND_NUM max batch backend num
-const MAX_BACKEND_NUM = 39
+// MAX_BACKEND_NUM max backends of an Add/Remove/SetVServerGroupAttribute call
+const MAX_BACKEND_NUM = 20
 
 type Func func([]interface{}) error
 
@@ -237,77 +309,53 @@ func (v *vgroup) diff(apis, nodes []slb.VBackendServerType) (
 		updates   []slb.VBackendServerType
 	)
 
+	current := make(map[string]slb.VBackendServerType, len(apis))
 	for _, api := range apis {
-		found := false
-		for _, node := range nodes {
-			if node.Type == "eni" {
-				if api.ServerId == node.ServerId &&
-					api.ServerIp == node.ServerIp {
-					found = true
-					break
-				}
-			} else {
-				if api.ServerId == node.ServerId {
-					found = true
-					break
-				}
-			}
-
-		}
-		if !found {
-			deletions = append(deletions, api)
-		}
+		current[backendKey(api)] = api
 	}
+	desired := make(map[string]bool, len(nodes))
 	for _, node := range nodes {
-		found := false
-		for _, api := range apis {
-			if node.Type == "eni" {
-				if api.ServerId == node.ServerId &&
-					api.ServerIp == node.ServerIp {
-					found = true
-					break
-				}
-			} else {
-				if api.ServerId == node.ServerId {
-					found = true
-					break
-				}
-			}
-
-		}
-		if !found {
+		key := backendKey(node)
+		desired[key] = true
+		api, ok := current[key]
+		if !ok {
 			addition = append(addition, node)
+			continue
+		}
+		if node.Weight != api.Weight ||
+			api.Description != node.Description {
+			updates = append(updates, node)
 		}
 	}
-	for _, node := range nodes {
-		for _, api := range apis {
-			if node.Type == "eni" {
-				if node.ServerId == api.ServerId &&
-					node.ServerIp == api.ServerIp &&
-					(node.Weight != api.Weight ||
-						api.Description != v.NamedKey.Key()) {
-					updates = append(updates, node)
-					break
-				}
-			} else {
-				if node.ServerId == api.ServerId &&
-					(node.Weight != api.Weight ||
-						api.Description != v.NamedKey.Key()) {
-					updates = append(updates, node)
-					break
-				}
-			}
+	for _, api := range apis {
+		if !desired[backendKey(api)] {
+			deletions = append(deletions, api)
 		}
 	}
 	return addition, deletions, updates
 }
 
+// backendKey identity of a backend in the vserver group. An eni backend is
+// identified by its ip as well, the eni id is shared by the pods on it.
+func backendKey(b slb.VBackendServerType) string {
+	if b.Type == "eni" {
+		return fmt.Sprintf("%s/%s/%d", b.ServerId, b.ServerIp, b.Port)
+	}
+	return fmt.Sprintf("%s/%d", b.ServerId, b.Port)
+}
+
 func Ensure(ctx context.Context, v *vgroup, nodes *EndpointWithENI) error {
+	if v.UserManaged {
+		if err := v.Describe(ctx); err != nil {
+			return err
+		}
+	}
 	backend, err := nodes.BuildBackend(ctx, v)
 	if err != nil {
 		return fmt.Errorf("build backend: %s, %s", err.Error(), v.NamedKey)
 	}
 	v.BackendServers = backend
+	v.ExistingNodes = nodes.ExistingNodes
 	return v.Update(ctx)
 }
 
@@ -322,54 +370,79 @@ func EnsureVirtualGroups(ctx context.Context, vgrps *vgroups, nodes *EndpointWit
 		if err := Ensure(ctx, v, nodes); err != nil {
 			return fmt.Errorf("ensure vgroup: %s. %s", err.Error(), v.NamedKey.Key())
 		}
-		v.Logf("EnsureGroup: id=[%s], Name:[%s], LoadBalancerId:[%s]", v.VGroupId, v.NamedKey.Key(), v.LoadBalancerId)
+		v.Logf("EnsureGroup: id=[%s], Name:[%s], LoadBalancerId:[%s]", v.VGroupId, v.NamedKey.VGroupName(), v.LoadBalancerId)
 	}
 	return nil
 }
 
-//CleanUPVGroupMerged Merge with service port and do clean vserver group
-func CleanUPVGroupMerged(
-	ctx context.Context,
-	slbins *LoadBalancerClient,
-	service *v1.Service,
-	lb *slb.LoadBalancerType,
-	local *vgroups,
-) error {
-
-	remote, err := BuildVirtualGroupFromRemoteAPI(ctx, lb, slbins)
-	if err != nil {
-		return fmt.Errorf("build vserver group from remote: %s", err.Error())
+// backendCount the distinct backends of the vserver groups as ensured
+func (vgrps *vgroups) backendCount() int {
+	backends := map[string]bool{}
+	for _, v := range *vgrps {
+		for _, b := range v.BackendServers {
+			backends[fmt.Sprintf("%s/%s", b.ServerId, b.ServerIp)] = true
+		}
 	}
-	for _, rem := range remote {
-		if rem.NamedKey.ServiceName != service.Name ||
-			rem.NamedKey.Namespace != service.Namespace ||
-			rem.NamedKey.CID != CLUSTER_ID {
-			// skip those which does not belong to this service
-			continue
+	return len(backends)
+}
+
+// backendZones the distinct ecs backends of the vserver groups as ensured by
+// the zone of their nodes. The backends which are not nodes, eg. eni and eci,
+// and the nodes without a zone label are left out.
+func (vgrps *vgroups) backendZones(nodes []*v1.Node) map[string]int {
+	zoneOf := map[string]string{}
+	for _, node := range nodes {
+		if _, id, err := nodeFromProviderID(node.Spec.ProviderID); err == nil {
+			zoneOf[string(id)] = utils.NodeZone(node)
 		}
-		found := false
-		for _, svc := range *local {
-			if rem.NamedKey.Port == svc.NamedKey.Port {
-				found = true
-				break
+	}
+	backends := map[string]bool{}
+	zones := map[string]int{}
+	for _, v := range *vgrps {
+		for _, b := range v.BackendServers {
+			zone := zoneOf[b.ServerId]
+			if zone == "" || backends[b.ServerId] {
+				continue
 			}
+			backends[b.ServerId] = true
+			zones[zone]++
 		}
-		if !found {
-			rem.Logf("try to remove unused vserver group, [%s][%s]", rem.NamedKey.Key(), rem.VGroupId)
-			err := rem.Remove(ctx)
-			if err != nil {
-				rem.Logf("Error: cleanup vgroup warining: "+
-					"failed to remove vgroup[%s]. wait for next try. %s", rem.NamedKey.Key(), err.Error())
-				return err
+	}
+	return zones
+}
+
+// backendNodes the names of the nodes added as backends of the vserver
+// groups as ensured, sorted
+func (vgrps *vgroups) backendNodes(nodes []*v1.Node) []string {
+	nameOf := map[string]string{}
+	for _, node := range nodes {
+		if _, id, err := nodeFromProviderID(node.Spec.ProviderID); err == nil {
+			nameOf[string(id)] = node.Name
+		}
+	}
+	backends := map[string]bool{}
+	for _, v := range *vgrps {
+		for _, b := range v.BackendServers {
+			if name := nameOf[b.ServerId]; name != "" {
+				backends[name] = true
 			}
 		}
 	}
-	return nil
+	names := []string{}
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 //CleanUPVGroupDirect do clean vserver group
 func CleanUPVGroupDirect(ctx context.Context, local *vgroups) error {
 	for _, vg := range *local {
+		if vg.UserManaged {
+			vg.Logf("skip user managed vgroup [%s]", vg.VGroupId)
+			continue
+		}
 		if vg.VGroupId == "" {
 			err := vg.Describe(ctx)
 			if err != nil {
@@ -396,12 +469,15 @@ func BuildVirtualGroupFromService(
 	service *v1.Service,
 	slbins *slb.LoadBalancerType,
 ) *vgroups {
+	_, request := ExtractAnnotationRequest(service)
+	// invalid annotation is rejected by ValidateVGroupPort
+	vgroupPorts, _ := parseVGroupPort(request.VGroupPort)
 	vgrps := vgroups{}
 	for _, port := range service.Spec.Ports {
 		vg := &vgroup{
 			NamedKey: &NamedKey{
 				CID:         CLUSTER_ID,
-				Port:        port.NodePort,
+				Port:        backendPort(service, port),
 				TargetPort:  port.TargetPort.IntVal,
 				Namespace:   service.Namespace,
 				ServiceName: service.Name,
@@ -412,9 +488,17 @@ func BuildVirtualGroupFromService(
 			RegionId:       common.Region(client.region),
 			InsClient:      client.ins,
 			VpcID:          client.vpcid,
-		}
-		if IsENIBackendType(service) {
-			vg.NamedKey.Port = port.TargetPort.IntVal
+			Weight:         request.Weight,
+			DrainSeconds:   request.GracefulDrainSeconds,
+		}
+		vg.KeepLastBackends = request.KeepLastBackends == "on"
+		vg.GuardEmptyBackends = isEmptyBackendsGuarded(service)
+		vg.PodCountWeighted = isPodCountWeighted(service)
+		vg.NamedTargetPort = isNamedTargetPort(port)
+		vg.ServicePortName = port.Name
+		if id, ok := vgroupPorts[port.Port]; ok {
+			vg.VGroupId = id
+			vg.UserManaged = true
 		}
 		vgrps = append(vgrps, vg)
 	}
@@ -434,10 +518,14 @@ func BuildVirtualGroupFromRemoteAPI(
 	}
 	vgrp, err := slbins.c.DescribeVServerGroups(ctx, &vargs)
 	if err != nil {
-		return vgrps, fmt.Errorf("list: vgroup error, %s", err.Error())
+		return vgrps, fmt.Errorf("list: vgroup error, %w", err)
 	}
 	for _, val := range vgrp.VServerGroups.VServerGroup {
-		key, err := LoadNamedKey(val.VServerGroupName)
+		key, err := loadVGroupName(val.VServerGroupName)
+		if err != nil {
+			// named by an older version
+			key, err = LoadNamedKey(val.VServerGroupName)
+		}
 		if err != nil {
 			klog.Warningf("we just en-counted an "+
 				"unexpected vserver group name: [%s]. Assume user managed "+
@@ -454,6 +542,7 @@ func BuildVirtualGroupFromRemoteAPI(
 				Client:         slbins.c,
 				RegionId:       common.Region(slbins.region),
 				VGroupId:       val.VServerGroupId,
+				VGroupName:     val.VServerGroupName,
 			},
 		)
 	}
@@ -499,6 +588,15 @@ type EndpointWithENI struct {
 	// It is the direct pod location information which cloud implementation
 	// may needed for some kind of filtering. eg. direct ENI attach.
 	Endpoints *v1.Endpoints
+
+	// ExistingNodes
+	// instance ids of the nodes in the cluster, including those filtered out.
+	// It is set only when graceful drain is enabled.
+	ExistingNodes map[string]bool
+
+	// SkippedIPs
+	// endpoint addresses without eni in eni mode, they are not added as backends.
+	SkippedIPs map[string]bool
 }
 
 // build backend function
@@ -508,6 +606,10 @@ func (v *EndpointWithENI) buildFunc(
 	g *vgroup,
 ) func(o []interface{}) error {
 
+	var resolved map[string]int
+	if g.NamedTargetPort {
+		resolved = resolveTargetPorts(v.Endpoints, g.ServicePortName)
+	}
 	// backend build function
 	return func(o []interface{}) error {
 		var ips []string
@@ -526,22 +628,37 @@ func (v *EndpointWithENI) buildFunc(
 		}
 		resp, err := g.InsClient.DescribeNetworkInterfaces(ctx, targs)
 		if err != nil {
-			return fmt.Errorf("call DescribeNetworkInterfaces: %s", err.Error())
+			return fmt.Errorf("call DescribeNetworkInterfaces: %w", err)
 		}
 		for _, ip := range ips {
 			eniid, err := findENIbyAddrIP(resp, ip)
 			if err != nil {
+				if v.BackendTypeENI {
+					// pod on a node without eni support
+					klog.Warningf("%s: skip backend %s, %s", g.NamedKey, ip, err.Error())
+					v.skipENI(ip)
+					continue
+				}
 				return err
 			}
+			port := int(g.NamedKey.TargetPort)
+			if g.NamedTargetPort {
+				p, ok := resolved[ip]
+				if !ok {
+					klog.Warningf("%s: skip backend %s, target port %s is not resolved", g.NamedKey, ip, g.ServicePortName)
+					continue
+				}
+				port = p
+			}
 			*backend = append(
 				*backend,
 				slb.VBackendServerType{
 					ServerId:    eniid,
 					Weight:      DEFAULT_SERVER_WEIGHT,
 					Type:        "eni",
-					Port:        int(g.NamedKey.TargetPort),
+					Port:        port,
 					ServerIp:    ip,
-					Description: g.NamedKey.Key(),
+					Description: backendDescription(v.podName(ip)),
 				},
 			)
 		}
@@ -554,7 +671,45 @@ func (v *EndpointWithENI) BuildBackend(ctx context.Context, g *vgroup) ([]slb.VB
 	if err != nil {
 		return backend, fmt.Errorf("build backend: %s", err.Error())
 	}
-	return v.nodeWeightWithMerge(backend)
+	backend, err = v.nodeWeightWithMerge(backend)
+	if err != nil {
+		return backend, err
+	}
+	if g.PodCountWeighted {
+		return v.podCountWeights(backend), nil
+	}
+	if g.Weight == 0 {
+		return backend, nil
+	}
+	// the weight annotation goes over the default and the merged ones,
+	// a changed weight is updated in place by the diff.
+	for i := range backend {
+		backend[i].Weight = g.Weight
+	}
+	return backend, nil
+}
+
+// MIN_SERVER_WEIGHT, MAX_SERVER_WEIGHT range of the weight annotation,
+// a backend of weight 0 receives no traffic at all.
+const (
+	MIN_SERVER_WEIGHT = 1
+	MAX_SERVER_WEIGHT = 100
+)
+
+// ValidateBackendWeight the weight annotation must be in range
+func ValidateBackendWeight(service *v1.Service) error {
+	weight := serviceAnnotation(service, ServiceAnnotationLoadBalancerWeight)
+	if weight == "" {
+		return nil
+	}
+	if i, err := strconv.Atoi(weight); err != nil || i < MIN_SERVER_WEIGHT || i > MAX_SERVER_WEIGHT {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerWeight,
+			token:      weight,
+			reason:     fmt.Sprintf("must be an integer in range [%d, %d]", MIN_SERVER_WEIGHT, MAX_SERVER_WEIGHT),
+		}
+	}
+	return nil
 }
 
 func (v *EndpointWithENI) doBackendBuild(ctx context.Context, g *vgroup) ([]slb.VBackendServerType, error) {
@@ -577,7 +732,7 @@ func (v *EndpointWithENI) doBackendBuild(ctx context.Context, g *vgroup) ([]slb.
 		}
 		err := Batch(privateIpAddress, 40, v.buildFunc(ctx, &backend, g))
 		if err != nil {
-			return backend, fmt.Errorf("batch process eni fail: %s", err.Error())
+			return backend, fmt.Errorf("batch process eni fail: %w", err)
 		}
 		return backend, nil
 	}
@@ -618,7 +773,7 @@ func (v *EndpointWithENI) doBackendBuild(ctx context.Context, g *vgroup) ([]slb.
 						Weight:      DEFAULT_SERVER_WEIGHT,
 						Port:        int(g.NamedKey.Port),
 						Type:        "ecs",
-						Description: g.NamedKey.Key(),
+						Description: backendDescription(node.Name),
 					},
 				)
 			}
@@ -632,7 +787,7 @@ func (v *EndpointWithENI) doBackendBuild(ctx context.Context, g *vgroup) ([]slb.
 	klog.Infof("[Cluster] mode service: %s", g.NamedKey)
 	// 1. add ecs backends
 	for _, node := range v.Nodes {
-		if isExcludeNode(node) {
+		if isExcludeNode(node) || isMasterNode(node) {
 			continue
 		}
 		_, id, err := nodeFromProviderID(node.Spec.ProviderID)
@@ -648,7 +803,7 @@ func (v *EndpointWithENI) doBackendBuild(ctx context.Context, g *vgroup) ([]slb.
 				Weight:      DEFAULT_SERVER_WEIGHT,
 				Port:        int(g.NamedKey.Port),
 				Type:        "ecs",
-				Description: g.NamedKey.Key(),
+				Description: backendDescription(node.Name),
 			},
 		)
 	}
@@ -721,20 +876,33 @@ func (v *EndpointWithENI) addECIBackends(
 	if len(privateIpAddress) > 0 {
 		err := Batch(privateIpAddress, 40, v.buildFunc(ctx, &backend, g))
 		if err != nil {
-			return backend, fmt.Errorf("batch process eni fail: %s", err.Error())
+			return backend, fmt.Errorf("batch process eni fail: %w", err)
 		}
 	}
 
 	return backend, nil
 }
 
+const labelNodeRoleMaster = "node-role.kubernetes.io/master"
+
+// isMasterNode the masters only serve the Local traffic policy services whose
+// pods run on them, they are kept out of the Cluster ones, eg. when the policy
+// is switched from Local to Cluster.
+func isMasterNode(node *v1.Node) bool {
+	if _, ok := node.Labels[labelNodeRoleMaster]; ok {
+		klog.Infof("ignore master node %s for cluster traffic policy", node.Name)
+		return true
+	}
+	return false
+}
+
 func isExcludeNode(node *v1.Node) bool {
 	if utils.IsExcludedNode(node) {
 		klog.Infof("ignore node with exclude node label %s", node.Name)
 		return true
 	}
-	if _, exclude := node.Labels[utils.LabelNodeRoleExcludeBalancer]; exclude {
-		klog.Infof("ignore node with exclude balancer label %s", node.Name)
+	if utils.IsExcludedFromBalancer(node) {
+		klog.Infof("ignore node excluded from loadbalancers %s", node.Name)
 		return true
 	}
 	return false
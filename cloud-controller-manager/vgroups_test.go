@@ -0,0 +1,405 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateBackendWeight(t *testing.T) {
+	cases := []struct {
+		weight  string
+		invalid bool
+	}{
+		{weight: ""},
+		{weight: "1"},
+		{weight: "50"},
+		{weight: "100"},
+		{weight: "0", invalid: true},
+		{weight: "101", invalid: true},
+		{weight: "-1", invalid: true},
+		{weight: "half", invalid: true},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: map[string]string{}},
+		}
+		if c.weight != "" {
+			svc.Annotations[ServiceAnnotationLoadBalancerWeight] = c.weight
+		}
+		err := ValidateBackendWeight(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("weight [%s]: unexpected error: %s", c.weight, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("weight [%s]: expect InvalidAnnotation error, got %v", c.weight, err)
+		}
+	}
+}
+
+func TestBackendWeight(t *testing.T) {
+	prid1 := nodeid(string(REGION), INSTANCEID)
+	prid2 := nodeid(string(REGION), INSTANCEID2)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-service",
+				Namespace:   "default",
+				UID:         types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid1},
+				Spec:       v1.NodeSpec{ProviderID: prid1},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid2},
+				Spec:       v1.NodeSpec{ProviderID: prid2},
+			},
+		},
+	)
+
+	// weights of the backends kept by the invalid annotation
+	expectWeight := func(f *FrameWork, weight int) error {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return fmt.Errorf("find loadbalancer: %v", err)
+		}
+		vgrps := BuildVirtualGroupFromService(f.LoadBalancer(), f.SVC, lb)
+		for _, vg := range *vgrps {
+			if err := vg.Describe(context.Background()); err != nil {
+				return err
+			}
+			att, err := f.SLBSDK().DescribeVServerGroupAttribute(
+				context.Background(),
+				&slb.DescribeVServerGroupAttributeArgs{VServerGroupId: vg.VGroupId},
+			)
+			if err != nil {
+				return err
+			}
+			backends := att.BackendServers.BackendServer
+			if len(backends) != len(f.Nodes) {
+				return fmt.Errorf("expect %d backends, got %v", len(f.Nodes), backends)
+			}
+			for _, b := range backends {
+				if b.Weight != weight {
+					return fmt.Errorf("expect backend weight %d, got %v", weight, backends)
+				}
+			}
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Default backend weight",
+		func(f *FrameWork) error {
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return nil
+		},
+	)
+
+	mock := f.SLBSDK().(*mockClientSLB)
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerWeight] = "20"
+	f.RunCustomized(t, "Update backend weight in place",
+		func(f *FrameWork) error {
+			// the backends are neither removed nor added again
+			churn := func(args interface{}) error {
+				return fmt.Errorf("unexpected backend churn on weight change: %v", args)
+			}
+			mock.addVServerGroupBackendServers = func(args *slb.AddVServerGroupBackendServersArgs) (*slb.AddVServerGroupBackendServersResponse, error) {
+				return nil, churn(args)
+			}
+			mock.removeVServerGroupBackendServers = func(args *slb.RemoveVServerGroupBackendServersArgs) (*slb.RemoveVServerGroupBackendServersResponse, error) {
+				return nil, churn(args)
+			}
+			defer func() {
+				mock.addVServerGroupBackendServers = nil
+				mock.removeVServerGroupBackendServers = nil
+			}()
+			if _, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes); err != nil {
+				return err
+			}
+			ExpectLoadBalancerEqual(t, f)
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerWeight] = "0"
+	f.RunCustomized(t, "Reject weight out of range",
+		func(f *FrameWork) error {
+			recorder := record.NewFakeRecorder(10)
+			ctx := context.WithValue(context.Background(), utils.ContextRecorder, recorder)
+			_, err := f.CloudImpl().EnsureLoadBalancer(ctx, CLUSTER_ID, f.SVC, f.Nodes)
+			if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+				return fmt.Errorf("expect InvalidAnnotation error, got %v", err)
+			}
+			if len(recorder.Events) == 0 {
+				return fmt.Errorf("expect an event for the invalid weight")
+			}
+			return expectWeight(f, 20)
+		},
+	)
+}
+
+func TestVGroupDiff(t *testing.T) {
+	v := &vgroup{NamedKey: &NamedKey{Prefix: DEFAULT_PREFIX, CID: "clusterid", Namespace: "default", ServiceName: "my-service", Port: 80}}
+	ecs := func(id string, port, weight int) slb.VBackendServerType {
+		return slb.VBackendServerType{ServerId: id, Port: port, Weight: weight, Type: "ecs", Description: "node-" + id}
+	}
+	eni := func(id, ip string, port, weight int) slb.VBackendServerType {
+		return slb.VBackendServerType{ServerId: id, ServerIp: ip, Port: port, Weight: weight, Type: "eni", Description: "pod-" + ip}
+	}
+	ids := func(backends []slb.VBackendServerType) []string {
+		var keys []string
+		for _, b := range backends {
+			keys = append(keys, backendKey(b))
+		}
+		return keys
+	}
+	cases := []struct {
+		describe         string
+		apis, nodes      []slb.VBackendServerType
+		add, del, update []string
+	}{
+		{
+			describe: "unchanged",
+			apis:     []slb.VBackendServerType{ecs("i-1", 30080, 100), ecs("i-2", 30080, 100)},
+			nodes:    []slb.VBackendServerType{ecs("i-1", 30080, 100), ecs("i-2", 30080, 100)},
+		},
+		{
+			describe: "node joined",
+			apis:     []slb.VBackendServerType{ecs("i-1", 30080, 100)},
+			nodes:    []slb.VBackendServerType{ecs("i-1", 30080, 100), ecs("i-2", 30080, 100)},
+			add:      []string{"i-2/30080"},
+		},
+		{
+			describe: "node left",
+			apis:     []slb.VBackendServerType{ecs("i-1", 30080, 100), ecs("i-2", 30080, 100)},
+			nodes:    []slb.VBackendServerType{ecs("i-1", 30080, 100)},
+			del:      []string{"i-2/30080"},
+		},
+		{
+			describe: "weight changed",
+			apis:     []slb.VBackendServerType{ecs("i-1", 30080, 100), ecs("i-2", 30080, 100)},
+			nodes:    []slb.VBackendServerType{ecs("i-1", 30080, 100), ecs("i-2", 30080, 50)},
+			update:   []string{"i-2/30080"},
+		},
+		{
+			describe: "description drifted",
+			apis: []slb.VBackendServerType{
+				{ServerId: "i-1", Port: 30080, Weight: 100, Type: "ecs", Description: "k8s/30080/my-service/default/clusterid"},
+			},
+			nodes:  []slb.VBackendServerType{ecs("i-1", 30080, 100)},
+			update: []string{"i-1/30080"},
+		},
+		{
+			describe: "node port changed",
+			apis:     []slb.VBackendServerType{ecs("i-1", 30080, 100)},
+			nodes:    []slb.VBackendServerType{ecs("i-1", 30081, 100)},
+			add:      []string{"i-1/30081"},
+			del:      []string{"i-1/30080"},
+		},
+		{
+			describe: "pods sharing an eni",
+			apis: []slb.VBackendServerType{
+				eni("eni-1", "10.0.0.1", 80, 100),
+				eni("eni-1", "10.0.0.2", 80, 100),
+			},
+			nodes: []slb.VBackendServerType{
+				eni("eni-1", "10.0.0.2", 80, 100),
+				eni("eni-1", "10.0.0.3", 80, 100),
+			},
+			add: []string{"eni-1/10.0.0.3/80"},
+			del: []string{"eni-1/10.0.0.1/80"},
+		},
+	}
+	for _, c := range cases {
+		add, del, update := v.diff(c.apis, c.nodes)
+		if !reflect.DeepEqual(ids(add), c.add) ||
+			!reflect.DeepEqual(ids(del), c.del) ||
+			!reflect.DeepEqual(ids(update), c.update) {
+			t.Errorf("%s: expect add %v, del %v, update %v, got add %v, del %v, update %v",
+				c.describe, c.add, c.del, c.update, ids(add), ids(del), ids(update))
+		}
+	}
+}
+
+// apiCalls backend calls of the mock slb client, each with the number of
+// backends in it.
+type apiCalls struct {
+	add, remove, set [][]slb.VBackendServerType
+}
+
+// countCalls record the backend calls of the mock, the calls are still served
+// by the mock.
+func countCalls(mock *mockClientSLB) (*apiCalls, func()) {
+	calls := &apiCalls{}
+	backends := func(s string) []slb.VBackendServerType {
+		var list []slb.VBackendServerType
+		_ = json.Unmarshal([]byte(s), &list)
+		return list
+	}
+	var add func(args *slb.AddVServerGroupBackendServersArgs) (*slb.AddVServerGroupBackendServersResponse, error)
+	add = func(args *slb.AddVServerGroupBackendServersArgs) (*slb.AddVServerGroupBackendServersResponse, error) {
+		calls.add = append(calls.add, backends(args.BackendServers))
+		mock.addVServerGroupBackendServers = nil
+		defer func() { mock.addVServerGroupBackendServers = add }()
+		return mock.AddVServerGroupBackendServers(context.Background(), args)
+	}
+	var remove func(args *slb.RemoveVServerGroupBackendServersArgs) (*slb.RemoveVServerGroupBackendServersResponse, error)
+	remove = func(args *slb.RemoveVServerGroupBackendServersArgs) (*slb.RemoveVServerGroupBackendServersResponse, error) {
+		calls.remove = append(calls.remove, backends(args.BackendServers))
+		mock.removeVServerGroupBackendServers = nil
+		defer func() { mock.removeVServerGroupBackendServers = remove }()
+		return mock.RemoveVServerGroupBackendServers(context.Background(), args)
+	}
+	var set func(args *slb.SetVServerGroupAttributeArgs) (*slb.SetVServerGroupAttributeResponse, error)
+	set = func(args *slb.SetVServerGroupAttributeArgs) (*slb.SetVServerGroupAttributeResponse, error) {
+		calls.set = append(calls.set, backends(args.BackendServers))
+		mock.setVServerGroupAttribute = nil
+		defer func() { mock.setVServerGroupAttribute = set }()
+		return mock.SetVServerGroupAttribute(context.Background(), args)
+	}
+	mock.addVServerGroupBackendServers = add
+	mock.removeVServerGroupBackendServers = remove
+	mock.setVServerGroupAttribute = set
+	return calls, func() {
+		mock.addVServerGroupBackendServers = nil
+		mock.removeVServerGroupBackendServers = nil
+		mock.setVServerGroupAttribute = nil
+	}
+}
+
+func TestBackendChurn(t *testing.T) {
+	var nodes []*v1.Node
+	for i := 0; i < 45; i++ {
+		prid := nodeid(string(REGION), fmt.Sprintf("i-churn-%02d", i))
+		nodes = append(nodes, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: prid},
+			Spec:       v1.NodeSpec{ProviderID: prid},
+		})
+	}
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(nodes[:44])
+
+	mock := f.SLBSDK().(*mockClientSLB)
+	ensure := func(f *FrameWork, nodes []*v1.Node) (*apiCalls, error) {
+		calls, reset := countCalls(mock)
+		defer reset()
+		_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, nodes)
+		return calls, err
+	}
+	sizes := func(calls [][]slb.VBackendServerType) []int {
+		var n []int
+		for _, c := range calls {
+			n = append(n, len(c))
+		}
+		return n
+	}
+	expectCalls := func(calls *apiCalls, add, remove, set []int) error {
+		if !reflect.DeepEqual(sizes(calls.add), add) ||
+			!reflect.DeepEqual(sizes(calls.remove), remove) ||
+			!reflect.DeepEqual(sizes(calls.set), set) {
+			return fmt.Errorf("expect add %v, remove %v, set %v backends per call, got add %v, remove %v, set %v",
+				add, remove, set, sizes(calls.add), sizes(calls.remove), sizes(calls.set))
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "Backends are added in batches",
+		func(f *FrameWork) error {
+			calls, err := ensure(f, f.Nodes)
+			if err != nil {
+				return err
+			}
+			return expectCalls(calls, []int{20, 20, 4}, nil, nil)
+		},
+	)
+
+	f.RunCustomized(t, "Node joined",
+		func(f *FrameWork) error {
+			calls, err := ensure(f, nodes)
+			if err != nil {
+				return err
+			}
+			return expectCalls(calls, []int{1}, nil, nil)
+		},
+	)
+
+	f.RunCustomized(t, "Node left",
+		func(f *FrameWork) error {
+			calls, err := ensure(f, nodes[1:])
+			if err != nil {
+				return err
+			}
+			return expectCalls(calls, nil, []int{1}, nil)
+		},
+	)
+
+	f.RunCustomized(t, "Nothing changed",
+		func(f *FrameWork) error {
+			calls, err := ensure(f, nodes[1:])
+			if err != nil {
+				return err
+			}
+			return expectCalls(calls, nil, nil, nil)
+		},
+	)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/ecs"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+)
+
+// ValidateVSwitch an intranet loadbalancer takes its address from the
+// vswitch, which must be in the vpc of the cluster, in a zone available for
+// loadbalancers and have ip addresses left. The api fails with an opaque
+// error after a long wait otherwise, so the vswitch is checked right before
+// creation and each failure is reported with its own event.
+func (s *LoadBalancerClient) ValidateVSwitch(ctx context.Context, service *v1.Service, vswitchid string) error {
+	defaulted, request := ExtractAnnotationRequest(service)
+	if defaulted.AddressType != slb.IntranetAddressType ||
+		defaulted.SLBNetworkType == "classic" || vswitchid == "" {
+		return nil
+	}
+	// misconfigured annotations are not retried, the vswitch of the
+	// metadata is not for users to fix.
+	invalid := func(reason, message string, args ...interface{}) error {
+		message = fmt.Sprintf(message, args...)
+		recordVSwitchInvalid(ctx, service, reason, message)
+		if request.VswitchID == "" {
+			return fmt.Errorf("vswitch %s: %s", vswitchid, message)
+		}
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerVswitch,
+			token:      vswitchid,
+			reason:     message,
+		}
+	}
+
+	vswitches, _, err := s.vpc.DescribeVSwitches(
+		ctx,
+		&ecs.DescribeVSwitchesArgs{
+			RegionId:  DEFAULT_REGION,
+			VSwitchId: vswitchid,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("describe vswitch %s: %s", vswitchid, err.Error())
+	}
+	var vsw *ecs.VSwitchSetType
+	for i := range vswitches {
+		if vswitches[i].VSwitchId == vswitchid {
+			vsw = &vswitches[i]
+		}
+	}
+	if vsw == nil {
+		return invalid("VSwitchNotFound", "vswitch %s not found in region %s", vswitchid, DEFAULT_REGION)
+	}
+	if s.vpcid != "" && vsw.VpcId != s.vpcid {
+		return invalid("VSwitchNotInVpc",
+			"vswitch %s belongs to vpc %s, not vpc %s of the cluster", vswitchid, vsw.VpcId, s.vpcid)
+	}
+	if request.MasterZoneID != "" {
+		if vsw.ZoneId != request.MasterZoneID {
+			return invalid("VSwitchZoneMismatch",
+				"vswitch %s is in zone %s, not master zone %s", vswitchid, vsw.ZoneId, request.MasterZoneID)
+		}
+	} else {
+		zones, err := s.c.DescribeZones(ctx, &DescribeZonesArgs{RegionId: DEFAULT_REGION})
+		if err != nil {
+			return fmt.Errorf("describe zones of region %s: %s", DEFAULT_REGION, err.Error())
+		}
+		available := false
+		for _, zone := range zones {
+			if zone.ZoneId == vsw.ZoneId {
+				available = true
+				break
+			}
+		}
+		if !available {
+			return invalid("VSwitchZoneMismatch",
+				"vswitch %s is in zone %s, which is not available for loadbalancers", vswitchid, vsw.ZoneId)
+		}
+	}
+	if vsw.AvailableIpAddressCount <= 0 {
+		// ips may be released later, retry
+		message := fmt.Sprintf("vswitch %s has no ip address available", vswitchid)
+		recordVSwitchInvalid(ctx, service, "VSwitchIPExhausted", message)
+		return fmt.Errorf("%s", message)
+	}
+	return nil
+}
+
+func recordVSwitchInvalid(ctx context.Context, service *v1.Service, reason, message string) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "%s", message)
+		return
+	}
+	record.Event(service, v1.EventTypeWarning, reason, message)
+}
+
+// recordVSwitchChanged explain why the vswitch annotation does not take effect.
+func recordVSwitchChanged(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType, request *AnnotationRequest) {
+	if request.VswitchID == "" || lb.VSwitchId == "" || request.VswitchID == lb.VSwitchId {
+		return
+	}
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "can not move loadbalancer %s to vswitch %s", lb.LoadBalancerId, request.VswitchID)
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeWarning,
+		"VSwitchChangeUnsupported",
+		"Loadbalancer %s is in vswitch %s, it can not be moved to vswitch %s without recreating the loadbalancer",
+		lb.LoadBalancerId, lb.VSwitchId, request.VswitchID,
+	)
+}
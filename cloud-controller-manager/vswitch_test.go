@@ -0,0 +1,147 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/ecs"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestVSwitch(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerAddressType: string(slb.IntranetAddressType),
+					ServiceAnnotationLoadBalancerVswitch:     "vsw-not-exist",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	for _, vsw := range []ecs.VSwitchSetType{
+		{VSwitchId: "vsw-other-vpc", VpcId: "vpc-other", ZoneId: REGION_A, AvailableIpAddressCount: 100},
+		{VSwitchId: "vsw-zone-b", VpcId: VPCID, ZoneId: REGION_B, AvailableIpAddressCount: 100},
+		{VSwitchId: "vsw-exhausted", VpcId: VPCID, ZoneId: REGION_A, AvailableIpAddressCount: 0},
+	} {
+		ROUTES.vswitches.Store(key(string(REGION), vsw.VSwitchId), vsw)
+	}
+
+	expectRejected := func(f *FrameWork, reason string, retry bool) error {
+		_, events, err := f.EnsureWithEvents()
+		if err == nil {
+			return fmt.Errorf("expect vswitch rejected")
+		}
+		if retry == strings.Contains(err.Error(), utils.InvalidAnnotation) {
+			return fmt.Errorf("unexpected error: %s", err.Error())
+		}
+		if !strings.Contains(events, reason) {
+			return fmt.Errorf("expect a %s event, got %q", reason, events)
+		}
+		exist, _, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil {
+			return err
+		}
+		if exist {
+			return fmt.Errorf("no loadbalancer is expected to be created")
+		}
+		return nil
+	}
+
+	f.RunCustomized(t, "VSwitch not found",
+		func(f *FrameWork) error {
+			return expectRejected(f, "VSwitchNotFound", false)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerVswitch] = "vsw-other-vpc"
+	f.RunCustomized(t, "VSwitch not in the vpc",
+		func(f *FrameWork) error {
+			return expectRejected(f, "VSwitchNotInVpc", false)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerVswitch] = "vsw-zone-b"
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerMasterZoneID] = REGION_A
+	f.RunCustomized(t, "VSwitch not in the master zone",
+		func(f *FrameWork) error {
+			return expectRejected(f, "VSwitchZoneMismatch", false)
+		},
+	)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerMasterZoneID)
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerVswitch] = "vsw-exhausted"
+	f.RunCustomized(t, "VSwitch ip exhausted",
+		func(f *FrameWork) error {
+			return expectRejected(f, "VSwitchIPExhausted", true)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerVswitch] = VSWITCH_ID
+	f.RunCustomized(t, "Create in vswitch",
+		func(f *FrameWork) error {
+			if _, _, err := f.EnsureWithEvents(); err != nil {
+				return err
+			}
+			return ExpectExistAndEqual(f)
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerVswitch] = "vsw-zone-b"
+	f.RunCustomized(t, "VSwitch changed",
+		func(f *FrameWork) error {
+			_, events, err := f.EnsureWithEvents()
+			if err != nil {
+				return fmt.Errorf("changed vswitch is not expected to fail the sync: %s", err.Error())
+			}
+			if !strings.Contains(events, "VSwitchChangeUnsupported") {
+				return fmt.Errorf("expect a VSwitchChangeUnsupported event, got %q", events)
+			}
+			return nil
+		},
+	)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+)
+
+// With externalTrafficPolicy=Cluster every node gets the same weight, the
+// nodes running more pods forward the traffic to the others. The pod-count
+// weight mode weights each node by the ready endpoints running on it.
+
+// WeightModePodCount weight the backends by the ready endpoints on the node
+const WeightModePodCount = "pod-count"
+
+// POD_COUNT_WEIGHT_HYSTERESIS weight changes smaller than it are not updated,
+// endpoints change much more often than the weights need to.
+const POD_COUNT_WEIGHT_HYSTERESIS = 10
+
+// ValidateWeightMode pod-count is the only weight mode, it computes the weight
+// the weight annotation would specify.
+func ValidateWeightMode(service *v1.Service) error {
+	mode := serviceAnnotation(service, ServiceAnnotationLoadBalancerWeightMode)
+	switch mode {
+	case "":
+		return nil
+	case WeightModePodCount:
+	default:
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerWeightMode,
+			token:      mode,
+			reason:     fmt.Sprintf("must be %s", WeightModePodCount),
+		}
+	}
+	if weight := serviceAnnotation(service, ServiceAnnotationLoadBalancerWeight); weight != "" {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerWeightMode,
+			token:      mode,
+			reason:     fmt.Sprintf("conflicts with %s [%s]", ServiceAnnotationLoadBalancerWeight, weight),
+		}
+	}
+	return nil
+}
+
+// isPodCountWeighted only the Cluster mode ecs backends are weighted by pod
+// count, the Local mode ones already are.
+func isPodCountWeighted(service *v1.Service) bool {
+	defaulted, _ := ExtractAnnotationRequest(service)
+	return defaulted.WeightMode == WeightModePodCount &&
+		!ServiceModeLocal(service) && !IsENIBackendType(service)
+}
+
+// podCountWeight weight of a backend running count pods, the most loaded one
+// running max pods gets the max weight. Backends without pods still get the
+// min weight for the traffic is forwarded by kube-proxy.
+func podCountWeight(count, max int) int {
+	if max <= 0 {
+		return DEFAULT_SERVER_WEIGHT
+	}
+	weight := (count*MAX_SERVER_WEIGHT + max/2) / max
+	if weight < MIN_SERVER_WEIGHT {
+		weight = MIN_SERVER_WEIGHT
+	}
+	return weight
+}
+
+// podCountWeights weight the backends by the ready endpoints on their node,
+// an eni backend is a pod itself.
+func (v *EndpointWithENI) podCountWeights(backends []slb.VBackendServerType) []slb.VBackendServerType {
+	ids := map[string]string{}
+	for _, node := range v.Nodes {
+		_, id, err := nodeFromProviderID(node.Spec.ProviderID)
+		if err == nil {
+			ids[node.Name] = id
+		}
+	}
+	pods := map[string]int{}
+	if v.Endpoints != nil {
+		for _, sub := range v.Endpoints.Subsets {
+			// NotReadyAddresses receive no traffic
+			for _, add := range sub.Addresses {
+				if add.NodeName == nil {
+					continue
+				}
+				if id, ok := ids[*add.NodeName]; ok {
+					pods[id]++
+				}
+			}
+		}
+	}
+	count := func(b slb.VBackendServerType) int {
+		if b.Type == "eni" {
+			return 1
+		}
+		return pods[b.ServerId]
+	}
+	max := 0
+	for _, b := range backends {
+		if c := count(b); c > max {
+			max = c
+		}
+	}
+	for i := range backends {
+		backends[i].Weight = podCountWeight(count(backends[i]), max)
+	}
+	return backends
+}
+
+// withWeightHysteresis keep the weights of the api when the computed ones
+// change less than POD_COUNT_WEIGHT_HYSTERESIS.
+func withWeightHysteresis(apis, nodes []slb.VBackendServerType) []slb.VBackendServerType {
+	for i := range nodes {
+		for _, api := range apis {
+			if backendKey(api) != backendKey(nodes[i]) {
+				continue
+			}
+			if isWeightChangeIgnorable(api.Weight, nodes[i].Weight) {
+				nodes[i].Weight = api.Weight
+			}
+			break
+		}
+	}
+	return nodes
+}
+
+// isWeightChangeIgnorable a node getting its first pod or losing the last one
+// is always updated.
+func isWeightChangeIgnorable(current, computed int) bool {
+	if current == computed {
+		return true
+	}
+	if current == MIN_SERVER_WEIGHT || computed == MIN_SERVER_WEIGHT {
+		return false
+	}
+	delta := current - computed
+	if delta < 0 {
+		delta = -delta
+	}
+	return delta < POD_COUNT_WEIGHT_HYSTERESIS
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateWeightMode(t *testing.T) {
+	cases := []struct {
+		annotations map[string]string
+		invalid     bool
+	}{
+		{annotations: map[string]string{}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerWeightMode: WeightModePodCount}},
+		{annotations: map[string]string{ServiceAnnotationLoadBalancerWeightMode: "equal"}, invalid: true},
+		{
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerWeightMode: WeightModePodCount,
+				ServiceAnnotationLoadBalancerWeight:     "50",
+			},
+			invalid: true,
+		},
+	}
+	for _, c := range cases {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Annotations: c.annotations},
+		}
+		err := ValidateWeightMode(svc)
+		if !c.invalid && err != nil {
+			t.Errorf("%v: unexpected error: %s", c.annotations, err.Error())
+		}
+		if c.invalid && (err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation)) {
+			t.Errorf("%v: expect InvalidAnnotation error, got %v", c.annotations, err)
+		}
+	}
+}
+
+func TestPodCountWeight(t *testing.T) {
+	cases := []struct {
+		count, max, weight int
+	}{
+		// no pods at all
+		{count: 0, max: 0, weight: DEFAULT_SERVER_WEIGHT},
+		{count: 0, max: 3, weight: MIN_SERVER_WEIGHT},
+		{count: 3, max: 3, weight: 100},
+		{count: 1, max: 3, weight: 33},
+		{count: 2, max: 3, weight: 67},
+		{count: 1, max: 200, weight: MIN_SERVER_WEIGHT},
+		{count: 1, max: 150, weight: MIN_SERVER_WEIGHT},
+		{count: 75, max: 150, weight: 50},
+	}
+	for _, c := range cases {
+		if weight := podCountWeight(c.count, c.max); weight != c.weight {
+			t.Errorf("%d of %d pods: expect weight %d, got %d", c.count, c.max, c.weight, weight)
+		}
+	}
+}
+
+func TestPodCountWeights(t *testing.T) {
+	node1, node2, node3 := "node-1", "node-2", "node-3"
+	v := &EndpointWithENI{
+		Nodes: []*v1.Node{
+			{ObjectMeta: metav1.ObjectMeta{Name: node1}, Spec: v1.NodeSpec{ProviderID: nodeid(string(REGION), "i-1")}},
+			{ObjectMeta: metav1.ObjectMeta{Name: node2}, Spec: v1.NodeSpec{ProviderID: nodeid(string(REGION), "i-2")}},
+			{ObjectMeta: metav1.ObjectMeta{Name: node3}, Spec: v1.NodeSpec{ProviderID: nodeid(string(REGION), "i-3")}},
+		},
+		Endpoints: &v1.Endpoints{
+			Subsets: []v1.EndpointSubset{
+				{
+					Addresses: []v1.EndpointAddress{
+						{IP: "10.0.0.1", NodeName: &node1},
+						{IP: "10.0.0.2", NodeName: &node1},
+						{IP: "10.0.0.3", NodeName: &node1},
+						{IP: "10.0.0.4", NodeName: &node1},
+						{IP: "10.0.0.5", NodeName: &node2},
+						// no node name
+						{IP: "10.0.0.6"},
+					},
+					// not ready pods receive no traffic
+					NotReadyAddresses: []v1.EndpointAddress{
+						{IP: "10.0.0.7", NodeName: &node3},
+					},
+				},
+			},
+		},
+	}
+	backends := v.podCountWeights(
+		[]slb.VBackendServerType{
+			{ServerId: "i-1", Type: "ecs"},
+			{ServerId: "i-2", Type: "ecs"},
+			{ServerId: "i-3", Type: "ecs"},
+			{ServerId: "eni-1", ServerIp: "10.0.1.1", Type: "eni"},
+		},
+	)
+	weights := map[string]int{}
+	for _, b := range backends {
+		weights[b.ServerId] = b.Weight
+	}
+	expect := map[string]int{"i-1": 100, "i-2": 25, "i-3": MIN_SERVER_WEIGHT, "eni-1": 25}
+	if !reflect.DeepEqual(weights, expect) {
+		t.Fatalf("expect weights %v, got %v", expect, weights)
+	}
+}
+
+func TestWeightHysteresis(t *testing.T) {
+	cases := []struct {
+		current, computed, expect int
+	}{
+		{current: 100, computed: 100, expect: 100},
+		{current: 50, computed: 55, expect: 50},
+		{current: 50, computed: 41, expect: 50},
+		{current: 50, computed: 60, expect: 60},
+		{current: 50, computed: 40, expect: 40},
+		// the first pod scheduled to the node
+		{current: MIN_SERVER_WEIGHT, computed: 5, expect: 5},
+		// the last pod gone from the node
+		{current: 5, computed: MIN_SERVER_WEIGHT, expect: MIN_SERVER_WEIGHT},
+	}
+	for _, c := range cases {
+		nodes := withWeightHysteresis(
+			[]slb.VBackendServerType{
+				{ServerId: "i-1", Type: "ecs", Weight: c.current},
+				{ServerId: "eni-1", ServerIp: "10.0.1.1", Type: "eni", Weight: c.current},
+			},
+			[]slb.VBackendServerType{
+				{ServerId: "i-1", Type: "ecs", Weight: c.computed},
+				{ServerId: "eni-1", ServerIp: "10.0.1.1", Type: "eni", Weight: c.computed},
+				// new backends are added with the computed weight
+				{ServerId: "i-2", Type: "ecs", Weight: c.computed},
+			},
+		)
+		if nodes[0].Weight != c.expect || nodes[1].Weight != c.expect {
+			t.Errorf("%d -> %d: expect weight %d, got %v", c.current, c.computed, c.expect, nodes)
+		}
+		if nodes[2].Weight != c.computed {
+			t.Errorf("%d -> %d: expect weight %d of new backend, got %v", c.current, c.computed, c.computed, nodes)
+		}
+	}
+}
+
+func TestPodCountWeightMode(t *testing.T) {
+	prid1 := nodeid(string(REGION), INSTANCEID)
+	prid2 := nodeid(string(REGION), INSTANCEID2)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerWeightMode: WeightModePodCount,
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:                  v1.ServiceTypeLoadBalancer,
+				SessionAffinity:       v1.ServiceAffinityNone,
+				ExternalTrafficPolicy: v1.ServiceExternalTrafficPolicyTypeCluster,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid1},
+				Spec:       v1.NodeSpec{ProviderID: prid1},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid2},
+				Spec:       v1.NodeSpec{ProviderID: prid2},
+			},
+		},
+	)
+	// endpoints with the number of ready pods on each node
+	endpoints := func(pods1, pods2 int) *v1.Endpoints {
+		var addresses []v1.EndpointAddress
+		for i := 0; i < pods1+pods2; i++ {
+			name := prid1
+			if i >= pods1 {
+				name = prid2
+			}
+			addresses = append(addresses, v1.EndpointAddress{IP: fmt.Sprintf("10.0.0.%d", i+1), NodeName: &name})
+		}
+		return &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-service", Namespace: "default"},
+			Subsets:    []v1.EndpointSubset{{Addresses: addresses}},
+		}
+	}
+	expectWeights := func(f *FrameWork, weight1, weight2 int) error {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return fmt.Errorf("find loadbalancer: %v", err)
+		}
+		vgrps := BuildVirtualGroupFromService(f.LoadBalancer(), f.SVC, lb)
+		for _, vg := range *vgrps {
+			if err := vg.Describe(context.Background()); err != nil {
+				return err
+			}
+			att, err := f.SLBSDK().DescribeVServerGroupAttribute(
+				context.Background(),
+				&slb.DescribeVServerGroupAttributeArgs{VServerGroupId: vg.VGroupId},
+			)
+			if err != nil {
+				return err
+			}
+			weights := map[string]int{}
+			for _, b := range att.BackendServers.BackendServer {
+				weights[b.ServerId] = b.Weight
+			}
+			expect := map[string]int{INSTANCEID: weight1, INSTANCEID2: weight2}
+			if !reflect.DeepEqual(weights, expect) {
+				return fmt.Errorf("expect weights %v, got %v", expect, weights)
+			}
+		}
+		return nil
+	}
+	ensure := func(f *FrameWork) error {
+		_, err := f.CloudImpl().EnsureLoadBalancer(context.Background(), CLUSTER_ID, f.SVC, f.Nodes)
+		return err
+	}
+
+	f.WithEndpoints(endpoints(3, 0))
+	f.RunCustomized(t, "Node without pods gets the min weight",
+		func(f *FrameWork) error {
+			if err := ensure(f); err != nil {
+				return err
+			}
+			return expectWeights(f, 100, MIN_SERVER_WEIGHT)
+		},
+	)
+
+	f.WithEndpoints(endpoints(3, 1))
+	f.RunCustomized(t, "Pod scheduled to the node",
+		func(f *FrameWork) error {
+			if err := ensure(f); err != nil {
+				return err
+			}
+			return expectWeights(f, 100, 33)
+		},
+	)
+
+	mock := f.SLBSDK().(*mockClientSLB)
+	f.WithEndpoints(endpoints(4, 1))
+	f.RunCustomized(t, "Small change is not updated",
+		func(f *FrameWork) error {
+			mock.setVServerGroupAttribute = func(args *slb.SetVServerGroupAttributeArgs) (*slb.SetVServerGroupAttributeResponse, error) {
+				return nil, fmt.Errorf("unexpected weight update within hysteresis: %s", args.BackendServers)
+			}
+			defer func() { mock.setVServerGroupAttribute = nil }()
+			if err := ensure(f); err != nil {
+				return err
+			}
+			return expectWeights(f, 100, 33)
+		},
+	)
+
+	f.WithEndpoints(endpoints(2, 2))
+	f.RunCustomized(t, "Even distribution",
+		func(f *FrameWork) error {
+			if err := ensure(f); err != nil {
+				return err
+			}
+			return expectWeights(f, 100, 100)
+		},
+	)
+
+	delete(f.SVC.Annotations, ServiceAnnotationLoadBalancerWeightMode)
+	f.WithEndpoints(endpoints(3, 0))
+	f.RunCustomized(t, "Default weight without the mode",
+		func(f *FrameWork) error {
+			if err := ensure(f); err != nil {
+				return err
+			}
+			return expectWeights(f, DEFAULT_SERVER_WEIGHT, DEFAULT_SERVER_WEIGHT)
+		},
+	)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/slb"
+	"k8s.io/api/core/v1"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"k8s.io/klog"
+	"strings"
+)
+
+// The zones available for loadbalancers are described by the slb api, which
+// is not wrapped by aliyungo either, see domainextension.go.
+
+type DescribeZonesArgs struct {
+	RegionId common.Region
+}
+
+type SlaveZoneType struct {
+	ZoneId    string
+	LocalName string
+}
+
+type ZoneType struct {
+	ZoneId     string
+	LocalName  string
+	SlaveZones struct {
+		SlaveZone []SlaveZoneType
+	}
+}
+
+type DescribeZonesResponse struct {
+	common.Response
+	Zones struct {
+		Zone []ZoneType
+	}
+}
+
+// ValidateZones the master zone must be available for loadbalancers in the
+// region, and the slave zone must be one of the slave zones of the master.
+// Zones are only used at creation, so they are validated right before it.
+func ValidateZones(ctx context.Context, client ClientSLBSDK, service *v1.Service) error {
+	_, request := ExtractAnnotationRequest(service)
+	if request.MasterZoneID == "" && request.SlaveZoneID == "" {
+		return nil
+	}
+	if request.MasterZoneID == "" {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerSlaveZoneID,
+			token:      request.SlaveZoneID,
+			reason:     fmt.Sprintf("requires annotation %s", ServiceAnnotationLoadBalancerMasterZoneID),
+		}
+	}
+	zones, err := client.DescribeZones(ctx, &DescribeZonesArgs{RegionId: DEFAULT_REGION})
+	if err != nil {
+		return fmt.Errorf("describe zones of region %s: %s", DEFAULT_REGION, err.Error())
+	}
+	var master *ZoneType
+	var available []string
+	for i := range zones {
+		available = append(available, zones[i].ZoneId)
+		if zones[i].ZoneId == request.MasterZoneID {
+			master = &zones[i]
+		}
+	}
+	if master == nil {
+		return annotationError{
+			annotation: ServiceAnnotationLoadBalancerMasterZoneID,
+			token:      request.MasterZoneID,
+			reason: fmt.Sprintf("zone is not available for loadbalancers in region %s, available zones: [%s]",
+				DEFAULT_REGION, strings.Join(available, ",")),
+		}
+	}
+	if request.SlaveZoneID == "" {
+		return nil
+	}
+	var slaves []string
+	for _, slave := range master.SlaveZones.SlaveZone {
+		if slave.ZoneId == request.SlaveZoneID {
+			return nil
+		}
+		slaves = append(slaves, slave.ZoneId)
+	}
+	return annotationError{
+		annotation: ServiceAnnotationLoadBalancerSlaveZoneID,
+		token:      request.SlaveZoneID,
+		reason: fmt.Sprintf("zone can not be the slave of master zone %s, available slave zones: [%s]",
+			request.MasterZoneID, strings.Join(slaves, ",")),
+	}
+}
+
+// isZonesChanged whether the zone annotations differ from the zones of lb
+func isZonesChanged(request *AnnotationRequest, lb *slb.LoadBalancerType) bool {
+	return (request.MasterZoneID != "" && request.MasterZoneID != lb.MasterZoneId) ||
+		(request.SlaveZoneID != "" && request.SlaveZoneID != lb.SlaveZoneId)
+}
+
+func isForceRecreateZones(svc *v1.Service) bool {
+	return strings.ToLower(serviceAnnotation(svc, ServiceAnnotationLoadBalancerForceRecreateZones)) == "true"
+}
+
+// recordZonesChanged explain why the zone annotations do not take effect.
+func recordZonesChanged(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType, request *AnnotationRequest) {
+	message := fmt.Sprintf("Zones of loadbalancer %s are [%s, %s], they can not be changed to [%s, %s] "+
+		"without recreating the loadbalancer. ",
+		lb.LoadBalancerId, lb.MasterZoneId, lb.SlaveZoneId, request.MasterZoneID, request.SlaveZoneID)
+	if isUserDefinedLoadBalancer(service) {
+		message += "User managed loadbalancers are never recreated"
+	} else {
+		message += fmt.Sprintf("Set annotation %s to \"true\" to recreate it", ServiceAnnotationLoadBalancerForceRecreateZones)
+	}
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		utils.Logf(service, "%s", message)
+		return
+	}
+	record.Event(service, v1.EventTypeWarning, "ZonesChangeUnsupported", message)
+}
+
+// recordZonesSelected tell which zones the loadbalancer has been created in,
+// they are chosen by the api when the annotations are absent.
+func recordZonesSelected(ctx context.Context, service *v1.Service, lb *slb.LoadBalancerType) {
+	record, err := utils.GetRecorderFromContext(ctx)
+	if err != nil {
+		klog.Warningf("get recorder error: %s", err.Error())
+		return
+	}
+	record.Eventf(
+		service,
+		v1.EventTypeNormal,
+		"ZonesSelected",
+		"Created loadbalancer %s in master zone %s and slave zone %s",
+		lb.LoadBalancerId, lb.MasterZoneId, lb.SlaveZoneId,
+	)
+}
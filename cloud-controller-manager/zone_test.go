@@ -0,0 +1,196 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"github.com/denverdino/aliyungo/slb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
+	"strings"
+	"testing"
+)
+
+func TestZones(t *testing.T) {
+	prid := nodeid(string(REGION), INSTANCEID)
+	f := NewDefaultFrameWork(nil)
+	f.WithService(
+		// initial service based on your definition
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-service",
+				Namespace: "default",
+				UID:       types.UID(serviceUIDNoneExist),
+				Annotations: map[string]string{
+					ServiceAnnotationLoadBalancerMasterZoneID: "cn-hangzhou-x",
+				},
+			},
+			Spec: v1.ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Port: listenPort1, TargetPort: targetPort1, Protocol: v1.ProtocolTCP, NodePort: nodePort1},
+				},
+				Type:            v1.ServiceTypeLoadBalancer,
+				SessionAffinity: v1.ServiceAffinityNone,
+			},
+		},
+	).WithNodes(
+		// initial node based on your definition.
+		// backend of the created loadbalancer
+		[]*v1.Node{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: prid},
+				Spec:       v1.NodeSpec{ProviderID: prid},
+			},
+		},
+	)
+
+	expectZones := func(f *FrameWork, master, slave string) (string, error) {
+		_, lb, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+		if err != nil || lb == nil {
+			return "", fmt.Errorf("find loadbalancer: %v", err)
+		}
+		if lb.MasterZoneId != master || lb.SlaveZoneId != slave {
+			return "", fmt.Errorf("expect zones [%s, %s], got [%s, %s]", master, slave, lb.MasterZoneId, lb.SlaveZoneId)
+		}
+		return lb.LoadBalancerId, nil
+	}
+
+	f.RunCustomized(t, "Unknown master zone",
+		func(f *FrameWork) error {
+			_, events, err := f.EnsureWithEvents()
+			if err == nil || !strings.Contains(err.Error(), utils.InvalidAnnotation) {
+				return fmt.Errorf("expect InvalidAnnotation error, got %v", err)
+			}
+			if !strings.Contains(events, "cn-hangzhou-x") {
+				return fmt.Errorf("expect an event of the unknown zone, got %q", events)
+			}
+			exist, _, err := f.LoadBalancer().FindLoadBalancer(context.Background(), f.SVC)
+			if err != nil {
+				return err
+			}
+			if exist {
+				return fmt.Errorf("no loadbalancer is expected to be created")
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerMasterZoneID] = fmt.Sprintf("%s-g", REGION)
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerSlaveZoneID] = REGION_A
+	f.RunCustomized(t, "Unsupported slave zone",
+		func(f *FrameWork) error {
+			_, _, err := f.EnsureWithEvents()
+			if err == nil || !strings.Contains(err.Error(), ServiceAnnotationLoadBalancerSlaveZoneID) {
+				return fmt.Errorf("expect invalid slave zone error, got %v", err)
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerMasterZoneID] = REGION_A
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerSlaveZoneID] = REGION_B
+	var created string
+	f.RunCustomized(t, "Create in zones",
+		func(f *FrameWork) error {
+			_, events, err := f.EnsureWithEvents()
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(events, "ZonesSelected") {
+				return fmt.Errorf("expect a ZonesSelected event, got %q", events)
+			}
+			if err := ExpectExistAndEqual(f); err != nil {
+				return err
+			}
+			created, err = expectZones(f, REGION_A, REGION_B)
+			return err
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerMasterZoneID] = REGION_B
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerSlaveZoneID] = REGION_A
+	f.RunCustomized(t, "Zones changed",
+		func(f *FrameWork) error {
+			_, events, err := f.EnsureWithEvents()
+			if err != nil {
+				return fmt.Errorf("changed zones are not expected to fail the sync: %s", err.Error())
+			}
+			if !strings.Contains(events, "ZonesChangeUnsupported") {
+				return fmt.Errorf("expect a ZonesChangeUnsupported event, got %q", events)
+			}
+			id, err := expectZones(f, REGION_A, REGION_B)
+			if err != nil {
+				return err
+			}
+			if id != created {
+				return fmt.Errorf("loadbalancer is not expected to be recreated")
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerForceRecreateZones] = "true"
+	f.RunCustomized(t, "Force recreate refused by delete protection",
+		func(f *FrameWork) error {
+			_, events, err := f.EnsureWithEvents()
+			if err != nil {
+				return fmt.Errorf("refused recreate is not expected to fail the sync: %s", err.Error())
+			}
+			if !strings.Contains(events, "SkipRecreateLoadBalancer") || !strings.Contains(events, "delete protection is on") {
+				return fmt.Errorf("expect a SkipRecreateLoadBalancer event, got %q", events)
+			}
+			id, err := expectZones(f, REGION_A, REGION_B)
+			if err != nil {
+				return err
+			}
+			if id != created {
+				return fmt.Errorf("loadbalancer is not expected to be recreated")
+			}
+			return nil
+		},
+	)
+
+	f.SVC.Annotations[ServiceAnnotationLoadBalancerDeleteProtection] = string(slb.OffFlag)
+	f.RunCustomized(t, "Force recreate in zones",
+		func(f *FrameWork) error {
+			if _, _, err := f.EnsureWithEvents(); err != nil {
+				return err
+			}
+			if err := ExpectExistAndEqual(f); err != nil {
+				return err
+			}
+			id, err := expectZones(f, REGION_B, REGION_A)
+			if err != nil {
+				return err
+			}
+			if id == created {
+				return fmt.Errorf("expect loadbalancer recreated")
+			}
+			exist, _, err := f.LoadBalancer().FindLoadBalancerByID(context.Background(), created)
+			if err != nil {
+				return err
+			}
+			if exist {
+				return fmt.Errorf("expect loadbalancer %s deleted", created)
+			}
+			return nil
+		},
+	)
+}
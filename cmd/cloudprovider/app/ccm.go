@@ -4,6 +4,7 @@ import (
 	"context"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	alicloud "k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils"
 	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/utils/metric"
 	componentbaseconfig "k8s.io/component-base/config"
 	"k8s.io/klog"
@@ -29,6 +30,7 @@ import (
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/cloud-provider"
+	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/controller/node"
 	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/controller/route"
 	"k8s.io/cloud-provider-alibaba-cloud/cloud-controller-manager/controller/service"
 	"k8s.io/kubernetes/pkg/api/legacyscheme"
@@ -59,8 +61,113 @@ type ServerCCM struct {
 	cloud      cloudprovider.Interface
 
 	// NodeStatusUpdateFrequency is the frequency at which the controller
-	// updates nodes' status
+	// updates nodes' status, 0 for the nodeAddrSyncPeriod of the cloud config
 	NodeStatusUpdateFrequency metav1.Duration
+
+	// NodeMonitorGracePeriod the nodeMonitorGracePeriod of
+	// kube-controller-manager the node monitor period is checked against
+	NodeMonitorGracePeriod metav1.Duration
+
+	// RouteConflictResolution how to resolve route conflicted with node podCIDR, report|replace
+	RouteConflictResolution string
+
+	// RoutesDryRun only log the route changes without any mutating vpc call
+	RoutesDryRun bool
+
+	// ClusterID the cluster id tagged on the loadbalancers created by the
+	// cloudprovider, overrides the one of the cloud config
+	ClusterID string
+
+	// LoadBalancerGC collect loadbalancers whose service no longer exists
+	LoadBalancerGC bool
+
+	// LoadBalancerGCPeriod interval between two loadbalancer collections
+	LoadBalancerGCPeriod metav1.Duration
+
+	// LoadBalancerGCDryRun only report the orphan loadbalancers without deleting them
+	LoadBalancerGCDryRun bool
+
+	// BackendHealthCheckPeriod interval between two backend health checks, 0 disables the check
+	BackendHealthCheckPeriod metav1.Duration
+
+	// BackendHealthCheckQPS max health status calls per second of the backend health check
+	BackendHealthCheckQPS float32
+
+	// UseVPCEndpoints call the vpc endpoints of the region
+	UseVPCEndpoints bool
+
+	// CloudAPILimiter options of the limiter of the cloud api calls
+	CloudAPILimiter alicloud.CloudAPILimiterOptions
+
+	// ServiceAPIBudget options of the budget of cloud api calls per service
+	ServiceAPIBudget alicloud.ServiceAPIBudgetOptions
+
+	// LoadBalancerStateConfigMap namespace/name of the configmap mirroring the loadbalancer states
+	LoadBalancerStateConfigMap string
+
+	// CloudAPILogVerbosity verbosity of the logs of the cloud api calls
+	CloudAPILogVerbosity int32
+
+	// UserAgentSuffix appended to the user agent of the cloud api calls
+	UserAgentSuffix string
+
+	// ExcludeNodeSelectors label selectors of the nodes excluded from the
+	// management, added to the exclude-node labels
+	ExcludeNodeSelectors []string
+	// ExcludeBalancerNodeSelectors label selectors of the nodes excluded from
+	// the loadbalancer backends only, added to the exclude-balancer label
+	ExcludeBalancerNodeSelectors []string
+	// DrainingNodeTaints keys of the taints of the draining nodes, added to
+	// the unschedulable taint
+	DrainingNodeTaints []string
+	// DrainingNodeSelectors label selectors of the draining nodes
+	DrainingNodeSelectors []string
+
+	// DiagnosticRedactPattern keys of the annotations redacted from the
+	// objects dumped to the logs
+	DiagnosticRedactPattern string
+	// DiagnosticMaxBytes max bytes of the objects dumped to the logs
+	DiagnosticMaxBytes int
+
+	// LoadBalancerClass the class of the services claimed besides the empty one
+	LoadBalancerClass string
+	// LoadBalancerClassReleasePolicy keep or delete the loadbalancer of a
+	// service given the class of another controller
+	LoadBalancerClassReleasePolicy string
+
+	// StartupSpread the window over which the services listed at the start
+	// are synced, 0 syncs them at once
+	StartupSpread metav1.Duration
+
+	// DefaultAnnotationsConfigMap namespace/name of the configmap of the
+	// default annotations of the loadbalancer services
+	DefaultAnnotationsConfigMap string
+
+	// EnableNodeDeletion delete the nodes whose instance is not found, or
+	// taint them to be deleted by the operator
+	EnableNodeDeletion bool
+
+	// AllowProvidedIPOverride replace the ip provided by kubelet which the
+	// instance no longer has by the addresses of the instance
+	AllowProvidedIPOverride bool
+
+	// NodeLabelReconcilePeriod the period of the repair of the cloud labels
+	// of the nodes, 0 disables it
+	NodeLabelReconcilePeriod metav1.Duration
+
+	// LoadBalancerNameTemplate the template of the names of the loadbalancers
+	// created, empty for the uid based names
+	LoadBalancerNameTemplate string
+
+	// HealthCheckDefaults the health check of the listeners whose service
+	// does not annotate it
+	HealthCheckDefaults alicloud.HealthCheckDefaults
+	// HealthCheckTypes protocol=type pairs of the default check types
+	HealthCheckTypes []string
+
+	// ServiceSyncTimestampMetric export the time of the last successful sync
+	// of each service
+	ServiceSyncTimestampMetric bool
 }
 
 // NewServerCCM creates a new ExternalCMServer with a default config.
@@ -88,7 +195,7 @@ func NewServerCCM() *ServerCCM {
 				ControllerStartInterval: metav1.Duration{Duration: 0 * time.Second},
 			},
 			KubeCloudShared: kubectrlmgrconfig.KubeCloudSharedConfiguration{
-				NodeMonitorPeriod:         metav1.Duration{Duration: 5 * time.Second},
+				NodeMonitorPeriod:         metav1.Duration{Duration: node.Options.MonitorPeriod},
 				ClusterName:               "kubernetes",
 				ConfigureCloudRoutes:      true,
 				RouteReconciliationPeriod: metav1.Duration{Duration: 10 * time.Second},
@@ -97,7 +204,22 @@ func NewServerCCM() *ServerCCM {
 				ConcurrentServiceSyncs: 3,
 			},
 		},
-		NodeStatusUpdateFrequency: metav1.Duration{Duration: 5 * time.Minute},
+		NodeStatusUpdateFrequency: metav1.Duration{Duration: node.Options.StatusUpdateFrequency},
+		NodeMonitorGracePeriod:    metav1.Duration{Duration: node.Options.MonitorGracePeriod},
+		RouteConflictResolution:   route.ConflictResolutionReport,
+		LoadBalancerGCPeriod:      metav1.Duration{Duration: alicloud.GCOptions.Period},
+		BackendHealthCheckQPS:     alicloud.HealthOptions.QPS,
+		CloudAPILimiter:           alicloud.APILimiterOptions,
+		ServiceAPIBudget:          alicloud.APIBudgetOptions,
+		HealthCheckDefaults:       alicloud.HealthCheckOptions,
+		CloudAPILogVerbosity:      int32(alicloud.API_LOG_VERBOSITY),
+		DiagnosticRedactPattern:   utils.DEFAULT_DIAGNOSTIC_REDACT_PATTERN,
+		DiagnosticMaxBytes:        utils.DEFAULT_DIAGNOSTIC_MAX_BYTES,
+
+		LoadBalancerClassReleasePolicy: service.ReleasePolicyKeep,
+		EnableNodeDeletion:             node.EnableNodeDeletion,
+		AllowProvidedIPOverride:        node.AllowProvidedIPOverride,
+		NodeLabelReconcilePeriod:       metav1.Duration{Duration: node.LabelReconcilePeriod},
 	}
 	ccm.Generic.LeaderElection.LeaderElect = true
 	return &ccm
@@ -151,6 +273,80 @@ func (ccm *ServerCCM) initialization() error {
 	}
 
 	alicloud.CloudConfigFile = ccm.KubeCloudShared.CloudProvider.CloudConfigFile
+	alicloud.USE_VPC_ENDPOINTS = ccm.UseVPCEndpoints
+	alicloud.APILimiter = alicloud.NewCloudAPILimiter(ccm.CloudAPILimiter)
+	if err := alicloud.ValidateServiceAPIBudget(ccm.ServiceAPIBudget); err != nil {
+		return err
+	}
+	alicloud.APIBudget = alicloud.NewServiceAPIBudget(ccm.ServiceAPIBudget)
+	service.REQUEUE_BUDGET_DELAY = ccm.ServiceAPIBudget.Window
+	service.SyncTimestampsPerService = ccm.ServiceSyncTimestampMetric
+	if err := alicloud.ValidateLoadBalancerStateOptions(
+		alicloud.LoadBalancerStateOptions{ConfigMap: ccm.LoadBalancerStateConfigMap}); err != nil {
+		return err
+	}
+	alicloud.API_LOG_VERBOSITY = klog.Level(ccm.CloudAPILogVerbosity)
+	if err := alicloud.ValidateUserAgentSuffix(ccm.UserAgentSuffix); err != nil {
+		return err
+	}
+	alicloud.USER_AGENT_SUFFIX = ccm.UserAgentSuffix
+	types, err := alicloud.ParseHealthCheckTypes(ccm.HealthCheckTypes)
+	if err != nil {
+		return err
+	}
+	ccm.HealthCheckDefaults.Types = types
+	if err := alicloud.ValidateHealthCheckDefaults(ccm.HealthCheckDefaults); err != nil {
+		return err
+	}
+	alicloud.SetHealthCheckDefaults(ccm.HealthCheckDefaults)
+	exclusion, err := utils.NewNodeExclusion(ccm.ExcludeNodeSelectors, ccm.ExcludeBalancerNodeSelectors)
+	if err != nil {
+		return err
+	}
+	utils.NodeExclusions = exclusion
+	draining, err := utils.NewNodeDraining(ccm.DrainingNodeTaints, ccm.DrainingNodeSelectors)
+	if err != nil {
+		return err
+	}
+	utils.DrainingNodes = draining
+	if err := utils.SetDiagnosticRedactPattern(ccm.DiagnosticRedactPattern); err != nil {
+		return err
+	}
+	utils.DiagnosticMaxBytes = ccm.DiagnosticMaxBytes
+	service.Options = service.ClassOptions{
+		Class:         ccm.LoadBalancerClass,
+		ReleasePolicy: ccm.LoadBalancerClassReleasePolicy,
+	}
+	if err := service.ValidateClassOptions(service.Options); err != nil {
+		return err
+	}
+	service.StartupSpread = ccm.StartupSpread.Duration
+	if _, _, err := service.ParseDefaultAnnotationsConfigMap(ccm.DefaultAnnotationsConfigMap); err != nil {
+		return err
+	}
+	service.DefaultAnnotationsConfigMap = ccm.DefaultAnnotationsConfigMap
+	if err := alicloud.ValidateLoadBalancerNameTemplate(ccm.LoadBalancerNameTemplate); err != nil {
+		return err
+	}
+	alicloud.LoadBalancerNameTemplate = ccm.LoadBalancerNameTemplate
+	periods := node.PeriodOptions{
+		MonitorPeriod:         ccm.KubeCloudShared.NodeMonitorPeriod.Duration,
+		StatusUpdateFrequency: ccm.NodeStatusUpdateFrequency.Duration,
+		MonitorGracePeriod:    ccm.NodeMonitorGracePeriod.Duration,
+	}
+	warnings, err := node.ValidatePeriodOptions(periods)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		klog.Warningf("node controller: %s", warning)
+	}
+	node.Options = periods
+	// the clients are created with the cluster id in the user agent
+	if ccm.ClusterID != "" {
+		alicloud.CLUSTER_ID = ccm.ClusterID
+		klog.Infof("use clusterid %s", alicloud.CLUSTER_ID)
+	}
 	cloud, err := cloudprovider.InitCloudProvider(
 		ccm.KubeCloudShared.CloudProvider.Name,
 		ccm.KubeCloudShared.CloudProvider.CloudConfigFile,
@@ -204,6 +400,7 @@ func (ccm *ServerCCM) Start() error {
 			}
 		}
 		configz.InstallHandler(mux)
+		mux.Handle(service.BACKOFF_DEBUG_PATH, service.Backoffs)
 		metric.RegisterPrometheus()
 		mux.Handle("/metrics", promhttp.Handler())
 		server := &http.Server{
@@ -245,7 +442,22 @@ func Run(ccm *ServerCCM) error {
 		ConfigCloudRoutes:         ccm.KubeCloudShared.ConfigureCloudRoutes,
 		RouteReconciliationPeriod: ccm.KubeCloudShared.RouteReconciliationPeriod,
 		ControllerStartInterval:   ccm.Generic.ControllerStartInterval,
+		ConflictResolution:        ccm.RouteConflictResolution,
+		DryRun:                    ccm.RoutesDryRun,
+	}
+	alicloud.GCOptions = alicloud.LoadBalancerGCOptions{
+		Enabled: ccm.LoadBalancerGC,
+		Period:  ccm.LoadBalancerGCPeriod.Duration,
+		DryRun:  ccm.LoadBalancerGCDryRun,
+	}
+	alicloud.HealthOptions = alicloud.BackendHealthOptions{
+		Period: ccm.BackendHealthCheckPeriod.Duration,
+		QPS:    ccm.BackendHealthCheckQPS,
 	}
+	alicloud.StateOptions = alicloud.LoadBalancerStateOptions{ConfigMap: ccm.LoadBalancerStateConfigMap}
+	node.EnableNodeDeletion = ccm.EnableNodeDeletion
+	node.AllowProvidedIPOverride = ccm.AllowProvidedIPOverride
+	node.LabelReconcilePeriod = ccm.NodeLabelReconcilePeriod.Duration
 
 	if !ccm.Generic.LeaderElection.LeaderElect {
 		ccm.MainLoop(context.TODO())
@@ -365,6 +577,8 @@ func runControllerService(
 	if err != nil {
 		return fmt.Errorf("failed to start service controller: %v", err)
 	}
+	// the loadbalancers drop the nodes deleted by the node controller at once
+	node.SetOnNodeDeleted(scon.EnqueueServicesOfNode)
 	go scon.Run(stop, int(ccm.ServiceController.ConcurrentServiceSyncs))
 	return nil
 }
@@ -37,11 +37,50 @@ func AddFlags(ccm *app.ServerCCM, fs *pflag.FlagSet) {
 	fs.BoolVar(&ccm.KubeCloudShared.AllowUntaggedCloud, "allow-untagged-cloud", false, "Allow the cluster to run without the cluster-id on cloud instances. This is a legacy mode of operation and a cluster-id will be required in the future.")
 	fs.DurationVar(&ccm.Generic.MinResyncPeriod.Duration, "min-resync-period", ccm.Generic.MinResyncPeriod.Duration, "The resync period in reflectors will be random between MinResyncPeriod and 2*MinResyncPeriod.")
 	fs.DurationVar(&ccm.KubeCloudShared.NodeMonitorPeriod.Duration, "node-monitor-period", ccm.KubeCloudShared.NodeMonitorPeriod.Duration,
-		"The period for syncing NodeStatus in NodeController.")
-	fs.DurationVar(&ccm.NodeStatusUpdateFrequency.Duration, "node-status-update-frequency", ccm.NodeStatusUpdateFrequency.Duration, "Specifies how often the controller updates nodes' status.")
+		"The period for checking the instances of the nodes still exist, at least 10s. 0 takes the nodeMonitorPeriod of the cloud config, 120s by default.")
+	fs.DurationVar(&ccm.NodeStatusUpdateFrequency.Duration, "node-status-update-frequency", ccm.NodeStatusUpdateFrequency.Duration, "Specifies how often the controller updates nodes' addresses, at least 30s. 0 takes the nodeAddrSyncPeriod of the cloud config, 240s by default.")
+	fs.DurationVar(&ccm.NodeMonitorGracePeriod.Duration, "node-monitor-grace-period", ccm.NodeMonitorGracePeriod.Duration, "The --node-monitor-grace-period of kube-controller-manager, a --node-monitor-period above it is warned of. 0 skips the check.")
+	fs.DurationVar(&ccm.NodeLabelReconcilePeriod.Duration, "node-label-reconcile-period", ccm.NodeLabelReconcilePeriod.Duration, "The period for repairing the instance type, zone and region labels of the nodes drifted from their instances, apart from the nodeAddrSyncPeriod of the address sync. 0 disables the repair.")
+	fs.BoolVar(&ccm.EnableNodeDeletion, "enable-node-deletion", ccm.EnableNodeDeletion, "Delete the not ready nodes whose instance is not found. When false the nodes are tainted node.alibabacloud.com/instance-not-found and warned by an event instead, to be deleted manually.")
+	fs.BoolVar(&ccm.AllowProvidedIPOverride, "allow-provided-ip-override", ccm.AllowProvidedIPOverride, "Replace the addresses of the nodes whose ip provided by kubelet --node-ip is no longer an address of the instance, eg. after its primary private ip is changed, by the addresses of the instance. When false such nodes keep their addresses and are warned by a ProvidedIPNotFound event.")
 	fs.BoolVar(&ccm.KubeCloudShared.UseServiceAccountCredentials, "use-service-account-credentials", ccm.KubeCloudShared.UseServiceAccountCredentials, "If true, use individual service account credentials for each controller.")
 	fs.DurationVar(&ccm.KubeCloudShared.RouteReconciliationPeriod.Duration, "route-reconciliation-period", ccm.KubeCloudShared.RouteReconciliationPeriod.Duration, "The period for reconciling routes created for nodes by cloud provider.")
 	fs.BoolVar(&ccm.KubeCloudShared.ConfigureCloudRoutes, "configure-cloud-routes", true, "Should CIDRs allocated by allocate-node-cidrs be configured on the cloud provider.")
+	fs.StringVar(&ccm.RouteConflictResolution, "route-conflict-resolution", ccm.RouteConflictResolution, "How to resolve route entry conflicted with node podCIDR whose next hop is not a node, eg. created manually. report: emit warning event only; replace: delete and recreate the route when its next hop instance does not exist.")
+	fs.BoolVar(&ccm.RoutesDryRun, "routes-dry-run", false, "If true, route controller only logs the routes to be created or deleted without any mutating vpc call.")
+	fs.StringVar(&ccm.ClusterID, "cluster-id", ccm.ClusterID, "The cluster id tagged on the loadbalancers created by the cloud provider, overrides the ClusterID of the cloud config.")
+	fs.BoolVar(&ccm.LoadBalancerGC, "loadbalancer-gc", false, "If true, periodically delete the loadbalancers tagged with the cluster id whose service no longer exists, and warn of the services owning more than one loadbalancer.")
+	fs.DurationVar(&ccm.LoadBalancerGCPeriod.Duration, "loadbalancer-gc-period", ccm.LoadBalancerGCPeriod.Duration, "The period for collecting the loadbalancers whose service no longer exists.")
+	fs.BoolVar(&ccm.LoadBalancerGCDryRun, "loadbalancer-gc-dry-run", false, "If true, loadbalancer garbage collector only logs the orphan loadbalancers without deleting them.")
+	fs.DurationVar(&ccm.BackendHealthCheckPeriod.Duration, "backend-health-check-period", 0, "The period for checking the backend health of the loadbalancers, unhealthy backends are reported by events and metrics. 0 disables the check.")
+	fs.Float32Var(&ccm.BackendHealthCheckQPS, "backend-health-check-qps", ccm.BackendHealthCheckQPS, "Max health status calls per second of the backend health check.")
+	fs.BoolVar(&ccm.UseVPCEndpoints, "use-vpc-endpoints", false, "If true, call the vpc endpoints of the region instead of the public ones, the endpoints of the cloud config go first.")
+	fs.Float32Var(&ccm.CloudAPILimiter.QPS, "cloud-api-qps", ccm.CloudAPILimiter.QPS, "Max Alibaba Cloud api calls per second shared by all the clients. 0 disables the limiter.")
+	fs.IntVar(&ccm.CloudAPILimiter.Burst, "cloud-api-burst", ccm.CloudAPILimiter.Burst, "Max Alibaba Cloud api calls in a burst.")
+	fs.Float32Var(&ccm.CloudAPILimiter.ReadQPS, "cloud-api-read-qps", ccm.CloudAPILimiter.ReadQPS, "Max Alibaba Cloud describe calls per second, which take a separate bucket from the modifications. 0 shares the bucket of --cloud-api-qps.")
+	fs.IntVar(&ccm.CloudAPILimiter.ReadBurst, "cloud-api-read-burst", ccm.CloudAPILimiter.ReadBurst, "Max Alibaba Cloud describe calls in a burst of the separate bucket.")
+	fs.IntVar(&ccm.ServiceAPIBudget.Calls, "service-api-budget", ccm.ServiceAPIBudget.Calls, "Max Alibaba Cloud api calls of the sync of a service per --service-api-budget-window, the sync out of its budget is aborted and requeued after the window. The deletions are not budgeted. 0 disables the budget.")
+	fs.DurationVar(&ccm.ServiceAPIBudget.Window, "service-api-budget-window", ccm.ServiceAPIBudget.Window, "The window the cloud api calls of a service are budgeted over.")
+	fs.StringVar(&ccm.LoadBalancerStateConfigMap, "loadbalancer-state-configmap", ccm.LoadBalancerStateConfigMap, "The namespace/name of a configmap, eg. kube-system/ccm-loadbalancer-state, mirroring the loadbalancer id, address, listeners, backend count and last error of each service after its sync, keyed by namespace_name. Empty disables the mirror.")
+	fs.Int32Var(&ccm.CloudAPILogVerbosity, "cloud-api-log-verbosity", ccm.CloudAPILogVerbosity, "The log verbosity at which each Alibaba Cloud api call is logged with its RequestId, the credentials and certificates redacted.")
+	fs.StringVar(&ccm.UserAgentSuffix, "user-agent-suffix", ccm.UserAgentSuffix, "The suffix appended to the user agent of the Alibaba Cloud api calls, after the version and the cluster id, printable ascii of 128 characters at most.")
+	fs.StringArrayVar(&ccm.ExcludeNodeSelectors, "exclude-node-selector", ccm.ExcludeNodeSelectors, "A label selector of the nodes excluded from the node, route and service controllers, in addition to the exclude-node labels. May be repeated.")
+	fs.StringArrayVar(&ccm.ExcludeBalancerNodeSelectors, "exclude-balancer-node-selector", ccm.ExcludeBalancerNodeSelectors, "A label selector of the nodes excluded from the loadbalancer backends only, their addresses are still synced, in addition to the exclude-balancer label. May be repeated.")
+	fs.StringArrayVar(&ccm.DrainingNodeTaints, "draining-node-taint", ccm.DrainingNodeTaints, "The key of a taint of the nodes being drained, eg. ToBeDeletedByClusterAutoscaler, in addition to the unschedulable taint. The draining nodes are drained from the loadbalancers with graceful-drain-seconds. May be repeated.")
+	fs.StringArrayVar(&ccm.DrainingNodeSelectors, "draining-node-selector", ccm.DrainingNodeSelectors, "A label selector of the nodes being drained. May be repeated.")
+	fs.StringVar(&ccm.DiagnosticRedactPattern, "diagnostic-redact-pattern", ccm.DiagnosticRedactPattern, "A regular expression of the annotation keys whose values are redacted from the objects dumped to the logs.")
+	fs.IntVar(&ccm.DiagnosticMaxBytes, "diagnostic-max-bytes", ccm.DiagnosticMaxBytes, "Max bytes of an object dumped to the logs, the rest is truncated. 0 for no limit.")
+	fs.StringVar(&ccm.LoadBalancerClass, "loadbalancer-class", ccm.LoadBalancerClass, "The class of the services managed besides those of no class, eg. alibabacloud.com/clb. The services of the other classes are left to their controllers.")
+	fs.StringVar(&ccm.LoadBalancerClassReleasePolicy, "loadbalancer-class-release-policy", ccm.LoadBalancerClassReleasePolicy, "What to do with the loadbalancer of a managed service given the class of another controller. keep: stop syncing and keep the loadbalancer; delete: delete the loadbalancer as if the service was deleted.")
+	fs.DurationVar(&ccm.StartupSpread.Duration, "startup-spread", 0, "The window over which the syncs of the services listed at the start or a leader failover are spread randomly, the services created or changed later are synced at once. 0 syncs them at once.")
+	fs.StringVar(&ccm.DefaultAnnotationsConfigMap, "default-lb-annotations-configmap", ccm.DefaultAnnotationsConfigMap, "The namespace/name of a configmap whose data are the default annotations of the loadbalancer services, merged under the annotations of each service on every sync.")
+	fs.StringVar(&ccm.LoadBalancerNameTemplate, "lb-name-template", ccm.LoadBalancerNameTemplate, "The template of the names of the loadbalancers, eg. k8s-{cluster}-{namespace}-{service}. The placeholders are {cluster}, {namespace}, {service} and {uid}. The loadbalancers owned by the services are renamed on the next sync once it changes, the name annotation overrides it.")
+	fs.IntVar(&ccm.HealthCheckDefaults.Interval, "health-check-interval", ccm.HealthCheckDefaults.Interval, "The default interval in seconds of the health check of the listeners whose service does not annotate health-check-interval. 0 leaves it to slb.")
+	fs.IntVar(&ccm.HealthCheckDefaults.Timeout, "health-check-timeout", ccm.HealthCheckDefaults.Timeout, "The default timeout in seconds of the health check of the listeners, the connect timeout of tcp and udp listeners and the response timeout of http and https ones, unless the service annotates it. 0 leaves it to slb.")
+	fs.IntVar(&ccm.HealthCheckDefaults.HealthyThreshold, "health-check-healthy-threshold", ccm.HealthCheckDefaults.HealthyThreshold, "The default healthy threshold of the health check of the listeners whose service does not annotate healthy-threshold. 0 leaves it to slb.")
+	fs.IntVar(&ccm.HealthCheckDefaults.UnhealthyThreshold, "health-check-unhealthy-threshold", ccm.HealthCheckDefaults.UnhealthyThreshold, "The default unhealthy threshold of the health check of the listeners whose service does not annotate unhealthy-threshold. 0 leaves it to slb.")
+	fs.StringArrayVar(&ccm.HealthCheckTypes, "health-check-type", ccm.HealthCheckTypes, "The default health check of a listener protocol as protocol=type, tcp=tcp or tcp=http for the check type of the tcp listeners, http=on, http=off, https=on or https=off for whether the http and https listeners are checked, unless the service annotates it. May be repeated.")
+	fs.BoolVar(&ccm.ServiceSyncTimestampMetric, "service-sync-timestamp-metric", false, "If true, export the time of the last successful sync of each loadbalancer service by ccm_service_last_successful_sync_timestamp_seconds, whose series grow with the services. The oldest of them is exported anyway.")
 	fs.BoolVar(&ccm.Generic.Debugging.EnableProfiling, "profiling", true, "Enable profiling via web interface host:port/debug/pprof/.")
 	fs.BoolVar(&ccm.Generic.Debugging.EnableContentionProfiling, "contention-profiling", false, "Enable lock contention profiling, if profiling is enabled.")
 	fs.StringVar(&ccm.KubeCloudShared.ClusterCIDR, "cluster-cidr", ccm.KubeCloudShared.ClusterCIDR, "CIDR Range for Pods in cluster.")